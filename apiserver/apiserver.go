@@ -1,8 +1,67 @@
 package main
 
-import "github.com/nitrous-io/rise-server/apiserver/server"
+import (
+	"os"
+	"time"
+
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/deploywatch"
+	"github.com/nitrous-io/rise-server/apiserver/models/jobrecord"
+	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/outboxjob"
+	"github.com/nitrous-io/rise-server/apiserver/server"
+	"github.com/nitrous-io/rise-server/pkg/job"
+	"github.com/nitrous-io/rise-server/pkg/mqconn"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// tokenUsageFlushInterval is how often token usage stats are written to the DB.
+const tokenUsageFlushInterval = 30 * time.Second
+
+// outboxDeliveryInterval is how often jobs that could not be enqueued right
+// after their transaction was committed are retried.
+const outboxDeliveryInterval = time.Minute
+
+// progressRetryInterval is how long to wait before consuming deployment
+// progress messages again after the connection to the message queue is lost.
+const progressRetryInterval = 5 * time.Second
+
+// version is set at build time, see script/build.
+var version string
 
 func main() {
-	r := server.New()
-	r.Run(":3000")
+	common.SetVersion(version)
+
+	db, err := dbconn.DB()
+	if err != nil {
+		log.Fatalf("failed to initialize db, err: %v", err)
+	}
+	job.DefaultRecorder = &jobrecord.Recorder{DB: db}
+
+	go oauthtoken.Usage.FlushEvery(db, tokenUsageFlushInterval)
+	go outboxjob.DeliverPendingEvery(db, outboxDeliveryInterval)
+	go func() {
+		if err := deploywatch.Default.Listen(os.Getenv("POSTGRES_URL")); err != nil {
+			log.Errorf("failed to listen for deployment state changes, err: %v", err)
+		}
+	}()
+	go func() {
+		for {
+			mq, err := mqconn.MQ()
+			if err == nil {
+				err = deploywatch.Default.ConsumeProgress(mq)
+			}
+			log.Errorf("failed to consume deployment progress, retrying in %s, err: %v", progressRetryInterval, err)
+			time.Sleep(progressRetryInterval)
+		}
+	}()
+
+	cfg := server.ConfigFromEnv()
+	log.Infof("Listening on %s (TLS: %t, HTTP/2: %t)", cfg.Addr, cfg.TLSEnabled(), cfg.HTTP2 && cfg.TLSEnabled())
+
+	if err := server.ListenAndServe(cfg, server.New()); err != nil {
+		log.Fatalf("failed to serve, err: %v", err)
+	}
 }
@@ -0,0 +1,69 @@
+package common
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/ericchiang/letsencrypt"
+)
+
+// Directory URLs of Let's Encrypt's ACME servers. ACME_URL can be set to
+// "production" or "staging" as a shorthand for these.
+const (
+	AcmeProductionURL = "https://acme-v01.api.letsencrypt.org/directory"
+	AcmeStagingURL    = "https://acme-staging.api.letsencrypt.org/directory"
+)
+
+// AcmeTransport is the transport used to talk to the ACME server. It is nil
+// (i.e. the default transport is used) unless ACME_CA_CERT_FILE is set.
+var AcmeTransport http.RoundTripper
+
+// NewAcmeClient returns a client of the ACME server at AcmeURL.
+func NewAcmeClient() (*letsencrypt.Client, error) {
+	return letsencrypt.NewClientWithTransport(AcmeURL, AcmeTransport)
+}
+
+// resolveAcmeURL returns the ACME directory URL to use given the value of
+// ACME_URL. When it is not set, production installs use Let's Encrypt's
+// production server and everything else uses the staging server so that
+// rate limits are not hit.
+func resolveAcmeURL(riseEnv, acmeURL string) string {
+	switch acmeURL {
+	case "production":
+		return AcmeProductionURL
+	case "staging":
+		return AcmeStagingURL
+	case "":
+		if riseEnv == "production" {
+			return AcmeProductionURL
+		}
+		return AcmeStagingURL
+	}
+	return acmeURL
+}
+
+// newAcmeTransport returns a transport that trusts the CA certificates in the
+// given PEM file in addition to the system's, so that an internal ACME server
+// (e.g. Boulder or step-ca) with a self-signed certificate can be used.
+func newAcmeTransport(caCertFile string) (http.RoundTripper, error) {
+	pemCerts, err := ioutil.ReadFile(caCertFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pemCerts) {
+		return nil, errors.New("no certificates found in " + caCertFile)
+	}
+
+	return &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: &tls.Config{RootCAs: pool},
+	}, nil
+}
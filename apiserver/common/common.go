@@ -3,8 +3,12 @@ package common
 import (
 	"io/ioutil"
 	"os"
+	"strings"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/pkg/aesencrypter"
 )
 
 var (
@@ -15,8 +19,108 @@ var (
 	GitHubAPIHost  = os.Getenv("GITHUB_API_HOST")
 	GitHubAPIToken = os.Getenv("GITHUB_API_TOKEN")
 	WebhookHost    = os.Getenv("WEBHOOK_HOST")
+
+	// GitLabAPIHost and the Bitbucket hosts are the hosts that repositories
+	// on those providers are fetched from, and default to the hosted services.
+	// The tokens and credentials are used to fetch private repositories.
+	GitLabAPIHost        = os.Getenv("GITLAB_API_HOST")
+	GitLabAPIToken       = os.Getenv("GITLAB_API_TOKEN")
+	BitbucketAPIHost     = os.Getenv("BITBUCKET_API_HOST")
+	BitbucketHost        = os.Getenv("BITBUCKET_HOST")
+	BitbucketUsername    = os.Getenv("BITBUCKET_USERNAME")
+	BitbucketAppPassword = os.Getenv("BITBUCKET_APP_PASSWORD")
+
+	// CertEventsWebhookURL is the URL to which cert lifecycle events are
+	// POSTed, e.g. so that they can be alerted on. Events are not POSTed if it
+	// is not set.
+	CertEventsWebhookURL = os.Getenv("CERT_EVENTS_WEBHOOK_URL")
+
+	// QuotaAlertsWebhookURL is the URL to which alerts are POSTed when a
+	// domain crosses one of its daily quotas. Alerts are not POSTed if it is
+	// not set.
+	QuotaAlertsWebhookURL = os.Getenv("QUOTA_ALERTS_WEBHOOK_URL")
+
+	// SSOCallbackURL is the URL that identity providers redirect users back
+	// to after they log in with single sign-on. It must be registered with
+	// each identity provider, and defaults to /sso/callback on WebhookHost.
+	SSOCallbackURL = os.Getenv("SSO_CALLBACK_URL")
+
+	// DeviceVerificationURL is the page of the web app where users enter the
+	// user code shown by a command line client to log it in. It defaults to
+	// /device on WebhookHost.
+	DeviceVerificationURL = os.Getenv("DEVICE_VERIFICATION_URL")
+
+	// RequestAuditSink is where the metadata of API requests is recorded for
+	// security investigations, either RequestAuditSinkDB or
+	// RequestAuditSinkLog. Requests are not recorded if it is not set.
+	RequestAuditSink = os.Getenv("REQUEST_AUDIT_SINK")
+
+	// RequestTimeout is how long an API request may take before the queries
+	// it runs are aborted.
+	RequestTimeout = 30 * time.Second
+
+	// AesKeys are the versioned AES keys that AesKey is being rotated to, by
+	// key ID. Data in the database is encrypted with the key with the highest
+	// ID, or with AesKey if there are none.
+	AesKeys = map[int]string{}
+
+	// Version is the version of the API server, e.g. "1.0.1", and GitSHA is
+	// the commit it was built from. They are set from the version the binary
+	// was built with, see script/build.
+	Version = "dev"
+	GitSHA  = ""
+
+	// Features are the feature flags that are enabled on this server, set as
+	// a comma-separated list in FEATURES.
+	Features = []string{}
+
+	// GeoCountryHeaders are request headers that the CDN or load balancer in
+	// front of the API server sets to the country of the client's IP address.
+	// The first one that is set is tracked as the location of the client.
+	GeoCountryHeaders = []string{"CloudFront-Viewer-Country", "CF-IPCountry"}
 )
 
+// Sinks that API requests can be recorded to.
+const (
+	// RequestAuditSinkDB records requests in the api_requests table, where
+	// they can be queried by admins.
+	RequestAuditSinkDB = "db"
+	// RequestAuditSinkLog writes requests to the log of the API server.
+	RequestAuditSinkLog = "log"
+)
+
+// SetVersion sets Version and GitSHA from a build version such as
+// "1.0.1-deadbeef". Empty build versions are ignored.
+func SetVersion(buildVersion string) {
+	if buildVersion == "" {
+		return
+	}
+
+	i := strings.LastIndex(buildVersion, "-")
+	if i == -1 {
+		Version = buildVersion
+		return
+	}
+	Version, GitSHA = buildVersion[:i], buildVersion[i+1:]
+}
+
+// FeatureEnabled returns whether the given feature flag is enabled.
+func FeatureEnabled(name string) bool {
+	for _, f := range Features {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// AesKeyring returns a keyring of AesKey and AesKeys to encrypt and decrypt
+// data in the database with. Files uploaded for edges are still encrypted with
+// AesKey.
+func AesKeyring() *aesencrypter.Keyring {
+	return aesencrypter.NewKeyring(AesKey, AesKeys)
+}
+
 func init() {
 	if MailerEmail == "" {
 		MailerEmail = "PubStorm <support@pubstorm.com>"
@@ -46,6 +150,56 @@ func init() {
 		log.SetLevel(logLevel)
 	}
 
+	AcmeURL = resolveAcmeURL(riseEnv, AcmeURL)
+	if SSOCallbackURL == "" {
+		SSOCallbackURL = WebhookHost + "/sso/callback"
+	}
+	if DeviceVerificationURL == "" {
+		DeviceVerificationURL = WebhookHost + "/device"
+	}
+
+	if caCertFile := os.Getenv("ACME_CA_CERT_FILE"); caCertFile != "" {
+		t, err := newAcmeTransport(caCertFile)
+		if err != nil {
+			log.Fatalf("Could not load ACME_CA_CERT_FILE: %v", err)
+		}
+		AcmeTransport = t
+	}
+
+	if keys := os.Getenv("AES_KEYS"); keys != "" {
+		k, err := aesencrypter.ParseKeys(keys)
+		if err != nil {
+			log.Fatalf("Could not parse AES_KEYS: %v", err)
+		}
+		AesKeys = k
+	}
+
+	if expiry := os.Getenv("CONFIRMATION_CODE_EXPIRY"); expiry != "" {
+		d, err := time.ParseDuration(expiry)
+		if err != nil {
+			log.Warn("Ignoring CONFIRMATION_CODE_EXPIRY, not a valid duration!")
+		} else {
+			user.ConfirmationCodeExpiry = d
+		}
+	}
+
+	if timeout := os.Getenv("REQUEST_TIMEOUT"); timeout != "" {
+		d, err := time.ParseDuration(timeout)
+		if err != nil {
+			log.Warn("Ignoring REQUEST_TIMEOUT, not a valid duration!")
+		} else {
+			RequestTimeout = d
+		}
+	}
+
+	if features := os.Getenv("FEATURES"); features != "" {
+		for _, f := range strings.Split(features, ",") {
+			if f = strings.TrimSpace(f); f != "" {
+				Features = append(Features, f)
+			}
+		}
+	}
+
 	if riseEnv != "test" {
 		if os.Getenv("AWS_ACCESS_KEY_ID") == "" || os.Getenv("AWS_SECRET_ACCESS_KEY") == "" {
 			log.Fatal("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables are required!")
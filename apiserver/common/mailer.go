@@ -1,15 +1,26 @@
 package common
 
 import (
-	"os"
-
 	"github.com/nitrous-io/rise-server/pkg/mailer"
+	"github.com/nitrous-io/rise-server/shared/emails"
 )
 
 var (
-	Mailer mailer.Mailer = mailer.NewSendGridMailer(os.Getenv("SENDGRID_USERNAME"), os.Getenv("SENDGRID_PASSWORD"))
+	// Mailer enqueues mail to be sent asynchronously by mailerd.
+	Mailer mailer.Mailer = &emails.QueueMailer{}
 )
 
 func SendMail(tos, ccs, bccs []string, subject, body, htmltext string) error {
 	return Mailer.SendMail(MailerEmail, tos, ccs, bccs, MailerEmail, subject, body, htmltext)
 }
+
+// SendTemplatedMail renders the email template with the given name and sends
+// it to tos.
+func SendTemplatedMail(tos []string, name string, data interface{}) error {
+	e, err := emails.Render(name, data)
+	if err != nil {
+		return err
+	}
+
+	return SendMail(tos, nil, nil, e.Subject, e.Text, e.HTML)
+}
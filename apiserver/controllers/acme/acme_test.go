@@ -11,6 +11,7 @@ import (
 	"github.com/nitrous-io/rise-server/apiserver/models/acmecert"
 	"github.com/nitrous-io/rise-server/apiserver/models/project"
 	"github.com/nitrous-io/rise-server/apiserver/server"
+	"github.com/nitrous-io/rise-server/pkg/aesencrypter"
 	"github.com/nitrous-io/rise-server/testhelper"
 	"github.com/nitrous-io/rise-server/testhelper/factories"
 	. "github.com/onsi/ginkgo"
@@ -67,8 +68,8 @@ var _ = Describe("Acme", func() {
 
 			dm := factories.Domain(db, proj, "www.foo-bar-express.com")
 
-			aesKey := "something-something-something-32"
-			acmeCert, err = acmecert.New(dm.ID, aesKey)
+			keyring := aesencrypter.NewKeyring("something-something-something-32", nil)
+			acmeCert, err = acmecert.New(dm.ID, keyring)
 			Expect(err).To(BeNil())
 			acmeCert.HTTPChallengePath = "/.well-known/acme-challenge/secrud-token"
 			acmeCert.HTTPChallengeResource = "secrud-token.abcde12345"
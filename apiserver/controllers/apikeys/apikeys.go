@@ -0,0 +1,147 @@
+package apikeys
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/apikey"
+)
+
+// Index lists the current user's API keys.
+func Index(c *gin.Context) {
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	u := controllers.CurrentUser(c)
+
+	keys, err := apikey.FindByUserID(db, u.ID)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	keysJSON := []interface{}{}
+	for _, k := range keys {
+		keysJSON = append(keysJSON, k.AsJSON())
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"api_keys": keysJSON,
+	})
+}
+
+// Create creates a new API key for the current user, with which machine
+// clients can sign requests instead of sending an access token.
+func Create(c *gin.Context) {
+	u := controllers.CurrentUser(c)
+
+	k := &apikey.APIKey{
+		UserID: u.ID,
+		Name:   strings.TrimSpace(c.PostForm("name")),
+	}
+
+	if errs := k.Validate(); errs != nil {
+		c.JSON(422, gin.H{
+			"error":  "invalid_params",
+			"errors": errs,
+		})
+		return
+	}
+
+	if err := k.GenerateSecret(common.AesKeyring()); err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if err := db.Create(k).Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	{
+		var (
+			event = "Created API Key"
+			props = map[string]interface{}{
+				"apiKeyName": k.Name,
+			}
+			context = controllers.TrackingContext(c)
+		)
+		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
+			log.Errorf("failed to track %q event for user ID %d, err: %v",
+				event, u.ID, err)
+		}
+	}
+
+	// The secret is only ever returned when the API key is created.
+	c.JSON(http.StatusCreated, gin.H{
+		"api_key": struct {
+			*apikey.JSON
+			Secret string `json:"secret"`
+		}{k.AsJSON(), k.Secret},
+	})
+}
+
+// Destroy revokes one of the current user's API keys by ID.
+func Destroy(c *gin.Context) {
+	u := controllers.CurrentUser(c)
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "api key could not be found",
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	q := db.Where("id = ? AND user_id = ?", id, u.ID).Delete(apikey.APIKey{})
+	if err := q.Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if q.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "api key could not be found",
+		})
+		return
+	}
+
+	{
+		var (
+			event   = "Revoked API Key"
+			props   = map[string]interface{}{"apiKeyId": id}
+			context = controllers.TrackingContext(c)
+		)
+		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
+			log.Errorf("failed to track %q event for user ID %d, err: %v",
+				event, u.ID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"revoked": true,
+	})
+}
@@ -0,0 +1,720 @@
+package apikeys_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/middleware"
+	"github.com/nitrous-io/rise-server/apiserver/models/apikey"
+	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/apiserver/server"
+	"github.com/nitrous-io/rise-server/pkg/tracker"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+	"github.com/nitrous-io/rise-server/testhelper/fake"
+	"github.com/nitrous-io/rise-server/testhelper/sharedexamples"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "apikeys")
+}
+
+var _ = Describe("APIKeys", func() {
+	var (
+		fakeTracker *fake.Tracker
+		origTracker tracker.Trackable
+		origAesKey  string
+
+		db *gorm.DB
+
+		s   *httptest.Server
+		res *http.Response
+		err error
+
+		u *user.User
+		t *oauthtoken.OauthToken
+
+		headers http.Header
+	)
+
+	BeforeEach(func() {
+		origTracker = common.Tracker
+		fakeTracker = &fake.Tracker{}
+		common.Tracker = fakeTracker
+
+		origAesKey = common.AesKey
+		common.AesKey = "something-something-something-32"
+
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+
+		testhelper.TruncateTables(db.DB())
+
+		u, _, t = factories.AuthTrio(db)
+
+		headers = http.Header{
+			"Authorization": {"Bearer " + t.Token},
+		}
+	})
+
+	AfterEach(func() {
+		common.Tracker = origTracker
+		common.AesKey = origAesKey
+
+		if res != nil {
+			res.Body.Close()
+		}
+		s.Close()
+	})
+
+	createAPIKey := func(u *user.User, name string) *apikey.APIKey {
+		k := &apikey.APIKey{UserID: u.ID, Name: name}
+		Expect(k.GenerateSecret(common.AesKeyring())).To(Succeed())
+		Expect(db.Create(k).Error).To(BeNil())
+		return k
+	}
+
+	Describe("GET /api_keys", func() {
+		var k1, k2 *apikey.APIKey
+
+		BeforeEach(func() {
+			k1 = createAPIKey(u, "Travis CI")
+			k2 = createAPIKey(u, "Jenkins")
+
+			// To make sure it does not list other users' API keys
+			createAPIKey(factories.User(db), "Other")
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("GET", s.URL+"/api_keys", nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("returns 200 OK with the user's API keys without their secrets", func() {
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+				"api_keys": [
+					{
+						"id": %d,
+						"name": "Jenkins",
+						"key": "%s",
+						"last_used_at": null,
+						"created_at": "%s"
+					},
+					{
+						"id": %d,
+						"name": "Travis CI",
+						"key": "%s",
+						"last_used_at": null,
+						"created_at": "%s"
+					}
+				]
+			}`, k2.ID, k2.Key, k2.CreatedAt.Format(time.RFC3339Nano),
+				k1.ID, k1.Key, k1.CreatedAt.Format(time.RFC3339Nano))))
+			Expect(b.String()).NotTo(ContainSubstring(k1.Secret))
+			Expect(b.String()).NotTo(ContainSubstring(k2.Secret))
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+
+	Describe("POST /api_keys", func() {
+		var params url.Values
+
+		BeforeEach(func() {
+			params = url.Values{"name": {"Travis CI"}}
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("POST", s.URL+"/api_keys", params, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("returns 201 created with the secret and stores the secret encrypted", func() {
+			doRequest()
+
+			var j struct {
+				APIKey struct {
+					ID     uint   `json:"id"`
+					Name   string `json:"name"`
+					Key    string `json:"key"`
+					Secret string `json:"secret"`
+				} `json:"api_key"`
+			}
+			Expect(json.NewDecoder(res.Body).Decode(&j)).To(Succeed())
+
+			Expect(res.StatusCode).To(Equal(http.StatusCreated))
+			Expect(j.APIKey.Name).To(Equal("Travis CI"))
+			Expect(j.APIKey.Key).NotTo(BeEmpty())
+			Expect(j.APIKey.Secret).To(HaveLen(apikey.SecretLength * 2))
+
+			k := &apikey.APIKey{}
+			Expect(db.First(k, j.APIKey.ID).Error).To(BeNil())
+			Expect(k.UserID).To(Equal(u.ID))
+			Expect(k.Key).To(Equal(j.APIKey.Key))
+			Expect(k.EncryptedSecret).NotTo(ContainSubstring(j.APIKey.Secret))
+
+			secret, err := k.DecryptedSecret(common.AesKeyring())
+			Expect(err).To(BeNil())
+			Expect(secret).To(Equal(j.APIKey.Secret))
+
+			trackCall := fakeTracker.TrackCalls.NthCall(1)
+			Expect(trackCall).NotTo(BeNil())
+			Expect(trackCall.Arguments[0]).To(Equal(fmt.Sprintf("%d", u.ID)))
+			Expect(trackCall.Arguments[1]).To(Equal("Created API Key"))
+		})
+
+		Context("when the name is missing", func() {
+			BeforeEach(func() {
+				params.Del("name")
+			})
+
+			It("returns 422 unprocessable entity", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"errors": {
+						"name": "is required"
+					}
+				}`))
+
+				var count int
+				Expect(db.Model(apikey.APIKey{}).Count(&count).Error).To(BeNil())
+				Expect(count).To(Equal(0))
+			})
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+
+	Describe("DELETE /api_keys/:id", func() {
+		var k *apikey.APIKey
+
+		BeforeEach(func() {
+			k = createAPIKey(u, "Travis CI")
+		})
+
+		doRequest := func(id uint) {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("DELETE", s.URL+"/api_keys/"+strconv.Itoa(int(id)), nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("returns 200 OK and revokes the API key", func() {
+			doRequest(k.ID)
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(MatchJSON(`{"revoked": true}`))
+
+			found, err := apikey.FindByKey(db, k.Key)
+			Expect(err).To(BeNil())
+			Expect(found).To(BeNil())
+
+			trackCall := fakeTracker.TrackCalls.NthCall(1)
+			Expect(trackCall).NotTo(BeNil())
+			Expect(trackCall.Arguments[1]).To(Equal("Revoked API Key"))
+		})
+
+		Context("when the API key belongs to another user", func() {
+			BeforeEach(func() {
+				k = createAPIKey(factories.User(db), "Other")
+			})
+
+			It("returns 404 not found", func() {
+				doRequest(k.ID)
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "not_found",
+					"error_description": "api key could not be found"
+				}`))
+
+				found, err := apikey.FindByKey(db, k.Key)
+				Expect(err).To(BeNil())
+				Expect(found).NotTo(BeNil())
+			})
+		})
+	})
+
+	Describe("requests signed with an API key", func() {
+		var (
+			k         *apikey.APIKey
+			timestamp time.Time
+			body      []byte
+		)
+
+		BeforeEach(func() {
+			k = createAPIKey(u, "Travis CI")
+			timestamp = time.Now()
+			body = nil
+		})
+
+		sign := func(req *http.Request) {
+			digest := sha256.Sum256(body)
+			ts := strconv.FormatInt(timestamp.Unix(), 10)
+			sig := apikey.Sign(k.Secret, req.Method, req.URL.RequestURI(), ts, hex.EncodeToString(digest[:]))
+			req.Header.Set("Authorization", fmt.Sprintf("%s Key=%s, Timestamp=%s, Signature=%s",
+				middleware.SignatureScheme, k.Key, ts, sig))
+		}
+
+		doRequest := func(method, path string, params url.Values) {
+			if params != nil && method != "GET" {
+				body = []byte(params.Encode())
+			}
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest(method, s.URL+path, params, nil, sign)
+			Expect(err).To(BeNil())
+		}
+
+		It("authenticates the user", func() {
+			doRequest("GET", "/user", nil)
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(ContainSubstring(u.Email))
+
+			Expect(db.First(k, k.ID).Error).To(BeNil())
+			Expect(k.LastUsedAt).NotTo(BeNil())
+		})
+
+		It("passes the signed body on to the handler", func() {
+			doRequest("POST", "/projects", url.Values{"name": {"foo-bar-express"}})
+
+			Expect(res.StatusCode).To(Equal(http.StatusCreated))
+
+			proj := &project.Project{}
+			Expect(db.Where("name = ?", "foo-bar-express").First(proj).Error).To(BeNil())
+			Expect(proj.UserID).To(Equal(u.ID))
+		})
+
+		assertUnauthorized := func(desc string) {
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusUnauthorized))
+			Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+				"error": "invalid_signature",
+				"error_description": %q
+			}`, desc)))
+		}
+
+		Context("when the body has been tampered with", func() {
+			BeforeEach(func() {
+				body = []byte("name=baz-qux-express")
+			})
+
+			It("returns 401 unauthorized", func() {
+				s = httptest.NewServer(server.New())
+				res, err = testhelper.MakeRequest("POST", s.URL+"/projects", url.Values{"name": {"foo-bar-express"}}, nil, sign)
+				Expect(err).To(BeNil())
+
+				assertUnauthorized("signature is invalid")
+
+				var count int
+				Expect(db.Model(project.Project{}).Count(&count).Error).To(BeNil())
+				Expect(count).To(Equal(0))
+			})
+		})
+
+		Context("when the signature was made with another secret", func() {
+			BeforeEach(func() {
+				k.Secret = "not-the-secret"
+			})
+
+			It("returns 401 unauthorized", func() {
+				doRequest("GET", "/user", nil)
+				assertUnauthorized("signature is invalid")
+			})
+		})
+
+		Context("when the API key has been revoked", func() {
+			BeforeEach(func() {
+				Expect(db.Delete(k).Error).To(BeNil())
+			})
+
+			It("returns 401 unauthorized", func() {
+				doRequest("GET", "/user", nil)
+				assertUnauthorized("signature is invalid")
+			})
+		})
+
+		Context("when the timestamp is too old", func() {
+			BeforeEach(func() {
+				timestamp = time.Now().Add(-middleware.MaxSignatureAge - time.Minute)
+			})
+
+			It("returns 401 unauthorized", func() {
+				doRequest("GET", "/user", nil)
+				assertUnauthorized("timestamp is too far from current time")
+			})
+		})
+
+		Context("when the request is for a route that requires an access token", func() {
+			It("returns 401 unauthorized", func() {
+				doRequest("GET", "/api_keys", nil)
+
+				Expect(res.StatusCode).To(Equal(http.StatusUnauthorized))
+			})
+		})
+	})
+
+	Describe("project API keys", func() {
+		var proj *project.Project
+
+		BeforeEach(func() {
+			proj = factories.Project(db, u, "foo-bar-express")
+		})
+
+		createProjectAPIKey := func(creator *user.User, proj *project.Project, name string, scopes ...string) *apikey.APIKey {
+			k := &apikey.APIKey{UserID: creator.ID, ProjectID: &proj.ID, Name: name}
+			k.SetScopes(scopes)
+			Expect(k.GenerateSecret(common.AesKeyring())).To(Succeed())
+			Expect(db.Create(k).Error).To(BeNil())
+			return k
+		}
+
+		Describe("GET /projects/:project_name/api_keys", func() {
+			var k *apikey.APIKey
+
+			BeforeEach(func() {
+				k = createProjectAPIKey(u, proj, "Deploy from CI", apikey.ScopeDeploy, apikey.ScopeRead)
+
+				// To make sure it does not list the user's own API keys or the
+				// API keys of other projects
+				createAPIKey(u, "Travis CI")
+				createProjectAPIKey(u, factories.Project(db, u), "Other", apikey.ScopeRead)
+			})
+
+			doRequest := func() {
+				s = httptest.NewServer(server.New())
+				res, err = testhelper.MakeRequest("GET", s.URL+"/projects/foo-bar-express/api_keys", nil, headers, nil)
+				Expect(err).To(BeNil())
+			}
+
+			It("returns 200 OK with the project's API keys without their secrets", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"api_keys": [
+						{
+							"id": %d,
+							"name": "Deploy from CI",
+							"key": "%s",
+							"last_used_at": null,
+							"created_at": "%s",
+							"scopes": ["deploy", "read"]
+						}
+					]
+				}`, k.ID, k.Key, k.CreatedAt.Format(time.RFC3339Nano))))
+				Expect(b.String()).NotTo(ContainSubstring(k.Secret))
+			})
+
+			It("does not list the project's API keys with the user's API keys", func() {
+				s = httptest.NewServer(server.New())
+				res, err = testhelper.MakeRequest("GET", s.URL+"/api_keys", nil, headers, nil)
+				Expect(err).To(BeNil())
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(b.String()).To(ContainSubstring("Travis CI"))
+				Expect(b.String()).NotTo(ContainSubstring("Deploy from CI"))
+			})
+
+			sharedexamples.ItRequiresProject(func() (*gorm.DB, *project.Project) {
+				return db, proj
+			}, func() *http.Response {
+				doRequest()
+				return res
+			}, nil)
+		})
+
+		Describe("POST /projects/:project_name/api_keys", func() {
+			var params url.Values
+
+			BeforeEach(func() {
+				params = url.Values{
+					"name":   {"Deploy from CI"},
+					"scopes": {"deploy, read"},
+				}
+			})
+
+			doRequest := func() {
+				s = httptest.NewServer(server.New())
+				res, err = testhelper.MakeRequest("POST", s.URL+"/projects/foo-bar-express/api_keys", params, headers, nil)
+				Expect(err).To(BeNil())
+			}
+
+			It("returns 201 created with the secret and stores the API key for the project", func() {
+				doRequest()
+
+				var j struct {
+					APIKey struct {
+						ID     uint     `json:"id"`
+						Name   string   `json:"name"`
+						Scopes []string `json:"scopes"`
+						Secret string   `json:"secret"`
+					} `json:"api_key"`
+				}
+				Expect(json.NewDecoder(res.Body).Decode(&j)).To(Succeed())
+
+				Expect(res.StatusCode).To(Equal(http.StatusCreated))
+				Expect(j.APIKey.Name).To(Equal("Deploy from CI"))
+				Expect(j.APIKey.Scopes).To(Equal([]string{"deploy", "read"}))
+				Expect(j.APIKey.Secret).To(HaveLen(apikey.SecretLength * 2))
+
+				k := &apikey.APIKey{}
+				Expect(db.First(k, j.APIKey.ID).Error).To(BeNil())
+				Expect(k.UserID).To(Equal(u.ID))
+				Expect(k.ProjectID).NotTo(BeNil())
+				Expect(*k.ProjectID).To(Equal(proj.ID))
+				Expect(k.Scope).To(Equal("deploy read"))
+
+				trackCall := fakeTracker.TrackCalls.NthCall(1)
+				Expect(trackCall).NotTo(BeNil())
+				Expect(trackCall.Arguments[0]).To(Equal(fmt.Sprintf("%d", u.ID)))
+				Expect(trackCall.Arguments[1]).To(Equal("Created Project API Key"))
+			})
+
+			Context("when the scopes are invalid", func() {
+				BeforeEach(func() {
+					params.Set("scopes", "deploy,admin")
+				})
+
+				It("returns 422 unprocessable entity", func() {
+					doRequest()
+
+					b := &bytes.Buffer{}
+					_, err := b.ReadFrom(res.Body)
+					Expect(err).To(BeNil())
+
+					Expect(res.StatusCode).To(Equal(422))
+					Expect(b.String()).To(MatchJSON(`{
+						"error": "invalid_params",
+						"errors": {
+							"scopes": "must be one or more of read, deploy, write"
+						}
+					}`))
+
+					var count int
+					Expect(db.Model(apikey.APIKey{}).Count(&count).Error).To(BeNil())
+					Expect(count).To(Equal(0))
+				})
+			})
+
+			sharedexamples.ItRequiresProject(func() (*gorm.DB, *project.Project) {
+				return db, proj
+			}, func() *http.Response {
+				doRequest()
+				return res
+			}, nil)
+		})
+
+		Describe("DELETE /projects/:project_name/api_keys/:id", func() {
+			var k *apikey.APIKey
+
+			BeforeEach(func() {
+				k = createProjectAPIKey(u, proj, "Deploy from CI", apikey.ScopeDeploy)
+			})
+
+			doRequest := func(id uint) {
+				s = httptest.NewServer(server.New())
+				res, err = testhelper.MakeRequest("DELETE", s.URL+"/projects/foo-bar-express/api_keys/"+strconv.Itoa(int(id)), nil, headers, nil)
+				Expect(err).To(BeNil())
+			}
+
+			It("returns 200 OK and revokes the API key", func() {
+				doRequest(k.ID)
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(b.String()).To(MatchJSON(`{"revoked": true}`))
+
+				found, err := apikey.FindByKey(db, k.Key)
+				Expect(err).To(BeNil())
+				Expect(found).To(BeNil())
+
+				trackCall := fakeTracker.TrackCalls.NthCall(1)
+				Expect(trackCall).NotTo(BeNil())
+				Expect(trackCall.Arguments[1]).To(Equal("Revoked Project API Key"))
+			})
+
+			Context("when the API key belongs to another project", func() {
+				BeforeEach(func() {
+					k = createProjectAPIKey(u, factories.Project(db, u), "Other", apikey.ScopeDeploy)
+				})
+
+				It("returns 404 not found", func() {
+					doRequest(k.ID)
+
+					Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+
+					found, err := apikey.FindByKey(db, k.Key)
+					Expect(err).To(BeNil())
+					Expect(found).NotTo(BeNil())
+				})
+			})
+		})
+
+		Describe("requests signed with a project API key", func() {
+			var (
+				creator *user.User
+				k       *apikey.APIKey
+			)
+
+			BeforeEach(func() {
+				// The API key was created by a collaborator who has since
+				// left the project.
+				creator = factories.User(db)
+				k = createProjectAPIKey(creator, proj, "Deploy from CI", apikey.ScopeRead)
+			})
+
+			sign := func(req *http.Request) {
+				digest := sha256.Sum256(nil)
+				ts := strconv.FormatInt(time.Now().Unix(), 10)
+				sig := apikey.Sign(k.Secret, req.Method, req.URL.RequestURI(), ts, hex.EncodeToString(digest[:]))
+				req.Header.Set("Authorization", fmt.Sprintf("%s Key=%s, Timestamp=%s, Signature=%s",
+					middleware.SignatureScheme, k.Key, ts, sig))
+			}
+
+			doRequest := func(method, path string) {
+				s = httptest.NewServer(server.New())
+				res, err = testhelper.MakeRequest(method, s.URL+path, nil, nil, sign)
+				Expect(err).To(BeNil())
+			}
+
+			assertForbidden := func(desc string) {
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusForbidden))
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"error": "forbidden",
+					"code": "token_scope_forbidden",
+					"error_description": %q
+				}`, desc)))
+			}
+
+			It("acts on behalf of the owner of the project", func() {
+				doRequest("GET", "/projects/foo-bar-express")
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				Expect(db.First(k, k.ID).Error).To(BeNil())
+				Expect(k.LastUsedAt).NotTo(BeNil())
+			})
+
+			Context("when the request is not allowed by the scopes of the API key", func() {
+				It("returns 403 forbidden", func() {
+					doRequest("DELETE", "/projects/foo-bar-express/domains/www.foo-bar-express.com")
+					assertForbidden("project API keys can only be used for their project within their scopes")
+				})
+			})
+
+			Context("when the request is for another project", func() {
+				BeforeEach(func() {
+					factories.Project(db, u, "baz-qux-express")
+				})
+
+				It("returns 403 forbidden", func() {
+					doRequest("GET", "/projects/baz-qux-express")
+					assertForbidden("project API keys can only be used for their project within their scopes")
+				})
+			})
+
+			Context("when the request is not for a project", func() {
+				It("returns 403 forbidden", func() {
+					doRequest("GET", "/user")
+					assertForbidden("project API keys can only be used for their project within their scopes")
+				})
+			})
+
+			Context("when the request is for a route that only the owner of the project can access", func() {
+				BeforeEach(func() {
+					Expect(db.Model(k).Update("scope", apikey.ScopeWrite).Error).To(BeNil())
+				})
+
+				It("returns 403 forbidden", func() {
+					doRequest("GET", "/projects/foo-bar-express/deploy_hooks")
+					assertForbidden("project API keys cannot be used for this request")
+				})
+			})
+
+			Context("when the project has been deleted", func() {
+				BeforeEach(func() {
+					Expect(db.Delete(proj).Error).To(BeNil())
+				})
+
+				It("returns 401 unauthorized", func() {
+					doRequest("GET", "/projects/foo-bar-express")
+
+					Expect(res.StatusCode).To(Equal(http.StatusUnauthorized))
+				})
+			})
+		})
+	})
+})
@@ -0,0 +1,158 @@
+package apikeys
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/apikey"
+)
+
+// IndexForProject lists the API keys of the current project.
+func IndexForProject(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	keys, err := apikey.FindByProjectID(db, proj.ID)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	keysJSON := []interface{}{}
+	for _, k := range keys {
+		keysJSON = append(keysJSON, k.AsJSON())
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"api_keys": keysJSON,
+	})
+}
+
+// CreateForProject creates a new API key for the current project. Unlike the
+// API keys of users, it keeps working when the user who created it is no
+// longer a collaborator of the project, and can only be used within the
+// given comma-separated scopes.
+func CreateForProject(c *gin.Context) {
+	u := controllers.CurrentUser(c)
+	proj := controllers.CurrentProject(c)
+
+	k := &apikey.APIKey{
+		UserID:    u.ID,
+		ProjectID: &proj.ID,
+		Name:      strings.TrimSpace(c.PostForm("name")),
+	}
+	k.SetScopes(strings.Split(c.PostForm("scopes"), ","))
+
+	if errs := k.Validate(); errs != nil {
+		c.JSON(422, gin.H{
+			"error":  "invalid_params",
+			"errors": errs,
+		})
+		return
+	}
+
+	if err := k.GenerateSecret(common.AesKeyring()); err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if err := db.Create(k).Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	{
+		var (
+			event = "Created Project API Key"
+			props = map[string]interface{}{
+				"projectName": proj.Name,
+				"apiKeyName":  k.Name,
+				"scopes":      k.Scopes(),
+			}
+			context = controllers.TrackingContext(c)
+		)
+		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
+			log.Errorf("failed to track %q event for user ID %d, err: %v",
+				event, u.ID, err)
+		}
+	}
+
+	// The secret is only ever returned when the API key is created.
+	c.JSON(http.StatusCreated, gin.H{
+		"api_key": struct {
+			*apikey.JSON
+			Secret string `json:"secret"`
+		}{k.AsJSON(), k.Secret},
+	})
+}
+
+// DestroyForProject revokes one of the current project's API keys by ID.
+func DestroyForProject(c *gin.Context) {
+	u := controllers.CurrentUser(c)
+	proj := controllers.CurrentProject(c)
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "api key could not be found",
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	q := db.Where("id = ? AND project_id = ?", id, proj.ID).Delete(apikey.APIKey{})
+	if err := q.Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if q.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "api key could not be found",
+		})
+		return
+	}
+
+	{
+		var (
+			event = "Revoked Project API Key"
+			props = map[string]interface{}{
+				"projectName": proj.Name,
+				"apiKeyId":    id,
+			}
+			context = controllers.TrackingContext(c)
+		)
+		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
+			log.Errorf("failed to track %q event for user ID %d, err: %v",
+				event, u.ID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"revoked": true,
+	})
+}
@@ -0,0 +1,102 @@
+package apirequests
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/apirequest"
+)
+
+const (
+	// DefaultLimit is the number of requests listed if no limit is given.
+	DefaultLimit = 100
+	// MaxLimit is the maximum number of requests that can be listed at once.
+	MaxLimit = 1000
+)
+
+// Index lists the most recent API requests that were recorded, optionally
+// filtered by user, IP, route, project, status and time.
+func Index(c *gin.Context) {
+	var (
+		f     = apirequest.Filter{IP: c.Query("ip"), Route: c.Query("route"), ProjectName: c.Query("project_name")}
+		limit = DefaultLimit
+		errs  = map[string]string{}
+	)
+
+	parseUint := func(key string, dst *uint) {
+		if v := c.Query(key); v != "" {
+			n, err := strconv.ParseUint(v, 10, 64)
+			if err != nil || n == 0 {
+				errs[key] = "is invalid"
+				return
+			}
+			*dst = uint(n)
+		}
+	}
+	parseTime := func(key string) *time.Time {
+		v := c.Query(key)
+		if v == "" {
+			return nil
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			errs[key] = "must be an RFC 3339 time"
+			return nil
+		}
+		return &t
+	}
+
+	parseUint("user_id", &f.UserID)
+	parseUint("before_id", &f.BeforeID)
+	f.Since = parseTime("since")
+	f.Until = parseTime("until")
+
+	if v := c.Query("status_code"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 100 || n > 599 {
+			errs["status_code"] = "is invalid"
+		}
+		f.StatusCode = n
+	}
+
+	if l := c.Query("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > MaxLimit {
+			errs["limit"] = "must be between 1 and " + strconv.Itoa(MaxLimit)
+		}
+		limit = n
+	}
+
+	if len(errs) > 0 {
+		c.JSON(422, gin.H{
+			"error":  "invalid_params",
+			"errors": errs,
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	reqs, err := apirequest.List(db, f, limit)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	reqsJSON := make([]interface{}, len(reqs))
+	for i, r := range reqs {
+		reqsJSON[i] = r.AsJSON()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"api_requests": reqsJSON,
+	})
+}
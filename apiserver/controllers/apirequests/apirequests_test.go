@@ -0,0 +1,237 @@
+package apirequests_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/apirequest"
+	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/apiserver/server"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "apirequests")
+}
+
+var _ = Describe("APIRequests", func() {
+	var (
+		db  *gorm.DB
+		s   *httptest.Server
+		res *http.Response
+		err error
+
+		orgStatsToken string
+		orgSink       string
+		params        url.Values
+
+		u *user.User
+		t *oauthtoken.OauthToken
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+
+		orgStatsToken = common.StatsToken
+		common.StatsToken = "statssecret"
+		orgSink = common.RequestAuditSink
+		common.RequestAuditSink = common.RequestAuditSinkDB
+
+		params = url.Values{
+			"token": {common.StatsToken},
+		}
+
+		u, _, t = factories.AuthTrio(db)
+		factories.Project(db, u, "foo-bar-express")
+
+		s = httptest.NewServer(server.New())
+	})
+
+	AfterEach(func() {
+		common.StatsToken = orgStatsToken
+		common.RequestAuditSink = orgSink
+		if res != nil {
+			res.Body.Close()
+		}
+		s.Close()
+	})
+
+	makeRequest := func(method, path string, headers http.Header) {
+		res, err := testhelper.MakeRequest(method, s.URL+path, nil, headers, nil)
+		Expect(err).To(BeNil())
+		res.Body.Close()
+	}
+
+	doRequest := func() {
+		res, err = testhelper.MakeRequest("GET", s.URL+"/admin/api_requests", params, nil, nil)
+		Expect(err).To(BeNil())
+	}
+
+	listed := func() []apirequest.JSON {
+		b := &bytes.Buffer{}
+		_, err := b.ReadFrom(res.Body)
+		Expect(err).To(BeNil())
+
+		var j struct {
+			APIRequests []apirequest.JSON `json:"api_requests"`
+		}
+		Expect(json.Unmarshal(b.Bytes(), &j)).To(BeNil())
+		return j.APIRequests
+	}
+
+	Describe("recording requests", func() {
+		It("records the route, user, status and IP of requests", func() {
+			makeRequest("GET", "/projects/foo-bar-express", http.Header{
+				"Authorization":   {"Bearer " + t.Token},
+				"User-Agent":      {"rise-cli/1.0"},
+				"X-Forwarded-For": {"1.2.3.4"},
+			})
+
+			reqs, err := apirequest.List(db, apirequest.Filter{}, 10)
+			Expect(err).To(BeNil())
+			Expect(reqs).To(HaveLen(1))
+
+			r := reqs[0]
+			Expect(r.Method).To(Equal("GET"))
+			Expect(r.Route).To(Equal("/projects/:project_name"))
+			Expect(r.Path).To(Equal("/projects/foo-bar-express"))
+			Expect(r.StatusCode).To(Equal(http.StatusOK))
+			Expect(r.IP).To(Equal("1.2.3.4"))
+			Expect(r.UserAgent).To(Equal("rise-cli/1.0"))
+			Expect(*r.UserID).To(Equal(u.ID))
+			Expect(*r.OauthTokenID).To(Equal(t.ID))
+			Expect(*r.ProjectName).To(Equal("foo-bar-express"))
+		})
+
+		It("records requests that were not authenticated", func() {
+			makeRequest("GET", "/projects/foo-bar-express", nil)
+
+			reqs, err := apirequest.List(db, apirequest.Filter{}, 10)
+			Expect(err).To(BeNil())
+			Expect(reqs).To(HaveLen(1))
+			Expect(reqs[0].StatusCode).To(Equal(http.StatusUnauthorized))
+			Expect(reqs[0].UserID).To(BeNil())
+		})
+
+		It("does not record health checks", func() {
+			makeRequest("GET", "/ping", nil)
+
+			reqs, err := apirequest.List(db, apirequest.Filter{}, 10)
+			Expect(err).To(BeNil())
+			Expect(reqs).To(BeEmpty())
+		})
+
+		Context("when no sink is set", func() {
+			BeforeEach(func() {
+				common.RequestAuditSink = ""
+			})
+
+			It("does not record requests", func() {
+				makeRequest("GET", "/projects/foo-bar-express", http.Header{
+					"Authorization": {"Bearer " + t.Token},
+				})
+
+				reqs, err := apirequest.List(db, apirequest.Filter{}, 10)
+				Expect(err).To(BeNil())
+				Expect(reqs).To(BeEmpty())
+			})
+		})
+	})
+
+	Describe("GET /admin/api_requests", func() {
+		BeforeEach(func() {
+			makeRequest("GET", "/projects/foo-bar-express", http.Header{
+				"Authorization": {"Bearer " + t.Token},
+			})
+			makeRequest("GET", "/projects/foo-bar-express", nil)
+		})
+
+		Context("when the admin token is invalid", func() {
+			BeforeEach(func() {
+				params.Set("token", "wrong")
+			})
+
+			It("returns 401 unauthorized", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+				Expect(res.StatusCode).To(Equal(http.StatusUnauthorized))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_admin_token",
+					"error_description": "admin token is required"
+				}`))
+			})
+		})
+
+		It("lists the most recent requests first", func() {
+			doRequest()
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			reqs := listed()
+			Expect(reqs).To(HaveLen(2))
+			Expect(reqs[0].StatusCode).To(Equal(http.StatusUnauthorized))
+			Expect(reqs[1].StatusCode).To(Equal(http.StatusOK))
+		})
+
+		It("filters requests", func() {
+			params.Set("user_id", fmt.Sprint(u.ID))
+			params.Set("route", "/projects/:project_name")
+			doRequest()
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			reqs := listed()
+			Expect(reqs).To(HaveLen(1))
+			Expect(*reqs[0].UserID).To(Equal(u.ID))
+		})
+
+		It("pages through requests", func() {
+			params.Set("limit", "1")
+			doRequest()
+			first := listed()
+			Expect(first).To(HaveLen(1))
+			res.Body.Close()
+
+			params.Set("before_id", fmt.Sprint(first[0].ID))
+			doRequest()
+			second := listed()
+			Expect(second).To(HaveLen(1))
+			Expect(second[0].ID).To(BeNumerically("<", first[0].ID))
+		})
+
+		It("returns 422 for invalid params", func() {
+			params.Set("since", "yesterday")
+			params.Set("limit", "0")
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+			Expect(res.StatusCode).To(Equal(422))
+			Expect(b.String()).To(MatchJSON(`{
+				"error": "invalid_params",
+				"errors": {
+					"since": "must be an RFC 3339 time",
+					"limit": "must be between 1 and 1000"
+				}
+			}`))
+		})
+	})
+})
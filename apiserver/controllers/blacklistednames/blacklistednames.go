@@ -0,0 +1,120 @@
+package blacklistednames
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/blacklistedname"
+)
+
+// Index lists the names and patterns that projects cannot be named.
+func Index(c *gin.Context) {
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	names, err := blacklistedname.All(db)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	namesJSON := make([]interface{}, len(names))
+	for i, b := range names {
+		namesJSON[i] = b.AsJSON()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"blacklisted_names": namesJSON,
+	})
+}
+
+// Create blacklists a name or a pattern. Existing projects are not affected.
+func Create(c *gin.Context) {
+	b := &blacklistedname.BlacklistedName{
+		Name: strings.TrimSpace(c.PostForm("name")),
+		Kind: c.PostForm("kind"),
+	}
+	if b.Kind == "" {
+		b.Kind = blacklistedname.KindExact
+	}
+	// Project names are always lower case, but regular expressions may be
+	// case sensitive, e.g. \D.
+	if b.Kind != blacklistedname.KindRegexp {
+		b.Name = strings.ToLower(b.Name)
+	}
+
+	if errs := b.Validate(); errs != nil {
+		c.JSON(422, gin.H{
+			"error":  "invalid_params",
+			"errors": errs,
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if err := b.Insert(db); err != nil {
+		if err == blacklistedname.ErrTaken {
+			c.JSON(422, gin.H{
+				"error": "invalid_params",
+				"errors": map[string]string{
+					"name": "is already blacklisted",
+				},
+			})
+			return
+		}
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"blacklisted_name": b.AsJSON(),
+	})
+}
+
+// Destroy allows a name or a pattern again.
+func Destroy(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "blacklisted name could not be found",
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	deleted, err := blacklistedname.Delete(db, uint(id))
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "blacklisted name could not be found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"deleted": true,
+	})
+}
@@ -0,0 +1,172 @@
+package blacklistednames_test
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/blacklistedname"
+	"github.com/nitrous-io/rise-server/apiserver/server"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "blacklistednames")
+}
+
+var _ = Describe("BlacklistedNames", func() {
+	var (
+		db  *gorm.DB
+		s   *httptest.Server
+		res *http.Response
+		err error
+
+		orgStatsToken string
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+		blacklistedname.ClearCache()
+
+		orgStatsToken = common.StatsToken
+		common.StatsToken = "statssecret"
+
+		s = httptest.NewServer(server.New())
+	})
+
+	AfterEach(func() {
+		if res != nil {
+			res.Body.Close()
+		}
+		s.Close()
+		common.StatsToken = orgStatsToken
+	})
+
+	readBody := func() string {
+		b := &bytes.Buffer{}
+		_, err := b.ReadFrom(res.Body)
+		Expect(err).To(BeNil())
+		return b.String()
+	}
+
+	Describe("GET /admin/blacklisted_names", func() {
+		It("lists patterns before exact names", func() {
+			b1 := factories.BlacklistedName(db, "admin")
+			b2 := factories.BlacklistedNameWithKind(db, blacklistedname.KindWildcard, "*-admin")
+
+			res, err = testhelper.MakeRequest("GET", s.URL+"/admin/blacklisted_names", url.Values{"token": {"statssecret"}}, nil, nil)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(readBody()).To(MatchJSON(fmt.Sprintf(`{
+				"blacklisted_names": [
+					{"id": %d, "name": "*-admin", "kind": "wildcard", "created_at": %q},
+					{"id": %d, "name": "admin", "kind": "exact", "created_at": %q}
+				]
+			}`, b2.ID, b2.CreatedAt.Format(time.RFC3339Nano), b1.ID, b1.CreatedAt.Format(time.RFC3339Nano))))
+		})
+
+		It("requires the admin token", func() {
+			res, err = testhelper.MakeRequest("GET", s.URL+"/admin/blacklisted_names", nil, nil, nil)
+			Expect(err).To(BeNil())
+			Expect(res.StatusCode).To(Equal(http.StatusUnauthorized))
+		})
+	})
+
+	Describe("POST /admin/blacklisted_names", func() {
+		doRequest := func(params url.Values) {
+			res, err = testhelper.MakeRequest("POST", s.URL+"/admin/blacklisted_names?token=statssecret", params, nil, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("blacklists the pattern in lower case", func() {
+			doRequest(url.Values{"kind": {"prefix"}, "name": {"PubStorm-"}})
+
+			b := &blacklistedname.BlacklistedName{}
+			Expect(db.Last(b).Error).To(BeNil())
+			Expect(b.Kind).To(Equal(blacklistedname.KindPrefix))
+			Expect(b.Name).To(Equal("pubstorm-"))
+
+			Expect(res.StatusCode).To(Equal(http.StatusCreated))
+			Expect(readBody()).To(MatchJSON(fmt.Sprintf(`{
+				"blacklisted_name": {"id": %d, "name": "pubstorm-", "kind": "prefix", "created_at": %q}
+			}`, b.ID, b.CreatedAt.Format(time.RFC3339Nano))))
+
+			blacklisted, err := blacklistedname.IsBlacklisted(db, "pubstorm-blog")
+			Expect(err).To(BeNil())
+			Expect(blacklisted).To(BeTrue())
+		})
+
+		It("blacklists exact names by default", func() {
+			doRequest(url.Values{"name": {"admin"}})
+			Expect(res.StatusCode).To(Equal(http.StatusCreated))
+
+			b := &blacklistedname.BlacklistedName{}
+			Expect(db.Last(b).Error).To(BeNil())
+			Expect(b.Kind).To(Equal(blacklistedname.KindExact))
+		})
+
+		It("returns 422 if the pattern is invalid", func() {
+			doRequest(url.Values{"kind": {"regexp"}, "name": {"admin("}})
+
+			Expect(res.StatusCode).To(Equal(422))
+			Expect(readBody()).To(MatchJSON(`{
+				"error": "invalid_params",
+				"errors": {"name": "is not a valid regular expression"}
+			}`))
+		})
+
+		It("returns 422 if the pattern is already blacklisted", func() {
+			factories.BlacklistedNameWithKind(db, blacklistedname.KindWildcard, "api*")
+
+			doRequest(url.Values{"kind": {"wildcard"}, "name": {"api*"}})
+
+			Expect(res.StatusCode).To(Equal(422))
+			Expect(readBody()).To(MatchJSON(`{
+				"error": "invalid_params",
+				"errors": {"name": "is already blacklisted"}
+			}`))
+		})
+	})
+
+	Describe("DELETE /admin/blacklisted_names/:id", func() {
+		var b *blacklistedname.BlacklistedName
+
+		BeforeEach(func() {
+			b = factories.BlacklistedNameWithKind(db, blacklistedname.KindWildcard, "*-admin")
+		})
+
+		It("allows names that match the pattern again", func() {
+			res, err = testhelper.MakeRequest("DELETE", fmt.Sprintf("%s/admin/blacklisted_names/%d?token=statssecret", s.URL, b.ID), nil, nil, nil)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(readBody()).To(MatchJSON(`{"deleted": true}`))
+
+			blacklisted, err := blacklistedname.IsBlacklisted(db, "super-admin")
+			Expect(err).To(BeNil())
+			Expect(blacklisted).To(BeFalse())
+		})
+
+		It("returns 404 if the blacklisted name does not exist", func() {
+			res, err = testhelper.MakeRequest("DELETE", fmt.Sprintf("%s/admin/blacklisted_names/%d?token=statssecret", s.URL, b.ID+1), nil, nil, nil)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+		})
+	})
+})
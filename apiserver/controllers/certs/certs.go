@@ -22,13 +22,13 @@ import (
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
 	"github.com/nitrous-io/rise-server/apiserver/models/acmecert"
 	"github.com/nitrous-io/rise-server/apiserver/models/cert"
+	"github.com/nitrous-io/rise-server/apiserver/models/certevent"
 	"github.com/nitrous-io/rise-server/apiserver/models/domain"
 	"github.com/nitrous-io/rise-server/pkg/aesencrypter"
 	"github.com/nitrous-io/rise-server/pkg/certhelper"
-	"github.com/nitrous-io/rise-server/pkg/pubsub"
 	"github.com/nitrous-io/rise-server/shared"
-	"github.com/nitrous-io/rise-server/shared/exchanges"
-	"github.com/nitrous-io/rise-server/shared/messages"
+	"github.com/nitrous-io/rise-server/shared/errcodes"
+	"github.com/nitrous-io/rise-server/shared/invalidation"
 	"github.com/nitrous-io/rise-server/shared/s3client"
 )
 
@@ -97,6 +97,7 @@ func Create(c *gin.Context) {
 		} else {
 			c.JSON(http.StatusBadRequest, gin.H{
 				"error":             "invalid_request",
+				"code":              errcodes.PayloadTooLarge,
 				"error_description": "request body is too large",
 			})
 		}
@@ -218,10 +219,7 @@ func Create(c *gin.Context) {
 				"certIssuer":    ct.Issuer,
 				"certExpiresAt": ct.ExpiresAt,
 			}
-			context = map[string]interface{}{
-				"ip":         common.GetIP(c.Request),
-				"user_agent": c.Request.UserAgent(),
-			}
+			context = controllers.TrackingContext(c)
 		)
 		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
 			log.Errorf("failed to track %q event for user ID %d, err: %v",
@@ -229,6 +227,10 @@ func Create(c *gin.Context) {
 		}
 	}
 
+	if err := certevent.Emit(db, certevent.New(d, certevent.Issued, &ct.ExpiresAt, "Uploaded by user")); err != nil {
+		log.Errorf("failed to emit %q event for domain %q, err: %v", certevent.Issued, d.Name, err)
+	}
+
 	c.JSON(http.StatusCreated, gin.H{
 		"cert": ct.AsJSON(),
 	})
@@ -248,6 +250,22 @@ func LetsEncrypt(c *gin.Context) {
 		return
 	}
 
+	// The HTTP challenge is used unless the TLS-ALPN challenge is requested,
+	// e.g. because port 80 of the domain is blocked or redirected.
+	challengeType := c.PostForm("challenge_type")
+	if challengeType == "" {
+		challengeType = letsencrypt.ChallengeHTTP
+	}
+	if challengeType != letsencrypt.ChallengeHTTP && challengeType != certhelper.ChallengeTLSALPN {
+		c.JSON(422, gin.H{
+			"error": "invalid_params",
+			"errors": map[string]interface{}{
+				"challenge_type": "is invalid",
+			},
+		})
+		return
+	}
+
 	db, err := dbconn.DB()
 	if err != nil {
 		controllers.InternalServerError(c, err)
@@ -283,7 +301,7 @@ func LetsEncrypt(c *gin.Context) {
 
 		// If no record exists, create one.
 		var err error
-		acmeCert, err = acmecert.New(dom.ID, common.AesKey)
+		acmeCert, err = acmecert.New(dom.ID, common.AesKeyring())
 		if err != nil {
 			log.Errorf("failed to initialize new AcmeCert for domain %q, err: %v", dom.Name, err)
 			controllers.InternalServerError(c, err)
@@ -305,12 +323,13 @@ func LetsEncrypt(c *gin.Context) {
 	if acmeCert.IsValid() {
 		c.JSON(http.StatusConflict, gin.H{
 			"error":             "already_exists",
+			"code":              errcodes.CertExists,
 			"error_description": "a certificate from Let's Encrypt has already been setup",
 		})
 		return
 	}
 
-	cli, err := letsencrypt.NewClient(common.AcmeURL)
+	cli, err := common.NewAcmeClient()
 	if err != nil {
 		log.Errorf("failed to query Let's Encrypt directory %q, err: %v", common.AcmeURL, err)
 		c.JSON(http.StatusServiceUnavailable, gin.H{
@@ -320,19 +339,35 @@ func LetsEncrypt(c *gin.Context) {
 		return
 	}
 
-	leKey, err := acmeCert.DecryptedLetsencryptKey(common.AesKey)
+	leKey, err := acmeCert.DecryptedLetsencryptKey(common.AesKeyring())
 	if err != nil {
 		log.Errorf("failed to decrypt Let's Encrypt private key, domain: %q, err: %v", dom.Name, err)
 		controllers.InternalServerError(c, err)
 		return
 	}
 
-	if _, err := cli.NewRegistration(leKey); err != nil {
+	reg, err := cli.NewRegistration(leKey)
+	if err != nil {
 		log.Errorf("failed to get Let's Encrypt registration, domain: %q, err: %v", dom.Name, err)
 		controllers.InternalServerError(c, err)
 		return
 	}
 
+	// Register the email of the user setting up the cert as the contact of the
+	// account so that expiry notices from Let's Encrypt reach them and the
+	// account can be recovered.
+	u := controllers.CurrentUser(c)
+	contact := "mailto:" + u.Email
+	if !hasContact(reg.Contact, contact) {
+		reg.Contact = []string{contact}
+		if _, err := cli.UpdateRegistration(leKey, reg); err != nil {
+			log.Errorf("failed to update Let's Encrypt registration contact, domain: %q, err: %v", dom.Name, err)
+			controllers.InternalServerError(c, err)
+			return
+		}
+	}
+	acmeCert.ContactEmail = &u.Email
+
 	auth, _, err := cli.NewAuthorization(leKey, "dns", dom.Name)
 	if err != nil {
 		log.Errorf("failed to get Let's Encrypt challenges, domain: %q, err: %v", dom.Name, err)
@@ -340,52 +375,99 @@ func LetsEncrypt(c *gin.Context) {
 		return
 	}
 
-	// Get the HTTP ("http-01") challenge.
-	var httpChallenge *letsencrypt.Challenge
+	// Get the challenge of the requested type.
+	var challenge *letsencrypt.Challenge
 	for _, chal := range auth.Challenges {
-		if chal.Type == letsencrypt.ChallengeHTTP {
-			httpChallenge = &chal
+		if chal.Type == challengeType {
+			challenge = &chal
 			break
 		}
 	}
-	if httpChallenge == nil {
-		log.Errorf("Let's Encrypt did not return a HTTP challenge, domain: %q, err: %v", dom.Name, err)
+	if challenge == nil {
+		log.Errorf("Let's Encrypt did not return a %s challenge, domain: %q, err: %v", challengeType, dom.Name, err)
 		controllers.InternalServerError(c, err)
 		return
 	}
 
-	path, resource, err := httpChallenge.HTTP(leKey)
-	if err != nil {
-		log.Errorf("failed to get Let's Encrypt HTTP challenge details, domain: %q, err: %v", dom.Name, err)
-		controllers.InternalServerError(c, err)
-		return
+	var keyAuth string
+	switch challengeType {
+	case letsencrypt.ChallengeHTTP:
+		path, resource, err := challenge.HTTP(leKey)
+		if err != nil {
+			log.Errorf("failed to get Let's Encrypt HTTP challenge details, domain: %q, err: %v", dom.Name, err)
+			controllers.InternalServerError(c, err)
+			return
+		}
+
+		// Save challenge details to database so that we can respond to Let's
+		// Encrypt's verification request later.
+		acmeCert.HTTPChallengePath = path
+		acmeCert.HTTPChallengeResource = resource
+	case certhelper.ChallengeTLSALPN:
+		keyAuth, err = acmecert.KeyAuthorization(leKey, challenge.Token)
+		if err != nil {
+			log.Errorf("failed to get Let's Encrypt TLS-ALPN challenge details, domain: %q, err: %v", dom.Name, err)
+			controllers.InternalServerError(c, err)
+			return
+		}
+
+		challengeCert, challengeKey, err := acmecert.NewTLSALPNChallengeCert(dom.Name, keyAuth)
+		if err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+
+		if err := acmeCert.SetTLSALPNChallengeCert(challengeCert, challengeKey, common.AesKeyring()); err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+
+		// Edges present the challenge cert to Let's Encrypt when it connects
+		// to the domain with the "acme-tls/1" protocol.
+		if err := uploadTLSALPNChallengeCert(dom.Name, challengeCert, challengeKey); err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
 	}
 
-	// Save challenge details to database so that we can respond to Let's
-	// Encrypt's verification request later.
-	acmeCert.HTTPChallengePath = path
-	acmeCert.HTTPChallengeResource = resource
 	if err := db.Save(acmeCert).Error; err != nil {
 		controllers.InternalServerError(c, err)
 		return
 	}
 
 	// Tell Let's Encrypt that we are ready for them to verify our response to
-	// the HTTP challenge.
-	// The ChallengeReady() method polls for 30s.
-	if err := cli.ChallengeReady(leKey, *httpChallenge); err != nil {
-		log.Errorf("failed to verify Let's Encrypt HTTP challenge, domain: %q, err: %v", dom.Name, err)
+	// the challenge.
+	// Both ChallengeReady() and TLSALPNChallengeReady() poll for 30s.
+	if challengeType == certhelper.ChallengeTLSALPN {
+		err = certhelper.TLSALPNChallengeReady(cli, common.AcmeURL, common.AcmeTransport, leKey, keyAuth, *challenge)
+
+		// The challenge cert is no longer needed whether or not the challenge
+		// succeeded.
+		if err := deleteTLSALPNChallengeCert(db, acmeCert, dom.Name); err != nil {
+			log.Errorf("failed to delete TLS-ALPN challenge cert, domain: %q, err: %v", dom.Name, err)
+		}
+	} else {
+		err = cli.ChallengeReady(leKey, *challenge)
+	}
+
+	if err != nil {
+		log.Errorf("failed to verify Let's Encrypt %s challenge, domain: %q, err: %v", challengeType, dom.Name, err)
 		c.JSON(http.StatusServiceUnavailable, gin.H{
 			"error":             "service_unavailable",
+			"code":              errcodes.CertPending,
 			"error_description": "domain could not be verified",
 		})
 		return
 	}
 
+	if abortIfDomainDeleted(c, db, &dom, acmeCert) {
+		return
+	}
+
 	// Now that Let's Encrypt has verified that we are legit owners of the
 	// domain, we can finally request a certificate with a certificate signing
 	// request (CSR).
-	certKey, err := acmeCert.DecryptedPrivateKey(common.AesKey)
+	certKey, err := acmeCert.DecryptedPrivateKey(common.AesKeyring())
 	if err != nil {
 		controllers.InternalServerError(c, err)
 		return
@@ -429,6 +511,11 @@ func LetsEncrypt(c *gin.Context) {
 		return
 	}
 
+	// The domain may have been deleted while the cert was being issued.
+	if abortIfDomainDeleted(c, db, &dom, acmeCert) {
+		return
+	}
+
 	// Save cert URI which we will use in future to renew the cert.
 	acmeCert.CertURI = certResp.URI
 	if err := db.Save(acmeCert).Error; err != nil {
@@ -437,7 +524,7 @@ func LetsEncrypt(c *gin.Context) {
 	}
 
 	// Save cert to database so we can use it elsewhere (e.g. for renewals).
-	if err := acmeCert.SaveCert(db, bundledPEM, common.AesKey); err != nil {
+	if err := acmeCert.SaveCert(db, bundledPEM, common.AesKeyring()); err != nil {
 		controllers.InternalServerError(c, err)
 		return
 	}
@@ -467,8 +554,6 @@ func LetsEncrypt(c *gin.Context) {
 	}
 
 	{
-		u := controllers.CurrentUser(c)
-
 		var (
 			event = "Activated Let's Encrypt certificate"
 			props = map[string]interface{}{
@@ -478,10 +563,7 @@ func LetsEncrypt(c *gin.Context) {
 				"certIssuer":    ct.Issuer,
 				"certExpiresAt": ct.ExpiresAt,
 			}
-			context = map[string]interface{}{
-				"ip":         common.GetIP(c.Request),
-				"user_agent": c.Request.UserAgent(),
-			}
+			context = controllers.TrackingContext(c)
 		)
 		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
 			log.Errorf("failed to track %q event for user ID %d, err: %v",
@@ -489,13 +571,71 @@ func LetsEncrypt(c *gin.Context) {
 		}
 	}
 
+	if err := certevent.Emit(db, certevent.New(&dom, certevent.Issued, &ct.ExpiresAt, "Issued by Let's Encrypt")); err != nil {
+		log.Errorf("failed to emit %q event for domain %q, err: %v", certevent.Issued, dom.Name, err)
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"cert": ct.AsJSON(),
 	})
 }
 
+// abortIfDomainDeleted responds with 404 and returns true if a domain was
+// deleted while a Let's Encrypt cert was being issued for it. Its AcmeCert is
+// deleted again, since saving it during issuance would have restored it.
+func abortIfDomainDeleted(c *gin.Context, db *gorm.DB, dom *domain.Domain, acmeCert *acmecert.AcmeCert) bool {
+	var count int
+	if err := db.Model(domain.Domain{}).Where("id = ?", dom.ID).Count(&count).Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return true
+	}
+	if count > 0 {
+		return false
+	}
+
+	log.Warnf("domain %q was deleted while its Let's Encrypt cert was being issued", dom.Name)
+	if err := db.Delete(acmeCert).Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return true
+	}
+
+	c.JSON(http.StatusNotFound, gin.H{
+		"error":             "not_found",
+		"error_description": "domain could not be found",
+	})
+	return true
+}
+
 func uploadCert(domainName string, cert, key []byte) error {
 	certPath := fmt.Sprintf("certs/%s/ssl.crt", domainName)
+	keyPath := fmt.Sprintf("certs/%s/ssl.key", domainName)
+	return uploadKeyPair(domainName, certPath, keyPath, cert, key)
+}
+
+// uploadTLSALPNChallengeCert uploads the cert and key that edges present in
+// response to a TLS-ALPN-01 challenge for a domain.
+func uploadTLSALPNChallengeCert(domainName string, cert, key []byte) error {
+	certPath, keyPath := acmecert.TLSALPNChallengeCertPaths(domainName)
+	return uploadKeyPair(domainName, certPath, keyPath, cert, key)
+}
+
+// deleteTLSALPNChallengeCert deletes the TLS-ALPN-01 challenge cert of a
+// domain from S3 and the database.
+func deleteTLSALPNChallengeCert(db *gorm.DB, acmeCert *acmecert.AcmeCert, domainName string) error {
+	certPath, keyPath := acmecert.TLSALPNChallengeCertPaths(domainName)
+	if err := s3client.Delete(certPath, keyPath); err != nil {
+		return err
+	}
+
+	acmeCert.TLSALPNChallengeCert = ""
+	if err := db.Model(acmeCert).Update("tls_alpn_challenge_cert", "").Error; err != nil {
+		return err
+	}
+
+	return invalidateDomain(domainName)
+}
+
+func uploadKeyPair(domainName, certPath, keyPath string, cert, key []byte) error {
 	encryptedCert, err := aesencrypter.Encrypt(cert, []byte(common.AesKey))
 	if err != nil {
 		return err
@@ -505,7 +645,6 @@ func uploadCert(domainName string, cert, key []byte) error {
 		return err
 	}
 
-	keyPath := fmt.Sprintf("certs/%s/ssl.key", domainName)
 	encryptedKey, err := aesencrypter.Encrypt(key, []byte(common.AesKey))
 	if err != nil {
 		return err
@@ -516,14 +655,11 @@ func uploadCert(domainName string, cert, key []byte) error {
 	}
 
 	// Invalidate cert cache
-	m, err := pubsub.NewMessageWithJSON(exchanges.Edges, exchanges.RouteV1Invalidation, &messages.V1InvalidationMessageData{
-		Domains: []string{domainName},
-	})
-	if err != nil {
-		return err
-	}
+	return invalidateDomain(domainName)
+}
 
-	return m.Publish()
+func invalidateDomain(domainName string) error {
+	return invalidation.Invalidate([]string{domainName})
 }
 
 func Destroy(c *gin.Context) {
@@ -592,16 +728,7 @@ func Destroy(c *gin.Context) {
 		return
 	}
 
-	m, err := pubsub.NewMessageWithJSON(exchanges.Edges, exchanges.RouteV1Invalidation, &messages.V1InvalidationMessageData{
-		Domains: []string{domainName},
-	})
-
-	if err != nil {
-		controllers.InternalServerError(c, err)
-		return
-	}
-
-	if err := m.Publish(); err != nil {
+	if err := invalidateDomain(domainName); err != nil {
 		controllers.InternalServerError(c, err)
 		return
 	}
@@ -616,10 +743,7 @@ func Destroy(c *gin.Context) {
 				"domain":      d.Name,
 				"certId":      crt.ID,
 			}
-			context = map[string]interface{}{
-				"ip":         common.GetIP(c.Request),
-				"user_agent": c.Request.UserAgent(),
-			}
+			context = controllers.TrackingContext(c)
 		)
 		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
 			log.Errorf("failed to track %q event for user ID %d, err: %v",
@@ -631,3 +755,14 @@ func Destroy(c *gin.Context) {
 		"deleted": true,
 	})
 }
+
+// hasContact returns whether contact is one of the contacts of a Let's
+// Encrypt registration.
+func hasContact(contacts []string, contact string) bool {
+	for _, c := range contacts {
+		if c == contact {
+			return true
+		}
+	}
+	return false
+}
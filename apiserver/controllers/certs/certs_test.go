@@ -2,12 +2,16 @@ package certs_test
 
 import (
 	"bytes"
+	"crypto/tls"
 	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
 	"encoding/pem"
 	"fmt"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
 	"time"
 
@@ -17,6 +21,7 @@ import (
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
 	"github.com/nitrous-io/rise-server/apiserver/models/acmecert"
 	"github.com/nitrous-io/rise-server/apiserver/models/cert"
+	"github.com/nitrous-io/rise-server/apiserver/models/certevent"
 	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
 	"github.com/nitrous-io/rise-server/apiserver/models/domain"
 	"github.com/nitrous-io/rise-server/apiserver/models/oauthclient"
@@ -38,7 +43,6 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/onsi/gomega/ghttp"
-	"github.com/streadway/amqp"
 )
 
 func Test(t *testing.T) {
@@ -90,7 +94,7 @@ var _ = Describe("Certs", func() {
 			fakeS3 *fake.S3
 			origS3 filetransfer.FileTransfer
 
-			mq                    *amqp.Connection
+			mq                    mqconn.Conn
 			invalidationQueueName string
 
 			origAesKey string
@@ -379,6 +383,16 @@ A6ao9QSL1ryillYV9Y4001C3jApzmMtBWoMp3NPzwU8nacAOzClJYUcSLkbAIEWV
 			Expect(trackCall.ReturnValues[0]).To(BeNil())
 		})
 
+		It("records a cert issued event", func() {
+			doRequest()
+
+			ev := &certevent.CertEvent{}
+			Expect(db.Last(ev).Error).To(BeNil())
+			Expect(ev.Event).To(Equal(certevent.Issued))
+			Expect(ev.DomainName).To(Equal("www.foo-bar-express.com"))
+			Expect(ev.ExpiresAt.String()).To(Equal("2017-04-20 08:50:15 +0000 +0000"))
+		})
+
 		Context("when given domain does not exist", func() {
 			BeforeEach(func() {
 				Expect(db.Delete(dm).Error).To(BeNil())
@@ -421,6 +435,7 @@ A6ao9QSL1ryillYV9Y4001C3jApzmMtBWoMp3NPzwU8nacAOzClJYUcSLkbAIEWV
 				Expect(res.StatusCode).To(Equal(http.StatusBadRequest))
 				Expect(b.String()).To(MatchJSON(`{
 					"error": "invalid_request",
+					"code": "payload_too_large",
 					"error_description": "request body is too large"
 				}`))
 
@@ -672,7 +687,7 @@ A6ao9QSL1ryillYV9Y4001C3jApzmMtBWoMp3NPzwU8nacAOzClJYUcSLkbAIEWV
 			fakeS3 *fake.S3
 			origS3 filetransfer.FileTransfer
 
-			mq                    *amqp.Connection
+			mq                    mqconn.Conn
 			invalidationQueueName string
 
 			acmeServer *ghttp.Server
@@ -689,6 +704,12 @@ A6ao9QSL1ryillYV9Y4001C3jApzmMtBWoMp3NPzwU8nacAOzClJYUcSLkbAIEWV
 			newAuthzBody        string
 			challengeStatusCode int
 			challengeBody       string
+
+			// The registration object sent to Let's Encrypt to update the
+			// account contact.
+			updatedReg map[string]interface{}
+
+			params url.Values
 		)
 
 		BeforeEach(func() {
@@ -723,6 +744,8 @@ A6ao9QSL1ryillYV9Y4001C3jApzmMtBWoMp3NPzwU8nacAOzClJYUcSLkbAIEWV
 			Expect(letsencryptIssuerPEM).NotTo(BeNil())
 
 			acmeServer = ghttp.NewServer()
+			updatedReg = nil
+			params = nil
 
 			newAuthzStatusCode = http.StatusCreated
 			newAuthzBody = `{
@@ -769,9 +792,30 @@ A6ao9QSL1ryillYV9Y4001C3jApzmMtBWoMp3NPzwU8nacAOzClJYUcSLkbAIEWV
 					ghttp.VerifyRequest("POST", "/new-reg"),
 					ghttp.VerifyContentType("application/jose+jws"),
 					ghttp.RespondWith(http.StatusCreated, `{
+						"id": 123,
 						"resource": "new-reg",
+						"agreement": "`+acmeServer.URL()+`/terms",
+						"authorizations": "",
+						"certificates": ""
+					}`, http.Header{"Replay-Nonce": {"nonce-2"}}),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", "/acme/reg/123"),
+					ghttp.VerifyContentType("application/jose+jws"),
+					func(w http.ResponseWriter, r *http.Request) {
+						var jws struct {
+							Payload string `json:"payload"`
+						}
+						Expect(json.NewDecoder(r.Body).Decode(&jws)).To(Succeed())
+						payload, err := base64.RawURLEncoding.DecodeString(jws.Payload)
+						Expect(err).To(BeNil())
+						Expect(json.Unmarshal(payload, &updatedReg)).To(Succeed())
+					},
+					ghttp.RespondWith(http.StatusAccepted, `{
+						"id": 123,
+						"resource": "reg",
 						"contact": [
-							"mailto:cert-admin@example.com"
+							"mailto:`+u.Email+`"
 						],
 						"agreement": "`+acmeServer.URL()+`/terms",
 						"authorizations": "",
@@ -831,7 +875,7 @@ A6ao9QSL1ryillYV9Y4001C3jApzmMtBWoMp3NPzwU8nacAOzClJYUcSLkbAIEWV
 
 		doRequest := func() {
 			s = httptest.NewServer(server.New())
-			res, err = testhelper.MakeRequest("POST", s.URL+"/projects/"+proj.Name+"/domains/"+dm.Name+"/cert/letsencrypt", nil, headers, nil)
+			res, err = testhelper.MakeRequest("POST", s.URL+"/projects/"+proj.Name+"/domains/"+dm.Name+"/cert/letsencrypt", params, headers, nil)
 			Expect(err).To(BeNil())
 		}
 
@@ -900,7 +944,7 @@ A6ao9QSL1ryillYV9Y4001C3jApzmMtBWoMp3NPzwU8nacAOzClJYUcSLkbAIEWV
 			err := db.Where("domain_id = ?", dm.ID).First(acmeCert).Error
 			Expect(err).To(BeNil())
 
-			certChain, err := acmeCert.DecryptedCerts(common.AesKey)
+			certChain, err := acmeCert.DecryptedCerts(common.AesKeyring())
 			Expect(err).To(BeNil())
 
 			Expect(certChain).To(HaveLen(2))
@@ -915,7 +959,7 @@ A6ao9QSL1ryillYV9Y4001C3jApzmMtBWoMp3NPzwU8nacAOzClJYUcSLkbAIEWV
 		})
 
 		It("uses an existing Let's Encrypt private key when there's one", func() {
-			acmeCert, err := acmecert.New(dm.ID, common.AesKey)
+			acmeCert, err := acmecert.New(dm.ID, common.AesKeyring())
 			Expect(err).To(BeNil())
 			Expect(db.Create(acmeCert).Error).To(BeNil())
 
@@ -962,7 +1006,7 @@ A6ao9QSL1ryillYV9Y4001C3jApzmMtBWoMp3NPzwU8nacAOzClJYUcSLkbAIEWV
 			err = db.Where("domain_id = ?", dm.ID).First(acmeCert).Error
 			Expect(err).To(BeNil())
 
-			privKey, err := acmeCert.DecryptedPrivateKey(common.AesKey)
+			privKey, err := acmeCert.DecryptedPrivateKey(common.AesKeyring())
 			Expect(err).To(BeNil())
 			privKeyPEM := pem.EncodeToMemory(&pem.Block{
 				Type:  "RSA PRIVATE KEY",
@@ -991,6 +1035,21 @@ A6ao9QSL1ryillYV9Y4001C3jApzmMtBWoMp3NPzwU8nacAOzClJYUcSLkbAIEWV
 			Expect(acmeCert.HTTPChallengeResource).To((HavePrefix("secret-token.")))
 		})
 
+		It("registers the email of the user as the contact of the Let's Encrypt account", func() {
+			doRequest()
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+			Expect(updatedReg).NotTo(BeNil())
+			Expect(updatedReg["resource"]).To(Equal("reg"))
+			Expect(updatedReg["contact"]).To(Equal([]interface{}{"mailto:" + u.Email}))
+
+			acmeCert := &acmecert.AcmeCert{}
+			err := db.Where("domain_id = ?", dm.ID).First(acmeCert).Error
+			Expect(err).To(BeNil())
+			Expect(acmeCert.ContactEmail).NotTo(BeNil())
+			Expect(*acmeCert.ContactEmail).To(Equal(u.Email))
+		})
+
 		It("saves the cert renewal URI returned by Let's Encrypt", func() {
 			doRequest()
 
@@ -1087,6 +1146,150 @@ A6ao9QSL1ryillYV9Y4001C3jApzmMtBWoMp3NPzwU8nacAOzClJYUcSLkbAIEWV
 			})
 		})
 
+		Context("when the TLS-ALPN challenge is requested", func() {
+			BeforeEach(func() {
+				params = url.Values{"challenge_type": {"tls-alpn-01"}}
+
+				// The TLS-ALPN challenge is marked ready with a nonce that is
+				// fetched from the directory, so insert that request before
+				// the challenge request.
+				var rest []http.HandlerFunc
+				for i := 5; i < 9; i++ {
+					rest = append(rest, acmeServer.GetHandler(i))
+				}
+				acmeServer.SetHandler(5, ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/"),
+					ghttp.RespondWith(http.StatusOK, `{}`, http.Header{"Replay-Nonce": {"nonce-tls-alpn"}}),
+				))
+				for i, h := range rest[:3] {
+					acmeServer.SetHandler(6+i, h)
+				}
+				acmeServer.AppendHandlers(rest[3])
+
+				newAuthzBody = `{
+					"identifier": {
+						"type": "dns",
+						"value": "www.foo-bar-express.com"
+					},
+					"status": "pending",
+					"expires": "2016-06-28T09:41:07.002634342Z",
+					"challenges": [
+						{
+							"type": "http-01",
+							"status": "pending",
+							"uri": "` + acmeServer.URL() + `/acme/challenge/abcde/123",
+							"token": "other-token"
+						},
+						{
+							"type": "tls-alpn-01",
+							"status": "pending",
+							"uri": "` + acmeServer.URL() + `/acme/challenge/abcde/124",
+							"token": "secret-token"
+						}
+					],
+					"combinations": [
+						[0],
+						[1]
+					]
+				}`
+			})
+
+			It("returns 200 OK", func() {
+				doRequest()
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+			})
+
+			It("uploads the challenge cert for edges to present to Let's Encrypt", func() {
+				doRequest()
+				Expect(fakeS3.UploadCalls.Count()).To(Equal(4))
+
+				call := fakeS3.UploadCalls.NthCall(1)
+				Expect(call).NotTo(BeNil())
+				Expect(call.Arguments[2]).To(Equal("certs/www.foo-bar-express.com/acme-tls-alpn.crt"))
+				Expect(call.Arguments[5]).To(Equal("private"))
+				encryptedCrt, ok := call.SideEffects["uploaded_content"].([]byte)
+				Expect(ok).To(BeTrue())
+				decryptedCrt, err := aesencrypter.Decrypt(encryptedCrt, []byte(common.AesKey))
+				Expect(err).To(BeNil())
+
+				call = fakeS3.UploadCalls.NthCall(2)
+				Expect(call).NotTo(BeNil())
+				Expect(call.Arguments[2]).To(Equal("certs/www.foo-bar-express.com/acme-tls-alpn.key"))
+				encryptedKey, ok := call.SideEffects["uploaded_content"].([]byte)
+				Expect(ok).To(BeTrue())
+				decryptedKey, err := aesencrypter.Decrypt(encryptedKey, []byte(common.AesKey))
+				Expect(err).To(BeNil())
+
+				challengeCert, err := tls.X509KeyPair(decryptedCrt, decryptedKey)
+				Expect(err).To(BeNil())
+				x509Cert, err := x509.ParseCertificate(challengeCert.Certificate[0])
+				Expect(err).To(BeNil())
+				Expect(x509Cert.DNSNames).To(Equal([]string{"www.foo-bar-express.com"}))
+
+				call = fakeS3.UploadCalls.NthCall(3)
+				Expect(call).NotTo(BeNil())
+				Expect(call.Arguments[2]).To(Equal("certs/www.foo-bar-express.com/ssl.crt"))
+			})
+
+			It("deletes the challenge cert once the challenge is done", func() {
+				doRequest()
+
+				Expect(fakeS3.DeleteCalls.Count()).To(Equal(1))
+				call := fakeS3.DeleteCalls.NthCall(1)
+				Expect(call).NotTo(BeNil())
+				Expect(call.Arguments[2]).To(Equal("certs/www.foo-bar-express.com/acme-tls-alpn.crt"))
+				Expect(call.Arguments[3]).To(Equal("certs/www.foo-bar-express.com/acme-tls-alpn.key"))
+
+				acmeCert := &acmecert.AcmeCert{}
+				err := db.Where("domain_id = ?", dm.ID).First(acmeCert).Error
+				Expect(err).To(BeNil())
+				Expect(acmeCert.TLSALPNChallengeCert).To(Equal(""))
+				Expect(acmeCert.HTTPChallengePath).To(Equal(""))
+			})
+
+			Context("when Let's Encrypt fails to verify the challenge", func() {
+				BeforeEach(func() {
+					challengeBody = `{
+						"type": "tls-alpn-01",
+						"status": "invalid",
+						"error": {
+							"type": "urn:acme:error:connection",
+							"detail": "Connection refused",
+							"status": 400
+						}
+					}`
+				})
+
+				It("responds with HTTP 503 and deletes the challenge cert", func() {
+					doRequest()
+
+					Expect(res.StatusCode).To(Equal(http.StatusServiceUnavailable))
+					Expect(fakeS3.DeleteCalls.Count()).To(Equal(1))
+				})
+			})
+		})
+
+		Context("when the challenge type is invalid", func() {
+			BeforeEach(func() {
+				params = url.Values{"challenge_type": {"dns-01"}}
+			})
+
+			It("responds with HTTP 422", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(422))
+
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"errors": {
+						"challenge_type": "is invalid"
+					}
+				}`))
+			})
+		})
+
 		Context("when Let's Encrypt is down", func() {
 			BeforeEach(func() {
 				crashedAcmeServer := ghttp.NewServer()
@@ -1142,11 +1345,43 @@ A6ao9QSL1ryillYV9Y4001C3jApzmMtBWoMp3NPzwU8nacAOzClJYUcSLkbAIEWV
 				_, err = b.ReadFrom(res.Body)
 				Expect(b.String()).To(MatchJSON(`{
 					"error": "service_unavailable",
+					"code": "cert_pending",
 					"error_description": "domain could not be verified"
 				}`))
 			})
 		})
 
+		Context("when the domain is deleted while the cert is being issued", func() {
+			BeforeEach(func() {
+				// Delete the domain while the challenge is being verified.
+				acmeServer.WrapHandler(6, func(w http.ResponseWriter, r *http.Request) {
+					Expect(db.Delete(dm).Error).To(BeNil())
+				})
+			})
+
+			It("responds with HTTP 404 and deletes the ACME cert", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "not_found",
+					"error_description": "domain could not be found"
+				}`))
+
+				var count int
+				Expect(db.Model(acmecert.AcmeCert{}).Where("domain_id = ?", dm.ID).Count(&count).Error).To(BeNil())
+				Expect(count).To(Equal(0))
+
+				Expect(db.Model(cert.Cert{}).Where("domain_id = ?", dm.ID).Count(&count).Error).To(BeNil())
+				Expect(count).To(Equal(0))
+
+				Expect(fakeS3.UploadCalls.Count()).To(Equal(0))
+			})
+		})
+
 		Context("when the default domain is given", func() {
 			It("responds with HTTP 403 forbidden", func() {
 				doRequestWithDefaultDomain()
@@ -1308,7 +1543,7 @@ A6ao9QSL1ryillYV9Y4001C3jApzmMtBWoMp3NPzwU8nacAOzClJYUcSLkbAIEWV
 			fakeS3 *fake.S3
 			origS3 filetransfer.FileTransfer
 
-			mq    *amqp.Connection
+			mq    mqconn.Conn
 			qName string
 
 			u  *user.User
@@ -1375,8 +1610,8 @@ A6ao9QSL1ryillYV9Y4001C3jApzmMtBWoMp3NPzwU8nacAOzClJYUcSLkbAIEWV
 		})
 
 		It("deletes Let's Encrypt ACME cert from DB, if it exists", func() {
-			aesKey := "something-something-something-32"
-			acmeCert, err := acmecert.New(dm.ID, aesKey)
+			keyring := aesencrypter.NewKeyring("something-something-something-32", nil)
+			acmeCert, err := acmecert.New(dm.ID, keyring)
 			Expect(err).To(BeNil())
 			Expect(db.Create(acmeCert).Error).To(BeNil())
 
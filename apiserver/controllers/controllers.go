@@ -6,21 +6,40 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/nitrous-io/rise-server/apiserver/models/apikey"
 	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
 	"github.com/nitrous-io/rise-server/apiserver/models/project"
 	"github.com/nitrous-io/rise-server/apiserver/models/user"
 
 	"github.com/gin-gonic/gin"
+	"golang.org/x/net/context"
 
 	log "github.com/Sirupsen/logrus"
 )
 
 const (
 	CurrentTokenKey   = "current_token"
+	CurrentAPIKeyKey  = "current_api_key"
 	CurrentUserKey    = "current_user"
 	CurrentProjectKey = "current_project"
+	ContextKey        = "context"
 )
 
+// Context returns the context of the current request, which is canceled when
+// the request times out. It returns a background context if none was set.
+func Context(c *gin.Context) context.Context {
+	ci, exists := c.Get(ContextKey)
+	if ci == nil || !exists {
+		return context.Background()
+	}
+
+	ctx, ok := ci.(context.Context)
+	if !ok {
+		return context.Background()
+	}
+	return ctx
+}
+
 func CurrentToken(c *gin.Context) *oauthtoken.OauthToken {
 	ti, exists := c.Get(CurrentTokenKey)
 	if ti == nil || !exists {
@@ -34,6 +53,21 @@ func CurrentToken(c *gin.Context) *oauthtoken.OauthToken {
 	return t
 }
 
+// CurrentAPIKey returns the API key that the current request was signed with,
+// or nil if it was not signed.
+func CurrentAPIKey(c *gin.Context) *apikey.APIKey {
+	ki, exists := c.Get(CurrentAPIKeyKey)
+	if ki == nil || !exists {
+		return nil
+	}
+
+	k, ok := ki.(*apikey.APIKey)
+	if !ok {
+		return nil
+	}
+	return k
+}
+
 func CurrentUser(c *gin.Context) *user.User {
 	ui, exists := c.Get(CurrentUserKey)
 	if ui == nil || !exists {
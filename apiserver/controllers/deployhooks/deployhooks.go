@@ -0,0 +1,284 @@
+package deployhooks
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployhook"
+	"github.com/nitrous-io/rise-server/shared/errcodes"
+)
+
+// Index lists the deploy hooks of a project.
+func Index(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	hooks, err := deployhook.FindByProjectID(db, proj.ID, "")
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	hooksAsJSON := make([]interface{}, len(hooks))
+	for i, h := range hooks {
+		hooksAsJSON[i] = h.AsJSON()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"deploy_hooks": hooksAsJSON,
+	})
+}
+
+// Create adds a deploy hook to a project. A pre_build hook is a shell command
+// that is run in the bundle before it is optimized, and a post_deploy hook is
+// an HTTPS URL that is POSTed to after a deployment goes live.
+func Create(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	hook := &deployhook.DeployHook{
+		ProjectID: proj.ID,
+		Stage:     c.PostForm("stage"),
+	}
+	if command := c.PostForm("command"); command != "" {
+		hook.Command = &command
+	}
+	if url := c.PostForm("url"); url != "" {
+		hook.URL = &url
+	}
+
+	if errs := hook.Validate(); errs != nil {
+		c.JSON(422, gin.H{
+			"error":  "invalid_params",
+			"errors": errs,
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	count, err := deployhook.CountByProjectID(db, proj.ID)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if count >= deployhook.MaxPerProject {
+		c.JSON(422, gin.H{
+			"error":             "invalid_request",
+			"code":              errcodes.QuotaExceeded,
+			"error_description": fmt.Sprintf("a project cannot have more than %d deploy hooks", deployhook.MaxPerProject),
+		})
+		return
+	}
+
+	if err := db.Create(hook).Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	{
+		u := controllers.CurrentUser(c)
+
+		var (
+			event = "Added Deploy Hook"
+			props = map[string]interface{}{
+				"projectName": proj.Name,
+				"stage":       hook.Stage,
+			}
+			context = controllers.TrackingContext(c)
+		)
+		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
+			log.Errorf("failed to track %q event for user ID %d, err: %v",
+				event, u.ID, err)
+		}
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"deploy_hook": hook.AsJSON(),
+	})
+}
+
+// Destroy removes a deploy hook from a project.
+func Destroy(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	hookID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "deploy hook could not be found",
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	q := db.Delete(deployhook.DeployHook{}, "id = ? AND project_id = ?", hookID, proj.ID)
+	if err := q.Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if q.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "deploy hook could not be found",
+		})
+		return
+	}
+
+	{
+		u := controllers.CurrentUser(c)
+
+		var (
+			event = "Removed Deploy Hook"
+			props = map[string]interface{}{
+				"projectName": proj.Name,
+			}
+			context = controllers.TrackingContext(c)
+		)
+		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
+			log.Errorf("failed to track %q event for user ID %d, err: %v",
+				event, u.ID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"deleted": true,
+	})
+}
+
+// Deliveries lists the recent deliveries of a post_deploy hook, most recent
+// first, so that integrators can see how their hook responded.
+func Deliveries(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	hook, ok := findHook(c, db, proj.ID)
+	if !ok {
+		return
+	}
+
+	deliveries, err := deployhook.FindDeliveries(db, hook.ID)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	deliveriesAsJSON := make([]interface{}, len(deliveries))
+	for i, d := range deliveries {
+		deliveriesAsJSON[i] = d.AsJSON()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"deliveries": deliveriesAsJSON,
+	})
+}
+
+// Replay POSTs the payload of a past delivery to a post_deploy hook again,
+// e.g. after the receiving end was down, and returns the new delivery.
+func Replay(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	hook, ok := findHook(c, db, proj.ID)
+	if !ok {
+		return
+	}
+
+	var orig *deployhook.Delivery
+	if deliveryID, err := strconv.ParseUint(c.Param("delivery_id"), 10, 64); err == nil {
+		orig, err = deployhook.FindDelivery(db, hook.ID, uint(deliveryID))
+		if err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+	}
+
+	if orig == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "delivery could not be found",
+		})
+		return
+	}
+
+	d, err := hook.Deliver(db, orig.DeploymentID, []byte(orig.Payload), &orig.ID)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	{
+		u := controllers.CurrentUser(c)
+
+		var (
+			event = "Replayed Deploy Hook Delivery"
+			props = map[string]interface{}{
+				"projectName": proj.Name,
+				"succeeded":   d.Succeeded(),
+			}
+			context = controllers.TrackingContext(c)
+		)
+		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
+			log.Errorf("failed to track %q event for user ID %d, err: %v",
+				event, u.ID, err)
+		}
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"delivery": d.AsJSON(),
+	})
+}
+
+// findHook returns the post_deploy hook of the project with the ID in the
+// path, and responds with 404 and returns false if there is none.
+func findHook(c *gin.Context, db *gorm.DB, projectID uint) (*deployhook.DeployHook, bool) {
+	hook := &deployhook.DeployHook{}
+	if hookID, err := strconv.ParseUint(c.Param("id"), 10, 64); err == nil {
+		err = db.Where("id = ? AND project_id = ? AND stage = ?", hookID, projectID, deployhook.StagePostDeploy).First(hook).Error
+		if err == nil {
+			return hook, true
+		}
+		if err != gorm.RecordNotFound {
+			controllers.InternalServerError(c, err)
+			return nil, false
+		}
+	}
+
+	c.JSON(http.StatusNotFound, gin.H{
+		"error":             "not_found",
+		"error_description": "deploy hook could not be found",
+	})
+	return nil, false
+}
@@ -0,0 +1,517 @@
+package deployhooks_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployhook"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/apiserver/server"
+	"github.com/nitrous-io/rise-server/pkg/tracker"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+	"github.com/nitrous-io/rise-server/testhelper/fake"
+	"github.com/nitrous-io/rise-server/testhelper/sharedexamples"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "deployhooks")
+}
+
+var _ = Describe("DeployHooks", func() {
+	var (
+		fakeTracker *fake.Tracker
+		origTracker tracker.Trackable
+
+		db *gorm.DB
+
+		s   *httptest.Server
+		res *http.Response
+		err error
+
+		u *user.User
+		t *oauthtoken.OauthToken
+
+		headers http.Header
+		proj    *project.Project
+	)
+
+	BeforeEach(func() {
+		origTracker = common.Tracker
+		fakeTracker = &fake.Tracker{}
+		common.Tracker = fakeTracker
+
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+
+		testhelper.TruncateTables(db.DB())
+
+		u, _, t = factories.AuthTrio(db)
+
+		proj = &project.Project{
+			Name:   "foo-bar-express",
+			UserID: u.ID,
+		}
+		Expect(db.Create(proj).Error).To(BeNil())
+
+		headers = http.Header{
+			"Authorization": {"Bearer " + t.Token},
+		}
+	})
+
+	AfterEach(func() {
+		common.Tracker = origTracker
+
+		if res != nil {
+			res.Body.Close()
+		}
+		s.Close()
+	})
+
+	createHook := func(projectID uint, stage, value string) *deployhook.DeployHook {
+		h := &deployhook.DeployHook{ProjectID: projectID, Stage: stage}
+		if stage == deployhook.StagePreBuild {
+			h.Command = &value
+		} else {
+			h.URL = &value
+		}
+		Expect(db.Create(h).Error).To(BeNil())
+		return h
+	}
+
+	Describe("GET /projects/:project_name/deploy_hooks", func() {
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("GET", s.URL+"/projects/foo-bar-express/deploy_hooks", nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("returns the deploy hooks of the project", func() {
+			h1 := createHook(proj.ID, deployhook.StagePreBuild, "npm run build")
+			h2 := createHook(proj.ID, deployhook.StagePostDeploy, "https://example.com/deployed")
+
+			otherProj := factories.Project(db, u)
+			createHook(otherProj.ID, deployhook.StagePreBuild, "make")
+
+			// Reload to get timestamps with the precision stored in the DB.
+			Expect(db.First(h1, h1.ID).Error).To(BeNil())
+			Expect(db.First(h2, h2.ID).Error).To(BeNil())
+
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+				"deploy_hooks": [
+					{
+						"id": %d,
+						"stage": "pre_build",
+						"command": "npm run build",
+						"created_at": %q
+					},
+					{
+						"id": %d,
+						"stage": "post_deploy",
+						"url": "https://example.com/deployed",
+						"created_at": %q
+					}
+				]
+			}`, h1.ID, h1.CreatedAt.Format(time.RFC3339Nano), h2.ID, h2.CreatedAt.Format(time.RFC3339Nano))))
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProject(func() (*gorm.DB, *project.Project) {
+			return db, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+
+	Describe("POST /projects/:project_name/deploy_hooks", func() {
+		var params url.Values
+
+		BeforeEach(func() {
+			params = url.Values{
+				"stage": {"post_deploy"},
+				"url":   {"https://example.com/deployed"},
+			}
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("POST", s.URL+"/projects/foo-bar-express/deploy_hooks", params, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		Context("when the params are invalid", func() {
+			BeforeEach(func() {
+				params.Set("url", "http://example.com/deployed")
+				params.Set("command", "make")
+			})
+
+			It("returns 422 unprocessable entity", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"errors": {
+						"url": "must be an https URL",
+						"command": "is not allowed for post_deploy hooks"
+					}
+				}`))
+
+				var count int
+				Expect(db.Model(deployhook.DeployHook{}).Count(&count).Error).To(BeNil())
+				Expect(count).To(Equal(0))
+			})
+		})
+
+		Context("when the project already has the maximum number of deploy hooks", func() {
+			BeforeEach(func() {
+				for i := 0; i < deployhook.MaxPerProject; i++ {
+					createHook(proj.ID, deployhook.StagePreBuild, "make")
+				}
+			})
+
+			It("returns 422 unprocessable entity", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_request",
+					"code": "quota_exceeded",
+					"error_description": "a project cannot have more than 10 deploy hooks"
+				}`))
+			})
+		})
+
+		It("creates a deploy hook", func() {
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			hooks, err := deployhook.FindByProjectID(db, proj.ID, deployhook.StagePostDeploy)
+			Expect(err).To(BeNil())
+			Expect(hooks).To(HaveLen(1))
+			Expect(*hooks[0].URL).To(Equal("https://example.com/deployed"))
+			Expect(hooks[0].Command).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusCreated))
+			Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+				"deploy_hook": {
+					"id": %d,
+					"stage": "post_deploy",
+					"url": "https://example.com/deployed",
+					"created_at": %q
+				}
+			}`, hooks[0].ID, hooks[0].CreatedAt.Format(time.RFC3339Nano))))
+		})
+
+		It("tracks an 'Added Deploy Hook' event", func() {
+			doRequest()
+
+			trackCall := fakeTracker.TrackCalls.NthCall(1)
+			Expect(trackCall).NotTo(BeNil())
+			Expect(trackCall.Arguments[0]).To(Equal(fmt.Sprintf("%d", u.ID)))
+			Expect(trackCall.Arguments[1]).To(Equal("Added Deploy Hook"))
+			Expect(trackCall.Arguments[2]).To(Equal(""))
+
+			t := trackCall.Arguments[3]
+			props, ok := t.(map[string]interface{})
+			Expect(ok).To(BeTrue())
+			Expect(props["projectName"]).To(Equal("foo-bar-express"))
+			Expect(props["stage"]).To(Equal("post_deploy"))
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProject(func() (*gorm.DB, *project.Project) {
+			return db, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+
+	Describe("DELETE /projects/:project_name/deploy_hooks/:id", func() {
+		var hook *deployhook.DeployHook
+
+		BeforeEach(func() {
+			hook = createHook(proj.ID, deployhook.StagePreBuild, "make")
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("DELETE", s.URL+fmt.Sprintf("/projects/foo-bar-express/deploy_hooks/%d", hook.ID), nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		Context("when the deploy hook belongs to another project", func() {
+			BeforeEach(func() {
+				otherProj := factories.Project(db, u)
+				hook = createHook(otherProj.ID, deployhook.StagePreBuild, "make")
+			})
+
+			It("returns 404 not found", func() {
+				doRequest()
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+
+				Expect(db.First(&deployhook.DeployHook{}, hook.ID).Error).To(BeNil())
+			})
+		})
+
+		It("deletes the deploy hook", func() {
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(MatchJSON(`{"deleted": true}`))
+
+			hooks, err := deployhook.FindByProjectID(db, proj.ID, "")
+			Expect(err).To(BeNil())
+			Expect(hooks).To(BeEmpty())
+
+			trackCall := fakeTracker.TrackCalls.NthCall(1)
+			Expect(trackCall).NotTo(BeNil())
+			Expect(trackCall.Arguments[1]).To(Equal("Removed Deploy Hook"))
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProject(func() (*gorm.DB, *project.Project) {
+			return db, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+
+	Describe("deliveries", func() {
+		var (
+			hookServer *httptest.Server
+			received   []string
+
+			hook     *deployhook.DeployHook
+			depl     *deployment.Deployment
+			delivery *deployhook.Delivery
+		)
+
+		BeforeEach(func() {
+			deployhook.AllowPrivateIPs = true
+
+			received = nil
+			hookServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, err := ioutil.ReadAll(r.Body)
+				Expect(err).To(BeNil())
+				received = append(received, string(b))
+
+				if len(received) == 1 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}
+			}))
+
+			hook = createHook(proj.ID, deployhook.StagePostDeploy, hookServer.URL)
+			depl = factories.Deployment(db, proj, u, deployment.StateDeployed)
+
+			delivery, err = hook.Deliver(db, depl.ID, []byte(`{"event":"deployed"}`), nil)
+			Expect(err).To(BeNil())
+		})
+
+		AfterEach(func() {
+			hookServer.Close()
+			deployhook.AllowPrivateIPs = false
+		})
+
+		Describe("GET /projects/:project_name/deploy_hooks/:id/deliveries", func() {
+			doRequest := func() {
+				s = httptest.NewServer(server.New())
+				res, err = testhelper.MakeRequest("GET", s.URL+fmt.Sprintf("/projects/foo-bar-express/deploy_hooks/%d/deliveries", hook.ID), nil, headers, nil)
+				Expect(err).To(BeNil())
+			}
+
+			It("returns the deliveries of the deploy hook", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"deliveries": [
+						{
+							"id": %d,
+							"deployment_id": %d,
+							"replay_of_id": null,
+							"succeeded": false,
+							"status_code": 503,
+							"latency_ms": %d,
+							"payload": "{\"event\":\"deployed\"}",
+							"response_snippet": "",
+							"error_message": "unexpected response status 503",
+							"created_at": %q
+						}
+					]
+				}`, delivery.ID, depl.ID, delivery.LatencyMs, delivery.CreatedAt.Format(time.RFC3339Nano))))
+			})
+
+			Context("when the deploy hook belongs to another project", func() {
+				BeforeEach(func() {
+					otherProj := factories.Project(db, u)
+					hook = createHook(otherProj.ID, deployhook.StagePostDeploy, hookServer.URL)
+				})
+
+				It("returns 404 not found", func() {
+					doRequest()
+					Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+				})
+			})
+
+			sharedexamples.ItRequiresProject(func() (*gorm.DB, *project.Project) {
+				return db, proj
+			}, func() *http.Response {
+				doRequest()
+				return res
+			}, nil)
+		})
+
+		Describe("POST /projects/:project_name/deploy_hooks/:id/deliveries/:delivery_id/replay", func() {
+			var deliveryID uint
+
+			BeforeEach(func() {
+				deliveryID = delivery.ID
+			})
+
+			doRequest := func() {
+				s = httptest.NewServer(server.New())
+				res, err = testhelper.MakeRequest("POST", s.URL+fmt.Sprintf("/projects/foo-bar-express/deploy_hooks/%d/deliveries/%d/replay", hook.ID, deliveryID), nil, headers, nil)
+				Expect(err).To(BeNil())
+			}
+
+			It("delivers the payload again and returns the new delivery", func() {
+				doRequest()
+
+				Expect(received).To(Equal([]string{`{"event":"deployed"}`, `{"event":"deployed"}`}))
+
+				deliveries, err := deployhook.FindDeliveries(db, hook.ID)
+				Expect(err).To(BeNil())
+				Expect(deliveries).To(HaveLen(2))
+				d := deliveries[0]
+				Expect(*d.ReplayOfID).To(Equal(delivery.ID))
+				Expect(d.DeploymentID).To(Equal(depl.ID))
+
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusCreated))
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"delivery": {
+						"id": %d,
+						"deployment_id": %d,
+						"replay_of_id": %d,
+						"succeeded": true,
+						"status_code": 200,
+						"latency_ms": %d,
+						"payload": "{\"event\":\"deployed\"}",
+						"response_snippet": "",
+						"error_message": null,
+						"created_at": %q
+					}
+				}`, d.ID, depl.ID, delivery.ID, d.LatencyMs, d.CreatedAt.Format(time.RFC3339Nano))))
+
+				trackCall := fakeTracker.TrackCalls.NthCall(1)
+				Expect(trackCall).NotTo(BeNil())
+				Expect(trackCall.Arguments[1]).To(Equal("Replayed Deploy Hook Delivery"))
+			})
+
+			Context("when the delivery does not exist", func() {
+				BeforeEach(func() {
+					deliveryID = delivery.ID + 1
+				})
+
+				It("returns 404 not found", func() {
+					doRequest()
+					Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+					Expect(received).To(HaveLen(1))
+				})
+			})
+
+			Context("when the hook URL is a private address", func() {
+				BeforeEach(func() {
+					deployhook.AllowPrivateIPs = false
+				})
+
+				It("records a failed delivery without calling it", func() {
+					doRequest()
+
+					Expect(res.StatusCode).To(Equal(http.StatusCreated))
+					Expect(received).To(HaveLen(1))
+
+					deliveries, err := deployhook.FindDeliveries(db, hook.ID)
+					Expect(err).To(BeNil())
+					Expect(deliveries).To(HaveLen(2))
+					Expect(deliveries[0].Succeeded()).To(BeFalse())
+					Expect(deliveries[0].ResponseSnippet).To(BeEmpty())
+					Expect(*deliveries[0].ErrorMessage).To(ContainSubstring("address is not publicly routable"))
+				})
+			})
+
+			sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+				return db, u, &headers
+			}, func() *http.Response {
+				doRequest()
+				return res
+			}, nil)
+		})
+	})
+})
@@ -2,9 +2,13 @@ package deployments
 
 import (
 	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"path"
 	"strconv"
 	"strings"
 	"time"
@@ -15,11 +19,17 @@ import (
 	"github.com/nitrous-io/rise-server/apiserver/common"
 	"github.com/nitrous-io/rise-server/apiserver/controllers"
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/deploywatch"
 	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/outboxjob"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
 	"github.com/nitrous-io/rise-server/apiserver/models/rawbundle"
 	"github.com/nitrous-io/rise-server/apiserver/models/template"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
 	"github.com/nitrous-io/rise-server/pkg/hasher"
 	"github.com/nitrous-io/rise-server/pkg/job"
+	"github.com/nitrous-io/rise-server/pkg/rootdir"
+	"github.com/nitrous-io/rise-server/shared/errcodes"
 	"github.com/nitrous-io/rise-server/shared/messages"
 	"github.com/nitrous-io/rise-server/shared/queues"
 	"github.com/nitrous-io/rise-server/shared/s3client"
@@ -36,6 +46,8 @@ const presignExpiryDuration = 1 * time.Minute
 
 // Create deploys a project.
 func Create(c *gin.Context) {
+	start := time.Now()
+
 	u := controllers.CurrentUser(c)
 	proj := controllers.CurrentProject(c)
 
@@ -45,9 +57,25 @@ func Create(c *gin.Context) {
 		return
 	}
 
+	// Fail early instead of after the bundle has been uploaded. The check is
+	// repeated with the project locked once the deployment is ready to start.
+	if proj.DeployConcurrency == project.DeployConcurrencyReject {
+		inFlight, err := deployment.InFlight(db, proj.ID)
+		if err != nil {
+			controllers.InternalServerError(c, err, "deployments: failed to find a deployment in flight")
+			return
+		}
+		if inFlight != nil {
+			respondInFlight(c, inFlight)
+			return
+		}
+	}
+
 	depl := &deployment.Deployment{
 		ProjectID: proj.ID,
 		UserID:    u.ID,
+		Source:    controllers.DeploymentSource(c),
+		Settings:  proj.DeploymentDefaults,
 	}
 
 	// Get js environment variables from previous deployment.
@@ -58,12 +86,29 @@ func Create(c *gin.Context) {
 			return
 		}
 
-		depl.JsEnvVars = prevDepl.JsEnvVars
+		depl.CopyJsEnvVars(&prevDepl)
 	}
 
+	// The deployment is created before its bundle is uploaded, as the bundle
+	// is stored under its prefix, but the bundle is recorded and the
+	// deployment started in another transaction once the upload is done, so
+	// that the project is not locked while the bundle is uploaded. The
+	// deployment is deleted again if it is not started, e.g. because the
+	// upload failed, so that it is not left behind in pending_upload.
+	var keep bool
+	defer func() {
+		if depl.ID != 0 && !keep {
+			discardDeployment(db, depl)
+		}
+	}()
+
 	var (
 		archiveFormat string
 		strategy      = viaUnknown
+
+		// The raw bundle that is uploaded or copied for the deployment. It is
+		// recorded with the deployment once it is in S3.
+		bun *rawbundle.RawBundle
 	)
 
 	if strings.HasPrefix(c.Request.Header.Get("Content-Type"), "multipart/form-data; boundary=") {
@@ -74,6 +119,41 @@ func Create(c *gin.Context) {
 		strategy = viaTemplate
 	}
 
+	// The root directory and VCS metadata of a payload are sent as parts of
+	// the multipart request, and are read when the parts are read below.
+	if strategy != viaPayload {
+		if depl.RootDir, err = rootdir.Clean(c.PostForm("root_dir")); err != nil {
+			c.JSON(422, gin.H{
+				"error": "invalid_params",
+				"errors": map[string]string{
+					"root_dir": "is invalid",
+				},
+			})
+			return
+		}
+
+		if preview, _ := strconv.ParseBool(c.PostForm("preview")); preview {
+			if err := makePreview(depl); err != nil {
+				controllers.InternalServerError(c, err, "deployments: failed to generate a preview prefix")
+				return
+			}
+		}
+
+		if protect, _ := strconv.ParseBool(c.PostForm("preview_basic_auth")); protect {
+			if !protectPreview(c, depl) {
+				return
+			}
+		}
+
+		if errs := setVCSParams(depl, c.PostForm); len(errs) > 0 {
+			c.JSON(422, gin.H{
+				"error":  "invalid_params",
+				"errors": errs,
+			})
+			return
+		}
+	}
+
 	switch strategy {
 	case viaPayload:
 		reader, err := c.Request.MultipartReader()
@@ -85,21 +165,32 @@ func Create(c *gin.Context) {
 			return
 		}
 
-		if n, err := strconv.ParseInt(c.Request.Header.Get("Content-Length"), 10, 64); err != nil || n > s3client.MaxUploadSize {
-			if err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{
-					"error":             "invalid_request",
-					"error_description": "Content-Length header is required",
-				})
-			} else {
-				c.JSON(http.StatusBadRequest, gin.H{
-					"error":             "invalid_request",
-					"error_description": "request body is too large",
-				})
-			}
+		n, err := strconv.ParseInt(c.Request.Header.Get("Content-Length"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":             "invalid_request",
+				"error_description": "Content-Length header is required",
+			})
+			return
+		}
+
+		maxSize, err := uploadSizeLimit(db, proj, u)
+		if err != nil {
+			controllers.InternalServerError(c, err, "deployments: failed to find the upload size limit")
+			return
+		}
+		if n > maxSize {
+			respondTooLarge(c, maxSize)
 			return
 		}
 
+		// SHA-256 digest of the payload as computed by the client, if sent.
+		var payloadChecksum string
+
+		// Whether the preview domain is protected with basic auth. It is
+		// applied when the payload is read, as "preview" may come after it.
+		var previewBasicAuth bool
+
 		// upload "payload" part to s3
 		for {
 			part, err := reader.NextPart()
@@ -113,16 +204,106 @@ func Create(c *gin.Context) {
 				return
 			}
 
-			if part.FormName() == "payload" {
-				ver, err := proj.NextVersion(db)
+			// "root_dir" has to be sent before "payload" to take effect.
+			if part.FormName() == "root_dir" {
+				b, err := ioutil.ReadAll(io.LimitReader(part, rootdir.MaxLength+1))
+				if err != nil {
+					controllers.InternalServerError(c, err, "deployments: failed to read root_dir")
+					return
+				}
+
+				if depl.RootDir, err = rootdir.Clean(string(b)); err != nil {
+					c.JSON(422, gin.H{
+						"error": "invalid_params",
+						"errors": map[string]interface{}{
+							"root_dir": "is invalid",
+						},
+					})
+					return
+				}
+				continue
+			}
+
+			// "preview" has to be sent before "payload" too, as the prefix of
+			// the deployment is generated before the payload is uploaded.
+			if part.FormName() == "preview" {
+				b, err := ioutil.ReadAll(io.LimitReader(part, 16))
+				if err != nil {
+					controllers.InternalServerError(c, err, "deployments: failed to read preview")
+					return
+				}
+
+				if preview, _ := strconv.ParseBool(strings.TrimSpace(string(b))); preview {
+					if err := makePreview(depl); err != nil {
+						controllers.InternalServerError(c, err, "deployments: failed to generate a preview prefix")
+						return
+					}
+				}
+				continue
+			}
+
+			// "preview_basic_auth" has to be sent before "payload" as well,
+			// as the credentials are returned with the created deployment.
+			if part.FormName() == "preview_basic_auth" {
+				b, err := ioutil.ReadAll(io.LimitReader(part, 16))
 				if err != nil {
-					controllers.InternalServerError(c, err, "deployments: failed to get next deployment version number")
+					controllers.InternalServerError(c, err, "deployments: failed to read preview_basic_auth")
 					return
 				}
 
-				depl.Version = ver
-				if err := db.Create(depl).Error; err != nil {
-					controllers.InternalServerError(c, err, "deployments: failed to create a deployment record in DB")
+				previewBasicAuth, _ = strconv.ParseBool(strings.TrimSpace(string(b)))
+				continue
+			}
+
+			// The VCS metadata params have to be sent before "payload" too,
+			// as the deployment is created when the payload is read.
+			if isVCSParam(part.FormName()) {
+				b, err := ioutil.ReadAll(io.LimitReader(part, maxVCSParamSize))
+				if err != nil {
+					controllers.InternalServerError(c, err, "deployments: failed to read "+part.FormName())
+					return
+				}
+
+				if msg := setVCSParam(depl, part.FormName(), string(b)); msg != "" {
+					c.JSON(422, gin.H{
+						"error": "invalid_params",
+						"errors": map[string]interface{}{
+							part.FormName(): msg,
+						},
+					})
+					return
+				}
+				continue
+			}
+
+			// "payload_checksum" has to be sent before "payload" as well, as
+			// the payload is verified while it is streamed to S3.
+			if part.FormName() == "payload_checksum" {
+				b, err := ioutil.ReadAll(io.LimitReader(part, sha256.Size*2+1))
+				if err != nil {
+					controllers.InternalServerError(c, err, "deployments: failed to read payload_checksum")
+					return
+				}
+
+				payloadChecksum = strings.ToLower(strings.TrimSpace(string(b)))
+				if _, err := hex.DecodeString(payloadChecksum); err != nil || len(payloadChecksum) != sha256.Size*2 {
+					c.JSON(422, gin.H{
+						"error": "invalid_params",
+						"errors": map[string]interface{}{
+							"payload_checksum": "is invalid",
+						},
+					})
+					return
+				}
+				continue
+			}
+
+			if part.FormName() == "payload" {
+				if previewBasicAuth && !protectPreview(c, depl) {
+					return
+				}
+
+				if !createDeployment(c, proj, depl) {
 					return
 				}
 
@@ -153,7 +334,7 @@ func Create(c *gin.Context) {
 					// By default, it returns "application/octet-stream"
 					c.JSON(http.StatusBadRequest, gin.H{
 						"error":             "invalid_request",
-						"error_description": "payload is in an unsupported format",
+						"error_description": "payload is in an unsupported format, it must be a zip or gzipped tar archive",
 					})
 					return
 				}
@@ -164,34 +345,22 @@ func Create(c *gin.Context) {
 					return
 				}
 
-				bun := &rawbundle.RawBundle{
+				if payloadChecksum != "" && payloadChecksum != hr.Checksum() {
+					keep = true
+					failChecksumMismatch(c, db, depl, uploadKey)
+					return
+				}
+
+				bun = &rawbundle.RawBundle{
 					ProjectID:    proj.ID,
 					Checksum:     hr.Checksum(),
 					UploadedPath: uploadKey,
 				}
-				if err := db.Create(bun).Error; err != nil {
-					controllers.InternalServerError(c, err, "deployments: failed to create a raw bundle record in DB")
-					return
-				}
-
-				depl.RawBundleID = &bun.ID
 				break
 			}
 		}
 
 	case viaCachedBundle:
-		ver, err := proj.NextVersion(db)
-		if err != nil {
-			controllers.InternalServerError(c, err, "deployments: failed to get next deployment version number")
-			return
-		}
-
-		depl.Version = ver
-		if err := db.Create(depl).Error; err != nil {
-			controllers.InternalServerError(c, err, "deployments: failed to create a deployment record in DB")
-			return
-		}
-
 		checksum := c.PostForm("bundle_checksum")
 		if checksum == "" {
 			c.JSON(422, gin.H{
@@ -203,8 +372,8 @@ func Create(c *gin.Context) {
 			return
 		}
 
-		bun := &rawbundle.RawBundle{}
-		if err := db.Where("checksum = ? AND project_id = ?", checksum, proj.ID).First(bun).Error; err != nil {
+		cachedBun := &rawbundle.RawBundle{}
+		if err := db.Where("checksum = ? AND project_id = ?", checksum, proj.ID).First(cachedBun).Error; err != nil {
 			if err == gorm.RecordNotFound {
 				c.JSON(422, gin.H{
 					"error": "invalid_params",
@@ -217,10 +386,18 @@ func Create(c *gin.Context) {
 			controllers.InternalServerError(c, err, "deployments: failed to find a raw bundle")
 			return
 		}
-		depl.RawBundleID = &bun.ID
+		depl.RawBundleID = &cachedBun.ID
 
-		// Currently bundle from CLI is always tar.gz
+		// Bundles are stored with the extension of their format, as zip
+		// bundles can be uploaded too.
 		archiveFormat = "tar.gz"
+		if strings.HasSuffix(cachedBun.UploadedPath, ".zip") {
+			archiveFormat = "zip"
+		}
+
+		if !createDeployment(c, proj, depl) {
+			return
+		}
 
 	case viaTemplate:
 		templateID, err := strconv.ParseInt(c.PostForm("template_id"), 10, 64)
@@ -259,16 +436,8 @@ func Create(c *gin.Context) {
 			return
 		}
 
-		ver, err := proj.NextVersion(db)
-		if err != nil {
-			controllers.InternalServerError(c, err, "deployments: failed to get next deployment version number")
-			return
-		}
-
 		depl.TemplateID = &tmpl.ID
-		depl.Version = ver
-		if err := db.Create(depl).Error; err != nil {
-			controllers.InternalServerError(c, err, "deployments: failed to create a  deployment record in DB")
+		if !createDeployment(c, proj, depl) {
 			return
 		}
 
@@ -278,16 +447,10 @@ func Create(c *gin.Context) {
 			return
 		}
 
-		bun := &rawbundle.RawBundle{
+		bun = &rawbundle.RawBundle{
 			ProjectID:    proj.ID,
 			UploadedPath: bundlePath,
 		}
-		if err := db.Create(bun).Error; err != nil {
-			controllers.InternalServerError(c, err, "deployments: failed to create a raw bundle record in DB")
-			return
-		}
-
-		depl.RawBundleID = &bun.ID
 
 	default:
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -297,13 +460,118 @@ func Create(c *gin.Context) {
 		return
 	}
 
-	if err := depl.UpdateState(db, deployment.StateUploaded); err != nil {
-		controllers.InternalServerError(c, err, "deployments: failed to update deployment state to be uploaded")
+	tx, err := dbconn.Begin(controllers.Context(c))
+	if err != nil {
+		controllers.InternalServerError(c, err, "deployments: failed to begin a transaction")
 		return
 	}
+	defer tx.Rollback()
+
+	if bun != nil {
+		if err := tx.Create(bun).Error; err != nil {
+			controllers.InternalServerError(c, err, "deployments: failed to create a raw bundle record in DB")
+			return
+		}
+		depl.RawBundleID = &bun.ID
+	}
 
-	var j *job.Job
-	if proj.SkipBuild {
+	if err := depl.RecordDuration(tx, deployment.PhaseUpload, time.Since(start)); err != nil {
+		controllers.InternalServerError(c, err, "deployments: failed to record upload duration")
+		return
+	}
+
+	keep = startDeployment(c, db, tx, u, proj, depl, archiveFormat)
+}
+
+// createDeployment gives depl the next version of the project and creates it,
+// in a transaction of its own, so that the project is only locked for as long
+// as that takes and not while the bundle of depl is uploaded. It responds with
+// an error and returns false if the deployment could not be created.
+func createDeployment(c *gin.Context, proj *project.Project, depl *deployment.Deployment) bool {
+	tx, err := dbconn.Begin(controllers.Context(c))
+	if err != nil {
+		controllers.InternalServerError(c, err, "deployments: failed to begin a transaction")
+		return false
+	}
+	defer tx.Rollback()
+
+	ver, err := proj.NextVersion(tx)
+	if err != nil {
+		controllers.InternalServerError(c, err, "deployments: failed to get next deployment version number")
+		return false
+	}
+
+	depl.Version = ver
+	if err := tx.Create(depl).Error; err != nil {
+		controllers.InternalServerError(c, err, "deployments: failed to create a deployment record in DB")
+		return false
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		controllers.InternalServerError(c, err, "deployments: failed to commit a transaction")
+		return false
+	}
+	return true
+}
+
+// discardDeployment deletes a deployment created by Create that was never
+// started.
+func discardDeployment(db *gorm.DB, depl *deployment.Deployment) {
+	if err := db.Unscoped().Delete(depl).Error; err != nil {
+		log.Errorf("failed to delete deployment %d that was not started, err: %v", depl.ID, err)
+	}
+}
+
+// makePreview makes depl a preview deployment. Previews are given a longer
+// prefix than other deployments, as their preview domains are derived from it
+// and should not be guessable.
+func makePreview(depl *deployment.Deployment) error {
+	prefix, err := deployment.NewPrefix()
+	if err != nil {
+		return err
+	}
+
+	depl.Preview = true
+	depl.Prefix = prefix
+	return nil
+}
+
+// protectPreview generates the basic auth credentials of the preview domain
+// of depl. It responds with an error and returns false if depl is not a
+// preview or the credentials could not be generated.
+func protectPreview(c *gin.Context, depl *deployment.Deployment) bool {
+	if !depl.Preview {
+		c.JSON(422, gin.H{
+			"error": "invalid_params",
+			"errors": map[string]string{
+				"preview_basic_auth": "is only allowed for preview deployments",
+			},
+		})
+		return false
+	}
+
+	if err := depl.GeneratePreviewBasicAuth(); err != nil {
+		controllers.InternalServerError(c, err, "deployments: failed to generate preview basic auth credentials")
+		return false
+	}
+	return true
+}
+
+// startDeployment marks a deployment whose raw bundle has been uploaded as
+// uploaded, and enqueues the job that builds or deploys it, or queues it if
+// another deployment of the project is in flight. The transaction is committed
+// before responding. It returns whether the deployment was started.
+func startDeployment(c *gin.Context, db, tx *gorm.DB, u *user.User, proj *project.Project, depl *deployment.Deployment, archiveFormat string) bool {
+	if err := depl.UpdateState(tx, deployment.StateUploaded); err != nil {
+		controllers.InternalServerError(c, err, "deployments: failed to update deployment state to be uploaded")
+		return false
+	}
+
+	var (
+		j   *job.Job
+		err error
+	)
+	if !proj.NeedsBuild() {
 		j, err = job.NewWithJSON(queues.Deploy, &messages.DeployJobData{
 			DeploymentID:  depl.ID,
 			UseRawBundle:  true,
@@ -317,23 +585,73 @@ func Create(c *gin.Context) {
 	}
 
 	if err != nil {
-		controllers.InternalServerError(c, err, "deployments: failed to connect to job queue")
-		return
+		controllers.InternalServerError(c, err, "deployments: failed to create a job")
+		return false
 	}
 
-	if err := j.Enqueue(); err != nil {
-		controllers.InternalServerError(c, err, "deployments: failed to enqueue a job")
-		return
+	// Only one deployment of a project is built or deployed at a time, so
+	// that deployments do not fight over the active deployment. The project
+	// is locked until the transaction is committed, so that concurrent
+	// deployments see each other.
+	if err := tx.Exec("SELECT id FROM projects WHERE id = ? FOR UPDATE", proj.ID).Error; err != nil {
+		controllers.InternalServerError(c, err, "deployments: failed to lock the project")
+		return false
+	}
+
+	inFlight, err := deployment.InFlight(tx, proj.ID)
+	if err != nil {
+		controllers.InternalServerError(c, err, "deployments: failed to find a deployment in flight")
+		return false
+	}
+
+	queued := false
+	if proj.DeployConcurrency == project.DeployConcurrencyReject {
+		if inFlight != nil {
+			respondInFlight(c, inFlight)
+			return false
+		}
+	} else {
+		// Deployments that are already queued go first.
+		var queuedCount int
+		if err := tx.Model(deployment.Deployment{}).Where("project_id = ? AND state = ?", proj.ID, deployment.StateQueued).Count(&queuedCount).Error; err != nil {
+			controllers.InternalServerError(c, err, "deployments: failed to count queued deployments")
+			return false
+		}
+		queued = inFlight != nil || queuedCount > 0
+	}
+
+	var ob *outboxjob.OutboxJob
+	if queued {
+		ob, err = outboxjob.Hold(tx, j, depl.ID)
+	} else {
+		ob, err = outboxjob.Add(tx, j)
+	}
+	if err != nil {
+		controllers.InternalServerError(c, err, "deployments: failed to add a job to the outbox")
+		return false
 	}
 
 	newState := deployment.StatePendingBuild
-	if proj.SkipBuild {
+	if queued {
+		newState = deployment.StateQueued
+	} else if !proj.NeedsBuild() {
 		newState = deployment.StatePendingDeploy
 	}
 
-	if err := depl.UpdateState(db, newState); err != nil {
+	if err := depl.UpdateState(tx, newState); err != nil {
 		controllers.InternalServerError(c, err, "deployments: failed to update deployment state to be "+newState)
-		return
+		return false
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		controllers.InternalServerError(c, err, "deployments: failed to commit a transaction")
+		return false
+	}
+
+	// Queued deployments are started by the release-queued-deployments
+	// task once the deployment in flight has finished.
+	if !queued {
+		outboxjob.DeliverAll(db, ob)
 	}
 
 	{
@@ -344,11 +662,9 @@ func Create(c *gin.Context) {
 				"deploymentId":      depl.ID,
 				"deploymentPrefix":  depl.Prefix,
 				"deploymentVersion": depl.Version,
+				"deploymentSource":  depl.Source,
 			}
-			context = map[string]interface{}{
-				"ip":         common.GetIP(c.Request),
-				"user_agent": c.Request.UserAgent(),
-			}
+			context = controllers.TrackingContext(c)
 		)
 		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
 			log.Errorf("failed to track %q event for user ID %d, err: %v",
@@ -359,6 +675,79 @@ func Create(c *gin.Context) {
 	c.JSON(http.StatusAccepted, gin.H{
 		"deployment": depl.AsJSON(),
 	})
+
+	return true
+}
+
+// failChecksumMismatch fails a deployment whose payload does not match the
+// checksum sent by the client, which means that it was corrupted on its way to
+// S3. The deployment is kept so that the failure shows up in its history, but
+// the corrupted bundle is discarded instead of being cached.
+func failChecksumMismatch(c *gin.Context, db *gorm.DB, depl *deployment.Deployment, uploadKey string) {
+	errMsg := "The uploaded bundle does not match its checksum, it may have been corrupted during upload. Please try deploying again."
+	errCode := deployment.ErrorCodeChecksumMismatch
+	depl.ErrorMessage = &errMsg
+	depl.ErrorCode = &errCode
+
+	if err := depl.UpdateState(db, deployment.StateDeployFailed); err != nil {
+		controllers.InternalServerError(c, err, "deployments: failed to update deployment state to be "+deployment.StateDeployFailed)
+		return
+	}
+
+	if err := s3client.Delete(uploadKey); err != nil {
+		log.Errorf("failed to delete corrupted bundle %q of deployment %d, err: %v", uploadKey, depl.ID, err)
+	}
+
+	c.JSON(422, gin.H{
+		"error":             deployment.ErrorCodeChecksumMismatch,
+		"code":              errcodes.ChecksumMismatch,
+		"error_description": "payload does not match payload_checksum",
+		"deployment":        depl.AsJSON(),
+	})
+}
+
+// respondInFlight responds with the deployment of the project that is being
+// built or deployed, when the project rejects concurrent deployments.
+func respondInFlight(c *gin.Context, inFlight *deployment.Deployment) {
+	c.JSON(http.StatusConflict, gin.H{
+		"error":             "conflict",
+		"code":              errcodes.DeploymentInProgress,
+		"error_description": "another deployment of this project is in progress",
+		"deployment_id":     inFlight.ID,
+	})
+}
+
+// uploadSizeLimit returns the maximum size of a bundle that can be uploaded
+// to deploy the project, which is the limit of the plan of its owner. u is the
+// current user, to avoid looking up the owner if it is the current user.
+func uploadSizeLimit(db *gorm.DB, proj *project.Project, u *user.User) (int64, error) {
+	owner := u
+	if proj.UserID != u.ID {
+		owner = &user.User{}
+		if err := db.First(owner, proj.UserID).Error; err != nil {
+			return 0, err
+		}
+	}
+	return owner.UploadSizeLimit(), nil
+}
+
+// respondTooLarge responds that a bundle is larger than the upload size limit
+// of the project.
+func respondTooLarge(c *gin.Context, maxSize int64) {
+	c.JSON(http.StatusForbidden, gin.H{
+		"error":             "forbidden",
+		"code":              errcodes.PayloadTooLarge,
+		"error_description": fmt.Sprintf("bundle is too large, the plan of the owner of this project allows bundles of up to %s", formatSize(maxSize)),
+		"max_size":          maxSize,
+	})
+}
+
+// formatSize formats a size in bytes for error messages.
+func formatSize(n int64) string {
+	if n < 1024*1024 {
+		return fmt.Sprintf("%d bytes", n)
+	}
+	return fmt.Sprintf("%.1f MiB", float64(n)/(1024*1024))
 }
 
 // Show displays information of a single deployment.
@@ -378,6 +767,19 @@ func Show(c *gin.Context) {
 		return
 	}
 
+	// With the state and wait params, the response is held until the state of
+	// the deployment is no longer the given state, for up to wait seconds.
+	knownState := c.Query("state")
+	wait := waitDuration(c.Query("wait"))
+
+	var changes <-chan *deployment.StateChange
+	if knownState != "" && wait > 0 {
+		// Subscribe before loading the deployment so that no change is missed.
+		ch, cancel := deploywatch.Default.Subscribe(uint(deploymentID))
+		defer cancel()
+		changes = ch
+	}
+
 	depl := &deployment.Deployment{}
 
 	if err := db.First(depl, deploymentID).Error; err != nil {
@@ -392,8 +794,26 @@ func Show(c *gin.Context) {
 		return
 	}
 
+	if changes != nil && depl.State == knownState {
+		if depl, err = waitForChange(c, db, depl, changes, wait); err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+	}
+
+	deplJSON := depl.AsJSON()
+
+	qs, err := depl.QueueStatus(db)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+	if qs != nil {
+		deplJSON.Queue = qs.AsJSON()
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"deployment": depl.AsJSON(),
+		"deployment": deplJSON,
 	})
 }
 
@@ -476,6 +896,138 @@ func Download(c *gin.Context) {
 	})
 }
 
+// ShowFile returns a pre-signed URL to a single file in the webroot of a
+// deployment, so that users can inspect what a version served without
+// downloading its whole bundle.
+func ShowFile(c *gin.Context) {
+	deploymentID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "deployment could not be found",
+		})
+		return
+	}
+
+	proj := controllers.CurrentProject(c)
+
+	// Directory paths are served by their index document, as on the edges.
+	filePath := path.Clean("/" + c.Param("path"))
+	if strings.HasSuffix(c.Param("path"), "/") || filePath == "/" {
+		filePath = path.Join(filePath, proj.IndexDocument)
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	depl := &deployment.Deployment{}
+	if err := db.Where("project_id = ?", proj.ID).First(depl, deploymentID).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":             "not_found",
+				"error_description": "deployment could not be found",
+			})
+			return
+		}
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	// Only deployments that have been deployed have a webroot.
+	if depl.DeployedAt == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "deployment has not been deployed",
+		})
+		return
+	}
+
+	key := "deployments/" + depl.PrefixID() + "/webroot" + filePath
+
+	exists, err := s3client.Exists(key)
+	if err != nil {
+		log.Warnf("failed to check existence of %q on S3, err: %v", key, err)
+		controllers.InternalServerError(c, err)
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "file could not be found",
+		})
+		return
+	}
+
+	url, err := s3client.PresignedURL(key, presignExpiryDuration)
+	if err != nil {
+		log.Printf("error generating presigned URL to %q, err: %v", key, err)
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"url": url,
+	})
+}
+
+// ShowReport returns a breakdown of the size of the files of a deployment by
+// extension, and its largest files.
+func ShowReport(c *gin.Context) {
+	deploymentID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "deployment could not be found",
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	proj := controllers.CurrentProject(c)
+
+	depl := &deployment.Deployment{}
+	if err := db.Where("project_id = ?", proj.ID).First(depl, deploymentID).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":             "not_found",
+				"error_description": "deployment could not be found",
+			})
+			return
+		}
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	report, err := depl.ReportData()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	// Reports are stored when a deployment's files are uploaded, so
+	// deployments that have not been deployed, or that were deployed before
+	// reports were introduced, do not have one.
+	if report == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "report could not be found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"report": report,
+	})
+}
+
 // Rollback either rolls back a project to the previous deployment, or to a
 // given version.
 func Rollback(c *gin.Context) {
@@ -484,6 +1036,7 @@ func Rollback(c *gin.Context) {
 	if proj.ActiveDeploymentID == nil {
 		c.JSON(http.StatusPreconditionFailed, gin.H{
 			"error":             "precondition_failed",
+			"code":              errcodes.NoActiveDeployment,
 			"error_description": "active deployment could not be found",
 		})
 		return
@@ -527,7 +1080,7 @@ func Rollback(c *gin.Context) {
 			return
 		}
 
-		if err := db.Where("project_id = ? AND state = ? AND version = ?", proj.ID, deployment.StateDeployed, version).First(depl).Error; err != nil {
+		if err := db.Where("project_id = ? AND state = ? AND version = ? AND preview = ?", proj.ID, deployment.StateDeployed, version, false).First(depl).Error; err != nil {
 			if err == gorm.RecordNotFound {
 				c.JSON(422, gin.H{
 					"error":             "invalid_request",
@@ -559,16 +1112,31 @@ func Rollback(c *gin.Context) {
 		return
 	}
 
-	if err := j.Enqueue(); err != nil {
+	tx, err := dbconn.Begin(controllers.Context(c))
+	if err != nil {
 		controllers.InternalServerError(c, err)
 		return
 	}
+	defer tx.Rollback()
 
-	if err := depl.UpdateState(db, deployment.StatePendingRollback); err != nil {
+	ob, err := outboxjob.Add(tx, j)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if err := depl.UpdateState(tx, deployment.StatePendingRollback); err != nil {
 		controllers.InternalServerError(c, err)
 		return
 	}
 
+	if err := tx.Commit().Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	outboxjob.DeliverAll(db, ob)
+
 	{
 		u := controllers.CurrentUser(c)
 
@@ -579,10 +1147,86 @@ func Rollback(c *gin.Context) {
 				"deployedVersion": currentDepl.Version,
 				"targetVersion":   depl.Version,
 			}
-			context = map[string]interface{}{
-				"ip":         common.GetIP(c.Request),
-				"user_agent": c.Request.UserAgent(),
+			context = controllers.TrackingContext(c)
+		)
+		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
+			log.Errorf("failed to track %q event for user ID %d, err: %v",
+				event, u.ID, err)
+		}
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"deployment": depl.AsJSON(),
+	})
+}
+
+// RegeneratePrefix moves the files of the active deployment to a new random
+// prefix and republishes it, for when the URL of the old prefix has leaked.
+// The files can no longer be accessed at the old prefix once the deploy job
+// has finished.
+func RegeneratePrefix(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	if proj.ActiveDeploymentID == nil {
+		c.JSON(http.StatusPreconditionFailed, gin.H{
+			"error":             "precondition_failed",
+			"code":              errcodes.NoActiveDeployment,
+			"error_description": "active deployment could not be found",
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	depl := &deployment.Deployment{}
+	if err := db.First(depl, *proj.ActiveDeploymentID).Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	prefix, err := deployment.NewPrefix()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	j, err := job.NewWithJSON(queues.Deploy, &messages.DeployJobData{
+		DeploymentID:      depl.ID,
+		SkipWebrootUpload: true,
+		PrefixRotation: &messages.PrefixRotation{
+			From: depl.Prefix,
+			To:   prefix,
+		},
+	})
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	ob, err := outboxjob.Add(db, j)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	outboxjob.DeliverAll(db, ob)
+
+	{
+		u := controllers.CurrentUser(c)
+
+		var (
+			event = "Regenerated Deployment Prefix"
+			props = map[string]interface{}{
+				"projectName":       proj.Name,
+				"deploymentId":      depl.ID,
+				"deploymentVersion": depl.Version,
+				"deploymentSource":  depl.Source,
 			}
+			context = controllers.TrackingContext(c)
 		)
 		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
 			log.Errorf("failed to track %q event for user ID %d, err: %v",
@@ -595,7 +1239,8 @@ func Rollback(c *gin.Context) {
 	})
 }
 
-// Index lists all deployments of a project.
+// Index lists the completed deployments of a project, with pinned
+// deployments listed separately.
 func Index(c *gin.Context) {
 	proj := controllers.CurrentProject(c)
 
@@ -611,14 +1256,29 @@ func Index(c *gin.Context) {
 		return
 	}
 
-	var deplsToJSON []interface{}
-	for _, depl := range depls {
-		deplJSON := depl.AsJSON()
-		deplJSON.Active = depl.ID == *proj.ActiveDeploymentID
-		deplsToJSON = append(deplsToJSON, deplJSON)
+	pinned, err := deployment.PinnedDeployments(db, proj.ID)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"deployments": deplsToJSON,
-	})
+	asJSON := func(depls []*deployment.Deployment) []interface{} {
+		var deplsToJSON []interface{}
+		for _, depl := range depls {
+			deplJSON := depl.AsJSON()
+			deplJSON.Active = depl.ID == *proj.ActiveDeploymentID
+			deplsToJSON = append(deplsToJSON, deplJSON)
+		}
+		return deplsToJSON
+	}
+
+	// Pinned deployments are listed separately, as they are kept regardless
+	// of max_deploys_kept.
+	resp := gin.H{
+		"deployments": asJSON(depls),
+	}
+	if len(pinned) > 0 {
+		resp["pinned_deployments"] = asJSON(pinned)
+	}
+	c.JSON(http.StatusOK, resp)
 }
@@ -2,7 +2,10 @@ package deployments_test
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -12,15 +15,18 @@ import (
 	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/jinzhu/gorm"
 	"github.com/nitrous-io/rise-server/apiserver/common"
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/deploywatch"
 	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
 	"github.com/nitrous-io/rise-server/apiserver/models/domain"
 	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/outboxjob"
 	"github.com/nitrous-io/rise-server/apiserver/models/project"
 	"github.com/nitrous-io/rise-server/apiserver/models/rawbundle"
 	"github.com/nitrous-io/rise-server/apiserver/models/template"
@@ -29,6 +35,8 @@ import (
 	"github.com/nitrous-io/rise-server/pkg/filetransfer"
 	"github.com/nitrous-io/rise-server/pkg/mqconn"
 	"github.com/nitrous-io/rise-server/pkg/tracker"
+	"github.com/nitrous-io/rise-server/shared"
+	"github.com/nitrous-io/rise-server/shared/messages"
 	"github.com/nitrous-io/rise-server/shared/queues"
 	"github.com/nitrous-io/rise-server/shared/s3client"
 	"github.com/nitrous-io/rise-server/testhelper"
@@ -37,7 +45,6 @@ import (
 	"github.com/nitrous-io/rise-server/testhelper/sharedexamples"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
-	"github.com/streadway/amqp"
 )
 
 func Test(t *testing.T) {
@@ -48,7 +55,7 @@ func Test(t *testing.T) {
 var _ = Describe("Deployments", func() {
 	var (
 		db *gorm.DB
-		mq *amqp.Connection
+		mq mqconn.Conn
 
 		s   *httptest.Server
 		res *http.Response
@@ -125,12 +132,18 @@ var _ = Describe("Deployments", func() {
 			s3client.S3 = origS3
 		})
 
-		doRequestWithMultipart := func(partName, filename string) {
+		doRequestWithMultipartFields := func(fields url.Values, partName, filename string) {
 			s = httptest.NewServer(server.New())
 
 			body := &bytes.Buffer{}
 			writer := multipart.NewWriter(body)
 
+			for k, v := range fields {
+				for _, fv := range v {
+					Expect(writer.WriteField(k, fv)).To(BeNil())
+				}
+			}
+
 			f, err := os.Open(filename)
 			Expect(err).To(BeNil())
 
@@ -158,6 +171,10 @@ var _ = Describe("Deployments", func() {
 			Expect(err).To(BeNil())
 		}
 
+		doRequestWithMultipart := func(partName, filename string) {
+			doRequestWithMultipartFields(nil, partName, filename)
+		}
+
 		doRequestWithForm := func(params url.Values) {
 			s = httptest.NewServer(server.New())
 			res, err = testhelper.MakeRequest("POST", s.URL+"/projects/foo-bar-express/deployments", params, headers, nil)
@@ -215,6 +232,17 @@ var _ = Describe("Deployments", func() {
 			Expect(db.Last(depl).Error).To(Equal(gorm.RecordNotFound))
 		})
 
+		sharedexamples.ItRequiresActiveUser(func() (*gorm.DB, *user.User) {
+			return db, u
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, func() {
+			Expect(fakeS3.UploadCalls.Count()).To(Equal(0))
+			depl := &deployment.Deployment{}
+			Expect(db.Last(depl).Error).To(Equal(gorm.RecordNotFound))
+		})
+
 		Context("when the project belongs to current user", func() {
 			Context("when the request does not contain payload part", func() {
 				It("returns 422 with invalid_params", func() {
@@ -250,14 +278,16 @@ var _ = Describe("Deployments", func() {
 					s3client.MaxUploadSize = origMaxUploadSize
 				})
 
-				It("returns 400 with invalid_request", func() {
+				It("returns 403 with the limit", func() {
 					b := &bytes.Buffer{}
 					_, err = b.ReadFrom(res.Body)
 
-					Expect(res.StatusCode).To(Equal(http.StatusBadRequest))
+					Expect(res.StatusCode).To(Equal(http.StatusForbidden))
 					Expect(b.String()).To(MatchJSON(`{
-						"error": "invalid_request",
-						"error_description": "request body is too large"
+						"error": "forbidden",
+						"code": "payload_too_large",
+						"error_description": "bundle is too large, the plan of the owner of this project allows bundles of up to 10 bytes",
+						"max_size": 10
 					}`))
 					Expect(fakeS3.UploadCalls.Count()).To(Equal(0))
 
@@ -266,6 +296,32 @@ var _ = Describe("Deployments", func() {
 				})
 			})
 
+			Context("when the payload is larger than the limit of the plan of the owner", func() {
+				BeforeEach(func() {
+					owner := factories.User(db)
+					Expect(db.Model(owner).Update("max_upload_size", 100).Error).To(BeNil())
+
+					Expect(db.Model(proj).Update("user_id", owner.ID).Error).To(BeNil())
+					factories.Collab(db, proj, u)
+
+					doRequest()
+				})
+
+				It("returns 403 with the limit of the owner", func() {
+					b := &bytes.Buffer{}
+					_, err = b.ReadFrom(res.Body)
+
+					Expect(res.StatusCode).To(Equal(http.StatusForbidden))
+					Expect(b.String()).To(MatchJSON(`{
+						"error": "forbidden",
+						"code": "payload_too_large",
+						"error_description": "bundle is too large, the plan of the owner of this project allows bundles of up to 100 bytes",
+						"max_size": 100
+					}`))
+					Expect(fakeS3.UploadCalls.Count()).To(Equal(0))
+				})
+			})
+
 			Context("when the payload is smaller than 512 bytes", func() {
 				It("uploads without error", func() {
 					doRequestWithSmallWebsite()
@@ -305,6 +361,7 @@ var _ = Describe("Deployments", func() {
 							"id":      depl.ID,
 							"state":   deployment.StatePendingBuild,
 							"version": 1,
+							"source":  deployment.SourceAPI,
 						},
 					}
 					expectedJSON, err := json.Marshal(j)
@@ -332,6 +389,24 @@ var _ = Describe("Deployments", func() {
 					Expect(call.SideEffects["uploaded_content"]).To(Equal(b))
 				})
 
+				It("copies the deployment defaults of the project to the deployment", func() {
+					defaults := []byte(`{"precompress":true,"cache_policy":"no_cache","verification_urls":["/"]}`)
+					Expect(db.Model(proj).Update("deployment_defaults", defaults).Error).To(BeNil())
+
+					doRequest()
+
+					depl = &deployment.Deployment{}
+					Expect(db.Last(depl).Error).To(BeNil())
+
+					settings, err := depl.SettingsData()
+					Expect(err).To(BeNil())
+					Expect(settings).To(Equal(&deployment.Settings{
+						Precompress:      true,
+						CachePolicy:      deployment.CachePolicyNoCache,
+						VerificationURLs: []string{"/"},
+					}))
+				})
+
 				It("creates a deployment record", func() {
 					doRequest()
 
@@ -353,6 +428,17 @@ var _ = Describe("Deployments", func() {
 					Expect(depl.JsEnvVars).To(Equal([]byte("{}")))
 				})
 
+				It("records how long the upload took", func() {
+					doRequest()
+
+					depl = &deployment.Deployment{}
+					db.Last(depl)
+
+					Expect(depl.UploadDurationMs).NotTo(BeNil())
+					Expect(*depl.UploadDurationMs).To(BeNumerically(">=", 0))
+					Expect(depl.BuildDurationMs).To(BeNil())
+				})
+
 				It("creates a bundle record", func() {
 					doRequest()
 
@@ -403,6 +489,37 @@ var _ = Describe("Deployments", func() {
 					`, depl.ID)))
 				})
 
+				It("marks the job in the outbox as enqueued", func() {
+					doRequest()
+
+					var jobs []*outboxjob.OutboxJob
+					Expect(db.Find(&jobs).Error).To(BeNil())
+					Expect(jobs).To(HaveLen(1))
+					Expect(jobs[0].QueueName).To(Equal(queues.Build))
+					Expect(jobs[0].EnqueuedAt).NotTo(BeNil())
+				})
+
+				Context("when the upload to S3 fails", func() {
+					BeforeEach(func() {
+						fakeS3.UploadError = errors.New("s3 is down")
+					})
+
+					It("does not leave a deployment or a job behind", func() {
+						doRequest()
+						Expect(res.StatusCode).To(Equal(http.StatusInternalServerError))
+
+						var count int
+						Expect(db.Model(deployment.Deployment{}).Where("project_id = ?", proj.ID).Count(&count).Error).To(BeNil())
+						Expect(count).To(Equal(0))
+
+						Expect(db.Model(outboxjob.OutboxJob{}).Count(&count).Error).To(BeNil())
+						Expect(count).To(Equal(0))
+
+						d := testhelper.ConsumeQueue(mq, queues.Build)
+						Expect(d).To(BeNil())
+					})
+				})
+
 				It("tracks an 'Initiated Project Deployment' event", func() {
 					doRequest()
 
@@ -422,6 +539,7 @@ var _ = Describe("Deployments", func() {
 					Expect(props["deploymentId"]).To(Equal(depl.ID))
 					Expect(props["deploymentPrefix"]).To(Equal(depl.Prefix))
 					Expect(props["deploymentVersion"]).To(Equal(depl.Version))
+					Expect(props["deploymentSource"]).To(Equal(deployment.SourceAPI))
 
 					c := trackCall.Arguments[4]
 					context, ok := c.(map[string]interface{})
@@ -453,6 +571,7 @@ var _ = Describe("Deployments", func() {
 								"id":      depl.ID,
 								"state":   deployment.StatePendingBuild,
 								"version": 2,
+								"source":  deployment.SourceAPI,
 							},
 						}
 						expectedJSON, err := json.Marshal(j)
@@ -479,6 +598,195 @@ var _ = Describe("Deployments", func() {
 					`, depl.ID)))
 				})
 
+				Context("when root_dir is specified", func() {
+					It("creates a deployment with the cleaned root directory", func() {
+						doRequestWithMultipartFields(url.Values{"root_dir": {"./apps/www/"}}, "payload", "../../../testhelper/fixtures/website.tar.gz")
+
+						depl := &deployment.Deployment{}
+						Expect(db.Last(depl).Error).To(BeNil())
+						Expect(depl.RootDir).To(Equal("apps/www"))
+
+						b := &bytes.Buffer{}
+						_, err = b.ReadFrom(res.Body)
+						Expect(err).To(BeNil())
+
+						Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+						Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+							"deployment": {
+								"id": %d,
+								"state": "pending_build",
+								"version": 1,
+								"source": "api",
+								"root_dir": "apps/www"
+							}
+						}`, depl.ID)))
+					})
+
+					Context("when root_dir is outside of the bundle", func() {
+						It("returns 422 with invalid_params without deploying anything", func() {
+							doRequestWithMultipartFields(url.Values{"root_dir": {"../../etc"}}, "payload", "../../../testhelper/fixtures/website.tar.gz")
+
+							b := &bytes.Buffer{}
+							_, err = b.ReadFrom(res.Body)
+							Expect(err).To(BeNil())
+
+							Expect(res.StatusCode).To(Equal(422))
+							Expect(b.String()).To(MatchJSON(`{
+								"error": "invalid_params",
+								"errors": {
+									"root_dir": "is invalid"
+								}
+							}`))
+
+							Expect(fakeS3.UploadCalls.Count()).To(Equal(0))
+							depl := &deployment.Deployment{}
+							Expect(db.Last(depl).Error).To(Equal(gorm.RecordNotFound))
+						})
+					})
+				})
+
+				Context("when VCS metadata is specified", func() {
+					It("creates a deployment with the VCS metadata", func() {
+						doRequestWithMultipartFields(url.Values{
+							"commit_sha":     {"5E908DC1F01E7A6C3B2D1E0F9A8B7C6D5E4F3A2B"},
+							"commit_message": {"  Fix typo in README\n"},
+							"branch":         {"refs/heads/master"},
+						}, "payload", "../../../testhelper/fixtures/website.tar.gz")
+
+						depl := &deployment.Deployment{}
+						Expect(db.Last(depl).Error).To(BeNil())
+						Expect(depl.CommitSHA).To(Equal("5e908dc1f01e7a6c3b2d1e0f9a8b7c6d5e4f3a2b"))
+						Expect(depl.CommitMessage).To(Equal("Fix typo in README"))
+						Expect(depl.Branch).To(Equal("master"))
+
+						b := &bytes.Buffer{}
+						_, err = b.ReadFrom(res.Body)
+						Expect(err).To(BeNil())
+
+						Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+						Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+							"deployment": {
+								"id": %d,
+								"state": "pending_build",
+								"version": 1,
+								"source": "api",
+								"commit_sha": "5e908dc1f01e7a6c3b2d1e0f9a8b7c6d5e4f3a2b",
+								"commit_message": "Fix typo in README",
+								"branch": "master"
+							}
+						}`, depl.ID)))
+					})
+
+					Context("when commit_sha is not a commit SHA", func() {
+						It("returns 422 with invalid_params without deploying anything", func() {
+							doRequestWithMultipartFields(url.Values{
+								"commit_sha": {"HEAD~1"},
+							}, "payload", "../../../testhelper/fixtures/website.tar.gz")
+
+							b := &bytes.Buffer{}
+							_, err = b.ReadFrom(res.Body)
+							Expect(err).To(BeNil())
+
+							Expect(res.StatusCode).To(Equal(422))
+							Expect(b.String()).To(MatchJSON(`{
+								"error": "invalid_params",
+								"errors": {
+									"commit_sha": "is invalid"
+								}
+							}`))
+
+							Expect(fakeS3.UploadCalls.Count()).To(Equal(0))
+							depl := &deployment.Deployment{}
+							Expect(db.Last(depl).Error).To(Equal(gorm.RecordNotFound))
+						})
+					})
+				})
+
+				Context("when payload_checksum is specified", func() {
+					Context("when the payload matches the checksum", func() {
+						It("creates a deployment", func() {
+							doRequestWithMultipartFields(url.Values{
+								"payload_checksum": {"D177DE8D751C4BC0CAD763ED53523BC10A88D0EF0C8B8814A9170D69CCC76945"},
+							}, "payload", "../../../testhelper/fixtures/website.tar.gz")
+
+							Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+
+							depl := &deployment.Deployment{}
+							Expect(db.Last(depl).Error).To(BeNil())
+							Expect(depl.State).To(Equal(deployment.StatePendingBuild))
+							Expect(fakeS3.DeleteCalls.Count()).To(Equal(0))
+						})
+					})
+
+					Context("when the payload does not match the checksum", func() {
+						It("fails the deployment with checksum_mismatch and discards the bundle", func() {
+							doRequestWithMultipartFields(url.Values{
+								"payload_checksum": {"db39e098913eee20e5371139022e4431ffe7b01baa524bd87e08f2763de3ea55"},
+							}, "payload", "../../../testhelper/fixtures/website.tar.gz")
+
+							depl := &deployment.Deployment{}
+							Expect(db.Last(depl).Error).To(BeNil())
+							Expect(depl.State).To(Equal(deployment.StateDeployFailed))
+							Expect(depl.ErrorCode).NotTo(BeNil())
+							Expect(*depl.ErrorCode).To(Equal(deployment.ErrorCodeChecksumMismatch))
+							Expect(depl.RawBundleID).To(BeNil())
+
+							b := &bytes.Buffer{}
+							_, err = b.ReadFrom(res.Body)
+							Expect(err).To(BeNil())
+
+							Expect(res.StatusCode).To(Equal(422))
+							Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+								"error": "checksum_mismatch",
+								"code": "checksum_mismatch",
+								"error_description": "payload does not match payload_checksum",
+								"deployment": {
+									"id": %d,
+									"state": "deploy_failed",
+									"version": 1,
+									"source": "api",
+									"error_message": %q,
+									"error_code": "checksum_mismatch"
+								}
+							}`, depl.ID, *depl.ErrorMessage)))
+
+							var count int
+							Expect(db.Model(rawbundle.RawBundle{}).Count(&count).Error).To(BeNil())
+							Expect(count).To(Equal(0))
+
+							Expect(fakeS3.DeleteCalls.Count()).To(Equal(1))
+							call := fakeS3.DeleteCalls.NthCall(1)
+							Expect(call.Arguments[2]).To(Equal(fmt.Sprintf("deployments/%s-%d/raw-bundle.tar.gz", depl.Prefix, depl.ID)))
+
+							Expect(testhelper.ConsumeQueue(mq, queues.Build)).To(BeNil())
+						})
+					})
+
+					Context("when payload_checksum is not a SHA-256 digest", func() {
+						It("returns 422 with invalid_params without deploying anything", func() {
+							doRequestWithMultipartFields(url.Values{
+								"payload_checksum": {"not-a-checksum"},
+							}, "payload", "../../../testhelper/fixtures/website.tar.gz")
+
+							b := &bytes.Buffer{}
+							_, err = b.ReadFrom(res.Body)
+							Expect(err).To(BeNil())
+
+							Expect(res.StatusCode).To(Equal(422))
+							Expect(b.String()).To(MatchJSON(`{
+								"error": "invalid_params",
+								"errors": {
+									"payload_checksum": "is invalid"
+								}
+							}`))
+
+							Expect(fakeS3.UploadCalls.Count()).To(Equal(0))
+							depl := &deployment.Deployment{}
+							Expect(db.Last(depl).Error).To(Equal(gorm.RecordNotFound))
+						})
+					})
+				})
+
 				Context("when skip_build is true", func() {
 					BeforeEach(func() {
 						proj.SkipBuild = true
@@ -511,6 +819,61 @@ var _ = Describe("Deployments", func() {
 						Expect(depl.State).To(Equal(deployment.StatePendingDeploy))
 					})
 				})
+
+				Context("when another deployment is in flight", func() {
+					var inFlight *deployment.Deployment
+
+					BeforeEach(func() {
+						inFlight = factories.Deployment(db, proj, u, deployment.StatePendingBuild)
+					})
+
+					It("queues the deployment and holds its job", func() {
+						doRequest()
+						Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+
+						depl = &deployment.Deployment{}
+						Expect(db.Last(depl).Error).To(BeNil())
+						Expect(depl.State).To(Equal(deployment.StateQueued))
+
+						var jobs []*outboxjob.OutboxJob
+						Expect(db.Find(&jobs).Error).To(BeNil())
+						Expect(jobs).To(HaveLen(1))
+						Expect(jobs[0].HeldDeploymentID).NotTo(BeNil())
+						Expect(*jobs[0].HeldDeploymentID).To(Equal(depl.ID))
+						Expect(jobs[0].EnqueuedAt).To(BeNil())
+
+						d := testhelper.ConsumeQueue(mq, queues.Build)
+						Expect(d).To(BeNil())
+					})
+
+					Context("when the project rejects concurrent deployments", func() {
+						BeforeEach(func() {
+							Expect(db.Model(proj).Update("deploy_concurrency", project.DeployConcurrencyReject).Error).To(BeNil())
+						})
+
+						It("returns 409 with the deployment in flight", func() {
+							doRequest()
+
+							b := &bytes.Buffer{}
+							_, err = b.ReadFrom(res.Body)
+							Expect(err).To(BeNil())
+
+							Expect(res.StatusCode).To(Equal(http.StatusConflict))
+							Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+								"error": "conflict",
+								"code": "deployment_in_progress",
+								"error_description": "another deployment of this project is in progress",
+								"deployment_id": %d
+							}`, inFlight.ID)))
+
+							var count int
+							Expect(db.Model(deployment.Deployment{}).Where("project_id = ?", proj.ID).Count(&count).Error).To(BeNil())
+							Expect(count).To(Equal(1))
+
+							Expect(fakeS3.UploadCalls.Count()).To(Equal(0))
+						})
+					})
+				})
 			})
 
 			Context("when bundle_checksum is specified", func() {
@@ -544,6 +907,7 @@ var _ = Describe("Deployments", func() {
 								"id":      depl.ID,
 								"state":   deployment.StatePendingBuild,
 								"version": 1,
+								"source":  deployment.SourceAPI,
 							},
 						}
 						expectedJSON, err := json.Marshal(j)
@@ -590,6 +954,178 @@ var _ = Describe("Deployments", func() {
 						`, depl.ID)))
 					})
 
+					Context("when the raw bundle is a zip archive", func() {
+						BeforeEach(func() {
+							Expect(db.Model(existingRawBundle).Update("uploaded_path", "deployments/pr3f1x-1234/raw-bundle.zip").Error).To(BeNil())
+						})
+
+						It("enqueues a build job for a zip archive", func() {
+							doRequestWithBundleChecksum(checksum)
+							depl = &deployment.Deployment{}
+							db.Last(depl)
+
+							m := testhelper.ConsumeQueue(mq, queues.Build)
+							Expect(m).NotTo(BeNil())
+							Expect(m.Body).To(MatchJSON(fmt.Sprintf(`
+								{
+									"deployment_id": %d,
+									"archive_format": "zip"
+								}
+							`, depl.ID)))
+						})
+					})
+
+					Context("when root_dir is specified", func() {
+						It("creates a deployment with the root directory", func() {
+							doRequestWithForm(url.Values{"bundle_checksum": {checksum}, "root_dir": {"site"}})
+
+							depl = &deployment.Deployment{}
+							Expect(db.Last(depl).Error).To(BeNil())
+							Expect(depl.RootDir).To(Equal("site"))
+							Expect(*depl.RawBundleID).To(Equal(existingRawBundle.ID))
+						})
+
+						Context("when root_dir is invalid", func() {
+							It("returns 422 with invalid_params", func() {
+								doRequestWithForm(url.Values{"bundle_checksum": {checksum}, "root_dir": {`site\..\..`}})
+
+								b := &bytes.Buffer{}
+								_, err = b.ReadFrom(res.Body)
+								Expect(err).To(BeNil())
+
+								Expect(res.StatusCode).To(Equal(422))
+								Expect(b.String()).To(MatchJSON(`{
+									"error": "invalid_params",
+									"errors": {
+										"root_dir": "is invalid"
+									}
+								}`))
+
+								depl = &deployment.Deployment{}
+								Expect(db.Last(depl).Error).To(Equal(gorm.RecordNotFound))
+							})
+						})
+					})
+
+					Context("when VCS metadata is specified", func() {
+						It("creates a deployment with the VCS metadata", func() {
+							doRequestWithForm(url.Values{
+								"bundle_checksum": {checksum},
+								"commit_sha":      {"5e908dc"},
+								"branch":          {"feature/login"},
+							})
+
+							depl = &deployment.Deployment{}
+							Expect(db.Last(depl).Error).To(BeNil())
+							Expect(depl.CommitSHA).To(Equal("5e908dc"))
+							Expect(depl.CommitMessage).To(Equal(""))
+							Expect(depl.Branch).To(Equal("feature/login"))
+						})
+
+						Context("when branch is too long", func() {
+							It("returns 422 with invalid_params", func() {
+								doRequestWithForm(url.Values{
+									"bundle_checksum": {checksum},
+									"branch":          {strings.Repeat("a", deployment.MaxBranchLength+1)},
+								})
+
+								b := &bytes.Buffer{}
+								_, err = b.ReadFrom(res.Body)
+								Expect(err).To(BeNil())
+
+								Expect(res.StatusCode).To(Equal(422))
+								Expect(b.String()).To(MatchJSON(`{
+									"error": "invalid_params",
+									"errors": {
+										"branch": "is too long (max. 255 characters)"
+									}
+								}`))
+
+								depl = &deployment.Deployment{}
+								Expect(db.Last(depl).Error).To(Equal(gorm.RecordNotFound))
+							})
+						})
+					})
+
+					Context("when preview is true", func() {
+						It("creates a preview deployment with a long prefix", func() {
+							doRequestWithForm(url.Values{"bundle_checksum": {checksum}, "preview": {"true"}})
+
+							depl = &deployment.Deployment{}
+							Expect(db.Last(depl).Error).To(BeNil())
+							Expect(depl.Preview).To(BeTrue())
+							Expect(depl.Prefix).To(HaveLen(16))
+
+							b := &bytes.Buffer{}
+							_, err = b.ReadFrom(res.Body)
+							Expect(err).To(BeNil())
+
+							Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+							Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+								"deployment": {
+									"id": %d,
+									"state": "pending_build",
+									"version": 1,
+									"source": "api",
+									"preview": true,
+									"preview_url": "https://%s-%d.preview.%s"
+								}
+							}`, depl.ID, depl.Prefix, depl.ID, shared.DefaultDomain)))
+						})
+
+						Context("when preview_basic_auth is true", func() {
+							It("generates basic auth credentials for the preview domain and returns them", func() {
+								doRequestWithForm(url.Values{"bundle_checksum": {checksum}, "preview": {"true"}, "preview_basic_auth": {"true"}})
+
+								b := &bytes.Buffer{}
+								_, err = b.ReadFrom(res.Body)
+								Expect(err).To(BeNil())
+								Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+
+								var j struct {
+									Deployment struct {
+										PreviewBasicAuth struct {
+											Username string `json:"username"`
+											Password string `json:"password"`
+										} `json:"preview_basic_auth"`
+									} `json:"deployment"`
+								}
+								Expect(json.Unmarshal(b.Bytes(), &j)).To(Succeed())
+								Expect(j.Deployment.PreviewBasicAuth.Username).To(Equal("preview"))
+								Expect(j.Deployment.PreviewBasicAuth.Password).To(HaveLen(24))
+
+								depl = &deployment.Deployment{}
+								Expect(db.Last(depl).Error).To(BeNil())
+								Expect(*depl.PreviewBasicAuthUsername).To(Equal("preview"))
+
+								hash := sha256.Sum256([]byte("preview:" + j.Deployment.PreviewBasicAuth.Password))
+								Expect(*depl.EncryptedPreviewBasicAuthPassword).To(Equal(hex.EncodeToString(hash[:])))
+							})
+						})
+					})
+
+					Context("when preview_basic_auth is true but preview is not", func() {
+						It("returns 422 unprocessable entity", func() {
+							doRequestWithForm(url.Values{"bundle_checksum": {checksum}, "preview_basic_auth": {"true"}})
+
+							b := &bytes.Buffer{}
+							_, err = b.ReadFrom(res.Body)
+							Expect(err).To(BeNil())
+
+							Expect(res.StatusCode).To(Equal(422))
+							Expect(b.String()).To(MatchJSON(`{
+								"error": "invalid_params",
+								"errors": {
+									"preview_basic_auth": "is only allowed for preview deployments"
+								}
+							}`))
+
+							var count int
+							Expect(db.Model(deployment.Deployment{}).Count(&count).Error).To(BeNil())
+							Expect(count).To(Equal(0))
+						})
+					})
+
 					Context("when the raw bundle is not associated with the project", func() {
 						BeforeEach(func() {
 							proj2 := factories.Project(db, u)
@@ -701,6 +1237,7 @@ var _ = Describe("Deployments", func() {
 								"id":      depl.ID,
 								"state":   deployment.StatePendingBuild,
 								"version": 1,
+								"source":  deployment.SourceAPI,
 							},
 						}
 						expectedJSON, err := json.Marshal(j)
@@ -776,6 +1313,26 @@ var _ = Describe("Deployments", func() {
 							}
 						`, depl.ID)))
 					})
+
+					Context("when the copy of the template fails", func() {
+						BeforeEach(func() {
+							fakeS3.CopyError = errors.New("s3 is down")
+						})
+
+						It("does not leave a deployment or a raw bundle behind", func() {
+							doRequestWithForm(url.Values{
+								"template_id": {strconv.Itoa(int(tmpl.ID))},
+							})
+							Expect(res.StatusCode).To(Equal(http.StatusInternalServerError))
+
+							var count int
+							Expect(db.Model(deployment.Deployment{}).Unscoped().Where("project_id = ?", proj.ID).Count(&count).Error).To(BeNil())
+							Expect(count).To(Equal(0))
+
+							Expect(db.Model(rawbundle.RawBundle{}).Where("project_id = ?", proj.ID).Count(&count).Error).To(BeNil())
+							Expect(count).To(Equal(0))
+						})
+					})
 				})
 
 				Context("when template_id is not a valid integer", func() {
@@ -923,27 +1480,130 @@ var _ = Describe("Deployments", func() {
 						"deployed_at":   d.DeployedAt,
 						"version":       d.Version,
 						"error_message": d.ErrorMessage,
+						"queue": map[string]interface{}{
+							"name":     queues.Deploy,
+							"position": 1,
+						},
 					},
 				}
 				expectedJSON, err := json.Marshal(j)
 				Expect(err).To(BeNil())
 				Expect(b.String()).To(MatchJSON(expectedJSON))
 			})
-		})
 
-		Context("the deployment does not exist", func() {
-			BeforeEach(func() {
-				Expect(db.Delete(depl).Error).To(BeNil())
-			})
+			Context("when other deployments are ahead of it in the queue", func() {
+				BeforeEach(func() {
+					deployDurationMs := int64(30000)
+					factories.DeploymentWithAttrs(db, nil, nil, deployment.Deployment{
+						State:            deployment.StateDeployed,
+						DeployDurationMs: &deployDurationMs,
+					})
 
-			It("returns 404 not found", func() {
-				doRequest()
-				b := &bytes.Buffer{}
-				_, err = b.ReadFrom(res.Body)
+					// The deployment created above is ahead of this one.
+					depl = factories.Deployment(db, proj, u, deployment.StatePendingDeploy)
+				})
 
-				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
-				Expect(b.String()).To(MatchJSON(`{
-					"error": "not_found",
+				It("returns its position in the queue and an ETA", func() {
+					doRequest()
+
+					var j struct {
+						Deployment struct {
+							Queue *deployment.QueueStatusJSON `json:"queue"`
+						} `json:"deployment"`
+					}
+					Expect(json.NewDecoder(res.Body).Decode(&j)).To(Succeed())
+					Expect(j.Deployment.Queue).NotTo(BeNil())
+					Expect(j.Deployment.Queue.Name).To(Equal(queues.Deploy))
+					Expect(j.Deployment.Queue.Position).To(Equal(2))
+					Expect(*j.Deployment.Queue.ETASeconds).To(Equal(int64(60)))
+				})
+			})
+
+			Context("when the deployment has finished", func() {
+				BeforeEach(func() {
+					Expect(depl.UpdateState(db, deployment.StateDeployed)).To(Succeed())
+				})
+
+				It("does not return a queue", func() {
+					doRequest()
+
+					var j map[string]map[string]interface{}
+					Expect(json.NewDecoder(res.Body).Decode(&j)).To(Succeed())
+					Expect(j["deployment"]).NotTo(HaveKey("queue"))
+				})
+			})
+		})
+
+		Context("when state and wait are given", func() {
+			doWaitRequest := func(state string, wait int) {
+				s = httptest.NewServer(server.New())
+				url := fmt.Sprintf("%s/projects/foo-bar-express/deployments/%d?state=%s&wait=%d", s.URL, depl.ID, state, wait)
+				res, err = testhelper.MakeRequest("GET", url, nil, headers, nil)
+				Expect(err).To(BeNil())
+			}
+
+			decodeState := func() string {
+				var j struct {
+					Deployment struct {
+						State string `json:"state"`
+					} `json:"deployment"`
+				}
+				Expect(json.NewDecoder(res.Body).Decode(&j)).To(Succeed())
+				return j.Deployment.State
+			}
+
+			It("returns right away if the deployment is in another state", func() {
+				start := time.Now()
+				doWaitRequest(deployment.StatePendingBuild, 5)
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(decodeState()).To(Equal(deployment.StatePendingDeploy))
+				Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+			})
+
+			It("returns the deployment once its state changes", func() {
+				go func() {
+					defer GinkgoRecover()
+					time.Sleep(200 * time.Millisecond)
+					Expect(depl.UpdateState(db, deployment.StateDeployed)).To(Succeed())
+					deploywatch.Default.Publish(&deployment.StateChange{
+						ID:        depl.ID,
+						ProjectID: proj.ID,
+						State:     deployment.StateDeployed,
+					})
+				}()
+
+				start := time.Now()
+				doWaitRequest(deployment.StatePendingDeploy, 5)
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(decodeState()).To(Equal(deployment.StateDeployed))
+				Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+			})
+
+			It("returns the deployment as it is if its state does not change in time", func() {
+				start := time.Now()
+				doWaitRequest(deployment.StatePendingDeploy, 1)
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(decodeState()).To(Equal(deployment.StatePendingDeploy))
+				Expect(time.Since(start)).To(BeNumerically(">=", time.Second))
+			})
+		})
+
+		Context("the deployment does not exist", func() {
+			BeforeEach(func() {
+				Expect(db.Delete(depl).Error).To(BeNil())
+			})
+
+			It("returns 404 not found", func() {
+				doRequest()
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "not_found",
 					"error_description": "deployment could not be found"
 				}`))
 			})
@@ -970,6 +1630,121 @@ var _ = Describe("Deployments", func() {
 		})
 	})
 
+	Describe("GET /projects/:project_name/deployments/:id/events", func() {
+		var (
+			u *user.User
+			t *oauthtoken.OauthToken
+
+			headers http.Header
+			proj    *project.Project
+			depl    *deployment.Deployment
+		)
+
+		BeforeEach(func() {
+			u, _, t = factories.AuthTrio(db)
+
+			proj = &project.Project{
+				Name:   "foo-bar-express",
+				UserID: u.ID,
+			}
+			Expect(db.Create(proj).Error).To(BeNil())
+
+			headers = http.Header{
+				"Authorization": {"Bearer " + t.Token},
+			}
+
+			depl = factories.Deployment(db, proj, u, deployment.StatePendingBuild)
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			url := fmt.Sprintf("%s/projects/foo-bar-express/deployments/%d/events", s.URL, depl.ID)
+			res, err = testhelper.MakeRequest("GET", url, nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		It("streams state changes until the deployment has finished", func() {
+			go func() {
+				defer GinkgoRecover()
+				for _, state := range []string{deployment.StatePendingDeploy, deployment.StateDeployed} {
+					time.Sleep(200 * time.Millisecond)
+					Expect(depl.UpdateState(db, state)).To(Succeed())
+					deploywatch.Default.Publish(&deployment.StateChange{
+						ID:        depl.ID,
+						ProjectID: proj.ID,
+						State:     state,
+					})
+				}
+			}()
+
+			doRequest()
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(res.Header.Get("Content-Type")).To(HavePrefix("text/event-stream"))
+
+			b, err := ioutil.ReadAll(res.Body)
+			Expect(err).To(BeNil())
+
+			events := strings.Split(strings.TrimSpace(string(b)), "\n\n")
+			Expect(events).To(HaveLen(3))
+			Expect(events[0]).To(HavePrefix("event:state\n"))
+			Expect(events[0]).To(ContainSubstring(`"state":"pending_build"`))
+			Expect(events[1]).To(ContainSubstring(`"state":"pending_deploy"`))
+			Expect(events[2]).To(ContainSubstring(`"state":"deployed"`))
+		})
+
+		It("streams progress messages published while the deployment is in the same state", func() {
+			go func() {
+				defer GinkgoRecover()
+				time.Sleep(200 * time.Millisecond)
+				deploywatch.Default.Publish(&deployment.StateChange{
+					ID: depl.ID,
+					Progress: &deployment.Progress{
+						Message:   "Optimizing assets",
+						Timestamp: time.Date(2016, 6, 1, 8, 0, 0, 0, time.UTC),
+					},
+				})
+
+				time.Sleep(200 * time.Millisecond)
+				Expect(depl.UpdateState(db, deployment.StateBuildFailed)).To(Succeed())
+				deploywatch.Default.Publish(&deployment.StateChange{
+					ID:        depl.ID,
+					ProjectID: proj.ID,
+					State:     deployment.StateBuildFailed,
+				})
+			}()
+
+			doRequest()
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+			b, err := ioutil.ReadAll(res.Body)
+			Expect(err).To(BeNil())
+
+			events := strings.Split(strings.TrimSpace(string(b)), "\n\n")
+			Expect(events).To(HaveLen(3))
+			Expect(events[0]).To(ContainSubstring(`"state":"pending_build"`))
+			Expect(events[1]).To(Equal(`event:progress` + "\n" + `data:{"message":"Optimizing assets","timestamp":"2016-06-01T08:00:00Z"}`))
+			Expect(events[2]).To(ContainSubstring(`"state":"build_failed"`))
+		})
+
+		Context("when the deployment belongs to another project", func() {
+			BeforeEach(func() {
+				depl = factories.Deployment(db, nil, u, deployment.StatePendingBuild)
+			})
+
+			It("returns 404 not found", func() {
+				doRequest()
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+			})
+		})
+	})
+
 	Describe("GET /projects/:project_name/deployments/:id/download", func() {
 		var (
 			err error
@@ -1164,6 +1939,312 @@ var _ = Describe("Deployments", func() {
 		})
 	})
 
+	Describe("GET /projects/:project_name/deployments/:id/files/*path", func() {
+		var (
+			err error
+
+			fakeS3 *fake.S3
+			origS3 filetransfer.FileTransfer
+
+			u *user.User
+			t *oauthtoken.OauthToken
+
+			headers  http.Header
+			proj     *project.Project
+			depl     *deployment.Deployment
+			filePath string
+		)
+
+		BeforeEach(func() {
+			origS3 = s3client.S3
+			fakeS3 = &fake.S3{}
+			s3client.S3 = fakeS3
+
+			fakeS3.ExistsReturn = true
+			fakeS3.PresignedURLReturn = "https://s3-us-west-2.amazonaws.com/deployments/a1b2c3/webroot/css/app.css?abc=123"
+
+			u, _, t = factories.AuthTrio(db)
+
+			headers = http.Header{
+				"Authorization": {"Bearer " + t.Token},
+			}
+
+			proj = &project.Project{
+				Name:   "foo-bar-express",
+				UserID: u.ID,
+			}
+			Expect(db.Create(proj).Error).To(BeNil())
+
+			depl = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				Prefix:     "a1b2c3",
+				State:      deployment.StateDeployed,
+				DeployedAt: timeAgo(-1 * time.Hour),
+			})
+
+			filePath = "css/app.css"
+		})
+
+		AfterEach(func() {
+			s3client.S3 = origS3
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			url := fmt.Sprintf("%s/projects/foo-bar-express/deployments/%d/files/%s", s.URL, depl.ID, filePath)
+			res, err = testhelper.MakeRequest("GET", url, nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		It("responds with a pre-signed URL of the file in the webroot of the deployment", func() {
+			doRequest()
+
+			Expect(fakeS3.ExistsCalls.Count()).To(Equal(1))
+			Expect(fakeS3.PresignedURLCalls.Count()).To(Equal(1))
+			call := fakeS3.PresignedURLCalls.NthCall(1)
+			Expect(call).NotTo(BeNil())
+			Expect(call.Arguments[0]).To(Equal(s3client.BucketRegion))
+			Expect(call.Arguments[1]).To(Equal(s3client.BucketName))
+			Expect(call.Arguments[2]).To(Equal("deployments/" + depl.PrefixID() + "/webroot/css/app.css"))
+
+			b := &bytes.Buffer{}
+			_, err = b.ReadFrom(res.Body)
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+				"url": "%s"
+			}`, fakeS3.PresignedURLReturn)))
+		})
+
+		Context("when the path is a directory", func() {
+			BeforeEach(func() {
+				filePath = "blog/"
+			})
+
+			It("responds with a pre-signed URL of its index.html", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				call := fakeS3.PresignedURLCalls.NthCall(1)
+				Expect(call).NotTo(BeNil())
+				Expect(call.Arguments[2]).To(Equal("deployments/" + depl.PrefixID() + "/webroot/blog/index.html"))
+			})
+
+			Context("when the project has a custom index document", func() {
+				BeforeEach(func() {
+					Expect(db.Model(proj).Update("index_document", "default.htm").Error).To(BeNil())
+				})
+
+				It("responds with a pre-signed URL of its index document", func() {
+					doRequest()
+
+					Expect(res.StatusCode).To(Equal(http.StatusOK))
+					call := fakeS3.PresignedURLCalls.NthCall(1)
+					Expect(call).NotTo(BeNil())
+					Expect(call.Arguments[2]).To(Equal("deployments/" + depl.PrefixID() + "/webroot/blog/default.htm"))
+				})
+			})
+		})
+
+		Context("when the path tries to escape the webroot", func() {
+			BeforeEach(func() {
+				filePath = "..%2F..%2Fraw-bundle.zip"
+			})
+
+			It("does not look outside of the webroot", func() {
+				doRequest()
+
+				call := fakeS3.ExistsCalls.NthCall(1)
+				Expect(call).NotTo(BeNil())
+				Expect(call.Arguments[2]).To(Equal("deployments/" + depl.PrefixID() + "/webroot/raw-bundle.zip"))
+			})
+		})
+
+		Context("when the file does not exist", func() {
+			BeforeEach(func() {
+				fakeS3.ExistsReturn = false
+			})
+
+			It("responds with 404 Not Found", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "not_found",
+					"error_description": "file could not be found"
+				}`))
+				Expect(fakeS3.PresignedURLCalls.Count()).To(Equal(0))
+			})
+		})
+
+		Context("when the deployment has not been deployed", func() {
+			BeforeEach(func() {
+				Expect(db.Model(depl).Updates(map[string]interface{}{
+					"state":       deployment.StateDeployFailed,
+					"deployed_at": nil,
+				}).Error).To(BeNil())
+			})
+
+			It("responds with 404 Not Found", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "not_found",
+					"error_description": "deployment has not been deployed"
+				}`))
+				Expect(fakeS3.ExistsCalls.Count()).To(Equal(0))
+			})
+		})
+
+		Context("when the deployment belongs to another project", func() {
+			BeforeEach(func() {
+				otherProj := factories.Project(db, u)
+				Expect(db.Model(depl).Update("project_id", otherProj.ID).Error).To(BeNil())
+			})
+
+			It("responds with 404 Not Found", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "not_found",
+					"error_description": "deployment could not be found"
+				}`))
+			})
+		})
+	})
+
+	Describe("GET /projects/:project_name/deployments/:id/report", func() {
+		var (
+			err error
+
+			u *user.User
+			t *oauthtoken.OauthToken
+
+			headers http.Header
+			proj    *project.Project
+			depl    *deployment.Deployment
+		)
+
+		BeforeEach(func() {
+			u, _, t = factories.AuthTrio(db)
+
+			headers = http.Header{
+				"Authorization": {"Bearer " + t.Token},
+			}
+
+			proj = factories.Project(db, u, "foo-bar-express")
+			depl = factories.Deployment(db, proj, u, deployment.StateDeployed)
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			url := fmt.Sprintf("%s/projects/foo-bar-express/deployments/%d/report", s.URL, depl.ID)
+			res, err = testhelper.MakeRequest("GET", url, nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		It("responds with the report of the deployment", func() {
+			Expect(depl.SaveReport(db, deployment.NewReport([]*deployment.FileSize{
+				{Path: "index.html", Size: 100},
+				{Path: "images/hero.png", Size: 5000},
+			}))).To(Succeed())
+
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err = b.ReadFrom(res.Body)
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(MatchJSON(`{
+				"report": {
+					"total_size": 5100,
+					"file_count": 2,
+					"extensions": [
+						{"extension": ".png", "file_count": 1, "size": 5000},
+						{"extension": ".html", "file_count": 1, "size": 100}
+					],
+					"largest_files": [
+						{"path": "images/hero.png", "size": 5000},
+						{"path": "index.html", "size": 100}
+					]
+				}
+			}`))
+		})
+
+		Context("when the deployment does not have a report", func() {
+			It("responds with 404 Not Found", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "not_found",
+					"error_description": "report could not be found"
+				}`))
+			})
+		})
+
+		Context("when the deployment belongs to another project", func() {
+			BeforeEach(func() {
+				proj2 := factories.Project(db, u)
+				depl = factories.Deployment(db, proj2, u, deployment.StateDeployed)
+			})
+
+			It("responds with 404 Not Found", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "not_found",
+					"error_description": "deployment could not be found"
+				}`))
+			})
+		})
+	})
+
 	Describe("POST /projects/:project_name/rollback", func() {
 		var (
 			err error
@@ -1171,7 +2252,7 @@ var _ = Describe("Deployments", func() {
 			fakeS3 *fake.S3
 			origS3 filetransfer.FileTransfer
 
-			mq *amqp.Connection
+			mq mqconn.Conn
 
 			u *user.User
 			t *oauthtoken.OauthToken
@@ -1267,6 +2348,55 @@ var _ = Describe("Deployments", func() {
 			return res
 		}, nil)
 
+		Context("when the deployments of the project are frozen", func() {
+			BeforeEach(func() {
+				endsAt := time.Now().Add(time.Hour).Truncate(time.Second)
+				Expect(proj.Freeze(db, time.Now().Add(-time.Minute).Truncate(time.Second), &endsAt, nil)).To(Succeed())
+			})
+
+			It("returns 423 locked", func() {
+				doRequest()
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				startsAt, err := proj.FreezeStartsAt.MarshalJSON()
+				Expect(err).To(BeNil())
+				endsAt, err := proj.FreezeEndsAt.MarshalJSON()
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(423))
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"error": "locked",
+					"code": "deploys_frozen",
+					"error_description": "deployments of this project are frozen",
+					"freeze": {
+						"frozen": true,
+						"starts_at": %s,
+						"ends_at": %s
+					}
+				}`, startsAt, endsAt)))
+
+				var d deployment.Deployment
+				Expect(db.First(&d, depl1.ID).Error).To(BeNil())
+				Expect(d.State).To(Equal(deployment.StateDeployed))
+				Expect(testhelper.ConsumeQueue(mq, queues.Deploy)).To(BeNil())
+			})
+
+			It("rolls back if the owner overrides the freeze", func() {
+				s = httptest.NewServer(server.New())
+				url := fmt.Sprintf("%s/projects/foo-bar-express/rollback?override_freeze=true", s.URL)
+				res, err = testhelper.MakeRequest("POST", url, params, headers, nil)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+
+				var d deployment.Deployment
+				Expect(db.First(&d, depl1.ID).Error).To(BeNil())
+				Expect(d.State).To(Equal(deployment.StatePendingRollback))
+			})
+		})
+
 		Context("when the version is not specified", func() {
 			It("returns 202 accepted", func() {
 				doRequest()
@@ -1541,6 +2671,7 @@ var _ = Describe("Deployments", func() {
 				Expect(err).To(BeNil())
 				Expect(b.String()).To(MatchJSON(`{
 					"error": "precondition_failed",
+					"code": "no_active_deployment",
 					"error_description": "active deployment could not be found"
 				}`))
 			})
@@ -1568,6 +2699,149 @@ var _ = Describe("Deployments", func() {
 		})
 	})
 
+	Describe("POST /projects/:project_name/prefix/regenerate", func() {
+		var (
+			err error
+
+			mq mqconn.Conn
+
+			u *user.User
+			t *oauthtoken.OauthToken
+
+			headers http.Header
+			proj    *project.Project
+			depl    *deployment.Deployment
+		)
+
+		BeforeEach(func() {
+			mq, err = mqconn.MQ()
+			Expect(err).To(BeNil())
+
+			testhelper.DeleteQueue(mq, queues.All...)
+
+			u, _, t = factories.AuthTrio(db)
+
+			proj = &project.Project{
+				Name:   "foo-bar-express",
+				UserID: u.ID,
+			}
+			Expect(db.Create(proj).Error).To(BeNil())
+
+			headers = http.Header{
+				"Authorization": {"Bearer " + t.Token},
+			}
+
+			depl = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				Prefix:     "a1b2c3",
+				State:      deployment.StateDeployed,
+				DeployedAt: timeAgo(1 * time.Hour),
+			})
+
+			proj.ActiveDeploymentID = &depl.ID
+			Expect(db.Save(proj).Error).To(BeNil())
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			url := fmt.Sprintf("%s/projects/foo-bar-express/prefix/regenerate", s.URL)
+			res, err = testhelper.MakeRequest("POST", url, nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProject(func() (*gorm.DB, *project.Project) {
+			return db, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItLocksProject(func() (*gorm.DB, *project.Project) {
+			return db, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		It("returns 202 accepted and enqueues a deploy job that moves the active deployment to a new prefix", func() {
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err = b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+			Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+				"deployment": {
+					"id": %d,
+					"state": "deployed",
+					"deployed_at": %q,
+					"version": %d
+				}
+			}`, depl.ID, depl.DeployedAt.Format(time.RFC3339Nano), depl.Version)))
+
+			d := testhelper.ConsumeQueue(mq, queues.Deploy)
+			Expect(d).NotTo(BeNil())
+
+			data := &messages.DeployJobData{}
+			Expect(json.Unmarshal(d.Body, data)).To(Succeed())
+			Expect(data.DeploymentID).To(Equal(depl.ID))
+			Expect(data.SkipWebrootUpload).To(BeTrue())
+			Expect(data.PrefixRotation).NotTo(BeNil())
+			Expect(data.PrefixRotation.From).To(Equal("a1b2c3"))
+			Expect(data.PrefixRotation.To).To(MatchRegexp(`\A[0-9a-f]{16}\z`))
+
+			// The prefix is only changed once the files have been copied.
+			var reloaded deployment.Deployment
+			Expect(db.First(&reloaded, depl.ID).Error).To(BeNil())
+			Expect(reloaded.Prefix).To(Equal("a1b2c3"))
+		})
+
+		It("tracks a 'Regenerated Deployment Prefix' event", func() {
+			doRequest()
+
+			trackCall := fakeTracker.TrackCalls.NthCall(1)
+			Expect(trackCall).NotTo(BeNil())
+			Expect(trackCall.Arguments[0]).To(Equal(fmt.Sprintf("%d", u.ID)))
+			Expect(trackCall.Arguments[1]).To(Equal("Regenerated Deployment Prefix"))
+
+			props, ok := trackCall.Arguments[3].(map[string]interface{})
+			Expect(ok).To(BeTrue())
+			Expect(props["projectName"]).To(Equal(proj.Name))
+			Expect(props["deploymentId"]).To(Equal(depl.ID))
+		})
+
+		Context("when active_deployment_id is nil", func() {
+			BeforeEach(func() {
+				proj.ActiveDeploymentID = nil
+				Expect(db.Save(proj).Error).To(BeNil())
+			})
+
+			It("returns 412 with precondition_failed", func() {
+				doRequest()
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusPreconditionFailed))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "precondition_failed",
+					"code": "no_active_deployment",
+					"error_description": "active deployment could not be found"
+				}`))
+
+				d := testhelper.ConsumeQueue(mq, queues.Deploy)
+				Expect(d).To(BeNil())
+			})
+		})
+	})
+
 	Describe("GET /projects/:name/deployments", func() {
 		var (
 			err error
@@ -1725,6 +2999,47 @@ var _ = Describe("Deployments", func() {
 				}`, depl2.ID, depl2.State, formattedTimeForJSON(depl2.DeployedAt), depl2.Version,
 				)))
 			})
+
+			Context("when older deployments are pinned", func() {
+				BeforeEach(func() {
+					Expect(depl4.Pin(db)).To(BeNil())
+				})
+
+				It("lists them separately", func() {
+					doRequest()
+
+					b := &bytes.Buffer{}
+					_, err = b.ReadFrom(res.Body)
+					Expect(err).To(BeNil())
+					Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+					depl2 = reloadDeployment(depl2)
+					depl4 = reloadDeployment(depl4)
+
+					Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+						"deployments": [
+							{
+								"id": %d,
+								"state": "%s",
+								"active": true,
+								"deployed_at": %s,
+								"version": %d
+							}
+						],
+						"pinned_deployments": [
+							{
+								"id": %d,
+								"state": "%s",
+								"deployed_at": %s,
+								"pinned_at": %s,
+								"version": %d
+							}
+						]
+					}`, depl2.ID, depl2.State, formattedTimeForJSON(depl2.DeployedAt), depl2.Version,
+						depl4.ID, depl4.State, formattedTimeForJSON(depl4.DeployedAt), formattedTimeForJSON(depl4.PinnedAt), depl4.Version,
+					)))
+				})
+			})
 		})
 	})
 })
@@ -0,0 +1,170 @@
+package deployments
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/outboxjob"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/pkg/job"
+	"github.com/nitrous-io/rise-server/shared/messages"
+	"github.com/nitrous-io/rise-server/shared/queues"
+)
+
+// importProviders are the providers that sites can be imported from.
+var importProviders = map[string]bool{
+	messages.ImportProviderURL:     true,
+	messages.ImportProviderNetlify: true,
+	messages.ImportProviderSurge:   true,
+}
+
+// Import deploys a project from a site on another static host, which is
+// either crawled from its URL or read from an export of it. The site is
+// fetched by the import worker, so the deployment is pending upload until it
+// has been imported.
+func Import(c *gin.Context) {
+	u := controllers.CurrentUser(c)
+	proj := controllers.CurrentProject(c)
+
+	provider := c.Param("provider")
+	if !importProviders[provider] {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "import provider could not be found",
+		})
+		return
+	}
+
+	rawURL := strings.TrimSpace(c.PostForm("url"))
+	if rawURL == "" {
+		c.JSON(422, gin.H{
+			"error": "invalid_params",
+			"errors": map[string]interface{}{
+				"url": "is required",
+			},
+		})
+		return
+	}
+
+	if pu, err := url.Parse(rawURL); err != nil || (pu.Scheme != "http" && pu.Scheme != "https") || pu.Host == "" {
+		c.JSON(422, gin.H{
+			"error": "invalid_params",
+			"errors": map[string]interface{}{
+				"url": "is invalid",
+			},
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err, "deployments: failed to get a db connection")
+		return
+	}
+
+	tx, err := dbconn.Begin(controllers.Context(c))
+	if err != nil {
+		controllers.InternalServerError(c, err, "deployments: failed to begin a transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	// Imported deployments are not queued, as the import worker starts the
+	// build or deploy once the site has been fetched.
+	if proj.DeployConcurrency == project.DeployConcurrencyReject {
+		inFlight, err := deployment.InFlight(tx, proj.ID)
+		if err != nil {
+			controllers.InternalServerError(c, err, "deployments: failed to find a deployment in flight")
+			return
+		}
+		if inFlight != nil {
+			respondInFlight(c, inFlight)
+			return
+		}
+	}
+
+	depl := &deployment.Deployment{
+		ProjectID: proj.ID,
+		UserID:    u.ID,
+		Source:    controllers.DeploymentSource(c),
+		Settings:  proj.DeploymentDefaults,
+	}
+
+	// Get js environment variables from previous deployment.
+	if proj.ActiveDeploymentID != nil {
+		var prevDepl deployment.Deployment
+		if err := tx.Where("id = ?", proj.ActiveDeploymentID).First(&prevDepl).Error; err != nil {
+			controllers.InternalServerError(c, err, "deployments: failed to fetch a previous deployment")
+			return
+		}
+
+		depl.CopyJsEnvVars(&prevDepl)
+	}
+
+	ver, err := proj.NextVersion(tx)
+	if err != nil {
+		controllers.InternalServerError(c, err, "deployments: failed to get next deployment version number")
+		return
+	}
+
+	depl.Version = ver
+	if err := tx.Create(depl).Error; err != nil {
+		controllers.InternalServerError(c, err, "deployments: failed to create a deployment record in DB")
+		return
+	}
+
+	j, err := job.NewWithJSON(queues.Import, &messages.ImportJobData{
+		DeploymentID: depl.ID,
+		Provider:     provider,
+		URL:          rawURL,
+	})
+	if err != nil {
+		controllers.InternalServerError(c, err, "deployments: failed to create an import job")
+		return
+	}
+
+	ob, err := outboxjob.Add(tx, j)
+	if err != nil {
+		controllers.InternalServerError(c, err, "deployments: failed to add a job to the outbox")
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		controllers.InternalServerError(c, err, "deployments: failed to commit a transaction")
+		return
+	}
+
+	outboxjob.DeliverAll(db, ob)
+
+	{
+		var (
+			event = "Initiated Project Deployment"
+			props = map[string]interface{}{
+				"projectName":       proj.Name,
+				"deploymentId":      depl.ID,
+				"deploymentPrefix":  depl.Prefix,
+				"deploymentVersion": depl.Version,
+				"deploymentSource":  depl.Source,
+				"source":            "Import",
+				"importProvider":    provider,
+			}
+			context = controllers.TrackingContext(c)
+		)
+		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
+			log.Errorf("failed to track %q event for user ID %d, err: %v",
+				event, u.ID, err)
+		}
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"deployment": depl.AsJSON(),
+	})
+}
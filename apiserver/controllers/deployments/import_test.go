@@ -0,0 +1,283 @@
+package deployments_test
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/apiserver/server"
+	"github.com/nitrous-io/rise-server/pkg/mqconn"
+	"github.com/nitrous-io/rise-server/pkg/tracker"
+	"github.com/nitrous-io/rise-server/shared/queues"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+	"github.com/nitrous-io/rise-server/testhelper/fake"
+	"github.com/nitrous-io/rise-server/testhelper/sharedexamples"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Imports", func() {
+	var (
+		db *gorm.DB
+		mq mqconn.Conn
+
+		s   *httptest.Server
+		res *http.Response
+		err error
+
+		fakeTracker *fake.Tracker
+		origTracker tracker.Trackable
+
+		u *user.User
+		t *oauthtoken.OauthToken
+
+		headers http.Header
+		proj    *project.Project
+
+		provider string
+		params   url.Values
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+
+		mq, err = mqconn.MQ()
+		Expect(err).To(BeNil())
+
+		origTracker = common.Tracker
+		fakeTracker = &fake.Tracker{}
+		common.Tracker = fakeTracker
+
+		testhelper.TruncateTables(db.DB())
+		testhelper.DeleteQueue(mq, queues.All...)
+
+		u, _, t = factories.AuthTrio(db)
+
+		proj = &project.Project{
+			Name:   "foo-bar-express",
+			UserID: u.ID,
+		}
+		Expect(db.Create(proj).Error).To(BeNil())
+
+		headers = http.Header{
+			"Authorization": {"Bearer " + t.Token},
+		}
+
+		provider = "netlify"
+		params = url.Values{"url": {"https://example.com/export.zip"}}
+	})
+
+	AfterEach(func() {
+		if res != nil {
+			res.Body.Close()
+		}
+		s.Close()
+
+		common.Tracker = origTracker
+	})
+
+	doRequest := func() {
+		s = httptest.NewServer(server.New())
+		res, err = testhelper.MakeRequest("POST", s.URL+"/projects/foo-bar-express/import/"+provider, params, headers, nil)
+		Expect(err).To(BeNil())
+	}
+
+	Describe("POST /projects/:name/import/:provider", func() {
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, func() {
+			depl := &deployment.Deployment{}
+			Expect(db.Last(depl).Error).To(Equal(gorm.RecordNotFound))
+		})
+
+		sharedexamples.ItLocksProject(func() (*gorm.DB, *project.Project) {
+			return db, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, func() {
+			depl := &deployment.Deployment{}
+			Expect(db.Last(depl).Error).To(Equal(gorm.RecordNotFound))
+		})
+
+		It("creates a deployment pending upload and enqueues an import job", func() {
+			doRequest()
+
+			depl := &deployment.Deployment{}
+			Expect(db.Last(depl).Error).To(BeNil())
+			Expect(depl.ProjectID).To(Equal(proj.ID))
+			Expect(depl.UserID).To(Equal(u.ID))
+			Expect(depl.State).To(Equal(deployment.StatePendingUpload))
+			Expect(depl.Version).To(Equal(int64(1)))
+
+			b := &bytes.Buffer{}
+			_, err = b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+			Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+			Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+				"deployment": {
+					"id": %d,
+					"state": "pending_upload",
+					"version": 1,
+					"source": "api"
+				}
+			}`, depl.ID)))
+
+			d := testhelper.ConsumeQueue(mq, queues.Import)
+			Expect(d).NotTo(BeNil())
+			Expect(d.Body).To(MatchJSON(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"provider": "netlify",
+				"url": "https://example.com/export.zip"
+			}`, depl.ID)))
+		})
+
+		DescribeTable("records the source of the deployment from the user agent",
+			func(userAgent, source string) {
+				headers.Set("User-Agent", userAgent)
+				doRequest()
+				Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+
+				depl := &deployment.Deployment{}
+				Expect(db.Last(depl).Error).To(BeNil())
+				Expect(depl.Source).To(Equal(source))
+			},
+			Entry("command line client", "rise-cli/1.2.0 (darwin)", deployment.SourceCLI),
+			Entry("command line client on CI", "rise-cli/1.2.0 (linux; CI)", deployment.SourceCI),
+			Entry("browser", "Mozilla/5.0 (Macintosh)", deployment.SourceWeb),
+			Entry("other clients", "curl/7.43.0", deployment.SourceAPI),
+		)
+
+		It("tracks an 'Initiated Project Deployment' event", func() {
+			doRequest()
+
+			depl := &deployment.Deployment{}
+			Expect(db.Last(depl).Error).To(BeNil())
+
+			trackCall := fakeTracker.TrackCalls.NthCall(1)
+			Expect(trackCall).NotTo(BeNil())
+			Expect(trackCall.Arguments[1]).To(Equal("Initiated Project Deployment"))
+
+			props := trackCall.Arguments[3].(map[string]interface{})
+			Expect(props["deploymentId"]).To(Equal(depl.ID))
+			Expect(props["source"]).To(Equal("Import"))
+			Expect(props["importProvider"]).To(Equal("netlify"))
+			Expect(props["deploymentSource"]).To(Equal(deployment.SourceAPI))
+		})
+
+		Context("when the provider is not supported", func() {
+			BeforeEach(func() {
+				provider = "geocities"
+			})
+
+			It("returns 404 not found", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "not_found",
+					"error_description": "import provider could not be found"
+				}`))
+
+				Expect(testhelper.ConsumeQueue(mq, queues.Import)).To(BeNil())
+			})
+		})
+
+		Context("when the url is invalid", func() {
+			BeforeEach(func() {
+				params = url.Values{"url": {"ftp://example.com/export.zip"}}
+			})
+
+			It("returns 422 with invalid_params", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"errors": {
+						"url": "is invalid"
+					}
+				}`))
+
+				depl := &deployment.Deployment{}
+				Expect(db.Last(depl).Error).To(Equal(gorm.RecordNotFound))
+			})
+		})
+
+		Context("when the url is missing", func() {
+			BeforeEach(func() {
+				params = url.Values{}
+			})
+
+			It("returns 422 with invalid_params", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"errors": {
+						"url": "is required"
+					}
+				}`))
+			})
+		})
+
+		Context("when the project rejects concurrent deployments and a deployment is in flight", func() {
+			var inFlight *deployment.Deployment
+
+			BeforeEach(func() {
+				Expect(db.Model(proj).Update("deploy_concurrency", project.DeployConcurrencyReject).Error).To(BeNil())
+
+				inFlight = factories.Deployment(db, proj, u, deployment.StatePendingBuild)
+			})
+
+			It("returns 409 conflict", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+				Expect(res.StatusCode).To(Equal(http.StatusConflict))
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"error": "conflict",
+					"code": "deployment_in_progress",
+					"error_description": "another deployment of this project is in progress",
+					"deployment_id": %d
+				}`, inFlight.ID)))
+
+				Expect(testhelper.ConsumeQueue(mq, queues.Import)).To(BeNil())
+			})
+		})
+	})
+})
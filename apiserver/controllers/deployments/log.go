@@ -0,0 +1,84 @@
+package deployments
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/shared/s3client"
+)
+
+// ShowLog returns the output of the commands that were run to build a
+// deployment, e.g. its pre-build hooks and the optimizer, so that users can
+// debug failed builds.
+func ShowLog(c *gin.Context) {
+	deploymentID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "deployment could not be found",
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	proj := controllers.CurrentProject(c)
+
+	depl := &deployment.Deployment{}
+	if err := db.Where("project_id = ?", proj.ID).First(depl, deploymentID).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":             "not_found",
+				"error_description": "deployment could not be found",
+			})
+			return
+		}
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	// Deployments that were not built, or whose build did not run any
+	// commands, do not have a log.
+	key := depl.BuildLogPath()
+	exists, err := s3client.Exists(key)
+	if err != nil {
+		log.Warnf("failed to check existence of %q on S3, err: %v", key, err)
+		controllers.InternalServerError(c, err)
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "build log could not be found",
+		})
+		return
+	}
+
+	rc, err := s3client.Open(key)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+	defer rc.Close()
+
+	b, err := ioutil.ReadAll(rc)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"log": string(b),
+	})
+}
@@ -0,0 +1,170 @@
+package deployments_test
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/apiserver/server"
+	"github.com/nitrous-io/rise-server/pkg/filetransfer"
+	"github.com/nitrous-io/rise-server/shared/s3client"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+	"github.com/nitrous-io/rise-server/testhelper/fake"
+	"github.com/nitrous-io/rise-server/testhelper/sharedexamples"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Deployment build logs", func() {
+	var (
+		db *gorm.DB
+
+		s   *httptest.Server
+		res *http.Response
+		err error
+
+		fakeS3 *fake.S3
+		origS3 filetransfer.FileTransfer
+
+		u *user.User
+		t *oauthtoken.OauthToken
+
+		headers http.Header
+		proj    *project.Project
+		depl    *deployment.Deployment
+		deplID  string
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+
+		origS3 = s3client.S3
+		fakeS3 = &fake.S3{}
+		s3client.S3 = fakeS3
+
+		testhelper.TruncateTables(db.DB())
+
+		u, _, t = factories.AuthTrio(db)
+
+		proj = &project.Project{
+			Name:   "foo-bar-express",
+			UserID: u.ID,
+		}
+		Expect(db.Create(proj).Error).To(BeNil())
+
+		headers = http.Header{
+			"Authorization": {"Bearer " + t.Token},
+		}
+
+		depl = factories.Deployment(db, proj, u, deployment.StateBuildFailed)
+		deplID = fmt.Sprint(depl.ID)
+
+		fakeS3.ExistsReturn = true
+		fakeS3.DownloadContent = []byte("[2016-06-01T08:00:00Z] Running pre-build hook \"npm test\"\n1 failing\n")
+	})
+
+	AfterEach(func() {
+		if res != nil {
+			res.Body.Close()
+		}
+		s.Close()
+
+		s3client.S3 = origS3
+	})
+
+	doRequest := func() {
+		s = httptest.NewServer(server.New())
+		res, err = testhelper.MakeRequest("GET", s.URL+"/projects/foo-bar-express/deployments/"+deplID+"/log", nil, headers, nil)
+		Expect(err).To(BeNil())
+	}
+
+	Describe("GET /projects/:name/deployments/:id/log", func() {
+		It("returns the build log of the deployment", func() {
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(MatchJSON(`{
+				"log": "[2016-06-01T08:00:00Z] Running pre-build hook \"npm test\"\n1 failing\n"
+			}`))
+
+			existsCall := fakeS3.ExistsCalls.NthCall(1)
+			Expect(existsCall).NotTo(BeNil())
+			Expect(existsCall.Arguments[2]).To(Equal("deployments/" + depl.PrefixID() + "/build.log"))
+
+			openCall := fakeS3.OpenCalls.NthCall(1)
+			Expect(openCall).NotTo(BeNil())
+			Expect(openCall.Arguments[0]).To(Equal(s3client.BucketRegion))
+			Expect(openCall.Arguments[1]).To(Equal(s3client.BucketName))
+			Expect(openCall.Arguments[2]).To(Equal("deployments/" + depl.PrefixID() + "/build.log"))
+		})
+
+		Context("when the deployment does not have a build log", func() {
+			BeforeEach(func() {
+				fakeS3.ExistsReturn = false
+			})
+
+			It("returns 404 not found", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "not_found",
+					"error_description": "build log could not be found"
+				}`))
+				Expect(fakeS3.OpenCalls.Count()).To(Equal(0))
+			})
+		})
+
+		Context("when the deployment belongs to another project", func() {
+			BeforeEach(func() {
+				deplID = fmt.Sprint(factories.Deployment(db, nil, nil, deployment.StateBuildFailed).ID)
+			})
+
+			It("returns 404 not found", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "not_found",
+					"error_description": "deployment could not be found"
+				}`))
+				Expect(fakeS3.ExistsCalls.Count()).To(Equal(0))
+			})
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+})
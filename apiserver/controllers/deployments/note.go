@@ -0,0 +1,95 @@
+package deployments
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+)
+
+// UpdateNote sets the note of a deployment, e.g. why it was rolled back, so
+// that it is shown with the deployment during incident reviews. An empty note
+// removes it.
+func UpdateNote(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	deploymentID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "deployment could not be found",
+		})
+		return
+	}
+
+	note := strings.TrimSpace(c.PostForm("note"))
+	if utf8.RuneCountInString(note) > deployment.MaxNoteLength {
+		c.JSON(422, gin.H{
+			"error": "invalid_params",
+			"errors": map[string]string{
+				"note": "is too long (max. " + strconv.Itoa(deployment.MaxNoteLength) + " characters)",
+			},
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	depl := &deployment.Deployment{}
+	if err := db.Where("id = ? AND project_id = ?", deploymentID, proj.ID).First(depl).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":             "not_found",
+				"error_description": "deployment could not be found",
+			})
+			return
+		}
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	depl.Note = nil
+	if note != "" {
+		depl.Note = &note
+	}
+
+	if err := db.Model(depl).Update("note", depl.Note).Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	{
+		u := controllers.CurrentUser(c)
+
+		var (
+			event = "Updated Deployment Note"
+			props = map[string]interface{}{
+				"projectName":       proj.Name,
+				"deploymentId":      depl.ID,
+				"deploymentVersion": depl.Version,
+				"removed":           depl.Note == nil,
+			}
+			context = controllers.TrackingContext(c)
+		)
+		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
+			log.Errorf("failed to track %q event for user ID %d, err: %v",
+				event, u.ID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"deployment": depl.AsJSON(),
+	})
+}
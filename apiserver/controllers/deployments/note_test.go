@@ -0,0 +1,200 @@
+package deployments_test
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/apiserver/server"
+	"github.com/nitrous-io/rise-server/pkg/tracker"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+	"github.com/nitrous-io/rise-server/testhelper/fake"
+	"github.com/nitrous-io/rise-server/testhelper/sharedexamples"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Deployment notes", func() {
+	var (
+		db *gorm.DB
+
+		s   *httptest.Server
+		res *http.Response
+		err error
+
+		fakeTracker *fake.Tracker
+		origTracker tracker.Trackable
+
+		u *user.User
+		t *oauthtoken.OauthToken
+
+		headers http.Header
+		proj    *project.Project
+		depl    *deployment.Deployment
+		deplID  string
+		params  url.Values
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+
+		origTracker = common.Tracker
+		fakeTracker = &fake.Tracker{}
+		common.Tracker = fakeTracker
+
+		testhelper.TruncateTables(db.DB())
+
+		u, _, t = factories.AuthTrio(db)
+
+		proj = &project.Project{
+			Name:   "foo-bar-express",
+			UserID: u.ID,
+		}
+		Expect(db.Create(proj).Error).To(BeNil())
+
+		headers = http.Header{
+			"Authorization": {"Bearer " + t.Token},
+		}
+
+		depl = factories.Deployment(db, proj, u, deployment.StateDeployFailed)
+		deplID = fmt.Sprint(depl.ID)
+		params = url.Values{"note": {"  rolled back due to broken checkout  "}}
+	})
+
+	AfterEach(func() {
+		if res != nil {
+			res.Body.Close()
+		}
+		s.Close()
+
+		common.Tracker = origTracker
+	})
+
+	doRequest := func() {
+		s = httptest.NewServer(server.New())
+		res, err = testhelper.MakeRequest("PUT", s.URL+"/projects/foo-bar-express/deployments/"+deplID+"/note", params, headers, nil)
+		Expect(err).To(BeNil())
+	}
+
+	Describe("PUT /projects/:name/deployments/:id/note", func() {
+		It("sets the note of the deployment", func() {
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+				"deployment": {
+					"id": %d,
+					"state": "deploy_failed",
+					"version": %d,
+					"note": "rolled back due to broken checkout"
+				}
+			}`, depl.ID, depl.Version)))
+
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			Expect(*depl.Note).To(Equal("rolled back due to broken checkout"))
+		})
+
+		It("tracks an 'Updated Deployment Note' event", func() {
+			doRequest()
+
+			trackCall := fakeTracker.TrackCalls.NthCall(1)
+			Expect(trackCall).NotTo(BeNil())
+			Expect(trackCall.Arguments[0]).To(Equal(fmt.Sprintf("%d", u.ID)))
+			Expect(trackCall.Arguments[1]).To(Equal("Updated Deployment Note"))
+
+			props, ok := trackCall.Arguments[3].(map[string]interface{})
+			Expect(ok).To(BeTrue())
+			Expect(props["projectName"]).To(Equal(proj.Name))
+			Expect(props["deploymentId"]).To(Equal(depl.ID))
+			Expect(props["removed"]).To(Equal(false))
+		})
+
+		Context("when the note is empty", func() {
+			BeforeEach(func() {
+				note := "bad deploy"
+				Expect(db.Model(depl).Update("note", &note).Error).To(BeNil())
+				params.Set("note", " ")
+			})
+
+			It("removes the note", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.Note).To(BeNil())
+			})
+		})
+
+		Context("when the note is too long", func() {
+			BeforeEach(func() {
+				params.Set("note", strings.Repeat("a", deployment.MaxNoteLength+1))
+			})
+
+			It("returns 422 unprocessable entity", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"errors": {
+						"note": "is too long (max. 1000 characters)"
+					}
+				}`))
+			})
+		})
+
+		Context("when the deployment belongs to another project", func() {
+			BeforeEach(func() {
+				deplID = fmt.Sprint(factories.Deployment(db, nil, nil, deployment.StateDeployed).ID)
+			})
+
+			It("returns 404 not found", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "not_found",
+					"error_description": "deployment could not be found"
+				}`))
+			})
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+})
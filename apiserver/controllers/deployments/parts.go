@@ -0,0 +1,225 @@
+package deployments
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/rawbundle"
+	"github.com/nitrous-io/rise-server/pkg/filetransfer"
+	"github.com/nitrous-io/rise-server/shared/errcodes"
+	"github.com/nitrous-io/rise-server/shared/s3client"
+)
+
+const (
+	// MinPartSize is the minimum size of every part of a bundle that is
+	// uploaded in parts except the last, as required by S3.
+	MinPartSize = int64(5 * 1024 * 1024) // 5 MiB
+	// MaxPartNumber is the highest number a part can have, as allowed by S3.
+	MaxPartNumber = 10000
+)
+
+// MaxPartSize is the maximum size of a part. Parts are held in memory while
+// they are uploaded to S3.
+var MaxPartSize = s3client.PartSize
+
+type partJSON struct {
+	Number int64 `json:"number"`
+	Size   int64 `json:"size"`
+}
+
+// ListParts lists the parts of a bundle that have been uploaded with
+// UploadPart, so that clients can resume an upload that was interrupted.
+func ListParts(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	tx, err := dbconn.Begin(controllers.Context(c))
+	if err != nil {
+		controllers.InternalServerError(c, err, "deployments: failed to begin a transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	_, bun, ok := findPendingUpload(c, tx, proj)
+	if !ok {
+		return
+	}
+
+	partsJSON := []partJSON{}
+	if bun.MultipartUploadID != nil {
+		parts, err := s3client.ListParts(bun.UploadedPath, *bun.MultipartUploadID)
+		if err != nil {
+			controllers.InternalServerError(c, err, "deployments: failed to list uploaded parts")
+			return
+		}
+
+		for _, p := range parts {
+			partsJSON = append(partsJSON, partJSON{Number: p.PartNumber, Size: p.Size})
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"parts": partsJSON,
+	})
+}
+
+// UploadPart uploads a numbered part of the bundle of a deployment created by
+// CreateUpload, which is sent as the request body. Parts can be sent in any
+// order, and a part that is sent again replaces the one that was sent before.
+// The parts are assembled in order by CompleteUpload.
+func UploadPart(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	partNumber, err := strconv.ParseInt(c.Param("number"), 10, 64)
+	if err != nil || partNumber < 1 || partNumber > MaxPartNumber {
+		c.JSON(422, gin.H{
+			"error": "invalid_params",
+			"errors": map[string]string{
+				"number": "must be between 1 and " + strconv.Itoa(MaxPartNumber),
+			},
+		})
+		return
+	}
+
+	size, err := strconv.ParseInt(c.Request.Header.Get("Content-Length"), 10, 64)
+	if err != nil || size <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":             "invalid_request",
+			"error_description": "Content-Length header is required",
+		})
+		return
+	}
+	if size > MaxPartSize {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":             "invalid_request",
+			"code":              errcodes.PayloadTooLarge,
+			"error_description": "request body is too large",
+		})
+		return
+	}
+
+	tx, err := dbconn.Begin(controllers.Context(c))
+	if err != nil {
+		controllers.InternalServerError(c, err, "deployments: failed to begin a transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	_, bun, ok := findPendingUpload(c, tx, proj)
+	if !ok {
+		return
+	}
+
+	// The multipart upload is started when the first part is sent. The
+	// deployment stays locked until it has been saved, so that concurrent
+	// parts do not start uploads of their own.
+	if bun.MultipartUploadID == nil {
+		uploadID, err := s3client.CreateMultipartUpload(bun.UploadedPath)
+		if err != nil {
+			controllers.InternalServerError(c, err, "deployments: failed to start a multipart upload")
+			return
+		}
+
+		if err := tx.Model(bun).Update("multipart_upload_id", uploadID).Error; err != nil {
+			controllers.InternalServerError(c, err, "deployments: failed to save multipart upload ID of raw bundle")
+			return
+		}
+		bun.MultipartUploadID = &uploadID
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		controllers.InternalServerError(c, err, "deployments: failed to commit a transaction")
+		return
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(c.Request.Body, MaxPartSize))
+	if err != nil {
+		controllers.InternalServerError(c, err, "deployments: failed to read part")
+		return
+	}
+
+	if int64(len(b)) != size {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":             "invalid_request",
+			"error_description": "request body does not match Content-Length header",
+		})
+		return
+	}
+
+	if err := s3client.UploadPart(bun.UploadedPath, *bun.MultipartUploadID, partNumber, bytes.NewReader(b)); err != nil {
+		controllers.InternalServerError(c, err, "deployments: failed to upload part to S3")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"part": partJSON{Number: partNumber, Size: size},
+	})
+}
+
+// completeMultipartUpload assembles the parts of a bundle that was uploaded
+// with UploadPart. It responds with an error and returns false if the parts
+// cannot be assembled, or are larger than maxSize in total.
+func completeMultipartUpload(c *gin.Context, db *gorm.DB, bun *rawbundle.RawBundle, maxSize int64) bool {
+	parts, err := s3client.ListParts(bun.UploadedPath, *bun.MultipartUploadID)
+	if err != nil {
+		controllers.InternalServerError(c, err, "deployments: failed to list uploaded parts")
+		return false
+	}
+
+	if errMsg := validateParts(parts, maxSize); errMsg != "" {
+		c.JSON(422, gin.H{
+			"error": "invalid_params",
+			"errors": map[string]string{
+				"parts": errMsg,
+			},
+		})
+		return false
+	}
+
+	if err := s3client.CompleteMultipartUpload(bun.UploadedPath, *bun.MultipartUploadID, parts); err != nil {
+		controllers.InternalServerError(c, err, "deployments: failed to complete multipart upload")
+		return false
+	}
+
+	// The multipart upload no longer exists once it has been completed, so it
+	// is forgotten even if the deployment cannot be started, e.g. because
+	// another deployment is in progress, so that it can be completed again.
+	if err := db.Model(bun).Update("multipart_upload_id", gorm.Expr("NULL")).Error; err != nil {
+		controllers.InternalServerError(c, err, "deployments: failed to clear multipart upload ID of raw bundle")
+		return false
+	}
+	bun.MultipartUploadID = nil
+
+	return true
+}
+
+// validateParts returns an error message if the uploaded parts, which are
+// ordered by part number, cannot be assembled into a bundle of at most maxSize
+// bytes.
+func validateParts(parts []*filetransfer.PartInfo, maxSize int64) string {
+	if len(parts) == 0 {
+		return "are required"
+	}
+
+	var total int64
+	for i, p := range parts {
+		if p.PartNumber != int64(i+1) {
+			return "are missing part " + strconv.Itoa(i+1)
+		}
+		if i < len(parts)-1 && p.Size < MinPartSize {
+			return "must be at least 5 MB each, except for the last part"
+		}
+		total += p.Size
+	}
+
+	if total > maxSize {
+		return "are too large in total"
+	}
+	return ""
+}
@@ -0,0 +1,298 @@
+package deployments_test
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/controllers/deployments"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/rawbundle"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/apiserver/server"
+	"github.com/nitrous-io/rise-server/pkg/filetransfer"
+	"github.com/nitrous-io/rise-server/pkg/mqconn"
+	"github.com/nitrous-io/rise-server/pkg/tracker"
+	"github.com/nitrous-io/rise-server/shared/queues"
+	"github.com/nitrous-io/rise-server/shared/s3client"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+	"github.com/nitrous-io/rise-server/testhelper/fake"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Uploads in parts", func() {
+	var (
+		db *gorm.DB
+		mq mqconn.Conn
+
+		s   *httptest.Server
+		res *http.Response
+		err error
+
+		fakeTracker *fake.Tracker
+		origTracker tracker.Trackable
+		memS3       *fake.MemoryS3
+		origS3      filetransfer.FileTransfer
+
+		u *user.User
+		t *oauthtoken.OauthToken
+
+		headers http.Header
+		proj    *project.Project
+		depl    *deployment.Deployment
+		bun     *rawbundle.RawBundle
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+
+		mq, err = mqconn.MQ()
+		Expect(err).To(BeNil())
+
+		origTracker = common.Tracker
+		fakeTracker = &fake.Tracker{}
+		common.Tracker = fakeTracker
+
+		origS3 = s3client.S3
+		memS3 = fake.NewMemoryS3()
+		s3client.S3 = memS3
+
+		testhelper.TruncateTables(db.DB())
+		testhelper.DeleteQueue(mq, queues.All...)
+
+		u, _, t = factories.AuthTrio(db)
+
+		proj = &project.Project{
+			Name:   "foo-bar-express",
+			UserID: u.ID,
+		}
+		Expect(db.Create(proj).Error).To(BeNil())
+
+		headers = http.Header{
+			"Authorization": {"Bearer " + t.Token},
+		}
+
+		s = httptest.NewServer(server.New())
+		res, err = testhelper.MakeRequest("POST", s.URL+"/projects/foo-bar-express/deployment_uploads", nil, headers, nil)
+		Expect(err).To(BeNil())
+		Expect(res.StatusCode).To(Equal(http.StatusCreated))
+
+		depl = &deployment.Deployment{}
+		Expect(db.Last(depl).Error).To(BeNil())
+		bun = &rawbundle.RawBundle{}
+		Expect(db.First(bun, *depl.RawBundleID).Error).To(BeNil())
+	})
+
+	AfterEach(func() {
+		if res != nil {
+			res.Body.Close()
+		}
+		s.Close()
+
+		common.Tracker = origTracker
+		s3client.S3 = origS3
+	})
+
+	uploadPart := func(number int, content []byte) {
+		res.Body.Close()
+
+		req, err := http.NewRequest("PUT", fmt.Sprintf("%s/projects/foo-bar-express/deployments/%d/parts/%d", s.URL, depl.ID, number), bytes.NewReader(content))
+		Expect(err).To(BeNil())
+		req.Header.Set("Authorization", "Bearer "+t.Token)
+
+		res, err = http.DefaultClient.Do(req)
+		Expect(err).To(BeNil())
+	}
+
+	listParts := func() {
+		res.Body.Close()
+
+		res, err = testhelper.MakeRequest("GET", fmt.Sprintf("%s/projects/foo-bar-express/deployments/%d/parts", s.URL, depl.ID), nil, headers, nil)
+		Expect(err).To(BeNil())
+	}
+
+	completeUpload := func() {
+		res.Body.Close()
+
+		res, err = testhelper.MakeRequest("POST", fmt.Sprintf("%s/projects/foo-bar-express/deployments/%d/complete", s.URL, depl.ID), nil, headers, nil)
+		Expect(err).To(BeNil())
+	}
+
+	readBody := func() string {
+		b := &bytes.Buffer{}
+		_, err := b.ReadFrom(res.Body)
+		Expect(err).To(BeNil())
+		return b.String()
+	}
+
+	Describe("PUT /projects/:name/deployments/:id/parts/:number", func() {
+		It("starts a multipart upload and uploads the part", func() {
+			uploadPart(1, []byte("first part"))
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(readBody()).To(MatchJSON(`{
+				"part": {
+					"number": 1,
+					"size": 10
+				}
+			}`))
+
+			Expect(db.First(bun, bun.ID).Error).To(BeNil())
+			Expect(bun.MultipartUploadID).NotTo(BeNil())
+
+			Expect(memS3.UploadPartCalls.Count()).To(Equal(1))
+			call := memS3.UploadPartCalls.NthCall(1)
+			Expect(call.Arguments[2]).To(Equal(bun.UploadedPath))
+			Expect(call.Arguments[3]).To(Equal(*bun.MultipartUploadID))
+			Expect(call.Arguments[4]).To(Equal(int64(1)))
+			Expect(call.SideEffects["uploaded_content"]).To(Equal([]byte("first part")))
+		})
+
+		It("reuses the multipart upload for later parts", func() {
+			uploadPart(2, []byte("second part"))
+			uploadPart(1, []byte("first part"))
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(memS3.CreateMultipartUploadCalls.Count()).To(Equal(1))
+			Expect(memS3.UploadPartCalls.Count()).To(Equal(2))
+		})
+
+		Context("when the part number is out of range", func() {
+			It("returns 422 unprocessable entity", func() {
+				uploadPart(deployments.MaxPartNumber+1, []byte("part"))
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(readBody()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"errors": {
+						"number": "must be between 1 and 10000"
+					}
+				}`))
+				Expect(memS3.CreateMultipartUploadCalls.Count()).To(Equal(0))
+			})
+		})
+
+		Context("when the part is too large", func() {
+			var origMaxPartSize int64
+
+			BeforeEach(func() {
+				origMaxPartSize = deployments.MaxPartSize
+				deployments.MaxPartSize = 4
+			})
+
+			AfterEach(func() {
+				deployments.MaxPartSize = origMaxPartSize
+			})
+
+			It("returns 400 bad request", func() {
+				uploadPart(1, []byte("too large"))
+
+				Expect(res.StatusCode).To(Equal(http.StatusBadRequest))
+				Expect(readBody()).To(MatchJSON(`{
+					"error": "invalid_request",
+					"code": "payload_too_large",
+					"error_description": "request body is too large"
+				}`))
+				Expect(memS3.UploadPartCalls.Count()).To(Equal(0))
+			})
+		})
+
+		Context("when the upload has already been completed", func() {
+			BeforeEach(func() {
+				Expect(depl.UpdateState(db, deployment.StatePendingBuild)).To(Succeed())
+			})
+
+			It("returns 409 conflict", func() {
+				uploadPart(1, []byte("part"))
+
+				Expect(res.StatusCode).To(Equal(http.StatusConflict))
+				Expect(memS3.UploadPartCalls.Count()).To(Equal(0))
+			})
+		})
+	})
+
+	Describe("GET /projects/:name/deployments/:id/parts", func() {
+		It("returns no parts before any have been uploaded", func() {
+			listParts()
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(readBody()).To(MatchJSON(`{"parts": []}`))
+		})
+
+		It("lists the parts that have been uploaded", func() {
+			uploadPart(3, []byte("third"))
+			uploadPart(1, []byte("first part"))
+			listParts()
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(readBody()).To(MatchJSON(`{
+				"parts": [
+					{"number": 1, "size": 10},
+					{"number": 3, "size": 5}
+				]
+			}`))
+		})
+	})
+
+	Describe("POST /projects/:name/deployments/:id/complete", func() {
+		It("assembles the parts and enqueues a build job", func() {
+			first := bytes.Repeat([]byte("a"), int(deployments.MinPartSize))
+			uploadPart(2, []byte("last part"))
+			uploadPart(1, first)
+			completeUpload()
+
+			Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+			Expect(memS3.Content(s3client.BucketName, bun.UploadedPath)).To(Equal(append(first, []byte("last part")...)))
+
+			Expect(db.First(bun, bun.ID).Error).To(BeNil())
+			Expect(bun.MultipartUploadID).To(BeNil())
+
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			Expect(depl.State).To(Equal(deployment.StatePendingBuild))
+
+			d := testhelper.ConsumeQueue(mq, queues.Build)
+			Expect(d).NotTo(BeNil())
+		})
+
+		Context("when a part is missing", func() {
+			It("returns 422 unprocessable entity", func() {
+				uploadPart(2, []byte("last part"))
+				completeUpload()
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(readBody()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"errors": {
+						"parts": "are missing part 1"
+					}
+				}`))
+				Expect(memS3.CompleteMultipartUploadCalls.Count()).To(Equal(0))
+			})
+		})
+
+		Context("when a part other than the last is too small", func() {
+			It("returns 422 unprocessable entity", func() {
+				uploadPart(1, []byte("first part"))
+				uploadPart(2, []byte("last part"))
+				completeUpload()
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(readBody()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"errors": {
+						"parts": "must be at least 5 MB each, except for the last part"
+					}
+				}`))
+			})
+		})
+	})
+})
@@ -0,0 +1,102 @@
+package deployments
+
+import (
+	"net/http"
+	"strconv"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+)
+
+// Pin pins a deployment, e.g. a release, so that it is never deleted to keep
+// the project within its max_deploys_kept and can always be rolled back to.
+func Pin(c *gin.Context) {
+	setPinned(c, true)
+}
+
+// Unpin unpins a deployment, so that it is deleted like other deployments
+// once it is older than the last max_deploys_kept deployments.
+func Unpin(c *gin.Context) {
+	setPinned(c, false)
+}
+
+func setPinned(c *gin.Context, pinned bool) {
+	proj := controllers.CurrentProject(c)
+
+	deploymentID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "deployment could not be found",
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	depl := &deployment.Deployment{}
+	if err := db.Where("id = ? AND project_id = ?", deploymentID, proj.ID).First(depl).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":             "not_found",
+				"error_description": "deployment could not be found",
+			})
+			return
+		}
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	event := "Unpinned Deployment"
+	if pinned {
+		// Only deployments that can be rolled back to are worth keeping.
+		if depl.State != deployment.StateDeployed {
+			c.JSON(422, gin.H{
+				"error":             "invalid_request",
+				"error_description": "only deployed deployments can be pinned",
+			})
+			return
+		}
+
+		event = "Pinned Deployment"
+		err = depl.Pin(db)
+	} else {
+		err = depl.Unpin(db)
+	}
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	{
+		u := controllers.CurrentUser(c)
+
+		var (
+			props = map[string]interface{}{
+				"projectName":       proj.Name,
+				"deploymentId":      depl.ID,
+				"deploymentVersion": depl.Version,
+			}
+			context = controllers.TrackingContext(c)
+		)
+		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
+			log.Errorf("failed to track %q event for user ID %d, err: %v",
+				event, u.ID, err)
+		}
+	}
+
+	deplJSON := depl.AsJSON()
+	deplJSON.Active = proj.ActiveDeploymentID != nil && depl.ID == *proj.ActiveDeploymentID
+	c.JSON(http.StatusOK, gin.H{
+		"deployment": deplJSON,
+	})
+}
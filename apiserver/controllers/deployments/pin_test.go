@@ -0,0 +1,238 @@
+package deployments_test
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/apiserver/server"
+	"github.com/nitrous-io/rise-server/pkg/tracker"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+	"github.com/nitrous-io/rise-server/testhelper/fake"
+	"github.com/nitrous-io/rise-server/testhelper/sharedexamples"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Deployment pins", func() {
+	var (
+		db *gorm.DB
+
+		s   *httptest.Server
+		res *http.Response
+		err error
+
+		fakeTracker *fake.Tracker
+		origTracker tracker.Trackable
+
+		u *user.User
+		t *oauthtoken.OauthToken
+
+		headers http.Header
+		proj    *project.Project
+		depl    *deployment.Deployment
+		deplID  string
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+
+		origTracker = common.Tracker
+		fakeTracker = &fake.Tracker{}
+		common.Tracker = fakeTracker
+
+		testhelper.TruncateTables(db.DB())
+
+		u, _, t = factories.AuthTrio(db)
+
+		proj = &project.Project{
+			Name:   "foo-bar-express",
+			UserID: u.ID,
+		}
+		Expect(db.Create(proj).Error).To(BeNil())
+
+		headers = http.Header{
+			"Authorization": {"Bearer " + t.Token},
+		}
+
+		depl = factories.Deployment(db, proj, u, deployment.StateDeployed)
+		deplID = fmt.Sprint(depl.ID)
+	})
+
+	AfterEach(func() {
+		if res != nil {
+			res.Body.Close()
+		}
+		s.Close()
+
+		common.Tracker = origTracker
+	})
+
+	Describe("POST /projects/:name/deployments/:id/pin", func() {
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("POST", s.URL+"/projects/foo-bar-express/deployments/"+deplID+"/pin", nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("pins the deployment", func() {
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			Expect(depl.PinnedAt).NotTo(BeNil())
+			Expect(*depl.PinnedAt).To(BeTemporally("~", time.Now(), time.Minute))
+
+			pinnedAt, err := depl.PinnedAt.MarshalJSON()
+			Expect(err).To(BeNil())
+			deployedAt, err := depl.DeployedAt.MarshalJSON()
+			Expect(err).To(BeNil())
+
+			Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+				"deployment": {
+					"id": %d,
+					"state": "deployed",
+					"version": %d,
+					"deployed_at": %s,
+					"pinned_at": %s
+				}
+			}`, depl.ID, depl.Version, deployedAt, pinnedAt)))
+		})
+
+		It("tracks a 'Pinned Deployment' event", func() {
+			doRequest()
+
+			trackCall := fakeTracker.TrackCalls.NthCall(1)
+			Expect(trackCall).NotTo(BeNil())
+			Expect(trackCall.Arguments[0]).To(Equal(fmt.Sprintf("%d", u.ID)))
+			Expect(trackCall.Arguments[1]).To(Equal("Pinned Deployment"))
+
+			props, ok := trackCall.Arguments[3].(map[string]interface{})
+			Expect(ok).To(BeTrue())
+			Expect(props["projectName"]).To(Equal(proj.Name))
+			Expect(props["deploymentId"]).To(Equal(depl.ID))
+		})
+
+		Context("when the deployment is already pinned", func() {
+			var pinnedAt time.Time
+
+			BeforeEach(func() {
+				pinnedAt = time.Now().Add(-24 * time.Hour).Round(time.Second)
+				Expect(db.Model(depl).UpdateColumn("pinned_at", pinnedAt).Error).To(BeNil())
+			})
+
+			It("keeps the time it was pinned at", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.PinnedAt.Unix()).To(Equal(pinnedAt.Unix()))
+			})
+		})
+
+		Context("when the deployment has not been deployed", func() {
+			BeforeEach(func() {
+				deplID = fmt.Sprint(factories.Deployment(db, proj, u, deployment.StateDeployFailed).ID)
+			})
+
+			It("returns 422 unprocessable entity", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_request",
+					"error_description": "only deployed deployments can be pinned"
+				}`))
+			})
+		})
+
+		Context("when the deployment belongs to another project", func() {
+			BeforeEach(func() {
+				deplID = fmt.Sprint(factories.Deployment(db, nil, nil, deployment.StateDeployed).ID)
+			})
+
+			It("returns 404 not found", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "not_found",
+					"error_description": "deployment could not be found"
+				}`))
+			})
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+
+	Describe("DELETE /projects/:name/deployments/:id/pin", func() {
+		BeforeEach(func() {
+			Expect(depl.Pin(db)).To(BeNil())
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("DELETE", s.URL+"/projects/foo-bar-express/deployments/"+deplID+"/pin", nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("unpins the deployment", func() {
+			doRequest()
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			Expect(depl.PinnedAt).To(BeNil())
+		})
+
+		It("tracks an 'Unpinned Deployment' event", func() {
+			doRequest()
+
+			trackCall := fakeTracker.TrackCalls.NthCall(1)
+			Expect(trackCall).NotTo(BeNil())
+			Expect(trackCall.Arguments[1]).To(Equal("Unpinned Deployment"))
+		})
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+})
@@ -0,0 +1,132 @@
+package deployments
+
+import (
+	"net/http"
+	"strconv"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/outboxjob"
+	"github.com/nitrous-io/rise-server/pkg/job"
+	"github.com/nitrous-io/rise-server/shared/messages"
+	"github.com/nitrous-io/rise-server/shared/queues"
+)
+
+// Promote makes a preview deployment the active deployment of the project, so
+// that it is served at the domains of the project. Its files are not uploaded
+// again, as with a rollback, and it is still served at its preview domain.
+func Promote(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	deploymentID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "deployment could not be found",
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	depl := &deployment.Deployment{}
+	if err := db.Where("id = ? AND project_id = ?", deploymentID, proj.ID).First(depl).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":             "not_found",
+				"error_description": "deployment could not be found",
+			})
+			return
+		}
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if !depl.Preview {
+		c.JSON(422, gin.H{
+			"error":             "invalid_request",
+			"error_description": "only preview deployments can be promoted",
+		})
+		return
+	}
+
+	if depl.State != deployment.StateDeployed {
+		c.JSON(422, gin.H{
+			"error":             "invalid_request",
+			"error_description": "the preview deployment has not been deployed",
+		})
+		return
+	}
+
+	j, err := job.NewWithJSON(queues.Deploy, &messages.DeployJobData{
+		DeploymentID:      depl.ID,
+		SkipWebrootUpload: true,
+	})
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	tx, err := dbconn.Begin(controllers.Context(c))
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+	defer tx.Rollback()
+
+	ob, err := outboxjob.Add(tx, j)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	// The deployer only activates deployments that are not previews.
+	if err := tx.Model(deployment.Deployment{}).Where("id = ?", depl.ID).UpdateColumn("preview", false).Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+	depl.Preview = false
+
+	if err := depl.UpdateState(tx, deployment.StatePendingRollback); err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	outboxjob.DeliverAll(db, ob)
+
+	{
+		u := controllers.CurrentUser(c)
+
+		var (
+			event = "Promoted Preview Deployment"
+			props = map[string]interface{}{
+				"projectName":       proj.Name,
+				"deploymentId":      depl.ID,
+				"deploymentVersion": depl.Version,
+			}
+			context = controllers.TrackingContext(c)
+		)
+		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
+			log.Errorf("failed to track %q event for user ID %d, err: %v",
+				event, u.ID, err)
+		}
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"deployment": depl.AsJSON(),
+	})
+}
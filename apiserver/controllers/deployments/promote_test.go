@@ -0,0 +1,229 @@
+package deployments_test
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/apiserver/server"
+	"github.com/nitrous-io/rise-server/pkg/mqconn"
+	"github.com/nitrous-io/rise-server/pkg/tracker"
+	"github.com/nitrous-io/rise-server/shared/queues"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+	"github.com/nitrous-io/rise-server/testhelper/fake"
+	"github.com/nitrous-io/rise-server/testhelper/sharedexamples"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Deployment promotion", func() {
+	var (
+		db *gorm.DB
+		mq mqconn.Conn
+
+		s   *httptest.Server
+		res *http.Response
+		err error
+
+		fakeTracker *fake.Tracker
+		origTracker tracker.Trackable
+
+		u *user.User
+		t *oauthtoken.OauthToken
+
+		headers http.Header
+		proj    *project.Project
+		depl    *deployment.Deployment
+		deplID  string
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+
+		mq, err = mqconn.MQ()
+		Expect(err).To(BeNil())
+		testhelper.DeleteQueue(mq, queues.All...)
+
+		origTracker = common.Tracker
+		fakeTracker = &fake.Tracker{}
+		common.Tracker = fakeTracker
+
+		testhelper.TruncateTables(db.DB())
+
+		u, _, t = factories.AuthTrio(db)
+
+		proj = &project.Project{
+			Name:   "foo-bar-express",
+			UserID: u.ID,
+		}
+		Expect(db.Create(proj).Error).To(BeNil())
+
+		headers = http.Header{
+			"Authorization": {"Bearer " + t.Token},
+		}
+
+		active := factories.Deployment(db, proj, u, deployment.StateDeployed)
+		Expect(db.Model(proj).UpdateColumn("active_deployment_id", active.ID).Error).To(BeNil())
+
+		depl = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+			Prefix:  "0123456789abcdef",
+			State:   deployment.StateDeployed,
+			Preview: true,
+		})
+		deplID = fmt.Sprint(depl.ID)
+	})
+
+	AfterEach(func() {
+		if res != nil {
+			res.Body.Close()
+		}
+		s.Close()
+
+		common.Tracker = origTracker
+	})
+
+	Describe("POST /projects/:name/deployments/:id/promote", func() {
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("POST", s.URL+"/projects/foo-bar-express/deployments/"+deplID+"/promote", nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("returns 202 accepted and marks the deployment as pending rollback", func() {
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			Expect(depl.Preview).To(BeFalse())
+			Expect(depl.State).To(Equal(deployment.StatePendingRollback))
+
+			Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+			Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+				"deployment": {
+					"id": %d,
+					"state": "pending_rollback",
+					"version": %d
+				}
+			}`, depl.ID, depl.Version)))
+		})
+
+		It("enqueues a deploy job that does not upload the files again", func() {
+			doRequest()
+
+			d := testhelper.ConsumeQueue(mq, queues.Deploy)
+			Expect(d).NotTo(BeNil())
+			Expect(d.Body).To(MatchJSON(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"skip_webroot_upload": true,
+				"skip_invalidation": false,
+				"use_raw_bundle": false
+			}`, depl.ID)))
+		})
+
+		It("tracks a 'Promoted Preview Deployment' event", func() {
+			doRequest()
+
+			trackCall := fakeTracker.TrackCalls.NthCall(1)
+			Expect(trackCall).NotTo(BeNil())
+			Expect(trackCall.Arguments[0]).To(Equal(fmt.Sprintf("%d", u.ID)))
+			Expect(trackCall.Arguments[1]).To(Equal("Promoted Preview Deployment"))
+
+			props, ok := trackCall.Arguments[3].(map[string]interface{})
+			Expect(ok).To(BeTrue())
+			Expect(props["projectName"]).To(Equal(proj.Name))
+			Expect(props["deploymentId"]).To(Equal(depl.ID))
+		})
+
+		Context("when the deployment is not a preview", func() {
+			BeforeEach(func() {
+				Expect(db.Model(depl).UpdateColumn("preview", false).Error).To(BeNil())
+			})
+
+			It("returns 422 unprocessable entity", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_request",
+					"error_description": "only preview deployments can be promoted"
+				}`))
+				Expect(testhelper.ConsumeQueue(mq, queues.Deploy)).To(BeNil())
+			})
+		})
+
+		Context("when the preview has not been deployed", func() {
+			BeforeEach(func() {
+				Expect(db.Model(depl).UpdateColumn("state", deployment.StatePendingDeploy).Error).To(BeNil())
+			})
+
+			It("returns 422 unprocessable entity", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_request",
+					"error_description": "the preview deployment has not been deployed"
+				}`))
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.Preview).To(BeTrue())
+			})
+		})
+
+		Context("when the deployment does not belong to the project", func() {
+			BeforeEach(func() {
+				proj2 := factories.Project(db, u)
+				depl2 := factories.Deployment(db, proj2, u, deployment.StateDeployed)
+				deplID = fmt.Sprint(depl2.ID)
+			})
+
+			It("returns 404 not found", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+			})
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItLocksProject(func() (*gorm.DB, *project.Project) {
+			return db, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+})
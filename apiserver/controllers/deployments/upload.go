@@ -0,0 +1,288 @@
+package deployments
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/rawbundle"
+	"github.com/nitrous-io/rise-server/pkg/rootdir"
+	"github.com/nitrous-io/rise-server/shared/s3client"
+)
+
+// uploadExpiryDuration is how long the presigned URL of a direct upload is
+// valid for. It is long enough for large bundles to be uploaded over slow
+// connections.
+const uploadExpiryDuration = 1 * time.Hour
+
+// CreateUpload creates a deployment whose raw bundle is uploaded directly to
+// S3 with the presigned URL in the response, so that large bundles do not tie
+// up API workers. The deployment is started by CompleteUpload once the bundle
+// has been uploaded.
+func CreateUpload(c *gin.Context) {
+	u := controllers.CurrentUser(c)
+	proj := controllers.CurrentProject(c)
+
+	archiveFormat := c.PostForm("archive_format")
+	if archiveFormat == "" {
+		archiveFormat = "tar.gz"
+	}
+
+	errs := map[string]string{}
+	if archiveFormat != "tar.gz" && archiveFormat != "zip" {
+		errs["archive_format"] = "is invalid"
+	}
+
+	rootDir, err := rootdir.Clean(c.PostForm("root_dir"))
+	if err != nil {
+		errs["root_dir"] = "is invalid"
+	}
+
+	depl := &deployment.Deployment{
+		ProjectID: proj.ID,
+		UserID:    u.ID,
+		RootDir:   rootDir,
+		Source:    controllers.DeploymentSource(c),
+		Settings:  proj.DeploymentDefaults,
+	}
+
+	for name, msg := range setVCSParams(depl, c.PostForm) {
+		errs[name] = msg
+	}
+
+	if len(errs) > 0 {
+		c.JSON(422, gin.H{
+			"error":  "invalid_params",
+			"errors": errs,
+		})
+		return
+	}
+
+	tx, err := dbconn.Begin(controllers.Context(c))
+	if err != nil {
+		controllers.InternalServerError(c, err, "deployments: failed to begin a transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	// Fail before the bundle is uploaded. The check is repeated when the
+	// upload is completed.
+	if proj.DeployConcurrency == project.DeployConcurrencyReject {
+		inFlight, err := deployment.InFlight(tx, proj.ID)
+		if err != nil {
+			controllers.InternalServerError(c, err, "deployments: failed to find a deployment in flight")
+			return
+		}
+		if inFlight != nil {
+			respondInFlight(c, inFlight)
+			return
+		}
+	}
+
+	if proj.ActiveDeploymentID != nil {
+		var prevDepl deployment.Deployment
+		if err := tx.Where("id = ?", proj.ActiveDeploymentID).First(&prevDepl).Error; err != nil {
+			controllers.InternalServerError(c, err, "deployments: failed to fetch a previous deployment")
+			return
+		}
+
+		depl.CopyJsEnvVars(&prevDepl)
+	}
+
+	if preview, _ := strconv.ParseBool(c.PostForm("preview")); preview {
+		if err := makePreview(depl); err != nil {
+			controllers.InternalServerError(c, err, "deployments: failed to generate a preview prefix")
+			return
+		}
+	}
+
+	if protect, _ := strconv.ParseBool(c.PostForm("preview_basic_auth")); protect {
+		if !protectPreview(c, depl) {
+			return
+		}
+	}
+
+	ver, err := proj.NextVersion(tx)
+	if err != nil {
+		controllers.InternalServerError(c, err, "deployments: failed to get next deployment version number")
+		return
+	}
+
+	depl.Version = ver
+	if err := tx.Create(depl).Error; err != nil {
+		controllers.InternalServerError(c, err, "deployments: failed to create a deployment record in DB")
+		return
+	}
+
+	// The raw bundle is recorded up front, so that CompleteUpload knows where
+	// the bundle was uploaded to and in which format.
+	bun := &rawbundle.RawBundle{
+		ProjectID:    proj.ID,
+		UploadedPath: "deployments/" + depl.PrefixID() + "/raw-bundle." + archiveFormat,
+	}
+	if err := tx.Create(bun).Error; err != nil {
+		controllers.InternalServerError(c, err, "deployments: failed to create a raw bundle record in DB")
+		return
+	}
+
+	if err := tx.Model(depl).Update("raw_bundle_id", bun.ID).Error; err != nil {
+		controllers.InternalServerError(c, err, "deployments: failed to update raw bundle of deployment")
+		return
+	}
+	depl.RawBundleID = &bun.ID
+
+	maxSize, err := uploadSizeLimit(tx, proj, u)
+	if err != nil {
+		controllers.InternalServerError(c, err, "deployments: failed to find the upload size limit")
+		return
+	}
+
+	uploadURL, err := s3client.PresignedPutURL(bun.UploadedPath, uploadExpiryDuration)
+	if err != nil {
+		controllers.InternalServerError(c, err, "deployments: failed to generate presigned upload URL")
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		controllers.InternalServerError(c, err, "deployments: failed to commit a transaction")
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"deployment": depl.AsJSON(),
+		"upload": gin.H{
+			"method":     "PUT",
+			"url":        uploadURL,
+			"expires_at": time.Now().Add(uploadExpiryDuration).UTC().Format(time.RFC3339),
+			"max_size":   maxSize,
+		},
+	})
+}
+
+// CompleteUpload starts a deployment created by CreateUpload once its raw
+// bundle has been uploaded to S3, either with the presigned URL or in parts
+// with UploadPart.
+func CompleteUpload(c *gin.Context) {
+	start := time.Now()
+
+	u := controllers.CurrentUser(c)
+	proj := controllers.CurrentProject(c)
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err, "deployments: failed to get a db connection")
+		return
+	}
+
+	tx, err := dbconn.Begin(controllers.Context(c))
+	if err != nil {
+		controllers.InternalServerError(c, err, "deployments: failed to begin a transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	depl, bun, ok := findPendingUpload(c, tx, proj)
+	if !ok {
+		return
+	}
+
+	// Bundles that were uploaded in parts are assembled first.
+	if bun.MultipartUploadID != nil {
+		maxSize, err := uploadSizeLimit(tx, proj, u)
+		if err != nil {
+			controllers.InternalServerError(c, err, "deployments: failed to find the upload size limit")
+			return
+		}
+		if !completeMultipartUpload(c, db, bun, maxSize) {
+			return
+		}
+	}
+
+	exists, err := s3client.Exists(bun.UploadedPath)
+	if err != nil {
+		controllers.InternalServerError(c, err, "deployments: failed to check if raw bundle exists in S3")
+		return
+	}
+
+	if !exists {
+		c.JSON(422, gin.H{
+			"error": "invalid_params",
+			"errors": map[string]string{
+				"bundle": "has not been uploaded",
+			},
+		})
+		return
+	}
+
+	archiveFormat := "tar.gz"
+	if strings.HasSuffix(bun.UploadedPath, ".zip") {
+		archiveFormat = "zip"
+	}
+
+	if err := depl.RecordDuration(tx, deployment.PhaseUpload, start.Sub(depl.CreatedAt)); err != nil {
+		controllers.InternalServerError(c, err, "deployments: failed to record upload duration")
+		return
+	}
+
+	startDeployment(c, db, tx, u, proj, depl, archiveFormat)
+}
+
+// findPendingUpload finds and locks the deployment of the current project
+// that is uploaded directly to S3 and has not been completed, along with its
+// raw bundle. It responds with an error and returns false if there is none.
+// The lock ensures that the deployment is only started once if the upload is
+// completed more than once at the same time.
+func findPendingUpload(c *gin.Context, tx *gorm.DB, proj *project.Project) (*deployment.Deployment, *rawbundle.RawBundle, bool) {
+	deploymentID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "deployment could not be found",
+		})
+		return nil, nil, false
+	}
+
+	if err := tx.Exec("SELECT id FROM deployments WHERE id = ? FOR UPDATE", deploymentID).Error; err != nil {
+		controllers.InternalServerError(c, err, "deployments: failed to lock the deployment")
+		return nil, nil, false
+	}
+
+	depl := &deployment.Deployment{}
+	if err := tx.Where("id = ? AND project_id = ?", deploymentID, proj.ID).First(depl).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":             "not_found",
+				"error_description": "deployment could not be found",
+			})
+			return nil, nil, false
+		}
+		controllers.InternalServerError(c, err, "deployments: failed to fetch a deployment")
+		return nil, nil, false
+	}
+
+	// Deployments that were created by Create have their state changed as
+	// soon as their bundle is uploaded, so only direct uploads that have not
+	// been completed are still pending upload.
+	if depl.State != deployment.StatePendingUpload || depl.RawBundleID == nil {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":             "conflict",
+			"error_description": "deployment is not pending upload",
+		})
+		return nil, nil, false
+	}
+
+	bun := &rawbundle.RawBundle{}
+	if err := tx.First(bun, *depl.RawBundleID).Error; err != nil {
+		controllers.InternalServerError(c, err, "deployments: failed to fetch a raw bundle")
+		return nil, nil, false
+	}
+
+	return depl, bun, true
+}
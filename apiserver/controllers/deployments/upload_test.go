@@ -0,0 +1,320 @@
+package deployments_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/rawbundle"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/apiserver/server"
+	"github.com/nitrous-io/rise-server/pkg/filetransfer"
+	"github.com/nitrous-io/rise-server/pkg/mqconn"
+	"github.com/nitrous-io/rise-server/pkg/tracker"
+	"github.com/nitrous-io/rise-server/shared/queues"
+	"github.com/nitrous-io/rise-server/shared/s3client"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+	"github.com/nitrous-io/rise-server/testhelper/fake"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Direct uploads", func() {
+	var (
+		db *gorm.DB
+		mq mqconn.Conn
+
+		s   *httptest.Server
+		res *http.Response
+		err error
+
+		fakeTracker *fake.Tracker
+		origTracker tracker.Trackable
+		fakeS3      *fake.S3
+		origS3      filetransfer.FileTransfer
+
+		u *user.User
+		t *oauthtoken.OauthToken
+
+		headers http.Header
+		proj    *project.Project
+		params  url.Values
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+
+		mq, err = mqconn.MQ()
+		Expect(err).To(BeNil())
+
+		origTracker = common.Tracker
+		fakeTracker = &fake.Tracker{}
+		common.Tracker = fakeTracker
+
+		origS3 = s3client.S3
+		fakeS3 = &fake.S3{}
+		s3client.S3 = fakeS3
+
+		testhelper.TruncateTables(db.DB())
+		testhelper.DeleteQueue(mq, queues.All...)
+
+		u, _, t = factories.AuthTrio(db)
+
+		proj = &project.Project{
+			Name:   "foo-bar-express",
+			UserID: u.ID,
+		}
+		Expect(db.Create(proj).Error).To(BeNil())
+
+		headers = http.Header{
+			"Authorization": {"Bearer " + t.Token},
+		}
+		params = url.Values{}
+	})
+
+	AfterEach(func() {
+		if res != nil {
+			res.Body.Close()
+		}
+		s.Close()
+
+		common.Tracker = origTracker
+		s3client.S3 = origS3
+	})
+
+	doRequest := func(method, path string) {
+		s = httptest.NewServer(server.New())
+		res, err = testhelper.MakeRequest(method, s.URL+path, params, headers, nil)
+		Expect(err).To(BeNil())
+	}
+
+	Describe("POST /projects/:name/deployment_uploads", func() {
+		BeforeEach(func() {
+			fakeS3.PresignedPutURLReturn = "https://s3-us-west-2.amazonaws.com/deployments/a1b2-1/raw-bundle.zip?abc=123"
+			params.Set("archive_format", "zip")
+			params.Set("root_dir", "dist")
+		})
+
+		It("creates a deployment that is pending upload and returns a presigned upload URL", func() {
+			doRequest("POST", "/projects/foo-bar-express/deployment_uploads")
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+			Expect(res.StatusCode).To(Equal(http.StatusCreated))
+
+			depl := &deployment.Deployment{}
+			Expect(db.Last(depl).Error).To(BeNil())
+			Expect(depl.State).To(Equal(deployment.StatePendingUpload))
+			Expect(depl.RootDir).To(Equal("dist"))
+			Expect(depl.RawBundleID).NotTo(BeNil())
+
+			bun := &rawbundle.RawBundle{}
+			Expect(db.First(bun, *depl.RawBundleID).Error).To(BeNil())
+			Expect(bun.ProjectID).To(Equal(proj.ID))
+			Expect(bun.UploadedPath).To(Equal(fmt.Sprintf("deployments/%s-%d/raw-bundle.zip", depl.Prefix, depl.ID)))
+
+			Expect(fakeS3.PresignedPutURLCalls.Count()).To(Equal(1))
+			call := fakeS3.PresignedPutURLCalls.NthCall(1)
+			Expect(call.Arguments[0]).To(Equal(s3client.BucketRegion))
+			Expect(call.Arguments[1]).To(Equal(s3client.BucketName))
+			Expect(call.Arguments[2]).To(Equal(bun.UploadedPath))
+
+			var j struct {
+				Deployment struct {
+					ID    uint   `json:"id"`
+					State string `json:"state"`
+				} `json:"deployment"`
+				Upload struct {
+					Method  string `json:"method"`
+					URL     string `json:"url"`
+					MaxSize int64  `json:"max_size"`
+				} `json:"upload"`
+			}
+			Expect(json.Unmarshal(b.Bytes(), &j)).To(BeNil())
+			Expect(j.Deployment.ID).To(Equal(depl.ID))
+			Expect(j.Deployment.State).To(Equal(deployment.StatePendingUpload))
+			Expect(j.Upload.Method).To(Equal("PUT"))
+			Expect(j.Upload.URL).To(Equal(fakeS3.PresignedPutURLReturn))
+			Expect(j.Upload.MaxSize).To(Equal(s3client.MaxUploadSize))
+		})
+
+		Context("when the plan of the owner has a different upload size limit", func() {
+			BeforeEach(func() {
+				Expect(db.Model(u).Update("max_upload_size", int64(5*1024*1024*1024)).Error).To(BeNil())
+			})
+
+			It("returns the limit of the owner as the max size", func() {
+				doRequest("POST", "/projects/foo-bar-express/deployment_uploads")
+
+				Expect(res.StatusCode).To(Equal(http.StatusCreated))
+
+				var j struct {
+					Upload struct {
+						MaxSize int64 `json:"max_size"`
+					} `json:"upload"`
+				}
+				Expect(json.NewDecoder(res.Body).Decode(&j)).To(BeNil())
+				Expect(j.Upload.MaxSize).To(Equal(int64(5 * 1024 * 1024 * 1024)))
+			})
+		})
+
+		It("does not enqueue a job", func() {
+			doRequest("POST", "/projects/foo-bar-express/deployment_uploads")
+
+			Expect(res.StatusCode).To(Equal(http.StatusCreated))
+			Expect(testhelper.ConsumeQueue(mq, queues.Build)).To(BeNil())
+			Expect(testhelper.ConsumeQueue(mq, queues.Deploy)).To(BeNil())
+		})
+
+		Context("when the params are invalid", func() {
+			BeforeEach(func() {
+				params.Set("archive_format", "rar")
+				params.Set("root_dir", "../etc")
+			})
+
+			It("returns 422 unprocessable entity", func() {
+				doRequest("POST", "/projects/foo-bar-express/deployment_uploads")
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"errors": {
+						"archive_format": "is invalid",
+						"root_dir": "is invalid"
+					}
+				}`))
+
+				var count int
+				Expect(db.Model(deployment.Deployment{}).Count(&count).Error).To(BeNil())
+				Expect(count).To(Equal(0))
+			})
+		})
+	})
+
+	Describe("POST /projects/:name/deployments/:id/complete", func() {
+		var depl *deployment.Deployment
+
+		BeforeEach(func() {
+			fakeS3.ExistsReturn = true
+
+			doRequest("POST", "/projects/foo-bar-express/deployment_uploads")
+			Expect(res.StatusCode).To(Equal(http.StatusCreated))
+			res.Body.Close()
+			s.Close()
+
+			depl = &deployment.Deployment{}
+			Expect(db.Last(depl).Error).To(BeNil())
+		})
+
+		completeUpload := func() {
+			doRequest("POST", fmt.Sprintf("/projects/foo-bar-express/deployments/%d/complete", depl.ID))
+		}
+
+		It("verifies that the bundle was uploaded and enqueues a build job", func() {
+			completeUpload()
+
+			Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+
+			Expect(fakeS3.ExistsCalls.Count()).To(Equal(1))
+			call := fakeS3.ExistsCalls.NthCall(1)
+			Expect(call.Arguments[2]).To(Equal(fmt.Sprintf("deployments/%s-%d/raw-bundle.tar.gz", depl.Prefix, depl.ID)))
+
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			Expect(depl.State).To(Equal(deployment.StatePendingBuild))
+			Expect(depl.UploadDurationMs).NotTo(BeNil())
+
+			d := testhelper.ConsumeQueue(mq, queues.Build)
+			Expect(d).NotTo(BeNil())
+			Expect(d.Body).To(MatchJSON(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+		})
+
+		It("tracks an 'Initiated Project Deployment' event", func() {
+			completeUpload()
+
+			trackCall := fakeTracker.TrackCalls.NthCall(1)
+			Expect(trackCall).NotTo(BeNil())
+			Expect(trackCall.Arguments[1]).To(Equal("Initiated Project Deployment"))
+
+			props, ok := trackCall.Arguments[3].(map[string]interface{})
+			Expect(ok).To(BeTrue())
+			Expect(props["deploymentId"]).To(Equal(depl.ID))
+		})
+
+		Context("when the bundle has not been uploaded", func() {
+			BeforeEach(func() {
+				fakeS3.ExistsReturn = false
+			})
+
+			It("returns 422 unprocessable entity", func() {
+				completeUpload()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"errors": {
+						"bundle": "has not been uploaded"
+					}
+				}`))
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StatePendingUpload))
+			})
+		})
+
+		Context("when the upload has already been completed", func() {
+			BeforeEach(func() {
+				Expect(db.Model(depl).Update("state", deployment.StatePendingBuild).Error).To(BeNil())
+			})
+
+			It("returns 409 conflict", func() {
+				completeUpload()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusConflict))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "conflict",
+					"error_description": "deployment is not pending upload"
+				}`))
+				Expect(testhelper.ConsumeQueue(mq, queues.Build)).To(BeNil())
+			})
+		})
+
+		Context("when the deployment belongs to another project", func() {
+			BeforeEach(func() {
+				depl = factories.Deployment(db, nil, nil, deployment.StatePendingUpload)
+			})
+
+			It("returns 404 not found", func() {
+				completeUpload()
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+			})
+		})
+	})
+})
@@ -0,0 +1,86 @@
+package deployments
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+)
+
+// vcsParams are the params that describe the commit that is deployed, e.g. by
+// CI. They are optional.
+var vcsParams = []string{"commit_sha", "commit_message", "branch"}
+
+// maxVCSParamSize is the number of bytes read of a VCS param sent as a part of
+// a multipart request, which is enough for the longest commit message in
+// multibyte characters.
+const maxVCSParamSize = deployment.MaxCommitMessageLength*utf8.UTFMax + 1
+
+// isVCSParam returns whether name is one of vcsParams.
+func isVCSParam(name string) bool {
+	for _, p := range vcsParams {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+// setVCSParam sets the VCS metadata of depl from the value of a VCS param, and
+// returns why the value is invalid if it is. Commit SHAs may be abbreviated,
+// but have to be at least 7 hex characters long.
+func setVCSParam(depl *deployment.Deployment, name, value string) string {
+	switch name {
+	case "commit_sha":
+		sha := strings.ToLower(strings.TrimSpace(value))
+		if sha != "" && (len(sha) < 7 || len(sha) > 64 || !isHex(sha)) {
+			return "is invalid"
+		}
+		depl.CommitSHA = sha
+
+	case "commit_message":
+		msg := strings.TrimSpace(value)
+		if !utf8.ValidString(msg) {
+			return "is invalid"
+		}
+		if utf8.RuneCountInString(msg) > deployment.MaxCommitMessageLength {
+			msg = string([]rune(msg)[:deployment.MaxCommitMessageLength])
+		}
+		depl.CommitMessage = msg
+
+	case "branch":
+		branch := strings.TrimPrefix(strings.TrimSpace(value), "refs/heads/")
+		if utf8.RuneCountInString(branch) > deployment.MaxBranchLength {
+			return "is too long (max. " + strconv.Itoa(deployment.MaxBranchLength) + " characters)"
+		}
+		if strings.ContainsAny(branch, " \t\n~^:?*[\\") {
+			return "is invalid"
+		}
+		depl.Branch = branch
+	}
+
+	return ""
+}
+
+// setVCSParams sets the VCS metadata of depl from form params, and returns the
+// errors of the params that are invalid.
+func setVCSParams(depl *deployment.Deployment, postForm func(string) string) map[string]string {
+	errs := map[string]string{}
+	for _, name := range vcsParams {
+		if msg := setVCSParam(depl, name, postForm(name)); msg != "" {
+			errs[name] = msg
+		}
+	}
+	return errs
+}
+
+// isHex returns whether s only has lowercase hex characters.
+func isHex(s string) bool {
+	for _, r := range s {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+	return true
+}
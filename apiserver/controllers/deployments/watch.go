@@ -0,0 +1,148 @@
+package deployments
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/deploywatch"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+)
+
+var (
+	// MaxWait is the longest a request to Show may wait for the state of a
+	// deployment to change.
+	MaxWait = time.Minute
+
+	// MaxStreamDuration is how long Events streams state changes before the
+	// client has to reconnect.
+	MaxStreamDuration = 5 * time.Minute
+)
+
+// waitDuration parses the wait query param, which is a number of seconds, and
+// caps it at MaxWait.
+func waitDuration(s string) time.Duration {
+	secs, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0
+	}
+
+	wait := time.Duration(secs) * time.Second
+	if wait > MaxWait {
+		return MaxWait
+	}
+	return wait
+}
+
+// waitForChange waits until the state of depl is no longer what it was when
+// it was loaded, or until the timeout or the client goes away, and returns
+// the deployment as it is then.
+func waitForChange(c *gin.Context, db *gorm.DB, depl *deployment.Deployment, changes <-chan *deployment.StateChange, timeout time.Duration) (*deployment.Deployment, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case change := <-changes:
+			if change.Progress != nil || change.State == depl.State {
+				continue
+			}
+		case <-timer.C:
+			return depl, nil
+		case <-c.Writer.CloseNotify():
+			return depl, nil
+		}
+
+		// Notifications are only hints, the deployment is always reloaded.
+		reloaded := &deployment.Deployment{}
+		if err := db.First(reloaded, depl.ID).Error; err != nil {
+			return nil, err
+		}
+		if reloaded.State != depl.State {
+			return reloaded, nil
+		}
+	}
+}
+
+// Events streams the state of a deployment as server-sent events until it has
+// finished, along with the progress messages that workers publish while it is
+// being built and deployed.
+func Events(c *gin.Context) {
+	deploymentID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "deployment could not be found",
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	// Subscribe before loading the deployment so that no change is missed.
+	changes, cancel := deploywatch.Default.Subscribe(uint(deploymentID))
+	defer cancel()
+
+	proj := controllers.CurrentProject(c)
+
+	depl := &deployment.Deployment{}
+	if err := db.Where("project_id = ?", proj.ID).First(depl, deploymentID).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":             "not_found",
+				"error_description": "deployment could not be found",
+			})
+			return
+		}
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	c.Header("X-Accel-Buffering", "no")
+	c.SSEvent("state", depl.AsJSON())
+
+	timeout := time.NewTimer(MaxStreamDuration)
+	defer timeout.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		if depl.Finished() {
+			return false
+		}
+
+		select {
+		case change := <-changes:
+			if change.Progress != nil {
+				c.SSEvent("progress", change.Progress)
+				return true
+			}
+		case <-timeout.C:
+			return false
+		case <-c.Writer.CloseNotify():
+			return false
+		}
+
+		reloaded := &deployment.Deployment{}
+		if err := db.First(reloaded, depl.ID).Error; err != nil {
+			c.SSEvent("error", gin.H{
+				"error":             "internal_server_error",
+				"error_description": "deployment could not be reloaded",
+			})
+			return false
+		}
+
+		if reloaded.State != depl.State {
+			depl = reloaded
+			c.SSEvent("state", depl.AsJSON())
+		}
+		return true
+	})
+}
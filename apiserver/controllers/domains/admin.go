@@ -0,0 +1,256 @@
+package domains
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/domain"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/pkg/job"
+	"github.com/nitrous-io/rise-server/shared/emails"
+	"github.com/nitrous-io/rise-server/shared/invalidation"
+	"github.com/nitrous-io/rise-server/shared/messages"
+	"github.com/nitrous-io/rise-server/shared/queues"
+	"github.com/nitrous-io/rise-server/shared/s3client"
+)
+
+type adminDomainJSON struct {
+	Name            string     `json:"name"`
+	ProjectName     string     `json:"project_name"`
+	State           string     `json:"state"`
+	DNSCheckedAt    *time.Time `json:"dns_checked_at"`
+	DNSFailingSince *time.Time `json:"dns_failing_since"`
+	DisabledAt      *time.Time `json:"disabled_at"`
+	DisabledReason  *string    `json:"disabled_reason"`
+}
+
+func adminJSON(dom *domain.Domain, proj *project.Project) *adminDomainJSON {
+	return &adminDomainJSON{
+		Name:            dom.Name,
+		ProjectName:     proj.Name,
+		State:           dom.State,
+		DNSCheckedAt:    dom.DNSCheckedAt,
+		DNSFailingSince: dom.DNSFailingSince,
+		DisabledAt:      dom.DisabledAt,
+		DisabledReason:  dom.DisabledReason,
+	}
+}
+
+// findDomain finds the domain with the name in the URL and its project, and
+// responds with 404 if there is none.
+func findDomain(c *gin.Context, db *gorm.DB) (*domain.Domain, *project.Project, bool) {
+	name := strings.ToLower(strings.TrimSpace(c.Param("name")))
+
+	dom := &domain.Domain{}
+	if err := db.Where("name = ?", name).First(dom).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":             "not_found",
+				"error_description": "domain could not be found",
+			})
+			return nil, nil, false
+		}
+		controllers.InternalServerError(c, err)
+		return nil, nil, false
+	}
+
+	proj := &project.Project{}
+	if err := db.First(proj, dom.ProjectID).Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return nil, nil, false
+	}
+
+	return dom, proj, true
+}
+
+// ListFailing lists the active domains whose DNS records no longer point to
+// PubStorm, and the disabled domains, longest failing first, so that admins
+// can follow up on them before or after they are disabled.
+func ListFailing(c *gin.Context) {
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	doms, err := domain.FindFailingDNS(db)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	domsJSON := make([]*adminDomainJSON, 0, len(doms))
+	for _, dom := range doms {
+		proj := &project.Project{}
+		if err := db.First(proj, dom.ProjectID).Error; err != nil {
+			if err == gorm.RecordNotFound {
+				continue
+			}
+			controllers.InternalServerError(c, err)
+			return
+		}
+		domsJSON = append(domsJSON, adminJSON(dom, proj))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"domains": domsJSON,
+	})
+}
+
+// Reverify checks the DNS records of a domain now instead of waiting for the
+// verify-domains job, and records the result. It does not change the state of
+// the domain.
+func Reverify(c *gin.Context) {
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	dom, proj, ok := findDomain(c, db)
+	if !ok {
+		return
+	}
+
+	pointsTo := dom.PointsTo(proj.DefaultDomainName())
+	if err := dom.RecordDNSCheck(db, pointsTo); err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"domain":             adminJSON(dom, proj),
+		"points_to_pubstorm": pointsTo,
+	})
+}
+
+// Disable stops serving a domain, e.g. while its ownership is disputed, and
+// notifies the owner of its project. Domains disabled by admins are not
+// enabled again by the verify-domains job.
+func Disable(c *gin.Context) {
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	dom, proj, ok := findDomain(c, db)
+	if !ok {
+		return
+	}
+
+	if dom.State == domain.StateDisabled {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":             "already_disabled",
+			"error_description": "domain is already disabled",
+		})
+		return
+	}
+
+	if err := s3client.Delete("domains/" + dom.Name + "/meta.json"); err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if err := invalidation.Invalidate([]string{dom.Name}); err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if _, err := dom.Disable(db, domain.DisabledReasonAdmin); err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	log.Infof("domain %q of project %d was disabled by an admin", dom.Name, proj.ID)
+
+	u := &user.User{}
+	if err := db.First(u, proj.UserID).Error; err != nil {
+		log.Errorf("failed to find owner of project %d, err: %v", proj.ID, err)
+	} else {
+		if err := common.SendTemplatedMail([]string{u.Email}, emails.DomainDisabled, &emails.DomainDisabledData{
+			ProjectName: proj.Name,
+			DomainName:  dom.Name,
+			ByAdmin:     true,
+		}); err != nil {
+			log.Errorf("failed to send domain disabled email for domain %q, err: %v", dom.Name, err)
+		}
+
+		var (
+			event = "Disabled Custom Domain"
+			props = map[string]interface{}{
+				"projectName": proj.Name,
+				"domain":      dom.Name,
+				"reason":      domain.DisabledReasonAdmin,
+			}
+			context map[string]interface{}
+		)
+		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
+			log.Errorf("failed to track %q event for user ID %d, err: %v",
+				event, u.ID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"domain": adminJSON(dom, proj),
+	})
+}
+
+// Enable serves a disabled domain again, whatever it was disabled for.
+func Enable(c *gin.Context) {
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	dom, proj, ok := findDomain(c, db)
+	if !ok {
+		return
+	}
+
+	enabled, err := dom.Enable(db)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if !enabled {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":             "not_disabled",
+			"error_description": "domain is not disabled",
+		})
+		return
+	}
+
+	if proj.ActiveDeploymentID != nil {
+		j, err := job.NewWithJSON(queues.Deploy, &messages.DeployJobData{
+			DeploymentID:      *proj.ActiveDeploymentID,
+			SkipWebrootUpload: true,
+		})
+		if err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+
+		if err := j.Enqueue(); err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+	}
+
+	log.Infof("domain %q of project %d was enabled by an admin", dom.Name, proj.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"domain": adminJSON(dom, proj),
+	})
+}
@@ -0,0 +1,263 @@
+package domains_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/domain"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/apiserver/server"
+	"github.com/nitrous-io/rise-server/pkg/filetransfer"
+	"github.com/nitrous-io/rise-server/pkg/mailer"
+	"github.com/nitrous-io/rise-server/pkg/mqconn"
+	"github.com/nitrous-io/rise-server/pkg/tracker"
+	"github.com/nitrous-io/rise-server/shared/queues"
+	"github.com/nitrous-io/rise-server/shared/s3client"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+	"github.com/nitrous-io/rise-server/testhelper/fake"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Domain admin", func() {
+	var (
+		fakeS3 *fake.S3
+		origS3 filetransfer.FileTransfer
+
+		fakeMailer  *fake.Mailer
+		origMailer  mailer.Mailer
+		fakeTracker *fake.Tracker
+		origTracker tracker.Trackable
+
+		origStatsToken string
+
+		db *gorm.DB
+		mq mqconn.Conn
+
+		s   *httptest.Server
+		res *http.Response
+		err error
+
+		u    *user.User
+		proj *project.Project
+		dom  *domain.Domain
+	)
+
+	BeforeEach(func() {
+		origS3 = s3client.S3
+		fakeS3 = &fake.S3{}
+		s3client.S3 = fakeS3
+
+		origMailer = common.Mailer
+		fakeMailer = &fake.Mailer{}
+		common.Mailer = fakeMailer
+
+		origTracker = common.Tracker
+		fakeTracker = &fake.Tracker{}
+		common.Tracker = fakeTracker
+
+		origStatsToken = common.StatsToken
+		common.StatsToken = "statssecret"
+
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+
+		mq, err = mqconn.MQ()
+		Expect(err).To(BeNil())
+		testhelper.DeleteQueue(mq, queues.All...)
+
+		u = factories.User(db)
+		proj = factories.Project(db, u, "foo-bar-express")
+
+		depl := factories.Deployment(db, proj, u, deployment.StateDeployed)
+		Expect(db.Model(proj).Update("active_deployment_id", depl.ID).Error).To(BeNil())
+
+		dom = factories.Domain(db, proj, "www.foo-bar.com")
+	})
+
+	AfterEach(func() {
+		if res != nil {
+			res.Body.Close()
+		}
+		s.Close()
+
+		s3client.S3 = origS3
+		common.Mailer = origMailer
+		common.Tracker = origTracker
+		common.StatsToken = origStatsToken
+	})
+
+	doRequest := func(method, path, token string) {
+		s = httptest.NewServer(server.New())
+		res, err = testhelper.MakeRequest(method, s.URL+path+"?token="+token, nil, nil, nil)
+		Expect(err).To(BeNil())
+	}
+
+	Describe("GET /admin/domains", func() {
+		BeforeEach(func() {
+			factories.Domain(db, proj, "www.fine.com")
+
+			Expect(db.Model(dom).Update("dns_failing_since", time.Now().Add(-time.Hour)).Error).To(BeNil())
+		})
+
+		It("lists the domains whose DNS records are failing", func() {
+			doRequest("GET", "/admin/domains", "statssecret")
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+			var j struct {
+				Domains []struct {
+					Name        string `json:"name"`
+					ProjectName string `json:"project_name"`
+					State       string `json:"state"`
+				} `json:"domains"`
+			}
+			Expect(json.Unmarshal(b.Bytes(), &j)).To(Succeed())
+			Expect(j.Domains).To(HaveLen(1))
+			Expect(j.Domains[0].Name).To(Equal("www.foo-bar.com"))
+			Expect(j.Domains[0].ProjectName).To(Equal("foo-bar-express"))
+			Expect(j.Domains[0].State).To(Equal(domain.StateActive))
+		})
+
+		It("returns 401 unauthorized without the admin token", func() {
+			doRequest("GET", "/admin/domains", "wrong")
+
+			Expect(res.StatusCode).To(Equal(http.StatusUnauthorized))
+		})
+	})
+
+	Describe("POST /admin/domains/:name/disable", func() {
+		It("stops serving the domain and notifies the project owner", func() {
+			doRequest("POST", "/admin/domains/www.foo-bar.com/disable", "statssecret")
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+			Expect(db.First(dom, dom.ID).Error).To(BeNil())
+			Expect(dom.State).To(Equal(domain.StateDisabled))
+			Expect(*dom.DisabledReason).To(Equal(domain.DisabledReasonAdmin))
+
+			Expect(fakeS3.DeleteCalls.Count()).To(Equal(1))
+			Expect(fakeS3.DeleteCalls.NthCall(1).Arguments[2]).To(Equal("domains/www.foo-bar.com/meta.json"))
+
+			Expect(fakeMailer.SendMailCalled).To(BeTrue())
+			Expect(fakeMailer.Tos).To(Equal([]string{u.Email}))
+			Expect(fakeMailer.Subject).To(Equal("www.foo-bar.com is no longer being served by PubStorm"))
+
+			trackCall := fakeTracker.TrackCalls.NthCall(1)
+			Expect(trackCall).NotTo(BeNil())
+			Expect(trackCall.Arguments[1]).To(Equal("Disabled Custom Domain"))
+		})
+
+		It("returns 409 conflict if the domain is already disabled", func() {
+			_, err := dom.Disable(db, domain.DisabledReasonDNS)
+			Expect(err).To(BeNil())
+
+			doRequest("POST", "/admin/domains/www.foo-bar.com/disable", "statssecret")
+
+			Expect(res.StatusCode).To(Equal(http.StatusConflict))
+			Expect(fakeS3.DeleteCalls.Count()).To(Equal(0))
+		})
+
+		It("returns 404 not found for unknown domains", func() {
+			doRequest("POST", "/admin/domains/www.unknown.com/disable", "statssecret")
+
+			Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+		})
+
+		It("returns 401 unauthorized without the admin token", func() {
+			doRequest("POST", "/admin/domains/www.foo-bar.com/disable", "wrong")
+
+			Expect(res.StatusCode).To(Equal(http.StatusUnauthorized))
+			Expect(db.First(dom, dom.ID).Error).To(BeNil())
+			Expect(dom.State).To(Equal(domain.StateActive))
+		})
+	})
+
+	Describe("POST /admin/domains/:name/enable", func() {
+		BeforeEach(func() {
+			_, err := dom.Disable(db, domain.DisabledReasonAdmin)
+			Expect(err).To(BeNil())
+		})
+
+		It("enables the domain and enqueues a deploy job to publish its meta.json", func() {
+			doRequest("POST", "/admin/domains/www.foo-bar.com/enable", "statssecret")
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+			Expect(db.First(dom, dom.ID).Error).To(BeNil())
+			Expect(dom.State).To(Equal(domain.StateActive))
+			Expect(dom.DisabledReason).To(BeNil())
+
+			d := testhelper.ConsumeQueue(mq, queues.Deploy)
+			Expect(d).NotTo(BeNil())
+			Expect(d.Body).To(MatchJSON(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"skip_webroot_upload": true,
+				"skip_invalidation": false,
+				"use_raw_bundle": false
+			}`, *proj.ActiveDeploymentID)))
+		})
+
+		It("returns 409 conflict if the domain is not disabled", func() {
+			_, err := dom.Enable(db)
+			Expect(err).To(BeNil())
+
+			doRequest("POST", "/admin/domains/www.foo-bar.com/enable", "statssecret")
+
+			Expect(res.StatusCode).To(Equal(http.StatusConflict))
+			Expect(testhelper.ConsumeQueue(mq, queues.Deploy)).To(BeNil())
+		})
+	})
+
+	Describe("POST /admin/domains/:name/reverify", func() {
+		var origLookupCNAME func(string) (string, error)
+
+		BeforeEach(func() {
+			origLookupCNAME = domain.LookupCNAME
+			domain.LookupCNAME = func(name string) (string, error) {
+				return proj.DefaultDomainName() + ".", nil
+			}
+		})
+
+		AfterEach(func() {
+			domain.LookupCNAME = origLookupCNAME
+		})
+
+		It("checks the DNS records of the domain and records the result", func() {
+			Expect(db.Model(dom).Update("dns_failing_since", time.Now().Add(-time.Hour)).Error).To(BeNil())
+
+			doRequest("POST", "/admin/domains/www.foo-bar.com/reverify", "statssecret")
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+			var j struct {
+				PointsToPubStorm bool `json:"points_to_pubstorm"`
+			}
+			Expect(json.Unmarshal(b.Bytes(), &j)).To(Succeed())
+			Expect(j.PointsToPubStorm).To(BeTrue())
+
+			Expect(db.First(dom, dom.ID).Error).To(BeNil())
+			Expect(dom.DNSCheckedAt).NotTo(BeNil())
+			Expect(dom.DNSFailingSince).To(BeNil())
+		})
+	})
+})
@@ -0,0 +1,50 @@
+package domains
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/domain"
+)
+
+// ShowDNS shows the DNS records that a domain and its apex domain need to
+// point to the project, and whether they are configured yet. Apex domains
+// cannot be CNAMEs, so they are given the addresses of the healthy edges to
+// use as A records, or the default domain to use as an ALIAS record.
+func ShowDNS(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+	domainName := strings.ToLower(c.Param("name"))
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	var d domain.Domain
+	if err := db.Where("name = ? AND project_id = ?", domainName, proj.ID).First(&d).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":             "not_found",
+				"error_description": "domain could not be found",
+			})
+			return
+		}
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	setup, err := d.DNSSetup(proj.DefaultDomainName())
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"dns": setup,
+	})
+}
@@ -17,13 +17,16 @@ import (
 	"github.com/nitrous-io/rise-server/apiserver/models/domain"
 	"github.com/nitrous-io/rise-server/apiserver/models/project"
 	"github.com/nitrous-io/rise-server/pkg/job"
-	"github.com/nitrous-io/rise-server/pkg/pubsub"
-	"github.com/nitrous-io/rise-server/shared/exchanges"
+	"github.com/nitrous-io/rise-server/shared/errcodes"
+	"github.com/nitrous-io/rise-server/shared/invalidation"
 	"github.com/nitrous-io/rise-server/shared/messages"
 	"github.com/nitrous-io/rise-server/shared/queues"
 	"github.com/nitrous-io/rise-server/shared/s3client"
 )
 
+// Index lists the domains of a project. Besides the names of the domains,
+// domain_details includes whether each domain is served over HTTPS, where its
+// cert came from and when it expires, and whether it has been verified.
 func Index(c *gin.Context) {
 	proj := controllers.CurrentProject(c)
 
@@ -39,9 +42,40 @@ func Index(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"domains": domNames,
-	})
+	pendingDomNames, err := proj.PendingDomainNames(db)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	doms, err := domain.FindWithCertsByProjectID(db, proj.ID)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	details := []interface{}{}
+	if proj.DefaultDomainEnabled {
+		details = append(details, domain.DetailsJSON{
+			Name:       proj.DefaultDomainName(),
+			State:      domain.StateActive,
+			HTTPS:      true,
+			CertSource: domain.CertSourceDefault,
+		})
+	}
+	for _, dom := range doms {
+		details = append(details, dom.AsDetailsJSON())
+	}
+
+	resp := gin.H{
+		"domains":        domNames,
+		"domain_details": details,
+	}
+	if len(pendingDomNames) > 0 {
+		resp["pending_domains"] = pendingDomNames
+	}
+
+	c.JSON(http.StatusOK, resp)
 }
 
 func DomainsByUser(c *gin.Context) {
@@ -124,6 +158,7 @@ func Create(c *gin.Context) {
 	dom := &domain.Domain{
 		Name:      domName,
 		ProjectID: proj.ID,
+		TLSPolicy: c.PostForm("tls_policy"),
 	}
 
 	if err := dom.Sanitize(); err != nil {
@@ -139,6 +174,12 @@ func Create(c *gin.Context) {
 		return
 	}
 
+	// Domains that do not point to PubStorm yet are not served until their
+	// DNS records have been verified by the verify-domains job.
+	if !dom.PointsTo(proj.DefaultDomainName()) {
+		dom.State = domain.StatePendingVerification
+	}
+
 	db, err := dbconn.DB()
 	if err != nil {
 		controllers.InternalServerError(c, err)
@@ -154,6 +195,7 @@ func Create(c *gin.Context) {
 	if !canCreate {
 		c.JSON(422, gin.H{
 			"error":             "invalid_request",
+			"code":              errcodes.QuotaExceeded,
 			"error_description": "project cannot have more domains",
 		})
 		return
@@ -163,6 +205,7 @@ func Create(c *gin.Context) {
 		if e, ok := err.(*pq.Error); ok && e.Code.Name() == "unique_violation" {
 			c.JSON(422, gin.H{
 				"error": "invalid_params",
+				"code":  errcodes.NameTaken,
 				"errors": map[string]interface{}{
 					"name": "is taken",
 				},
@@ -174,7 +217,7 @@ func Create(c *gin.Context) {
 		return
 	}
 
-	if proj.ActiveDeploymentID != nil {
+	if proj.ActiveDeploymentID != nil && !dom.IsPending() {
 		j, err := job.NewWithJSON(queues.Deploy, &messages.DeployJobData{
 			DeploymentID:      *proj.ActiveDeploymentID,
 			SkipWebrootUpload: true,
@@ -199,11 +242,9 @@ func Create(c *gin.Context) {
 			props = map[string]interface{}{
 				"projectName": proj.Name,
 				"domain":      dom.Name,
+				"pending":     dom.IsPending(),
 			}
-			context = map[string]interface{}{
-				"ip":         common.GetIP(c.Request),
-				"user_agent": c.Request.UserAgent(),
-			}
+			context = controllers.TrackingContext(c)
 		)
 		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
 			log.Errorf("failed to track %q event for user ID %d, err: %v",
@@ -247,10 +288,13 @@ func Destroy(c *gin.Context) {
 		}
 	}
 
+	// Delete the domain's certs, including any TLS-ALPN-01 challenge cert of
+	// a Let's Encrypt cert that is being issued.
 	metaJSONPath := "domains/" + domainName + "/meta.json"
 	certificatePath := "certs/" + domainName + "/ssl.crt"
 	privateKeyPath := "certs/" + domainName + "/ssl.key"
-	if err := s3client.Delete(metaJSONPath, certificatePath, privateKeyPath); err != nil {
+	challengeCertPath, challengeKeyPath := acmecert.TLSALPNChallengeCertPaths(domainName)
+	if err := s3client.Delete(metaJSONPath, certificatePath, privateKeyPath, challengeCertPath, challengeKeyPath); err != nil {
 		controllers.InternalServerError(c, err)
 		return
 	}
@@ -270,16 +314,7 @@ func Destroy(c *gin.Context) {
 		return
 	}
 
-	m, err := pubsub.NewMessageWithJSON(exchanges.Edges, exchanges.RouteV1Invalidation, &messages.V1InvalidationMessageData{
-		Domains: []string{domainName},
-	})
-
-	if err != nil {
-		controllers.InternalServerError(c, err)
-		return
-	}
-
-	if err := m.Publish(); err != nil {
+	if err := invalidation.Invalidate([]string{domainName}); err != nil {
 		controllers.InternalServerError(c, err)
 		return
 	}
@@ -298,10 +333,7 @@ func Destroy(c *gin.Context) {
 				"projectName": proj.Name,
 				"domain":      d.Name,
 			}
-			context = map[string]interface{}{
-				"ip":         common.GetIP(c.Request),
-				"user_agent": c.Request.UserAgent(),
-			}
+			context = controllers.TrackingContext(c)
 		)
 		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
 			log.Errorf("failed to track %q event for user ID %d, err: %v",
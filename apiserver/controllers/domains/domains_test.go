@@ -2,6 +2,7 @@ package domains_test
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -14,6 +15,7 @@ import (
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
 	"github.com/nitrous-io/rise-server/apiserver/models/acmecert"
 	"github.com/nitrous-io/rise-server/apiserver/models/cert"
+	"github.com/nitrous-io/rise-server/apiserver/models/dailystat"
 	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
 	"github.com/nitrous-io/rise-server/apiserver/models/domain"
 	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
@@ -33,7 +35,6 @@ import (
 	"github.com/nitrous-io/rise-server/testhelper/sharedexamples"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
-	"github.com/streadway/amqp"
 )
 
 func Test(t *testing.T) {
@@ -50,7 +51,7 @@ var _ = Describe("Domains", func() {
 		origTracker tracker.Trackable
 
 		db *gorm.DB
-		mq *amqp.Connection
+		mq mqconn.Conn
 
 		s   *httptest.Server
 		res *http.Response
@@ -125,6 +126,61 @@ var _ = Describe("Domains", func() {
 				Expect(b.String()).To(MatchJSON(`{
 					"domains": [
 						"` + proj.DefaultDomainName() + `"
+					],
+					"domain_details": [
+						{
+							"name": "` + proj.DefaultDomainName() + `",
+							"state": "active",
+							"https": true,
+							"cert_source": "default"
+						}
+					]
+				}`))
+			})
+		})
+
+		Context("when a custom domain is pending verification", func() {
+			BeforeEach(func() {
+				factories.Domain(db, proj, "www.foo-bar-express.com")
+				Expect(db.Create(&domain.Domain{
+					Name:      "www.foobarexpress.com",
+					ProjectID: proj.ID,
+					State:     domain.StatePendingVerification,
+				}).Error).To(BeNil())
+			})
+
+			It("lists the pending domain separately", func() {
+				doRequest()
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(b.String()).To(MatchJSON(`{
+					"domains": [
+						"` + proj.DefaultDomainName() + `",
+						"www.foo-bar-express.com"
+					],
+					"pending_domains": [
+						"www.foobarexpress.com"
+					],
+					"domain_details": [
+						{
+							"name": "` + proj.DefaultDomainName() + `",
+							"state": "active",
+							"https": true,
+							"cert_source": "default"
+						},
+						{
+							"name": "www.foo-bar-express.com",
+							"state": "active",
+							"https": false
+						},
+						{
+							"name": "www.foobarexpress.com",
+							"state": "pending_verification",
+							"https": false
+						}
 					]
 				}`))
 			})
@@ -132,7 +188,8 @@ var _ = Describe("Domains", func() {
 
 		Context("when custom domains for this project exist", func() {
 			BeforeEach(func() {
-				for _, dn := range []string{"www.foo-bar-express.com", "www.foobarexpress.com"} {
+				var doms []*domain.Domain
+				for _, dn := range []string{"www.foo-bar-express.com", "www.foobarexpress.com", "www.foobarxpress.com"} {
 					dom := &domain.Domain{
 						Name:      dn,
 						ProjectID: proj.ID,
@@ -140,11 +197,26 @@ var _ = Describe("Domains", func() {
 
 					err := db.Create(dom).Error
 					Expect(err).To(BeNil())
+					doms = append(doms, dom)
+				}
+
+				expiresAt := time.Date(2016, 9, 1, 0, 0, 0, 0, time.UTC)
+				for _, dom := range doms[1:] {
+					ct := factories.Cert(db, dom)
+					Expect(db.Model(ct).Update("expires_at", expiresAt).Error).To(BeNil())
 				}
+
+				Expect(db.Create(&acmecert.AcmeCert{
+					DomainID: doms[2].ID,
+					Cert:     "encrypted-cert",
+				}).Error).To(BeNil())
+
+				Expect(db.Model(doms[2]).Update("tls_policy", domain.TLSPolicyModern).Error).To(BeNil())
+
 				doRequest()
 			})
 
-			It("lists all domains for the project", func() {
+			It("lists all domains for the project with the status of their certs and their TLS policies", func() {
 				b := &bytes.Buffer{}
 				_, err := b.ReadFrom(res.Body)
 				Expect(err).To(BeNil())
@@ -154,7 +226,36 @@ var _ = Describe("Domains", func() {
 					"domains": [
 						"` + proj.DefaultDomainName() + `",
 						"www.foo-bar-express.com",
-						"www.foobarexpress.com"
+						"www.foobarexpress.com",
+						"www.foobarxpress.com"
+					],
+					"domain_details": [
+						{
+							"name": "` + proj.DefaultDomainName() + `",
+							"state": "active",
+							"https": true,
+							"cert_source": "default"
+						},
+						{
+							"name": "www.foo-bar-express.com",
+							"state": "active",
+							"https": false
+						},
+						{
+							"name": "www.foobarexpress.com",
+							"state": "active",
+							"https": true,
+							"cert_source": "uploaded",
+							"cert_expires_at": "2016-09-01T00:00:00Z"
+						},
+						{
+							"name": "www.foobarxpress.com",
+							"state": "active",
+							"https": true,
+							"cert_source": "letsencrypt",
+							"cert_expires_at": "2016-09-01T00:00:00Z",
+							"tls_policy": "modern"
+						}
 					]
 				}`))
 			})
@@ -176,12 +277,30 @@ var _ = Describe("Domains", func() {
 	})
 
 	Describe("POST /projects/:name/domains", func() {
-		var params url.Values
+		var (
+			params url.Values
+
+			origLookupCNAME func(string) (string, error)
+			pointsToProject bool
+		)
 
 		BeforeEach(func() {
 			params = url.Values{
 				"name": {"www.foo-bar-express.com"},
 			}
+
+			pointsToProject = true
+			origLookupCNAME = domain.LookupCNAME
+			domain.LookupCNAME = func(name string) (string, error) {
+				if pointsToProject {
+					return proj.DefaultDomainName() + ".", nil
+				}
+				return name + ".", nil
+			}
+		})
+
+		AfterEach(func() {
+			domain.LookupCNAME = origLookupCNAME
 		})
 
 		doRequest := func() {
@@ -253,6 +372,7 @@ var _ = Describe("Domains", func() {
 					Expect(res.StatusCode).To(Equal(422))
 					Expect(b.String()).To(MatchJSON(`{
 						"error": "invalid_params",
+						"code": "name_taken",
 						"errors": {
 							"name": "is taken"
 						}
@@ -286,6 +406,7 @@ var _ = Describe("Domains", func() {
 					Expect(res.StatusCode).To(Equal(422))
 					Expect(b.String()).To(MatchJSON(`{
 						"error": "invalid_request",
+						"code": "quota_exceeded",
 						"error_description": "project cannot have more domains"
 					}`))
 
@@ -419,6 +540,48 @@ var _ = Describe("Domains", func() {
 					})
 				})
 
+				Context("when the domain does not point to the project yet", func() {
+					var origLookupHost func(string) ([]string, error)
+
+					BeforeEach(func() {
+						pointsToProject = false
+
+						origLookupHost = domain.LookupHost
+						domain.LookupHost = func(name string) ([]string, error) {
+							return nil, errors.New("no such host")
+						}
+
+						depl := factories.Deployment(db, proj, u, deployment.StateDeployed)
+						err := db.Model(proj).Update("active_deployment_id", depl.ID).Error
+						Expect(err).To(BeNil())
+					})
+
+					AfterEach(func() {
+						domain.LookupHost = origLookupHost
+					})
+
+					It("creates a domain that is pending verification", func() {
+						b := &bytes.Buffer{}
+						_, err := b.ReadFrom(res.Body)
+						Expect(err).To(BeNil())
+
+						Expect(res.StatusCode).To(Equal(http.StatusCreated))
+						Expect(b.String()).To(MatchJSON(`{
+							"domain": {
+								"name": "www.foo-bar-express.com",
+								"state": "pending_verification"
+							}
+						}`))
+
+						Expect(dom.State).To(Equal(domain.StatePendingVerification))
+					})
+
+					It("does not enqueue any job", func() {
+						d := testhelper.ConsumeQueue(mq, queues.Deploy)
+						Expect(d).To(BeNil())
+					})
+				})
+
 				Context("when an apex domain is given", func() {
 					BeforeEach(func() {
 						params.Set("name", "foo-bar-express.com")
@@ -465,6 +628,543 @@ var _ = Describe("Domains", func() {
 		}, nil)
 	})
 
+	Describe("GET /projects/:project_name/domains/:name/dns", func() {
+		var (
+			domainName string
+
+			origLookupCNAME func(string) (string, error)
+			origLookupHost  func(string) ([]string, error)
+			origCheckEdgeIP func(string) bool
+			origEdgeIPs     []string
+		)
+
+		BeforeEach(func() {
+			domainName = "www.foo-bar-express.com"
+			factories.Domain(db, proj, domainName)
+
+			origLookupCNAME = domain.LookupCNAME
+			origLookupHost = domain.LookupHost
+			origCheckEdgeIP = domain.CheckEdgeIP
+			origEdgeIPs = shared.EdgeIPs
+
+			shared.EdgeIPs = []string{"192.0.2.1", "192.0.2.2"}
+			domain.CheckEdgeIP = func(ip string) bool {
+				return ip != "192.0.2.2"
+			}
+			domain.LookupCNAME = func(name string) (string, error) {
+				if name == "www.foo-bar-express.com" {
+					return "foo-bar-express." + shared.DefaultDomain + ".", nil
+				}
+				return "", errors.New("no such host")
+			}
+			domain.LookupHost = func(name string) ([]string, error) {
+				if name == "foo-bar-express.com" {
+					return []string{"192.0.2.1"}, nil
+				}
+				return nil, errors.New("no such host")
+			}
+		})
+
+		AfterEach(func() {
+			domain.LookupCNAME = origLookupCNAME
+			domain.LookupHost = origLookupHost
+			domain.CheckEdgeIP = origCheckEdgeIP
+			shared.EdgeIPs = origEdgeIPs
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("GET", s.URL+"/projects/foo-bar-express/domains/"+domainName+"/dns", nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("returns the DNS records of the domain and its apex domain", func() {
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+				"dns": {
+					"domain": "www.foo-bar-express.com",
+					"apex": "foo-bar-express.com",
+					"records": [
+						{"name": "www.foo-bar-express.com", "type": "CNAME", "values": ["foo-bar-express.%[1]s"]},
+						{"name": "foo-bar-express.com", "type": "A", "values": ["192.0.2.1"]},
+						{"name": "foo-bar-express.com", "type": "ALIAS", "values": ["foo-bar-express.%[1]s"]}
+					],
+					"configured": true,
+					"apex_configured": true
+				}
+			}`, shared.DefaultDomain)))
+		})
+
+		Context("when the domain does not exist", func() {
+			BeforeEach(func() {
+				domainName = "www.foo-bar-express.io"
+			})
+
+			It("returns 404", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "not_found",
+					"error_description": "domain could not be found"
+				}`))
+			})
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+
+	Describe("PUT /projects/:project_name/domains/:name/tls_policy", func() {
+		var (
+			d      *domain.Domain
+			params url.Values
+		)
+
+		BeforeEach(func() {
+			d = factories.Domain(db, proj, "www.foo-bar-express.com")
+			params = url.Values{
+				"policy": {"legacy"},
+			}
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("PUT", s.URL+"/projects/foo-bar-express/domains/"+d.Name+"/tls_policy", params, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		Context("when the policy is invalid", func() {
+			BeforeEach(func() {
+				params.Set("policy", "ancient")
+			})
+
+			It("returns 422 unprocessable entity", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"errors": {
+						"policy": "must be one of modern, intermediate, legacy"
+					}
+				}`))
+
+				Expect(db.First(d, d.ID).Error).To(BeNil())
+				Expect(d.TLSPolicy).To(Equal(domain.TLSPolicyIntermediate))
+			})
+		})
+
+		Context("when the domain does not exist", func() {
+			BeforeEach(func() {
+				d = &domain.Domain{Name: "www.foo-bar-express.io"}
+			})
+
+			It("returns 404", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "not_found",
+					"error_description": "domain could not be found"
+				}`))
+			})
+		})
+
+		It("updates the TLS policy of the domain", func() {
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+				"tls_policy": {
+					"policy": "legacy",
+					"min_version": "1.0",
+					"ciphers": %q
+				}
+			}`, domain.TLSPolicies[domain.TLSPolicyLegacy].Ciphers)))
+
+			Expect(db.First(d, d.ID).Error).To(BeNil())
+			Expect(d.TLSPolicy).To(Equal(domain.TLSPolicyLegacy))
+		})
+
+		It("tracks an 'Updated Domain TLS Policy' event", func() {
+			doRequest()
+
+			trackCall := fakeTracker.TrackCalls.NthCall(1)
+			Expect(trackCall).NotTo(BeNil())
+			Expect(trackCall.Arguments[1]).To(Equal("Updated Domain TLS Policy"))
+
+			props, ok := trackCall.Arguments[3].(map[string]interface{})
+			Expect(ok).To(BeTrue())
+			Expect(props["domain"]).To(Equal(d.Name))
+			Expect(props["tlsPolicy"]).To(Equal("legacy"))
+		})
+
+		Context("when there is an active deployment", func() {
+			BeforeEach(func() {
+				depl := factories.Deployment(db, proj, u, deployment.StateDeployed)
+				Expect(db.Model(proj).Update("active_deployment_id", depl.ID).Error).To(BeNil())
+			})
+
+			It("enqueues a deploy job to update and invalidate meta.json", func() {
+				doRequest()
+
+				j := testhelper.ConsumeQueue(mq, queues.Deploy)
+				Expect(j).NotTo(BeNil())
+				Expect(j.Body).To(MatchJSON(fmt.Sprintf(`{
+					"deployment_id": %d,
+					"skip_webroot_upload": true,
+					"skip_invalidation": false,
+					"use_raw_bundle": false
+				}`, *proj.ActiveDeploymentID)))
+			})
+
+			Context("when the domain is pending verification", func() {
+				BeforeEach(func() {
+					Expect(db.Model(d).Update("state", domain.StatePendingVerification).Error).To(BeNil())
+				})
+
+				It("does not enqueue any job", func() {
+					doRequest()
+
+					Expect(res.StatusCode).To(Equal(http.StatusOK))
+					Expect(testhelper.ConsumeQueue(mq, queues.Deploy)).To(BeNil())
+				})
+			})
+		})
+
+		Context("when there is no active deployment", func() {
+			It("does not enqueue any job", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(testhelper.ConsumeQueue(mq, queues.Deploy)).To(BeNil())
+			})
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItLocksProject(func() (*gorm.DB, *project.Project) {
+			return db, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+
+	Describe("GET /projects/:project_name/domains/:name/quota", func() {
+		var (
+			d     *domain.Domain
+			today time.Time
+		)
+
+		BeforeEach(func() {
+			d = factories.Domain(db, proj, "www.foo-bar-express.com")
+			today = time.Now().UTC().Truncate(24 * time.Hour)
+
+			Expect(db.Model(proj).Update("max_domain_requests", 1000).Error).To(BeNil())
+			Expect(dailystat.Increment(db, &dailystat.DailyStat{
+				ProjectID:  proj.ID,
+				DomainName: d.Name,
+				Date:       today,
+				Requests:   250,
+				Bytes:      4096,
+			})).To(BeNil())
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("GET", s.URL+"/projects/foo-bar-express/domains/"+d.Name+"/quota", nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("returns the quotas of the domain and how much of them has been used today", func() {
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+				"quota": {
+					"domain": "www.foo-bar-express.com",
+					"date": "%s",
+					"resets_at": "%s",
+					"requests": {
+						"soft": 800,
+						"hard": 1000,
+						"used": 250
+					},
+					"bandwidth": null,
+					"plan_limits": {
+						"requests": 1000,
+						"bandwidth": 0
+					}
+				}
+			}`, today.Format("2006-01-02"), today.Add(24*time.Hour).Format(time.RFC3339))))
+		})
+
+		Context("when the domain is the default domain", func() {
+			BeforeEach(func() {
+				d = &domain.Domain{Name: proj.DefaultDomainName()}
+			})
+
+			It("returns the quotas of the plan", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(b.String()).To(ContainSubstring(`"requests":{"soft":800,"hard":1000,"used":0}`))
+			})
+		})
+
+		Context("when the domain does not exist", func() {
+			BeforeEach(func() {
+				d = &domain.Domain{Name: "www.foo-bar-express.io"}
+			})
+
+			It("returns 404", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+			})
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+
+	Describe("PUT /projects/:project_name/domains/:name/quota", func() {
+		var (
+			d      *domain.Domain
+			params url.Values
+		)
+
+		BeforeEach(func() {
+			d = factories.Domain(db, proj, "www.foo-bar-express.com")
+			Expect(db.Model(proj).Update("max_domain_requests", 1000).Error).To(BeNil())
+
+			params = url.Values{
+				"requests_hard":  {"500"},
+				"bandwidth_soft": {"1000000"},
+				"bandwidth_hard": {"2000000"},
+			}
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("PUT", s.URL+"/projects/foo-bar-express/domains/"+d.Name+"/quota", params, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("sets the quotas of the domain", func() {
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(ContainSubstring(`"requests":{"soft":400,"hard":500,"used":0}`))
+			Expect(b.String()).To(ContainSubstring(`"bandwidth":{"soft":1000000,"hard":2000000,"used":0}`))
+
+			Expect(db.First(d, d.ID).Error).To(BeNil())
+			Expect(d.RequestQuotaSoft).To(BeNil())
+			Expect(*d.RequestQuotaHard).To(Equal(int64(500)))
+			Expect(*d.BandwidthQuotaSoft).To(Equal(int64(1000000)))
+			Expect(*d.BandwidthQuotaHard).To(Equal(int64(2000000)))
+		})
+
+		It("tracks an 'Updated Domain Quota' event", func() {
+			doRequest()
+
+			trackCall := fakeTracker.TrackCalls.NthCall(1)
+			Expect(trackCall).NotTo(BeNil())
+			Expect(trackCall.Arguments[1]).To(Equal("Updated Domain Quota"))
+
+			props, ok := trackCall.Arguments[3].(map[string]interface{})
+			Expect(ok).To(BeTrue())
+			Expect(props["domain"]).To(Equal(d.Name))
+			Expect(props["requestsHard"]).To(Equal(int64(500)))
+			Expect(props["bandwidthHard"]).To(Equal(int64(2000000)))
+		})
+
+		Context("when a quota is set to an empty value", func() {
+			BeforeEach(func() {
+				Expect(db.Model(d).Update("request_quota_hard", 100).Error).To(BeNil())
+				params = url.Values{"requests_hard": {""}}
+			})
+
+			It("resets the quota to the one of the plan", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(db.First(d, d.ID).Error).To(BeNil())
+				Expect(d.RequestQuotaHard).To(BeNil())
+			})
+		})
+
+		Context("when a quota is not a number", func() {
+			BeforeEach(func() {
+				params.Set("bandwidth_soft", "lots")
+			})
+
+			It("returns 422 unprocessable entity", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"errors": {
+						"bandwidth_soft": "must be a number"
+					}
+				}`))
+			})
+		})
+
+		Context("when a quota is greater than the limit of the plan", func() {
+			BeforeEach(func() {
+				params.Set("requests_hard", "1001")
+			})
+
+			It("returns 422 unprocessable entity", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"errors": {
+						"requests_hard": "cannot be greater than the limit of your plan (1000)"
+					}
+				}`))
+
+				Expect(db.First(d, d.ID).Error).To(BeNil())
+				Expect(d.RequestQuotaHard).To(BeNil())
+			})
+		})
+
+		Context("when the domain does not exist", func() {
+			BeforeEach(func() {
+				d = &domain.Domain{Name: "www.foo-bar-express.io"}
+			})
+
+			It("returns 404", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+			})
+		})
+
+		Context("when there is an active deployment", func() {
+			BeforeEach(func() {
+				depl := factories.Deployment(db, proj, u, deployment.StateDeployed)
+				Expect(db.Model(proj).Update("active_deployment_id", depl.ID).Error).To(BeNil())
+			})
+
+			It("enqueues a deploy job to update and invalidate meta.json", func() {
+				doRequest()
+
+				j := testhelper.ConsumeQueue(mq, queues.Deploy)
+				Expect(j).NotTo(BeNil())
+				Expect(j.Body).To(MatchJSON(fmt.Sprintf(`{
+					"deployment_id": %d,
+					"skip_webroot_upload": true,
+					"skip_invalidation": false,
+					"use_raw_bundle": false
+				}`, *proj.ActiveDeploymentID)))
+			})
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItLocksProject(func() (*gorm.DB, *project.Project) {
+			return db, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+
 	Describe("DELETE /projects/:project_name/domains/:name", func() {
 		var (
 			domainName string
@@ -524,7 +1224,7 @@ var _ = Describe("Domains", func() {
 				Expect(count).To(BeZero())
 			})
 
-			It("deletes the meta.json for the domain from s3", func() {
+			It("deletes the meta.json and certs for the domain from s3", func() {
 				doRequest()
 
 				Expect(fakeS3.DeleteCalls.Count()).To(Equal(1))
@@ -536,6 +1236,8 @@ var _ = Describe("Domains", func() {
 				Expect(deleteCall.Arguments[2]).To(Equal("domains/" + domainName + "/meta.json"))
 				Expect(deleteCall.Arguments[3]).To(Equal("certs/" + domainName + "/ssl.crt"))
 				Expect(deleteCall.Arguments[4]).To(Equal("certs/" + domainName + "/ssl.key"))
+				Expect(deleteCall.Arguments[5]).To(Equal("certs/" + domainName + "/acme-tls-alpn.crt"))
+				Expect(deleteCall.Arguments[6]).To(Equal("certs/" + domainName + "/acme-tls-alpn.key"))
 				Expect(deleteCall.ReturnValues[0]).To(BeNil())
 			})
 
@@ -623,6 +1325,8 @@ var _ = Describe("Domains", func() {
 					Expect(deleteCall.Arguments[2]).To(Equal("domains/" + domainName + "/meta.json"))
 					Expect(deleteCall.Arguments[3]).To(Equal("certs/" + domainName + "/ssl.crt"))
 					Expect(deleteCall.Arguments[4]).To(Equal("certs/" + domainName + "/ssl.key"))
+					Expect(deleteCall.Arguments[5]).To(Equal("certs/" + domainName + "/acme-tls-alpn.crt"))
+					Expect(deleteCall.Arguments[6]).To(Equal("certs/" + domainName + "/acme-tls-alpn.key"))
 					Expect(deleteCall.ReturnValues[0]).To(BeNil())
 				})
 
@@ -0,0 +1,215 @@
+package domains
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/dailystat"
+	"github.com/nitrous-io/rise-server/apiserver/models/domain"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/pkg/job"
+	"github.com/nitrous-io/rise-server/shared/messages"
+	"github.com/nitrous-io/rise-server/shared/queues"
+)
+
+// quotaUsage is a daily quota of a domain with how much of it has been used.
+type quotaUsage struct {
+	*domain.Quota
+	Used int64 `json:"used"`
+}
+
+// ShowQuota shows the daily quotas of a domain, which may be the default
+// domain of the project, and how much of them has been used today.
+func ShowQuota(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+	domainName := strings.ToLower(c.Param("name"))
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	quotas, err := proj.DomainQuotas(db, domainName)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if quotas == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "domain could not be found",
+		})
+		return
+	}
+
+	renderQuota(c, db, proj, domainName, quotas)
+}
+
+// UpdateQuota sets the daily quotas of a domain within the limits of the plan
+// of the project, and republishes the domain's meta.json if it is being
+// served, so that edges enforce them. Quotas that are set to an empty value
+// are reset to the ones derived from the plan.
+func UpdateQuota(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+	domainName := strings.ToLower(c.Param("name"))
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	var d domain.Domain
+	if err := db.Where("name = ? AND project_id = ?", domainName, proj.ID).First(&d).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":             "not_found",
+				"error_description": "domain could not be found",
+			})
+			return
+		}
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	errs := map[string]string{}
+	updates := map[string]interface{}{}
+	for _, f := range []struct {
+		param, column string
+		field         **int64
+	}{
+		{"requests_soft", "request_quota_soft", &d.RequestQuotaSoft},
+		{"requests_hard", "request_quota_hard", &d.RequestQuotaHard},
+		{"bandwidth_soft", "bandwidth_quota_soft", &d.BandwidthQuotaSoft},
+		{"bandwidth_hard", "bandwidth_quota_hard", &d.BandwidthQuotaHard},
+	} {
+		v, ok := c.GetPostForm(f.param)
+		if !ok {
+			continue
+		}
+
+		if v == "" {
+			*f.field = nil
+			updates[f.column] = nil
+			continue
+		}
+
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			errs[f.param] = "must be a number"
+			continue
+		}
+		*f.field = &n
+		updates[f.column] = n
+	}
+
+	if len(errs) == 0 {
+		if verrs := d.ValidateQuotas(proj.DomainRequestLimit(), proj.DomainBandwidthLimit()); verrs != nil {
+			errs = verrs
+		}
+	}
+
+	if len(errs) > 0 {
+		c.JSON(422, gin.H{
+			"error":  "invalid_params",
+			"errors": errs,
+		})
+		return
+	}
+
+	if len(updates) > 0 {
+		if err := db.Model(&d).Updates(updates).Error; err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+
+		if proj.ActiveDeploymentID != nil && !d.IsPending() {
+			j, err := job.NewWithJSON(queues.Deploy, &messages.DeployJobData{
+				DeploymentID:      *proj.ActiveDeploymentID,
+				SkipWebrootUpload: true,
+				SkipInvalidation:  false, // edges cache meta.json, so it has to be invalidated
+			})
+			if err != nil {
+				controllers.InternalServerError(c, err)
+				return
+			}
+
+			if err := j.Enqueue(); err != nil {
+				controllers.InternalServerError(c, err)
+				return
+			}
+		}
+	}
+
+	quotas := d.Quotas(proj.DomainRequestLimit(), proj.DomainBandwidthLimit())
+
+	{
+		u := controllers.CurrentUser(c)
+
+		var (
+			event = "Updated Domain Quota"
+			props = map[string]interface{}{
+				"projectName": proj.Name,
+				"domain":      d.Name,
+			}
+			context = controllers.TrackingContext(c)
+		)
+		if q := quotas.Requests; q != nil {
+			props["requestsSoft"], props["requestsHard"] = q.Soft, q.Hard
+		}
+		if q := quotas.Bandwidth; q != nil {
+			props["bandwidthSoft"], props["bandwidthHard"] = q.Soft, q.Hard
+		}
+		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
+			log.Errorf("failed to track %q event for user ID %d, err: %v",
+				event, u.ID, err)
+		}
+	}
+
+	renderQuota(c, db, proj, d.Name, quotas)
+}
+
+func renderQuota(c *gin.Context, db *gorm.DB, proj *project.Project, domainName string, quotas *domain.Quotas) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+
+	s, err := dailystat.FindByDomain(db, proj.ID, domainName, today)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+	if s == nil {
+		s = &dailystat.DailyStat{}
+	}
+
+	var requests, bandwidth *quotaUsage
+	if quotas.Requests != nil {
+		requests = &quotaUsage{quotas.Requests, s.Requests}
+	}
+	if quotas.Bandwidth != nil {
+		bandwidth = &quotaUsage{quotas.Bandwidth, s.Bytes}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"quota": gin.H{
+			"domain":    domainName,
+			"date":      today.Format(dailystat.DateFormat),
+			"resets_at": today.Add(24 * time.Hour),
+			"requests":  requests,
+			"bandwidth": bandwidth,
+			"plan_limits": gin.H{
+				"requests":  proj.DomainRequestLimit(),
+				"bandwidth": proj.DomainBandwidthLimit(),
+			},
+		},
+	})
+}
@@ -0,0 +1,100 @@
+package domains
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/domain"
+	"github.com/nitrous-io/rise-server/pkg/job"
+	"github.com/nitrous-io/rise-server/shared/messages"
+	"github.com/nitrous-io/rise-server/shared/queues"
+)
+
+// UpdateTLSPolicy selects the TLS policy that edges use for a domain, and
+// republishes the domain's meta.json if it is being served.
+func UpdateTLSPolicy(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+	domainName := strings.ToLower(c.Param("name"))
+
+	policy := c.PostForm("policy")
+	if policy == "" || domain.TLSPolicies[policy] == nil {
+		c.JSON(422, gin.H{
+			"error": "invalid_params",
+			"errors": map[string]interface{}{
+				"policy": "must be one of " + strings.Join(domain.TLSPolicyNames, ", "),
+			},
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	var d domain.Domain
+	if err := db.Where("name = ? AND project_id = ?", domainName, proj.ID).First(&d).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":             "not_found",
+				"error_description": "domain could not be found",
+			})
+			return
+		}
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	prevPolicy := d.TLSPolicy
+	if err := db.Model(&d).Update("tls_policy", policy).Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if proj.ActiveDeploymentID != nil && !d.IsPending() && policy != prevPolicy {
+		j, err := job.NewWithJSON(queues.Deploy, &messages.DeployJobData{
+			DeploymentID:      *proj.ActiveDeploymentID,
+			SkipWebrootUpload: true,
+			SkipInvalidation:  false, // edges cache meta.json, so it has to be invalidated
+		})
+		if err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+
+		if err := j.Enqueue(); err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+	}
+
+	{
+		u := controllers.CurrentUser(c)
+
+		var (
+			event = "Updated Domain TLS Policy"
+			props = map[string]interface{}{
+				"projectName": proj.Name,
+				"domain":      d.Name,
+				"tlsPolicy":   policy,
+			}
+			context = controllers.TrackingContext(c)
+		)
+		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
+			log.Errorf("failed to track %q event for user ID %d, err: %v",
+				event, u.ID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"tls_policy": domain.TLSPolicies[policy],
+	})
+}
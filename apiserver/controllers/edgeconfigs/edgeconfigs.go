@@ -0,0 +1,184 @@
+package edgeconfigs
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/edge"
+	"github.com/nitrous-io/rise-server/apiserver/models/edgeconfig"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/pkg/pubsub"
+	"github.com/nitrous-io/rise-server/shared/exchanges"
+	"github.com/nitrous-io/rise-server/shared/messages"
+)
+
+// RecentLimit is the number of configs listed.
+const RecentLimit = 50
+
+// Index lists the most recent versions of the settings of edge servers.
+func Index(c *gin.Context) {
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	configs, err := edgeconfig.Recent(db, RecentLimit)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	configsJSON := make([]interface{}, len(configs))
+	for i, cfg := range configs {
+		configsJSON[i], err = cfg.AsJSON()
+		if err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"edge_configs": configsJSON,
+	})
+}
+
+// Create adds a new version of the settings of edge servers, either for all
+// domains or for the domains of a project, and publishes it to the edges.
+func Create(c *gin.Context) {
+	var params struct {
+		ProjectName string               `json:"project_name"`
+		Settings    *edgeconfig.Settings `json:"settings"`
+	}
+	if err := c.BindJSON(&params); err != nil || params.Settings == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":             "invalid_request",
+			"error_description": "request body is in invalid format",
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	msg := &messages.V1EdgeConfigMessageData{}
+	cfg := &edgeconfig.EdgeConfig{}
+
+	if params.ProjectName != "" {
+		proj, err := project.FindByName(db, params.ProjectName)
+		if err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+
+		if proj == nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":             "not_found",
+				"error_description": "project could not be found",
+			})
+			return
+		}
+
+		domainNames, err := proj.DomainNames(db)
+		if err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+
+		cfg.ProjectID = &proj.ID
+		msg.Project = proj.Name
+		msg.Domains = domainNames
+	}
+
+	if err := cfg.SetSettings(params.Settings); err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if errs := cfg.Validate(); errs != nil {
+		c.JSON(422, gin.H{
+			"error":  "invalid_params",
+			"errors": errs,
+		})
+		return
+	}
+
+	tx := db.Begin()
+	if err := tx.Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := tx.Create(cfg).Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	// The config is published before committing, so that it is not saved if
+	// the edges could not be told about it.
+	msg.Version = cfg.ID
+	msg.Settings = cfg.Settings
+
+	m, err := pubsub.NewMessageWithJSON(exchanges.Edges, exchanges.RouteV1EdgeConfig, msg)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if err := m.Publish(); err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	cfgJSON, err := cfg.AsJSON()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"edge_config": cfgJSON,
+	})
+}
+
+// Edges lists the edge servers and the config version each of them runs.
+func Edges(c *gin.Context) {
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	latestVersion, err := edgeconfig.LatestVersion(db)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	edges, err := edge.All(db)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	edgesJSON := make([]interface{}, len(edges))
+	for i, e := range edges {
+		edgesJSON[i] = e.AsJSON(latestVersion)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"latest_version": latestVersion,
+		"edges":          edgesJSON,
+	})
+}
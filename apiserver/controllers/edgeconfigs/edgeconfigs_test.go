@@ -0,0 +1,268 @@
+package edgeconfigs_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/edge"
+	"github.com/nitrous-io/rise-server/apiserver/models/edgeconfig"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/server"
+	"github.com/nitrous-io/rise-server/pkg/mqconn"
+	"github.com/nitrous-io/rise-server/shared"
+	"github.com/nitrous-io/rise-server/shared/exchanges"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "edgeconfigs")
+}
+
+var _ = Describe("EdgeConfigs", func() {
+	var (
+		db  *gorm.DB
+		s   *httptest.Server
+		res *http.Response
+		err error
+
+		orgStatsToken string
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+
+		orgStatsToken = common.StatsToken
+		common.StatsToken = "statssecret"
+
+		s = httptest.NewServer(server.New())
+	})
+
+	AfterEach(func() {
+		if res != nil {
+			res.Body.Close()
+		}
+		s.Close()
+		common.StatsToken = orgStatsToken
+	})
+
+	readBody := func() string {
+		b := &bytes.Buffer{}
+		_, err := b.ReadFrom(res.Body)
+		Expect(err).To(BeNil())
+		return b.String()
+	}
+
+	Describe("GET /admin/edge_configs", func() {
+		It("lists the most recent configs, latest first", func() {
+			cfg1 := &edgeconfig.EdgeConfig{Settings: []byte(`{"blocked_ips": ["10.0.0.1"]}`)}
+			Expect(db.Create(cfg1).Error).To(BeNil())
+			cfg2 := &edgeconfig.EdgeConfig{Settings: []byte(`{"tls": {"min_version": "1.2"}}`)}
+			Expect(db.Create(cfg2).Error).To(BeNil())
+
+			res, err = testhelper.MakeRequest("GET", s.URL+"/admin/edge_configs", url.Values{"token": {"statssecret"}}, nil, nil)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+			var j struct {
+				Configs []map[string]interface{} `json:"edge_configs"`
+			}
+			Expect(json.Unmarshal([]byte(readBody()), &j)).To(BeNil())
+			Expect(j.Configs).To(HaveLen(2))
+			Expect(j.Configs[0]["version"]).To(BeEquivalentTo(cfg2.ID))
+			Expect(j.Configs[0]["settings"]).To(Equal(map[string]interface{}{
+				"tls": map[string]interface{}{"min_version": "1.2"},
+			}))
+			Expect(j.Configs[1]["version"]).To(BeEquivalentTo(cfg1.ID))
+		})
+
+		It("returns 401 without a valid admin token", func() {
+			res, err = testhelper.MakeRequest("GET", s.URL+"/admin/edge_configs", url.Values{"token": {"wrong"}}, nil, nil)
+			Expect(err).To(BeNil())
+			Expect(res.StatusCode).To(Equal(http.StatusUnauthorized))
+		})
+	})
+
+	Describe("POST /admin/edge_configs", func() {
+		var (
+			mq        mqconn.Conn
+			queueName string
+			body      string
+			proj      *project.Project
+		)
+
+		BeforeEach(func() {
+			mq, err = mqconn.MQ()
+			Expect(err).To(BeNil())
+
+			testhelper.DeleteExchange(mq, exchanges.All...)
+			queueName = testhelper.StartQueueWithExchange(mq, exchanges.Edges, exchanges.RouteV1EdgeConfig)
+
+			proj = factories.Project(db, nil, "foo-bar-express")
+			factories.Domain(db, proj, "www.foo-bar-express.com")
+
+			body = `{
+				"settings": {
+					"rate_limit": {"requests_per_second": 10, "burst": 20},
+					"blocked_ips": ["10.0.0.1", "192.168.0.0/16"]
+				}
+			}`
+		})
+
+		doRequest := func() {
+			req, err := http.NewRequest("POST", s.URL+"/admin/edge_configs?token=statssecret", bytes.NewBufferString(body))
+			Expect(err).To(BeNil())
+			req.Header.Set("Content-Type", "application/json")
+
+			res, err = http.DefaultClient.Do(req)
+			Expect(err).To(BeNil())
+		}
+
+		It("creates a global config and publishes it to the edges", func() {
+			doRequest()
+
+			Expect(res.StatusCode).To(Equal(http.StatusCreated))
+
+			var cfgs []*edgeconfig.EdgeConfig
+			Expect(db.Find(&cfgs).Error).To(BeNil())
+			Expect(cfgs).To(HaveLen(1))
+			Expect(cfgs[0].ProjectID).To(BeNil())
+
+			d := testhelper.ConsumeQueue(mq, queueName)
+			Expect(d).NotTo(BeNil())
+			Expect(d.Body).To(MatchJSON(fmt.Sprintf(`{
+				"version": %d,
+				"settings": {
+					"rate_limit": {"requests_per_second": 10, "burst": 20},
+					"blocked_ips": ["10.0.0.1", "192.168.0.0/16"]
+				}
+			}`, cfgs[0].ID)))
+		})
+
+		Context("when a project is given", func() {
+			BeforeEach(func() {
+				body = `{"project_name": "foo-bar-express", "settings": {"blocked_ips": ["10.0.0.1"]}}`
+			})
+
+			It("creates a config for the project and publishes it with the project's domains", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusCreated))
+
+				var cfgs []*edgeconfig.EdgeConfig
+				Expect(db.Find(&cfgs).Error).To(BeNil())
+				Expect(cfgs).To(HaveLen(1))
+				Expect(cfgs[0].ProjectID).NotTo(BeNil())
+				Expect(*cfgs[0].ProjectID).To(Equal(proj.ID))
+
+				d := testhelper.ConsumeQueue(mq, queueName)
+				Expect(d).NotTo(BeNil())
+				Expect(d.Body).To(MatchJSON(fmt.Sprintf(`{
+					"version": %d,
+					"project": "foo-bar-express",
+					"domains": ["foo-bar-express.%s", "www.foo-bar-express.com"],
+					"settings": {"blocked_ips": ["10.0.0.1"]}
+				}`, cfgs[0].ID, shared.DefaultDomain)))
+			})
+		})
+
+		Context("when the project does not exist", func() {
+			BeforeEach(func() {
+				body = `{"project_name": "no-such-project", "settings": {}}`
+			})
+
+			It("returns 404", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+			})
+		})
+
+		Context("when the settings are invalid", func() {
+			BeforeEach(func() {
+				body = `{"settings": {"tls": {"min_version": "0.9"}}}`
+			})
+
+			It("returns 422 and does not publish the config", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(readBody()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"errors": {
+						"tls": "min_version must be one of 1.0, 1.1, 1.2"
+					}
+				}`))
+
+				var count int
+				Expect(db.Model(edgeconfig.EdgeConfig{}).Count(&count).Error).To(BeNil())
+				Expect(count).To(Equal(0))
+
+				Expect(testhelper.ConsumeQueue(mq, queueName)).To(BeNil())
+			})
+		})
+
+		Context("when settings are missing", func() {
+			BeforeEach(func() {
+				body = `{}`
+			})
+
+			It("returns 400", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusBadRequest))
+			})
+		})
+	})
+
+	Describe("GET /admin/edges", func() {
+		It("lists the edges and whether they run the latest config", func() {
+			cfg1 := &edgeconfig.EdgeConfig{Settings: []byte(`{}`)}
+			Expect(db.Create(cfg1).Error).To(BeNil())
+			cfg2 := &edgeconfig.EdgeConfig{Settings: []byte(`{}`)}
+			Expect(db.Create(cfg2).Error).To(BeNil())
+
+			Expect(edge.Acknowledge(db, "edge-sg-1", cfg2.ID)).To(Succeed())
+			Expect(edge.Acknowledge(db, "edge-us-1", cfg1.ID)).To(Succeed())
+
+			res, err = testhelper.MakeRequest("GET", s.URL+"/admin/edges", url.Values{"token": {"statssecret"}}, nil, nil)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+			var j struct {
+				LatestVersion uint                     `json:"latest_version"`
+				Edges         []map[string]interface{} `json:"edges"`
+			}
+			Expect(json.Unmarshal([]byte(readBody()), &j)).To(BeNil())
+			Expect(j.LatestVersion).To(Equal(cfg2.ID))
+			Expect(j.Edges).To(HaveLen(2))
+			Expect(j.Edges[0]["name"]).To(Equal("edge-sg-1"))
+			Expect(j.Edges[0]["config_version"]).To(BeEquivalentTo(cfg2.ID))
+			Expect(j.Edges[0]["up_to_date"]).To(BeTrue())
+			Expect(j.Edges[1]["name"]).To(Equal("edge-us-1"))
+			Expect(j.Edges[1]["up_to_date"]).To(BeFalse())
+		})
+
+		It("returns 401 without a valid admin token", func() {
+			res, err = testhelper.MakeRequest("GET", s.URL+"/admin/edges", url.Values{"token": {"wrong"}}, nil, nil)
+			Expect(err).To(BeNil())
+			Expect(res.StatusCode).To(Equal(http.StatusUnauthorized))
+		})
+	})
+})
@@ -0,0 +1,63 @@
+package hooks
+
+import (
+	"crypto/sha256"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/repo"
+	"github.com/nitrous-io/rise-server/pkg/bitbucketapi"
+)
+
+func BitbucketPush(c *gin.Context) {
+	// See https://support.atlassian.com/bitbucket-cloud/docs/event-payloads/#Push
+	// for details of what Bitbucket POSTs to this endpoint.
+	var pl bitbucketapi.PushPayload
+	body, ok := readPayload(c, &pl)
+	if !ok {
+		return
+	}
+
+	if c.Request.Header.Get("X-Event-Key") != "repo:push" {
+		c.String(http.StatusAccepted, "Only push events are processed.")
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	rp, ok := findRepo(c, db, repo.ProviderBitbucket)
+	if !ok {
+		return
+	}
+
+	// A push can update several branches, so the commit of the repo's branch
+	// is looked for among them.
+	ref := pl.Head(rp.Branch)
+	if ref == "" {
+		c.String(http.StatusAccepted, "Payload is not for the %q branch, aborting.", rp.Branch)
+		return
+	}
+
+	if rp.WebhookSecret != "" {
+		// The X-Hub-Signature header contains the HMAC-SHA256 hex digest of the
+		// payload if the webhook's secret is non-empty (on Bitbucket).
+		sig := c.Request.Header.Get("X-Hub-Signature")
+		if sig == "" {
+			c.String(http.StatusAccepted, "Webhook secret empty. Please enter the Webhook Secret into the Secret textbox of your webhook on Bitbucket.")
+			return
+		}
+
+		if !validHMAC(sig, "sha256=", sha256.New, rp.WebhookSecret, body) {
+			c.String(http.StatusAccepted, "Webhook secret incorrect. Please enter the Webhook Secret into the Secret textbox of your webhook on Bitbucket.")
+			return
+		}
+	}
+
+	initiateDeployment(c, db, rp, ref, body)
+}
@@ -0,0 +1,222 @@
+package hooks_test
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/push"
+	"github.com/nitrous-io/rise-server/apiserver/models/repo"
+	"github.com/nitrous-io/rise-server/apiserver/server"
+	"github.com/nitrous-io/rise-server/pkg/mqconn"
+	"github.com/nitrous-io/rise-server/shared/queues"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Bitbucket", func() {
+	var (
+		db *gorm.DB
+		mq mqconn.Conn
+
+		s   *httptest.Server
+		res *http.Response
+		err error
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+
+		mq, err = mqconn.MQ()
+		Expect(err).To(BeNil())
+		testhelper.DeleteQueue(mq, queues.All...)
+	})
+
+	AfterEach(func() {
+		if res != nil {
+			res.Body.Close()
+		}
+		s.Close()
+	})
+
+	Describe("POST /hooks/bitbucket/:path", func() {
+		var (
+			reqBody io.Reader
+			headers http.Header
+			reqPath string
+
+			proj *project.Project
+			rp   *repo.Repo
+		)
+
+		BeforeEach(func() {
+			reqBody = bytes.NewBuffer(bbPushPayload)
+			headers = http.Header{}
+			headers.Set("X-Event-Key", "repo:push")
+
+			proj = factories.Project(db, nil)
+			rp = &repo.Repo{
+				ProjectID:     proj.ID,
+				UserID:        proj.UserID,
+				Provider:      repo.ProviderBitbucket,
+				URI:           "https://bitbucket.org/chuyeow/pubstorm-www.git",
+				Branch:        "master",
+				WebhookPath:   "defacedbeeffece5",
+				WebhookSecret: "secrud1985",
+			}
+			Expect(db.Create(rp).Error).To(BeNil())
+
+			reqPath = rp.WebhookPath
+
+			mac := hmac.New(sha256.New, []byte(rp.WebhookSecret))
+			mac.Write(bbPushPayload)
+			headers.Set("X-Hub-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			req, err := http.NewRequest("POST", s.URL+"/hooks/bitbucket/"+reqPath, reqBody)
+			Expect(err).To(BeNil())
+			req.Header.Set("Content-Type", "application/json")
+			for k, v := range headers {
+				for _, h := range v {
+					req.Header.Add(k, h)
+				}
+			}
+			res, err = http.DefaultClient.Do(req)
+			Expect(err).To(BeNil())
+		}
+
+		readBody := func() string {
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+			return b.String()
+		}
+
+		It("creates a deployment and a push for the commit of the repo's branch", func() {
+			doRequest()
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(readBody()).To(Equal("A deployment has been initiated by this push."))
+
+			depl := &deployment.Deployment{}
+			Expect(db.Last(depl).Error).To(BeNil())
+			Expect(depl.ProjectID).To(Equal(proj.ID))
+			Expect(depl.State).To(Equal(deployment.StatePendingUpload))
+
+			pu := &push.Push{}
+			Expect(db.Last(pu).Error).To(BeNil())
+			Expect(pu.RepoID).To(Equal(rp.ID))
+			Expect(pu.DeploymentID).To(Equal(depl.ID))
+			Expect(pu.Ref).To(Equal("5e908dc1f01e9e5ae2ff1314666e366cbc7260dc"))
+
+			Expect(testhelper.ConsumeQueue(mq, queues.Push)).NotTo(BeNil())
+		})
+
+		Context("when the X-Event-Key header is not repo:push", func() {
+			BeforeEach(func() {
+				headers.Set("X-Event-Key", "pullrequest:created")
+			})
+
+			It("responds with HTTP 202 Accepted but returns an error message", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+				Expect(readBody()).To(Equal("Only push events are processed."))
+			})
+		})
+
+		Context("when the push does not update the repo's branch", func() {
+			BeforeEach(func() {
+				Expect(db.Model(rp).Update("branch", "release").Error).To(BeNil())
+			})
+
+			It("responds with HTTP 202 Accepted but returns an error message", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+				Expect(readBody()).To(Equal(`Payload is not for the "release" branch, aborting.`))
+			})
+		})
+
+		Context("when the X-Hub-Signature header is empty", func() {
+			BeforeEach(func() {
+				headers.Del("X-Hub-Signature")
+			})
+
+			It("responds with HTTP 202 Accepted but returns an error message", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+				Expect(readBody()).To(Equal("Webhook secret empty. Please enter the Webhook Secret into the Secret textbox of your webhook on Bitbucket."))
+			})
+		})
+
+		Context("when the X-Hub-Signature header doesn't match the hex-encoded HMAC signature of the payload", func() {
+			BeforeEach(func() {
+				headers.Set("X-Hub-Signature", "sha256=this-definitely-wont-match")
+			})
+
+			It("responds with HTTP 202 Accepted but returns an error message", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+				Expect(readBody()).To(Equal("Webhook secret incorrect. Please enter the Webhook Secret into the Secret textbox of your webhook on Bitbucket."))
+
+				var count int
+				Expect(db.Model(deployment.Deployment{}).Count(&count).Error).To(BeNil())
+				Expect(count).To(Equal(0))
+			})
+		})
+	})
+})
+
+var bbPushPayload = []byte(`{
+  "push": {
+    "changes": [
+      {
+        "new": {
+          "type": "branch",
+          "name": "master",
+          "target": {
+            "type": "commit",
+            "hash": "5e908dc1f01e9e5ae2ff1314666e366cbc7260dc"
+          }
+        },
+        "old": {
+          "type": "branch",
+          "name": "master",
+          "target": {
+            "type": "commit",
+            "hash": "a0fbcc76e4b2453c35261419208e2f72c98b010f"
+          }
+        },
+        "created": false,
+        "closed": false,
+        "forced": false
+      }
+    ]
+  },
+  "repository": {
+    "type": "repository",
+    "name": "pubstorm-www",
+    "full_name": "chuyeow/pubstorm-www"
+  },
+  "actor": {
+    "type": "user",
+    "username": "chuyeow"
+  }
+}`)
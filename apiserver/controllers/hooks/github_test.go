@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"crypto/hmac"
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"io"
@@ -24,7 +25,6 @@ import (
 	"github.com/nitrous-io/rise-server/testhelper/factories"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
-	"github.com/streadway/amqp"
 )
 
 func Test(t *testing.T) {
@@ -35,7 +35,7 @@ func Test(t *testing.T) {
 var _ = Describe("GitHub", func() {
 	var (
 		db *gorm.DB
-		mq *amqp.Connection
+		mq mqconn.Conn
 
 		s   *httptest.Server
 		res *http.Response
@@ -132,6 +132,9 @@ var _ = Describe("GitHub", func() {
 			Expect(depl.UserID).To(Equal(rp.UserID))
 			Expect(depl.State).To(Equal(deployment.StatePendingUpload))
 			Expect(depl.Prefix).NotTo(HaveLen(0))
+			Expect(depl.Source).To(Equal(deployment.SourceWebhook))
+			Expect(depl.CommitSHA).To(Equal("5e908dc1f01e9e5ae2ff1314666e366cbc7260dc"))
+			Expect(depl.Branch).To(Equal("master"))
 			Expect(depl.Version).To(Equal(int64(1)))
 			Expect(depl.RawBundleID).To(BeNil())
 			Expect(depl.JsEnvVars).To(Equal([]byte("{}")))
@@ -247,6 +250,44 @@ var _ = Describe("GitHub", func() {
 		})
 
 		Context("when a webhook secret is set in the repo record", func() {
+			Context("when the X-Hub-Signature-256 header is set", func() {
+				BeforeEach(func() {
+					headers.Del("X-Hub-Signature")
+
+					mac := hmac.New(sha256.New, []byte(rp.WebhookSecret))
+					mac.Write(ghPushPayload)
+					headers.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+				})
+
+				It("verifies the SHA-256 signature and initiates a deployment", func() {
+					doRequest()
+
+					Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+					b := &bytes.Buffer{}
+					_, err = b.ReadFrom(res.Body)
+
+					Expect(b.String()).To(Equal("A deployment has been initiated by this push."))
+				})
+
+				Context("when it doesn't match the hex-encoded HMAC signature of the payload", func() {
+					BeforeEach(func() {
+						headers.Set("X-Hub-Signature-256", "sha256=this-definitely-wont-match")
+					})
+
+					It("responds with HTTP 202 Accepted but returns an error message", func() {
+						doRequest()
+
+						Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+
+						b := &bytes.Buffer{}
+						_, err = b.ReadFrom(res.Body)
+
+						Expect(b.String()).To(Equal("Webhook secret incorrect. Please enter the Webhook Secret into the Secret textbox of your webhook on GitHub."))
+					})
+				})
+			})
+
 			Context("when the X-Hub-Signature header is empty", func() {
 				BeforeEach(func() {
 					headers.Del("X-Hub-Signature")
@@ -0,0 +1,66 @@
+package hooks
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/repo"
+	"github.com/nitrous-io/rise-server/pkg/gitlabapi"
+)
+
+func GitLabPush(c *gin.Context) {
+	// See https://docs.gitlab.com/ee/user/project/integrations/webhook_events.html#push-events
+	// for details of what GitLab POSTs to this endpoint.
+	var pl gitlabapi.PushPayload
+	body, ok := readPayload(c, &pl)
+	if !ok {
+		return
+	}
+
+	if c.Request.Header.Get("X-Gitlab-Event") != "Push Hook" {
+		c.String(http.StatusAccepted, "Only push events are processed.")
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	rp, ok := findRepo(c, db, repo.ProviderGitLab)
+	if !ok {
+		return
+	}
+
+	if rp.Branch != pl.Branch() {
+		c.String(http.StatusAccepted, "Payload is not for the %q branch, aborting.", rp.Branch)
+		return
+	}
+
+	if rp.WebhookSecret != "" {
+		// GitLab does not sign payloads, but sends the webhook's secret token
+		// as is in the X-Gitlab-Token header.
+		token := c.Request.Header.Get("X-Gitlab-Token")
+		if token == "" {
+			c.String(http.StatusAccepted, "Webhook secret empty. Please enter the Webhook Secret into the Secret Token textbox of your webhook on GitLab.")
+			return
+		}
+
+		if subtle.ConstantTimeCompare([]byte(token), []byte(rp.WebhookSecret)) != 1 {
+			c.String(http.StatusAccepted, "Webhook secret incorrect. Please enter the Webhook Secret into the Secret Token textbox of your webhook on GitLab.")
+			return
+		}
+	}
+
+	// The checkout SHA is empty when the branch has been deleted.
+	if pl.CheckoutSHA == "" {
+		c.String(http.StatusAccepted, "Payload does not contain a commit to deploy, aborting.")
+		return
+	}
+
+	initiateDeployment(c, db, rp, pl.CheckoutSHA, body)
+}
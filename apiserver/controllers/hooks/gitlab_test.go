@@ -0,0 +1,217 @@
+package hooks_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/push"
+	"github.com/nitrous-io/rise-server/apiserver/models/repo"
+	"github.com/nitrous-io/rise-server/apiserver/server"
+	"github.com/nitrous-io/rise-server/pkg/mqconn"
+	"github.com/nitrous-io/rise-server/shared/queues"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("GitLab", func() {
+	var (
+		db *gorm.DB
+		mq mqconn.Conn
+
+		s   *httptest.Server
+		res *http.Response
+		err error
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+
+		mq, err = mqconn.MQ()
+		Expect(err).To(BeNil())
+		testhelper.DeleteQueue(mq, queues.All...)
+	})
+
+	AfterEach(func() {
+		if res != nil {
+			res.Body.Close()
+		}
+		s.Close()
+	})
+
+	Describe("POST /hooks/gitlab/:path", func() {
+		var (
+			reqBody io.Reader
+			headers http.Header
+			reqPath string
+
+			proj *project.Project
+			rp   *repo.Repo
+		)
+
+		BeforeEach(func() {
+			reqBody = bytes.NewBuffer(glPushPayload)
+			headers = http.Header{}
+			headers.Set("X-Gitlab-Event", "Push Hook")
+			headers.Set("X-Gitlab-Token", "secrud1985")
+
+			proj = factories.Project(db, nil)
+			rp = &repo.Repo{
+				ProjectID:     proj.ID,
+				UserID:        proj.UserID,
+				Provider:      repo.ProviderGitLab,
+				URI:           "https://gitlab.com/mike/diaspora.git",
+				Branch:        "master",
+				WebhookPath:   "defacedbeeffece5",
+				WebhookSecret: "secrud1985",
+			}
+			Expect(db.Create(rp).Error).To(BeNil())
+
+			reqPath = rp.WebhookPath
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			req, err := http.NewRequest("POST", s.URL+"/hooks/gitlab/"+reqPath, reqBody)
+			Expect(err).To(BeNil())
+			req.Header.Set("Content-Type", "application/json")
+			for k, v := range headers {
+				for _, h := range v {
+					req.Header.Add(k, h)
+				}
+			}
+			res, err = http.DefaultClient.Do(req)
+			Expect(err).To(BeNil())
+		}
+
+		readBody := func() string {
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+			return b.String()
+		}
+
+		It("creates a deployment and a push, and enqueues a push job", func() {
+			doRequest()
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(readBody()).To(Equal("A deployment has been initiated by this push."))
+
+			depl := &deployment.Deployment{}
+			Expect(db.Last(depl).Error).To(BeNil())
+			Expect(depl.ProjectID).To(Equal(proj.ID))
+			Expect(depl.State).To(Equal(deployment.StatePendingUpload))
+			Expect(depl.Source).To(Equal(deployment.SourceWebhook))
+
+			pu := &push.Push{}
+			Expect(db.Last(pu).Error).To(BeNil())
+			Expect(pu.RepoID).To(Equal(rp.ID))
+			Expect(pu.DeploymentID).To(Equal(depl.ID))
+			Expect(pu.Ref).To(Equal("da1560886d4f094c3e6c9ef40349f7d38b5d27d7"))
+			Expect(pu.Payload).To(Equal(string(glPushPayload)))
+
+			d := testhelper.ConsumeQueue(mq, queues.Push)
+			Expect(d).NotTo(BeNil())
+			Expect(d.Body).To(MatchJSON(fmt.Sprintf(`{
+				"push_id": %d
+			}`, pu.ID)))
+		})
+
+		Context("when the X-Gitlab-Event header is not a push hook", func() {
+			BeforeEach(func() {
+				headers.Set("X-Gitlab-Event", "Tag Push Hook")
+			})
+
+			It("responds with HTTP 202 Accepted but returns an error message", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+				Expect(readBody()).To(Equal("Only push events are processed."))
+			})
+		})
+
+		Context("when the webhook path belongs to a repo on another provider", func() {
+			BeforeEach(func() {
+				Expect(db.Model(rp).Update("provider", repo.ProviderGitHub).Error).To(BeNil())
+			})
+
+			It("responds with HTTP 202 Accepted but returns an error message", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+				Expect(readBody()).To(Equal("Payload URL unknown. Are you using the correct Webhook URL for your PubStorm project?"))
+			})
+		})
+
+		Context("when branch in payload is not the same as that of the repo", func() {
+			BeforeEach(func() {
+				Expect(db.Model(rp).Update("branch", "release").Error).To(BeNil())
+			})
+
+			It("responds with HTTP 202 Accepted but returns an error message", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+				Expect(readBody()).To(Equal(`Payload is not for the "release" branch, aborting.`))
+			})
+		})
+
+		Context("when the X-Gitlab-Token header is empty", func() {
+			BeforeEach(func() {
+				headers.Del("X-Gitlab-Token")
+			})
+
+			It("responds with HTTP 202 Accepted but returns an error message", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+				Expect(readBody()).To(Equal("Webhook secret empty. Please enter the Webhook Secret into the Secret Token textbox of your webhook on GitLab."))
+			})
+		})
+
+		Context("when the X-Gitlab-Token header doesn't match the webhook secret", func() {
+			BeforeEach(func() {
+				headers.Set("X-Gitlab-Token", "this-definitely-wont-match")
+			})
+
+			It("responds with HTTP 202 Accepted but returns an error message", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+				Expect(readBody()).To(Equal("Webhook secret incorrect. Please enter the Webhook Secret into the Secret Token textbox of your webhook on GitLab."))
+
+				var count int
+				Expect(db.Model(deployment.Deployment{}).Count(&count).Error).To(BeNil())
+				Expect(count).To(Equal(0))
+			})
+		})
+	})
+})
+
+var glPushPayload = []byte(`{
+  "object_kind": "push",
+  "before": "95790bf891e76fee5e1747ab589903a6a1f80f22",
+  "after": "da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+  "ref": "refs/heads/master",
+  "checkout_sha": "da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+  "user_name": "John Smith",
+  "user_username": "jsmith",
+  "project": {
+    "name": "Diaspora",
+    "web_url": "https://gitlab.com/mike/diaspora",
+    "path_with_namespace": "mike/diaspora",
+    "default_branch": "master"
+  },
+  "commits": [],
+  "total_commits_count": 1
+}`)
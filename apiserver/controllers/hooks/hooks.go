@@ -0,0 +1,193 @@
+package hooks
+
+import (
+	"crypto/hmac"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/push"
+	"github.com/nitrous-io/rise-server/apiserver/models/repo"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/pkg/job"
+	"github.com/nitrous-io/rise-server/shared/messages"
+	"github.com/nitrous-io/rise-server/shared/queues"
+)
+
+// readPayload reads the request body and unmarshals it into v. The body is
+// returned as well, as it is needed for signature verification.
+func readPayload(c *gin.Context, v interface{}) ([]byte, bool) {
+	// We slurp in the entire request body here (instead of using c.BindJSON())
+	// because we need it as a string for HMAC verification later.
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		c.String(http.StatusAccepted, "Failed to read payload.")
+		return nil, false
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		log.Errorf("failed to unmarshal JSON payload from webhook, err: %v", err)
+		c.String(http.StatusAccepted, "Payload is empty or is in an unexpected format.")
+		return nil, false
+	}
+
+	return body, true
+}
+
+// findRepo finds the repo of the given provider that the webhook path in the
+// request URL belongs to.
+func findRepo(c *gin.Context, db *gorm.DB, provider string) (*repo.Repo, bool) {
+	rp := &repo.Repo{}
+	if err := db.Where("webhook_path = ? AND provider = ?", c.Param("path"), provider).First(rp).Error; err != nil {
+		c.String(http.StatusAccepted, "Payload URL unknown. Are you using the correct Webhook URL for your PubStorm project?")
+		return nil, false
+	}
+
+	return rp, true
+}
+
+// validHMAC returns whether sig is the hex-encoded HMAC of body with the given
+// prefix, e.g. "sha1=7da1a65eadb87f7df30cc12131d3ff0151570204".
+func validHMAC(sig, prefix string, hashFunc func() hash.Hash, secret string, body []byte) bool {
+	mac := hmac.New(hashFunc, []byte(secret))
+	mac.Write(body)
+	expectedSig := prefix + hex.EncodeToString(mac.Sum(nil))
+
+	return hmac.Equal([]byte(sig), []byte(expectedSig))
+}
+
+// initiateDeployment creates a deployment of the project that a repo is linked
+// to for a push of the given commit, and enqueues a push job, which fetches
+// the repo and enqueues a build of the deployment.
+func initiateDeployment(c *gin.Context, db *gorm.DB, rp *repo.Repo, ref string, body []byte) {
+	unexpectedErr := func(err error) {
+		log.Errorf("hooks: unexpected error: %v", err)
+		c.String(http.StatusAccepted, "An unexpected error has occurred. If this problem persists, please contact PubStorm support.")
+	}
+
+	tx := db.Begin()
+	if err := tx.Error; err != nil {
+		unexpectedErr(err)
+		return
+	}
+	defer tx.Rollback()
+
+	var proj project.Project
+	if err := tx.First(&proj, rp.ProjectID).Error; err != nil {
+		unexpectedErr(err)
+		return
+	}
+
+	// Pushes are deployed on behalf of the user who linked the repo, who has
+	// to be able to deploy.
+	var u user.User
+	if err := tx.First(&u, rp.UserID).Error; err != nil {
+		unexpectedErr(err)
+		return
+	}
+	if err := u.CheckActive(); err != nil {
+		c.String(http.StatusAccepted, "Push not deployed, as the user who linked the repository cannot deploy: "+err.Error()+".")
+		return
+	}
+
+	if proj.IsFrozen(time.Now()) {
+		c.String(http.StatusAccepted, "Push not deployed, as deployments of the project are frozen.")
+		return
+	}
+
+	// TODO We should record more metadata:
+	// E.g. "Triggered by GitHub push by @chuyeow. Changes: https://github.com/PubStorm/pubstorm-www/compare/a0fbcc76e4b2...5e908dc1f01e."
+	depl := &deployment.Deployment{
+		ProjectID: rp.ProjectID,
+		UserID:    rp.UserID,
+		Source:    deployment.SourceWebhook,
+		Settings:  proj.DeploymentDefaults,
+		CommitSHA: ref,
+		Branch:    rp.Branch,
+	}
+
+	// Get JS environment variables from previous deployment.
+	if proj.ActiveDeploymentID != nil {
+		var prev deployment.Deployment
+		if err := tx.Where("id = ?", proj.ActiveDeploymentID).First(&prev).Error; err != nil {
+			unexpectedErr(err)
+			return
+		}
+
+		depl.CopyJsEnvVars(&prev)
+	}
+
+	ver, err := proj.NextVersion(tx)
+	if err != nil {
+		unexpectedErr(err)
+		return
+	}
+
+	depl.Version = ver
+	if err := tx.Create(depl).Error; err != nil {
+		unexpectedErr(err)
+		return
+	}
+
+	pu := &push.Push{
+		DeploymentID: depl.ID,
+		RepoID:       rp.ID,
+		Ref:          ref,
+		Payload:      string(body),
+	}
+	if err := tx.Create(pu).Error; err != nil {
+		unexpectedErr(err)
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		unexpectedErr(err)
+		return
+	}
+
+	jb, err := job.NewWithJSON(queues.Push, &messages.PushJobData{
+		PushID: pu.ID,
+	})
+	if err != nil {
+		unexpectedErr(err)
+		return
+	}
+	if err := jb.Enqueue(); err != nil {
+		unexpectedErr(err)
+		return
+	}
+
+	{
+		// Track event, attributing it to the user who setup the repo
+		// integration.
+		var (
+			event = "Initiated Project Deployment"
+			props = map[string]interface{}{
+				"projectName":       proj.Name,
+				"deploymentId":      depl.ID,
+				"deploymentPrefix":  depl.Prefix,
+				"deploymentVersion": depl.Version,
+				"deploymentSource":  depl.Source,
+				"source":            rp.ProviderName() + " push",
+			}
+			context = controllers.TrackingContext(c)
+		)
+		if err := common.Track(strconv.Itoa(int(rp.UserID)), event, "", props, context); err != nil {
+			log.Errorf("failed to track %q event for user ID %d, err: %v",
+				event, rp.UserID, err)
+		}
+	}
+
+	c.String(http.StatusOK, "A deployment has been initiated by this push.")
+}
@@ -0,0 +1,96 @@
+package jobs
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/jobrecord"
+)
+
+const (
+	// DefaultLimit is the number of jobs listed if no limit is given.
+	DefaultLimit = 50
+	// MaxLimit is the maximum number of jobs that can be listed at once.
+	MaxLimit = 500
+)
+
+// Index lists the most recently enqueued jobs, optionally filtered by queue
+// name and state.
+func Index(c *gin.Context) {
+	state := c.Query("state")
+	if state != "" && !jobrecord.ValidState(state) {
+		c.JSON(422, gin.H{
+			"error": "invalid_params",
+			"errors": map[string]string{
+				"state": "is invalid",
+			},
+		})
+		return
+	}
+
+	limit := DefaultLimit
+	if l := c.Query("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > MaxLimit {
+			c.JSON(422, gin.H{
+				"error": "invalid_params",
+				"errors": map[string]string{
+					"limit": "must be between 1 and " + strconv.Itoa(MaxLimit),
+				},
+			})
+			return
+		}
+		limit = n
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	records, err := jobrecord.List(db, c.Query("queue"), state, limit)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	jobsJSON := make([]interface{}, len(records))
+	for i, r := range records {
+		jobsJSON[i] = r.AsJSON()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"jobs": jobsJSON,
+	})
+}
+
+// Show returns the status of a job.
+func Show(c *gin.Context) {
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	r, err := jobrecord.FindByID(db, c.Param("id"))
+	if err != nil && err != jobrecord.ErrInvalidID {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if r == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "job could not be found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"job": r.AsJSON(),
+	})
+}
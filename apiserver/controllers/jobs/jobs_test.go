@@ -0,0 +1,248 @@
+package jobs_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/jobrecord"
+	"github.com/nitrous-io/rise-server/apiserver/server"
+	"github.com/nitrous-io/rise-server/pkg/job"
+	"github.com/nitrous-io/rise-server/testhelper"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "jobs")
+}
+
+var _ = Describe("Jobs", func() {
+	var (
+		db  *gorm.DB
+		s   *httptest.Server
+		res *http.Response
+		err error
+
+		orgStatsToken string
+		params        url.Values
+
+		rec *jobrecord.Recorder
+		ids []string
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+
+		orgStatsToken = common.StatsToken
+		common.StatsToken = "statssecret"
+
+		params = url.Values{
+			"token": {common.StatsToken},
+		}
+
+		rec = &jobrecord.Recorder{DB: db}
+		ids = nil
+		for _, queueName := range []string{"deploy", "build", "deploy"} {
+			id, err := rec.RecordEnqueued(job.New(queueName, []byte(`{"deployment_id":1}`)))
+			Expect(err).To(BeNil())
+			ids = append(ids, id)
+		}
+
+		Expect(rec.RecordStarted(ids[0])).To(BeNil())
+		Expect(rec.RecordFinished(ids[0], errors.New("s3 is down"), false)).To(BeNil())
+	})
+
+	AfterEach(func() {
+		common.StatsToken = orgStatsToken
+		if res != nil {
+			res.Body.Close()
+		}
+		s.Close()
+	})
+
+	jobIDs := func() []string {
+		b := &bytes.Buffer{}
+		_, err := b.ReadFrom(res.Body)
+		Expect(err).To(BeNil())
+
+		var j struct {
+			Jobs []struct {
+				ID json.Number `json:"id"`
+			} `json:"jobs"`
+		}
+		Expect(json.Unmarshal(b.Bytes(), &j)).To(BeNil())
+
+		var ids []string
+		for _, jb := range j.Jobs {
+			ids = append(ids, jb.ID.String())
+		}
+		return ids
+	}
+
+	Describe("GET /admin/jobs", func() {
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("GET", s.URL+"/admin/jobs", params, nil, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("returns the most recently enqueued jobs", func() {
+			doRequest()
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(jobIDs()).To(Equal([]string{ids[2], ids[1], ids[0]}))
+		})
+
+		It("filters jobs by queue and state", func() {
+			params.Set("queue", "deploy")
+			params.Set("state", jobrecord.StateFailed)
+			doRequest()
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(jobIDs()).To(Equal([]string{ids[0]}))
+		})
+
+		It("limits the number of jobs returned", func() {
+			params.Set("limit", "1")
+			doRequest()
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(jobIDs()).To(Equal([]string{ids[2]}))
+		})
+
+		It("returns 422 for an invalid state", func() {
+			params.Set("state", "exploded")
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(422))
+			Expect(b.String()).To(MatchJSON(`{
+				"error": "invalid_params",
+				"errors": {
+					"state": "is invalid"
+				}
+			}`))
+		})
+
+		It("returns 422 for an invalid limit", func() {
+			params.Set("limit", "501")
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(422))
+			Expect(b.String()).To(MatchJSON(`{
+				"error": "invalid_params",
+				"errors": {
+					"limit": "must be between 1 and 500"
+				}
+			}`))
+		})
+
+		It("returns 401 without a valid admin token", func() {
+			params.Set("token", "wrong")
+			doRequest()
+
+			Expect(res.StatusCode).To(Equal(http.StatusUnauthorized))
+		})
+
+		Context("when no admin token is configured", func() {
+			BeforeEach(func() {
+				common.StatsToken = ""
+			})
+
+			It("returns 401 unauthorized without a token", func() {
+				params.Del("token")
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusUnauthorized))
+			})
+
+			It("returns 401 unauthorized with an empty token", func() {
+				params.Set("token", "")
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusUnauthorized))
+			})
+		})
+	})
+
+	Describe("GET /admin/jobs/:id", func() {
+		var id string
+
+		BeforeEach(func() {
+			id = ids[0]
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("GET", s.URL+"/admin/jobs/"+id, params, nil, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("returns the status of the job", func() {
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+			var j struct {
+				Job map[string]interface{} `json:"job"`
+			}
+			Expect(json.Unmarshal(b.Bytes(), &j)).To(BeNil())
+			Expect(j.Job["queue_name"]).To(Equal("deploy"))
+			Expect(j.Job["data"]).To(Equal(map[string]interface{}{"deployment_id": 1.0}))
+			Expect(j.Job["state"]).To(Equal(jobrecord.StateFailed))
+			Expect(j.Job["attempts"]).To(Equal(1.0))
+			Expect(j.Job["last_error"]).To(Equal("s3 is down"))
+			Expect(j.Job["finished_at"]).NotTo(BeNil())
+		})
+
+		Context("when the job does not exist", func() {
+			BeforeEach(func() {
+				id = "0"
+			})
+
+			It("returns 404 not found", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "not_found",
+					"error_description": "job could not be found"
+				}`))
+			})
+		})
+
+		It("returns 401 without a valid admin token", func() {
+			params.Set("token", "wrong")
+			doRequest()
+
+			Expect(res.StatusCode).To(Equal(http.StatusUnauthorized))
+		})
+	})
+})
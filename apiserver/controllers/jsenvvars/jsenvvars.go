@@ -1,19 +1,24 @@
 package jsenvvars
 
 import (
-	"encoding/json"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
 	"github.com/nitrous-io/rise-server/apiserver/controllers"
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
 	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/outboxjob"
 	"github.com/nitrous-io/rise-server/apiserver/models/project"
 	"github.com/nitrous-io/rise-server/apiserver/models/user"
 	"github.com/nitrous-io/rise-server/pkg/job"
+	"github.com/nitrous-io/rise-server/shared/errcodes"
 	"github.com/nitrous-io/rise-server/shared/messages"
 	"github.com/nitrous-io/rise-server/shared/queues"
+	"golang.org/x/net/context"
 )
 
 func Add(c *gin.Context) {
@@ -40,6 +45,7 @@ func Add(c *gin.Context) {
 	if proj.ActiveDeploymentID == nil {
 		c.JSON(http.StatusPreconditionFailed, gin.H{
 			"error":             "precondition_failed",
+			"code":              errcodes.NoActiveDeployment,
 			"error_description": "current active deployment could not be found",
 		})
 		return
@@ -57,8 +63,8 @@ func Add(c *gin.Context) {
 		return
 	}
 
-	var currentJsEnvVars map[string]string
-	if err := json.Unmarshal(depl.JsEnvVars, &currentJsEnvVars); err != nil {
+	currentJsEnvVars, err := depl.DecryptedJsEnvVars(common.AesKeyring())
+	if err != nil {
 		controllers.InternalServerError(c, err)
 		return
 	}
@@ -78,7 +84,7 @@ func Add(c *gin.Context) {
 		return
 	}
 
-	newDepl, err := deployWithJsEnvVars(db, u, proj, &depl, &currentJsEnvVars)
+	newDepl, err := deployWithJsEnvVars(controllers.Context(c), db, u, proj, &depl, currentJsEnvVars, controllers.DeploymentSource(c))
 	if err != nil {
 		controllers.InternalServerError(c, err)
 		return
@@ -96,6 +102,7 @@ func Delete(c *gin.Context) {
 	if proj.ActiveDeploymentID == nil {
 		c.JSON(http.StatusPreconditionFailed, gin.H{
 			"error":             "precondition_failed",
+			"code":              errcodes.NoActiveDeployment,
 			"error_description": "current active deployment could not be found",
 		})
 		return
@@ -127,8 +134,8 @@ func Delete(c *gin.Context) {
 		return
 	}
 
-	var currentJsEnvVars map[string]string
-	if err := json.Unmarshal(depl.JsEnvVars, &currentJsEnvVars); err != nil {
+	currentJsEnvVars, err := depl.DecryptedJsEnvVars(common.AesKeyring())
+	if err != nil {
 		controllers.InternalServerError(c, err)
 		return
 	}
@@ -148,7 +155,7 @@ func Delete(c *gin.Context) {
 		return
 	}
 
-	newDepl, err := deployWithJsEnvVars(db, u, proj, &depl, &currentJsEnvVars)
+	newDepl, err := deployWithJsEnvVars(controllers.Context(c), db, u, proj, &depl, currentJsEnvVars, controllers.DeploymentSource(c))
 	if err != nil {
 		controllers.InternalServerError(c, err)
 		return
@@ -165,6 +172,7 @@ func Index(c *gin.Context) {
 	if proj.ActiveDeploymentID == nil {
 		c.JSON(http.StatusPreconditionFailed, gin.H{
 			"error":             "precondition_failed",
+			"code":              errcodes.NoActiveDeployment,
 			"error_description": "current active deployment could not be found",
 		})
 		return
@@ -182,8 +190,8 @@ func Index(c *gin.Context) {
 		return
 	}
 
-	var jsEnvVars map[string]string
-	if err := json.Unmarshal(depl.JsEnvVars, &jsEnvVars); err != nil {
+	jsEnvVars, err := depl.DecryptedJsEnvVars(common.AesKeyring())
+	if err != nil {
 		controllers.InternalServerError(c, err)
 		return
 	}
@@ -194,26 +202,160 @@ func Index(c *gin.Context) {
 	return
 }
 
-func deployWithJsEnvVars(db *gorm.DB, u *user.User, proj *project.Project, currentDepl *deployment.Deployment, jsEnvVars *map[string]string) (*deployment.Deployment, error) {
-	updatedJSON, err := json.Marshal(&jsEnvVars)
+// secretKeyPatterns are substrings of the names of JS env vars whose values
+// are masked in diffs.
+var secretKeyPatterns = []string{"SECRET", "TOKEN", "PASSWORD", "PASSWD", "PRIVATE", "CREDENTIAL", "API_KEY", "APIKEY", "ACCESS_KEY"}
+
+const maskedValue = "********"
+
+// change is a JS env var whose value differs between two deployments.
+type change struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Diff returns the JS env vars that were added, removed or changed in a
+// deployment compared to another deployment of the project. Values of vars
+// that look like secrets are masked.
+func Diff(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	deploymentID, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
-		return nil, err
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "deployment could not be found",
+		})
+		return
+	}
+
+	against := c.Query("against")
+	if against == "" {
+		c.JSON(422, gin.H{
+			"error": "invalid_params",
+			"errors": map[string]interface{}{
+				"against": "is required",
+			},
+		})
+		return
+	}
+	againstID, err := strconv.ParseInt(against, 10, 64)
+	if err != nil {
+		c.JSON(422, gin.H{
+			"error": "invalid_params",
+			"errors": map[string]interface{}{
+				"against": "is invalid",
+			},
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	var depls [2]*deployment.Deployment
+	for i, id := range []int64{deploymentID, againstID} {
+		depl := &deployment.Deployment{}
+		if err := db.Where("project_id = ?", proj.ID).First(depl, id).Error; err != nil {
+			if err == gorm.RecordNotFound {
+				c.JSON(http.StatusNotFound, gin.H{
+					"error":             "not_found",
+					"error_description": "deployment could not be found",
+				})
+				return
+			}
+			controllers.InternalServerError(c, err)
+			return
+		}
+		depls[i] = depl
+	}
+
+	to, err := depls[0].DecryptedJsEnvVars(common.AesKeyring())
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+	from, err := depls[1].DecryptedJsEnvVars(common.AesKeyring())
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	added := map[string]string{}
+	removed := map[string]string{}
+	changed := map[string]change{}
+
+	for key, value := range to {
+		fromValue, ok := from[key]
+		if !ok {
+			added[key] = maskIfSecret(key, value)
+		} else if fromValue != value {
+			changed[key] = change{
+				From: maskIfSecret(key, fromValue),
+				To:   maskIfSecret(key, value),
+			}
+		}
+	}
+	for key, value := range from {
+		if _, ok := to[key]; !ok {
+			removed[key] = maskIfSecret(key, value)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"added":   added,
+		"removed": removed,
+		"changed": changed,
+	})
+}
+
+// maskIfSecret returns the value of a JS env var, or a mask if its name looks
+// like that of a secret.
+func maskIfSecret(key, value string) string {
+	upperKey := strings.ToUpper(key)
+	for _, pattern := range secretKeyPatterns {
+		if strings.Contains(upperKey, pattern) {
+			return maskedValue
+		}
 	}
+	return value
+}
 
+func deployWithJsEnvVars(ctx context.Context, db *gorm.DB, u *user.User, proj *project.Project, currentDepl *deployment.Deployment, jsEnvVars map[string]string, source string) (*deployment.Deployment, error) {
 	newDepl := &deployment.Deployment{
 		ProjectID:   proj.ID,
 		UserID:      u.ID,
-		JsEnvVars:   updatedJSON,
 		RawBundleID: currentDepl.RawBundleID,
+		RootDir:     currentDepl.RootDir,
+		Source:      source,
+		Settings:    proj.DeploymentDefaults,
+
+		// The files of the current deployment are deployed again, so it is
+		// the same commit.
+		CommitSHA:     currentDepl.CommitSHA,
+		CommitMessage: currentDepl.CommitMessage,
+		Branch:        currentDepl.Branch,
+	}
+	if err := newDepl.SetJsEnvVars(jsEnvVars, common.AesKeyring()); err != nil {
+		return nil, err
+	}
+
+	tx, err := dbconn.Begin(ctx)
+	if err != nil {
+		return nil, err
 	}
+	defer tx.Rollback()
 
-	ver, err := proj.NextVersion(db)
+	ver, err := proj.NextVersion(tx)
 	if err != nil {
 		return nil, err
 	}
 
 	newDepl.Version = ver
-	if err := db.Create(newDepl).Error; err != nil {
+	if err := tx.Create(newDepl).Error; err != nil {
 		return nil, err
 	}
 
@@ -222,13 +364,20 @@ func deployWithJsEnvVars(db *gorm.DB, u *user.User, proj *project.Project, curre
 		return nil, err
 	}
 
-	if err := j.Enqueue(); err != nil {
+	ob, err := outboxjob.Add(tx, j)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := newDepl.UpdateState(tx, deployment.StatePendingBuild); err != nil {
 		return nil, err
 	}
 
-	if err := newDepl.UpdateState(db, deployment.StatePendingBuild); err != nil {
+	if err := tx.Commit().Error; err != nil {
 		return nil, err
 	}
 
+	outboxjob.DeliverAll(db, ob)
+
 	return newDepl, nil
 }
@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
 	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
 	"github.com/nitrous-io/rise-server/apiserver/models/oauthclient"
@@ -26,7 +27,6 @@ import (
 	"github.com/nitrous-io/rise-server/testhelper/factories"
 	"github.com/nitrous-io/rise-server/testhelper/fake"
 	"github.com/nitrous-io/rise-server/testhelper/sharedexamples"
-	"github.com/streadway/amqp"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
@@ -41,7 +41,7 @@ func Test(t *testing.T) {
 var _ = Describe("JSEnvVars", func() {
 	var (
 		db *gorm.DB
-		mq *amqp.Connection
+		mq mqconn.Conn
 
 		s   *httptest.Server
 		res *http.Response
@@ -53,9 +53,14 @@ var _ = Describe("JSEnvVars", func() {
 
 		headers http.Header
 		proj    *project.Project
+
+		origAesKey string
 	)
 
 	BeforeEach(func() {
+		origAesKey = common.AesKey
+		common.AesKey = "something-something-something-32"
+
 		mq, err = mqconn.MQ()
 		Expect(err).To(BeNil())
 
@@ -79,6 +84,7 @@ var _ = Describe("JSEnvVars", func() {
 	})
 
 	AfterEach(func() {
+		common.AesKey = origAesKey
 		if res != nil {
 			res.Body.Close()
 		}
@@ -172,6 +178,7 @@ var _ = Describe("JSEnvVars", func() {
 						"id":      newDepl.ID,
 						"state":   deployment.StatePendingBuild,
 						"version": newDepl.Version,
+						"source":  deployment.SourceAPI,
 					},
 				}
 
@@ -179,7 +186,11 @@ var _ = Describe("JSEnvVars", func() {
 				Expect(err).To(BeNil())
 				Expect(b.String()).To(MatchJSON(expectedJSON))
 
-				Expect(newDepl.JsEnvVars).To(MatchJSON(`{"foo": "bar"}`))
+				Expect(newDepl.EncryptedJsEnvVars).NotTo(BeNil())
+				Expect(newDepl.JsEnvVars).To(MatchJSON(`{}`))
+				jsEnvVars, err := newDepl.DecryptedJsEnvVars(common.AesKeyring())
+				Expect(err).To(BeNil())
+				Expect(jsEnvVars).To(Equal(map[string]string{"foo": "bar"}))
 				Expect(newDepl.RawBundleID).To(Equal(depl.RawBundleID))
 			})
 
@@ -249,6 +260,7 @@ var _ = Describe("JSEnvVars", func() {
 				doRequest()
 			}, http.StatusPreconditionFailed, `{
 				"error":             "precondition_failed",
+				"code": "no_active_deployment",
 				"error_description": "current active deployment could not be found"
 			}`),
 			Entry("when request body is invalid json", func() {
@@ -363,6 +375,7 @@ var _ = Describe("JSEnvVars", func() {
 						"id":      newDepl.ID,
 						"state":   deployment.StatePendingBuild,
 						"version": newDepl.Version,
+						"source":  deployment.SourceAPI,
 					},
 				}
 
@@ -370,7 +383,11 @@ var _ = Describe("JSEnvVars", func() {
 				Expect(err).To(BeNil())
 				Expect(b.String()).To(MatchJSON(expectedJSON))
 
-				Expect(newDepl.JsEnvVars).To(MatchJSON(`{"quux": "corge"}`))
+				Expect(newDepl.EncryptedJsEnvVars).NotTo(BeNil())
+				Expect(newDepl.JsEnvVars).To(MatchJSON(`{}`))
+				jsEnvVars, err := newDepl.DecryptedJsEnvVars(common.AesKeyring())
+				Expect(err).To(BeNil())
+				Expect(jsEnvVars).To(Equal(map[string]string{"quux": "corge"}))
 				Expect(newDepl.RawBundleID).To(Equal(depl.RawBundleID))
 			})
 
@@ -440,6 +457,7 @@ var _ = Describe("JSEnvVars", func() {
 				doRequest()
 			}, http.StatusPreconditionFailed, `{
 				"error":             "precondition_failed",
+				"code": "no_active_deployment",
 				"error_description": "current active deployment could not be found"
 			}`),
 			Entry("when request body is empty", func() {
@@ -535,6 +553,7 @@ var _ = Describe("JSEnvVars", func() {
 				doRequest()
 			}, http.StatusPreconditionFailed, `{
 				"error":             "precondition_failed",
+				"code": "no_active_deployment",
 				"error_description": "current active deployment could not be found"
 			}`),
 		)
@@ -553,4 +572,113 @@ var _ = Describe("JSEnvVars", func() {
 			return res
 		}, nil)
 	})
+
+	Describe("GET /projects/:project_name/deployments/:id/jsenvvars/diff", func() {
+		var (
+			depl1, depl2 *deployment.Deployment
+			against      string
+		)
+
+		BeforeEach(func() {
+			depl1 = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				State:     deployment.StateDeployed,
+				JsEnvVars: []byte(`{"foo":"bar","baz":"qux","API_URL":"https://old.example.com","STRIPE_SECRET":"sk_old","REMOVED_TOKEN":"t0k3n"}`),
+			})
+			depl2 = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+				State:     deployment.StateDeployed,
+				JsEnvVars: []byte(`{"foo":"bar","quux":"corge","API_URL":"https://new.example.com","STRIPE_SECRET":"sk_new","github_token":"gh"}`),
+			})
+			against = fmt.Sprintf("%d", depl1.ID)
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			path := fmt.Sprintf("%s/projects/foo-bar-express/deployments/%d/jsenvvars/diff?against=%s", s.URL, depl2.ID, against)
+			res, err = testhelper.MakeRequest("GET", path, nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("returns added, removed and changed vars with secrets masked", func() {
+			doRequest()
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(b.String()).To(MatchJSON(`{
+				"added": {
+					"quux": "corge",
+					"github_token": "********"
+				},
+				"removed": {
+					"baz": "qux",
+					"REMOVED_TOKEN": "********"
+				},
+				"changed": {
+					"API_URL": {
+						"from": "https://old.example.com",
+						"to": "https://new.example.com"
+					},
+					"STRIPE_SECRET": {
+						"from": "********",
+						"to": "********"
+					}
+				}
+			}`))
+		})
+
+		DescribeTable("errors",
+			func(setup func(), expectedCode int, expectedBody string) {
+				setup()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(expectedCode))
+				Expect(b.String()).To(MatchJSON(expectedBody))
+			},
+			Entry("when against is missing", func() {
+				against = ""
+				doRequest()
+			}, 422, `{
+				"error": "invalid_params",
+				"errors": {
+					"against": "is required"
+				}
+			}`),
+			Entry("when against is not a number", func() {
+				against = "cafebabe"
+				doRequest()
+			}, 422, `{
+				"error": "invalid_params",
+				"errors": {
+					"against": "is invalid"
+				}
+			}`),
+			Entry("when the other deployment belongs to another project", func() {
+				otherProj := factories.Project(db, u)
+				Expect(db.Model(depl1).Update("project_id", otherProj.ID).Error).To(BeNil())
+				doRequest()
+			}, http.StatusNotFound, `{
+				"error": "not_found",
+				"error_description": "deployment could not be found"
+			}`),
+		)
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
 })
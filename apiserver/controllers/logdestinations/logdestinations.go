@@ -0,0 +1,144 @@
+package logdestinations
+
+import (
+	"net/http"
+	"strconv"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/logdestination"
+)
+
+// Show displays the S3 bucket to which access logs of a project are delivered.
+func Show(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	dest, err := logdestination.FindByProjectID(db, proj.ID)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if dest == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "log destination could not be found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"log_destination": dest.AsJSON(),
+	})
+}
+
+// Update sets the S3 bucket to which access logs of a project are delivered.
+// The access key is optional - if it is not given, the bucket policy must allow
+// PubStorm to write to the bucket.
+func Update(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	dest, err := logdestination.FindByProjectID(db, proj.ID)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if dest == nil {
+		dest = &logdestination.LogDestination{ProjectID: proj.ID}
+	}
+
+	accessKeyID := c.PostForm("access_key_id")
+
+	dest.BucketName = c.PostForm("bucket_name")
+	dest.BucketRegion = c.PostForm("bucket_region")
+	dest.Prefix = c.PostForm("prefix")
+	dest.AccessKeyID = &accessKeyID
+	dest.SecretAccessKey = c.PostForm("secret_access_key")
+
+	if errs := dest.Validate(); errs != nil {
+		c.JSON(422, gin.H{
+			"error":  "invalid_params",
+			"errors": errs,
+		})
+		return
+	}
+
+	if accessKeyID == "" {
+		dest.AccessKeyID = nil
+		dest.EncryptedSecretAccessKey = nil
+	} else if err := dest.EncryptSecretAccessKey(common.AesKeyring()); err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if err := db.Save(dest).Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	{
+		u := controllers.CurrentUser(c)
+
+		var (
+			event = "Set Log Destination"
+			props = map[string]interface{}{
+				"projectName": proj.Name,
+				"bucketName":  dest.BucketName,
+			}
+			context = controllers.TrackingContext(c)
+		)
+		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
+			log.Errorf("failed to track %q event for user ID %d, err: %v",
+				event, u.ID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"log_destination": dest.AsJSON(),
+	})
+}
+
+// Destroy stops delivering access logs of a project.
+func Destroy(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	q := db.Delete(logdestination.LogDestination{}, "project_id = ?", proj.ID)
+	if err := q.Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if q.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "log destination could not be found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"deleted": true,
+	})
+}
@@ -0,0 +1,271 @@
+// Package lookup lets admins find out which account owns a domain or project,
+// and what has happened to it, when handling abuse reports and support
+// tickets. Deleted records are included, since reports often concern sites
+// that have since been taken down.
+package lookup
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/domain"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/shared"
+)
+
+// DeploymentHistoryLimit is the number of most recent deployments listed for
+// each project.
+const DeploymentHistoryLimit = 20
+
+type userJSON struct {
+	ID           uint       `json:"id"`
+	Email        string     `json:"email"`
+	Name         string     `json:"name"`
+	Organization string     `json:"organization"`
+	State        string     `json:"state"`
+	ConfirmedAt  *time.Time `json:"confirmed_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+	DeletedAt    *time.Time `json:"deleted_at"`
+}
+
+type domainJSON struct {
+	Name      string     `json:"name"`
+	State     string     `json:"state"`
+	Default   bool       `json:"default,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	DeletedAt *time.Time `json:"deleted_at"`
+}
+
+type deploymentJSON struct {
+	*deployment.JSON
+	UserID    uint       `json:"user_id"`
+	PurgedAt  *time.Time `json:"purged_at"`
+	CreatedAt time.Time  `json:"created_at"`
+	DeletedAt *time.Time `json:"deleted_at"`
+}
+
+type projectJSON struct {
+	ID                   uint              `json:"id"`
+	Name                 string            `json:"name"`
+	DefaultDomainEnabled bool              `json:"default_domain_enabled"`
+	Locked               bool              `json:"locked"`
+	CreatedAt            time.Time         `json:"created_at"`
+	DeletedAt            *time.Time        `json:"deleted_at"`
+	Owner                *userJSON         `json:"owner"`
+	Collaborators        []*userJSON       `json:"collaborators"`
+	Domains              []*domainJSON     `json:"domains"`
+	Deployments          []*deploymentJSON `json:"deployments"`
+}
+
+// Domain looks up the projects that a domain has belonged to, with their
+// owners. Default domains are looked up by their project name.
+func Domain(c *gin.Context) {
+	name := strings.ToLower(strings.TrimSpace(c.Param("name")))
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	domainsJSON := []interface{}{}
+
+	if projName := strings.TrimSuffix(name, "."+shared.DefaultDomain); projName != name {
+		projs, err := project.FindAllByName(db, projName)
+		if err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+
+		for _, proj := range projs {
+			projJSON, err := projectReport(db, proj)
+			if err != nil {
+				controllers.InternalServerError(c, err)
+				return
+			}
+
+			domainsJSON = append(domainsJSON, struct {
+				*domainJSON
+				Project *projectJSON `json:"project"`
+			}{defaultDomainJSON(proj), projJSON})
+		}
+	} else {
+		doms, err := domain.FindAllByName(db, name)
+		if err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+
+		for _, dom := range doms {
+			var projJSON *projectJSON
+
+			proj, err := project.FindByIDUnscoped(db, dom.ProjectID)
+			if err != nil {
+				controllers.InternalServerError(c, err)
+				return
+			}
+			if proj != nil {
+				projJSON, err = projectReport(db, proj)
+				if err != nil {
+					controllers.InternalServerError(c, err)
+					return
+				}
+			}
+
+			domainsJSON = append(domainsJSON, struct {
+				*domainJSON
+				Project *projectJSON `json:"project"`
+			}{asDomainJSON(dom), projJSON})
+		}
+	}
+
+	if len(domainsJSON) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "domain could not be found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"domains": domainsJSON,
+	})
+}
+
+// Project looks up the projects that have had a name, with their owners,
+// collaborators, domains and recent deployments.
+func Project(c *gin.Context) {
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	projs, err := project.FindAllByName(db, strings.ToLower(strings.TrimSpace(c.Param("name"))))
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if len(projs) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "project could not be found",
+		})
+		return
+	}
+
+	projsJSON := make([]*projectJSON, len(projs))
+	for i, proj := range projs {
+		projsJSON[i], err = projectReport(db, proj)
+		if err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"projects": projsJSON,
+	})
+}
+
+func projectReport(db *gorm.DB, proj *project.Project) (*projectJSON, error) {
+	j := &projectJSON{
+		ID:                   proj.ID,
+		Name:                 proj.Name,
+		DefaultDomainEnabled: proj.DefaultDomainEnabled,
+		Locked:               proj.IsLocked(),
+		CreatedAt:            proj.CreatedAt,
+		DeletedAt:            proj.DeletedAt,
+		Collaborators:        []*userJSON{},
+		Domains:              []*domainJSON{},
+		Deployments:          []*deploymentJSON{},
+	}
+
+	owner := &user.User{}
+	if err := db.Unscoped().Where("id = ?", proj.UserID).First(owner).Error; err != nil {
+		if err != gorm.RecordNotFound {
+			return nil, err
+		}
+	} else {
+		j.Owner = asUserJSON(owner)
+	}
+
+	var collaborators []*user.User
+	if err := db.Unscoped().Where("id IN (SELECT user_id FROM collabs WHERE project_id = ? AND deleted_at IS NULL)", proj.ID).Order("email ASC").Find(&collaborators).Error; err != nil {
+		return nil, err
+	}
+	for _, u := range collaborators {
+		j.Collaborators = append(j.Collaborators, asUserJSON(u))
+	}
+
+	doms, err := domain.FindAllByProjectID(db, proj.ID)
+	if err != nil {
+		return nil, err
+	}
+	j.Domains = append(j.Domains, defaultDomainJSON(proj))
+	for _, dom := range doms {
+		j.Domains = append(j.Domains, asDomainJSON(dom))
+	}
+
+	depls, err := deployment.History(db, proj.ID, DeploymentHistoryLimit)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range depls {
+		dj := d.AsJSON()
+		dj.Active = proj.ActiveDeploymentID != nil && *proj.ActiveDeploymentID == d.ID
+		j.Deployments = append(j.Deployments, &deploymentJSON{
+			JSON:      dj,
+			UserID:    d.UserID,
+			PurgedAt:  d.PurgedAt,
+			CreatedAt: d.CreatedAt,
+			DeletedAt: d.DeletedAt,
+		})
+	}
+
+	return j, nil
+}
+
+func asUserJSON(u *user.User) *userJSON {
+	return &userJSON{
+		ID:           u.ID,
+		Email:        u.Email,
+		Name:         u.Name,
+		Organization: u.Organization,
+		State:        u.State,
+		ConfirmedAt:  u.ConfirmedAt,
+		CreatedAt:    u.CreatedAt,
+		DeletedAt:    u.DeletedAt,
+	}
+}
+
+func asDomainJSON(dom *domain.Domain) *domainJSON {
+	return &domainJSON{
+		Name:      dom.Name,
+		State:     dom.State,
+		CreatedAt: dom.CreatedAt,
+		DeletedAt: dom.DeletedAt,
+	}
+}
+
+func defaultDomainJSON(proj *project.Project) *domainJSON {
+	state := domain.StateActive
+	if !proj.DefaultDomainEnabled {
+		state = "disabled"
+	}
+	return &domainJSON{
+		Name:      proj.DefaultDomainName(),
+		State:     state,
+		Default:   true,
+		CreatedAt: proj.CreatedAt,
+		DeletedAt: proj.DeletedAt,
+	}
+}
@@ -0,0 +1,302 @@
+package lookup_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/domain"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/apiserver/server"
+	"github.com/nitrous-io/rise-server/shared"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "lookup")
+}
+
+type lookupJSON struct {
+	Domains []struct {
+		Name      string             `json:"name"`
+		DeletedAt *string            `json:"deleted_at"`
+		Default   bool               `json:"default"`
+		Project   *lookupProjectJSON `json:"project"`
+	} `json:"domains"`
+	Projects []*lookupProjectJSON `json:"projects"`
+}
+
+type lookupProjectJSON struct {
+	ID        uint    `json:"id"`
+	Name      string  `json:"name"`
+	DeletedAt *string `json:"deleted_at"`
+	Owner     *struct {
+		ID    uint   `json:"id"`
+		Email string `json:"email"`
+		State string `json:"state"`
+	} `json:"owner"`
+	Collaborators []struct {
+		Email string `json:"email"`
+	} `json:"collaborators"`
+	Domains []struct {
+		Name      string  `json:"name"`
+		DeletedAt *string `json:"deleted_at"`
+	} `json:"domains"`
+	Deployments []struct {
+		ID        uint    `json:"id"`
+		Active    bool    `json:"active"`
+		DeletedAt *string `json:"deleted_at"`
+	} `json:"deployments"`
+}
+
+var _ = Describe("Lookup", func() {
+	var (
+		db  *gorm.DB
+		s   *httptest.Server
+		res *http.Response
+		err error
+
+		orgStatsToken string
+		params        url.Values
+
+		u, u2 *user.User
+		proj  *project.Project
+		dm    *domain.Domain
+		depl  *deployment.Deployment
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+
+		orgStatsToken = common.StatsToken
+		common.StatsToken = "statssecret"
+
+		params = url.Values{
+			"token": {common.StatsToken},
+		}
+
+		u = factories.User(db)
+		u2 = factories.User(db)
+
+		proj = factories.Project(db, u, "foo-bar-express")
+		factories.Collab(db, proj, u2)
+		dm = factories.Domain(db, proj, "www.foo-bar-express.com")
+
+		depl = factories.Deployment(db, proj, u, deployment.StateDeployed)
+		proj.ActiveDeploymentID = &depl.ID
+		Expect(db.Save(proj).Error).To(BeNil())
+	})
+
+	AfterEach(func() {
+		common.StatsToken = orgStatsToken
+		if res != nil {
+			res.Body.Close()
+		}
+		s.Close()
+	})
+
+	parseBody := func() *lookupJSON {
+		b := &bytes.Buffer{}
+		_, err := b.ReadFrom(res.Body)
+		Expect(err).To(BeNil())
+
+		j := &lookupJSON{}
+		Expect(json.Unmarshal(b.Bytes(), j)).To(BeNil())
+		return j
+	}
+
+	Describe("GET /admin/domains/:name", func() {
+		var name string
+
+		BeforeEach(func() {
+			name = dm.Name
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("GET", s.URL+"/admin/domains/"+name, params, nil, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("returns 401 without the admin token", func() {
+			params.Del("token")
+			doRequest()
+
+			Expect(res.StatusCode).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("returns the project and owner of the domain", func() {
+			doRequest()
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+			j := parseBody()
+			Expect(j.Domains).To(HaveLen(1))
+			Expect(j.Domains[0].Name).To(Equal(dm.Name))
+			Expect(j.Domains[0].DeletedAt).To(BeNil())
+
+			p := j.Domains[0].Project
+			Expect(p).NotTo(BeNil())
+			Expect(p.ID).To(Equal(proj.ID))
+			Expect(p.Owner.ID).To(Equal(u.ID))
+			Expect(p.Owner.Email).To(Equal(u.Email))
+			Expect(p.Collaborators).To(HaveLen(1))
+			Expect(p.Collaborators[0].Email).To(Equal(u2.Email))
+			Expect(p.Deployments).To(HaveLen(1))
+			Expect(p.Deployments[0].ID).To(Equal(depl.ID))
+			Expect(p.Deployments[0].Active).To(BeTrue())
+		})
+
+		Context("when the domain has been deleted and added to another project", func() {
+			var proj2 *project.Project
+
+			BeforeEach(func() {
+				Expect(db.Delete(dm).Error).To(BeNil())
+
+				proj2 = factories.Project(db, u2)
+				factories.Domain(db, proj2, dm.Name)
+			})
+
+			It("returns every project that the domain has belonged to", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				j := parseBody()
+				Expect(j.Domains).To(HaveLen(2))
+				Expect(j.Domains[0].DeletedAt).To(BeNil())
+				Expect(j.Domains[0].Project.ID).To(Equal(proj2.ID))
+				Expect(j.Domains[0].Project.Owner.ID).To(Equal(u2.ID))
+				Expect(j.Domains[1].DeletedAt).NotTo(BeNil())
+				Expect(j.Domains[1].Project.ID).To(Equal(proj.ID))
+			})
+		})
+
+		Context("when a default domain is given", func() {
+			BeforeEach(func() {
+				name = proj.Name + "." + shared.DefaultDomain
+			})
+
+			It("returns the project of the default domain", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				j := parseBody()
+				Expect(j.Domains).To(HaveLen(1))
+				Expect(j.Domains[0].Default).To(BeTrue())
+				Expect(j.Domains[0].Project.ID).To(Equal(proj.ID))
+			})
+		})
+
+		Context("when the domain does not exist", func() {
+			BeforeEach(func() {
+				name = "www.example.com"
+			})
+
+			It("returns 404", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "not_found",
+					"error_description": "domain could not be found"
+				}`))
+			})
+		})
+	})
+
+	Describe("GET /admin/projects/:name", func() {
+		var name string
+
+		BeforeEach(func() {
+			name = proj.Name
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("GET", s.URL+"/admin/projects/"+name, params, nil, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("returns 401 without the admin token", func() {
+			params.Del("token")
+			doRequest()
+
+			Expect(res.StatusCode).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("returns the project with its owner, domains and deployments", func() {
+			doRequest()
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+			j := parseBody()
+			Expect(j.Projects).To(HaveLen(1))
+
+			p := j.Projects[0]
+			Expect(p.ID).To(Equal(proj.ID))
+			Expect(p.DeletedAt).To(BeNil())
+			Expect(p.Owner.Email).To(Equal(u.Email))
+			Expect(p.Owner.State).To(Equal(user.StateConfirmed))
+			Expect(p.Domains).To(HaveLen(2))
+			Expect(p.Domains[0].Name).To(Equal(proj.DefaultDomainName()))
+			Expect(p.Domains[1].Name).To(Equal(dm.Name))
+			Expect(p.Deployments).To(HaveLen(1))
+		})
+
+		Context("when the project has been deleted", func() {
+			BeforeEach(func() {
+				Expect(proj.Destroy(db)).To(BeNil())
+			})
+
+			It("returns the deleted project with its history", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				j := parseBody()
+				Expect(j.Projects).To(HaveLen(1))
+
+				p := j.Projects[0]
+				Expect(p.DeletedAt).NotTo(BeNil())
+				Expect(p.Owner.Email).To(Equal(u.Email))
+				Expect(p.Domains[1].Name).To(Equal(dm.Name))
+				Expect(p.Domains[1].DeletedAt).NotTo(BeNil())
+				Expect(p.Deployments).To(HaveLen(1))
+				Expect(p.Deployments[0].DeletedAt).NotTo(BeNil())
+			})
+		})
+
+		Context("when the project does not exist", func() {
+			BeforeEach(func() {
+				name = "no-such-project"
+			})
+
+			It("returns 404", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+			})
+		})
+	})
+})
@@ -0,0 +1,16 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nitrous-io/rise-server/pkg/filetransfer"
+)
+
+// Index shows the metrics of this API server since it started, e.g. the
+// timing and errors of S3 operations.
+func Index(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"s3": filetransfer.Metrics(),
+	})
+}
@@ -0,0 +1,67 @@
+package metrics_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/server"
+	"github.com/nitrous-io/rise-server/pkg/filetransfer"
+	"github.com/nitrous-io/rise-server/testhelper"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "metrics")
+}
+
+var _ = Describe("Metrics", func() {
+	var (
+		s   *httptest.Server
+		res *http.Response
+		err error
+
+		orgStatsToken string
+	)
+
+	BeforeEach(func() {
+		orgStatsToken = common.StatsToken
+		common.StatsToken = "statssecret"
+
+		filetransfer.ResetMetrics()
+		s = httptest.NewServer(server.New())
+	})
+
+	AfterEach(func() {
+		common.StatsToken = orgStatsToken
+		if res != nil {
+			res.Body.Close()
+		}
+		s.Close()
+	})
+
+	Describe("GET /admin/metrics", func() {
+		It("returns the metrics of S3 operations", func() {
+			res, err = testhelper.MakeRequest("GET", s.URL+"/admin/metrics", url.Values{"token": {"statssecret"}}, nil, nil)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+			var j map[string]interface{}
+			Expect(json.NewDecoder(res.Body).Decode(&j)).To(Succeed())
+			Expect(j).To(HaveKeyWithValue("s3", map[string]interface{}{}))
+		})
+
+		It("returns 401 without a valid admin token", func() {
+			res, err = testhelper.MakeRequest("GET", s.URL+"/admin/metrics", url.Values{"token": {"wrong"}}, nil, nil)
+			Expect(err).To(BeNil())
+			Expect(res.StatusCode).To(Equal(http.StatusUnauthorized))
+		})
+	})
+})
@@ -0,0 +1,123 @@
+package oauth
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/collab"
+	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+)
+
+// CreateDeployToken exchanges the current access token for a short-lived
+// token that can only be used to deploy a single project, so that CI steps do
+// not need a full access token.
+func CreateDeployToken(c *gin.Context) {
+	u := controllers.CurrentUser(c)
+	currentToken := controllers.CurrentToken(c)
+
+	var (
+		projectName = c.PostForm("project_name")
+		ttl         = oauthtoken.DefaultDeployTokenTTL
+		errs        = map[string]string{}
+	)
+
+	if projectName == "" {
+		errs["project_name"] = "is required"
+	}
+
+	if v := c.PostForm("expires_in"); v != "" {
+		secs, err := strconv.Atoi(v)
+		ttl = time.Duration(secs) * time.Second
+		if err != nil || ttl < oauthtoken.MinDeployTokenTTL || ttl > oauthtoken.MaxDeployTokenTTL {
+			errs["expires_in"] = fmt.Sprintf("must be between %d and %d seconds",
+				int(oauthtoken.MinDeployTokenTTL/time.Second), int(oauthtoken.MaxDeployTokenTTL/time.Second))
+		}
+	}
+
+	if len(errs) > 0 {
+		c.JSON(422, gin.H{
+			"error":  "invalid_params",
+			"errors": errs,
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	proj, err := project.FindByName(db, projectName)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	// Projects that the user cannot access are reported as not found, as
+	// with RequireProjectCollab.
+	accessible := proj != nil && proj.UserID == u.ID
+	if proj != nil && !accessible {
+		cnt := 0
+		if err := db.Model(collab.Collab{}).Where("project_id = ? AND user_id = ?", proj.ID, u.ID).Count(&cnt).Error; err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+		accessible = cnt > 0
+	}
+
+	if !accessible {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "project could not be found",
+		})
+		return
+	}
+
+	expiresAt := time.Now().Add(ttl)
+	token := &oauthtoken.OauthToken{
+		UserID:        u.ID,
+		OauthClientID: currentToken.OauthClientID,
+		Name:          "Deploy token for " + proj.Name,
+		ProjectID:     &proj.ID,
+		ExpiresAt:     &expiresAt,
+	}
+
+	if err := db.Create(token).Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	{
+		var (
+			event = "Created Deploy Token"
+			props = map[string]interface{}{
+				"projectName": proj.Name,
+				"expiresIn":   int(ttl / time.Second),
+			}
+			context = controllers.TrackingContext(c)
+		)
+		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
+			log.Errorf("failed to track %q event for user ID %d, err: %v",
+				event, u.ID, err)
+		}
+	}
+
+	// The access token is only ever returned when it is created.
+	c.JSON(http.StatusCreated, gin.H{
+		"token": struct {
+			*oauthtoken.JSON
+			AccessToken string `json:"access_token"`
+			TokenType   string `json:"token_type"`
+			ExpiresIn   int    `json:"expires_in"`
+		}{token.AsJSON(), token.Token, "bearer", int(ttl / time.Second)},
+	})
+}
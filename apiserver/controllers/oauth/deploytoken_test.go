@@ -0,0 +1,303 @@
+package oauth_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/oauthclient"
+	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/apiserver/server"
+	"github.com/nitrous-io/rise-server/pkg/tracker"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+	"github.com/nitrous-io/rise-server/testhelper/fake"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Deploy tokens", func() {
+	var (
+		db  *gorm.DB
+		s   *httptest.Server
+		res *http.Response
+		err error
+
+		u    *user.User
+		oc   *oauthclient.OauthClient
+		t    *oauthtoken.OauthToken
+		proj *project.Project
+
+		fakeTracker *fake.Tracker
+		origTracker tracker.Trackable
+
+		headers http.Header
+		params  url.Values
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+
+		u, oc = factories.AuthDuo(db)
+		t = &oauthtoken.OauthToken{
+			UserID:        u.ID,
+			OauthClientID: oc.ID,
+		}
+		Expect(db.Create(t).Error).To(BeNil())
+		proj = factories.Project(db, u, "foo-bar-express")
+
+		origTracker = common.Tracker
+		fakeTracker = &fake.Tracker{}
+		common.Tracker = fakeTracker
+
+		headers = http.Header{
+			"Authorization": {"Bearer " + t.Token},
+		}
+		params = url.Values{
+			"project_name": {"foo-bar-express"},
+		}
+
+		s = httptest.NewServer(server.New())
+	})
+
+	AfterEach(func() {
+		if res != nil {
+			res.Body.Close()
+		}
+		s.Close()
+
+		common.Tracker = origTracker
+	})
+
+	doRequest := func() {
+		res, err = testhelper.MakeRequest("POST", s.URL+"/oauth/deploy_tokens", params, headers, nil)
+		Expect(err).To(BeNil())
+	}
+
+	Describe("POST /oauth/deploy_tokens", func() {
+		It("returns 201 Created with a deploy token for the project", func() {
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			newToken := &oauthtoken.OauthToken{}
+			Expect(db.Last(newToken).Error).To(BeNil())
+			Expect(newToken.ID).NotTo(Equal(t.ID))
+			Expect(newToken.UserID).To(Equal(u.ID))
+			Expect(newToken.OauthClientID).To(Equal(oc.ID))
+			Expect(*newToken.ProjectID).To(Equal(proj.ID))
+			Expect(*newToken.ExpiresAt).To(BeTemporally("~", time.Now().Add(15*time.Minute), time.Minute))
+
+			Expect(res.StatusCode).To(Equal(http.StatusCreated))
+			Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+				"token": {
+					"id": %d,
+					"name": "Deploy token for foo-bar-express",
+					"description": "",
+					"request_count": 0,
+					"last_used_at": null,
+					"created_at": "%s",
+					"project_id": %d,
+					"expires_at": "%s",
+					"access_token": "%s",
+					"token_type": "bearer",
+					"expires_in": 900
+				}
+			}`, newToken.ID, newToken.CreatedAt.Format(time.RFC3339Nano), proj.ID,
+				newToken.ExpiresAt.Format(time.RFC3339Nano), newToken.Token)))
+		})
+
+		It("tracks a 'Created Deploy Token' event", func() {
+			doRequest()
+
+			trackCall := fakeTracker.TrackCalls.NthCall(1)
+			Expect(trackCall).NotTo(BeNil())
+			Expect(trackCall.Arguments[0]).To(Equal(fmt.Sprintf("%d", u.ID)))
+			Expect(trackCall.Arguments[1]).To(Equal("Created Deploy Token"))
+
+			props, ok := trackCall.Arguments[3].(map[string]interface{})
+			Expect(ok).To(BeTrue())
+			Expect(props["projectName"]).To(Equal("foo-bar-express"))
+			Expect(props["expiresIn"]).To(Equal(900))
+		})
+
+		Context("when expires_in is given", func() {
+			BeforeEach(func() {
+				params.Set("expires_in", "120")
+			})
+
+			It("expires the token after that many seconds", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusCreated))
+				newToken := &oauthtoken.OauthToken{}
+				Expect(db.Last(newToken).Error).To(BeNil())
+				Expect(*newToken.ExpiresAt).To(BeTemporally("~", time.Now().Add(2*time.Minute), 10*time.Second))
+			})
+		})
+
+		Context("when the params are invalid", func() {
+			BeforeEach(func() {
+				params.Del("project_name")
+				params.Set("expires_in", "86400")
+			})
+
+			It("returns 422 unprocessable entity", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"errors": {
+						"project_name": "is required",
+						"expires_in": "must be between 60 and 3600 seconds"
+					}
+				}`))
+			})
+		})
+
+		Context("when the user cannot access the project", func() {
+			BeforeEach(func() {
+				factories.Project(db, nil, "someone-elses")
+				params.Set("project_name", "someone-elses")
+			})
+
+			It("returns 404 not found", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+
+				var count int
+				Expect(db.Model(oauthtoken.OauthToken{}).Count(&count).Error).To(BeNil())
+				Expect(count).To(Equal(1))
+			})
+		})
+
+		Context("when the user is a collaborator of the project", func() {
+			BeforeEach(func() {
+				other := factories.Project(db, nil, "shared-project")
+				Expect(other.AddCollaborator(db, u)).To(Succeed())
+				params.Set("project_name", "shared-project")
+			})
+
+			It("returns 201 Created", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusCreated))
+			})
+		})
+	})
+
+	Describe("using a deploy token", func() {
+		var (
+			deployHeaders http.Header
+			deployToken   *oauthtoken.OauthToken
+			depl          *deployment.Deployment
+		)
+
+		BeforeEach(func() {
+			doRequest()
+			Expect(res.StatusCode).To(Equal(http.StatusCreated))
+
+			var j struct {
+				Token struct {
+					AccessToken string `json:"access_token"`
+				} `json:"token"`
+			}
+			Expect(json.NewDecoder(res.Body).Decode(&j)).To(Succeed())
+			res.Body.Close()
+
+			deployHeaders = http.Header{
+				"Authorization": {"Bearer " + j.Token.AccessToken},
+			}
+			deployToken, err = oauthtoken.FindByToken(db, j.Token.AccessToken)
+			Expect(err).To(BeNil())
+
+			depl = factories.Deployment(db, proj, u, deployment.StateDeployed)
+		})
+
+		makeRequest := func(method, path string) {
+			res, err = testhelper.MakeRequest(method, s.URL+path, nil, deployHeaders, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("can be used to follow a deployment of its project", func() {
+			makeRequest("GET", fmt.Sprintf("/projects/foo-bar-express/deployments/%d", depl.ID))
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+		})
+
+		It("cannot be used for anything other than deploying", func() {
+			for _, path := range []string{"/projects/foo-bar-express", "/projects", "/oauth/tokens", "/user"} {
+				makeRequest("GET", path)
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+				res.Body.Close()
+
+				Expect(res.StatusCode).To(Equal(http.StatusForbidden), path)
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "forbidden",
+					"code": "token_scope_forbidden",
+					"error_description": "deploy tokens can only be used to deploy their project"
+				}`))
+			}
+		})
+
+		It("cannot be used to deploy another project", func() {
+			other := factories.Project(db, u, "another-project")
+			otherDepl := factories.Deployment(db, other, u, deployment.StateDeployed)
+
+			makeRequest("GET", fmt.Sprintf("/projects/another-project/deployments/%d", otherDepl.ID))
+
+			Expect(res.StatusCode).To(Equal(http.StatusForbidden))
+		})
+
+		It("cannot be used to create other deploy tokens", func() {
+			res, err = testhelper.MakeRequest("POST", s.URL+"/oauth/deploy_tokens", params, deployHeaders, nil)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusForbidden))
+		})
+
+		Context("when it has expired", func() {
+			BeforeEach(func() {
+				Expect(db.Model(deployToken).Update("expires_at", time.Now().Add(-time.Second)).Error).To(BeNil())
+			})
+
+			It("returns 401 unauthorized", func() {
+				makeRequest("GET", fmt.Sprintf("/projects/foo-bar-express/deployments/%d", depl.ID))
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusUnauthorized))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_token",
+					"code": "token_expired",
+					"error_description": "access token has expired"
+				}`))
+			})
+		})
+	})
+})
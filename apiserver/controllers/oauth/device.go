@@ -0,0 +1,292 @@
+package oauth
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/devicecode"
+	"github.com/nitrous-io/rise-server/apiserver/models/oauthclient"
+	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
+)
+
+// DeviceCodeGrantType is the grant type clients poll the token endpoint with
+// to exchange a device code for an access token.
+const DeviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// CreateDeviceCode starts a login with the device authorization grant. The
+// client shows the user code and the verification URI to the user, and polls
+// the token endpoint until the user has approved the login in their browser.
+func CreateDeviceCode(c *gin.Context) {
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	client, ok := authenticateClient(c, db)
+	if !ok {
+		return
+	}
+
+	dc, err := devicecode.Create(db, client.ID)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"device_code":               dc.DeviceCode,
+		"user_code":                 dc.FormattedUserCode(),
+		"verification_uri":          common.DeviceVerificationURL,
+		"verification_uri_complete": withUserCode(common.DeviceVerificationURL, dc.FormattedUserCode()),
+		"expires_in":                int(devicecode.ExpiresIn / time.Second),
+		"interval":                  int(devicecode.Interval / time.Second),
+	})
+}
+
+// ApproveDeviceCode lets the client that requested the user code log in as
+// the current user.
+func ApproveDeviceCode(c *gin.Context) {
+	dc, client, ok := findPendingDeviceCode(c)
+	if !ok {
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if err := dc.Approve(db, controllers.CurrentUser(c).ID); err != nil {
+		if err == devicecode.ErrNotPending {
+			respondDeviceCodeNotFound(c)
+			return
+		}
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"approved":    true,
+		"client_name": client.Name,
+	})
+}
+
+// DenyDeviceCode stops the client that requested the user code from logging
+// in.
+func DenyDeviceCode(c *gin.Context) {
+	dc, client, ok := findPendingDeviceCode(c)
+	if !ok {
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if err := dc.Deny(db); err != nil {
+		if err == devicecode.ErrNotPending {
+			respondDeviceCodeNotFound(c)
+			return
+		}
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"denied":      true,
+		"client_name": client.Name,
+	})
+}
+
+// createTokenWithDeviceCode issues an access token to a client that polls the
+// token endpoint with a device code, once the user has approved the login.
+func createTokenWithDeviceCode(c *gin.Context) {
+	deviceCode := c.PostForm("device_code")
+	if deviceCode == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":             "invalid_request",
+			"error_description": `"device_code" is required`,
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	client, ok := authenticateClient(c, db)
+	if !ok {
+		return
+	}
+
+	dc, err := devicecode.FindByDeviceCode(db, deviceCode)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if dc == nil || dc.OauthClientID != client.ID {
+		respondDeviceCodeError(c, "invalid_grant", "device code is invalid")
+		return
+	}
+
+	if dc.Expired() {
+		respondDeviceCodeError(c, "expired_token", "device code has expired")
+		return
+	}
+
+	if dc.DeniedAt != nil {
+		respondDeviceCodeError(c, "access_denied", "user denied the login")
+		return
+	}
+
+	polled, err := dc.Poll(db)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if !polled {
+		respondDeviceCodeError(c, "slow_down", "device code is being polled too frequently")
+		return
+	}
+
+	if dc.UserID == nil {
+		respondDeviceCodeError(c, "authorization_pending", "user has not approved the login yet")
+		return
+	}
+
+	tx := db.Begin()
+	if err := tx.Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+	defer tx.Rollback()
+
+	redeemed, err := dc.Redeem(tx)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if !redeemed {
+		respondDeviceCodeError(c, "invalid_grant", "device code is invalid")
+		return
+	}
+
+	token := &oauthtoken.OauthToken{
+		UserID:        *dc.UserID,
+		OauthClientID: client.ID,
+	}
+	if err := tx.Create(token).Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	{
+		var (
+			event = "User Logged In"
+			props = map[string]interface{}{
+				"oauthClientId":   client.ID,
+				"oauthClientName": client.Name,
+				"grantType":       DeviceCodeGrantType,
+			}
+			context = controllers.TrackingContext(c)
+		)
+		if err := common.Track(strconv.Itoa(int(token.UserID)), event, "", props, context); err != nil {
+			log.Errorf("failed to track %q event for user ID %d, err: %v",
+				event, token.UserID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"access_token": token.Token,
+		"token_type":   "bearer",
+		"client_id":    client.ClientID,
+	})
+}
+
+// findPendingDeviceCode finds the device code of the user_code param and the
+// client that requested it, and responds with 404 if the user code is invalid
+// or has expired.
+func findPendingDeviceCode(c *gin.Context) (*devicecode.DeviceCode, *oauthclient.OauthClient, bool) {
+	userCode := c.PostForm("user_code")
+	if userCode == "" {
+		c.JSON(422, gin.H{
+			"error": "invalid_params",
+			"errors": map[string]string{
+				"user_code": "is required",
+			},
+		})
+		return nil, nil, false
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return nil, nil, false
+	}
+
+	dc, err := devicecode.FindPendingByUserCode(db, userCode)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return nil, nil, false
+	}
+
+	if dc == nil {
+		respondDeviceCodeNotFound(c)
+		return nil, nil, false
+	}
+
+	client := &oauthclient.OauthClient{}
+	if err := db.First(client, dc.OauthClientID).Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return nil, nil, false
+	}
+
+	return dc, client, true
+}
+
+func respondDeviceCodeNotFound(c *gin.Context) {
+	c.JSON(http.StatusNotFound, gin.H{
+		"error":             "not_found",
+		"error_description": "user code is invalid or has expired",
+	})
+}
+
+func respondDeviceCodeError(c *gin.Context, code, desc string) {
+	c.JSON(http.StatusBadRequest, gin.H{
+		"error":             code,
+		"error_description": desc,
+	})
+}
+
+func withUserCode(rawurl, userCode string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return rawurl
+	}
+
+	q := u.Query()
+	q.Set("user_code", userCode)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
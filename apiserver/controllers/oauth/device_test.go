@@ -0,0 +1,300 @@
+package oauth_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/controllers/oauth"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/devicecode"
+	"github.com/nitrous-io/rise-server/apiserver/models/oauthclient"
+	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/apiserver/server"
+	"github.com/nitrous-io/rise-server/pkg/tracker"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+	"github.com/nitrous-io/rise-server/testhelper/fake"
+	"github.com/nitrous-io/rise-server/testhelper/sharedexamples"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Device Authorization", func() {
+	var (
+		db  *gorm.DB
+		s   *httptest.Server
+		res *http.Response
+		err error
+
+		u  *user.User
+		oc *oauthclient.OauthClient
+		t  *oauthtoken.OauthToken
+
+		fakeTracker *fake.Tracker
+		origTracker tracker.Trackable
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+
+		u, oc, t = factories.AuthTrio(db)
+
+		origTracker = common.Tracker
+		fakeTracker = &fake.Tracker{}
+		common.Tracker = fakeTracker
+
+		s = httptest.NewServer(server.New())
+	})
+
+	AfterEach(func() {
+		if res != nil {
+			res.Body.Close()
+		}
+		s.Close()
+
+		common.Tracker = origTracker
+	})
+
+	Describe("POST /oauth/device/code", func() {
+		doRequest := func(clientID, clientSecret string) {
+			res, err = testhelper.MakeRequest("POST", s.URL+"/oauth/device/code", nil, nil, func(req *http.Request) {
+				req.SetBasicAuth(clientID, clientSecret)
+			})
+			Expect(err).To(BeNil())
+		}
+
+		It("returns a device code and a user code", func() {
+			doRequest(oc.ClientID, oc.ClientSecret)
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+			var j map[string]interface{}
+			Expect(json.NewDecoder(res.Body).Decode(&j)).To(Succeed())
+
+			dc, err := devicecode.FindByDeviceCode(db, j["device_code"].(string))
+			Expect(err).To(BeNil())
+			Expect(dc).NotTo(BeNil())
+			Expect(dc.OauthClientID).To(Equal(oc.ID))
+
+			Expect(j).To(Equal(map[string]interface{}{
+				"device_code":               dc.DeviceCode,
+				"user_code":                 dc.FormattedUserCode(),
+				"verification_uri":          common.DeviceVerificationURL,
+				"verification_uri_complete": common.DeviceVerificationURL + "?user_code=" + dc.FormattedUserCode(),
+				"expires_in":                float64(600),
+				"interval":                  float64(5),
+			}))
+		})
+
+		It("returns 401 if the client credentials are invalid", func() {
+			doRequest(oc.ClientID, "x")
+			Expect(res.StatusCode).To(Equal(http.StatusUnauthorized))
+
+			var count int
+			Expect(db.Model(devicecode.DeviceCode{}).Count(&count).Error).To(BeNil())
+			Expect(count).To(Equal(0))
+		})
+	})
+
+	Describe("POST /oauth/token with the device code grant", func() {
+		var dc *devicecode.DeviceCode
+
+		BeforeEach(func() {
+			dc, err = devicecode.Create(db, oc.ID)
+			Expect(err).To(BeNil())
+		})
+
+		doRequest := func(deviceCode string) {
+			res, err = testhelper.MakeRequest("POST", s.URL+"/oauth/token", url.Values{
+				"grant_type":  {oauth.DeviceCodeGrantType},
+				"device_code": {deviceCode},
+			}, nil, func(req *http.Request) {
+				req.SetBasicAuth(oc.ClientID, oc.ClientSecret)
+			})
+			Expect(err).To(BeNil())
+		}
+
+		expectError := func(code, desc string) {
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusBadRequest))
+			Expect(b.String()).To(MatchJSON(`{
+				"error": "` + code + `",
+				"error_description": "` + desc + `"
+			}`))
+		}
+
+		It("returns 'authorization_pending' until the user has approved the login", func() {
+			doRequest(dc.DeviceCode)
+			expectError("authorization_pending", "user has not approved the login yet")
+		})
+
+		It("returns 'slow_down' if the client polls too frequently", func() {
+			doRequest(dc.DeviceCode)
+			res.Body.Close()
+
+			doRequest(dc.DeviceCode)
+			expectError("slow_down", "device code is being polled too frequently")
+		})
+
+		It("returns 'access_denied' if the user has denied the login", func() {
+			Expect(dc.Deny(db)).To(Succeed())
+
+			doRequest(dc.DeviceCode)
+			expectError("access_denied", "user denied the login")
+		})
+
+		It("returns 'expired_token' if the device code has expired", func() {
+			Expect(db.Model(dc).UpdateColumn("expires_at", time.Now().Add(-time.Second)).Error).To(BeNil())
+
+			doRequest(dc.DeviceCode)
+			expectError("expired_token", "device code has expired")
+		})
+
+		It("returns 'invalid_grant' if the device code does not exist", func() {
+			doRequest("x" + dc.DeviceCode)
+			expectError("invalid_grant", "device code is invalid")
+		})
+
+		It("returns 'invalid_grant' if the device code belongs to another client", func() {
+			oc2 := factories.OauthClient(db)
+			dc2, err := devicecode.Create(db, oc2.ID)
+			Expect(err).To(BeNil())
+			Expect(dc2.Approve(db, u.ID)).To(Succeed())
+
+			doRequest(dc2.DeviceCode)
+			expectError("invalid_grant", "device code is invalid")
+		})
+
+		It("returns 'invalid_request' if the device code is missing", func() {
+			doRequest("")
+			expectError("invalid_request", `\"device_code\" is required`)
+		})
+
+		Context("when the user has approved the login", func() {
+			BeforeEach(func() {
+				Expect(dc.Approve(db, u.ID)).To(Succeed())
+			})
+
+			It("issues an access token once", func() {
+				doRequest(dc.DeviceCode)
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				tok := &oauthtoken.OauthToken{}
+				Expect(db.Last(tok).Error).To(BeNil())
+				Expect(tok.UserID).To(Equal(u.ID))
+				Expect(tok.OauthClientID).To(Equal(oc.ID))
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(b.String()).To(MatchJSON(`{
+					"access_token": "` + tok.Token + `",
+					"token_type": "bearer",
+					"client_id": "` + oc.ClientID + `"
+				}`))
+
+				Expect(fakeTracker.TrackCalls.Count()).To(Equal(1))
+				trackCall := fakeTracker.TrackCalls.NthCall(1)
+				Expect(trackCall.Arguments[1]).To(Equal("User Logged In"))
+
+				res.Body.Close()
+				doRequest(dc.DeviceCode)
+				expectError("invalid_grant", "device code is invalid")
+			})
+		})
+	})
+
+	for _, action := range []string{"approve", "deny"} {
+		action := action
+
+		Describe("POST /oauth/device/"+action, func() {
+			var (
+				dc      *devicecode.DeviceCode
+				headers http.Header
+			)
+
+			BeforeEach(func() {
+				dc, err = devicecode.Create(db, oc.ID)
+				Expect(err).To(BeNil())
+
+				headers = http.Header{
+					"Authorization": {"Bearer " + t.Token},
+				}
+			})
+
+			doRequest := func(userCode string) {
+				res, err = testhelper.MakeRequest("POST", s.URL+"/oauth/device/"+action, url.Values{
+					"user_code": {userCode},
+				}, headers, nil)
+				Expect(err).To(BeNil())
+			}
+
+			It("approves or denies the login", func() {
+				doRequest(dc.FormattedUserCode())
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				dc1, err := devicecode.FindByDeviceCode(db, dc.DeviceCode)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				if action == "approve" {
+					Expect(b.String()).To(MatchJSON(`{
+						"approved": true,
+						"client_name": "` + oc.Name + `"
+					}`))
+					Expect(*dc1.UserID).To(Equal(u.ID))
+				} else {
+					Expect(b.String()).To(MatchJSON(`{
+						"denied": true,
+						"client_name": "` + oc.Name + `"
+					}`))
+					Expect(dc1.UserID).To(BeNil())
+					Expect(dc1.DeniedAt).NotTo(BeNil())
+				}
+			})
+
+			It("returns 404 if the user code has already been used", func() {
+				Expect(dc.Approve(db, u.ID)).To(Succeed())
+
+				doRequest(dc.UserCode)
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "not_found",
+					"error_description": "user code is invalid or has expired"
+				}`))
+			})
+
+			It("returns 422 if the user code is missing", func() {
+				doRequest("")
+				Expect(res.StatusCode).To(Equal(422))
+			})
+
+			sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+				return db, u, &headers
+			}, func() *http.Response {
+				doRequest(dc.UserCode)
+				return res
+			}, nil)
+		})
+	}
+})
@@ -2,20 +2,29 @@ package oauth
 
 import (
 	"encoding/base64"
+	"net/http"
 	"strconv"
 	"strings"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
 	"github.com/nitrous-io/rise-server/apiserver/common"
 	"github.com/nitrous-io/rise-server/apiserver/controllers"
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
 	"github.com/nitrous-io/rise-server/apiserver/models/oauthclient"
 	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/ssoconnection"
 	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/shared/errcodes"
 )
 
 func CreateToken(c *gin.Context) {
+	if c.PostForm("grant_type") == DeviceCodeGrantType {
+		createTokenWithDeviceCode(c)
+		return
+	}
+
 	for _, p := range []string{"grant_type", "username", "password"} {
 		if c.PostForm(p) == "" {
 			c.JSON(400, gin.H{
@@ -44,6 +53,22 @@ func CreateToken(c *gin.Context) {
 		return
 	}
 
+	// Members of organizations that use single sign-on must log in through
+	// their identity provider.
+	conn, err := ssoconnection.FindByEmailDomain(db, ssoconnection.EmailDomain(email))
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if conn != nil {
+		c.JSON(400, gin.H{
+			"error":             "invalid_grant",
+			"error_description": "user must log in with single sign-on",
+		})
+		return
+	}
+
 	u, err := user.Authenticate(db, email, password)
 	if err != nil {
 		controllers.InternalServerError(c, err)
@@ -58,14 +83,56 @@ func CreateToken(c *gin.Context) {
 		return
 	}
 
-	if u.ConfirmedAt == nil {
+	if err := u.CheckActive(); err != nil {
 		c.JSON(400, gin.H{
 			"error":             "invalid_grant",
-			"error_description": "user has not confirmed email address",
+			"code":              errcodes.UserInactive,
+			"error_description": err.Error(),
+			"pending_actions":   u.PendingActions(),
 		})
 		return
 	}
 
+	client, ok := authenticateClient(c, db)
+	if !ok {
+		return
+	}
+
+	token := &oauthtoken.OauthToken{
+		UserID:        u.ID,
+		OauthClientID: client.ID,
+	}
+	if err := db.Create(token).Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	{
+		var (
+			event = "User Logged In"
+			props = map[string]interface{}{
+				"oauthClientId":   client.ID,
+				"oauthClientName": client.Name,
+			}
+			context = controllers.TrackingContext(c)
+		)
+		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
+			log.Errorf("failed to track %q event for user ID %d, err: %v",
+				event, u.ID, err)
+		}
+	}
+
+	c.JSON(200, gin.H{
+		"access_token": token.Token,
+		"token_type":   "bearer",
+		"client_id":    client.ClientID,
+	})
+}
+
+// authenticateClient authenticates the client with the credentials in the
+// Authorization header, or in the form params if there is none. It responds
+// with an error and returns false if they are invalid.
+func authenticateClient(c *gin.Context, db *gorm.DB) (*oauthclient.OauthClient, bool) {
 	var clientID, clientSecret string
 
 	authHeader := strings.TrimPrefix(c.Request.Header.Get("Authorization"), "Basic ")
@@ -73,7 +140,7 @@ func CreateToken(c *gin.Context) {
 		authBytes, err := base64.StdEncoding.DecodeString(authHeader)
 		if err != nil {
 			controllers.InternalServerError(c, err)
-			return
+			return nil, false
 		}
 
 		authPair := strings.SplitN(string(authBytes), ":", 2)
@@ -87,7 +154,7 @@ func CreateToken(c *gin.Context) {
 	client, err := oauthclient.Authenticate(db, clientID, clientSecret)
 	if err != nil {
 		controllers.InternalServerError(c, err)
-		return
+		return nil, false
 	}
 
 	if client == nil {
@@ -96,13 +163,110 @@ func CreateToken(c *gin.Context) {
 			"error":             "invalid_client",
 			"error_description": "client credentials are invalid",
 		})
+		return nil, false
+	}
+
+	return client, true
+}
+
+func DestroyToken(c *gin.Context) {
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	t := controllers.CurrentToken(c)
+	if t == nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	delQuery := db.Where("token = ?", t.Token).Delete(oauthtoken.OauthToken{})
+	if err := delQuery.Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	{
+		u := controllers.CurrentUser(c)
+
+		var (
+			event   = "User Logged Out"
+			props   map[string]interface{}
+			context = controllers.TrackingContext(c)
+		)
+		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
+			log.Errorf("failed to track %q event for user ID %d, err: %v",
+				event, u.ID, err)
+		}
+	}
+
+	c.JSON(200, gin.H{
+		"invalidated": true,
+	})
+}
+
+// ListTokens lists the current user's access tokens with their usage stats, so
+// that users can tell which tokens are still in use. Usage stats are written
+// periodically and may lag behind by a short while.
+func ListTokens(c *gin.Context) {
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	u := controllers.CurrentUser(c)
+	currentToken := controllers.CurrentToken(c)
+
+	tokens, err := oauthtoken.FindByUserID(db, u.ID)
+	if err != nil {
+		controllers.InternalServerError(c, err)
 		return
 	}
 
+	tokensJSON := []interface{}{}
+	for _, t := range tokens {
+		tokensJSON = append(tokensJSON, struct {
+			*oauthtoken.JSON
+			Current bool `json:"current"`
+		}{t.AsJSON(), currentToken != nil && t.ID == currentToken.ID})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"tokens": tokensJSON,
+	})
+}
+
+// CreateNamedToken creates a new access token for the current user with a
+// name (e.g. "MacBook" or "GitHub Actions") so that it can be told apart from
+// other tokens when deciding which tokens to revoke.
+func CreateNamedToken(c *gin.Context) {
+	u := controllers.CurrentUser(c)
+	currentToken := controllers.CurrentToken(c)
+
 	token := &oauthtoken.OauthToken{
 		UserID:        u.ID,
-		OauthClientID: client.ID,
+		OauthClientID: currentToken.OauthClientID,
+		Name:          strings.TrimSpace(c.PostForm("name")),
+		Description:   strings.TrimSpace(c.PostForm("description")),
+	}
+
+	if errs := token.Validate(); errs != nil {
+		c.JSON(422, gin.H{
+			"error":  "invalid_params",
+			"errors": errs,
+		})
+		return
 	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
 	if err := db.Create(token).Error; err != nil {
 		controllers.InternalServerError(c, err)
 		return
@@ -110,15 +274,11 @@ func CreateToken(c *gin.Context) {
 
 	{
 		var (
-			event = "User Logged In"
+			event = "Created Access Token"
 			props = map[string]interface{}{
-				"oauthClientId":   client.ID,
-				"oauthClientName": client.Name,
-			}
-			context = map[string]interface{}{
-				"ip":         common.GetIP(c.Request),
-				"user_agent": c.Request.UserAgent(),
+				"tokenName": token.Name,
 			}
+			context = controllers.TrackingContext(c)
 		)
 		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
 			log.Errorf("failed to track %q event for user ID %d, err: %v",
@@ -126,42 +286,54 @@ func CreateToken(c *gin.Context) {
 		}
 	}
 
-	c.JSON(200, gin.H{
-		"access_token": token.Token,
-		"token_type":   "bearer",
-		"client_id":    client.ClientID,
+	// The access token is only ever returned when it is created.
+	c.JSON(http.StatusCreated, gin.H{
+		"token": struct {
+			*oauthtoken.JSON
+			AccessToken string `json:"access_token"`
+			TokenType   string `json:"token_type"`
+		}{token.AsJSON(), token.Token, "bearer"},
 	})
 }
 
-func DestroyToken(c *gin.Context) {
+// RevokeToken invalidates one of the current user's access tokens by ID.
+func RevokeToken(c *gin.Context) {
+	u := controllers.CurrentUser(c)
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "token could not be found",
+		})
+		return
+	}
+
 	db, err := dbconn.DB()
 	if err != nil {
 		controllers.InternalServerError(c, err)
 		return
 	}
 
-	t := controllers.CurrentToken(c)
-	if t == nil {
+	q := db.Where("id = ? AND user_id = ?", id, u.ID).Delete(oauthtoken.OauthToken{})
+	if err := q.Error; err != nil {
 		controllers.InternalServerError(c, err)
 		return
 	}
 
-	delQuery := db.Where("token = ?", t.Token).Delete(oauthtoken.OauthToken{})
-	if err := delQuery.Error; err != nil {
-		controllers.InternalServerError(c, err)
+	if q.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "token could not be found",
+		})
 		return
 	}
 
 	{
-		u := controllers.CurrentUser(c)
-
 		var (
-			event   = "User Logged Out"
-			props   map[string]interface{}
-			context = map[string]interface{}{
-				"ip":         common.GetIP(c.Request),
-				"user_agent": c.Request.UserAgent(),
-			}
+			event   = "Revoked Access Token"
+			props   = map[string]interface{}{"tokenId": id}
+			context = controllers.TrackingContext(c)
 		)
 		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
 			log.Errorf("failed to track %q event for user ID %d, err: %v",
@@ -169,7 +341,7 @@ func DestroyToken(c *gin.Context) {
 		}
 	}
 
-	c.JSON(200, gin.H{
+	c.JSON(http.StatusOK, gin.H{
 		"invalidated": true,
 	})
 }
@@ -7,12 +7,14 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/jinzhu/gorm"
 	"github.com/nitrous-io/rise-server/apiserver/common"
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
 	"github.com/nitrous-io/rise-server/apiserver/models/oauthclient"
 	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/ssoconnection"
 	"github.com/nitrous-io/rise-server/apiserver/models/user"
 	"github.com/nitrous-io/rise-server/apiserver/server"
 	"github.com/nitrous-io/rise-server/pkg/tracker"
@@ -163,7 +165,10 @@ var _ = Describe("OAuth", func() {
 
 		Context("when the user hasn't confirmed email", func() {
 			BeforeEach(func() {
-				err = db.Model(u).Update("confirmed_at", gorm.Expr("NULL")).Error
+				err = db.Model(u).Updates(map[string]interface{}{
+					"confirmed_at": gorm.Expr("NULL"),
+					"state":        user.StateUnconfirmed,
+				}).Error
 				Expect(err).To(BeNil())
 
 				doRequest(url.Values{
@@ -181,7 +186,74 @@ var _ = Describe("OAuth", func() {
 				Expect(res.StatusCode).To(Equal(http.StatusBadRequest))
 				Expect(b.String()).To(MatchJSON(`{
 					"error": "invalid_grant",
-					"error_description": "user has not confirmed email address"
+					"code": "user_inactive",
+					"error_description": "user has not confirmed email address",
+					"pending_actions": ["confirm_email"]
+				}`))
+
+				tok := &oauthtoken.OauthToken{}
+				err = db.Last(tok).Error
+				Expect(err).To(Equal(gorm.RecordNotFound))
+			})
+		})
+
+		DescribeTable("when the user cannot use their account",
+			func(event, desc, action string) {
+				Expect(u.Transition(db, event)).To(Succeed())
+
+				doRequest(url.Values{
+					"grant_type": {"password"},
+					"username":   {u.Email},
+					"password":   {u.Password},
+				}, nil, oc.ClientID, oc.ClientSecret)
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusBadRequest))
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"error": "invalid_grant",
+					"code": "user_inactive",
+					"error_description": %q,
+					"pending_actions": [%q]
+				}`, desc, action)))
+
+				tok := &oauthtoken.OauthToken{}
+				err = db.Last(tok).Error
+				Expect(err).To(Equal(gorm.RecordNotFound))
+			},
+			Entry("locked", user.EventLock, "user is locked, reset password to unlock", user.ActionResetPassword),
+			Entry("suspended", user.EventSuspend, "user is suspended", user.ActionContactSupport),
+		)
+
+		Context("when the user's email domain uses single sign-on", func() {
+			BeforeEach(func() {
+				err = db.Create(&ssoconnection.SSOConnection{
+					Organization:          "Example",
+					EmailDomain:           "example.com",
+					Issuer:                "https://login.example.com",
+					ClientID:              "pubstorm",
+					EncryptedClientSecret: "encrypted",
+				}).Error
+				Expect(err).To(BeNil())
+
+				doRequest(url.Values{
+					"grant_type": {"password"},
+					"username":   {u.Email},
+					"password":   {u.Password},
+				}, nil, oc.ClientID, oc.ClientSecret)
+			})
+
+			It("returns 400 with 'invalid_grant' error", func() {
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusBadRequest))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_grant",
+					"error_description": "user must log in with single sign-on"
 				}`))
 
 				tok := &oauthtoken.OauthToken{}
@@ -352,4 +424,289 @@ var _ = Describe("OAuth", func() {
 			return res
 		}, nil)
 	})
+
+	Describe("GET /oauth/tokens", func() {
+		var (
+			t1, t2  *oauthtoken.OauthToken
+			headers http.Header
+		)
+
+		BeforeEach(func() {
+			t1 = &oauthtoken.OauthToken{
+				UserID:        u.ID,
+				OauthClientID: oc.ID,
+				Name:          "GitHub Actions",
+				Description:   "deploys master",
+			}
+			Expect(db.Create(t1).Error).To(BeNil())
+
+			t2 = &oauthtoken.OauthToken{
+				UserID:        u.ID,
+				OauthClientID: oc.ID,
+			}
+			Expect(db.Create(t2).Error).To(BeNil())
+
+			lastUsedAt := time.Date(2016, 6, 1, 10, 0, 0, 0, time.UTC)
+			Expect(oauthtoken.IncrementUsage(db, t1.ID, 42, lastUsedAt)).To(BeNil())
+			Expect(db.First(t1, t1.ID).Error).To(BeNil())
+
+			// To make sure it does not list other users' tokens
+			factories.AuthTrio(db)
+
+			headers = http.Header{
+				"Authorization": {"Bearer " + t2.Token},
+			}
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("GET", s.URL+"/oauth/tokens", nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("returns 200 OK with usage stats of the user's tokens", func() {
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+				"tokens": [
+					{
+						"id": %d,
+						"name": "",
+						"description": "",
+						"request_count": 0,
+						"last_used_at": null,
+						"created_at": "%s",
+						"current": true
+					},
+					{
+						"id": %d,
+						"name": "GitHub Actions",
+						"description": "deploys master",
+						"request_count": 42,
+						"last_used_at": "%s",
+						"created_at": "%s",
+						"current": false
+					}
+				]
+			}`, t2.ID, t2.CreatedAt.Format(time.RFC3339Nano),
+				t1.ID, t1.LastUsedAt.Format(time.RFC3339Nano), t1.CreatedAt.Format(time.RFC3339Nano))))
+			Expect(b.String()).NotTo(ContainSubstring(t1.Token))
+		})
+
+		It("records usage of the current token", func() {
+			doRequest()
+
+			Expect(oauthtoken.Usage.Flush(db)).To(BeNil())
+
+			Expect(db.First(t2, t2.ID).Error).To(BeNil())
+			Expect(t2.RequestCount).To(Equal(int64(1)))
+			Expect(t2.LastUsedAt).NotTo(BeNil())
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+
+	Describe("POST /oauth/tokens", func() {
+		var (
+			t       *oauthtoken.OauthToken
+			headers http.Header
+			params  url.Values
+		)
+
+		BeforeEach(func() {
+			t = &oauthtoken.OauthToken{
+				UserID:        u.ID,
+				OauthClientID: oc.ID,
+			}
+			Expect(db.Create(t).Error).To(BeNil())
+
+			headers = http.Header{
+				"Authorization": {"Bearer " + t.Token},
+			}
+
+			params = url.Values{
+				"name":        {"GitHub Actions"},
+				"description": {"deploys master"},
+			}
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("POST", s.URL+"/oauth/tokens", params, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("returns 201 Created with a new named token", func() {
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			newToken := &oauthtoken.OauthToken{}
+			Expect(db.Last(newToken).Error).To(BeNil())
+			Expect(newToken.ID).NotTo(Equal(t.ID))
+			Expect(newToken.UserID).To(Equal(u.ID))
+			Expect(newToken.OauthClientID).To(Equal(oc.ID))
+			Expect(newToken.Name).To(Equal("GitHub Actions"))
+			Expect(newToken.Description).To(Equal("deploys master"))
+
+			Expect(res.StatusCode).To(Equal(http.StatusCreated))
+			Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+				"token": {
+					"id": %d,
+					"name": "GitHub Actions",
+					"description": "deploys master",
+					"request_count": 0,
+					"last_used_at": null,
+					"created_at": "%s",
+					"access_token": "%s",
+					"token_type": "bearer"
+				}
+			}`, newToken.ID, newToken.CreatedAt.Format(time.RFC3339Nano), newToken.Token)))
+		})
+
+		It("tracks a 'Created Access Token' event", func() {
+			doRequest()
+
+			trackCall := fakeTracker.TrackCalls.NthCall(1)
+			Expect(trackCall).NotTo(BeNil())
+			Expect(trackCall.Arguments[0]).To(Equal(fmt.Sprintf("%d", u.ID)))
+			Expect(trackCall.Arguments[1]).To(Equal("Created Access Token"))
+
+			props, ok := trackCall.Arguments[3].(map[string]interface{})
+			Expect(ok).To(BeTrue())
+			Expect(props["tokenName"]).To(Equal("GitHub Actions"))
+		})
+
+		Context("when the name is not given", func() {
+			BeforeEach(func() {
+				params.Set("name", "  ")
+			})
+
+			It("returns 422 and does not create a token", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"errors": {
+						"name": "is required"
+					}
+				}`))
+
+				var count int
+				Expect(db.Model(oauthtoken.OauthToken{}).Count(&count).Error).To(BeNil())
+				Expect(count).To(Equal(1))
+			})
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+
+	Describe("DELETE /oauth/tokens/:id", func() {
+		var (
+			t, ciToken *oauthtoken.OauthToken
+			headers    http.Header
+			tokenID    uint
+		)
+
+		BeforeEach(func() {
+			t = &oauthtoken.OauthToken{
+				UserID:        u.ID,
+				OauthClientID: oc.ID,
+			}
+			Expect(db.Create(t).Error).To(BeNil())
+
+			ciToken = &oauthtoken.OauthToken{
+				UserID:        u.ID,
+				OauthClientID: oc.ID,
+				Name:          "GitHub Actions",
+			}
+			Expect(db.Create(ciToken).Error).To(BeNil())
+			tokenID = ciToken.ID
+
+			headers = http.Header{
+				"Authorization": {"Bearer " + t.Token},
+			}
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("DELETE", fmt.Sprintf("%s/oauth/tokens/%d", s.URL, tokenID), nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("returns 200 OK and soft-deletes the token", func() {
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(MatchJSON(`{
+				"invalidated": true
+			}`))
+
+			t1, err := oauthtoken.FindByToken(db, ciToken.Token)
+			Expect(err).To(BeNil())
+			Expect(t1).To(BeNil())
+
+			t2, err := oauthtoken.FindByToken(db, t.Token)
+			Expect(err).To(BeNil())
+			Expect(t2).NotTo(BeNil())
+		})
+
+		Context("when the token belongs to another user", func() {
+			BeforeEach(func() {
+				_, _, otherToken := factories.AuthTrio(db)
+				tokenID = otherToken.ID
+			})
+
+			It("returns 404 and does not delete the token", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "not_found",
+					"error_description": "token could not be found"
+				}`))
+
+				var count int
+				Expect(db.Model(oauthtoken.OauthToken{}).Where("id = ?", tokenID).Count(&count).Error).To(BeNil())
+				Expect(count).To(Equal(1))
+			})
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
 })
@@ -4,10 +4,25 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/nitrous-io/rise-server/apiserver/common"
 )
 
+// Capabilities are the optional parts of the API that this server supports,
+// so that clients can feature-detect instead of guessing from the version.
+var Capabilities = map[string]bool{
+	"zip_bundles":        true,
+	"chunked_upload":     false,
+	"environments":       false,
+	"language_redirects": true,
+	"deployment_reports": true,
+}
+
 func Ping(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
-		"message": "pong",
+		"message":      "pong",
+		"version":      common.Version,
+		"git_sha":      common.GitSHA,
+		"features":     common.Features,
+		"capabilities": Capabilities,
 	})
 }
@@ -6,6 +6,7 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/nitrous-io/rise-server/apiserver/common"
 	"github.com/nitrous-io/rise-server/apiserver/server"
 
 	. "github.com/onsi/ginkgo"
@@ -24,7 +25,14 @@ var _ = Describe("Ping", func() {
 		err error
 	)
 
+	var origVersion, origGitSHA string
+	var origFeatures []string
+
 	BeforeEach(func() {
+		origVersion, origGitSHA, origFeatures = common.Version, common.GitSHA, common.Features
+		common.SetVersion("1.2.3-deadbeef")
+		common.Features = []string{"new-dashboard"}
+
 		s = httptest.NewServer(server.New())
 		res, err = http.Get(s.URL + "/ping")
 		Expect(err).To(BeNil())
@@ -35,14 +43,33 @@ var _ = Describe("Ping", func() {
 			res.Body.Close()
 		}
 		s.Close()
+		common.Version, common.GitSHA, common.Features = origVersion, origGitSHA, origFeatures
 	})
 
 	It("returns 200 OK and a json message containing pong", func() {
-		var j map[string]string
+		var j map[string]interface{}
 		err = json.NewDecoder(res.Body).Decode(&j)
 		Expect(err).To(BeNil())
 
 		Expect(res.StatusCode).To(Equal(http.StatusOK))
 		Expect(j["message"]).To(Equal("pong"))
 	})
+
+	It("returns the version, enabled features and capabilities of the server", func() {
+		var j struct {
+			Version      string          `json:"version"`
+			GitSHA       string          `json:"git_sha"`
+			Features     []string        `json:"features"`
+			Capabilities map[string]bool `json:"capabilities"`
+		}
+		err = json.NewDecoder(res.Body).Decode(&j)
+		Expect(err).To(BeNil())
+
+		Expect(j.Version).To(Equal("1.2.3"))
+		Expect(j.GitSHA).To(Equal("deadbeef"))
+		Expect(j.Features).To(Equal([]string{"new-dashboard"}))
+		Expect(j.Capabilities).To(HaveKeyWithValue("zip_bundles", true))
+		Expect(j.Capabilities).To(HaveKeyWithValue("chunked_upload", false))
+		Expect(j.Capabilities).To(HaveKeyWithValue("environments", false))
+	})
 })
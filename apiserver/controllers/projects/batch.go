@@ -0,0 +1,366 @@
+package projects
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/blacklistedname"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/outboxjob"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/rawbundle"
+	"github.com/nitrous-io/rise-server/apiserver/models/releasedname"
+	"github.com/nitrous-io/rise-server/apiserver/models/template"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/pkg/job"
+	"github.com/nitrous-io/rise-server/shared/errcodes"
+	"github.com/nitrous-io/rise-server/shared/messages"
+	"github.com/nitrous-io/rise-server/shared/queues"
+	"github.com/nitrous-io/rise-server/shared/s3client"
+)
+
+// MaxProjectsPerBatch is the maximum number of projects that can be created
+// in a single batch.
+var MaxProjectsPerBatch = 20
+
+type batchItem struct {
+	Name       string `json:"name"`
+	TemplateID *uint  `json:"template_id"`
+
+	DefaultDomainEnabled *bool   `json:"default_domain_enabled"`
+	ForceHTTPS           *bool   `json:"force_https"`
+	SkipBuild            *bool   `json:"skip_build"`
+	IndexDocument        *string `json:"index_document"`
+}
+
+// CreateBatch creates several projects at once, optionally deploying a
+// template to each of them. Either all of the projects are created or none
+// are, and the response has a result for each project in the order they were
+// given.
+func CreateBatch(c *gin.Context) {
+	u := controllers.CurrentUser(c)
+
+	var params struct {
+		Projects []*batchItem `json:"projects"`
+	}
+	if err := c.BindJSON(&params); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":             "invalid_request",
+			"error_description": "request body is in invalid format",
+		})
+		return
+	}
+
+	if len(params.Projects) == 0 {
+		c.JSON(422, gin.H{
+			"error": "invalid_params",
+			"errors": map[string]string{
+				"projects": "is required",
+			},
+		})
+		return
+	}
+
+	if len(params.Projects) > MaxProjectsPerBatch {
+		c.JSON(422, gin.H{
+			"error": "invalid_params",
+			"errors": map[string]string{
+				"projects": "has too many projects (max. " + strconv.Itoa(MaxProjectsPerBatch) + ")",
+			},
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	var (
+		projs   = make([]*project.Project, len(params.Projects))
+		tmpls   = make([]*template.Template, len(params.Projects))
+		results = make([]gin.H, len(params.Projects))
+		seen    = map[string]bool{}
+		invalid = false
+	)
+
+	for i, item := range params.Projects {
+		proj := &project.Project{
+			Name:   strings.ToLower(item.Name),
+			UserID: u.ID,
+		}
+		if item.IndexDocument != nil {
+			proj.IndexDocument = *item.IndexDocument
+		}
+		projs[i] = proj
+		results[i] = gin.H{"name": proj.Name}
+
+		errs := proj.Validate()
+		if errs == nil {
+			errs = map[string]string{}
+		}
+
+		if errs["name"] == "" {
+			if seen[proj.Name] {
+				errs["name"] = "is duplicated"
+			} else {
+				blacklisted, err := blacklistedname.IsBlacklisted(db, proj.Name)
+				if err != nil {
+					controllers.InternalServerError(c, err)
+					return
+				}
+				if blacklisted {
+					errs["name"] = "is taken"
+				} else {
+					existing, err := project.FindByName(db, proj.Name)
+					if err != nil {
+						controllers.InternalServerError(c, err)
+						return
+					}
+					if existing != nil {
+						errs["name"] = "is taken"
+					} else {
+						held, err := releasedname.IsHeld(db, proj.Name, u.ID)
+						if err != nil {
+							controllers.InternalServerError(c, err)
+							return
+						}
+						if held {
+							errs["name"] = "is taken"
+						}
+					}
+				}
+			}
+			seen[proj.Name] = true
+		}
+
+		if item.TemplateID != nil {
+			tmpl := &template.Template{}
+			if err := db.First(tmpl, *item.TemplateID).Error; err != nil {
+				if err != gorm.RecordNotFound {
+					controllers.InternalServerError(c, err)
+					return
+				}
+				errs["template_id"] = "is not that of a known template"
+			} else if templateArchiveFormat(tmpl) == "" {
+				errs["template_id"] = "is no longer valid"
+			}
+			tmpls[i] = tmpl
+		}
+
+		if len(errs) > 0 {
+			results[i]["errors"] = errs
+			invalid = true
+		}
+	}
+
+	if invalid {
+		respondBatchInvalid(c, results)
+		return
+	}
+
+	var count int
+	if err := db.Model(project.Project{}).Where("user_id = ?", u.ID).Count(&count).Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if count+len(projs) > project.MaxProjectPerUser {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":             "invalid_request",
+			"code":              errcodes.QuotaExceeded,
+			"error_description": "maximum number of projects reached",
+		})
+		return
+	}
+
+	tx := db.Begin()
+	if err := tx.Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+	defer tx.Rollback()
+
+	var obs []*outboxjob.OutboxJob
+	for i, item := range params.Projects {
+		proj := projs[i]
+
+		if err := tx.Create(proj).Error; err != nil {
+			// The name may have been taken since it was checked.
+			if e, ok := err.(*pq.Error); ok && e.Code.Name() == "unique_violation" {
+				results[i]["errors"] = map[string]string{
+					"name": "is taken",
+				}
+				respondBatchInvalid(c, results)
+				return
+			}
+			controllers.InternalServerError(c, err)
+			return
+		}
+
+		// Fields that have a default in the DB are not inserted if they are
+		// false, so they are updated after the project is created.
+		settings := map[string]interface{}{}
+		if item.DefaultDomainEnabled != nil {
+			settings["default_domain_enabled"] = *item.DefaultDomainEnabled
+		}
+		if item.ForceHTTPS != nil {
+			settings["force_https"] = *item.ForceHTTPS
+		}
+		if item.SkipBuild != nil {
+			settings["skip_build"] = *item.SkipBuild
+		}
+		if len(settings) > 0 {
+			if err := tx.Model(proj).UpdateColumns(settings).Error; err != nil {
+				controllers.InternalServerError(c, err)
+				return
+			}
+		}
+
+		// Re-fetch from db to get correct timestamps and defaults.
+		if err := tx.First(proj, proj.ID).Error; err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+		results[i]["project"] = proj.AsJSON()
+
+		if tmpls[i] != nil {
+			depl, ob, err := deployTemplate(tx, u, proj, tmpls[i], controllers.DeploymentSource(c))
+			if err != nil {
+				controllers.InternalServerError(c, err, "projects: failed to deploy a template")
+				return
+			}
+			obs = append(obs, ob)
+			results[i]["deployment"] = depl.AsJSON()
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	outboxjob.DeliverAll(db, obs...)
+
+	for _, proj := range projs {
+		var (
+			event   = "Created Project"
+			props   = map[string]interface{}{"projectName": proj.Name, "batch": true}
+			context = controllers.TrackingContext(c)
+		)
+		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
+			log.Errorf("failed to track %q event for user ID %d, err: %v",
+				event, u.ID, err)
+		}
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"results": results,
+	})
+}
+
+func respondBatchInvalid(c *gin.Context, results []gin.H) {
+	// Nothing is created, so only the errors are returned.
+	for _, r := range results {
+		delete(r, "project")
+	}
+
+	c.JSON(422, gin.H{
+		"error":   "invalid_params",
+		"results": results,
+	})
+}
+
+// templateArchiveFormat returns the format of the archive of a template, or
+// an empty string if it is not supported.
+func templateArchiveFormat(tmpl *template.Template) string {
+	if strings.HasSuffix(tmpl.DownloadURL, ".tar.gz") {
+		return "tar.gz"
+	} else if strings.HasSuffix(tmpl.DownloadURL, ".zip") {
+		return "zip"
+	}
+	return ""
+}
+
+// deployTemplate creates the first deployment of a new project from a
+// template, and adds the job that builds or deploys it to the outbox.
+func deployTemplate(tx *gorm.DB, u *user.User, proj *project.Project, tmpl *template.Template, source string) (*deployment.Deployment, *outboxjob.OutboxJob, error) {
+	archiveFormat := templateArchiveFormat(tmpl)
+
+	ver, err := proj.NextVersion(tx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	depl := &deployment.Deployment{
+		ProjectID:  proj.ID,
+		UserID:     u.ID,
+		TemplateID: &tmpl.ID,
+		Version:    ver,
+		Source:     source,
+		Settings:   proj.DeploymentDefaults,
+	}
+	if err := tx.Create(depl).Error; err != nil {
+		return nil, nil, err
+	}
+
+	bundlePath := "deployments/" + depl.PrefixID() + "/raw-bundle." + archiveFormat
+	if err := s3client.Copy(tmpl.DownloadURL, bundlePath); err != nil {
+		return nil, nil, err
+	}
+
+	bun := &rawbundle.RawBundle{
+		ProjectID:    proj.ID,
+		UploadedPath: bundlePath,
+	}
+	if err := tx.Create(bun).Error; err != nil {
+		return nil, nil, err
+	}
+	depl.RawBundleID = &bun.ID
+
+	if err := depl.UpdateState(tx, deployment.StateUploaded); err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		j        *job.Job
+		newState string
+	)
+	if !proj.NeedsBuild() {
+		j, err = job.NewWithJSON(queues.Deploy, &messages.DeployJobData{
+			DeploymentID:  depl.ID,
+			UseRawBundle:  true,
+			ArchiveFormat: archiveFormat,
+		})
+		newState = deployment.StatePendingDeploy
+	} else {
+		j, err = job.NewWithJSON(queues.Build, &messages.BuildJobData{
+			DeploymentID:  depl.ID,
+			ArchiveFormat: archiveFormat,
+		})
+		newState = deployment.StatePendingBuild
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ob, err := outboxjob.Add(tx, j)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := depl.UpdateState(tx, newState); err != nil {
+		return nil, nil, err
+	}
+
+	return depl, ob, nil
+}
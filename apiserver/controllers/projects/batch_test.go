@@ -0,0 +1,267 @@
+package projects_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/blacklistedname"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/template"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/apiserver/server"
+	"github.com/nitrous-io/rise-server/pkg/filetransfer"
+	"github.com/nitrous-io/rise-server/pkg/mqconn"
+	"github.com/nitrous-io/rise-server/shared/queues"
+	"github.com/nitrous-io/rise-server/shared/s3client"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+	"github.com/nitrous-io/rise-server/testhelper/fake"
+	"github.com/nitrous-io/rise-server/testhelper/sharedexamples"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Project batches", func() {
+	var (
+		db      *gorm.DB
+		mq      mqconn.Conn
+		s       *httptest.Server
+		res     *http.Response
+		headers http.Header
+		err     error
+
+		u *user.User
+		t *oauthtoken.OauthToken
+
+		fakeS3 *fake.S3
+		origS3 filetransfer.FileTransfer
+
+		body string
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+
+		mq, err = mqconn.MQ()
+		Expect(err).To(BeNil())
+		testhelper.DeleteQueue(mq, queues.All...)
+
+		origS3 = s3client.S3
+		fakeS3 = &fake.S3{}
+		s3client.S3 = fakeS3
+
+		u, _, t = factories.AuthTrio(db)
+
+		headers = http.Header{
+			"Authorization": {"Bearer " + t.Token},
+		}
+	})
+
+	AfterEach(func() {
+		if res != nil {
+			res.Body.Close()
+		}
+		s.Close()
+		s3client.S3 = origS3
+		blacklistedname.ClearCache()
+	})
+
+	doRequest := func() {
+		s = httptest.NewServer(server.New())
+		req, err := http.NewRequest("POST", s.URL+"/project_batches", bytes.NewBufferString(body))
+		Expect(err).To(BeNil())
+		req.Header.Add("Content-Type", "application/json")
+
+		for k, v := range headers {
+			for _, h := range v {
+				req.Header.Add(k, h)
+			}
+		}
+
+		res, err = http.DefaultClient.Do(req)
+		Expect(err).To(BeNil())
+	}
+
+	readBody := func() string {
+		b := &bytes.Buffer{}
+		_, err := b.ReadFrom(res.Body)
+		Expect(err).To(BeNil())
+		return b.String()
+	}
+
+	asJSON := func(v interface{}) string {
+		b, err := json.Marshal(v)
+		Expect(err).To(BeNil())
+		return string(b)
+	}
+
+	countProjects := func() int {
+		var count int
+		Expect(db.Model(project.Project{}).Count(&count).Error).To(BeNil())
+		return count
+	}
+
+	sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+		return db, u, &headers
+	}, func() *http.Response {
+		doRequest()
+		return res
+	}, nil)
+
+	Context("when all of the projects are valid", func() {
+		var tmpl *template.Template
+
+		BeforeEach(func() {
+			tmpl = factories.Template(db, 1, "Blog")
+			body = fmt.Sprintf(`{
+				"projects": [
+					{"name": "Client-One"},
+					{"name": "client-two", "template_id": %d, "force_https": true, "default_domain_enabled": false}
+				]
+			}`, tmpl.ID)
+		})
+
+		It("creates the projects and returns a result for each", func() {
+			doRequest()
+
+			Expect(res.StatusCode).To(Equal(http.StatusCreated))
+
+			proj1, err := project.FindByName(db, "client-one")
+			Expect(err).To(BeNil())
+			Expect(proj1).NotTo(BeNil())
+			Expect(proj1.UserID).To(Equal(u.ID))
+			Expect(proj1.DefaultDomainEnabled).To(BeTrue())
+
+			proj2, err := project.FindByName(db, "client-two")
+			Expect(err).To(BeNil())
+			Expect(proj2).NotTo(BeNil())
+			Expect(proj2.ForceHTTPS).To(BeTrue())
+			Expect(proj2.DefaultDomainEnabled).To(BeFalse())
+
+			depl := &deployment.Deployment{}
+			Expect(db.Last(depl).Error).To(BeNil())
+			Expect(depl.ProjectID).To(Equal(proj2.ID))
+			Expect(*depl.TemplateID).To(Equal(tmpl.ID))
+
+			Expect(readBody()).To(MatchJSON(fmt.Sprintf(`{
+				"results": [
+					{"name": "client-one", "project": %s},
+					{"name": "client-two", "project": %s, "deployment": %s}
+				]
+			}`, asJSON(proj1.AsJSON()), asJSON(proj2.AsJSON()), asJSON(depl.AsJSON()))))
+		})
+
+		It("deploys the templates", func() {
+			doRequest()
+
+			depl := &deployment.Deployment{}
+			Expect(db.Last(depl).Error).To(BeNil())
+			Expect(depl.State).To(Equal(deployment.StatePendingDeploy))
+
+			Expect(fakeS3.CopyCalls.Count()).To(Equal(1))
+			call := fakeS3.CopyCalls.NthCall(1)
+			Expect(call.Arguments[2]).To(Equal(tmpl.DownloadURL))
+			Expect(call.Arguments[3]).To(Equal("deployments/" + depl.PrefixID() + "/raw-bundle.tar.gz"))
+
+			m := testhelper.ConsumeQueue(mq, queues.Deploy)
+			Expect(m).NotTo(BeNil())
+			Expect(m.Body).To(MatchJSON(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"skip_webroot_upload": false,
+				"skip_invalidation": false,
+				"use_raw_bundle": true,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+		})
+	})
+
+	Context("when any of the projects is invalid", func() {
+		BeforeEach(func() {
+			factories.Project(db, nil, "client-two")
+			factories.BlacklistedNameWithKind(db, blacklistedname.KindPrefix, "pubstorm-")
+
+			body = `{
+				"projects": [
+					{"name": "client-one"},
+					{"name": "client-two"},
+					{"name": "pubstorm-client"},
+					{"name": "client_four", "template_id": 999},
+					{"name": "client-one"}
+				]
+			}`
+		})
+
+		It("returns 422 with the errors of each project and creates none of them", func() {
+			doRequest()
+
+			Expect(res.StatusCode).To(Equal(422))
+			Expect(readBody()).To(MatchJSON(`{
+				"error": "invalid_params",
+				"results": [
+					{"name": "client-one"},
+					{"name": "client-two", "errors": {"name": "is taken"}},
+					{"name": "pubstorm-client", "errors": {"name": "is taken"}},
+					{"name": "client_four", "errors": {"name": "is invalid", "template_id": "is not that of a known template"}},
+					{"name": "client-one", "errors": {"name": "is duplicated"}}
+				]
+			}`))
+
+			Expect(countProjects()).To(Equal(1))
+		})
+	})
+
+	Context("when the projects would exceed the maximum number of projects", func() {
+		var origMaxProjects int
+
+		BeforeEach(func() {
+			origMaxProjects = project.MaxProjectPerUser
+			project.MaxProjectPerUser = 2
+			factories.Project(db, u)
+
+			body = `{"projects": [{"name": "client-one"}, {"name": "client-two"}]}`
+		})
+
+		AfterEach(func() {
+			project.MaxProjectPerUser = origMaxProjects
+		})
+
+		It("returns 403 forbidden", func() {
+			doRequest()
+
+			Expect(res.StatusCode).To(Equal(http.StatusForbidden))
+			Expect(readBody()).To(MatchJSON(`{
+				"error": "invalid_request",
+				"code": "quota_exceeded",
+				"error_description": "maximum number of projects reached"
+			}`))
+
+			Expect(countProjects()).To(Equal(1))
+		})
+	})
+
+	Context("when no projects are given", func() {
+		BeforeEach(func() {
+			body = `{"projects": []}`
+		})
+
+		It("returns 422", func() {
+			doRequest()
+
+			Expect(res.StatusCode).To(Equal(422))
+			Expect(readBody()).To(MatchJSON(`{
+				"error": "invalid_params",
+				"errors": {"projects": "is required"}
+			}`))
+		})
+	})
+})
@@ -1,20 +1,25 @@
 package projects
 
 import (
-	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/gin-gonic/gin"
 	"github.com/nitrous-io/rise-server/apiserver/common"
 	"github.com/nitrous-io/rise-server/apiserver/controllers"
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/apirequest"
 	"github.com/nitrous-io/rise-server/apiserver/models/collab"
 	"github.com/nitrous-io/rise-server/apiserver/models/project"
 	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/shared/errcodes"
 )
 
+// ListCollaborators lists the collaborators of a project with when and by whom
+// they were added, and the last change they made to the project, so that
+// owners can tell who no longer needs access.
 func ListCollaborators(c *gin.Context) {
 	proj := controllers.CurrentProject(c)
 
@@ -24,16 +29,62 @@ func ListCollaborators(c *gin.Context) {
 		return
 	}
 
-	collaborators := []struct {
-		Email string `json:"email"`
-	}{}
+	var collabs []struct {
+		UserID       uint
+		Email        string
+		CreatedAt    time.Time
+		AddedByEmail *string
+	}
+	if err := db.Model(collab.Collab{}).
+		Select("collabs.user_id, users.email, collabs.created_at, added_by.email AS added_by_email").
+		Joins("JOIN users ON users.id = collabs.user_id LEFT JOIN users added_by ON added_by.id = collabs.added_by_user_id").
+		Where("collabs.project_id = ?", proj.ID).Order("users.email ASC").Scan(&collabs).Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
 
-	if err := db.Model(collab.Collab{}).Select("users.email").Joins("JOIN projects ON projects.id = collabs.project_id JOIN users ON users.id = collabs.user_id").Where("collabs.project_id = ?", proj.ID).Order("users.email ASC").Scan(&collaborators).Error; err != nil {
-		fmt.Println(err)
+	userIDs := make([]uint, len(collabs))
+	for i, cl := range collabs {
+		userIDs[i] = cl.UserID
+	}
+
+	// Actions are only known if API requests are being recorded to the DB.
+	lastActions, err := apirequest.LastActionsByUserID(db, proj.Name, userIDs)
+	if err != nil {
 		controllers.InternalServerError(c, err)
 		return
 	}
 
+	type actionJSON struct {
+		Method string    `json:"method"`
+		Route  string    `json:"route"`
+		At     time.Time `json:"at"`
+	}
+	type collaboratorJSON struct {
+		Email      string      `json:"email"`
+		Role       string      `json:"role"`
+		AddedAt    time.Time   `json:"added_at"`
+		AddedBy    *string     `json:"added_by,omitempty"`
+		LastAction *actionJSON `json:"last_action,omitempty"`
+	}
+
+	collaborators := make([]collaboratorJSON, len(collabs))
+	for i, cl := range collabs {
+		collaborators[i] = collaboratorJSON{
+			Email:   cl.Email,
+			Role:    collab.RoleCollaborator,
+			AddedAt: cl.CreatedAt,
+			AddedBy: cl.AddedByEmail,
+		}
+		if r := lastActions[cl.UserID]; r != nil {
+			collaborators[i].LastAction = &actionJSON{
+				Method: r.Method,
+				Route:  r.Route,
+				At:     r.CreatedAt,
+			}
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"collaborators": collaborators,
 	})
@@ -62,7 +113,7 @@ func AddCollaborator(c *gin.Context) {
 		return
 	}
 
-	if err := proj.AddCollaborator(db, u); err != nil {
+	if err := proj.AddCollaboratorBy(db, u, controllers.CurrentUser(c)); err != nil {
 		switch err {
 		case project.ErrCollaboratorIsOwner:
 			c.JSON(422, gin.H{
@@ -72,6 +123,7 @@ func AddCollaborator(c *gin.Context) {
 		case project.ErrCollaboratorAlreadyExists:
 			c.JSON(http.StatusConflict, gin.H{
 				"error":             "already_exists",
+				"code":              errcodes.AlreadyCollaborator,
 				"error_description": "user is already a collaborator",
 			})
 		default:
@@ -89,10 +141,7 @@ func AddCollaborator(c *gin.Context) {
 				"projectName": proj.Name,
 				"collabEmail": u.Email,
 			}
-			context = map[string]interface{}{
-				"ip":         common.GetIP(c.Request),
-				"user_agent": c.Request.UserAgent(),
-			}
+			context = controllers.TrackingContext(c)
 		)
 		if err := common.Track(strconv.Itoa(int(currUser.ID)), event, "", props, context); err != nil {
 			log.Errorf("failed to track %q event for user ID %d, err: %v",
@@ -145,10 +194,7 @@ func RemoveCollaborator(c *gin.Context) {
 				"projectName": proj.Name,
 				"collabEmail": u.Email,
 			}
-			context = map[string]interface{}{
-				"ip":         common.GetIP(c.Request),
-				"user_agent": c.Request.UserAgent(),
-			}
+			context = controllers.TrackingContext(c)
 		)
 		if err := common.Track(strconv.Itoa(int(currUser.ID)), event, "", props, context); err != nil {
 			log.Errorf("failed to track %q event for user ID %d, err: %v",
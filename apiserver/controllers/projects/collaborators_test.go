@@ -2,14 +2,17 @@ package projects_test
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"time"
 
 	"github.com/jinzhu/gorm"
 	"github.com/nitrous-io/rise-server/apiserver/common"
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/apirequest"
 	"github.com/nitrous-io/rise-server/apiserver/models/collab"
 	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
 	"github.com/nitrous-io/rise-server/apiserver/models/project"
@@ -88,9 +91,22 @@ var _ = Describe("Project collaborators", func() {
 			BeforeEach(func() {
 				u2 = factories.User(db)
 				u3 = factories.User(db)
-				factories.Collab(db, proj, u2)
+				cl := factories.Collab(db, proj, u2)
+				Expect(db.Model(cl).Update("added_by_user_id", u.ID).Error).To(BeNil())
 				factories.Collab(db, proj, u3)
 				factories.Collab(db, nil, nil) // another project
+
+				projName := proj.Name
+				for _, r := range []*apirequest.APIRequest{
+					{Method: "POST", Route: "/projects/:project_name/deployments", StatusCode: http.StatusAccepted},
+					{Method: "PUT", Route: "/projects/:project_name", StatusCode: http.StatusConflict},
+					{Method: "GET", Route: "/projects/:project_name", StatusCode: http.StatusOK},
+				} {
+					r.Path = r.Route
+					r.UserID = &u2.ID
+					r.ProjectName = &projName
+					Expect(db.Create(r).Error).To(BeNil())
+				}
 			})
 
 			It("returns 200 OK with the project's collaborators", func() {
@@ -101,16 +117,39 @@ var _ = Describe("Project collaborators", func() {
 				Expect(err).To(BeNil())
 
 				Expect(res.StatusCode).To(Equal(http.StatusOK))
-				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
-					"collaborators": [
-						{
-							"email": "%s"
-						},
-						{
-							"email": "%s"
-						}
-					]
-				}`, u2.Email, u3.Email)))
+
+				var j struct {
+					Collaborators []struct {
+						Email      string     `json:"email"`
+						Role       string     `json:"role"`
+						AddedAt    *time.Time `json:"added_at"`
+						AddedBy    *string    `json:"added_by"`
+						LastAction *struct {
+							Method string     `json:"method"`
+							Route  string     `json:"route"`
+							At     *time.Time `json:"at"`
+						} `json:"last_action"`
+					} `json:"collaborators"`
+				}
+				Expect(json.Unmarshal(b.Bytes(), &j)).To(BeNil())
+
+				collabs := j.Collaborators
+				Expect(collabs).To(HaveLen(2))
+
+				Expect(collabs[0].Email).To(Equal(u2.Email))
+				Expect(collabs[0].Role).To(Equal("collaborator"))
+				Expect(collabs[0].AddedAt).NotTo(BeNil())
+				Expect(*collabs[0].AddedBy).To(Equal(u.Email))
+				Expect(collabs[0].LastAction).NotTo(BeNil())
+				Expect(collabs[0].LastAction.Method).To(Equal("POST"))
+				Expect(collabs[0].LastAction.Route).To(Equal("/projects/:project_name/deployments"))
+				Expect(collabs[0].LastAction.At).NotTo(BeNil())
+
+				Expect(collabs[1].Email).To(Equal(u3.Email))
+				Expect(collabs[1].Role).To(Equal("collaborator"))
+				Expect(collabs[1].AddedAt).NotTo(BeNil())
+				Expect(collabs[1].AddedBy).To(BeNil())
+				Expect(collabs[1].LastAction).To(BeNil())
 			})
 		})
 
@@ -201,6 +240,7 @@ var _ = Describe("Project collaborators", func() {
 				Expect(res.StatusCode).To(Equal(http.StatusConflict))
 				Expect(b.String()).To(MatchJSON(`{
 					"error": "already_exists",
+					"code": "already_collaborator",
 					"error_description": "user is already a collaborator"
 				}`))
 			})
@@ -231,6 +271,7 @@ var _ = Describe("Project collaborators", func() {
 				Expect(len(cols)).To(Equal(1))
 				Expect(cols[0].UserID).To(Equal(anotherU.ID))
 				Expect(cols[0].ProjectID).To(Equal(proj.ID))
+				Expect(*cols[0].AddedByUserID).To(Equal(u.ID))
 			})
 
 			It("tracks an 'Added Collaborator' event", func() {
@@ -0,0 +1,84 @@
+package projects
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+)
+
+// ShowContentTypes shows the content types that override the type registered
+// for file extensions when a project is deployed.
+func ShowContentTypes(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	respondContentTypes(c, proj)
+}
+
+// UpdateContentTypes replaces the content types that override the type
+// registered for file extensions or specific paths. Files are uploaded with
+// their content type when they are deployed, so the overrides only apply to
+// deployments made after they are updated.
+func UpdateContentTypes(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	if !checkLockVersion(c, proj) {
+		return
+	}
+
+	var params struct {
+		ContentTypes map[string]string `json:"content_types"`
+	}
+	if err := c.Bind(&params); err != nil || params.ContentTypes == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":             "invalid_request",
+			"error_description": "request body is in invalid format",
+		})
+		return
+	}
+
+	if err := proj.SetContentTypeOverrides(params.ContentTypes); err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if errs := proj.Validate(); errs != nil {
+		c.JSON(422, gin.H{
+			"error":  "invalid_params",
+			"errors": errs,
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if err := proj.SaveWithLock(db); err != nil {
+		if err == project.ErrStaleProject {
+			respondConflict(c)
+			return
+		}
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	respondContentTypes(c, proj)
+}
+
+func respondContentTypes(c *gin.Context, proj *project.Project) {
+	overrides, err := proj.ContentTypeOverridesMap()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"content_types": overrides,
+		"lock_version":  proj.LockVersion,
+	})
+}
@@ -0,0 +1,230 @@
+package projects_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/apiserver/server"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+	"github.com/nitrous-io/rise-server/testhelper/sharedexamples"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Project content types", func() {
+	var (
+		db      *gorm.DB
+		s       *httptest.Server
+		res     *http.Response
+		headers http.Header
+		err     error
+
+		u    *user.User
+		t    *oauthtoken.OauthToken
+		proj *project.Project
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+
+		u, _, t = factories.AuthTrio(db)
+
+		headers = http.Header{
+			"Authorization": {"Bearer " + t.Token},
+		}
+
+		proj = factories.Project(db, u, "panda-express")
+	})
+
+	AfterEach(func() {
+		if res != nil {
+			res.Body.Close()
+		}
+		s.Close()
+	})
+
+	Describe("GET /projects/:project_name/content_types", func() {
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("GET", s.URL+"/projects/panda-express/content_types", nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("returns 200 OK with the content type overrides", func() {
+			Expect(proj.SetContentTypeOverrides(map[string]string{".unityweb": "application/octet-stream"})).To(Succeed())
+			Expect(db.Save(proj).Error).To(BeNil())
+
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(MatchJSON(`{
+				"content_types": {
+					".unityweb": "application/octet-stream"
+				},
+				"lock_version": 0
+			}`))
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+
+	Describe("PUT /projects/:project_name/content_types", func() {
+		var (
+			body string
+			path string
+		)
+
+		BeforeEach(func() {
+			path = "/projects/panda-express/content_types"
+			body = `{
+				"content_types": {
+					"WASM": "application/wasm",
+					"/Build/game.data": "application/octet-stream"
+				}
+			}`
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+
+			req, err := http.NewRequest("PUT", s.URL+path, bytes.NewBufferString(body))
+			Expect(err).To(BeNil())
+			req.Header.Add("Content-Type", "application/json")
+
+			for k, v := range headers {
+				for _, h := range v {
+					req.Header.Add(k, h)
+				}
+			}
+
+			res, err = http.DefaultClient.Do(req)
+			Expect(err).To(BeNil())
+		}
+
+		It("returns 200 OK and replaces the content type overrides", func() {
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(MatchJSON(`{
+				"content_types": {
+					".wasm": "application/wasm",
+					"/Build/game.data": "application/octet-stream"
+				},
+				"lock_version": 1
+			}`))
+
+			Expect(db.First(proj, proj.ID).Error).To(BeNil())
+			overrides, err := proj.ContentTypeOverridesMap()
+			Expect(err).To(BeNil())
+			Expect(overrides).To(Equal(map[string]string{
+				".wasm":            "application/wasm",
+				"/Build/game.data": "application/octet-stream",
+			}))
+		})
+
+		Context("when content_types is missing", func() {
+			BeforeEach(func() {
+				body = `{}`
+			})
+
+			It("returns 400", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusBadRequest))
+			})
+		})
+
+		Context("when an invalid content type is given", func() {
+			BeforeEach(func() {
+				body = `{"content_types": {".wasm": "wasm"}}`
+			})
+
+			It("returns 422 and does not update the project", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"errors": {
+						"content_types": "\"wasm\" is not a valid content type"
+					}
+				}`))
+
+				Expect(db.First(proj, proj.ID).Error).To(BeNil())
+				Expect(proj.LockVersion).To(Equal(int64(0)))
+			})
+		})
+
+		Context("when lock_version does not match the current lock version", func() {
+			BeforeEach(func() {
+				path += "?lock_version=1"
+			})
+
+			It("returns 409 conflict and does not update the project", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusConflict))
+
+				Expect(db.First(proj, proj.ID).Error).To(BeNil())
+				overrides, err := proj.ContentTypeOverridesMap()
+				Expect(err).To(BeNil())
+				Expect(overrides).To(BeEmpty())
+			})
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItLocksProject(func() (*gorm.DB, *project.Project) {
+			return db, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+})
@@ -0,0 +1,112 @@
+package projects
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+)
+
+// ShowDeploymentDefaults shows the settings that new deployments of a project
+// are deployed with.
+func ShowDeploymentDefaults(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	respondDeploymentDefaults(c, proj)
+}
+
+// UpdateDeploymentDefaults updates the settings that new deployments of a
+// project are deployed with. Settings that are not given are left unchanged.
+// Deployments keep the settings they were made with, so the defaults only
+// apply to deployments made after they are updated. Minification is the
+// project's skip_build setting, as assets are minified when they are built.
+func UpdateDeploymentDefaults(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	if !checkLockVersion(c, proj) {
+		return
+	}
+
+	var params struct {
+		Precompress      *bool    `json:"precompress"`
+		CachePolicy      *string  `json:"cache_policy"`
+		Minify           *bool    `json:"minify"`
+		VerificationURLs []string `json:"verification_urls"`
+	}
+	if err := c.Bind(&params); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":             "invalid_request",
+			"error_description": "request body is in invalid format",
+		})
+		return
+	}
+
+	defaults, err := proj.DeploymentDefaultsData()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if params.Precompress != nil {
+		defaults.Precompress = *params.Precompress
+	}
+	if params.CachePolicy != nil {
+		defaults.CachePolicy = *params.CachePolicy
+	}
+	if params.VerificationURLs != nil {
+		defaults.VerificationURLs = params.VerificationURLs
+	}
+	if params.Minify != nil {
+		proj.SkipBuild = !*params.Minify
+	}
+
+	if err := proj.SetDeploymentDefaults(defaults); err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if errs := proj.Validate(); errs != nil {
+		c.JSON(422, gin.H{
+			"error":  "invalid_params",
+			"errors": errs,
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if err := proj.SaveWithLock(db); err != nil {
+		if err == project.ErrStaleProject {
+			respondConflict(c)
+			return
+		}
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	respondDeploymentDefaults(c, proj)
+}
+
+func respondDeploymentDefaults(c *gin.Context, proj *project.Project) {
+	defaults, err := proj.DeploymentDefaultsData()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"deployment_defaults": gin.H{
+			"precompress":       defaults.Precompress,
+			"cache_policy":      defaults.CachePolicy,
+			"minify":            !proj.SkipBuild,
+			"verification_urls": defaults.VerificationURLs,
+		},
+		"lock_version": proj.LockVersion,
+	})
+}
@@ -0,0 +1,254 @@
+package projects_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/apiserver/server"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+	"github.com/nitrous-io/rise-server/testhelper/sharedexamples"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Project deployment defaults", func() {
+	var (
+		db      *gorm.DB
+		s       *httptest.Server
+		res     *http.Response
+		headers http.Header
+		err     error
+
+		u    *user.User
+		t    *oauthtoken.OauthToken
+		proj *project.Project
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+
+		u, _, t = factories.AuthTrio(db)
+
+		headers = http.Header{
+			"Authorization": {"Bearer " + t.Token},
+		}
+
+		proj = factories.Project(db, u, "panda-express")
+	})
+
+	AfterEach(func() {
+		if res != nil {
+			res.Body.Close()
+		}
+		s.Close()
+	})
+
+	Describe("GET /projects/:project_name/deployment_defaults", func() {
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("GET", s.URL+"/projects/panda-express/deployment_defaults", nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("returns 200 OK with the defaults of a project that has none set", func() {
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(MatchJSON(`{
+				"deployment_defaults": {
+					"precompress": false,
+					"cache_policy": "default",
+					"minify": false,
+					"verification_urls": []
+				},
+				"lock_version": 0
+			}`))
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+
+	Describe("PUT /projects/:project_name/deployment_defaults", func() {
+		var (
+			body string
+			path string
+		)
+
+		BeforeEach(func() {
+			path = "/projects/panda-express/deployment_defaults"
+			body = `{
+				"precompress": true,
+				"cache_policy": "immutable_assets",
+				"minify": true,
+				"verification_urls": ["/", " /cart/./confirm "]
+			}`
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+
+			req, err := http.NewRequest("PUT", s.URL+path, bytes.NewBufferString(body))
+			Expect(err).To(BeNil())
+			req.Header.Add("Content-Type", "application/json")
+
+			for k, v := range headers {
+				for _, h := range v {
+					req.Header.Add(k, h)
+				}
+			}
+
+			res, err = http.DefaultClient.Do(req)
+			Expect(err).To(BeNil())
+		}
+
+		It("returns 200 OK and updates the defaults", func() {
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(MatchJSON(`{
+				"deployment_defaults": {
+					"precompress": true,
+					"cache_policy": "immutable_assets",
+					"minify": true,
+					"verification_urls": ["/", "/cart/confirm"]
+				},
+				"lock_version": 1
+			}`))
+
+			Expect(db.First(proj, proj.ID).Error).To(BeNil())
+			Expect(proj.SkipBuild).To(BeFalse())
+
+			defaults, err := proj.DeploymentDefaultsData()
+			Expect(err).To(BeNil())
+			Expect(defaults).To(Equal(&deployment.Settings{
+				Precompress:      true,
+				CachePolicy:      deployment.CachePolicyImmutableAssets,
+				VerificationURLs: []string{"/", "/cart/confirm"},
+			}))
+		})
+
+		Context("when only some of the defaults are given", func() {
+			BeforeEach(func() {
+				doRequest()
+				res.Body.Close()
+				s.Close()
+
+				body = `{"cache_policy": "no_cache"}`
+				path += "?lock_version=1"
+			})
+
+			It("leaves the others unchanged", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(b.String()).To(MatchJSON(`{
+					"deployment_defaults": {
+						"precompress": true,
+						"cache_policy": "no_cache",
+						"minify": true,
+						"verification_urls": ["/", "/cart/confirm"]
+					},
+					"lock_version": 2
+				}`))
+			})
+		})
+
+		Context("when an invalid cache policy is given", func() {
+			BeforeEach(func() {
+				body = `{"cache_policy": "forever"}`
+			})
+
+			It("returns 422 and does not update the project", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"errors": {
+						"deployment_defaults": "cache_policy must be one of default, no_cache, immutable_assets"
+					}
+				}`))
+
+				Expect(db.First(proj, proj.ID).Error).To(BeNil())
+				Expect(proj.LockVersion).To(Equal(int64(0)))
+			})
+		})
+
+		Context("when lock_version does not match the current lock version", func() {
+			BeforeEach(func() {
+				path += "?lock_version=1"
+			})
+
+			It("returns 409 conflict and does not update the project", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusConflict))
+
+				Expect(db.First(proj, proj.ID).Error).To(BeNil())
+				defaults, err := proj.DeploymentDefaultsData()
+				Expect(err).To(BeNil())
+				Expect(defaults).To(Equal(deployment.NewSettings()))
+			})
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItLocksProject(func() (*gorm.DB, *project.Project) {
+			return db, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+})
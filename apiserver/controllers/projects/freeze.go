@@ -0,0 +1,149 @@
+package projects
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+)
+
+// ShowFreeze shows the freeze of a project's deployments.
+func ShowFreeze(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	c.JSON(http.StatusOK, gin.H{
+		"freeze": proj.FreezeInfo(),
+	})
+}
+
+// Freeze rejects new deployments of a project during a window, e.g. during a
+// launch or a holiday, unless the owner overrides the freeze. The window
+// starts now unless starts_at is given, and lasts until the freeze is lifted
+// unless ends_at is given.
+func Freeze(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	errs := map[string]string{}
+	parseTime := func(key string) *time.Time {
+		v := c.PostForm(key)
+		if v == "" {
+			return nil
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			errs[key] = "must be an RFC 3339 time"
+			return nil
+		}
+		return &t
+	}
+
+	now := time.Now()
+	startsAt := now
+	if t := parseTime("starts_at"); t != nil {
+		startsAt = *t
+	}
+	endsAt := parseTime("ends_at")
+	if endsAt != nil && errs["starts_at"] == "" {
+		if !endsAt.After(startsAt) {
+			errs["ends_at"] = "must be after starts_at"
+		} else if !endsAt.After(now) {
+			errs["ends_at"] = "must be in the future"
+		}
+	}
+
+	var reason *string
+	if v := strings.TrimSpace(c.PostForm("reason")); v != "" {
+		if len(v) > project.MaxFreezeReasonLength {
+			errs["reason"] = fmt.Sprintf("is too long (max. %d characters)", project.MaxFreezeReasonLength)
+		}
+		reason = &v
+	}
+
+	if len(errs) > 0 {
+		c.JSON(422, gin.H{
+			"error":  "invalid_params",
+			"errors": errs,
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if err := proj.Freeze(db, startsAt, endsAt, reason); err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	{
+		u := controllers.CurrentUser(c)
+
+		var (
+			event = "Froze Project Deployments"
+			props = map[string]interface{}{
+				"projectName": proj.Name,
+				"startsAt":    proj.FreezeStartsAt,
+			}
+			context = controllers.TrackingContext(c)
+		)
+		if proj.FreezeEndsAt != nil {
+			props["endsAt"] = proj.FreezeEndsAt
+		}
+		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
+			log.Errorf("failed to track %q event for user ID %d, err: %v",
+				event, u.ID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"freeze": proj.FreezeInfo(),
+	})
+}
+
+// Unfreeze lifts the freeze of a project's deployments.
+func Unfreeze(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	unfrozen, err := proj.Unfreeze(db)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if unfrozen {
+		u := controllers.CurrentUser(c)
+
+		var (
+			event = "Unfroze Project Deployments"
+			props = map[string]interface{}{
+				"projectName": proj.Name,
+			}
+			context = controllers.TrackingContext(c)
+		)
+		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
+			log.Errorf("failed to track %q event for user ID %d, err: %v",
+				event, u.ID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"unfrozen": unfrozen,
+	})
+}
@@ -0,0 +1,343 @@
+package projects_test
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/apiserver/server"
+	"github.com/nitrous-io/rise-server/pkg/tracker"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+	"github.com/nitrous-io/rise-server/testhelper/fake"
+	"github.com/nitrous-io/rise-server/testhelper/sharedexamples"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Project freeze", func() {
+	var (
+		db      *gorm.DB
+		s       *httptest.Server
+		res     *http.Response
+		headers http.Header
+		err     error
+
+		u    *user.User
+		t    *oauthtoken.OauthToken
+		proj *project.Project
+
+		fakeTracker *fake.Tracker
+		origTracker tracker.Trackable
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+
+		origTracker = common.Tracker
+		fakeTracker = &fake.Tracker{}
+		common.Tracker = fakeTracker
+
+		u, _, t = factories.AuthTrio(db)
+
+		headers = http.Header{
+			"Authorization": {"Bearer " + t.Token},
+		}
+
+		proj = factories.Project(db, u, "panda-express")
+	})
+
+	AfterEach(func() {
+		if res != nil {
+			res.Body.Close()
+		}
+		s.Close()
+		common.Tracker = origTracker
+	})
+
+	Describe("GET /projects/:project_name/freeze", func() {
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("GET", s.URL+"/projects/panda-express/freeze", nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		Context("when the project is not frozen", func() {
+			It("returns 200 OK with frozen set to false", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(b.String()).To(MatchJSON(`{
+					"freeze": {
+						"frozen": false
+					}
+				}`))
+			})
+		})
+
+		Context("when the project has a freeze that has not started yet", func() {
+			BeforeEach(func() {
+				reason := "Launch day"
+				Expect(proj.Freeze(db, time.Now().Add(time.Hour), nil, &reason)).To(Succeed())
+			})
+
+			It("returns 200 OK with the freeze", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(db.First(proj, proj.ID).Error).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"freeze": {
+						"frozen": false,
+						"starts_at": "%s",
+						"reason": "Launch day"
+					}
+				}`, proj.FreezeStartsAt.Format(time.RFC3339Nano))))
+			})
+		})
+
+		Context("when the freeze of the project has ended", func() {
+			BeforeEach(func() {
+				endsAt := time.Now().Add(-time.Minute)
+				Expect(proj.Freeze(db, time.Now().Add(-time.Hour), &endsAt, nil)).To(Succeed())
+			})
+
+			It("returns 200 OK with frozen set to false", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(b.String()).To(MatchJSON(`{
+					"freeze": {
+						"frozen": false
+					}
+				}`))
+			})
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+
+	Describe("POST /projects/:project_name/freeze", func() {
+		var params url.Values
+
+		BeforeEach(func() {
+			params = url.Values{}
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("POST", s.URL+"/projects/panda-express/freeze", params, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("returns 200 OK and freezes the project from now on", func() {
+			params.Set("reason", "Holidays")
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(db.First(proj, proj.ID).Error).To(BeNil())
+			Expect(proj.FreezeStartsAt).NotTo(BeNil())
+			Expect(*proj.FreezeStartsAt).To(BeTemporally("~", time.Now(), 5*time.Second))
+			Expect(proj.FreezeEndsAt).To(BeNil())
+			Expect(proj.FreezeReason).NotTo(BeNil())
+			Expect(*proj.FreezeReason).To(Equal("Holidays"))
+			Expect(proj.IsFrozen(time.Now())).To(BeTrue())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+				"freeze": {
+					"frozen": true,
+					"starts_at": "%s",
+					"reason": "Holidays"
+				}
+			}`, proj.FreezeStartsAt.Format(time.RFC3339Nano))))
+		})
+
+		It("freezes the project during the given window", func() {
+			params.Set("starts_at", "2030-12-24T00:00:00Z")
+			params.Set("ends_at", "2030-12-27T00:00:00+08:00")
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(MatchJSON(`{
+				"freeze": {
+					"frozen": false,
+					"starts_at": "2030-12-24T00:00:00Z",
+					"ends_at": "2030-12-26T16:00:00Z"
+				}
+			}`))
+
+			Expect(db.First(proj, proj.ID).Error).To(BeNil())
+			Expect(proj.IsFrozen(time.Date(2030, 12, 25, 0, 0, 0, 0, time.UTC))).To(BeTrue())
+			Expect(proj.IsFrozen(time.Date(2030, 12, 27, 0, 0, 0, 0, time.UTC))).To(BeFalse())
+		})
+
+		It("tracks a 'Froze Project Deployments' event", func() {
+			doRequest()
+
+			trackCall := fakeTracker.TrackCalls.NthCall(1)
+			Expect(trackCall).NotTo(BeNil())
+			Expect(trackCall.Arguments[0]).To(Equal(fmt.Sprintf("%d", u.ID)))
+			Expect(trackCall.Arguments[1]).To(Equal("Froze Project Deployments"))
+
+			props, ok := trackCall.Arguments[3].(map[string]interface{})
+			Expect(ok).To(BeTrue())
+			Expect(props["projectName"]).To(Equal("panda-express"))
+		})
+
+		DescribeTable("invalid params",
+			func(key, value, message string) {
+				params.Set(key, value)
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"error": "invalid_params",
+					"errors": {
+						%q: %q
+					}
+				}`, key, message)))
+
+				Expect(db.First(proj, proj.ID).Error).To(BeNil())
+				Expect(proj.FreezeStartsAt).To(BeNil())
+			},
+			Entry("starts_at", "starts_at", "tomorrow", "must be an RFC 3339 time"),
+			Entry("ends_at", "ends_at", "2030-12-27", "must be an RFC 3339 time"),
+			Entry("ends_at in the past", "ends_at", "2016-01-01T00:00:00Z", "must be after starts_at"),
+			Entry("reason", "reason", strings.Repeat("a", project.MaxFreezeReasonLength+1), "is too long (max. 255 characters)"),
+		)
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProject(func() (*gorm.DB, *project.Project) {
+			return db, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+
+	Describe("DELETE /projects/:project_name/freeze", func() {
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("DELETE", s.URL+"/projects/panda-express/freeze", nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		Context("when the project is frozen", func() {
+			BeforeEach(func() {
+				Expect(proj.Freeze(db, time.Now().Add(-time.Hour), nil, nil)).To(Succeed())
+			})
+
+			It("returns 200 OK and lifts the freeze", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(b.String()).To(MatchJSON(`{
+					"unfrozen": true
+				}`))
+
+				Expect(db.First(proj, proj.ID).Error).To(BeNil())
+				Expect(proj.FreezeStartsAt).To(BeNil())
+				Expect(proj.IsFrozen(time.Now())).To(BeFalse())
+			})
+
+			It("tracks an 'Unfroze Project Deployments' event", func() {
+				doRequest()
+
+				trackCall := fakeTracker.TrackCalls.NthCall(1)
+				Expect(trackCall).NotTo(BeNil())
+				Expect(trackCall.Arguments[1]).To(Equal("Unfroze Project Deployments"))
+			})
+		})
+
+		Context("when the project is not frozen", func() {
+			It("returns 200 OK with unfrozen set to false", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(b.String()).To(MatchJSON(`{
+					"unfrozen": false
+				}`))
+				Expect(fakeTracker.TrackCalls.Count()).To(Equal(0))
+			})
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProject(func() (*gorm.DB, *project.Project) {
+			return db, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+})
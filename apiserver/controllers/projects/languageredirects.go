@@ -0,0 +1,76 @@
+package projects
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+)
+
+// ShowLanguageRedirects shows the redirects that are made based on the
+// preferred language of visitors.
+func ShowLanguageRedirects(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	respondLanguageRedirects(c, proj)
+}
+
+// UpdateLanguageRedirects replaces the redirects that are made based on the
+// preferred language of visitors, e.g. from "/" to "/de/" for visitors who
+// prefer German.
+func UpdateLanguageRedirects(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	if !checkLockVersion(c, proj) {
+		return
+	}
+
+	var params struct {
+		LanguageRedirects []project.LanguageRedirect `json:"language_redirects"`
+	}
+	if err := c.Bind(&params); err != nil || params.LanguageRedirects == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":             "invalid_request",
+			"error_description": "request body is in invalid format",
+		})
+		return
+	}
+
+	if err := proj.SetLanguageRedirects(params.LanguageRedirects); err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if errs := proj.Validate(); errs != nil {
+		c.JSON(422, gin.H{
+			"error":  "invalid_params",
+			"errors": errs,
+		})
+		return
+	}
+
+	if err := saveAndUpdateMeta(c, proj); err != nil {
+		if err == project.ErrStaleProject {
+			respondConflict(c)
+			return
+		}
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	respondLanguageRedirects(c, proj)
+}
+
+func respondLanguageRedirects(c *gin.Context, proj *project.Project) {
+	redirects, err := proj.LanguageRedirectsList()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"language_redirects": redirects,
+		"lock_version":       proj.LockVersion,
+	})
+}
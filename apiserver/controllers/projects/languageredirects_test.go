@@ -0,0 +1,262 @@
+package projects_test
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/apiserver/server"
+	"github.com/nitrous-io/rise-server/pkg/mqconn"
+	"github.com/nitrous-io/rise-server/shared/queues"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+	"github.com/nitrous-io/rise-server/testhelper/sharedexamples"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Project language redirects", func() {
+	var (
+		db      *gorm.DB
+		s       *httptest.Server
+		res     *http.Response
+		headers http.Header
+		err     error
+
+		u    *user.User
+		t    *oauthtoken.OauthToken
+		proj *project.Project
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+
+		u, _, t = factories.AuthTrio(db)
+
+		headers = http.Header{
+			"Authorization": {"Bearer " + t.Token},
+		}
+
+		proj = factories.Project(db, u, "panda-express")
+	})
+
+	AfterEach(func() {
+		if res != nil {
+			res.Body.Close()
+		}
+		s.Close()
+	})
+
+	Describe("GET /projects/:project_name/language_redirects", func() {
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("GET", s.URL+"/projects/panda-express/language_redirects", nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("returns 200 OK with the language redirects", func() {
+			Expect(proj.SetLanguageRedirects([]project.LanguageRedirect{
+				{Path: "/", Languages: []string{"de"}, To: "/de/"},
+			})).To(Succeed())
+			Expect(db.Save(proj).Error).To(BeNil())
+
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(MatchJSON(`{
+				"language_redirects": [
+					{"path": "/", "languages": ["de"], "to": "/de/"}
+				],
+				"lock_version": 0
+			}`))
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+
+	Describe("PUT /projects/:project_name/language_redirects", func() {
+		var (
+			mq   mqconn.Conn
+			body string
+			path string
+		)
+
+		BeforeEach(func() {
+			mq, err = mqconn.MQ()
+			Expect(err).To(BeNil())
+
+			testhelper.DeleteQueue(mq, queues.All...)
+
+			path = "/projects/panda-express/language_redirects"
+			body = `{
+				"language_redirects": [
+					{"path": "/", "languages": ["DE", "de-AT"], "to": "/de/"},
+					{"path": "/", "languages": ["fr"], "to": "https://fr.panda-express.com/"}
+				]
+			}`
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+
+			req, err := http.NewRequest("PUT", s.URL+path, bytes.NewBufferString(body))
+			Expect(err).To(BeNil())
+			req.Header.Add("Content-Type", "application/json")
+
+			for k, v := range headers {
+				for _, h := range v {
+					req.Header.Add(k, h)
+				}
+			}
+
+			res, err = http.DefaultClient.Do(req)
+			Expect(err).To(BeNil())
+		}
+
+		It("returns 200 OK and replaces the language redirects", func() {
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(MatchJSON(`{
+				"language_redirects": [
+					{"path": "/", "languages": ["de", "de-at"], "to": "/de/"},
+					{"path": "/", "languages": ["fr"], "to": "https://fr.panda-express.com/"}
+				],
+				"lock_version": 1
+			}`))
+
+			Expect(db.First(proj, proj.ID).Error).To(BeNil())
+			redirects, err := proj.LanguageRedirectsList()
+			Expect(err).To(BeNil())
+			Expect(redirects).To(HaveLen(2))
+			Expect(redirects[0].Languages).To(Equal([]string{"de", "de-at"}))
+		})
+
+		Context("when there is an active deployment", func() {
+			BeforeEach(func() {
+				depl := factories.Deployment(db, proj, u, deployment.StateDeployed)
+				Expect(db.Model(proj).Update("active_deployment_id", depl.ID).Error).To(BeNil())
+			})
+
+			It("enqueues a deploy job to update meta.json", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				d := testhelper.ConsumeQueue(mq, queues.Deploy)
+				Expect(d).NotTo(BeNil())
+				Expect(d.Body).To(MatchJSON(fmt.Sprintf(`{
+					"deployment_id": %d,
+					"skip_webroot_upload": true,
+					"skip_invalidation": false,
+					"use_raw_bundle": false
+				}`, *proj.ActiveDeploymentID)))
+			})
+		})
+
+		Context("when language_redirects is missing", func() {
+			BeforeEach(func() {
+				body = `{}`
+			})
+
+			It("returns 400", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusBadRequest))
+			})
+		})
+
+		Context("when an invalid language is given", func() {
+			BeforeEach(func() {
+				body = `{"language_redirects": [{"path": "/", "languages": ["german"], "to": "/de/"}]}`
+			})
+
+			It("returns 422 and does not update the project", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"errors": {
+						"language_redirects": "\"german\" is not a valid language"
+					}
+				}`))
+
+				Expect(db.First(proj, proj.ID).Error).To(BeNil())
+				Expect(proj.LockVersion).To(Equal(int64(0)))
+			})
+		})
+
+		Context("when lock_version does not match the current lock version", func() {
+			BeforeEach(func() {
+				path += "?lock_version=1"
+			})
+
+			It("returns 409 conflict and does not update the project", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusConflict))
+
+				Expect(db.First(proj, proj.ID).Error).To(BeNil())
+				redirects, err := proj.LanguageRedirectsList()
+				Expect(err).To(BeNil())
+				Expect(redirects).To(BeEmpty())
+			})
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItLocksProject(func() (*gorm.DB, *project.Project) {
+			return db, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+})
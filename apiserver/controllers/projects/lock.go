@@ -0,0 +1,65 @@
+package projects
+
+import (
+	"net/http"
+	"strconv"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+)
+
+// ShowLock shows whether a project is locked, and if so, by whom and until
+// when.
+func ShowLock(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	c.JSON(http.StatusOK, gin.H{
+		"lock": proj.LockInfo(),
+	})
+}
+
+// ForceUnlock releases the lock of a project regardless of who holds it. It
+// allows owners to recover a project that was left locked by a request or a
+// job that did not finish.
+func ForceUnlock(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+	lockInfo := proj.LockInfo()
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	unlocked, err := proj.ForceUnlock(db)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if unlocked {
+		u := controllers.CurrentUser(c)
+
+		var (
+			event = "Force Unlocked Project"
+			props = map[string]interface{}{
+				"projectName": proj.Name,
+			}
+			context = controllers.TrackingContext(c)
+		)
+		if lockInfo.LockedBy != nil {
+			props["lockedBy"] = *lockInfo.LockedBy
+		}
+		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
+			log.Errorf("failed to track %q event for user ID %d, err: %v",
+				event, u.ID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"unlocked": unlocked,
+	})
+}
@@ -0,0 +1,242 @@
+package projects_test
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/apiserver/server"
+	"github.com/nitrous-io/rise-server/pkg/tracker"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+	"github.com/nitrous-io/rise-server/testhelper/fake"
+	"github.com/nitrous-io/rise-server/testhelper/sharedexamples"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Project lock", func() {
+	var (
+		db      *gorm.DB
+		s       *httptest.Server
+		res     *http.Response
+		headers http.Header
+		err     error
+
+		u    *user.User
+		t    *oauthtoken.OauthToken
+		proj *project.Project
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+
+		u, _, t = factories.AuthTrio(db)
+
+		headers = http.Header{
+			"Authorization": {"Bearer " + t.Token},
+		}
+
+		proj = factories.Project(db, u, "panda-express")
+	})
+
+	AfterEach(func() {
+		if res != nil {
+			res.Body.Close()
+		}
+		s.Close()
+	})
+
+	Describe("GET /projects/:project_name/lock", func() {
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("GET", s.URL+"/projects/panda-express/lock", nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		Context("when the project is not locked", func() {
+			It("returns 200 OK with locked set to false", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(b.String()).To(MatchJSON(`{
+					"lock": {
+						"locked": false
+					}
+				}`))
+			})
+		})
+
+		Context("when the project is locked", func() {
+			BeforeEach(func() {
+				acquired, err := proj.LockFor(db, "builder (deployment 1)", time.Minute)
+				Expect(err).To(BeNil())
+				Expect(acquired).To(BeTrue())
+			})
+
+			It("returns 200 OK with the holder of the lock", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(db.First(proj, proj.ID).Error).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"lock": {
+						"locked": true,
+						"locked_by": "builder (deployment 1)",
+						"locked_at": "%s",
+						"expires_at": "%s"
+					}
+				}`, proj.LockedAt.Format(time.RFC3339Nano), proj.LockExpiresAt.Format(time.RFC3339Nano))))
+			})
+		})
+
+		Context("when the lock of the project has expired", func() {
+			BeforeEach(func() {
+				Expect(db.Exec("UPDATE projects SET locked_at = now() - interval '1 hour', lock_expires_at = now() - interval '1 minute' WHERE id = ?", proj.ID).Error).To(BeNil())
+			})
+
+			It("returns 200 OK with locked set to false", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(b.String()).To(MatchJSON(`{
+					"lock": {
+						"locked": false
+					}
+				}`))
+			})
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+
+	Describe("DELETE /projects/:project_name/lock", func() {
+		var (
+			fakeTracker *fake.Tracker
+			origTracker tracker.Trackable
+		)
+
+		BeforeEach(func() {
+			origTracker = common.Tracker
+			fakeTracker = &fake.Tracker{}
+			common.Tracker = fakeTracker
+		})
+
+		AfterEach(func() {
+			common.Tracker = origTracker
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("DELETE", s.URL+"/projects/panda-express/lock", nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		Context("when the project is locked", func() {
+			BeforeEach(func() {
+				acquired, err := proj.LockFor(db, "builder (deployment 1)", time.Hour)
+				Expect(err).To(BeNil())
+				Expect(acquired).To(BeTrue())
+			})
+
+			It("returns 200 OK and unlocks the project", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(b.String()).To(MatchJSON(`{
+					"unlocked": true
+				}`))
+
+				Expect(db.First(proj, proj.ID).Error).To(BeNil())
+				Expect(proj.LockedAt).To(BeNil())
+				Expect(proj.LockedBy).To(BeNil())
+				Expect(proj.LockExpiresAt).To(BeNil())
+			})
+
+			It("tracks a 'Force Unlocked Project' event", func() {
+				doRequest()
+
+				trackCall := fakeTracker.TrackCalls.NthCall(1)
+				Expect(trackCall).NotTo(BeNil())
+				Expect(trackCall.Arguments[0]).To(Equal(fmt.Sprintf("%d", u.ID)))
+				Expect(trackCall.Arguments[1]).To(Equal("Force Unlocked Project"))
+
+				props, ok := trackCall.Arguments[3].(map[string]interface{})
+				Expect(ok).To(BeTrue())
+				Expect(props["projectName"]).To(Equal("panda-express"))
+				Expect(props["lockedBy"]).To(Equal("builder (deployment 1)"))
+			})
+		})
+
+		Context("when the project is not locked", func() {
+			It("returns 200 OK with unlocked set to false", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(b.String()).To(MatchJSON(`{
+					"unlocked": false
+				}`))
+
+				Expect(fakeTracker.TrackCalls.Count()).To(Equal(0))
+			})
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProject(func() (*gorm.DB, *project.Project) {
+			return db, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+})
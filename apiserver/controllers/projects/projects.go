@@ -1,23 +1,29 @@
 package projects
 
 import (
+	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
 	"github.com/lib/pq"
 	"github.com/nitrous-io/rise-server/apiserver/common"
 	"github.com/nitrous-io/rise-server/apiserver/controllers"
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/acmecert"
 	"github.com/nitrous-io/rise-server/apiserver/models/blacklistedname"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/outboxjob"
 	"github.com/nitrous-io/rise-server/apiserver/models/project"
 	"github.com/nitrous-io/rise-server/apiserver/models/rawbundle"
+	"github.com/nitrous-io/rise-server/apiserver/models/releasedname"
 	"github.com/nitrous-io/rise-server/pkg/job"
-	"github.com/nitrous-io/rise-server/pkg/pubsub"
 	"github.com/nitrous-io/rise-server/shared"
-	"github.com/nitrous-io/rise-server/shared/exchanges"
+	"github.com/nitrous-io/rise-server/shared/errcodes"
+	"github.com/nitrous-io/rise-server/shared/invalidation"
 	"github.com/nitrous-io/rise-server/shared/messages"
 	"github.com/nitrous-io/rise-server/shared/queues"
 	"github.com/nitrous-io/rise-server/shared/s3client"
@@ -57,10 +63,7 @@ func Create(c *gin.Context) {
 			var (
 				event   = "Used Blacklisted Project Name"
 				props   = map[string]interface{}{"projectName": proj.Name}
-				context = map[string]interface{}{
-					"ip":         common.GetIP(c.Request),
-					"user_agent": c.Request.UserAgent(),
-				}
+				context = controllers.TrackingContext(c)
 			)
 			if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
 				log.Errorf("failed to track %q event for user ID %d, err: %v",
@@ -70,6 +73,25 @@ func Create(c *gin.Context) {
 
 		c.JSON(422, gin.H{
 			"error": "invalid_params",
+			"code":  errcodes.NameBlacklisted,
+			"errors": map[string]interface{}{
+				"name": "is taken",
+			},
+		})
+		return
+	}
+
+	// The names of deleted projects are held for their owners for a while.
+	held, err := releasedname.IsHeld(db, proj.Name, u.ID)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if held {
+		c.JSON(422, gin.H{
+			"error": "invalid_params",
+			"code":  errcodes.NameTaken,
 			"errors": map[string]interface{}{
 				"name": "is taken",
 			},
@@ -86,6 +108,7 @@ func Create(c *gin.Context) {
 	if !canCreate {
 		c.JSON(http.StatusForbidden, gin.H{
 			"error":             "invalid_request",
+			"code":              errcodes.QuotaExceeded,
 			"error_description": "maximum number of projects reached",
 		})
 		return
@@ -95,6 +118,7 @@ func Create(c *gin.Context) {
 		if e, ok := err.(*pq.Error); ok && e.Code.Name() == "unique_violation" {
 			c.JSON(422, gin.H{
 				"error": "invalid_params",
+				"code":  errcodes.NameTaken,
 				"errors": map[string]interface{}{
 					"name": "is taken",
 				},
@@ -116,10 +140,7 @@ func Create(c *gin.Context) {
 		var (
 			event   = "Created Project"
 			props   = map[string]interface{}{"projectName": proj.Name}
-			context = map[string]interface{}{
-				"ip":         common.GetIP(c.Request),
-				"user_agent": c.Request.UserAgent(),
-			}
+			context = controllers.TrackingContext(c)
 		)
 		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
 			log.Errorf("failed to track %q event for user ID %d, err: %v",
@@ -132,6 +153,84 @@ func Create(c *gin.Context) {
 	})
 }
 
+// Availability returns whether a project could be created with the given
+// name, so that clients can validate names before attempting creation. If the
+// name is not available, the reason is one of "invalid", "blacklisted",
+// "taken" or "held", which is returned for names of recently deleted projects
+// that only their former owners can use until the cooldown ends.
+func Availability(c *gin.Context) {
+	name := strings.ToLower(c.Query("name"))
+	proj := &project.Project{Name: name}
+
+	if errs := proj.Validate(); errs != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"name":      name,
+			"available": false,
+			"reason":    "invalid",
+			"errors":    errs,
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	blacklisted, err := blacklistedname.IsBlacklisted(db, name)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if blacklisted {
+		c.JSON(http.StatusOK, gin.H{
+			"name":      name,
+			"available": false,
+			"reason":    "blacklisted",
+		})
+		return
+	}
+
+	existing, err := project.FindByName(db, name)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if existing != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"name":      name,
+			"available": false,
+			"reason":    "taken",
+		})
+		return
+	}
+
+	// Availability does not require authentication, so names are reported
+	// as held even to their former owners.
+	held, err := releasedname.IsHeld(db, name, 0)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if held {
+		c.JSON(http.StatusOK, gin.H{
+			"name":      name,
+			"available": false,
+			"reason":    "held",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"name":      name,
+		"available": true,
+	})
+}
+
 func Get(c *gin.Context) {
 	proj := controllers.CurrentProject(c)
 
@@ -149,7 +248,7 @@ func Index(c *gin.Context) {
 		return
 	}
 
-	projects, err := project.ProjectsByUserID(db, u.ID)
+	projects, sharedProjects, err := project.AccessibleProjectsByUserID(db, u.ID)
 	if err != nil {
 		controllers.InternalServerError(c, err)
 		return
@@ -160,12 +259,6 @@ func Index(c *gin.Context) {
 		projectsAsJson = append(projectsAsJson, proj.AsJSON())
 	}
 
-	sharedProjects, err := project.SharedProjectsByUserID(db, u.ID)
-	if err != nil {
-		controllers.InternalServerError(c, err)
-		return
-	}
-
 	sharedProjectsAsJson := []interface{}{}
 	for _, proj := range sharedProjects {
 		sharedProjectsAsJson = append(sharedProjectsAsJson, proj.AsJSON())
@@ -180,10 +273,22 @@ func Index(c *gin.Context) {
 func Update(c *gin.Context) {
 	proj := controllers.CurrentProject(c)
 
+	if !checkLockVersion(c, proj) {
+		return
+	}
+
 	// Make a copy of the original project.
 	updatedProj := *proj
 	projChanged := false
 
+	var (
+		// Jobs to be enqueued once the changes have been saved.
+		jobs []*job.Job
+
+		removeDefaultDomain bool
+		deleteOldDeploys    bool
+	)
+
 	if c.PostForm("default_domain_enabled") != "" {
 		defaultDomainEnabled, _ := strconv.ParseBool(c.PostForm("default_domain_enabled"))
 		updatedProj.DefaultDomainEnabled = defaultDomainEnabled
@@ -205,35 +310,15 @@ func Update(c *gin.Context) {
 						controllers.InternalServerError(c, err)
 						return
 					}
-
-					if err := j.Enqueue(); err != nil {
-						controllers.InternalServerError(c, err)
-						return
-					}
-				} else {
-					// If default domain was just disabled, we need to remove it so that it no longer works.
-					defaultDomain := proj.Name + "." + shared.DefaultDomain
-
-					if err := s3client.Delete("/domains/" + defaultDomain + "/meta.json"); err != nil {
-						controllers.InternalServerError(c, err)
-						return
-					}
-
-					m, err := pubsub.NewMessageWithJSON(exchanges.Edges, exchanges.RouteV1Invalidation, &messages.V1InvalidationMessageData{
-						Domains: []string{defaultDomain},
-					})
-					if err != nil {
-						controllers.InternalServerError(c, err)
-						return
-					}
-
-					if err := m.Publish(); err != nil {
-						controllers.InternalServerError(c, err)
-						return
-					}
+					jobs = append(jobs, j)
 				}
 			}
 		}
+
+		// If default domain is disabled, we need to remove it so that it no
+		// longer works. This is done even if it was already disabled, so that
+		// a removal that failed after the change was saved can be retried.
+		removeDefaultDomain = !defaultDomainEnabled && proj.ActiveDeploymentID != nil
 	}
 
 	if c.PostForm("force_https") != "" {
@@ -256,15 +341,128 @@ func Update(c *gin.Context) {
 					controllers.InternalServerError(c, err)
 					return
 				}
+				jobs = append(jobs, j)
+			}
+		}
+	}
+
+	if c.PostForm("noindex_default_domain") != "" {
+		noindex, _ := strconv.ParseBool(c.PostForm("noindex_default_domain"))
+		updatedProj.NoindexDefaultDomain = noindex
+
+		// if noindex_default_domain changed
+		if proj.NoindexDefaultDomain != updatedProj.NoindexDefaultDomain {
+			projChanged = true
 
-				if err := j.Enqueue(); err != nil {
+			// if there is an active deployment and the default domain is served
+			if proj.ActiveDeploymentID != nil && proj.DefaultDomainEnabled {
+				// enqueue a deployment job with invalidation to update meta.json
+				j, err := job.NewWithJSON(queues.Deploy, &messages.DeployJobData{
+					DeploymentID:      *proj.ActiveDeploymentID,
+					SkipWebrootUpload: true,
+					SkipInvalidation:  false,
+				})
+				if err != nil {
 					controllers.InternalServerError(c, err)
 					return
 				}
+				jobs = append(jobs, j)
 			}
 		}
 	}
 
+	updateMeta := false
+
+	if indexDocument := strings.TrimSpace(c.PostForm("index_document")); indexDocument != "" {
+		updatedProj.IndexDocument = indexDocument
+		if errs := updatedProj.Validate(); errs != nil && errs["index_document"] != "" {
+			c.JSON(422, gin.H{
+				"error": "invalid_params",
+				"errors": map[string]string{
+					"index_document": errs["index_document"],
+				},
+			})
+			return
+		}
+
+		if proj.IndexDocument != updatedProj.IndexDocument {
+			projChanged = true
+			updateMeta = true
+		}
+	}
+
+	if c.PostForm("directory_listings") != "" {
+		directoryListings, _ := strconv.ParseBool(c.PostForm("directory_listings"))
+		updatedProj.DirectoryListings = directoryListings
+
+		if proj.DirectoryListings != updatedProj.DirectoryListings {
+			projChanged = true
+			updateMeta = true
+		}
+	}
+
+	// The mode and page are published with the quotas of each domain, so
+	// meta.json is updated when either changes. Domains that are already over
+	// their bandwidth quota today are degraded or blocked right away.
+	if mode := c.PostForm("bandwidth_exceeded_mode"); mode != "" {
+		updatedProj.BandwidthExceededMode = mode
+		if errs := updatedProj.Validate(); errs != nil && errs["bandwidth_exceeded_mode"] != "" {
+			c.JSON(422, gin.H{
+				"error": "invalid_params",
+				"errors": map[string]string{
+					"bandwidth_exceeded_mode": errs["bandwidth_exceeded_mode"],
+				},
+			})
+			return
+		}
+
+		if proj.BandwidthExceededMode != updatedProj.BandwidthExceededMode {
+			projChanged = true
+			updateMeta = true
+		}
+	}
+
+	// An empty page removes it, so that the edge's default page is served.
+	if page, ok := c.GetPostForm("bandwidth_exceeded_page"); ok {
+		updatedProj.BandwidthExceededPage = nil
+		if page = strings.TrimSpace(page); page != "" {
+			updatedProj.BandwidthExceededPage = &page
+		}
+		if errs := updatedProj.Validate(); errs != nil && errs["bandwidth_exceeded_page"] != "" {
+			c.JSON(422, gin.H{
+				"error": "invalid_params",
+				"errors": map[string]string{
+					"bandwidth_exceeded_page": errs["bandwidth_exceeded_page"],
+				},
+			})
+			return
+		}
+
+		oldPage, newPage := "", ""
+		if proj.BandwidthExceededPage != nil {
+			oldPage = *proj.BandwidthExceededPage
+		}
+		if updatedProj.BandwidthExceededPage != nil {
+			newPage = *updatedProj.BandwidthExceededPage
+		}
+		if oldPage != newPage {
+			projChanged = true
+			updateMeta = true
+		}
+	}
+
+	// Only meta.json is updated. Directory listing pages are generated when a
+	// deployment is deployed, so they are only served for deployments made
+	// after listings are enabled.
+	if updateMeta && proj.ActiveDeploymentID != nil {
+		j, err := invalidationJob(proj)
+		if err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+		jobs = append(jobs, j)
+	}
+
 	if c.PostForm("skip_build") != "" {
 		skipBuild, _ := strconv.ParseBool(c.PostForm("skip_build"))
 		updatedProj.SkipBuild = skipBuild
@@ -273,6 +471,66 @@ func Update(c *gin.Context) {
 		}
 	}
 
+	// Assets are fingerprinted when deployments are built, so it only takes
+	// effect for deployments made after it is enabled.
+	if c.PostForm("asset_fingerprinting") != "" {
+		assetFingerprinting, _ := strconv.ParseBool(c.PostForm("asset_fingerprinting"))
+		updatedProj.AssetFingerprinting = assetFingerprinting
+		if proj.AssetFingerprinting != updatedProj.AssetFingerprinting {
+			projChanged = true
+		}
+	}
+
+	if concurrency := c.PostForm("deploy_concurrency"); concurrency != "" {
+		updatedProj.DeployConcurrency = concurrency
+		if errs := updatedProj.Validate(); errs != nil && errs["deploy_concurrency"] != "" {
+			c.JSON(422, gin.H{
+				"error": "invalid_params",
+				"errors": map[string]string{
+					"deploy_concurrency": errs["deploy_concurrency"],
+				},
+			})
+			return
+		}
+
+		if proj.DeployConcurrency != updatedProj.DeployConcurrency {
+			projChanged = true
+		}
+	}
+
+	if c.PostForm("max_deploys_kept") != "" {
+		// Keeping fewer deployments deletes older ones, so only owners can
+		// change it.
+		if u := controllers.CurrentUser(c); u.ID != proj.UserID {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":             "forbidden",
+				"error_description": "only the owner of the project can change max_deploys_kept",
+			})
+			return
+		}
+
+		n, err := strconv.ParseUint(c.PostForm("max_deploys_kept"), 10, 32)
+		updatedProj.MaxDeploysKept = uint(n)
+		if errs := updatedProj.Validate(); err != nil || n == 0 || (errs != nil && errs["max_deploys_kept"] != "") {
+			c.JSON(422, gin.H{
+				"error": "invalid_params",
+				"errors": map[string]string{
+					"max_deploys_kept": fmt.Sprintf("must be between 1 and %d", project.MaxDeploysKeptLimit),
+				},
+			})
+			return
+		}
+
+		if proj.MaxDeploysKept != updatedProj.MaxDeploysKept {
+			projChanged = true
+
+			// Deployments beyond the new limit are deleted now rather than on
+			// the next deploy, and their files are purged by the
+			// purge-deleted-deploys task.
+			deleteOldDeploys = proj.MaxDeploysKept == 0 || updatedProj.MaxDeploysKept < proj.MaxDeploysKept
+		}
+	}
+
 	if projChanged {
 		db, err := dbconn.DB()
 		if err != nil {
@@ -280,11 +538,42 @@ func Update(c *gin.Context) {
 			return
 		}
 
-		if err := db.Save(&updatedProj).Error; err != nil {
+		lockVersion := updatedProj.LockVersion
+
+		var obs []*outboxjob.OutboxJob
+		if err := dbconn.Transaction(controllers.Context(c), func(tx *gorm.DB) error {
+			// A failed commit leaves the lock version incremented.
+			updatedProj.LockVersion = lockVersion
+			if err := updatedProj.SaveWithLock(tx); err != nil {
+				return err
+			}
+
+			if deleteOldDeploys {
+				if err := deployment.DeleteExceptLastN(tx, proj.ID, updatedProj.MaxDeploysKept); err != nil {
+					return err
+				}
+			}
+
+			obs = nil
+			for _, j := range jobs {
+				ob, err := outboxjob.Add(tx, j)
+				if err != nil {
+					return err
+				}
+				obs = append(obs, ob)
+			}
+			return nil
+		}); err != nil {
+			if err == project.ErrStaleProject {
+				respondConflict(c)
+				return
+			}
 			controllers.InternalServerError(c, err)
 			return
 		}
 
+		outboxjob.DeliverAll(db, obs...)
+
 		{
 			u := controllers.CurrentUser(c)
 
@@ -292,10 +581,7 @@ func Update(c *gin.Context) {
 				var (
 					event   = "Disabled Default Domain"
 					props   = map[string]interface{}{"projectName": proj.Name}
-					context = map[string]interface{}{
-						"ip":         common.GetIP(c.Request),
-						"user_agent": c.Request.UserAgent(),
-					}
+					context = controllers.TrackingContext(c)
 				)
 				if updatedProj.DefaultDomainEnabled {
 					event = "Enabled Default Domain"
@@ -310,10 +596,7 @@ func Update(c *gin.Context) {
 				var (
 					event   = "Disabled Force HTTPS"
 					props   = map[string]interface{}{"projectName": proj.Name}
-					context = map[string]interface{}{
-						"ip":         common.GetIP(c.Request),
-						"user_agent": c.Request.UserAgent(),
-					}
+					context = controllers.TrackingContext(c)
 				)
 				if updatedProj.ForceHTTPS {
 					event = "Enabled Force HTTPS"
@@ -323,6 +606,37 @@ func Update(c *gin.Context) {
 						event, u.ID, err)
 				}
 			}
+
+			if proj.NoindexDefaultDomain != updatedProj.NoindexDefaultDomain {
+				var (
+					event   = "Disabled Noindex Default Domain"
+					props   = map[string]interface{}{"projectName": proj.Name}
+					context = controllers.TrackingContext(c)
+				)
+				if updatedProj.NoindexDefaultDomain {
+					event = "Enabled Noindex Default Domain"
+				}
+				if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
+					log.Errorf("failed to track %q event for user ID %d, err: %v",
+						event, u.ID, err)
+				}
+			}
+		}
+	}
+
+	// The default domain is removed once the change has been saved, since
+	// removing it cannot be rolled back.
+	if removeDefaultDomain {
+		defaultDomain := proj.Name + "." + shared.DefaultDomain
+
+		if err := s3client.Delete("/domains/" + defaultDomain + "/meta.json"); err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+
+		if err := invalidation.Invalidate([]string{defaultDomain}); err != nil {
+			controllers.InternalServerError(c, err)
+			return
 		}
 	}
 
@@ -353,6 +667,14 @@ func Destroy(c *gin.Context) {
 		return
 	}
 
+	// Pending domains may have certs even though they are not served yet.
+	pendingDomainNames, err := proj.PendingDomainNames(db)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+	domainNames = append(domainNames, pendingDomainNames...)
+
 	var rawBundles []*rawbundle.RawBundle
 	if err := db.Where("project_id = ?", proj.ID).Find(&rawBundles).Error; err != nil {
 		controllers.InternalServerError(c, err)
@@ -366,6 +688,9 @@ func Destroy(c *gin.Context) {
 		if domainName != proj.DefaultDomainName() {
 			filesToDelete = append(filesToDelete, "certs/"+domainName+"/ssl.crt")
 			filesToDelete = append(filesToDelete, "certs/"+domainName+"/ssl.key")
+
+			challengeCertPath, challengeKeyPath := acmecert.TLSALPNChallengeCertPaths(domainName)
+			filesToDelete = append(filesToDelete, challengeCertPath, challengeKeyPath)
 		}
 	}
 
@@ -378,20 +703,17 @@ func Destroy(c *gin.Context) {
 		return
 	}
 
-	m, err := pubsub.NewMessageWithJSON(exchanges.Edges, exchanges.RouteV1Invalidation, &messages.V1InvalidationMessageData{
-		Domains: domainNames,
-	})
-	if err != nil {
+	if err := invalidation.Invalidate(domainNames); err != nil {
 		controllers.InternalServerError(c, err)
 		return
 	}
 
-	if err := m.Publish(); err != nil {
+	if err := proj.Destroy(tx); err != nil {
 		controllers.InternalServerError(c, err)
 		return
 	}
 
-	if err := proj.Destroy(tx); err != nil {
+	if err := releasedname.Release(tx, proj.Name, proj.UserID); err != nil {
 		controllers.InternalServerError(c, err)
 		return
 	}
@@ -407,10 +729,7 @@ func Destroy(c *gin.Context) {
 		var (
 			event   = "Deleted Project"
 			props   = map[string]interface{}{"projectName": proj.Name}
-			context = map[string]interface{}{
-				"ip":         common.GetIP(c.Request),
-				"user_agent": c.Request.UserAgent(),
-			}
+			context = controllers.TrackingContext(c)
 		)
 		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
 			log.Errorf("failed to track %q event for user ID %d, err: %v",
@@ -426,11 +745,23 @@ func Destroy(c *gin.Context) {
 func CreateAuth(c *gin.Context) {
 	proj := controllers.CurrentProject(c)
 
+	if !checkLockVersion(c, proj) {
+		return
+	}
+
 	username := c.PostForm("basic_auth_username")
 	password := c.PostForm("basic_auth_password")
 
 	proj.BasicAuthUsername = &username
 	proj.BasicAuthPassword = password
+	proj.BasicAuthRealm = nil
+	if realm := strings.TrimSpace(c.PostForm("basic_auth_realm")); realm != "" {
+		proj.BasicAuthRealm = &realm
+	}
+	proj.BasicAuthPage = nil
+	if page := strings.TrimSpace(c.PostForm("basic_auth_page")); page != "" {
+		proj.BasicAuthPage = &page
+	}
 	if errs := proj.Validate(); errs != nil {
 		c.JSON(422, gin.H{
 			"error":  "invalid_params",
@@ -444,20 +775,11 @@ func CreateAuth(c *gin.Context) {
 		return
 	}
 
-	if proj.ActiveDeploymentID != nil {
-		if err := publishInvalidationJob(proj); err != nil {
-			controllers.InternalServerError(c, err)
+	if err := saveAndUpdateMeta(c, proj); err != nil {
+		if err == project.ErrStaleProject {
+			respondConflict(c)
 			return
 		}
-	}
-
-	db, err := dbconn.DB()
-	if err != nil {
-		controllers.InternalServerError(c, err)
-		return
-	}
-
-	if err := db.Save(&proj).Error; err != nil {
 		controllers.InternalServerError(c, err)
 		return
 	}
@@ -469,22 +791,20 @@ func CreateAuth(c *gin.Context) {
 
 func DeleteAuth(c *gin.Context) {
 	proj := controllers.CurrentProject(c)
-	if proj.ActiveDeploymentID != nil {
-		if err := publishInvalidationJob(proj); err != nil {
-			controllers.InternalServerError(c, err)
-			return
-		}
-	}
 
-	db, err := dbconn.DB()
-	if err != nil {
-		controllers.InternalServerError(c, err)
+	if !checkLockVersion(c, proj) {
 		return
 	}
 
 	proj.BasicAuthUsername = nil
 	proj.EncryptedBasicAuthPassword = nil
-	if err := db.Save(&proj).Error; err != nil {
+	proj.BasicAuthRealm = nil
+	proj.BasicAuthPage = nil
+	if err := saveAndUpdateMeta(c, proj); err != nil {
+		if err == project.ErrStaleProject {
+			respondConflict(c)
+			return
+		}
 		controllers.InternalServerError(c, err)
 		return
 	}
@@ -494,16 +814,90 @@ func DeleteAuth(c *gin.Context) {
 	})
 }
 
-func publishInvalidationJob(proj *project.Project) error {
-	j, err := job.NewWithJSON(queues.Deploy, &messages.DeployJobData{
-		DeploymentID:      *proj.ActiveDeploymentID,
-		SkipWebrootUpload: true,
-		SkipInvalidation:  false,
-	})
-
+// saveAndUpdateMeta saves a project and, if the project has an active
+// deployment, enqueues a deploy job to update its meta.json.
+func saveAndUpdateMeta(c *gin.Context, proj *project.Project) error {
+	db, err := dbconn.DB()
 	if err != nil {
 		return err
 	}
 
-	return j.Enqueue()
+	lockVersion := proj.LockVersion
+
+	var ob *outboxjob.OutboxJob
+	if err := dbconn.Transaction(controllers.Context(c), func(tx *gorm.DB) error {
+		// A failed commit leaves the lock version incremented.
+		proj.LockVersion = lockVersion
+		if err := proj.SaveWithLock(tx); err != nil {
+			return err
+		}
+
+		ob = nil
+		if proj.ActiveDeploymentID != nil {
+			j, err := invalidationJob(proj)
+			if err != nil {
+				return err
+			}
+
+			ob, err = outboxjob.Add(tx, j)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		proj.LockVersion = lockVersion
+		return err
+	}
+
+	if ob != nil {
+		outboxjob.DeliverAll(db, ob)
+	}
+	return nil
+}
+
+// checkLockVersion responds with 409 Conflict and returns false if the
+// request has a lock_version that does not match the current lock version of
+// the project, i.e. the project has been modified since the client loaded it.
+func checkLockVersion(c *gin.Context, proj *project.Project) bool {
+	v := c.PostForm("lock_version")
+	if v == "" {
+		v = c.Query("lock_version")
+	}
+	if v == "" {
+		return true
+	}
+
+	lockVersion, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		c.JSON(422, gin.H{
+			"error": "invalid_params",
+			"errors": map[string]string{
+				"lock_version": "is invalid",
+			},
+		})
+		return false
+	}
+
+	if lockVersion != proj.LockVersion {
+		respondConflict(c)
+		return false
+	}
+	return true
+}
+
+func respondConflict(c *gin.Context) {
+	c.JSON(http.StatusConflict, gin.H{
+		"error":             "conflict",
+		"code":              errcodes.StaleLockVersion,
+		"error_description": "project has been modified by someone else, please reload it and try again",
+	})
+}
+
+func invalidationJob(proj *project.Project) (*job.Job, error) {
+	return job.NewWithJSON(queues.Deploy, &messages.DeployJobData{
+		DeploymentID:      *proj.ActiveDeploymentID,
+		SkipWebrootUpload: true,
+		SkipInvalidation:  false,
+	})
 }
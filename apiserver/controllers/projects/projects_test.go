@@ -14,12 +14,14 @@ import (
 	"github.com/jinzhu/gorm"
 	"github.com/nitrous-io/rise-server/apiserver/common"
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/blacklistedname"
 	"github.com/nitrous-io/rise-server/apiserver/models/cert"
 	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
 	"github.com/nitrous-io/rise-server/apiserver/models/domain"
 	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
 	"github.com/nitrous-io/rise-server/apiserver/models/project"
 	"github.com/nitrous-io/rise-server/apiserver/models/rawbundle"
+	"github.com/nitrous-io/rise-server/apiserver/models/releasedname"
 	"github.com/nitrous-io/rise-server/apiserver/models/user"
 	"github.com/nitrous-io/rise-server/apiserver/server"
 	"github.com/nitrous-io/rise-server/pkg/filetransfer"
@@ -33,7 +35,6 @@ import (
 	"github.com/nitrous-io/rise-server/testhelper/factories"
 	"github.com/nitrous-io/rise-server/testhelper/fake"
 	"github.com/nitrous-io/rise-server/testhelper/sharedexamples"
-	"github.com/streadway/amqp"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
@@ -164,6 +165,7 @@ var _ = Describe("Projects", func() {
 				Expect(res.StatusCode).To(Equal(422))
 				Expect(b.String()).To(MatchJSON(`{
 					"error": "invalid_params",
+					"code": "name_taken",
 					"errors": {
 						"name": "is taken"
 					}
@@ -171,6 +173,48 @@ var _ = Describe("Projects", func() {
 			})
 		})
 
+		Context("when the project name was released by another user recently", func() {
+			BeforeEach(func() {
+				Expect(releasedname.Release(db, "foo-bar-express", factories.User(db).ID)).To(Succeed())
+				doRequest()
+			})
+
+			It("returns 422 unprocessable entity", func() {
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"code": "name_taken",
+					"errors": {
+						"name": "is taken"
+					}
+				}`))
+
+				var count int
+				Expect(db.Model(project.Project{}).Count(&count).Error).To(BeNil())
+				Expect(count).To(Equal(0))
+			})
+		})
+
+		Context("when the project name was released by the current user recently", func() {
+			BeforeEach(func() {
+				Expect(releasedname.Release(db, "foo-bar-express", u.ID)).To(Succeed())
+				doRequest()
+			})
+
+			It("creates the project", func() {
+				Expect(res.StatusCode).To(Equal(http.StatusCreated))
+
+				proj, err := project.FindByName(db, "foo-bar-express")
+				Expect(err).To(BeNil())
+				Expect(proj).NotTo(BeNil())
+				Expect(proj.UserID).To(Equal(u.ID))
+			})
+		})
+
 		Context("when the project name is blacklisted", func() {
 			BeforeEach(func() {
 				factories.BlacklistedName(db, "foo-bar-express")
@@ -185,6 +229,7 @@ var _ = Describe("Projects", func() {
 				Expect(res.StatusCode).To(Equal(422))
 				Expect(b.String()).To(MatchJSON(`{
 					"error": "invalid_params",
+					"code": "name_blacklisted",
 					"errors": {
 						"name": "is taken"
 					}
@@ -213,6 +258,36 @@ var _ = Describe("Projects", func() {
 			})
 		})
 
+		Context("when the project name matches a blacklisted pattern", func() {
+			BeforeEach(func() {
+				factories.BlacklistedNameWithKind(db, blacklistedname.KindWildcard, "*-express")
+				doRequest()
+			})
+
+			AfterEach(func() {
+				blacklistedname.ClearCache()
+			})
+
+			It("returns 422 unprocessable entity", func() {
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"code": "name_blacklisted",
+					"errors": {
+						"name": "is taken"
+					}
+				}`))
+
+				var count int
+				Expect(db.Model(project.Project{}).Count(&count).Error).To(BeNil())
+				Expect(count).To(Equal(0))
+			})
+		})
+
 		Context("when the project name contains uppercase characters", func() {
 			BeforeEach(func() {
 				params.Set("name", "Foo-Bar-Express")
@@ -237,7 +312,15 @@ var _ = Describe("Projects", func() {
 						"name": "foo-bar-express",
 						"default_domain_enabled": true,
 						"force_https": false,
+						"noindex_default_domain": false,
+						"lock_version": 0,
 						"skip_build": false,
+						"security_headers_enabled": true,
+						"index_document": "index.html",
+						"directory_listings": false,
+						"asset_fingerprinting": false,
+						"deploy_concurrency": "queue",
+						"bandwidth_exceeded_mode": "block",
 						"created_at": %s
 					}
 				}`, createdAtJSON)))
@@ -268,6 +351,7 @@ var _ = Describe("Projects", func() {
 				Expect(res.StatusCode).To(Equal(http.StatusForbidden))
 				Expect(b.String()).To(MatchJSON(`{
 					"error": "invalid_request",
+					"code": "quota_exceeded",
 					"error_description": "maximum number of projects reached"
 				}`))
 			})
@@ -301,7 +385,15 @@ var _ = Describe("Projects", func() {
 						"name": "foo-bar-express",
 						"default_domain_enabled": true,
 						"force_https": false,
+						"noindex_default_domain": false,
+						"lock_version": 0,
 						"skip_build": false,
+						"security_headers_enabled": true,
+						"index_document": "index.html",
+						"directory_listings": false,
+						"asset_fingerprinting": false,
+						"deploy_concurrency": "queue",
+						"bandwidth_exceeded_mode": "block",
 						"created_at": %s
 					}
 				}`, createdAtJSON)))
@@ -328,17 +420,141 @@ var _ = Describe("Projects", func() {
 				Expect(ok).To(BeTrue())
 				Expect(context["ip"]).ToNot(BeNil())
 				Expect(context["user_agent"]).ToNot(BeNil())
+				Expect(context["request_id"]).To(Equal(res.Header.Get("X-Request-Id")))
+				Expect(context["request_id"]).NotTo(BeEmpty())
 
 				Expect(trackCall.ReturnValues[0]).To(BeNil())
 			})
 		})
 
+		Context("when the request is made by the command line client through a CDN", func() {
+			BeforeEach(func() {
+				headers.Set("User-Agent", "rise-cli/1.2.0 (darwin)")
+				headers.Set("X-Request-Id", "f00dcafe")
+				headers.Set("CloudFront-Viewer-Country", "SG")
+			})
+
+			It("tracks the event with the CLI version, request ID and location", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusCreated))
+				Expect(res.Header.Get("X-Request-Id")).To(Equal("f00dcafe"))
+
+				trackCall := fakeTracker.TrackCalls.NthCall(1)
+				Expect(trackCall).NotTo(BeNil())
+
+				context, ok := trackCall.Arguments[4].(map[string]interface{})
+				Expect(ok).To(BeTrue())
+				Expect(context["user_agent"]).To(Equal("rise-cli/1.2.0 (darwin)"))
+				Expect(context["cli_version"]).To(Equal("1.2.0"))
+				Expect(context["request_id"]).To(Equal("f00dcafe"))
+				Expect(context["location"]).To(Equal(map[string]interface{}{"country": "SG"}))
+			})
+		})
+
 		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
 			return db, u, &headers
 		}, func() *http.Response {
 			doRequest()
 			return res
 		}, nil)
+
+		sharedexamples.ItRequiresActiveUser(func() (*gorm.DB, *user.User) {
+			return db, u
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, func() {
+			var count int
+			Expect(db.Model(project.Project{}).Count(&count).Error).To(BeNil())
+			Expect(count).To(Equal(0))
+		})
+	})
+
+	Describe("GET /project_availability", func() {
+		doRequest := func(name string) {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("GET", s.URL+"/project_availability", url.Values{"name": {name}}, nil, nil)
+			Expect(err).To(BeNil())
+		}
+
+		readBody := func() string {
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+			return b.String()
+		}
+
+		AfterEach(func() {
+			blacklistedname.ClearCache()
+		})
+
+		It("returns available if the name can be used", func() {
+			doRequest("Foo-Bar-Express")
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(readBody()).To(MatchJSON(`{
+				"name": "foo-bar-express",
+				"available": true
+			}`))
+
+			var count int
+			Expect(db.Model(project.Project{}).Count(&count).Error).To(BeNil())
+			Expect(count).To(Equal(0))
+		})
+
+		It("returns the validation errors if the name is invalid", func() {
+			doRequest("foo_bar")
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(readBody()).To(MatchJSON(`{
+				"name": "foo_bar",
+				"available": false,
+				"reason": "invalid",
+				"errors": {
+					"name": "is invalid"
+				}
+			}`))
+		})
+
+		It("returns blacklisted if the name matches a blacklisted pattern", func() {
+			factories.BlacklistedNameWithKind(db, blacklistedname.KindWildcard, "*-express")
+
+			doRequest("foo-bar-express")
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(readBody()).To(MatchJSON(`{
+				"name": "foo-bar-express",
+				"available": false,
+				"reason": "blacklisted"
+			}`))
+		})
+
+		It("returns taken if a project already has the name", func() {
+			factories.Project(db, u, "foo-bar-express")
+
+			doRequest("foo-bar-express")
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(readBody()).To(MatchJSON(`{
+				"name": "foo-bar-express",
+				"available": false,
+				"reason": "taken"
+			}`))
+		})
+
+		It("returns held if the name of a deleted project is held", func() {
+			Expect(releasedname.Release(db, "foo-bar-express", u.ID)).To(Succeed())
+
+			doRequest("foo-bar-express")
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(readBody()).To(MatchJSON(`{
+				"name": "foo-bar-express",
+				"available": false,
+				"reason": "held"
+			}`))
+		})
 	})
 
 	Describe("GET /projects/:projectName", func() {
@@ -381,7 +597,15 @@ var _ = Describe("Projects", func() {
 					"name": "%s",
 					"default_domain_enabled": true,
 					"force_https": false,
+					"noindex_default_domain": false,
+					"lock_version": 0,
 					"skip_build": false,
+					"security_headers_enabled": true,
+					"index_document": "index.html",
+					"directory_listings": false,
+					"asset_fingerprinting": false,
+					"deploy_concurrency": "queue",
+					"bandwidth_exceeded_mode": "block",
 					"created_at": %s
 				}
 			}`, proj.Name, createdAtJSON)))
@@ -459,14 +683,30 @@ var _ = Describe("Projects", func() {
 						"name": "%s",
 						"default_domain_enabled": true,
 						"force_https": false,
+						"noindex_default_domain": false,
+						"lock_version": 0,
 						"skip_build": false,
+						"security_headers_enabled": true,
+						"index_document": "index.html",
+						"directory_listings": false,
+						"asset_fingerprinting": false,
+						"deploy_concurrency": "queue",
+						"bandwidth_exceeded_mode": "block",
 						"created_at": %s
 					},
 					{
 						"name": "%s",
 						"default_domain_enabled": true,
 						"force_https": false,
+						"noindex_default_domain": false,
+						"lock_version": 0,
 						"skip_build": false,
+						"security_headers_enabled": true,
+						"index_document": "index.html",
+						"directory_listings": false,
+						"asset_fingerprinting": false,
+						"deploy_concurrency": "queue",
+						"bandwidth_exceeded_mode": "block",
 						"created_at": %s
 					}
 				],
@@ -537,14 +777,30 @@ var _ = Describe("Projects", func() {
 							"name": "%s",
 							"default_domain_enabled": true,
 							"force_https": false,
+							"noindex_default_domain": false,
+							"lock_version": 0,
 							"skip_build": false,
+							"security_headers_enabled": true,
+							"index_document": "index.html",
+							"directory_listings": false,
+							"asset_fingerprinting": false,
+							"deploy_concurrency": "queue",
+							"bandwidth_exceeded_mode": "block",
 							"created_at": %s
 						},
 						{
 							"name": "%s",
 							"default_domain_enabled": true,
 							"force_https": false,
+							"noindex_default_domain": false,
+							"lock_version": 0,
 							"skip_build": false,
+							"security_headers_enabled": true,
+							"index_document": "index.html",
+							"directory_listings": false,
+							"asset_fingerprinting": false,
+							"deploy_concurrency": "queue",
+							"bandwidth_exceeded_mode": "block",
 							"created_at": %s
 						}
 					],
@@ -553,14 +809,30 @@ var _ = Describe("Projects", func() {
 							"name": "%s",
 							"default_domain_enabled": true,
 							"force_https": false,
+							"noindex_default_domain": false,
+							"lock_version": 0,
 							"skip_build": false,
+							"security_headers_enabled": true,
+							"index_document": "index.html",
+							"directory_listings": false,
+							"asset_fingerprinting": false,
+							"deploy_concurrency": "queue",
+							"bandwidth_exceeded_mode": "block",
 							"created_at": %s
 						},
 						{
 							"name": "%s",
 							"default_domain_enabled": true,
 							"force_https": false,
+							"noindex_default_domain": false,
+							"lock_version": 0,
 							"skip_build": false,
+							"security_headers_enabled": true,
+							"index_document": "index.html",
+							"directory_listings": false,
+							"asset_fingerprinting": false,
+							"deploy_concurrency": "queue",
+							"bandwidth_exceeded_mode": "block",
 							"created_at": %s
 						}
 					]
@@ -624,7 +896,15 @@ var _ = Describe("Projects", func() {
 							"name": "%s",
 							"default_domain_enabled": true,
 							"force_https": false,
+							"noindex_default_domain": false,
+							"lock_version": 0,
 							"skip_build": false,
+							"security_headers_enabled": true,
+							"index_document": "index.html",
+							"directory_listings": false,
+							"asset_fingerprinting": false,
+							"deploy_concurrency": "queue",
+							"bandwidth_exceeded_mode": "block",
 							"created_at": %s,
 							"deployed_at": %s
 						},
@@ -632,7 +912,15 @@ var _ = Describe("Projects", func() {
 							"name": "%s",
 							"default_domain_enabled": true,
 							"force_https": false,
+							"noindex_default_domain": false,
+							"lock_version": 0,
 							"skip_build": false,
+							"security_headers_enabled": true,
+							"index_document": "index.html",
+							"directory_listings": false,
+							"asset_fingerprinting": false,
+							"deploy_concurrency": "queue",
+							"bandwidth_exceeded_mode": "block",
 							"created_at": %s
 						}
 					],
@@ -641,7 +929,15 @@ var _ = Describe("Projects", func() {
 							"name": "%s",
 							"default_domain_enabled": true,
 							"force_https": false,
+							"noindex_default_domain": false,
+							"lock_version": 0,
 							"skip_build": false,
+							"security_headers_enabled": true,
+							"index_document": "index.html",
+							"directory_listings": false,
+							"asset_fingerprinting": false,
+							"deploy_concurrency": "queue",
+							"bandwidth_exceeded_mode": "block",
 							"created_at": %s,
 							"deployed_at": %s
 						}
@@ -665,7 +961,7 @@ var _ = Describe("Projects", func() {
 		var (
 			fakeS3                *fake.S3
 			origS3                filetransfer.FileTransfer
-			mq                    *amqp.Connection
+			mq                    mqconn.Conn
 			invalidationQueueName string
 
 			proj *project.Project
@@ -730,7 +1026,15 @@ var _ = Describe("Projects", func() {
 						"name": "%s",
 						"default_domain_enabled": false,
 						"force_https": false,
+						"noindex_default_domain": false,
+						"lock_version": 1,
 						"skip_build": false,
+						"security_headers_enabled": true,
+						"index_document": "index.html",
+						"directory_listings": false,
+						"asset_fingerprinting": false,
+						"deploy_concurrency": "queue",
+						"bandwidth_exceeded_mode": "block",
 						"created_at": "%s"
 					}
 				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
@@ -785,6 +1089,32 @@ var _ = Describe("Projects", func() {
 			})
 		})
 
+		Context("when default domain is disabled again (i.e. it was already disabled)", func() {
+			BeforeEach(func() {
+				proj.DefaultDomainEnabled = false
+				Expect(db.Save(proj).Error).To(BeNil())
+
+				depl := factories.Deployment(db, proj, u, deployment.StateDeployed)
+				err := db.Model(proj).Update("active_deployment_id", depl.ID).Error
+				Expect(err).To(BeNil())
+
+				params = url.Values{
+					"default_domain_enabled": {"false"},
+				}
+			})
+
+			It("deletes the meta.json for the default domain from S3 again, in case an earlier removal failed", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(fakeS3.DeleteCalls.Count()).To(Equal(1))
+
+				deleteCall := fakeS3.DeleteCalls.NthCall(1)
+				Expect(deleteCall).NotTo(BeNil())
+				Expect(deleteCall.Arguments[2]).To(Equal("/domains/" + proj.Name + "." + shared.DefaultDomain + "/meta.json"))
+			})
+		})
+
 		Context("when default domain is newly enabled (i.e. it was disabled)", func() {
 			BeforeEach(func() {
 				proj.DefaultDomainEnabled = false
@@ -812,7 +1142,15 @@ var _ = Describe("Projects", func() {
 						"name": "%s",
 						"default_domain_enabled": true,
 						"force_https": false,
+						"noindex_default_domain": false,
+						"lock_version": 1,
 						"skip_build": false,
+						"security_headers_enabled": true,
+						"index_document": "index.html",
+						"directory_listings": false,
+						"asset_fingerprinting": false,
+						"deploy_concurrency": "queue",
+						"bandwidth_exceeded_mode": "block",
 						"created_at": "%s"
 					}
 				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
@@ -878,7 +1216,15 @@ var _ = Describe("Projects", func() {
 						"name": "%s",
 						"default_domain_enabled": true,
 						"force_https": true,
+						"noindex_default_domain": false,
+						"lock_version": 1,
 						"skip_build": false,
+						"security_headers_enabled": true,
+						"index_document": "index.html",
+						"directory_listings": false,
+						"asset_fingerprinting": false,
+						"deploy_concurrency": "queue",
+						"bandwidth_exceeded_mode": "block",
 						"created_at": "%s"
 					}
 				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
@@ -935,7 +1281,15 @@ var _ = Describe("Projects", func() {
 						"name": "%s",
 						"default_domain_enabled": true,
 						"force_https": false,
+						"noindex_default_domain": false,
+						"lock_version": 1,
 						"skip_build": false,
+						"security_headers_enabled": true,
+						"index_document": "index.html",
+						"directory_listings": false,
+						"asset_fingerprinting": false,
+						"deploy_concurrency": "queue",
+						"bandwidth_exceeded_mode": "block",
 						"created_at": "%s"
 					}
 				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
@@ -965,12 +1319,10 @@ var _ = Describe("Projects", func() {
 			})
 		})
 
-		Context("when skip_build set to true", func() {
+		Context("when noindex_default_domain is newly enabled (i.e. it was disabled)", func() {
 			BeforeEach(func() {
-				proj.SkipBuild = false
-				Expect(db.Save(proj).Error).To(BeNil())
 				params = url.Values{
-					"skip_build": {"true"},
+					"noindex_default_domain": {"true"},
 				}
 			})
 
@@ -985,94 +1337,631 @@ var _ = Describe("Projects", func() {
 
 				err = db.First(proj, proj.ID).Error
 				Expect(err).To(BeNil())
-				Expect(proj.DefaultDomainEnabled).To(Equal(true))
+				Expect(proj.NoindexDefaultDomain).To(Equal(true))
 
 				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
 					"project":{
 						"name": "%s",
 						"default_domain_enabled": true,
 						"force_https": false,
-						"skip_build": true,
+						"noindex_default_domain": true,
+						"lock_version": 1,
+						"skip_build": false,
+						"security_headers_enabled": true,
+						"index_document": "index.html",
+						"directory_listings": false,
+						"asset_fingerprinting": false,
+						"deploy_concurrency": "queue",
+						"bandwidth_exceeded_mode": "block",
 						"created_at": "%s"
 					}
 				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
 			})
 
-		})
+			Context("when there is an active deployment", func() {
+				var depl *deployment.Deployment
 
-		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
-			return db, u, &headers
-		}, func() *http.Response {
-			doRequest()
-			return res
-		}, nil)
+				BeforeEach(func() {
+					depl = factories.Deployment(db, proj, u, deployment.StateDeployed)
+					err := db.Model(proj).Update("active_deployment_id", depl.ID).Error
+					Expect(err).To(BeNil())
+				})
 
-		sharedexamples.ItRequiresProject(func() (*gorm.DB, *project.Project) {
-			return db, proj
-		}, func() *http.Response {
-			doRequest()
-			return res
-		}, nil)
+				It("enqueues a deploy job to update meta.json", func() {
+					doRequest()
 
-		sharedexamples.ItLocksProject(func() (*gorm.DB, *project.Project) {
-			return db, proj
-		}, func() *http.Response {
-			doRequest()
-			return res
-		}, nil)
-	})
+					d := testhelper.ConsumeQueue(mq, queues.Deploy)
+					Expect(d).NotTo(BeNil())
+					Expect(d.Body).To(MatchJSON(fmt.Sprintf(`{
+						"deployment_id": %d,
+						"skip_webroot_upload": true,
+						"skip_invalidation": false,
+						"use_raw_bundle": false
+					}`, *proj.ActiveDeploymentID)))
+				})
 
-	Describe("DELETE /projects/:name", func() {
-		var (
-			fakeS3                *fake.S3
-			origS3                filetransfer.FileTransfer
-			mq                    *amqp.Connection
-			invalidationQueueName string
+				Context("when the default domain is disabled", func() {
+					BeforeEach(func() {
+						Expect(db.Model(proj).Update("default_domain_enabled", false).Error).To(BeNil())
+					})
 
-			proj *project.Project
-			dm1  *domain.Domain
-			dm2  *domain.Domain
+					It("does not enqueue any job", func() {
+						doRequest()
 
-			headers http.Header
-		)
+						d := testhelper.ConsumeQueue(mq, queues.Deploy)
+						Expect(d).To(BeNil())
+					})
+				})
+			})
+		})
 
-		BeforeEach(func() {
-			origS3 = s3client.S3
-			fakeS3 = &fake.S3{}
-			s3client.S3 = fakeS3
+		Context("when index_document and directory_listings are given", func() {
+			BeforeEach(func() {
+				params = url.Values{
+					"index_document":     {"default.htm"},
+					"directory_listings": {"true"},
+				}
+			})
 
-			mq, err = mqconn.MQ()
-			Expect(err).To(BeNil())
+			It("returns 200 OK", func() {
+				doRequest()
 
-			testhelper.DeleteQueue(mq, queues.All...)
-			testhelper.DeleteExchange(mq, exchanges.All...)
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
 
-			invalidationQueueName = testhelper.StartQueueWithExchange(mq, exchanges.Edges, exchanges.RouteV1Invalidation)
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
 
-			headers = http.Header{
-				"Authorization": {"Bearer " + t.Token},
-			}
+				err = db.First(proj, proj.ID).Error
+				Expect(err).To(BeNil())
+				Expect(proj.IndexDocument).To(Equal("default.htm"))
+				Expect(proj.DirectoryListings).To(Equal(true))
 
-			proj = factories.Project(db, u)
-			dm1 = factories.Domain(db, proj)
-			dm2 = factories.Domain(db, proj)
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"project":{
+						"name": "%s",
+						"default_domain_enabled": true,
+						"force_https": false,
+						"noindex_default_domain": false,
+						"lock_version": 1,
+						"skip_build": false,
+						"security_headers_enabled": true,
+						"index_document": "default.htm",
+						"directory_listings": true,
+						"asset_fingerprinting": false,
+						"deploy_concurrency": "queue",
+						"bandwidth_exceeded_mode": "block",
+						"created_at": "%s"
+					}
+				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
+			})
 
-			ct1 := &cert.Cert{
-				DomainID:        dm1.ID,
-				CertificatePath: "old/path",
-				PrivateKeyPath:  "old/path",
-			}
-			Expect(db.Create(ct1).Error).To(BeNil())
+			Context("when there is an active deployment", func() {
+				var depl *deployment.Deployment
 
-			ct2 := &cert.Cert{
-				DomainID:        dm2.ID,
-				CertificatePath: "old/path",
-				PrivateKeyPath:  "old/path",
-			}
-			Expect(db.Create(ct2).Error).To(BeNil())
-		})
+				BeforeEach(func() {
+					depl = factories.Deployment(db, proj, u, deployment.StateDeployed)
+					err := db.Model(proj).Update("active_deployment_id", depl.ID).Error
+					Expect(err).To(BeNil())
+				})
 
-		AfterEach(func() {
+				It("enqueues a deploy job to update meta.json", func() {
+					doRequest()
+
+					d := testhelper.ConsumeQueue(mq, queues.Deploy)
+					Expect(d).NotTo(BeNil())
+					Expect(d.Body).To(MatchJSON(fmt.Sprintf(`{
+						"deployment_id": %d,
+						"skip_webroot_upload": true,
+						"skip_invalidation": false,
+						"use_raw_bundle": false
+					}`, *proj.ActiveDeploymentID)))
+				})
+			})
+		})
+
+		Context("when index_document is invalid", func() {
+			BeforeEach(func() {
+				params = url.Values{
+					"index_document": {"docs/index.html"},
+				}
+			})
+
+			It("returns 422 and does not update the project", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"errors": {
+						"index_document": "is invalid"
+					}
+				}`))
+
+				err = db.First(proj, proj.ID).Error
+				Expect(err).To(BeNil())
+				Expect(proj.IndexDocument).To(Equal("index.html"))
+			})
+		})
+
+		Context("when deploy_concurrency is given", func() {
+			BeforeEach(func() {
+				params = url.Values{
+					"deploy_concurrency": {"reject"},
+				}
+			})
+
+			It("updates the project", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				err = db.First(proj, proj.ID).Error
+				Expect(err).To(BeNil())
+				Expect(proj.DeployConcurrency).To(Equal(project.DeployConcurrencyReject))
+			})
+
+			Context("when it is invalid", func() {
+				BeforeEach(func() {
+					params = url.Values{
+						"deploy_concurrency": {"parallel"},
+					}
+				})
+
+				It("returns 422 and does not update the project", func() {
+					doRequest()
+
+					b := &bytes.Buffer{}
+					_, err := b.ReadFrom(res.Body)
+					Expect(err).To(BeNil())
+
+					Expect(res.StatusCode).To(Equal(422))
+					Expect(b.String()).To(MatchJSON(`{
+						"error": "invalid_params",
+						"errors": {
+							"deploy_concurrency": "must be either queue or reject"
+						}
+					}`))
+
+					err = db.First(proj, proj.ID).Error
+					Expect(err).To(BeNil())
+					Expect(proj.DeployConcurrency).To(Equal(project.DeployConcurrencyQueue))
+				})
+			})
+		})
+
+		Context("when bandwidth_exceeded_mode and bandwidth_exceeded_page are given", func() {
+			BeforeEach(func() {
+				params = url.Values{
+					"bandwidth_exceeded_mode": {"degrade"},
+					"bandwidth_exceeded_page": {" /quota.html "},
+				}
+			})
+
+			It("updates the project", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				err = db.First(proj, proj.ID).Error
+				Expect(err).To(BeNil())
+				Expect(proj.BandwidthExceededMode).To(Equal(project.BandwidthExceededDegrade))
+				Expect(proj.BandwidthExceededPage).NotTo(BeNil())
+				Expect(*proj.BandwidthExceededPage).To(Equal("/quota.html"))
+			})
+
+			Context("when there is an active deployment", func() {
+				BeforeEach(func() {
+					depl := factories.Deployment(db, proj, u, deployment.StateDeployed)
+					err := db.Model(proj).Update("active_deployment_id", depl.ID).Error
+					Expect(err).To(BeNil())
+				})
+
+				It("enqueues a deploy job to update meta.json", func() {
+					doRequest()
+
+					d := testhelper.ConsumeQueue(mq, queues.Deploy)
+					Expect(d).NotTo(BeNil())
+					Expect(d.Body).To(MatchJSON(fmt.Sprintf(`{
+						"deployment_id": %d,
+						"skip_webroot_upload": true,
+						"skip_invalidation": false,
+						"use_raw_bundle": false
+					}`, *proj.ActiveDeploymentID)))
+					Expect(testhelper.ConsumeQueue(mq, queues.Deploy)).To(BeNil())
+				})
+			})
+
+			Context("when the page is empty", func() {
+				BeforeEach(func() {
+					page := "/quota.html"
+					Expect(db.Model(proj).Update("bandwidth_exceeded_page", &page).Error).To(BeNil())
+
+					params = url.Values{
+						"bandwidth_exceeded_page": {""},
+					}
+				})
+
+				It("removes the page", func() {
+					doRequest()
+
+					Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+					err = db.First(proj, proj.ID).Error
+					Expect(err).To(BeNil())
+					Expect(proj.BandwidthExceededPage).To(BeNil())
+				})
+			})
+
+			Context("when the mode is invalid", func() {
+				BeforeEach(func() {
+					params = url.Values{
+						"bandwidth_exceeded_mode": {"throttle"},
+					}
+				})
+
+				It("returns 422 and does not update the project", func() {
+					doRequest()
+
+					b := &bytes.Buffer{}
+					_, err := b.ReadFrom(res.Body)
+					Expect(err).To(BeNil())
+
+					Expect(res.StatusCode).To(Equal(422))
+					Expect(b.String()).To(MatchJSON(`{
+						"error": "invalid_params",
+						"errors": {
+							"bandwidth_exceeded_mode": "must be either block or degrade"
+						}
+					}`))
+
+					err = db.First(proj, proj.ID).Error
+					Expect(err).To(BeNil())
+					Expect(proj.BandwidthExceededMode).To(Equal(project.BandwidthExceededBlock))
+				})
+			})
+		})
+
+		Context("when max_deploys_kept is given", func() {
+			var depls []*deployment.Deployment
+
+			BeforeEach(func() {
+				depls = nil
+				for i := 3; i > 0; i-- {
+					deployedAt := time.Now().Add(-time.Duration(i) * time.Hour)
+					depls = append(depls, factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+						State:      deployment.StateDeployed,
+						DeployedAt: &deployedAt,
+					}))
+				}
+
+				params = url.Values{
+					"max_deploys_kept": {"2"},
+				}
+			})
+
+			It("returns 200 OK and deletes deployments beyond the limit", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"project":{
+						"name": "%s",
+						"default_domain_enabled": true,
+						"force_https": false,
+						"noindex_default_domain": false,
+						"lock_version": 1,
+						"skip_build": false,
+						"security_headers_enabled": true,
+						"index_document": "index.html",
+						"directory_listings": false,
+						"asset_fingerprinting": false,
+						"deploy_concurrency": "queue",
+						"bandwidth_exceeded_mode": "block",
+						"max_deploys_kept": 2,
+						"created_at": "%s"
+					}
+				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
+
+				err = db.First(proj, proj.ID).Error
+				Expect(err).To(BeNil())
+				Expect(proj.MaxDeploysKept).To(Equal(uint(2)))
+
+				var count int
+				Expect(db.Model(deployment.Deployment{}).Where("project_id = ?", proj.ID).Count(&count).Error).To(BeNil())
+				Expect(count).To(Equal(2))
+
+				Expect(db.First(&deployment.Deployment{}, depls[0].ID).Error).To(Equal(gorm.RecordNotFound))
+			})
+
+			Context("when max_deploys_kept is out of range", func() {
+				BeforeEach(func() {
+					params = url.Values{
+						"max_deploys_kept": {"0"},
+					}
+				})
+
+				It("returns 422 and does not update the project", func() {
+					doRequest()
+
+					b := &bytes.Buffer{}
+					_, err := b.ReadFrom(res.Body)
+					Expect(err).To(BeNil())
+
+					Expect(res.StatusCode).To(Equal(422))
+					Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+						"error": "invalid_params",
+						"errors": {
+							"max_deploys_kept": "must be between 1 and %d"
+						}
+					}`, project.MaxDeploysKeptLimit)))
+
+					err = db.First(proj, proj.ID).Error
+					Expect(err).To(BeNil())
+					Expect(proj.MaxDeploysKept).To(Equal(uint(0)))
+				})
+			})
+
+			Context("when the current user is a collaborator", func() {
+				BeforeEach(func() {
+					u2, _, t2 := factories.AuthTrio(db)
+					factories.Collab(db, proj, u2)
+
+					headers = http.Header{
+						"Authorization": {"Bearer " + t2.Token},
+					}
+				})
+
+				It("returns 403 and does not delete any deployments", func() {
+					doRequest()
+
+					Expect(res.StatusCode).To(Equal(http.StatusForbidden))
+
+					var count int
+					Expect(db.Model(deployment.Deployment{}).Where("project_id = ?", proj.ID).Count(&count).Error).To(BeNil())
+					Expect(count).To(Equal(3))
+				})
+			})
+		})
+
+		Context("when skip_build set to true", func() {
+			BeforeEach(func() {
+				proj.SkipBuild = false
+				Expect(db.Save(proj).Error).To(BeNil())
+				params = url.Values{
+					"skip_build": {"true"},
+				}
+			})
+
+			It("returns 200 OK", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				err = db.First(proj, proj.ID).Error
+				Expect(err).To(BeNil())
+				Expect(proj.DefaultDomainEnabled).To(Equal(true))
+
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"project":{
+						"name": "%s",
+						"default_domain_enabled": true,
+						"force_https": false,
+						"noindex_default_domain": false,
+						"lock_version": 1,
+						"skip_build": true,
+						"security_headers_enabled": true,
+						"index_document": "index.html",
+						"directory_listings": false,
+						"asset_fingerprinting": false,
+						"deploy_concurrency": "queue",
+						"bandwidth_exceeded_mode": "block",
+						"created_at": "%s"
+					}
+				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
+			})
+
+		})
+
+		Context("when asset_fingerprinting is set to true", func() {
+			BeforeEach(func() {
+				params = url.Values{
+					"asset_fingerprinting": {"true"},
+				}
+			})
+
+			It("returns 200 OK", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				err = db.First(proj, proj.ID).Error
+				Expect(err).To(BeNil())
+				Expect(proj.AssetFingerprinting).To(BeTrue())
+				Expect(proj.LockVersion).To(Equal(int64(1)))
+
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"project":{
+						"name": "%s",
+						"default_domain_enabled": true,
+						"force_https": false,
+						"noindex_default_domain": false,
+						"lock_version": 1,
+						"skip_build": true,
+						"security_headers_enabled": true,
+						"index_document": "index.html",
+						"directory_listings": false,
+						"asset_fingerprinting": true,
+						"deploy_concurrency": "queue",
+						"bandwidth_exceeded_mode": "block",
+						"created_at": "%s"
+					}
+				}`, proj.Name, proj.CreatedAt.Format(time.RFC3339Nano))))
+			})
+		})
+
+		Context("when lock_version is given", func() {
+			BeforeEach(func() {
+				Expect(db.Model(proj).UpdateColumn("lock_version", 3).Error).To(BeNil())
+				params = url.Values{
+					"force_https": {"true"},
+				}
+			})
+
+			Context("when it matches the current lock version", func() {
+				BeforeEach(func() {
+					params.Set("lock_version", "3")
+				})
+
+				It("updates the project and increments the lock version", func() {
+					doRequest()
+					Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+					err = db.First(proj, proj.ID).Error
+					Expect(err).To(BeNil())
+					Expect(proj.ForceHTTPS).To(BeTrue())
+					Expect(proj.LockVersion).To(Equal(int64(4)))
+				})
+			})
+
+			Context("when it does not match the current lock version", func() {
+				BeforeEach(func() {
+					params.Set("lock_version", "2")
+				})
+
+				It("returns 409 conflict and does not update the project", func() {
+					doRequest()
+
+					b := &bytes.Buffer{}
+					_, err := b.ReadFrom(res.Body)
+					Expect(err).To(BeNil())
+
+					Expect(res.StatusCode).To(Equal(http.StatusConflict))
+					Expect(b.String()).To(MatchJSON(`{
+						"error": "conflict",
+						"code": "stale_lock_version",
+						"error_description": "project has been modified by someone else, please reload it and try again"
+					}`))
+
+					err = db.First(proj, proj.ID).Error
+					Expect(err).To(BeNil())
+					Expect(proj.ForceHTTPS).To(BeFalse())
+					Expect(proj.LockVersion).To(Equal(int64(3)))
+				})
+			})
+
+			Context("when it is not a number", func() {
+				BeforeEach(func() {
+					params.Set("lock_version", "abc")
+				})
+
+				It("returns 422 with invalid_params", func() {
+					doRequest()
+
+					b := &bytes.Buffer{}
+					_, err := b.ReadFrom(res.Body)
+					Expect(err).To(BeNil())
+
+					Expect(res.StatusCode).To(Equal(422))
+					Expect(b.String()).To(MatchJSON(`{
+						"error": "invalid_params",
+						"errors": {
+							"lock_version": "is invalid"
+						}
+					}`))
+				})
+			})
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProject(func() (*gorm.DB, *project.Project) {
+			return db, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItLocksProject(func() (*gorm.DB, *project.Project) {
+			return db, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+
+	Describe("DELETE /projects/:name", func() {
+		var (
+			fakeS3                *fake.S3
+			origS3                filetransfer.FileTransfer
+			mq                    mqconn.Conn
+			invalidationQueueName string
+
+			proj *project.Project
+			dm1  *domain.Domain
+			dm2  *domain.Domain
+
+			headers http.Header
+		)
+
+		BeforeEach(func() {
+			origS3 = s3client.S3
+			fakeS3 = &fake.S3{}
+			s3client.S3 = fakeS3
+
+			mq, err = mqconn.MQ()
+			Expect(err).To(BeNil())
+
+			testhelper.DeleteQueue(mq, queues.All...)
+			testhelper.DeleteExchange(mq, exchanges.All...)
+
+			invalidationQueueName = testhelper.StartQueueWithExchange(mq, exchanges.Edges, exchanges.RouteV1Invalidation)
+
+			headers = http.Header{
+				"Authorization": {"Bearer " + t.Token},
+			}
+
+			proj = factories.Project(db, u)
+			dm1 = factories.Domain(db, proj)
+			dm2 = factories.Domain(db, proj)
+
+			ct1 := &cert.Cert{
+				DomainID:        dm1.ID,
+				CertificatePath: "old/path",
+				PrivateKeyPath:  "old/path",
+			}
+			Expect(db.Create(ct1).Error).To(BeNil())
+
+			ct2 := &cert.Cert{
+				DomainID:        dm2.ID,
+				CertificatePath: "old/path",
+				PrivateKeyPath:  "old/path",
+			}
+			Expect(db.Create(ct2).Error).To(BeNil())
+		})
+
+		AfterEach(func() {
 			s3client.S3 = origS3
 		})
 
@@ -1094,6 +1983,19 @@ var _ = Describe("Projects", func() {
 			}`))
 		})
 
+		It("holds the name of the project for its owner", func() {
+			doRequest()
+
+			rn := &releasedname.ReleasedName{}
+			Expect(db.Where("name = ?", proj.Name).First(rn).Error).To(BeNil())
+			Expect(rn.UserID).To(Equal(u.ID))
+			Expect(rn.HeldUntil).To(BeTemporally("~", time.Now().AddDate(0, 0, shared.NameCooldownDays), time.Minute))
+
+			held, err := releasedname.IsHeld(db, proj.Name, u.ID+1)
+			Expect(err).To(BeNil())
+			Expect(held).To(BeTrue())
+		})
+
 		It("deletes associated domains and certs", func() {
 			doRequest()
 
@@ -1121,9 +2023,13 @@ var _ = Describe("Projects", func() {
 				"domains/" + dm1.Name + "/meta.json",
 				"certs/" + dm1.Name + "/ssl.crt",
 				"certs/" + dm1.Name + "/ssl.key",
+				"certs/" + dm1.Name + "/acme-tls-alpn.crt",
+				"certs/" + dm1.Name + "/acme-tls-alpn.key",
 				"domains/" + dm2.Name + "/meta.json",
 				"certs/" + dm2.Name + "/ssl.crt",
 				"certs/" + dm2.Name + "/ssl.key",
+				"certs/" + dm2.Name + "/acme-tls-alpn.crt",
+				"certs/" + dm2.Name + "/acme-tls-alpn.key",
 			}
 
 			for i, path := range filesToDelete {
@@ -1199,9 +2105,13 @@ var _ = Describe("Projects", func() {
 					"domains/" + dm1.Name + "/meta.json",
 					"certs/" + dm1.Name + "/ssl.crt",
 					"certs/" + dm1.Name + "/ssl.key",
+					"certs/" + dm1.Name + "/acme-tls-alpn.crt",
+					"certs/" + dm1.Name + "/acme-tls-alpn.key",
 					"domains/" + dm2.Name + "/meta.json",
 					"certs/" + dm2.Name + "/ssl.crt",
 					"certs/" + dm2.Name + "/ssl.key",
+					"certs/" + dm2.Name + "/acme-tls-alpn.crt",
+					"certs/" + dm2.Name + "/acme-tls-alpn.key",
 
 					bun1.UploadedPath,
 					bun2.UploadedPath,
@@ -1237,7 +2147,7 @@ var _ = Describe("Projects", func() {
 
 	Describe("POST /projects/:name/auth", func() {
 		var (
-			mq *amqp.Connection
+			mq mqconn.Conn
 
 			proj *project.Project
 
@@ -1293,6 +2203,29 @@ var _ = Describe("Projects", func() {
 				Expect(err).To(BeNil())
 
 				Expect(*proj.EncryptedBasicAuthPassword).To(Equal(hex.EncodeToString(hasher.Sum(nil))))
+				Expect(proj.BasicAuthRealm).To(BeNil())
+				Expect(proj.BasicAuthPage).To(BeNil())
+			})
+
+			Context("when `basic_auth_realm` and `basic_auth_page` are provided", func() {
+				BeforeEach(func() {
+					params.Set("basic_auth_realm", "Acme Corp client preview")
+					params.Set("basic_auth_page", "/401.html")
+				})
+
+				It("saves the realm and page", func() {
+					doRequest()
+
+					Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+					err = db.First(proj, proj.ID).Error
+					Expect(err).To(BeNil())
+
+					Expect(proj.BasicAuthRealm).NotTo(BeNil())
+					Expect(*proj.BasicAuthRealm).To(Equal("Acme Corp client preview"))
+					Expect(proj.BasicAuthPage).NotTo(BeNil())
+					Expect(*proj.BasicAuthPage).To(Equal("/401.html"))
+				})
 			})
 
 			Context("when there is an active deployment", func() {
@@ -1319,6 +2252,22 @@ var _ = Describe("Projects", func() {
 			})
 		})
 
+		Context("when lock_version does not match the current lock version", func() {
+			BeforeEach(func() {
+				params.Set("lock_version", "1")
+			})
+
+			It("returns 409 conflict and does not update the project", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusConflict))
+
+				err = db.First(proj, proj.ID).Error
+				Expect(err).To(BeNil())
+				Expect(proj.BasicAuthUsername).To(BeNil())
+			})
+		})
+
 		Context("when invalid params are provided", func() {
 			DescribeTable("it returns 422 and does not update project",
 				func(setUp func(), message string) {
@@ -1356,6 +2305,24 @@ var _ = Describe("Projects", func() {
 							"basic_auth_password": "is required"
 						}
 					}`),
+
+				Entry("require a valid basic_auth_realm", func() {
+					params.Set("basic_auth_realm", `"Acme"`)
+				}, `{
+						"error": "invalid_params",
+						"errors": {
+							"basic_auth_realm": "is invalid"
+						}
+					}`),
+
+				Entry("require a valid basic_auth_page", func() {
+					params.Set("basic_auth_page", "https://example.com/401.html")
+				}, `{
+						"error": "invalid_params",
+						"errors": {
+							"basic_auth_page": "must be the path of an HTML file, e.g. /401.html"
+						}
+					}`),
 			)
 		})
 
@@ -1383,7 +2350,7 @@ var _ = Describe("Projects", func() {
 
 	Describe("DELETE /projects/:name/auth", func() {
 		var (
-			mq *amqp.Connection
+			mq mqconn.Conn
 
 			proj *project.Project
 
@@ -1405,6 +2372,10 @@ var _ = Describe("Projects", func() {
 			password := "pass"
 			proj.BasicAuthUsername = &username
 			proj.BasicAuthPassword = password
+			realm := "Acme Corp client preview"
+			page := "/401.html"
+			proj.BasicAuthRealm = &realm
+			proj.BasicAuthPage = &page
 			Expect(proj.EncryptBasicAuthPassword()).To(BeNil())
 			Expect(db.Save(proj).Error).To(BeNil())
 		})
@@ -1433,6 +2404,8 @@ var _ = Describe("Projects", func() {
 
 				Expect(proj.BasicAuthUsername).To(BeNil())
 				Expect(proj.EncryptedBasicAuthPassword).To(BeNil())
+				Expect(proj.BasicAuthRealm).To(BeNil())
+				Expect(proj.BasicAuthPage).To(BeNil())
 			})
 
 			Context("when there is an active deployment", func() {
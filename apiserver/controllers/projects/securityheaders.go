@@ -0,0 +1,118 @@
+package projects
+
+import (
+	"net/http"
+	"strconv"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+)
+
+// ShowSecurityHeaders shows whether security headers are enabled for a
+// project, the headers that override the defaults, and the headers that are
+// served.
+func ShowSecurityHeaders(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	respondSecurityHeaders(c, proj)
+}
+
+// UpdateSecurityHeaders enables or disables security headers for a project
+// and replaces the headers that override the defaults. A header overridden
+// with an empty value is not served.
+func UpdateSecurityHeaders(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	if !checkLockVersion(c, proj) {
+		return
+	}
+
+	var params struct {
+		Enabled *bool             `json:"enabled"`
+		Headers map[string]string `json:"headers"`
+	}
+	if err := c.Bind(&params); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":             "invalid_request",
+			"error_description": "request body is in invalid format",
+		})
+		return
+	}
+
+	wasEnabled := proj.SecurityHeadersEnabled
+	if params.Enabled != nil {
+		proj.SecurityHeadersEnabled = *params.Enabled
+	}
+
+	if params.Headers != nil {
+		if err := proj.SetSecurityHeaderOverrides(params.Headers); err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+	}
+
+	if errs := proj.Validate(); errs != nil {
+		c.JSON(422, gin.H{
+			"error":  "invalid_params",
+			"errors": errs,
+		})
+		return
+	}
+
+	if err := saveAndUpdateMeta(c, proj); err != nil {
+		if err == project.ErrStaleProject {
+			respondConflict(c)
+			return
+		}
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if wasEnabled != proj.SecurityHeadersEnabled {
+		u := controllers.CurrentUser(c)
+
+		var (
+			event   = "Disabled Security Headers"
+			props   = map[string]interface{}{"projectName": proj.Name}
+			context = controllers.TrackingContext(c)
+		)
+		if proj.SecurityHeadersEnabled {
+			event = "Enabled Security Headers"
+		}
+		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
+			log.Errorf("failed to track %q event for user ID %d, err: %v",
+				event, u.ID, err)
+		}
+	}
+
+	respondSecurityHeaders(c, proj)
+}
+
+func respondSecurityHeaders(c *gin.Context, proj *project.Project) {
+	overrides, err := proj.SecurityHeaderOverridesMap()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	headers, err := proj.SecurityHeaders()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+	if headers == nil {
+		headers = map[string]string{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"security_headers": gin.H{
+			"enabled":   proj.SecurityHeadersEnabled,
+			"overrides": overrides,
+			"headers":   headers,
+		},
+		"lock_version": proj.LockVersion,
+	})
+}
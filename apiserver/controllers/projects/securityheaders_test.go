@@ -0,0 +1,301 @@
+package projects_test
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/apiserver/server"
+	"github.com/nitrous-io/rise-server/pkg/mqconn"
+	"github.com/nitrous-io/rise-server/shared/queues"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+	"github.com/nitrous-io/rise-server/testhelper/sharedexamples"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Project security headers", func() {
+	var (
+		db      *gorm.DB
+		s       *httptest.Server
+		res     *http.Response
+		headers http.Header
+		err     error
+
+		u    *user.User
+		t    *oauthtoken.OauthToken
+		proj *project.Project
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+
+		u, _, t = factories.AuthTrio(db)
+
+		headers = http.Header{
+			"Authorization": {"Bearer " + t.Token},
+		}
+
+		proj = factories.Project(db, u, "panda-express")
+	})
+
+	AfterEach(func() {
+		if res != nil {
+			res.Body.Close()
+		}
+		s.Close()
+	})
+
+	Describe("GET /projects/:project_name/security_headers", func() {
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("GET", s.URL+"/projects/panda-express/security_headers", nil, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("returns 200 OK with the default security headers", func() {
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+				"security_headers": {
+					"enabled": true,
+					"overrides": {},
+					"headers": {
+						"X-Content-Type-Options": "nosniff",
+						"Referrer-Policy": "strict-origin-when-cross-origin",
+						"X-Frame-Options": "SAMEORIGIN",
+						"Content-Security-Policy": %q
+					}
+				},
+				"lock_version": 0
+			}`, project.DefaultSecurityHeaders[project.HeaderContentSecurityPolicy])))
+		})
+
+		Context("when security headers are disabled", func() {
+			BeforeEach(func() {
+				Expect(db.Model(proj).UpdateColumn("security_headers_enabled", false).Error).To(BeNil())
+			})
+
+			It("returns 200 OK without any headers", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(b.String()).To(MatchJSON(`{
+					"security_headers": {
+						"enabled": false,
+						"overrides": {},
+						"headers": {}
+					},
+					"lock_version": 0
+				}`))
+			})
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+
+	Describe("PUT /projects/:project_name/security_headers", func() {
+		var (
+			mq   mqconn.Conn
+			body string
+			path string
+		)
+
+		BeforeEach(func() {
+			mq, err = mqconn.MQ()
+			Expect(err).To(BeNil())
+
+			testhelper.DeleteQueue(mq, queues.All...)
+
+			path = "/projects/panda-express/security_headers"
+			body = `{
+				"headers": {
+					"x-frame-options": "DENY",
+					"Content-Security-Policy": ""
+				}
+			}`
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+
+			req, err := http.NewRequest("PUT", s.URL+path, bytes.NewBufferString(body))
+			Expect(err).To(BeNil())
+			req.Header.Add("Content-Type", "application/json")
+
+			for k, v := range headers {
+				for _, h := range v {
+					req.Header.Add(k, h)
+				}
+			}
+
+			res, err = http.DefaultClient.Do(req)
+			Expect(err).To(BeNil())
+		}
+
+		It("returns 200 OK and overrides the security headers", func() {
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(MatchJSON(`{
+				"security_headers": {
+					"enabled": true,
+					"overrides": {
+						"X-Frame-Options": "DENY",
+						"Content-Security-Policy": ""
+					},
+					"headers": {
+						"X-Content-Type-Options": "nosniff",
+						"Referrer-Policy": "strict-origin-when-cross-origin",
+						"X-Frame-Options": "DENY"
+					}
+				},
+				"lock_version": 1
+			}`))
+
+			Expect(db.First(proj, proj.ID).Error).To(BeNil())
+			overrides, err := proj.SecurityHeaderOverridesMap()
+			Expect(err).To(BeNil())
+			Expect(overrides).To(Equal(map[string]string{
+				"X-Frame-Options":         "DENY",
+				"Content-Security-Policy": "",
+			}))
+		})
+
+		Context("when security headers are disabled", func() {
+			BeforeEach(func() {
+				body = `{"enabled": false}`
+			})
+
+			It("returns 200 OK and disables security headers", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				Expect(db.First(proj, proj.ID).Error).To(BeNil())
+				Expect(proj.SecurityHeadersEnabled).To(BeFalse())
+			})
+		})
+
+		Context("when there is an active deployment", func() {
+			BeforeEach(func() {
+				depl := factories.Deployment(db, proj, u, deployment.StateDeployed)
+				Expect(db.Model(proj).Update("active_deployment_id", depl.ID).Error).To(BeNil())
+			})
+
+			It("enqueues a deploy job to update meta.json", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+				d := testhelper.ConsumeQueue(mq, queues.Deploy)
+				Expect(d).NotTo(BeNil())
+				Expect(d.Body).To(MatchJSON(fmt.Sprintf(`{
+					"deployment_id": %d,
+					"skip_webroot_upload": true,
+					"skip_invalidation": false,
+					"use_raw_bundle": false
+				}`, *proj.ActiveDeploymentID)))
+			})
+		})
+
+		Context("when an unsupported header is given", func() {
+			BeforeEach(func() {
+				body = `{"headers": {"Set-Cookie": "foo=bar"}}`
+			})
+
+			It("returns 422 and does not update the project", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"errors": {
+						"security_headers": "\"Set-Cookie\" is not a supported header"
+					}
+				}`))
+
+				Expect(db.First(proj, proj.ID).Error).To(BeNil())
+				Expect(proj.LockVersion).To(Equal(int64(0)))
+			})
+		})
+
+		Context("when lock_version does not match the current lock version", func() {
+			BeforeEach(func() {
+				path += "?lock_version=1"
+			})
+
+			It("returns 409 conflict and does not update the project", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(http.StatusConflict))
+
+				Expect(db.First(proj, proj.ID).Error).To(BeNil())
+				overrides, err := proj.SecurityHeaderOverridesMap()
+				Expect(err).To(BeNil())
+				Expect(overrides).To(BeEmpty())
+			})
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItLocksProject(func() (*gorm.DB, *project.Project) {
+			return db, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+})
@@ -0,0 +1,88 @@
+package projects
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/dailystat"
+)
+
+const (
+	defaultStatsDays = 30
+	maxStatsDays     = 366
+)
+
+// Stats returns daily request, bandwidth and status code numbers for each
+// domain of a project, aggregated from edge access logs.
+func Stats(c *gin.Context) {
+	proj := controllers.CurrentProject(c)
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	to := today
+	from := today.AddDate(0, 0, -(defaultStatsDays - 1))
+
+	for k, t := range map[string]*time.Time{"from": &from, "to": &to} {
+		if v := c.Query(k); v != "" {
+			parsed, err := time.Parse(dailystat.DateFormat, v)
+			if err != nil {
+				c.JSON(422, gin.H{
+					"error": "invalid_params",
+					"errors": map[string]string{
+						k: "is invalid",
+					},
+				})
+				return
+			}
+			*t = parsed
+		}
+	}
+
+	if from.After(to) {
+		c.JSON(422, gin.H{
+			"error": "invalid_params",
+			"errors": map[string]string{
+				"from": "must not be after to",
+			},
+		})
+		return
+	}
+
+	if to.Sub(from) >= maxStatsDays*24*time.Hour {
+		c.JSON(422, gin.H{
+			"error": "invalid_params",
+			"errors": map[string]string{
+				"from": "is too far from to (max. 366 days)",
+			},
+		})
+		return
+	}
+
+	tx, err := dbconn.Begin(controllers.Context(c))
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+	defer tx.Rollback()
+
+	stats, err := dailystat.FindByProject(tx, proj.ID, from, to)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	domains := map[string][]*dailystat.JSON{}
+	for _, s := range stats {
+		domains[s.DomainName] = append(domains[s.DomainName], s.AsJSON())
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"stats": gin.H{
+			"from":    from.Format(dailystat.DateFormat),
+			"to":      to.Format(dailystat.DateFormat),
+			"domains": domains,
+		},
+	})
+}
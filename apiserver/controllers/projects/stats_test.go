@@ -0,0 +1,171 @@
+package projects_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/dailystat"
+	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/apiserver/server"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+	"github.com/nitrous-io/rise-server/testhelper/sharedexamples"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Project stats", func() {
+	var (
+		db      *gorm.DB
+		s       *httptest.Server
+		res     *http.Response
+		headers http.Header
+		params  url.Values
+		err     error
+
+		u    *user.User
+		t    *oauthtoken.OauthToken
+		proj *project.Project
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+
+		u, _, t = factories.AuthTrio(db)
+
+		headers = http.Header{
+			"Authorization": {"Bearer " + t.Token},
+		}
+		params = url.Values{}
+
+		proj = factories.Project(db, u, "panda-express")
+	})
+
+	AfterEach(func() {
+		if res != nil {
+			res.Body.Close()
+		}
+		s.Close()
+	})
+
+	Describe("GET /projects/:project_name/stats", func() {
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("GET", s.URL+"/projects/panda-express/stats", params, headers, nil)
+			Expect(err).To(BeNil())
+		}
+
+		BeforeEach(func() {
+			for _, st := range []*dailystat.DailyStat{
+				{ProjectID: proj.ID, DomainName: "www.panda-express.com", Date: time.Date(2016, 6, 1, 0, 0, 0, 0, time.UTC), Requests: 3, Bytes: 300, Status2xx: 2, Status4xx: 1},
+				{ProjectID: proj.ID, DomainName: "www.panda-express.com", Date: time.Date(2016, 6, 2, 0, 0, 0, 0, time.UTC), Requests: 1, Bytes: 100, Status3xx: 1},
+				{ProjectID: proj.ID, DomainName: "www.panda-express.com", Date: time.Date(2016, 7, 1, 0, 0, 0, 0, time.UTC), Requests: 9, Bytes: 900, Status2xx: 9},
+			} {
+				Expect(dailystat.Increment(db, st)).To(BeNil())
+			}
+
+			params.Set("from", "2016-06-01")
+			params.Set("to", "2016-06-30")
+		})
+
+		It("returns daily stats of each domain within the date range", func() {
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err = b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(MatchJSON(`{
+				"stats": {
+					"from": "2016-06-01",
+					"to": "2016-06-30",
+					"domains": {
+						"www.panda-express.com": [
+							{
+								"date": "2016-06-01",
+								"requests": 3,
+								"bytes": 300,
+								"status_codes": {"2xx": 2, "3xx": 0, "4xx": 1, "5xx": 0}
+							},
+							{
+								"date": "2016-06-02",
+								"requests": 1,
+								"bytes": 100,
+								"status_codes": {"2xx": 0, "3xx": 1, "4xx": 0, "5xx": 0}
+							}
+						]
+					}
+				}
+			}`))
+		})
+
+		Context("when from is invalid", func() {
+			BeforeEach(func() {
+				params.Set("from", "june")
+			})
+
+			It("returns 422 unprocessable entity", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"errors": {
+						"from": "is invalid"
+					}
+				}`))
+			})
+		})
+
+		Context("when from is after to", func() {
+			BeforeEach(func() {
+				params.Set("from", "2016-07-01")
+			})
+
+			It("returns 422 unprocessable entity", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"errors": {
+						"from": "must not be after to"
+					}
+				}`))
+			})
+		})
+
+		sharedexamples.ItRequiresAuthentication(func() (*gorm.DB, *user.User, *http.Header) {
+			return db, u, &headers
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+
+		sharedexamples.ItRequiresProjectCollab(func() (*gorm.DB, *user.User, *project.Project) {
+			return db, u, proj
+		}, func() *http.Response {
+			doRequest()
+			return res
+		}, nil)
+	})
+})
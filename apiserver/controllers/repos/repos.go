@@ -8,6 +8,7 @@ import (
 	"github.com/nitrous-io/rise-server/apiserver/controllers"
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
 	"github.com/nitrous-io/rise-server/apiserver/models/repo"
+	"github.com/nitrous-io/rise-server/pkg/rootdir"
 )
 
 func Show(c *gin.Context) {
@@ -46,6 +47,27 @@ func Link(c *gin.Context) {
 		return
 	}
 
+	provider := c.PostForm("provider")
+	if provider == "" {
+		provider = repo.ProviderGitHub
+	}
+	if _, ok := repo.ProviderNames[provider]; !ok {
+		c.JSON(422, gin.H{
+			"error":  "invalid_params",
+			"errors": map[string]interface{}{"provider": "is invalid"},
+		})
+		return
+	}
+
+	rootDir, err := rootdir.Clean(c.PostForm("root_dir"))
+	if err != nil {
+		c.JSON(422, gin.H{
+			"error":  "invalid_params",
+			"errors": map[string]interface{}{"root_dir": "is invalid"},
+		})
+		return
+	}
+
 	db, err := dbconn.DB()
 	if err != nil {
 		controllers.InternalServerError(c, err)
@@ -55,8 +77,10 @@ func Link(c *gin.Context) {
 	rp := &repo.Repo{
 		ProjectID:     proj.ID,
 		UserID:        u.ID,
+		Provider:      provider,
 		URI:           uri,
 		Branch:        branch,
+		RootDir:       rootDir,
 		WebhookSecret: secret,
 	}
 	if err := db.Create(rp).Error; err != nil {
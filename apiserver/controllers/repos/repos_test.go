@@ -2,6 +2,7 @@ package repos_test
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -100,8 +101,10 @@ var _ = Describe("Repos", func() {
 			Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
 				"repo": {
 					"project_id": %d,
+					"provider": "github",
 					"uri": "git@github.com:golang/talks.git",
 					"branch": "release",
+					"root_dir": "",
 					"webhook_url": "%s",
 					"webhook_secret": "%s"
 				}
@@ -206,14 +209,69 @@ var _ = Describe("Repos", func() {
 			Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
 				"repo": {
 					"project_id": %d,
+					"provider": "github",
 					"uri": "git@github.com:golang/talks.git",
 					"branch": "release",
+					"root_dir": "",
 					"webhook_url": "%s",
 					"webhook_secret": "%s"
 				}
 			}`, proj.ID, fmt.Sprintf("%s/hooks/github/%s", common.WebhookHost, rp.WebhookPath), rp.WebhookSecret)))
 		})
 
+		Context("when provider is specified", func() {
+			BeforeEach(func() {
+				params.Set("provider", "gitlab")
+				params.Set("uri", "git@gitlab.com:mike/diaspora.git")
+			})
+
+			It("saves the provider and responds with the webhook URL of the provider", func() {
+				doRequest()
+
+				rp := &repo.Repo{}
+				err := db.Where("project_id = ?", proj.ID).First(&rp).Error
+				Expect(err).To(BeNil())
+
+				Expect(rp.Provider).To(Equal("gitlab"))
+
+				var j struct {
+					Repo struct {
+						Provider   string `json:"provider"`
+						WebhookURL string `json:"webhook_url"`
+					} `json:"repo"`
+				}
+				Expect(res.StatusCode).To(Equal(http.StatusCreated))
+				Expect(json.NewDecoder(res.Body).Decode(&j)).To(Succeed())
+				Expect(j.Repo.Provider).To(Equal("gitlab"))
+				Expect(j.Repo.WebhookURL).To(Equal(fmt.Sprintf("%s/hooks/gitlab/%s", common.WebhookHost, rp.WebhookPath)))
+			})
+		})
+
+		Context("when provider is not supported", func() {
+			BeforeEach(func() {
+				params.Set("provider", "sourceforge")
+			})
+
+			It("responds with HTTP 422 with invalid_params", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+						"error": "invalid_params",
+						"errors": {
+							"provider": "is invalid"
+						}
+					}`))
+
+				var count int
+				Expect(db.Model(repo.Repo{}).Where("project_id = ?", proj.ID).Count(&count).Error).To(BeNil())
+				Expect(count).To(Equal(0))
+			})
+		})
+
 		Context("when repo branch is not specified", func() {
 			BeforeEach(func() {
 				params.Set("branch", "")
@@ -252,8 +310,10 @@ var _ = Describe("Repos", func() {
 				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
 					"repo": {
 						"project_id": %d,
+						"provider": "github",
 						"uri": "git@github.com:golang/talks.git",
 						"branch": "release",
+						"root_dir": "",
 						"webhook_url": "%s",
 						"webhook_secret": "my little secret pony"
 					}
@@ -261,6 +321,64 @@ var _ = Describe("Repos", func() {
 			})
 		})
 
+		Context("when root directory is specified", func() {
+			BeforeEach(func() {
+				params.Add("root_dir", "/apps/www/")
+			})
+
+			It("saves the cleaned root directory", func() {
+				doRequest()
+
+				rp := &repo.Repo{}
+				err := db.Where("project_id = ?", proj.ID).First(&rp).Error
+				Expect(err).To(BeNil())
+
+				Expect(rp.RootDir).To(Equal("apps/www"))
+
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusCreated))
+				Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+					"repo": {
+						"project_id": %d,
+						"provider": "github",
+						"uri": "git@github.com:golang/talks.git",
+						"branch": "release",
+						"root_dir": "apps/www",
+						"webhook_url": "%s",
+						"webhook_secret": ""
+					}
+				}`, proj.ID, fmt.Sprintf("%s/hooks/github/%s", common.WebhookHost, rp.WebhookPath))))
+			})
+		})
+
+		Context("when root directory is outside of the repository", func() {
+			BeforeEach(func() {
+				params.Add("root_dir", "apps/../../etc")
+			})
+
+			It("responds with HTTP 422 with invalid_params", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err = b.ReadFrom(res.Body)
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+						"error": "invalid_params",
+						"errors": {
+							"root_dir": "is invalid"
+						}
+					}`))
+
+				var count int
+				Expect(db.Model(repo.Repo{}).Where("project_id = ?", proj.ID).Count(&count).Error).To(BeNil())
+				Expect(count).To(Equal(0))
+			})
+		})
+
 		Context("when uri param is empty", func() {
 			BeforeEach(func() {
 				params.Set("uri", "")
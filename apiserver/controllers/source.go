@@ -0,0 +1,39 @@
+package controllers
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+)
+
+// CLIUserAgentPrefix is the start of the user agent of the command line
+// client, e.g. "rise-cli/1.2.0 (darwin)".
+const CLIUserAgentPrefix = "rise-cli/"
+
+// ciUserAgentRe matches the user agents of command line clients that run on a
+// CI server, which add "CI" to their user agent, e.g.
+// "rise-cli/1.2.0 (linux; CI)".
+var ciUserAgentRe = regexp.MustCompile(`\bCI\b`)
+
+// DeploymentSource returns the source of a deployment created by the current
+// request. Requests signed with an API key are from CI, as API keys are meant
+// for automated deploys.
+func DeploymentSource(c *gin.Context) string {
+	if CurrentToken(c) == nil && CurrentUser(c) != nil {
+		return deployment.SourceCI
+	}
+
+	ua := c.Request.UserAgent()
+	switch {
+	case strings.HasPrefix(ua, CLIUserAgentPrefix):
+		if ciUserAgentRe.MatchString(ua) {
+			return deployment.SourceCI
+		}
+		return deployment.SourceCLI
+	case strings.HasPrefix(ua, "Mozilla/"):
+		return deployment.SourceWeb
+	}
+	return deployment.SourceAPI
+}
@@ -0,0 +1,141 @@
+package sso
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/ssoconnection"
+	"github.com/nitrous-io/rise-server/pkg/oidc"
+)
+
+// ListConnections lists the SSO connections of all organizations.
+func ListConnections(c *gin.Context) {
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	conns, err := ssoconnection.All(db)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	connsJSON := make([]interface{}, len(conns))
+	for i, conn := range conns {
+		connsJSON[i] = conn.AsJSON()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"sso_connections": connsJSON,
+	})
+}
+
+// CreateConnection configures an organization's identity provider. Its
+// endpoints are looked up from the discovery document of the issuer.
+func CreateConnection(c *gin.Context) {
+	conn := &ssoconnection.SSOConnection{
+		Organization: c.PostForm("organization"),
+		EmailDomain:  strings.ToLower(c.PostForm("email_domain")),
+		Issuer:       c.PostForm("issuer"),
+		ClientID:     c.PostForm("client_id"),
+		ClientSecret: c.PostForm("client_secret"),
+	}
+
+	if errs := conn.Validate(); errs != nil {
+		c.JSON(422, gin.H{
+			"error":  "invalid_params",
+			"errors": errs,
+		})
+		return
+	}
+
+	m, err := oidc.Discover(nil, conn.Issuer)
+	if err != nil {
+		log.Warnf("failed to discover identity provider %q, err: %v", conn.Issuer, err)
+		c.JSON(422, gin.H{
+			"error": "invalid_params",
+			"errors": map[string]string{
+				"issuer": "could not be discovered",
+			},
+		})
+		return
+	}
+
+	conn.AuthorizationEndpoint = m.AuthorizationEndpoint
+	conn.TokenEndpoint = m.TokenEndpoint
+	conn.JWKSURI = m.JWKSURI
+
+	if err := conn.EncryptClientSecret(common.AesKeyring()); err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if err := conn.Insert(db); err != nil {
+		if err == ssoconnection.ErrEmailDomainTaken {
+			c.JSON(422, gin.H{
+				"error": "invalid_params",
+				"errors": map[string]string{
+					"email_domain": "is taken",
+				},
+			})
+			return
+		}
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"sso_connection": conn.AsJSON(),
+	})
+}
+
+// DestroyConnection disables single sign-on for an organization. Users who
+// were provisioned keep their accounts.
+func DestroyConnection(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "sso connection could not be found",
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	q := db.Where("id = ?", id).Delete(ssoconnection.SSOConnection{})
+	if err := q.Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if q.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "sso connection could not be found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"deleted": true,
+	})
+}
@@ -0,0 +1,317 @@
+// Package sso implements logging in with an organization's OpenID Connect
+// identity provider.
+//
+// A client starts a login by sending the user to GET /sso/login with their
+// email address. The user is redirected to the identity provider of the
+// organization that owns the email domain, and back to GET /sso/callback once
+// they have logged in there, where they are given an access token. Users who
+// log in for the first time are provisioned an account.
+package sso
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/oauthclient"
+	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/ssoconnection"
+)
+
+// StateCookie is the cookie that binds a login to the browser that started
+// it, so that users cannot be made to log in as someone else.
+const StateCookie = "sso_state"
+
+// Login redirects the user to the identity provider of their email domain.
+// If redirect_uri is given, the user is sent there with their access token
+// once they have logged in. Only loopback URLs are allowed, for command line
+// clients that listen for the token locally.
+func Login(c *gin.Context) {
+	email := c.Query("email")
+	clientID := c.Query("client_id")
+	redirectURI := c.Query("redirect_uri")
+
+	errors := map[string]string{}
+	if email == "" {
+		errors["email"] = "is required"
+	}
+	if clientID == "" {
+		errors["client_id"] = "is required"
+	}
+	if redirectURI != "" && !isLoopbackURL(redirectURI) {
+		errors["redirect_uri"] = "must be a loopback URL"
+	}
+	if len(errors) > 0 {
+		c.JSON(422, gin.H{
+			"error":  "invalid_params",
+			"errors": errors,
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	var conn *ssoconnection.SSOConnection
+	if domain := ssoconnection.EmailDomain(email); domain != "" {
+		conn, err = ssoconnection.FindByEmailDomain(db, domain)
+		if err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+	}
+
+	if conn == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "single sign-on is not enabled for this email address",
+		})
+		return
+	}
+
+	client, err := oauthclient.FindByClientID(db, clientID)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if client == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error":             "invalid_client",
+			"error_description": "client credentials are invalid",
+		})
+		return
+	}
+
+	p, err := conn.Provider(common.AesKeyring())
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	st, err := newLoginState(conn.ID, client.ClientID, redirectURI)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	state, err := st.encode()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	setStateCookie(c, state, int(StateTTL/time.Second))
+	c.Redirect(http.StatusFound, p.AuthCodeURL(common.SSOCallbackURL, state, st.Nonce))
+}
+
+// Callback completes a login once the identity provider has redirected the
+// user back with an authorization code.
+func Callback(c *gin.Context) {
+	if idpErr := c.Query("error"); idpErr != "" {
+		log.Warnf("identity provider returned an error, err: %s, description: %s", idpErr, c.Query("error_description"))
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":             "access_denied",
+			"error_description": "login with the identity provider was not completed",
+		})
+		return
+	}
+
+	state := c.Query("state")
+	cookie, err := c.Request.Cookie(StateCookie)
+	if state == "" || err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":             "invalid_request",
+			"error_description": "state is invalid",
+		})
+		return
+	}
+
+	st, err := decodeLoginState(state)
+	if err != nil {
+		desc := "state is invalid"
+		if err == errStateExpired {
+			desc = "login has expired, please try again"
+		}
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":             "invalid_request",
+			"error_description": desc,
+		})
+		return
+	}
+
+	// The state cannot be used again.
+	setStateCookie(c, "", -1)
+
+	code := c.Query("code")
+	if code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":             "invalid_request",
+			"error_description": `"code" is required`,
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	conn, err := ssoconnection.FindByID(db, st.ConnectionID)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if conn == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "single sign-on is not enabled for this email address",
+		})
+		return
+	}
+
+	client, err := oauthclient.FindByClientID(db, st.ClientID)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if client == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error":             "invalid_client",
+			"error_description": "client credentials are invalid",
+		})
+		return
+	}
+
+	p, err := conn.Provider(common.AesKeyring())
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	claims, err := p.Exchange(code, common.SSOCallbackURL, st.Nonce)
+	if err != nil {
+		log.Warnf("failed to exchange authorization code with identity provider of SSO connection ID %d, err: %v", conn.ID, err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":             "invalid_grant",
+			"error_description": "could not log in with the identity provider",
+		})
+		return
+	}
+
+	u, created, err := conn.ProvisionUser(db, claims)
+	if err != nil {
+		if err == ssoconnection.ErrEmailNotVerified || err == ssoconnection.ErrEmailDomainDenied {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":             "access_denied",
+				"error_description": err.Error(),
+			})
+			return
+		}
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	// Users are confirmed when they are provisioned, but may have been locked
+	// or suspended since.
+	if err := u.CheckActive(); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":             "access_denied",
+			"error_description": err.Error(),
+			"pending_actions":   u.PendingActions(),
+		})
+		return
+	}
+
+	token := &oauthtoken.OauthToken{
+		UserID:        u.ID,
+		OauthClientID: client.ID,
+	}
+	if err := db.Create(token).Error; err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	{
+		var (
+			event = "User Logged In"
+			props = map[string]interface{}{
+				"oauthClientId":   client.ID,
+				"oauthClientName": client.Name,
+				"ssoConnectionId": conn.ID,
+				"ssoProvisioned":  created,
+			}
+			context = controllers.TrackingContext(c)
+		)
+		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
+			log.Errorf("failed to track %q event for user ID %d, err: %v",
+				event, u.ID, err)
+		}
+	}
+
+	if st.RedirectURI != "" {
+		c.Redirect(http.StatusFound, withParams(st.RedirectURI, url.Values{
+			"access_token": {token.Token},
+			"token_type":   {"bearer"},
+			"client_id":    {client.ClientID},
+		}))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"access_token": token.Token,
+		"token_type":   "bearer",
+		"client_id":    client.ClientID,
+	})
+}
+
+func setStateCookie(c *gin.Context, value string, maxAge int) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     StateCookie,
+		Value:    value,
+		Path:     "/sso",
+		MaxAge:   maxAge,
+		Secure:   strings.HasPrefix(common.SSOCallbackURL, "https://"),
+		HttpOnly: true,
+	})
+}
+
+func isLoopbackURL(rawurl string) bool {
+	u, err := url.Parse(rawurl)
+	if err != nil || u.Scheme != "http" || u.User != nil {
+		return false
+	}
+
+	host := u.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func withParams(rawurl string, params url.Values) string {
+	sep := "?"
+	if strings.Contains(rawurl, "?") {
+		sep = "&"
+	}
+	return rawurl + sep + params.Encode()
+}
@@ -0,0 +1,450 @@
+package sso_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/controllers/sso"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/oauthclient"
+	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/ssoconnection"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/apiserver/server"
+	"github.com/nitrous-io/rise-server/pkg/oidc"
+	"github.com/nitrous-io/rise-server/pkg/tracker"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+	"github.com/nitrous-io/rise-server/testhelper/fake"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "sso")
+}
+
+var _ = Describe("SSO", func() {
+	var (
+		db  *gorm.DB
+		s   *httptest.Server
+		res *http.Response
+		err error
+
+		idp  *fake.OIDCProvider
+		oc   *oauthclient.OauthClient
+		conn *ssoconnection.SSOConnection
+
+		client *http.Client
+
+		origHTTPClient *http.Client
+		origStatsToken string
+		fakeTracker    *fake.Tracker
+		origTracker    tracker.Trackable
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+
+		idp = fake.NewOIDCProvider()
+		origHTTPClient = oidc.DefaultHTTPClient
+		oidc.DefaultHTTPClient = idp.Client()
+
+		origStatsToken = common.StatsToken
+		common.StatsToken = "statssecret"
+
+		origTracker = common.Tracker
+		fakeTracker = &fake.Tracker{}
+		common.Tracker = fakeTracker
+
+		oc = factories.OauthClient(db)
+
+		conn = &ssoconnection.SSOConnection{
+			Organization:          "Hogwarts",
+			EmailDomain:           "hogwarts.edu",
+			Issuer:                idp.URL,
+			AuthorizationEndpoint: idp.URL + "/authorize",
+			TokenEndpoint:         idp.URL + "/token",
+			JWKSURI:               idp.URL + "/jwks",
+			ClientID:              "pubstorm",
+			ClientSecret:          "s3cr3t",
+		}
+		Expect(conn.EncryptClientSecret(common.AesKeyring())).To(BeNil())
+		Expect(conn.Insert(db)).To(BeNil())
+
+		// Redirects are not followed, so that they can be inspected.
+		client = &http.Client{
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+
+		s = httptest.NewServer(server.New())
+	})
+
+	AfterEach(func() {
+		if res != nil {
+			res.Body.Close()
+		}
+		s.Close()
+		idp.Close()
+
+		oidc.DefaultHTTPClient = origHTTPClient
+		common.StatsToken = origStatsToken
+		common.Tracker = origTracker
+	})
+
+	readBody := func() string {
+		b := &bytes.Buffer{}
+		_, err := b.ReadFrom(res.Body)
+		Expect(err).To(BeNil())
+		return b.String()
+	}
+
+	Describe("GET /admin/sso_connections", func() {
+		It("lists the connections", func() {
+			res, err = testhelper.MakeRequest("GET", s.URL+"/admin/sso_connections", url.Values{"token": {"statssecret"}}, nil, nil)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+			var j struct {
+				Connections []map[string]interface{} `json:"sso_connections"`
+			}
+			Expect(json.Unmarshal([]byte(readBody()), &j)).To(BeNil())
+			Expect(j.Connections).To(HaveLen(1))
+			Expect(j.Connections[0]["email_domain"]).To(Equal("hogwarts.edu"))
+			Expect(j.Connections[0]).NotTo(HaveKey("client_secret"))
+			Expect(j.Connections[0]).NotTo(HaveKey("encrypted_client_secret"))
+		})
+
+		It("returns 401 without a valid admin token", func() {
+			res, err = testhelper.MakeRequest("GET", s.URL+"/admin/sso_connections", url.Values{"token": {"wrong"}}, nil, nil)
+			Expect(err).To(BeNil())
+			Expect(res.StatusCode).To(Equal(http.StatusUnauthorized))
+		})
+	})
+
+	Describe("POST /admin/sso_connections", func() {
+		var params url.Values
+
+		BeforeEach(func() {
+			params = url.Values{
+				"organization":  {"Beauxbatons"},
+				"email_domain":  {"Beauxbatons.fr"},
+				"issuer":        {idp.URL},
+				"client_id":     {"pubstorm"},
+				"client_secret": {"s3cr3t"},
+			}
+		})
+
+		doRequest := func() {
+			res, err = testhelper.MakeRequest("POST", s.URL+"/admin/sso_connections?token=statssecret", params, nil, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("creates a connection with the endpoints of the identity provider", func() {
+			doRequest()
+
+			Expect(res.StatusCode).To(Equal(http.StatusCreated))
+
+			c, err := ssoconnection.FindByEmailDomain(db, "beauxbatons.fr")
+			Expect(err).To(BeNil())
+			Expect(c).NotTo(BeNil())
+			Expect(c.Organization).To(Equal("Beauxbatons"))
+			Expect(c.TokenEndpoint).To(Equal(idp.URL + "/token"))
+			Expect(c.JWKSURI).To(Equal(idp.URL + "/jwks"))
+
+			p, err := c.Provider(common.AesKeyring())
+			Expect(err).To(BeNil())
+			Expect(p.ClientSecret).To(Equal("s3cr3t"))
+		})
+
+		It("returns 422 if params are invalid", func() {
+			params.Set("issuer", "http://login.beauxbatons.fr")
+			doRequest()
+
+			Expect(res.StatusCode).To(Equal(422))
+			Expect(readBody()).To(MatchJSON(`{
+				"error": "invalid_params",
+				"errors": {
+					"issuer": "must be an https URL"
+				}
+			}`))
+		})
+
+		It("returns 422 if the identity provider cannot be discovered", func() {
+			params.Set("issuer", idp.URL+"/nowhere")
+			doRequest()
+
+			Expect(res.StatusCode).To(Equal(422))
+			Expect(readBody()).To(MatchJSON(`{
+				"error": "invalid_params",
+				"errors": {
+					"issuer": "could not be discovered"
+				}
+			}`))
+		})
+
+		It("returns 422 if the email domain already has a connection", func() {
+			params.Set("email_domain", "hogwarts.edu")
+			doRequest()
+
+			Expect(res.StatusCode).To(Equal(422))
+			Expect(readBody()).To(MatchJSON(`{
+				"error": "invalid_params",
+				"errors": {
+					"email_domain": "is taken"
+				}
+			}`))
+		})
+	})
+
+	Describe("DELETE /admin/sso_connections/:id", func() {
+		It("deletes the connection", func() {
+			res, err = testhelper.MakeRequest("DELETE", fmt.Sprintf("%s/admin/sso_connections/%d?token=statssecret", s.URL, conn.ID), nil, nil, nil)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+			c, err := ssoconnection.FindByID(db, conn.ID)
+			Expect(err).To(BeNil())
+			Expect(c).To(BeNil())
+		})
+
+		It("returns 404 if the connection does not exist", func() {
+			res, err = testhelper.MakeRequest("DELETE", fmt.Sprintf("%s/admin/sso_connections/%d?token=statssecret", s.URL, conn.ID+1), nil, nil, nil)
+			Expect(err).To(BeNil())
+			Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Describe("GET /sso/login", func() {
+		var params url.Values
+
+		BeforeEach(func() {
+			params = url.Values{
+				"email":     {"harry@hogwarts.edu"},
+				"client_id": {oc.ClientID},
+			}
+		})
+
+		doRequest := func() {
+			res, err = client.Get(s.URL + "/sso/login?" + params.Encode())
+			Expect(err).To(BeNil())
+		}
+
+		It("redirects to the identity provider of the email domain", func() {
+			doRequest()
+
+			Expect(res.StatusCode).To(Equal(http.StatusFound))
+
+			loc, err := url.Parse(res.Header.Get("Location"))
+			Expect(err).To(BeNil())
+			Expect(loc.Host).To(Equal(idp.Listener.Addr().String()))
+			Expect(loc.Path).To(Equal("/authorize"))
+			Expect(loc.Query().Get("client_id")).To(Equal("pubstorm"))
+			Expect(loc.Query().Get("redirect_uri")).To(Equal(common.SSOCallbackURL))
+			Expect(loc.Query().Get("nonce")).NotTo(BeEmpty())
+
+			state := loc.Query().Get("state")
+			Expect(state).NotTo(BeEmpty())
+			Expect(res.Cookies()).To(HaveLen(1))
+			Expect(res.Cookies()[0].Name).To(Equal(sso.StateCookie))
+			Expect(res.Cookies()[0].Value).To(Equal(state))
+			Expect(res.Cookies()[0].HttpOnly).To(BeTrue())
+		})
+
+		It("returns 404 if the email domain does not use single sign-on", func() {
+			params.Set("email", "fleur@beauxbatons.fr")
+			doRequest()
+
+			Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+			Expect(readBody()).To(MatchJSON(`{
+				"error": "not_found",
+				"error_description": "single sign-on is not enabled for this email address"
+			}`))
+		})
+
+		It("returns 401 if the client does not exist", func() {
+			params.Set("client_id", "nope")
+			doRequest()
+
+			Expect(res.StatusCode).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("returns 422 if the redirect uri is not a loopback URL", func() {
+			params.Set("redirect_uri", "https://evil.example.com/steal")
+			doRequest()
+
+			Expect(res.StatusCode).To(Equal(422))
+			Expect(readBody()).To(MatchJSON(`{
+				"error": "invalid_params",
+				"errors": {
+					"redirect_uri": "must be a loopback URL"
+				}
+			}`))
+		})
+	})
+
+	Describe("GET /sso/callback", func() {
+		var (
+			state string
+			nonce string
+		)
+
+		startLogin := func(redirectURI string) {
+			params := url.Values{
+				"email":     {"harry@hogwarts.edu"},
+				"client_id": {oc.ClientID},
+			}
+			if redirectURI != "" {
+				params.Set("redirect_uri", redirectURI)
+			}
+
+			r, err := client.Get(s.URL + "/sso/login?" + params.Encode())
+			Expect(err).To(BeNil())
+			r.Body.Close()
+			Expect(r.StatusCode).To(Equal(http.StatusFound))
+
+			loc, err := url.Parse(r.Header.Get("Location"))
+			Expect(err).To(BeNil())
+			state = loc.Query().Get("state")
+			nonce = loc.Query().Get("nonce")
+
+			idp.Claims = map[string]interface{}{
+				"iss":            idp.URL,
+				"sub":            "248289761001",
+				"aud":            "pubstorm",
+				"exp":            time.Now().Add(time.Hour).Unix(),
+				"nonce":          nonce,
+				"email":          "harry@hogwarts.edu",
+				"email_verified": true,
+				"name":           "Harry Potter",
+			}
+		}
+
+		doRequest := func(cookie string) {
+			req, err := http.NewRequest("GET", s.URL+"/sso/callback?"+url.Values{
+				"code":  {"the-code"},
+				"state": {state},
+			}.Encode(), nil)
+			Expect(err).To(BeNil())
+			req.AddCookie(&http.Cookie{Name: sso.StateCookie, Value: cookie})
+
+			res, err = client.Do(req)
+			Expect(err).To(BeNil())
+		}
+
+		It("provisions the user and returns an access token", func() {
+			startLogin("")
+			doRequest(state)
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+			var j struct {
+				AccessToken string `json:"access_token"`
+				TokenType   string `json:"token_type"`
+				ClientID    string `json:"client_id"`
+			}
+			Expect(json.Unmarshal([]byte(readBody()), &j)).To(BeNil())
+			Expect(j.TokenType).To(Equal("bearer"))
+			Expect(j.ClientID).To(Equal(oc.ClientID))
+
+			u, err := user.FindByEmail(db, "harry@hogwarts.edu")
+			Expect(err).To(BeNil())
+			Expect(u).NotTo(BeNil())
+			Expect(u.ConfirmedAt).NotTo(BeNil())
+
+			t, err := oauthtoken.FindByToken(db, j.AccessToken)
+			Expect(err).To(BeNil())
+			Expect(t).NotTo(BeNil())
+			Expect(t.UserID).To(Equal(u.ID))
+			Expect(t.OauthClientID).To(Equal(oc.ID))
+
+			Expect(idp.TokenParams).To(HaveLen(1))
+			Expect(idp.TokenParams[0]["redirect_uri"]).To(Equal(common.SSOCallbackURL))
+
+			trackCall := fakeTracker.TrackCalls.NthCall(1)
+			Expect(trackCall).NotTo(BeNil())
+			Expect(trackCall.Arguments[0]).To(Equal(fmt.Sprintf("%d", u.ID)))
+			Expect(trackCall.Arguments[1]).To(Equal("User Logged In"))
+
+			props, ok := trackCall.Arguments[3].(map[string]interface{})
+			Expect(ok).To(BeTrue())
+			Expect(props["ssoConnectionId"]).To(Equal(conn.ID))
+			Expect(props["ssoProvisioned"]).To(Equal(true))
+		})
+
+		It("redirects to the loopback redirect uri with the access token", func() {
+			startLogin("http://127.0.0.1:8585/callback")
+			doRequest(state)
+
+			Expect(res.StatusCode).To(Equal(http.StatusFound))
+
+			loc, err := url.Parse(res.Header.Get("Location"))
+			Expect(err).To(BeNil())
+			Expect(loc.Host).To(Equal("127.0.0.1:8585"))
+			Expect(loc.Path).To(Equal("/callback"))
+			Expect(loc.Query().Get("access_token")).NotTo(BeEmpty())
+			Expect(loc.Query().Get("client_id")).To(Equal(oc.ClientID))
+		})
+
+		It("returns 400 if the state does not match the cookie", func() {
+			startLogin("")
+			doRequest("another-state")
+
+			Expect(res.StatusCode).To(Equal(http.StatusBadRequest))
+			Expect(readBody()).To(MatchJSON(`{
+				"error": "invalid_request",
+				"error_description": "state is invalid"
+			}`))
+			Expect(idp.TokenParams).To(BeEmpty())
+		})
+
+		It("returns 400 if the state has been tampered with", func() {
+			startLogin("")
+			state = state[:len(state)-2] + "AA"
+			doRequest(state)
+
+			Expect(res.StatusCode).To(Equal(http.StatusBadRequest))
+			Expect(idp.TokenParams).To(BeEmpty())
+		})
+
+		It("returns 400 if the identity provider rejects the code", func() {
+			startLogin("")
+			idp.TokenStatus = http.StatusBadRequest
+			doRequest(state)
+
+			Expect(res.StatusCode).To(Equal(http.StatusBadRequest))
+			Expect(readBody()).To(MatchJSON(`{
+				"error": "invalid_grant",
+				"error_description": "could not log in with the identity provider"
+			}`))
+		})
+
+		It("returns 403 if the email address has not been verified", func() {
+			startLogin("")
+			idp.Claims["email_verified"] = false
+			doRequest(state)
+
+			Expect(res.StatusCode).To(Equal(http.StatusForbidden))
+
+			u, err := user.FindByEmail(db, "harry@hogwarts.edu")
+			Expect(err).To(BeNil())
+			Expect(u).To(BeNil())
+		})
+	})
+})
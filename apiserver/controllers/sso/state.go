@@ -0,0 +1,98 @@
+package sso
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/nitrous-io/rise-server/apiserver/common"
+)
+
+// StateTTL is how long users have to log in with their identity provider
+// after starting a single sign-on login.
+var StateTTL = 10 * time.Minute
+
+var (
+	errStateInvalid = errors.New("sso state is invalid")
+	errStateExpired = errors.New("sso state has expired")
+)
+
+// loginState is what is remembered about a login while the user is away at
+// their identity provider. It is passed to the identity provider as the
+// OAuth 2 state, signed so that it cannot be tampered with.
+type loginState struct {
+	ConnectionID uint   `json:"conn"`
+	ClientID     string `json:"client"`
+	RedirectURI  string `json:"redirect,omitempty"`
+	Nonce        string `json:"nonce"`
+	ExpiresAt    int64  `json:"exp"`
+}
+
+func newLoginState(connID uint, clientID, redirectURI string) (*loginState, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+
+	return &loginState{
+		ConnectionID: connID,
+		ClientID:     clientID,
+		RedirectURI:  redirectURI,
+		Nonce:        hex.EncodeToString(b),
+		ExpiresAt:    time.Now().Add(StateTTL).Unix(),
+	}, nil
+}
+
+// encode returns the state as a signed string.
+func (s *loginState) encode() (string, error) {
+	payload, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+
+	p := base64.RawURLEncoding.EncodeToString(payload)
+	return p + "." + base64.RawURLEncoding.EncodeToString(signState(p)), nil
+}
+
+// decodeLoginState verifies the signature and expiry of an encoded state.
+func decodeLoginState(encoded string) (*loginState, error) {
+	parts := strings.Split(encoded, ".")
+	if len(parts) != 2 {
+		return nil, errStateInvalid
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(sig, signState(parts[0])) {
+		return nil, errStateInvalid
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errStateInvalid
+	}
+
+	s := &loginState{}
+	if err := json.Unmarshal(payload, s); err != nil {
+		return nil, errStateInvalid
+	}
+
+	if time.Unix(s.ExpiresAt, 0).Before(time.Now()) {
+		return nil, errStateExpired
+	}
+	return s, nil
+}
+
+// signState signs a state payload with a key derived from the AES key, so that
+// a separate secret does not have to be configured.
+func signState(payload string) []byte {
+	key := sha256.Sum256([]byte("sso-state:" + common.AesKey))
+	mac := hmac.New(sha256.New, key[:])
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
@@ -7,20 +7,11 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/jinzhu/gorm"
-	"github.com/nitrous-io/rise-server/apiserver/common"
 	"github.com/nitrous-io/rise-server/apiserver/controllers"
 	"github.com/nitrous-io/rise-server/apiserver/stat"
 )
 
 func Index(c *gin.Context) {
-	if c.Query("token") != common.StatsToken {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"error":             "invalid_admin_token",
-			"error_description": "admin token is required",
-		})
-		return
-	}
-
 	filters := map[string]int64{}
 	requiredParams := []string{"project_id", "year", "month", "day"}
 	for _, requiredParam := range requiredParams {
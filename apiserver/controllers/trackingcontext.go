@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+)
+
+const (
+	TrackingContextKey = "tracking_context"
+
+	// RequestIDHeader is the header that the ID of a request is read from, if
+	// it was set by a proxy, and returned in.
+	RequestIDHeader = "X-Request-Id"
+
+	// maxRequestIDLength is the maximum length of a request ID that is read
+	// from a request.
+	maxRequestIDLength = 128
+)
+
+// TrackingContext returns the context that analytics events tracked by the
+// current request are sent with. It is built once per request by the
+// TrackingContext middleware, and a copy is returned so that it can be added
+// to. It is built on the fly if the middleware did not run.
+func TrackingContext(c *gin.Context) map[string]interface{} {
+	ti, _ := c.Get(TrackingContextKey)
+	tc, ok := ti.(map[string]interface{})
+	if !ok {
+		tc = NewTrackingContext(c)
+	}
+
+	cp := make(map[string]interface{}, len(tc))
+	for k, v := range tc {
+		cp[k] = v
+	}
+	return cp
+}
+
+// NewTrackingContext builds the tracking context of the current request from
+// its IP, user agent, request ID, the version of the command line client that
+// made it, if any, and the country of the client, if it is known.
+func NewTrackingContext(c *gin.Context) map[string]interface{} {
+	tc := map[string]interface{}{
+		"ip":         common.GetIP(c.Request),
+		"user_agent": c.Request.UserAgent(),
+		"request_id": RequestID(c),
+	}
+
+	if v := CLIVersion(c.Request.UserAgent()); v != "" {
+		tc["cli_version"] = v
+	}
+
+	for _, h := range common.GeoCountryHeaders {
+		if country := c.Request.Header.Get(h); country != "" {
+			tc["location"] = map[string]interface{}{
+				"country": country,
+			}
+			break
+		}
+	}
+
+	return tc
+}
+
+// RequestID returns the ID of the current request, which is read from the
+// request if it was set by a proxy, or generated otherwise. It is returned in
+// the X-Request-Id response header.
+func RequestID(c *gin.Context) string {
+	if id := c.Writer.Header().Get(RequestIDHeader); id != "" {
+		return id
+	}
+
+	id := c.Request.Header.Get(RequestIDHeader)
+	if id == "" || len(id) > maxRequestIDLength {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return ""
+		}
+		id = hex.EncodeToString(b)
+	}
+
+	c.Writer.Header().Set(RequestIDHeader, id)
+	return id
+}
+
+// CLIVersion returns the version of the command line client from its user
+// agent, e.g. "1.2.0" for "rise-cli/1.2.0 (darwin)", or an empty string for
+// other clients.
+func CLIVersion(userAgent string) string {
+	if !strings.HasPrefix(userAgent, CLIUserAgentPrefix) {
+		return ""
+	}
+
+	v := strings.TrimPrefix(userAgent, CLIUserAgentPrefix)
+	if i := strings.IndexByte(v, ' '); i != -1 {
+		v = v[:i]
+	}
+	return v
+}
@@ -0,0 +1,82 @@
+package users
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+)
+
+// Transition lets admins transition a user to another state, e.g. to suspend
+// them. Access tokens of users who are locked or suspended are revoked, so
+// that they are logged out.
+func Transition(c *gin.Context) {
+	event := c.PostForm("event")
+	if !user.IsValidEvent(event) {
+		c.JSON(422, gin.H{
+			"error": "invalid_params",
+			"errors": map[string]string{
+				"event": "must be one of " + strings.Join(user.Events, ", "),
+			},
+		})
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	u, err := user.FindByEmail(db, strings.TrimSpace(c.Param("email")))
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if u == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "not_found",
+			"error_description": "user could not be found",
+		})
+		return
+	}
+
+	prevState := u.State
+	if err := u.Transition(db, event); err != nil {
+		if err == user.ErrInvalidTransition {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":             "invalid_transition",
+				"error_description": `user cannot be transitioned with "` + event + `" when ` + prevState,
+			})
+			return
+		}
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if u.State == user.StateLocked || u.State == user.StateSuspended {
+		if err := db.Where("user_id = ?", u.ID).Delete(oauthtoken.OauthToken{}).Error; err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+	}
+
+	log.Infof("user %d was transitioned from %s to %s with %q", u.ID, prevState, u.State, event)
+
+	c.JSON(http.StatusOK, gin.H{
+		"user": struct {
+			ID             uint       `json:"id"`
+			Email          string     `json:"email"`
+			State          string     `json:"state"`
+			ConfirmedAt    *time.Time `json:"confirmed_at"`
+			PendingActions []string   `json:"pending_actions"`
+		}{u.ID, u.Email, u.State, u.ConfirmedAt, u.PendingActions()},
+	})
+}
@@ -14,6 +14,8 @@ import (
 	"github.com/nitrous-io/rise-server/apiserver/models/blacklistedemail"
 	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
 	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/shared/emails"
+	"github.com/nitrous-io/rise-server/shared/errcodes"
 )
 
 var TrackInterval = 5 * time.Second
@@ -46,6 +48,7 @@ func Create(c *gin.Context) {
 
 		c.JSON(422, gin.H{
 			"error": "invalid_params",
+			"code":  errcodes.EmailBlacklisted,
 			"errors": map[string]string{
 				"email": "is blacklisted",
 			},
@@ -64,6 +67,7 @@ func Create(c *gin.Context) {
 		if err == user.ErrEmailTaken {
 			c.JSON(422, gin.H{
 				"error": "invalid_params",
+				"code":  errcodes.EmailTaken,
 				"errors": map[string]string{
 					"email": "is taken",
 				},
@@ -84,36 +88,18 @@ func Create(c *gin.Context) {
 		return
 	}
 
-	go func() {
-		var (
-			anonymousID = c.PostForm("anonymous_id")
-			traits      = map[string]interface{}{
-				"email": u.Email,
-				"name":  u.Name,
-			}
-			event = "User Signed Up"
-			props = map[string]interface{}{
-				"email": u.Email,
-				"name":  u.Name,
-			}
-			context = map[string]interface{}{
-				"ip":         common.GetIP(c.Request),
-				"user_agent": c.Request.UserAgent(),
-			}
-		)
-
-		if err := common.Alias(strconv.Itoa(int(u.ID)), anonymousID); err != nil {
-			log.Errorf("failed to alias new user ID %d to anonymous ID %s, err: %v",
-				u.ID, anonymousID, err)
+	var (
+		anonymousID = c.PostForm("anonymous_id")
+		event       = "User Signed Up"
+		props       = map[string]interface{}{
+			"email": u.Email,
+			"name":  u.Name,
 		}
+		context = controllers.TrackingContext(c)
+	)
 
-		// Sleep 5 second to avoid race condition of identify/track and alias
-		// Read more at: https://segment.com/docs/integrations/mixpanel/#aliasing-server-side
-		time.Sleep(TrackInterval)
-
-		if err := common.Identify(strconv.Itoa(int(u.ID)), anonymousID, traits, context); err != nil {
-			log.Errorf("failed to track new user ID %d, err: %v", u.ID, err)
-		}
+	go func() {
+		identify(u, anonymousID, context)
 
 		if err := common.Track(strconv.Itoa(int(u.ID)), event, anonymousID, props, context); err != nil {
 			log.Errorf("failed to track %q event for user ID %d, err: %v",
@@ -147,6 +133,14 @@ func Confirm(c *gin.Context) {
 	email := c.PostForm("email")
 	confirmed, err := user.Confirm(db, email, c.PostForm("confirmation_code"))
 	if err != nil {
+		if err == user.ErrConfirmationCodeExpired {
+			c.JSON(422, gin.H{
+				"error":             "invalid_params",
+				"error_description": "confirmation_code has expired",
+				"confirmed":         false,
+			})
+			return
+		}
 		controllers.InternalServerError(c, err)
 		return
 	}
@@ -160,32 +154,22 @@ func Confirm(c *gin.Context) {
 		return
 	}
 
-	{
-		u, err := user.FindByEmail(db, email)
-		if err == nil {
-			var (
-				anonymousID = c.PostForm("anonymous_id")
-				event       = "Confirmed Email"
-				traits      = map[string]interface{}{
-					"email":       u.Email,
-					"name":        u.Name,
-					"confirmedAt": u.ConfirmedAt,
-				}
-				props   map[string]interface{}
-				context = map[string]interface{}{
-					"ip":         common.GetIP(c.Request),
-					"user_agent": c.Request.UserAgent(),
-				}
-			)
-			if err := common.Identify(strconv.Itoa(int(u.ID)), anonymousID, traits, context); err != nil {
-				log.Errorf("failed to update user identity for user ID %d, err: %v", u.ID, err)
-			}
+	if u, err := user.FindByEmail(db, email); err == nil {
+		var (
+			anonymousID = c.PostForm("anonymous_id")
+			event       = "Confirmed Email"
+			props       map[string]interface{}
+			context     = controllers.TrackingContext(c)
+		)
+
+		go func() {
+			identify(u, anonymousID, context)
 
 			if err := common.Track(strconv.Itoa(int(u.ID)), event, anonymousID, props, context); err != nil {
 				log.Errorf("failed to track %q event for user ID %d, err: %v",
 					event, u.ID, err)
 			}
-		}
+		}()
 	}
 
 	c.JSON(200, gin.H{
@@ -193,7 +177,47 @@ func Confirm(c *gin.Context) {
 	})
 }
 
+// identify links the anonymous ID that the user was tracked with before
+// signing up, if any, to the user, and identifies the user with their traits.
+func identify(u *user.User, anonymousID string, context map[string]interface{}) {
+	userID := strconv.Itoa(int(u.ID))
+
+	if anonymousID != "" {
+		if err := common.Alias(userID, anonymousID); err != nil {
+			log.Errorf("failed to alias user ID %d to anonymous ID %s, err: %v",
+				u.ID, anonymousID, err)
+		}
+
+		// Sleep 5 second to avoid race condition of identify/track and alias
+		// Read more at: https://segment.com/docs/integrations/mixpanel/#aliasing-server-side
+		time.Sleep(TrackInterval)
+	}
+
+	traits := map[string]interface{}{
+		"email":        u.Email,
+		"name":         u.Name,
+		"organization": u.Organization,
+		"createdAt":    u.CreatedAt,
+		"confirmedAt":  u.ConfirmedAt,
+	}
+	if err := common.Identify(userID, anonymousID, traits, context); err != nil {
+		log.Errorf("failed to update user identity for user ID %d, err: %v", u.ID, err)
+	}
+}
+
+// ResendConfirmationCode sends the user's confirmation code again. A new code
+// is generated if the current one has expired.
 func ResendConfirmationCode(c *gin.Context) {
+	sendConfirmationCode(c, false)
+}
+
+// RegenerateConfirmationCode generates a new confirmation code for the user
+// and sends it.
+func RegenerateConfirmationCode(c *gin.Context) {
+	sendConfirmationCode(c, true)
+}
+
+func sendConfirmationCode(c *gin.Context, regenerate bool) {
 	email := c.PostForm("email")
 	if email == "" {
 		c.JSON(422, gin.H{
@@ -225,6 +249,42 @@ func ResendConfirmationCode(c *gin.Context) {
 		return
 	}
 
+	ok, err := u.MarkConfirmationSent(db)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		return
+	}
+
+	if !ok {
+		t, err := u.ConfirmationThrottle(db)
+		if err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+
+		desc := "confirmation code was sent recently, please try again later"
+		if t.DailyLimitReached {
+			desc = "confirmation code has been sent too many times today, please try again later"
+		}
+
+		retryAfter := int64(t.RetryAfter / time.Second)
+		c.Header("Retry-After", strconv.FormatInt(retryAfter, 10))
+		c.JSON(429, gin.H{
+			"error":             "too_many_requests",
+			"error_description": desc,
+			"retry_after":       retryAfter,
+			"sent":              false,
+		})
+		return
+	}
+
+	if regenerate || u.ConfirmationCodeExpired() {
+		if err := u.RegenerateConfirmationCode(db); err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+	}
+
 	if err := sendConfirmationEmail(u); err != nil {
 		controllers.InternalServerError(c, err)
 		return
@@ -241,17 +301,28 @@ func Show(c *gin.Context) {
 	})
 }
 
+// Update changes the password of the current user, or their preferences, i.e.
+// timezone and locale. The existing password is only required to change the
+// password.
 func Update(c *gin.Context) {
 	currentUser := controllers.CurrentUser(c)
-	for _, k := range []string{"existing_password", "password"} {
-		if c.PostForm(k) == "" {
-			c.JSON(422, gin.H{
-				"error": "invalid_params",
-				"errors": map[string]string{
-					k: "is required",
-				},
-			})
-			return
+
+	timezone, timezoneGiven := c.GetPostForm("timezone")
+	locale, localeGiven := c.GetPostForm("locale")
+	prefsGiven := timezoneGiven || localeGiven
+	changePassword := !prefsGiven || c.PostForm("existing_password") != "" || c.PostForm("password") != ""
+
+	if changePassword {
+		for _, k := range []string{"existing_password", "password"} {
+			if c.PostForm(k) == "" {
+				c.JSON(422, gin.H{
+					"error": "invalid_params",
+					"errors": map[string]string{
+						k: "is required",
+					},
+				})
+				return
+			}
 		}
 	}
 
@@ -261,42 +332,61 @@ func Update(c *gin.Context) {
 		return
 	}
 
-	existingPassword := c.PostForm("existing_password")
-	password := c.PostForm("password")
+	u := currentUser
+	if changePassword {
+		existingPassword := c.PostForm("existing_password")
+		password := c.PostForm("password")
 
-	u, err := user.Authenticate(db, currentUser.Email, existingPassword)
-	if err != nil {
-		controllers.InternalServerError(c, err)
-		return
-	}
+		u, err = user.Authenticate(db, currentUser.Email, existingPassword)
+		if err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
 
-	if u == nil {
-		c.JSON(422, gin.H{
-			"error": "invalid_params",
-			"errors": map[string]string{
-				"existing_password": "is incorrect",
-			},
-		})
-		return
-	}
+		if u == nil {
+			c.JSON(422, gin.H{
+				"error": "invalid_params",
+				"errors": map[string]string{
+					"existing_password": "is incorrect",
+				},
+			})
+			return
+		}
 
-	if existingPassword == password {
-		c.JSON(422, gin.H{
-			"error": "invalid_params",
-			"errors": map[string]string{
-				"password": "cannot be the same as the existing password",
-			},
-		})
-		return
+		if existingPassword == password {
+			c.JSON(422, gin.H{
+				"error": "invalid_params",
+				"errors": map[string]string{
+					"password": "cannot be the same as the existing password",
+				},
+			})
+			return
+		}
+
+		u.Password = password
+		if errs := u.Validate(); errs != nil {
+			c.JSON(422, gin.H{
+				"error":  "invalid_params",
+				"errors": errs,
+			})
+			return
+		}
 	}
 
-	u.Password = password
-	if errs := u.Validate(); errs != nil {
-		c.JSON(422, gin.H{
-			"error":  "invalid_params",
-			"errors": errs,
-		})
-		return
+	if prefsGiven {
+		if timezoneGiven {
+			u.Timezone = timezone
+		}
+		if localeGiven {
+			u.Locale = locale
+		}
+		if errs := u.ValidatePreferences(); errs != nil {
+			c.JSON(422, gin.H{
+				"error":  "invalid_params",
+				"errors": errs,
+			})
+			return
+		}
 	}
 
 	tx := db.Begin()
@@ -306,14 +396,26 @@ func Update(c *gin.Context) {
 	}
 	defer tx.Rollback()
 
-	if err := u.SavePassword(tx); err != nil {
-		controllers.InternalServerError(c, err)
-		return
+	if changePassword {
+		if err := u.SavePassword(tx); err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
+
+		if err := tx.Where("user_id = ?", u.ID).Delete(oauthtoken.OauthToken{}).Error; err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
 	}
 
-	if err := tx.Where("user_id = ?", u.ID).Delete(oauthtoken.OauthToken{}).Error; err != nil {
-		controllers.InternalServerError(c, err)
-		return
+	if prefsGiven {
+		if err := tx.Model(u).Updates(map[string]interface{}{
+			"timezone": u.Timezone,
+			"locale":   u.Locale,
+		}).Error; err != nil {
+			controllers.InternalServerError(c, err)
+			return
+		}
 	}
 
 	if err := tx.Commit().Error; err != nil {
@@ -446,53 +548,13 @@ func ResetPassword(c *gin.Context) {
 }
 
 func sendConfirmationEmail(u *user.User) error {
-	subject := "Please confirm your PubStorm account email address"
-
-	txt := "Welcome to PubStorm!\n\n" +
-		"To complete sign up, please confirm your email address by entering the following confirmation code when logging in for the first time:\n\n" +
-		u.ConfirmationCode + "\n\n" +
-		"Thanks,\n" +
-		"PubStorm"
-
-	html := "<p>Welcome to PubStorm!</p>" +
-		"<p>To complete sign up, please confirm your email address by entering the following confirmation code when logging in for the first time:</p>" +
-		"<p><strong>" + u.ConfirmationCode + "</strong></p>" +
-		"<p>Thanks,<br />" +
-		"PubStorm</p>"
-
-	return common.SendMail(
-		[]string{u.Email}, // tos
-		nil,               // ccs
-		nil,               // bccs
-		subject,           // subject
-		txt,               // text body
-		html,              // html body
-	)
+	return common.SendTemplatedMail([]string{u.Email}, emails.Confirmation, &emails.ConfirmationData{
+		ConfirmationCode: u.ConfirmationCode,
+	})
 }
 
 func sendPasswordResetToken(u *user.User) error {
-	subject := "PubStorm password reset instructions"
-
-	txt := "Someone (hopefully you!) requested a password reset for your PubStorm account.\n\n" +
-		"To reset your password, please use the following code with the PubStorm CLI:\n\n" +
-		u.PasswordResetToken + "\n\n" +
-		"You can use `storm password.reset --continue` to enter this code." + "\n\n" +
-		"Thanks,\n" +
-		"PubStorm"
-
-	html := "<p>Someone (hopefully you!) requested a password reset for your PubStorm account.</p>" +
-		"<p>To reset your password, please use the following code with the PubStorm CLI:</p>" +
-		"<p><strong>" + u.PasswordResetToken + "</strong></p>" +
-		"<p>You can use <code>storm password.reset --continue</code> to enter this code.</p>" +
-		"<p>Thanks,<br />" +
-		"PubStorm</p>"
-
-	return common.SendMail(
-		[]string{u.Email}, // tos
-		nil,               // ccs
-		nil,               // bccs
-		subject,           // subject
-		txt,               // text body
-		html,              // html body
-	)
+	return common.SendTemplatedMail([]string{u.Email}, emails.PasswordReset, &emails.PasswordResetData{
+		PasswordResetToken: u.PasswordResetToken,
+	})
 }
@@ -120,7 +120,9 @@ var _ = Describe("Users", func() {
 					"user": {
 						"email": "foo@example.com",
 						"name": "",
-						"organization": ""
+						"organization": "",
+						"timezone": "UTC",
+						"locale": "en"
 					}
 				}`))
 			})
@@ -159,6 +161,9 @@ var _ = Describe("Users", func() {
 				traits, ok := i.(map[string]interface{})
 				Expect(ok).To(BeTrue())
 				Expect(traits["email"]).To(Equal(u.Email))
+				Expect(traits["name"]).To(Equal(u.Name))
+				Expect(traits["organization"]).To(Equal(u.Organization))
+				Expect(traits["createdAt"]).To(BeTemporally("~", u.CreatedAt, time.Second))
 
 				ic := identifyCall.Arguments[3]
 				context, ok := ic.(map[string]interface{})
@@ -195,7 +200,24 @@ var _ = Describe("Users", func() {
 
 				Expect(trackCall.ReturnValues[0]).To(BeNil())
 			})
+		})
 
+		Context("when anonymous_id is not provided", func() {
+			BeforeEach(func() {
+				params.Del("anonymous_id")
+			})
+
+			It("identifies the new user without aliasing", func() {
+				doRequest()
+				Expect(res.StatusCode).To(Equal(http.StatusCreated))
+
+				Eventually(func() int { return fakeTracker.TrackCalls.Count() }).Should(Equal(1))
+				Expect(fakeTracker.AliasCalls.Count()).To(Equal(0))
+
+				identifyCall := fakeTracker.IdentifyCalls.NthCall(1)
+				Expect(identifyCall).NotTo(BeNil())
+				Expect(identifyCall.Arguments[1]).To(Equal(""))
+			})
 		})
 
 		Context("when email addresss contains uppercase characters", func() {
@@ -263,6 +285,7 @@ var _ = Describe("Users", func() {
 				Expect(err).To(BeNil())
 			}, `{
 				"error": "invalid_params",
+				"code": "email_taken",
 				"errors": {
 					"email": "is taken"
 				}
@@ -275,6 +298,7 @@ var _ = Describe("Users", func() {
 				params.Set("email", "FOO@example.com")
 			}, `{
 				"error": "invalid_params",
+				"code": "email_taken",
 				"errors": {
 					"email": "is taken"
 				}
@@ -283,6 +307,7 @@ var _ = Describe("Users", func() {
 				factories.BlacklistedEmail(db, "example.com")
 			}, `{
 				"error": "invalid_params",
+				"code": "email_blacklisted",
 				"errors": {
 					"email": "is blacklisted"
 				}
@@ -297,6 +322,8 @@ var _ = Describe("Users", func() {
 
 			fakeTracker *fake.Tracker
 			origTracker tracker.Trackable
+
+			origTrackInterval time.Duration
 		)
 
 		BeforeEach(func() {
@@ -315,10 +342,14 @@ var _ = Describe("Users", func() {
 			origTracker = common.Tracker
 			fakeTracker = &fake.Tracker{}
 			common.Tracker = fakeTracker
+
+			origTrackInterval = users.TrackInterval
+			users.TrackInterval = 0 * time.Second
 		})
 
 		AfterEach(func() {
 			common.Tracker = origTracker
+			users.TrackInterval = origTrackInterval
 		})
 
 		doRequest := func() {
@@ -365,6 +396,11 @@ var _ = Describe("Users", func() {
 				Entry("validate confirmation code", func() {
 					params.Set("confirmation_code", u.ConfirmationCode+"x")
 				}, "invalid email or confirmation_code"),
+
+				Entry("validate confirmation code has not expired", func() {
+					err := db.Exec("UPDATE users SET confirmation_code_created_at = now() - interval '25 hours' WHERE id = ?", u.ID).Error
+					Expect(err).To(BeNil())
+				}, "confirmation_code has expired"),
 			)
 		})
 
@@ -391,6 +427,7 @@ var _ = Describe("Users", func() {
 			It("tracks a 'Confirmed Email' event", func() {
 				doRequest()
 
+				Eventually(func() int { return fakeTracker.TrackCalls.Count() }).Should(Equal(1))
 				trackCall := fakeTracker.TrackCalls.NthCall(1)
 				Expect(trackCall).NotTo(BeNil())
 				Expect(trackCall.Arguments[0]).To(Equal(fmt.Sprintf("%d", u.ID)))
@@ -406,6 +443,44 @@ var _ = Describe("Users", func() {
 
 				Expect(trackCall.ReturnValues[0]).To(BeNil())
 			})
+
+			It("aliases the anonymous ID to the user and identifies the user", func() {
+				doRequest()
+
+				Eventually(func() int { return fakeTracker.TrackCalls.Count() }).Should(Equal(1))
+				Expect(db.First(u, u.ID).Error).To(BeNil())
+
+				aliasCall := fakeTracker.AliasCalls.NthCall(1)
+				Expect(aliasCall).NotTo(BeNil())
+				Expect(aliasCall.Arguments[0]).To(Equal(fmt.Sprintf("%d", u.ID)))
+				Expect(aliasCall.Arguments[1]).To(Equal("anonyid"))
+
+				identifyCall := fakeTracker.IdentifyCalls.NthCall(1)
+				Expect(identifyCall).NotTo(BeNil())
+				Expect(identifyCall.Arguments[0]).To(Equal(fmt.Sprintf("%d", u.ID)))
+				Expect(identifyCall.Arguments[1]).To(Equal("anonyid"))
+
+				traits, ok := identifyCall.Arguments[2].(map[string]interface{})
+				Expect(ok).To(BeTrue())
+				Expect(traits["email"]).To(Equal(u.Email))
+				Expect(traits["organization"]).To(Equal(u.Organization))
+				Expect(traits["createdAt"]).To(BeTemporally("~", u.CreatedAt, time.Second))
+				Expect(*traits["confirmedAt"].(*time.Time)).To(BeTemporally("~", *u.ConfirmedAt, time.Second))
+			})
+
+			Context("when anonymous_id is not provided", func() {
+				BeforeEach(func() {
+					params.Del("anonymous_id")
+				})
+
+				It("does not alias the user", func() {
+					doRequest()
+
+					Eventually(func() int { return fakeTracker.TrackCalls.Count() }).Should(Equal(1))
+					Expect(fakeTracker.AliasCalls.Count()).To(Equal(0))
+					Expect(fakeTracker.IdentifyCalls.Count()).To(Equal(1))
+				})
+			})
 		})
 	})
 
@@ -528,6 +603,91 @@ var _ = Describe("Users", func() {
 						Expect(fakeMailer.SendMailCalled).To(BeFalse())
 					})
 				})
+
+				Context("when the confirmation code was sent recently", func() {
+					BeforeEach(func() {
+						doRequest(params)
+						Expect(res.StatusCode).To(Equal(http.StatusOK))
+						fakeMailer.Reset()
+					})
+
+					It("returns 429 and does not send an email", func() {
+						doRequest(params)
+						b := &bytes.Buffer{}
+						_, err := b.ReadFrom(res.Body)
+						Expect(err).To(BeNil())
+
+						Expect(res.StatusCode).To(Equal(429))
+						Expect(res.Header.Get("Retry-After")).To(Equal("60"))
+						Expect(b.String()).To(MatchJSON(`{
+							"error": "too_many_requests",
+							"error_description": "confirmation code was sent recently, please try again later",
+							"retry_after": 60,
+							"sent": false
+						}`))
+						Expect(fakeMailer.SendMailCalled).To(BeFalse())
+					})
+				})
+
+				Context("when the confirmation code has been sent too many times today", func() {
+					BeforeEach(func() {
+						err := db.Exec(`UPDATE users SET
+							confirmation_sent_at = now() - interval '2 minutes',
+							confirmation_send_count = ?,
+							confirmation_send_count_since = now() - interval '23 hours'
+							WHERE id = ?`, user.MaxConfirmationSendsPerDay, u.ID).Error
+						Expect(err).To(BeNil())
+					})
+
+					It("returns 429 with when it can be sent again, and does not send an email", func() {
+						doRequest(params)
+						b := &bytes.Buffer{}
+						_, err := b.ReadFrom(res.Body)
+						Expect(err).To(BeNil())
+
+						Expect(res.StatusCode).To(Equal(429))
+						Expect(res.Header.Get("Retry-After")).To(Equal("3600"))
+						Expect(b.String()).To(MatchJSON(`{
+							"error": "too_many_requests",
+							"error_description": "confirmation code has been sent too many times today, please try again later",
+							"retry_after": 3600,
+							"sent": false
+						}`))
+						Expect(fakeMailer.SendMailCalled).To(BeFalse())
+					})
+
+					Context("when a day has passed", func() {
+						BeforeEach(func() {
+							err := db.Exec("UPDATE users SET confirmation_send_count_since = now() - interval '25 hours' WHERE id = ?", u.ID).Error
+							Expect(err).To(BeNil())
+						})
+
+						It("sends the confirmation code", func() {
+							doRequest(params)
+							Expect(res.StatusCode).To(Equal(http.StatusOK))
+							Expect(fakeMailer.SendMailCalled).To(BeTrue())
+						})
+					})
+				})
+
+				Context("when the confirmation code has expired", func() {
+					BeforeEach(func() {
+						err := db.Exec("UPDATE users SET confirmation_code = 'old', confirmation_code_created_at = now() - interval '25 hours' WHERE id = ?", u.ID).Error
+						Expect(err).To(BeNil())
+					})
+
+					It("sends a newly generated confirmation code", func() {
+						doRequest(params)
+						Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+						Expect(db.First(u, u.ID).Error).To(BeNil())
+						Expect(u.ConfirmationCode).NotTo(Equal("old"))
+						Expect(u.ConfirmationCodeExpired()).To(BeFalse())
+
+						Expect(fakeMailer.SendMailCalled).To(BeTrue())
+						Expect(fakeMailer.Body).To(ContainSubstring(u.ConfirmationCode))
+					})
+				})
 			})
 
 			Context("when user does not exist", func() {
@@ -553,6 +713,98 @@ var _ = Describe("Users", func() {
 		})
 	})
 
+	Describe("POST /user/confirm/regenerate", func() {
+		var (
+			fakeMailer *fake.Mailer
+			origMailer mailer.Mailer
+
+			u      *user.User
+			params url.Values
+		)
+
+		BeforeEach(func() {
+			origMailer = common.Mailer
+			fakeMailer = &fake.Mailer{}
+			common.Mailer = fakeMailer
+
+			u = &user.User{Email: "foo@example.com", Password: "foobar"}
+			err = u.Insert(db)
+			Expect(err).To(BeNil())
+
+			params = url.Values{
+				"email": {"foo@example.com"},
+			}
+		})
+
+		AfterEach(func() {
+			common.Mailer = origMailer
+		})
+
+		doRequest := func() {
+			s = httptest.NewServer(server.New())
+			res, err = http.PostForm(s.URL+"/user/confirm/regenerate", params)
+			Expect(err).To(BeNil())
+		}
+
+		It("generates a new confirmation code and sends it to user", func() {
+			err := db.Exec("UPDATE users SET confirmation_code_created_at = now() - interval '1 hour' WHERE id = ?", u.ID).Error
+			Expect(err).To(BeNil())
+
+			doRequest()
+
+			b := &bytes.Buffer{}
+			_, err = b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(MatchJSON(`{
+				"sent": true
+			}`))
+
+			Expect(db.First(u, u.ID).Error).To(BeNil())
+			Expect(time.Since(u.ConfirmationCodeCreatedAt)).To(BeNumerically("<", time.Minute))
+			Expect(u.ConfirmationSentAt).NotTo(BeNil())
+
+			Expect(fakeMailer.SendMailCalled).To(BeTrue())
+			Expect(fakeMailer.Tos).To(Equal([]string{"foo@example.com"}))
+			Expect(fakeMailer.Body).To(ContainSubstring(u.ConfirmationCode))
+
+			confirmed, err := user.Confirm(db, u.Email, u.ConfirmationCode)
+			Expect(err).To(BeNil())
+			Expect(confirmed).To(BeTrue())
+		})
+
+		Context("when the confirmation code was sent recently", func() {
+			BeforeEach(func() {
+				ok, err := u.MarkConfirmationSent(db)
+				Expect(err).To(BeNil())
+				Expect(ok).To(BeTrue())
+			})
+
+			It("returns 429 and does not send an email", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(429))
+				Expect(fakeMailer.SendMailCalled).To(BeFalse())
+			})
+		})
+
+		Context("when the user is already confirmed", func() {
+			BeforeEach(func() {
+				confirmed, err := user.Confirm(db, u.Email, u.ConfirmationCode)
+				Expect(confirmed).To(BeTrue())
+				Expect(err).To(BeNil())
+			})
+
+			It("returns 422 and does not send an email", func() {
+				doRequest()
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(fakeMailer.SendMailCalled).To(BeFalse())
+			})
+		})
+	})
+
 	Describe("GET /user", func() {
 		var (
 			u       *user.User
@@ -586,7 +838,9 @@ var _ = Describe("Users", func() {
 				"user": {
 					"email": "` + u.Email + `",
 					"name": "` + u.Name + `",
-					"organization": "` + u.Organization + `"
+					"organization": "` + u.Organization + `",
+					"timezone": "UTC",
+					"locale": "en"
 				}
 			}`))
 		})
@@ -611,6 +865,7 @@ var _ = Describe("Users", func() {
 				Expect(res.StatusCode).To(Equal(http.StatusUnauthorized))
 				Expect(b.String()).To(MatchJSON(`{
 					"error": "invalid_token",
+					"code": "token_invalid",
 					"error_description": "access token is invalid"
 				}`))
 			})
@@ -742,7 +997,9 @@ var _ = Describe("Users", func() {
 				"user": {
 					"email": "` + u.Email + `",
 					"name": "",
-					"organization": ""
+					"organization": "",
+					"timezone": "UTC",
+					"locale": "en"
 				}
 			}`))
 
@@ -768,6 +1025,70 @@ var _ = Describe("Users", func() {
 			Expect(db.First(&currentToken, t1.ID).Error).To(Equal(gorm.RecordNotFound))
 			Expect(db.First(&currentToken, t2.ID).Error).To(BeNil())
 		})
+
+		Context("when only preferences are given", func() {
+			BeforeEach(func() {
+				params = url.Values{
+					"timezone": {"Asia/Singapore"},
+					"locale":   {"en-GB"},
+				}
+			})
+
+			It("returns 200 OK and updates the preferences without changing the password", func() {
+				doRequest()
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(b.String()).To(MatchJSON(`{
+					"user": {
+						"email": "` + u.Email + `",
+						"name": "",
+						"organization": "",
+						"timezone": "Asia/Singapore",
+						"locale": "en-GB"
+					}
+				}`))
+
+				Expect(db.First(u, u.ID).Error).To(BeNil())
+				Expect(u.Timezone).To(Equal("Asia/Singapore"))
+				Expect(u.Locale).To(Equal("en-GB"))
+
+				existingUser, err := user.Authenticate(db, u.Email, existingPassword)
+				Expect(err).To(BeNil())
+				Expect(existingUser.ID).To(Equal(u.ID))
+
+				var currentToken oauthtoken.OauthToken
+				Expect(db.First(&currentToken, t.ID).Error).To(BeNil())
+			})
+
+			DescribeTable("it returns 422 and does not update preferences",
+				func(key, value, message string) {
+					params.Set(key, value)
+					doRequest()
+
+					b := &bytes.Buffer{}
+					_, err := b.ReadFrom(res.Body)
+					Expect(err).To(BeNil())
+
+					Expect(res.StatusCode).To(Equal(422))
+					Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+						"error": "invalid_params",
+						"errors": { %q: %q }
+					}`, key, message)))
+
+					Expect(db.First(u, u.ID).Error).To(BeNil())
+					Expect(u.Timezone).To(Equal("UTC"))
+					Expect(u.Locale).To(Equal("en"))
+				},
+
+				Entry("unknown timezone", "timezone", "Mars/Olympus_Mons", "is not a known time zone"),
+				Entry("empty timezone", "timezone", "", "is not a known time zone"),
+				Entry("unsupported locale", "locale", "xx", "must be one of en, en-GB, en-US"),
+			)
+		})
 	})
 
 	Describe("POST /user/password/forgot", func() {
@@ -1057,4 +1378,111 @@ var _ = Describe("Users", func() {
 			})
 		})
 	})
+
+	Describe("POST /admin/users/:email/transitions", func() {
+		var (
+			u            *user.User
+			t            *oauthtoken.OauthToken
+			params       url.Values
+			origStatsTok string
+		)
+
+		BeforeEach(func() {
+			origStatsTok = common.StatsToken
+			common.StatsToken = "statssecret"
+
+			u, _, t = factories.AuthTrio(db)
+			params = url.Values{"event": {user.EventSuspend}}
+		})
+
+		AfterEach(func() {
+			common.StatsToken = origStatsTok
+		})
+
+		doRequest := func(token string) {
+			s = httptest.NewServer(server.New())
+			res, err = testhelper.MakeRequest("POST", s.URL+"/admin/users/"+url.QueryEscape(u.Email)+"/transitions?token="+token, params, nil, nil)
+			Expect(err).To(BeNil())
+		}
+
+		It("transitions the user and revokes their access tokens", func() {
+			doRequest("statssecret")
+
+			b := &bytes.Buffer{}
+			_, err := b.ReadFrom(res.Body)
+			Expect(err).To(BeNil())
+
+			Expect(db.First(u, u.ID).Error).To(BeNil())
+			Expect(u.State).To(Equal(user.StateSuspended))
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+				"user": {
+					"id": %d,
+					"email": %q,
+					"state": "suspended",
+					"confirmed_at": %q,
+					"pending_actions": ["contact_support"]
+				}
+			}`, u.ID, u.Email, u.ConfirmedAt.Format(time.RFC3339Nano))))
+
+			found, err := oauthtoken.FindByToken(db, t.Token)
+			Expect(err).To(BeNil())
+			Expect(found).To(BeNil())
+		})
+
+		It("returns 401 without the admin token", func() {
+			doRequest("wrong")
+
+			Expect(res.StatusCode).To(Equal(http.StatusUnauthorized))
+			Expect(db.First(u, u.ID).Error).To(BeNil())
+			Expect(u.State).To(Equal(user.StateConfirmed))
+		})
+
+		Context("when the event is not valid in the user's state", func() {
+			BeforeEach(func() {
+				params.Set("event", user.EventReinstate)
+			})
+
+			It("returns 409 conflict", func() {
+				doRequest("statssecret")
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(http.StatusConflict))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_transition",
+					"error_description": "user cannot be transitioned with \"reinstate\" when confirmed"
+				}`))
+
+				found, err := oauthtoken.FindByToken(db, t.Token)
+				Expect(err).To(BeNil())
+				Expect(found).NotTo(BeNil())
+			})
+		})
+
+		Context("when the event is unknown", func() {
+			BeforeEach(func() {
+				params.Set("event", "delete")
+			})
+
+			It("returns 422 unprocessable entity", func() {
+				doRequest("statssecret")
+
+				b := &bytes.Buffer{}
+				_, err := b.ReadFrom(res.Body)
+				Expect(err).To(BeNil())
+
+				Expect(res.StatusCode).To(Equal(422))
+				Expect(b.String()).To(MatchJSON(`{
+					"error": "invalid_params",
+					"errors": {
+						"event": "must be one of confirm, lock, unlock, suspend, reinstate"
+					}
+				}`))
+			})
+		})
+	})
 })
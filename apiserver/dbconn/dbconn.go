@@ -1,24 +1,49 @@
 package dbconn
 
 import (
+	"fmt"
+	"net/url"
 	"os"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/jinzhu/gorm"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+	"golang.org/x/net/context"
 )
 
 var (
 	db     *gorm.DB
 	dbLock sync.Mutex
+
+	// StatementTimeout is the maximum duration of any query run on a
+	// connection. Queries that run longer are aborted by Postgres. Zero means
+	// no limit. It can be set with POSTGRES_STATEMENT_TIMEOUT.
+	StatementTimeout = 30 * time.Second
+
+	// MaxTxAttempts is how many times Transaction runs a transaction that
+	// fails because of a serialization failure or a deadlock.
+	MaxTxAttempts = 3
+	// TxRetryDelay is how long Transaction waits before retrying a
+	// transaction, multiplied by the number of attempts so far.
+	TxRetryDelay = 50 * time.Millisecond
 )
 
+func init() {
+	if timeout := os.Getenv("POSTGRES_STATEMENT_TIMEOUT"); timeout != "" {
+		if d, err := time.ParseDuration(timeout); err == nil {
+			StatementTimeout = d
+		}
+	}
+}
+
 // DB returns gorm DB handle
 func DB() (*gorm.DB, error) {
 	dbLock.Lock()
 	defer dbLock.Unlock()
 	if db == nil {
-		d, err := gorm.Open("postgres", os.Getenv("POSTGRES_URL"))
+		d, err := gorm.Open("postgres", withStatementTimeout(os.Getenv("POSTGRES_URL"), StatementTimeout))
 		if err != nil {
 			return nil, err
 		}
@@ -29,3 +54,121 @@ func DB() (*gorm.DB, error) {
 	}
 	return db, nil
 }
+
+// Begin starts a transaction whose queries are aborted by Postgres once the
+// deadline of ctx has passed. Models take the returned handle like any other
+// *gorm.DB, which is how a request or job deadline reaches the queries they
+// run. The caller must commit or roll back the transaction.
+func Begin(ctx context.Context) (*gorm.DB, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	db, err := DB()
+	if err != nil {
+		return nil, err
+	}
+
+	tx := db.Begin()
+	if err := tx.Error; err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		remaining := deadline.Sub(time.Now())
+		if remaining <= 0 {
+			tx.Rollback()
+			return nil, context.DeadlineExceeded
+		}
+
+		if err := tx.Exec(fmt.Sprintf("SET LOCAL statement_timeout = %d", millis(remaining))).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	return tx, nil
+}
+
+// Transaction runs fn in a transaction started with Begin, and commits it if
+// fn returns nil. If fn or the commit fails because of a serialization failure
+// or a deadlock with a concurrent transaction, e.g. collaborators changing the
+// same project at once, the transaction is retried up to MaxTxAttempts times.
+// fn may therefore be run more than once, so it must not change state outside
+// the transaction, and must reset any changes it makes to the models it is
+// given before it saves them. The error of the last attempt is returned.
+func Transaction(ctx context.Context, fn func(tx *gorm.DB) error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = runTransaction(ctx, fn)
+		if err == nil || !IsRetryable(err) || attempt >= MaxTxAttempts {
+			return err
+		}
+
+		select {
+		case <-time.After(time.Duration(attempt) * TxRetryDelay):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+func runTransaction(ctx context.Context, fn func(tx *gorm.DB) error) error {
+	tx, err := Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// IsRetryable returns whether err is a Postgres serialization failure or
+// deadlock, i.e. whether the transaction it aborted would likely succeed if
+// it were run again.
+func IsRetryable(err error) bool {
+	e, ok := err.(*pq.Error)
+	if !ok {
+		return false
+	}
+
+	switch e.Code.Name() {
+	case "serialization_failure", "deadlock_detected":
+		return true
+	}
+	return false
+}
+
+// withStatementTimeout adds the statement_timeout run-time parameter to a
+// Postgres connection string, which can either be a URL or a list of
+// key=value pairs. An existing statement_timeout is left untouched.
+func withStatementTimeout(connStr string, timeout time.Duration) string {
+	if timeout <= 0 || strings.Contains(connStr, "statement_timeout") {
+		return connStr
+	}
+
+	ms := fmt.Sprintf("%d", millis(timeout))
+
+	if strings.HasPrefix(connStr, "postgres://") || strings.HasPrefix(connStr, "postgresql://") {
+		u, err := url.Parse(connStr)
+		if err != nil {
+			return connStr
+		}
+		q := u.Query()
+		q.Set("statement_timeout", ms)
+		u.RawQuery = q.Encode()
+		return u.String()
+	}
+
+	return strings.TrimSpace(connStr + " statement_timeout=" + ms)
+}
+
+// millis returns d in whole milliseconds, rounded up so that a positive
+// duration never becomes 0, which Postgres treats as no limit.
+func millis(d time.Duration) int64 {
+	return int64((d + time.Millisecond - 1) / time.Millisecond)
+}
@@ -0,0 +1,160 @@
+package dbconn
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+	"golang.org/x/net/context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "dbconn")
+}
+
+var _ = Describe("DBConn", func() {
+	Describe("withStatementTimeout()", func() {
+		It("adds statement_timeout to the query string of a URL", func() {
+			Expect(withStatementTimeout("postgres://u:p@localhost/rise_test?sslmode=disable", 30*time.Second)).To(Equal(
+				"postgres://u:p@localhost/rise_test?sslmode=disable&statement_timeout=30000"))
+			Expect(withStatementTimeout("postgres:///rise_test", 1500*time.Millisecond)).To(Equal(
+				"postgres:///rise_test?statement_timeout=1500"))
+		})
+
+		It("appends statement_timeout to a key=value connection string", func() {
+			Expect(withStatementTimeout("dbname=rise_test sslmode=disable", time.Minute)).To(Equal(
+				"dbname=rise_test sslmode=disable statement_timeout=60000"))
+		})
+
+		It("does not change the connection string if the timeout is zero", func() {
+			Expect(withStatementTimeout("dbname=rise_test", 0)).To(Equal("dbname=rise_test"))
+		})
+
+		It("does not override an existing statement_timeout", func() {
+			Expect(withStatementTimeout("postgres:///rise_test?statement_timeout=100", time.Minute)).To(Equal(
+				"postgres:///rise_test?statement_timeout=100"))
+		})
+	})
+
+	Describe("Begin()", func() {
+		BeforeEach(func() {
+			_, err := DB()
+			Expect(err).To(BeNil())
+		})
+
+		It("aborts queries that run past the deadline of the context", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+
+			tx, err := Begin(ctx)
+			Expect(err).To(BeNil())
+			defer tx.Rollback()
+
+			err = tx.Exec("SELECT pg_sleep(1)").Error
+			Expect(err).NotTo(BeNil())
+			Expect(err.Error()).To(ContainSubstring("statement timeout"))
+		})
+
+		It("returns an error if the context is already done", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			tx, err := Begin(ctx)
+			Expect(err).To(Equal(context.Canceled))
+			Expect(tx).To(BeNil())
+		})
+
+		It("does not set a statement timeout when the context has no deadline", func() {
+			tx, err := Begin(context.Background())
+			Expect(err).To(BeNil())
+			defer tx.Rollback()
+
+			Expect(tx.Exec("SELECT pg_sleep(0.01)").Error).To(BeNil())
+		})
+	})
+
+	Describe("Transaction()", func() {
+		var (
+			origTxRetryDelay time.Duration
+			serializationErr = &pq.Error{Code: "40001"}
+		)
+
+		BeforeEach(func() {
+			_, err := DB()
+			Expect(err).To(BeNil())
+
+			origTxRetryDelay = TxRetryDelay
+			TxRetryDelay = time.Millisecond
+		})
+
+		AfterEach(func() {
+			TxRetryDelay = origTxRetryDelay
+		})
+
+		It("retries the transaction on serialization failures", func() {
+			attempts := 0
+			err := Transaction(context.Background(), func(tx *gorm.DB) error {
+				attempts++
+				if attempts < MaxTxAttempts {
+					return serializationErr
+				}
+				return tx.Exec("SELECT 1").Error
+			})
+			Expect(err).To(BeNil())
+			Expect(attempts).To(Equal(MaxTxAttempts))
+		})
+
+		It("gives up after MaxTxAttempts attempts", func() {
+			attempts := 0
+			err := Transaction(context.Background(), func(tx *gorm.DB) error {
+				attempts++
+				return &pq.Error{Code: "40P01"}
+			})
+			Expect(err).To(Equal(&pq.Error{Code: "40P01"}))
+			Expect(attempts).To(Equal(MaxTxAttempts))
+		})
+
+		It("does not retry other errors", func() {
+			errFoo := errors.New("foo")
+			attempts := 0
+			err := Transaction(context.Background(), func(tx *gorm.DB) error {
+				attempts++
+				return errFoo
+			})
+			Expect(err).To(Equal(errFoo))
+			Expect(attempts).To(Equal(1))
+		})
+
+		It("stops retrying once the context is done", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+
+			attempts := 0
+			err := Transaction(ctx, func(tx *gorm.DB) error {
+				attempts++
+				cancel()
+				return serializationErr
+			})
+			Expect(err).To(Equal(serializationErr))
+			Expect(attempts).To(Equal(1))
+		})
+	})
+
+	Describe("IsRetryable()", func() {
+		It("returns true for serialization failures and deadlocks", func() {
+			Expect(IsRetryable(&pq.Error{Code: "40001"})).To(BeTrue())
+			Expect(IsRetryable(&pq.Error{Code: "40P01"})).To(BeTrue())
+		})
+
+		It("returns false for other errors", func() {
+			Expect(IsRetryable(&pq.Error{Code: "23505"})).To(BeFalse())
+			Expect(IsRetryable(errors.New("serialization_failure"))).To(BeFalse())
+			Expect(IsRetryable(nil)).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,209 @@
+package deploywatch
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/lib/pq"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/pkg/job"
+	"github.com/nitrous-io/rise-server/pkg/mqconn"
+	"github.com/nitrous-io/rise-server/shared/exchanges"
+	"github.com/nitrous-io/rise-server/shared/messages"
+)
+
+// Reconnect intervals of the listener connection.
+const (
+	minReconnectInterval = time.Second
+	maxReconnectInterval = time.Minute
+)
+
+// Default is the watcher that the API server's LISTEN connection feeds.
+var Default = New()
+
+// Watcher fans out deployment state changes to the requests that wait for
+// them, so that each API server needs a single LISTEN connection rather than
+// one per request.
+type Watcher struct {
+	mu   sync.Mutex
+	subs map[uint]map[chan *deployment.StateChange]struct{}
+}
+
+// New returns a new Watcher.
+func New() *Watcher {
+	return &Watcher{
+		subs: map[uint]map[chan *deployment.StateChange]struct{}{},
+	}
+}
+
+// Subscribe returns a channel that receives state changes of the deployment
+// with the given ID. Changes are dropped if the channel is not drained, so
+// receivers should reload the deployment rather than rely on every change.
+// cancel must be called once the changes are no longer needed.
+func (w *Watcher) Subscribe(deploymentID uint) (changes <-chan *deployment.StateChange, cancel func()) {
+	ch := make(chan *deployment.StateChange, 8)
+
+	w.mu.Lock()
+	if w.subs[deploymentID] == nil {
+		w.subs[deploymentID] = map[chan *deployment.StateChange]struct{}{}
+	}
+	w.subs[deploymentID][ch] = struct{}{}
+	w.mu.Unlock()
+
+	return ch, func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		delete(w.subs[deploymentID], ch)
+		if len(w.subs[deploymentID]) == 0 {
+			delete(w.subs, deploymentID)
+		}
+	}
+}
+
+// Publish sends a state change to the subscribers of its deployment.
+func (w *Watcher) Publish(change *deployment.StateChange) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for ch := range w.subs[change.ID] {
+		select {
+		case ch <- change:
+		default:
+		}
+	}
+}
+
+// Listen listens for notifications on deployment.StateChangesChannel of the
+// database at connStr and publishes them. It only returns if the channel
+// could not be listened on.
+func (w *Watcher) Listen(connStr string) error {
+	l := pq.NewListener(connStr, minReconnectInterval, maxReconnectInterval, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Errorf("deployment state listener event %d, err: %v", ev, err)
+		}
+	})
+
+	if err := l.Listen(deployment.StateChangesChannel); err != nil {
+		l.Close()
+		return err
+	}
+
+	for n := range l.Notify {
+		// A nil notification is sent after the connection has been
+		// re-established, when changes may have been missed, so everyone
+		// waiting is told to reload their deployment.
+		if n == nil {
+			w.resync()
+			continue
+		}
+
+		if change := parse(n.Extra); change != nil {
+			w.Publish(change)
+		}
+	}
+	return nil
+}
+
+// ConsumeProgress consumes the progress messages that workers publish to the
+// deployment status exchange, and publishes them as changes with a Progress.
+// Each API server consumes from its own queue, which is deleted when it
+// disconnects. It only returns once the messages can no longer be consumed,
+// e.g. because the connection was closed.
+func (w *Watcher) ConsumeProgress(mq mqconn.Conn) error {
+	ch, err := mq.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	if err := ch.ExchangeDeclare(
+		exchanges.DeploymentStatus, // name
+		"direct",                   // type
+		true,                       // durable
+		false,                      // auto-deleted
+		false,                      // internal
+		false,                      // no-wait
+		nil,                        // arguments
+	); err != nil {
+		return err
+	}
+
+	q, err := ch.QueueDeclare(
+		"",    // name
+		false, // durable
+		true,  // delete when unused
+		true,  // exclusive
+		false, // no-wait
+		nil,   // arguments
+	)
+	if err != nil {
+		return err
+	}
+
+	if err := ch.QueueBind(q.Name, exchanges.RouteV1DeploymentProgress, exchanges.DeploymentStatus, false, nil); err != nil {
+		return err
+	}
+
+	msgs, err := ch.Consume(
+		q.Name, // queue
+		"",     // consumer
+		true,   // auto-ack
+		true,   // exclusive
+		false,  // no-local
+		false,  // no-wait
+		nil,    // args
+	)
+	if err != nil {
+		return err
+	}
+
+	for msg := range msgs {
+		if err := job.Verify(msg); err != nil {
+			log.Errorf("rejected deployment progress %q, err: %v", msg.Body, err)
+			continue
+		}
+
+		data := &messages.V1DeploymentProgressMessageData{}
+		if err := json.Unmarshal(msg.Body, data); err != nil {
+			log.Errorf("failed to parse deployment progress %q, err: %v", msg.Body, err)
+			continue
+		}
+
+		w.Publish(&deployment.StateChange{
+			ID: data.DeploymentID,
+			Progress: &deployment.Progress{
+				Message:   data.Message,
+				Timestamp: data.Timestamp,
+			},
+		})
+	}
+	return errors.New("deployment progress consumer was closed")
+}
+
+// resync sends every subscriber a change without a state, which tells them
+// that changes may have been missed.
+func (w *Watcher) resync() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for id, chs := range w.subs {
+		for ch := range chs {
+			select {
+			case ch <- &deployment.StateChange{ID: id}:
+			default:
+			}
+		}
+	}
+}
+
+func parse(payload string) *deployment.StateChange {
+	change := &deployment.StateChange{}
+	if err := json.Unmarshal([]byte(payload), change); err != nil {
+		log.Errorf("failed to parse deployment state change %q, err: %v", payload, err)
+		return nil
+	}
+	return change
+}
@@ -0,0 +1,148 @@
+package deploywatch_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/deploywatch"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/pkg/mqconn"
+	"github.com/nitrous-io/rise-server/shared/exchanges"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+	"github.com/streadway/amqp"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "deploywatch")
+}
+
+var _ = Describe("Watcher", func() {
+	var w *deploywatch.Watcher
+
+	BeforeEach(func() {
+		w = deploywatch.New()
+	})
+
+	Describe("Publish()", func() {
+		It("sends the change to the subscribers of the deployment", func() {
+			ch1, cancel1 := w.Subscribe(1)
+			defer cancel1()
+			ch2, cancel2 := w.Subscribe(1)
+			defer cancel2()
+			other, cancelOther := w.Subscribe(2)
+			defer cancelOther()
+
+			change := &deployment.StateChange{ID: 1, ProjectID: 3, State: deployment.StateDeployed}
+			w.Publish(change)
+
+			Expect(<-ch1).To(Equal(change))
+			Expect(<-ch2).To(Equal(change))
+			Consistently(other).ShouldNot(Receive())
+		})
+
+		It("does not send changes after the subscription is cancelled", func() {
+			ch, cancel := w.Subscribe(1)
+			cancel()
+
+			w.Publish(&deployment.StateChange{ID: 1, State: deployment.StateDeployed})
+			Consistently(ch).ShouldNot(Receive())
+		})
+
+		It("does not block if a subscriber is not receiving", func() {
+			_, cancel := w.Subscribe(1)
+			defer cancel()
+
+			done := make(chan struct{})
+			go func() {
+				for i := 0; i < 100; i++ {
+					w.Publish(&deployment.StateChange{ID: 1, State: deployment.StatePendingBuild})
+				}
+				close(done)
+			}()
+			Eventually(done).Should(BeClosed())
+		})
+	})
+
+	Describe("ConsumeProgress()", func() {
+		It("publishes progress messages of deployments", func() {
+			mq := mqconn.Memory()
+			go w.ConsumeProgress(mq)
+
+			changes, cancel := w.Subscribe(1)
+			defer cancel()
+
+			ch, err := mq.Channel()
+			Expect(err).To(BeNil())
+			defer ch.Close()
+			Expect(ch.ExchangeDeclare(exchanges.DeploymentStatus, "direct", true, false, false, false, nil)).To(Succeed())
+
+			// Messages are dropped until the consumer's queue is bound, so
+			// the message is published until it is received.
+			var change *deployment.StateChange
+			Eventually(func() bool {
+				Expect(ch.Publish(exchanges.DeploymentStatus, exchanges.RouteV1DeploymentProgress, false, false, amqp.Publishing{
+					Body: []byte(`{"deployment_id": 1, "message": "Optimizing assets", "timestamp": "2016-06-01T08:00:00Z"}`),
+				})).To(Succeed())
+
+				select {
+				case change = <-changes:
+					return true
+				case <-time.After(50 * time.Millisecond):
+					return false
+				}
+			}).Should(BeTrue())
+
+			Expect(change).To(Equal(&deployment.StateChange{
+				ID: 1,
+				Progress: &deployment.Progress{
+					Message:   "Optimizing assets",
+					Timestamp: time.Date(2016, 6, 1, 8, 0, 0, 0, time.UTC),
+				},
+			}))
+		})
+	})
+
+	Describe("Listen()", func() {
+		var db *gorm.DB
+
+		BeforeEach(func() {
+			var err error
+			db, err = dbconn.DB()
+			Expect(err).To(BeNil())
+			testhelper.TruncateTables(db.DB())
+
+			go w.Listen(os.Getenv("POSTGRES_URL"))
+		})
+
+		It("publishes state changes of deployments once they are committed", func() {
+			depl := factories.Deployment(db, nil, nil, deployment.StatePendingBuild)
+
+			ch, cancel := w.Subscribe(depl.ID)
+			defer cancel()
+
+			// Give the listener time to connect.
+			time.Sleep(500 * time.Millisecond)
+
+			tx := db.Begin()
+			Expect(depl.UpdateState(tx, deployment.StatePendingDeploy)).To(Succeed())
+			Consistently(ch, 200*time.Millisecond).ShouldNot(Receive())
+			Expect(tx.Commit().Error).To(BeNil())
+
+			var change *deployment.StateChange
+			Eventually(ch).Should(Receive(&change))
+			Expect(change).To(Equal(&deployment.StateChange{
+				ID:        depl.ID,
+				ProjectID: depl.ProjectID,
+				State:     deployment.StatePendingDeploy,
+			}))
+		})
+	})
+})
@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/apirequest"
+)
+
+// unauditedPaths are paths whose requests are not recorded, as they are made
+// by health checks and would drown out the rest.
+var unauditedPaths = map[string]bool{
+	"/ping": true,
+}
+
+// AuditRequest records the route, user, status, latency and IP of the request
+// to common.RequestAuditSink once it has been handled. The query string is
+// not recorded, as it can contain tokens.
+func AuditRequest(c *gin.Context) {
+	if common.RequestAuditSink == "" || unauditedPaths[c.Request.URL.Path] {
+		c.Next()
+		return
+	}
+
+	start := time.Now()
+	c.Next()
+
+	r := &apirequest.APIRequest{
+		Method:     c.Request.Method,
+		Route:      route(c),
+		Path:       c.Request.URL.Path,
+		StatusCode: c.Writer.Status(),
+		LatencyMs:  int64(time.Since(start) / time.Millisecond),
+		IP:         common.GetIP(c.Request),
+		UserAgent:  c.Request.UserAgent(),
+	}
+	if u := controllers.CurrentUser(c); u != nil {
+		r.UserID = &u.ID
+	}
+	if t := controllers.CurrentToken(c); t != nil {
+		r.OauthTokenID = &t.ID
+	}
+	if name := c.Param("project_name"); name != "" {
+		r.ProjectName = &name
+	}
+
+	switch common.RequestAuditSink {
+	case common.RequestAuditSinkDB:
+		db, err := dbconn.DB()
+		if err == nil {
+			err = db.Create(r).Error
+		}
+		if err != nil {
+			log.Errorf("failed to record API request to %s, err: %v", r.Path, err)
+		}
+	case common.RequestAuditSinkLog:
+		fields := log.Fields{
+			"method":      r.Method,
+			"route":       r.Route,
+			"path":        r.Path,
+			"status_code": r.StatusCode,
+			"latency_ms":  r.LatencyMs,
+			"ip":          r.IP,
+			"user_agent":  r.UserAgent,
+		}
+		if r.UserID != nil {
+			fields["user_id"] = *r.UserID
+		}
+		if r.OauthTokenID != nil {
+			fields["oauth_token_id"] = *r.OauthTokenID
+		}
+		if r.ProjectName != nil {
+			fields["project_name"] = *r.ProjectName
+		}
+		log.WithFields(fields).Info("api request")
+	}
+}
+
+// route returns the route that handled the request by replacing the values of
+// its params in the path with their names, e.g. "/projects/:project_name".
+func route(c *gin.Context) string {
+	segs := strings.Split(c.Request.URL.Path, "/")
+	for _, p := range c.Params {
+		for i, seg := range segs {
+			if seg != "" && seg == p.Value {
+				segs[i] = ":" + p.Key
+				break
+			}
+		}
+	}
+	return strings.Join(segs, "/")
+}
@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+)
+
+// deployTokenRoutes are the routes that deploy tokens can be used with, which
+// are those that the command line client needs to deploy a project and to
+// follow the deployment.
+var deployTokenRoutes = map[string]bool{
+	"POST /projects/:project_name/deployments":                  true,
+	"POST /projects/:project_name/deployment_uploads":           true,
+	"POST /projects/:project_name/deployments/:id/complete":     true,
+	"GET /projects/:project_name/deployments/:id/parts":         true,
+	"PUT /projects/:project_name/deployments/:id/parts/:number": true,
+	"GET /projects/:project_name/deployments/:id":               true,
+	"GET /projects/:project_name/deployments/:id/events":        true,
+	"GET /projects/:project_name/raw_bundles/:bundle_checksum":  true,
+}
+
+// deployTokenAllowed returns whether the deploy token t can be used for the
+// request, i.e. whether the request deploys the project of the token.
+func deployTokenAllowed(c *gin.Context, db *gorm.DB, t *oauthtoken.OauthToken) (bool, error) {
+	if !deployTokenRoutes[c.Request.Method+" "+route(c)] {
+		return false, nil
+	}
+
+	proj := &project.Project{}
+	if err := db.First(proj, *t.ProjectID).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return proj.Name == c.Param("project_name"), nil
+}
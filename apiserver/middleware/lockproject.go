@@ -4,6 +4,8 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/nitrous-io/rise-server/apiserver/controllers"
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/shared/errcodes"
 )
 
 func LockProject(c *gin.Context) {
@@ -21,7 +23,8 @@ func LockProject(c *gin.Context) {
 		return
 	}
 
-	acquired, err := proj.Lock(db)
+	holder := project.LockHolder("apiserver " + c.Request.Method + " " + c.Request.URL.Path)
+	acquired, err := proj.LockFor(db, holder, project.DefaultLockTTL)
 	if err != nil {
 		controllers.InternalServerError(c, err)
 		return
@@ -37,6 +40,7 @@ func LockProject(c *gin.Context) {
 	} else {
 		c.JSON(423, gin.H{
 			"error":             "locked",
+			"code":              errcodes.ProjectLocked,
 			"error_description": "project is locked",
 		})
 		c.Abort()
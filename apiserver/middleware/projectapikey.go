@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/models/apikey"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+)
+
+// projectAPIKeyAllowed returns whether the project API key k can be used for
+// the request, i.e. whether the request is for a route of the project of the
+// key that one of its scopes allows. It also returns the project.
+func projectAPIKeyAllowed(c *gin.Context, db *gorm.DB, k *apikey.APIKey) (bool, *project.Project, error) {
+	proj := &project.Project{}
+	if err := db.First(proj, *k.ProjectID).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return false, nil, nil
+		}
+		return false, nil, err
+	}
+
+	r := route(c)
+	if !strings.HasPrefix(r+"/", "/projects/:project_name/") || c.Param("project_name") != proj.Name {
+		return false, proj, nil
+	}
+
+	switch {
+	case k.HasScope(apikey.ScopeWrite):
+		return true, proj, nil
+	case k.HasScope(apikey.ScopeDeploy) && deployTokenRoutes[c.Request.Method+" "+r]:
+		return true, proj, nil
+	case k.HasScope(apikey.ScopeRead) && c.Request.Method == "GET":
+		return true, proj, nil
+	}
+	return false, proj, nil
+}
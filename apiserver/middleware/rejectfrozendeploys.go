@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/shared/errcodes"
+)
+
+// RejectFrozenDeploys is a Gin middleware that rejects requests that deploy
+// the current project while its deployments are frozen. The owner of the
+// project can override the freeze with the override_freeze query param. It is
+// read from the query string rather than the form, so that the body of
+// uploads is not read before the handler streams it.
+func RejectFrozenDeploys(c *gin.Context) {
+	u := controllers.CurrentUser(c)
+	proj := controllers.CurrentProject(c)
+	if u == nil || proj == nil {
+		controllers.InternalServerError(c, nil)
+		c.Abort()
+		return
+	}
+
+	if !proj.IsFrozen(time.Now()) {
+		c.Next()
+		return
+	}
+
+	if c.Query("override_freeze") == "true" && proj.UserID == u.ID {
+		c.Next()
+		return
+	}
+
+	desc := "deployments of this project are frozen"
+	if c.Query("override_freeze") == "true" {
+		desc = "deployments of this project are frozen, and only the owner of the project can override the freeze"
+	}
+
+	c.JSON(423, gin.H{
+		"error":             "locked",
+		"code":              errcodes.DeploysFrozen,
+		"error_description": desc,
+		"freeze":            proj.FreezeInfo(),
+	})
+	c.Abort()
+}
@@ -0,0 +1,19 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"golang.org/x/net/context"
+)
+
+// RequestContext sets a context that is canceled after common.RequestTimeout,
+// or when the request has been handled, whichever comes first. Handlers can
+// get it with controllers.Context.
+func RequestContext(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), common.RequestTimeout)
+	defer cancel()
+
+	c.Set(controllers.ContextKey, ctx)
+	c.Next()
+}
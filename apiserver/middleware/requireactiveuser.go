@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/shared/errcodes"
+)
+
+// RequireActiveUser is a Gin middleware that ensures that the current user's
+// account is confirmed, and is neither locked nor suspended, before they
+// create credentials, projects or deployments. Users who are not are told what
+// they have to do to use their account again.
+func RequireActiveUser(c *gin.Context) {
+	u := controllers.CurrentUser(c)
+	if u == nil {
+		controllers.InternalServerError(c, nil)
+		c.Abort()
+		return
+	}
+
+	if err := u.CheckActive(); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":             "forbidden",
+			"code":              errcodes.UserInactive,
+			"error_description": err.Error(),
+			"pending_actions":   u.PendingActions(),
+		})
+		c.Abort()
+		return
+	}
+
+	c.Next()
+}
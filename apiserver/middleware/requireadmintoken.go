@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+)
+
+// RequireAdminToken is a Gin middleware that ensures that requests to admin
+// endpoints have the admin token in the token query param. Admin endpoints
+// are unreachable if no admin token is configured, so that a missing
+// STATS_TOKEN does not open them to everyone.
+func RequireAdminToken(c *gin.Context) {
+	token := c.Query("token")
+	if common.StatsToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(common.StatsToken)) != 1 {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error":             "invalid_admin_token",
+			"error_description": "admin token is required",
+		})
+		c.Abort()
+		return
+	}
+
+	c.Next()
+}
@@ -7,12 +7,15 @@ import (
 	"github.com/nitrous-io/rise-server/apiserver/controllers"
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
 	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/shared/errcodes"
 )
 
 // RequireProject is a Gin middleware that:
 // 1. checks that the "project_name" parameter in the path is the name of a
 //    valid project, and
 // 2. ensures that the project is owned by the current user.
+// Project API keys cannot be used, as they would otherwise be able to do
+// everything that the owner of their project can.
 func RequireProject(c *gin.Context) {
 	u := controllers.CurrentUser(c)
 	if u == nil {
@@ -21,6 +24,16 @@ func RequireProject(c *gin.Context) {
 		return
 	}
 
+	if k := controllers.CurrentAPIKey(c); k != nil && k.IsProjectKey() {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":             "forbidden",
+			"code":              errcodes.TokenScopeForbidden,
+			"error_description": "project API keys cannot be used for this request",
+		})
+		c.Abort()
+		return
+	}
+
 	db, err := dbconn.DB()
 	if err != nil {
 		controllers.InternalServerError(c, err)
@@ -0,0 +1,284 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/apikey"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/shared/errcodes"
+	"github.com/nitrous-io/rise-server/shared/s3client"
+)
+
+// SignatureScheme is the scheme of the Authorization header of requests that
+// are signed with an API key, e.g.:
+//
+//	Authorization: Rise-HMAC-SHA256 Key=KEY, Timestamp=UNIX_TIME, Signature=SIGNATURE
+const SignatureScheme = "Rise-HMAC-SHA256"
+
+// MaxSignatureAge is how far the timestamp of a signed request may be from
+// the current time, so that captured requests cannot be replayed later.
+var MaxSignatureAge = 5 * time.Minute
+
+// maxMemoryBodySize is the size above which the body of a signed request is
+// buffered to a temporary file instead of memory.
+// It is also the maximum size of the body of a signed request to routes that
+// do not accept uploads.
+const maxMemoryBodySize = 10 << 20
+
+// maxDeploymentFormOverhead is the room allowed for the fields other than the
+// bundle in a signed request to create a deployment.
+const maxDeploymentFormOverhead = 1 << 20
+
+var errBodyTooLarge = errors.New("request body is too large")
+
+var signatureAuthHeaderRe = regexp.MustCompile(`\A\s*` + SignatureScheme +
+	`\s+Key=([^,\s]+)\s*,\s*Timestamp=(\d+)\s*,\s*Signature=([0-9a-fA-F]+)\s*\z`)
+
+// RequireTokenOrSignature authenticates a request with either an access token
+// or an API key signature, depending on the scheme of its Authorization
+// header.
+func RequireTokenOrSignature(c *gin.Context) {
+	if strings.HasPrefix(strings.TrimSpace(c.Request.Header.Get("Authorization")), SignatureScheme+" ") {
+		RequireSignature(c)
+		return
+	}
+	RequireToken(c)
+}
+
+// RequireSignature authenticates a request that is signed with an API key.
+// The signature is the hex-encoded HMAC-SHA256, keyed with the secret of the
+// API key, of the request method, request URI, timestamp and the hex-encoded
+// SHA-256 digest of the request body, joined by newlines.
+func RequireSignature(c *gin.Context) {
+	match := signatureAuthHeaderRe.FindStringSubmatch(c.Request.Header.Get("Authorization"))
+	if match == nil {
+		invalidSignature(c, "signature is required")
+		return
+	}
+	key, timestamp, signature := match[1], match[2], match[3]
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		invalidSignature(c, "timestamp is invalid")
+		return
+	}
+	if age := time.Since(time.Unix(ts, 0)); age > MaxSignatureAge || age < -MaxSignatureAge {
+		invalidSignature(c, "timestamp is too far from current time")
+		return
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		c.Abort()
+		return
+	}
+
+	k, err := apikey.FindByKey(db, key)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		c.Abort()
+		return
+	}
+	if k == nil {
+		invalidSignature(c, "signature is invalid")
+		return
+	}
+
+	secret, err := k.DecryptedSecret(common.AesKeyring())
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		c.Abort()
+		return
+	}
+
+	limit, err := signedBodyLimit(c, db)
+	if err != nil {
+		controllers.InternalServerError(c, err)
+		c.Abort()
+		return
+	}
+
+	bodyDigest, cleanup, err := bufferBody(c.Request, limit)
+	if err != nil {
+		if err == errBodyTooLarge {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+				"error":             "request_too_large",
+				"code":              errcodes.PayloadTooLarge,
+				"error_description": "request body is too large",
+			})
+		} else {
+			controllers.InternalServerError(c, err)
+		}
+		c.Abort()
+		return
+	}
+	defer cleanup()
+
+	expected := apikey.Sign(secret, c.Request.Method, c.Request.URL.RequestURI(), timestamp, bodyDigest)
+	if !hmac.Equal([]byte(strings.ToLower(signature)), []byte(expected)) {
+		invalidSignature(c, "signature is invalid")
+		return
+	}
+
+	userID := k.UserID
+	if k.IsProjectKey() {
+		allowed, proj, err := projectAPIKeyAllowed(c, db, k)
+		if err != nil {
+			controllers.InternalServerError(c, err)
+			c.Abort()
+			return
+		}
+		if proj == nil {
+			invalidSignature(c, "signature is invalid")
+			return
+		}
+
+		if !allowed {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":             "forbidden",
+				"code":              errcodes.TokenScopeForbidden,
+				"error_description": "project API keys can only be used for their project within their scopes",
+			})
+			c.Abort()
+			return
+		}
+
+		// Project API keys act on behalf of the owner of the project, not the
+		// user who created them.
+		userID = proj.UserID
+	}
+
+	u := &user.User{}
+	if err := db.First(u, userID).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			invalidSignature(c, "signature is invalid")
+		} else {
+			controllers.InternalServerError(c, err)
+			c.Abort()
+		}
+		return
+	}
+
+	if err := db.Model(k).UpdateColumn("last_used_at", time.Now()).Error; err != nil {
+		log.Errorf("failed to update last used time of API key ID %d, err: %v", k.ID, err)
+	}
+
+	c.Set(controllers.CurrentAPIKeyKey, k)
+	c.Set(controllers.CurrentUserKey, u)
+
+	c.Next()
+}
+
+func invalidSignature(c *gin.Context, desc string) {
+	c.Header("WWW-Authenticate", SignatureScheme+` realm="rise-user"`)
+	c.JSON(http.StatusUnauthorized, gin.H{
+		"error":             "invalid_signature",
+		"error_description": desc,
+	})
+	c.Abort()
+}
+
+// signedBodyLimit returns the maximum size of the body of a signed request to
+// the route of c, so that a body that the route would not accept is not
+// buffered before the signature is verified.
+func signedBodyLimit(c *gin.Context, db *gorm.DB) (int64, error) {
+	switch c.Request.Method + " " + route(c) {
+	case "POST /projects/:project_name/deployments":
+		// Bundles can be as large as the plan of the owner of the project
+		// allows.
+		proj, err := project.FindByName(db, c.Param("project_name"))
+		if err != nil || proj == nil {
+			return maxMemoryBodySize, err
+		}
+		owner := &user.User{}
+		if err := db.First(owner, proj.UserID).Error; err != nil {
+			if err == gorm.RecordNotFound {
+				return maxMemoryBodySize, nil
+			}
+			return 0, err
+		}
+		return owner.UploadSizeLimit() + maxDeploymentFormOverhead, nil
+	case "PUT /projects/:project_name/deployments/:id/parts/:number":
+		return s3client.PartSize, nil
+	}
+	return maxMemoryBodySize, nil
+}
+
+// bufferBody reads the body of a request so that its digest can be computed,
+// and replaces it with the buffered body for handlers to read. It returns the
+// hex-encoded SHA-256 digest of the body and a function that releases the
+// buffered body, or errBodyTooLarge if the body is larger than limit.
+func bufferBody(req *http.Request, limit int64) (digest string, cleanup func(), err error) {
+	h := sha256.New()
+	cleanup = func() {}
+
+	if req.Body == nil {
+		return hex.EncodeToString(h.Sum(nil)), cleanup, nil
+	}
+	defer req.Body.Close()
+
+	if req.ContentLength > limit {
+		return "", cleanup, errBodyTooLarge
+	}
+	// Read one byte more than the limit to tell whether the body, whose
+	// length may not be known in advance, is too large.
+	body := io.LimitReader(req.Body, limit+1)
+
+	if req.ContentLength >= 0 && req.ContentLength <= maxMemoryBodySize {
+		b, err := ioutil.ReadAll(io.TeeReader(body, h))
+		if err != nil {
+			return "", cleanup, err
+		}
+		if int64(len(b)) > limit {
+			return "", cleanup, errBodyTooLarge
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(b))
+		return hex.EncodeToString(h.Sum(nil)), cleanup, nil
+	}
+
+	f, err := ioutil.TempFile("", "rise-signed-body-")
+	if err != nil {
+		return "", cleanup, err
+	}
+	cleanup = func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+
+	n, err := io.Copy(io.MultiWriter(f, h), body)
+	if err != nil {
+		cleanup()
+		return "", func() {}, err
+	}
+	if n > limit {
+		cleanup()
+		return "", func() {}, errBodyTooLarge
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		cleanup()
+		return "", func() {}, err
+	}
+
+	req.Body = ioutil.NopCloser(f)
+	return hex.EncodeToString(h.Sum(nil)), cleanup, nil
+}
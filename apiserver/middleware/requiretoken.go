@@ -3,6 +3,7 @@ package middleware
 import (
 	"net/http"
 	"regexp"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jinzhu/gorm"
@@ -10,6 +11,7 @@ import (
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
 	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
 	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/shared/errcodes"
 )
 
 var bearerTokenAuthHeaderRe = regexp.MustCompile(`\A\s*Bearer\s+([\S]+)\s*\z`)
@@ -28,6 +30,7 @@ func RequireToken(c *gin.Context) {
 		c.Header("WWW-Authenticate", `Bearer realm="rise-user"`)
 		c.JSON(http.StatusUnauthorized, gin.H{
 			"error":             "invalid_token",
+			"code":              errcodes.TokenInvalid,
 			"error_description": "access token is required",
 		})
 		c.Abort()
@@ -45,12 +48,43 @@ func RequireToken(c *gin.Context) {
 		c.Header("WWW-Authenticate", `Bearer realm="rise-user"`)
 		c.JSON(http.StatusUnauthorized, gin.H{
 			"error":             "invalid_token",
+			"code":              errcodes.TokenInvalid,
 			"error_description": "access token is invalid",
 		})
 		c.Abort()
 		return
 	}
 
+	if t.Expired() {
+		c.Header("WWW-Authenticate", `Bearer realm="rise-user"`)
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error":             "invalid_token",
+			"code":              errcodes.TokenExpired,
+			"error_description": "access token has expired",
+		})
+		c.Abort()
+		return
+	}
+
+	if t.IsDeployToken() {
+		allowed, err := deployTokenAllowed(c, db, t)
+		if err != nil {
+			controllers.InternalServerError(c, err)
+			c.Abort()
+			return
+		}
+
+		if !allowed {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":             "forbidden",
+				"code":              errcodes.TokenScopeForbidden,
+				"error_description": "deploy tokens can only be used to deploy their project",
+			})
+			c.Abort()
+			return
+		}
+	}
+
 	u := &user.User{}
 
 	if err := db.Model(t).Related(u).Error; err != nil {
@@ -58,6 +92,7 @@ func RequireToken(c *gin.Context) {
 			c.Header("WWW-Authenticate", `Bearer realm="rise-user"`)
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"error":             "invalid_token",
+				"code":              errcodes.TokenInvalid,
 				"error_description": "access token is invalid",
 			})
 		} else {
@@ -67,6 +102,8 @@ func RequireToken(c *gin.Context) {
 		return
 	}
 
+	oauthtoken.Usage.Record(t.ID, time.Now())
+
 	c.Set(controllers.CurrentTokenKey, t)
 	c.Set(controllers.CurrentUserKey, u)
 
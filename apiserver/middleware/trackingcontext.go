@@ -0,0 +1,14 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/nitrous-io/rise-server/apiserver/controllers"
+)
+
+// TrackingContext builds the context that analytics events are tracked with
+// once per request, so that every event of a request has the same data.
+// Handlers can get it with controllers.TrackingContext.
+func TrackingContext(c *gin.Context) {
+	c.Set(controllers.TrackingContextKey, controllers.NewTrackingContext(c))
+	c.Next()
+}
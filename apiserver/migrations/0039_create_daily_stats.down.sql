@@ -0,0 +1,2 @@
+DROP INDEX index_daily_stats_on_project_id_and_domain_name_and_date;
+DROP TABLE daily_stats;
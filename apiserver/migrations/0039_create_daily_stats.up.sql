@@ -0,0 +1,19 @@
+CREATE TABLE daily_stats (
+  id bigserial PRIMARY KEY NOT NULL,
+
+  project_id bigint REFERENCES projects(id) NOT NULL,
+  domain_name character varying(255) NOT NULL,
+  date date NOT NULL,
+
+  requests bigint DEFAULT 0 NOT NULL,
+  bytes bigint DEFAULT 0 NOT NULL,
+  status_2xx bigint DEFAULT 0 NOT NULL,
+  status_3xx bigint DEFAULT 0 NOT NULL,
+  status_4xx bigint DEFAULT 0 NOT NULL,
+  status_5xx bigint DEFAULT 0 NOT NULL,
+
+  created_at timestamp without time zone DEFAULT now() NOT NULL,
+  updated_at timestamp without time zone DEFAULT now() NOT NULL
+);
+
+CREATE UNIQUE INDEX index_daily_stats_on_project_id_and_domain_name_and_date ON daily_stats USING btree (project_id, domain_name, date);
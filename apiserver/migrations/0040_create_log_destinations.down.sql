@@ -0,0 +1,2 @@
+DROP INDEX index_log_destinations_on_project_id;
+DROP TABLE log_destinations;
@@ -0,0 +1,20 @@
+CREATE TABLE log_destinations (
+  id bigserial PRIMARY KEY NOT NULL,
+
+  project_id bigint REFERENCES projects(id) NOT NULL,
+
+  bucket_name character varying(255) NOT NULL,
+  bucket_region character varying(255) NOT NULL,
+  prefix character varying(255) DEFAULT '' NOT NULL,
+
+  access_key_id character varying(255),
+  encrypted_secret_access_key text,
+
+  last_delivered_at timestamp without time zone,
+
+  created_at timestamp without time zone DEFAULT now() NOT NULL,
+  updated_at timestamp without time zone DEFAULT now() NOT NULL,
+  deleted_at timestamp without time zone
+);
+
+CREATE UNIQUE INDEX index_log_destinations_on_project_id ON log_destinations USING btree (project_id) WHERE deleted_at IS NULL;
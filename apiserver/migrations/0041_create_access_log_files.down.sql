@@ -0,0 +1,2 @@
+DROP INDEX index_access_log_files_on_project_id;
+DROP TABLE access_log_files;
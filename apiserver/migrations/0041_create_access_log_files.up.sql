@@ -0,0 +1,14 @@
+CREATE TABLE access_log_files (
+  id bigserial PRIMARY KEY NOT NULL,
+
+  project_id bigint REFERENCES projects(id) NOT NULL,
+  path character varying(255) NOT NULL,
+
+  delivered_at timestamp without time zone,
+
+  created_at timestamp without time zone DEFAULT now() NOT NULL,
+  updated_at timestamp without time zone DEFAULT now() NOT NULL,
+  deleted_at timestamp without time zone
+);
+
+CREATE INDEX index_access_log_files_on_project_id ON access_log_files USING btree (project_id) WHERE delivered_at IS NULL AND deleted_at IS NULL;
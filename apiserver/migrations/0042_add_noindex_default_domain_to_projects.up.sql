@@ -0,0 +1 @@
+ALTER TABLE projects ADD COLUMN noindex_default_domain boolean NOT NULL DEFAULT false;
@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN confirmation_sent_at;
+ALTER TABLE users DROP COLUMN confirmation_code_created_at;
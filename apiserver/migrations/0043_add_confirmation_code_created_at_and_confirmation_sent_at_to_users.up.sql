@@ -0,0 +1,2 @@
+ALTER TABLE users ADD COLUMN confirmation_code_created_at timestamp without time zone DEFAULT now() NOT NULL;
+ALTER TABLE users ADD COLUMN confirmation_sent_at timestamp without time zone;
@@ -0,0 +1,2 @@
+ALTER TABLE oauth_tokens DROP COLUMN last_used_at;
+ALTER TABLE oauth_tokens DROP COLUMN request_count;
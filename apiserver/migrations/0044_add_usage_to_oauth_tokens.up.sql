@@ -0,0 +1,2 @@
+ALTER TABLE oauth_tokens ADD COLUMN request_count bigint DEFAULT 0 NOT NULL;
+ALTER TABLE oauth_tokens ADD COLUMN last_used_at timestamp without time zone;
@@ -0,0 +1,2 @@
+ALTER TABLE oauth_tokens DROP COLUMN description;
+ALTER TABLE oauth_tokens DROP COLUMN name;
@@ -0,0 +1,2 @@
+ALTER TABLE oauth_tokens ADD COLUMN name character varying(255) DEFAULT '' NOT NULL;
+ALTER TABLE oauth_tokens ADD COLUMN description text DEFAULT '' NOT NULL;
@@ -0,0 +1,2 @@
+DROP INDEX index_outbox_jobs_on_created_at;
+DROP TABLE outbox_jobs;
@@ -0,0 +1,14 @@
+CREATE TABLE outbox_jobs (
+  id bigserial PRIMARY KEY NOT NULL,
+
+  queue_name character varying(255) NOT NULL,
+  data bytea NOT NULL,
+
+  attempts integer DEFAULT 0 NOT NULL,
+  last_error text,
+  enqueued_at timestamp without time zone,
+
+  created_at timestamp without time zone DEFAULT now() NOT NULL
+);
+
+CREATE INDEX index_outbox_jobs_on_created_at ON outbox_jobs USING btree (created_at) WHERE enqueued_at IS NULL;
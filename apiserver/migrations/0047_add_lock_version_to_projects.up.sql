@@ -0,0 +1 @@
+ALTER TABLE projects ADD COLUMN lock_version integer DEFAULT 0 NOT NULL;
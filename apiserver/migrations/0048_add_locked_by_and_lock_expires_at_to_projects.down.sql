@@ -0,0 +1,2 @@
+ALTER TABLE projects DROP COLUMN lock_expires_at;
+ALTER TABLE projects DROP COLUMN locked_by;
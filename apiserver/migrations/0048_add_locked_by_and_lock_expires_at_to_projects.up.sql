@@ -0,0 +1,2 @@
+ALTER TABLE projects ADD COLUMN locked_by character varying(255);
+ALTER TABLE projects ADD COLUMN lock_expires_at timestamp without time zone;
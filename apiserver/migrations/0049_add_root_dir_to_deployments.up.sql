@@ -0,0 +1 @@
+ALTER TABLE deployments ADD COLUMN root_dir character varying(255) NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+DROP INDEX index_deploy_hooks_on_project_id_and_stage;
+DROP TABLE deploy_hooks;
@@ -0,0 +1,15 @@
+CREATE TABLE deploy_hooks (
+  id bigserial PRIMARY KEY NOT NULL,
+
+  project_id bigint REFERENCES projects(id) NOT NULL,
+
+  stage character varying(255) NOT NULL,
+  command text,
+  url text,
+
+  created_at timestamp without time zone DEFAULT now() NOT NULL,
+  updated_at timestamp without time zone DEFAULT now() NOT NULL,
+  deleted_at timestamp without time zone
+);
+
+CREATE INDEX index_deploy_hooks_on_project_id_and_stage ON deploy_hooks USING btree (project_id, stage) WHERE deleted_at IS NULL;
@@ -0,0 +1,2 @@
+DROP INDEX index_cert_events_on_domain_id_and_event;
+DROP TABLE cert_events;
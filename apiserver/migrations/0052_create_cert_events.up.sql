@@ -0,0 +1,15 @@
+CREATE TABLE cert_events (
+  id bigserial PRIMARY KEY NOT NULL,
+
+  project_id bigint REFERENCES projects(id) NOT NULL,
+  domain_id bigint REFERENCES domains(id) NOT NULL,
+  domain_name character varying(255) NOT NULL,
+
+  event character varying(255) NOT NULL,
+  expires_at timestamp without time zone,
+  message text,
+
+  created_at timestamp without time zone DEFAULT now() NOT NULL
+);
+
+CREATE INDEX index_cert_events_on_domain_id_and_event ON cert_events USING btree (domain_id, event);
@@ -0,0 +1,3 @@
+DROP INDEX index_domains_on_state;
+ALTER TABLE domains DROP COLUMN dns_checked_at;
+ALTER TABLE domains DROP COLUMN state;
@@ -0,0 +1,4 @@
+ALTER TABLE domains ADD COLUMN state character varying(255) NOT NULL DEFAULT 'active';
+ALTER TABLE domains ADD COLUMN dns_checked_at timestamp without time zone;
+
+CREATE INDEX index_domains_on_state ON domains USING btree (state) WHERE deleted_at IS NULL AND state <> 'active';
@@ -0,0 +1 @@
+ALTER TABLE acme_certs DROP COLUMN contact_email;
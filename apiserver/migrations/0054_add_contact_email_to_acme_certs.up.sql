@@ -0,0 +1 @@
+ALTER TABLE acme_certs ADD COLUMN contact_email character varying(255);
@@ -0,0 +1 @@
+ALTER TABLE acme_certs DROP COLUMN tls_alpn_challenge_cert;
@@ -0,0 +1 @@
+ALTER TABLE acme_certs ADD COLUMN tls_alpn_challenge_cert text;
@@ -0,0 +1,2 @@
+DROP INDEX index_ct_alerts_on_domain_id_and_log_entry_id;
+DROP TABLE ct_alerts;
@@ -0,0 +1,14 @@
+CREATE TABLE ct_alerts (
+  id bigserial PRIMARY KEY NOT NULL,
+
+  domain_id bigint REFERENCES domains(id) NOT NULL,
+  log_entry_id bigint NOT NULL,
+
+  issuer_name text NOT NULL,
+  not_before timestamp without time zone NOT NULL,
+  not_after timestamp without time zone NOT NULL,
+
+  created_at timestamp without time zone DEFAULT now() NOT NULL
+);
+
+CREATE UNIQUE INDEX index_ct_alerts_on_domain_id_and_log_entry_id ON ct_alerts USING btree (domain_id, log_entry_id);
@@ -0,0 +1 @@
+ALTER TABLE deployments DROP COLUMN encrypted_js_env_vars;
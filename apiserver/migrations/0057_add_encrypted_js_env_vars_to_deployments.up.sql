@@ -0,0 +1 @@
+ALTER TABLE deployments ADD COLUMN encrypted_js_env_vars text;
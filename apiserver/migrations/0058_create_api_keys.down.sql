@@ -0,0 +1,3 @@
+DROP INDEX index_api_keys_on_key;
+DROP INDEX index_api_keys_on_user_id;
+DROP TABLE api_keys;
@@ -0,0 +1,15 @@
+CREATE TABLE api_keys (
+  id bigserial PRIMARY KEY NOT NULL,
+
+  user_id bigint REFERENCES users(id) NOT NULL,
+  name character varying(255) DEFAULT '' NOT NULL,
+  key character varying(255) DEFAULT encode(gen_random_bytes(16), 'hex') NOT NULL,
+  encrypted_secret text NOT NULL,
+  last_used_at timestamp without time zone,
+
+  created_at timestamp without time zone DEFAULT now() NOT NULL,
+  deleted_at timestamp without time zone
+);
+
+CREATE INDEX index_api_keys_on_user_id ON api_keys USING btree (user_id);
+CREATE UNIQUE INDEX index_api_keys_on_key ON api_keys USING btree (key);
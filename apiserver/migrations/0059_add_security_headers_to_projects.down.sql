@@ -0,0 +1,2 @@
+ALTER TABLE projects DROP COLUMN security_header_overrides;
+ALTER TABLE projects DROP COLUMN security_headers_enabled;
@@ -0,0 +1,3 @@
+ALTER TABLE projects ADD COLUMN security_headers_enabled boolean NOT NULL DEFAULT false;
+ALTER TABLE projects ALTER COLUMN security_headers_enabled SET DEFAULT true;
+ALTER TABLE projects ADD COLUMN security_header_overrides json DEFAULT '{}';
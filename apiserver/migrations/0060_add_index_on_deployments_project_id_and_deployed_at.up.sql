@@ -0,0 +1 @@
+CREATE INDEX index_deployments_on_project_id_and_deployed_at ON deployments USING btree (project_id, deployed_at) WHERE deleted_at IS NULL;
@@ -0,0 +1,3 @@
+DROP INDEX index_jobs_on_queue_name_and_state;
+DROP INDEX index_jobs_on_created_at;
+DROP TABLE jobs;
@@ -0,0 +1,19 @@
+CREATE TABLE jobs (
+  id bigserial PRIMARY KEY NOT NULL,
+
+  queue_name character varying(255) NOT NULL,
+  data bytea NOT NULL,
+
+  state character varying(255) DEFAULT 'queued' NOT NULL,
+  attempts integer DEFAULT 0 NOT NULL,
+  last_error text,
+
+  started_at timestamp without time zone,
+  finished_at timestamp without time zone,
+
+  created_at timestamp without time zone DEFAULT now() NOT NULL,
+  updated_at timestamp without time zone DEFAULT now() NOT NULL
+);
+
+CREATE INDEX index_jobs_on_created_at ON jobs USING btree (created_at);
+CREATE INDEX index_jobs_on_queue_name_and_state ON jobs USING btree (queue_name, state);
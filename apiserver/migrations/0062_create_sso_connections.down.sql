@@ -0,0 +1,6 @@
+DROP INDEX index_sso_identities_on_user_id;
+DROP INDEX index_sso_identities_on_sso_connection_id_and_subject;
+DROP TABLE sso_identities;
+
+DROP INDEX index_sso_connections_on_email_domain;
+DROP TABLE sso_connections;
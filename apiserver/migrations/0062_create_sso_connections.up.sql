@@ -0,0 +1,33 @@
+CREATE TABLE sso_connections (
+  id bigserial PRIMARY KEY NOT NULL,
+
+  organization character varying(255) NOT NULL,
+  email_domain character varying(255) NOT NULL,
+
+  issuer text NOT NULL,
+  authorization_endpoint text NOT NULL,
+  token_endpoint text NOT NULL,
+  jwks_uri text NOT NULL,
+
+  client_id text NOT NULL,
+  encrypted_client_secret text NOT NULL,
+
+  created_at timestamp without time zone DEFAULT now() NOT NULL,
+  updated_at timestamp without time zone DEFAULT now() NOT NULL,
+  deleted_at timestamp without time zone
+);
+
+CREATE UNIQUE INDEX index_sso_connections_on_email_domain ON sso_connections USING btree (email_domain) WHERE deleted_at IS NULL;
+
+CREATE TABLE sso_identities (
+  id bigserial PRIMARY KEY NOT NULL,
+
+  sso_connection_id bigint REFERENCES sso_connections(id) NOT NULL,
+  subject character varying(255) NOT NULL,
+  user_id bigint REFERENCES users(id) NOT NULL,
+
+  created_at timestamp without time zone DEFAULT now() NOT NULL
+);
+
+CREATE UNIQUE INDEX index_sso_identities_on_sso_connection_id_and_subject ON sso_identities USING btree (sso_connection_id, subject);
+CREATE INDEX index_sso_identities_on_user_id ON sso_identities USING btree (user_id);
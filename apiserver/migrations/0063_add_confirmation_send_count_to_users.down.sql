@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN confirmation_send_count_since;
+ALTER TABLE users DROP COLUMN confirmation_send_count;
@@ -0,0 +1,2 @@
+ALTER TABLE users ADD COLUMN confirmation_send_count integer DEFAULT 0 NOT NULL;
+ALTER TABLE users ADD COLUMN confirmation_send_count_since timestamp without time zone;
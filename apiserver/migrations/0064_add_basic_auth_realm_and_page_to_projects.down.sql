@@ -0,0 +1,2 @@
+ALTER TABLE projects DROP COLUMN basic_auth_page;
+ALTER TABLE projects DROP COLUMN basic_auth_realm;
@@ -0,0 +1,2 @@
+ALTER TABLE projects ADD COLUMN basic_auth_realm character varying(255);
+ALTER TABLE projects ADD COLUMN basic_auth_page character varying(255);
@@ -0,0 +1 @@
+ALTER TABLE projects DROP COLUMN content_type_overrides;
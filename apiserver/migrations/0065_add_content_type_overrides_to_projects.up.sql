@@ -0,0 +1 @@
+ALTER TABLE projects ADD COLUMN content_type_overrides json DEFAULT '{}';
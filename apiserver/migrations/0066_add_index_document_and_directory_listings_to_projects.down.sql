@@ -0,0 +1,2 @@
+ALTER TABLE projects DROP COLUMN directory_listings;
+ALTER TABLE projects DROP COLUMN index_document;
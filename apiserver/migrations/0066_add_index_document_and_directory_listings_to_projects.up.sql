@@ -0,0 +1,2 @@
+ALTER TABLE projects ADD COLUMN index_document character varying(255) DEFAULT 'index.html' NOT NULL;
+ALTER TABLE projects ADD COLUMN directory_listings boolean DEFAULT false NOT NULL;
@@ -0,0 +1 @@
+ALTER TABLE projects ADD COLUMN language_redirects json DEFAULT '[]';
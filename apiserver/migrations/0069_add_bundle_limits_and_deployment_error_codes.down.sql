@@ -0,0 +1,3 @@
+ALTER TABLE projects DROP COLUMN max_bundle_files;
+ALTER TABLE projects DROP COLUMN max_file_size;
+ALTER TABLE deployments DROP COLUMN error_code;
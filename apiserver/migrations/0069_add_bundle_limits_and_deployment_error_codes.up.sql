@@ -0,0 +1,3 @@
+ALTER TABLE projects ADD COLUMN max_bundle_files integer;
+ALTER TABLE projects ADD COLUMN max_file_size bigint;
+ALTER TABLE deployments ADD COLUMN error_code varchar(255);
@@ -0,0 +1,4 @@
+ALTER TABLE deployments DROP COLUMN upload_duration_ms;
+ALTER TABLE deployments DROP COLUMN build_duration_ms;
+ALTER TABLE deployments DROP COLUMN deploy_duration_ms;
+ALTER TABLE deployments DROP COLUMN webroot_size;
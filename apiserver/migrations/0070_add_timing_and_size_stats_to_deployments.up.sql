@@ -0,0 +1,4 @@
+ALTER TABLE deployments ADD COLUMN upload_duration_ms bigint;
+ALTER TABLE deployments ADD COLUMN build_duration_ms bigint;
+ALTER TABLE deployments ADD COLUMN deploy_duration_ms bigint;
+ALTER TABLE deployments ADD COLUMN webroot_size bigint;
@@ -0,0 +1,5 @@
+DROP INDEX index_edges_on_name;
+DROP TABLE edges;
+
+DROP INDEX index_edge_configs_on_project_id;
+DROP TABLE edge_configs;
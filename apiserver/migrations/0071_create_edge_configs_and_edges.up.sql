@@ -0,0 +1,23 @@
+CREATE TABLE edge_configs (
+  id bigserial PRIMARY KEY NOT NULL,
+
+  project_id bigint REFERENCES projects(id),
+  settings json DEFAULT '{}' NOT NULL,
+
+  created_at timestamp without time zone DEFAULT now() NOT NULL
+);
+
+CREATE INDEX index_edge_configs_on_project_id ON edge_configs USING btree (project_id);
+
+CREATE TABLE edges (
+  id bigserial PRIMARY KEY NOT NULL,
+
+  name character varying(255) NOT NULL,
+  config_version bigint DEFAULT 0 NOT NULL,
+  acknowledged_at timestamp without time zone,
+
+  created_at timestamp without time zone DEFAULT now() NOT NULL,
+  updated_at timestamp without time zone DEFAULT now() NOT NULL
+);
+
+CREATE UNIQUE INDEX index_edges_on_name ON edges USING btree (name);
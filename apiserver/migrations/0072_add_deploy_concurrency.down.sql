@@ -0,0 +1,3 @@
+DROP INDEX index_outbox_jobs_on_held_deployment_id;
+ALTER TABLE outbox_jobs DROP COLUMN held_deployment_id;
+ALTER TABLE projects DROP COLUMN deploy_concurrency;
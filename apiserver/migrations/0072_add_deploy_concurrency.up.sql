@@ -0,0 +1,3 @@
+ALTER TABLE projects ADD COLUMN deploy_concurrency character varying(10) DEFAULT 'queue' NOT NULL;
+ALTER TABLE outbox_jobs ADD COLUMN held_deployment_id bigint REFERENCES deployments(id) ON DELETE CASCADE;
+CREATE INDEX index_outbox_jobs_on_held_deployment_id ON outbox_jobs USING btree (held_deployment_id) WHERE held_deployment_id IS NOT NULL;
@@ -0,0 +1,4 @@
+DROP INDEX index_device_codes_on_expires_at;
+DROP INDEX index_device_codes_on_user_code;
+DROP INDEX index_device_codes_on_device_code;
+DROP TABLE device_codes;
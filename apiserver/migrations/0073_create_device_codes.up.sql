@@ -0,0 +1,17 @@
+CREATE TABLE device_codes (
+  id bigserial PRIMARY KEY NOT NULL,
+
+  device_code character varying(64) DEFAULT encode(gen_random_bytes(32), 'hex') NOT NULL,
+  user_code character varying(8) NOT NULL,
+  oauth_client_id bigint REFERENCES oauth_clients(id) ON DELETE CASCADE NOT NULL,
+  user_id bigint REFERENCES users(id) ON DELETE CASCADE,
+
+  denied_at timestamp without time zone,
+  last_polled_at timestamp without time zone,
+  expires_at timestamp without time zone NOT NULL,
+  created_at timestamp without time zone DEFAULT now() NOT NULL
+);
+
+CREATE UNIQUE INDEX index_device_codes_on_device_code ON device_codes USING btree (device_code);
+CREATE UNIQUE INDEX index_device_codes_on_user_code ON device_codes USING btree (user_code);
+CREATE INDEX index_device_codes_on_expires_at ON device_codes USING btree (expires_at);
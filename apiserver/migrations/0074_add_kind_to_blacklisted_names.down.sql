@@ -0,0 +1,7 @@
+DELETE FROM blacklisted_names WHERE kind <> 'exact';
+
+DROP INDEX index_blacklisted_names_on_kind_and_name;
+CREATE UNIQUE INDEX index_blacklisted_names_on_name ON blacklisted_names USING btree (name);
+
+ALTER TABLE blacklisted_names DROP COLUMN created_at;
+ALTER TABLE blacklisted_names DROP COLUMN kind;
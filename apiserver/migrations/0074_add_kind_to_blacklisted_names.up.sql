@@ -0,0 +1,5 @@
+ALTER TABLE blacklisted_names ADD COLUMN kind character varying(10) DEFAULT 'exact' NOT NULL;
+ALTER TABLE blacklisted_names ADD COLUMN created_at timestamp without time zone DEFAULT now() NOT NULL;
+
+DROP INDEX index_blacklisted_names_on_name;
+CREATE UNIQUE INDEX index_blacklisted_names_on_kind_and_name ON blacklisted_names USING btree (kind, name);
@@ -0,0 +1,2 @@
+DROP INDEX index_deploy_hook_deliveries_on_deploy_hook_id;
+DROP TABLE deploy_hook_deliveries;
@@ -0,0 +1,17 @@
+CREATE TABLE deploy_hook_deliveries (
+  id bigserial PRIMARY KEY NOT NULL,
+
+  deploy_hook_id bigint REFERENCES deploy_hooks(id) ON DELETE CASCADE NOT NULL,
+  deployment_id bigint REFERENCES deployments(id) ON DELETE CASCADE NOT NULL,
+  replay_of_id bigint REFERENCES deploy_hook_deliveries(id) ON DELETE SET NULL,
+
+  payload text NOT NULL,
+  status_code integer,
+  latency_ms bigint NOT NULL,
+  response_snippet text DEFAULT '' NOT NULL,
+  error_message text,
+
+  created_at timestamp without time zone DEFAULT now() NOT NULL
+);
+
+CREATE INDEX index_deploy_hook_deliveries_on_deploy_hook_id ON deploy_hook_deliveries USING btree (deploy_hook_id);
@@ -0,0 +1,4 @@
+DROP INDEX index_api_requests_on_ip;
+DROP INDEX index_api_requests_on_user_id;
+DROP INDEX index_api_requests_on_created_at;
+DROP TABLE api_requests;
@@ -0,0 +1,23 @@
+CREATE TABLE api_requests (
+  id bigserial PRIMARY KEY NOT NULL,
+
+  method character varying(16) NOT NULL,
+  route text NOT NULL,
+  path text NOT NULL,
+  status_code integer NOT NULL,
+  latency_ms bigint NOT NULL,
+  ip text DEFAULT '' NOT NULL,
+  user_agent text DEFAULT '' NOT NULL,
+
+  -- Not foreign keys, so that requests are kept after the user, token or
+  -- project is deleted.
+  user_id bigint,
+  oauth_token_id bigint,
+  project_name text,
+
+  created_at timestamp without time zone DEFAULT now() NOT NULL
+);
+
+CREATE INDEX index_api_requests_on_created_at ON api_requests USING btree (created_at);
+CREATE INDEX index_api_requests_on_user_id ON api_requests USING btree (user_id);
+CREATE INDEX index_api_requests_on_ip ON api_requests USING btree (ip);
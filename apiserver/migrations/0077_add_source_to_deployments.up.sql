@@ -0,0 +1 @@
+ALTER TABLE deployments ADD COLUMN source character varying(16) DEFAULT '' NOT NULL;
@@ -0,0 +1 @@
+ALTER TABLE domains ADD COLUMN tls_policy character varying(16) DEFAULT 'intermediate' NOT NULL;
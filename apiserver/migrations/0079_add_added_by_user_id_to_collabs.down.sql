@@ -0,0 +1 @@
+ALTER TABLE collabs DROP COLUMN added_by_user_id;
@@ -0,0 +1 @@
+ALTER TABLE collabs ADD COLUMN added_by_user_id bigint REFERENCES users(id);
@@ -0,0 +1,2 @@
+ALTER TABLE deployments DROP COLUMN settings;
+ALTER TABLE projects DROP COLUMN deployment_defaults;
@@ -0,0 +1,2 @@
+ALTER TABLE projects ADD COLUMN deployment_defaults json DEFAULT '{}';
+ALTER TABLE deployments ADD COLUMN settings json;
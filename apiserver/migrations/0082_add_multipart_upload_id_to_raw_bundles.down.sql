@@ -0,0 +1 @@
+ALTER TABLE raw_bundles DROP COLUMN multipart_upload_id;
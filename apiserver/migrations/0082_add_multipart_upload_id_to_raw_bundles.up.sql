@@ -0,0 +1 @@
+ALTER TABLE raw_bundles ADD COLUMN multipart_upload_id text;
@@ -0,0 +1,2 @@
+ALTER TABLE oauth_tokens DROP COLUMN expires_at;
+ALTER TABLE oauth_tokens DROP COLUMN project_id;
@@ -0,0 +1,2 @@
+ALTER TABLE oauth_tokens ADD COLUMN project_id bigint REFERENCES projects(id) ON DELETE CASCADE;
+ALTER TABLE oauth_tokens ADD COLUMN expires_at timestamp without time zone;
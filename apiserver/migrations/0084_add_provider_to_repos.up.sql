@@ -0,0 +1 @@
+ALTER TABLE repos ADD COLUMN provider character varying(255) NOT NULL DEFAULT 'github';
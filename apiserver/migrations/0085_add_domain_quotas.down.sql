@@ -0,0 +1,10 @@
+DROP INDEX index_quota_events_on_domain_name_and_date_and_metric_and_level;
+DROP TABLE quota_events;
+
+ALTER TABLE projects DROP COLUMN max_domain_bandwidth;
+ALTER TABLE projects DROP COLUMN max_domain_requests;
+
+ALTER TABLE domains DROP COLUMN bandwidth_quota_hard;
+ALTER TABLE domains DROP COLUMN bandwidth_quota_soft;
+ALTER TABLE domains DROP COLUMN request_quota_hard;
+ALTER TABLE domains DROP COLUMN request_quota_soft;
@@ -0,0 +1,24 @@
+ALTER TABLE domains ADD COLUMN request_quota_soft bigint;
+ALTER TABLE domains ADD COLUMN request_quota_hard bigint;
+ALTER TABLE domains ADD COLUMN bandwidth_quota_soft bigint;
+ALTER TABLE domains ADD COLUMN bandwidth_quota_hard bigint;
+
+ALTER TABLE projects ADD COLUMN max_domain_requests bigint;
+ALTER TABLE projects ADD COLUMN max_domain_bandwidth bigint;
+
+CREATE TABLE quota_events (
+  id bigserial PRIMARY KEY NOT NULL,
+
+  project_id bigint REFERENCES projects(id) NOT NULL,
+  domain_name character varying(255) NOT NULL,
+  date date NOT NULL,
+
+  metric character varying(255) NOT NULL,
+  level character varying(255) NOT NULL,
+  quota bigint NOT NULL,
+  usage bigint NOT NULL,
+
+  created_at timestamp without time zone DEFAULT now() NOT NULL
+);
+
+CREATE UNIQUE INDEX index_quota_events_on_domain_name_and_date_and_metric_and_level ON quota_events USING btree (domain_name, date, metric, level);
@@ -0,0 +1,4 @@
+DROP INDEX index_api_keys_on_project_id;
+
+ALTER TABLE api_keys DROP COLUMN scope;
+ALTER TABLE api_keys DROP COLUMN project_id;
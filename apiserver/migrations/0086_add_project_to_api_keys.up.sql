@@ -0,0 +1,4 @@
+ALTER TABLE api_keys ADD COLUMN project_id bigint REFERENCES projects(id);
+ALTER TABLE api_keys ADD COLUMN scope character varying(255) DEFAULT '' NOT NULL;
+
+CREATE INDEX index_api_keys_on_project_id ON api_keys USING btree (project_id);
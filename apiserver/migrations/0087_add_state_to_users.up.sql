@@ -0,0 +1,3 @@
+ALTER TABLE users ADD COLUMN state character varying(255) DEFAULT 'unconfirmed' NOT NULL;
+
+UPDATE users SET state = 'confirmed' WHERE confirmed_at IS NOT NULL;
@@ -0,0 +1 @@
+ALTER TABLE deployments ADD COLUMN pinned_at timestamp without time zone;
@@ -0,0 +1 @@
+ALTER TABLE projects ADD COLUMN asset_fingerprinting boolean DEFAULT false NOT NULL;
@@ -0,0 +1,3 @@
+ALTER TABLE projects DROP COLUMN freeze_reason;
+ALTER TABLE projects DROP COLUMN freeze_ends_at;
+ALTER TABLE projects DROP COLUMN freeze_starts_at;
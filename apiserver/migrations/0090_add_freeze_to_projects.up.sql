@@ -0,0 +1,3 @@
+ALTER TABLE projects ADD COLUMN freeze_starts_at timestamp without time zone;
+ALTER TABLE projects ADD COLUMN freeze_ends_at timestamp without time zone;
+ALTER TABLE projects ADD COLUMN freeze_reason text;
@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN max_upload_size bigint;
@@ -0,0 +1 @@
+DROP TABLE released_names;
@@ -0,0 +1,9 @@
+CREATE TABLE released_names (
+  id bigserial PRIMARY KEY NOT NULL,
+  name character varying(255) NOT NULL,
+  user_id integer NOT NULL REFERENCES users(id),
+  released_at timestamp without time zone NOT NULL DEFAULT now(),
+  held_until timestamp without time zone NOT NULL
+);
+
+CREATE UNIQUE INDEX index_released_names_on_name ON released_names USING btree (name);
@@ -0,0 +1,2 @@
+ALTER TABLE users ADD COLUMN timezone character varying(64) NOT NULL DEFAULT 'UTC';
+ALTER TABLE users ADD COLUMN locale character varying(16) NOT NULL DEFAULT 'en';
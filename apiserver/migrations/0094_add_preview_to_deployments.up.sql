@@ -0,0 +1 @@
+ALTER TABLE deployments ADD COLUMN preview boolean NOT NULL DEFAULT false;
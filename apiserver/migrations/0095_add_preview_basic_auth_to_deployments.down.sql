@@ -0,0 +1,2 @@
+ALTER TABLE deployments DROP COLUMN preview_basic_auth_username;
+ALTER TABLE deployments DROP COLUMN encrypted_preview_basic_auth_password;
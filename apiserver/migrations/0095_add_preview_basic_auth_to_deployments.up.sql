@@ -0,0 +1,2 @@
+ALTER TABLE deployments ADD COLUMN preview_basic_auth_username character varying(255);
+ALTER TABLE deployments ADD COLUMN encrypted_preview_basic_auth_password character varying(255);
@@ -0,0 +1,3 @@
+ALTER TABLE domains DROP COLUMN disabled_reason;
+ALTER TABLE domains DROP COLUMN disabled_at;
+ALTER TABLE domains DROP COLUMN dns_failing_since;
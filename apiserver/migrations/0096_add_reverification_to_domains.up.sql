@@ -0,0 +1,3 @@
+ALTER TABLE domains ADD COLUMN dns_failing_since timestamp without time zone;
+ALTER TABLE domains ADD COLUMN disabled_at timestamp without time zone;
+ALTER TABLE domains ADD COLUMN disabled_reason character varying(32);
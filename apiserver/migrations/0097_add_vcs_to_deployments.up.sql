@@ -0,0 +1,3 @@
+ALTER TABLE deployments ADD COLUMN commit_sha character varying(64) DEFAULT '' NOT NULL;
+ALTER TABLE deployments ADD COLUMN commit_message text DEFAULT '' NOT NULL;
+ALTER TABLE deployments ADD COLUMN branch character varying(255) DEFAULT '' NOT NULL;
@@ -0,0 +1,7 @@
+DROP INDEX index_quota_events_on_date_for_bandwidth;
+
+ALTER TABLE quota_events DROP COLUMN lifted_at;
+ALTER TABLE quota_events DROP COLUMN enforced_at;
+
+ALTER TABLE projects DROP COLUMN bandwidth_exceeded_page;
+ALTER TABLE projects DROP COLUMN bandwidth_exceeded_mode;
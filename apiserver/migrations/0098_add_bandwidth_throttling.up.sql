@@ -0,0 +1,7 @@
+ALTER TABLE projects ADD COLUMN bandwidth_exceeded_mode character varying(16) DEFAULT 'block' NOT NULL;
+ALTER TABLE projects ADD COLUMN bandwidth_exceeded_page character varying(255);
+
+ALTER TABLE quota_events ADD COLUMN enforced_at timestamp without time zone;
+ALTER TABLE quota_events ADD COLUMN lifted_at timestamp without time zone;
+
+CREATE INDEX index_quota_events_on_date_for_bandwidth ON quota_events USING btree (date) WHERE metric = 'bandwidth' AND level = 'hard';
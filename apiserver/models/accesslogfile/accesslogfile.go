@@ -0,0 +1,36 @@
+package accesslogfile
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// AccessLogFile is a database model representing a batch of raw access log
+// entries of a project stored in S3, waiting to be delivered to the project's
+// log destination.
+type AccessLogFile struct {
+	gorm.Model
+
+	ProjectID uint
+	Path      string
+
+	DeliveredAt *time.Time
+}
+
+// Undelivered returns log files of a project that have not been delivered,
+// oldest first.
+func Undelivered(db *gorm.DB, projectID uint) ([]*AccessLogFile, error) {
+	var files []*AccessLogFile
+	if err := db.Where("project_id = ? AND delivered_at IS NULL", projectID).
+		Order("id ASC").
+		Find(&files).Error; err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// MarkDelivered sets the time the log file was delivered to now.
+func (f *AccessLogFile) MarkDelivered(db *gorm.DB) error {
+	return db.Model(AccessLogFile{}).Where("id = ?", f.ID).Update("delivered_at", gorm.Expr("now()")).Error
+}
@@ -4,12 +4,9 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
-	"encoding/base64"
 	"encoding/pem"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"strings"
 
 	"golang.org/x/crypto/ssh"
 
@@ -32,6 +29,11 @@ type AcmeCert struct {
 	//    add a Let's Encrypt cert to a domain).
 	LetsencryptKey string
 
+	// ContactEmail is the email address registered as the contact of the
+	// domain's Let's Encrypt account. It is the email of the user who set up
+	// the cert, so that expiry notices from Let's Encrypt reach them.
+	ContactEmail *string
+
 	PrivateKey string
 
 	// Cert stores the base64-encoded, encrypted cert bundle in PEM format. It
@@ -44,11 +46,17 @@ type AcmeCert struct {
 
 	HTTPChallengePath     string `sql:"column:http_challenge_path"`
 	HTTPChallengeResource string `sql:"column:http_challenge_resource"`
+
+	// TLSALPNChallengeCert stores the base64-encoded, encrypted self-signed
+	// certificate and private key in PEM format that edges present to Let's
+	// Encrypt while a TLS-ALPN-01 challenge is in progress. It is blank when
+	// there is no such challenge.
+	TLSALPNChallengeCert string `sql:"column:tls_alpn_challenge_cert"`
 }
 
 // New returns a new AcmeCert with randomly generated private RSA private keys
 // in LetsencryptKey and PrivateKey.
-func New(domainID uint, aesKey string) (*AcmeCert, error) {
+func New(domainID uint, keyring *aesencrypter.Keyring) (*AcmeCert, error) {
 	crt := &AcmeCert{DomainID: domainID}
 
 	var err error
@@ -56,7 +64,7 @@ func New(domainID uint, aesKey string) (*AcmeCert, error) {
 	if err != nil {
 		return nil, err
 	}
-	crt.LetsencryptKey, err = encryptPrivateKey(leKey, aesKey)
+	crt.LetsencryptKey, err = encryptPrivateKey(leKey, keyring)
 	if err != nil {
 		return nil, err
 	}
@@ -65,7 +73,7 @@ func New(domainID uint, aesKey string) (*AcmeCert, error) {
 	if err != nil {
 		return nil, err
 	}
-	crt.PrivateKey, err = encryptPrivateKey(privKey, aesKey)
+	crt.PrivateKey, err = encryptPrivateKey(privKey, keyring)
 	if err != nil {
 		return nil, err
 	}
@@ -74,19 +82,19 @@ func New(domainID uint, aesKey string) (*AcmeCert, error) {
 }
 
 // encryptPrivatekey converts an RSA private key to ASN.1 DER encoded form,
-// encrypts it with the given AES key, and then Base64-encodes it.
-func encryptPrivateKey(privKey *rsa.PrivateKey, aesKey string) (string, error) {
+// encrypts it with the given keyring, and then Base64-encodes it.
+func encryptPrivateKey(privKey *rsa.PrivateKey, keyring *aesencrypter.Keyring) (string, error) {
 	// Convert private key to ASN.1 DER encoded form.
 	privKeyBytes := pem.EncodeToMemory(&pem.Block{
 		Type:  "RSA PRIVATE KEY",
 		Bytes: x509.MarshalPKCS1PrivateKey(privKey),
 	})
 
-	return encryptBase64(privKeyBytes, aesKey)
+	return encryptBase64(privKeyBytes, keyring)
 }
 
-func decryptPrivateKey(privKey, aesKey string) (*rsa.PrivateKey, error) {
-	decrypted, err := decryptBase64(privKey, aesKey)
+func decryptPrivateKey(privKey string, keyring *aesencrypter.Keyring) (*rsa.PrivateKey, error) {
+	decrypted, err := decryptBase64(privKey, keyring)
 	if err != nil {
 		return nil, err
 	}
@@ -104,31 +112,25 @@ func decryptPrivateKey(privKey, aesKey string) (*rsa.PrivateKey, error) {
 	return rpk, nil
 }
 
-func encryptBase64(data []byte, aesKey string) (string, error) {
-	cipherText, err := aesencrypter.Encrypt(data, []byte(aesKey))
+func encryptBase64(data []byte, keyring *aesencrypter.Keyring) (string, error) {
+	encrypted, err := keyring.EncryptToString(data)
 	if err != nil {
 		return "", fmt.Errorf("acmecert.encryptBase64(): error encrypting data, err: %v", err)
 	}
 
-	return base64.StdEncoding.EncodeToString(cipherText), nil
+	return encrypted, nil
 }
 
-func decryptBase64(data, aesKey string) ([]byte, error) {
-	decoder := base64.NewDecoder(base64.StdEncoding, strings.NewReader(data))
-	cipherText, err := ioutil.ReadAll(decoder)
-	if err != nil {
-		return nil, err
-	}
-
-	return aesencrypter.Decrypt(cipherText, []byte(aesKey))
+func decryptBase64(data string, keyring *aesencrypter.Keyring) ([]byte, error) {
+	return keyring.DecryptString(data)
 }
 
 func (c *AcmeCert) IsValid() bool {
 	return c.DomainID != 0 && c.LetsencryptKey != "" && c.PrivateKey != "" && c.Cert != ""
 }
 
-func (c *AcmeCert) SaveCert(db *gorm.DB, certBundlePEM []byte, aesKey string) error {
-	b, err := encryptBase64(certBundlePEM, aesKey)
+func (c *AcmeCert) SaveCert(db *gorm.DB, certBundlePEM []byte, keyring *aesencrypter.Keyring) error {
+	b, err := encryptBase64(certBundlePEM, keyring)
 	if err != nil {
 		return err
 	}
@@ -138,8 +140,8 @@ func (c *AcmeCert) SaveCert(db *gorm.DB, certBundlePEM []byte, aesKey string) er
 	return db.Model(AcmeCert{}).Where("id = ?", c.ID).Update("cert", b).Error
 }
 
-func (c *AcmeCert) DecryptedCerts(aesKey string) ([]*x509.Certificate, error) {
-	decrypted, err := decryptBase64(c.Cert, aesKey)
+func (c *AcmeCert) DecryptedCerts(keyring *aesencrypter.Keyring) ([]*x509.Certificate, error) {
+	decrypted, err := decryptBase64(c.Cert, keyring)
 	if err != nil {
 		return nil, err
 	}
@@ -167,10 +169,21 @@ func (c *AcmeCert) DecryptedCerts(aesKey string) ([]*x509.Certificate, error) {
 	return certChain, nil
 }
 
-func (c *AcmeCert) DecryptedLetsencryptKey(aesKey string) (*rsa.PrivateKey, error) {
-	return decryptPrivateKey(c.LetsencryptKey, aesKey)
+func (c *AcmeCert) DecryptedLetsencryptKey(keyring *aesencrypter.Keyring) (*rsa.PrivateKey, error) {
+	return decryptPrivateKey(c.LetsencryptKey, keyring)
+}
+
+func (c *AcmeCert) DecryptedPrivateKey(keyring *aesencrypter.Keyring) (*rsa.PrivateKey, error) {
+	return decryptPrivateKey(c.PrivateKey, keyring)
 }
 
-func (c *AcmeCert) DecryptedPrivateKey(aesKey string) (*rsa.PrivateKey, error) {
-	return decryptPrivateKey(c.PrivateKey, aesKey)
+// DeleteOrphaned soft-deletes the AcmeCerts of domains that have been
+// deleted, so that they are no longer renewed, and returns how many were
+// deleted.
+func DeleteOrphaned(db *gorm.DB) (int64, error) {
+	q := db.Exec(`UPDATE acme_certs SET deleted_at = now()
+		WHERE deleted_at IS NULL AND NOT EXISTS (
+			SELECT 1 FROM domains WHERE domains.id = acme_certs.domain_id AND domains.deleted_at IS NULL
+		)`)
+	return q.RowsAffected, q.Error
 }
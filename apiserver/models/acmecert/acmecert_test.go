@@ -41,7 +41,7 @@ var _ = Describe("AcmeCert", func() {
 		It("sets LetsencryptKey and PrivateKey to randomly generated private keys", func() {
 			dm := factories.Domain(db, nil)
 
-			c, err := New(dm.ID, "something-something-something-32")
+			c, err := New(dm.ID, aesencrypter.NewKeyring("something-something-something-32", nil))
 			Expect(err).To(BeNil())
 
 			Expect(c.DomainID).To(Equal(dm.ID))
@@ -56,10 +56,11 @@ var _ = Describe("AcmeCert", func() {
 			Expect(err).To(BeNil())
 
 			aesKey := "something-something-something-32"
-			encrypted, err := encryptPrivateKey(privKey, aesKey)
+			keyring := aesencrypter.NewKeyring(aesKey, nil)
+			encrypted, err := encryptPrivateKey(privKey, keyring)
 			Expect(err).To(BeNil())
 
-			decrypted, err := decryptPrivateKey(encrypted, aesKey)
+			decrypted, err := decryptPrivateKey(encrypted, keyring)
 			Expect(err).To(BeNil())
 			Expect(decrypted).To(Equal(privKey))
 		})
@@ -110,11 +111,12 @@ var _ = Describe("AcmeCert", func() {
 			dm := factories.Domain(db, nil)
 
 			aesKey := "something-something-something-32"
-			acmeCert, err := New(dm.ID, aesKey)
+			keyring := aesencrypter.NewKeyring(aesKey, nil)
+			acmeCert, err := New(dm.ID, keyring)
 			Expect(err).To(BeNil())
 			Expect(db.Create(acmeCert).Error).To(BeNil())
 
-			err = acmeCert.SaveCert(db, certPEM, aesKey)
+			err = acmeCert.SaveCert(db, certPEM, keyring)
 			Expect(err).To(BeNil())
 
 			// Reload from db.
@@ -138,11 +140,12 @@ var _ = Describe("AcmeCert", func() {
 				dm := factories.Domain(db, nil)
 
 				aesKey := "something-something-something-32"
-				acmeCert, err := New(dm.ID, aesKey)
+				keyring := aesencrypter.NewKeyring(aesKey, nil)
+				acmeCert, err := New(dm.ID, keyring)
 				Expect(err).To(BeNil())
 				Expect(db.Create(acmeCert).Error).To(BeNil())
 
-				err = acmeCert.SaveCert(db, bundledPEM, aesKey)
+				err = acmeCert.SaveCert(db, bundledPEM, keyring)
 				Expect(err).To(BeNil())
 
 				// Reload from db.
@@ -166,6 +169,7 @@ var _ = Describe("AcmeCert", func() {
 			acmeCert *AcmeCert
 			dm       *domain.Domain
 			aesKey   = "something-something-something-32"
+			keyring  = aesencrypter.NewKeyring(aesKey, nil)
 		)
 
 		BeforeEach(func() {
@@ -175,11 +179,11 @@ var _ = Describe("AcmeCert", func() {
 		Context("when .Cert is a single certificate", func() {
 			BeforeEach(func() {
 				var err error
-				acmeCert, err = New(dm.ID, aesKey)
+				acmeCert, err = New(dm.ID, keyring)
 				Expect(err).To(BeNil())
 				Expect(db.Create(acmeCert).Error).To(BeNil())
 
-				err = acmeCert.SaveCert(db, certPEM, aesKey)
+				err = acmeCert.SaveCert(db, certPEM, keyring)
 				Expect(err).To(BeNil())
 			})
 
@@ -188,7 +192,7 @@ var _ = Describe("AcmeCert", func() {
 				err = db.First(acmeCert, acmeCert.ID).Error
 				Expect(err).To(BeNil())
 
-				certChain, err := acmeCert.DecryptedCerts(aesKey)
+				certChain, err := acmeCert.DecryptedCerts(keyring)
 				Expect(err).To(BeNil())
 
 				Expect(certChain).To(HaveLen(1))
@@ -208,13 +212,13 @@ var _ = Describe("AcmeCert", func() {
 		Context("when .Cert is a certificate bundle", func() {
 			BeforeEach(func() {
 				var err error
-				acmeCert, err = New(dm.ID, aesKey)
+				acmeCert, err = New(dm.ID, keyring)
 				Expect(err).To(BeNil())
 				Expect(db.Create(acmeCert).Error).To(BeNil())
 
 				bundledPEM := append(certPEM, issuerCertPEM...)
 
-				err = acmeCert.SaveCert(db, bundledPEM, aesKey)
+				err = acmeCert.SaveCert(db, bundledPEM, keyring)
 				Expect(err).To(BeNil())
 			})
 
@@ -223,7 +227,7 @@ var _ = Describe("AcmeCert", func() {
 				err = db.First(acmeCert, acmeCert.ID).Error
 				Expect(err).To(BeNil())
 
-				certChain, err := acmeCert.DecryptedCerts(aesKey)
+				certChain, err := acmeCert.DecryptedCerts(keyring)
 				Expect(err).To(BeNil())
 
 				Expect(certChain).To(HaveLen(2))
@@ -250,6 +254,35 @@ var _ = Describe("AcmeCert", func() {
 			})
 		})
 	})
+
+	Describe("DeleteOrphaned()", func() {
+		It("deletes the acme certs of deleted domains", func() {
+			dm1 := factories.Domain(db, nil)
+			dm2 := factories.Domain(db, nil)
+
+			ac1 := &AcmeCert{DomainID: dm1.ID, LetsencryptKey: "key-1", PrivateKey: "key-1"}
+			Expect(db.Create(ac1).Error).To(BeNil())
+			ac2 := &AcmeCert{DomainID: dm2.ID, LetsencryptKey: "key-2", PrivateKey: "key-2"}
+			Expect(db.Create(ac2).Error).To(BeNil())
+
+			Expect(db.Delete(dm1).Error).To(BeNil())
+
+			n, err := DeleteOrphaned(db)
+			Expect(err).To(BeNil())
+			Expect(n).To(Equal(int64(1)))
+
+			var count int
+			Expect(db.Model(AcmeCert{}).Where("id = ?", ac1.ID).Count(&count).Error).To(BeNil())
+			Expect(count).To(Equal(0))
+
+			Expect(db.Model(AcmeCert{}).Where("id = ?", ac2.ID).Count(&count).Error).To(BeNil())
+			Expect(count).To(Equal(1))
+
+			n, err = DeleteOrphaned(db)
+			Expect(err).To(BeNil())
+			Expect(n).To(Equal(int64(0)))
+		})
+	})
 })
 
 var certPEM = []byte(`-----BEGIN CERTIFICATE-----
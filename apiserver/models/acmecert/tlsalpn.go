@@ -0,0 +1,110 @@
+package acmecert
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"time"
+
+	"github.com/nitrous-io/rise-server/pkg/aesencrypter"
+	"github.com/square/go-jose"
+)
+
+// idPeAcmeIdentifier is the OID of the certificate extension that carries the
+// SHA-256 digest of the key authorization in a TLS-ALPN-01 challenge
+// certificate. See https://tools.ietf.org/html/rfc8737#section-6.1.
+var idPeAcmeIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+// TLSALPNChallengeCertValidity is how long a TLS-ALPN-01 challenge
+// certificate is valid for.
+var TLSALPNChallengeCertValidity = 24 * time.Hour
+
+// TLSALPNChallengeCertPaths returns the S3 paths of the cert and key that
+// edges present in response to a TLS-ALPN-01 challenge for a domain.
+func TLSALPNChallengeCertPaths(domainName string) (certPath, keyPath string) {
+	return "certs/" + domainName + "/acme-tls-alpn.crt", "certs/" + domainName + "/acme-tls-alpn.key"
+}
+
+// KeyAuthorization returns the key authorization of a challenge token for the
+// given Let's Encrypt account key.
+func KeyAuthorization(accountKey *rsa.PrivateKey, token string) (string, error) {
+	thumbprint, err := (&jose.JsonWebKey{Key: &accountKey.PublicKey}).Thumbprint(crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+	return token + "." + base64.RawURLEncoding.EncodeToString(thumbprint), nil
+}
+
+// NewTLSALPNChallengeCert returns a PEM-encoded self-signed certificate and
+// its private key that answer a TLS-ALPN-01 challenge for a domain with the
+// given key authorization.
+func NewTLSALPNChallengeCert(domainName, keyAuth string) (certPEM, keyPEM []byte, err error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	digest := sha256.Sum256([]byte(keyAuth))
+	extValue, err := asn1.Marshal(digest[:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject:      pkix.Name{CommonName: domainName},
+		DNSNames:     []string{domainName},
+		NotBefore:    now,
+		NotAfter:     now.Add(TLSALPNChallengeCertValidity),
+		ExtraExtensions: []pkix.Extension{
+			{Id: idPeAcmeIdentifier, Critical: true, Value: extValue},
+		},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	})
+	return certPEM, keyPEM, nil
+}
+
+// SetTLSALPNChallengeCert encrypts a PEM-encoded TLS-ALPN-01 challenge
+// certificate and its private key and sets them in TLSALPNChallengeCert.
+func (c *AcmeCert) SetTLSALPNChallengeCert(certPEM, keyPEM []byte, keyring *aesencrypter.Keyring) error {
+	b, err := encryptBase64(append(append([]byte{}, certPEM...), keyPEM...), keyring)
+	if err != nil {
+		return err
+	}
+	c.TLSALPNChallengeCert = b
+	return nil
+}
+
+// DecryptedTLSALPNChallengeCert returns the TLS-ALPN-01 challenge certificate
+// stored in TLSALPNChallengeCert.
+func (c *AcmeCert) DecryptedTLSALPNChallengeCert(keyring *aesencrypter.Keyring) (tls.Certificate, error) {
+	decrypted, err := decryptBase64(c.TLSALPNChallengeCert, keyring)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.X509KeyPair(decrypted, decrypted)
+}
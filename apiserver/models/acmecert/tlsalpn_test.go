@@ -0,0 +1,69 @@
+package acmecert
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+
+	"github.com/ericchiang/letsencrypt"
+	"github.com/nitrous-io/rise-server/pkg/aesencrypter"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TLS-ALPN-01 challenge", func() {
+	Describe("KeyAuthorization()", func() {
+		It("returns the key authorization of the token", func() {
+			key, err := rsa.GenerateKey(rand.Reader, 2048)
+			Expect(err).To(BeNil())
+
+			chal := letsencrypt.Challenge{Type: letsencrypt.ChallengeHTTP, Token: "secret-token"}
+			_, expected, err := chal.HTTP(key)
+			Expect(err).To(BeNil())
+
+			keyAuth, err := KeyAuthorization(key, "secret-token")
+			Expect(err).To(BeNil())
+			Expect(keyAuth).To(Equal(expected))
+		})
+	})
+
+	Describe("NewTLSALPNChallengeCert()", func() {
+		It("returns a self-signed cert for the domain with the acmeIdentifier extension", func() {
+			certPEM, keyPEM, err := NewTLSALPNChallengeCert("www.example.com", "secret-token.thumbprint")
+			Expect(err).To(BeNil())
+
+			keyring := aesencrypter.NewKeyring("something-something-something-32", nil)
+
+			c := &AcmeCert{}
+			Expect(c.SetTLSALPNChallengeCert(certPEM, keyPEM, keyring)).To(Succeed())
+			Expect(c.TLSALPNChallengeCert).NotTo(Equal(""))
+
+			tlsCert, err := c.DecryptedTLSALPNChallengeCert(keyring)
+			Expect(err).To(BeNil())
+			Expect(tlsCert.Certificate).To(HaveLen(1))
+
+			x509Cert, err := x509.ParseCertificate(tlsCert.Certificate[0])
+			Expect(err).To(BeNil())
+			Expect(x509Cert.DNSNames).To(Equal([]string{"www.example.com"}))
+			Expect(x509Cert.CheckSignature(x509Cert.SignatureAlgorithm, x509Cert.RawTBSCertificate, x509Cert.Signature)).To(Succeed())
+
+			var found bool
+			for _, ext := range x509Cert.Extensions {
+				if !ext.Id.Equal(idPeAcmeIdentifier) {
+					continue
+				}
+				found = true
+				Expect(ext.Critical).To(BeTrue())
+
+				var digest []byte
+				_, err := asn1.Unmarshal(ext.Value, &digest)
+				Expect(err).To(BeNil())
+				expected := sha256.Sum256([]byte("secret-token.thumbprint"))
+				Expect(digest).To(Equal(expected[:]))
+			}
+			Expect(found).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,253 @@
+package apikey
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/pkg/aesencrypter"
+)
+
+// SecretLength is the number of random bytes in a secret.
+const SecretLength = 32
+
+var ErrNoSecret = errors.New("secret is empty")
+
+// Scopes that project API keys can be given.
+const (
+	// ScopeRead allows reading the project, e.g. its deployments and domains.
+	ScopeRead = "read"
+	// ScopeDeploy allows deploying the project, with the same routes as
+	// deploy tokens.
+	ScopeDeploy = "deploy"
+	// ScopeWrite allows everything that collaborators of the project can do.
+	ScopeWrite = "write"
+)
+
+// Scopes are all the scopes that project API keys can be given.
+var Scopes = []string{ScopeRead, ScopeDeploy, ScopeWrite}
+
+// APIKey is a database model representing a key and secret pair with which
+// machine clients (e.g. CI services) sign requests on behalf of a user,
+// instead of sending a long-lived access token.
+type APIKey struct {
+	ID uint `gorm:"primary_key"`
+	// UserID is the user that the API key belongs to, or the user who created
+	// it if it is a project API key.
+	UserID uint
+	Name   string
+
+	// ProjectID is set for project API keys, which belong to the project
+	// instead of a user, so that automation keeps working when the user who
+	// set it up leaves. They act on behalf of the owner of the project, and
+	// can only be used for the routes of the project that Scope allows.
+	ProjectID *uint
+	// Scope is the space-separated list of scopes of a project API key.
+	Scope string
+
+	// Key identifies the API key in signed requests.
+	Key string `sql:"default:encode(gen_random_bytes(16), 'hex')"`
+
+	Secret          string `sql:"-"`
+	EncryptedSecret string
+
+	LastUsedAt *time.Time
+	CreatedAt  time.Time
+	DeletedAt  *time.Time
+}
+
+// TableName returns the table name of APIKey, which gorm would otherwise
+// derive as "a_p_i_keys".
+func (k *APIKey) TableName() string {
+	return "api_keys"
+}
+
+// JSON specifies which fields of an API key will be marshaled to JSON. The
+// secret is never included.
+type JSON struct {
+	ID         uint       `json:"id"`
+	Name       string     `json:"name"`
+	Key        string     `json:"key"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Scopes     []string   `json:"scopes,omitempty"`
+}
+
+// AsJSON returns a struct that can be converted to JSON
+func (k *APIKey) AsJSON() *JSON {
+	return &JSON{
+		ID:         k.ID,
+		Name:       k.Name,
+		Key:        k.Key,
+		LastUsedAt: k.LastUsedAt,
+		CreatedAt:  k.CreatedAt,
+		Scopes:     k.Scopes(),
+	}
+}
+
+// IsProjectKey returns whether the API key belongs to a project instead of a
+// user.
+func (k *APIKey) IsProjectKey() bool {
+	return k.ProjectID != nil
+}
+
+// Scopes returns the scopes of a project API key.
+func (k *APIKey) Scopes() []string {
+	return strings.Fields(k.Scope)
+}
+
+// HasScope returns whether a project API key has the given scope.
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes() {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// SetScopes sets the scopes of a project API key, ignoring duplicates.
+func (k *APIKey) SetScopes(scopes []string) {
+	var uniq []string
+	seen := map[string]bool{}
+	for _, s := range scopes {
+		if s = strings.ToLower(strings.TrimSpace(s)); s != "" && !seen[s] {
+			seen[s] = true
+			uniq = append(uniq, s)
+		}
+	}
+	k.Scope = strings.Join(uniq, " ")
+}
+
+// Validate validates APIKey, if there are invalid fields, it returns a map of
+// <field, errors> and returns nil if valid
+func (k *APIKey) Validate() map[string]string {
+	errors := map[string]string{}
+
+	if k.Name == "" {
+		errors["name"] = "is required"
+	} else if len(k.Name) > 255 {
+		errors["name"] = "is too long (max. 255 characters)"
+	}
+
+	if k.IsProjectKey() {
+		scopes := k.Scopes()
+		if len(scopes) == 0 {
+			errors["scopes"] = "is required"
+		}
+		for _, s := range scopes {
+			if !isValidScope(s) {
+				errors["scopes"] = "must be one or more of " + strings.Join(Scopes, ", ")
+				break
+			}
+		}
+	} else if k.Scope != "" {
+		errors["scopes"] = "can only be set for project API keys"
+	}
+
+	if len(errors) == 0 {
+		return nil
+	}
+	return errors
+}
+
+// GenerateSecret sets `Secret` to a new random secret and encrypts it with the
+// given keyring.
+func (k *APIKey) GenerateSecret(keyring *aesencrypter.Keyring) error {
+	b := make([]byte, SecretLength)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	k.Secret = hex.EncodeToString(b)
+
+	return k.EncryptSecret(keyring)
+}
+
+// EncryptSecret encrypts `Secret` with the given keyring
+func (k *APIKey) EncryptSecret(keyring *aesencrypter.Keyring) error {
+	if k.Secret == "" {
+		return ErrNoSecret
+	}
+
+	encrypted, err := keyring.EncryptToString([]byte(k.Secret))
+	if err != nil {
+		return err
+	}
+
+	k.EncryptedSecret = encrypted
+	return nil
+}
+
+// DecryptedSecret returns the secret decrypted with the given keyring
+func (k *APIKey) DecryptedSecret(keyring *aesencrypter.Keyring) (string, error) {
+	if k.EncryptedSecret == "" {
+		return "", ErrNoSecret
+	}
+
+	plainText, err := keyring.DecryptString(k.EncryptedSecret)
+	if err != nil {
+		return "", err
+	}
+
+	return string(plainText), nil
+}
+
+// StringToSign returns the string that is signed for a request. bodyDigest is
+// the hex-encoded SHA-256 digest of the request body.
+func StringToSign(method, requestURI, timestamp, bodyDigest string) string {
+	return strings.Join([]string{strings.ToUpper(method), requestURI, timestamp, bodyDigest}, "\n")
+}
+
+// Sign returns the hex-encoded HMAC-SHA256 signature of a request signed with
+// the given secret.
+func Sign(secret, method, requestURI, timestamp, bodyDigest string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(StringToSign(method, requestURI, timestamp, bodyDigest)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// FindByKey returns the API key with the given key, or nil if none is found
+func FindByKey(db *gorm.DB, key string) (*APIKey, error) {
+	k := &APIKey{}
+	if err := db.Where("key = ?", key).First(k).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return k, nil
+}
+
+// FindByUserID returns all API keys of a user, most recently created first.
+// Project API keys that the user created are not included.
+func FindByUserID(db *gorm.DB, userID uint) ([]*APIKey, error) {
+	var keys []*APIKey
+	if err := db.Where("user_id = ? AND project_id IS NULL", userID).Order("created_at DESC, id DESC").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// FindByProjectID returns all API keys of a project, most recently created
+// first
+func FindByProjectID(db *gorm.DB, projectID uint) ([]*APIKey, error) {
+	var keys []*APIKey
+	if err := db.Where("project_id = ?", projectID).Order("created_at DESC, id DESC").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func isValidScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
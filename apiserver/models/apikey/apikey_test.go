@@ -0,0 +1,107 @@
+package apikey_test
+
+import (
+	"testing"
+
+	"github.com/nitrous-io/rise-server/apiserver/models/apikey"
+	"github.com/nitrous-io/rise-server/pkg/aesencrypter"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "apikey")
+}
+
+var _ = Describe("APIKey", func() {
+	keyring := aesencrypter.NewKeyring("something-something-something-32", nil)
+
+	Describe("GenerateSecret()", func() {
+		It("generates a random secret and encrypts it", func() {
+			k := &apikey.APIKey{}
+			Expect(k.GenerateSecret(keyring)).To(Succeed())
+			Expect(k.Secret).To(HaveLen(apikey.SecretLength * 2))
+			Expect(k.EncryptedSecret).NotTo(BeEmpty())
+			Expect(k.EncryptedSecret).NotTo(ContainSubstring(k.Secret))
+
+			secret, err := k.DecryptedSecret(keyring)
+			Expect(err).To(BeNil())
+			Expect(secret).To(Equal(k.Secret))
+
+			k2 := &apikey.APIKey{}
+			Expect(k2.GenerateSecret(keyring)).To(Succeed())
+			Expect(k2.Secret).NotTo(Equal(k.Secret))
+		})
+	})
+
+	Describe("DecryptedSecret()", func() {
+		It("returns an error if there is no secret", func() {
+			_, err := (&apikey.APIKey{}).DecryptedSecret(keyring)
+			Expect(err).To(Equal(apikey.ErrNoSecret))
+		})
+	})
+
+	Describe("Validate()", func() {
+		var (
+			projectID uint = 1
+			k         *apikey.APIKey
+		)
+
+		BeforeEach(func() {
+			k = &apikey.APIKey{Name: "Deploy from CI", ProjectID: &projectID}
+			k.SetScopes([]string{"deploy", "read"})
+		})
+
+		It("returns nil if the API key is valid", func() {
+			Expect(k.Validate()).To(BeNil())
+		})
+
+		It("requires project API keys to have scopes", func() {
+			k.SetScopes(nil)
+			Expect(k.Validate()).To(Equal(map[string]string{
+				"scopes": "is required",
+			}))
+		})
+
+		It("requires the scopes of project API keys to be valid", func() {
+			k.SetScopes([]string{"deploy", "admin"})
+			Expect(k.Validate()).To(Equal(map[string]string{
+				"scopes": "must be one or more of read, deploy, write",
+			}))
+		})
+
+		It("does not allow scopes on API keys of users", func() {
+			k.ProjectID = nil
+			Expect(k.Validate()).To(Equal(map[string]string{
+				"scopes": "can only be set for project API keys",
+			}))
+		})
+	})
+
+	Describe("SetScopes()", func() {
+		It("sets the normalized scopes without duplicates", func() {
+			k := &apikey.APIKey{}
+			k.SetScopes([]string{" Deploy", "", "read", "deploy "})
+
+			Expect(k.Scope).To(Equal("deploy read"))
+			Expect(k.Scopes()).To(Equal([]string{"deploy", "read"}))
+			Expect(k.HasScope(apikey.ScopeDeploy)).To(BeTrue())
+			Expect(k.HasScope(apikey.ScopeRead)).To(BeTrue())
+			Expect(k.HasScope(apikey.ScopeWrite)).To(BeFalse())
+		})
+	})
+
+	Describe("Sign()", func() {
+		It("returns the HMAC-SHA256 of the method, request URI, timestamp and body digest", func() {
+			Expect(apikey.StringToSign("post", "/projects?foo=bar", "1465000000", "abc123")).
+				To(Equal("POST\n/projects?foo=bar\n1465000000\nabc123"))
+
+			sig := apikey.Sign("secret", "POST", "/projects?foo=bar", "1465000000", "abc123")
+			Expect(sig).To(HaveLen(64))
+			Expect(apikey.Sign("secret", "POST", "/projects?foo=bar", "1465000000", "abc123")).To(Equal(sig))
+			Expect(apikey.Sign("another", "POST", "/projects?foo=bar", "1465000000", "abc123")).NotTo(Equal(sig))
+			Expect(apikey.Sign("secret", "POST", "/projects?foo=baz", "1465000000", "abc123")).NotTo(Equal(sig))
+		})
+	})
+})
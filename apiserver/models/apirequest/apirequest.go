@@ -0,0 +1,150 @@
+package apirequest
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// APIRequest is a database model recording the metadata of a request to the
+// API, which is kept for security investigations and abuse analysis. Request
+// and response bodies are never recorded.
+type APIRequest struct {
+	ID uint `gorm:"primary_key"`
+
+	Method string
+	// Route is the route that handled the request, e.g.
+	// "/projects/:project_name/deployments", or the path if no route matched.
+	Route      string
+	Path       string
+	StatusCode int
+	LatencyMs  int64
+	IP         string
+	UserAgent  string
+
+	// UserID and OauthTokenID are nil if the request was not authenticated.
+	UserID       *uint
+	OauthTokenID *uint
+	ProjectName  *string
+
+	CreatedAt time.Time
+}
+
+// TableName returns the table name of APIRequest.
+func (r APIRequest) TableName() string {
+	return "api_requests"
+}
+
+// JSON specifies which fields of a request will be marshaled to JSON.
+type JSON struct {
+	ID           uint      `json:"id"`
+	Method       string    `json:"method"`
+	Route        string    `json:"route"`
+	Path         string    `json:"path"`
+	StatusCode   int       `json:"status_code"`
+	LatencyMs    int64     `json:"latency_ms"`
+	IP           string    `json:"ip"`
+	UserAgent    string    `json:"user_agent"`
+	UserID       *uint     `json:"user_id"`
+	OauthTokenID *uint     `json:"oauth_token_id"`
+	ProjectName  *string   `json:"project_name"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// AsJSON returns a struct that can be converted to JSON
+func (r *APIRequest) AsJSON() interface{} {
+	return JSON{
+		ID:           r.ID,
+		Method:       r.Method,
+		Route:        r.Route,
+		Path:         r.Path,
+		StatusCode:   r.StatusCode,
+		LatencyMs:    r.LatencyMs,
+		IP:           r.IP,
+		UserAgent:    r.UserAgent,
+		UserID:       r.UserID,
+		OauthTokenID: r.OauthTokenID,
+		ProjectName:  r.ProjectName,
+		CreatedAt:    r.CreatedAt,
+	}
+}
+
+// Filter narrows down the requests returned by List. Zero values match all
+// requests.
+type Filter struct {
+	UserID      uint
+	IP          string
+	Route       string
+	ProjectName string
+	StatusCode  int
+	Since       *time.Time
+	Until       *time.Time
+	// BeforeID only matches requests older than the request with the given
+	// ID, so that results can be paged through.
+	BeforeID uint
+}
+
+// List returns up to limit requests that match the filter, most recent first.
+func List(db *gorm.DB, f Filter, limit int) ([]*APIRequest, error) {
+	q := db.Order("id DESC").Limit(limit)
+	if f.UserID != 0 {
+		q = q.Where("user_id = ?", f.UserID)
+	}
+	if f.IP != "" {
+		q = q.Where("ip = ?", f.IP)
+	}
+	if f.Route != "" {
+		q = q.Where("route = ?", f.Route)
+	}
+	if f.ProjectName != "" {
+		q = q.Where("project_name = ?", f.ProjectName)
+	}
+	if f.StatusCode != 0 {
+		q = q.Where("status_code = ?", f.StatusCode)
+	}
+	if f.Since != nil {
+		q = q.Where("created_at >= ?", *f.Since)
+	}
+	if f.Until != nil {
+		q = q.Where("created_at < ?", *f.Until)
+	}
+	if f.BeforeID != 0 {
+		q = q.Where("id < ?", f.BeforeID)
+	}
+
+	var reqs []*APIRequest
+	if err := q.Find(&reqs).Error; err != nil {
+		return nil, err
+	}
+	return reqs, nil
+}
+
+// DeleteOlderThan deletes requests that were made before t and returns the
+// number of requests deleted.
+func DeleteOlderThan(db *gorm.DB, t time.Time) (int64, error) {
+	q := db.Where("created_at < ?", t).Delete(APIRequest{})
+	return q.RowsAffected, q.Error
+}
+
+// LastActionsByUserID returns the most recent successful request that changed
+// something, i.e. that was not a GET or HEAD request, that each of the given
+// users made to a project, by user ID. Users without any are left out.
+func LastActionsByUserID(db *gorm.DB, projectName string, userIDs []uint) (map[uint]*APIRequest, error) {
+	actions := map[uint]*APIRequest{}
+	if len(userIDs) == 0 {
+		return actions, nil
+	}
+
+	var reqs []*APIRequest
+	if err := db.Select("DISTINCT ON (user_id) *").
+		Where("project_name = ? AND user_id IN (?)", projectName, userIDs).
+		Where("method NOT IN (?) AND status_code < ?", []string{"GET", "HEAD"}, 400).
+		Order("user_id, id DESC").Find(&reqs).Error; err != nil {
+		return nil, err
+	}
+
+	for _, r := range reqs {
+		actions[*r.UserID] = r
+	}
+	return actions, nil
+}
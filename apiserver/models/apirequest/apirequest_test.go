@@ -0,0 +1,115 @@
+package apirequest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/apirequest"
+	"github.com/nitrous-io/rise-server/testhelper"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "apirequest")
+}
+
+var _ = Describe("APIRequest", func() {
+	var (
+		db  *gorm.DB
+		err error
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+	})
+
+	record := func(ip string, statusCode int, createdAt time.Time) *apirequest.APIRequest {
+		r := &apirequest.APIRequest{
+			Method:     "GET",
+			Route:      "/projects",
+			Path:       "/projects",
+			StatusCode: statusCode,
+			IP:         ip,
+		}
+		Expect(db.Create(r).Error).To(BeNil())
+		Expect(db.Model(r).Update("created_at", createdAt).Error).To(BeNil())
+		return r
+	}
+
+	Describe("List()", func() {
+		It("returns the requests that match the filter, most recent first", func() {
+			now := time.Now()
+			r1 := record("1.2.3.4", 200, now.Add(-2*time.Hour))
+			r2 := record("1.2.3.4", 401, now.Add(-time.Hour))
+			r3 := record("5.6.7.8", 200, now)
+
+			ids := func(f apirequest.Filter) []uint {
+				reqs, err := apirequest.List(db, f, 10)
+				Expect(err).To(BeNil())
+				var ids []uint
+				for _, r := range reqs {
+					ids = append(ids, r.ID)
+				}
+				return ids
+			}
+
+			Expect(ids(apirequest.Filter{})).To(Equal([]uint{r3.ID, r2.ID, r1.ID}))
+			Expect(ids(apirequest.Filter{IP: "1.2.3.4"})).To(Equal([]uint{r2.ID, r1.ID}))
+			Expect(ids(apirequest.Filter{StatusCode: 200})).To(Equal([]uint{r3.ID, r1.ID}))
+
+			since := now.Add(-90 * time.Minute)
+			Expect(ids(apirequest.Filter{Since: &since})).To(Equal([]uint{r3.ID, r2.ID}))
+			Expect(ids(apirequest.Filter{Until: &since})).To(Equal([]uint{r1.ID}))
+			Expect(ids(apirequest.Filter{BeforeID: r3.ID})).To(Equal([]uint{r2.ID, r1.ID}))
+		})
+	})
+
+	Describe("DeleteOlderThan()", func() {
+		It("deletes requests made before the given time", func() {
+			now := time.Now()
+			record("1.2.3.4", 200, now.Add(-48*time.Hour))
+			r := record("1.2.3.4", 200, now)
+
+			n, err := apirequest.DeleteOlderThan(db, now.Add(-24*time.Hour))
+			Expect(err).To(BeNil())
+			Expect(n).To(Equal(int64(1)))
+
+			reqs, err := apirequest.List(db, apirequest.Filter{}, 10)
+			Expect(err).To(BeNil())
+			Expect(reqs).To(HaveLen(1))
+			Expect(reqs[0].ID).To(Equal(r.ID))
+		})
+	})
+
+	Describe("LastActionsByUserID()", func() {
+		It("returns the most recent successful change made by each user to the project", func() {
+			var uid1, uid2, uid3 uint = 1, 2, 3
+			projName, otherProjName := "foo-bar-express", "baz-qux"
+
+			for _, r := range []*apirequest.APIRequest{
+				{Method: "POST", Route: "/projects/:project_name/deployments", StatusCode: 202, UserID: &uid1, ProjectName: &projName},
+				{Method: "PUT", Route: "/projects/:project_name", StatusCode: 200, UserID: &uid1, ProjectName: &projName},
+				{Method: "POST", Route: "/projects/:project_name/rollback", StatusCode: 409, UserID: &uid1, ProjectName: &projName},
+				{Method: "GET", Route: "/projects/:project_name", StatusCode: 200, UserID: &uid1, ProjectName: &projName},
+				{Method: "GET", Route: "/projects/:project_name", StatusCode: 200, UserID: &uid2, ProjectName: &projName},
+				{Method: "POST", Route: "/projects/:project_name/deployments", StatusCode: 202, UserID: &uid3, ProjectName: &otherProjName},
+			} {
+				r.Path = r.Route
+				Expect(db.Create(r).Error).To(BeNil())
+			}
+
+			actions, err := apirequest.LastActionsByUserID(db, projName, []uint{uid1, uid2, uid3})
+			Expect(err).To(BeNil())
+			Expect(actions).To(HaveLen(1))
+			Expect(actions[uid1].Method).To(Equal("PUT"))
+			Expect(actions[uid1].Route).To(Equal("/projects/:project_name"))
+		})
+	})
+})
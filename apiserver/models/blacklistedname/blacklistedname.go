@@ -1,14 +1,134 @@
 package blacklistedname
 
-import "github.com/jinzhu/gorm"
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+)
+
+// Kinds of blacklisted names.
+const (
+	// KindExact blacklists the name itself.
+	KindExact = "exact"
+	// KindWildcard blacklists names that match a pattern in which "*"
+	// matches any characters, e.g. "*-admin".
+	KindWildcard = "wildcard"
+	// KindRegexp blacklists names that match a regular expression as a whole.
+	KindRegexp = "regexp"
+	// KindPrefix blacklists names that start with a reserved prefix.
+	KindPrefix = "prefix"
+)
+
+var ErrTaken = errors.New("blacklisted name is taken")
+
+// CacheTTL is how long the patterns are cached before they are loaded from
+// the DB again, so patterns that are added or deleted through another server
+// take up to this long to apply.
+var CacheTTL = time.Minute
+
+var cache struct {
+	sync.Mutex
+	matchers []matcher
+	loadedAt time.Time
+}
 
 type BlacklistedName struct {
-	Name string
+	ID        uint `gorm:"primary_key"`
+	Name      string
+	Kind      string `sql:"default:'exact'"`
+	CreatedAt time.Time
 }
 
+// JSON specifies which fields of a blacklisted name will be marshaled to JSON.
+type JSON struct {
+	ID        uint      `json:"id"`
+	Name      string    `json:"name"`
+	Kind      string    `json:"kind"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AsJSON returns a struct that can be converted to JSON
+func (b *BlacklistedName) AsJSON() *JSON {
+	return &JSON{
+		ID:        b.ID,
+		Name:      b.Name,
+		Kind:      b.Kind,
+		CreatedAt: b.CreatedAt,
+	}
+}
+
+// Validate validates BlacklistedName, if there are invalid fields, it returns
+// a map of <field, errors> and returns nil if valid
+func (b *BlacklistedName) Validate() map[string]string {
+	errors := map[string]string{}
+
+	switch b.Kind {
+	case KindExact, KindWildcard, KindRegexp, KindPrefix:
+	default:
+		errors["kind"] = "must be one of exact, wildcard, regexp or prefix"
+	}
+
+	if b.Name == "" {
+		errors["name"] = "is required"
+	} else if len(b.Name) > 255 {
+		errors["name"] = "is too long (max. 255 characters)"
+	} else if b.Kind == KindWildcard && !strings.Contains(b.Name, "*") {
+		errors["name"] = "must contain a wildcard"
+	} else if b.Kind == KindRegexp {
+		if _, err := regexp.Compile(b.Name); err != nil {
+			errors["name"] = "is not a valid regular expression"
+		}
+	}
+
+	if len(errors) == 0 {
+		return nil
+	}
+	return errors
+}
+
+// Insert saves the blacklisted name to the DB, returning ErrTaken if it is
+// already blacklisted.
+func (b *BlacklistedName) Insert(db *gorm.DB) error {
+	err := db.Create(b).Error
+	if e, ok := err.(*pq.Error); ok && e.Code.Name() == "unique_violation" {
+		return ErrTaken
+	}
+	if err == nil {
+		ClearCache()
+	}
+	return err
+}
+
+// All returns all blacklisted names, patterns first.
+func All(db *gorm.DB) ([]*BlacklistedName, error) {
+	var names []*BlacklistedName
+	if err := db.Order("kind = 'exact', kind, name").Find(&names).Error; err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// Delete deletes the blacklisted name with the given ID, and returns false if
+// there is none.
+func Delete(db *gorm.DB, id uint) (bool, error) {
+	q := db.Where("id = ?", id).Delete(BlacklistedName{})
+	if err := q.Error; err != nil {
+		return false, err
+	}
+	ClearCache()
+	return q.RowsAffected > 0, nil
+}
+
+// IsBlacklisted returns whether a project cannot be given the name, either
+// because the name itself is blacklisted or because it matches a pattern.
 func IsBlacklisted(db *gorm.DB, name string) (listed bool, err error) {
 	var count int
-	q := db.Model(&BlacklistedName{}).Where("name = ?", name).Count(&count)
+	q := db.Model(&BlacklistedName{}).Where("kind = ? AND name = ?", KindExact, name).Count(&count)
 	if q.Error != nil {
 		return false, q.Error
 	}
@@ -17,5 +137,78 @@ func IsBlacklisted(db *gorm.DB, name string) (listed bool, err error) {
 		return true, nil
 	}
 
+	matchers, err := cachedMatchers(db)
+	if err != nil {
+		return false, err
+	}
+
+	for _, m := range matchers {
+		if m(name) {
+			return true, nil
+		}
+	}
+
 	return false, nil
 }
+
+// ClearCache makes the next check load the patterns from the DB.
+func ClearCache() {
+	cache.Lock()
+	defer cache.Unlock()
+
+	cache.matchers = nil
+	cache.loadedAt = time.Time{}
+}
+
+type matcher func(name string) bool
+
+func cachedMatchers(db *gorm.DB) ([]matcher, error) {
+	cache.Lock()
+	defer cache.Unlock()
+
+	if !cache.loadedAt.IsZero() && time.Since(cache.loadedAt) < CacheTTL {
+		return cache.matchers, nil
+	}
+
+	var patterns []*BlacklistedName
+	if err := db.Where("kind <> ?", KindExact).Find(&patterns).Error; err != nil {
+		return nil, err
+	}
+
+	matchers := make([]matcher, 0, len(patterns))
+	for _, p := range patterns {
+		if m := p.matcher(); m != nil {
+			matchers = append(matchers, m)
+		}
+	}
+
+	cache.matchers = matchers
+	cache.loadedAt = time.Now()
+	return matchers, nil
+}
+
+// matcher returns a function that matches names against the pattern, or nil
+// if the pattern is invalid.
+func (b *BlacklistedName) matcher() matcher {
+	switch b.Kind {
+	case KindPrefix:
+		prefix := b.Name
+		return func(name string) bool {
+			return strings.HasPrefix(name, prefix)
+		}
+	case KindWildcard:
+		parts := strings.Split(b.Name, "*")
+		for i, part := range parts {
+			parts[i] = regexp.QuoteMeta(part)
+		}
+		re := regexp.MustCompile(`\A` + strings.Join(parts, ".*") + `\z`)
+		return re.MatchString
+	case KindRegexp:
+		re, err := regexp.Compile(`\A(?:` + b.Name + `)\z`)
+		if err != nil {
+			return nil
+		}
+		return re.MatchString
+	}
+	return nil
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/nitrous-io/rise-server/testhelper/factories"
 
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 )
 
@@ -28,6 +29,7 @@ var _ = Describe("BlacklistedName", func() {
 		db, err = dbconn.DB()
 		Expect(err).To(BeNil())
 		testhelper.TruncateTables(db.DB())
+		blacklistedname.ClearCache()
 	})
 
 	Describe("IsBlacklisted()", func() {
@@ -52,5 +54,84 @@ var _ = Describe("BlacklistedName", func() {
 				Expect(blacklisted).To(BeFalse())
 			})
 		})
+
+		Context("there are patterns", func() {
+			BeforeEach(func() {
+				factories.BlacklistedNameWithKind(db, blacklistedname.KindWildcard, "*-admin")
+				factories.BlacklistedNameWithKind(db, blacklistedname.KindWildcard, "api*")
+				factories.BlacklistedNameWithKind(db, blacklistedname.KindRegexp, "[0-9]+")
+				factories.BlacklistedNameWithKind(db, blacklistedname.KindPrefix, "pubstorm-")
+			})
+
+			DescribeTable("it returns whether the name matches any pattern",
+				func(name string, expected bool) {
+					blacklisted, err := blacklistedname.IsBlacklisted(db, name)
+					Expect(err).To(BeNil())
+					Expect(blacklisted).To(Equal(expected))
+				},
+				Entry("wildcard at the start", "super-admin", true),
+				Entry("wildcard at the end", "api-v2", true),
+				Entry("wildcard matching nothing", "api", true),
+				Entry("wildcard not matching", "admin-panel", false),
+				Entry("regexp matching the whole name", "12345", true),
+				Entry("regexp matching part of the name", "foo123", false),
+				Entry("reserved prefix", "pubstorm-blog", true),
+				Entry("reserved prefix elsewhere in the name", "my-pubstorm-blog", false),
+			)
+
+			It("caches the patterns until the cache is cleared", func() {
+				Expect(db.Exec("DELETE FROM blacklisted_names").Error).To(BeNil())
+
+				blacklisted, err := blacklistedname.IsBlacklisted(db, "super-admin")
+				Expect(err).To(BeNil())
+				Expect(blacklisted).To(BeTrue())
+
+				blacklistedname.ClearCache()
+
+				blacklisted, err = blacklistedname.IsBlacklisted(db, "super-admin")
+				Expect(err).To(BeNil())
+				Expect(blacklisted).To(BeFalse())
+			})
+		})
+	})
+
+	Describe("Validate()", func() {
+		DescribeTable("validates the name and kind",
+			func(kind, name string, expected map[string]string) {
+				b := &blacklistedname.BlacklistedName{Kind: kind, Name: name}
+				Expect(b.Validate()).To(Equal(expected))
+			},
+			Entry("valid exact name", blacklistedname.KindExact, "admin", nil),
+			Entry("valid regexp", blacklistedname.KindRegexp, "admin[0-9]*", nil),
+			Entry("missing name", blacklistedname.KindPrefix, "", map[string]string{"name": "is required"}),
+			Entry("invalid kind", "glob", "admin", map[string]string{"kind": "must be one of exact, wildcard, regexp or prefix"}),
+			Entry("wildcard without a wildcard", blacklistedname.KindWildcard, "admin", map[string]string{"name": "must contain a wildcard"}),
+			Entry("invalid regexp", blacklistedname.KindRegexp, "admin(", map[string]string{"name": "is not a valid regular expression"}),
+		)
+	})
+
+	Describe("Insert()", func() {
+		It("returns ErrTaken if the name is already blacklisted as the same kind", func() {
+			factories.BlacklistedNameWithKind(db, blacklistedname.KindPrefix, "api")
+
+			b := &blacklistedname.BlacklistedName{Kind: blacklistedname.KindPrefix, Name: "api"}
+			Expect(b.Insert(db)).To(Equal(blacklistedname.ErrTaken))
+
+			b = &blacklistedname.BlacklistedName{Kind: blacklistedname.KindExact, Name: "api"}
+			Expect(b.Insert(db)).To(BeNil())
+		})
+
+		It("applies the pattern immediately", func() {
+			blacklisted, err := blacklistedname.IsBlacklisted(db, "api-v2")
+			Expect(err).To(BeNil())
+			Expect(blacklisted).To(BeFalse())
+
+			b := &blacklistedname.BlacklistedName{Kind: blacklistedname.KindPrefix, Name: "api"}
+			Expect(b.Insert(db)).To(BeNil())
+
+			blacklisted, err = blacklistedname.IsBlacklisted(db, "api-v2")
+			Expect(err).To(BeNil())
+			Expect(blacklisted).To(BeTrue())
+		})
 	})
 })
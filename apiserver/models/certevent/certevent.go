@@ -0,0 +1,171 @@
+package certevent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/models/domain"
+)
+
+// Cert lifecycle events.
+const (
+	Issued        = "cert.issued"
+	Renewed       = "cert.renewed"
+	RenewalFailed = "cert.renewal_failed"
+	ExpiringSoon  = "cert.expiring_soon"
+	// UnexpectedIssuance is emitted when a cert for a domain issued by a CA
+	// other than the ones we use is found in certificate transparency logs.
+	UnexpectedIssuance = "cert.unexpected_issuance"
+)
+
+// trackedEventNames maps cert lifecycle events to the names of the events
+// that are sent to the tracker.
+var trackedEventNames = map[string]string{
+	Issued:        "SSL Certificate Issued",
+	Renewed:       "SSL Certificate Renewed",
+	RenewalFailed: "SSL Certificate Renewal Failed",
+	ExpiringSoon:  "SSL Certificate Expiring Soon",
+
+	UnexpectedIssuance: "Unexpected SSL Certificate Issued",
+}
+
+// WebhookTimeout is how long the cert events webhook is given to respond.
+var WebhookTimeout = 10 * time.Second
+
+// CertEvent is a database model representing a transition in the lifecycle
+// of the cert of a domain. Cert events form an audit log and are never
+// updated or deleted.
+type CertEvent struct {
+	ID uint `gorm:"primary_key"`
+
+	ProjectID  uint
+	DomainID   uint
+	DomainName string
+
+	Event     string
+	ExpiresAt *time.Time
+	Message   *string
+
+	CreatedAt time.Time
+}
+
+// New returns a cert event for a domain. message is optional.
+func New(dom *domain.Domain, event string, expiresAt *time.Time, message string) *CertEvent {
+	ev := &CertEvent{
+		ProjectID:  dom.ProjectID,
+		DomainID:   dom.ID,
+		DomainName: dom.Name,
+		Event:      event,
+		ExpiresAt:  expiresAt,
+	}
+	if message != "" {
+		ev.Message = &message
+	}
+	return ev
+}
+
+// Emit records a cert event in the audit log, sends it to the tracker on
+// behalf of the project owner and POSTs it to the cert events webhook. Only
+// recording the event can fail, the rest is best-effort and errors are
+// logged.
+func Emit(db *gorm.DB, ev *CertEvent) error {
+	if err := db.Create(ev).Error; err != nil {
+		return err
+	}
+
+	var (
+		userID      uint
+		projectName string
+	)
+	row := db.Table("projects").Where("id = ?", ev.ProjectID).Select("user_id, name").Row()
+	if err := row.Scan(&userID, &projectName); err != nil {
+		log.Errorf("failed to find project %d of cert event %d, err: %v", ev.ProjectID, ev.ID, err)
+		return nil
+	}
+
+	{
+		var (
+			event = trackedEventNames[ev.Event]
+			props = map[string]interface{}{
+				"projectName":   projectName,
+				"domain":        ev.DomainName,
+				"certExpiresAt": ev.ExpiresAt,
+			}
+			context map[string]interface{}
+		)
+		if ev.Message != nil {
+			props["message"] = *ev.Message
+		}
+		if err := common.Track(strconv.Itoa(int(userID)), event, "", props, context); err != nil {
+			log.Errorf("failed to track %q event for user ID %d, err: %v",
+				event, userID, err)
+		}
+	}
+
+	if common.CertEventsWebhookURL != "" {
+		if err := postToWebhook(common.CertEventsWebhookURL, ev, projectName); err != nil {
+			log.Errorf("failed to post cert event %d to webhook, err: %v", ev.ID, err)
+		}
+	}
+
+	return nil
+}
+
+func postToWebhook(url string, ev *CertEvent, projectName string) error {
+	body, err := json.Marshal(struct {
+		ID         uint       `json:"id"`
+		Event      string     `json:"event"`
+		Project    string     `json:"project"`
+		Domain     string     `json:"domain"`
+		ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+		Message    *string    `json:"message,omitempty"`
+		OccurredAt time.Time  `json:"occurred_at"`
+	}{
+		ev.ID,
+		ev.Event,
+		projectName,
+		ev.DomainName,
+		ev.ExpiresAt,
+		ev.Message,
+		ev.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: WebhookTimeout}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected response status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// Exists returns whether an event has already been recorded for the cert of a
+// domain that expires at the given time. It is used to avoid emitting the
+// same event repeatedly.
+func Exists(db *gorm.DB, domainID uint, event string, expiresAt time.Time) (bool, error) {
+	var count int
+	if err := db.Model(CertEvent{}).Where("domain_id = ? AND event = ? AND expires_at = ?", domainID, event, expiresAt).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
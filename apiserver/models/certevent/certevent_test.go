@@ -0,0 +1,169 @@
+package certevent_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/certevent"
+	"github.com/nitrous-io/rise-server/apiserver/models/domain"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/pkg/tracker"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+	"github.com/nitrous-io/rise-server/testhelper/fake"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "certevent")
+}
+
+var _ = Describe("CertEvent", func() {
+	var (
+		db  *gorm.DB
+		err error
+
+		fakeTracker *fake.Tracker
+		origTracker tracker.Trackable
+
+		u         *user.User
+		proj      *project.Project
+		dom       *domain.Domain
+		expiresAt time.Time
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+
+		origTracker = common.Tracker
+		fakeTracker = &fake.Tracker{}
+		common.Tracker = fakeTracker
+
+		u = factories.User(db)
+		proj = factories.Project(db, u)
+		dom = factories.Domain(db, proj, "www.foo-bar.com")
+		expiresAt = time.Date(2016, 6, 1, 0, 0, 0, 0, time.UTC)
+	})
+
+	AfterEach(func() {
+		common.Tracker = origTracker
+	})
+
+	Describe("Emit()", func() {
+		It("records the event", func() {
+			Expect(certevent.Emit(db, certevent.New(dom, certevent.RenewalFailed, &expiresAt, "rate limited"))).To(BeNil())
+
+			ev := &certevent.CertEvent{}
+			Expect(db.Last(ev).Error).To(BeNil())
+			Expect(ev.ProjectID).To(Equal(proj.ID))
+			Expect(ev.DomainID).To(Equal(dom.ID))
+			Expect(ev.DomainName).To(Equal("www.foo-bar.com"))
+			Expect(ev.Event).To(Equal(certevent.RenewalFailed))
+			Expect(ev.ExpiresAt.UTC()).To(Equal(expiresAt))
+			Expect(*ev.Message).To(Equal("rate limited"))
+		})
+
+		It("tracks the event on behalf of the project owner", func() {
+			Expect(certevent.Emit(db, certevent.New(dom, certevent.RenewalFailed, &expiresAt, "rate limited"))).To(BeNil())
+
+			trackCall := fakeTracker.TrackCalls.NthCall(1)
+			Expect(trackCall).NotTo(BeNil())
+			Expect(trackCall.Arguments[0]).To(Equal(fmt.Sprintf("%d", u.ID)))
+			Expect(trackCall.Arguments[1]).To(Equal("SSL Certificate Renewal Failed"))
+
+			props, ok := trackCall.Arguments[3].(map[string]interface{})
+			Expect(ok).To(BeTrue())
+			Expect(props["projectName"]).To(Equal(proj.Name))
+			Expect(props["domain"]).To(Equal("www.foo-bar.com"))
+			Expect(props["message"]).To(Equal("rate limited"))
+		})
+
+		Context("when the cert events webhook is set", func() {
+			var (
+				s        *httptest.Server
+				bodies   chan []byte
+				origURL  string
+				respCode int
+			)
+
+			BeforeEach(func() {
+				bodies = make(chan []byte, 1)
+				respCode = http.StatusOK
+				s = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					b, _ := ioutil.ReadAll(r.Body)
+					bodies <- b
+					w.WriteHeader(respCode)
+				}))
+
+				origURL = common.CertEventsWebhookURL
+				common.CertEventsWebhookURL = s.URL
+			})
+
+			AfterEach(func() {
+				common.CertEventsWebhookURL = origURL
+				s.Close()
+			})
+
+			It("posts the event to the webhook", func() {
+				Expect(certevent.Emit(db, certevent.New(dom, certevent.ExpiringSoon, &expiresAt, ""))).To(BeNil())
+
+				var b []byte
+				Eventually(bodies).Should(Receive(&b))
+
+				var payload map[string]interface{}
+				Expect(json.Unmarshal(b, &payload)).To(BeNil())
+				Expect(payload["event"]).To(Equal("cert.expiring_soon"))
+				Expect(payload["project"]).To(Equal(proj.Name))
+				Expect(payload["domain"]).To(Equal("www.foo-bar.com"))
+				Expect(payload["expires_at"]).To(Equal("2016-06-01T00:00:00Z"))
+				Expect(payload).NotTo(HaveKey("message"))
+			})
+
+			Context("when the webhook fails", func() {
+				BeforeEach(func() {
+					respCode = http.StatusInternalServerError
+				})
+
+				It("still records the event", func() {
+					Expect(certevent.Emit(db, certevent.New(dom, certevent.ExpiringSoon, &expiresAt, ""))).To(BeNil())
+
+					exists, err := certevent.Exists(db, dom.ID, certevent.ExpiringSoon, expiresAt)
+					Expect(err).To(BeNil())
+					Expect(exists).To(BeTrue())
+				})
+			})
+		})
+	})
+
+	Describe("Exists()", func() {
+		It("returns whether the event has been recorded for the cert expiring at the given time", func() {
+			Expect(certevent.Emit(db, certevent.New(dom, certevent.ExpiringSoon, &expiresAt, ""))).To(BeNil())
+
+			exists, err := certevent.Exists(db, dom.ID, certevent.ExpiringSoon, expiresAt)
+			Expect(err).To(BeNil())
+			Expect(exists).To(BeTrue())
+
+			exists, err = certevent.Exists(db, dom.ID, certevent.ExpiringSoon, expiresAt.AddDate(0, 3, 0))
+			Expect(err).To(BeNil())
+			Expect(exists).To(BeFalse())
+
+			exists, err = certevent.Exists(db, dom.ID, certevent.RenewalFailed, expiresAt)
+			Expect(err).To(BeNil())
+			Expect(exists).To(BeFalse())
+		})
+	})
+})
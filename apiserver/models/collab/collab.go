@@ -2,9 +2,16 @@ package collab
 
 import "github.com/jinzhu/gorm"
 
+// RoleCollaborator is the role of collaborators, who can deploy and configure
+// a project, but cannot manage its collaborators or delete it.
+const RoleCollaborator = "collaborator"
+
 type Collab struct {
 	gorm.Model
 
 	UserID    uint
 	ProjectID uint
+
+	// AddedByUserID is nil for collaborators added before it was recorded.
+	AddedByUserID *uint
 }
@@ -0,0 +1,43 @@
+package ctalert
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// CTAlert is a database model representing a cert for a domain that was
+// found in certificate transparency logs and was issued by an unexpected CA.
+// It is recorded when the owner of the domain is alerted so that they are
+// only alerted once per cert.
+type CTAlert struct {
+	ID uint `gorm:"primary_key"`
+
+	DomainID uint
+
+	// LogEntryID is the ID of the cert in the certificate transparency log
+	// search service.
+	LogEntryID int64
+
+	IssuerName string
+	NotBefore  time.Time
+	NotAfter   time.Time
+
+	CreatedAt time.Time
+}
+
+// TableName returns the table name of CTAlert, which gorm would otherwise
+// derive as "c_t_alerts".
+func (a *CTAlert) TableName() string {
+	return "ct_alerts"
+}
+
+// Exists returns whether an alert has already been recorded for a log entry
+// of a domain.
+func Exists(db *gorm.DB, domainID uint, logEntryID int64) (bool, error) {
+	var count int
+	if err := db.Model(&CTAlert{}).Where("domain_id = ? AND log_entry_id = ?", domainID, logEntryID).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
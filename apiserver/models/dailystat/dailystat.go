@@ -0,0 +1,119 @@
+package dailystat
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// DateFormat is the format used for dates in stats JSON.
+const DateFormat = "2006-01-02"
+
+// DailyStat is a database model holding aggregated access log numbers for a
+// domain of a project on a particular (UTC) day.
+type DailyStat struct {
+	ID uint `gorm:"primary_key"`
+
+	ProjectID  uint
+	DomainName string
+	Date       time.Time
+
+	Requests  int64
+	Bytes     int64
+	Status2xx int64 `sql:"column:status_2xx"`
+	Status3xx int64 `sql:"column:status_3xx"`
+	Status4xx int64 `sql:"column:status_4xx"`
+	Status5xx int64 `sql:"column:status_5xx"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// JSON specifies which fields of a daily stat will be marshaled to JSON.
+type JSON struct {
+	Date        string           `json:"date"`
+	Requests    int64            `json:"requests"`
+	Bytes       int64            `json:"bytes"`
+	StatusCodes map[string]int64 `json:"status_codes"`
+}
+
+// AsJSON returns a struct that can be converted to JSON
+func (s *DailyStat) AsJSON() *JSON {
+	return &JSON{
+		Date:     s.Date.Format(DateFormat),
+		Requests: s.Requests,
+		Bytes:    s.Bytes,
+		StatusCodes: map[string]int64{
+			"2xx": s.Status2xx,
+			"3xx": s.Status3xx,
+			"4xx": s.Status4xx,
+			"5xx": s.Status5xx,
+		},
+	}
+}
+
+// AddStatus counts a response with the given HTTP status code.
+func (s *DailyStat) AddStatus(status int) {
+	switch {
+	case status >= 200 && status < 300:
+		s.Status2xx++
+	case status >= 300 && status < 400:
+		s.Status3xx++
+	case status >= 400 && status < 500:
+		s.Status4xx++
+	case status >= 500 && status < 600:
+		s.Status5xx++
+	}
+}
+
+// Increment adds the numbers in s to the existing stat row for the same
+// project, domain and date, inserting the row if it does not exist yet. On
+// return, s contains the updated totals.
+func Increment(db *gorm.DB, s *DailyStat) error {
+	return db.Raw(`WITH update_stat AS (
+		UPDATE daily_stats
+		SET requests = requests + $4, bytes = bytes + $5, status_2xx = status_2xx + $6, status_3xx = status_3xx + $7, status_4xx = status_4xx + $8, status_5xx = status_5xx + $9, updated_at = now()
+		WHERE project_id = $1 AND domain_name = $2 AND date = $3 RETURNING *
+	), insert_stat AS (
+		INSERT INTO
+		daily_stats (project_id, domain_name, date, requests, bytes, status_2xx, status_3xx, status_4xx, status_5xx)
+		SELECT $1, $2, $3, $4, $5, $6, $7, $8, $9 WHERE NOT EXISTS (SELECT * FROM update_stat) RETURNING *
+	) SELECT * FROM update_stat UNION ALL SELECT * FROM insert_stat;
+	`,
+		s.ProjectID,               // $1
+		s.DomainName,              // $2
+		s.Date.Format(DateFormat), // $3
+		s.Requests,                // $4
+		s.Bytes,                   // $5
+		s.Status2xx,               // $6
+		s.Status3xx,               // $7
+		s.Status4xx,               // $8
+		s.Status5xx,               // $9
+	).Scan(s).Error
+}
+
+// FindByProject returns the daily stats of a project between from and to
+// (inclusive), ordered by domain name and date.
+func FindByProject(db *gorm.DB, projectID uint, from, to time.Time) ([]*DailyStat, error) {
+	var stats []*DailyStat
+	if err := db.Where("project_id = ? AND date >= ? AND date <= ?", projectID, from.Format(DateFormat), to.Format(DateFormat)).
+		Order("domain_name ASC, date ASC").
+		Find(&stats).Error; err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// FindByDomain returns the daily stat of a domain of a project on the given
+// date, or nil if nothing has been served for the domain on that date.
+func FindByDomain(db *gorm.DB, projectID uint, domainName string, date time.Time) (*DailyStat, error) {
+	s := &DailyStat{}
+	if err := db.Where("project_id = ? AND domain_name = ? AND date = ?", projectID, domainName, date.Format(DateFormat)).
+		First(s).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return s, nil
+}
@@ -0,0 +1,143 @@
+package dailystat_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/dailystat"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "dailystat")
+}
+
+var _ = Describe("DailyStat", func() {
+	var (
+		db   *gorm.DB
+		err  error
+		proj *project.Project
+		day  time.Time
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+
+		proj = factories.Project(db, nil)
+		day = time.Date(2016, 6, 1, 0, 0, 0, 0, time.UTC)
+	})
+
+	Describe("AddStatus()", func() {
+		It("counts the status code in its class", func() {
+			s := &dailystat.DailyStat{}
+			for _, status := range []int{200, 204, 301, 404, 403, 410, 503, 99} {
+				s.AddStatus(status)
+			}
+
+			Expect(s.Status2xx).To(Equal(int64(2)))
+			Expect(s.Status3xx).To(Equal(int64(1)))
+			Expect(s.Status4xx).To(Equal(int64(3)))
+			Expect(s.Status5xx).To(Equal(int64(1)))
+		})
+	})
+
+	Describe("Increment()", func() {
+		It("inserts a row if one does not exist", func() {
+			s := &dailystat.DailyStat{
+				ProjectID:  proj.ID,
+				DomainName: "www.example.com",
+				Date:       day,
+				Requests:   2,
+				Bytes:      1024,
+				Status2xx:  2,
+			}
+			Expect(dailystat.Increment(db, s)).To(BeNil())
+			Expect(s.ID).NotTo(BeZero())
+
+			var count int
+			Expect(db.Model(dailystat.DailyStat{}).Count(&count).Error).To(BeNil())
+			Expect(count).To(Equal(1))
+		})
+
+		It("adds to the existing row for the same project, domain and date", func() {
+			for i := 0; i < 2; i++ {
+				s := &dailystat.DailyStat{
+					ProjectID:  proj.ID,
+					DomainName: "www.example.com",
+					Date:       day,
+					Requests:   2,
+					Bytes:      1024,
+					Status2xx:  1,
+					Status5xx:  1,
+				}
+				Expect(dailystat.Increment(db, s)).To(BeNil())
+			}
+
+			var stats []*dailystat.DailyStat
+			Expect(db.Find(&stats).Error).To(BeNil())
+			Expect(stats).To(HaveLen(1))
+			Expect(stats[0].Requests).To(Equal(int64(4)))
+			Expect(stats[0].Bytes).To(Equal(int64(2048)))
+			Expect(stats[0].Status2xx).To(Equal(int64(2)))
+			Expect(stats[0].Status5xx).To(Equal(int64(2)))
+		})
+	})
+
+	Describe("FindByProject()", func() {
+		BeforeEach(func() {
+			otherProj := factories.Project(db, nil)
+
+			for _, st := range []*dailystat.DailyStat{
+				{ProjectID: proj.ID, DomainName: "www.example.com", Date: day.AddDate(0, 0, 1), Requests: 1},
+				{ProjectID: proj.ID, DomainName: "www.example.com", Date: day, Requests: 1},
+				{ProjectID: proj.ID, DomainName: "www.example.com", Date: day.AddDate(0, 0, -1), Requests: 1},
+				{ProjectID: otherProj.ID, DomainName: "www.other.com", Date: day, Requests: 1},
+			} {
+				Expect(dailystat.Increment(db, st)).To(BeNil())
+			}
+		})
+
+		It("returns the stats of the project within the range in date order", func() {
+			stats, err := dailystat.FindByProject(db, proj.ID, day, day.AddDate(0, 0, 1))
+			Expect(err).To(BeNil())
+			Expect(stats).To(HaveLen(2))
+			Expect(stats[0].Date.Format(dailystat.DateFormat)).To(Equal("2016-06-01"))
+			Expect(stats[1].Date.Format(dailystat.DateFormat)).To(Equal("2016-06-02"))
+		})
+	})
+
+	Describe("FindByDomain()", func() {
+		BeforeEach(func() {
+			for _, st := range []*dailystat.DailyStat{
+				{ProjectID: proj.ID, DomainName: "www.example.com", Date: day, Requests: 2},
+				{ProjectID: proj.ID, DomainName: "www.example.com", Date: day.AddDate(0, 0, 1), Requests: 3},
+				{ProjectID: proj.ID, DomainName: "www.other.com", Date: day, Requests: 5},
+			} {
+				Expect(dailystat.Increment(db, st)).To(BeNil())
+			}
+		})
+
+		It("returns the stat of the domain on the date", func() {
+			s, err := dailystat.FindByDomain(db, proj.ID, "www.example.com", day)
+			Expect(err).To(BeNil())
+			Expect(s).NotTo(BeNil())
+			Expect(s.Requests).To(Equal(int64(2)))
+		})
+
+		It("returns nil if nothing was served for the domain on the date", func() {
+			s, err := dailystat.FindByDomain(db, proj.ID, "www.example.com", day.AddDate(0, 0, 2))
+			Expect(err).To(BeNil())
+			Expect(s).To(BeNil())
+		})
+	})
+})
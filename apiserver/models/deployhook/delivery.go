@@ -0,0 +1,189 @@
+package deployhook
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/pkg/safehttp"
+)
+
+var (
+	// Timeout is how long each post-deploy hook is given to respond.
+	Timeout = 10 * time.Second
+
+	// MaxDeliveriesKept is the number of deliveries of each hook that are
+	// kept. Older deliveries are deleted when a hook is delivered.
+	MaxDeliveriesKept = 50
+
+	// AllowPrivateIPs is whether hooks can be delivered to loopback and
+	// private addresses. It is only meant to be set in tests, as users could
+	// otherwise make the deployer call internal services and read their
+	// responses in the response snippets of deliveries.
+	AllowPrivateIPs = false
+)
+
+// responseSnippetLength is the maximum number of bytes of the response to a
+// delivery that are stored.
+const responseSnippetLength = 1024
+
+// Delivery is a database model representing an attempt to call a post-deploy
+// hook, which is kept so that integrators can debug their hooks and replay
+// deliveries that they missed.
+type Delivery struct {
+	ID           uint `gorm:"primary_key"`
+	DeployHookID uint
+	DeploymentID uint
+	// ReplayOfID is the ID of the delivery that this delivery replayed, if
+	// any.
+	ReplayOfID *uint
+
+	Payload string
+	// StatusCode is nil if no response was received.
+	StatusCode      *int
+	LatencyMs       int64
+	ResponseSnippet string
+	ErrorMessage    *string
+
+	CreatedAt time.Time
+}
+
+// TableName returns the name of the table of deliveries.
+func (d Delivery) TableName() string {
+	return "deploy_hook_deliveries"
+}
+
+// DeliveryJSON specifies which fields of a delivery will be marshaled to JSON.
+type DeliveryJSON struct {
+	ID              uint      `json:"id"`
+	DeploymentID    uint      `json:"deployment_id"`
+	ReplayOfID      *uint     `json:"replay_of_id"`
+	Succeeded       bool      `json:"succeeded"`
+	StatusCode      *int      `json:"status_code"`
+	LatencyMs       int64     `json:"latency_ms"`
+	Payload         string    `json:"payload"`
+	ResponseSnippet string    `json:"response_snippet"`
+	ErrorMessage    *string   `json:"error_message"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// AsJSON returns a struct that can be converted to JSON
+func (d *Delivery) AsJSON() interface{} {
+	return DeliveryJSON{
+		ID:              d.ID,
+		DeploymentID:    d.DeploymentID,
+		ReplayOfID:      d.ReplayOfID,
+		Succeeded:       d.Succeeded(),
+		StatusCode:      d.StatusCode,
+		LatencyMs:       d.LatencyMs,
+		Payload:         d.Payload,
+		ResponseSnippet: d.ResponseSnippet,
+		ErrorMessage:    d.ErrorMessage,
+		CreatedAt:       d.CreatedAt,
+	}
+}
+
+// Succeeded returns whether the hook responded with a 2xx status.
+func (d *Delivery) Succeeded() bool {
+	return d.StatusCode != nil && *d.StatusCode >= 200 && *d.StatusCode <= 299
+}
+
+// Deliver POSTs the JSON payload to the URL of the hook and records the
+// attempt. A failed call is recorded in the returned delivery, and an error is
+// only returned if the delivery could not be saved.
+func (h *DeployHook) Deliver(db *gorm.DB, deploymentID uint, payload []byte, replayOfID *uint) (*Delivery, error) {
+	d := &Delivery{
+		DeployHookID: h.ID,
+		DeploymentID: deploymentID,
+		ReplayOfID:   replayOfID,
+		Payload:      string(payload),
+	}
+
+	start := time.Now()
+	statusCode, snippet, err := call(*h.URL, payload)
+	d.LatencyMs = int64(time.Since(start) / time.Millisecond)
+	d.ResponseSnippet = snippet
+	if statusCode != 0 {
+		d.StatusCode = &statusCode
+	}
+	if err == nil && !d.Succeeded() {
+		err = fmt.Errorf("unexpected response status %d", statusCode)
+	}
+	if err != nil {
+		errMsg := err.Error()
+		d.ErrorMessage = &errMsg
+	}
+
+	if err := db.Create(d).Error; err != nil {
+		return nil, err
+	}
+
+	if err := db.Exec(`DELETE FROM deploy_hook_deliveries WHERE deploy_hook_id = ? AND id NOT IN (
+		SELECT id FROM deploy_hook_deliveries WHERE deploy_hook_id = ? ORDER BY id DESC LIMIT ?
+	)`, h.ID, h.ID, MaxDeliveriesKept).Error; err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// FindDeliveries returns the deliveries of a hook, most recent first.
+func FindDeliveries(db *gorm.DB, hookID uint) ([]*Delivery, error) {
+	var deliveries []*Delivery
+	if err := db.Where("deploy_hook_id = ?", hookID).Order("id DESC").Find(&deliveries).Error; err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// FindDelivery returns the delivery of a hook with the given ID, or nil if it
+// does not exist.
+func FindDelivery(db *gorm.DB, hookID, id uint) (*Delivery, error) {
+	d := &Delivery{}
+	if err := db.Where("id = ? AND deploy_hook_id = ?", id, hookID).First(d).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return d, nil
+}
+
+// call POSTs the payload to the URL and returns the status code and the start
+// of the body of the response. The status code is 0 if no response was
+// received. URLs that resolve to private addresses are refused.
+func call(url string, payload []byte) (int, string, error) {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(payload))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "PubStorm-Hooks")
+
+	res, err := safehttp.NewClient(Timeout, AllowPrivateIPs).Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer res.Body.Close()
+
+	b := &bytes.Buffer{}
+	if _, err := io.CopyN(b, res.Body, responseSnippetLength); err != nil && err != io.EOF {
+		return res.StatusCode, "", err
+	}
+
+	// Postgres rejects text that contains NUL characters or is not valid
+	// UTF-8, which binary responses or a multi-byte character cut in half
+	// would be. strings.Map replaces invalid bytes with U+FFFD.
+	snippet := strings.Map(func(r rune) rune {
+		if r == 0 {
+			return -1
+		}
+		return r
+	}, b.String())
+
+	return res.StatusCode, snippet, nil
+}
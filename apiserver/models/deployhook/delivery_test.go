@@ -0,0 +1,158 @@
+package deployhook_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployhook"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Delivery", func() {
+	var (
+		db  *gorm.DB
+		err error
+
+		hookServer *httptest.Server
+		status     int
+		body       string
+		received   []string
+
+		hook *deployhook.DeployHook
+		depl *deployment.Deployment
+
+		payload = []byte(`{"event":"deployed"}`)
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+
+		deployhook.AllowPrivateIPs = true
+
+		status = http.StatusOK
+		body = "ok"
+		received = nil
+		hookServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, err := ioutil.ReadAll(r.Body)
+			Expect(err).To(BeNil())
+			received = append(received, string(b))
+
+			w.WriteHeader(status)
+			w.Write([]byte(body))
+		}))
+
+		u := factories.User(db)
+		proj := factories.Project(db, u)
+		depl = factories.Deployment(db, proj, u, deployment.StateDeployed)
+
+		hook = &deployhook.DeployHook{ProjectID: proj.ID, Stage: deployhook.StagePostDeploy, URL: &hookServer.URL}
+		Expect(db.Create(hook).Error).To(BeNil())
+	})
+
+	AfterEach(func() {
+		hookServer.Close()
+		deployhook.AllowPrivateIPs = false
+	})
+
+	Describe("Deliver()", func() {
+		It("POSTs the payload to the hook and records the delivery", func() {
+			d, err := hook.Deliver(db, depl.ID, payload, nil)
+			Expect(err).To(BeNil())
+			Expect(received).To(Equal([]string{string(payload)}))
+
+			Expect(d.Succeeded()).To(BeTrue())
+			Expect(*d.StatusCode).To(Equal(http.StatusOK))
+			Expect(d.ResponseSnippet).To(Equal("ok"))
+			Expect(d.ErrorMessage).To(BeNil())
+
+			deliveries, err := deployhook.FindDeliveries(db, hook.ID)
+			Expect(err).To(BeNil())
+			Expect(deliveries).To(HaveLen(1))
+			Expect(deliveries[0].DeploymentID).To(Equal(depl.ID))
+			Expect(deliveries[0].Payload).To(Equal(string(payload)))
+		})
+
+		It("records failed deliveries with the start of the response", func() {
+			status = http.StatusBadGateway
+			body = strings.Repeat("a", 2000)
+
+			d, err := hook.Deliver(db, depl.ID, payload, nil)
+			Expect(err).To(BeNil())
+
+			Expect(d.Succeeded()).To(BeFalse())
+			Expect(*d.StatusCode).To(Equal(http.StatusBadGateway))
+			Expect(d.ResponseSnippet).To(HaveLen(1024))
+			Expect(*d.ErrorMessage).To(Equal("unexpected response status 502"))
+		})
+
+		It("records deliveries for which no response was received", func() {
+			hookServer.Close()
+
+			d, err := hook.Deliver(db, depl.ID, payload, nil)
+			Expect(err).To(BeNil())
+
+			Expect(d.Succeeded()).To(BeFalse())
+			Expect(d.StatusCode).To(BeNil())
+			Expect(d.ErrorMessage).NotTo(BeNil())
+		})
+
+		It("refuses to deliver to private addresses", func() {
+			deployhook.AllowPrivateIPs = false
+
+			d, err := hook.Deliver(db, depl.ID, payload, nil)
+			Expect(err).To(BeNil())
+			Expect(received).To(BeEmpty())
+
+			Expect(d.Succeeded()).To(BeFalse())
+			Expect(d.StatusCode).To(BeNil())
+			Expect(d.ResponseSnippet).To(BeEmpty())
+			Expect(*d.ErrorMessage).To(ContainSubstring("address is not publicly routable"))
+		})
+
+		It("only keeps the most recent deliveries", func() {
+			origMax := deployhook.MaxDeliveriesKept
+			deployhook.MaxDeliveriesKept = 2
+			defer func() {
+				deployhook.MaxDeliveriesKept = origMax
+			}()
+
+			var ids []uint
+			for i := 0; i < 3; i++ {
+				d, err := hook.Deliver(db, depl.ID, payload, nil)
+				Expect(err).To(BeNil())
+				ids = append(ids, d.ID)
+			}
+
+			deliveries, err := deployhook.FindDeliveries(db, hook.ID)
+			Expect(err).To(BeNil())
+			Expect([]uint{deliveries[0].ID, deliveries[1].ID}).To(Equal([]uint{ids[2], ids[1]}))
+			Expect(deliveries).To(HaveLen(2))
+		})
+	})
+
+	Describe("FindDelivery()", func() {
+		It("only returns deliveries of the hook", func() {
+			d, err := hook.Deliver(db, depl.ID, payload, nil)
+			Expect(err).To(BeNil())
+
+			found, err := deployhook.FindDelivery(db, hook.ID, d.ID)
+			Expect(err).To(BeNil())
+			Expect(found.ID).To(Equal(d.ID))
+
+			found, err = deployhook.FindDelivery(db, hook.ID+1, d.ID)
+			Expect(err).To(BeNil())
+			Expect(found).To(BeNil())
+		})
+	})
+})
@@ -0,0 +1,123 @@
+package deployhook
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Stages at which hooks are run.
+const (
+	// StagePreBuild hooks are commands that are run by the builder in a
+	// sandbox in which the bundle is mounted, before assets are optimized.
+	StagePreBuild = "pre_build"
+	// StagePostDeploy hooks are HTTPS URLs that the deployer POSTs to after a
+	// deployment has been activated.
+	StagePostDeploy = "post_deploy"
+)
+
+const (
+	// MaxPerProject is the maximum number of hooks a project can have.
+	MaxPerProject = 10
+
+	maxCommandLength = 1024
+	maxURLLength     = 2048
+)
+
+// DeployHook is a database model representing a command or an HTTPS call that
+// is run automatically during the deployment of a project, e.g. to warm
+// caches, ping search engines or notify other systems.
+type DeployHook struct {
+	gorm.Model
+
+	ProjectID uint
+
+	Stage   string
+	Command *string
+	URL     *string `sql:"column:url"`
+}
+
+// JSON specifies which fields of a deploy hook will be marshaled to JSON.
+type JSON struct {
+	ID        uint      `json:"id"`
+	Stage     string    `json:"stage"`
+	Command   *string   `json:"command,omitempty"`
+	URL       *string   `json:"url,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AsJSON returns a struct that can be converted to JSON
+func (h *DeployHook) AsJSON() interface{} {
+	return JSON{
+		ID:        h.ID,
+		Stage:     h.Stage,
+		Command:   h.Command,
+		URL:       h.URL,
+		CreatedAt: h.CreatedAt,
+	}
+}
+
+// Validate validates DeployHook, if there are invalid fields, it returns a
+// map of <field, errors> and returns nil if valid
+func (h *DeployHook) Validate() map[string]string {
+	errors := map[string]string{}
+
+	switch h.Stage {
+	case StagePreBuild:
+		if h.Command == nil || *h.Command == "" {
+			errors["command"] = "is required"
+		} else if len(*h.Command) > maxCommandLength {
+			errors["command"] = "is too long (max. 1024 characters)"
+		}
+
+		if h.URL != nil {
+			errors["url"] = "is not allowed for pre_build hooks"
+		}
+	case StagePostDeploy:
+		if h.URL == nil || *h.URL == "" {
+			errors["url"] = "is required"
+		} else if len(*h.URL) > maxURLLength {
+			errors["url"] = "is too long (max. 2048 characters)"
+		} else if u, err := url.Parse(*h.URL); err != nil || u.Scheme != "https" || u.Host == "" || u.User != nil {
+			errors["url"] = "must be an https URL"
+		}
+
+		if h.Command != nil {
+			errors["command"] = "is not allowed for post_deploy hooks"
+		}
+	case "":
+		errors["stage"] = "is required"
+	default:
+		errors["stage"] = "is invalid"
+	}
+
+	if len(errors) == 0 {
+		return nil
+	}
+	return errors
+}
+
+// FindByProjectID returns the hooks of a project in the order in which they
+// were added. If stage is not empty, only hooks of that stage are returned.
+func FindByProjectID(db *gorm.DB, projectID uint, stage string) ([]*DeployHook, error) {
+	q := db.Where("project_id = ?", projectID)
+	if stage != "" {
+		q = q.Where("stage = ?", stage)
+	}
+
+	var hooks []*DeployHook
+	if err := q.Order("id ASC").Find(&hooks).Error; err != nil {
+		return nil, err
+	}
+	return hooks, nil
+}
+
+// CountByProjectID returns the number of hooks of a project.
+func CountByProjectID(db *gorm.DB, projectID uint) (int, error) {
+	var count int
+	if err := db.Model(DeployHook{}).Where("project_id = ?", projectID).Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
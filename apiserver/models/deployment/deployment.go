@@ -1,11 +1,17 @@
 package deployment
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"time"
 
 	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/pkg/aesencrypter"
+	"github.com/nitrous-io/rise-server/shared"
 )
 
 // Allowed deployment states.
@@ -20,11 +26,86 @@ const (
 	StateBuilt               = "built"
 	StateBuildFailed         = "build_failed"
 	StatePendingUpdateConfig = "pending_update_config"
+	// StateQueued is the state of deployments that wait for an earlier
+	// deployment of the same project to finish before they are built or
+	// deployed.
+	StateQueued = "queued"
 )
 
+// Codes of the errors that deployments fail with, so that clients can tell
+// why a deployment failed without parsing its error message.
+const (
+	ErrorCodeRootDirNotFound = "root_dir_not_found"
+	ErrorCodeTooManyFiles    = "too_many_files"
+	ErrorCodeFileTooLarge    = "file_too_large"
+	// ErrorCodeVerificationFailed is the code of deployments that are missing
+	// one of their verification URLs.
+	ErrorCodeVerificationFailed = "verification_failed"
+	// ErrorCodeInvalidBundle is the code of deployments whose bundles could
+	// not be unpacked, i.e. ones that are not zip or gzipped tar archives.
+	ErrorCodeInvalidBundle = "invalid_bundle"
+	// ErrorCodeChecksumMismatch is the code of deployments whose bundles do
+	// not match the checksum sent by the client, i.e. ones that were
+	// corrupted during upload.
+	ErrorCodeChecksumMismatch = "checksum_mismatch"
+)
+
+// Sources of deployments, i.e. what created them, so that teams can tell which
+// pipeline produced a version.
+const (
+	SourceCLI     = "cli"
+	SourceCI      = "ci"
+	SourceWeb     = "web"
+	SourceWebhook = "webhook"
+	// SourceAPI is the source of deployments that were created by other API
+	// clients.
+	SourceAPI = "api"
+)
+
+// MaxNoteLength is the maximum number of characters in the note of a
+// deployment.
+const MaxNoteLength = 1000
+
+// Limits of the VCS metadata of a deployment. Commit messages that are longer
+// are truncated instead of rejected, as they are only informational.
+const (
+	MaxCommitMessageLength = 2000
+	MaxBranchLength        = 255
+)
+
+// Phases of a deployment whose durations are recorded.
+const (
+	PhaseUpload = "upload"
+	PhaseBuild  = "build"
+	PhaseDeploy = "deploy"
+)
+
+// StateChangesChannel is the Postgres channel that deployment state changes
+// are sent to with NOTIFY, so that they can be picked up with LISTEN instead
+// of polling the deployments table.
+const StateChangesChannel = "deployment_state_changes"
+
+// StateChange is the payload of a notification on StateChangesChannel.
+type StateChange struct {
+	ID        uint   `json:"id"`
+	ProjectID uint   `json:"project_id"`
+	State     string `json:"state"`
+
+	// Progress is set instead of State for the progress messages that workers
+	// publish while the deployment is in the same state.
+	Progress *Progress `json:"progress,omitempty"`
+}
+
+// Progress is a progress message of a deployment, e.g. "Optimizing assets".
+type Progress struct {
+	Message   string    `json:"message"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
 // Errors returned from this package.
 var (
 	ErrInvalidState = errors.New("state is not valid")
+	ErrInvalidPhase = errors.New("phase is not valid")
 )
 
 // Deployment is a database model representing a particular deploy of a Project.
@@ -42,41 +123,238 @@ type Deployment struct {
 	RawBundleID *uint
 	TemplateID  *uint
 
+	// RootDir is the directory of the raw bundle that is deployed. It is empty
+	// if the whole bundle is deployed.
+	RootDir string
+
+	// Source is what created the deployment, e.g. SourceCLI. It is empty for
+	// deployments that were created before sources were recorded.
+	Source string
+
+	// Preview is whether the deployment is only served at its preview domain
+	// instead of replacing the active deployment of the project, until it is
+	// promoted.
+	Preview bool
+
+	// PreviewBasicAuthUsername and EncryptedPreviewBasicAuthPassword protect
+	// the preview domain of a preview deployment with basic auth, if it was
+	// created with preview_basic_auth. The password is generated when the
+	// deployment is created, and PreviewBasicAuthPassword only holds it until
+	// it is returned to the client, as only its hash is stored.
+	PreviewBasicAuthUsername          *string
+	PreviewBasicAuthPassword          string `sql:"-"`
+	EncryptedPreviewBasicAuthPassword *string
+
+	// CommitSHA, CommitMessage and Branch describe the commit that was
+	// deployed, e.g. by CI, so that deployments can be correlated with source
+	// history. They are empty if the client did not send them.
+	CommitSHA     string `sql:"column:commit_sha"`
+	CommitMessage string
+	Branch        string
+
+	// JsEnvVars holds the JS env vars of deployments that were created before
+	// they were encrypted, until the encryptjsenvvars job migrates them to
+	// EncryptedJsEnvVars. Use DecryptedJsEnvVars() to read them.
 	JsEnvVars []byte `sql:"default:{}"`
+	// EncryptedJsEnvVars stores the base64-encoded, encrypted JSON object of
+	// JS env vars.
+	EncryptedJsEnvVars *string
 
 	DeployedAt *time.Time
 	PurgedAt   *time.Time
+	// PinnedAt is when the deployment was pinned. Pinned deployments are
+	// never deleted to keep the project within its MaxDeploysKept, so that
+	// they can always be rolled back to, e.g. releases.
+	PinnedAt *time.Time
+
+	// Report stores the JSON-encoded Report of the files that were deployed.
+	// Use ReportData() to read it.
+	Report []byte
+
+	// How long each phase of the deployment took, and the total size in
+	// bytes of the files that were deployed. They are nil for phases that did
+	// not run, e.g. the build of projects that skip builds.
+	UploadDurationMs *int64
+	BuildDurationMs  *int64
+	DeployDurationMs *int64
+	WebrootSize      *int64
 
 	ErrorMessage *string
+	ErrorCode    *string
+
+	// Note is a free-form note that users can attach to a deployment after the
+	// fact, e.g. why it was rolled back.
+	Note *string
+
+	// Settings stores the JSON-encoded Settings the deployment is deployed
+	// with. Use SettingsData() to read them.
+	Settings []byte
 }
 
 // JSON specifies which fields of a deployment will be marshaled to JSON.
 type JSON struct {
-	ID           uint       `json:"id"`
-	State        string     `json:"state"`
-	Version      int64      `json:"version"`
-	Active       bool       `json:"active,omitempty"`
-	DeployedAt   *time.Time `json:"deployed_at,omitempty"`
-	ErrorMessage *string    `json:"error_message,omitempty"`
+	ID               uint                  `json:"id"`
+	State            string                `json:"state"`
+	Version          int64                 `json:"version"`
+	Active           bool                  `json:"active,omitempty"`
+	RootDir          string                `json:"root_dir,omitempty"`
+	Source           string                `json:"source,omitempty"`
+	Preview          bool                  `json:"preview,omitempty"`
+	PreviewURL       string                `json:"preview_url,omitempty"`
+	PreviewBasicAuth *PreviewBasicAuthJSON `json:"preview_basic_auth,omitempty"`
+	CommitSHA        string                `json:"commit_sha,omitempty"`
+	CommitMessage    string                `json:"commit_message,omitempty"`
+	Branch           string                `json:"branch,omitempty"`
+	DeployedAt       *time.Time            `json:"deployed_at,omitempty"`
+	PinnedAt         *time.Time            `json:"pinned_at,omitempty"`
+	ErrorMessage     *string               `json:"error_message,omitempty"`
+	ErrorCode        *string               `json:"error_code,omitempty"`
+	Note             *string               `json:"note,omitempty"`
+
+	UploadDurationMs *int64 `json:"upload_duration_ms,omitempty"`
+	BuildDurationMs  *int64 `json:"build_duration_ms,omitempty"`
+	DeployDurationMs *int64 `json:"deploy_duration_ms,omitempty"`
+	WebrootSize      *int64 `json:"webroot_size,omitempty"`
+
+	// Queue is only set by endpoints that look up the queue status of pending
+	// deployments.
+	Queue *QueueStatusJSON `json:"queue,omitempty"`
+}
+
+// PreviewBasicAuthJSON is the basic auth credentials of a preview domain. The
+// password is only included when the deployment is created.
+type PreviewBasicAuthJSON struct {
+	Username string `json:"username"`
+	Password string `json:"password,omitempty"`
 }
 
 // AsJSON returns a struct that can be converted to JSON
 func (d *Deployment) AsJSON() *JSON {
+	var previewURL string
+	if d.Preview {
+		previewURL = "https://" + d.PreviewDomainName()
+	}
+
+	var previewBasicAuth *PreviewBasicAuthJSON
+	if d.PreviewBasicAuthUsername != nil {
+		previewBasicAuth = &PreviewBasicAuthJSON{
+			Username: *d.PreviewBasicAuthUsername,
+			Password: d.PreviewBasicAuthPassword,
+		}
+	}
+
 	return &JSON{
-		ID:           d.ID,
-		State:        d.State,
-		Version:      d.Version,
-		DeployedAt:   d.DeployedAt,
-		ErrorMessage: d.ErrorMessage,
+		ID:               d.ID,
+		State:            d.State,
+		Version:          d.Version,
+		RootDir:          d.RootDir,
+		Source:           d.Source,
+		Preview:          d.Preview,
+		PreviewURL:       previewURL,
+		PreviewBasicAuth: previewBasicAuth,
+		CommitSHA:        d.CommitSHA,
+		CommitMessage:    d.CommitMessage,
+		Branch:           d.Branch,
+		DeployedAt:       d.DeployedAt,
+		PinnedAt:         d.PinnedAt,
+		ErrorMessage:     d.ErrorMessage,
+		ErrorCode:        d.ErrorCode,
+		Note:             d.Note,
+
+		UploadDurationMs: d.UploadDurationMs,
+		BuildDurationMs:  d.BuildDurationMs,
+		DeployDurationMs: d.DeployDurationMs,
+		WebrootSize:      d.WebrootSize,
 	}
 }
 
+// Finished returns whether the deployment has been deployed or has failed,
+// after which its state only changes if it is rolled back to.
+func (d *Deployment) Finished() bool {
+	return d.State == StateDeployed || d.State == StateDeployFailed || d.State == StateBuildFailed
+}
+
 // PrefixID returns prefix and ID in <prefix>-<id> format
 func (d *Deployment) PrefixID() string {
 	return fmt.Sprintf("%s-%d", d.Prefix, d.ID)
 }
 
-// PreviousCompletedDeployment returns previous deployment of current deployment
+// PreviewDomainName returns the domain that a preview deployment is served at,
+// e.g. "3f2a9c0e1b4d7a6c-42.preview.rise.cloud".
+func (d *Deployment) PreviewDomainName() string {
+	return d.PrefixID() + ".preview." + shared.DefaultDomain
+}
+
+// BuildLogPath returns the path in S3 of the log of the deployment's build.
+func (d *Deployment) BuildLogPath() string {
+	return "deployments/" + d.PrefixID() + "/build.log"
+}
+
+// ManifestPath returns the path in S3 of the manifest of the files that were
+// deployed, which the deployer compares with the manifest of the next
+// deployment to tell which files changed.
+func (d *Deployment) ManifestPath() string {
+	return "deployments/" + d.PrefixID() + "/manifest.json"
+}
+
+// GeneratePreviewBasicAuth generates basic auth credentials for the preview
+// domain of the deployment. Only the hash of the password is stored, in the
+// same format as the basic auth passwords of projects, which edges check.
+func (d *Deployment) GeneratePreviewBasicAuth() error {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+
+	username := "preview"
+	password := hex.EncodeToString(b)
+
+	hasher := sha256.New()
+	if _, err := hasher.Write([]byte(username + ":" + password)); err != nil {
+		return err
+	}
+	encryptedPassword := hex.EncodeToString(hasher.Sum(nil))
+
+	d.PreviewBasicAuthUsername = &username
+	d.PreviewBasicAuthPassword = password
+	d.EncryptedPreviewBasicAuthPassword = &encryptedPassword
+	return nil
+}
+
+// NewPrefix returns a random prefix to replace the prefix of a deployment
+// with. It is longer than the prefixes deployments are created with, as the
+// prefix it replaces may have leaked.
+func NewPrefix() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// ChangePrefix sets the prefix of the deployment, and updates the paths of
+// raw bundles that were uploaded under the old prefix. The files of the
+// deployment must have been copied to the new prefix already.
+func (d *Deployment) ChangePrefix(db *gorm.DB, prefix string) error {
+	oldDir, newDir := "deployments/"+d.PrefixID()+"/", fmt.Sprintf("deployments/%s-%d/", prefix, d.ID)
+
+	if err := db.Model(Deployment{}).Unscoped().Where("id = ?", d.ID).UpdateColumn("prefix", prefix).Error; err != nil {
+		return err
+	}
+
+	if err := db.Exec(`UPDATE raw_bundles
+		SET uploaded_path = ? || substr(uploaded_path, ?)
+		WHERE substr(uploaded_path, 1, ?) = ?;`,
+		newDir, len(oldDir)+1, len(oldDir), oldDir).Error; err != nil {
+		return err
+	}
+
+	d.Prefix = prefix
+	return nil
+}
+
+// PreviousCompletedDeployment returns previous deployment of current deployment.
+// Previews that have not been promoted are skipped, as they were never active.
 func (d *Deployment) PreviousCompletedDeployment(db *gorm.DB) (*Deployment, error) {
 	var prevDepl Deployment
 
@@ -84,7 +362,7 @@ func (d *Deployment) PreviousCompletedDeployment(db *gorm.DB) (*Deployment, erro
 		return nil, nil
 	}
 
-	if err := db.Where("project_id = ? AND deployed_at IS NOT NULL AND deployed_at < ? AND state = ?", d.ProjectID, *d.DeployedAt, StateDeployed).
+	if err := db.Where("project_id = ? AND deployed_at IS NOT NULL AND deployed_at < ? AND state = ? AND preview = ?", d.ProjectID, *d.DeployedAt, StateDeployed, false).
 		Order("deployed_at DESC").
 		First(&prevDepl).Error; err != nil {
 		if err == gorm.RecordNotFound {
@@ -96,7 +374,8 @@ func (d *Deployment) PreviousCompletedDeployment(db *gorm.DB) (*Deployment, erro
 	return &prevDepl, nil
 }
 
-// CompletedDeployments returns completed deployments up to the given limit.
+// CompletedDeployments returns completed deployments that are not pinned up
+// to the given limit.
 // A limit of 0 implies no limit (i.e. all deployments will be returned).
 // Apologies for the magic number, but who'd ask for 0 deployments anyway.
 func CompletedDeployments(db *gorm.DB, projectID, limit uint) ([]*Deployment, error) {
@@ -106,13 +385,64 @@ func CompletedDeployments(db *gorm.DB, projectID, limit uint) ([]*Deployment, er
 	}
 
 	var depls []*Deployment
-	if err := db.Limit(qLimit).Where("project_id = ? AND state = ?", projectID, StateDeployed).Order("deployed_at DESC").Find(&depls).Error; err != nil {
+	if err := db.Limit(qLimit).Where("project_id = ? AND state = ? AND pinned_at IS NULL", projectID, StateDeployed).Order("deployed_at DESC").Find(&depls).Error; err != nil {
 		return nil, err
 	}
 	return depls, nil
 }
 
-// DeleteExceptLastN deletes all but the last n deployed deployments.
+// PinnedDeployments returns the completed deployments of a project that are
+// pinned, most recently deployed first.
+func PinnedDeployments(db *gorm.DB, projectID uint) ([]*Deployment, error) {
+	var depls []*Deployment
+	if err := db.Where("project_id = ? AND state = ? AND pinned_at IS NOT NULL", projectID, StateDeployed).Order("deployed_at DESC").Find(&depls).Error; err != nil {
+		return nil, err
+	}
+	return depls, nil
+}
+
+// Pin pins the deployment, so that it is not deleted to keep the project
+// within its MaxDeploysKept. Pinning a pinned deployment does nothing.
+func (d *Deployment) Pin(db *gorm.DB) error {
+	if d.PinnedAt != nil {
+		return nil
+	}
+
+	pinnedAt := time.Now()
+	if err := db.Model(Deployment{}).Where("id = ?", d.ID).UpdateColumn("pinned_at", pinnedAt).Error; err != nil {
+		return err
+	}
+
+	d.PinnedAt = &pinnedAt
+	return nil
+}
+
+// Unpin unpins the deployment. It is deleted with the next deploy if it is
+// older than the last MaxDeploysKept deployments of the project.
+func (d *Deployment) Unpin(db *gorm.DB) error {
+	var pinnedAt *time.Time
+	if err := db.Model(Deployment{}).Where("id = ?", d.ID).UpdateColumn("pinned_at", pinnedAt).Error; err != nil {
+		return err
+	}
+
+	d.PinnedAt = nil
+	return nil
+}
+
+// History returns the most recent deployments of a project up to the given
+// limit, including deleted ones, most recently created first.
+func History(db *gorm.DB, projectID, limit uint) ([]*Deployment, error) {
+	var depls []*Deployment
+	if err := db.Unscoped().Limit(int(limit)).Where("project_id = ?", projectID).Order("created_at DESC, id DESC").Find(&depls).Error; err != nil {
+		return nil, err
+	}
+	return depls, nil
+}
+
+// DeleteExceptLastN deletes all but the last n deployed deployments. Pinned
+// deployments are never deleted, and do not count towards n. The active
+// deployment of the project is never deleted either, e.g. after it was rolled
+// back to.
 func DeleteExceptLastN(db *gorm.DB, projectID, n uint) error {
 	q := db.Exec(`
 		UPDATE deployments
@@ -121,18 +451,72 @@ func DeleteExceptLastN(db *gorm.DB, projectID, n uint) error {
 			project_id = ?
 			AND state = ?
 			AND deleted_at IS NULL
+			AND pinned_at IS NULL
+			AND id <> COALESCE((SELECT active_deployment_id FROM projects WHERE id = ?), 0)
 			AND deployed_at <= (
 				SELECT deployed_at FROM deployments
 				WHERE
 					project_id = ?
 					AND state = ?
 					AND deleted_at IS NULL
+					AND pinned_at IS NULL
 				ORDER BY deployed_at DESC
 				LIMIT 1 OFFSET ?
-			);`, projectID, StateDeployed, projectID, StateDeployed, n)
+			);`, projectID, StateDeployed, projectID, projectID, StateDeployed, n)
 	return q.Error
 }
 
+// Prune deletes the deployments of all projects that keep a limited number of
+// deployments (i.e. whose max_deploys_kept is set) beyond that number, and
+// returns the number of deployments deleted. Deployed and failed deployments
+// are counted separately, so that up to max_deploys_kept of each are kept.
+// Pinned deployments and the active deployments of projects are never
+// deleted, and deleted deployments are purged from S3 by the purgedeploys
+// job.
+func Prune(db *gorm.DB) (int64, error) {
+	q := db.Exec(`
+		UPDATE deployments
+		SET deleted_at = now()
+		WHERE id IN (
+			SELECT ranked.id
+			FROM (
+				SELECT
+					id,
+					project_id,
+					row_number() OVER (
+						PARTITION BY project_id, state = ?
+						ORDER BY COALESCE(deployed_at, created_at) DESC, id DESC
+					) AS rank
+				FROM deployments
+				WHERE
+					state IN (?, ?, ?)
+					AND deleted_at IS NULL
+					AND pinned_at IS NULL
+			) ranked
+			JOIN projects ON projects.id = ranked.project_id
+			WHERE
+				projects.deleted_at IS NULL
+				AND projects.max_deploys_kept > 0
+				AND ranked.rank > projects.max_deploys_kept
+				AND ranked.id <> COALESCE(projects.active_deployment_id, 0)
+		);`, StateDeployed, StateDeployed, StateBuildFailed, StateDeployFailed)
+	return q.RowsAffected, q.Error
+}
+
+// DeleteAbandonedUploads deletes deployments that have been pending upload
+// since before the given time, i.e. whose bundle was never uploaded, and
+// returns the number of deployments deleted.
+func DeleteAbandonedUploads(db *gorm.DB, createdBefore time.Time) (int64, error) {
+	q := db.Exec(`
+		UPDATE deployments
+		SET deleted_at = now()
+		WHERE
+			state = ?
+			AND created_at < ?
+			AND deleted_at IS NULL;`, StatePendingUpload, createdBefore)
+	return q.RowsAffected, q.Error
+}
+
 // UpdateState updates deployment state
 func (d *Deployment) UpdateState(db *gorm.DB, state string) error {
 	if !isValidState(state) {
@@ -145,7 +529,7 @@ func (d *Deployment) UpdateState(db *gorm.DB, state string) error {
 	}
 
 	if state == StateBuildFailed || state == StateDeployFailed {
-		q = q.Update("error_message", d.ErrorMessage)
+		q = q.Update("error_message", d.ErrorMessage).Update("error_code", d.ErrorCode)
 	}
 	if state == StateUploaded && d.RawBundleID != nil {
 		q = q.Update("raw_bundle_id", d.RawBundleID)
@@ -155,9 +539,95 @@ func (d *Deployment) UpdateState(db *gorm.DB, state string) error {
 		return err
 	}
 
+	return d.notifyStateChange(db, state)
+}
+
+// notifyStateChange sends the new state of the deployment to
+// StateChangesChannel. If db is a transaction, the notification is only sent
+// once it is committed.
+func (d *Deployment) notifyStateChange(db *gorm.DB, state string) error {
+	payload, err := json.Marshal(&StateChange{
+		ID:        d.ID,
+		ProjectID: d.ProjectID,
+		State:     state,
+	})
+	if err != nil {
+		return err
+	}
+
+	return db.Exec("SELECT pg_notify(?, ?)", StateChangesChannel, string(payload)).Error
+}
+
+// RecordDuration stores how long a phase of the deployment took.
+func (d *Deployment) RecordDuration(db *gorm.DB, phase string, dur time.Duration) error {
+	ms := int64(dur / time.Millisecond)
+
+	var column string
+	switch phase {
+	case PhaseUpload:
+		column, d.UploadDurationMs = "upload_duration_ms", &ms
+	case PhaseBuild:
+		column, d.BuildDurationMs = "build_duration_ms", &ms
+	case PhaseDeploy:
+		column, d.DeployDurationMs = "deploy_duration_ms", &ms
+	default:
+		return ErrInvalidPhase
+	}
+
+	return db.Model(Deployment{}).Where("id = ?", d.ID).UpdateColumn(column, ms).Error
+}
+
+// SetJsEnvVars encrypts JS env vars with the given keyring and sets them in
+// EncryptedJsEnvVars.
+func (d *Deployment) SetJsEnvVars(vars map[string]string, keyring *aesencrypter.Keyring) error {
+	if vars == nil {
+		vars = map[string]string{}
+	}
+
+	b, err := json.Marshal(vars)
+	if err != nil {
+		return err
+	}
+
+	encrypted, err := keyring.EncryptToString(b)
+	if err != nil {
+		return err
+	}
+
+	d.EncryptedJsEnvVars = &encrypted
+	d.JsEnvVars = []byte("{}")
 	return nil
 }
 
+// DecryptedJsEnvVars returns the JS env vars of the deployment, decrypting
+// them with the given keyring if they are encrypted.
+func (d *Deployment) DecryptedJsEnvVars(keyring *aesencrypter.Keyring) (map[string]string, error) {
+	b := d.JsEnvVars
+	if d.EncryptedJsEnvVars != nil {
+		var err error
+		b, err = keyring.DecryptString(*d.EncryptedJsEnvVars)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	vars := map[string]string{}
+	if len(b) == 0 {
+		return vars, nil
+	}
+	if err := json.Unmarshal(b, &vars); err != nil {
+		return nil, err
+	}
+	return vars, nil
+}
+
+// CopyJsEnvVars copies the JS env vars of another deployment without
+// decrypting them.
+func (d *Deployment) CopyJsEnvVars(from *Deployment) {
+	d.JsEnvVars = from.JsEnvVars
+	d.EncryptedJsEnvVars = from.EncryptedJsEnvVars
+}
+
 func (d *Deployment) String() string {
 	return fmt.Sprintf("v%d of project %d", d.Version, d.ProjectID)
 }
@@ -172,5 +642,6 @@ func isValidState(state string) bool {
 		StatePendingBuild == state ||
 		StateBuilt == state ||
 		StateBuildFailed == state ||
-		StatePendingUpdateConfig == state
+		StatePendingUpdateConfig == state ||
+		StateQueued == state
 }
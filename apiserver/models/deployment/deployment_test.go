@@ -1,14 +1,21 @@
 package deployment_test
 
 import (
+	"encoding/json"
+	"fmt"
 	"testing"
 	"time"
 
 	"github.com/jinzhu/gorm"
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
 	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/outboxjob"
 	"github.com/nitrous-io/rise-server/apiserver/models/project"
 	"github.com/nitrous-io/rise-server/apiserver/models/rawbundle"
+	"github.com/nitrous-io/rise-server/pkg/aesencrypter"
+	"github.com/nitrous-io/rise-server/pkg/job"
+	"github.com/nitrous-io/rise-server/shared"
+	"github.com/nitrous-io/rise-server/shared/queues"
 	"github.com/nitrous-io/rise-server/testhelper"
 	"github.com/nitrous-io/rise-server/testhelper/factories"
 
@@ -68,6 +75,71 @@ var _ = Describe("Deployment", func() {
 			Expect(err).To(BeNil())
 			Expect(prevDepl).To(BeNil())
 		})
+
+		It("skips previews that have not been promoted", func() {
+			Expect(db.Model(d1).UpdateColumn("preview", true).Error).To(BeNil())
+
+			prevDepl, err := d4.PreviousCompletedDeployment(db)
+			Expect(err).To(BeNil())
+			Expect(prevDepl.ID).To(Equal(d3.ID))
+		})
+	})
+
+	Describe("AsJSON()", func() {
+		It("includes the VCS metadata of the deployment", func() {
+			depl := &deployment.Deployment{
+				CommitSHA:     "5e908dc1f01e",
+				CommitMessage: "Fix typo in README",
+				Branch:        "master",
+			}
+
+			j := depl.AsJSON()
+			Expect(j.CommitSHA).To(Equal("5e908dc1f01e"))
+			Expect(j.CommitMessage).To(Equal("Fix typo in README"))
+			Expect(j.Branch).To(Equal("master"))
+		})
+
+		It("omits VCS metadata that was not sent", func() {
+			b, err := json.Marshal((&deployment.Deployment{}).AsJSON())
+			Expect(err).To(BeNil())
+			Expect(string(b)).NotTo(ContainSubstring("commit_sha"))
+			Expect(string(b)).NotTo(ContainSubstring("branch"))
+		})
+	})
+
+	Describe("PreviewDomainName()", func() {
+		It("returns a subdomain of the preview subdomain of the default domain", func() {
+			d := &deployment.Deployment{Prefix: "0123456789abcdef"}
+			d.ID = 42
+			Expect(d.PreviewDomainName()).To(Equal("0123456789abcdef-42.preview." + shared.DefaultDomain))
+		})
+	})
+
+	Describe("GeneratePreviewBasicAuth()", func() {
+		It("generates credentials whose password is only included in the JSON while it is known", func() {
+			d := &deployment.Deployment{Preview: true}
+			Expect(d.GeneratePreviewBasicAuth()).To(Succeed())
+
+			Expect(*d.PreviewBasicAuthUsername).To(Equal("preview"))
+			Expect(d.PreviewBasicAuthPassword).To(HaveLen(24))
+			Expect(*d.EncryptedPreviewBasicAuthPassword).To(HaveLen(64))
+			Expect(*d.EncryptedPreviewBasicAuthPassword).NotTo(ContainSubstring(d.PreviewBasicAuthPassword))
+
+			j := d.AsJSON()
+			Expect(j.PreviewBasicAuth.Username).To(Equal("preview"))
+			Expect(j.PreviewBasicAuth.Password).To(Equal(d.PreviewBasicAuthPassword))
+
+			other := &deployment.Deployment{Preview: true}
+			Expect(other.GeneratePreviewBasicAuth()).To(Succeed())
+			Expect(other.PreviewBasicAuthPassword).NotTo(Equal(d.PreviewBasicAuthPassword))
+
+			reloaded := &deployment.Deployment{
+				Preview:                           true,
+				PreviewBasicAuthUsername:          d.PreviewBasicAuthUsername,
+				EncryptedPreviewBasicAuthPassword: d.EncryptedPreviewBasicAuthPassword,
+			}
+			Expect(reloaded.AsJSON().PreviewBasicAuth.Password).To(BeEmpty())
+		})
 	})
 
 	Describe("CompletedDeployments()", func() {
@@ -107,6 +179,61 @@ var _ = Describe("Deployment", func() {
 				Expect(depls[0].ID).To(Equal(d3.ID))
 			})
 		})
+
+		Context("when a deployment is pinned", func() {
+			BeforeEach(func() {
+				Expect(d3.Pin(db)).To(BeNil())
+			})
+
+			It("does not return it", func() {
+				depls, err := deployment.CompletedDeployments(db, proj.ID, 0)
+				Expect(err).To(BeNil())
+
+				Expect(depls).To(HaveLen(1))
+				Expect(depls[0].ID).To(Equal(d1.ID))
+			})
+		})
+	})
+
+	Describe("PinnedDeployments()", func() {
+		var (
+			proj *project.Project
+
+			d1 *deployment.Deployment
+			d3 *deployment.Deployment
+		)
+
+		BeforeEach(func() {
+			u := factories.User(db)
+			proj = factories.Project(db, u)
+			d1 = factories.Deployment(db, proj, u, deployment.StateDeployed)
+			factories.Deployment(db, proj, u, deployment.StateDeployed)
+			d3 = factories.Deployment(db, proj, u, deployment.StateDeployed)
+
+			Expect(d1.Pin(db)).To(BeNil())
+			Expect(d3.Pin(db)).To(BeNil())
+		})
+
+		It("returns pinned deployments sorted by deployed_at", func() {
+			depls, err := deployment.PinnedDeployments(db, proj.ID)
+			Expect(err).To(BeNil())
+
+			Expect(depls).To(HaveLen(2))
+			Expect(depls[0].ID).To(Equal(d3.ID))
+			Expect(depls[1].ID).To(Equal(d1.ID))
+			Expect(depls[0].PinnedAt).NotTo(BeNil())
+		})
+
+		It("does not return deployments that were unpinned", func() {
+			Expect(d3.Unpin(db)).To(BeNil())
+			Expect(d3.PinnedAt).To(BeNil())
+
+			depls, err := deployment.PinnedDeployments(db, proj.ID)
+			Expect(err).To(BeNil())
+
+			Expect(depls).To(HaveLen(1))
+			Expect(depls[0].ID).To(Equal(d1.ID))
+		})
 	})
 
 	Describe("DeleteExceptLastN()", func() {
@@ -178,6 +305,130 @@ var _ = Describe("Deployment", func() {
 			Expect(ids).To(HaveLen(3))
 			Expect(ids).To(ConsistOf(d1.ID, d3.ID, d4.ID))
 		})
+
+		It("does not delete or count pinned deployments", func() {
+			Expect(d1.Pin(db)).To(BeNil())
+
+			err := deployment.DeleteExceptLastN(db, proj.ID, 1)
+			Expect(err).To(BeNil())
+
+			var depls []*deployment.Deployment
+			q := db.Where("project_id = ? AND state = ?", proj.ID, deployment.StateDeployed).Find(&depls)
+			Expect(q.Error).To(BeNil())
+
+			var ids []uint
+			for _, depl := range depls {
+				ids = append(ids, depl.ID)
+			}
+
+			Expect(ids).To(HaveLen(2))
+			Expect(ids).To(ConsistOf(d1.ID, d4.ID))
+		})
+
+		It("does not delete the active deployment", func() {
+			Expect(db.Model(proj).Update("active_deployment_id", d1.ID).Error).To(BeNil())
+
+			err := deployment.DeleteExceptLastN(db, proj.ID, 1)
+			Expect(err).To(BeNil())
+
+			var depls []*deployment.Deployment
+			q := db.Where("project_id = ? AND state = ?", proj.ID, deployment.StateDeployed).Find(&depls)
+			Expect(q.Error).To(BeNil())
+
+			var ids []uint
+			for _, depl := range depls {
+				ids = append(ids, depl.ID)
+			}
+
+			Expect(ids).To(ConsistOf(d1.ID, d4.ID))
+		})
+	})
+
+	Describe("Prune()", func() {
+		var (
+			proj      *project.Project
+			otherProj *project.Project
+
+			d1, d2, d3, d4, d5 *deployment.Deployment
+			other              *deployment.Deployment
+		)
+
+		BeforeEach(func() {
+			u := factories.User(db)
+			proj = factories.Project(db, u)
+			Expect(db.Model(proj).Update("max_deploys_kept", 1).Error).To(BeNil())
+
+			d1 = factories.Deployment(db, proj, u, deployment.StateDeployed)
+			d2 = factories.Deployment(db, proj, u, deployment.StateBuildFailed)
+			d3 = factories.Deployment(db, proj, u, deployment.StateDeployed)
+			d4 = factories.Deployment(db, proj, u, deployment.StateDeployFailed)
+			d5 = factories.Deployment(db, proj, u, deployment.StatePendingBuild)
+
+			// Projects that keep all deployments are not pruned.
+			otherProj = factories.Project(db, u)
+			other = factories.Deployment(db, otherProj, u, deployment.StateDeployed)
+			factories.Deployment(db, otherProj, u, deployment.StateDeployed)
+		})
+
+		remainingIDs := func(projectID uint) []uint {
+			var depls []*deployment.Deployment
+			Expect(db.Where("project_id = ?", projectID).Find(&depls).Error).To(BeNil())
+
+			var ids []uint
+			for _, depl := range depls {
+				ids = append(ids, depl.ID)
+			}
+			return ids
+		}
+
+		It("deletes deployed and failed deployments beyond the number of deployments kept", func() {
+			n, err := deployment.Prune(db)
+			Expect(err).To(BeNil())
+			Expect(n).To(Equal(int64(2)))
+
+			Expect(remainingIDs(proj.ID)).To(ConsistOf(d3.ID, d4.ID, d5.ID))
+			Expect(remainingIDs(otherProj.ID)).To(ContainElement(other.ID))
+		})
+
+		It("does not delete pinned deployments or the active deployment", func() {
+			Expect(d1.Pin(db)).To(BeNil())
+			Expect(db.Model(proj).Update("active_deployment_id", d2.ID).Error).To(BeNil())
+
+			n, err := deployment.Prune(db)
+			Expect(err).To(BeNil())
+			Expect(n).To(Equal(int64(0)))
+
+			Expect(remainingIDs(proj.ID)).To(ConsistOf(d1.ID, d2.ID, d3.ID, d4.ID, d5.ID))
+		})
+	})
+
+	Describe("DeleteAbandonedUploads()", func() {
+		var (
+			d1 *deployment.Deployment
+			d2 *deployment.Deployment
+			d3 *deployment.Deployment
+		)
+
+		BeforeEach(func() {
+			u := factories.User(db)
+			proj := factories.Project(db, u)
+			d1 = factories.Deployment(db, proj, u, deployment.StatePendingUpload)
+			d2 = factories.Deployment(db, proj, u, deployment.StatePendingUpload)
+			d3 = factories.Deployment(db, proj, u, deployment.StateDeployed)
+
+			Expect(db.Exec("UPDATE deployments SET created_at = ? WHERE id IN (?, ?)",
+				time.Now().Add(-48*time.Hour), d1.ID, d3.ID).Error).To(BeNil())
+		})
+
+		It("deletes deployments that have been pending upload since before the given time", func() {
+			n, err := deployment.DeleteAbandonedUploads(db, time.Now().Add(-24*time.Hour))
+			Expect(err).To(BeNil())
+			Expect(n).To(Equal(int64(1)))
+
+			var ids []uint
+			Expect(db.Model(deployment.Deployment{}).Pluck("id", &ids).Error).To(BeNil())
+			Expect(ids).To(ConsistOf(d2.ID, d3.ID))
+		})
 	})
 
 	Describe("UpdateState()", func() {
@@ -250,5 +501,302 @@ var _ = Describe("Deployment", func() {
 			Expect(d.ErrorMessage).NotTo(BeNil())
 			Expect(*d.ErrorMessage).To(Equal(msg))
 		})
+
+		It("updates error_code when new state is deploy_failed", func() {
+			msg := "Your bundle has too many files."
+			code := deployment.ErrorCodeTooManyFiles
+			d.ErrorMessage = &msg
+			d.ErrorCode = &code
+			err := d.UpdateState(db, deployment.StateDeployFailed)
+			Expect(err).To(BeNil())
+
+			d2 := &deployment.Deployment{}
+			Expect(db.First(d2, d.ID).Error).To(BeNil())
+			Expect(d2.ErrorCode).NotTo(BeNil())
+			Expect(*d2.ErrorCode).To(Equal(deployment.ErrorCodeTooManyFiles))
+		})
+	})
+
+	Describe("InFlight()", func() {
+		var (
+			proj *project.Project
+			d1   *deployment.Deployment
+		)
+
+		BeforeEach(func() {
+			u := factories.User(db)
+			proj = factories.Project(db, u)
+			factories.Deployment(db, proj, u, deployment.StateDeployed)
+			d1 = factories.Deployment(db, proj, u, deployment.StatePendingBuild)
+			factories.Deployment(db, nil, u, deployment.StatePendingDeploy)
+		})
+
+		It("returns the deployment of the project that is being built or deployed", func() {
+			depl, err := deployment.InFlight(db, proj.ID)
+			Expect(err).To(BeNil())
+			Expect(depl).NotTo(BeNil())
+			Expect(depl.ID).To(Equal(d1.ID))
+		})
+
+		It("ignores deployments that have not been updated for too long", func() {
+			Expect(db.Exec("UPDATE deployments SET updated_at = ? WHERE id = ?",
+				time.Now().Add(-deployment.InFlightTimeout-time.Minute), d1.ID).Error).To(BeNil())
+
+			depl, err := deployment.InFlight(db, proj.ID)
+			Expect(err).To(BeNil())
+			Expect(depl).To(BeNil())
+		})
+	})
+
+	Describe("ReleaseQueued()", func() {
+		var (
+			d1, d2, d3, d4 *deployment.Deployment
+			o2, o3, o4     *outboxjob.OutboxJob
+		)
+
+		BeforeEach(func() {
+			u := factories.User(db)
+			proj1 := factories.Project(db, u)
+			proj2 := factories.Project(db, u)
+
+			d1 = factories.Deployment(db, proj1, u, deployment.StatePendingBuild)
+			d2 = factories.Deployment(db, proj1, u, deployment.StateQueued)
+			d3 = factories.Deployment(db, proj2, u, deployment.StateQueued)
+			d4 = factories.Deployment(db, proj2, u, deployment.StateQueued)
+
+			o2, err = outboxjob.Hold(db, job.New(queues.Build, []byte("two")), d2.ID)
+			Expect(err).To(BeNil())
+			o3, err = outboxjob.Hold(db, job.New(queues.Deploy, []byte("three")), d3.ID)
+			Expect(err).To(BeNil())
+			o4, err = outboxjob.Hold(db, job.New(queues.Build, []byte("four")), d4.ID)
+			Expect(err).To(BeNil())
+		})
+
+		It("starts the oldest queued deployment of projects without a deployment in flight", func() {
+			n, err := deployment.ReleaseQueued(db)
+			Expect(err).To(BeNil())
+			Expect(n).To(Equal(1))
+
+			for _, d := range []*deployment.Deployment{d2, d3, d4} {
+				Expect(db.First(d, d.ID).Error).To(BeNil())
+			}
+			Expect(d2.State).To(Equal(deployment.StateQueued))
+			Expect(d3.State).To(Equal(deployment.StatePendingDeploy))
+			Expect(d4.State).To(Equal(deployment.StateQueued))
+
+			for _, o := range []*outboxjob.OutboxJob{o2, o3, o4} {
+				Expect(db.First(o, o.ID).Error).To(BeNil())
+			}
+			Expect(o2.HeldDeploymentID).NotTo(BeNil())
+			Expect(o3.HeldDeploymentID).To(BeNil())
+			Expect(o4.HeldDeploymentID).NotTo(BeNil())
+		})
+
+		It("starts the next queued deployment once the one in flight has finished", func() {
+			Expect(d1.UpdateState(db, deployment.StateDeployed)).To(Succeed())
+
+			_, err := deployment.ReleaseQueued(db)
+			Expect(err).To(BeNil())
+
+			Expect(db.First(d2, d2.ID).Error).To(BeNil())
+			Expect(d2.State).To(Equal(deployment.StatePendingBuild))
+		})
+	})
+
+	Describe("QueueStatus()", func() {
+		var (
+			proj           *project.Project
+			d1, d2, d3, d4 *deployment.Deployment
+		)
+
+		BeforeEach(func() {
+			u := factories.User(db)
+			proj = factories.Project(db, u)
+
+			buildMs, deployMs := int64(60000), int64(20000)
+			factories.DeploymentWithAttrs(db, nil, u, deployment.Deployment{
+				State:            deployment.StateDeployed,
+				BuildDurationMs:  &buildMs,
+				DeployDurationMs: &deployMs,
+			})
+
+			d1 = factories.Deployment(db, nil, u, deployment.StatePendingBuild)
+			d2 = factories.Deployment(db, proj, u, deployment.StatePendingBuild)
+			d3 = factories.Deployment(db, proj, u, deployment.StateQueued)
+			d4 = factories.Deployment(db, proj, u, deployment.StateDeployed)
+
+			_, err = outboxjob.Hold(db, job.New(queues.Build, []byte("three")), d3.ID)
+			Expect(err).To(BeNil())
+		})
+
+		It("returns the position of a pending deployment in its queue and an ETA", func() {
+			qs, err := d2.QueueStatus(db)
+			Expect(err).To(BeNil())
+			Expect(qs.Queue).To(Equal(queues.Build))
+			Expect(qs.Position).To(Equal(2))
+			Expect(*qs.ETA).To(Equal(2*time.Minute + 20*time.Second))
+		})
+
+		It("returns the position of a queued deployment behind the deployment of its project in flight", func() {
+			qs, err := d3.QueueStatus(db)
+			Expect(err).To(BeNil())
+			Expect(qs.Queue).To(Equal(deployment.QueueProject))
+			Expect(qs.Position).To(Equal(2))
+			Expect(*qs.ETA).To(Equal(2 * 80 * time.Second))
+		})
+
+		It("ignores deployments that have not been updated for too long", func() {
+			Expect(db.Exec("UPDATE deployments SET updated_at = ? WHERE id = ?",
+				time.Now().Add(-deployment.InFlightTimeout-time.Minute), d1.ID).Error).To(BeNil())
+
+			qs, err := d2.QueueStatus(db)
+			Expect(err).To(BeNil())
+			Expect(qs.Position).To(Equal(1))
+		})
+
+		It("returns nil for deployments that are not pending", func() {
+			qs, err := d4.QueueStatus(db)
+			Expect(err).To(BeNil())
+			Expect(qs).To(BeNil())
+		})
+
+		Context("when no deployments have recorded durations", func() {
+			BeforeEach(func() {
+				Expect(db.Exec("UPDATE deployments SET build_duration_ms = NULL, deploy_duration_ms = NULL").Error).To(BeNil())
+			})
+
+			It("does not return an ETA", func() {
+				qs, err := d2.QueueStatus(db)
+				Expect(err).To(BeNil())
+				Expect(qs.Position).To(Equal(2))
+				Expect(qs.ETA).To(BeNil())
+			})
+		})
+	})
+
+	Describe("RecordDuration()", func() {
+		var d *deployment.Deployment
+
+		BeforeEach(func() {
+			u := factories.User(db)
+			proj := factories.Project(db, u)
+			d = factories.Deployment(db, proj, u, deployment.StatePendingBuild)
+		})
+
+		It("stores how long the phase took in milliseconds", func() {
+			Expect(d.RecordDuration(db, deployment.PhaseBuild, 1500*time.Millisecond)).To(Succeed())
+			Expect(*d.BuildDurationMs).To(Equal(int64(1500)))
+
+			d2 := &deployment.Deployment{}
+			Expect(db.First(d2, d.ID).Error).To(BeNil())
+			Expect(d2.BuildDurationMs).NotTo(BeNil())
+			Expect(*d2.BuildDurationMs).To(Equal(int64(1500)))
+			Expect(d2.UploadDurationMs).To(BeNil())
+			Expect(d2.DeployDurationMs).To(BeNil())
+		})
+
+		It("returns an error for an unknown phase", func() {
+			Expect(d.RecordDuration(db, "optimize", time.Second)).To(Equal(deployment.ErrInvalidPhase))
+		})
+	})
+
+	Describe("SetJsEnvVars()", func() {
+		keyring := aesencrypter.NewKeyring("something-something-something-32", nil)
+
+		It("encrypts the JS env vars", func() {
+			d := &deployment.Deployment{JsEnvVars: []byte(`{"foo":"bar"}`)}
+			Expect(d.SetJsEnvVars(map[string]string{"foo": "baz"}, keyring)).To(Succeed())
+
+			Expect(d.EncryptedJsEnvVars).NotTo(BeNil())
+			Expect(*d.EncryptedJsEnvVars).NotTo(ContainSubstring("baz"))
+			Expect(d.JsEnvVars).To(MatchJSON(`{}`))
+
+			vars, err := d.DecryptedJsEnvVars(keyring)
+			Expect(err).To(BeNil())
+			Expect(vars).To(Equal(map[string]string{"foo": "baz"}))
+		})
+	})
+
+	Describe("DecryptedJsEnvVars()", func() {
+		keyring := aesencrypter.NewKeyring("something-something-something-32", nil)
+
+		It("returns the unencrypted JS env vars of deployments that have not been migrated", func() {
+			d := &deployment.Deployment{JsEnvVars: []byte(`{"foo":"bar"}`)}
+
+			vars, err := d.DecryptedJsEnvVars(keyring)
+			Expect(err).To(BeNil())
+			Expect(vars).To(Equal(map[string]string{"foo": "bar"}))
+		})
+
+		It("returns an error when the AES key is wrong", func() {
+			d := &deployment.Deployment{}
+			Expect(d.SetJsEnvVars(map[string]string{"foo": "bar"}, keyring)).To(Succeed())
+
+			_, err := d.DecryptedJsEnvVars(aesencrypter.NewKeyring("wrong-wrong-wrong-wrong-wrong-32", nil))
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Describe("NewReport()", func() {
+		It("totals the sizes of the files by extension, largest first", func() {
+			r := deployment.NewReport([]*deployment.FileSize{
+				{Path: "index.html", Size: 100},
+				{Path: "js/app.js", Size: 3000},
+				{Path: "js/vendor.JS", Size: 2000},
+				{Path: "LICENSE", Size: 10},
+				{Path: "about.html", Size: 200},
+			})
+
+			Expect(r.TotalSize).To(Equal(int64(5310)))
+			Expect(r.FileCount).To(Equal(5))
+			Expect(r.Extensions).To(Equal([]*deployment.ExtensionSize{
+				{Extension: ".js", FileCount: 2, Size: 5000},
+				{Extension: ".html", FileCount: 2, Size: 300},
+				{Extension: "", FileCount: 1, Size: 10},
+			}))
+			Expect(r.LargestFiles).To(HaveLen(5))
+			Expect(r.LargestFiles[0].Path).To(Equal("js/app.js"))
+			Expect(r.LargestFiles[4].Path).To(Equal("LICENSE"))
+		})
+
+		It("lists at most MaxLargestFiles files", func() {
+			files := []*deployment.FileSize{}
+			for i := 0; i < deployment.MaxLargestFiles+5; i++ {
+				files = append(files, &deployment.FileSize{Path: fmt.Sprintf("%d.txt", i), Size: int64(i)})
+			}
+
+			r := deployment.NewReport(files)
+			Expect(r.FileCount).To(Equal(deployment.MaxLargestFiles + 5))
+			Expect(r.LargestFiles).To(HaveLen(deployment.MaxLargestFiles))
+			Expect(r.LargestFiles[0].Size).To(Equal(int64(deployment.MaxLargestFiles + 4)))
+		})
+	})
+
+	Describe("SaveReport()", func() {
+		It("stores the report of the deployment", func() {
+			u := factories.User(db)
+			proj := factories.Project(db, u)
+			d := factories.Deployment(db, proj, u, deployment.StatePendingDeploy)
+
+			r, err := d.ReportData()
+			Expect(err).To(BeNil())
+			Expect(r).To(BeNil())
+
+			Expect(d.SaveReport(db, deployment.NewReport([]*deployment.FileSize{
+				{Path: "index.html", Size: 100},
+			}))).To(Succeed())
+
+			d2 := &deployment.Deployment{}
+			Expect(db.First(d2, d.ID).Error).To(BeNil())
+
+			r, err = d2.ReportData()
+			Expect(err).To(BeNil())
+			Expect(r.TotalSize).To(Equal(int64(100)))
+			Expect(d2.WebrootSize).NotTo(BeNil())
+			Expect(*d2.WebrootSize).To(Equal(int64(100)))
+			Expect(r.LargestFiles).To(Equal([]*deployment.FileSize{
+				{Path: "index.html", Size: 100},
+			}))
+		})
 	})
 })
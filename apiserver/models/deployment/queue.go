@@ -0,0 +1,231 @@
+package deployment
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/models/outboxjob"
+	"github.com/nitrous-io/rise-server/shared/queues"
+)
+
+// InFlightStates are the states of deployments that are being built or
+// deployed. Only one deployment of a project can be in flight at a time, so
+// that deployments do not overwrite each other's active deployment.
+var InFlightStates = []string{StatePendingBuild, StatePendingDeploy}
+
+// InFlightTimeout is how long a deployment can be in flight without being
+// updated before it is considered stuck, e.g. because its worker crashed, and
+// no longer holds back other deployments of its project.
+var InFlightTimeout = time.Hour
+
+// InFlight returns the deployment of a project that is being built or
+// deployed, or nil if there is none.
+func InFlight(db *gorm.DB, projectID uint) (*Deployment, error) {
+	depl := &Deployment{}
+	if err := db.Where("project_id = ? AND state IN (?) AND updated_at > ?", projectID, InFlightStates, time.Now().Add(-InFlightTimeout)).
+		Order("id DESC").
+		First(depl).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return depl, nil
+}
+
+// ReleaseQueued starts the oldest queued deployment of every project that has
+// no deployment in flight, and returns the number of deployments started.
+// Queued deployments are locked while they are released, so that multiple
+// schedulers can release them concurrently.
+func ReleaseQueued(db *gorm.DB) (int, error) {
+	tx := db.Begin()
+	if err := tx.Error; err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var depls []*Deployment
+	if err := tx.Raw(`SELECT * FROM deployments d
+		WHERE
+			d.state = ?
+			AND d.deleted_at IS NULL
+			AND d.id = (
+				SELECT MIN(q.id) FROM deployments q
+				WHERE q.project_id = d.project_id AND q.state = ? AND q.deleted_at IS NULL
+			)
+			AND NOT EXISTS (
+				SELECT 1 FROM deployments f
+				WHERE f.project_id = d.project_id AND f.state IN (?) AND f.updated_at > ? AND f.deleted_at IS NULL
+			)
+		ORDER BY d.id ASC
+		FOR UPDATE SKIP LOCKED`, StateQueued, StateQueued, InFlightStates, time.Now().Add(-InFlightTimeout)).Scan(&depls).Error; err != nil {
+		return 0, err
+	}
+
+	var jobs []*outboxjob.OutboxJob
+	for _, depl := range depls {
+		released, err := outboxjob.Release(tx, depl.ID)
+		if err != nil {
+			return 0, err
+		}
+
+		newState := StatePendingDeploy
+		for _, o := range released {
+			if o.QueueName == queues.Build {
+				newState = StatePendingBuild
+			}
+		}
+
+		if err := depl.UpdateState(tx, newState); err != nil {
+			return 0, err
+		}
+		jobs = append(jobs, released...)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return 0, err
+	}
+
+	outboxjob.DeliverAll(db, jobs...)
+	return len(depls), nil
+}
+
+// QueueProject is the queue of a deployment that waits for another deployment
+// of the same project to finish.
+const QueueProject = "project"
+
+// QueueStatusSampleSize is the number of recent deployments whose build and
+// deploy durations are averaged to estimate how long pending deployments take.
+var QueueStatusSampleSize = 50
+
+// QueueStatus is where a pending deployment is in line, and roughly how long
+// it will take until it has been deployed.
+type QueueStatus struct {
+	// Queue is queues.Build or queues.Deploy for deployments that wait for a
+	// worker, or QueueProject for queued deployments.
+	Queue string
+	// Position is 1 for the deployment that is next in line, or is already
+	// being built or deployed.
+	Position int
+	// ETA is nil if there are no recent deployments to estimate it from.
+	ETA *time.Duration
+}
+
+// QueueStatusJSON is the JSON representation of a QueueStatus.
+type QueueStatusJSON struct {
+	Name       string `json:"name"`
+	Position   int    `json:"position"`
+	ETASeconds *int64 `json:"eta_seconds,omitempty"`
+}
+
+// AsJSON returns a struct that can be converted to JSON
+func (qs *QueueStatus) AsJSON() *QueueStatusJSON {
+	j := &QueueStatusJSON{
+		Name:     qs.Queue,
+		Position: qs.Position,
+	}
+	if qs.ETA != nil {
+		secs := int64(*qs.ETA / time.Second)
+		j.ETASeconds = &secs
+	}
+	return j
+}
+
+// QueueStatus returns the position of the deployment in the queue it waits in,
+// and an ETA based on the durations of recent deployments, assuming that the
+// deployments ahead of it are processed one at a time. It returns nil if the
+// deployment is not pending.
+func (d *Deployment) QueueStatus(db *gorm.DB) (*QueueStatus, error) {
+	var (
+		qs    = &QueueStatus{}
+		ahead int
+		err   error
+	)
+
+	switch d.State {
+	case StatePendingBuild, StatePendingDeploy:
+		qs.Queue = queues.Build
+		if d.State == StatePendingDeploy {
+			qs.Queue = queues.Deploy
+		}
+
+		// Deployments that are stuck do not hold up the queue.
+		if err := db.Model(Deployment{}).Where("state = ? AND id < ? AND updated_at > ?",
+			d.State, d.ID, time.Now().Add(-InFlightTimeout)).Count(&ahead).Error; err != nil {
+			return nil, err
+		}
+	case StateQueued:
+		qs.Queue = QueueProject
+
+		if err := db.Model(Deployment{}).Where("project_id = ? AND ((state = ? AND id < ?) OR (state IN (?) AND updated_at > ?))",
+			d.ProjectID, StateQueued, d.ID, InFlightStates, time.Now().Add(-InFlightTimeout)).Count(&ahead).Error; err != nil {
+			return nil, err
+		}
+	default:
+		return nil, nil
+	}
+	qs.Position = ahead + 1
+
+	avgBuild, err := recentAverageDuration(db, "build_duration_ms")
+	if err != nil {
+		return nil, err
+	}
+	avgDeploy, err := recentAverageDuration(db, "deploy_duration_ms")
+	if err != nil {
+		return nil, err
+	}
+	if avgDeploy == nil {
+		return qs, nil
+	}
+
+	var eta time.Duration
+	switch d.State {
+	case StatePendingBuild:
+		if avgBuild == nil {
+			return qs, nil
+		}
+		eta = time.Duration(qs.Position)*(*avgBuild) + *avgDeploy
+	case StatePendingDeploy:
+		eta = time.Duration(qs.Position) * (*avgDeploy)
+	case StateQueued:
+		// The held job of a queued deployment tells whether it is built.
+		var builds int
+		if err := db.Model(outboxjob.OutboxJob{}).Where("held_deployment_id = ? AND queue_name = ?",
+			d.ID, queues.Build).Count(&builds).Error; err != nil {
+			return nil, err
+		}
+
+		each := *avgDeploy
+		if builds > 0 {
+			if avgBuild == nil {
+				return qs, nil
+			}
+			each += *avgBuild
+		}
+		eta = time.Duration(qs.Position) * each
+	}
+	qs.ETA = &eta
+
+	return qs, nil
+}
+
+// recentAverageDuration returns the average of a duration column of the most
+// recent deployments that recorded it, or nil if none did.
+func recentAverageDuration(db *gorm.DB, column string) (*time.Duration, error) {
+	var avgMs sql.NullFloat64
+	if err := db.Raw(`SELECT AVG(`+column+`) FROM (
+			SELECT `+column+` FROM deployments
+			WHERE `+column+` IS NOT NULL AND deleted_at IS NULL
+			ORDER BY id DESC
+			LIMIT ?
+		) d`, QueueStatusSampleSize).Row().Scan(&avgMs); err != nil {
+		return nil, err
+	}
+
+	if !avgMs.Valid {
+		return nil, nil
+	}
+	avg := time.Duration(avgMs.Float64 * float64(time.Millisecond))
+	return &avg, nil
+}
@@ -0,0 +1,126 @@
+package deployment
+
+import (
+	"encoding/json"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// MaxLargestFiles is the number of largest files listed in a report.
+const MaxLargestFiles = 20
+
+// Report is a breakdown of the size of the files that were deployed.
+type Report struct {
+	TotalSize    int64            `json:"total_size"`
+	FileCount    int              `json:"file_count"`
+	Extensions   []*ExtensionSize `json:"extensions"`
+	LargestFiles []*FileSize      `json:"largest_files"`
+}
+
+// ExtensionSize is the total size of the deployed files with an extension.
+type ExtensionSize struct {
+	Extension string `json:"extension"`
+	FileCount int    `json:"file_count"`
+	Size      int64  `json:"size"`
+}
+
+// FileSize is the size of a deployed file.
+type FileSize struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// NewReport returns a report of the given files. Extensions and files are
+// sorted by size, largest first. Files without an extension are totalled
+// under an empty extension.
+func NewReport(files []*FileSize) *Report {
+	r := &Report{
+		FileCount:    len(files),
+		Extensions:   []*ExtensionSize{},
+		LargestFiles: []*FileSize{},
+	}
+
+	exts := map[string]*ExtensionSize{}
+	for _, f := range files {
+		r.TotalSize += f.Size
+
+		ext := strings.ToLower(path.Ext(f.Path))
+		es, ok := exts[ext]
+		if !ok {
+			es = &ExtensionSize{Extension: ext}
+			exts[ext] = es
+			r.Extensions = append(r.Extensions, es)
+		}
+		es.FileCount++
+		es.Size += f.Size
+	}
+
+	sort.Sort(byExtensionSize(r.Extensions))
+
+	r.LargestFiles = append(r.LargestFiles, files...)
+	sort.Sort(byFileSize(r.LargestFiles))
+	if len(r.LargestFiles) > MaxLargestFiles {
+		r.LargestFiles = r.LargestFiles[:MaxLargestFiles]
+	}
+
+	return r
+}
+
+// SaveReport stores the report of the deployment, and its total size as the
+// size of the deployment's webroot.
+func (d *Deployment) SaveReport(db *gorm.DB, r *Report) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	if err := db.Model(Deployment{}).Where("id = ?", d.ID).Updates(map[string]interface{}{
+		"report":       b,
+		"webroot_size": r.TotalSize,
+	}).Error; err != nil {
+		return err
+	}
+
+	d.Report = b
+	d.WebrootSize = &r.TotalSize
+	return nil
+}
+
+// ReportData returns the report of the deployment, or nil if the deployment
+// does not have one, e.g. because it has not been deployed yet.
+func (d *Deployment) ReportData() (*Report, error) {
+	if len(d.Report) == 0 {
+		return nil, nil
+	}
+
+	r := &Report{}
+	if err := json.Unmarshal(d.Report, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+type byExtensionSize []*ExtensionSize
+
+func (s byExtensionSize) Len() int      { return len(s) }
+func (s byExtensionSize) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byExtensionSize) Less(i, j int) bool {
+	if s[i].Size != s[j].Size {
+		return s[i].Size > s[j].Size
+	}
+	return s[i].Extension < s[j].Extension
+}
+
+type byFileSize []*FileSize
+
+func (s byFileSize) Len() int      { return len(s) }
+func (s byFileSize) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byFileSize) Less(i, j int) bool {
+	if s[i].Size != s[j].Size {
+		return s[i].Size > s[j].Size
+	}
+	return s[i].Path < s[j].Path
+}
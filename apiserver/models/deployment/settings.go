@@ -0,0 +1,103 @@
+package deployment
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Cache policies, which tell edges which Cache-Control header to serve the
+// files of a deployment with.
+const (
+	// CachePolicyDefault serves files with the edges' default header.
+	CachePolicyDefault = "default"
+	// CachePolicyNoCache has browsers revalidate every file on each visit.
+	CachePolicyNoCache = "no_cache"
+	// CachePolicyImmutableAssets has browsers cache files other than HTML
+	// pages for a year, for sites whose assets have fingerprinted names.
+	CachePolicyImmutableAssets = "immutable_assets"
+)
+
+// CachePolicies are the cache policies that deployments can have.
+var CachePolicies = []string{CachePolicyDefault, CachePolicyNoCache, CachePolicyImmutableAssets}
+
+// MaxVerificationURLs is the maximum number of verification URLs that
+// deployments can have.
+const MaxVerificationURLs = 20
+
+// Settings are the settings a deployment is deployed with. They are copied
+// from the deployment defaults of its project when it is created, so that
+// changing the defaults does not affect deployments that were already made.
+type Settings struct {
+	// Precompress is whether Brotli-compressed and gzipped copies of
+	// compressible files are uploaded next to them, with ".br" and ".gz"
+	// extensions, for edges to serve to clients that accept them.
+	Precompress bool   `json:"precompress"`
+	CachePolicy string `json:"cache_policy"`
+	// VerificationURLs are the paths of pages that must be in the deployment,
+	// e.g. "/checkout". The deployment fails instead of being served if any
+	// of them is missing.
+	VerificationURLs []string `json:"verification_urls"`
+}
+
+// NewSettings returns the settings of deployments whose project has no
+// deployment defaults.
+func NewSettings() *Settings {
+	return &Settings{
+		CachePolicy:      CachePolicyDefault,
+		VerificationURLs: []string{},
+	}
+}
+
+// ParseSettings parses settings stored as JSON, filling in the defaults of
+// settings that are not set.
+func ParseSettings(b []byte) (*Settings, error) {
+	s := NewSettings()
+	if len(b) == 0 {
+		return s, nil
+	}
+
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, err
+	}
+	if s.CachePolicy == "" {
+		s.CachePolicy = CachePolicyDefault
+	}
+	if s.VerificationURLs == nil {
+		s.VerificationURLs = []string{}
+	}
+	return s, nil
+}
+
+// SettingsData returns the settings the deployment is deployed with.
+func (d *Deployment) SettingsData() (*Settings, error) {
+	return ParseSettings(d.Settings)
+}
+
+// Validate returns a description of what is wrong with the settings, or an
+// empty string if they are valid.
+func (s *Settings) Validate() string {
+	if !isCachePolicy(s.CachePolicy) {
+		return "cache_policy must be one of " + strings.Join(CachePolicies, ", ")
+	}
+
+	if len(s.VerificationURLs) > MaxVerificationURLs {
+		return fmt.Sprintf("verification_urls has too many entries (max. %d)", MaxVerificationURLs)
+	}
+	for _, u := range s.VerificationURLs {
+		if !strings.HasPrefix(u, "/") || len(u) > 1024 || strings.Contains(u, "/..") || strings.ContainsAny(u, "?#\r\n") {
+			return fmt.Sprintf("%q is not a valid verification URL", u)
+		}
+	}
+
+	return ""
+}
+
+func isCachePolicy(p string) bool {
+	for _, cp := range CachePolicies {
+		if p == cp {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package deployment_test
+
+import (
+	"strings"
+
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Settings", func() {
+	Describe("ParseSettings()", func() {
+		It("fills in the defaults of settings that are not set", func() {
+			s, err := deployment.ParseSettings(nil)
+			Expect(err).To(BeNil())
+			Expect(s).To(Equal(deployment.NewSettings()))
+
+			s, err = deployment.ParseSettings([]byte(`{"precompress": true}`))
+			Expect(err).To(BeNil())
+			Expect(s).To(Equal(&deployment.Settings{
+				Precompress:      true,
+				CachePolicy:      deployment.CachePolicyDefault,
+				VerificationURLs: []string{},
+			}))
+		})
+	})
+
+	DescribeTable("Validate()",
+		func(s *deployment.Settings, expectedErr string) {
+			Expect(s.Validate()).To(Equal(expectedErr))
+		},
+
+		Entry("defaults", deployment.NewSettings(), ""),
+		Entry("valid settings", &deployment.Settings{
+			Precompress:      true,
+			CachePolicy:      deployment.CachePolicyImmutableAssets,
+			VerificationURLs: []string{"/", "/checkout"},
+		}, ""),
+		Entry("unknown cache policy", &deployment.Settings{
+			CachePolicy: "forever",
+		}, "cache_policy must be one of default, no_cache, immutable_assets"),
+		Entry("too many verification URLs", &deployment.Settings{
+			CachePolicy:      deployment.CachePolicyDefault,
+			VerificationURLs: strings.Split(strings.Repeat("/a,", deployment.MaxVerificationURLs)+"/b", ","),
+		}, "verification_urls has too many entries (max. 20)"),
+		Entry("relative verification URL", &deployment.Settings{
+			CachePolicy:      deployment.CachePolicyDefault,
+			VerificationURLs: []string{"checkout"},
+		}, `"checkout" is not a valid verification URL`),
+		Entry("verification URL with a query", &deployment.Settings{
+			CachePolicy:      deployment.CachePolicyDefault,
+			VerificationURLs: []string{"/search?q=a"},
+		}, `"/search?q=a" is not a valid verification URL`),
+	)
+})
@@ -0,0 +1,226 @@
+// Package devicecode implements the OAuth 2 device authorization grant
+// (RFC 8628), which lets command line clients log users in through a browser.
+//
+// A client requests a device code and a short user code, and asks the user to
+// enter the user code on the verification page of the web app, where they can
+// be logged in with single sign-on. The client polls the token endpoint with
+// the device code until the user has approved or denied the login.
+package devicecode
+
+import (
+	"crypto/rand"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+)
+
+const (
+	// ExpiresIn is how long users have to approve a login after a client
+	// requests a device code.
+	ExpiresIn = 10 * time.Minute
+
+	// Interval is how long clients must wait between polls of the token
+	// endpoint.
+	Interval = 5 * time.Second
+
+	// userCodeChars are the characters user codes are made of. Vowels are
+	// left out so that codes do not spell words, and so are characters that
+	// are easily confused with others.
+	userCodeChars = "BCDFGHJKLMNPQRSTVWXZ"
+
+	userCodeLen = 8
+)
+
+var ErrNotPending = errors.New("device code has already been approved or denied")
+
+// DeviceCode is a database model representing a login that a client has
+// started with the device authorization grant.
+type DeviceCode struct {
+	ID uint `gorm:"primary_key"`
+
+	DeviceCode    string `sql:"default:encode(gen_random_bytes(32), 'hex')"`
+	UserCode      string
+	OauthClientID uint
+
+	// UserID is set once the user has approved the login.
+	UserID *uint
+
+	DeniedAt     *time.Time
+	LastPolledAt *time.Time
+	ExpiresAt    time.Time
+	CreatedAt    time.Time
+}
+
+// Create creates a device code for the given client with a random user code.
+func Create(db *gorm.DB, oauthClientID uint) (*DeviceCode, error) {
+	var err error
+	// User codes are short, so retry in the unlikely case that one is taken.
+	for i := 0; i < 3; i++ {
+		var userCode string
+		userCode, err = generateUserCode()
+		if err != nil {
+			return nil, err
+		}
+
+		dc := &DeviceCode{
+			UserCode:      userCode,
+			OauthClientID: oauthClientID,
+			ExpiresAt:     time.Now().Add(ExpiresIn),
+		}
+		err = db.Create(dc).Error
+		if e, ok := err.(*pq.Error); ok && e.Code.Name() == "unique_violation" && e.Constraint == "index_device_codes_on_user_code" {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return dc, nil
+	}
+	return nil, err
+}
+
+// FindByDeviceCode returns the device code with the given device code, or nil
+// if it does not exist.
+func FindByDeviceCode(db *gorm.DB, deviceCode string) (*DeviceCode, error) {
+	dc := &DeviceCode{}
+	if err := db.Where("device_code = ?", deviceCode).First(dc).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return dc, nil
+}
+
+// FindPendingByUserCode returns the device code with the given user code if
+// it has neither expired nor been approved or denied, or nil otherwise. User
+// codes are matched regardless of case and dashes.
+func FindPendingByUserCode(db *gorm.DB, userCode string) (*DeviceCode, error) {
+	dc := &DeviceCode{}
+	if err := db.Where("user_code = ? AND user_id IS NULL AND denied_at IS NULL AND expires_at > ?",
+		NormalizeUserCode(userCode), time.Now()).First(dc).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return dc, nil
+}
+
+// NormalizeUserCode returns the user code as it is stored, in upper case
+// without dashes or spaces.
+func NormalizeUserCode(userCode string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(userCode))
+}
+
+// FormattedUserCode returns the user code as it is shown to users, e.g.
+// "BCDF-GHJK".
+func (dc *DeviceCode) FormattedUserCode() string {
+	if len(dc.UserCode) != userCodeLen {
+		return dc.UserCode
+	}
+	return dc.UserCode[:userCodeLen/2] + "-" + dc.UserCode[userCodeLen/2:]
+}
+
+// Expired returns whether the login can no longer be completed.
+func (dc *DeviceCode) Expired() bool {
+	return !dc.ExpiresAt.After(time.Now())
+}
+
+// Approve lets the client log in as the given user. It returns ErrNotPending
+// if the login has already been approved or denied.
+func (dc *DeviceCode) Approve(db *gorm.DB, userID uint) error {
+	q := db.Model(DeviceCode{}).
+		Where("id = ? AND user_id IS NULL AND denied_at IS NULL", dc.ID).
+		UpdateColumn("user_id", userID)
+	if err := q.Error; err != nil {
+		return err
+	}
+	if q.RowsAffected == 0 {
+		return ErrNotPending
+	}
+	dc.UserID = &userID
+	return nil
+}
+
+// Deny stops the client from logging in. It returns ErrNotPending if the
+// login has already been approved or denied.
+func (dc *DeviceCode) Deny(db *gorm.DB) error {
+	now := time.Now()
+	q := db.Model(DeviceCode{}).
+		Where("id = ? AND user_id IS NULL AND denied_at IS NULL", dc.ID).
+		UpdateColumn("denied_at", now)
+	if err := q.Error; err != nil {
+		return err
+	}
+	if q.RowsAffected == 0 {
+		return ErrNotPending
+	}
+	dc.DeniedAt = &now
+	return nil
+}
+
+// Poll records that the client has polled the token endpoint, and returns
+// false if the client last polled less than Interval ago.
+func (dc *DeviceCode) Poll(db *gorm.DB) (bool, error) {
+	now := time.Now()
+	q := db.Model(DeviceCode{}).
+		Where("id = ? AND (last_polled_at IS NULL OR last_polled_at <= ?)", dc.ID, now.Add(-Interval)).
+		UpdateColumn("last_polled_at", now)
+	if err := q.Error; err != nil {
+		return false, err
+	}
+	if q.RowsAffected == 0 {
+		return false, nil
+	}
+	dc.LastPolledAt = &now
+	return true, nil
+}
+
+// Redeem deletes an approved device code so that it can only be exchanged for
+// an access token once, and returns false if it has already been redeemed.
+func (dc *DeviceCode) Redeem(db *gorm.DB) (bool, error) {
+	q := db.Where("id = ? AND user_id IS NOT NULL", dc.ID).Delete(DeviceCode{})
+	if err := q.Error; err != nil {
+		return false, err
+	}
+	return q.RowsAffected > 0, nil
+}
+
+// DeleteExpired deletes device codes that have expired and returns the number
+// of device codes deleted.
+func DeleteExpired(db *gorm.DB) (int64, error) {
+	q := db.Where("expires_at <= ?", time.Now()).Delete(DeviceCode{})
+	return q.RowsAffected, q.Error
+}
+
+func generateUserCode() (string, error) {
+	b := make([]byte, userCodeLen)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	// 256 is not a multiple of len(userCodeChars), so bytes that would bias
+	// the code are not used.
+	max := byte(256 - 256%len(userCodeChars))
+	code := make([]byte, 0, userCodeLen)
+	for len(code) < userCodeLen {
+		for _, c := range b {
+			if c < max && len(code) < userCodeLen {
+				code = append(code, userCodeChars[int(c)%len(userCodeChars)])
+			}
+		}
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+	}
+	return string(code), nil
+}
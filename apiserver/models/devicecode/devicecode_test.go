@@ -0,0 +1,166 @@
+package devicecode_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/devicecode"
+	"github.com/nitrous-io/rise-server/apiserver/models/oauthclient"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "devicecode")
+}
+
+var _ = Describe("DeviceCode", func() {
+	var (
+		db  *gorm.DB
+		err error
+
+		u  *user.User
+		oc *oauthclient.OauthClient
+		dc *devicecode.DeviceCode
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+
+		u, oc = factories.AuthDuo(db)
+
+		dc, err = devicecode.Create(db, oc.ID)
+		Expect(err).To(BeNil())
+	})
+
+	Describe("Create()", func() {
+		It("creates a device code with a random user code", func() {
+			Expect(dc.DeviceCode).To(HaveLen(64))
+			Expect(dc.UserCode).To(MatchRegexp(`\A[BCDFGHJKLMNPQRSTVWXZ]{8}\z`))
+			Expect(dc.FormattedUserCode()).To(Equal(dc.UserCode[:4] + "-" + dc.UserCode[4:]))
+			Expect(dc.OauthClientID).To(Equal(oc.ID))
+			Expect(dc.UserID).To(BeNil())
+			Expect(dc.ExpiresAt).To(BeTemporally("~", time.Now().Add(devicecode.ExpiresIn), time.Second))
+
+			dc2, err := devicecode.Create(db, oc.ID)
+			Expect(err).To(BeNil())
+			Expect(dc2.DeviceCode).NotTo(Equal(dc.DeviceCode))
+		})
+	})
+
+	Describe("FindPendingByUserCode()", func() {
+		It("finds the device code regardless of case and dashes", func() {
+			for _, code := range []string{dc.UserCode, dc.FormattedUserCode(), strings.ToLower(dc.FormattedUserCode())} {
+				dc1, err := devicecode.FindPendingByUserCode(db, code)
+				Expect(err).To(BeNil())
+				Expect(dc1).NotTo(BeNil())
+				Expect(dc1.ID).To(Equal(dc.ID))
+			}
+		})
+
+		It("returns nil if the device code has expired", func() {
+			Expect(db.Model(dc).UpdateColumn("expires_at", time.Now().Add(-time.Second)).Error).To(BeNil())
+
+			dc1, err := devicecode.FindPendingByUserCode(db, dc.UserCode)
+			Expect(err).To(BeNil())
+			Expect(dc1).To(BeNil())
+		})
+
+		It("returns nil if the device code has been approved or denied", func() {
+			Expect(dc.Approve(db, u.ID)).To(Succeed())
+
+			dc1, err := devicecode.FindPendingByUserCode(db, dc.UserCode)
+			Expect(err).To(BeNil())
+			Expect(dc1).To(BeNil())
+
+			dc2, err := devicecode.Create(db, oc.ID)
+			Expect(err).To(BeNil())
+			Expect(dc2.Deny(db)).To(Succeed())
+
+			dc1, err = devicecode.FindPendingByUserCode(db, dc2.UserCode)
+			Expect(err).To(BeNil())
+			Expect(dc1).To(BeNil())
+		})
+	})
+
+	Describe("Approve() and Deny()", func() {
+		It("can only approve or deny a device code once", func() {
+			Expect(dc.Approve(db, u.ID)).To(Succeed())
+			Expect(*dc.UserID).To(Equal(u.ID))
+
+			Expect(dc.Approve(db, u.ID)).To(Equal(devicecode.ErrNotPending))
+			Expect(dc.Deny(db)).To(Equal(devicecode.ErrNotPending))
+
+			dc1, err := devicecode.FindByDeviceCode(db, dc.DeviceCode)
+			Expect(err).To(BeNil())
+			Expect(*dc1.UserID).To(Equal(u.ID))
+			Expect(dc1.DeniedAt).To(BeNil())
+		})
+	})
+
+	Describe("Poll()", func() {
+		It("returns false if the device code was polled less than Interval ago", func() {
+			ok, err := dc.Poll(db)
+			Expect(err).To(BeNil())
+			Expect(ok).To(BeTrue())
+
+			ok, err = dc.Poll(db)
+			Expect(err).To(BeNil())
+			Expect(ok).To(BeFalse())
+
+			Expect(db.Model(dc).UpdateColumn("last_polled_at", time.Now().Add(-devicecode.Interval)).Error).To(BeNil())
+
+			ok, err = dc.Poll(db)
+			Expect(err).To(BeNil())
+			Expect(ok).To(BeTrue())
+		})
+	})
+
+	Describe("Redeem()", func() {
+		It("only redeems an approved device code once", func() {
+			ok, err := dc.Redeem(db)
+			Expect(err).To(BeNil())
+			Expect(ok).To(BeFalse())
+
+			Expect(dc.Approve(db, u.ID)).To(Succeed())
+
+			ok, err = dc.Redeem(db)
+			Expect(err).To(BeNil())
+			Expect(ok).To(BeTrue())
+
+			ok, err = dc.Redeem(db)
+			Expect(err).To(BeNil())
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	Describe("DeleteExpired()", func() {
+		It("deletes device codes that have expired", func() {
+			expired, err := devicecode.Create(db, oc.ID)
+			Expect(err).To(BeNil())
+			Expect(db.Model(expired).UpdateColumn("expires_at", time.Now().Add(-time.Second)).Error).To(BeNil())
+
+			n, err := devicecode.DeleteExpired(db)
+			Expect(err).To(BeNil())
+			Expect(n).To(Equal(int64(1)))
+
+			dc1, err := devicecode.FindByDeviceCode(db, expired.DeviceCode)
+			Expect(err).To(BeNil())
+			Expect(dc1).To(BeNil())
+
+			dc1, err = devicecode.FindByDeviceCode(db, dc.DeviceCode)
+			Expect(err).To(BeNil())
+			Expect(dc1).NotTo(BeNil())
+		})
+	})
+})
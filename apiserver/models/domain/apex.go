@@ -0,0 +1,141 @@
+package domain
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/nitrous-io/rise-server/shared"
+	"golang.org/x/net/publicsuffix"
+)
+
+// DNS record types
+const (
+	RecordTypeCNAME = "CNAME"
+	RecordTypeA     = "A"
+	RecordTypeALIAS = "ALIAS"
+)
+
+// EdgeHealthCheckTimeout is how long an edge may take to accept a connection
+// before it is considered unhealthy.
+var EdgeHealthCheckTimeout = 2 * time.Second
+
+// CheckEdgeIP returns whether the edge at the given IP address is healthy, i.e.
+// accepts HTTP connections. It can be replaced in tests.
+var CheckEdgeIP = func(ip string) bool {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip, "80"), EdgeHealthCheckTimeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// DNSRecord is a DNS record that a domain needs to be served by PubStorm.
+type DNSRecord struct {
+	Name   string   `json:"name"`
+	Type   string   `json:"type"`
+	Values []string `json:"values"`
+}
+
+// DNSSetup lists the DNS records that a domain and its apex domain need, and
+// whether they are configured. The apex domain cannot be a CNAME, so it needs
+// either A records pointing to the edges, or an ALIAS record if the DNS host
+// supports them.
+type DNSSetup struct {
+	Domain         string       `json:"domain"`
+	Apex           string       `json:"apex"`
+	Records        []*DNSRecord `json:"records"`
+	Configured     bool         `json:"configured"`
+	ApexConfigured bool         `json:"apex_configured"`
+}
+
+// DNSSetup returns the DNS setup of the domain for it to point to target,
+// i.e. the default domain of its project.
+func (d *Domain) DNSSetup(target string) (*DNSSetup, error) {
+	apex, err := publicsuffix.EffectiveTLDPlusOne(d.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &DNSSetup{
+		Domain:     d.Name,
+		Apex:       apex,
+		Configured: d.PointsTo(target),
+	}
+
+	if d.Name != apex {
+		s.Records = append(s.Records, &DNSRecord{Name: d.Name, Type: RecordTypeCNAME, Values: []string{target}})
+	}
+
+	if ips := HealthyEdgeIPs(); len(ips) > 0 {
+		s.Records = append(s.Records, &DNSRecord{Name: apex, Type: RecordTypeA, Values: ips})
+	}
+	s.Records = append(s.Records, &DNSRecord{Name: apex, Type: RecordTypeALIAS, Values: []string{target}})
+
+	s.ApexConfigured = ApexPointsTo(apex, target)
+	if d.Name == apex {
+		s.Configured = s.ApexConfigured
+	}
+
+	return s, nil
+}
+
+// ApexPointsTo returns whether the A records of an apex domain point to
+// PubStorm. Unlike subdomains, which only need one address in common with
+// target, every address of an apex domain has to be one of the edges or one
+// of the addresses of target (e.g. with a flattened ALIAS record), since
+// visitors are sent to any of them.
+func ApexPointsTo(apex, target string) bool {
+	addrs, err := LookupHost(apex)
+	if err != nil || len(addrs) == 0 {
+		return false
+	}
+
+	valid := map[string]bool{}
+	for _, ip := range shared.EdgeIPs {
+		valid[ip] = true
+	}
+	if targetAddrs, err := LookupHost(target); err == nil {
+		for _, addr := range targetAddrs {
+			valid[addr] = true
+		}
+	}
+
+	for _, addr := range addrs {
+		if !valid[addr] {
+			return false
+		}
+	}
+	return true
+}
+
+// HealthyEdgeIPs returns the addresses of the edges that pass a health check,
+// in the order they are configured. If none of them do, all of them are
+// returned, since an apex domain is better off pointing to edges that are
+// down for now than to nothing at all.
+func HealthyEdgeIPs() []string {
+	healthy := make([]bool, len(shared.EdgeIPs))
+
+	var wg sync.WaitGroup
+	for i, ip := range shared.EdgeIPs {
+		wg.Add(1)
+		go func(i int, ip string) {
+			defer wg.Done()
+			healthy[i] = CheckEdgeIP(ip)
+		}(i, ip)
+	}
+	wg.Wait()
+
+	var ips []string
+	for i, ip := range shared.EdgeIPs {
+		if healthy[i] {
+			ips = append(ips, ip)
+		}
+	}
+
+	if len(ips) == 0 {
+		return shared.EdgeIPs
+	}
+	return ips
+}
@@ -0,0 +1,54 @@
+package domain
+
+import (
+	"net"
+	"strings"
+)
+
+// DNS lookup functions, which can be replaced in tests.
+var (
+	LookupCNAME = net.LookupCNAME
+	LookupHost  = net.LookupHost
+)
+
+// PointsTo returns whether the DNS records of the domain point to target,
+// either with a CNAME record, or with A records (e.g. ALIAS or flattened
+// CNAME records of apex domains) that resolve to the addresses of target.
+func (d *Domain) PointsTo(target string) bool {
+	if cname, err := LookupCNAME(d.Name); err == nil {
+		cname = canonicalName(cname)
+		if cname == canonicalName(target) {
+			return true
+		}
+
+		// LookupCNAME follows the whole CNAME chain, so the domain points to
+		// target if both end up at the same canonical name.
+		if targetCNAME, err := LookupCNAME(target); err == nil && cname == canonicalName(targetCNAME) && cname != canonicalName(d.Name) {
+			return true
+		}
+	}
+
+	addrs, err := LookupHost(d.Name)
+	if err != nil || len(addrs) == 0 {
+		return false
+	}
+
+	targetAddrs, err := LookupHost(target)
+	if err != nil {
+		return false
+	}
+
+	for _, addr := range addrs {
+		for _, targetAddr := range targetAddrs {
+			if addr == targetAddr {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func canonicalName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(name), ".")
+}
@@ -0,0 +1,172 @@
+package domain_test
+
+import (
+	"errors"
+	"net"
+
+	"github.com/nitrous-io/rise-server/apiserver/models/domain"
+	"github.com/nitrous-io/rise-server/shared"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DNS", func() {
+	var (
+		origLookupCNAME func(string) (string, error)
+		origLookupHost  func(string) ([]string, error)
+
+		cnames map[string]string
+		hosts  map[string][]string
+
+		errNotFound = errors.New("no such host")
+	)
+
+	BeforeEach(func() {
+		origLookupCNAME = domain.LookupCNAME
+		origLookupHost = domain.LookupHost
+
+		cnames = map[string]string{}
+		hosts = map[string][]string{
+			"foo-bar.risecloud.dev": {"203.0.113.1", "203.0.113.2"},
+		}
+
+		domain.LookupCNAME = func(name string) (string, error) {
+			if cname, ok := cnames[name]; ok {
+				return cname, nil
+			}
+			if _, ok := hosts[name]; ok {
+				return name + ".", nil
+			}
+			return "", errNotFound
+		}
+		domain.LookupHost = func(name string) ([]string, error) {
+			if addrs, ok := hosts[name]; ok {
+				return addrs, nil
+			}
+			return nil, &net.DNSError{Err: "no such host", Name: name}
+		}
+	})
+
+	AfterEach(func() {
+		domain.LookupCNAME = origLookupCNAME
+		domain.LookupHost = origLookupHost
+	})
+
+	Describe("PointsTo()", func() {
+		dom := &domain.Domain{Name: "www.foo-bar.com"}
+
+		It("returns true if the domain is a CNAME of the target", func() {
+			cnames["www.foo-bar.com"] = "Foo-Bar.risecloud.dev."
+			Expect(dom.PointsTo("foo-bar.risecloud.dev")).To(BeTrue())
+		})
+
+		It("returns true if the domain and the target have the same canonical name", func() {
+			cnames["www.foo-bar.com"] = "edge-lb.example.com."
+			cnames["foo-bar.risecloud.dev"] = "edge-lb.example.com."
+			Expect(dom.PointsTo("foo-bar.risecloud.dev")).To(BeTrue())
+		})
+
+		It("returns true if the domain resolves to an address of the target", func() {
+			hosts["www.foo-bar.com"] = []string{"203.0.113.2"}
+			Expect(dom.PointsTo("foo-bar.risecloud.dev")).To(BeTrue())
+		})
+
+		It("returns false if the domain points elsewhere", func() {
+			hosts["www.foo-bar.com"] = []string{"198.51.100.1"}
+			Expect(dom.PointsTo("foo-bar.risecloud.dev")).To(BeFalse())
+		})
+
+		It("returns false if the domain does not resolve", func() {
+			Expect(dom.PointsTo("foo-bar.risecloud.dev")).To(BeFalse())
+		})
+	})
+
+	Context("with edge IPs", func() {
+		var (
+			origEdgeIPs     []string
+			origCheckEdgeIP func(string) bool
+
+			unhealthy map[string]bool
+		)
+
+		BeforeEach(func() {
+			origEdgeIPs = shared.EdgeIPs
+			shared.EdgeIPs = []string{"192.0.2.1", "192.0.2.2"}
+
+			unhealthy = map[string]bool{}
+			origCheckEdgeIP = domain.CheckEdgeIP
+			domain.CheckEdgeIP = func(ip string) bool {
+				return !unhealthy[ip]
+			}
+		})
+
+		AfterEach(func() {
+			shared.EdgeIPs = origEdgeIPs
+			domain.CheckEdgeIP = origCheckEdgeIP
+		})
+
+		Describe("ApexPointsTo()", func() {
+			It("returns true if every address of the apex domain is an edge", func() {
+				hosts["foo-bar.com"] = []string{"192.0.2.1", "192.0.2.2"}
+				Expect(domain.ApexPointsTo("foo-bar.com", "foo-bar.risecloud.dev")).To(BeTrue())
+			})
+
+			It("returns true if the apex domain is an ALIAS of the target", func() {
+				hosts["foo-bar.com"] = []string{"203.0.113.1", "203.0.113.2"}
+				Expect(domain.ApexPointsTo("foo-bar.com", "foo-bar.risecloud.dev")).To(BeTrue())
+			})
+
+			It("returns false if any address of the apex domain points elsewhere", func() {
+				hosts["foo-bar.com"] = []string{"192.0.2.1", "198.51.100.1"}
+				Expect(domain.ApexPointsTo("foo-bar.com", "foo-bar.risecloud.dev")).To(BeFalse())
+			})
+
+			It("returns false if the apex domain does not resolve", func() {
+				Expect(domain.ApexPointsTo("foo-bar.com", "foo-bar.risecloud.dev")).To(BeFalse())
+			})
+		})
+
+		Describe("HealthyEdgeIPs()", func() {
+			It("returns the edges that pass the health check", func() {
+				unhealthy["192.0.2.1"] = true
+				Expect(domain.HealthyEdgeIPs()).To(Equal([]string{"192.0.2.2"}))
+			})
+
+			It("returns all edges if none of them pass the health check", func() {
+				unhealthy["192.0.2.1"] = true
+				unhealthy["192.0.2.2"] = true
+				Expect(domain.HealthyEdgeIPs()).To(Equal([]string{"192.0.2.1", "192.0.2.2"}))
+			})
+		})
+
+		Describe("DNSSetup()", func() {
+			It("returns the records of the domain and its apex domain", func() {
+				cnames["www.foo-bar.com"] = "foo-bar.risecloud.dev."
+
+				dom := &domain.Domain{Name: "www.foo-bar.com"}
+				s, err := dom.DNSSetup("foo-bar.risecloud.dev")
+				Expect(err).To(BeNil())
+
+				Expect(s.Domain).To(Equal("www.foo-bar.com"))
+				Expect(s.Apex).To(Equal("foo-bar.com"))
+				Expect(s.Records).To(Equal([]*domain.DNSRecord{
+					{Name: "www.foo-bar.com", Type: domain.RecordTypeCNAME, Values: []string{"foo-bar.risecloud.dev"}},
+					{Name: "foo-bar.com", Type: domain.RecordTypeA, Values: []string{"192.0.2.1", "192.0.2.2"}},
+					{Name: "foo-bar.com", Type: domain.RecordTypeALIAS, Values: []string{"foo-bar.risecloud.dev"}},
+				}))
+				Expect(s.Configured).To(BeTrue())
+				Expect(s.ApexConfigured).To(BeFalse())
+			})
+
+			It("leaves out the edges that fail the health check", func() {
+				unhealthy["192.0.2.2"] = true
+
+				dom := &domain.Domain{Name: "www.foo-bar.com"}
+				s, err := dom.DNSSetup("foo-bar.risecloud.dev")
+				Expect(err).To(BeNil())
+				Expect(s.Records[1].Values).To(Equal([]string{"192.0.2.1"}))
+			})
+		})
+	})
+})
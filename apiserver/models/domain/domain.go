@@ -3,6 +3,7 @@ package domain
 import (
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/jinzhu/gorm"
 	"github.com/nitrous-io/rise-server/shared"
@@ -11,17 +12,107 @@ import (
 
 var domainLabelRe = regexp.MustCompile(`\A([a-z0-9]|([a-z0-9][a-z0-9\-]*[a-z0-9]))\z`)
 
+// Domain states
+const (
+	// StatePendingVerification domains do not point to PubStorm yet, so they
+	// are not served until their DNS records are verified.
+	StatePendingVerification = "pending_verification"
+	StateActive              = "active"
+	// StateDisabled domains are no longer served, either because they stopped
+	// pointing to PubStorm or because an admin disabled them, e.g. during an
+	// ownership dispute. See DisabledReason.
+	StateDisabled = "disabled"
+)
+
+// Reasons that domains are disabled for.
+const (
+	// DisabledReasonDNS is the reason of domains that failed re-verification
+	// of their DNS records for shared.DomainDisableDays. They are enabled
+	// again once they point to PubStorm again.
+	DisabledReasonDNS = "dns_check_failed"
+	// DisabledReasonAdmin is the reason of domains that were disabled by an
+	// admin. They are only enabled again by an admin.
+	DisabledReasonAdmin = "disabled_by_admin"
+)
+
+// TLS policies
+const (
+	// TLSPolicyModern only accepts TLS 1.2 with forward secret AEAD ciphers,
+	// for domains that must pass strict scans.
+	TLSPolicyModern = "modern"
+	// TLSPolicyIntermediate is the default, with which domains are served
+	// using the TLS settings of the edges.
+	TLSPolicyIntermediate = "intermediate"
+	// TLSPolicyLegacy also accepts ciphers without forward secrecy and 3DES,
+	// for domains that must support very old clients.
+	TLSPolicyLegacy = "legacy"
+)
+
+// TLSPolicyNames are the names of the TLS policies that can be selected for a
+// domain.
+var TLSPolicyNames = []string{TLSPolicyModern, TLSPolicyIntermediate, TLSPolicyLegacy}
+
+// TLSPolicy is the minimum TLS version and the ciphers (in OpenSSL cipher list
+// format) that edges accept for a domain.
+type TLSPolicy struct {
+	Name       string `json:"policy"`
+	MinVersion string `json:"min_version"`
+	Ciphers    string `json:"ciphers"`
+}
+
+const (
+	modernCiphers = "ECDHE-ECDSA-AES128-GCM-SHA256:ECDHE-RSA-AES128-GCM-SHA256:" +
+		"ECDHE-ECDSA-AES256-GCM-SHA384:ECDHE-RSA-AES256-GCM-SHA384:" +
+		"ECDHE-ECDSA-CHACHA20-POLY1305:ECDHE-RSA-CHACHA20-POLY1305"
+	intermediateCiphers = modernCiphers + ":" +
+		"DHE-RSA-AES128-GCM-SHA256:DHE-RSA-AES256-GCM-SHA384:" +
+		"ECDHE-ECDSA-AES128-SHA256:ECDHE-RSA-AES128-SHA256:" +
+		"ECDHE-ECDSA-AES128-SHA:ECDHE-RSA-AES128-SHA:" +
+		"ECDHE-ECDSA-AES256-SHA384:ECDHE-RSA-AES256-SHA384:" +
+		"ECDHE-ECDSA-AES256-SHA:ECDHE-RSA-AES256-SHA:" +
+		"DHE-RSA-AES128-SHA256:DHE-RSA-AES256-SHA256"
+	legacyCiphers = intermediateCiphers + ":" +
+		"AES128-GCM-SHA256:AES256-GCM-SHA384:AES128-SHA256:AES256-SHA256:" +
+		"AES128-SHA:AES256-SHA:DES-CBC3-SHA"
+)
+
+// TLSPolicies are the TLS policies by name.
+var TLSPolicies = map[string]*TLSPolicy{
+	TLSPolicyModern:       {Name: TLSPolicyModern, MinVersion: "1.2", Ciphers: modernCiphers},
+	TLSPolicyIntermediate: {Name: TLSPolicyIntermediate, MinVersion: "1.0", Ciphers: intermediateCiphers},
+	TLSPolicyLegacy:       {Name: TLSPolicyLegacy, MinVersion: "1.0", Ciphers: legacyCiphers},
+}
+
 type Domain struct {
 	gorm.Model
 
 	ProjectID uint
 	Name      string
+
+	State        string `sql:"default:'active'"`
+	DNSCheckedAt *time.Time
+	// DNSFailingSince is when the DNS records of an active domain were first
+	// found to no longer point to PubStorm. It is cleared once they do again.
+	DNSFailingSince *time.Time
+
+	DisabledAt     *time.Time
+	DisabledReason *string
+
+	TLSPolicy string `sql:"default:'intermediate'"`
+
+	// The daily quotas set for the domain, which override the quotas derived
+	// from the plan of its project. Use Quotas() to read them.
+	RequestQuotaSoft   *int64
+	RequestQuotaHard   *int64
+	BandwidthQuotaSoft *int64
+	BandwidthQuotaHard *int64
 }
 
 // JSON specifies which fields of a domain will be marshaled to JSON.
 type JSON struct {
 	Name  string `json:"name"`
 	HTTPS *bool  `json:"https,omitempty"`
+	State string `json:"state,omitempty"`
 }
 
 // Sanitizes domain, e.g. Prepends www if an apex domain is given
@@ -66,6 +157,10 @@ func (d *Domain) Validate() map[string]string {
 		}
 	}
 
+	if d.TLSPolicy != "" && TLSPolicies[d.TLSPolicy] == nil {
+		errors["tls_policy"] = "must be one of " + strings.Join(TLSPolicyNames, ", ")
+	}
+
 	if len(errors) == 0 {
 		return nil
 	}
@@ -75,8 +170,43 @@ func (d *Domain) Validate() map[string]string {
 // Returns a struct that can be converted to JSON
 func (d *Domain) AsJSON() interface{} {
 	return JSON{
-		Name: d.Name,
+		Name:  d.Name,
+		State: d.jsonState(),
+	}
+}
+
+// IsPending returns whether the domain is pending verification of its DNS
+// records.
+func (d *Domain) IsPending() bool {
+	return d.State == StatePendingVerification
+}
+
+// jsonState returns the state to be included in JSON, which is omitted for
+// active domains.
+func (d *Domain) jsonState() string {
+	if d.State != StateActive {
+		return d.State
+	}
+	return ""
+}
+
+// PublishedTLSPolicy returns the TLS policy to be included in the domain's
+// meta.json, which is nil for the default policy, as edges are already
+// configured with it.
+func (d *Domain) PublishedTLSPolicy() *TLSPolicy {
+	if d.TLSPolicy == "" || d.TLSPolicy == TLSPolicyIntermediate {
+		return nil
+	}
+	return TLSPolicies[d.TLSPolicy]
+}
+
+// jsonTLSPolicy returns the TLS policy to be included in JSON, which is
+// omitted for the default policy.
+func (d *Domain) jsonTLSPolicy() string {
+	if d.TLSPolicy == TLSPolicyIntermediate {
+		return ""
 	}
+	return d.TLSPolicy
 }
 
 // Domain with protocol
@@ -95,5 +225,107 @@ func (dp *DomainWithProtocol) AsJSON() interface{} {
 	return JSON{
 		Name:  dp.Name,
 		HTTPS: &dp.HTTPS,
+		State: dp.jsonState(),
 	}
 }
+
+// Cert sources
+const (
+	// CertSourceDefault is the source of the cert of a project's default
+	// domain, which is served with our wildcard cert.
+	CertSourceDefault     = "default"
+	CertSourceUploaded    = "uploaded"
+	CertSourceLetsEncrypt = "letsencrypt"
+)
+
+// DomainWithCert is a domain with the status of its cert, if it has one.
+type DomainWithCert struct {
+	Domain
+	CertExpiresAt *time.Time `sql:"column:cert_expires_at"`
+	LetsEncrypt   bool       `sql:"column:letsencrypt"`
+}
+
+// DetailsJSON specifies which fields of a domain and its cert will be
+// marshaled to JSON.
+type DetailsJSON struct {
+	Name          string     `json:"name"`
+	State         string     `json:"state"`
+	HTTPS         bool       `json:"https"`
+	CertSource    string     `json:"cert_source,omitempty"`
+	CertExpiresAt *time.Time `json:"cert_expires_at,omitempty"`
+	TLSPolicy     string     `json:"tls_policy,omitempty"`
+}
+
+// Returns table name
+func (dc *DomainWithCert) TableName() string {
+	return "domains"
+}
+
+// AsDetailsJSON returns a struct that can be converted to JSON
+func (dc *DomainWithCert) AsDetailsJSON() interface{} {
+	j := DetailsJSON{
+		Name:          dc.Name,
+		State:         dc.State,
+		HTTPS:         dc.CertExpiresAt != nil,
+		CertExpiresAt: dc.CertExpiresAt,
+		TLSPolicy:     dc.jsonTLSPolicy(),
+	}
+
+	if j.HTTPS {
+		j.CertSource = CertSourceUploaded
+		if dc.LetsEncrypt {
+			j.CertSource = CertSourceLetsEncrypt
+		}
+	}
+
+	return j
+}
+
+// FindWithCertsByProjectID returns the domains of a project, ordered by name,
+// with the status of their certs.
+func FindWithCertsByProjectID(db *gorm.DB, projectID uint) ([]*DomainWithCert, error) {
+	var doms []*DomainWithCert
+	if err := db.Select("domains.*, certs.expires_at AS cert_expires_at, acme_certs.id IS NOT NULL AS letsencrypt").
+		Joins(`LEFT JOIN certs ON certs.domain_id = domains.id AND certs.deleted_at IS NULL
+			LEFT JOIN acme_certs ON acme_certs.domain_id = domains.id AND acme_certs.deleted_at IS NULL AND acme_certs.cert <> ''`).
+		Where("domains.project_id = ?", projectID).
+		Order("domains.name ASC").Find(&doms).Error; err != nil {
+		return nil, err
+	}
+
+	return doms, nil
+}
+
+// FindAllByName returns the domains with the given name, including deleted
+// ones, most recently created first. A domain can be added again once it is
+// deleted, so there can be more than one.
+func FindAllByName(db *gorm.DB, name string) ([]*Domain, error) {
+	var doms []*Domain
+	if err := db.Unscoped().Where("name = ?", name).Order("created_at DESC, id DESC").Find(&doms).Error; err != nil {
+		return nil, err
+	}
+
+	return doms, nil
+}
+
+// FindAllByProjectID returns the domains of a project, including deleted
+// ones, most recently created first.
+func FindAllByProjectID(db *gorm.DB, projectID uint) ([]*Domain, error) {
+	var doms []*Domain
+	if err := db.Unscoped().Where("project_id = ?", projectID).Order("created_at DESC, id DESC").Find(&doms).Error; err != nil {
+		return nil, err
+	}
+
+	return doms, nil
+}
+
+// FindActiveByProjectID returns the active domains of a project, ordered by
+// name.
+func FindActiveByProjectID(db *gorm.DB, projectID uint) ([]*Domain, error) {
+	var doms []*Domain
+	if err := db.Where("project_id = ? AND state = ?", projectID, StateActive).Order("name ASC").Find(&doms).Error; err != nil {
+		return nil, err
+	}
+
+	return doms, nil
+}
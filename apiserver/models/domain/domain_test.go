@@ -154,5 +154,116 @@ var _ = Describe("Domain", func() {
 			Entry("disallows names shorter than 3 characters", "co", "is too short (min. 3 characters)"),
 			Entry("disallows names longer than 255 characters", strings.Repeat("a", 252)+".com", "is too long (max. 255 characters)"),
 		)
+
+		DescribeTable("validates TLS policy",
+			func(policy, policyErr string) {
+				dom.Name = "abc.com"
+				dom.TLSPolicy = policy
+				errors := dom.Validate()
+
+				if policyErr == "" {
+					Expect(errors).To(BeNil())
+				} else {
+					Expect(errors).NotTo(BeNil())
+					Expect(errors["tls_policy"]).To(Equal(policyErr))
+				}
+			},
+
+			Entry("default", "", ""),
+			Entry("modern", domain.TLSPolicyModern, ""),
+			Entry("intermediate", domain.TLSPolicyIntermediate, ""),
+			Entry("legacy", domain.TLSPolicyLegacy, ""),
+			Entry("unknown", "ancient", "must be one of modern, intermediate, legacy"),
+		)
+	})
+
+	Describe("PublishedTLSPolicy()", func() {
+		It("returns the TLS policy unless it is the default", func() {
+			dom := factories.Domain(db, proj)
+			Expect(db.First(dom, dom.ID).Error).To(BeNil())
+			Expect(dom.TLSPolicy).To(Equal(domain.TLSPolicyIntermediate))
+			Expect(dom.PublishedTLSPolicy()).To(BeNil())
+
+			dom.TLSPolicy = domain.TLSPolicyModern
+			Expect(dom.PublishedTLSPolicy()).To(Equal(domain.TLSPolicies[domain.TLSPolicyModern]))
+			Expect(dom.PublishedTLSPolicy().MinVersion).To(Equal("1.2"))
+		})
+	})
+
+	Describe("PlanQuotas()", func() {
+		It("derives the quotas from the limits of the plan", func() {
+			Expect(domain.PlanQuotas(1000, 0)).To(Equal(&domain.Quotas{
+				Requests: &domain.Quota{Soft: 800, Hard: 1000},
+			}))
+			Expect(domain.PlanQuotas(0, 0).IsEmpty()).To(BeTrue())
+		})
+	})
+
+	Describe("Quotas()", func() {
+		var dom *domain.Domain
+
+		BeforeEach(func() {
+			dom = &domain.Domain{}
+		})
+
+		It("returns the quotas of the plan if none are set", func() {
+			Expect(dom.Quotas(1000, 5000)).To(Equal(domain.PlanQuotas(1000, 5000)))
+		})
+
+		It("returns the quotas that are set within the limits of the plan", func() {
+			requestsHard, bandwidthSoft, bandwidthHard := int64(500), int64(100), int64(9000)
+			dom.RequestQuotaHard = &requestsHard
+			dom.BandwidthQuotaSoft = &bandwidthSoft
+			dom.BandwidthQuotaHard = &bandwidthHard
+
+			Expect(dom.Quotas(1000, 5000)).To(Equal(&domain.Quotas{
+				Requests:  &domain.Quota{Soft: 400, Hard: 500},
+				Bandwidth: &domain.Quota{Soft: 100, Hard: 5000},
+			}))
+		})
+
+		It("returns the quotas that are set if the plan is unlimited", func() {
+			requestsSoft, requestsHard := int64(10), int64(20)
+			dom.RequestQuotaSoft = &requestsSoft
+			dom.RequestQuotaHard = &requestsHard
+
+			Expect(dom.Quotas(0, 0)).To(Equal(&domain.Quotas{
+				Requests: &domain.Quota{Soft: 10, Hard: 20},
+			}))
+		})
+	})
+
+	Describe("ValidateQuotas()", func() {
+		n := func(v int64) *int64 {
+			return &v
+		}
+
+		DescribeTable("validates the quotas against the limits of the plan",
+			func(requestsSoft, requestsHard *int64, errs map[string]string) {
+				dom := &domain.Domain{
+					RequestQuotaSoft: requestsSoft,
+					RequestQuotaHard: requestsHard,
+				}
+				if errs == nil {
+					Expect(dom.ValidateQuotas(1000, 0)).To(BeNil())
+				} else {
+					Expect(dom.ValidateQuotas(1000, 0)).To(Equal(errs))
+				}
+			},
+
+			Entry("none", nil, nil, nil),
+			Entry("within the limit", n(500), n(1000), nil),
+			Entry("soft quota within the limit", n(900), nil, nil),
+			Entry("hard quota above the limit", nil, n(1001), map[string]string{
+				"requests_hard": "cannot be greater than the limit of your plan (1000)",
+			}),
+			Entry("soft quota above the hard quota", n(600), n(500), map[string]string{
+				"requests_soft": "cannot be greater than the hard quota",
+			}),
+			Entry("not positive", n(0), n(-1), map[string]string{
+				"requests_soft": "must be greater than 0",
+				"requests_hard": "must be greater than 0",
+			}),
+		)
 	})
 })
@@ -0,0 +1,106 @@
+package domain
+
+import "fmt"
+
+// DefaultSoftQuotaPercent is the percentage of a hard quota at which its soft
+// quota is, unless a soft quota is set for the domain.
+const DefaultSoftQuotaPercent = 80
+
+// Quota is how much of something a domain may use per (UTC) day. Crossing the
+// soft quota only alerts the owner of the project, while edges stop serving
+// the domain once the hard quota has been reached.
+type Quota struct {
+	Soft int64 `json:"soft"`
+	Hard int64 `json:"hard"`
+}
+
+// Quotas are the daily quotas of a domain. A quota is nil if the domain has
+// no quota for it.
+type Quotas struct {
+	// Requests is the number of requests per day.
+	Requests *Quota `json:"requests,omitempty"`
+	// Bandwidth is the number of bytes served per day.
+	Bandwidth *Quota `json:"bandwidth,omitempty"`
+}
+
+// PlanQuotas returns the quotas of domains that do not override them, which
+// are derived from the limits of the plan of their project. A limit of 0 is
+// unlimited.
+func PlanQuotas(requestLimit, bandwidthLimit int64) *Quotas {
+	return &Quotas{
+		Requests:  quota(requestLimit, nil, nil),
+		Bandwidth: quota(bandwidthLimit, nil, nil),
+	}
+}
+
+// Quotas returns the quotas of the domain, which are the ones set for it
+// within the limits of the plan of its project.
+func (d *Domain) Quotas(requestLimit, bandwidthLimit int64) *Quotas {
+	return &Quotas{
+		Requests:  quota(requestLimit, d.RequestQuotaSoft, d.RequestQuotaHard),
+		Bandwidth: quota(bandwidthLimit, d.BandwidthQuotaSoft, d.BandwidthQuotaHard),
+	}
+}
+
+// IsEmpty returns whether there are no quotas.
+func (q *Quotas) IsEmpty() bool {
+	return q == nil || (q.Requests == nil && q.Bandwidth == nil)
+}
+
+// ValidateQuotas validates the quotas set for the domain against the limits
+// of the plan of its project. If there are invalid fields, it returns a map
+// of <field, errors> and returns nil if valid.
+func (d *Domain) ValidateQuotas(requestLimit, bandwidthLimit int64) map[string]string {
+	errors := map[string]string{}
+
+	validateQuota(errors, "requests", requestLimit, d.RequestQuotaSoft, d.RequestQuotaHard)
+	validateQuota(errors, "bandwidth", bandwidthLimit, d.BandwidthQuotaSoft, d.BandwidthQuotaHard)
+
+	if len(errors) == 0 {
+		return nil
+	}
+	return errors
+}
+
+// quota returns a quota with the given soft and hard quotas, if set. The hard
+// quota is at most limit, and the soft quota is at most the hard quota.
+func quota(limit int64, soft, hard *int64) *Quota {
+	q := &Quota{Hard: limit}
+	if hard != nil && (limit == 0 || *hard < limit) {
+		q.Hard = *hard
+	}
+	if q.Hard == 0 {
+		return nil
+	}
+
+	q.Soft = q.Hard * DefaultSoftQuotaPercent / 100
+	if soft != nil && *soft <= q.Hard {
+		q.Soft = *soft
+	}
+	return q
+}
+
+func validateQuota(errors map[string]string, name string, limit int64, soft, hard *int64) {
+	softKey, hardKey := name+"_soft", name+"_hard"
+
+	if hard != nil {
+		if *hard < 1 {
+			errors[hardKey] = "must be greater than 0"
+		} else if limit > 0 && *hard > limit {
+			errors[hardKey] = fmt.Sprintf("cannot be greater than the limit of your plan (%d)", limit)
+		}
+	}
+
+	if soft != nil {
+		effectiveHard := limit
+		if hard != nil {
+			effectiveHard = *hard
+		}
+
+		if *soft < 1 {
+			errors[softKey] = "must be greater than 0"
+		} else if effectiveHard > 0 && *soft > effectiveHard {
+			errors[softKey] = "cannot be greater than the hard quota"
+		}
+	}
+}
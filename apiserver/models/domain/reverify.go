@@ -0,0 +1,118 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// FindDueForReverification returns the active domains, and the domains that
+// were disabled because of their DNS records, whose DNS records were last
+// checked before the given time, least recently checked first.
+func FindDueForReverification(db *gorm.DB, checkedBefore time.Time) ([]*Domain, error) {
+	var doms []*Domain
+	if err := db.Where("(state = ? OR (state = ? AND disabled_reason = ?)) AND (dns_checked_at IS NULL OR dns_checked_at < ?)",
+		StateActive, StateDisabled, DisabledReasonDNS, checkedBefore).
+		Order("dns_checked_at ASC NULLS FIRST, id ASC").Find(&doms).Error; err != nil {
+		return nil, err
+	}
+
+	return doms, nil
+}
+
+// FindFailingDNS returns the active domains whose DNS records no longer point
+// to PubStorm, and the disabled domains, longest failing first.
+func FindFailingDNS(db *gorm.DB) ([]*Domain, error) {
+	var doms []*Domain
+	if err := db.Where("(state = ? AND dns_failing_since IS NOT NULL) OR state = ?", StateActive, StateDisabled).
+		Order("COALESCE(dns_failing_since, disabled_at) ASC, id ASC").Find(&doms).Error; err != nil {
+		return nil, err
+	}
+
+	return doms, nil
+}
+
+// RecordDNSCheck records that the DNS records of the domain were checked,
+// and whether they pointed to PubStorm.
+func (d *Domain) RecordDNSCheck(db *gorm.DB, ok bool) error {
+	now := time.Now()
+
+	failingSince := d.DNSFailingSince
+	if ok {
+		failingSince = nil
+	} else if failingSince == nil {
+		failingSince = &now
+	}
+
+	if err := db.Model(Domain{}).Where("id = ?", d.ID).Updates(map[string]interface{}{
+		"dns_checked_at":    now,
+		"dns_failing_since": failingSince,
+	}).Error; err != nil {
+		return err
+	}
+
+	d.DNSCheckedAt = &now
+	d.DNSFailingSince = failingSince
+	return nil
+}
+
+// FailingFor returns how long the DNS records of the domain have not pointed
+// to PubStorm, which is 0 if they do.
+func (d *Domain) FailingFor(now time.Time) time.Duration {
+	if d.DNSFailingSince == nil {
+		return 0
+	}
+	return now.Sub(*d.DNSFailingSince)
+}
+
+// Disable marks the domain as disabled for the given reason, e.g.
+// DisabledReasonDNS. The caller has to stop serving the domain by deleting
+// its meta.json. It returns false if the domain was already disabled.
+func (d *Domain) Disable(db *gorm.DB, reason string) (bool, error) {
+	now := time.Now()
+
+	q := db.Model(Domain{}).Where("id = ? AND state <> ?", d.ID, StateDisabled).Updates(map[string]interface{}{
+		"state":           StateDisabled,
+		"disabled_at":     now,
+		"disabled_reason": reason,
+	})
+	if err := q.Error; err != nil {
+		return false, err
+	}
+	if q.RowsAffected == 0 {
+		return false, nil
+	}
+
+	d.State = StateDisabled
+	d.DisabledAt = &now
+	d.DisabledReason = &reason
+	return true, nil
+}
+
+// Enable marks a disabled domain as active again, e.g. once its DNS records
+// point to PubStorm again. The caller has to publish the meta.json of the
+// domain. It returns false if the domain was not disabled.
+func (d *Domain) Enable(db *gorm.DB) (bool, error) {
+	now := time.Now()
+
+	q := db.Model(Domain{}).Where("id = ? AND state = ?", d.ID, StateDisabled).Updates(map[string]interface{}{
+		"state":             StateActive,
+		"dns_checked_at":    now,
+		"dns_failing_since": nil,
+		"disabled_at":       nil,
+		"disabled_reason":   nil,
+	})
+	if err := q.Error; err != nil {
+		return false, err
+	}
+	if q.RowsAffected == 0 {
+		return false, nil
+	}
+
+	d.State = StateActive
+	d.DNSCheckedAt = &now
+	d.DNSFailingSince = nil
+	d.DisabledAt = nil
+	d.DisabledReason = nil
+	return true, nil
+}
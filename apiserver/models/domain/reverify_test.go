@@ -0,0 +1,108 @@
+package domain_test
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/domain"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Domain re-verification", func() {
+	var (
+		db   *gorm.DB
+		err  error
+		proj *project.Project
+		dom  *domain.Domain
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+
+		u := factories.User(db)
+		proj = factories.Project(db, u)
+		dom = factories.Domain(db, proj, "www.example.com")
+	})
+
+	Describe("FindDueForReverification()", func() {
+		It("returns active domains and domains disabled because of their DNS records that were not checked recently", func() {
+			recent := factories.Domain(db, proj, "recent.example.com")
+			Expect(db.Model(recent).Update("dns_checked_at", time.Now()).Error).To(BeNil())
+
+			pending := factories.Domain(db, proj, "pending.example.com")
+			Expect(db.Model(pending).Update("state", domain.StatePendingVerification).Error).To(BeNil())
+
+			failing := factories.Domain(db, proj, "failing.example.com")
+			Expect(failing.Disable(db, domain.DisabledReasonDNS)).To(BeTrue())
+			Expect(db.Model(failing).Update("dns_checked_at", time.Now().Add(-48*time.Hour)).Error).To(BeNil())
+
+			disputed := factories.Domain(db, proj, "disputed.example.com")
+			Expect(disputed.Disable(db, domain.DisabledReasonAdmin)).To(BeTrue())
+
+			doms, err := domain.FindDueForReverification(db, time.Now().Add(-24*time.Hour))
+			Expect(err).To(BeNil())
+			Expect(doms).To(HaveLen(2))
+			Expect(doms[0].ID).To(Equal(dom.ID))
+			Expect(doms[1].ID).To(Equal(failing.ID))
+		})
+	})
+
+	Describe("RecordDNSCheck()", func() {
+		It("records when the domain started failing, and clears it once it passes", func() {
+			Expect(dom.RecordDNSCheck(db, false)).To(Succeed())
+			Expect(db.First(dom, dom.ID).Error).To(BeNil())
+			Expect(dom.DNSCheckedAt).NotTo(BeNil())
+			Expect(dom.DNSFailingSince).NotTo(BeNil())
+			failingSince := *dom.DNSFailingSince
+
+			Expect(dom.RecordDNSCheck(db, false)).To(Succeed())
+			Expect(db.First(dom, dom.ID).Error).To(BeNil())
+			Expect(dom.DNSFailingSince.Unix()).To(Equal(failingSince.Unix()))
+			Expect(dom.FailingFor(failingSince.Add(time.Hour))).To(Equal(time.Hour))
+
+			Expect(dom.RecordDNSCheck(db, true)).To(Succeed())
+			Expect(db.First(dom, dom.ID).Error).To(BeNil())
+			Expect(dom.DNSFailingSince).To(BeNil())
+			Expect(dom.FailingFor(time.Now())).To(BeZero())
+		})
+	})
+
+	Describe("Disable() and Enable()", func() {
+		It("disables and enables the domain", func() {
+			ok, err := dom.Disable(db, domain.DisabledReasonAdmin)
+			Expect(err).To(BeNil())
+			Expect(ok).To(BeTrue())
+
+			Expect(db.First(dom, dom.ID).Error).To(BeNil())
+			Expect(dom.State).To(Equal(domain.StateDisabled))
+			Expect(dom.DisabledAt).NotTo(BeNil())
+			Expect(*dom.DisabledReason).To(Equal(domain.DisabledReasonAdmin))
+			Expect(dom.AsJSON()).To(Equal(domain.JSON{Name: "www.example.com", State: "disabled"}))
+
+			ok, err = dom.Disable(db, domain.DisabledReasonDNS)
+			Expect(err).To(BeNil())
+			Expect(ok).To(BeFalse())
+
+			ok, err = dom.Enable(db)
+			Expect(err).To(BeNil())
+			Expect(ok).To(BeTrue())
+
+			Expect(db.First(dom, dom.ID).Error).To(BeNil())
+			Expect(dom.State).To(Equal(domain.StateActive))
+			Expect(dom.DisabledAt).To(BeNil())
+			Expect(dom.DisabledReason).To(BeNil())
+
+			ok, err = dom.Enable(db)
+			Expect(err).To(BeNil())
+			Expect(ok).To(BeFalse())
+		})
+	})
+})
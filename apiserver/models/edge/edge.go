@@ -0,0 +1,62 @@
+package edge
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Edge is a database model representing an edge server, and the version of
+// the edge config it last acknowledged applying.
+type Edge struct {
+	ID             uint `gorm:"primary_key"`
+	Name           string
+	ConfigVersion  uint
+	AcknowledgedAt *time.Time
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// JSON specifies which fields of an edge will be marshaled to JSON.
+type JSON struct {
+	Name           string     `json:"name"`
+	ConfigVersion  uint       `json:"config_version"`
+	AcknowledgedAt *time.Time `json:"acknowledged_at"`
+	UpToDate       bool       `json:"up_to_date"`
+}
+
+// AsJSON returns a struct that can be converted to JSON. The edge is up to
+// date if it runs the given latest config version.
+func (e *Edge) AsJSON(latestVersion uint) interface{} {
+	return JSON{
+		Name:           e.Name,
+		ConfigVersion:  e.ConfigVersion,
+		AcknowledgedAt: e.AcknowledgedAt,
+		UpToDate:       e.ConfigVersion >= latestVersion,
+	}
+}
+
+// Acknowledge records that the edge with the given name has applied the
+// config with the given version, adding the edge if it is not known yet.
+// Acknowledgements can arrive out of order, so the version the edge is known
+// to run is never lowered.
+func Acknowledge(db *gorm.DB, name string, version uint) error {
+	return db.Exec(`WITH update_edge AS (
+		UPDATE edges
+		SET config_version = GREATEST(config_version, $2), acknowledged_at = now(), updated_at = now()
+		WHERE name = $1 RETURNING id
+	)
+	INSERT INTO edges (name, config_version, acknowledged_at)
+	SELECT $1, $2, now() WHERE NOT EXISTS (SELECT * FROM update_edge);
+	`, name, version).Error
+}
+
+// All returns all edges, ordered by name.
+func All(db *gorm.DB) ([]*Edge, error) {
+	var edges []*Edge
+	if err := db.Order("name ASC").Find(&edges).Error; err != nil {
+		return nil, err
+	}
+	return edges, nil
+}
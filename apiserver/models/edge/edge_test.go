@@ -0,0 +1,69 @@
+package edge_test
+
+import (
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/edge"
+	"github.com/nitrous-io/rise-server/testhelper"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "edge")
+}
+
+var _ = Describe("Edge", func() {
+	var db *gorm.DB
+
+	BeforeEach(func() {
+		var err error
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+	})
+
+	Describe("Acknowledge()", func() {
+		It("adds edges that are not known yet", func() {
+			Expect(edge.Acknowledge(db, "edge-sg-1", 3)).To(Succeed())
+
+			edges, err := edge.All(db)
+			Expect(err).To(BeNil())
+			Expect(edges).To(HaveLen(1))
+			Expect(edges[0].Name).To(Equal("edge-sg-1"))
+			Expect(edges[0].ConfigVersion).To(Equal(uint(3)))
+			Expect(edges[0].AcknowledgedAt).NotTo(BeNil())
+		})
+
+		It("updates the config version of known edges", func() {
+			Expect(edge.Acknowledge(db, "edge-sg-1", 3)).To(Succeed())
+			Expect(edge.Acknowledge(db, "edge-sg-1", 5)).To(Succeed())
+
+			edges, err := edge.All(db)
+			Expect(err).To(BeNil())
+			Expect(edges).To(HaveLen(1))
+			Expect(edges[0].ConfigVersion).To(Equal(uint(5)))
+		})
+
+		It("does not lower the config version when acknowledgements arrive out of order", func() {
+			Expect(edge.Acknowledge(db, "edge-sg-1", 5)).To(Succeed())
+			Expect(edge.Acknowledge(db, "edge-sg-1", 3)).To(Succeed())
+
+			edges, err := edge.All(db)
+			Expect(err).To(BeNil())
+			Expect(edges[0].ConfigVersion).To(Equal(uint(5)))
+		})
+	})
+
+	Describe("AsJSON()", func() {
+		It("reports whether the edge runs the latest config version", func() {
+			e := &edge.Edge{Name: "edge-sg-1", ConfigVersion: 3}
+
+			Expect(e.AsJSON(3).(edge.JSON).UpToDate).To(BeTrue())
+			Expect(e.AsJSON(4).(edge.JSON).UpToDate).To(BeFalse())
+		})
+	})
+})
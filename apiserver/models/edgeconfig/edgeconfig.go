@@ -0,0 +1,182 @@
+package edgeconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// MaxBlockedIPs is the maximum number of IP addresses and ranges that can be
+// blocked by a config.
+const MaxBlockedIPs = 1000
+
+// TLSVersions are the minimum TLS versions that edges can be configured to
+// accept.
+var TLSVersions = []string{"1.0", "1.1", "1.2"}
+
+// EdgeConfig is a database model representing a version of the settings of
+// edge servers. Configs without a project apply to all domains, and configs
+// of a project apply to the project's domains in addition to them. The ID of
+// a config is its version, so later configs always have higher versions.
+type EdgeConfig struct {
+	ID        uint `gorm:"primary_key"`
+	ProjectID *uint
+	Settings  []byte
+	CreatedAt time.Time
+}
+
+// Settings are the settings that are pushed to edge servers.
+type Settings struct {
+	RateLimit  *RateLimit   `json:"rate_limit,omitempty"`
+	TLS        *TLSSettings `json:"tls,omitempty"`
+	BlockedIPs []string     `json:"blocked_ips,omitempty"`
+}
+
+// RateLimit is how many requests a client IP address may make per second,
+// with bursts of up to Burst requests.
+type RateLimit struct {
+	RequestsPerSecond int `json:"requests_per_second"`
+	Burst             int `json:"burst"`
+}
+
+// TLSSettings are the settings of TLS connections to edge servers.
+type TLSSettings struct {
+	MinVersion string `json:"min_version"`
+}
+
+// JSON specifies which fields of a config will be marshaled to JSON.
+type JSON struct {
+	Version   uint      `json:"version"`
+	ProjectID *uint     `json:"project_id"`
+	Settings  *Settings `json:"settings"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AsJSON returns a struct that can be converted to JSON
+func (c *EdgeConfig) AsJSON() (interface{}, error) {
+	s, err := c.SettingsData()
+	if err != nil {
+		return nil, err
+	}
+
+	return JSON{
+		Version:   c.ID,
+		ProjectID: c.ProjectID,
+		Settings:  s,
+		CreatedAt: c.CreatedAt,
+	}, nil
+}
+
+// SetSettings sets the settings of the config. Blocked IP addresses are
+// normalized so that the same address is always written the same way.
+func (c *EdgeConfig) SetSettings(s *Settings) error {
+	for i, ip := range s.BlockedIPs {
+		s.BlockedIPs[i] = strings.TrimSpace(ip)
+		if parsed := net.ParseIP(s.BlockedIPs[i]); parsed != nil {
+			s.BlockedIPs[i] = parsed.String()
+		}
+	}
+
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	c.Settings = b
+	return nil
+}
+
+// SettingsData returns the settings of the config.
+func (c *EdgeConfig) SettingsData() (*Settings, error) {
+	s := &Settings{}
+	if len(c.Settings) == 0 {
+		return s, nil
+	}
+
+	if err := json.Unmarshal(c.Settings, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Validate validates EdgeConfig, if there are invalid fields, it returns a
+// map of <field, errors> and returns nil if valid
+func (c *EdgeConfig) Validate() map[string]string {
+	errors := map[string]string{}
+
+	s, err := c.SettingsData()
+	if err != nil {
+		errors["settings"] = "is invalid"
+		return errors
+	}
+
+	if rl := s.RateLimit; rl != nil {
+		if rl.RequestsPerSecond < 1 {
+			errors["rate_limit"] = "requests_per_second must be greater than 0"
+		} else if rl.Burst < 0 {
+			errors["rate_limit"] = "burst must not be negative"
+		}
+	}
+
+	if s.TLS != nil && !isTLSVersion(s.TLS.MinVersion) {
+		errors["tls"] = "min_version must be one of " + strings.Join(TLSVersions, ", ")
+	}
+
+	if len(s.BlockedIPs) > MaxBlockedIPs {
+		errors["blocked_ips"] = fmt.Sprintf("cannot have more than %d entries", MaxBlockedIPs)
+	} else {
+		for _, ip := range s.BlockedIPs {
+			if !isIPOrCIDR(ip) {
+				errors["blocked_ips"] = fmt.Sprintf("%q is not a valid IP address or range", ip)
+				break
+			}
+		}
+	}
+
+	if len(errors) == 0 {
+		return nil
+	}
+	return errors
+}
+
+// LatestVersion returns the version of the latest config of any scope, or 0
+// if there is none. Edges running this version are up to date.
+func LatestVersion(db *gorm.DB) (uint, error) {
+	var version struct {
+		Version uint
+	}
+	if err := db.Raw("SELECT COALESCE(MAX(id), 0) AS version FROM edge_configs").Scan(&version).Error; err != nil {
+		return 0, err
+	}
+	return version.Version, nil
+}
+
+// Recent returns the given number of most recent configs of all scopes, latest
+// first.
+func Recent(db *gorm.DB, limit int) ([]*EdgeConfig, error) {
+	var configs []*EdgeConfig
+	if err := db.Order("id DESC").Limit(limit).Find(&configs).Error; err != nil {
+		return nil, err
+	}
+	return configs, nil
+}
+
+func isTLSVersion(v string) bool {
+	for _, tv := range TLSVersions {
+		if v == tv {
+			return true
+		}
+	}
+	return false
+}
+
+func isIPOrCIDR(s string) bool {
+	if net.ParseIP(s) != nil {
+		return true
+	}
+	_, _, err := net.ParseCIDR(s)
+	return err == nil
+}
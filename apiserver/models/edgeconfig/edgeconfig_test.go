@@ -0,0 +1,98 @@
+package edgeconfig_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/edgeconfig"
+	"github.com/nitrous-io/rise-server/testhelper"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "edgeconfig")
+}
+
+var _ = Describe("EdgeConfig", func() {
+	Describe("SetSettings()", func() {
+		It("normalizes blocked IP addresses", func() {
+			cfg := &edgeconfig.EdgeConfig{}
+			Expect(cfg.SetSettings(&edgeconfig.Settings{
+				BlockedIPs: []string{" 10.0.0.1 ", "2001:DB8:0:0:0:0:0:1", "192.168.0.0/16"},
+			})).To(Succeed())
+
+			s, err := cfg.SettingsData()
+			Expect(err).To(BeNil())
+			Expect(s.BlockedIPs).To(Equal([]string{"10.0.0.1", "2001:db8::1", "192.168.0.0/16"}))
+		})
+	})
+
+	DescribeTable("Validate()",
+		func(s *edgeconfig.Settings, field, message string) {
+			cfg := &edgeconfig.EdgeConfig{}
+			Expect(cfg.SetSettings(s)).To(Succeed())
+
+			errs := cfg.Validate()
+			if field == "" {
+				Expect(errs).To(BeNil())
+			} else {
+				Expect(errs).To(HaveKeyWithValue(field, message))
+			}
+		},
+
+		Entry("empty settings", &edgeconfig.Settings{}, "", ""),
+		Entry("valid settings", &edgeconfig.Settings{
+			RateLimit:  &edgeconfig.RateLimit{RequestsPerSecond: 10, Burst: 20},
+			TLS:        &edgeconfig.TLSSettings{MinVersion: "1.2"},
+			BlockedIPs: []string{"10.0.0.1", "10.1.0.0/16"},
+		}, "", ""),
+		Entry("zero requests per second", &edgeconfig.Settings{
+			RateLimit: &edgeconfig.RateLimit{RequestsPerSecond: 0},
+		}, "rate_limit", "requests_per_second must be greater than 0"),
+		Entry("negative burst", &edgeconfig.Settings{
+			RateLimit: &edgeconfig.RateLimit{RequestsPerSecond: 10, Burst: -1},
+		}, "rate_limit", "burst must not be negative"),
+		Entry("unknown TLS version", &edgeconfig.Settings{
+			TLS: &edgeconfig.TLSSettings{MinVersion: "1.3"},
+		}, "tls", "min_version must be one of 1.0, 1.1, 1.2"),
+		Entry("invalid blocked IP", &edgeconfig.Settings{
+			BlockedIPs: []string{"10.0.0.1", "10.0.0.256"},
+		}, "blocked_ips", `"10.0.0.256" is not a valid IP address or range`),
+		Entry("too many blocked IPs", &edgeconfig.Settings{
+			BlockedIPs: strings.Split(strings.Repeat("10.0.0.1,", edgeconfig.MaxBlockedIPs)+"10.0.0.2", ","),
+		}, "blocked_ips", "cannot have more than 1000 entries"),
+	)
+
+	Describe("LatestVersion()", func() {
+		var db *gorm.DB
+
+		BeforeEach(func() {
+			var err error
+			db, err = dbconn.DB()
+			Expect(err).To(BeNil())
+			testhelper.TruncateTables(db.DB())
+		})
+
+		It("returns 0 if there are no configs", func() {
+			v, err := edgeconfig.LatestVersion(db)
+			Expect(err).To(BeNil())
+			Expect(v).To(Equal(uint(0)))
+		})
+
+		It("returns the version of the latest config", func() {
+			cfg1 := &edgeconfig.EdgeConfig{Settings: []byte(`{}`)}
+			Expect(db.Create(cfg1).Error).To(BeNil())
+			cfg2 := &edgeconfig.EdgeConfig{Settings: []byte(`{}`)}
+			Expect(db.Create(cfg2).Error).To(BeNil())
+
+			v, err := edgeconfig.LatestVersion(db)
+			Expect(err).To(BeNil())
+			Expect(v).To(Equal(cfg2.ID))
+		})
+	})
+})
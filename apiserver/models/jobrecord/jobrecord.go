@@ -0,0 +1,197 @@
+package jobrecord
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/pkg/job"
+)
+
+// Job states
+const (
+	StateQueued    = "queued"
+	StateRunning   = "running"
+	StateSucceeded = "succeeded"
+	StateFailed    = "failed"
+)
+
+// States are all the states a job can be in.
+var States = []string{StateQueued, StateRunning, StateSucceeded, StateFailed}
+
+// ErrInvalidID is returned when a job ID is not a valid number.
+var ErrInvalidID = errors.New("invalid job id")
+
+// JobRecord is a database model recording a job that was enqueued and the
+// status of the workers' attempts at it. A job that failed and was requeued
+// goes back to the queued state with its error recorded in LastError.
+type JobRecord struct {
+	ID uint `gorm:"primary_key"`
+
+	QueueName string
+	Data      []byte
+
+	State     string `sql:"default:'queued'"`
+	Attempts  int
+	LastError *string
+
+	StartedAt  *time.Time
+	FinishedAt *time.Time
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// TableName returns the table name of JobRecord.
+func (r *JobRecord) TableName() string {
+	return "jobs"
+}
+
+// IDString returns the ID of the job as it is sent along with the job.
+func (r *JobRecord) IDString() string {
+	return strconv.FormatUint(uint64(r.ID), 10)
+}
+
+// JSON specifies which fields of a job record will be marshaled to JSON.
+type JSON struct {
+	ID         uint        `json:"id"`
+	QueueName  string      `json:"queue_name"`
+	Data       interface{} `json:"data"`
+	State      string      `json:"state"`
+	Attempts   int         `json:"attempts"`
+	LastError  *string     `json:"last_error"`
+	StartedAt  *time.Time  `json:"started_at"`
+	FinishedAt *time.Time  `json:"finished_at"`
+	CreatedAt  time.Time   `json:"created_at"`
+	UpdatedAt  time.Time   `json:"updated_at"`
+}
+
+// AsJSON returns a struct that can be converted to JSON. Job data is
+// included as is if it is JSON, otherwise it is included as a string.
+func (r *JobRecord) AsJSON() interface{} {
+	var data interface{} = string(r.Data)
+	if json.Valid(r.Data) {
+		data = json.RawMessage(r.Data)
+	}
+
+	return JSON{
+		ID:         r.ID,
+		QueueName:  r.QueueName,
+		Data:       data,
+		State:      r.State,
+		Attempts:   r.Attempts,
+		LastError:  r.LastError,
+		StartedAt:  r.StartedAt,
+		FinishedAt: r.FinishedAt,
+		CreatedAt:  r.CreatedAt,
+		UpdatedAt:  r.UpdatedAt,
+	}
+}
+
+// ValidState returns whether state is a valid job state.
+func ValidState(state string) bool {
+	for _, s := range States {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+// FindByID returns the job record with the given ID, or nil if it does not
+// exist.
+func FindByID(db *gorm.DB, id string) (*JobRecord, error) {
+	n, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidID
+	}
+
+	r := &JobRecord{}
+	if err := db.Where("id = ?", n).First(r).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return r, nil
+}
+
+// List returns up to limit job records, most recently enqueued first,
+// optionally filtered by queue name and state.
+func List(db *gorm.DB, queueName, state string, limit int) ([]*JobRecord, error) {
+	q := db.Order("id DESC").Limit(limit)
+	if queueName != "" {
+		q = q.Where("queue_name = ?", queueName)
+	}
+	if state != "" {
+		q = q.Where("state = ?", state)
+	}
+
+	var records []*JobRecord
+	if err := q.Find(&records).Error; err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// Recorder is a job.Recorder that records jobs in the jobs table.
+type Recorder struct {
+	DB *gorm.DB
+}
+
+var _ job.Recorder = (*Recorder)(nil)
+
+// RecordEnqueued inserts a record for j in the queued state.
+func (rec *Recorder) RecordEnqueued(j *job.Job) (string, error) {
+	r := &JobRecord{
+		QueueName: j.QueueName,
+		Data:      j.Data,
+		State:     StateQueued,
+	}
+	if err := rec.DB.Create(r).Error; err != nil {
+		return "", err
+	}
+	return r.IDString(), nil
+}
+
+// RecordStarted marks the job as running and counts the attempt.
+func (rec *Recorder) RecordStarted(id string) error {
+	n, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return ErrInvalidID
+	}
+
+	return rec.DB.Model(JobRecord{}).Where("id = ?", n).Updates(map[string]interface{}{
+		"state":       StateRunning,
+		"attempts":    gorm.Expr("attempts + 1"),
+		"started_at":  time.Now(),
+		"finished_at": nil,
+	}).Error
+}
+
+// RecordFinished marks the job as succeeded or failed. A failed job that will
+// be retried is marked as queued again.
+func (rec *Recorder) RecordFinished(id string, workErr error, willRetry bool) error {
+	n, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return ErrInvalidID
+	}
+
+	updates := map[string]interface{}{}
+	switch {
+	case workErr == nil:
+		updates["state"] = StateSucceeded
+		updates["finished_at"] = time.Now()
+	case willRetry:
+		updates["state"] = StateQueued
+		updates["last_error"] = workErr.Error()
+	default:
+		updates["state"] = StateFailed
+		updates["last_error"] = workErr.Error()
+		updates["finished_at"] = time.Now()
+	}
+
+	return rec.DB.Model(JobRecord{}).Where("id = ?", n).Updates(updates).Error
+}
@@ -0,0 +1,166 @@
+package jobrecord_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/jobrecord"
+	"github.com/nitrous-io/rise-server/pkg/job"
+	"github.com/nitrous-io/rise-server/testhelper"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "jobrecord")
+}
+
+var _ = Describe("JobRecord", func() {
+	var (
+		db  *gorm.DB
+		rec *jobrecord.Recorder
+		err error
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+
+		rec = &jobrecord.Recorder{DB: db}
+	})
+
+	enqueue := func(queueName, data string) string {
+		id, err := rec.RecordEnqueued(job.New(queueName, []byte(data)))
+		Expect(err).To(BeNil())
+		return id
+	}
+
+	reload := func(id string) *jobrecord.JobRecord {
+		r, err := jobrecord.FindByID(db, id)
+		Expect(err).To(BeNil())
+		Expect(r).NotTo(BeNil())
+		return r
+	}
+
+	Describe("Recorder", func() {
+		It("records an enqueued job as queued", func() {
+			r := reload(enqueue("deploy", `{"deployment_id":1}`))
+			Expect(r.QueueName).To(Equal("deploy"))
+			Expect(string(r.Data)).To(Equal(`{"deployment_id":1}`))
+			Expect(r.State).To(Equal(jobrecord.StateQueued))
+			Expect(r.Attempts).To(Equal(0))
+			Expect(r.StartedAt).To(BeNil())
+		})
+
+		It("records a job that succeeded", func() {
+			id := enqueue("deploy", "{}")
+
+			Expect(rec.RecordStarted(id)).To(BeNil())
+			r := reload(id)
+			Expect(r.State).To(Equal(jobrecord.StateRunning))
+			Expect(r.Attempts).To(Equal(1))
+			Expect(r.StartedAt).NotTo(BeNil())
+
+			Expect(rec.RecordFinished(id, nil, false)).To(BeNil())
+			r = reload(id)
+			Expect(r.State).To(Equal(jobrecord.StateSucceeded))
+			Expect(r.FinishedAt).NotTo(BeNil())
+			Expect(r.LastError).To(BeNil())
+		})
+
+		It("records a job that failed and will be retried as queued", func() {
+			id := enqueue("deploy", "{}")
+
+			Expect(rec.RecordStarted(id)).To(BeNil())
+			Expect(rec.RecordFinished(id, errors.New("s3 is down"), true)).To(BeNil())
+
+			r := reload(id)
+			Expect(r.State).To(Equal(jobrecord.StateQueued))
+			Expect(r.LastError).NotTo(BeNil())
+			Expect(*r.LastError).To(Equal("s3 is down"))
+			Expect(r.FinishedAt).To(BeNil())
+
+			Expect(rec.RecordStarted(id)).To(BeNil())
+			Expect(rec.RecordFinished(id, errors.New("record not found"), false)).To(BeNil())
+
+			r = reload(id)
+			Expect(r.State).To(Equal(jobrecord.StateFailed))
+			Expect(r.Attempts).To(Equal(2))
+			Expect(*r.LastError).To(Equal("record not found"))
+			Expect(r.FinishedAt).NotTo(BeNil())
+		})
+
+		It("returns an error for invalid ids", func() {
+			Expect(rec.RecordStarted("foo")).To(Equal(jobrecord.ErrInvalidID))
+			Expect(rec.RecordFinished("foo", nil, false)).To(Equal(jobrecord.ErrInvalidID))
+		})
+	})
+
+	Describe("FindByID()", func() {
+		It("returns nil if the job does not exist", func() {
+			r, err := jobrecord.FindByID(db, "123")
+			Expect(err).To(BeNil())
+			Expect(r).To(BeNil())
+		})
+
+		It("returns an error if the id is invalid", func() {
+			r, err := jobrecord.FindByID(db, "abc")
+			Expect(err).To(Equal(jobrecord.ErrInvalidID))
+			Expect(r).To(BeNil())
+		})
+	})
+
+	Describe("List()", func() {
+		var ids []string
+
+		BeforeEach(func() {
+			ids = []string{
+				enqueue("deploy", "{}"),
+				enqueue("build", "{}"),
+				enqueue("deploy", "{}"),
+			}
+			Expect(rec.RecordStarted(ids[2])).To(BeNil())
+		})
+
+		idsOf := func(records []*jobrecord.JobRecord) []string {
+			var ids []string
+			for _, r := range records {
+				ids = append(ids, r.IDString())
+			}
+			return ids
+		}
+
+		It("returns the most recently enqueued jobs first", func() {
+			records, err := jobrecord.List(db, "", "", 2)
+			Expect(err).To(BeNil())
+			Expect(idsOf(records)).To(Equal([]string{ids[2], ids[1]}))
+		})
+
+		It("filters by queue name and state", func() {
+			records, err := jobrecord.List(db, "deploy", "", 10)
+			Expect(err).To(BeNil())
+			Expect(idsOf(records)).To(Equal([]string{ids[2], ids[0]}))
+
+			records, err = jobrecord.List(db, "deploy", jobrecord.StateQueued, 10)
+			Expect(err).To(BeNil())
+			Expect(idsOf(records)).To(Equal([]string{ids[0]}))
+		})
+	})
+
+	Describe("AsJSON()", func() {
+		It("includes JSON data as is", func() {
+			r := reload(enqueue("deploy", `{"deployment_id":1}`))
+			Expect(r.AsJSON().(jobrecord.JSON).Data).To(BeEquivalentTo(`{"deployment_id":1}`))
+		})
+
+		It("includes other data as a string", func() {
+			r := reload(enqueue("deploy", "not json"))
+			Expect(r.AsJSON().(jobrecord.JSON).Data).To(Equal("not json"))
+		})
+	})
+})
@@ -0,0 +1,150 @@
+package logdestination
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/pkg/aesencrypter"
+)
+
+var (
+	bucketNameRe = regexp.MustCompile(`\A[a-z0-9][a-z0-9.\-]{1,61}[a-z0-9]\z`)
+	regionRe     = regexp.MustCompile(`\A[a-z]{2}(-[a-z]+)+-\d\z`)
+
+	ErrNoSecretAccessKey = errors.New("secret access key is empty")
+)
+
+// LogDestination is a database model representing an S3 bucket owned by a
+// user, to which raw access logs of a project are delivered periodically.
+// If no access key is given, the bucket must have a bucket policy that allows
+// PubStorm to write to it.
+type LogDestination struct {
+	gorm.Model
+
+	ProjectID uint
+
+	BucketName   string
+	BucketRegion string
+	Prefix       string
+
+	AccessKeyID     *string `sql:"column:access_key_id"`
+	SecretAccessKey string  `sql:"-"`
+
+	EncryptedSecretAccessKey *string
+
+	LastDeliveredAt *time.Time
+}
+
+// JSON specifies which fields of a log destination will be marshaled to JSON.
+// The secret access key is never included.
+type JSON struct {
+	BucketName      string     `json:"bucket_name"`
+	BucketRegion    string     `json:"bucket_region"`
+	Prefix          string     `json:"prefix"`
+	AccessKeyID     *string    `json:"access_key_id,omitempty"`
+	LastDeliveredAt *time.Time `json:"last_delivered_at,omitempty"`
+}
+
+// AsJSON returns a struct that can be converted to JSON
+func (d *LogDestination) AsJSON() interface{} {
+	return JSON{
+		BucketName:      d.BucketName,
+		BucketRegion:    d.BucketRegion,
+		Prefix:          d.Prefix,
+		AccessKeyID:     d.AccessKeyID,
+		LastDeliveredAt: d.LastDeliveredAt,
+	}
+}
+
+// Validate validates LogDestination, if there are invalid fields, it returns
+// a map of <field, errors> and returns nil if valid
+func (d *LogDestination) Validate() map[string]string {
+	errors := map[string]string{}
+
+	if d.BucketName == "" {
+		errors["bucket_name"] = "is required"
+	} else if !bucketNameRe.MatchString(d.BucketName) {
+		errors["bucket_name"] = "is invalid"
+	}
+
+	if d.BucketRegion == "" {
+		errors["bucket_region"] = "is required"
+	} else if !regionRe.MatchString(d.BucketRegion) {
+		errors["bucket_region"] = "is invalid"
+	}
+
+	if len(d.Prefix) > 255 {
+		errors["prefix"] = "is too long (max. 255 characters)"
+	}
+
+	if (d.AccessKeyID != nil && *d.AccessKeyID != "") || d.SecretAccessKey != "" {
+		if d.AccessKeyID == nil || *d.AccessKeyID == "" {
+			errors["access_key_id"] = "is required"
+		} else if d.SecretAccessKey == "" {
+			errors["secret_access_key"] = "is required"
+		}
+	}
+
+	if len(errors) == 0 {
+		return nil
+	}
+	return errors
+}
+
+// HasCredentials returns whether an access key was given for the bucket.
+func (d *LogDestination) HasCredentials() bool {
+	return d.AccessKeyID != nil && d.EncryptedSecretAccessKey != nil
+}
+
+// KeyPrefix returns the prefix (without leading or trailing slashes) under
+// which log files are written in the bucket.
+func (d *LogDestination) KeyPrefix() string {
+	return strings.Trim(d.Prefix, "/")
+}
+
+// EncryptSecretAccessKey encrypts `SecretAccessKey` with the given keyring
+func (d *LogDestination) EncryptSecretAccessKey(keyring *aesencrypter.Keyring) error {
+	if d.SecretAccessKey == "" {
+		return ErrNoSecretAccessKey
+	}
+
+	encrypted, err := keyring.EncryptToString([]byte(d.SecretAccessKey))
+	if err != nil {
+		return err
+	}
+
+	d.EncryptedSecretAccessKey = &encrypted
+	return nil
+}
+
+// DecryptedSecretAccessKey returns the secret access key decrypted with the
+// given keyring
+func (d *LogDestination) DecryptedSecretAccessKey(keyring *aesencrypter.Keyring) (string, error) {
+	if d.EncryptedSecretAccessKey == nil {
+		return "", ErrNoSecretAccessKey
+	}
+
+	plainText, err := keyring.DecryptString(*d.EncryptedSecretAccessKey)
+	if err != nil {
+		return "", err
+	}
+
+	return string(plainText), nil
+}
+
+// FindByProjectID returns the log destination of a project, or nil if the
+// project does not have one
+func FindByProjectID(db *gorm.DB, projectID uint) (*LogDestination, error) {
+	d := &LogDestination{}
+	if err := db.Where("project_id = ?", projectID).First(d).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return d, nil
+}
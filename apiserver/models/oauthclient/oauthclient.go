@@ -27,3 +27,18 @@ func Authenticate(db *gorm.DB, clientID, clientSecret string) (c *OauthClient, e
 
 	return c, err
 }
+
+// FindByClientID returns the client with the given client id, or nil if it
+// does not exist. It is used where a client identifies itself without its
+// secret, as when it starts a single sign-on login.
+func FindByClientID(db *gorm.DB, clientID string) (c *OauthClient, err error) {
+	c = &OauthClient{}
+	if err = db.Where("client_id = ?", clientID).First(c).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return c, nil
+}
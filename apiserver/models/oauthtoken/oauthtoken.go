@@ -6,13 +6,93 @@ import (
 	"github.com/jinzhu/gorm"
 )
 
+const (
+	// DefaultDeployTokenTTL is how long a deploy token is valid for if no
+	// other duration is requested.
+	DefaultDeployTokenTTL = 15 * time.Minute
+	// MinDeployTokenTTL and MaxDeployTokenTTL limit how long a deploy token
+	// can be valid for.
+	MinDeployTokenTTL = time.Minute
+	MaxDeployTokenTTL = time.Hour
+)
+
 type OauthToken struct {
 	ID            uint `gorm:"primary_key"`
 	UserID        uint
 	OauthClientID uint
 	Token         string `sql:"default:encode(gen_random_bytes(64), 'hex')"`
+	Name          string
+	Description   string
+	RequestCount  int64
+	LastUsedAt    *time.Time
 	CreatedAt     time.Time
 	DeletedAt     *time.Time
+
+	// ProjectID is set for deploy tokens, which can only be used to deploy
+	// the project until they expire at ExpiresAt. They are meant to be passed
+	// to CI steps instead of a full access token, so that a leaked token can
+	// do little harm.
+	ProjectID *uint
+	ExpiresAt *time.Time
+}
+
+// JSON specifies which fields of a token will be marshaled to JSON. The token
+// itself is never included.
+type JSON struct {
+	ID           uint       `json:"id"`
+	Name         string     `json:"name"`
+	Description  string     `json:"description"`
+	RequestCount int64      `json:"request_count"`
+	LastUsedAt   *time.Time `json:"last_used_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ProjectID    *uint      `json:"project_id,omitempty"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+}
+
+// AsJSON returns a struct that can be converted to JSON
+func (t *OauthToken) AsJSON() *JSON {
+	return &JSON{
+		ID:           t.ID,
+		Name:         t.Name,
+		Description:  t.Description,
+		RequestCount: t.RequestCount,
+		LastUsedAt:   t.LastUsedAt,
+		CreatedAt:    t.CreatedAt,
+		ProjectID:    t.ProjectID,
+		ExpiresAt:    t.ExpiresAt,
+	}
+}
+
+// IsDeployToken returns whether the token can only be used to deploy a
+// project.
+func (t *OauthToken) IsDeployToken() bool {
+	return t.ProjectID != nil
+}
+
+// Expired returns whether the token can no longer be used.
+func (t *OauthToken) Expired() bool {
+	return t.ExpiresAt != nil && !t.ExpiresAt.After(time.Now())
+}
+
+// Validate validates OauthToken, if there are invalid fields, it returns a map
+// of <field, errors> and returns nil if valid
+func (t *OauthToken) Validate() map[string]string {
+	errors := map[string]string{}
+
+	if t.Name == "" {
+		errors["name"] = "is required"
+	} else if len(t.Name) > 255 {
+		errors["name"] = "is too long (max. 255 characters)"
+	}
+
+	if len(t.Description) > 1000 {
+		errors["description"] = "is too long (max. 1000 characters)"
+	}
+
+	if len(errors) == 0 {
+		return nil
+	}
+	return errors
 }
 
 // Finds oauth token by token
@@ -28,3 +108,30 @@ func FindByToken(db *gorm.DB, token string) (t *OauthToken, err error) {
 
 	return t, nil
 }
+
+// FindByUserID returns all tokens of a user that have not expired, most
+// recently created first
+func FindByUserID(db *gorm.DB, userID uint) ([]*OauthToken, error) {
+	var tokens []*OauthToken
+	if err := db.Where("user_id = ? AND (expires_at IS NULL OR expires_at > ?)", userID, time.Now()).Order("created_at DESC, id DESC").Find(&tokens).Error; err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// IncrementUsage adds count to the request count of the token with the given
+// ID, and updates its last used time if lastUsedAt is later.
+func IncrementUsage(db *gorm.DB, id uint, count int64, lastUsedAt time.Time) error {
+	return db.Exec(`UPDATE oauth_tokens
+		SET
+			request_count = request_count + ?,
+			last_used_at = GREATEST(last_used_at, ?)
+		WHERE id = ?;`, count, lastUsedAt, id).Error
+}
+
+// DeleteExpired deletes tokens that have expired and returns the number of
+// tokens deleted.
+func DeleteExpired(db *gorm.DB) (int64, error) {
+	q := db.Where("expires_at <= ?", time.Now()).Delete(OauthToken{})
+	return q.RowsAffected, q.Error
+}
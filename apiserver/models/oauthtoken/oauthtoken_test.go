@@ -2,6 +2,7 @@ package oauthtoken_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/jinzhu/gorm"
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
@@ -9,6 +10,7 @@ import (
 	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
 	"github.com/nitrous-io/rise-server/apiserver/models/user"
 	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -72,4 +74,104 @@ var _ = Describe("OauthToken", func() {
 			})
 		})
 	})
+
+	Describe("expiring tokens", func() {
+		var t1, t2, t3 *oauthtoken.OauthToken
+
+		BeforeEach(func() {
+			u, oc := factories.AuthDuo(db)
+
+			past, future := time.Now().Add(-time.Minute), time.Now().Add(time.Minute)
+			for _, tok := range []**oauthtoken.OauthToken{&t1, &t2, &t3} {
+				*tok = &oauthtoken.OauthToken{UserID: u.ID, OauthClientID: oc.ID}
+			}
+			t2.ExpiresAt = &past
+			t3.ExpiresAt = &future
+			for _, tok := range []*oauthtoken.OauthToken{t1, t2, t3} {
+				Expect(db.Create(tok).Error).To(BeNil())
+			}
+		})
+
+		It("reports whether tokens have expired", func() {
+			Expect(t1.Expired()).To(BeFalse())
+			Expect(t2.Expired()).To(BeTrue())
+			Expect(t3.Expired()).To(BeFalse())
+		})
+
+		It("does not list tokens that have expired", func() {
+			tokens, err := oauthtoken.FindByUserID(db, t1.UserID)
+			Expect(err).To(BeNil())
+			Expect(tokens).To(HaveLen(2))
+			Expect(tokens[0].ID).To(Equal(t3.ID))
+			Expect(tokens[1].ID).To(Equal(t1.ID))
+		})
+
+		It("deletes tokens that have expired", func() {
+			n, err := oauthtoken.DeleteExpired(db)
+			Expect(err).To(BeNil())
+			Expect(n).To(Equal(int64(1)))
+
+			t, err := oauthtoken.FindByToken(db, t2.Token)
+			Expect(err).To(BeNil())
+			Expect(t).To(BeNil())
+		})
+	})
+
+	Describe("UsageBuffer", func() {
+		var (
+			t1, t2 *oauthtoken.OauthToken
+			b      *oauthtoken.UsageBuffer
+		)
+
+		BeforeEach(func() {
+			c := &oauthclient.OauthClient{}
+			Expect(db.Create(c).Error).To(BeNil())
+
+			u := &user.User{
+				Email:    "harry.potter@gmail.com",
+				Password: "123456",
+			}
+			Expect(u.Insert(db)).To(BeNil())
+
+			t1 = &oauthtoken.OauthToken{OauthClientID: c.ID, UserID: u.ID}
+			Expect(db.Create(t1).Error).To(BeNil())
+			t2 = &oauthtoken.OauthToken{OauthClientID: c.ID, UserID: u.ID}
+			Expect(db.Create(t2).Error).To(BeNil())
+
+			b = oauthtoken.NewUsageBuffer()
+		})
+
+		It("does not write usage to the DB until flushed", func() {
+			b.Record(t1.ID, time.Now())
+
+			Expect(db.First(t1, t1.ID).Error).To(BeNil())
+			Expect(t1.RequestCount).To(BeZero())
+			Expect(t1.LastUsedAt).To(BeNil())
+		})
+
+		It("writes accumulated request counts and the latest used time when flushed", func() {
+			earlier := time.Date(2016, 6, 1, 10, 0, 0, 0, time.UTC)
+			later := earlier.Add(time.Hour)
+
+			b.Record(t1.ID, later)
+			b.Record(t1.ID, earlier)
+			b.Record(t2.ID, earlier)
+			Expect(b.Flush(db)).To(BeNil())
+
+			Expect(db.First(t1, t1.ID).Error).To(BeNil())
+			Expect(t1.RequestCount).To(Equal(int64(2)))
+			Expect(t1.LastUsedAt.Unix()).To(Equal(later.Unix()))
+
+			Expect(db.First(t2, t2.ID).Error).To(BeNil())
+			Expect(t2.RequestCount).To(Equal(int64(1)))
+
+			// Flushing again should not count the same requests twice.
+			b.Record(t1.ID, earlier)
+			Expect(b.Flush(db)).To(BeNil())
+
+			Expect(db.First(t1, t1.ID).Error).To(BeNil())
+			Expect(t1.RequestCount).To(Equal(int64(3)))
+			Expect(t1.LastUsedAt.Unix()).To(Equal(later.Unix()))
+		})
+	})
 })
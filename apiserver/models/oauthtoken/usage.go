@@ -0,0 +1,83 @@
+package oauthtoken
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Usage buffers token usage recorded by the API server so that usage stats can
+// be written periodically instead of on every request.
+var Usage = NewUsageBuffer()
+
+type usage struct {
+	count      int64
+	lastUsedAt time.Time
+}
+
+// UsageBuffer accumulates request counts and last used times of tokens in
+// memory until they are flushed to the DB.
+type UsageBuffer struct {
+	mu     sync.Mutex
+	usages map[uint]*usage
+}
+
+func NewUsageBuffer() *UsageBuffer {
+	return &UsageBuffer{usages: map[uint]*usage{}}
+}
+
+// Record records a request made with the token with the given ID at time t.
+func (b *UsageBuffer) Record(id uint, t time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.add(id, &usage{count: 1, lastUsedAt: t})
+}
+
+func (b *UsageBuffer) add(id uint, u *usage) {
+	existing, ok := b.usages[id]
+	if !ok {
+		b.usages[id] = u
+		return
+	}
+
+	existing.count += u.count
+	if u.lastUsedAt.After(existing.lastUsedAt) {
+		existing.lastUsedAt = u.lastUsedAt
+	}
+}
+
+// Flush writes buffered usage to the DB, one UPDATE per token. Usage that
+// fails to be written is kept in the buffer to be retried on the next flush.
+func (b *UsageBuffer) Flush(db *gorm.DB) error {
+	b.mu.Lock()
+	usages := b.usages
+	b.usages = map[uint]*usage{}
+	b.mu.Unlock()
+
+	var lastErr error
+	for id, u := range usages {
+		if err := IncrementUsage(db, id, u.count, u.lastUsedAt); err != nil {
+			lastErr = err
+
+			b.mu.Lock()
+			b.add(id, u)
+			b.mu.Unlock()
+		}
+	}
+
+	return lastErr
+}
+
+// FlushEvery flushes buffered usage to the DB at the given interval. It never
+// returns.
+func (b *UsageBuffer) FlushEvery(db *gorm.DB, interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := b.Flush(db); err != nil {
+			log.Errorf("failed to flush token usage, err: %v", err)
+		}
+	}
+}
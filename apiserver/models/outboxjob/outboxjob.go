@@ -0,0 +1,155 @@
+package outboxjob
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/pkg/job"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// OutboxJob is a database model representing a job that is to be enqueued
+// once the transaction that created it has been committed. Adding jobs to the
+// outbox instead of enqueuing them directly ensures that workers never pick up
+// a job for a write that was rolled back, and that a job is not lost if the
+// job queue is unavailable at the time of the request. Jobs are delivered at
+// least once, so workers must be able to handle duplicates.
+type OutboxJob struct {
+	ID uint `gorm:"primary_key"`
+
+	QueueName string
+	Data      []byte
+
+	Attempts   int
+	LastError  *string
+	EnqueuedAt *time.Time
+
+	// HeldDeploymentID is the ID of a queued deployment that the job is held
+	// for. Held jobs are not delivered until they are released with Release.
+	HeldDeploymentID *uint
+
+	CreatedAt time.Time
+}
+
+// Add adds a job to the outbox. db should be a transaction.
+func Add(db *gorm.DB, j *job.Job) (*OutboxJob, error) {
+	o := &OutboxJob{
+		QueueName: j.QueueName,
+		Data:      j.Data,
+	}
+	if err := db.Create(o).Error; err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// Hold adds a job to the outbox that is not delivered until it is released
+// with Release, e.g. the job of a deployment that waits for an earlier
+// deployment of the same project to finish. db should be a transaction.
+func Hold(db *gorm.DB, j *job.Job, deploymentID uint) (*OutboxJob, error) {
+	o := &OutboxJob{
+		QueueName:        j.QueueName,
+		Data:             j.Data,
+		HeldDeploymentID: &deploymentID,
+	}
+	if err := db.Create(o).Error; err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// Release releases the jobs held for a deployment and returns them, so that
+// they can be delivered once the transaction is committed.
+func Release(db *gorm.DB, deploymentID uint) ([]*OutboxJob, error) {
+	var jobs []*OutboxJob
+	if err := db.Where("held_deployment_id = ? AND enqueued_at IS NULL", deploymentID).Order("id ASC").Find(&jobs).Error; err != nil {
+		return nil, err
+	}
+
+	if err := db.Model(OutboxJob{}).Where("held_deployment_id = ?", deploymentID).
+		Update("held_deployment_id", gorm.Expr("NULL")).Error; err != nil {
+		return nil, err
+	}
+
+	for _, o := range jobs {
+		o.HeldDeploymentID = nil
+	}
+	return jobs, nil
+}
+
+// Deliver enqueues the job and marks it as enqueued. If the job cannot be
+// enqueued, the error is recorded so that it can be retried by DeliverPending.
+func (o *OutboxJob) Deliver(db *gorm.DB) error {
+	if err := job.New(o.QueueName, o.Data).Enqueue(); err != nil {
+		errMsg := err.Error()
+		o.Attempts++
+		o.LastError = &errMsg
+		if err := db.Model(OutboxJob{}).Where("id = ?", o.ID).Updates(map[string]interface{}{
+			"attempts":   gorm.Expr("attempts + 1"),
+			"last_error": errMsg,
+		}).Error; err != nil {
+			log.Errorf("failed to record failed delivery of outbox job %d, err: %v", o.ID, err)
+		}
+		return err
+	}
+
+	now := time.Now()
+	o.EnqueuedAt = &now
+	return db.Model(OutboxJob{}).Where("id = ?", o.ID).Update("enqueued_at", now).Error
+}
+
+// DeliverAll delivers the given jobs, typically right after the transaction
+// that added them has been committed. Failures are only logged, since
+// DeliverPending retries jobs that have not been enqueued.
+func DeliverAll(db *gorm.DB, jobs ...*OutboxJob) {
+	for _, o := range jobs {
+		if err := o.Deliver(db); err != nil {
+			log.Errorf("failed to deliver outbox job %d to %q, will retry later, err: %v", o.ID, o.QueueName, err)
+		}
+	}
+}
+
+// DeliverPending delivers up to limit jobs that were created more than minAge
+// ago but have not been enqueued yet, and returns the number of jobs
+// delivered. Jobs are locked while they are delivered so that multiple API
+// servers can deliver pending jobs concurrently.
+func DeliverPending(db *gorm.DB, minAge time.Duration, limit int) (int, error) {
+	tx := db.Begin()
+	if err := tx.Error; err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var jobs []*OutboxJob
+	if err := tx.Raw(`SELECT * FROM outbox_jobs
+		WHERE enqueued_at IS NULL AND held_deployment_id IS NULL AND created_at < ?
+		ORDER BY id ASC LIMIT ?
+		FOR UPDATE SKIP LOCKED`, time.Now().Add(-minAge), limit).Scan(&jobs).Error; err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	for _, o := range jobs {
+		if err := o.Deliver(tx); err != nil {
+			log.Errorf("failed to deliver outbox job %d to %q, err: %v", o.ID, o.QueueName, err)
+			continue
+		}
+		delivered++
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return 0, err
+	}
+	return delivered, nil
+}
+
+// DeliverPendingEvery delivers pending jobs at the given interval. It never
+// returns.
+func DeliverPendingEvery(db *gorm.DB, interval time.Duration) {
+	for range time.Tick(interval) {
+		if _, err := DeliverPending(db, interval, 100); err != nil {
+			log.Errorf("failed to deliver pending outbox jobs, err: %v", err)
+		}
+	}
+}
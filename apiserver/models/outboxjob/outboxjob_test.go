@@ -0,0 +1,152 @@
+package outboxjob_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/outboxjob"
+	"github.com/nitrous-io/rise-server/pkg/job"
+	"github.com/nitrous-io/rise-server/pkg/mqconn"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "outboxjob")
+}
+
+var _ = Describe("OutboxJob", func() {
+	var (
+		db  *gorm.DB
+		mq  mqconn.Conn
+		err error
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+
+		mq, err = mqconn.MQ()
+		Expect(err).To(BeNil())
+		testhelper.DeleteQueue(mq, "fooq")
+	})
+
+	Describe("Add()", func() {
+		It("adds a job that has not been enqueued", func() {
+			o, err := outboxjob.Add(db, job.New("fooq", []byte("bar")))
+			Expect(err).To(BeNil())
+
+			reloaded := &outboxjob.OutboxJob{}
+			Expect(db.First(reloaded, o.ID).Error).To(BeNil())
+			Expect(reloaded.QueueName).To(Equal("fooq"))
+			Expect(string(reloaded.Data)).To(Equal("bar"))
+			Expect(reloaded.EnqueuedAt).To(BeNil())
+		})
+
+		It("does not keep the job if the transaction is rolled back", func() {
+			tx := db.Begin()
+			_, err := outboxjob.Add(tx, job.New("fooq", []byte("bar")))
+			Expect(err).To(BeNil())
+			Expect(tx.Rollback().Error).To(BeNil())
+
+			var count int
+			Expect(db.Model(outboxjob.OutboxJob{}).Count(&count).Error).To(BeNil())
+			Expect(count).To(Equal(0))
+		})
+	})
+
+	Describe("Hold() and Release()", func() {
+		var depl *deployment.Deployment
+
+		BeforeEach(func() {
+			depl = factories.Deployment(db, nil, nil, deployment.StateQueued)
+		})
+
+		It("holds the job until it is released", func() {
+			o, err := outboxjob.Hold(db, job.New("fooq", []byte("bar")), depl.ID)
+			Expect(err).To(BeNil())
+			Expect(db.Model(o).Update("created_at", time.Now().Add(-5*time.Minute)).Error).To(BeNil())
+
+			n, err := outboxjob.DeliverPending(db, time.Minute, 10)
+			Expect(err).To(BeNil())
+			Expect(n).To(Equal(0))
+
+			released, err := outboxjob.Release(db, depl.ID)
+			Expect(err).To(BeNil())
+			Expect(released).To(HaveLen(1))
+			Expect(released[0].ID).To(Equal(o.ID))
+			Expect(released[0].HeldDeploymentID).To(BeNil())
+
+			reloaded := &outboxjob.OutboxJob{}
+			Expect(db.First(reloaded, o.ID).Error).To(BeNil())
+			Expect(reloaded.HeldDeploymentID).To(BeNil())
+
+			n, err = outboxjob.DeliverPending(db, time.Minute, 10)
+			Expect(err).To(BeNil())
+			Expect(n).To(Equal(1))
+		})
+	})
+
+	Describe("Deliver()", func() {
+		It("enqueues the job and marks it as enqueued", func() {
+			o, err := outboxjob.Add(db, job.New("fooq", []byte("bar")))
+			Expect(err).To(BeNil())
+
+			Expect(o.Deliver(db)).To(BeNil())
+
+			d := testhelper.ConsumeQueue(mq, "fooq")
+			Expect(d).NotTo(BeNil())
+			Expect(string(d.Body)).To(Equal("bar"))
+
+			reloaded := &outboxjob.OutboxJob{}
+			Expect(db.First(reloaded, o.ID).Error).To(BeNil())
+			Expect(reloaded.EnqueuedAt).NotTo(BeNil())
+		})
+	})
+
+	Describe("DeliverPending()", func() {
+		var o1, o2, o3 *outboxjob.OutboxJob
+
+		BeforeEach(func() {
+			o1, err = outboxjob.Add(db, job.New("fooq", []byte("one")))
+			Expect(err).To(BeNil())
+			o2, err = outboxjob.Add(db, job.New("fooq", []byte("two")))
+			Expect(err).To(BeNil())
+			o3, err = outboxjob.Add(db, job.New("fooq", []byte("three")))
+			Expect(err).To(BeNil())
+
+			Expect(db.Model(outboxjob.OutboxJob{}).Where("id IN (?)", []uint{o1.ID, o2.ID}).
+				Update("created_at", time.Now().Add(-5*time.Minute)).Error).To(BeNil())
+			Expect(db.Model(outboxjob.OutboxJob{}).Where("id = ?", o2.ID).
+				Update("enqueued_at", time.Now()).Error).To(BeNil())
+		})
+
+		It("delivers jobs older than the given age that have not been enqueued", func() {
+			n, err := outboxjob.DeliverPending(db, time.Minute, 10)
+			Expect(err).To(BeNil())
+			Expect(n).To(Equal(1))
+
+			d := testhelper.ConsumeQueue(mq, "fooq")
+			Expect(d).NotTo(BeNil())
+			Expect(string(d.Body)).To(Equal("one"))
+
+			Expect(testhelper.ConsumeQueue(mq, "fooq")).To(BeNil())
+
+			reloaded := &outboxjob.OutboxJob{}
+			Expect(db.First(reloaded, o1.ID).Error).To(BeNil())
+			Expect(reloaded.EnqueuedAt).NotTo(BeNil())
+
+			reloaded = &outboxjob.OutboxJob{}
+			Expect(db.First(reloaded, o3.ID).Error).To(BeNil())
+			Expect(reloaded.EnqueuedAt).To(BeNil())
+		})
+	})
+})
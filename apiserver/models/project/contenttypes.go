@@ -0,0 +1,93 @@
+package project
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// MaxContentTypeOverrides is the maximum number of content type overrides a
+// project can have.
+const MaxContentTypeOverrides = 100
+
+var contentTypeExtRe = regexp.MustCompile(`\A\.[a-z0-9][a-z0-9_+\-]{0,31}\z`)
+
+// ContentTypeOverridesMap returns the content types that files are uploaded
+// with instead of the type registered for their extension, by path (e.g.
+// "/Build/game.unityweb") or lowercase extension (e.g. ".wasm").
+func (p *Project) ContentTypeOverridesMap() (map[string]string, error) {
+	overrides := map[string]string{}
+	if len(p.ContentTypeOverrides) == 0 {
+		return overrides, nil
+	}
+
+	if err := json.Unmarshal(p.ContentTypeOverrides, &overrides); err != nil {
+		return nil, err
+	}
+	return overrides, nil
+}
+
+// SetContentTypeOverrides replaces the project's content type overrides.
+// Extensions are lowercased and given a leading "." if they do not have one,
+// use Validate() to check that the overrides are valid.
+func (p *Project) SetContentTypeOverrides(overrides map[string]string) error {
+	normalized := make(map[string]string, len(overrides))
+	for key, contentType := range overrides {
+		key = strings.TrimSpace(key)
+		if strings.HasPrefix(key, "/") {
+			key = path.Clean(key)
+		} else {
+			key = "." + strings.TrimPrefix(strings.ToLower(key), ".")
+		}
+		normalized[key] = strings.TrimSpace(contentType)
+	}
+
+	b, err := json.Marshal(normalized)
+	if err != nil {
+		return err
+	}
+
+	p.ContentTypeOverrides = b
+	return nil
+}
+
+// validateContentTypeOverrides returns a description of what is wrong with
+// the project's content type overrides, or an empty string if they are valid.
+func (p *Project) validateContentTypeOverrides() string {
+	overrides, err := p.ContentTypeOverridesMap()
+	if err != nil {
+		return "is invalid"
+	}
+
+	if len(overrides) > MaxContentTypeOverrides {
+		return fmt.Sprintf("has too many entries (max. %d)", MaxContentTypeOverrides)
+	}
+
+	keys := make([]string, 0, len(overrides))
+	for key := range overrides {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if strings.HasPrefix(key, "/") {
+			if key == "/" || len(key) > 1024 || strings.Contains(key, "/..") || strings.ContainsAny(key, "\r\n") {
+				return fmt.Sprintf("%q is not a valid path", key)
+			}
+		} else if !contentTypeExtRe.MatchString(key) {
+			return fmt.Sprintf("%q is not a valid extension", key)
+		}
+
+		contentType := overrides[key]
+		mediaType, params, err := mime.ParseMediaType(contentType)
+		if err != nil || len(params) > 0 || !strings.Contains(mediaType, "/") || len(contentType) > 255 {
+			return fmt.Sprintf("%q is not a valid content type", contentType)
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,56 @@
+package project
+
+import (
+	"encoding/json"
+	"path"
+	"strings"
+
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+)
+
+// DeploymentDefaultsData returns the settings that new deployments of the
+// project are deployed with.
+func (p *Project) DeploymentDefaultsData() (*deployment.Settings, error) {
+	return deployment.ParseSettings(p.DeploymentDefaults)
+}
+
+// SetDeploymentDefaults replaces the project's deployment defaults.
+// Verification URLs are cleaned, use Validate() to check that the defaults are
+// valid.
+func (p *Project) SetDeploymentDefaults(s *deployment.Settings) error {
+	urls := make([]string, 0, len(s.VerificationURLs))
+	for _, u := range s.VerificationURLs {
+		u = strings.TrimSpace(u)
+		if strings.HasPrefix(u, "/") && !strings.Contains(u, "/..") {
+			// Keep the trailing slash of directories, which path.Clean removes.
+			cleaned := path.Clean(u)
+			if strings.HasSuffix(u, "/") && cleaned != "/" {
+				cleaned += "/"
+			}
+			u = cleaned
+		}
+		urls = append(urls, u)
+	}
+
+	b, err := json.Marshal(&deployment.Settings{
+		Precompress:      s.Precompress,
+		CachePolicy:      s.CachePolicy,
+		VerificationURLs: urls,
+	})
+	if err != nil {
+		return err
+	}
+
+	p.DeploymentDefaults = b
+	return nil
+}
+
+// validateDeploymentDefaults returns a description of what is wrong with the
+// project's deployment defaults, or an empty string if they are valid.
+func (p *Project) validateDeploymentDefaults() string {
+	s, err := p.DeploymentDefaultsData()
+	if err != nil {
+		return "is invalid"
+	}
+	return s.Validate()
+}
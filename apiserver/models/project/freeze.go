@@ -0,0 +1,85 @@
+package project
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// MaxFreezeReasonLength is the maximum length of the reason of a freeze.
+const MaxFreezeReasonLength = 255
+
+// FreezeInfo specifies which fields of a project's freeze will be marshaled
+// to JSON. Frozen is only true while the freeze is in effect, but a freeze
+// that starts in the future is also shown.
+type FreezeInfo struct {
+	Frozen   bool       `json:"frozen"`
+	StartsAt *time.Time `json:"starts_at,omitempty"`
+	EndsAt   *time.Time `json:"ends_at,omitempty"`
+	Reason   *string    `json:"reason,omitempty"`
+}
+
+// IsFrozen returns whether new deployments of the project are rejected at
+// time t.
+func (p *Project) IsFrozen(t time.Time) bool {
+	if p.FreezeStartsAt == nil || t.Before(*p.FreezeStartsAt) {
+		return false
+	}
+	return p.FreezeEndsAt == nil || t.Before(*p.FreezeEndsAt)
+}
+
+// FreezeInfo returns the freeze of the project, unless it has ended.
+func (p *Project) FreezeInfo() *FreezeInfo {
+	if p.FreezeStartsAt == nil || (p.FreezeEndsAt != nil && !time.Now().Before(*p.FreezeEndsAt)) {
+		return &FreezeInfo{Frozen: false}
+	}
+	return &FreezeInfo{
+		Frozen:   p.IsFrozen(time.Now()),
+		StartsAt: p.FreezeStartsAt,
+		EndsAt:   p.FreezeEndsAt,
+		Reason:   p.FreezeReason,
+	}
+}
+
+// Freeze rejects new deployments of the project from startsAt until endsAt,
+// or until the freeze is lifted if endsAt is nil. It replaces any existing
+// freeze.
+func (p *Project) Freeze(db *gorm.DB, startsAt time.Time, endsAt *time.Time, reason *string) error {
+	startsAt = startsAt.UTC()
+	if endsAt != nil {
+		t := endsAt.UTC()
+		endsAt = &t
+	}
+
+	if err := db.Exec(`
+		UPDATE projects
+		SET freeze_starts_at = ?, freeze_ends_at = ?, freeze_reason = ?
+		WHERE id = ?;
+	`, startsAt, endsAt, reason, p.ID).Error; err != nil {
+		return err
+	}
+
+	p.FreezeStartsAt = &startsAt
+	p.FreezeEndsAt = endsAt
+	p.FreezeReason = reason
+	return nil
+}
+
+// Unfreeze lifts the freeze of the project, and returns whether the project
+// had a freeze that had not ended.
+func (p *Project) Unfreeze(db *gorm.DB) (bool, error) {
+	hadFreeze := p.FreezeInfo().StartsAt != nil
+
+	if err := db.Exec(`
+		UPDATE projects
+		SET freeze_starts_at = NULL, freeze_ends_at = NULL, freeze_reason = NULL
+		WHERE id = ?;
+	`, p.ID).Error; err != nil {
+		return false, err
+	}
+
+	p.FreezeStartsAt = nil
+	p.FreezeEndsAt = nil
+	p.FreezeReason = nil
+	return hadFreeze, nil
+}
@@ -0,0 +1,116 @@
+package project
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// MaxLanguageRedirects is the maximum number of language redirects a project
+// can have.
+const MaxLanguageRedirects = 50
+
+var languageTagRe = regexp.MustCompile(`\A[a-z]{2,3}(-[a-z0-9]{1,8})*\z`)
+
+// LanguageRedirect redirects visitors that request Path to To if the language
+// they prefer the most, according to their Accept-Language header, is one of
+// Languages. A language such as "de" also matches regional variants such as
+// "de-at".
+type LanguageRedirect struct {
+	Path      string   `json:"path"`
+	Languages []string `json:"languages"`
+	To        string   `json:"to"`
+}
+
+// LanguageRedirectsList returns the project's language redirects in the
+// order they are evaluated by the edges; the first matching redirect wins.
+func (p *Project) LanguageRedirectsList() ([]LanguageRedirect, error) {
+	redirects := []LanguageRedirect{}
+	if len(p.LanguageRedirects) == 0 {
+		return redirects, nil
+	}
+
+	if err := json.Unmarshal(p.LanguageRedirects, &redirects); err != nil {
+		return nil, err
+	}
+	return redirects, nil
+}
+
+// SetLanguageRedirects replaces the project's language redirects. Languages
+// are lowercased, use Validate() to check that the redirects are valid.
+func (p *Project) SetLanguageRedirects(redirects []LanguageRedirect) error {
+	normalized := make([]LanguageRedirect, 0, len(redirects))
+	for _, r := range redirects {
+		languages := make([]string, 0, len(r.Languages))
+		for _, lang := range r.Languages {
+			languages = append(languages, strings.ToLower(strings.TrimSpace(lang)))
+		}
+
+		normalized = append(normalized, LanguageRedirect{
+			Path:      strings.TrimSpace(r.Path),
+			Languages: languages,
+			To:        strings.TrimSpace(r.To),
+		})
+	}
+
+	b, err := json.Marshal(normalized)
+	if err != nil {
+		return err
+	}
+
+	p.LanguageRedirects = b
+	return nil
+}
+
+// validateLanguageRedirects returns a description of what is wrong with the
+// project's language redirects, or an empty string if they are valid.
+func (p *Project) validateLanguageRedirects() string {
+	redirects, err := p.LanguageRedirectsList()
+	if err != nil {
+		return "is invalid"
+	}
+
+	if len(redirects) > MaxLanguageRedirects {
+		return fmt.Sprintf("has too many entries (max. %d)", MaxLanguageRedirects)
+	}
+
+	for _, r := range redirects {
+		if !strings.HasPrefix(r.Path, "/") || len(r.Path) > 1024 || strings.Contains(r.Path, "/..") || strings.ContainsAny(r.Path, "\r\n") {
+			return fmt.Sprintf("%q is not a valid path", r.Path)
+		}
+
+		if len(r.Languages) == 0 {
+			return fmt.Sprintf("%q must have at least one language", r.Path)
+		}
+		for _, lang := range r.Languages {
+			if !languageTagRe.MatchString(lang) {
+				return fmt.Sprintf("%q is not a valid language", lang)
+			}
+		}
+
+		if !isRedirectTarget(r.To) {
+			return fmt.Sprintf("%q is not a valid path or URL", r.To)
+		}
+		if r.To == r.Path {
+			return fmt.Sprintf("%q must not redirect to itself", r.Path)
+		}
+	}
+
+	return ""
+}
+
+// isRedirectTarget returns whether s is a path or an absolute HTTP(S) URL.
+func isRedirectTarget(s string) bool {
+	if len(s) > 1024 || strings.ContainsAny(s, "\r\n") {
+		return false
+	}
+
+	if strings.HasPrefix(s, "/") {
+		return !strings.HasPrefix(s, "//")
+	}
+
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
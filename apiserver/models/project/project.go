@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"os"
 	"regexp"
 	"sort"
 	"time"
@@ -20,16 +21,55 @@ import (
 	"github.com/jinzhu/gorm"
 )
 
+// How new deployments are handled while another deployment of the project is
+// being built or deployed.
+const (
+	// DeployConcurrencyQueue queues new deployments until the deployment in
+	// flight has finished.
+	DeployConcurrencyQueue = "queue"
+	// DeployConcurrencyReject rejects new deployments.
+	DeployConcurrencyReject = "reject"
+)
+
+// How edges serve the domains of a project once they have reached their hard
+// bandwidth quota for the day.
+const (
+	// BandwidthExceededBlock stops serving the domain until the quota resets.
+	BandwidthExceededBlock = "block"
+	// BandwidthExceededDegrade serves a quota exceeded page instead of HTML
+	// pages, but keeps serving other files from the edges' caches, so that
+	// sites embedding them do not break.
+	BandwidthExceededDegrade = "degrade"
+)
+
 var (
 	MaxProjectPerUser = 10
 
-	projectNameRe = regexp.MustCompile(`\A[a-z0-9][a-z0-9\-]{1,61}[a-z0-9]\z`)
+	// MaxDeploysKeptLimit is the maximum number of past deployments that
+	// owners can choose to keep restorable.
+	MaxDeploysKeptLimit uint = 50
+
+	// DefaultLockTTL is how long a lock acquired with Lock is held before it
+	// is considered stale and can be taken over by someone else. Locks
+	// acquired before lock expiry was introduced are considered stale once
+	// they are older than this.
+	DefaultLockTTL = 10 * time.Minute
+
+	projectNameRe   = regexp.MustCompile(`\A[a-z0-9][a-z0-9\-]{1,61}[a-z0-9]\z`)
+	indexDocumentRe = regexp.MustCompile(`\A[A-Za-z0-9_~\-][A-Za-z0-9._~\-]*\z`)
+
+	// Basic auth realms are sent in a quoted string in the WWW-Authenticate
+	// header, so they may only contain printable ASCII characters other than
+	// '"' and '\'.
+	basicAuthRealmRe = regexp.MustCompile(`\A[\x20\x21\x23-\x5b\x5d-\x7e]+\z`)
+	basicAuthPageRe  = regexp.MustCompile(`\A(/[A-Za-z0-9_~\-][A-Za-z0-9._~\-]*)+\.html?\z`)
 
 	ErrCollaboratorIsOwner       = errors.New("owner of project cannot be added as a collaborator")
 	ErrCollaboratorAlreadyExists = errors.New("collaborator already exists")
 	ErrNotCollaborator           = errors.New("user is not a collaborator of this project")
 
 	ErrBasicAuthCredentialRequired = errors.New("basic_auth_username or basic_auth_password is empty")
+	ErrStaleProject                = errors.New("project has been modified since it was loaded")
 )
 
 type Project struct {
@@ -39,10 +79,70 @@ type Project struct {
 	UserID               uint
 	DefaultDomainEnabled bool `sql:"default:true"`
 	ForceHTTPS           bool `sql:"column:force_https"`
+	NoindexDefaultDomain bool
 	SkipBuild            bool `sql:"default:true"`
 	Watermark            bool `sql:"default:true"`
-	MaxDeploysKept       uint
-	LastDigestSentAt     *time.Time
+	// AssetFingerprinting is whether assets are renamed to names that contain
+	// a hash of their content when the project is built, so that they can be
+	// cached for a year.
+	AssetFingerprinting bool
+	// DeployConcurrency is either DeployConcurrencyQueue or
+	// DeployConcurrencyReject.
+	DeployConcurrency string `sql:"default:'queue'"`
+	// MaxDeploysKept is the number of deployments that are kept restorable.
+	// Older deployments are deleted and their files purged. All deployments
+	// are kept if it is 0.
+	MaxDeploysKept uint
+
+	// MaxBundleFiles and MaxFileSize override shared.MaxFilesPerBundle and
+	// shared.MaxFileSize for projects on plans with different limits.
+	MaxBundleFiles *int
+	MaxFileSize    *int64
+
+	// MaxDomainRequests and MaxDomainBandwidth override
+	// shared.MaxDomainRequestsPerDay and shared.MaxDomainBandwidthPerDay for
+	// projects on plans with different limits.
+	MaxDomainRequests  *int64
+	MaxDomainBandwidth *int64
+
+	// BandwidthExceededMode is either BandwidthExceededBlock or
+	// BandwidthExceededDegrade.
+	BandwidthExceededMode string `sql:"default:'block'"`
+	// BandwidthExceededPage is the path of a page in the deployment that is
+	// served instead of HTML pages while a domain is degraded, instead of the
+	// edge's default page.
+	BandwidthExceededPage *string
+
+	// IndexDocument is the name of the file that is served for requests to
+	// a directory.
+	IndexDocument string `sql:"default:'index.html'"`
+	// DirectoryListings is whether a listing of files is served for
+	// directories that do not have an index document.
+	DirectoryListings bool
+	LastDigestSentAt  *time.Time
+
+	// SecurityHeadersEnabled is whether DefaultSecurityHeaders, merged with
+	// SecurityHeaderOverrides, are served for the project.
+	SecurityHeadersEnabled bool `sql:"default:true"`
+	// SecurityHeaderOverrides stores a JSON object of security headers that
+	// override DefaultSecurityHeaders. Use SecurityHeaderOverridesMap() to
+	// read them.
+	SecurityHeaderOverrides []byte `sql:"default:'{}'"`
+
+	// ContentTypeOverrides stores a JSON object of the content types that
+	// files are uploaded with instead of the type registered for their
+	// extension. Use ContentTypeOverridesMap() to read them.
+	ContentTypeOverrides []byte `sql:"default:'{}'"`
+
+	// LanguageRedirects stores a JSON array of redirects based on the
+	// Accept-Language header of visitors. Use LanguageRedirectsList() to read
+	// them.
+	LanguageRedirects []byte `sql:"default:'[]'"`
+
+	// DeploymentDefaults stores the JSON-encoded deployment.Settings that are
+	// copied to each new deployment. Use DeploymentDefaultsData() to read
+	// them.
+	DeploymentDefaults []byte `sql:"default:'{}'"`
 
 	ActiveDeploymentID *uint // pointer to be nullable. remember to dereference by using *ActiveDeploymentID to get actual value
 	BasicAuthUsername  *string
@@ -50,16 +150,47 @@ type Project struct {
 
 	EncryptedBasicAuthPassword *string
 
-	LockedAt *time.Time
+	// BasicAuthRealm is the realm that browsers show in their login prompt.
+	// The edge uses its default realm if it is nil.
+	BasicAuthRealm *string
+	// BasicAuthPage is the path of a page in the deployment that is served
+	// with 401 responses instead of the edge's default page.
+	BasicAuthPage *string
+
+	LockedAt      *time.Time
+	LockedBy      *string
+	LockExpiresAt *time.Time
+
+	// FreezeStartsAt and FreezeEndsAt are the window during which new
+	// deployments of the project are rejected, unless the owner overrides
+	// the freeze. A freeze without an end lasts until it is lifted. Use
+	// IsFrozen() to check whether deployments are frozen.
+	FreezeStartsAt *time.Time
+	FreezeEndsAt   *time.Time
+	FreezeReason   *string
+
+	// LockVersion is incremented every time the project is saved with
+	// SaveWithLock, to detect concurrent modifications.
+	LockVersion int64
 }
 
 type JSON struct {
-	Name                 string     `json:"name"`
-	DefaultDomainEnabled bool       `json:"default_domain_enabled"`
-	ForceHTTPS           bool       `json:"force_https"`
-	SkipBuild            bool       `json:"skip_build"`
-	CreatedAt            time.Time  `json:"created_at"`
-	DeployedAt           *time.Time `json:"deployed_at,omitempty"`
+	Name                   string     `json:"name"`
+	DefaultDomainEnabled   bool       `json:"default_domain_enabled"`
+	ForceHTTPS             bool       `json:"force_https"`
+	NoindexDefaultDomain   bool       `json:"noindex_default_domain"`
+	SkipBuild              bool       `json:"skip_build"`
+	AssetFingerprinting    bool       `json:"asset_fingerprinting"`
+	SecurityHeadersEnabled bool       `json:"security_headers_enabled"`
+	IndexDocument          string     `json:"index_document"`
+	DirectoryListings      bool       `json:"directory_listings"`
+	DeployConcurrency      string     `json:"deploy_concurrency"`
+	BandwidthExceededMode  string     `json:"bandwidth_exceeded_mode"`
+	BandwidthExceededPage  *string    `json:"bandwidth_exceeded_page,omitempty"`
+	MaxDeploysKept         uint       `json:"max_deploys_kept,omitempty"`
+	LockVersion            int64      `json:"lock_version"`
+	CreatedAt              time.Time  `json:"created_at"`
+	DeployedAt             *time.Time `json:"deployed_at,omitempty"`
 }
 
 // Validates Project, if there are invalid fields, it returns a map of
@@ -85,6 +216,66 @@ func (p *Project) Validate() map[string]string {
 		}
 	}
 
+	if p.IndexDocument != "" {
+		if len(p.IndexDocument) > 255 {
+			errors["index_document"] = "is too long (max. 255 characters)"
+		} else if !indexDocumentRe.MatchString(p.IndexDocument) {
+			errors["index_document"] = "is invalid"
+		}
+	}
+
+	if p.BasicAuthRealm != nil {
+		if len(*p.BasicAuthRealm) > 100 {
+			errors["basic_auth_realm"] = "is too long (max. 100 characters)"
+		} else if !basicAuthRealmRe.MatchString(*p.BasicAuthRealm) {
+			errors["basic_auth_realm"] = "is invalid"
+		}
+	}
+
+	if p.BasicAuthPage != nil {
+		if len(*p.BasicAuthPage) > 255 {
+			errors["basic_auth_page"] = "is too long (max. 255 characters)"
+		} else if !basicAuthPageRe.MatchString(*p.BasicAuthPage) {
+			errors["basic_auth_page"] = "must be the path of an HTML file, e.g. /401.html"
+		}
+	}
+
+	if p.DeployConcurrency != "" && p.DeployConcurrency != DeployConcurrencyQueue && p.DeployConcurrency != DeployConcurrencyReject {
+		errors["deploy_concurrency"] = "must be either queue or reject"
+	}
+
+	if p.BandwidthExceededMode != "" && p.BandwidthExceededMode != BandwidthExceededBlock && p.BandwidthExceededMode != BandwidthExceededDegrade {
+		errors["bandwidth_exceeded_mode"] = "must be either block or degrade"
+	}
+
+	if p.BandwidthExceededPage != nil {
+		if len(*p.BandwidthExceededPage) > 255 {
+			errors["bandwidth_exceeded_page"] = "is too long (max. 255 characters)"
+		} else if !basicAuthPageRe.MatchString(*p.BandwidthExceededPage) {
+			errors["bandwidth_exceeded_page"] = "must be the path of an HTML file, e.g. /quota.html"
+		}
+	}
+
+	if p.MaxDeploysKept > MaxDeploysKeptLimit {
+		errors["max_deploys_kept"] = fmt.Sprintf("must be between 1 and %d", MaxDeploysKeptLimit)
+	}
+
+	if e := p.validateSecurityHeaderOverrides(); e != "" {
+		errors["security_headers"] = e
+	}
+
+	if e := p.validateContentTypeOverrides(); e != "" {
+		errors["content_types"] = e
+	}
+
+	if e := p.validateLanguageRedirects(); e != "" {
+		errors["language_redirects"] = e
+	}
+
+	if e := p.validateDeploymentDefaults(); e != "" {
+		errors["deployment_defaults"] = e
+	}
+
 	if len(errors) == 0 {
 		return nil
 	}
@@ -94,18 +285,29 @@ func (p *Project) Validate() map[string]string {
 // Returns a struct that can be converted to JSON
 func (p *Project) AsJSON() interface{} {
 	return JSON{
-		Name:                 p.Name,
-		DefaultDomainEnabled: p.DefaultDomainEnabled,
-		ForceHTTPS:           p.ForceHTTPS,
-		SkipBuild:            p.SkipBuild,
-		CreatedAt:            p.CreatedAt,
+		Name:                   p.Name,
+		DefaultDomainEnabled:   p.DefaultDomainEnabled,
+		ForceHTTPS:             p.ForceHTTPS,
+		NoindexDefaultDomain:   p.NoindexDefaultDomain,
+		SkipBuild:              p.SkipBuild,
+		AssetFingerprinting:    p.AssetFingerprinting,
+		SecurityHeadersEnabled: p.SecurityHeadersEnabled,
+		IndexDocument:          p.IndexDocument,
+		DirectoryListings:      p.DirectoryListings,
+		DeployConcurrency:      p.DeployConcurrency,
+		BandwidthExceededMode:  p.BandwidthExceededMode,
+		BandwidthExceededPage:  p.BandwidthExceededPage,
+		MaxDeploysKept:         p.MaxDeploysKept,
+		LockVersion:            p.LockVersion,
+		CreatedAt:              p.CreatedAt,
 	}
 }
 
-// Returns list of domain names for this project
+// Returns list of domain names for this project, excluding domains that are
+// pending verification
 func (p *Project) DomainNames(db *gorm.DB) ([]string, error) {
 	doms := []*domain.Domain{}
-	if err := db.Order("name ASC").Where("project_id = ?", p.ID).Find(&doms).Error; err != nil {
+	if err := db.Order("name ASC").Where("project_id = ? AND state = ?", p.ID, domain.StateActive).Find(&doms).Error; err != nil {
 		return nil, err
 	}
 
@@ -123,6 +325,23 @@ func (p *Project) DomainNames(db *gorm.DB) ([]string, error) {
 	return domNames, nil
 }
 
+// PendingDomainNames returns the names of the domains of this project that are
+// pending verification.
+func (p *Project) PendingDomainNames(db *gorm.DB) ([]string, error) {
+	var domNames []string
+	if err := db.Model(domain.Domain{}).Order("name ASC").Where("project_id = ? AND state = ?", p.ID, domain.StatePendingVerification).Pluck("name", &domNames).Error; err != nil {
+		return nil, err
+	}
+
+	return domNames, nil
+}
+
+// NeedsBuild returns whether deployments of the project are built before they
+// are deployed, i.e. whether their assets are minified or fingerprinted.
+func (p *Project) NeedsBuild() bool {
+	return !p.SkipBuild || p.AssetFingerprinting
+}
+
 // Return Default domain
 func (p *Project) DefaultDomainName() string {
 	return p.Name + "." + shared.DefaultDomain
@@ -142,6 +361,32 @@ func FindByName(db *gorm.DB, name string) (proj *Project, err error) {
 	return proj, nil
 }
 
+// FindAllByName returns the projects with the given name, including deleted
+// ones, most recently created first. A name can be taken again once its
+// project is deleted, so there can be more than one.
+func FindAllByName(db *gorm.DB, name string) ([]*Project, error) {
+	var projs []*Project
+	if err := db.Unscoped().Where("name = ?", name).Order("created_at DESC, id DESC").Find(&projs).Error; err != nil {
+		return nil, err
+	}
+
+	return projs, nil
+}
+
+// FindByIDUnscoped returns the project with the given ID even if it has been
+// deleted, or nil if there is no such project.
+func FindByIDUnscoped(db *gorm.DB, id uint) (*Project, error) {
+	proj := &Project{}
+	if err := db.Unscoped().Where("id = ?", id).First(proj).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return proj, nil
+}
+
 // Returns whether more domains can be added to this project
 func (p *Project) CanAddDomain(db *gorm.DB) (bool, error) {
 	var domainCount int
@@ -156,43 +401,218 @@ func (p *Project) CanAddDomain(db *gorm.DB) (bool, error) {
 	return false, nil
 }
 
+// BundleFileLimit returns the maximum number of files that can be deployed
+// from a bundle of the project.
+func (p *Project) BundleFileLimit() int {
+	if p.MaxBundleFiles != nil {
+		return *p.MaxBundleFiles
+	}
+	return shared.MaxFilesPerBundle
+}
+
+// FileSizeLimit returns the maximum size in bytes of a file that can be
+// deployed from a bundle of the project.
+func (p *Project) FileSizeLimit() int64 {
+	if p.MaxFileSize != nil {
+		return *p.MaxFileSize
+	}
+	return shared.MaxFileSize
+}
+
+// DomainRequestLimit returns the maximum number of requests per day that each
+// domain of the project may serve, or 0 if it is unlimited.
+func (p *Project) DomainRequestLimit() int64 {
+	if p.MaxDomainRequests != nil {
+		return *p.MaxDomainRequests
+	}
+	return shared.MaxDomainRequestsPerDay
+}
+
+// DomainBandwidthLimit returns the maximum number of bytes per day that each
+// domain of the project may serve, or 0 if it is unlimited.
+func (p *Project) DomainBandwidthLimit() int64 {
+	if p.MaxDomainBandwidth != nil {
+		return *p.MaxDomainBandwidth
+	}
+	return shared.MaxDomainBandwidthPerDay
+}
+
+// DomainQuotas returns the daily quotas of the domain of the project with the
+// given name, which may be the default domain of the project.
+func (p *Project) DomainQuotas(db *gorm.DB, domainName string) (*domain.Quotas, error) {
+	if domainName == p.DefaultDomainName() {
+		return domain.PlanQuotas(p.DomainRequestLimit(), p.DomainBandwidthLimit()), nil
+	}
+
+	dom := &domain.Domain{}
+	if err := db.Where("project_id = ? AND name = ?", p.ID, domainName).First(dom).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return dom.Quotas(p.DomainRequestLimit(), p.DomainBandwidthLimit()), nil
+}
+
+// LockInfo specifies which fields of a project's lock will be marshaled to
+// JSON.
+type LockInfo struct {
+	Locked    bool       `json:"locked"`
+	LockedBy  *string    `json:"locked_by,omitempty"`
+	LockedAt  *time.Time `json:"locked_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// LockHolder returns a description of the current process doing the given
+// task, to be used as the holder of a lock.
+func LockHolder(task string) string {
+	host, _ := os.Hostname()
+	holder := fmt.Sprintf("%s on %s (pid %d)", task, host, os.Getpid())
+	if len(holder) > 255 {
+		holder = holder[:255]
+	}
+	return holder
+}
+
+// IsLocked returns whether the project is locked by a lock that has not
+// expired yet.
+func (p *Project) IsLocked() bool {
+	if p.LockedAt == nil {
+		return false
+	}
+	if p.LockExpiresAt == nil {
+		return time.Since(*p.LockedAt) < DefaultLockTTL
+	}
+	return time.Now().Before(*p.LockExpiresAt)
+}
+
+// LockInfo returns the current state of the project's lock
+func (p *Project) LockInfo() *LockInfo {
+	if !p.IsLocked() {
+		return &LockInfo{Locked: false}
+	}
+	return &LockInfo{
+		Locked:    true,
+		LockedBy:  p.LockedBy,
+		LockedAt:  p.LockedAt,
+		ExpiresAt: p.LockExpiresAt,
+	}
+}
+
 // Acquire a lock from the project for concurrent update
 func (p *Project) Lock(db *gorm.DB) (bool, error) {
-	q := db.Exec(`
+	return p.LockFor(db, "", DefaultLockTTL)
+}
+
+// LockFor acquires a lock from the project on behalf of holder, which expires
+// after ttl. A lock that has expired is taken over, so that a process that
+// crashed while holding the lock does not leave the project locked forever.
+func (p *Project) LockFor(db *gorm.DB, holder string, ttl time.Duration) (bool, error) {
+	r := struct {
+		LockedAt      *time.Time
+		LockedBy      *string
+		LockExpiresAt *time.Time
+	}{}
+
+	ttlMillis := int64(ttl / time.Millisecond)
+	staleMillis := int64(DefaultLockTTL / time.Millisecond)
+
+	err := db.Raw(`
 		UPDATE projects
-		SET locked_at = now()
+		SET locked_at = now(), locked_by = NULLIF(?::text, ''), lock_expires_at = now() + ?::bigint * interval '1 millisecond'
 		WHERE id IN (
 			SELECT id FROM projects
-			WHERE id = ? AND locked_at IS NULL
+			WHERE id = ? AND (
+				locked_at IS NULL OR
+				lock_expires_at < now() OR
+				(lock_expires_at IS NULL AND locked_at < now() - ?::bigint * interval '1 millisecond')
+			)
 			FOR UPDATE
-		);
-	`, p.ID)
+		)
+		RETURNING locked_at, locked_by, lock_expires_at;
+	`, holder, ttlMillis, p.ID, staleMillis).Scan(&r).Error
 
-	if q.Error != nil {
-		return false, q.Error
+	if err != nil {
+		if err == gorm.RecordNotFound {
+			return false, nil
+		}
+		return false, err
 	}
 
-	if q.RowsAffected == 0 {
-		return false, nil
-	}
+	p.LockedAt = r.LockedAt
+	p.LockedBy = r.LockedBy
+	p.LockExpiresAt = r.LockExpiresAt
 
 	return true, nil
 }
 
-// Release the lock from the project for concurrent update
+// Release the lock from the project for concurrent update. If the lock was
+// acquired with a holder, it is only released if it is still held by the
+// same holder, i.e. it has not expired and been taken over by someone else.
 func (p *Project) Unlock(db *gorm.DB) error {
-	return db.Exec(`
+	holder := ""
+	if p.LockedBy != nil {
+		holder = *p.LockedBy
+	}
+
+	if err := db.Exec(`
 		UPDATE projects
-		SET locked_at = NULL
+		SET locked_at = NULL, locked_by = NULL, lock_expires_at = NULL
 		WHERE id IN (
 			SELECT id FROM projects
-			WHERE id = ? AND locked_at IS NOT NULL
+			WHERE id = ? AND locked_at IS NOT NULL AND (?::text = '' OR locked_by = ?)
 			FOR UPDATE
 		);
-	`, p.ID).Error
+	`, p.ID, holder, holder).Error; err != nil {
+		return err
+	}
+
+	p.LockedAt = nil
+	p.LockedBy = nil
+	p.LockExpiresAt = nil
+	return nil
+}
+
+// ReleaseExpiredLocks releases locks that have expired, which are otherwise
+// only released when the project is locked again, and returns the number of
+// locks released.
+func ReleaseExpiredLocks(db *gorm.DB) (int64, error) {
+	q := db.Exec(`
+		UPDATE projects
+		SET locked_at = NULL, locked_by = NULL, lock_expires_at = NULL
+		WHERE locked_at IS NOT NULL AND (
+			lock_expires_at < now() OR
+			(lock_expires_at IS NULL AND locked_at < now() - ?::bigint * interval '1 millisecond')
+		);
+	`, int64(DefaultLockTTL/time.Millisecond))
+	return q.RowsAffected, q.Error
+}
+
+// ForceUnlock releases the lock from the project regardless of who holds it,
+// and returns whether the project was locked.
+func (p *Project) ForceUnlock(db *gorm.DB) (bool, error) {
+	q := db.Exec(`
+		UPDATE projects
+		SET locked_at = NULL, locked_by = NULL, lock_expires_at = NULL
+		WHERE id = ? AND locked_at IS NOT NULL;
+	`, p.ID)
+	if q.Error != nil {
+		return false, q.Error
+	}
+
+	p.LockedAt = nil
+	p.LockedBy = nil
+	p.LockExpiresAt = nil
+	return q.RowsAffected > 0, nil
 }
 
 func (p *Project) AddCollaborator(db *gorm.DB, u *user.User) error {
+	return p.AddCollaboratorBy(db, u, nil)
+}
+
+// AddCollaboratorBy adds u as a collaborator of the project, recording that
+// it was done by addedBy.
+func (p *Project) AddCollaboratorBy(db *gorm.DB, u, addedBy *user.User) error {
 	if u.ID == p.UserID {
 		return ErrCollaboratorIsOwner
 	}
@@ -201,6 +621,9 @@ func (p *Project) AddCollaborator(db *gorm.DB, u *user.User) error {
 		UserID:    u.ID,
 		ProjectID: p.ID,
 	}
+	if addedBy != nil {
+		collab.AddedByUserID = &addedBy.ID
+	}
 
 	err := db.Create(&collab).Error
 
@@ -225,6 +648,27 @@ func (p *Project) RemoveCollaborator(db *gorm.DB, u *user.User) error {
 	return nil
 }
 
+// SaveWithLock saves the project only if it has not been modified since it
+// was loaded, i.e. if lock_version in the DB still equals p.LockVersion, and
+// increments the lock version. It returns ErrStaleProject otherwise.
+func (p *Project) SaveWithLock(db *gorm.DB) error {
+	loadedVersion := p.LockVersion
+	p.LockVersion++
+
+	q := db.Where("lock_version = ?", loadedVersion).Save(p)
+	if err := q.Error; err != nil {
+		p.LockVersion = loadedVersion
+		return err
+	}
+
+	if q.RowsAffected == 0 {
+		p.LockVersion = loadedVersion
+		return ErrStaleProject
+	}
+
+	return nil
+}
+
 // Atomically increments version_counter and returns next deployment version
 func (p *Project) NextVersion(db *gorm.DB) (int64, error) {
 	r := struct{ V int64 }{}
@@ -288,7 +732,7 @@ func (p *Project) DomainNamesWithProtocol(db *gorm.DB) ([]string, error) {
 		CertID *uint
 	}{}
 
-	if err := db.Table("domains").Select("domains.Name, certs.ID AS cert_id").Joins("LEFT JOIN certs ON domains.id = certs.domain_id AND certs.deleted_at is null").Where("project_id = ? AND domains.deleted_at is null", p.ID).Find(&doms).Error; err != nil {
+	if err := db.Table("domains").Select("domains.Name, certs.ID AS cert_id").Joins("LEFT JOIN certs ON domains.id = certs.domain_id AND certs.deleted_at is null").Where("project_id = ? AND domains.state = ? AND domains.deleted_at is null", p.ID, domain.StateActive).Find(&doms).Error; err != nil {
 		return nil, err
 	}
 
@@ -333,44 +777,65 @@ func (pd *ProjectWithDeployedAt) TableName() string {
 	return "projects"
 }
 
-// AsJSON return table name for database
+// AsJSON returns a struct that can be converted to JSON
 func (pd *ProjectWithDeployedAt) AsJSON() interface{} {
-	return JSON{
-		Name:                 pd.Name,
-		DefaultDomainEnabled: pd.DefaultDomainEnabled,
-		ForceHTTPS:           pd.ForceHTTPS,
-		SkipBuild:            pd.SkipBuild,
-		CreatedAt:            pd.CreatedAt,
-		DeployedAt:           pd.DeployedAt,
-	}
+	j := pd.Project.AsJSON().(JSON)
+	j.DeployedAt = pd.DeployedAt
+	return j
+}
+
+// withDeployedAt selects projects along with the time they were last deployed
+// at. The time is looked up with a correlated subquery, which uses
+// index_deployments_on_project_id_and_deployed_at, so that it does not have to
+// aggregate every deployment of every project.
+func withDeployedAt(db *gorm.DB) *gorm.DB {
+	return db.Select(`projects.*, (
+		SELECT max(deployments.deployed_at) FROM deployments
+		WHERE deployments.project_id = projects.id AND deployments.deleted_at IS NULL
+	) AS deployed_at`).Order("projects.name ASC")
 }
 
+// ProjectsByUserID returns the projects owned by a user, ordered by name.
 func ProjectsByUserID(db *gorm.DB, userID uint) ([]*ProjectWithDeployedAt, error) {
 	projects := []*ProjectWithDeployedAt{}
-	err := db.Select("projects.*, max(deployments.deployed_at) AS deployed_at").
-		Joins("LEFT JOIN deployments ON projects.id = deployments.project_id").
-		Group("projects.id").
-		Order("projects.name ASC").
-		Where("deployments.deleted_at IS NULL").
+	err := withDeployedAt(db).
 		Where("projects.user_id = ?", userID).
 		Find(&projects).Error
 
 	return projects, err
 }
 
+// SharedProjectsByUserID returns the projects a user is a collaborator of,
+// ordered by name.
 func SharedProjectsByUserID(db *gorm.DB, userID uint) ([]*ProjectWithDeployedAt, error) {
 	sharedProjects := []*ProjectWithDeployedAt{}
-	err := db.Select("projects.*, max(deployments.deployed_at) AS deployed_at").
-		Joins(`LEFT JOIN deployments ON projects.id = deployments.project_id
-			JOIN collabs ON collabs.project_id = projects.id
-			JOIN users ON users.id = collabs.user_id`).
-		Where("deployments.deleted_at IS NULL").
-		Where("collabs.deleted_at IS NULL").
-		Where("users.deleted_at IS NULL").
-		Where("collabs.user_id = ?", userID).
-		Order("projects.name ASC").
-		Group("projects.id").
+	err := withDeployedAt(db).
+		Where("projects.id IN (SELECT project_id FROM collabs WHERE user_id = ? AND deleted_at IS NULL)", userID).
 		Find(&sharedProjects).Error
 
 	return sharedProjects, err
 }
+
+// AccessibleProjectsByUserID returns the projects owned by a user and the
+// projects the user is a collaborator of, both ordered by name, in a single
+// query.
+func AccessibleProjectsByUserID(db *gorm.DB, userID uint) (projects, sharedProjects []*ProjectWithDeployedAt, err error) {
+	all := []*ProjectWithDeployedAt{}
+	if err := withDeployedAt(db).
+		Where("projects.user_id = ? OR projects.id IN (SELECT project_id FROM collabs WHERE user_id = ? AND deleted_at IS NULL)", userID, userID).
+		Find(&all).Error; err != nil {
+		return nil, nil, err
+	}
+
+	projects = []*ProjectWithDeployedAt{}
+	sharedProjects = []*ProjectWithDeployedAt{}
+	for _, proj := range all {
+		if proj.UserID == userID {
+			projects = append(projects, proj)
+		} else {
+			sharedProjects = append(sharedProjects, proj)
+		}
+	}
+
+	return projects, sharedProjects, nil
+}
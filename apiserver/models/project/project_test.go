@@ -102,6 +102,245 @@ var _ = Describe("Project", func() {
 			Entry("missing username", "", "def", "is required", ""),
 			Entry("missing password", "abc", "", "", "is required"),
 		)
+
+		DescribeTable("validates basic auth realm",
+			func(realm, realmErr string) {
+				proj.BasicAuthRealm = &realm
+				errors := proj.Validate()
+
+				if realmErr == "" {
+					Expect(errors).To(BeNil())
+				} else {
+					Expect(errors).NotTo(BeNil())
+					Expect(errors["basic_auth_realm"]).To(Equal(realmErr))
+				}
+			},
+
+			Entry("normal", "Acme Corp client preview", ""),
+			Entry("disallows quotes", `Acme "Corp"`, "is invalid"),
+			Entry("disallows backslashes", `Acme\Corp`, "is invalid"),
+			Entry("disallows newlines", "Acme\nCorp", "is invalid"),
+			Entry("disallows long realms", strings.Repeat("a", 101), "is too long (max. 100 characters)"),
+		)
+
+		DescribeTable("validates basic auth page",
+			func(page, pageErr string) {
+				proj.BasicAuthPage = &page
+				errors := proj.Validate()
+
+				if pageErr == "" {
+					Expect(errors).To(BeNil())
+				} else {
+					Expect(errors).NotTo(BeNil())
+					Expect(errors["basic_auth_page"]).To(Equal(pageErr))
+				}
+			},
+
+			Entry("normal", "/401.html", ""),
+			Entry("allows nested paths", "/errors/unauthorized.htm", ""),
+			Entry("requires a leading slash", "401.html", "must be the path of an HTML file, e.g. /401.html"),
+			Entry("requires an HTML file", "/401.js", "must be the path of an HTML file, e.g. /401.html"),
+			Entry("disallows parent directories", "/../401.html", "must be the path of an HTML file, e.g. /401.html"),
+			Entry("disallows URLs", "https://example.com/401.html", "must be the path of an HTML file, e.g. /401.html"),
+			Entry("disallows long paths", "/"+strings.Repeat("a", 251)+".html", "is too long (max. 255 characters)"),
+		)
+
+		DescribeTable("validates index document",
+			func(indexDocument, indexDocumentErr string) {
+				proj.IndexDocument = indexDocument
+				errors := proj.Validate()
+
+				if indexDocumentErr == "" {
+					Expect(errors).To(BeNil())
+				} else {
+					Expect(errors).NotTo(BeNil())
+					Expect(errors["index_document"]).To(Equal(indexDocumentErr))
+				}
+			},
+
+			Entry("normal", "index.html", ""),
+			Entry("allows other file names", "default.htm", ""),
+			Entry("disallows paths", "docs/index.html", "is invalid"),
+			Entry("disallows parent directories", "..", "is invalid"),
+			Entry("disallows spaces", "my index.html", "is invalid"),
+			Entry("disallows long names", strings.Repeat("a", 251)+".html", "is too long (max. 255 characters)"),
+		)
+
+		DescribeTable("validates max deploys kept",
+			func(n uint, nErr string) {
+				proj.MaxDeploysKept = n
+				errors := proj.Validate()
+
+				if nErr == "" {
+					Expect(errors).To(BeNil())
+				} else {
+					Expect(errors).NotTo(BeNil())
+					Expect(errors["max_deploys_kept"]).To(Equal(nErr))
+				}
+			},
+
+			Entry("unlimited", uint(0), ""),
+			Entry("normal", uint(10), ""),
+			Entry("the limit", project.MaxDeploysKeptLimit, ""),
+			Entry("disallows more than the limit", project.MaxDeploysKeptLimit+1, "must be between 1 and 50"),
+		)
+
+		DescribeTable("validates deploy concurrency",
+			func(concurrency, concurrencyErr string) {
+				proj.DeployConcurrency = concurrency
+				errors := proj.Validate()
+
+				if concurrencyErr == "" {
+					Expect(errors).To(BeNil())
+				} else {
+					Expect(errors).NotTo(BeNil())
+					Expect(errors["deploy_concurrency"]).To(Equal(concurrencyErr))
+				}
+			},
+
+			Entry("queue", project.DeployConcurrencyQueue, ""),
+			Entry("reject", project.DeployConcurrencyReject, ""),
+			Entry("disallows other values", "parallel", "must be either queue or reject"),
+		)
+
+		DescribeTable("validates bandwidth exceeded mode",
+			func(mode, modeErr string) {
+				proj.BandwidthExceededMode = mode
+				errors := proj.Validate()
+
+				if modeErr == "" {
+					Expect(errors).To(BeNil())
+				} else {
+					Expect(errors).NotTo(BeNil())
+					Expect(errors["bandwidth_exceeded_mode"]).To(Equal(modeErr))
+				}
+			},
+
+			Entry("block", project.BandwidthExceededBlock, ""),
+			Entry("degrade", project.BandwidthExceededDegrade, ""),
+			Entry("disallows other values", "throttle", "must be either block or degrade"),
+		)
+
+		DescribeTable("validates bandwidth exceeded page",
+			func(page, pageErr string) {
+				proj.BandwidthExceededPage = &page
+				errors := proj.Validate()
+
+				if pageErr == "" {
+					Expect(errors).To(BeNil())
+				} else {
+					Expect(errors).NotTo(BeNil())
+					Expect(errors["bandwidth_exceeded_page"]).To(Equal(pageErr))
+				}
+			},
+
+			Entry("normal", "/quota.html", ""),
+			Entry("allows nested pages", "/errors/quota.htm", ""),
+			Entry("disallows relative paths", "quota.html", "must be the path of an HTML file, e.g. /quota.html"),
+			Entry("disallows other files", "/quota.png", "must be the path of an HTML file, e.g. /quota.html"),
+			Entry("disallows long paths", "/"+strings.Repeat("a", 250)+".html", "is too long (max. 255 characters)"),
+		)
+
+		DescribeTable("validates security header overrides",
+			func(overrides map[string]string, overridesErr string) {
+				Expect(proj.SetSecurityHeaderOverrides(overrides)).To(Succeed())
+				errors := proj.Validate()
+
+				if overridesErr == "" {
+					Expect(errors).To(BeNil())
+				} else {
+					Expect(errors).NotTo(BeNil())
+					Expect(errors["security_headers"]).To(Equal(overridesErr))
+				}
+			},
+
+			Entry("normal", map[string]string{"X-Frame-Options": "DENY"}, ""),
+			Entry("allows lowercase header names", map[string]string{"referrer-policy": "no-referrer"}, ""),
+			Entry("allows empty values", map[string]string{"Content-Security-Policy": ""}, ""),
+			Entry("allows any content security policy", map[string]string{"Content-Security-Policy": "default-src 'self'"}, ""),
+			Entry("disallows unsupported headers", map[string]string{"Set-Cookie": "a=b"}, `"Set-Cookie" is not a supported header`),
+			Entry("disallows unknown values", map[string]string{"X-Frame-Options": "ALLOW"}, `"X-Frame-Options" must be one of: DENY, SAMEORIGIN`),
+			Entry("disallows newlines", map[string]string{"Content-Security-Policy": "default-src 'self'\nSet-Cookie: a=b"}, `"Content-Security-Policy" is invalid`),
+			Entry("disallows long values", map[string]string{"Content-Security-Policy": strings.Repeat("a", 2049)}, `"Content-Security-Policy" is too long (max. 2048 characters)`),
+		)
+
+		DescribeTable("validates content type overrides",
+			func(overrides map[string]string, overridesErr string) {
+				Expect(proj.SetContentTypeOverrides(overrides)).To(Succeed())
+				errors := proj.Validate()
+
+				if overridesErr == "" {
+					Expect(errors).To(BeNil())
+				} else {
+					Expect(errors).NotTo(BeNil())
+					Expect(errors["content_types"]).To(Equal(overridesErr))
+				}
+			},
+
+			Entry("normal", map[string]string{".wasm": "application/wasm"}, ""),
+			Entry("allows extensions without a leading dot", map[string]string{"mjs": "application/javascript"}, ""),
+			Entry("allows paths", map[string]string{"/Build/game.unityweb": "application/octet-stream"}, ""),
+			Entry("disallows invalid extensions", map[string]string{".w/asm": "application/wasm"}, `".w/asm" is not a valid extension`),
+			Entry("disallows the root path", map[string]string{"/": "text/html"}, `"/" is not a valid path`),
+			Entry("disallows content types without a subtype", map[string]string{".wasm": "wasm"}, `"wasm" is not a valid content type`),
+			Entry("disallows content type parameters", map[string]string{".txt": "text/plain; charset=utf-8"}, `"text/plain; charset=utf-8" is not a valid content type`),
+			Entry("disallows newlines", map[string]string{".txt": "text/plain\nSet-Cookie: a=b"}, `"text/plain\nSet-Cookie: a=b" is not a valid content type`),
+		)
+
+		DescribeTable("validates language redirects",
+			func(r project.LanguageRedirect, redirectsErr string) {
+				Expect(proj.SetLanguageRedirects([]project.LanguageRedirect{r})).To(Succeed())
+				errors := proj.Validate()
+
+				if redirectsErr == "" {
+					Expect(errors).To(BeNil())
+				} else {
+					Expect(errors).NotTo(BeNil())
+					Expect(errors["language_redirects"]).To(Equal(redirectsErr))
+				}
+			},
+
+			Entry("normal", project.LanguageRedirect{Path: "/", Languages: []string{"de"}, To: "/de/"}, ""),
+			Entry("allows regional variants", project.LanguageRedirect{Path: "/", Languages: []string{"zh-Hant-TW"}, To: "/zh-tw/"}, ""),
+			Entry("allows URLs", project.LanguageRedirect{Path: "/", Languages: []string{"fr"}, To: "https://fr.example.com/"}, ""),
+			Entry("requires a leading slash", project.LanguageRedirect{Path: "about", Languages: []string{"de"}, To: "/de/about"}, `"about" is not a valid path`),
+			Entry("requires a language", project.LanguageRedirect{Path: "/", To: "/de/"}, `"/" must have at least one language`),
+			Entry("disallows invalid languages", project.LanguageRedirect{Path: "/", Languages: []string{"de_DE"}, To: "/de/"}, `"de_de" is not a valid language`),
+			Entry("disallows protocol-relative URLs", project.LanguageRedirect{Path: "/", Languages: []string{"de"}, To: "//example.de/"}, `"//example.de/" is not a valid path or URL`),
+			Entry("disallows other schemes", project.LanguageRedirect{Path: "/", Languages: []string{"de"}, To: "javascript:alert(1)"}, `"javascript:alert(1)" is not a valid path or URL`),
+			Entry("disallows redirect loops", project.LanguageRedirect{Path: "/de/", Languages: []string{"de"}, To: "/de/"}, `"/de/" must not redirect to itself`),
+		)
+	})
+
+	Describe("SecurityHeaders()", func() {
+		It("returns the default security headers", func() {
+			headers, err := proj.SecurityHeaders()
+			Expect(err).To(BeNil())
+			Expect(headers).To(Equal(project.DefaultSecurityHeaders))
+		})
+
+		It("returns the default security headers merged with the overrides", func() {
+			Expect(proj.SetSecurityHeaderOverrides(map[string]string{
+				"X-Frame-Options":         "DENY",
+				"Content-Security-Policy": "",
+			})).To(Succeed())
+
+			headers, err := proj.SecurityHeaders()
+			Expect(err).To(BeNil())
+			Expect(headers).To(Equal(map[string]string{
+				"X-Content-Type-Options": "nosniff",
+				"Referrer-Policy":        "strict-origin-when-cross-origin",
+				"X-Frame-Options":        "DENY",
+			}))
+		})
+
+		It("returns nil when security headers are disabled", func() {
+			proj.SecurityHeadersEnabled = false
+
+			headers, err := proj.SecurityHeaders()
+			Expect(err).To(BeNil())
+			Expect(headers).To(BeNil())
+		})
 	})
 
 	Describe("FindByName()", func() {
@@ -225,6 +464,30 @@ var _ = Describe("Project", func() {
 		})
 	})
 
+	Describe("BundleFileLimit()", func() {
+		It("returns the default limit", func() {
+			Expect(proj.BundleFileLimit()).To(Equal(shared.MaxFilesPerBundle))
+		})
+
+		It("returns the limit of the project if it has one", func() {
+			n := 50000
+			proj.MaxBundleFiles = &n
+			Expect(proj.BundleFileLimit()).To(Equal(50000))
+		})
+	})
+
+	Describe("FileSizeLimit()", func() {
+		It("returns the default limit", func() {
+			Expect(proj.FileSizeLimit()).To(Equal(shared.MaxFileSize))
+		})
+
+		It("returns the limit of the project if it has one", func() {
+			n := int64(500 * 1000 * 1000)
+			proj.MaxFileSize = &n
+			Expect(proj.FileSizeLimit()).To(Equal(n))
+		})
+	})
+
 	Describe("Lock()", func() {
 		It("returns true if it successfully acquires a lock from the project", func() {
 			proj.LockedAt = nil
@@ -250,6 +513,55 @@ var _ = Describe("Project", func() {
 		})
 	})
 
+	Describe("LockFor()", func() {
+		It("records the holder and expiry of the lock", func() {
+			success, err := proj.LockFor(db, "builder (deployment 1)", time.Hour)
+			Expect(err).To(BeNil())
+			Expect(success).To(BeTrue())
+
+			Expect(*proj.LockedBy).To(Equal("builder (deployment 1)"))
+			Expect(proj.IsLocked()).To(BeTrue())
+
+			var updatedProj project.Project
+			Expect(db.First(&updatedProj, proj.ID).Error).To(BeNil())
+			Expect(updatedProj.LockedAt).NotTo(BeNil())
+			Expect(*updatedProj.LockedBy).To(Equal("builder (deployment 1)"))
+			Expect(updatedProj.LockExpiresAt.Sub(*updatedProj.LockedAt)).To(Equal(time.Hour))
+		})
+
+		It("returns false if the project is locked by someone else", func() {
+			proj2 := &project.Project{}
+			Expect(db.First(proj2, proj.ID).Error).To(BeNil())
+
+			success, err := proj2.LockFor(db, "deployer (deployment 1)", time.Hour)
+			Expect(err).To(BeNil())
+			Expect(success).To(BeTrue())
+
+			success, err = proj.LockFor(db, "builder (deployment 2)", time.Hour)
+			Expect(err).To(BeNil())
+			Expect(success).To(BeFalse())
+		})
+
+		It("takes over a lock that has expired", func() {
+			Expect(db.Exec("UPDATE projects SET locked_at = now() - interval '1 hour', locked_by = 'crashed', lock_expires_at = now() - interval '1 minute' WHERE id = ?", proj.ID).Error).To(BeNil())
+
+			success, err := proj.LockFor(db, "builder (deployment 2)", time.Hour)
+			Expect(err).To(BeNil())
+			Expect(success).To(BeTrue())
+			Expect(*proj.LockedBy).To(Equal("builder (deployment 2)"))
+		})
+
+		It("takes over a lock without expiry that is older than DefaultLockTTL", func() {
+			lockedTime := time.Now().Add(-project.DefaultLockTTL - time.Minute)
+			proj.LockedAt = &lockedTime
+			Expect(db.Save(proj).Error).To(BeNil())
+
+			success, err := proj.LockFor(db, "builder (deployment 2)", time.Hour)
+			Expect(err).To(BeNil())
+			Expect(success).To(BeTrue())
+		})
+	})
+
 	Describe("Unlock()", func() {
 		It("unlocks the project", func() {
 			currentTime := time.Now()
@@ -262,6 +574,85 @@ var _ = Describe("Project", func() {
 			Expect(db.First(&updatedProj, proj.ID).Error).To(BeNil())
 			Expect(updatedProj.LockedAt).To(BeNil())
 		})
+
+		It("does not release a lock that has been taken over by someone else", func() {
+			success, err := proj.LockFor(db, "builder (deployment 1)", time.Hour)
+			Expect(err).To(BeNil())
+			Expect(success).To(BeTrue())
+
+			Expect(db.Exec("UPDATE projects SET locked_by = 'deployer (deployment 2)' WHERE id = ?", proj.ID).Error).To(BeNil())
+
+			Expect(proj.Unlock(db)).To(BeNil())
+
+			var updatedProj project.Project
+			Expect(db.First(&updatedProj, proj.ID).Error).To(BeNil())
+			Expect(updatedProj.LockedAt).NotTo(BeNil())
+			Expect(*updatedProj.LockedBy).To(Equal("deployer (deployment 2)"))
+		})
+	})
+
+	Describe("ForceUnlock()", func() {
+		It("releases the lock regardless of who holds it", func() {
+			proj2 := &project.Project{}
+			Expect(db.First(proj2, proj.ID).Error).To(BeNil())
+
+			success, err := proj2.LockFor(db, "builder (deployment 1)", time.Hour)
+			Expect(err).To(BeNil())
+			Expect(success).To(BeTrue())
+
+			unlocked, err := proj.ForceUnlock(db)
+			Expect(err).To(BeNil())
+			Expect(unlocked).To(BeTrue())
+
+			var updatedProj project.Project
+			Expect(db.First(&updatedProj, proj.ID).Error).To(BeNil())
+			Expect(updatedProj.LockedAt).To(BeNil())
+			Expect(updatedProj.LockedBy).To(BeNil())
+			Expect(updatedProj.LockExpiresAt).To(BeNil())
+		})
+
+		It("returns false if the project is not locked", func() {
+			unlocked, err := proj.ForceUnlock(db)
+			Expect(err).To(BeNil())
+			Expect(unlocked).To(BeFalse())
+		})
+	})
+
+	Describe("ReleaseExpiredLocks()", func() {
+		var proj2, proj3 *project.Project
+
+		BeforeEach(func() {
+			proj2 = factories.Project(db, u)
+			proj3 = factories.Project(db, u)
+
+			success, err := proj.LockFor(db, "builder (deployment 1)", time.Hour)
+			Expect(err).To(BeNil())
+			Expect(success).To(BeTrue())
+
+			success, err = proj2.LockFor(db, "builder (deployment 2)", time.Hour)
+			Expect(err).To(BeNil())
+			Expect(success).To(BeTrue())
+			Expect(db.Exec("UPDATE projects SET lock_expires_at = now() - interval '1 minute' WHERE id = ?", proj2.ID).Error).To(BeNil())
+
+			lockedTime := time.Now().Add(-project.DefaultLockTTL - time.Minute)
+			proj3.LockedAt = &lockedTime
+			Expect(db.Save(proj3).Error).To(BeNil())
+		})
+
+		It("releases locks that have expired", func() {
+			n, err := project.ReleaseExpiredLocks(db)
+			Expect(err).To(BeNil())
+			Expect(n).To(Equal(int64(2)))
+
+			for _, p := range []*project.Project{proj, proj2, proj3} {
+				Expect(db.First(p, p.ID).Error).To(BeNil())
+			}
+			Expect(proj.LockedAt).NotTo(BeNil())
+			Expect(proj2.LockedAt).To(BeNil())
+			Expect(proj2.LockedBy).To(BeNil())
+			Expect(proj2.LockExpiresAt).To(BeNil())
+			Expect(proj3.LockedAt).To(BeNil())
+		})
 	})
 
 	Describe("AddCollaborator()", func() {
@@ -315,6 +706,16 @@ var _ = Describe("Project", func() {
 				Expect(err).To(BeNil())
 				Expect(len(cols)).To(Equal(0))
 			})
+
+			It("records who added the collaborator", func() {
+				err := proj.AddCollaboratorBy(db, anotherU, u)
+				Expect(err).To(BeNil())
+
+				col := &collab.Collab{}
+				Expect(db.Where("project_id = ?", proj.ID).First(col).Error).To(BeNil())
+				Expect(col.UserID).To(Equal(anotherU.ID))
+				Expect(*col.AddedByUserID).To(Equal(u.ID))
+			})
 		})
 	})
 
@@ -392,6 +793,39 @@ var _ = Describe("Project", func() {
 		})
 	})
 
+	Describe("SaveWithLock()", func() {
+		It("saves the project and increments the lock version", func() {
+			proj.ForceHTTPS = true
+			Expect(proj.SaveWithLock(db)).To(BeNil())
+			Expect(proj.LockVersion).To(Equal(int64(1)))
+
+			reloaded := &project.Project{}
+			Expect(db.First(reloaded, proj.ID).Error).To(BeNil())
+			Expect(reloaded.ForceHTTPS).To(BeTrue())
+			Expect(reloaded.LockVersion).To(Equal(int64(1)))
+		})
+
+		Context("when the project has been modified since it was loaded", func() {
+			It("returns ErrStaleProject and does not save the project", func() {
+				stale := &project.Project{}
+				Expect(db.First(stale, proj.ID).Error).To(BeNil())
+
+				proj.SkipBuild = !proj.SkipBuild
+				Expect(proj.SaveWithLock(db)).To(BeNil())
+
+				stale.ForceHTTPS = true
+				Expect(stale.SaveWithLock(db)).To(Equal(project.ErrStaleProject))
+				Expect(stale.LockVersion).To(Equal(int64(0)))
+
+				reloaded := &project.Project{}
+				Expect(db.First(reloaded, proj.ID).Error).To(BeNil())
+				Expect(reloaded.ForceHTTPS).To(BeFalse())
+				Expect(reloaded.SkipBuild).To(Equal(proj.SkipBuild))
+				Expect(reloaded.LockVersion).To(Equal(int64(1)))
+			})
+		})
+	})
+
 	Describe("Destroy()", func() {
 		var (
 			proj  *project.Project
@@ -735,6 +1169,22 @@ var _ = Describe("Project", func() {
 				Expect(projs[1].DeployedAt).To(BeNil())
 			})
 		})
+
+		Context("when all deployments of a project are deleted", func() {
+			BeforeEach(func() {
+				depl := factories.Deployment(db, proj, u, deployment.StateDeployed)
+				Expect(db.Delete(depl).Error).To(BeNil())
+			})
+
+			It("returns the project without deployed time", func() {
+				projs, err := project.ProjectsByUserID(db, u.ID)
+				Expect(err).To(BeNil())
+
+				Expect(projs).To(HaveLen(1))
+				Expect(projs[0].ID).To(Equal(proj.ID))
+				Expect(projs[0].DeployedAt).To(BeNil())
+			})
+		})
 	})
 
 	Describe("SharedProjectsByUserID", func() {
@@ -802,4 +1252,60 @@ var _ = Describe("Project", func() {
 			})
 		})
 	})
+
+	Describe("AccessibleProjectsByUserID", func() {
+		var (
+			proj  *project.Project
+			proj2 *project.Project
+			proj3 *project.Project
+
+			u  *user.User
+			u2 *user.User
+			u3 *user.User
+
+			depl *deployment.Deployment
+		)
+
+		BeforeEach(func() {
+			u = factories.User(db)
+			u2 = factories.User(db)
+			u3 = factories.User(db)
+
+			proj = factories.Project(db, u, "site-b")
+			proj2 = factories.Project(db, u2, "site-a")
+			proj3 = factories.Project(db, u3, "site-c")
+			factories.Project(db, u2, "site-d")
+
+			Expect(proj2.AddCollaborator(db, u)).To(BeNil())
+			Expect(proj3.AddCollaborator(db, u)).To(BeNil())
+			Expect(proj3.RemoveCollaborator(db, u)).To(BeNil())
+
+			depl = factories.Deployment(db, proj2, u2, deployment.StateDeployed)
+		})
+
+		It("returns projects and shared projects for the given user", func() {
+			projs, sharedProjs, err := project.AccessibleProjectsByUserID(db, u.ID)
+			Expect(err).To(BeNil())
+
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+
+			Expect(projs).To(HaveLen(1))
+			Expect(projs[0].ID).To(Equal(proj.ID))
+			Expect(projs[0].DeployedAt).To(BeNil())
+
+			Expect(sharedProjs).To(HaveLen(1))
+			Expect(sharedProjs[0].ID).To(Equal(proj2.ID))
+			Expect(sharedProjs[0].DeployedAt).To(Equal(depl.DeployedAt))
+		})
+
+		It("returns empty slices when the user has no projects", func() {
+			projs, sharedProjs, err := project.AccessibleProjectsByUserID(db, factories.User(db).ID)
+			Expect(err).To(BeNil())
+
+			Expect(projs).To(BeEmpty())
+			Expect(projs).NotTo(BeNil())
+			Expect(sharedProjs).To(BeEmpty())
+			Expect(sharedProjs).NotTo(BeNil())
+		})
+	})
 })
@@ -0,0 +1,159 @@
+package project
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Names of the security headers that are served for projects.
+const (
+	HeaderContentTypeOptions    = "X-Content-Type-Options"
+	HeaderReferrerPolicy        = "Referrer-Policy"
+	HeaderFrameOptions          = "X-Frame-Options"
+	HeaderContentSecurityPolicy = "Content-Security-Policy"
+)
+
+// MaxSecurityHeaderLength is the maximum length of the value of a security
+// header.
+const MaxSecurityHeaderLength = 2048
+
+// DefaultSecurityHeaders are the security headers served for projects that
+// have security headers enabled, unless they are overridden. The
+// Content-Security-Policy is deliberately lenient so that it does not break
+// existing sites, while still disallowing plugins, mixed content and framing
+// by other sites.
+var DefaultSecurityHeaders = map[string]string{
+	HeaderContentTypeOptions:    "nosniff",
+	HeaderReferrerPolicy:        "strict-origin-when-cross-origin",
+	HeaderFrameOptions:          "SAMEORIGIN",
+	HeaderContentSecurityPolicy: "default-src 'self' https: data: blob: 'unsafe-inline' 'unsafe-eval'; object-src 'none'; base-uri 'self'; frame-ancestors 'self'; upgrade-insecure-requests",
+}
+
+// allowedSecurityHeaderValues are the values a security header may be
+// overridden with, for headers that only accept a fixed set of values.
+var allowedSecurityHeaderValues = map[string][]string{
+	HeaderContentTypeOptions: {"nosniff"},
+	HeaderReferrerPolicy: {
+		"no-referrer",
+		"no-referrer-when-downgrade",
+		"origin",
+		"origin-when-cross-origin",
+		"same-origin",
+		"strict-origin",
+		"strict-origin-when-cross-origin",
+		"unsafe-url",
+	},
+	HeaderFrameOptions: {"DENY", "SAMEORIGIN"},
+}
+
+// SecurityHeaderOverridesMap returns the security headers that override
+// DefaultSecurityHeaders, by canonical header name. A header overridden with
+// an empty value is not served.
+func (p *Project) SecurityHeaderOverridesMap() (map[string]string, error) {
+	overrides := map[string]string{}
+	if len(p.SecurityHeaderOverrides) == 0 {
+		return overrides, nil
+	}
+
+	if err := json.Unmarshal(p.SecurityHeaderOverrides, &overrides); err != nil {
+		return nil, err
+	}
+	return overrides, nil
+}
+
+// SetSecurityHeaderOverrides replaces the security headers that override
+// DefaultSecurityHeaders. Header names are canonicalized, use Validate() to
+// check that the headers are supported.
+func (p *Project) SetSecurityHeaderOverrides(overrides map[string]string) error {
+	canonical := make(map[string]string, len(overrides))
+	for name, value := range overrides {
+		canonical[http.CanonicalHeaderKey(strings.TrimSpace(name))] = strings.TrimSpace(value)
+	}
+
+	b, err := json.Marshal(canonical)
+	if err != nil {
+		return err
+	}
+
+	p.SecurityHeaderOverrides = b
+	return nil
+}
+
+// SecurityHeaders returns the security headers to be served for the project,
+// i.e. DefaultSecurityHeaders merged with the project's overrides. It returns
+// nil if security headers are disabled for the project.
+func (p *Project) SecurityHeaders() (map[string]string, error) {
+	if !p.SecurityHeadersEnabled {
+		return nil, nil
+	}
+
+	overrides, err := p.SecurityHeaderOverridesMap()
+	if err != nil {
+		return nil, err
+	}
+
+	headers := map[string]string{}
+	for name, value := range DefaultSecurityHeaders {
+		if v, ok := overrides[name]; ok {
+			value = v
+		}
+		if value != "" {
+			headers[name] = value
+		}
+	}
+	return headers, nil
+}
+
+// validateSecurityHeaderOverrides returns a description of what is wrong with
+// the project's security header overrides, or an empty string if they are
+// valid.
+func (p *Project) validateSecurityHeaderOverrides() string {
+	overrides, err := p.SecurityHeaderOverridesMap()
+	if err != nil {
+		return "is invalid"
+	}
+
+	names := make([]string, 0, len(overrides))
+	for name := range overrides {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := overrides[name]
+
+		if _, ok := DefaultSecurityHeaders[name]; !ok {
+			return fmt.Sprintf("%q is not a supported header", name)
+		}
+
+		if value == "" {
+			continue
+		}
+
+		if len(value) > MaxSecurityHeaderLength {
+			return fmt.Sprintf("%q is too long (max. %d characters)", name, MaxSecurityHeaderLength)
+		}
+
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Sprintf("%q is invalid", name)
+		}
+
+		if allowed, ok := allowedSecurityHeaderValues[name]; ok && !containsFold(allowed, value) {
+			return fmt.Sprintf("%q must be one of: %s", name, strings.Join(allowed, ", "))
+		}
+	}
+
+	return ""
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package quotaevent
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/models/dailystat"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+)
+
+// BandwidthExceededDomainNames returns the names of the domains of a project
+// that have reached their hard bandwidth quota on the given (UTC) day.
+func BandwidthExceededDomainNames(db *gorm.DB, projectID uint, day time.Time) (map[string]bool, error) {
+	var names []string
+	if err := db.Model(QuotaEvent{}).Where("project_id = ? AND date = ? AND metric = ? AND level = ?",
+		projectID, day.Format(dailystat.DateFormat), MetricBandwidth, LevelHard).Pluck("domain_name", &names).Error; err != nil {
+		return nil, err
+	}
+
+	exceeded := make(map[string]bool, len(names))
+	for _, name := range names {
+		exceeded[name] = true
+	}
+	return exceeded, nil
+}
+
+// FindUnenforced returns the hard bandwidth quota events of the given (UTC)
+// day of projects that degrade domains over their quota, whose domains have
+// not been degraded yet.
+func FindUnenforced(db *gorm.DB, day time.Time) ([]*QuotaEvent, error) {
+	var evs []*QuotaEvent
+	if err := db.Where("date = ? AND metric = ? AND level = ? AND enforced_at IS NULL", day.Format(dailystat.DateFormat), MetricBandwidth, LevelHard).
+		Where("project_id IN (SELECT id FROM projects WHERE bandwidth_exceeded_mode = ? AND deleted_at IS NULL)", project.BandwidthExceededDegrade).
+		Order("id ASC").Find(&evs).Error; err != nil {
+		return nil, err
+	}
+	return evs, nil
+}
+
+// FindLiftable returns the enforced hard bandwidth quota events of days
+// before the given (UTC) day, whose domains are still degraded.
+func FindLiftable(db *gorm.DB, day time.Time) ([]*QuotaEvent, error) {
+	var evs []*QuotaEvent
+	if err := db.Where("date < ? AND metric = ? AND level = ? AND enforced_at IS NOT NULL AND lifted_at IS NULL", day.Format(dailystat.DateFormat), MetricBandwidth, LevelHard).
+		Order("id ASC").Find(&evs).Error; err != nil {
+		return nil, err
+	}
+	return evs, nil
+}
+
+// MarkEnforced records that the domains of the events have been degraded.
+func MarkEnforced(db *gorm.DB, evs []*QuotaEvent) error {
+	if len(evs) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	if err := db.Model(QuotaEvent{}).Where("id IN (?)", ids(evs)).UpdateColumn("enforced_at", now).Error; err != nil {
+		return err
+	}
+
+	for _, ev := range evs {
+		ev.EnforcedAt = &now
+	}
+	return nil
+}
+
+// MarkLifted records that the domains of the events are no longer degraded.
+func MarkLifted(db *gorm.DB, evs []*QuotaEvent) error {
+	if len(evs) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	if err := db.Model(QuotaEvent{}).Where("id IN (?)", ids(evs)).UpdateColumn("lifted_at", now).Error; err != nil {
+		return err
+	}
+
+	for _, ev := range evs {
+		ev.LiftedAt = &now
+	}
+	return nil
+}
+
+func ids(evs []*QuotaEvent) []uint {
+	ids := make([]uint, len(evs))
+	for i, ev := range evs {
+		ids[i] = ev.ID
+	}
+	return ids
+}
@@ -0,0 +1,130 @@
+package quotaevent_test
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/quotaevent"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Bandwidth quota enforcement", func() {
+	var (
+		db  *gorm.DB
+		err error
+
+		proj      *project.Project
+		today     time.Time
+		yesterday time.Time
+	)
+
+	createEvent := func(projectID uint, domainName string, date time.Time, metric, level string) *quotaevent.QuotaEvent {
+		ev := &quotaevent.QuotaEvent{
+			ProjectID:  projectID,
+			DomainName: domainName,
+			Date:       date,
+			Metric:     metric,
+			Level:      level,
+			Quota:      100,
+			Usage:      120,
+		}
+		Expect(db.Create(ev).Error).To(BeNil())
+		return ev
+	}
+
+	eventIDs := func(evs []*quotaevent.QuotaEvent) []uint {
+		ids := make([]uint, len(evs))
+		for i, ev := range evs {
+			ids[i] = ev.ID
+		}
+		return ids
+	}
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+
+		u := factories.User(db)
+		proj = factories.Project(db, u)
+		Expect(db.Model(proj).Update("bandwidth_exceeded_mode", project.BandwidthExceededDegrade).Error).To(BeNil())
+
+		today = time.Now().UTC().Truncate(24 * time.Hour)
+		yesterday = today.Add(-24 * time.Hour)
+	})
+
+	Describe("BandwidthExceededDomainNames()", func() {
+		It("returns the domains of the project that reached their hard bandwidth quota on the day", func() {
+			createEvent(proj.ID, "www.foo-bar.com", today, quotaevent.MetricBandwidth, quotaevent.LevelHard)
+			createEvent(proj.ID, "www.baz.com", today, quotaevent.MetricBandwidth, quotaevent.LevelSoft)
+			createEvent(proj.ID, "www.qux.com", today, quotaevent.MetricRequests, quotaevent.LevelHard)
+			createEvent(proj.ID, "www.quux.com", yesterday, quotaevent.MetricBandwidth, quotaevent.LevelHard)
+
+			names, err := quotaevent.BandwidthExceededDomainNames(db, proj.ID, today)
+			Expect(err).To(BeNil())
+			Expect(names).To(Equal(map[string]bool{"www.foo-bar.com": true}))
+		})
+	})
+
+	Describe("FindUnenforced()", func() {
+		It("returns the unenforced hard bandwidth events of the day of projects that degrade domains", func() {
+			blockProj := factories.Project(db, nil)
+
+			ev := createEvent(proj.ID, "www.foo-bar.com", today, quotaevent.MetricBandwidth, quotaevent.LevelHard)
+			createEvent(proj.ID, "www.baz.com", today, quotaevent.MetricBandwidth, quotaevent.LevelSoft)
+			createEvent(proj.ID, "www.quux.com", yesterday, quotaevent.MetricBandwidth, quotaevent.LevelHard)
+			createEvent(blockProj.ID, "www.block.com", today, quotaevent.MetricBandwidth, quotaevent.LevelHard)
+
+			enforced := createEvent(proj.ID, "www.enforced.com", today, quotaevent.MetricBandwidth, quotaevent.LevelHard)
+			Expect(quotaevent.MarkEnforced(db, []*quotaevent.QuotaEvent{enforced})).To(Succeed())
+
+			evs, err := quotaevent.FindUnenforced(db, today)
+			Expect(err).To(BeNil())
+			Expect(eventIDs(evs)).To(Equal([]uint{ev.ID}))
+		})
+	})
+
+	Describe("FindLiftable()", func() {
+		It("returns the enforced hard bandwidth events of previous days that have not been lifted", func() {
+			ev := createEvent(proj.ID, "www.foo-bar.com", yesterday, quotaevent.MetricBandwidth, quotaevent.LevelHard)
+			createEvent(proj.ID, "www.baz.com", yesterday, quotaevent.MetricBandwidth, quotaevent.LevelHard)
+			todays := createEvent(proj.ID, "www.qux.com", today, quotaevent.MetricBandwidth, quotaevent.LevelHard)
+			lifted := createEvent(proj.ID, "www.quux.com", yesterday, quotaevent.MetricBandwidth, quotaevent.LevelHard)
+
+			Expect(quotaevent.MarkEnforced(db, []*quotaevent.QuotaEvent{ev, todays, lifted})).To(Succeed())
+			Expect(quotaevent.MarkLifted(db, []*quotaevent.QuotaEvent{lifted})).To(Succeed())
+
+			evs, err := quotaevent.FindLiftable(db, today)
+			Expect(err).To(BeNil())
+			Expect(eventIDs(evs)).To(Equal([]uint{ev.ID}))
+		})
+	})
+
+	Describe("MarkEnforced() and MarkLifted()", func() {
+		It("records when the events were enforced and lifted", func() {
+			ev := createEvent(proj.ID, "www.foo-bar.com", today, quotaevent.MetricBandwidth, quotaevent.LevelHard)
+
+			Expect(quotaevent.MarkEnforced(db, []*quotaevent.QuotaEvent{ev})).To(Succeed())
+			Expect(ev.EnforcedAt).NotTo(BeNil())
+
+			Expect(quotaevent.MarkLifted(db, []*quotaevent.QuotaEvent{ev})).To(Succeed())
+			Expect(ev.LiftedAt).NotTo(BeNil())
+
+			reloaded := &quotaevent.QuotaEvent{}
+			Expect(db.First(reloaded, ev.ID).Error).To(BeNil())
+			Expect(reloaded.EnforcedAt).NotTo(BeNil())
+			Expect(reloaded.LiftedAt).NotTo(BeNil())
+		})
+
+		It("does nothing without events", func() {
+			Expect(quotaevent.MarkEnforced(db, nil)).To(Succeed())
+			Expect(quotaevent.MarkLifted(db, nil)).To(Succeed())
+		})
+	})
+})
@@ -0,0 +1,209 @@
+package quotaevent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/models/dailystat"
+	"github.com/nitrous-io/rise-server/apiserver/models/domain"
+)
+
+// Metrics that domains have quotas for.
+const (
+	MetricRequests  = "requests"
+	MetricBandwidth = "bandwidth"
+)
+
+// Levels of quotas.
+const (
+	LevelSoft = "soft"
+	LevelHard = "hard"
+)
+
+// trackedEventNames maps quota levels to the names of the events that are
+// sent to the tracker.
+var trackedEventNames = map[string]string{
+	LevelSoft: "Domain Soft Quota Exceeded",
+	LevelHard: "Domain Hard Quota Exceeded",
+}
+
+// WebhookTimeout is how long the quota alerts webhook is given to respond.
+var WebhookTimeout = 10 * time.Second
+
+// QuotaEvent is a database model representing a domain crossing one of its
+// daily quotas. It is recorded when the owner of the project is alerted, so
+// that they are only alerted once per quota per day.
+type QuotaEvent struct {
+	ID uint `gorm:"primary_key"`
+
+	ProjectID  uint
+	DomainName string
+	Date       time.Time
+
+	Metric string
+	Level  string
+	Quota  int64
+	Usage  int64
+
+	// EnforcedAt is when the meta.json of the domain was republished to
+	// degrade it, and LiftedAt is when it was republished once the quota had
+	// reset. They are only set for hard bandwidth quota events of projects
+	// that degrade domains instead of blocking them.
+	EnforcedAt *time.Time
+	LiftedAt   *time.Time
+
+	CreatedAt time.Time
+}
+
+// Check emits an event for each quota of a domain that was crossed when the
+// numbers in s were incremented by addedRequests and addedBytes. s must
+// contain the totals after the increment, as returned by dailystat.Increment.
+// As events are recorded with a unique index, db must not be a transaction.
+func Check(db *gorm.DB, quotas *domain.Quotas, s *dailystat.DailyStat, addedRequests, addedBytes int64) error {
+	if quotas.IsEmpty() {
+		return nil
+	}
+
+	type check struct {
+		metric        string
+		quota         *domain.Quota
+		before, after int64
+	}
+	for _, c := range []check{
+		{MetricRequests, quotas.Requests, s.Requests - addedRequests, s.Requests},
+		{MetricBandwidth, quotas.Bandwidth, s.Bytes - addedBytes, s.Bytes},
+	} {
+		if c.quota == nil {
+			continue
+		}
+
+		for _, level := range []string{LevelSoft, LevelHard} {
+			q := c.quota.Soft
+			if level == LevelHard {
+				q = c.quota.Hard
+			}
+			if q <= 0 || c.before >= q || c.after < q {
+				continue
+			}
+
+			if err := Emit(db, &QuotaEvent{
+				ProjectID:  s.ProjectID,
+				DomainName: s.DomainName,
+				Date:       s.Date,
+				Metric:     c.metric,
+				Level:      level,
+				Quota:      q,
+				Usage:      c.after,
+			}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Emit records a quota event, sends it to the tracker on behalf of the
+// project owner and POSTs it to the quota alerts webhook. Events that have
+// already been recorded for the same quota of the domain on the same day are
+// ignored. Only recording the event can fail, the rest is best-effort and
+// errors are logged.
+func Emit(db *gorm.DB, ev *QuotaEvent) error {
+	if err := db.Create(ev).Error; err != nil {
+		if e, ok := err.(*pq.Error); ok && e.Code.Name() == "unique_violation" {
+			return nil
+		}
+		return err
+	}
+
+	var (
+		userID      uint
+		projectName string
+	)
+	row := db.Table("projects").Where("id = ?", ev.ProjectID).Select("user_id, name").Row()
+	if err := row.Scan(&userID, &projectName); err != nil {
+		log.Errorf("failed to find project %d of quota event %d, err: %v", ev.ProjectID, ev.ID, err)
+		return nil
+	}
+
+	{
+		var (
+			event = trackedEventNames[ev.Level]
+			props = map[string]interface{}{
+				"projectName": projectName,
+				"domain":      ev.DomainName,
+				"metric":      ev.Metric,
+				"quota":       ev.Quota,
+				"usage":       ev.Usage,
+			}
+			context map[string]interface{}
+		)
+		if err := common.Track(strconv.Itoa(int(userID)), event, "", props, context); err != nil {
+			log.Errorf("failed to track %q event for user ID %d, err: %v",
+				event, userID, err)
+		}
+	}
+
+	if common.QuotaAlertsWebhookURL != "" {
+		if err := postToWebhook(common.QuotaAlertsWebhookURL, ev, projectName); err != nil {
+			log.Errorf("failed to post quota event %d to webhook, err: %v", ev.ID, err)
+		}
+	}
+
+	return nil
+}
+
+func postToWebhook(url string, ev *QuotaEvent, projectName string) error {
+	body, err := json.Marshal(struct {
+		ID         uint      `json:"id"`
+		Event      string    `json:"event"`
+		Project    string    `json:"project"`
+		Domain     string    `json:"domain"`
+		Date       string    `json:"date"`
+		Metric     string    `json:"metric"`
+		Level      string    `json:"level"`
+		Quota      int64     `json:"quota"`
+		Usage      int64     `json:"usage"`
+		OccurredAt time.Time `json:"occurred_at"`
+	}{
+		ev.ID,
+		"quota." + ev.Level + "_exceeded",
+		projectName,
+		ev.DomainName,
+		ev.Date.Format(dailystat.DateFormat),
+		ev.Metric,
+		ev.Level,
+		ev.Quota,
+		ev.Usage,
+		ev.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: WebhookTimeout}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected response status %d", res.StatusCode)
+	}
+	return nil
+}
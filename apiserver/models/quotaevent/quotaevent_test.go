@@ -0,0 +1,211 @@
+package quotaevent_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/dailystat"
+	"github.com/nitrous-io/rise-server/apiserver/models/domain"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/quotaevent"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/pkg/tracker"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+	"github.com/nitrous-io/rise-server/testhelper/fake"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "quotaevent")
+}
+
+var _ = Describe("QuotaEvent", func() {
+	var (
+		db  *gorm.DB
+		err error
+
+		fakeTracker *fake.Tracker
+		origTracker tracker.Trackable
+
+		u    *user.User
+		proj *project.Project
+		day  time.Time
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+
+		origTracker = common.Tracker
+		fakeTracker = &fake.Tracker{}
+		common.Tracker = fakeTracker
+
+		u = factories.User(db)
+		proj = factories.Project(db, u)
+		day = time.Date(2016, 6, 1, 0, 0, 0, 0, time.UTC)
+	})
+
+	AfterEach(func() {
+		common.Tracker = origTracker
+	})
+
+	countEvents := func() int {
+		var count int
+		Expect(db.Model(quotaevent.QuotaEvent{}).Count(&count).Error).To(BeNil())
+		return count
+	}
+
+	Describe("Check()", func() {
+		var quotas *domain.Quotas
+
+		BeforeEach(func() {
+			quotas = &domain.Quotas{
+				Requests:  &domain.Quota{Soft: 80, Hard: 100},
+				Bandwidth: &domain.Quota{Soft: 8000, Hard: 10000},
+			}
+		})
+
+		stat := func(requests, bytes int64) *dailystat.DailyStat {
+			return &dailystat.DailyStat{
+				ProjectID:  proj.ID,
+				DomainName: "www.foo-bar.com",
+				Date:       day,
+				Requests:   requests,
+				Bytes:      bytes,
+			}
+		}
+
+		It("emits an event for each quota that was crossed", func() {
+			Expect(quotaevent.Check(db, quotas, stat(85, 10500), 10, 3000)).To(BeNil())
+
+			var events []*quotaevent.QuotaEvent
+			Expect(db.Order("id ASC").Find(&events).Error).To(BeNil())
+			Expect(events).To(HaveLen(3))
+
+			for i, expected := range []struct {
+				metric, level string
+				quota, usage  int64
+			}{
+				{quotaevent.MetricRequests, quotaevent.LevelSoft, 80, 85},
+				{quotaevent.MetricBandwidth, quotaevent.LevelSoft, 8000, 10500},
+				{quotaevent.MetricBandwidth, quotaevent.LevelHard, 10000, 10500},
+			} {
+				ev := events[i]
+				Expect(ev.ProjectID).To(Equal(proj.ID))
+				Expect(ev.DomainName).To(Equal("www.foo-bar.com"))
+				Expect(ev.Date.UTC()).To(Equal(day))
+				Expect(ev.Metric).To(Equal(expected.metric))
+				Expect(ev.Level).To(Equal(expected.level))
+				Expect(ev.Quota).To(Equal(expected.quota))
+				Expect(ev.Usage).To(Equal(expected.usage))
+			}
+		})
+
+		It("does not emit events for quotas that had already been crossed", func() {
+			Expect(quotaevent.Check(db, quotas, stat(90, 500), 5, 100)).To(BeNil())
+			Expect(countEvents()).To(Equal(0))
+		})
+
+		It("only emits each event once per day", func() {
+			Expect(quotaevent.Check(db, quotas, stat(85, 0), 10, 0)).To(BeNil())
+			Expect(quotaevent.Check(db, &domain.Quotas{
+				Requests: &domain.Quota{Soft: 90, Hard: 100},
+			}, stat(95, 0), 10, 0)).To(BeNil())
+
+			Expect(countEvents()).To(Equal(1))
+			Expect(fakeTracker.TrackCalls.Count()).To(Equal(1))
+		})
+
+		It("does nothing if the domain has no quotas", func() {
+			Expect(quotaevent.Check(db, &domain.Quotas{}, stat(1000, 1000000), 1000, 1000000)).To(BeNil())
+			Expect(countEvents()).To(Equal(0))
+		})
+	})
+
+	Describe("Emit()", func() {
+		var ev *quotaevent.QuotaEvent
+
+		BeforeEach(func() {
+			ev = &quotaevent.QuotaEvent{
+				ProjectID:  proj.ID,
+				DomainName: "www.foo-bar.com",
+				Date:       day,
+				Metric:     quotaevent.MetricRequests,
+				Level:      quotaevent.LevelHard,
+				Quota:      100,
+				Usage:      101,
+			}
+		})
+
+		It("tracks the event on behalf of the project owner", func() {
+			Expect(quotaevent.Emit(db, ev)).To(BeNil())
+
+			trackCall := fakeTracker.TrackCalls.NthCall(1)
+			Expect(trackCall).NotTo(BeNil())
+			Expect(trackCall.Arguments[0]).To(Equal(fmt.Sprintf("%d", u.ID)))
+			Expect(trackCall.Arguments[1]).To(Equal("Domain Hard Quota Exceeded"))
+
+			props, ok := trackCall.Arguments[3].(map[string]interface{})
+			Expect(ok).To(BeTrue())
+			Expect(props["projectName"]).To(Equal(proj.Name))
+			Expect(props["domain"]).To(Equal("www.foo-bar.com"))
+			Expect(props["metric"]).To(Equal("requests"))
+			Expect(props["quota"]).To(Equal(int64(100)))
+			Expect(props["usage"]).To(Equal(int64(101)))
+		})
+
+		Context("when the quota alerts webhook is set", func() {
+			var (
+				s       *httptest.Server
+				bodies  chan []byte
+				origURL string
+			)
+
+			BeforeEach(func() {
+				bodies = make(chan []byte, 1)
+				s = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					b, _ := ioutil.ReadAll(r.Body)
+					bodies <- b
+				}))
+
+				origURL = common.QuotaAlertsWebhookURL
+				common.QuotaAlertsWebhookURL = s.URL
+			})
+
+			AfterEach(func() {
+				common.QuotaAlertsWebhookURL = origURL
+				s.Close()
+			})
+
+			It("posts the event to the webhook", func() {
+				Expect(quotaevent.Emit(db, ev)).To(BeNil())
+
+				var b []byte
+				Eventually(bodies).Should(Receive(&b))
+
+				var payload map[string]interface{}
+				Expect(json.Unmarshal(b, &payload)).To(BeNil())
+				Expect(payload["event"]).To(Equal("quota.hard_exceeded"))
+				Expect(payload["project"]).To(Equal(proj.Name))
+				Expect(payload["domain"]).To(Equal("www.foo-bar.com"))
+				Expect(payload["date"]).To(Equal("2016-06-01"))
+				Expect(payload["metric"]).To(Equal("requests"))
+				Expect(payload["quota"]).To(Equal(float64(100)))
+				Expect(payload["usage"]).To(Equal(float64(101)))
+			})
+		})
+	})
+})
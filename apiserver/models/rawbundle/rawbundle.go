@@ -8,6 +8,10 @@ type RawBundle struct {
 	ProjectID    uint
 	Checksum     string
 	UploadedPath string
+
+	// MultipartUploadID is the ID of the S3 multipart upload that the bundle
+	// is uploaded in parts with, until the upload is completed.
+	MultipartUploadID *string
 }
 
 // Returns a struct that can be converted to JSON
@@ -0,0 +1,56 @@
+// Package releasedname holds the names of deleted projects for a cooldown
+// period before other users can use them, so that the default domains of the
+// projects, which may still be linked to, cannot be taken over right away.
+package releasedname
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/shared"
+)
+
+// ReleasedName is the name of a deleted project that is held for the user who
+// owned the project until HeldUntil.
+type ReleasedName struct {
+	ID         uint `gorm:"primary_key"`
+	Name       string
+	UserID     uint
+	ReleasedAt time.Time `sql:"default:now()"`
+	HeldUntil  time.Time
+}
+
+// Release holds the name of a project owned by the user with the given ID for
+// shared.NameCooldownDays days. Releasing a name that is held already restarts
+// its cooldown.
+func Release(db *gorm.DB, name string, userID uint) error {
+	if shared.NameCooldownDays <= 0 {
+		return nil
+	}
+
+	return db.Exec(`WITH update_name AS (
+		UPDATE released_names
+		SET user_id = $2, released_at = now(), held_until = now() + $3 * interval '1 day'
+		WHERE name = $1 RETURNING id
+	)
+	INSERT INTO released_names (name, user_id, held_until)
+	SELECT $1, $2, now() + $3 * interval '1 day' WHERE NOT EXISTS (SELECT * FROM update_name);
+	`, name, userID, shared.NameCooldownDays).Error
+}
+
+// IsHeld returns whether the name is held for a user other than the one with
+// the given ID, who can use the name again right away.
+func IsHeld(db *gorm.DB, name string, userID uint) (bool, error) {
+	var count int
+	if err := db.Model(&ReleasedName{}).Where("name = ? AND user_id <> ? AND held_until > now()", name, userID).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// DeleteExpired deletes the names whose cooldown has ended, and returns the
+// number of names deleted.
+func DeleteExpired(db *gorm.DB) (int64, error) {
+	q := db.Where("held_until <= now()").Delete(ReleasedName{})
+	return q.RowsAffected, q.Error
+}
@@ -0,0 +1,136 @@
+package releasedname_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/releasedname"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/shared"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "releasedname")
+}
+
+var _ = Describe("ReleasedName", func() {
+	var (
+		db  *gorm.DB
+		err error
+
+		u1, u2 *user.User
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+
+		u1 = factories.User(db)
+		u2 = factories.User(db)
+	})
+
+	Describe("Release()", func() {
+		It("holds the name for the cooldown period", func() {
+			Expect(releasedname.Release(db, "foo-bar-express", u1.ID)).To(Succeed())
+
+			rn := &releasedname.ReleasedName{}
+			Expect(db.Where("name = ?", "foo-bar-express").First(rn).Error).To(BeNil())
+			Expect(rn.UserID).To(Equal(u1.ID))
+			Expect(rn.HeldUntil.Sub(rn.ReleasedAt)).To(Equal(time.Duration(shared.NameCooldownDays) * 24 * time.Hour))
+		})
+
+		It("restarts the cooldown of a name that is held already", func() {
+			Expect(db.Create(&releasedname.ReleasedName{
+				Name:      "foo-bar-express",
+				UserID:    u1.ID,
+				HeldUntil: time.Now().Add(-time.Hour),
+			}).Error).To(BeNil())
+
+			Expect(releasedname.Release(db, "foo-bar-express", u2.ID)).To(Succeed())
+
+			var names []*releasedname.ReleasedName
+			Expect(db.Find(&names).Error).To(BeNil())
+			Expect(names).To(HaveLen(1))
+			Expect(names[0].UserID).To(Equal(u2.ID))
+			Expect(names[0].HeldUntil).To(BeTemporally(">", time.Now()))
+		})
+
+		Context("when the cooldown is disabled", func() {
+			var origCooldownDays int
+
+			BeforeEach(func() {
+				origCooldownDays = shared.NameCooldownDays
+				shared.NameCooldownDays = 0
+			})
+
+			AfterEach(func() {
+				shared.NameCooldownDays = origCooldownDays
+			})
+
+			It("does not hold the name", func() {
+				Expect(releasedname.Release(db, "foo-bar-express", u1.ID)).To(Succeed())
+
+				var count int
+				Expect(db.Model(releasedname.ReleasedName{}).Count(&count).Error).To(BeNil())
+				Expect(count).To(Equal(0))
+			})
+		})
+	})
+
+	Describe("IsHeld()", func() {
+		BeforeEach(func() {
+			Expect(releasedname.Release(db, "foo-bar-express", u1.ID)).To(Succeed())
+		})
+
+		It("returns whether the name is held for another user", func() {
+			held, err := releasedname.IsHeld(db, "foo-bar-express", u2.ID)
+			Expect(err).To(BeNil())
+			Expect(held).To(BeTrue())
+
+			held, err = releasedname.IsHeld(db, "foo-bar-express", u1.ID)
+			Expect(err).To(BeNil())
+			Expect(held).To(BeFalse())
+
+			held, err = releasedname.IsHeld(db, "foo-bar-baz", u2.ID)
+			Expect(err).To(BeNil())
+			Expect(held).To(BeFalse())
+		})
+
+		It("returns false once the cooldown has ended", func() {
+			Expect(db.Model(releasedname.ReleasedName{}).Update("held_until", time.Now().Add(-time.Minute)).Error).To(BeNil())
+
+			held, err := releasedname.IsHeld(db, "foo-bar-express", u2.ID)
+			Expect(err).To(BeNil())
+			Expect(held).To(BeFalse())
+		})
+	})
+
+	Describe("DeleteExpired()", func() {
+		It("deletes the names whose cooldown has ended", func() {
+			Expect(releasedname.Release(db, "foo-bar-express", u1.ID)).To(Succeed())
+			Expect(db.Create(&releasedname.ReleasedName{
+				Name:      "foo-bar-baz",
+				UserID:    u1.ID,
+				HeldUntil: time.Now().Add(-time.Hour),
+			}).Error).To(BeNil())
+
+			n, err := releasedname.DeleteExpired(db)
+			Expect(err).To(BeNil())
+			Expect(n).To(Equal(int64(1)))
+
+			var names []*releasedname.ReleasedName
+			Expect(db.Find(&names).Error).To(BeNil())
+			Expect(names).To(HaveLen(1))
+			Expect(names[0].Name).To(Equal("foo-bar-express"))
+		})
+	})
+})
@@ -5,8 +5,23 @@ import (
 
 	"github.com/jinzhu/gorm"
 	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/pkg/repofetcher"
 )
 
+// Providers that repositories can be hosted on.
+const (
+	ProviderGitHub    = repofetcher.ProviderGitHub
+	ProviderGitLab    = repofetcher.ProviderGitLab
+	ProviderBitbucket = repofetcher.ProviderBitbucket
+)
+
+// ProviderNames are the display names of the providers.
+var ProviderNames = map[string]string{
+	ProviderGitHub:    "GitHub",
+	ProviderGitLab:    "GitLab",
+	ProviderBitbucket: "Bitbucket",
+}
+
 type Repo struct {
 	gorm.Model
 
@@ -15,8 +30,15 @@ type Repo struct {
 	// This could be the project owner or a collaborator.
 	UserID uint
 
+	// Provider is where the repository is hosted, which determines the
+	// webhook that pushes are received with and how the repository is fetched.
+	Provider string `sql:"default:'github'"`
+
 	URI    string `sql:"column:uri"`
 	Branch string `sql:"default:'master'"`
+	// RootDir is the directory of the repository that contains pubstorm.json,
+	// for repositories that contain more than the project, e.g. monorepos.
+	RootDir string
 
 	WebhookPath   string `sql:"default:encode(gen_random_bytes(16), 'hex')"`
 	WebhookSecret string
@@ -25,22 +47,38 @@ type Repo struct {
 func (r *Repo) AsJSON() interface{} {
 	return struct {
 		ProjectID     uint   `json:"project_id"`
+		Provider      string `json:"provider"`
 		URI           string `json:"uri"`
 		Branch        string `json:"branch"`
+		RootDir       string `json:"root_dir"`
 		WebhookURL    string `json:"webhook_url"`
 		WebhookSecret string `json:"webhook_secret"`
 	}{
 		r.ProjectID,
+		r.Provider,
 		r.URI,
 		r.Branch,
+		r.RootDir,
 		r.WebhookURL(),
 		r.WebhookSecret,
 	}
 }
 
+// ProviderName returns the display name of the provider of the repository.
+func (r *Repo) ProviderName() string {
+	if name, ok := ProviderNames[r.Provider]; ok {
+		return name
+	}
+	return ProviderNames[ProviderGitHub]
+}
+
 func (r *Repo) WebhookURL() string {
 	// Gin does not provide route generation, so unfortunately we have to
 	// hardcode this and maintain it with routes.go.
 	// See https://github.com/gin-gonic/gin/issues/357 to track this issue.
-	return fmt.Sprintf("%s/hooks/github/%s", common.WebhookHost, r.WebhookPath)
+	provider := r.Provider
+	if provider == "" {
+		provider = ProviderGitHub
+	}
+	return fmt.Sprintf("%s/hooks/%s/%s", common.WebhookHost, provider, r.WebhookPath)
 }
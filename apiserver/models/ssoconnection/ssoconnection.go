@@ -0,0 +1,306 @@
+package ssoconnection
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/pkg/aesencrypter"
+	"github.com/nitrous-io/rise-server/pkg/oidc"
+)
+
+var (
+	emailDomainRe = regexp.MustCompile(`\A([a-z0-9]([a-z0-9\-]*[a-z0-9])?\.)+[a-z]{2,}\z`)
+
+	ErrNoClientSecret    = errors.New("client secret is empty")
+	ErrEmailDomainTaken  = errors.New("email domain is taken")
+	ErrEmailNotVerified  = errors.New("email address has not been verified by the identity provider")
+	ErrEmailDomainDenied = errors.New("email address does not belong to the organization's domain")
+)
+
+// SSOConnection is a database model representing an organization's OpenID
+// Connect identity provider. Users whose email address belongs to the
+// organization's email domain log in through the identity provider, and are
+// provisioned the first time they log in.
+type SSOConnection struct {
+	ID uint `gorm:"primary_key"`
+
+	Organization string
+	EmailDomain  string
+
+	Issuer                string
+	AuthorizationEndpoint string
+	TokenEndpoint         string
+	JWKSURI               string `sql:"column:jwks_uri"`
+
+	ClientID              string
+	ClientSecret          string `sql:"-"`
+	EncryptedClientSecret string
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt *time.Time
+}
+
+// TableName returns the table name of SSOConnection, which gorm would
+// otherwise derive as "s_s_o_connections".
+func (c *SSOConnection) TableName() string {
+	return "sso_connections"
+}
+
+// JSON specifies which fields of an SSO connection will be marshaled to JSON.
+// The client secret is never included.
+type JSON struct {
+	ID                    uint      `json:"id"`
+	Organization          string    `json:"organization"`
+	EmailDomain           string    `json:"email_domain"`
+	Issuer                string    `json:"issuer"`
+	AuthorizationEndpoint string    `json:"authorization_endpoint"`
+	TokenEndpoint         string    `json:"token_endpoint"`
+	JWKSURI               string    `json:"jwks_uri"`
+	ClientID              string    `json:"client_id"`
+	CreatedAt             time.Time `json:"created_at"`
+}
+
+// AsJSON returns a struct that can be converted to JSON
+func (c *SSOConnection) AsJSON() interface{} {
+	return JSON{
+		ID:                    c.ID,
+		Organization:          c.Organization,
+		EmailDomain:           c.EmailDomain,
+		Issuer:                c.Issuer,
+		AuthorizationEndpoint: c.AuthorizationEndpoint,
+		TokenEndpoint:         c.TokenEndpoint,
+		JWKSURI:               c.JWKSURI,
+		ClientID:              c.ClientID,
+		CreatedAt:             c.CreatedAt,
+	}
+}
+
+// Validate validates SSOConnection, if there are invalid fields, it returns a
+// map of <field, errors> and returns nil if valid
+func (c *SSOConnection) Validate() map[string]string {
+	errors := map[string]string{}
+
+	if c.Organization == "" {
+		errors["organization"] = "is required"
+	} else if len(c.Organization) > 255 {
+		errors["organization"] = "is too long (max. 255 characters)"
+	}
+
+	if c.EmailDomain == "" {
+		errors["email_domain"] = "is required"
+	} else if len(c.EmailDomain) > 255 || !emailDomainRe.MatchString(c.EmailDomain) {
+		errors["email_domain"] = "is invalid"
+	}
+
+	if c.Issuer == "" {
+		errors["issuer"] = "is required"
+	} else if !strings.HasPrefix(c.Issuer, "https://") {
+		errors["issuer"] = "must be an https URL"
+	}
+
+	if c.ClientID == "" {
+		errors["client_id"] = "is required"
+	}
+
+	if c.ClientSecret == "" && c.EncryptedClientSecret == "" {
+		errors["client_secret"] = "is required"
+	}
+
+	if len(errors) == 0 {
+		return nil
+	}
+	return errors
+}
+
+// EncryptClientSecret encrypts `ClientSecret` with the given keyring
+func (c *SSOConnection) EncryptClientSecret(keyring *aesencrypter.Keyring) error {
+	if c.ClientSecret == "" {
+		return ErrNoClientSecret
+	}
+
+	encrypted, err := keyring.EncryptToString([]byte(c.ClientSecret))
+	if err != nil {
+		return err
+	}
+
+	c.EncryptedClientSecret = encrypted
+	return nil
+}
+
+// Insert saves the connection to the DB, returning ErrEmailDomainTaken if
+// there is already a connection for the email domain.
+func (c *SSOConnection) Insert(db *gorm.DB) error {
+	err := db.Create(c).Error
+	if e, ok := err.(*pq.Error); ok && e.Code.Name() == "unique_violation" && e.Constraint == "index_sso_connections_on_email_domain" {
+		return ErrEmailDomainTaken
+	}
+	return err
+}
+
+// Provider returns the identity provider of the connection.
+func (c *SSOConnection) Provider(keyring *aesencrypter.Keyring) (*oidc.Provider, error) {
+	secret, err := keyring.DecryptString(c.EncryptedClientSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	return &oidc.Provider{
+		Metadata: oidc.Metadata{
+			Issuer:                c.Issuer,
+			AuthorizationEndpoint: c.AuthorizationEndpoint,
+			TokenEndpoint:         c.TokenEndpoint,
+			JWKSURI:               c.JWKSURI,
+		},
+		ClientID:     c.ClientID,
+		ClientSecret: string(secret),
+	}, nil
+}
+
+// EmailDomain returns the domain of an email address, in lower case.
+func EmailDomain(email string) string {
+	i := strings.LastIndex(email, "@")
+	if i < 0 {
+		return ""
+	}
+	return strings.ToLower(email[i+1:])
+}
+
+// FindByID returns the connection with the given ID, or nil if it does not
+// exist.
+func FindByID(db *gorm.DB, id uint) (*SSOConnection, error) {
+	c := &SSOConnection{}
+	if err := db.Where("id = ?", id).First(c).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+// FindByEmailDomain returns the connection for the given email domain, or nil
+// if the domain has none.
+func FindByEmailDomain(db *gorm.DB, domain string) (*SSOConnection, error) {
+	c := &SSOConnection{}
+	if err := db.Where("email_domain = ?", strings.ToLower(domain)).First(c).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+// All returns all connections, ordered by email domain.
+func All(db *gorm.DB) ([]*SSOConnection, error) {
+	var conns []*SSOConnection
+	if err := db.Order("email_domain ASC").Find(&conns).Error; err != nil {
+		return nil, err
+	}
+	return conns, nil
+}
+
+// SSOIdentity is a database model linking a user of the identity provider of
+// an SSO connection, identified by the subject of their ID tokens, to a user.
+type SSOIdentity struct {
+	ID              uint `gorm:"primary_key"`
+	SSOConnectionID uint `sql:"column:sso_connection_id"`
+	Subject         string
+	UserID          uint
+	CreatedAt       time.Time
+}
+
+// TableName returns the table name of SSOIdentity, which gorm would otherwise
+// derive as "s_s_o_identities".
+func (i *SSOIdentity) TableName() string {
+	return "sso_identities"
+}
+
+// ProvisionUser returns the user that the identity provider authenticated
+// with the given claims. If the identity has not logged in before, it is
+// linked to the user with the same email address, which is created (and
+// confirmed, since the identity provider has verified the email address) if
+// it does not exist yet. created is whether the user was created.
+func (c *SSOConnection) ProvisionUser(db *gorm.DB, claims *oidc.Claims) (u *user.User, created bool, err error) {
+	tx := db.Begin()
+	if err := tx.Error; err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	ident := &SSOIdentity{}
+	err = tx.Where("sso_connection_id = ? AND subject = ?", c.ID, claims.Subject).First(ident).Error
+	if err != nil && err != gorm.RecordNotFound {
+		return nil, false, err
+	}
+
+	if err == nil {
+		u = &user.User{}
+		if err := tx.First(u, ident.UserID).Error; err != nil {
+			return nil, false, err
+		}
+		return u, false, tx.Commit().Error
+	}
+
+	if !claims.EmailVerified {
+		return nil, false, ErrEmailNotVerified
+	}
+
+	email := strings.ToLower(claims.Email)
+	if EmailDomain(email) != c.EmailDomain {
+		return nil, false, ErrEmailDomainDenied
+	}
+
+	u, err = user.FindByEmail(tx, email)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if u == nil {
+		// Users provisioned by SSO log in through their identity provider,
+		// so they are given a random password that no one knows.
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return nil, false, err
+		}
+
+		u = &user.User{Email: email, Password: hex.EncodeToString(b)}
+		if err := u.Insert(tx); err != nil {
+			return nil, false, err
+		}
+		created = true
+
+		if err := tx.Model(u).Updates(map[string]interface{}{
+			"name":         claims.Name,
+			"organization": c.Organization,
+		}).Error; err != nil {
+			return nil, false, err
+		}
+	}
+
+	// The identity provider has verified the email address.
+	if u.State == user.StateUnconfirmed {
+		if err := u.Transition(tx, user.EventConfirm); err != nil {
+			return nil, false, err
+		}
+	}
+
+	ident = &SSOIdentity{
+		SSOConnectionID: c.ID,
+		Subject:         claims.Subject,
+		UserID:          u.ID,
+	}
+	if err := tx.Create(ident).Error; err != nil {
+		return nil, false, err
+	}
+
+	return u, created, tx.Commit().Error
+}
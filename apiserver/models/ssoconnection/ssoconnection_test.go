@@ -0,0 +1,215 @@
+package ssoconnection_test
+
+import (
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/ssoconnection"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/pkg/aesencrypter"
+	"github.com/nitrous-io/rise-server/pkg/oidc"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "ssoconnection")
+}
+
+var _ = Describe("SSOConnection", func() {
+	var (
+		conn    *ssoconnection.SSOConnection
+		keyring = aesencrypter.NewKeyring("something-something-something-32", nil)
+	)
+
+	BeforeEach(func() {
+		conn = &ssoconnection.SSOConnection{
+			Organization:          "Hogwarts",
+			EmailDomain:           "hogwarts.edu",
+			Issuer:                "https://login.hogwarts.edu",
+			AuthorizationEndpoint: "https://login.hogwarts.edu/authorize",
+			TokenEndpoint:         "https://login.hogwarts.edu/token",
+			JWKSURI:               "https://login.hogwarts.edu/jwks",
+			ClientID:              "pubstorm",
+			ClientSecret:          "s3cr3t",
+		}
+	})
+
+	Describe("Validate()", func() {
+		It("returns nil if valid", func() {
+			Expect(conn.Validate()).To(BeNil())
+		})
+
+		DescribeTable("invalid fields",
+			func(setup func(), field, message string) {
+				setup()
+				errors := conn.Validate()
+				Expect(errors).To(HaveLen(1))
+				Expect(errors[field]).To(Equal(message))
+			},
+			Entry("organization is empty", func() { conn.Organization = "" }, "organization", "is required"),
+			Entry("email domain is empty", func() { conn.EmailDomain = "" }, "email_domain", "is required"),
+			Entry("email domain is invalid", func() { conn.EmailDomain = "hogwarts" }, "email_domain", "is invalid"),
+			Entry("email domain is an email address", func() { conn.EmailDomain = "harry@hogwarts.edu" }, "email_domain", "is invalid"),
+			Entry("issuer is empty", func() { conn.Issuer = "" }, "issuer", "is required"),
+			Entry("issuer is not https", func() { conn.Issuer = "http://login.hogwarts.edu" }, "issuer", "must be an https URL"),
+			Entry("client id is empty", func() { conn.ClientID = "" }, "client_id", "is required"),
+			Entry("client secret is empty", func() { conn.ClientSecret = "" }, "client_secret", "is required"),
+		)
+	})
+
+	Describe("EncryptClientSecret() / Provider()", func() {
+		It("encrypts the client secret and decrypts it into the provider", func() {
+			Expect(conn.EncryptClientSecret(keyring)).To(BeNil())
+			Expect(conn.EncryptedClientSecret).NotTo(ContainSubstring("s3cr3t"))
+
+			p, err := conn.Provider(keyring)
+			Expect(err).To(BeNil())
+			Expect(p.ClientID).To(Equal("pubstorm"))
+			Expect(p.ClientSecret).To(Equal("s3cr3t"))
+			Expect(p.Issuer).To(Equal("https://login.hogwarts.edu"))
+			Expect(p.TokenEndpoint).To(Equal("https://login.hogwarts.edu/token"))
+			Expect(p.JWKSURI).To(Equal("https://login.hogwarts.edu/jwks"))
+		})
+
+		It("returns an error if there is no client secret", func() {
+			conn.ClientSecret = ""
+			Expect(conn.EncryptClientSecret(keyring)).To(Equal(ssoconnection.ErrNoClientSecret))
+		})
+	})
+
+	Describe("EmailDomain()", func() {
+		It("returns the lowercased domain of an email address", func() {
+			Expect(ssoconnection.EmailDomain("Harry@Hogwarts.EDU")).To(Equal("hogwarts.edu"))
+			Expect(ssoconnection.EmailDomain("harry")).To(Equal(""))
+		})
+	})
+
+	Context("with a database", func() {
+		var (
+			db  *gorm.DB
+			err error
+		)
+
+		BeforeEach(func() {
+			db, err = dbconn.DB()
+			Expect(err).To(BeNil())
+			testhelper.TruncateTables(db.DB())
+
+			Expect(conn.EncryptClientSecret(keyring)).To(BeNil())
+			Expect(conn.Insert(db)).To(BeNil())
+		})
+
+		Describe("Insert()", func() {
+			It("returns ErrEmailDomainTaken if the email domain already has a connection", func() {
+				conn2 := &ssoconnection.SSOConnection{
+					Organization:          "Hogwarts Alumni",
+					EmailDomain:           "hogwarts.edu",
+					Issuer:                "https://alumni.hogwarts.edu",
+					ClientID:              "pubstorm",
+					EncryptedClientSecret: conn.EncryptedClientSecret,
+				}
+				Expect(conn2.Insert(db)).To(Equal(ssoconnection.ErrEmailDomainTaken))
+			})
+		})
+
+		Describe("FindByEmailDomain()", func() {
+			It("returns the connection of the domain", func() {
+				c, err := ssoconnection.FindByEmailDomain(db, "Hogwarts.edu")
+				Expect(err).To(BeNil())
+				Expect(c).NotTo(BeNil())
+				Expect(c.ID).To(Equal(conn.ID))
+			})
+
+			It("returns nil if the domain has no connection", func() {
+				c, err := ssoconnection.FindByEmailDomain(db, "beauxbatons.fr")
+				Expect(err).To(BeNil())
+				Expect(c).To(BeNil())
+			})
+
+			It("returns nil if the connection was deleted", func() {
+				Expect(db.Delete(conn).Error).To(BeNil())
+
+				c, err := ssoconnection.FindByEmailDomain(db, "hogwarts.edu")
+				Expect(err).To(BeNil())
+				Expect(c).To(BeNil())
+			})
+		})
+
+		Describe("ProvisionUser()", func() {
+			var claims *oidc.Claims
+
+			BeforeEach(func() {
+				claims = &oidc.Claims{
+					Subject:       "248289761001",
+					Email:         "Harry@hogwarts.edu",
+					EmailVerified: true,
+					Name:          "Harry Potter",
+				}
+			})
+
+			It("creates a confirmed user if there is no user with the email address", func() {
+				u, created, err := conn.ProvisionUser(db, claims)
+				Expect(err).To(BeNil())
+				Expect(created).To(BeTrue())
+
+				u2 := &user.User{}
+				Expect(db.First(u2, u.ID).Error).To(BeNil())
+				Expect(u2.Email).To(Equal("harry@hogwarts.edu"))
+				Expect(u2.Name).To(Equal("Harry Potter"))
+				Expect(u2.Organization).To(Equal("Hogwarts"))
+				Expect(u2.ConfirmedAt).NotTo(BeNil())
+
+				var count int
+				Expect(db.Model(ssoconnection.SSOIdentity{}).Where("user_id = ? AND subject = ?", u.ID, claims.Subject).Count(&count).Error).To(BeNil())
+				Expect(count).To(Equal(1))
+			})
+
+			It("links the identity to an existing user with the email address", func() {
+				existing := factories.User(db)
+				Expect(db.Model(existing).Update("email", "harry@hogwarts.edu").Error).To(BeNil())
+
+				u, created, err := conn.ProvisionUser(db, claims)
+				Expect(err).To(BeNil())
+				Expect(created).To(BeFalse())
+				Expect(u.ID).To(Equal(existing.ID))
+			})
+
+			It("returns the linked user on subsequent logins, even if the email address changed", func() {
+				u, _, err := conn.ProvisionUser(db, claims)
+				Expect(err).To(BeNil())
+
+				claims.Email = "harry.potter@gmail.com"
+				claims.EmailVerified = false
+
+				u2, created, err := conn.ProvisionUser(db, claims)
+				Expect(err).To(BeNil())
+				Expect(created).To(BeFalse())
+				Expect(u2.ID).To(Equal(u.ID))
+			})
+
+			It("returns ErrEmailNotVerified if the identity provider has not verified the email address", func() {
+				claims.EmailVerified = false
+
+				_, _, err := conn.ProvisionUser(db, claims)
+				Expect(err).To(Equal(ssoconnection.ErrEmailNotVerified))
+			})
+
+			It("returns ErrEmailDomainDenied if the email address is not in the organization's domain", func() {
+				claims.Email = "harry@beauxbatons.fr"
+
+				_, _, err := conn.ProvisionUser(db, claims)
+				Expect(err).To(Equal(ssoconnection.ErrEmailDomainDenied))
+
+				var count int
+				Expect(db.Model(user.User{}).Count(&count).Error).To(BeNil())
+				Expect(count).To(Equal(0))
+			})
+		})
+	})
+})
@@ -0,0 +1,129 @@
+package user
+
+import (
+	"errors"
+
+	"github.com/jinzhu/gorm"
+)
+
+// States of user accounts. Only confirmed users can log in, create projects
+// and deploy.
+const (
+	StateUnconfirmed = "unconfirmed"
+	StateConfirmed   = "confirmed"
+	// StateLocked is the state of users who are locked out of their account,
+	// e.g. because it may have been compromised, until they reset their
+	// password.
+	StateLocked = "locked"
+	// StateSuspended is the state of users who have been suspended by an
+	// admin, e.g. for abuse, until they are reinstated.
+	StateSuspended = "suspended"
+)
+
+// Events that transition users between states.
+const (
+	EventConfirm   = "confirm"
+	EventLock      = "lock"
+	EventUnlock    = "unlock"
+	EventSuspend   = "suspend"
+	EventReinstate = "reinstate"
+)
+
+// Actions that users have to take before they can use their account again.
+const (
+	ActionConfirmEmail   = "confirm_email"
+	ActionResetPassword  = "reset_password"
+	ActionContactSupport = "contact_support"
+)
+
+// Errors returned from this package.
+var (
+	ErrInvalidTransition = errors.New("transition is not valid in the current state")
+
+	ErrUnconfirmed = errors.New("user has not confirmed email address")
+	ErrLocked      = errors.New("user is locked, reset password to unlock")
+	ErrSuspended   = errors.New("user is suspended")
+)
+
+// restoredState is the SQL expression of the state that unlocked and
+// reinstated users return to.
+const restoredState = "CASE WHEN confirmed_at IS NULL THEN 'unconfirmed' ELSE 'confirmed' END"
+
+// transition is the states that an event transitions users from, and the SQL
+// expression of the state that it transitions them to.
+type transition struct {
+	from []string
+	to   string
+}
+
+var transitions = map[string]transition{
+	EventConfirm:   {[]string{StateUnconfirmed}, "'" + StateConfirmed + "'"},
+	EventLock:      {[]string{StateUnconfirmed, StateConfirmed}, "'" + StateLocked + "'"},
+	EventUnlock:    {[]string{StateLocked}, restoredState},
+	EventSuspend:   {[]string{StateUnconfirmed, StateConfirmed, StateLocked}, "'" + StateSuspended + "'"},
+	EventReinstate: {[]string{StateSuspended}, restoredState},
+}
+
+// Events are all the events that transition users between states.
+var Events = []string{EventConfirm, EventLock, EventUnlock, EventSuspend, EventReinstate}
+
+// IsValidEvent returns whether event is one of Events.
+func IsValidEvent(event string) bool {
+	_, ok := transitions[event]
+	return ok
+}
+
+// Transition transitions the user to the state that event leads to from the
+// current state, and reloads the user. It returns ErrInvalidTransition if the
+// event is not valid in the current state. Confirming a user also confirms
+// their email address.
+func (u *User) Transition(db *gorm.DB, event string) error {
+	t, ok := transitions[event]
+	if !ok {
+		return ErrInvalidTransition
+	}
+
+	confirmedAt := "confirmed_at"
+	if event == EventConfirm {
+		confirmedAt = "COALESCE(confirmed_at, now())"
+	}
+
+	err := db.Raw(`UPDATE users
+		SET
+			state = `+t.to+`,
+			confirmed_at = `+confirmedAt+`
+		WHERE id = ? AND state IN (?)
+		RETURNING *;`, u.ID, t.from).Scan(u).Error
+	if err == gorm.RecordNotFound {
+		return ErrInvalidTransition
+	}
+	return err
+}
+
+// CheckActive returns an error describing why the user cannot use their
+// account, or nil if they are confirmed.
+func (u *User) CheckActive() error {
+	switch u.State {
+	case StateConfirmed:
+		return nil
+	case StateLocked:
+		return ErrLocked
+	case StateSuspended:
+		return ErrSuspended
+	}
+	return ErrUnconfirmed
+}
+
+// PendingActions returns the actions that the user has to take before they
+// can use their account again.
+func (u *User) PendingActions() []string {
+	switch u.State {
+	case StateConfirmed:
+		return []string{}
+	case StateLocked:
+		return []string{ActionResetPassword}
+	case StateSuspended:
+		return []string{ActionContactSupport}
+	}
+	return []string{ActionConfirmEmail}
+}
@@ -5,14 +5,34 @@ import (
 	"encoding/base64"
 	"errors"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/jinzhu/gorm"
 	"github.com/lib/pq"
+	"github.com/nitrous-io/rise-server/shared/emails"
+	"github.com/nitrous-io/rise-server/shared/s3client"
 )
 
 var (
 	emailRe = regexp.MustCompile(`\A[^@\s]+@([^@\s]+\.)+[^@\s]+\z`)
+
+	// ConfirmationCodeExpiry is how long a confirmation code is valid for
+	// after it is generated.
+	ConfirmationCodeExpiry = 24 * time.Hour
+
+	// ConfirmationResendInterval is the minimum interval between confirmation
+	// codes being (re)sent to a user.
+	ConfirmationResendInterval = 1 * time.Minute
+
+	// MaxConfirmationSendsPerDay is the maximum number of times a
+	// confirmation code can be (re)sent to a user in a day.
+	MaxConfirmationSendsPerDay = 5
+
+	// PasswordResetTokenExpiry is how long a password reset token is kept
+	// after it is generated before it is cleared by
+	// ClearExpiredPasswordResetTokens.
+	PasswordResetTokenExpiry = 24 * time.Hour
 )
 
 // Errors returned from this package.
@@ -20,6 +40,7 @@ var (
 	ErrEmailTaken                  = errors.New("email is taken")
 	ErrPasswordResetTokenRequired  = errors.New("password reset token is required")
 	ErrPasswordResetTokenIncorrect = errors.New("password reset token incorrect")
+	ErrConfirmationCodeExpired     = errors.New("confirmation code has expired")
 )
 
 // User is a database model representing a user account,
@@ -31,11 +52,31 @@ type User struct {
 	Name         string
 	Organization string
 
-	ConfirmationCode string `sql:"default:lpad((floor(random() * 999999) + 1)::text, 6, '0')"`
-	ConfirmedAt      *time.Time
+	ConfirmationCode          string    `sql:"default:lpad((floor(random() * 999999) + 1)::text, 6, '0')"`
+	ConfirmationCodeCreatedAt time.Time `sql:"default:now()"`
+	ConfirmationSentAt        *time.Time
+	ConfirmedAt               *time.Time
+
+	// State is the state of the user's account, e.g. StateConfirmed. Use
+	// Transition() to change it.
+	State string `sql:"default:'unconfirmed'"`
+
+	// ConfirmationSendCount is the number of times a confirmation code has
+	// been sent to the user in the day since ConfirmationSendCountSince.
+	ConfirmationSendCount      int
+	ConfirmationSendCountSince *time.Time
 
 	PasswordResetToken          string
 	PasswordResetTokenCreatedAt *time.Time
+
+	// Timezone (e.g. "Asia/Singapore") and Locale (e.g. "en-US") are used to
+	// render dates and times in emails to the user.
+	Timezone string `sql:"default:'UTC'"`
+	Locale   string `sql:"default:'en'"`
+
+	// MaxUploadSize overrides s3client.MaxUploadSize for users on plans with
+	// different limits. It applies to the projects owned by the user.
+	MaxUploadSize *int64
 }
 
 // AsJSON returns a struct that can be converted to JSON
@@ -44,11 +85,58 @@ func (u *User) AsJSON() interface{} {
 		Email        string `json:"email"`
 		Name         string `json:"name"`
 		Organization string `json:"organization"`
+		Timezone     string `json:"timezone"`
+		Locale       string `json:"locale"`
 	}{
 		u.Email,
 		u.Name,
 		u.Organization,
+		u.EmailPrefs().Timezone,
+		u.EmailPrefs().Locale,
+	}
+}
+
+// EmailPrefs returns the preferences that emails to the user are rendered
+// with.
+func (u *User) EmailPrefs() emails.Prefs {
+	p := emails.Prefs{Timezone: u.Timezone, Locale: u.Locale}
+	if p.Timezone == "" {
+		p.Timezone = emails.DefaultTimezone
+	}
+	if p.Locale == "" {
+		p.Locale = emails.DefaultLocale
 	}
+	return p
+}
+
+// ValidatePreferences validates the timezone and locale of the user. It
+// returns a map of <field, errors>, or nil if they are valid.
+func (u *User) ValidatePreferences() map[string]string {
+	errors := map[string]string{}
+
+	// time.LoadLocation also accepts "" and "Local", which are not time
+	// zones.
+	if _, err := time.LoadLocation(u.Timezone); err != nil || u.Timezone == "" || u.Timezone == "Local" {
+		errors["timezone"] = "is not a known time zone"
+	}
+
+	if !emails.IsSupportedLocale(u.Locale) {
+		errors["locale"] = "must be one of " + strings.Join(emails.Locales(), ", ")
+	}
+
+	if len(errors) == 0 {
+		return nil
+	}
+	return errors
+}
+
+// UploadSizeLimit returns the maximum size in bytes of a bundle that can be
+// uploaded to deploy a project owned by the user.
+func (u *User) UploadSizeLimit() int64 {
+	if u.MaxUploadSize != nil {
+		return *u.MaxUploadSize
+	}
+	return s3client.MaxUploadSize
 }
 
 // Validate validates User, if there are invalid fields, it returns a map of
@@ -116,6 +204,17 @@ func (u *User) GeneratePasswordResetToken(db *gorm.DB) error {
 	}).Error
 }
 
+// ClearExpiredPasswordResetTokens clears password reset tokens that were
+// generated more than PasswordResetTokenExpiry ago, so that they can no longer
+// be used, and returns the number of tokens cleared.
+func ClearExpiredPasswordResetTokens(db *gorm.DB) (int64, error) {
+	q := db.Exec(`UPDATE users
+		SET password_reset_token = NULL, password_reset_token_created_at = NULL
+		WHERE password_reset_token IS NOT NULL AND password_reset_token_created_at < ?;`,
+		time.Now().Add(-PasswordResetTokenExpiry))
+	return q.RowsAffected, q.Error
+}
+
 // ResetPassword attempts to set a user's password to the new password. The
 // resetToken must not be empty, and must match the password reset token. If
 // successful, the password reset token will be cleared (so that the token
@@ -129,11 +228,13 @@ func (u *User) ResetPassword(db *gorm.DB, newPassword, resetToken string) error
 
 	// TODO We should check password_reset_token_created_at.
 
+	// Resetting the password unlocks locked users.
 	q := db.Raw(`UPDATE users
         SET
             encrypted_password = crypt(?, gen_salt('bf')),
             password_reset_token = NULL,
-            password_reset_token_created_at = NULL
+            password_reset_token_created_at = NULL,
+            state = CASE WHEN state = '`+StateLocked+`' THEN `+restoredState+` ELSE state END
         WHERE id = ? AND password_reset_token = ?
         RETURNING *;`, newPassword, u.ID, resetToken).Scan(u)
 
@@ -163,22 +264,130 @@ func Authenticate(db *gorm.DB, email, password string) (*User, error) {
 	return u, nil
 }
 
-// Confirm finds user by email and confirmation code and confirms user if found
+// Confirm finds user by email and confirmation code and confirms user if found.
+// It returns ErrConfirmationCodeExpired if the confirmation code is correct but
+// has expired. Users who are locked or suspended have their email address
+// confirmed, but stay in their state.
 func Confirm(db *gorm.DB, email, confirmationCode string) (confirmed bool, err error) {
 	q := db.Model(User{}).Where(
-		"email = ? AND confirmation_code = ? AND confirmed_at IS NULL", email, confirmationCode,
-	).Update("confirmed_at", gorm.Expr("now()"))
+		"email = ? AND confirmation_code = ? AND confirmed_at IS NULL AND confirmation_code_created_at > now() - (? * interval '1 second')",
+		email, confirmationCode, int64(ConfirmationCodeExpiry/time.Second),
+	).Updates(map[string]interface{}{
+		"confirmed_at": gorm.Expr("now()"),
+		"state":        gorm.Expr("CASE WHEN state = ? THEN ? ELSE state END", StateUnconfirmed, StateConfirmed),
+	})
 	if err = q.Error; err != nil {
 		return false, err
 	}
 
 	if q.RowsAffected == 0 {
+		var count int
+		if err := db.Model(User{}).Where(
+			"email = ? AND confirmation_code = ? AND confirmed_at IS NULL", email, confirmationCode,
+		).Count(&count).Error; err != nil {
+			return false, err
+		}
+
+		if count > 0 {
+			return false, ErrConfirmationCodeExpired
+		}
 		return false, nil
 	}
 
 	return true, nil
 }
 
+// ConfirmationCodeExpired returns whether the user's confirmation code can no
+// longer be used to confirm the user.
+func (u *User) ConfirmationCodeExpired() bool {
+	return time.Since(u.ConfirmationCodeCreatedAt) >= ConfirmationCodeExpiry
+}
+
+// RegenerateConfirmationCode replaces the user's confirmation code with a new
+// one, which expires after ConfirmationCodeExpiry.
+func (u *User) RegenerateConfirmationCode(db *gorm.DB) error {
+	return db.Raw(`UPDATE users
+		SET
+			confirmation_code = lpad((floor(random() * 999999) + 1)::text, 6, '0'),
+			confirmation_code_created_at = now()
+		WHERE id = ?
+		RETURNING *;`, u.ID).Scan(u).Error
+}
+
+// MarkConfirmationSent records that the confirmation code was sent to the user
+// now. It returns false without updating anything if the code was already sent
+// within ConfirmationResendInterval, or MaxConfirmationSendsPerDay times in the
+// past day.
+func (u *User) MarkConfirmationSent(db *gorm.DB) (bool, error) {
+	q := db.Exec(`UPDATE users
+		SET
+			confirmation_sent_at = now(),
+			confirmation_send_count = CASE
+				WHEN confirmation_send_count_since IS NULL OR confirmation_send_count_since <= now() - interval '1 day' THEN 1
+				ELSE confirmation_send_count + 1
+			END,
+			confirmation_send_count_since = CASE
+				WHEN confirmation_send_count_since IS NULL OR confirmation_send_count_since <= now() - interval '1 day' THEN now()
+				ELSE confirmation_send_count_since
+			END
+		WHERE id = ? AND (
+			confirmation_sent_at IS NULL OR
+			confirmation_sent_at <= now() - (? * interval '1 second')
+		) AND (
+			confirmation_send_count_since IS NULL OR
+			confirmation_send_count_since <= now() - interval '1 day' OR
+			confirmation_send_count < ?
+		);`, u.ID, int64(ConfirmationResendInterval/time.Second), MaxConfirmationSendsPerDay)
+	if err := q.Error; err != nil {
+		return false, err
+	}
+
+	return q.RowsAffected > 0, nil
+}
+
+// ConfirmationThrottle describes why a confirmation code cannot be sent to a
+// user right now.
+type ConfirmationThrottle struct {
+	// DailyLimitReached is whether MaxConfirmationSendsPerDay codes have been
+	// sent in the past day, as opposed to one within
+	// ConfirmationResendInterval.
+	DailyLimitReached bool
+	// RetryAfter is how long until a code can be sent again.
+	RetryAfter time.Duration
+}
+
+// ConfirmationThrottle returns why a confirmation code cannot be sent to the
+// user right now. It is meant to be called after MarkConfirmationSent returns
+// false.
+func (u *User) ConfirmationThrottle(db *gorm.DB) (*ConfirmationThrottle, error) {
+	var (
+		t          ConfirmationThrottle
+		retryAfter int64
+	)
+	err := db.Raw(`SELECT
+			daily_limit_reached,
+			CEIL(GREATEST(0, EXTRACT(EPOCH FROM (
+				CASE
+					WHEN daily_limit_reached THEN confirmation_send_count_since + interval '1 day'
+					ELSE COALESCE(confirmation_sent_at + (? * interval '1 second'), now())
+				END
+			) - now())))::bigint
+		FROM (
+			SELECT
+				confirmation_sent_at,
+				confirmation_send_count_since,
+				confirmation_send_count >= ? AND confirmation_send_count_since > now() - interval '1 day' AS daily_limit_reached
+			FROM users
+			WHERE id = ?
+		) u;`, int64(ConfirmationResendInterval/time.Second), MaxConfirmationSendsPerDay, u.ID).Row().Scan(&t.DailyLimitReached, &retryAfter)
+	if err != nil {
+		return nil, err
+	}
+
+	t.RetryAfter = time.Duration(retryAfter) * time.Second
+	return &t, nil
+}
+
 // FindByEmail returns the user with the given email
 func FindByEmail(db *gorm.DB, email string) (u *User, err error) {
 	u = &User{}
@@ -3,10 +3,13 @@ package user_test
 import (
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/jinzhu/gorm"
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
 	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/shared/emails"
+	"github.com/nitrous-io/rise-server/shared/s3client"
 	"github.com/nitrous-io/rise-server/testhelper"
 
 	. "github.com/onsi/ginkgo"
@@ -194,6 +197,143 @@ var _ = Describe("User", func() {
 				Expect(u2).NotTo(BeNil())
 				Expect(u2.ID).To(Equal(u.ID))
 			})
+
+			Context("when the user is locked", func() {
+				BeforeEach(func() {
+					Expect(u.Transition(db, user.EventConfirm)).To(Succeed())
+					Expect(u.Transition(db, user.EventLock)).To(Succeed())
+				})
+
+				It("unlocks the user", func() {
+					Expect(u.ResetPassword(db, "new-password", u.PasswordResetToken)).To(Succeed())
+					Expect(u.State).To(Equal(user.StateConfirmed))
+				})
+			})
+		})
+	})
+
+	Describe("Transition()", func() {
+		BeforeEach(func() {
+			u = &user.User{
+				Email:    "harry.potter@gmail.com",
+				Password: "123456",
+			}
+			Expect(u.Insert(db)).To(BeNil())
+			Expect(u.State).To(Equal(user.StateUnconfirmed))
+		})
+
+		It("confirms the user and their email address", func() {
+			Expect(u.Transition(db, user.EventConfirm)).To(Succeed())
+			Expect(u.State).To(Equal(user.StateConfirmed))
+			Expect(u.ConfirmedAt).NotTo(BeNil())
+
+			Expect(db.First(u, u.ID).Error).To(BeNil())
+			Expect(u.State).To(Equal(user.StateConfirmed))
+		})
+
+		It("returns locked and suspended users to the state they were in", func() {
+			Expect(u.Transition(db, user.EventLock)).To(Succeed())
+			Expect(u.State).To(Equal(user.StateLocked))
+			Expect(u.Transition(db, user.EventUnlock)).To(Succeed())
+			Expect(u.State).To(Equal(user.StateUnconfirmed))
+
+			Expect(u.Transition(db, user.EventConfirm)).To(Succeed())
+			Expect(u.Transition(db, user.EventSuspend)).To(Succeed())
+			Expect(u.State).To(Equal(user.StateSuspended))
+			Expect(u.Transition(db, user.EventReinstate)).To(Succeed())
+			Expect(u.State).To(Equal(user.StateConfirmed))
+		})
+
+		It("returns ErrInvalidTransition if the event is not valid in the current state", func() {
+			Expect(u.Transition(db, user.EventUnlock)).To(Equal(user.ErrInvalidTransition))
+			Expect(u.Transition(db, user.EventReinstate)).To(Equal(user.ErrInvalidTransition))
+			Expect(u.Transition(db, "delete")).To(Equal(user.ErrInvalidTransition))
+
+			Expect(u.Transition(db, user.EventSuspend)).To(Succeed())
+			Expect(u.Transition(db, user.EventConfirm)).To(Equal(user.ErrInvalidTransition))
+			Expect(u.Transition(db, user.EventLock)).To(Equal(user.ErrInvalidTransition))
+
+			Expect(db.First(u, u.ID).Error).To(BeNil())
+			Expect(u.State).To(Equal(user.StateSuspended))
+		})
+	})
+
+	Describe("CheckActive()", func() {
+		It("returns why the user cannot use their account with the actions they have to take", func() {
+			for _, c := range []struct {
+				state  string
+				err    error
+				action string
+			}{
+				{user.StateUnconfirmed, user.ErrUnconfirmed, user.ActionConfirmEmail},
+				{user.StateLocked, user.ErrLocked, user.ActionResetPassword},
+				{user.StateSuspended, user.ErrSuspended, user.ActionContactSupport},
+			} {
+				u := &user.User{State: c.state}
+				Expect(u.CheckActive()).To(Equal(c.err))
+				Expect(u.PendingActions()).To(Equal([]string{c.action}))
+			}
+
+			u := &user.User{State: user.StateConfirmed}
+			Expect(u.CheckActive()).To(BeNil())
+			Expect(u.PendingActions()).To(BeEmpty())
+		})
+	})
+
+	Describe("UploadSizeLimit()", func() {
+		It("returns the upload size limit of the plan of the user", func() {
+			u := &user.User{}
+			Expect(u.UploadSizeLimit()).To(Equal(s3client.MaxUploadSize))
+
+			maxSize := int64(5 * 1024 * 1024 * 1024)
+			u.MaxUploadSize = &maxSize
+			Expect(u.UploadSizeLimit()).To(Equal(maxSize))
+		})
+	})
+
+	Describe("ValidatePreferences()", func() {
+		It("returns errors for unknown time zones and unsupported locales", func() {
+			u := &user.User{Timezone: "America/New_York", Locale: "en-US"}
+			Expect(u.ValidatePreferences()).To(BeNil())
+
+			u = &user.User{Timezone: "Local", Locale: "fr"}
+			Expect(u.ValidatePreferences()).To(Equal(map[string]string{
+				"timezone": "is not a known time zone",
+				"locale":   "must be one of en, en-GB, en-US",
+			}))
+		})
+	})
+
+	Describe("EmailPrefs()", func() {
+		It("returns the preferences of the user, with defaults for unset ones", func() {
+			u := &user.User{Timezone: "Asia/Singapore"}
+			Expect(u.EmailPrefs()).To(Equal(emails.Prefs{Timezone: "Asia/Singapore", Locale: "en"}))
+		})
+	})
+
+	Describe("ClearExpiredPasswordResetTokens()", func() {
+		var u1, u2 *user.User
+
+		BeforeEach(func() {
+			u1 = &user.User{Email: "harry.potter@gmail.com", Password: "123456"}
+			Expect(u1.Insert(db)).To(BeNil())
+			Expect(u1.GeneratePasswordResetToken(db)).To(BeNil())
+
+			u2 = &user.User{Email: "ron.weasley@gmail.com", Password: "123456"}
+			Expect(u2.Insert(db)).To(BeNil())
+			Expect(u2.GeneratePasswordResetToken(db)).To(BeNil())
+
+			Expect(db.Exec("UPDATE users SET password_reset_token_created_at = ? WHERE id = ?",
+				time.Now().Add(-user.PasswordResetTokenExpiry-time.Minute), u1.ID).Error).To(BeNil())
+		})
+
+		It("clears password reset tokens that have expired", func() {
+			n, err := user.ClearExpiredPasswordResetTokens(db)
+			Expect(err).To(BeNil())
+			Expect(n).To(Equal(int64(1)))
+
+			Expect(u1.ResetPassword(db, "new-password", u1.PasswordResetToken)).To(Equal(user.ErrPasswordResetTokenIncorrect))
+			Expect(u2.ResetPassword(db, "new-password", u2.PasswordResetToken)).To(BeNil())
 		})
 	})
 
@@ -251,6 +391,23 @@ var _ = Describe("User", func() {
 
 				Expect(u.ConfirmedAt).NotTo(BeNil())
 				Expect(u.ConfirmedAt.Unix()).NotTo(BeZero())
+				Expect(u.State).To(Equal(user.StateConfirmed))
+			})
+
+			Context("when the user is locked", func() {
+				BeforeEach(func() {
+					Expect(u.Transition(db, user.EventLock)).To(Succeed())
+				})
+
+				It("confirms the email address of the user without unlocking them", func() {
+					confirmed, err := user.Confirm(db, u.Email, u.ConfirmationCode)
+					Expect(confirmed).To(BeTrue())
+					Expect(err).To(BeNil())
+
+					Expect(db.First(u, u.ID).Error).To(BeNil())
+					Expect(u.ConfirmedAt).NotTo(BeNil())
+					Expect(u.State).To(Equal(user.StateLocked))
+				})
 			})
 		})
 
@@ -289,6 +446,141 @@ var _ = Describe("User", func() {
 				Expect(u.ConfirmedAt.Unix()).To(Equal(prevConfirmedAt.Unix()))
 			})
 		})
+
+		Context("when the confirmation code has expired", func() {
+			BeforeEach(func() {
+				err = db.Exec("UPDATE users SET confirmation_code_created_at = now() - interval '25 hours' WHERE id = ?", u.ID).Error
+				Expect(err).To(BeNil())
+			})
+
+			It("returns ErrConfirmationCodeExpired and does not confirm user", func() {
+				confirmed, err := user.Confirm(db, u.Email, u.ConfirmationCode)
+				Expect(confirmed).To(BeFalse())
+				Expect(err).To(Equal(user.ErrConfirmationCodeExpired))
+
+				err = db.First(u, u.ID).Error
+				Expect(err).To(BeNil())
+
+				Expect(u.ConfirmedAt).To(BeNil())
+			})
+		})
+	})
+
+	Describe("RegenerateConfirmationCode()", func() {
+		BeforeEach(func() {
+			u = &user.User{
+				Email:    "harry.potter@gmail.com",
+				Password: "123456",
+			}
+			err = u.Insert(db)
+			Expect(err).To(BeNil())
+
+			err = db.Exec("UPDATE users SET confirmation_code = 'old', confirmation_code_created_at = now() - interval '25 hours' WHERE id = ?", u.ID).Error
+			Expect(err).To(BeNil())
+			Expect(db.First(u, u.ID).Error).To(BeNil())
+			Expect(u.ConfirmationCodeExpired()).To(BeTrue())
+		})
+
+		It("generates a new confirmation code that has not expired", func() {
+			Expect(u.RegenerateConfirmationCode(db)).To(BeNil())
+			Expect(u.ConfirmationCode).To(HaveLen(6))
+			Expect(u.ConfirmationCodeExpired()).To(BeFalse())
+
+			confirmed, err := user.Confirm(db, u.Email, u.ConfirmationCode)
+			Expect(confirmed).To(BeTrue())
+			Expect(err).To(BeNil())
+		})
+	})
+
+	Describe("MarkConfirmationSent()", func() {
+		BeforeEach(func() {
+			u = &user.User{
+				Email:    "harry.potter@gmail.com",
+				Password: "123456",
+			}
+			err = u.Insert(db)
+			Expect(err).To(BeNil())
+		})
+
+		It("returns false if the confirmation code was sent recently", func() {
+			ok, err := u.MarkConfirmationSent(db)
+			Expect(err).To(BeNil())
+			Expect(ok).To(BeTrue())
+
+			ok, err = u.MarkConfirmationSent(db)
+			Expect(err).To(BeNil())
+			Expect(ok).To(BeFalse())
+		})
+
+		It("returns true once the resend interval has passed", func() {
+			err = db.Exec("UPDATE users SET confirmation_sent_at = now() - interval '2 minutes' WHERE id = ?", u.ID).Error
+			Expect(err).To(BeNil())
+
+			ok, err := u.MarkConfirmationSent(db)
+			Expect(err).To(BeNil())
+			Expect(ok).To(BeTrue())
+		})
+
+		It("returns false once the code has been sent MaxConfirmationSendsPerDay times in a day", func() {
+			for i := 0; i < user.MaxConfirmationSendsPerDay; i++ {
+				ok, err := u.MarkConfirmationSent(db)
+				Expect(err).To(BeNil())
+				Expect(ok).To(BeTrue())
+
+				err = db.Exec("UPDATE users SET confirmation_sent_at = now() - interval '2 minutes' WHERE id = ?", u.ID).Error
+				Expect(err).To(BeNil())
+			}
+
+			ok, err := u.MarkConfirmationSent(db)
+			Expect(err).To(BeNil())
+			Expect(ok).To(BeFalse())
+
+			err = db.Exec("UPDATE users SET confirmation_send_count_since = now() - interval '25 hours' WHERE id = ?", u.ID).Error
+			Expect(err).To(BeNil())
+
+			ok, err = u.MarkConfirmationSent(db)
+			Expect(err).To(BeNil())
+			Expect(ok).To(BeTrue())
+
+			Expect(db.First(u, u.ID).Error).To(BeNil())
+			Expect(u.ConfirmationSendCount).To(Equal(1))
+		})
+	})
+
+	Describe("ConfirmationThrottle()", func() {
+		BeforeEach(func() {
+			u = &user.User{
+				Email:    "harry.potter@gmail.com",
+				Password: "123456",
+			}
+			err = u.Insert(db)
+			Expect(err).To(BeNil())
+		})
+
+		It("returns how long until the resend interval has passed", func() {
+			ok, err := u.MarkConfirmationSent(db)
+			Expect(err).To(BeNil())
+			Expect(ok).To(BeTrue())
+
+			t, err := u.ConfirmationThrottle(db)
+			Expect(err).To(BeNil())
+			Expect(t.DailyLimitReached).To(BeFalse())
+			Expect(t.RetryAfter).To(Equal(user.ConfirmationResendInterval))
+		})
+
+		It("returns how long until a day has passed when the daily limit is reached", func() {
+			err = db.Exec(`UPDATE users SET
+				confirmation_sent_at = now() - interval '2 minutes',
+				confirmation_send_count = ?,
+				confirmation_send_count_since = now() - interval '23 hours'
+				WHERE id = ?`, user.MaxConfirmationSendsPerDay, u.ID).Error
+			Expect(err).To(BeNil())
+
+			t, err := u.ConfirmationThrottle(db)
+			Expect(err).To(BeNil())
+			Expect(t.DailyLimitReached).To(BeTrue())
+			Expect(t.RetryAfter).To(Equal(time.Hour))
+		})
 	})
 
 	Describe("FindByEmail()", func() {
@@ -3,17 +3,27 @@ package routes
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/nitrous-io/rise-server/apiserver/controllers/acme"
+	"github.com/nitrous-io/rise-server/apiserver/controllers/apikeys"
+	"github.com/nitrous-io/rise-server/apiserver/controllers/apirequests"
+	"github.com/nitrous-io/rise-server/apiserver/controllers/blacklistednames"
 	"github.com/nitrous-io/rise-server/apiserver/controllers/certs"
+	"github.com/nitrous-io/rise-server/apiserver/controllers/deployhooks"
 	"github.com/nitrous-io/rise-server/apiserver/controllers/deployments"
 	"github.com/nitrous-io/rise-server/apiserver/controllers/domains"
+	"github.com/nitrous-io/rise-server/apiserver/controllers/edgeconfigs"
 	"github.com/nitrous-io/rise-server/apiserver/controllers/hooks"
+	"github.com/nitrous-io/rise-server/apiserver/controllers/jobs"
 	"github.com/nitrous-io/rise-server/apiserver/controllers/jsenvvars"
+	"github.com/nitrous-io/rise-server/apiserver/controllers/logdestinations"
+	"github.com/nitrous-io/rise-server/apiserver/controllers/lookup"
+	"github.com/nitrous-io/rise-server/apiserver/controllers/metrics"
 	"github.com/nitrous-io/rise-server/apiserver/controllers/oauth"
 	"github.com/nitrous-io/rise-server/apiserver/controllers/ping"
 	"github.com/nitrous-io/rise-server/apiserver/controllers/projects"
 	"github.com/nitrous-io/rise-server/apiserver/controllers/rawbundles"
 	"github.com/nitrous-io/rise-server/apiserver/controllers/repos"
 	"github.com/nitrous-io/rise-server/apiserver/controllers/root"
+	"github.com/nitrous-io/rise-server/apiserver/controllers/sso"
 	"github.com/nitrous-io/rise-server/apiserver/controllers/stats"
 	"github.com/nitrous-io/rise-server/apiserver/controllers/templates"
 	"github.com/nitrous-io/rise-server/apiserver/controllers/users"
@@ -27,28 +37,87 @@ func Draw(r *gin.Engine) {
 	}
 
 	r.Use(middleware.CORS)
+	r.Use(middleware.RequestContext)
+	r.Use(middleware.TrackingContext)
+	r.Use(middleware.AuditRequest)
 
 	r.GET("/", root.Root)
 	r.GET("/ping", ping.Ping)
 	r.POST("/users", users.Create)
 	r.POST("/user/confirm", users.Confirm)
 	r.POST("/user/confirm/resend", users.ResendConfirmationCode)
+	r.POST("/user/confirm/regenerate", users.RegenerateConfirmationCode)
 	r.POST("/user/password/forgot", users.ForgotPassword)
 	r.POST("/user/password/reset", users.ResetPassword)
+	// Not under /projects, as it would conflict with /projects/:project_name.
+	r.GET("/project_availability", projects.Availability)
 	r.POST("/oauth/token", oauth.CreateToken)
-	r.GET("/admin/stats", stats.Index)
+	r.POST("/oauth/device/code", oauth.CreateDeviceCode)
+	r.GET("/sso/login", sso.Login)
+	r.GET("/sso/callback", sso.Callback)
 
 	r.GET("/.well-known/acme-challenge/:token", acme.ChallengeResponse)
 
 	r.POST("/hooks/github/:path", hooks.GitHubPush)
+	r.POST("/hooks/gitlab/:path", hooks.GitLabPush)
+	r.POST("/hooks/bitbucket/:path", hooks.BitbucketPush)
 
-	{ // Routes that require a OAuth Token
-		authorized := r.Group("", middleware.RequireToken)
-		authorized.DELETE("/oauth/token", oauth.DestroyToken)
-		authorized.POST("/projects", projects.Create)
+	{ // Admin routes, which require the admin token instead of a user's token
+		admin := r.Group("/admin", middleware.RequireAdminToken)
+		admin.GET("/stats", stats.Index)
+		admin.GET("/jobs", jobs.Index)
+		admin.GET("/jobs/:id", jobs.Show)
+		admin.GET("/sso_connections", sso.ListConnections)
+		admin.POST("/sso_connections", sso.CreateConnection)
+		admin.DELETE("/sso_connections/:id", sso.DestroyConnection)
+		admin.GET("/domains/:name", lookup.Domain)
+		admin.GET("/projects/:name", lookup.Project)
+		admin.GET("/edge_configs", edgeconfigs.Index)
+		admin.POST("/edge_configs", edgeconfigs.Create)
+		admin.GET("/edges", edgeconfigs.Edges)
+		admin.GET("/blacklisted_names", blacklistednames.Index)
+		admin.POST("/blacklisted_names", blacklistednames.Create)
+		admin.DELETE("/blacklisted_names/:id", blacklistednames.Destroy)
+		admin.GET("/api_requests", apirequests.Index)
+		admin.POST("/users/:email/transitions", users.Transition)
+		admin.GET("/metrics", metrics.Index)
+		admin.GET("/domains", domains.ListFailing)
+		admin.POST("/domains/:name/reverify", domains.Reverify)
+		admin.POST("/domains/:name/disable", domains.Disable)
+		admin.POST("/domains/:name/enable", domains.Enable)
+	}
+
+	{ // Routes that require a OAuth Token, so that API keys cannot be used to
+		// manage credentials
+		tokenOnly := r.Group("", middleware.RequireToken)
+		tokenOnly.DELETE("/oauth/token", oauth.DestroyToken)
+		tokenOnly.GET("/oauth/tokens", oauth.ListTokens)
+		tokenOnly.POST("/oauth/tokens", middleware.RequireActiveUser, oauth.CreateNamedToken)
+		tokenOnly.DELETE("/oauth/tokens/:id", oauth.RevokeToken)
+		tokenOnly.POST("/oauth/deploy_tokens", middleware.RequireActiveUser, oauth.CreateDeployToken)
+		tokenOnly.POST("/oauth/device/approve", middleware.RequireActiveUser, oauth.ApproveDeviceCode)
+		tokenOnly.POST("/oauth/device/deny", oauth.DenyDeviceCode)
+		tokenOnly.PUT("/user", users.Update)
+		tokenOnly.GET("/api_keys", apikeys.Index)
+		tokenOnly.POST("/api_keys", middleware.RequireActiveUser, apikeys.Create)
+		tokenOnly.DELETE("/api_keys/:id", apikeys.Destroy)
+
+		{ // Routes that only project owners can access
+			projOwner := tokenOnly.Group("/projects/:project_name", middleware.RequireProject)
+
+			projOwner.GET("/api_keys", apikeys.IndexForProject)
+			projOwner.POST("/api_keys", middleware.RequireActiveUser, apikeys.CreateForProject)
+			projOwner.DELETE("/api_keys/:id", apikeys.DestroyForProject)
+		}
+	}
+
+	{ // Routes that require a OAuth Token or a request signed with an API key
+		authorized := r.Group("", middleware.RequireTokenOrSignature)
+		authorized.POST("/projects", middleware.RequireActiveUser, projects.Create)
 		authorized.GET("/projects", projects.Index)
+		// Not under /projects, as it would conflict with /projects/:project_name.
+		authorized.POST("/project_batches", middleware.RequireActiveUser, projects.CreateBatch)
 		authorized.GET("/user", users.Show)
-		authorized.PUT("/user", users.Update)
 		authorized.GET("/templates", templates.Index)
 		authorized.GET("/domains", domains.DomainsByUser)
 
@@ -57,12 +126,22 @@ func Draw(r *gin.Engine) {
 
 			projCollab.GET("", projects.Get)
 			projCollab.GET("/deployments/:id/download", deployments.Download)
+			projCollab.GET("/deployments/:id/files/*path", deployments.ShowFile)
+			projCollab.GET("/deployments/:id/report", deployments.ShowReport)
+			projCollab.GET("/deployments/:id/log", deployments.ShowLog)
+			projCollab.GET("/deployments/:id/events", deployments.Events)
 			projCollab.GET("/deployments/:id", deployments.Show)
+			projCollab.PUT("/deployments/:id/note", deployments.UpdateNote)
+			projCollab.POST("/deployments/:id/pin", deployments.Pin)
+			projCollab.DELETE("/deployments/:id/pin", deployments.Unpin)
+			projCollab.GET("/deployments/:id/parts", deployments.ListParts)
 			projCollab.GET("/deployments", deployments.Index)
 			projCollab.GET("repos", repos.Show)
 			projCollab.POST("/repos", repos.Link)
 			projCollab.DELETE("/repos", repos.Unlink)
 			projCollab.GET("/domains", domains.Index)
+			projCollab.GET("/domains/:name/dns", domains.ShowDNS)
+			projCollab.GET("/domains/:name/quota", domains.ShowQuota)
 			projCollab.GET("/collaborators", projects.ListCollaborators)
 			projCollab.GET("/domains/:name/cert", certs.Show)
 			projCollab.POST("/domains/:name/cert", certs.Create)
@@ -70,18 +149,37 @@ func Draw(r *gin.Engine) {
 			projCollab.DELETE("/domains/:name/cert", certs.Destroy)
 			projCollab.GET("/raw_bundles/:bundle_checksum", rawbundles.Get)
 			projCollab.GET("/jsenvvars", jsenvvars.Index)
+			projCollab.GET("/deployments/:id/jsenvvars/diff", jsenvvars.Diff)
+			projCollab.GET("/stats", projects.Stats)
+			projCollab.GET("/lock", projects.ShowLock)
+			projCollab.GET("/freeze", projects.ShowFreeze)
+			projCollab.GET("/security_headers", projects.ShowSecurityHeaders)
+			projCollab.GET("/content_types", projects.ShowContentTypes)
+			projCollab.GET("/language_redirects", projects.ShowLanguageRedirects)
+			projCollab.GET("/deployment_defaults", projects.ShowDeploymentDefaults)
 
 			{ // Routes that lock a project
 				lock := projCollab.Group("", middleware.LockProject)
 				lock.PUT("", projects.Update)
-				lock.POST("/deployments", deployments.Create)
+				lock.POST("/deployments", middleware.RequireActiveUser, middleware.RejectFrozenDeploys, deployments.Create)
+				lock.POST("/deployments/:id/complete", middleware.RequireActiveUser, deployments.CompleteUpload)
+				lock.PUT("/deployments/:id/parts/:number", middleware.RequireActiveUser, deployments.UploadPart)
+				lock.POST("/deployment_uploads", middleware.RequireActiveUser, middleware.RejectFrozenDeploys, deployments.CreateUpload)
+				lock.POST("/import/:provider", middleware.RequireActiveUser, middleware.RejectFrozenDeploys, deployments.Import)
 				lock.POST("/domains", domains.Create)
 				lock.DELETE("/domains/:name", domains.Destroy)
-				lock.POST("/rollback", deployments.Rollback)
+				lock.PUT("/domains/:name/tls_policy", domains.UpdateTLSPolicy)
+				lock.PUT("/domains/:name/quota", domains.UpdateQuota)
+				lock.POST("/rollback", middleware.RequireActiveUser, middleware.RejectFrozenDeploys, deployments.Rollback)
+				lock.POST("/deployments/:id/promote", middleware.RequireActiveUser, middleware.RejectFrozenDeploys, deployments.Promote)
 				lock.POST("/auth", projects.CreateAuth)
 				lock.DELETE("/auth", projects.DeleteAuth)
-				lock.PUT("/jsenvvars/add", jsenvvars.Add)
-				lock.PUT("/jsenvvars/delete", jsenvvars.Delete)
+				lock.PUT("/security_headers", projects.UpdateSecurityHeaders)
+				lock.PUT("/content_types", projects.UpdateContentTypes)
+				lock.PUT("/language_redirects", projects.UpdateLanguageRedirects)
+				lock.PUT("/deployment_defaults", projects.UpdateDeploymentDefaults)
+				lock.PUT("/jsenvvars/add", middleware.RequireActiveUser, middleware.RejectFrozenDeploys, jsenvvars.Add)
+				lock.PUT("/jsenvvars/delete", middleware.RequireActiveUser, middleware.RejectFrozenDeploys, jsenvvars.Delete)
 			}
 		}
 
@@ -90,10 +188,22 @@ func Draw(r *gin.Engine) {
 
 			projOwner.POST("/collaborators", projects.AddCollaborator)
 			projOwner.DELETE("/collaborators/:email", projects.RemoveCollaborator)
+			projOwner.GET("/log_destination", logdestinations.Show)
+			projOwner.PUT("/log_destination", logdestinations.Update)
+			projOwner.DELETE("/log_destination", logdestinations.Destroy)
+			projOwner.GET("/deploy_hooks", deployhooks.Index)
+			projOwner.POST("/deploy_hooks", deployhooks.Create)
+			projOwner.DELETE("/deploy_hooks/:id", deployhooks.Destroy)
+			projOwner.GET("/deploy_hooks/:id/deliveries", deployhooks.Deliveries)
+			projOwner.POST("/deploy_hooks/:id/deliveries/:delivery_id/replay", deployhooks.Replay)
+			projOwner.DELETE("/lock", projects.ForceUnlock)
+			projOwner.POST("/freeze", projects.Freeze)
+			projOwner.DELETE("/freeze", projects.Unfreeze)
 
 			{ // Routes that lock a project
 				lock := projOwner.Group("", middleware.LockProject)
 				lock.DELETE("", projects.Destroy) // DELETE /projects/:project_name
+				lock.POST("/prefix/regenerate", deployments.RegeneratePrefix)
 			}
 		}
 	}
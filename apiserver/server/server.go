@@ -1,12 +1,174 @@
 package server
 
 import (
+	"crypto/tls"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
 	"github.com/gin-gonic/gin"
+	"github.com/nitrous-io/rise-server/apiserver/controllers/deployments"
 	"github.com/nitrous-io/rise-server/apiserver/routes"
 )
 
+// streamMargin is how long before the write timeout streaming responses are
+// ended, so that they end cleanly instead of being cut off.
+const streamMargin = 10 * time.Second
+
 func New() *gin.Engine {
 	r := gin.New()
 	routes.Draw(r)
 	return r
 }
+
+// Config configures the http.Server that serves the API.
+type Config struct {
+	Addr string
+
+	// ReadHeaderTimeout is how long a client may take to send request
+	// headers, so that slow clients cannot hold connections open.
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout is how long a client may take to send a whole request. It
+	// has to allow for bundle uploads, which can be up to 1 GiB.
+	ReadTimeout time.Duration
+	// WriteTimeout is how long a response may take to be written, counted
+	// from when the request headers are read. Streaming responses, i.e.
+	// deployment events and long polls, are shortened to end before it, and
+	// clients reconnect when they end.
+	WriteTimeout time.Duration
+	// IdleTimeout is how long a keep-alive connection is kept open between
+	// requests.
+	IdleTimeout time.Duration
+
+	MaxHeaderBytes int
+
+	// The server serves HTTPS if both TLSCertFile and TLSKeyFile are set.
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// HTTP2 is whether HTTP/2 is served. HTTP/2 is only served over HTTPS.
+	HTTP2 bool
+}
+
+// DefaultConfig returns the Config the API is served with unless it is
+// overridden with environment variables.
+func DefaultConfig() *Config {
+	return &Config{
+		Addr:              ":3000",
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       10 * time.Minute,
+		WriteTimeout:      10 * time.Minute,
+		IdleTimeout:       2 * time.Minute,
+		MaxHeaderBytes:    64 * 1024,
+		HTTP2:             true,
+	}
+}
+
+// ConfigFromEnv returns DefaultConfig() overridden with the HTTP_*
+// environment variables. Invalid values are ignored.
+func ConfigFromEnv() *Config {
+	cfg := DefaultConfig()
+
+	if addr := os.Getenv("HTTP_ADDR"); addr != "" {
+		cfg.Addr = addr
+	}
+
+	for name, d := range map[string]*time.Duration{
+		"HTTP_READ_HEADER_TIMEOUT": &cfg.ReadHeaderTimeout,
+		"HTTP_READ_TIMEOUT":        &cfg.ReadTimeout,
+		"HTTP_WRITE_TIMEOUT":       &cfg.WriteTimeout,
+		"HTTP_IDLE_TIMEOUT":        &cfg.IdleTimeout,
+	} {
+		if v := os.Getenv(name); v != "" {
+			parsed, err := time.ParseDuration(v)
+			if err != nil || parsed < 0 {
+				log.Warnf("Ignoring %s, not a valid duration!", name)
+				continue
+			}
+			*d = parsed
+		}
+	}
+
+	if v := os.Getenv("HTTP_MAX_HEADER_BYTES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			log.Warn("Ignoring HTTP_MAX_HEADER_BYTES, not a valid number of bytes!")
+		} else {
+			cfg.MaxHeaderBytes = n
+		}
+	}
+
+	cfg.TLSCertFile = os.Getenv("HTTP_TLS_CERT_FILE")
+	cfg.TLSKeyFile = os.Getenv("HTTP_TLS_KEY_FILE")
+
+	if v := os.Getenv("HTTP2_ENABLED"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			log.Warn("Ignoring HTTP2_ENABLED, not a valid boolean!")
+		} else {
+			cfg.HTTP2 = enabled
+		}
+	}
+
+	return cfg
+}
+
+// TLSEnabled returns whether the server serves HTTPS.
+func (cfg *Config) TLSEnabled() bool {
+	return cfg.TLSCertFile != "" && cfg.TLSKeyFile != ""
+}
+
+// NewHTTPServer returns an http.Server that serves handler with cfg.
+func NewHTTPServer(cfg *Config, handler http.Handler) *http.Server {
+	fitStreams(cfg.WriteTimeout)
+
+	s := &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
+
+	if !cfg.HTTP2 {
+		// A non-nil, empty TLSNextProto disables HTTP/2.
+		s.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+	}
+
+	return s
+}
+
+// fitStreams shortens the streaming responses of the API so that they end
+// before the write timeout of the server, which applies to the whole response.
+func fitStreams(writeTimeout time.Duration) {
+	if writeTimeout == 0 {
+		return
+	}
+
+	max := writeTimeout - streamMargin
+	if max <= 0 {
+		max = writeTimeout / 2
+	}
+
+	if deployments.MaxStreamDuration > max {
+		log.Warnf("Deployment events are streamed for %s instead of %s to end before the write timeout", max, deployments.MaxStreamDuration)
+		deployments.MaxStreamDuration = max
+	}
+	if deployments.MaxWait > max {
+		log.Warnf("Long polls of deployments wait for at most %s instead of %s to end before the write timeout", max, deployments.MaxWait)
+		deployments.MaxWait = max
+	}
+}
+
+// ListenAndServe serves handler with cfg, over HTTPS if TLS is configured.
+func ListenAndServe(cfg *Config, handler http.Handler) error {
+	s := NewHTTPServer(cfg, handler)
+	if cfg.TLSEnabled() {
+		return s.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+	}
+	return s.ListenAndServe()
+}
@@ -0,0 +1,139 @@
+package server_test
+
+import (
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nitrous-io/rise-server/apiserver/controllers/deployments"
+	"github.com/nitrous-io/rise-server/apiserver/server"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "server")
+}
+
+var _ = Describe("Server", func() {
+	envVars := []string{
+		"HTTP_ADDR",
+		"HTTP_READ_HEADER_TIMEOUT",
+		"HTTP_READ_TIMEOUT",
+		"HTTP_WRITE_TIMEOUT",
+		"HTTP_IDLE_TIMEOUT",
+		"HTTP_MAX_HEADER_BYTES",
+		"HTTP_TLS_CERT_FILE",
+		"HTTP_TLS_KEY_FILE",
+		"HTTP2_ENABLED",
+	}
+
+	var origEnv map[string]string
+
+	BeforeEach(func() {
+		origEnv = map[string]string{}
+		for _, name := range envVars {
+			origEnv[name] = os.Getenv(name)
+			os.Unsetenv(name)
+		}
+	})
+
+	AfterEach(func() {
+		for name, v := range origEnv {
+			os.Setenv(name, v)
+		}
+	})
+
+	Describe("ConfigFromEnv()", func() {
+		It("returns the default config", func() {
+			Expect(server.ConfigFromEnv()).To(Equal(server.DefaultConfig()))
+		})
+
+		It("overrides the default config with environment variables", func() {
+			os.Setenv("HTTP_ADDR", ":8080")
+			os.Setenv("HTTP_READ_HEADER_TIMEOUT", "5s")
+			os.Setenv("HTTP_READ_TIMEOUT", "1m")
+			os.Setenv("HTTP_WRITE_TIMEOUT", "2m")
+			os.Setenv("HTTP_IDLE_TIMEOUT", "30s")
+			os.Setenv("HTTP_MAX_HEADER_BYTES", "8192")
+			os.Setenv("HTTP_TLS_CERT_FILE", "/etc/ssl/api.crt")
+			os.Setenv("HTTP_TLS_KEY_FILE", "/etc/ssl/api.key")
+			os.Setenv("HTTP2_ENABLED", "false")
+
+			cfg := server.ConfigFromEnv()
+			Expect(cfg).To(Equal(&server.Config{
+				Addr:              ":8080",
+				ReadHeaderTimeout: 5 * time.Second,
+				ReadTimeout:       time.Minute,
+				WriteTimeout:      2 * time.Minute,
+				IdleTimeout:       30 * time.Second,
+				MaxHeaderBytes:    8192,
+				TLSCertFile:       "/etc/ssl/api.crt",
+				TLSKeyFile:        "/etc/ssl/api.key",
+				HTTP2:             false,
+			}))
+			Expect(cfg.TLSEnabled()).To(BeTrue())
+		})
+
+		It("ignores invalid values", func() {
+			os.Setenv("HTTP_READ_TIMEOUT", "forever")
+			os.Setenv("HTTP_IDLE_TIMEOUT", "-1s")
+			os.Setenv("HTTP_MAX_HEADER_BYTES", "lots")
+			os.Setenv("HTTP2_ENABLED", "maybe")
+
+			Expect(server.ConfigFromEnv()).To(Equal(server.DefaultConfig()))
+		})
+	})
+
+	Describe("NewHTTPServer()", func() {
+		It("returns a server with the configured timeouts and limits", func() {
+			cfg := server.DefaultConfig()
+			s := server.NewHTTPServer(cfg, http.NotFoundHandler())
+
+			Expect(s.Addr).To(Equal(cfg.Addr))
+			Expect(s.ReadHeaderTimeout).To(Equal(cfg.ReadHeaderTimeout))
+			Expect(s.ReadTimeout).To(Equal(cfg.ReadTimeout))
+			Expect(s.WriteTimeout).To(Equal(cfg.WriteTimeout))
+			Expect(s.IdleTimeout).To(Equal(cfg.IdleTimeout))
+			Expect(s.MaxHeaderBytes).To(Equal(cfg.MaxHeaderBytes))
+			Expect(s.TLSNextProto).To(BeNil())
+		})
+
+		Context("when the write timeout is shorter than streaming responses", func() {
+			var origMaxStreamDuration, origMaxWait time.Duration
+
+			BeforeEach(func() {
+				origMaxStreamDuration = deployments.MaxStreamDuration
+				origMaxWait = deployments.MaxWait
+			})
+
+			AfterEach(func() {
+				deployments.MaxStreamDuration = origMaxStreamDuration
+				deployments.MaxWait = origMaxWait
+			})
+
+			It("shortens them to end before the write timeout", func() {
+				cfg := server.DefaultConfig()
+				cfg.WriteTimeout = 2 * time.Minute
+				deployments.MaxStreamDuration = 5 * time.Minute
+				deployments.MaxWait = time.Minute
+
+				server.NewHTTPServer(cfg, http.NotFoundHandler())
+				Expect(deployments.MaxStreamDuration).To(Equal(110 * time.Second))
+				Expect(deployments.MaxWait).To(Equal(time.Minute))
+			})
+		})
+
+		It("disables HTTP/2 when it is not enabled", func() {
+			cfg := server.DefaultConfig()
+			cfg.HTTP2 = false
+
+			s := server.NewHTTPServer(cfg, http.NotFoundHandler())
+			Expect(s.TLSNextProto).NotTo(BeNil())
+			Expect(s.TLSNextProto).To(BeEmpty())
+		})
+	})
+})
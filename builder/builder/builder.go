@@ -3,6 +3,7 @@ package builder
 import (
 	"archive/tar"
 	"archive/zip"
+	"bytes"
 	"compress/gzip"
 	"encoding/json"
 	"errors"
@@ -19,12 +20,16 @@ import (
 
 	"github.com/jinzhu/gorm"
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployhook"
 	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
 	"github.com/nitrous-io/rise-server/apiserver/models/project"
 	"github.com/nitrous-io/rise-server/apiserver/models/rawbundle"
 	"github.com/nitrous-io/rise-server/pkg/filetransfer"
+	"github.com/nitrous-io/rise-server/pkg/fingerprint"
 	"github.com/nitrous-io/rise-server/pkg/job"
+	"github.com/nitrous-io/rise-server/pkg/rootdir"
 	"github.com/nitrous-io/rise-server/shared/messages"
+	"github.com/nitrous-io/rise-server/shared/progress"
 	"github.com/nitrous-io/rise-server/shared/queues"
 	"github.com/nitrous-io/rise-server/shared/s3client"
 )
@@ -57,12 +62,54 @@ var (
 	ErrOptimizerTimeout = errors.New("Timed out on optimizing assets. This might happen due to too large asset files. We will continue without optimizing your assets.")
 	ErrRecordNotFound   = errors.New("project or deployment is deleted")
 	ErrUnarchiveFailed  = errors.New("Failed to unarchive file")
+	ErrRootDirNotFound  = errors.New("root directory not found in bundle")
+
+	ErrPreBuildHookFailed = errors.New("pre-build hook failed")
+	ErrPackageBuildFailed = errors.New("package build failed")
+	errSandboxTimeout     = errors.New("sandboxed command timed out")
 
 	OptimizerCmd = func(containerName string, srcDir string, domainNames []string) *exec.Cmd {
 		return exec.Command("docker", "run", "--name", containerName, "-v", srcDir+":"+OptimizePath, "-e", "DOMAIN_NAMES_WITH_PROTOCOL="+strings.Join(domainNames, ","), "--rm", OptimizerDockerImage)
 	}
 
 	OptimizerTimeout = 5 * 60 * time.Second // 5 mins
+
+	// PreBuildHookCmd runs a pre-build hook command in a sandbox container
+	// with the bundle mounted as its working directory.
+	PreBuildHookCmd = func(containerName string, srcDir string, command string) *exec.Cmd {
+		return exec.Command("docker", "run", "--name", containerName, "-v", srcDir+":"+OptimizePath, "-w", OptimizePath, "--rm", OptimizerDockerImage, "sh", "-c", command)
+	}
+
+	PreBuildHookTimeout = 5 * 60 * time.Second // 5 mins
+
+	// PackageBuildDockerImage is the image that bundles with a package.json
+	// are built in. It has to have both npm and yarn.
+	PackageBuildDockerImage = "node:6"
+
+	// PackageBuildCmd runs the build script of a bundle with a package.json
+	// in a sandbox container with the bundle mounted as its working
+	// directory.
+	PackageBuildCmd = func(containerName string, srcDir string, command string) *exec.Cmd {
+		return exec.Command("docker", "run", "--name", containerName, "-v", srcDir+":"+OptimizePath, "-w", OptimizePath, "--rm", PackageBuildDockerImage, "sh", "-c", command)
+	}
+
+	PackageBuildTimeout = 10 * 60 * time.Second // 10 mins
+
+	// PackageBuildOutputDirs are the directories that the output of a
+	// package build is looked for in, in order.
+	PackageBuildOutputDirs = []string{"dist", "build", "out", "public"}
+
+	// maxHookOutputLength is how much of the output of a failed pre-build
+	// hook or package build is shown to the user.
+	maxHookOutputLength = 1000
+
+	// MaxBuildLogSize is the size of the largest build log that is stored.
+	// Only the end of longer logs is kept, as that is where errors are.
+	MaxBuildLogSize = 1024 * 1024 // 1 MB
+
+	// LockTTL is how long the project stays locked during a build if the
+	// builder crashes before unlocking it.
+	LockTTL = 30 * time.Minute
 )
 
 func Work(data []byte) error {
@@ -92,7 +139,7 @@ func Work(data []byte) error {
 		return err
 	}
 
-	acquired, err := proj.Lock(db)
+	acquired, err := proj.LockFor(db, project.LockHolder(fmt.Sprintf("builder (deployment %d)", depl.ID)), LockTTL)
 	if err != nil {
 		return err
 	}
@@ -111,6 +158,17 @@ func Work(data []byte) error {
 		return errUnexpectedState
 	}
 
+	// The output of the commands that are run is stored whether or not the
+	// build succeeds, so that users can see why it failed.
+	blog := &buildLog{deploymentID: depl.ID}
+	defer func() {
+		if err := blog.upload(depl); err != nil {
+			log.Printf("failed to upload build log of deployment %d due to %v", depl.ID, err)
+		}
+	}()
+
+	start := time.Now()
+
 	// There are 2 possible sources for the bundle (i.e. the files to be
 	// deployed):
 	//   1. A raw bundle from a previous deployment.
@@ -155,10 +213,26 @@ func Work(data []byte) error {
 		return err
 	}
 
+	// Bundles that cannot be unpacked will never build, so the deployment is
+	// failed with a message that tells the user why.
+	unarchiveFailed := func() error {
+		errorMessage := "Your bundle could not be unpacked. Bundles must be zip or gzipped tar archives."
+		errorCode := deployment.ErrorCodeInvalidBundle
+		depl.ErrorMessage = &errorMessage
+		depl.ErrorCode = &errorCode
+		if err := depl.UpdateState(db, deployment.StateBuildFailed); err != nil {
+			return err
+		}
+		return ErrUnarchiveFailed
+	}
+
+	// Only the files in the root dir of the deployment are extracted, so that
+	// the optimized bundle contains only those.
+	extracted := 0
 	if archiveFormat == "tar.gz" {
 		gr, err := gzip.NewReader(f)
 		if err != nil {
-			return ErrUnarchiveFailed
+			return unarchiveFailed()
 		}
 		defer gr.Close()
 
@@ -176,12 +250,17 @@ func Work(data []byte) error {
 				continue
 			}
 
-			folderPath := path.Dir(hdr.Name)
+			fileName, ok := rootdir.Rel(depl.RootDir, path.Clean(hdr.Name))
+			if !ok {
+				continue
+			}
+			extracted++
+
+			folderPath := path.Dir(fileName)
 			if err := os.MkdirAll(filepath.Join(dirName, folderPath), 0755); err != nil {
 				return err
 			}
 
-			fileName := path.Clean(hdr.Name)
 			targetFileName := filepath.Join(dirName, fileName)
 			entry, err := os.Create(targetFileName)
 			if err != nil {
@@ -198,27 +277,33 @@ func Work(data []byte) error {
 	} else if archiveFormat == "zip" {
 		r, err := zip.OpenReader(f.Name())
 		if err != nil {
-			return ErrUnarchiveFailed
+			return unarchiveFailed()
 		}
 		defer r.Close()
 
 		for _, file := range r.File {
+			name := rootdir.ZipName(file.Name)
+			if file.FileInfo().IsDir() || strings.HasSuffix(name, "/") {
+				continue
+			}
+
 			rc, err := file.Open()
 			if err != nil {
-				return err
+				return unarchiveFailed()
 			}
 			defer rc.Close()
 
-			if file.FileInfo().IsDir() {
+			fileName, ok := rootdir.Rel(depl.RootDir, path.Clean(name))
+			if !ok {
 				continue
 			}
+			extracted++
 
-			folderPath := path.Dir(file.Name)
+			folderPath := path.Dir(fileName)
 			if err := os.MkdirAll(filepath.Join(dirName, folderPath), 0755); err != nil {
 				return err
 			}
 
-			fileName := path.Clean(file.Name)
 			targetFileName := filepath.Join(dirName, fileName)
 			entry, err := os.Create(targetFileName)
 			if err != nil {
@@ -234,6 +319,87 @@ func Work(data []byte) error {
 		}
 	}
 
+	if extracted == 0 && depl.RootDir != "" {
+		errorMessage := fmt.Sprintf("The root directory %q could not be found in your bundle.", depl.RootDir)
+		depl.ErrorMessage = &errorMessage
+		if err := depl.UpdateState(db, deployment.StateBuildFailed); err != nil {
+			return err
+		}
+		return ErrRootDirNotFound
+	}
+
+	hooks, err := deployhook.FindByProjectID(db, proj.ID, deployhook.StagePreBuild)
+	if err != nil {
+		return err
+	}
+
+	for i, hook := range hooks {
+		containerName := fmt.Sprintf("%s-hook-%d-%d", prefixID, i, time.Now().Unix())
+		blog.Printf("Running pre-build hook %q", *hook.Command)
+		output, err := runPreBuildHook(containerName, dirName, *hook.Command)
+		blog.Output(output, err)
+		if err == nil {
+			continue
+		}
+
+		errorMessage := fmt.Sprintf("The pre-build hook %q failed", *hook.Command)
+		if err == errSandboxTimeout {
+			errorMessage += " because it did not finish within " + PreBuildHookTimeout.String()
+		}
+		errorMessage += outputForErrorMessage(output)
+
+		depl.ErrorMessage = &errorMessage
+		if err := depl.UpdateState(db, deployment.StateBuildFailed); err != nil {
+			return err
+		}
+		return ErrPreBuildHookFailed
+	}
+
+	// Bundles with a package.json that has a build script, e.g. React or
+	// Angular apps, are built with npm or yarn, and only the output of the
+	// build is deployed. The sources are never deployed, as the bundle
+	// directory is not served.
+	bundleDir := dirName
+	packageBuilt := false
+	if !proj.SkipBuild {
+		command, ok, err := packageBuildCommand(dirName)
+		if err != nil {
+			return err
+		}
+
+		if ok {
+			containerName := fmt.Sprintf("%s-package-%d", prefixID, time.Now().Unix())
+			blog.Printf("Building with %q", command)
+			output, err := runSandboxed(PackageBuildCmd(containerName, dirName, command), containerName, PackageBuildTimeout)
+			blog.Output(output, err)
+			if err != nil {
+				errorMessage := fmt.Sprintf("The build (%q) failed", command)
+				if err == errSandboxTimeout {
+					errorMessage += " because it did not finish within " + PackageBuildTimeout.String()
+				}
+				errorMessage += outputForErrorMessage(output)
+
+				depl.ErrorMessage = &errorMessage
+				if err := depl.UpdateState(db, deployment.StateBuildFailed); err != nil {
+					return err
+				}
+				return ErrPackageBuildFailed
+			}
+
+			bundleDir = packageBuildOutputDir(dirName)
+			if bundleDir == "" {
+				errorMessage := fmt.Sprintf("The build (%q) did not output any files. The output of the build must be in one of these directories: %s.", command, strings.Join(PackageBuildOutputDirs, ", "))
+				blog.Printf("%s", errorMessage)
+				depl.ErrorMessage = &errorMessage
+				if err := depl.UpdateState(db, deployment.StateBuildFailed); err != nil {
+					return err
+				}
+				return ErrPackageBuildFailed
+			}
+			packageBuilt = true
+		}
+	}
+
 	optimizedBundleArchive, err := ioutil.TempFile("", "optimized-bundle."+archiveFormat)
 	if err != nil {
 		return err
@@ -247,14 +413,32 @@ func Work(data []byte) error {
 
 	nextState := deployment.StateBuilt
 
-	// Optimize assets
-	domainNames, err := proj.DomainNamesWithProtocol(db)
-	if err != nil {
-		return err
+	// Optimize assets, unless the project is only built to fingerprint its
+	// assets.
+	var (
+		output       string
+		optimizerErr error
+	)
+	if !proj.SkipBuild {
+		domainNames, err := proj.DomainNamesWithProtocol(db)
+		if err != nil {
+			return err
+		}
+
+		blog.Printf("Optimizing assets")
+		output, optimizerErr = runOptimizer(fmt.Sprintf("%s-%d", prefixID, time.Now().Unix()), bundleDir, domainNames)
+		blog.Output(output, optimizerErr)
 	}
 
-	output, err := runOptimizer(fmt.Sprintf("%s-%d", prefixID, time.Now().Unix()), dirName, domainNames)
-	if err == nil {
+	uploadOptimizedBundle := func() error {
+		if err := pack(optimizedBundleArchive, bundleDir, archiveFormat); err != nil {
+			return err
+		}
+
+		return S3.Upload(s3client.BucketRegion, s3client.BucketName, "deployments/"+prefixID+"/optimized-bundle."+archiveFormat, optimizedBundleArchive, "", "private")
+	}
+
+	if optimizerErr == nil {
 		var errorMessages []string
 		outputs := strings.Split(output, "\n")
 		for _, output := range outputs {
@@ -269,15 +453,21 @@ func Work(data []byte) error {
 			log.Printf("error on optimizing: %v", errorMessage)
 		}
 
-		if err := pack(optimizedBundleArchive, dirName, archiveFormat); err != nil {
-			return err
+		// Assets are fingerprinted after they are optimized, as optimizing
+		// changes their content.
+		if proj.AssetFingerprinting {
+			renamed, err := fingerprint.Dir(bundleDir)
+			if err != nil {
+				return err
+			}
+			blog.Printf("Fingerprinted %d assets", len(renamed))
 		}
 
-		if err := S3.Upload(s3client.BucketRegion, s3client.BucketName, "deployments/"+prefixID+"/optimized-bundle."+archiveFormat, optimizedBundleArchive, "", "private"); err != nil {
+		if err := uploadOptimizedBundle(); err != nil {
 			return err
 		}
 
-	} else if err == ErrOptimizerTimeout {
+	} else if optimizerErr == ErrOptimizerTimeout {
 		if err := depl.UpdateState(db, deployment.StateBuildFailed); err != nil {
 			return err
 		}
@@ -285,8 +475,21 @@ func Work(data []byte) error {
 		nextState = deployment.StateBuildFailed
 		errorMessage := ErrOptimizerTimeout.Error()
 		depl.ErrorMessage = &errorMessage
-		deployJobMsg.UseRawBundle = true
+
+		// The raw bundle of a package build only has its sources, so the
+		// output of the build is deployed as it is instead.
+		if packageBuilt {
+			if err := uploadOptimizedBundle(); err != nil {
+				return err
+			}
+		} else {
+			deployJobMsg.UseRawBundle = true
+		}
 	} else {
+		return optimizerErr
+	}
+
+	if err := depl.RecordDuration(db, deployment.PhaseBuild, time.Since(start)); err != nil {
 		return err
 	}
 
@@ -452,3 +655,131 @@ func runOptimizer(containerName, srcDir string, domainNames []string) (output st
 		return "", ErrOptimizerTimeout
 	}
 }
+
+// runPreBuildHook runs a pre-build hook command in the bundle directory. The
+// output of the command is returned even if it fails.
+func runPreBuildHook(containerName, srcDir, command string) (output string, err error) {
+	return runSandboxed(PreBuildHookCmd(containerName, srcDir, command), containerName, PreBuildHookTimeout)
+}
+
+// runSandboxed runs cmd, which runs a command in the container with the given
+// name, and removes the container if it does not finish within timeout. The
+// output of the command is returned even if it fails.
+func runSandboxed(cmd *exec.Cmd, containerName string, timeout time.Duration) (output string, err error) {
+	type result struct {
+		out []byte
+		err error
+	}
+
+	resCh := make(chan result, 1)
+
+	go func() {
+		out, err := cmd.CombinedOutput()
+		resCh <- result{out, err}
+	}()
+
+	select {
+	case res := <-resCh:
+		return string(res.out), res.err
+	case <-time.After(timeout):
+		if _, err := exec.Command("docker", "rm", "-f", containerName).CombinedOutput(); err != nil {
+			if cmd.Process != nil {
+				cmd.Process.Kill()
+			}
+		}
+
+		return "", errSandboxTimeout
+	}
+}
+
+// outputForErrorMessage formats the output of a failed command to be appended
+// to an error message, keeping only the end of long output.
+func outputForErrorMessage(output string) string {
+	output = strings.TrimSpace(output)
+	if output == "" {
+		return "."
+	}
+	if len(output) > maxHookOutputLength {
+		output = "..." + output[len(output)-maxHookOutputLength:]
+	}
+	return ":\n" + output
+}
+
+// packageBuildCommand returns the command that builds the bundle in dir. The
+// second return value is false if the bundle does not have a package.json
+// with a build script, in which case it is not built.
+func packageBuildCommand(dir string) (string, bool, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, "package.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+
+	var pkg struct {
+		Scripts map[string]string `json:"scripts"`
+	}
+	// A package.json that cannot be parsed cannot be built either, so it is
+	// treated like any other file in the bundle.
+	if err := json.Unmarshal(b, &pkg); err != nil || pkg.Scripts["build"] == "" {
+		return "", false, nil
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "yarn.lock")); err == nil {
+		return "yarn install --pure-lockfile && yarn run build", true, nil
+	}
+	return "npm install && npm run build", true, nil
+}
+
+// packageBuildOutputDir returns the first of PackageBuildOutputDirs in dir
+// that has files in it, or an empty string if there is none.
+func packageBuildOutputDir(dir string) string {
+	for _, name := range PackageBuildOutputDirs {
+		outputDir := filepath.Join(dir, name)
+		fis, err := ioutil.ReadDir(outputDir)
+		if err == nil && len(fis) > 0 {
+			return outputDir
+		}
+	}
+	return ""
+}
+
+// buildLog collects the output of the commands that are run during a build.
+type buildLog struct {
+	bytes.Buffer
+	deploymentID uint
+}
+
+// Printf adds a line to the log, and publishes it as a progress message of
+// the deployment.
+func (l *buildLog) Printf(format string, args ...interface{}) {
+	fmt.Fprintf(&l.Buffer, "[%s] "+format+"\n", append([]interface{}{time.Now().UTC().Format(time.RFC3339)}, args...)...)
+	progress.Publish(l.deploymentID, format, args...)
+}
+
+// Output adds the output of a command, and the error it failed with if any,
+// to the log.
+func (l *buildLog) Output(output string, err error) {
+	if output = strings.TrimRight(output, "\n"); output != "" {
+		l.WriteString(output + "\n")
+	}
+	if err != nil {
+		l.Printf("Failed: %v", err)
+	}
+}
+
+// upload uploads the log to the path of the deployment's build log, unless no
+// commands were run.
+func (l *buildLog) upload(depl *deployment.Deployment) error {
+	if l.Len() == 0 {
+		return nil
+	}
+
+	b := l.Bytes()
+	if len(b) > MaxBuildLogSize {
+		b = append([]byte("...\n"), b[len(b)-MaxBuildLogSize:]...)
+	}
+
+	return S3.Upload(s3client.BucketRegion, s3client.BucketName, depl.BuildLogPath(), bytes.NewReader(b), "text/plain; charset=utf-8", "private")
+}
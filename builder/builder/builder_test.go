@@ -17,6 +17,7 @@ import (
 
 	"github.com/jinzhu/gorm"
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployhook"
 	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
 	"github.com/nitrous-io/rise-server/apiserver/models/project"
 	"github.com/nitrous-io/rise-server/apiserver/models/rawbundle"
@@ -31,7 +32,6 @@ import (
 	"github.com/nitrous-io/rise-server/testhelper/fake"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
-	"github.com/streadway/amqp"
 )
 
 func Test(t *testing.T) {
@@ -46,7 +46,7 @@ var _ = Describe("Builder", func() {
 		err    error
 
 		db *gorm.DB
-		mq *amqp.Connection
+		mq mqconn.Conn
 
 		u    *user.User
 		proj *project.Project
@@ -91,6 +91,18 @@ var _ = Describe("Builder", func() {
 		Expect(uploadCall.ReturnValues[0]).To(BeNil())
 	}
 
+	uploadedBuildLog := func(nthUpload int) string {
+		uploadCall := fakeS3.UploadCalls.NthCall(nthUpload)
+		Expect(uploadCall).NotTo(BeNil())
+		Expect(uploadCall.Arguments[2]).To(Equal("deployments/" + depl.PrefixID() + "/build.log"))
+		Expect(uploadCall.Arguments[4]).To(Equal("text/plain; charset=utf-8"))
+		Expect(uploadCall.Arguments[5]).To(Equal("private"))
+
+		uploadedContent, ok := uploadCall.SideEffects["uploaded_content"].([]byte)
+		Expect(ok).To(BeTrue())
+		return string(uploadedContent)
+	}
+
 	assertCleanTempFile := func(prefixID string) {
 		files, _ := ioutil.ReadDir("/tmp")
 		for _, f := range files {
@@ -118,8 +130,9 @@ var _ = Describe("Builder", func() {
 		Expect(downloadCall.Arguments[2]).To(Equal(fmt.Sprintf("deployments/%s/raw-bundle.tar.gz", depl.PrefixID())))
 		Expect(downloadCall.ReturnValues[0]).To(BeNil())
 
-		// it should upload optimized assets as a tar-gzipped file.
-		Expect(fakeS3.UploadCalls.Count()).To(Equal(1))
+		// it should upload optimized assets as a tar-gzipped file, and the
+		// build log.
+		Expect(fakeS3.UploadCalls.Count()).To(Equal(2))
 
 		assertUpload(
 			1,
@@ -236,8 +249,9 @@ var _ = Describe("Builder", func() {
 		Expect(downloadCall.Arguments[2]).To(Equal(fmt.Sprintf("deployments/%s/raw-bundle.zip", depl.PrefixID())))
 		Expect(downloadCall.ReturnValues[0]).To(BeNil())
 
-		// it should upload optimized assets as a tar-gzipped file.
-		Expect(fakeS3.UploadCalls.Count()).To(Equal(1))
+		// it should upload optimized assets as a tar-gzipped file, and the
+		// build log.
+		Expect(fakeS3.UploadCalls.Count()).To(Equal(2))
 
 		assertUpload(
 			1,
@@ -537,6 +551,549 @@ var _ = Describe("Builder", func() {
 		})
 	})
 
+	Context("when the deployment has a root directory", func() {
+		var origOptimizerCmd func(string, string, []string) *exec.Cmd
+
+		BeforeEach(func() {
+			origOptimizerCmd = builder.OptimizerCmd
+			builder.OptimizerCmd = func(cn string, srcDir string, domainNames []string) *exec.Cmd {
+				return exec.Command("true")
+			}
+
+			buf := &bytes.Buffer{}
+			gw := gzip.NewWriter(buf)
+			tw := tar.NewWriter(gw)
+			for name, content := range map[string]string{
+				"README.md":            "# monorepo",
+				"app/main.go":          "package main",
+				"site/index.html":      "<h1>hello</h1>",
+				"site/css/app.css":     "h1 { color: red; }",
+				"site-other/index.htm": "<h1>other</h1>",
+			} {
+				Expect(tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))})).To(BeNil())
+				_, err := tw.Write([]byte(content))
+				Expect(err).To(BeNil())
+			}
+			Expect(tw.Close()).To(BeNil())
+			Expect(gw.Close()).To(BeNil())
+			fakeS3.DownloadContent = buf.Bytes()
+
+			depl.RootDir = "site"
+			Expect(db.Save(depl).Error).To(BeNil())
+		})
+
+		AfterEach(func() {
+			builder.OptimizerCmd = origOptimizerCmd
+		})
+
+		It("only builds the files in the root directory", func() {
+			err = builder.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+
+			uploadCall := fakeS3.UploadCalls.NthCall(1)
+			Expect(uploadCall).NotTo(BeNil())
+			uploadedContent, ok := uploadCall.SideEffects["uploaded_content"].([]byte)
+			Expect(ok).To(BeTrue())
+
+			gr, err := gzip.NewReader(bytes.NewBuffer(uploadedContent))
+			Expect(err).To(BeNil())
+			defer gr.Close()
+			tr := tar.NewReader(gr)
+
+			contents := map[string]string{}
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				Expect(err).To(BeNil())
+
+				content, err := ioutil.ReadAll(tr)
+				Expect(err).To(BeNil())
+				contents[hdr.Name] = string(content)
+			}
+
+			Expect(contents).To(Equal(map[string]string{
+				"index.html":  "<h1>hello</h1>",
+				"css/app.css": "h1 { color: red; }",
+			}))
+		})
+
+		Context("when the root directory does not exist in the bundle", func() {
+			BeforeEach(func() {
+				depl.RootDir = "www"
+				Expect(db.Save(depl).Error).To(BeNil())
+			})
+
+			It("marks the deployment as failed", func() {
+				err = builder.Work([]byte(fmt.Sprintf(`{
+					"deployment_id": %d,
+					"archive_format": "tar.gz"
+				}`, depl.ID)))
+				Expect(err).To(Equal(builder.ErrRootDirNotFound))
+
+				Expect(fakeS3.UploadCalls.Count()).To(Equal(0))
+				Expect(testhelper.ConsumeQueue(mq, queues.Deploy)).To(BeNil())
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StateBuildFailed))
+				Expect(*depl.ErrorMessage).To(Equal(`The root directory "www" could not be found in your bundle.`))
+
+				assertCleanTempFile(depl.PrefixID())
+			})
+		})
+	})
+
+	Context("when the zip bundle was created on Windows", func() {
+		var origOptimizerCmd func(string, string, []string) *exec.Cmd
+
+		BeforeEach(func() {
+			origOptimizerCmd = builder.OptimizerCmd
+			builder.OptimizerCmd = func(cn string, srcDir string, domainNames []string) *exec.Cmd {
+				return exec.Command("true")
+			}
+
+			// Some Windows tools use backslashes as separators in zip archives.
+			buf := &bytes.Buffer{}
+			zw := zip.NewWriter(buf)
+			_, err := zw.Create(`site\css\`)
+			Expect(err).To(BeNil())
+			for _, f := range []struct{ name, content string }{
+				{"README.md", "# monorepo"},
+				{`site\index.html`, "<h1>hello</h1>"},
+				{`site\css\app.css`, "h1 { color: red; }"},
+			} {
+				w, err := zw.Create(f.name)
+				Expect(err).To(BeNil())
+				_, err = w.Write([]byte(f.content))
+				Expect(err).To(BeNil())
+			}
+			Expect(zw.Close()).To(BeNil())
+			fakeS3.DownloadContent = buf.Bytes()
+
+			depl.RootDir = "site"
+			Expect(db.Save(depl).Error).To(BeNil())
+		})
+
+		AfterEach(func() {
+			builder.OptimizerCmd = origOptimizerCmd
+		})
+
+		It("extracts the files into their directories", func() {
+			err = builder.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"archive_format": "zip"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+
+			uploadCall := fakeS3.UploadCalls.NthCall(1)
+			Expect(uploadCall).NotTo(BeNil())
+			uploadedContent, ok := uploadCall.SideEffects["uploaded_content"].([]byte)
+			Expect(ok).To(BeTrue())
+
+			zr, err := zip.NewReader(bytes.NewReader(uploadedContent), int64(len(uploadedContent)))
+			Expect(err).To(BeNil())
+
+			contents := map[string]string{}
+			for _, f := range zr.File {
+				if f.FileInfo().IsDir() {
+					continue
+				}
+				rc, err := f.Open()
+				Expect(err).To(BeNil())
+				content, err := ioutil.ReadAll(rc)
+				Expect(err).To(BeNil())
+				rc.Close()
+				contents[f.Name] = string(content)
+			}
+
+			Expect(contents).To(Equal(map[string]string{
+				"index.html":  "<h1>hello</h1>",
+				"css/app.css": "h1 { color: red; }",
+			}))
+		})
+	})
+
+	Context("when the bundle is not in the given format", func() {
+		BeforeEach(func() {
+			fakeS3.DownloadContent = []byte("this is not an archive")
+		})
+
+		It("marks the deployment as failed with an invalid bundle error", func() {
+			err = builder.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"archive_format": "zip"
+			}`, depl.ID)))
+			Expect(err).To(Equal(builder.ErrUnarchiveFailed))
+
+			Expect(fakeS3.UploadCalls.Count()).To(Equal(0))
+			Expect(testhelper.ConsumeQueue(mq, queues.Deploy)).To(BeNil())
+
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			Expect(depl.State).To(Equal(deployment.StateBuildFailed))
+			Expect(*depl.ErrorMessage).To(Equal("Your bundle could not be unpacked. Bundles must be zip or gzipped tar archives."))
+			Expect(*depl.ErrorCode).To(Equal(deployment.ErrorCodeInvalidBundle))
+
+			assertCleanTempFile(depl.PrefixID())
+		})
+	})
+
+	Context("when the project has pre-build hooks", func() {
+		var (
+			origOptimizerCmd    func(string, string, []string) *exec.Cmd
+			origPreBuildHookCmd func(string, string, string) *exec.Cmd
+		)
+
+		BeforeEach(func() {
+			origOptimizerCmd = builder.OptimizerCmd
+			builder.OptimizerCmd = func(cn string, srcDir string, domainNames []string) *exec.Cmd {
+				return exec.Command("true")
+			}
+
+			origPreBuildHookCmd = builder.PreBuildHookCmd
+			builder.PreBuildHookCmd = func(cn string, srcDir string, command string) *exec.Cmd {
+				cmd := exec.Command("sh", "-c", command)
+				cmd.Dir = srcDir
+				return cmd
+			}
+
+			buf := &bytes.Buffer{}
+			gw := gzip.NewWriter(buf)
+			tw := tar.NewWriter(gw)
+			content := "<h1>hello</h1>"
+			Expect(tw.WriteHeader(&tar.Header{Name: "index.html", Mode: 0644, Size: int64(len(content))})).To(BeNil())
+			_, err := tw.Write([]byte(content))
+			Expect(err).To(BeNil())
+			Expect(tw.Close()).To(BeNil())
+			Expect(gw.Close()).To(BeNil())
+			fakeS3.DownloadContent = buf.Bytes()
+
+			for _, command := range []string{"echo built > build.txt", "cat build.txt >> index.html"} {
+				command := command
+				Expect(db.Create(&deployhook.DeployHook{
+					ProjectID: proj.ID,
+					Stage:     deployhook.StagePreBuild,
+					Command:   &command,
+				}).Error).To(BeNil())
+			}
+		})
+
+		AfterEach(func() {
+			builder.OptimizerCmd = origOptimizerCmd
+			builder.PreBuildHookCmd = origPreBuildHookCmd
+		})
+
+		It("runs the hooks in order on the bundle before optimizing it", func() {
+			err = builder.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+
+			uploadCall := fakeS3.UploadCalls.NthCall(1)
+			Expect(uploadCall).NotTo(BeNil())
+			uploadedContent, ok := uploadCall.SideEffects["uploaded_content"].([]byte)
+			Expect(ok).To(BeTrue())
+
+			gr, err := gzip.NewReader(bytes.NewBuffer(uploadedContent))
+			Expect(err).To(BeNil())
+			defer gr.Close()
+			tr := tar.NewReader(gr)
+
+			contents := map[string]string{}
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				Expect(err).To(BeNil())
+
+				content, err := ioutil.ReadAll(tr)
+				Expect(err).To(BeNil())
+				contents[hdr.Name] = string(content)
+			}
+
+			Expect(contents).To(Equal(map[string]string{
+				"index.html": "<h1>hello</h1>built\n",
+				"build.txt":  "built\n",
+			}))
+
+			buildLog := uploadedBuildLog(2)
+			Expect(buildLog).To(ContainSubstring(`Running pre-build hook "echo built > build.txt"`))
+			Expect(buildLog).To(ContainSubstring(`Running pre-build hook "cat build.txt >> index.html"`))
+			Expect(buildLog).To(ContainSubstring("Optimizing assets"))
+
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			Expect(depl.State).To(Equal(deployment.StatePendingDeploy))
+		})
+
+		Context("when a hook fails", func() {
+			BeforeEach(func() {
+				command := "echo oops; exit 1"
+				Expect(db.Create(&deployhook.DeployHook{
+					ProjectID: proj.ID,
+					Stage:     deployhook.StagePreBuild,
+					Command:   &command,
+				}).Error).To(BeNil())
+			})
+
+			It("marks the deployment as failed with the output of the hook", func() {
+				err = builder.Work([]byte(fmt.Sprintf(`{
+					"deployment_id": %d,
+					"archive_format": "tar.gz"
+				}`, depl.ID)))
+				Expect(err).To(Equal(builder.ErrPreBuildHookFailed))
+
+				// Only the build log is uploaded.
+				Expect(fakeS3.UploadCalls.Count()).To(Equal(1))
+				Expect(uploadedBuildLog(1)).To(ContainSubstring("Running pre-build hook \"echo oops; exit 1\"\noops\n"))
+				Expect(testhelper.ConsumeQueue(mq, queues.Deploy)).To(BeNil())
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StateBuildFailed))
+				Expect(*depl.ErrorMessage).To(Equal("The pre-build hook \"echo oops; exit 1\" failed:\noops"))
+
+				assertCleanTempFile(depl.PrefixID())
+			})
+		})
+
+		Context("when a hook times out", func() {
+			var origPreBuildHookTimeout time.Duration
+
+			BeforeEach(func() {
+				origPreBuildHookTimeout = builder.PreBuildHookTimeout
+				builder.PreBuildHookTimeout = 100 * time.Millisecond
+
+				command := "sleep 5"
+				Expect(db.Create(&deployhook.DeployHook{
+					ProjectID: proj.ID,
+					Stage:     deployhook.StagePreBuild,
+					Command:   &command,
+				}).Error).To(BeNil())
+			})
+
+			AfterEach(func() {
+				builder.PreBuildHookTimeout = origPreBuildHookTimeout
+			})
+
+			It("marks the deployment as failed", func() {
+				err = builder.Work([]byte(fmt.Sprintf(`{
+					"deployment_id": %d,
+					"archive_format": "tar.gz"
+				}`, depl.ID)))
+				Expect(err).To(Equal(builder.ErrPreBuildHookFailed))
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StateBuildFailed))
+				Expect(*depl.ErrorMessage).To(Equal(`The pre-build hook "sleep 5" failed because it did not finish within 100ms.`))
+			})
+		})
+	})
+
+	Context("when the bundle has a package.json with a build script", func() {
+		var (
+			origOptimizerCmd    func(string, string, []string) *exec.Cmd
+			origPackageBuildCmd func(string, string, string) *exec.Cmd
+
+			files        map[string]string
+			buildScript  string
+			buildCommand string
+		)
+
+		BeforeEach(func() {
+			origOptimizerCmd = builder.OptimizerCmd
+			builder.OptimizerCmd = func(cn string, srcDir string, domainNames []string) *exec.Cmd {
+				return exec.Command("true")
+			}
+
+			// The build is faked with a script, as npm is not run in tests.
+			buildScript = "mkdir -p dist && cp src/index.html dist/index.html && echo built"
+			buildCommand = ""
+			origPackageBuildCmd = builder.PackageBuildCmd
+			builder.PackageBuildCmd = func(cn string, srcDir string, command string) *exec.Cmd {
+				buildCommand = command
+				cmd := exec.Command("sh", "-c", buildScript)
+				cmd.Dir = srcDir
+				return cmd
+			}
+
+			files = map[string]string{
+				"package.json":   `{"scripts": {"build": "webpack"}}`,
+				"src/index.html": "<h1>hello</h1>",
+			}
+		})
+
+		JustBeforeEach(func() {
+			buf := &bytes.Buffer{}
+			gw := gzip.NewWriter(buf)
+			tw := tar.NewWriter(gw)
+			for name, content := range files {
+				Expect(tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))})).To(BeNil())
+				_, err := tw.Write([]byte(content))
+				Expect(err).To(BeNil())
+			}
+			Expect(tw.Close()).To(BeNil())
+			Expect(gw.Close()).To(BeNil())
+			fakeS3.DownloadContent = buf.Bytes()
+		})
+
+		AfterEach(func() {
+			builder.OptimizerCmd = origOptimizerCmd
+			builder.PackageBuildCmd = origPackageBuildCmd
+		})
+
+		uploadedFiles := func() map[string]string {
+			uploadCall := fakeS3.UploadCalls.NthCall(1)
+			Expect(uploadCall).NotTo(BeNil())
+			uploadedContent, ok := uploadCall.SideEffects["uploaded_content"].([]byte)
+			Expect(ok).To(BeTrue())
+
+			gr, err := gzip.NewReader(bytes.NewBuffer(uploadedContent))
+			Expect(err).To(BeNil())
+			defer gr.Close()
+			tr := tar.NewReader(gr)
+
+			contents := map[string]string{}
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				Expect(err).To(BeNil())
+
+				content, err := ioutil.ReadAll(tr)
+				Expect(err).To(BeNil())
+				contents[hdr.Name] = string(content)
+			}
+			return contents
+		}
+
+		It("builds the bundle with npm and only uploads the output of the build", func() {
+			err = builder.Work([]byte(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+			Expect(err).To(BeNil())
+
+			Expect(buildCommand).To(Equal("npm install && npm run build"))
+			Expect(uploadedFiles()).To(Equal(map[string]string{
+				"index.html": "<h1>hello</h1>",
+			}))
+
+			d := testhelper.ConsumeQueue(mq, queues.Deploy)
+			Expect(d).NotTo(BeNil())
+			Expect(d.Body).To(MatchJSON(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"skip_webroot_upload": false,
+				"skip_invalidation": false,
+				"use_raw_bundle": false,
+				"archive_format": "tar.gz"
+			}`, depl.ID)))
+
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			Expect(depl.State).To(Equal(deployment.StatePendingDeploy))
+
+			assertCleanTempFile(depl.PrefixID())
+		})
+
+		Context("when the bundle has a yarn.lock", func() {
+			BeforeEach(func() {
+				files["yarn.lock"] = ""
+			})
+
+			It("builds the bundle with yarn", func() {
+				err = builder.Work([]byte(fmt.Sprintf(`{
+					"deployment_id": %d,
+					"archive_format": "tar.gz"
+				}`, depl.ID)))
+				Expect(err).To(BeNil())
+
+				Expect(buildCommand).To(Equal("yarn install --pure-lockfile && yarn run build"))
+			})
+		})
+
+		Context("when package.json does not have a build script", func() {
+			BeforeEach(func() {
+				files["package.json"] = `{"scripts": {"test": "mocha"}}`
+			})
+
+			It("does not build the bundle", func() {
+				err = builder.Work([]byte(fmt.Sprintf(`{
+					"deployment_id": %d,
+					"archive_format": "tar.gz"
+				}`, depl.ID)))
+				Expect(err).To(BeNil())
+
+				Expect(buildCommand).To(Equal(""))
+				Expect(uploadedFiles()).To(HaveKey("src/index.html"))
+			})
+		})
+
+		Context("when the build fails", func() {
+			BeforeEach(func() {
+				buildScript = "echo oops; exit 1"
+			})
+
+			It("marks the deployment as failed with the output of the build", func() {
+				err = builder.Work([]byte(fmt.Sprintf(`{
+					"deployment_id": %d,
+					"archive_format": "tar.gz"
+				}`, depl.ID)))
+				Expect(err).To(Equal(builder.ErrPackageBuildFailed))
+
+				// Only the build log is uploaded.
+				Expect(fakeS3.UploadCalls.Count()).To(Equal(1))
+				Expect(uploadedBuildLog(1)).To(ContainSubstring("Building with \"npm install && npm run build\"\noops\n"))
+				Expect(testhelper.ConsumeQueue(mq, queues.Deploy)).To(BeNil())
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StateBuildFailed))
+				Expect(*depl.ErrorMessage).To(Equal("The build (\"npm install && npm run build\") failed:\noops"))
+
+				assertCleanTempFile(depl.PrefixID())
+			})
+		})
+
+		Context("when the build does not output any files", func() {
+			BeforeEach(func() {
+				buildScript = "echo built"
+			})
+
+			It("marks the deployment as failed", func() {
+				err = builder.Work([]byte(fmt.Sprintf(`{
+					"deployment_id": %d,
+					"archive_format": "tar.gz"
+				}`, depl.ID)))
+				Expect(err).To(Equal(builder.ErrPackageBuildFailed))
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StateBuildFailed))
+				Expect(*depl.ErrorMessage).To(ContainSubstring("did not output any files"))
+			})
+		})
+
+		Context("when the project skips builds", func() {
+			BeforeEach(func() {
+				Expect(db.Model(proj).UpdateColumn("skip_build", true).Error).To(BeNil())
+				Expect(db.Model(proj).UpdateColumn("asset_fingerprinting", true).Error).To(BeNil())
+			})
+
+			It("does not build the bundle", func() {
+				err = builder.Work([]byte(fmt.Sprintf(`{
+					"deployment_id": %d,
+					"archive_format": "tar.gz"
+				}`, depl.ID)))
+				Expect(err).To(BeNil())
+
+				Expect(buildCommand).To(Equal(""))
+				Expect(uploadedFiles()).To(HaveKey("package.json"))
+			})
+		})
+	})
+
 	Context("when the project is locked", func() {
 		BeforeEach(func() {
 			lockedTime := time.Now().Add(-time.Minute)
@@ -6,7 +6,10 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/jobrecord"
 	"github.com/nitrous-io/rise-server/deployer/deployer"
+	"github.com/nitrous-io/rise-server/pkg/job"
 	"github.com/nitrous-io/rise-server/pkg/mqconn"
 	"github.com/nitrous-io/rise-server/shared/queues"
 	"github.com/streadway/amqp"
@@ -20,6 +23,13 @@ func main() {
 }
 
 func run() {
+	db, err := dbconn.DB()
+	if err != nil {
+		log.Errorln("Failed to connect to db:", err)
+		return
+	}
+	job.DefaultRecorder = &jobrecord.Recorder{DB: db}
+
 	mq, err := mqconn.MQ()
 	if err != nil {
 		log.Errorln("Failed to connect to mq:", err)
@@ -92,6 +102,16 @@ func run() {
 	for {
 		select {
 		case d := <-msgCh:
+			if err := job.Verify(d); err != nil {
+				// Drop messages that were not enqueued by us.
+				log.WithFields(log.Fields{"queue": queueName}).Errorf("Rejected message, err: %v, message: %s", err, d.Body)
+				if err := d.Ack(false); err != nil {
+					log.WithFields(log.Fields{"queue": queueName}).Warnln("Failed to Ack message:", err)
+				}
+				continue
+			}
+
+			job.Started(d)
 			err = deployer.Work(d.Body)
 
 			if err != nil {
@@ -99,14 +119,21 @@ func run() {
 				log.Warnln("Work failed", err, string(d.Body))
 
 				// It does not retry for timeout or record not found error or unarchive failed
-				// because it could retry for long time.
+				// because it could retry for long time. Bundles that exceed
+				// the limits of their project would fail again.
+				_, isLimitErr := err.(*deployer.BundleLimitError)
 				if err == deployer.ErrTimeout ||
 					err == deployer.ErrRecordNotFound ||
-					err == deployer.ErrUnarchiveFailed {
+					err == deployer.ErrUnarchiveFailed ||
+					err == deployer.ErrRootDirNotFound ||
+					err == deployer.ErrVerificationFailed ||
+					isLimitErr {
+					job.Finished(d, err, false)
 					if err := d.Ack(false); err != nil {
 						log.WithFields(log.Fields{"queue": queueName}).Warnln("Failed to Ack message:", err)
 					}
 				} else {
+					job.Finished(d, err, true)
 					go func() {
 						// nack after a delay to prevent thrashing
 						time.Sleep(1 * time.Second)
@@ -117,6 +144,7 @@ func run() {
 				}
 			} else {
 				// success
+				job.Finished(d, nil, false)
 				if err := d.Ack(false); err != nil {
 					log.WithFields(log.Fields{"queue": queueName}).Warnln("Failed to Ack message:", err)
 				}
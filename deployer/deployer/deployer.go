@@ -11,7 +11,6 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
-	"mime"
 	"os"
 	"path"
 	"path/filepath"
@@ -23,15 +22,20 @@ import (
 	"github.com/jinzhu/gorm"
 	"github.com/nitrous-io/rise-server/apiserver/common"
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployhook"
 	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/domain"
 	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/quotaevent"
 	"github.com/nitrous-io/rise-server/apiserver/models/rawbundle"
 	"github.com/nitrous-io/rise-server/apiserver/models/user"
 	"github.com/nitrous-io/rise-server/pkg/filetransfer"
-	"github.com/nitrous-io/rise-server/pkg/pubsub"
-	"github.com/nitrous-io/rise-server/shared/exchanges"
+	"github.com/nitrous-io/rise-server/pkg/fingerprint"
+	"github.com/nitrous-io/rise-server/pkg/rootdir"
+	"github.com/nitrous-io/rise-server/shared/invalidation"
 	"github.com/nitrous-io/rise-server/shared/messages"
 	"github.com/nitrous-io/rise-server/shared/mimetypes"
+	"github.com/nitrous-io/rise-server/shared/progress"
 	"github.com/nitrous-io/rise-server/shared/s3client"
 )
 
@@ -40,11 +44,38 @@ var (
 	ErrRecordNotFound  = errors.New("project or deployment is deleted")
 	ErrTimeout         = errors.New("failed to upload files due to timeout on uploading to s3")
 	ErrUnarchiveFailed = errors.New("Failed to unarchive file")
+	ErrRootDirNotFound = errors.New("root directory not found in bundle")
+	// ErrVerificationFailed is returned when a deployment is missing one of
+	// its verification URLs.
+	ErrVerificationFailed = errors.New("verification URLs not found in bundle")
 
 	MaxFileSizeToWatermark int64 = 5 * 1000 * 1000 // in bytes
-	UploadTimeout                = 3 * time.Minute
+
+	// UploadTimeout is how long uploading the files of a bundle may take. For
+	// tar.gz bundles, it includes downloading the bundle, as files are
+	// uploaded while the bundle is being downloaded.
+	UploadTimeout = 3 * time.Minute
+
+	// LockTTL is how long the project stays locked during a deploy if the
+	// deployer crashes before unlocking it.
+	LockTTL = 15 * time.Minute
+
+	// From http://docs.aws.amazon.com/AmazonS3/latest/dev/UsingMetadata.html#object-keys
+	// Add @ as an exceptional
+	invalidPathElementRe = regexp.MustCompile("[^0-9A-Za-z,!_'()\\.\\*\\-@]+")
 )
 
+// BundleLimitError is returned when a bundle has more files, or larger files,
+// than its project allows. Its message is shown to the user.
+type BundleLimitError struct {
+	Code    string
+	Message string
+}
+
+func (e *BundleLimitError) Error() string {
+	return e.Message
+}
+
 var jsenvFormat = `(function(global, env) {
 	if (typeof module === "object" && typeof module.exports === "object") {
 		module.exports = env;
@@ -103,7 +134,7 @@ func Work(data []byte) error {
 		return err
 	}
 
-	acquired, err := proj.Lock(db)
+	acquired, err := proj.LockFor(db, project.LockHolder(fmt.Sprintf("deployer (deployment %d)", depl.ID)), LockTTL)
 	if err != nil {
 		return err
 	}
@@ -118,6 +149,10 @@ func Work(data []byte) error {
 		}
 	}()
 
+	if d.PrefixRotation != nil {
+		return rotatePrefix(db, proj, depl, d.PrefixRotation, !d.SkipInvalidation)
+	}
+
 	if proj.Name != "help" && proj.Name != "pubstorm-blog" && proj.Name != "pubstorm-www" && proj.Name != "nitrous-www" {
 		var errorMessage = "Project deployments and new account sign ups are no longer accepted. For more information, please visit https://www.pubstorm.com/"
 		depl.ErrorMessage = &errorMessage
@@ -130,14 +165,26 @@ func Work(data []byte) error {
 		return errUnexpectedState
 	}
 
+	start := time.Now()
 	prefixID := depl.PrefixID()
 
+	// The manifest of the deployed files, which is only known if the files
+	// are uploaded.
+	var manifest *Manifest
+
+	settings, err := depl.SettingsData()
+	if err != nil {
+		return err
+	}
+
 	if !d.SkipWebrootUpload {
 		// Disallow re-deploying a deployed project.
 		if depl.State == deployment.StateDeployed {
 			return errUnexpectedState
 		}
 
+		progress.Publish(depl.ID, "Uploading files")
+
 		archiveFormat := d.ArchiveFormat
 		if archiveFormat == "" {
 			archiveFormat = "tar.gz"
@@ -158,33 +205,42 @@ func Work(data []byte) error {
 			}
 		}
 
-		f, err := ioutil.TempFile("", prefixID+"-optimized-bundle."+archiveFormat)
-		if err != nil {
-			return err
-		}
-		defer func() {
-			f.Close()
-			os.Remove(f.Name())
-		}()
-
-		if err := S3.Download(s3client.BucketRegion, s3client.BucketName, bundlePath, f); err != nil {
-			return err
-		}
+		tempFilePrefix := prefixID + "-optimized-bundle." + archiveFormat
 
 		// webroot is a publicly readable directory on S3.
 		webroot := "deployments/" + prefixID + "/webroot"
 
-		// From http://docs.aws.amazon.com/AmazonS3/latest/dev/UsingMetadata.html#object-keys
-		// Add @ as an exceptional
-		r := regexp.MustCompile("[^0-9A-Za-z,!_'()\\.\\*\\-@]+")
+		contentTypeOverrides, err := proj.ContentTypeOverridesMap()
+		if err != nil {
+			return err
+		}
+
 		done := make(chan struct{})
 		errCh := make(chan error)
+
+		// Only the files in the root dir of the deployment are deployed. Optimized
+		// bundles only contain those files already.
+		bundleRootDir := ""
+		if d.UseRawBundle {
+			bundleRootDir = depl.RootDir
+		}
+		uploaded := 0
+		// The files that were uploaded, for directory listings and the report
+		// of the deployment.
+		var uploadedFiles []*deployment.FileSize
+		manifest = newManifest()
 		if archiveFormat == "tar.gz" {
-			go func() {
-				gr, err := gzip.NewReader(f)
+			// Files are uploaded as they are extracted from the bundle while it
+			// is being downloaded, instead of after the whole bundle has been
+			// downloaded to disk.
+			uploadTarGz := func(bundle io.Reader) error {
+				uploaded = 0
+				uploadedFiles = nil
+				manifest = newManifest()
+
+				gr, err := gzip.NewReader(bundle)
 				if err != nil {
-					errCh <- ErrUnarchiveFailed
-					return
+					return ErrUnarchiveFailed
 				}
 				defer gr.Close()
 				tr := tar.NewReader(gr)
@@ -195,36 +251,32 @@ func Work(data []byte) error {
 						if err == io.EOF {
 							break
 						}
-						errCh <- err
-						return
+						return err
 					}
 
 					if hdr.FileInfo().IsDir() {
 						continue
 					}
 
-					fileName := path.Clean(hdr.Name)
-					remotePath := webroot + "/" + fileName
+					fileName, ok := rootdir.Rel(bundleRootDir, path.Clean(hdr.Name))
+					if !ok {
+						continue
+					}
+					uploaded++
 
-					// Skip file with invalid filename
-					pathElements := strings.Split(fileName, string(filepath.Separator))
-					isValidFileName := true
-					for _, pathElement := range pathElements {
-						if r.MatchString(pathElement) {
-							isValidFileName = false
-							break
-						}
+					if err := checkBundleLimits(proj, uploaded, fileName, hdr.Size); err != nil {
+						return err
 					}
 
-					if !isValidFileName {
+					remotePath := webroot + "/" + fileName
+
+					// Skip file with invalid filename
+					if !isValidFileName(fileName) {
 						log.Printf("filename contains invalid character: %q", fileName)
 						continue
 					}
 
-					contentType := mime.TypeByExtension(filepath.Ext(fileName))
-					if i := strings.Index(contentType, ";"); i != -1 {
-						contentType = contentType[:i]
-					}
+					contentType := mimetypes.TypeByPath(fileName, contentTypeOverrides)
 
 					var rdr io.Reader = tr
 
@@ -244,15 +296,40 @@ func Work(data []byte) error {
 						}
 					}
 
-					if err := S3.Upload(s3client.BucketRegion, s3client.BucketName, remotePath, rdr, contentType, "public-read"); err != nil {
-						errCh <- err
-						return
+					headers := webrootHeaders(proj, fileName, contentType)
+					rdr, digest := digestReader(rdr, headers)
+					if err := uploadWebrootFile(remotePath, rdr, headers, hdr.Size, settings.Precompress); err != nil {
+						return err
 					}
+					uploadedFiles = append(uploadedFiles, &deployment.FileSize{Path: fileName, Size: hdr.Size})
+					manifest.add(fileName, digest)
 				}
 
+				if uploaded == 0 && bundleRootDir != "" {
+					return ErrRootDirNotFound
+				}
+				return nil
+			}
+
+			go func() {
+				if err := streamBundle(bundlePath, tempFilePrefix, uploadTarGz); err != nil {
+					errCh <- err
+					return
+				}
 				close(done)
 			}()
 		} else if archiveFormat == "zip" {
+			// Zip archives cannot be read as a stream, as the list of files is
+			// at the end of the archive.
+			f, err := downloadBundle(bundlePath, tempFilePrefix)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				f.Close()
+				os.Remove(f.Name())
+			}()
+
 			go func() {
 				r, err := zip.OpenReader(f.Name())
 				if err != nil {
@@ -261,23 +338,39 @@ func Work(data []byte) error {
 				}
 				defer r.Close()
 
+				// The files of zip archives are known up front, so the limits
+				// are checked before any file is uploaded.
+				n := 0
 				for _, file := range r.File {
-					rc, err := file.Open()
-					if err != nil {
-						errCh <- err
-						return
+					name := rootdir.ZipName(file.Name)
+					if file.FileInfo().IsDir() || strings.HasSuffix(name, "/") {
+						continue
 					}
-					defer rc.Close()
 
-					if file.FileInfo().IsDir() {
+					fileName, ok := rootdir.Rel(bundleRootDir, path.Clean(name))
+					if !ok {
 						continue
 					}
-					remotePath := webroot + "/" + file.Name
+					n++
 
-					contentType := mime.TypeByExtension(filepath.Ext(file.Name))
-					if i := strings.Index(contentType, ";"); i != -1 {
-						contentType = contentType[:i]
+					if err := checkBundleLimits(proj, n, fileName, int64(file.UncompressedSize64)); err != nil {
+						errCh <- err
+						return
 					}
+				}
+
+				// uploadZipFile uploads a file of the archive, and closes it
+				// before the next file is opened.
+				uploadZipFile := func(file *zip.File, fileName string) error {
+					rc, err := file.Open()
+					if err != nil {
+						return ErrUnarchiveFailed
+					}
+					defer rc.Close()
+
+					remotePath := webroot + "/" + fileName
+
+					contentType := mimetypes.TypeByPath(fileName, contentTypeOverrides)
 
 					var rdr io.Reader = rc
 
@@ -293,15 +386,48 @@ func Work(data []byte) error {
 						if err != nil {
 							// Log and skip this file.
 							log.Printf("failed to inject watermark to %q, err: %v", file.Name, err)
-							continue
+							return nil
 						}
 					}
 
-					if err := S3.Upload(s3client.BucketRegion, s3client.BucketName, remotePath, rdr, contentType, "public-read"); err != nil {
+					headers := webrootHeaders(proj, fileName, contentType)
+					rdr, digest := digestReader(rdr, headers)
+					if err := uploadWebrootFile(remotePath, rdr, headers, file.FileInfo().Size(), settings.Precompress); err != nil {
+						return err
+					}
+					uploadedFiles = append(uploadedFiles, &deployment.FileSize{Path: fileName, Size: file.FileInfo().Size()})
+					manifest.add(fileName, digest)
+					return nil
+				}
+
+				for _, file := range r.File {
+					name := rootdir.ZipName(file.Name)
+					if file.FileInfo().IsDir() || strings.HasSuffix(name, "/") {
+						continue
+					}
+
+					fileName, ok := rootdir.Rel(bundleRootDir, path.Clean(name))
+					if !ok {
+						continue
+					}
+					uploaded++
+
+					// Skip file with invalid filename
+					if !isValidFileName(fileName) {
+						log.Printf("filename contains invalid character: %q", fileName)
+						continue
+					}
+
+					if err := uploadZipFile(file, fileName); err != nil {
 						errCh <- err
 						return
 					}
 				}
+
+				if uploaded == 0 && bundleRootDir != "" {
+					errCh <- ErrRootDirNotFound
+					return
+				}
 				close(done)
 			}()
 		}
@@ -309,6 +435,24 @@ func Work(data []byte) error {
 		select {
 		case <-done:
 		case err := <-errCh:
+			var errorMessage, errorCode string
+			if err == ErrRootDirNotFound {
+				errorMessage = fmt.Sprintf("The root directory %q could not be found in your bundle.", depl.RootDir)
+				errorCode = deployment.ErrorCodeRootDirNotFound
+			} else if err == ErrUnarchiveFailed {
+				errorMessage = "Your bundle could not be unpacked. Bundles must be zip or gzipped tar archives."
+				errorCode = deployment.ErrorCodeInvalidBundle
+			} else if limitErr, ok := err.(*BundleLimitError); ok {
+				errorMessage, errorCode = limitErr.Message, limitErr.Code
+			}
+
+			if errorMessage != "" {
+				depl.ErrorMessage = &errorMessage
+				depl.ErrorCode = &errorCode
+				if err := depl.UpdateState(db, deployment.StateDeployFailed); err != nil {
+					fmt.Printf("Failed to update deployment state for %s due to %v", prefixID, err)
+				}
+			}
 			return err
 		case <-time.After(UploadTimeout):
 			errorMessage := "Timed out due to too many files"
@@ -320,62 +464,87 @@ func Work(data []byte) error {
 			return ErrTimeout
 		}
 
-		var envvars map[string]string
-		if err := json.Unmarshal(depl.JsEnvVars, &envvars); err != nil {
+		if missing := missingVerificationURLs(settings.VerificationURLs, uploadedFiles, proj.IndexDocument); len(missing) > 0 {
+			errorMessage := fmt.Sprintf("Your deployment is missing the verification URLs %s.", strings.Join(missing, ", "))
+			errorCode := deployment.ErrorCodeVerificationFailed
+			depl.ErrorMessage = &errorMessage
+			depl.ErrorCode = &errorCode
+			if err := depl.UpdateState(db, deployment.StateDeployFailed); err != nil {
+				fmt.Printf("Failed to update deployment state for %s due to %v", prefixID, err)
+			}
+			return ErrVerificationFailed
+		}
+
+		progress.Publish(depl.ID, "Uploaded %d files", len(uploadedFiles))
+
+		if proj.DirectoryListings {
+			if err := uploadDirectoryListings(webroot, uploadedFiles, proj.IndexDocument, manifest); err != nil {
+				return err
+			}
+		}
+
+		if err := depl.SaveReport(db, deployment.NewReport(uploadedFiles)); err != nil {
+			return err
+		}
+
+		envvars, err := depl.DecryptedJsEnvVars(common.AesKeyring())
+		if err != nil {
+			return err
+		}
+
+		envvarsJSON, err := json.Marshal(envvars)
+		if err != nil {
 			return err
 		}
 
+		jsenv := []byte(fmt.Sprintf(jsenvFormat, envvarsJSON))
 		if err := S3.Upload(s3client.BucketRegion,
 			s3client.BucketName,
 			webroot+"/jsenv.js",
-			bytes.NewBufferString(fmt.Sprintf(jsenvFormat, depl.JsEnvVars)),
+			bytes.NewReader(jsenv),
 			"application/javascript",
 			"public-read"); err != nil {
 			return err
 		}
-	}
+		manifest.addContent("jsenv.js", &filetransfer.Headers{ContentType: "application/javascript"}, jsenv)
 
-	// the metadata file is also publicly readable, do not put sensitive data
-	metaJson, err := json.Marshal(struct {
-		Prefix            string  `json:"prefix"`
-		ForceHTTPS        bool    `json:"force_https,omitempty"`
-		BasicAuthUsername *string `json:"basic_auth_username,omitempty"`
-		BasicAuthPassword *string `json:"basic_auth_password,omitempty"`
-	}{
-		prefixID,
-		proj.ForceHTTPS,
-		proj.BasicAuthUsername,
-		proj.EncryptedBasicAuthPassword,
-	})
-
-	if err != nil {
-		return err
-	}
-
-	domainNames, err := proj.DomainNames(db)
-	if err != nil {
-		return err
+		// Without a manifest, the next deployment purges whole domains from
+		// edges, so failing to upload it does not fail the deployment.
+		if err := uploadManifest(depl, manifest); err != nil {
+			log.Printf("failed to upload manifest of deployment %d, err: %v", depl.ID, err)
+		}
 	}
 
-	// Upload metadata file for each domain.
-	reader := bytes.NewReader(metaJson)
-	for _, domain := range domainNames {
-		reader.Seek(0, 0)
-		if err := S3.Upload(s3client.BucketRegion, s3client.BucketName, "domains/"+domain+"/meta.json", reader, "application/json", "public-read"); err != nil {
+	var domainNames []string
+	if depl.Preview {
+		// Previews are only served at their own domain, which no edge has
+		// cached files of, so there is nothing to invalidate.
+		if err := publishPreviewMeta(proj, depl, settings); err != nil {
 			return err
 		}
-	}
-
-	if !d.SkipInvalidation {
-		m, err := pubsub.NewMessageWithJSON(exchanges.Edges, exchanges.RouteV1Invalidation, &messages.V1InvalidationMessageData{
-			Domains: domainNames,
-		})
+	} else {
+		domainNames, err = publishMeta(db, proj, prefixID, settings)
 		if err != nil {
 			return err
 		}
 
-		if err := m.Publish(); err != nil {
-			return err
+		if !d.SkipInvalidation {
+			progress.Publish(depl.ID, "Invalidating caches of %d domains", len(domainNames))
+
+			// If only some files changed since the active deployment, edges
+			// only purge those, so that the files that did not change stay
+			// cached.
+			if paths, ok := deltaInvalidationPaths(db, proj, depl, manifest); ok {
+				domainPaths := make(map[string][]string, len(domainNames))
+				for _, domName := range domainNames {
+					domainPaths[domName] = paths
+				}
+				if err := invalidation.InvalidatePaths(domainPaths); err != nil {
+					return err
+				}
+			} else if err := invalidation.Invalidate(domainNames); err != nil {
+				return err
+			}
 		}
 	}
 
@@ -389,16 +558,27 @@ func Work(data []byte) error {
 		return err
 	}
 
-	if err := tx.Model(project.Project{}).Where("id = ?", proj.ID).Update("active_deployment_id", &depl.ID).Error; err != nil {
-		return err
+	// Updating the meta.json of a deployment, e.g. on rollback, is not a
+	// deploy of its files.
+	if !d.SkipWebrootUpload {
+		if err := depl.RecordDuration(tx, deployment.PhaseDeploy, time.Since(start)); err != nil {
+			return err
+		}
 	}
 
-	// If project has exceeded its max number of deployments (N), we soft delete
-	// deployments older than the last N deployments.
-	if proj.MaxDeploysKept > 0 {
-		if err := deployment.DeleteExceptLastN(tx, proj.ID, proj.MaxDeploysKept); err != nil {
+	// Previews do not replace the active deployment until they are promoted.
+	if !depl.Preview {
+		if err := tx.Model(project.Project{}).Where("id = ?", proj.ID).Update("active_deployment_id", &depl.ID).Error; err != nil {
 			return err
 		}
+
+		// If project has exceeded its max number of deployments (N), we soft
+		// delete deployments older than the last N deployments.
+		if proj.MaxDeploysKept > 0 {
+			if err := deployment.DeleteExceptLastN(tx, proj.ID, proj.MaxDeploysKept); err != nil {
+				return err
+			}
+		}
 	}
 
 	if err := tx.Commit().Error; err != nil {
@@ -417,9 +597,31 @@ func Work(data []byte) error {
 					"deploymentPrefix":   depl.Prefix,
 					"deploymentVersion":  depl.Version,
 					"timeTakenInSeconds": int64(timeTaken / time.Second),
+					"preview":            depl.Preview,
 				}
 				context map[string]interface{}
 			)
+			stats := map[string]*int64{
+				"uploadTimeInMilliseconds": depl.UploadDurationMs,
+				"buildTimeInMilliseconds":  depl.BuildDurationMs,
+				"deployTimeInMilliseconds": depl.DeployDurationMs,
+				"webrootSizeInBytes":       depl.WebrootSize,
+			}
+			for name, v := range stats {
+				if v != nil {
+					props[name] = *v
+				}
+			}
+			vcs := map[string]string{
+				"commitSha":     depl.CommitSHA,
+				"commitMessage": depl.CommitMessage,
+				"branch":        depl.Branch,
+			}
+			for name, v := range vcs {
+				if v != "" {
+					props[name] = v
+				}
+			}
 			if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
 				log.Printf("failed to track %q event for user ID %d, err: %v",
 					event, u.ID, err)
@@ -427,5 +629,314 @@ func Work(data []byte) error {
 		}
 	}
 
+	// Post-deploy hooks are run when a preview is promoted instead, as they
+	// are meant for deployments that go live. Jobs that only republish the
+	// meta.json of the active deployment, e.g. after its settings or domains
+	// changed, do not run them either, but rollbacks do.
+	if depl.Preview {
+		return nil
+	}
+	if d.SkipWebrootUpload && proj.ActiveDeploymentID != nil && *proj.ActiveDeploymentID == depl.ID {
+		return nil
+	}
+
+	hooks, err := deployhook.FindByProjectID(db, proj.ID, deployhook.StagePostDeploy)
+	if err != nil {
+		log.Printf("failed to fetch post-deploy hooks of project %d, err: %v", proj.ID, err)
+	} else if len(hooks) > 0 {
+		runPostDeployHooks(db, hooks, proj, depl, domainNames)
+	}
+
 	return nil
 }
+
+// meta is the meta.json of a domain, which tells edges which prefix to serve
+// the domain's files from and how to serve them. The meta.json is publicly
+// readable, do not put sensitive data in it.
+type meta struct {
+	Prefix            string                     `json:"prefix"`
+	ForceHTTPS        bool                       `json:"force_https,omitempty"`
+	Noindex           bool                       `json:"noindex,omitempty"`
+	BasicAuthUsername *string                    `json:"basic_auth_username,omitempty"`
+	BasicAuthPassword *string                    `json:"basic_auth_password,omitempty"`
+	BasicAuthRealm    *string                    `json:"basic_auth_realm,omitempty"`
+	BasicAuthPage     *string                    `json:"basic_auth_page,omitempty"`
+	IndexDocument     string                     `json:"index_document,omitempty"`
+	DirectoryListings bool                       `json:"directory_listings,omitempty"`
+	SecurityHeaders   map[string]string          `json:"security_headers,omitempty"`
+	LanguageRedirects []project.LanguageRedirect `json:"language_redirects,omitempty"`
+	TLS               *domain.TLSPolicy          `json:"tls,omitempty"`
+	Precompressed     bool                       `json:"precompressed,omitempty"`
+	CachePolicy       string                     `json:"cache_policy,omitempty"`
+	Quotas            *domain.Quotas             `json:"quotas,omitempty"`
+
+	// BandwidthExceededMode is only set to project.BandwidthExceededDegrade,
+	// which tells edges not to block the domain once it reaches its hard
+	// bandwidth quota, but to wait for Degraded to be set instead.
+	BandwidthExceededMode string  `json:"bandwidth_exceeded_mode,omitempty"`
+	BandwidthExceededPage *string `json:"bandwidth_exceeded_page,omitempty"`
+	Degraded              bool    `json:"degraded,omitempty"`
+}
+
+// newMeta returns the meta.json fields that are the same for every domain of
+// the project. settings are the settings of the deployment at the prefix.
+func newMeta(proj *project.Project, prefixID string, settings *deployment.Settings) (*meta, error) {
+	securityHeaders, err := proj.SecurityHeaders()
+	if err != nil {
+		return nil, err
+	}
+
+	languageRedirects, err := proj.LanguageRedirectsList()
+	if err != nil {
+		return nil, err
+	}
+
+	// The realm and 401 page are only used when basic auth is enabled.
+	var basicAuthRealm, basicAuthPage *string
+	if proj.BasicAuthUsername != nil {
+		basicAuthRealm, basicAuthPage = proj.BasicAuthRealm, proj.BasicAuthPage
+	}
+
+	// Edges serve index.html unless told otherwise.
+	indexDocument := proj.IndexDocument
+	if indexDocument == "index.html" {
+		indexDocument = ""
+	}
+
+	// Edges serve files with their default Cache-Control header unless told
+	// otherwise.
+	cachePolicy := settings.CachePolicy
+	if cachePolicy == deployment.CachePolicyDefault {
+		cachePolicy = ""
+	}
+
+	return &meta{
+		Prefix:            prefixID,
+		ForceHTTPS:        proj.ForceHTTPS,
+		BasicAuthUsername: proj.BasicAuthUsername,
+		BasicAuthPassword: proj.EncryptedBasicAuthPassword,
+		BasicAuthRealm:    basicAuthRealm,
+		BasicAuthPage:     basicAuthPage,
+		IndexDocument:     indexDocument,
+		DirectoryListings: proj.DirectoryListings,
+		SecurityHeaders:   securityHeaders,
+		LanguageRedirects: languageRedirects,
+		Precompressed:     settings.Precompress,
+		CachePolicy:       cachePolicy,
+	}, nil
+}
+
+// uploadMeta uploads m as the meta.json of the domain.
+func uploadMeta(domName string, m *meta) error {
+	metaJson, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	return S3.Upload(s3client.BucketRegion, s3client.BucketName, "domains/"+domName+"/meta.json", bytes.NewReader(metaJson), "application/json", "public-read")
+}
+
+// publishMeta uploads the meta.json of each domain of the project, and returns
+// the domain names. settings are the settings of the deployment at the prefix.
+func publishMeta(db *gorm.DB, proj *project.Project, prefixID string, settings *deployment.Settings) ([]string, error) {
+	domainNames, err := proj.DomainNames(db)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := newMeta(proj, prefixID, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	// Domains are served with the TLS policy selected for them, except for the
+	// default domain, which has no domain record.
+	doms, err := domain.FindActiveByProjectID(db, proj.ID)
+	if err != nil {
+		return nil, err
+	}
+	tlsPolicies := make(map[string]*domain.TLSPolicy, len(doms))
+	for _, dom := range doms {
+		tlsPolicies[dom.Name] = dom.PublishedTLSPolicy()
+	}
+
+	// Edges stop serving a domain for the rest of the day once it reaches
+	// one of its hard quotas. The default domain has the quotas of the plan.
+	quotas := make(map[string]*domain.Quotas, len(domainNames))
+	quotas[proj.DefaultDomainName()] = domain.PlanQuotas(proj.DomainRequestLimit(), proj.DomainBandwidthLimit())
+	for _, dom := range doms {
+		quotas[dom.Name] = dom.Quotas(proj.DomainRequestLimit(), proj.DomainBandwidthLimit())
+	}
+	for name, q := range quotas {
+		if q.IsEmpty() {
+			delete(quotas, name)
+		}
+	}
+
+	// Domains of projects that degrade instead are degraded once they are
+	// over their hard bandwidth quota today. The enforcequotas job
+	// republishes meta.json when a domain goes over its quota and when the
+	// quota resets.
+	degrade := proj.BandwidthExceededMode == project.BandwidthExceededDegrade
+	var bandwidthExceeded map[string]bool
+	if degrade {
+		bandwidthExceeded, err = quotaevent.BandwidthExceededDomainNames(db, proj.ID, time.Now().UTC().Truncate(24*time.Hour))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Upload metadata file for each domain.
+	for _, domName := range domainNames {
+		// The edge serves "X-Robots-Tag: noindex" and a disallow-all
+		// robots.txt for noindex domains, so that preview URLs are never
+		// indexed by search engines.
+		m.Noindex = proj.NoindexDefaultDomain && domName == proj.DefaultDomainName()
+		m.TLS = tlsPolicies[domName]
+		m.Quotas = quotas[domName]
+
+		m.BandwidthExceededMode, m.BandwidthExceededPage, m.Degraded = "", nil, false
+		if degrade && m.Quotas != nil && m.Quotas.Bandwidth != nil {
+			m.BandwidthExceededMode = project.BandwidthExceededDegrade
+			m.BandwidthExceededPage = proj.BandwidthExceededPage
+			m.Degraded = bandwidthExceeded[domName]
+		}
+
+		if err := uploadMeta(domName, m); err != nil {
+			return nil, err
+		}
+	}
+
+	return domainNames, nil
+}
+
+// publishPreviewMeta uploads the meta.json of the preview domain of a preview
+// deployment. Preview domains are never indexed, and have no quotas, as they
+// are only visited by the reviewers of the deployment. Previews with their own
+// basic auth credentials are protected with those instead of the project's.
+func publishPreviewMeta(proj *project.Project, depl *deployment.Deployment, settings *deployment.Settings) error {
+	m, err := newMeta(proj, depl.PrefixID(), settings)
+	if err != nil {
+		return err
+	}
+	m.Noindex = true
+
+	if depl.PreviewBasicAuthUsername != nil {
+		m.BasicAuthUsername = depl.PreviewBasicAuthUsername
+		m.BasicAuthPassword = depl.EncryptedPreviewBasicAuthPassword
+		m.BasicAuthRealm, m.BasicAuthPage = proj.BasicAuthRealm, proj.BasicAuthPage
+	}
+
+	return uploadMeta(depl.PreviewDomainName(), m)
+}
+
+// streamBundle calls fn with the content of the bundle at bundlePath as it is
+// downloaded from S3. If reading from S3 fails midway, e.g. because the
+// connection was reset, the bundle is downloaded to a temp file and fn is
+// called again with the file, so fn has to be safe to retry.
+func streamBundle(bundlePath, tempFilePrefix string, fn func(io.Reader) error) error {
+	body, err := S3.Open(s3client.BucketRegion, s3client.BucketName, bundlePath)
+	if err != nil {
+		return err
+	}
+
+	sr := &sourceReader{r: body}
+	err = fn(sr)
+	body.Close()
+	if err == nil || sr.err == nil {
+		return err
+	}
+
+	log.Printf("failed to stream bundle %q, retrying from a temp file, err: %v", bundlePath, sr.err)
+
+	f, err := downloadBundle(bundlePath, tempFilePrefix)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+
+	return fn(f)
+}
+
+// downloadBundle downloads the bundle at bundlePath to a temp file. The caller
+// is responsible for closing and removing the file.
+func downloadBundle(bundlePath, tempFilePrefix string) (*os.File, error) {
+	f, err := ioutil.TempFile("", tempFilePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := S3.Download(s3client.BucketRegion, s3client.BucketName, bundlePath, f); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// sourceReader records the error that reading from r failed with, to tell
+// errors reading the bundle apart from errors extracting or uploading it.
+type sourceReader struct {
+	r   io.Reader
+	err error
+}
+
+func (sr *sourceReader) Read(p []byte) (int, error) {
+	n, err := sr.r.Read(p)
+	if err != nil && err != io.EOF {
+		sr.err = err
+	}
+	return n, err
+}
+
+// webrootHeaders returns the headers that the file of a deployment with the
+// given name is served with. Assets that were fingerprinted when the
+// deployment was built are cached for a year, as their names change whenever
+// their content does.
+func webrootHeaders(proj *project.Project, fileName, contentType string) *filetransfer.Headers {
+	h := &filetransfer.Headers{ContentType: contentType}
+	if proj.AssetFingerprinting && fingerprint.IsFingerprinted(fileName) {
+		h.CacheControl = fingerprint.CacheControl
+	}
+	return h
+}
+
+// checkBundleLimits returns a *BundleLimitError if the nth file of a bundle,
+// which has the given size, exceeds the limits of the project.
+func checkBundleLimits(proj *project.Project, n int, fileName string, size int64) error {
+	if maxFiles := proj.BundleFileLimit(); n > maxFiles {
+		return &BundleLimitError{
+			Code:    deployment.ErrorCodeTooManyFiles,
+			Message: fmt.Sprintf("Your bundle has more than %d files, which is the maximum number of files that can be deployed.", maxFiles),
+		}
+	}
+
+	if maxSize := proj.FileSizeLimit(); size > maxSize {
+		return &BundleLimitError{
+			Code:    deployment.ErrorCodeFileTooLarge,
+			Message: fmt.Sprintf("%q is %s, which is larger than the maximum file size of %s.", fileName, formatSize(size), formatSize(maxSize)),
+		}
+	}
+
+	return nil
+}
+
+// isValidFileName returns whether every element of the path of a file can be
+// used in an S3 object key. Files with other names are not deployed.
+func isValidFileName(fileName string) bool {
+	for _, pathElement := range strings.Split(fileName, string(filepath.Separator)) {
+		if invalidPathElementRe.MatchString(pathElement) {
+			return false
+		}
+	}
+	return true
+}
+
+// formatSize formats a size in bytes in megabytes, e.g. "12.3 MB".
+func formatSize(size int64) string {
+	return fmt.Sprintf("%.1f MB", float64(size)/(1000*1000))
+}
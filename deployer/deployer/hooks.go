@@ -0,0 +1,65 @@
+package deployer
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployhook"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+)
+
+type postDeployHookPayload struct {
+	Event   string   `json:"event"`
+	Project string   `json:"project"`
+	Domains []string `json:"domains"`
+
+	Deployment struct {
+		ID         uint       `json:"id"`
+		Version    int64      `json:"version"`
+		Prefix     string     `json:"prefix"`
+		DeployedAt *time.Time `json:"deployed_at"`
+	} `json:"deployment"`
+}
+
+// runPostDeployHooks POSTs a JSON payload describing the activated deployment
+// to each of the given hooks concurrently, and records each delivery so that
+// it can be inspected and replayed. Hooks are best-effort, so failures are
+// logged and do not fail the deployment.
+func runPostDeployHooks(db *gorm.DB, hooks []*deployhook.DeployHook, proj *project.Project, depl *deployment.Deployment, domainNames []string) {
+	payload := postDeployHookPayload{
+		Event:   "deployed",
+		Project: proj.Name,
+		Domains: domainNames,
+	}
+	payload.Deployment.ID = depl.ID
+	payload.Deployment.Version = depl.Version
+	payload.Deployment.Prefix = depl.Prefix
+	payload.Deployment.DeployedAt = depl.DeployedAt
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("failed to marshal post-deploy hook payload for deployment %d, err: %v", depl.ID, err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, hook := range hooks {
+		wg.Add(1)
+		go func(hook *deployhook.DeployHook) {
+			defer wg.Done()
+			d, err := hook.Deliver(db, depl.ID, body, nil)
+			if err != nil {
+				log.Printf("failed to record delivery of post-deploy hook %d of project %d, err: %v", hook.ID, proj.ID, err)
+				return
+			}
+			if !d.Succeeded() {
+				log.Printf("post-deploy hook %d of project %d failed, err: %s", hook.ID, proj.ID, *d.ErrorMessage)
+			}
+		}(hook)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,103 @@
+package deployer
+
+import (
+	"bytes"
+	"html/template"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/pkg/filetransfer"
+	"github.com/nitrous-io/rise-server/shared/s3client"
+)
+
+// DirectoryListingName is the name of the listing pages that are uploaded to
+// directories without an index document when directory listings are enabled.
+// Edges serve them for directory paths when meta.json has
+// "directory_listings": true.
+const DirectoryListingName = ".directory-listing.html"
+
+var listingTmpl = template.Must(template.New("listing").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Index of {{.Dir}}</title>
+<style>body{font-family:sans-serif;margin:2em}li{line-height:1.6}</style>
+</head>
+<body>
+<h1>Index of {{.Dir}}</h1>
+<ul>
+{{if ne .Dir "/"}}<li><a href="../">../</a></li>
+{{end}}{{range .Entries}}<li><a href="{{.}}">{{.}}</a></li>
+{{end}}</ul>
+</body>
+</html>
+`))
+
+// directoryListings returns the entries of each directory of a webroot that
+// does not have an index document, by directory path (e.g. "/" or "/docs/").
+// Subdirectories are listed with a trailing slash.
+func directoryListings(files []*deployment.FileSize, indexDocument string) map[string][]string {
+	entries := map[string]map[string]bool{"/": {}}
+	hasIndex := map[string]bool{}
+
+	for _, f := range files {
+		dir, name := path.Split("/" + strings.TrimPrefix(f.Path, "/"))
+		if name == DirectoryListingName {
+			continue
+		}
+		if name == indexDocument {
+			hasIndex[dir] = true
+		}
+
+		// Add the file to its directory, and each directory to its parent.
+		for {
+			if entries[dir] == nil {
+				entries[dir] = map[string]bool{}
+			}
+			entries[dir][name] = true
+			if dir == "/" {
+				break
+			}
+			dir, name = path.Split(strings.TrimSuffix(dir, "/"))
+			name += "/"
+		}
+	}
+
+	listings := map[string][]string{}
+	for dir, names := range entries {
+		if hasIndex[dir] {
+			continue
+		}
+
+		listing := make([]string, 0, len(names))
+		for name := range names {
+			listing = append(listing, name)
+		}
+		sort.Strings(listing)
+		listings[dir] = listing
+	}
+	return listings
+}
+
+// uploadDirectoryListings uploads a listing page to each directory of the
+// webroot that does not have an index document, and adds them to the manifest.
+func uploadDirectoryListings(webroot string, files []*deployment.FileSize, indexDocument string, m *Manifest) error {
+	for dir, entries := range directoryListings(files, indexDocument) {
+		buf := &bytes.Buffer{}
+		if err := listingTmpl.Execute(buf, struct {
+			Dir     string
+			Entries []string
+		}{dir, entries}); err != nil {
+			return err
+		}
+
+		content := buf.Bytes()
+		if err := S3.Upload(s3client.BucketRegion, s3client.BucketName, webroot+dir+DirectoryListingName, bytes.NewReader(content), "text/html", "public-read"); err != nil {
+			return err
+		}
+		m.addContent(strings.TrimPrefix(dir, "/")+DirectoryListingName, &filetransfer.Headers{ContentType: "text/html"}, content)
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package deployer
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/pkg/filetransfer"
+	"github.com/nitrous-io/rise-server/shared/s3client"
+)
+
+var (
+	// MaxDeltaInvalidationPaths is the largest number of paths that are
+	// purged from edges when a deployment replaces another. Whole domains are
+	// purged if more files changed.
+	MaxDeltaInvalidationPaths = 200
+
+	// ErrorDocument is the page that edges serve for paths that are not
+	// found. Whole domains are purged when it changes, as any path may have
+	// been cached with it.
+	ErrorDocument = "404.html"
+)
+
+// Manifest lists the files of the webroot of a deployment with a digest of
+// each, to tell which files changed between two deployments.
+type Manifest struct {
+	// Files are the hex-encoded MD5 digests of the files, by path in the
+	// webroot (e.g. "css/app.css").
+	Files map[string]string `json:"files"`
+}
+
+func newManifest() *Manifest {
+	return &Manifest{Files: map[string]string{}}
+}
+
+// newDigest returns the hash that the content of a file served with the given
+// headers is written to. It starts with the headers, so that files that are
+// served differently have different digests even if their content did not
+// change.
+func newDigest(h *filetransfer.Headers) hash.Hash {
+	d := md5.New()
+	fmt.Fprintf(d, "%q %q %q\n", h.ContentType, h.ContentEncoding, h.CacheControl)
+	return d
+}
+
+// digestReader returns a reader that reads r, and the digest that what is read
+// from it is written to.
+func digestReader(r io.Reader, h *filetransfer.Headers) (io.Reader, hash.Hash) {
+	d := newDigest(h)
+	return io.TeeReader(r, d), d
+}
+
+// add records the digest of the file with the given name.
+func (m *Manifest) add(fileName string, d hash.Hash) {
+	m.Files[fileName] = hex.EncodeToString(d.Sum(nil))
+}
+
+// addContent records the digest of a file that is generated by the deployer.
+func (m *Manifest) addContent(fileName string, h *filetransfer.Headers, content []byte) {
+	d := newDigest(h)
+	d.Write(content)
+	m.add(fileName, d)
+}
+
+// changedFiles returns the names of the files that are only in one of the
+// manifests, or whose digests differ, in alphabetical order.
+func (m *Manifest) changedFiles(prev *Manifest) []string {
+	var changed []string
+	for name, digest := range m.Files {
+		if prev.Files[name] != digest {
+			changed = append(changed, name)
+		}
+	}
+	for name := range prev.Files {
+		if _, ok := m.Files[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+func uploadManifest(depl *deployment.Deployment, m *Manifest) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	return S3.Upload(s3client.BucketRegion, s3client.BucketName, depl.ManifestPath(), bytes.NewReader(b), "application/json", "private")
+}
+
+func downloadManifest(depl *deployment.Deployment) (*Manifest, error) {
+	rc, err := S3.Open(s3client.BucketRegion, s3client.BucketName, depl.ManifestPath())
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	m := &Manifest{}
+	if err := json.NewDecoder(rc).Decode(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// deltaInvalidationPaths returns the URL paths that have to be purged from
+// edges for domains that serve the active deployment of the project to serve
+// depl instead, whose files are listed in m. It returns false if the whole
+// domains have to be purged, e.g. because the active deployment has no
+// manifest or was deployed with different settings.
+func deltaInvalidationPaths(db *gorm.DB, proj *project.Project, depl *deployment.Deployment, m *Manifest) ([]string, bool) {
+	if m == nil || proj.ActiveDeploymentID == nil || *proj.ActiveDeploymentID == depl.ID {
+		return nil, false
+	}
+
+	prevDepl := &deployment.Deployment{}
+	if err := db.First(prevDepl, *proj.ActiveDeploymentID).Error; err != nil {
+		return nil, false
+	}
+
+	if !bytes.Equal(prevDepl.Settings, depl.Settings) {
+		return nil, false
+	}
+
+	prev, err := downloadManifest(prevDepl)
+	if err != nil {
+		return nil, false
+	}
+
+	paths := []string{}
+	seen := map[string]bool{}
+	for _, fileName := range m.changedFiles(prev) {
+		if fileName == ErrorDocument {
+			return nil, false
+		}
+
+		for _, p := range urlPaths(fileName, proj.IndexDocument) {
+			if !seen[p] {
+				seen[p] = true
+				paths = append(paths, p)
+			}
+		}
+	}
+
+	if len(paths) > MaxDeltaInvalidationPaths {
+		return nil, false
+	}
+	return paths, true
+}
+
+// urlPaths returns the URL paths that edges serve the file with the given
+// name at. Index documents and directory listings are also served at the
+// path of their directory, with and without a trailing slash.
+func urlPaths(fileName, indexDocument string) []string {
+	p := "/" + fileName
+	paths := []string{p}
+
+	dir, name := path.Split(p)
+	if name == indexDocument || name == DirectoryListingName {
+		paths = append(paths, dir)
+		if dir != "/" {
+			paths = append(paths, strings.TrimSuffix(dir, "/"))
+		}
+	}
+	return paths
+}
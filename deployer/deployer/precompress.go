@@ -0,0 +1,145 @@
+package deployer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"log"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/nitrous-io/rise-server/pkg/filetransfer"
+	"github.com/nitrous-io/rise-server/shared/s3client"
+)
+
+var (
+	// MinFileSizeToPrecompress is the size of the smallest file that
+	// compressed copies are uploaded for, as smaller files gain little from
+	// it.
+	MinFileSizeToPrecompress int64 = 1024 // in bytes
+	// MaxFileSizeToPrecompress is the size of the largest file that
+	// compressed copies are uploaded for, as files are compressed in memory.
+	MaxFileSizeToPrecompress int64 = 10 * 1000 * 1000 // in bytes
+
+	// BrotliPath is the brotli command that Brotli-compressed copies are made
+	// with. Only gzipped copies are uploaded if it cannot be found.
+	BrotliPath = "brotli"
+)
+
+// compressibleTypes are the content types, besides text/*, of files that
+// compressed copies are uploaded for.
+var compressibleTypes = map[string]bool{
+	"application/javascript": true,
+	"application/json":       true,
+	"application/xml":        true,
+	"application/wasm":       true,
+	"image/svg+xml":          true,
+	"image/x-icon":           true,
+}
+
+func isCompressible(contentType string) bool {
+	mediaType := strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])
+	return strings.HasPrefix(mediaType, "text/") || compressibleTypes[mediaType]
+}
+
+// encoding is a way files are precompressed, with the extension of the
+// compressed copies and the Content-Encoding they are served with.
+type encoding struct {
+	ext      string
+	name     string
+	compress func([]byte) ([]byte, error)
+}
+
+var encodings = []encoding{
+	{".br", "br", brotliCompress},
+	{".gz", "gzip", gzipCompress},
+}
+
+// uploadWebrootFile uploads a file of a deployment to the webroot with the
+// given headers. If precompress is true and the file is compressible,
+// Brotli-compressed and gzipped copies of the file are also uploaded, with
+// ".br" and ".gz" extensions and the matching Content-Encoding, for edges to
+// serve to clients that accept them.
+func uploadWebrootFile(remotePath string, r io.Reader, h *filetransfer.Headers, size int64, precompress bool) error {
+	if !precompress || !isCompressible(h.ContentType) || size < MinFileSizeToPrecompress || size > MaxFileSizeToPrecompress {
+		return S3.UploadWithHeaders(s3client.BucketRegion, s3client.BucketName, remotePath, r, h, "public-read")
+	}
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	if err := S3.UploadWithHeaders(s3client.BucketRegion, s3client.BucketName, remotePath, bytes.NewReader(b), h, "public-read"); err != nil {
+		return err
+	}
+
+	for _, enc := range encodings {
+		compressed, err := enc.compress(b)
+		if err != nil {
+			return err
+		}
+
+		// The copy is not worth serving if compressing did not make it
+		// smaller.
+		if compressed == nil || len(compressed) >= len(b) {
+			continue
+		}
+
+		encodedHeaders := *h
+		encodedHeaders.ContentEncoding = enc.name
+		if err := S3.UploadWithHeaders(s3client.BucketRegion, s3client.BucketName, remotePath+enc.ext, bytes.NewReader(compressed), &encodedHeaders, "public-read"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func gzipCompress(b []byte) ([]byte, error) {
+	gzipped := &bytes.Buffer{}
+	gw, err := gzip.NewWriterLevel(gzipped, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := gw.Write(b); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+	return gzipped.Bytes(), nil
+}
+
+var (
+	brotliOnce    sync.Once
+	brotliCmdPath string
+)
+
+// brotliCompress compresses b with the brotli command at the highest quality.
+// It returns nil if the command cannot be found or fails, as the gzipped copy
+// can be served instead.
+func brotliCompress(b []byte) ([]byte, error) {
+	brotliOnce.Do(func() {
+		var err error
+		brotliCmdPath, err = exec.LookPath(BrotliPath)
+		if err != nil {
+			log.Printf("brotli command %q not found, only gzipped copies of files will be uploaded, err: %v", BrotliPath, err)
+		}
+	})
+	if brotliCmdPath == "" {
+		return nil, nil
+	}
+
+	out := &bytes.Buffer{}
+	cmd := exec.Command(brotliCmdPath, "--stdout", "--quality=11")
+	cmd.Stdin = bytes.NewReader(b)
+	cmd.Stdout = out
+	if err := cmd.Run(); err != nil {
+		log.Printf("failed to compress with brotli, err: %v", err)
+		return nil, nil
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,70 @@
+package deployer
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/pkg/filetransfer"
+	"github.com/nitrous-io/rise-server/shared/invalidation"
+	"github.com/nitrous-io/rise-server/shared/messages"
+	"github.com/nitrous-io/rise-server/shared/s3client"
+)
+
+// rotatePrefix moves the files of a deployment to a new prefix, for when the
+// old one has leaked. The files are copied before the prefix is changed and
+// the meta.json of the project's domains is republished, and the files under
+// the old prefix are only deleted after that, so that the deployment is
+// served throughout. It is safe to retry.
+func rotatePrefix(db *gorm.DB, proj *project.Project, depl *deployment.Deployment, r *messages.PrefixRotation, invalidate bool) error {
+	oldDir := fmt.Sprintf("deployments/%s-%d/", r.From, depl.ID)
+	newDir := fmt.Sprintf("deployments/%s-%d/", r.To, depl.ID)
+
+	switch depl.Prefix {
+	case r.From:
+		if err := S3.List(s3client.BucketRegion, s3client.BucketName, oldDir, func(obj *filetransfer.ObjectInfo) error {
+			// Only the webroot is publicly readable.
+			acl := "private"
+			if strings.HasPrefix(obj.Key, oldDir+"webroot/") {
+				acl = "public-read"
+			}
+			return S3.Copy(s3client.BucketRegion, s3client.BucketName, obj.Key, newDir+strings.TrimPrefix(obj.Key, oldDir), acl)
+		}); err != nil {
+			return err
+		}
+
+		if err := depl.ChangePrefix(db, r.To); err != nil {
+			return err
+		}
+	case r.To:
+		// The prefix was changed by an earlier attempt.
+	default:
+		log.Printf("prefix of deployment %d is neither %q nor %q, skipping rotation", depl.ID, r.From, r.To)
+		return nil
+	}
+
+	// Edges only need to be told about the new prefix if the deployment is
+	// being served.
+	if proj.ActiveDeploymentID != nil && *proj.ActiveDeploymentID == depl.ID {
+		settings, err := depl.SettingsData()
+		if err != nil {
+			return err
+		}
+
+		domainNames, err := publishMeta(db, proj, depl.PrefixID(), settings)
+		if err != nil {
+			return err
+		}
+
+		if invalidate {
+			if err := invalidation.Invalidate(domainNames); err != nil {
+				return err
+			}
+		}
+	}
+
+	return S3.DeleteAll(s3client.BucketRegion, s3client.BucketName, oldDir)
+}
@@ -0,0 +1,31 @@
+package deployer
+
+import (
+	"strings"
+
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+)
+
+// missingVerificationURLs returns the verification URLs of a deployment that
+// none of its files would be served for. A URL is served by the file at its
+// path, or by the index document of the directory at its path.
+func missingVerificationURLs(urls []string, files []*deployment.FileSize, indexDocument string) []string {
+	if indexDocument == "" {
+		indexDocument = "index.html"
+	}
+
+	paths := make(map[string]bool, len(files))
+	for _, f := range files {
+		paths["/"+strings.TrimPrefix(f.Path, "/")] = true
+	}
+
+	var missing []string
+	for _, u := range urls {
+		dir := strings.TrimSuffix(u, "/") + "/"
+		if paths[u] || paths[dir+indexDocument] {
+			continue
+		}
+		missing = append(missing, u)
+	}
+	return missing
+}
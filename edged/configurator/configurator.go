@@ -0,0 +1,64 @@
+package configurator
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/nitrous-io/rise-server/pkg/job"
+	"github.com/nitrous-io/rise-server/shared/messages"
+	"github.com/nitrous-io/rise-server/shared/queues"
+)
+
+var APIHost = "http://127.0.0.1:8081"
+
+// EdgeName is the name this edge server reports when it acknowledges a
+// config. It defaults to the hostname.
+var EdgeName = os.Getenv("EDGE_NAME")
+
+var errRequestFailed = errors.New("Unexpected error on making config request")
+
+func init() {
+	if EdgeName == "" {
+		EdgeName, _ = os.Hostname()
+	}
+}
+
+// Work applies a version of the edge settings by passing it on to the edge
+// server, and acknowledges it once it has been applied.
+func Work(data []byte) error {
+	j := &messages.V1EdgeConfigMessageData{}
+	if err := json.Unmarshal(data, j); err != nil {
+		return err
+	}
+
+	res, err := http.Post(APIHost+"/config", "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		output := ""
+		if b, err := ioutil.ReadAll(res.Body); err == nil {
+			output = string(b)
+		}
+
+		log.Errorf("Unexpected error on config request: (%d) %s", res.StatusCode, output)
+		return errRequestFailed
+	}
+
+	ack, err := job.NewWithJSON(queues.EdgeConfigAck, &messages.EdgeConfigAckJobData{
+		Edge:    EdgeName,
+		Version: j.Version,
+	})
+	if err != nil {
+		return err
+	}
+
+	return ack.Enqueue()
+}
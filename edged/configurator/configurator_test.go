@@ -0,0 +1,86 @@
+package configurator_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/nitrous-io/rise-server/edged/configurator"
+	"github.com/nitrous-io/rise-server/pkg/mqconn"
+	"github.com/nitrous-io/rise-server/shared/queues"
+	"github.com/nitrous-io/rise-server/testhelper"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "configurator")
+}
+
+var _ = Describe("Configurator", func() {
+	var (
+		origAPIHost  string
+		origEdgeName string
+		server       *ghttp.Server
+		mq           mqconn.Conn
+		err          error
+	)
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+		origAPIHost = configurator.APIHost
+		configurator.APIHost = server.URL()
+		origEdgeName = configurator.EdgeName
+		configurator.EdgeName = "edge-sg-1"
+
+		mq, err = mqconn.MQ()
+		Expect(err).To(BeNil())
+		testhelper.DeleteQueue(mq, queues.All...)
+	})
+
+	AfterEach(func() {
+		configurator.APIHost = origAPIHost
+		configurator.EdgeName = origEdgeName
+		server.Close()
+	})
+
+	Describe("Work", func() {
+		data := `{
+			"version": 3,
+			"settings": {"blocked_ips": ["10.0.0.1"]}
+		}`
+
+		It("makes config request and acknowledges the config", func() {
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", "/config"),
+					ghttp.VerifyJSON(data),
+					ghttp.RespondWith(http.StatusOK, `{ "applied": true }`),
+				),
+			)
+
+			err := configurator.Work([]byte(data))
+			Expect(err).To(BeNil())
+			Expect(server.ReceivedRequests()).To(HaveLen(1))
+
+			d := testhelper.ConsumeQueue(mq, queues.EdgeConfigAck)
+			Expect(d).NotTo(BeNil())
+			Expect(d.Body).To(MatchJSON(`{"edge": "edge-sg-1", "version": 3}`))
+		})
+
+		It("does not acknowledge the config if it could not be applied", func() {
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", "/config"),
+					ghttp.RespondWith(http.StatusInternalServerError, `{ "applied": false }`),
+				),
+			)
+
+			err := configurator.Work([]byte(data))
+			Expect(err).NotTo(BeNil())
+
+			Expect(testhelper.ConsumeQueue(mq, queues.EdgeConfigAck)).To(BeNil())
+		})
+	})
+})
@@ -7,7 +7,9 @@ import (
 	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/nitrous-io/rise-server/edged/configurator"
 	"github.com/nitrous-io/rise-server/edged/invalidator"
+	"github.com/nitrous-io/rise-server/pkg/job"
 	"github.com/nitrous-io/rise-server/pkg/mqconn"
 	"github.com/nitrous-io/rise-server/shared/exchanges"
 	"github.com/streadway/amqp"
@@ -54,7 +56,10 @@ func run() {
 		return
 	}
 
-	routeKey := exchanges.RouteV1Invalidation
+	routeKeys := []string{
+		exchanges.RouteV1Invalidation,
+		exchanges.RouteV1EdgeConfig,
+	}
 
 	q, err := ch.QueueDeclare(
 		"",    // name
@@ -65,29 +70,33 @@ func run() {
 		nil,   // arguments
 	)
 	if err != nil {
-		log.Errorf("Failed to declare queue for exchange(%s) and route(%s): %v", exchangeName, routeKey, err)
-		return
-	}
-
-	if ch.QueueBind(
-		q.Name,       // queue name
-		routeKey,     // routing key
-		exchangeName, // exchange
-		false,
-		nil,
-	); err != nil {
-		log.Errorf("Failed to bind queue(%s) for route(%s) to exchange(%s): %v", q.Name, routeKey, exchangeName, err)
+		log.Errorf("Failed to declare queue for exchange(%s): %v", exchangeName, err)
 		return
 	}
 
-	defer func() {
-		if err = ch.QueueUnbind(
+	for _, routeKey := range routeKeys {
+		if err := ch.QueueBind(
 			q.Name,       // queue name
 			routeKey,     // routing key
 			exchangeName, // exchange
+			false,
 			nil,
 		); err != nil {
-			log.Errorf("Failed to unbind queue(%s) for route(%s) from exchange(%s): %v", q.Name, routeKey, exchangeName, err)
+			log.Errorf("Failed to bind queue(%s) for route(%s) to exchange(%s): %v", q.Name, routeKey, exchangeName, err)
+			return
+		}
+	}
+
+	defer func() {
+		for _, routeKey := range routeKeys {
+			if err = ch.QueueUnbind(
+				q.Name,       // queue name
+				routeKey,     // routing key
+				exchangeName, // exchange
+				nil,
+			); err != nil {
+				log.Errorf("Failed to unbind queue(%s) for route(%s) from exchange(%s): %v", q.Name, routeKey, exchangeName, err)
+			}
 		}
 	}()
 
@@ -114,7 +123,22 @@ func run() {
 	for {
 		select {
 		case d := <-msgCh:
-			err := invalidator.Work(d.Body)
+			if err := job.Verify(d); err != nil {
+				// Drop messages that were not published by us.
+				log.WithFields(log.Fields{"queue": q.Name}).Errorf("Rejected message, err: %v, message: %s", err, d.Body)
+				if err := d.Ack(false); err != nil {
+					log.WithFields(log.Fields{"queue": q.Name}).Warnln("Failed to Ack message:", err)
+				}
+				continue
+			}
+
+			var err error
+			switch d.RoutingKey {
+			case exchanges.RouteV1EdgeConfig:
+				err = configurator.Work(d.Body)
+			default:
+				err = invalidator.Work(d.Body)
+			}
 
 			if err != nil {
 				// failure
@@ -23,8 +23,18 @@ func Work(data []byte) error {
 	}
 
 	for _, domain := range j.Domains {
+		// Only the given paths of the domain are purged if there are any,
+		// otherwise the whole domain is.
+		params := url.Values{}
+		if paths, ok := j.Paths[domain]; ok {
+			params.Set("delta", "true")
+			for _, p := range paths {
+				params.Add("path", p)
+			}
+		}
+
 		invalidateURL := fmt.Sprintf("%s/invalidate/%s", APIHost, domain)
-		res, err := http.PostForm(invalidateURL, url.Values{})
+		res, err := http.PostForm(invalidateURL, params)
 		if err != nil {
 			return err
 		}
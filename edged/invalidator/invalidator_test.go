@@ -2,6 +2,7 @@ package invalidator_test
 
 import (
 	"net/http"
+	"net/url"
 	"testing"
 
 	"github.com/nitrous-io/rise-server/edged/invalidator"
@@ -55,5 +56,39 @@ var _ = Describe("Invalidator", func() {
 			Expect(server.ReceivedRequests()).To(HaveLen(2))
 			Expect(err).To(BeNil())
 		})
+
+		It("only purges the given paths of domains that have them", func() {
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", "/invalidate/foo-bar-express.pubstorm.site"),
+					ghttp.VerifyForm(url.Values{
+						"delta": {"true"},
+						"path":  {"/", "/css/app.css"},
+					}),
+					ghttp.RespondWith(http.StatusOK, `{ "invalidated": true }`),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", "/invalidate/www.foo-bar-express.com"),
+					func(w http.ResponseWriter, r *http.Request) {
+						Expect(r.ParseForm()).To(Succeed())
+						Expect(r.PostForm).To(BeEmpty())
+					},
+					ghttp.RespondWith(http.StatusOK, `{ "invalidated": true }`),
+				),
+			)
+
+			err := invalidator.Work([]byte(`{
+				"domains": [
+					"foo-bar-express.pubstorm.site",
+					"www.foo-bar-express.com"
+				],
+				"paths": {
+					"foo-bar-express.pubstorm.site": ["/", "/css/app.css"]
+				}
+			}`))
+
+			Expect(server.ReceivedRequests()).To(HaveLen(2))
+			Expect(err).To(BeNil())
+		})
 	})
 })
@@ -0,0 +1,177 @@
+package importd
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/nitrous-io/rise-server/shared"
+)
+
+var (
+	// MaxCrawledFiles is the maximum number of files that are fetched when a
+	// site is crawled.
+	MaxCrawledFiles = 1000
+
+	// CrawlTimeout is how long a site is crawled for before the import fails.
+	CrawlTimeout = 10 * time.Minute
+
+	htmlLinkRe = regexp.MustCompile(`(?i)(?:href|src)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+	cssLinkRe  = regexp.MustCompile(`(?i)url\(\s*(?:"([^"]*)"|'([^']*)'|([^'")\s]*))\s*\)`)
+)
+
+// crawl fetches the page at the given URL and every page, stylesheet, script
+// and other file that is linked from it on the same host, and saves them in
+// dst at their paths on the site. Pages whose paths do not have an extension
+// are saved as the index document of a directory of the same name.
+func crawl(rawURL, dst string) error {
+	root, err := url.Parse(rawURL)
+	if err != nil || (root.Scheme != "http" && root.Scheme != "https") || root.Host == "" {
+		return importErrorf("%q is not a valid URL.", rawURL)
+	}
+	root.Fragment = ""
+
+	var (
+		client   = newClient()
+		deadline = time.Now().Add(CrawlTimeout)
+		queue    = []*url.URL{root}
+		seen     = map[string]bool{root.String(): true}
+		saved    = 0
+		total    int64
+	)
+
+	for len(queue) > 0 {
+		if time.Now().After(deadline) {
+			return importErrorf("Crawling %s took too long.", root)
+		}
+
+		u := queue[0]
+		queue = queue[1:]
+
+		links, size, ok, err := fetchPage(client, root, u, dst)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		saved++
+		if saved > MaxCrawledFiles || saved > shared.MaxFilesPerBundle {
+			return importErrorf("%s has too many files to import.", root)
+		}
+
+		total += size
+		if total > MaxSiteSize {
+			return importErrorf("%s is larger than the maximum bundle size.", root)
+		}
+
+		for _, link := range links {
+			l, err := u.Parse(link)
+			if err != nil || l.Scheme != root.Scheme || l.Host != root.Host {
+				continue
+			}
+			l.Fragment = ""
+			l.RawQuery = ""
+
+			if !seen[l.String()] {
+				seen[l.String()] = true
+				queue = append(queue, l)
+			}
+		}
+	}
+
+	if saved == 0 {
+		return importErrorf("Nothing could be imported from %s.", root)
+	}
+
+	return nil
+}
+
+// fetchPage saves the file at u, and returns its size and the links in it if
+// it is an HTML page or a stylesheet. ok is false if the file does not exist
+// or has moved to another host, so that broken links do not fail the import.
+func fetchPage(client *http.Client, root, u *url.URL, dst string) (links []string, size int64, ok bool, err error) {
+	res, err := client.Get(u.String())
+	if err != nil {
+		if u == root {
+			return nil, 0, false, importErrorf("Could not fetch %s: %v", u, err)
+		}
+		return nil, 0, false, nil
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK || res.Request.URL.Host != root.Host {
+		if u == root {
+			return nil, 0, false, importErrorf("Could not fetch %s, it responded with status %d.", u, res.StatusCode)
+		}
+		return nil, 0, false, nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+
+	relPath := strings.TrimPrefix(path.Clean("/"+u.Path), "/")
+	if relPath == "" || strings.HasSuffix(u.Path, "/") || (mediaType == "text/html" && path.Ext(relPath) == "") {
+		relPath = path.Join(relPath, "index.html")
+	}
+
+	// Only the start of the file is read if it is too large, so that the
+	// error can be reported without downloading all of it.
+	buf := &bytes.Buffer{}
+	n, err := io.CopyN(buf, res.Body, shared.MaxFileSize+1)
+	if err != nil && err != io.EOF {
+		return nil, 0, false, importErrorf("Could not fetch %s: %v", u, err)
+	}
+	if n > shared.MaxFileSize {
+		return nil, 0, false, importErrorf("%s is larger than the maximum file size.", u)
+	}
+
+	// Paths on a site can clash with each other on disk, e.g. "/docs" that
+	// is not HTML and "/docs/intro.html", in which case only the file that was
+	// fetched first is kept.
+	target := filepath.Join(dst, filepath.FromSlash(relPath))
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return nil, 0, false, nil
+	}
+	if _, err := os.Stat(target); err == nil {
+		return nil, 0, false, nil
+	}
+
+	f, err := os.Create(target)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		return nil, 0, false, err
+	}
+
+	var re *regexp.Regexp
+	switch mediaType {
+	case "text/html":
+		re = htmlLinkRe
+	case "text/css":
+		re = cssLinkRe
+	default:
+		return nil, n, true, nil
+	}
+
+	for _, m := range re.FindAllStringSubmatch(buf.String(), -1) {
+		for _, link := range m[1:] {
+			if link != "" {
+				links = append(links, link)
+			}
+		}
+	}
+
+	return links, n, true, nil
+}
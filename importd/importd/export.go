@@ -0,0 +1,261 @@
+package importd
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/nitrous-io/rise-server/shared"
+	"github.com/nitrous-io/rise-server/shared/messages"
+	"github.com/nitrous-io/rise-server/shared/s3client"
+)
+
+// providerFiles are files in the root of exports that configure the other
+// host and are not part of the site.
+var providerFiles = map[string][]string{
+	messages.ImportProviderNetlify: {"_headers", "_redirects", "netlify.toml"},
+	messages.ImportProviderSurge:   {"AUTH", "CNAME", "CORS", "ROUTER"},
+}
+
+// fetchExport downloads a zip or gzipped tar archive of a site that was
+// exported from the given provider, and unpacks it to dst without the files
+// that only configure the provider. If all of the files in the archive are in
+// a single directory, the files in that directory are unpacked instead. dst
+// must be an empty directory.
+func fetchExport(rawURL, provider, dst string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return importErrorf("%q is not a valid URL.", rawURL)
+	}
+
+	f, err := ioutil.TempFile("", "import-export")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+
+	size, err := download(u, f)
+	if err != nil {
+		return err
+	}
+
+	staging, err := ioutil.TempDir("", "import-export-files")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+
+	magic, _ := bufio.NewReader(f).Peek(4)
+	if _, err := f.Seek(0, 0); err != nil {
+		return err
+	}
+
+	var count int
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")):
+		count, err = unzip(f, size, staging)
+	case bytes.HasPrefix(magic, []byte("\x1f\x8b")):
+		count, err = untarGz(f, staging)
+	default:
+		return importErrorf("%s is not a zip or gzipped tar archive.", u)
+	}
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return importErrorf("%s does not contain any files.", u)
+	}
+
+	root := staging
+	if entries, err := ioutil.ReadDir(staging); err != nil {
+		return err
+	} else if len(entries) == 1 && entries[0].IsDir() {
+		root = filepath.Join(staging, entries[0].Name())
+	}
+
+	for _, name := range providerFiles[provider] {
+		if err := os.Remove(filepath.Join(root, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	// dst is empty, so it can be replaced with the root of the site.
+	if err := os.Remove(dst); err != nil {
+		return err
+	}
+	return os.Rename(root, dst)
+}
+
+// download saves the file at u to f, and returns its size. f is rewound so
+// that it can be read.
+func download(u *url.URL, f *os.File) (int64, error) {
+	res, err := newClient().Get(u.String())
+	if err != nil {
+		return 0, importErrorf("Could not download %s: %v", u, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return 0, importErrorf("Could not download %s, it responded with status %d.", u, res.StatusCode)
+	}
+
+	n, err := io.CopyN(f, res.Body, s3client.MaxUploadSize+1)
+	if err != nil && err != io.EOF {
+		return 0, importErrorf("Could not download %s: %v", u, err)
+	}
+	if n > s3client.MaxUploadSize {
+		return 0, importErrorf("%s is larger than the maximum bundle size.", u)
+	}
+
+	if _, err := f.Seek(0, 0); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func unzip(f *os.File, size int64, dst string) (int, error) {
+	zr, err := zip.NewReader(f, size)
+	if err != nil {
+		return 0, importErrorf("The archive could not be read: %v", err)
+	}
+
+	var (
+		count int
+		total int64
+	)
+	for _, zf := range zr.File {
+		name, ok := cleanName(zf.Name)
+		if !ok || !zf.Mode().IsRegular() {
+			continue
+		}
+
+		count++
+		if count > shared.MaxFilesPerBundle {
+			return 0, importErrorf("The archive has too many files to import.")
+		}
+		if zf.UncompressedSize64 > uint64(shared.MaxFileSize) {
+			return 0, importErrorf("%s is larger than the maximum file size.", name)
+		}
+
+		r, err := zf.Open()
+		if err != nil {
+			return 0, importErrorf("Could not read %s from the archive: %v", name, err)
+		}
+		n, err := extract(r, dst, name)
+		r.Close()
+		if err != nil {
+			return 0, err
+		}
+
+		total += n
+		if total > MaxSiteSize {
+			return 0, importErrorf("The site is larger than the maximum bundle size.")
+		}
+	}
+
+	return count, nil
+}
+
+func untarGz(f *os.File, dst string) (int, error) {
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return 0, importErrorf("The archive could not be read: %v", err)
+	}
+	defer gr.Close()
+
+	var (
+		count int
+		total int64
+	)
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, importErrorf("The archive could not be read: %v", err)
+		}
+
+		name, ok := cleanName(hdr.Name)
+		if !ok || !hdr.FileInfo().Mode().IsRegular() {
+			continue
+		}
+
+		count++
+		if count > shared.MaxFilesPerBundle {
+			return 0, importErrorf("The archive has too many files to import.")
+		}
+		if hdr.Size > shared.MaxFileSize {
+			return 0, importErrorf("%s is larger than the maximum file size.", name)
+		}
+
+		n, err := extract(tr, dst, name)
+		if err != nil {
+			return 0, err
+		}
+
+		total += n
+		if total > MaxSiteSize {
+			return 0, importErrorf("The site is larger than the maximum bundle size.")
+		}
+	}
+
+	return count, nil
+}
+
+// extract writes a file of an archive to its path in dst, and returns its
+// size.
+func extract(r io.Reader, dst, name string) (int64, error) {
+	target := filepath.Join(dst, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return 0, importErrorf("Could not extract %s from the archive: %v", name, err)
+	}
+
+	f, err := os.Create(target)
+	if err != nil {
+		return 0, importErrorf("Could not extract %s from the archive: %v", name, err)
+	}
+	defer f.Close()
+
+	// The size in the header of a zip file can be forged.
+	n, err := io.Copy(f, io.LimitReader(r, shared.MaxFileSize+1))
+	if err != nil {
+		return 0, importErrorf("Could not read %s from the archive: %v", name, err)
+	}
+	if n > shared.MaxFileSize {
+		return 0, importErrorf("%s is larger than the maximum file size.", name)
+	}
+	return n, nil
+}
+
+// cleanName returns the path of a file in an archive relative to the root of
+// the archive, and false if it is metadata that macOS adds to archives.
+// Paths are cleaned so that files cannot be extracted outside of the root.
+func cleanName(name string) (string, bool) {
+	name = strings.TrimPrefix(path.Clean("/"+strings.Replace(name, `\`, "/", -1)), "/")
+	if name == "" || name == "." {
+		return "", false
+	}
+
+	for _, seg := range strings.Split(name, "/") {
+		if seg == "__MACOSX" || seg == ".DS_Store" {
+			return "", false
+		}
+	}
+	return name, true
+}
@@ -0,0 +1,233 @@
+// Package importd imports sites from other static hosts, either by crawling
+// a live site or by reading an export of it, into the raw bundle of a
+// deployment, which is then built or deployed like any other bundle.
+package importd
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/pkg/filetransfer"
+	"github.com/nitrous-io/rise-server/pkg/job"
+	"github.com/nitrous-io/rise-server/pkg/safehttp"
+	"github.com/nitrous-io/rise-server/shared/messages"
+	"github.com/nitrous-io/rise-server/shared/queues"
+	"github.com/nitrous-io/rise-server/shared/s3client"
+)
+
+var (
+	S3 filetransfer.FileTransfer = filetransfer.NewS3(s3client.PartSize, s3client.MaxUploadParts)
+
+	ErrUnexpectedDeploymentState = errors.New("deployment is in an unexpected state")
+	ErrRecordNotFound            = errors.New("project or deployment is deleted")
+	// ErrImportFailed is returned when the site could not be imported for a
+	// reason that retrying would not fix. The reason is shown to the user in
+	// the error message of the deployment.
+	ErrImportFailed = errors.New("site could not be imported")
+
+	// RequestTimeout is how long each request to the other host is given to
+	// complete.
+	RequestTimeout = 30 * time.Second
+
+	// MaxSiteSize is the maximum total size of the files of an imported site.
+	MaxSiteSize = s3client.MaxUploadSize
+
+	// AllowPrivateIPs is whether sites can be imported from loopback and
+	// private addresses. It is only meant to be set in tests, as users could
+	// otherwise make the importer fetch from internal services.
+	AllowPrivateIPs = false
+)
+
+// importError is an error that is shown to the user.
+type importError struct {
+	msg string
+}
+
+func (e *importError) Error() string {
+	return e.msg
+}
+
+func importErrorf(format string, args ...interface{}) error {
+	return &importError{fmt.Sprintf(format, args...)}
+}
+
+func Work(data []byte) error {
+	d := &messages.ImportJobData{}
+	if err := json.Unmarshal(data, d); err != nil {
+		return err
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		return err
+	}
+
+	depl := &deployment.Deployment{}
+	if err := db.First(depl, d.DeploymentID).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+
+	if depl.State != deployment.StatePendingUpload {
+		return ErrUnexpectedDeploymentState
+	}
+
+	proj := &project.Project{}
+	if err := db.Where("id = ?", depl.ProjectID).First(proj).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+
+	tmpDir, err := ioutil.TempDir("", "import-"+depl.PrefixID())
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	switch d.Provider {
+	case messages.ImportProviderURL:
+		err = crawl(d.URL, tmpDir)
+	case messages.ImportProviderNetlify, messages.ImportProviderSurge:
+		err = fetchExport(d.URL, d.Provider, tmpDir)
+	default:
+		err = importErrorf("Sites cannot be imported from %q.", d.Provider)
+	}
+
+	if err != nil {
+		if ie, ok := err.(*importError); ok {
+			m := ie.Error()
+			depl.ErrorMessage = &m
+			if err := depl.UpdateState(db, deployment.StateDeployFailed); err != nil {
+				log.Printf("failed to update state of deployment %d due to %v", depl.ID, err)
+			}
+			return ErrImportFailed
+		}
+		return err
+	}
+
+	tarball, err := ioutil.TempFile("", "import-raw-bundle")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		tarball.Close()
+		os.Remove(tarball.Name())
+	}()
+
+	if err := gzipTarball(tarball, tmpDir); err != nil {
+		return err
+	}
+
+	if _, err := tarball.Seek(0, 0); err != nil {
+		return err
+	}
+
+	uploadKey := fmt.Sprintf("deployments/%s/raw-bundle.tar.gz", depl.PrefixID())
+	if err := S3.Upload(s3client.BucketRegion, s3client.BucketName, uploadKey, tarball, "", "private"); err != nil {
+		return err
+	}
+
+	var j *job.Job
+	if !proj.NeedsBuild() {
+		j, err = job.NewWithJSON(queues.Deploy, &messages.DeployJobData{
+			DeploymentID: depl.ID,
+			UseRawBundle: true,
+		})
+	} else {
+		j, err = job.NewWithJSON(queues.Build, &messages.BuildJobData{
+			DeploymentID: depl.ID,
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := j.Enqueue(); err != nil {
+		return err
+	}
+
+	newState := deployment.StatePendingBuild
+	if !proj.NeedsBuild() {
+		newState = deployment.StatePendingDeploy
+	}
+
+	return depl.UpdateState(db, newState)
+}
+
+// newClient returns an HTTP client that refuses to connect to addresses that
+// are not publicly routable, so that users cannot make the importer fetch
+// from internal services.
+func newClient() *http.Client {
+	return safehttp.NewClient(RequestTimeout, AllowPrivateIPs)
+}
+
+func gzipTarball(w io.Writer, dir string) error {
+	gw := gzip.NewWriter(w)
+	defer func() {
+		gw.Flush()
+		gw.Close()
+	}()
+
+	tw := tar.NewWriter(gw)
+	defer func() {
+		tw.Flush()
+		tw.Close()
+	}()
+
+	walkFn := func(absPath string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if fi.IsDir() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(dir, absPath)
+		if err != nil {
+			return err
+		}
+
+		hdr, err := tar.FileInfoHeader(fi, relPath)
+		if err != nil {
+			return err
+		}
+		hdr.Name = relPath
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		f, err := os.Open(absPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if _, err := io.Copy(tw, f); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	return filepath.Walk(dir, walkFn)
+}
@@ -0,0 +1,326 @@
+package importd_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/importd/importd"
+	"github.com/nitrous-io/rise-server/pkg/filetransfer"
+	"github.com/nitrous-io/rise-server/pkg/mqconn"
+	"github.com/nitrous-io/rise-server/shared/queues"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+	"github.com/nitrous-io/rise-server/testhelper/fake"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "importd")
+}
+
+var _ = Describe("Importd", func() {
+	var (
+		err error
+		db  *gorm.DB
+		mq  mqconn.Conn
+
+		proj *project.Project
+		depl *deployment.Deployment
+
+		siteServer *httptest.Server
+
+		fakeS3 *fake.S3
+		origS3 filetransfer.FileTransfer
+	)
+
+	zipArchive := func(files map[string]string) []byte {
+		buf := &bytes.Buffer{}
+		zw := zip.NewWriter(buf)
+		for name, content := range files {
+			w, err := zw.Create(name)
+			Expect(err).To(BeNil())
+			_, err = w.Write([]byte(content))
+			Expect(err).To(BeNil())
+		}
+		Expect(zw.Close()).To(BeNil())
+		return buf.Bytes()
+	}
+
+	tarGzArchive := func(files map[string]string) []byte {
+		buf := &bytes.Buffer{}
+		gw := gzip.NewWriter(buf)
+		tw := tar.NewWriter(gw)
+		for name, content := range files {
+			Expect(tw.WriteHeader(&tar.Header{
+				Name:     name,
+				Mode:     0644,
+				Size:     int64(len(content)),
+				Typeflag: tar.TypeReg,
+			})).To(BeNil())
+			_, err := tw.Write([]byte(content))
+			Expect(err).To(BeNil())
+		}
+		Expect(tw.Close()).To(BeNil())
+		Expect(gw.Close()).To(BeNil())
+		return buf.Bytes()
+	}
+
+	uploadedFiles := func() map[string]string {
+		Expect(fakeS3.UploadCalls.Count()).To(Equal(1))
+		uploadCall := fakeS3.UploadCalls.NthCall(1)
+		Expect(uploadCall.Arguments[2]).To(Equal(fmt.Sprintf("deployments/%s/raw-bundle.tar.gz", depl.PrefixID())))
+		Expect(uploadCall.Arguments[5]).To(Equal("private"))
+
+		uploadedContent, ok := uploadCall.SideEffects["uploaded_content"].([]byte)
+		Expect(ok).To(BeTrue())
+		gr, err := gzip.NewReader(bytes.NewBuffer(uploadedContent))
+		Expect(err).To(BeNil())
+		defer gr.Close()
+
+		files := map[string]string{}
+		tr := tar.NewReader(gr)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			Expect(err).To(BeNil())
+
+			b := &bytes.Buffer{}
+			_, err = io.Copy(b, tr)
+			Expect(err).To(BeNil())
+			files[hdr.Name] = b.String()
+		}
+		return files
+	}
+
+	work := func(provider, path string) error {
+		return importd.Work([]byte(fmt.Sprintf(`{
+			"deployment_id": %d,
+			"provider": "%s",
+			"url": "%s"
+		}`, depl.ID, provider, siteServer.URL+path)))
+	}
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+
+		mq, err = mqconn.MQ()
+		Expect(err).To(BeNil())
+		testhelper.DeleteQueue(mq, queues.All...)
+
+		importd.AllowPrivateIPs = true
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprint(w, `<html><head><link href="/css/app.css" rel="stylesheet"></head>
+				<body><a href="about?ref=home#team">About</a> <a href='/missing.html'>Gone</a>
+				<a href="https://example.com/elsewhere">Elsewhere</a></body></html>`)
+		})
+		mux.HandleFunc("/about", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><body><a href="/">Home</a></body></html>`)
+		})
+		mux.HandleFunc("/css/app.css", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/css")
+			fmt.Fprint(w, `body { background: url("../img/bg.png"); }`)
+		})
+		mux.HandleFunc("/img/bg.png", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			fmt.Fprint(w, "PNG")
+		})
+		mux.HandleFunc("/netlify.zip", func(w http.ResponseWriter, r *http.Request) {
+			w.Write(zipArchive(map[string]string{
+				"site/index.html":         "<html></html>",
+				"site/js/app.js":          "alert(1);",
+				"site/_redirects":         "/old /new",
+				"site/netlify.toml":       "[build]",
+				"__MACOSX/site/._js":      "",
+				"site/.DS_Store":          "",
+				"site/docs/_redirects.md": "not a provider file",
+			}))
+		})
+		mux.HandleFunc("/surge.tar.gz", func(w http.ResponseWriter, r *http.Request) {
+			w.Write(tarGzArchive(map[string]string{
+				"index.html": "<html></html>",
+				"CNAME":      "example.surge.sh",
+				"200.html":   "<html></html>",
+			}))
+		})
+		mux.HandleFunc("/empty.zip", func(w http.ResponseWriter, r *http.Request) {
+			w.Write(zipArchive(map[string]string{}))
+		})
+		mux.HandleFunc("/not-an-archive", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "hello")
+		})
+		siteServer = httptest.NewServer(mux)
+
+		u := factories.User(db)
+		proj = factories.Project(db, u)
+		depl = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+			State: deployment.StatePendingUpload,
+		})
+
+		origS3 = importd.S3
+		fakeS3 = &fake.S3{}
+		importd.S3 = fakeS3
+	})
+
+	AfterEach(func() {
+		siteServer.Close()
+		importd.S3 = origS3
+		importd.AllowPrivateIPs = false
+	})
+
+	Context("when the provider is url", func() {
+		It("crawls the site on the same host and uploads the files it links to", func() {
+			Expect(work("url", "/")).To(BeNil())
+
+			files := uploadedFiles()
+			Expect(files).To(HaveLen(4))
+			Expect(files).To(HaveKey("index.html"))
+			Expect(files).To(HaveKey("about/index.html"))
+			Expect(files["css/app.css"]).To(Equal(`body { background: url("../img/bg.png"); }`))
+			Expect(files["img/bg.png"]).To(Equal("PNG"))
+		})
+
+		It("enqueues a build job and updates the deployment state", func() {
+			Expect(work("url", "/")).To(BeNil())
+
+			d := testhelper.ConsumeQueue(mq, queues.Build)
+			Expect(d).NotTo(BeNil())
+			Expect(d.Body).To(MatchJSON(fmt.Sprintf(`{
+				"deployment_id": %d
+			}`, depl.ID)))
+
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			Expect(depl.State).To(Equal(deployment.StatePendingBuild))
+		})
+
+		Context("when the page cannot be fetched", func() {
+			It("fails the deployment with the reason", func() {
+				Expect(work("url", "/missing.html")).To(Equal(importd.ErrImportFailed))
+				Expect(fakeS3.UploadCalls.Count()).To(Equal(0))
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StateDeployFailed))
+				Expect(*depl.ErrorMessage).To(ContainSubstring("responded with status 404"))
+			})
+		})
+
+		Context("when the site is on a private address", func() {
+			BeforeEach(func() {
+				importd.AllowPrivateIPs = false
+			})
+
+			It("refuses to fetch it", func() {
+				Expect(work("url", "/")).To(Equal(importd.ErrImportFailed))
+				Expect(fakeS3.UploadCalls.Count()).To(Equal(0))
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(*depl.ErrorMessage).To(ContainSubstring("address is not publicly routable"))
+			})
+		})
+	})
+
+	Context("when the provider is netlify", func() {
+		It("uploads the files in the export without netlify's configuration and macOS metadata", func() {
+			Expect(work("netlify", "/netlify.zip")).To(BeNil())
+
+			files := uploadedFiles()
+			Expect(files).To(HaveLen(3))
+			Expect(files["index.html"]).To(Equal("<html></html>"))
+			Expect(files["js/app.js"]).To(Equal("alert(1);"))
+			Expect(files).To(HaveKey("docs/_redirects.md"))
+		})
+	})
+
+	Context("when the provider is surge", func() {
+		It("uploads the files in the export without surge's configuration", func() {
+			Expect(work("surge", "/surge.tar.gz")).To(BeNil())
+
+			files := uploadedFiles()
+			Expect(files).To(HaveLen(2))
+			Expect(files).To(HaveKey("index.html"))
+			Expect(files).To(HaveKey("200.html"))
+		})
+
+		Context("when the project's skip_build column is true", func() {
+			BeforeEach(func() {
+				proj.SkipBuild = true
+				Expect(db.Save(proj).Error).To(BeNil())
+			})
+
+			It("enqueues a deploy job", func() {
+				Expect(work("surge", "/surge.tar.gz")).To(BeNil())
+
+				d := testhelper.ConsumeQueue(mq, queues.Deploy)
+				Expect(d).NotTo(BeNil())
+				Expect(d.Body).To(MatchJSON(fmt.Sprintf(`{
+					"deployment_id": %d,
+					"skip_webroot_upload": false,
+					"skip_invalidation": false,
+					"use_raw_bundle": true
+				}`, depl.ID)))
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StatePendingDeploy))
+			})
+		})
+	})
+
+	DescribeTable("exports that cannot be imported",
+		func(path, message string) {
+			Expect(work("netlify", path)).To(Equal(importd.ErrImportFailed))
+			Expect(fakeS3.UploadCalls.Count()).To(Equal(0))
+
+			Expect(db.First(depl, depl.ID).Error).To(BeNil())
+			Expect(depl.State).To(Equal(deployment.StateDeployFailed))
+			Expect(*depl.ErrorMessage).To(ContainSubstring(message))
+		},
+		Entry("empty archive", "/empty.zip", "does not contain any files"),
+		Entry("not an archive", "/not-an-archive", "is not a zip or gzipped tar archive"),
+	)
+
+	Context("when the deployment is not pending upload", func() {
+		BeforeEach(func() {
+			Expect(depl.UpdateState(db, deployment.StateDeployed)).To(BeNil())
+		})
+
+		It("returns ErrUnexpectedDeploymentState", func() {
+			Expect(work("url", "/")).To(Equal(importd.ErrUnexpectedDeploymentState))
+			Expect(fakeS3.UploadCalls.Count()).To(Equal(0))
+		})
+	})
+
+	Context("when the project is deleted", func() {
+		BeforeEach(func() {
+			Expect(proj.Destroy(db)).To(BeNil())
+		})
+
+		It("returns ErrRecordNotFound so it can start next job", func() {
+			Expect(work("url", "/")).To(Equal(importd.ErrRecordNotFound))
+		})
+	})
+})
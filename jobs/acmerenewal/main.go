@@ -13,17 +13,15 @@ import (
 	"time"
 
 	log "github.com/Sirupsen/logrus"
-	"github.com/ericchiang/letsencrypt"
 	"github.com/jinzhu/gorm"
 	"github.com/nitrous-io/rise-server/apiserver/common"
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
 	"github.com/nitrous-io/rise-server/apiserver/models/acmecert"
 	"github.com/nitrous-io/rise-server/apiserver/models/cert"
+	"github.com/nitrous-io/rise-server/apiserver/models/certevent"
 	"github.com/nitrous-io/rise-server/apiserver/models/domain"
 	"github.com/nitrous-io/rise-server/pkg/aesencrypter"
-	"github.com/nitrous-io/rise-server/pkg/pubsub"
-	"github.com/nitrous-io/rise-server/shared/exchanges"
-	"github.com/nitrous-io/rise-server/shared/messages"
+	"github.com/nitrous-io/rise-server/shared/invalidation"
 	"github.com/nitrous-io/rise-server/shared/s3client"
 )
 
@@ -41,6 +39,10 @@ var (
 	fields          = log.Fields{"job": jobName}
 	expiryThreshold = 30 * 24 * time.Hour // Renew certs that have < 30 days left
 
+	// Emit an "expiring soon" event for certs that have < 14 days left, which
+	// are either uploaded certs or Let's Encrypt certs that failed to renew.
+	expiringSoonThreshold = 14 * 24 * time.Hour
+
 	numRenewed int
 	numFailed  int
 )
@@ -86,14 +88,63 @@ func main() {
 
 	log.WithFields(fields).WithField("event", "completed").
 		Infof("Attempted renewal of %d ACME certificates, success: %d, failed: %d", len(acmeCerts), numRenewed, numFailed)
+
+	n, err := emitExpiringSoonEvents(db, time.Now().Add(expiringSoonThreshold))
+	if err != nil {
+		log.WithFields(fields).Fatalf("failed to emit events for expiring certs, err: %v", err)
+	}
+
+	log.WithFields(fields).Infof("Emitted %d cert expiring soon events", n)
+}
+
+// emitExpiringSoonEvents emits an ExpiringSoon cert event for each cert that
+// expires before the deadline, unless one has already been emitted for it.
+// It returns the number of events emitted.
+func emitExpiringSoonEvents(db *gorm.DB, deadline time.Time) (int, error) {
+	certs := []*cert.Cert{}
+	if err := db.Where("expires_at <= ?", deadline).Order("expires_at ASC").Find(&certs).Error; err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, ct := range certs {
+		exists, err := certevent.Exists(db, ct.DomainID, certevent.ExpiringSoon, ct.ExpiresAt)
+		if err != nil {
+			return n, err
+		}
+		if exists {
+			continue
+		}
+
+		var dom domain.Domain
+		if err := db.First(&dom, ct.DomainID).Error; err != nil {
+			if err == gorm.RecordNotFound {
+				continue
+			}
+			return n, err
+		}
+
+		expiresAt := ct.ExpiresAt
+		msg := fmt.Sprintf("Expires in %d days", int(expiresAt.Sub(time.Now()).Hours()/24))
+		if expiresAt.Before(time.Now()) {
+			msg = "Expired"
+		}
+		if err := certevent.Emit(db, certevent.New(&dom, certevent.ExpiringSoon, &expiresAt, msg)); err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	return n, nil
 }
 
 // findExpiringAcmeCerts returns AcmeCerts that expire before the deadline.
+// Certs of deleted domains are not renewed.
 func findExpiringAcmeCerts(db *gorm.DB, deadline time.Time) ([]*acmecert.AcmeCert, error) {
 	acmeCerts := []*acmecert.AcmeCert{}
 
 	certs := []*cert.Cert{}
-	if err := db.Where("expires_at <= ?", deadline).Find(&certs).Error; err != nil {
+	if err := db.Where("expires_at <= ? AND domain_id IN (SELECT id FROM domains WHERE deleted_at IS NULL)", deadline).Find(&certs).Error; err != nil {
 		return nil, err
 	}
 	if len(certs) == 0 {
@@ -119,6 +170,10 @@ func renewer(db *gorm.DB, wg *sync.WaitGroup, jobs chan *acmecert.AcmeCert) {
 		if err := renew(db, cert); err != nil {
 			log.WithFields(fields).Errorf("failed to renew ACME cert ID %d, err: %v", cert.ID, err)
 			numFailed++
+
+			if err := emitRenewalFailedEvent(db, cert, err); err != nil {
+				log.WithFields(fields).Errorf("failed to emit renewal failed event for ACME cert ID %d, err: %v", cert.ID, err)
+			}
 		} else {
 			numRenewed++
 		}
@@ -129,7 +184,7 @@ func renewer(db *gorm.DB, wg *sync.WaitGroup, jobs chan *acmecert.AcmeCert) {
 }
 
 func renew(db *gorm.DB, acmeCert *acmecert.AcmeCert) error {
-	certChain, err := acmeCert.DecryptedCerts(common.AesKey)
+	certChain, err := acmeCert.DecryptedCerts(common.AesKeyring())
 	if err != nil {
 		return fmt.Errorf("failed to decrypt ACME cert %d, err: %v", acmeCert.ID, err)
 	}
@@ -142,7 +197,7 @@ func renew(db *gorm.DB, acmeCert *acmecert.AcmeCert) error {
 	x509Cert := certChain[0]
 	log.WithFields(fields).Infof("ACME cert %d for %q expires on %v", acmeCert.ID, dom.Name, x509Cert.NotAfter)
 
-	cli, err := letsencrypt.NewClient(common.AcmeURL)
+	cli, err := common.NewAcmeClient()
 	if err != nil {
 		return err
 	}
@@ -165,7 +220,7 @@ func renew(db *gorm.DB, acmeCert *acmecert.AcmeCert) error {
 	if certResp.Certificate.Equal(x509Cert) {
 		log.WithFields(fields).Infof("Let's Encrypt returned an identical cert for ACME cert ID %d - requesting a new cert instead...", acmeCert.ID)
 
-		certKey, err := acmeCert.DecryptedPrivateKey(common.AesKey)
+		certKey, err := acmeCert.DecryptedPrivateKey(common.AesKeyring())
 		if err != nil {
 			return err
 		}
@@ -186,7 +241,7 @@ func renew(db *gorm.DB, acmeCert *acmecert.AcmeCert) error {
 			return err
 		}
 
-		leKey, err := acmeCert.DecryptedLetsencryptKey(common.AesKey)
+		leKey, err := acmeCert.DecryptedLetsencryptKey(common.AesKeyring())
 		if err != nil {
 			return err
 		}
@@ -218,7 +273,7 @@ func renew(db *gorm.DB, acmeCert *acmecert.AcmeCert) error {
 		return err
 	}
 
-	if err := acmeCert.SaveCert(db, bundledPEM, common.AesKey); err != nil {
+	if err := acmeCert.SaveCert(db, bundledPEM, common.AesKeyring()); err != nil {
 		return err
 	}
 
@@ -240,11 +295,29 @@ func renew(db *gorm.DB, acmeCert *acmecert.AcmeCert) error {
 		return err
 	}
 
+	if err := certevent.Emit(db, certevent.New(&dom, certevent.Renewed, &ct.ExpiresAt, "")); err != nil {
+		log.WithFields(fields).Errorf("failed to emit renewed event for ACME cert ID %d, err: %v", acmeCert.ID, err)
+	}
+
 	log.WithFields(fields).Infof("Successfully renewed cert ID %d", acmeCert.ID)
 
 	return nil
 }
 
+func emitRenewalFailedEvent(db *gorm.DB, acmeCert *acmecert.AcmeCert, renewErr error) error {
+	var dom domain.Domain
+	if err := db.First(&dom, acmeCert.DomainID).Error; err != nil {
+		return err
+	}
+
+	var ct cert.Cert
+	if err := db.Where("domain_id = ?", acmeCert.DomainID).First(&ct).Error; err != nil {
+		return err
+	}
+
+	return certevent.Emit(db, certevent.New(&dom, certevent.RenewalFailed, &ct.ExpiresAt, renewErr.Error()))
+}
+
 func uploadCert(domainName string, cert []byte) error {
 	certPath := fmt.Sprintf("certs/%s/ssl.crt", domainName) // TODO This should be a method of domain.Domain.
 	encryptedCert, err := aesencrypter.Encrypt(cert, []byte(common.AesKey))
@@ -257,12 +330,5 @@ func uploadCert(domainName string, cert []byte) error {
 	}
 
 	// Invalidate cert cache
-	m, err := pubsub.NewMessageWithJSON(exchanges.Edges, exchanges.RouteV1Invalidation, &messages.V1InvalidationMessageData{
-		Domains: []string{domainName},
-	})
-	if err != nil {
-		return err
-	}
-
-	return m.Publish()
+	return invalidation.Invalidate([]string{domainName})
 }
@@ -11,6 +11,7 @@ import (
 	"github.com/nitrous-io/rise-server/apiserver/dbconn"
 	"github.com/nitrous-io/rise-server/apiserver/models/acmecert"
 	"github.com/nitrous-io/rise-server/apiserver/models/cert"
+	"github.com/nitrous-io/rise-server/apiserver/models/certevent"
 	"github.com/nitrous-io/rise-server/apiserver/models/domain"
 	"github.com/nitrous-io/rise-server/pkg/aesencrypter"
 	"github.com/nitrous-io/rise-server/pkg/filetransfer"
@@ -23,7 +24,6 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/onsi/gomega/ghttp"
-	"github.com/streadway/amqp"
 )
 
 func Test(t *testing.T) {
@@ -143,6 +143,19 @@ var _ = Describe("acmerenewal", func() {
 			}
 			Expect(domainIDs).To(ConsistOf(dm1.ID, dm2.ID, dm3.ID))
 		})
+
+		It("does not return ACME certificates of deleted domains", func() {
+			Expect(db.Delete(dm2).Error).To(BeNil())
+
+			certs, err := findExpiringAcmeCerts(db, time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC))
+			Expect(err).To(BeNil())
+
+			domainIDs := []uint{}
+			for _, ct := range certs {
+				domainIDs = append(domainIDs, ct.DomainID)
+			}
+			Expect(domainIDs).To(ConsistOf(dm1.ID, dm3.ID))
+		})
 	})
 
 	Describe("renew()", func() {
@@ -150,7 +163,7 @@ var _ = Describe("acmerenewal", func() {
 			fakeS3 *fake.S3
 			origS3 filetransfer.FileTransfer
 
-			mq                    *amqp.Connection
+			mq                    mqconn.Conn
 			invalidationQueueName string
 
 			acmeServer *ghttp.Server
@@ -233,11 +246,11 @@ var _ = Describe("acmerenewal", func() {
 			}
 			Expect(db.Create(ct).Error).To(BeNil())
 
-			acmeCert, err = acmecert.New(dm.ID, common.AesKey)
+			acmeCert, err = acmecert.New(dm.ID, common.AesKeyring())
 			Expect(err).To(BeNil())
 			Expect(db.Create(acmeCert).Error).To(BeNil())
 			bundledPEM := append(currentCert, issuerCert...)
-			err := acmeCert.SaveCert(db, bundledPEM, common.AesKey)
+			err := acmeCert.SaveCert(db, bundledPEM, common.AesKeyring())
 			Expect(err).To(BeNil())
 			acmeCert.CertURI = acmeServer.URL() + `/renew-cert/cert-1`
 			err = db.Save(acmeCert).Error
@@ -267,7 +280,7 @@ var _ = Describe("acmerenewal", func() {
 
 			Expect(acmeCert2.Cert).NotTo(Equal(origCert))
 
-			certChain, err := acmeCert2.DecryptedCerts(common.AesKey)
+			certChain, err := acmeCert2.DecryptedCerts(common.AesKeyring())
 			Expect(err).To(BeNil())
 			x509Cert := certChain[0]
 			Expect(x509Cert.Raw).To(Equal(renewedCertPEM.Bytes))
@@ -285,7 +298,7 @@ var _ = Describe("acmerenewal", func() {
 			err = db.Where("domain_id = ?", dm.ID).First(acmeCert).Error
 			Expect(err).To(BeNil())
 
-			certChain, err := acmeCert.DecryptedCerts(common.AesKey)
+			certChain, err := acmeCert.DecryptedCerts(common.AesKeyring())
 			Expect(err).To(BeNil())
 			x509Cert := certChain[0]
 
@@ -327,6 +340,20 @@ var _ = Describe("acmerenewal", func() {
 			Expect(d.Body).To(MatchJSON(`{"domains": ["` + dm.Name + `"]}`))
 		})
 
+		It("emits a cert renewed event", func() {
+			err := renew(db, acmeCert)
+			Expect(err).To(BeNil())
+
+			ev := &certevent.CertEvent{}
+			Expect(db.Last(ev).Error).To(BeNil())
+			Expect(ev.Event).To(Equal(certevent.Renewed))
+			Expect(ev.DomainID).To(Equal(dm.ID))
+
+			ct := &cert.Cert{}
+			Expect(db.Where("domain_id = ?", dm.ID).First(ct).Error).To(BeNil())
+			Expect(ev.ExpiresAt.UTC()).To(Equal(ct.ExpiresAt.UTC()))
+		})
+
 		Context("when Let's Encrypt does not return a certificate", func() {
 			BeforeEach(func() {
 				renewCertBody = ``
@@ -366,6 +393,45 @@ var _ = Describe("acmerenewal", func() {
 			})
 		})
 	})
+
+	Describe("emitExpiringSoonEvents()", func() {
+		var dm1, dm2 *domain.Domain
+
+		BeforeEach(func() {
+			u, _ := factories.AuthDuo(db)
+			proj := factories.Project(db, u)
+			dm1 = factories.Domain(db, proj)
+			dm2 = factories.Domain(db, proj)
+
+			for i, dm := range []*domain.Domain{dm1, dm2} {
+				Expect(db.Create(&cert.Cert{
+					DomainID:        dm.ID,
+					CertificatePath: "certs/1",
+					PrivateKeyPath:  "keys/1",
+					StartsAt:        time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC),
+					ExpiresAt:       time.Date(2016, time.June+time.Month(i), 1, 0, 0, 0, 0, time.UTC),
+				}).Error).To(BeNil())
+			}
+		})
+
+		It("emits an event for each cert that expires before the deadline only once", func() {
+			n, err := emitExpiringSoonEvents(db, time.Date(2016, 6, 15, 0, 0, 0, 0, time.UTC))
+			Expect(err).To(BeNil())
+			Expect(n).To(Equal(1))
+
+			exists, err := certevent.Exists(db, dm1.ID, certevent.ExpiringSoon, time.Date(2016, 6, 1, 0, 0, 0, 0, time.UTC))
+			Expect(err).To(BeNil())
+			Expect(exists).To(BeTrue())
+
+			n, err = emitExpiringSoonEvents(db, time.Date(2016, 7, 15, 0, 0, 0, 0, time.UTC))
+			Expect(err).To(BeNil())
+			Expect(n).To(Equal(1))
+
+			exists, err = certevent.Exists(db, dm2.ID, certevent.ExpiringSoon, time.Date(2016, 7, 1, 0, 0, 0, 0, time.UTC))
+			Expect(err).To(BeNil())
+			Expect(exists).To(BeTrue())
+		})
+	})
 })
 
 var currentCert = []byte(`-----BEGIN CERTIFICATE-----
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/cert"
+	"github.com/nitrous-io/rise-server/apiserver/models/certevent"
+	"github.com/nitrous-io/rise-server/apiserver/models/ctalert"
+	"github.com/nitrous-io/rise-server/apiserver/models/domain"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	ruser "github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/shared/emails"
+)
+
+func init() {
+	riseEnv := os.Getenv("RISE_ENV")
+	if riseEnv == "" {
+		riseEnv = "development"
+		os.Setenv("RISE_ENV", riseEnv)
+	}
+
+	if u := os.Getenv("CT_SEARCH_URL"); u != "" {
+		CTSearchURL = u
+	}
+
+	if issuers := os.Getenv("CT_EXPECTED_ISSUERS"); issuers != "" {
+		ExpectedIssuers = strings.Split(issuers, ",")
+	}
+}
+
+const jobName = "ct-monitor"
+
+var fields = log.Fields{"job": jobName}
+
+var (
+	// CTSearchURL is the URL of the crt.sh-compatible service used to search
+	// certificate transparency logs.
+	CTSearchURL = "https://crt.sh/"
+
+	// ExpectedIssuers are the names of the CAs we obtain certs from. A cert
+	// whose issuer name contains none of these, nor the issuer of the cert
+	// uploaded for the domain, is unexpected.
+	ExpectedIssuers = []string{"Let's Encrypt"}
+
+	// RequestInterval is how long to wait between searches so as not to
+	// overload the search service.
+	RequestInterval = time.Second
+
+	// RequestTimeout is how long a search may take.
+	RequestTimeout = 30 * time.Second
+)
+
+func main() {
+	if u, err := user.Current(); err == nil {
+		fields["user"] = u.Username
+	}
+	log.WithFields(fields).WithField("event", "start").
+		Infof("Searching certificate transparency logs for certs of custom domains...")
+
+	db, err := dbconn.DB()
+	if err != nil {
+		log.WithFields(fields).Fatalf("failed to initialize db, err: %v", err)
+	}
+
+	checked, alerted, err := monitorDomains(db)
+	if err != nil {
+		log.WithFields(fields).Fatalf("failed to monitor domains, err: %v", err)
+	}
+
+	log.WithFields(fields).WithField("event", "completed").
+		Infof("Checked %d domains, unexpected certs found: %d", checked, alerted)
+}
+
+// logEntry is a cert found in certificate transparency logs, as returned by
+// the search service.
+type logEntry struct {
+	ID         int64  `json:"id"`
+	IssuerName string `json:"issuer_name"`
+	NameValue  string `json:"name_value"`
+	NotBefore  string `json:"not_before"`
+	NotAfter   string `json:"not_after"`
+}
+
+// logEntryTimeFormat is the format of the timestamps of log entries, which
+// are in UTC.
+const logEntryTimeFormat = "2006-01-02T15:04:05"
+
+// monitorDomains searches certificate transparency logs for unexpired certs
+// of all active custom domains, and alerts the owners of domains for which an
+// unexpected CA has issued a cert since the domain was added. It returns the
+// number of domains checked and certs alerted on.
+func monitorDomains(db *gorm.DB) (checked, alerted int, err error) {
+	var doms []*domain.Domain
+	if err := db.Where("state = ?", domain.StateActive).Order("id ASC").Find(&doms).Error; err != nil {
+		return 0, 0, err
+	}
+
+	for i, dom := range doms {
+		if i > 0 {
+			time.Sleep(RequestInterval)
+		}
+
+		entries, err := search(dom.Name)
+		if err != nil {
+			// The search service is known to be flaky, so carry on with the
+			// other domains and try again on the next run.
+			log.WithFields(fields).Errorf("failed to search certificate transparency logs for %q, err: %v", dom.Name, err)
+			continue
+		}
+		checked++
+
+		expected, err := expectedIssuers(db, dom)
+		if err != nil {
+			return checked, alerted, err
+		}
+
+		for _, entry := range entries {
+			ok, err := checkEntry(db, dom, entry, expected)
+			if err != nil {
+				return checked, alerted, err
+			}
+			if ok {
+				alerted++
+			}
+		}
+	}
+
+	return checked, alerted, nil
+}
+
+// search returns the unexpired certs of a domain in certificate transparency
+// logs.
+func search(domainName string) ([]*logEntry, error) {
+	u, err := url.Parse(CTSearchURL)
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = url.Values{
+		"q":       {domainName},
+		"output":  {"json"},
+		"exclude": {"expired"},
+	}.Encode()
+
+	client := &http.Client{Timeout: RequestTimeout}
+	res, err := client.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response status %d", res.StatusCode)
+	}
+
+	var entries []*logEntry
+	if err := json.NewDecoder(res.Body).Decode(&entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// expectedIssuers returns the names of the CAs that are expected to issue
+// certs for a domain.
+func expectedIssuers(db *gorm.DB, dom *domain.Domain) ([]string, error) {
+	expected := ExpectedIssuers
+
+	ct := &cert.Cert{}
+	if err := db.Where("domain_id = ?", dom.ID).First(ct).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return expected, nil
+		}
+		return nil, err
+	}
+
+	if ct.Issuer != nil && *ct.Issuer != "" {
+		expected = append([]string{*ct.Issuer}, expected...)
+	}
+	return expected, nil
+}
+
+// checkEntry alerts the owner of a domain about a cert in certificate
+// transparency logs if it was issued by an unexpected CA after the domain was
+// added, and has not been alerted on before. It returns whether an alert was
+// sent.
+func checkEntry(db *gorm.DB, dom *domain.Domain, entry *logEntry, expected []string) (bool, error) {
+	for _, issuer := range expected {
+		if strings.Contains(entry.IssuerName, issuer) {
+			return false, nil
+		}
+	}
+
+	notBefore, err := time.Parse(logEntryTimeFormat, entry.NotBefore)
+	if err != nil {
+		log.WithFields(fields).Errorf("invalid not_before %q of log entry %d, err: %v", entry.NotBefore, entry.ID, err)
+		return false, nil
+	}
+	notAfter, err := time.Parse(logEntryTimeFormat, entry.NotAfter)
+	if err != nil {
+		log.WithFields(fields).Errorf("invalid not_after %q of log entry %d, err: %v", entry.NotAfter, entry.ID, err)
+		return false, nil
+	}
+
+	// Certs issued before the domain was added were most likely requested by
+	// wherever the domain was hosted before.
+	if notBefore.Before(dom.CreatedAt) {
+		return false, nil
+	}
+
+	exists, err := ctalert.Exists(db, dom.ID, entry.ID)
+	if err != nil {
+		return false, err
+	}
+	if exists {
+		return false, nil
+	}
+
+	alert := &ctalert.CTAlert{
+		DomainID:   dom.ID,
+		LogEntryID: entry.ID,
+		IssuerName: entry.IssuerName,
+		NotBefore:  notBefore,
+		NotAfter:   notAfter,
+	}
+	if err := db.Create(alert).Error; err != nil {
+		return false, err
+	}
+
+	log.WithFields(fields).Infof("Found cert for %q issued by unexpected CA %q, log entry ID: %d", dom.Name, entry.IssuerName, entry.ID)
+
+	notify(db, dom, alert)
+
+	return true, nil
+}
+
+// notify emails the owner of a domain about an unexpected cert and emits a
+// cert event for it. Errors are logged.
+func notify(db *gorm.DB, dom *domain.Domain, alert *ctalert.CTAlert) {
+	logEntryURL := logEntryURL(alert.LogEntryID)
+
+	ev := certevent.New(dom, certevent.UnexpectedIssuance, &alert.NotAfter,
+		fmt.Sprintf("Issued by %s, see %s", alert.IssuerName, logEntryURL))
+	if err := certevent.Emit(db, ev); err != nil {
+		log.WithFields(fields).Errorf("failed to emit cert event for domain %q, err: %v", dom.Name, err)
+	}
+
+	proj := &project.Project{}
+	if err := db.First(proj, dom.ProjectID).Error; err != nil {
+		log.WithFields(fields).Errorf("failed to find project %d, err: %v", dom.ProjectID, err)
+		return
+	}
+
+	u := &ruser.User{}
+	if err := db.First(u, proj.UserID).Error; err != nil {
+		log.WithFields(fields).Errorf("failed to find owner of project %d, err: %v", proj.ID, err)
+		return
+	}
+
+	if err := common.SendTemplatedMail([]string{u.Email}, emails.UnexpectedCert, &emails.UnexpectedCertData{
+		Prefs:       u.EmailPrefs(),
+		ProjectName: proj.Name,
+		DomainName:  dom.Name,
+		IssuerName:  alert.IssuerName,
+		NotBefore:   alert.NotBefore,
+		LogEntryURL: logEntryURL,
+	}); err != nil {
+		log.WithFields(fields).Errorf("failed to send unexpected cert email for domain %q, err: %v", dom.Name, err)
+	}
+}
+
+// logEntryURL returns the URL at which a log entry can be viewed.
+func logEntryURL(id int64) string {
+	u, err := url.Parse(CTSearchURL)
+	if err != nil {
+		return CTSearchURL
+	}
+	u.RawQuery = url.Values{"id": {fmt.Sprint(id)}}.Encode()
+	return u.String()
+}
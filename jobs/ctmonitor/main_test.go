@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/cert"
+	"github.com/nitrous-io/rise-server/apiserver/models/certevent"
+	"github.com/nitrous-io/rise-server/apiserver/models/ctalert"
+	"github.com/nitrous-io/rise-server/apiserver/models/domain"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	ruser "github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/pkg/mailer"
+	"github.com/nitrous-io/rise-server/pkg/tracker"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+	"github.com/nitrous-io/rise-server/testhelper/fake"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "ctmonitor")
+}
+
+var _ = Describe("ctmonitor", func() {
+	var (
+		err error
+
+		db *gorm.DB
+
+		fakeMailer  *fake.Mailer
+		origMailer  mailer.Mailer
+		fakeTracker *fake.Tracker
+		origTracker tracker.Trackable
+
+		ctServer            *ghttp.Server
+		origCTSearchURL     string
+		origRequestInterval time.Duration
+
+		u    *ruser.User
+		proj *project.Project
+		dom  *domain.Domain
+
+		statusCode int
+		entries    string
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+
+		origMailer = common.Mailer
+		fakeMailer = &fake.Mailer{}
+		common.Mailer = fakeMailer
+
+		origTracker = common.Tracker
+		fakeTracker = &fake.Tracker{}
+		common.Tracker = fakeTracker
+
+		u = factories.User(db)
+		proj = factories.Project(db, u)
+		dom = factories.Domain(db, proj, "www.foo-bar.com")
+		Expect(db.Model(dom).Update("created_at", time.Date(2016, time.June, 1, 0, 0, 0, 0, time.UTC)).Error).To(BeNil())
+
+		pending := &domain.Domain{ProjectID: proj.ID, Name: "www.baz-qux.com", State: domain.StatePendingVerification}
+		Expect(db.Create(pending).Error).To(BeNil())
+
+		entries = `[
+			{
+				"id": 1001,
+				"issuer_name": "C=US, O=Let's Encrypt, CN=Let's Encrypt Authority X3",
+				"name_value": "www.foo-bar.com",
+				"not_before": "2016-07-01T00:00:00",
+				"not_after": "2016-09-29T00:00:00"
+			},
+			{
+				"id": 1002,
+				"issuer_name": "C=US, O=Sketchy CA, CN=Sketchy CA",
+				"name_value": "www.foo-bar.com",
+				"not_before": "2016-07-02T00:00:00",
+				"not_after": "2017-07-02T00:00:00"
+			},
+			{
+				"id": 1003,
+				"issuer_name": "C=US, O=Previous Host CA, CN=Previous Host CA",
+				"name_value": "www.foo-bar.com",
+				"not_before": "2016-05-01T00:00:00",
+				"not_after": "2017-05-01T00:00:00"
+			}
+		]`
+
+		statusCode = http.StatusOK
+
+		ctServer = ghttp.NewServer()
+		ctServer.RouteToHandler("GET", "/", ghttp.CombineHandlers(
+			ghttp.VerifyRequest("GET", "/", "exclude=expired&output=json&q=www.foo-bar.com"),
+			ghttp.RespondWithPtr(&statusCode, &entries),
+		))
+
+		origCTSearchURL = CTSearchURL
+		CTSearchURL = ctServer.URL() + "/"
+		origRequestInterval = RequestInterval
+		RequestInterval = 0
+	})
+
+	AfterEach(func() {
+		common.Mailer = origMailer
+		common.Tracker = origTracker
+		CTSearchURL = origCTSearchURL
+		RequestInterval = origRequestInterval
+		ctServer.Close()
+	})
+
+	Describe("monitorDomains()", func() {
+		It("only checks active domains", func() {
+			checked, _, err := monitorDomains(db)
+			Expect(err).To(BeNil())
+			Expect(checked).To(Equal(1))
+			Expect(ctServer.ReceivedRequests()).To(HaveLen(1))
+		})
+
+		It("records certs issued by unexpected CAs after the domain was added", func() {
+			_, alerted, err := monitorDomains(db)
+			Expect(err).To(BeNil())
+			Expect(alerted).To(Equal(1))
+
+			var alerts []*ctalert.CTAlert
+			Expect(db.Find(&alerts).Error).To(BeNil())
+			Expect(alerts).To(HaveLen(1))
+			Expect(alerts[0].DomainID).To(Equal(dom.ID))
+			Expect(alerts[0].LogEntryID).To(Equal(int64(1002)))
+			Expect(alerts[0].IssuerName).To(Equal("C=US, O=Sketchy CA, CN=Sketchy CA"))
+			Expect(alerts[0].NotBefore.Equal(time.Date(2016, time.July, 2, 0, 0, 0, 0, time.UTC))).To(BeTrue())
+		})
+
+		It("alerts the project owner", func() {
+			_, _, err := monitorDomains(db)
+			Expect(err).To(BeNil())
+
+			Expect(fakeMailer.SendMailCalled).To(BeTrue())
+			Expect(fakeMailer.Tos).To(Equal([]string{u.Email}))
+			Expect(fakeMailer.Subject).To(Equal("An SSL certificate for www.foo-bar.com was issued by an unexpected CA"))
+			Expect(fakeMailer.Body).To(ContainSubstring(ctServer.URL() + "/?id=1002"))
+
+			ev := &certevent.CertEvent{}
+			Expect(db.Last(ev).Error).To(BeNil())
+			Expect(ev.DomainID).To(Equal(dom.ID))
+			Expect(ev.Event).To(Equal(certevent.UnexpectedIssuance))
+
+			trackCall := fakeTracker.TrackCalls.NthCall(1)
+			Expect(trackCall).NotTo(BeNil())
+			Expect(trackCall.Arguments[0]).To(Equal(fmt.Sprintf("%d", u.ID)))
+			Expect(trackCall.Arguments[1]).To(Equal("Unexpected SSL Certificate Issued"))
+		})
+
+		It("only alerts once per cert", func() {
+			_, alerted, err := monitorDomains(db)
+			Expect(err).To(BeNil())
+			Expect(alerted).To(Equal(1))
+
+			_, alerted, err = monitorDomains(db)
+			Expect(err).To(BeNil())
+			Expect(alerted).To(Equal(0))
+		})
+
+		Context("when a cert from the unexpected CA was uploaded for the domain", func() {
+			BeforeEach(func() {
+				issuer := "Sketchy CA"
+				ct := &cert.Cert{
+					DomainID:        dom.ID,
+					CertificatePath: "certs/www.foo-bar.com/ssl.crt",
+					PrivateKeyPath:  "certs/www.foo-bar.com/ssl.key",
+					StartsAt:        time.Date(2016, time.July, 2, 0, 0, 0, 0, time.UTC),
+					ExpiresAt:       time.Date(2017, time.July, 2, 0, 0, 0, 0, time.UTC),
+					Issuer:          &issuer,
+				}
+				Expect(db.Create(ct).Error).To(BeNil())
+			})
+
+			It("does not alert", func() {
+				_, alerted, err := monitorDomains(db)
+				Expect(err).To(BeNil())
+				Expect(alerted).To(Equal(0))
+				Expect(fakeMailer.SendMailCalled).To(BeFalse())
+			})
+		})
+
+		Context("when the search fails", func() {
+			BeforeEach(func() {
+				statusCode = http.StatusBadGateway
+			})
+
+			It("skips the domain", func() {
+				checked, alerted, err := monitorDomains(db)
+				Expect(err).To(BeNil())
+				Expect(checked).To(Equal(0))
+				Expect(alerted).To(Equal(0))
+			})
+		})
+	})
+})
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/user"
+	"path"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/accesslogfile"
+	"github.com/nitrous-io/rise-server/apiserver/models/logdestination"
+	"github.com/nitrous-io/rise-server/pkg/filetransfer"
+	"github.com/nitrous-io/rise-server/shared/s3client"
+	"golang.org/x/net/context"
+)
+
+const jobName = "deliver-access-logs"
+
+var fields = log.Fields{"job": jobName}
+
+var (
+	// DeliveryTimeout is how long delivering the log files of a single
+	// destination may take before it is abandoned until the next run.
+	DeliveryTimeout = 10 * time.Minute
+
+	S3 filetransfer.FileTransfer = filetransfer.NewS3(s3client.PartSize, s3client.MaxUploadParts)
+
+	// DestS3 returns the FileTransfer used to write to a log destination.
+	DestS3 = func(dest *logdestination.LogDestination) (filetransfer.FileTransfer, error) {
+		if !dest.HasCredentials() {
+			return S3, nil
+		}
+
+		secret, err := dest.DecryptedSecretAccessKey(common.AesKeyring())
+		if err != nil {
+			return nil, err
+		}
+
+		return filetransfer.NewS3WithCredentials(s3client.PartSize, s3client.MaxUploadParts, *dest.AccessKeyID, secret), nil
+	}
+)
+
+func init() {
+	riseEnv := os.Getenv("RISE_ENV")
+	if riseEnv == "" {
+		riseEnv = "development"
+		os.Setenv("RISE_ENV", riseEnv)
+	}
+
+	if riseEnv != "test" {
+		if os.Getenv("AWS_ACCESS_KEY_ID") == "" || os.Getenv("AWS_SECRET_ACCESS_KEY") == "" {
+			log.Fatal("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables are required!")
+		}
+	}
+}
+
+func main() {
+	if u, err := user.Current(); err == nil {
+		fields["user"] = u.Username
+	}
+	log.WithFields(fields).WithField("event", "start").
+		Infof("Delivering access logs to log destinations...")
+
+	db, err := dbconn.DB()
+	if err != nil {
+		log.WithFields(fields).Fatalf("failed to initialize db, err: %v", err)
+	}
+
+	var dests []*logdestination.LogDestination
+	if err := db.Find(&dests).Error; err != nil {
+		log.WithFields(fields).Fatalf("failed to retrieve log destinations from db, err: %v", err)
+	}
+
+	log.WithFields(fields).Infof("Found %d log destinations", len(dests))
+
+	var (
+		wg       sync.WaitGroup
+		jobs     = make(chan *logdestination.LogDestination, len(dests))
+		nWorkers = 5
+	)
+
+	for i := 0; i < nWorkers; i++ {
+		go deliverer(db, &wg, jobs)
+	}
+
+	for _, dest := range dests {
+		wg.Add(1)
+		jobs <- dest
+	}
+
+	wg.Wait()
+
+	log.WithFields(fields).WithField("event", "completed").Infof("Finished delivering access logs")
+}
+
+func deliverer(db *gorm.DB, wg *sync.WaitGroup, jobs chan *logdestination.LogDestination) {
+	for dest := range jobs {
+		n, err := deliver(db, dest)
+		if err != nil {
+			log.WithFields(fields).Errorf("failed to deliver access logs of project %d to bucket %q, err: %v", dest.ProjectID, dest.BucketName, err)
+		} else if n > 0 {
+			log.WithFields(fields).Infof("Delivered %d access log files of project %d to bucket %q", n, dest.ProjectID, dest.BucketName)
+		}
+
+		wg.Done()
+	}
+}
+
+// deliver copies undelivered access log files of the destination's project to
+// the destination bucket, and returns the number of files delivered.
+func deliver(db *gorm.DB, dest *logdestination.LogDestination) (int, error) {
+	files, err := accesslogfile.Undelivered(db, dest.ProjectID)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(files) == 0 {
+		return 0, nil
+	}
+
+	destS3, err := DestS3(dest)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DeliveryTimeout)
+	defer cancel()
+
+	delivered := 0
+	for _, f := range files {
+		buf := &aws.WriteAtBuffer{}
+		if err := filetransfer.WithContext(ctx, func() error {
+			return S3.Download(s3client.BucketRegion, s3client.BucketName, f.Path, buf)
+		}); err != nil {
+			return delivered, err
+		}
+
+		key := path.Join(dest.KeyPrefix(), f.CreatedAt.UTC().Format("2006/01/02"), path.Base(f.Path))
+		if err := filetransfer.WithContext(ctx, func() error {
+			return destS3.Upload(dest.BucketRegion, dest.BucketName, key, bytes.NewReader(buf.Bytes()), "application/x-ndjson", "bucket-owner-full-control")
+		}); err != nil {
+			return delivered, err
+		}
+
+		if err := f.MarkDelivered(db); err != nil {
+			return delivered, err
+		}
+
+		if err := S3.Delete(s3client.BucketRegion, s3client.BucketName, f.Path); err != nil {
+			log.WithFields(fields).Warnf("failed to delete delivered access log file %q, err: %v", f.Path, err)
+		}
+
+		delivered++
+	}
+
+	if err := db.Model(logdestination.LogDestination{}).Where("id = ?", dest.ID).UpdateColumn("last_delivered_at", time.Now()).Error; err != nil {
+		return delivered, err
+	}
+
+	return delivered, nil
+}
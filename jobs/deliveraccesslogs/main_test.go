@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/accesslogfile"
+	"github.com/nitrous-io/rise-server/apiserver/models/logdestination"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/pkg/filetransfer"
+	"github.com/nitrous-io/rise-server/shared/s3client"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+	"github.com/nitrous-io/rise-server/testhelper/fake"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "deliveraccesslogs")
+}
+
+var _ = Describe("deliveraccesslogs", func() {
+	var (
+		fakeS3     *fake.MemoryS3
+		fakeDestS3 *fake.MemoryS3
+		origS3     filetransfer.FileTransfer
+		origDestS3 func(*logdestination.LogDestination) (filetransfer.FileTransfer, error)
+		err        error
+
+		db *gorm.DB
+
+		proj   *project.Project
+		dest   *logdestination.LogDestination
+		f1, f2 *accesslogfile.AccessLogFile
+	)
+
+	BeforeEach(func() {
+		origS3 = S3
+		fakeS3 = fake.NewMemoryS3()
+		S3 = fakeS3
+
+		origDestS3 = DestS3
+		fakeDestS3 = fake.NewMemoryS3()
+		DestS3 = func(*logdestination.LogDestination) (filetransfer.FileTransfer, error) {
+			return fakeDestS3, nil
+		}
+
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+
+		testhelper.TruncateTables(db.DB())
+
+		proj = factories.Project(db, nil)
+
+		dest = &logdestination.LogDestination{
+			ProjectID:    proj.ID,
+			BucketName:   "my-logs",
+			BucketRegion: "us-east-1",
+			Prefix:       "/pubstorm/",
+		}
+		Expect(db.Create(dest).Error).To(BeNil())
+
+		f1 = &accesslogfile.AccessLogFile{ProjectID: proj.ID, Path: "access-logs/1/aaa.log"}
+		Expect(db.Create(f1).Error).To(BeNil())
+		f2 = &accesslogfile.AccessLogFile{ProjectID: proj.ID, Path: "access-logs/1/bbb.log"}
+		Expect(db.Create(f2).Error).To(BeNil())
+		Expect(f2.MarkDelivered(db)).To(BeNil())
+
+		fakeS3.Put(s3client.BucketName, f1.Path, []byte(`{"domain":"www.example.com"}`))
+	})
+
+	AfterEach(func() {
+		S3 = origS3
+		DestS3 = origDestS3
+	})
+
+	Describe("deliver()", func() {
+		It("copies undelivered log files to the destination bucket", func() {
+			n, err := deliver(db, dest)
+			Expect(err).To(BeNil())
+			Expect(n).To(Equal(1))
+
+			Expect(fakeS3.DownloadCalls.Count()).To(Equal(1))
+			Expect(fakeS3.DownloadCalls.NthCall(1).Arguments[2]).To(Equal(f1.Path))
+
+			Expect(fakeDestS3.UploadCalls.Count()).To(Equal(1))
+			call := fakeDestS3.UploadCalls.NthCall(1)
+			Expect(call.Arguments[0]).To(Equal("us-east-1"))
+			Expect(call.Arguments[1]).To(Equal("my-logs"))
+			Expect(call.Arguments[2]).To(Equal("pubstorm/" + f1.CreatedAt.UTC().Format("2006/01/02") + "/aaa.log"))
+			Expect(call.Arguments[5]).To(Equal("bucket-owner-full-control"))
+
+			key := "pubstorm/" + f1.CreatedAt.UTC().Format("2006/01/02") + "/aaa.log"
+			Expect(fakeDestS3.Keys("my-logs")).To(Equal([]string{key}))
+			Expect(fakeDestS3.Content("my-logs", key)).To(Equal([]byte(`{"domain":"www.example.com"}`)))
+		})
+
+		It("marks delivered files and deletes them from S3", func() {
+			_, err := deliver(db, dest)
+			Expect(err).To(BeNil())
+
+			files, err := accesslogfile.Undelivered(db, proj.ID)
+			Expect(err).To(BeNil())
+			Expect(files).To(BeEmpty())
+
+			Expect(fakeS3.DeleteCalls.Count()).To(Equal(1))
+			Expect(fakeS3.DeleteCalls.NthCall(1).Arguments[2]).To(Equal(f1.Path))
+			Expect(fakeS3.Keys(s3client.BucketName)).To(BeEmpty())
+
+			Expect(db.First(dest, dest.ID).Error).To(BeNil())
+			Expect(dest.LastDeliveredAt).NotTo(BeNil())
+		})
+
+		Context("when uploading to the destination bucket fails", func() {
+			BeforeEach(func() {
+				fakeDestS3.UploadError = errors.New("access denied")
+			})
+
+			It("does not mark the file as delivered", func() {
+				_, err := deliver(db, dest)
+				Expect(err).To(Equal(fakeDestS3.UploadError))
+
+				files, err := accesslogfile.Undelivered(db, proj.ID)
+				Expect(err).To(BeNil())
+				Expect(files).To(HaveLen(1))
+				Expect(fakeS3.DeleteCalls.Count()).To(Equal(0))
+				Expect(fakeS3.Content(s3client.BucketName, f1.Path)).NotTo(BeNil())
+			})
+		})
+
+		Context("when a log file is missing from S3", func() {
+			BeforeEach(func() {
+				fakeS3.Delete(s3client.BucketRegion, s3client.BucketName, f1.Path)
+			})
+
+			It("returns an error and does not mark the file as delivered", func() {
+				_, err := deliver(db, dest)
+				Expect(err).NotTo(BeNil())
+
+				files, err := accesslogfile.Undelivered(db, proj.ID)
+				Expect(err).To(BeNil())
+				Expect(files).To(HaveLen(1))
+				Expect(fakeDestS3.UploadCalls.Count()).To(Equal(0))
+			})
+		})
+	})
+})
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"os/user"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/pkg/aesencrypter"
+)
+
+func init() {
+	riseEnv := os.Getenv("RISE_ENV")
+	if riseEnv == "" {
+		riseEnv = "development"
+		os.Setenv("RISE_ENV", riseEnv)
+	}
+}
+
+const jobName = "encrypt-js-env-vars"
+
+var (
+	fields = log.Fields{"job": jobName}
+
+	// BatchSize is the number of deployments that are encrypted at a time.
+	BatchSize = 500
+)
+
+func main() {
+	if u, err := user.Current(); err == nil {
+		fields["user"] = u.Username
+	}
+	log.WithFields(fields).WithField("event", "start").
+		Infof("Encrypting JS env vars of deployments...")
+
+	db, err := dbconn.DB()
+	if err != nil {
+		log.WithFields(fields).Fatalf("failed to initialize db, err: %v", err)
+	}
+
+	n, err := encryptJsEnvVars(db, common.AesKeyring())
+	if err != nil {
+		log.WithFields(fields).Fatalf("failed to encrypt JS env vars, err: %v", err)
+	}
+
+	log.WithFields(fields).WithField("event", "completed").
+		Infof("Encrypted JS env vars of %d deployments", n)
+}
+
+// encryptJsEnvVars encrypts the JS env vars of deployments that were created
+// before JS env vars were encrypted, and clears their unencrypted JS env vars.
+// It returns the number of deployments encrypted.
+func encryptJsEnvVars(db *gorm.DB, keyring *aesencrypter.Keyring) (int, error) {
+	n := 0
+	for {
+		var depls []*deployment.Deployment
+		if err := db.Where("encrypted_js_env_vars IS NULL").
+			Order("id ASC").Limit(BatchSize).Find(&depls).Error; err != nil {
+			return n, err
+		}
+
+		if len(depls) == 0 {
+			return n, nil
+		}
+
+		for _, depl := range depls {
+			vars, err := depl.DecryptedJsEnvVars(keyring)
+			if err != nil {
+				return n, err
+			}
+
+			if err := depl.SetJsEnvVars(vars, keyring); err != nil {
+				return n, err
+			}
+
+			if err := db.Model(depl).UpdateColumns(map[string]interface{}{
+				"encrypted_js_env_vars": depl.EncryptedJsEnvVars,
+				"js_env_vars":           depl.JsEnvVars,
+			}).Error; err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+}
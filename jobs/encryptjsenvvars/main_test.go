@@ -0,0 +1,96 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/pkg/aesencrypter"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "encryptjsenvvars")
+}
+
+var _ = Describe("encryptjsenvvars", func() {
+	keyring := aesencrypter.NewKeyring("something-something-something-32", nil)
+
+	var (
+		err error
+
+		db *gorm.DB
+
+		origBatchSize int
+
+		depl1, depl2, depl3 *deployment.Deployment
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+
+		origBatchSize = BatchSize
+		BatchSize = 1
+
+		u := factories.User(db)
+		proj := factories.Project(db, u)
+
+		depl1 = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+			JsEnvVars: []byte(`{"foo":"bar"}`),
+		})
+		depl2 = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+			JsEnvVars: []byte(`{}`),
+		})
+
+		depl3 = &deployment.Deployment{}
+		Expect(depl3.SetJsEnvVars(map[string]string{"baz": "qux"}, keyring)).To(Succeed())
+		depl3 = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+			EncryptedJsEnvVars: depl3.EncryptedJsEnvVars,
+		})
+	})
+
+	AfterEach(func() {
+		BatchSize = origBatchSize
+	})
+
+	Describe("encryptJsEnvVars()", func() {
+		It("encrypts the JS env vars of deployments that are not encrypted", func() {
+			n, err := encryptJsEnvVars(db, keyring)
+			Expect(err).To(BeNil())
+			Expect(n).To(Equal(2))
+
+			expected := map[*deployment.Deployment]map[string]string{
+				depl1: {"foo": "bar"},
+				depl2: {},
+				depl3: {"baz": "qux"},
+			}
+
+			for depl, vars := range expected {
+				d := &deployment.Deployment{}
+				Expect(db.First(d, depl.ID).Error).To(BeNil())
+				Expect(d.EncryptedJsEnvVars).NotTo(BeNil())
+				Expect(d.JsEnvVars).To(MatchJSON(`{}`))
+
+				decrypted, err := d.DecryptedJsEnvVars(keyring)
+				Expect(err).To(BeNil())
+				Expect(decrypted).To(Equal(vars))
+			}
+		})
+
+		It("does nothing when all deployments are encrypted", func() {
+			_, err := encryptJsEnvVars(db, keyring)
+			Expect(err).To(BeNil())
+
+			n, err := encryptJsEnvVars(db, keyring)
+			Expect(err).To(BeNil())
+			Expect(n).To(Equal(0))
+		})
+	})
+})
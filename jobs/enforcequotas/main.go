@@ -0,0 +1,160 @@
+package main
+
+import (
+	"os"
+	"os/user"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/jobrecord"
+	"github.com/nitrous-io/rise-server/apiserver/models/outboxjob"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/quotaevent"
+	"github.com/nitrous-io/rise-server/pkg/job"
+	"github.com/nitrous-io/rise-server/shared/messages"
+	"github.com/nitrous-io/rise-server/shared/queues"
+)
+
+func init() {
+	riseEnv := os.Getenv("RISE_ENV")
+	if riseEnv == "" {
+		riseEnv = "development"
+		os.Setenv("RISE_ENV", riseEnv)
+	}
+}
+
+const jobName = "enforce-quotas"
+
+var fields = log.Fields{"job": jobName}
+
+func main() {
+	if u, err := user.Current(); err == nil {
+		fields["user"] = u.Username
+	}
+	log.WithFields(fields).WithField("event", "start").
+		Infof("Degrading domains over their bandwidth quota...")
+
+	db, err := dbconn.DB()
+	if err != nil {
+		log.WithFields(fields).Fatalf("failed to initialize db, err: %v", err)
+	}
+	job.DefaultRecorder = &jobrecord.Recorder{DB: db}
+
+	degraded, restored, err := enforceQuotas(db, time.Now())
+	if err != nil {
+		log.WithFields(fields).Fatalf("failed to enforce quotas, err: %v", err)
+	}
+
+	log.WithFields(fields).WithField("event", "completed").
+		Infof("Republished meta.json of %d projects to degrade domains, and of %d projects to restore them", degraded, restored)
+}
+
+// enforceQuotas republishes the meta.json of the domains of projects that
+// degrade domains over their hard bandwidth quota instead of blocking them.
+// Domains that went over their quota today are degraded, and domains that
+// were degraded on previous days are restored, as their quotas have reset. It
+// returns the number of projects that were republished for each.
+func enforceQuotas(db *gorm.DB, now time.Time) (degraded, restored int, err error) {
+	today := now.UTC().Truncate(24 * time.Hour)
+
+	evs, err := quotaevent.FindUnenforced(db, today)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	degraded, err = republish(db, evs, quotaevent.MarkEnforced)
+	if err != nil {
+		return degraded, 0, err
+	}
+
+	evs, err = quotaevent.FindLiftable(db, today)
+	if err != nil {
+		return degraded, 0, err
+	}
+
+	restored, err = republish(db, evs, quotaevent.MarkLifted)
+	return degraded, restored, err
+}
+
+// republish enqueues a deploy job that republishes the meta.json of the
+// active deployment of each project of the events, and marks the events with
+// mark in the same transaction. The deployer works out which domains are
+// degraded. It returns the number of projects that were republished.
+func republish(db *gorm.DB, evs []*quotaevent.QuotaEvent, mark func(*gorm.DB, []*quotaevent.QuotaEvent) error) (int, error) {
+	var projectIDs []uint
+	byProject := map[uint][]*quotaevent.QuotaEvent{}
+	for _, ev := range evs {
+		if _, ok := byProject[ev.ProjectID]; !ok {
+			projectIDs = append(projectIDs, ev.ProjectID)
+		}
+		byProject[ev.ProjectID] = append(byProject[ev.ProjectID], ev)
+	}
+
+	n := 0
+	for _, id := range projectIDs {
+		ok, err := republishProject(db, id, byProject[id], mark)
+		if err != nil {
+			return n, err
+		}
+		if ok {
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// republishProject republishes the meta.json of a project and marks its
+// events. Events of projects that have been deleted or have nothing deployed
+// are marked without republishing anything, and it returns false.
+func republishProject(db *gorm.DB, projectID uint, evs []*quotaevent.QuotaEvent, mark func(*gorm.DB, []*quotaevent.QuotaEvent) error) (bool, error) {
+	proj := &project.Project{}
+	if err := db.First(proj, projectID).Error; err != nil && err != gorm.RecordNotFound {
+		return false, err
+	}
+
+	tx := db.Begin()
+	if err := tx.Error; err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var ob *outboxjob.OutboxJob
+	if proj.ID != 0 && proj.ActiveDeploymentID != nil {
+		j, err := job.NewWithJSON(queues.Deploy, &messages.DeployJobData{
+			DeploymentID:      *proj.ActiveDeploymentID,
+			SkipWebrootUpload: true,
+			SkipInvalidation:  false, // edges cache meta.json, so it has to be invalidated
+		})
+		if err != nil {
+			return false, err
+		}
+
+		ob, err = outboxjob.Add(tx, j)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if err := mark(tx, evs); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return false, err
+	}
+
+	if ob == nil {
+		return false, nil
+	}
+
+	outboxjob.DeliverAll(db, ob)
+
+	for _, ev := range evs {
+		log.WithFields(fields).Infof("Republished meta.json of domain %q of project %q, which went over its bandwidth quota on %s",
+			ev.DomainName, proj.Name, ev.Date.Format("2006-01-02"))
+	}
+	return true, nil
+}
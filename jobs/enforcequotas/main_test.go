@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/quotaevent"
+	"github.com/nitrous-io/rise-server/pkg/mqconn"
+	"github.com/nitrous-io/rise-server/shared/queues"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "enforcequotas")
+}
+
+var _ = Describe("enforcequotas", func() {
+	var (
+		err error
+
+		db *gorm.DB
+		mq mqconn.Conn
+
+		proj  *project.Project
+		depl  *deployment.Deployment
+		now   time.Time
+		today time.Time
+	)
+
+	createEvent := func(proj *project.Project, domainName string, date time.Time) *quotaevent.QuotaEvent {
+		ev := &quotaevent.QuotaEvent{
+			ProjectID:  proj.ID,
+			DomainName: domainName,
+			Date:       date,
+			Metric:     quotaevent.MetricBandwidth,
+			Level:      quotaevent.LevelHard,
+			Quota:      100,
+			Usage:      120,
+		}
+		Expect(db.Create(ev).Error).To(BeNil())
+		return ev
+	}
+
+	deployJobBody := func(deploymentID uint) string {
+		return fmt.Sprintf(`{
+			"deployment_id": %d,
+			"skip_webroot_upload": true,
+			"skip_invalidation": false,
+			"use_raw_bundle": false
+		}`, deploymentID)
+	}
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+
+		mq, err = mqconn.MQ()
+		Expect(err).To(BeNil())
+
+		testhelper.TruncateTables(db.DB())
+		testhelper.DeleteQueue(mq, queues.All...)
+
+		u := factories.User(db)
+		proj = factories.Project(db, u)
+
+		depl = factories.Deployment(db, proj, u, deployment.StateDeployed)
+		Expect(db.Model(proj).Updates(map[string]interface{}{
+			"active_deployment_id":    depl.ID,
+			"bandwidth_exceeded_mode": project.BandwidthExceededDegrade,
+		}).Error).To(BeNil())
+
+		now = time.Date(2016, 6, 2, 10, 0, 0, 0, time.UTC)
+		today = time.Date(2016, 6, 2, 0, 0, 0, 0, time.UTC)
+	})
+
+	Describe("enforceQuotas()", func() {
+		It("republishes the meta.json of projects whose domains went over their bandwidth quota today", func() {
+			ev1 := createEvent(proj, proj.DefaultDomainName(), today)
+			ev2 := createEvent(proj, "www.foo-bar.com", today)
+
+			degraded, restored, err := enforceQuotas(db, now)
+			Expect(err).To(BeNil())
+			Expect(degraded).To(Equal(1))
+			Expect(restored).To(Equal(0))
+
+			d := testhelper.ConsumeQueue(mq, queues.Deploy)
+			Expect(d).NotTo(BeNil())
+			Expect(d.Body).To(MatchJSON(deployJobBody(depl.ID)))
+			Expect(testhelper.ConsumeQueue(mq, queues.Deploy)).To(BeNil())
+
+			for _, ev := range []*quotaevent.QuotaEvent{ev1, ev2} {
+				Expect(db.First(ev, ev.ID).Error).To(BeNil())
+				Expect(ev.EnforcedAt).NotTo(BeNil())
+				Expect(ev.LiftedAt).To(BeNil())
+			}
+		})
+
+		It("does not republish domains that have already been degraded", func() {
+			createEvent(proj, "www.foo-bar.com", today)
+
+			_, _, err := enforceQuotas(db, now)
+			Expect(err).To(BeNil())
+			Expect(testhelper.ConsumeQueue(mq, queues.Deploy)).NotTo(BeNil())
+
+			degraded, _, err := enforceQuotas(db, now)
+			Expect(err).To(BeNil())
+			Expect(degraded).To(Equal(0))
+			Expect(testhelper.ConsumeQueue(mq, queues.Deploy)).To(BeNil())
+		})
+
+		It("republishes the meta.json of projects whose domains were degraded on previous days", func() {
+			ev := createEvent(proj, "www.foo-bar.com", today.Add(-24*time.Hour))
+			Expect(quotaevent.MarkEnforced(db, []*quotaevent.QuotaEvent{ev})).To(Succeed())
+
+			degraded, restored, err := enforceQuotas(db, now)
+			Expect(err).To(BeNil())
+			Expect(degraded).To(Equal(0))
+			Expect(restored).To(Equal(1))
+
+			d := testhelper.ConsumeQueue(mq, queues.Deploy)
+			Expect(d).NotTo(BeNil())
+			Expect(d.Body).To(MatchJSON(deployJobBody(depl.ID)))
+
+			Expect(db.First(ev, ev.ID).Error).To(BeNil())
+			Expect(ev.LiftedAt).NotTo(BeNil())
+		})
+
+		It("ignores projects that block domains over their bandwidth quota", func() {
+			Expect(db.Model(proj).Update("bandwidth_exceeded_mode", project.BandwidthExceededBlock).Error).To(BeNil())
+			ev := createEvent(proj, "www.foo-bar.com", today)
+
+			degraded, _, err := enforceQuotas(db, now)
+			Expect(err).To(BeNil())
+			Expect(degraded).To(Equal(0))
+			Expect(testhelper.ConsumeQueue(mq, queues.Deploy)).To(BeNil())
+
+			Expect(db.First(ev, ev.ID).Error).To(BeNil())
+			Expect(ev.EnforcedAt).To(BeNil())
+		})
+
+		Context("when the project has nothing deployed", func() {
+			BeforeEach(func() {
+				Expect(db.Model(proj).Update("active_deployment_id", nil).Error).To(BeNil())
+			})
+
+			It("marks the events as enforced without republishing anything", func() {
+				ev := createEvent(proj, "www.foo-bar.com", today)
+
+				degraded, _, err := enforceQuotas(db, now)
+				Expect(err).To(BeNil())
+				Expect(degraded).To(Equal(0))
+				Expect(testhelper.ConsumeQueue(mq, queues.Deploy)).To(BeNil())
+
+				Expect(db.First(ev, ev.ID).Error).To(BeNil())
+				Expect(ev.EnforcedAt).NotTo(BeNil())
+			})
+		})
+	})
+})
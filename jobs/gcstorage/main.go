@@ -0,0 +1,251 @@
+package main
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/cert"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/domain"
+	"github.com/nitrous-io/rise-server/apiserver/models/rawbundle"
+	"github.com/nitrous-io/rise-server/pkg/filetransfer"
+	"github.com/nitrous-io/rise-server/shared/s3client"
+)
+
+const jobName = "gc-storage"
+
+var fields = log.Fields{"job": jobName}
+
+// deleteBatchSize is the number of objects deleted in a single request, which
+// S3 limits to 1000.
+const deleteBatchSize = 1000
+
+var (
+	S3 filetransfer.FileTransfer = filetransfer.NewS3(s3client.PartSize, s3client.MaxUploadParts)
+
+	// MinAge is how old an object has to be before it can be deleted. Newer
+	// objects may belong to a deployment or a domain whose transaction has
+	// not been committed yet. It can be set with GC_MIN_AGE.
+	MinAge = 7 * 24 * time.Hour
+
+	// DryRun reports orphaned objects without deleting them. It can be set
+	// with DRY_RUN=true.
+	DryRun = false
+)
+
+func init() {
+	riseEnv := os.Getenv("RISE_ENV")
+	if riseEnv == "" {
+		riseEnv = "development"
+		os.Setenv("RISE_ENV", riseEnv)
+	}
+
+	if riseEnv != "test" {
+		if os.Getenv("AWS_ACCESS_KEY_ID") == "" || os.Getenv("AWS_SECRET_ACCESS_KEY") == "" {
+			log.Fatal("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables are required!")
+		}
+	}
+
+	if s := os.Getenv("GC_MIN_AGE"); s != "" {
+		if d, err := time.ParseDuration(s); err == nil {
+			MinAge = d
+		}
+	}
+	DryRun = os.Getenv("DRY_RUN") == "true"
+}
+
+// usage is the number and total size of objects.
+type usage struct {
+	Objects int
+	Bytes   int64
+}
+
+func main() {
+	if u, err := user.Current(); err == nil {
+		fields["user"] = u.Username
+	}
+	log.WithFields(fields).WithField("event", "start").
+		Infof("Deleting orphaned objects from S3 (dry run: %t)...", DryRun)
+
+	db, err := dbconn.DB()
+	if err != nil {
+		log.WithFields(fields).Fatalf("failed to initialize db, err: %v", err)
+	}
+
+	keep, err := referencedKeys(db)
+	if err != nil {
+		log.WithFields(fields).Fatalf("failed to find objects referenced by the db, err: %v", err)
+	}
+
+	cutoff := time.Now().Add(-MinAge)
+
+	var total usage
+	for _, root := range []struct {
+		prefix   string
+		orphaned func(name string) (bool, error)
+	}{
+		{"deployments/", deploymentOrphaned(db)},
+		{"certs/", certOrphaned(db)},
+	} {
+		u, err := collect(root.prefix, root.orphaned, keep, cutoff)
+		if err != nil {
+			log.WithFields(fields).Fatalf("failed to delete orphaned objects under %q, err: %v", root.prefix, err)
+		}
+
+		log.WithFields(fields).Infof("Reclaimed %d bytes from %d orphaned objects under %q", u.Bytes, u.Objects, root.prefix)
+		total.Objects += u.Objects
+		total.Bytes += u.Bytes
+	}
+
+	log.WithFields(fields).WithField("event", "completed").
+		WithField("objects", total.Objects).WithField("bytes", total.Bytes).
+		Infof("Reclaimed %d bytes from %d orphaned objects", total.Bytes, total.Objects)
+}
+
+// referencedKeys returns the keys of the objects that rows in the db point to,
+// which are never deleted even if they are stored under an orphaned
+// directory, e.g. the raw bundle of a deleted deployment that is redeployed
+// by a later one.
+func referencedKeys(db *gorm.DB) (map[string]bool, error) {
+	keep := map[string]bool{}
+
+	var buns []*rawbundle.RawBundle
+	if err := db.Find(&buns).Error; err != nil {
+		return nil, err
+	}
+	for _, bun := range buns {
+		keep[bun.UploadedPath] = true
+	}
+
+	var certs []*cert.Cert
+	if err := db.Find(&certs).Error; err != nil {
+		return nil, err
+	}
+	for _, ct := range certs {
+		keep[ct.CertificatePath] = true
+		keep[ct.PrivateKeyPath] = true
+	}
+
+	return keep, nil
+}
+
+// collect deletes the objects under the directories of prefix that orphaned
+// returns true for, except for objects that are referenced or newer than
+// cutoff, and returns how much was reclaimed. Objects are listed in the order
+// of their keys, so the objects of a directory are listed one after another.
+func collect(prefix string, orphaned func(name string) (bool, error), keep map[string]bool, cutoff time.Time) (*usage, error) {
+	u := &usage{}
+
+	var (
+		dir  string
+		objs []*filetransfer.ObjectInfo
+	)
+
+	flush := func() error {
+		defer func() { objs = nil }()
+		if len(objs) == 0 {
+			return nil
+		}
+
+		ok, err := orphaned(dir)
+		if err != nil || !ok {
+			return err
+		}
+
+		var keys []string
+		var bytes int64
+		for _, obj := range objs {
+			if keep[obj.Key] || !obj.LastModified.Before(cutoff) {
+				continue
+			}
+			keys = append(keys, obj.Key)
+			bytes += obj.Size
+		}
+		if len(keys) == 0 {
+			return nil
+		}
+
+		log.WithFields(fields).Infof("Deleting %d orphaned objects (%d bytes) under %q", len(keys), bytes, prefix+dir+"/")
+		if !DryRun {
+			for i := 0; i < len(keys); i += deleteBatchSize {
+				end := i + deleteBatchSize
+				if end > len(keys) {
+					end = len(keys)
+				}
+				if err := S3.Delete(s3client.BucketRegion, s3client.BucketName, keys[i:end]...); err != nil {
+					return err
+				}
+			}
+		}
+
+		u.Objects += len(keys)
+		u.Bytes += bytes
+		return nil
+	}
+
+	if err := S3.List(s3client.BucketRegion, s3client.BucketName, prefix, func(obj *filetransfer.ObjectInfo) error {
+		name := strings.SplitN(strings.TrimPrefix(obj.Key, prefix), "/", 2)[0]
+		if name != dir {
+			if err := flush(); err != nil {
+				return err
+			}
+			dir = name
+		}
+		objs = append(objs, obj)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// deploymentOrphaned returns whether the files under deployments/<name> are
+// orphaned, where name is the prefix ID of a deployment. Files of deleted
+// deployments that were deployed are left to purgedeploys.
+func deploymentOrphaned(db *gorm.DB) func(name string) (bool, error) {
+	return func(name string) (bool, error) {
+		i := strings.LastIndex(name, "-")
+		if i < 0 {
+			return false, nil
+		}
+		id, err := strconv.ParseUint(name[i+1:], 10, 64)
+		if err != nil {
+			return false, nil
+		}
+
+		depl := &deployment.Deployment{}
+		if err := db.Unscoped().First(depl, id).Error; err != nil {
+			if err == gorm.RecordNotFound {
+				return true, nil
+			}
+			return false, err
+		}
+
+		if depl.Prefix != name[:i] || depl.PurgedAt != nil {
+			return true, nil
+		}
+		return depl.DeletedAt != nil && depl.State != deployment.StateDeployed, nil
+	}
+}
+
+// certOrphaned returns whether the files under certs/<name> are orphaned,
+// where name is a domain name.
+func certOrphaned(db *gorm.DB) func(name string) (bool, error) {
+	return func(name string) (bool, error) {
+		var count int
+		if err := db.Model(domain.Domain{}).Where("name = ?", name).Count(&count).Error; err != nil {
+			return false, err
+		}
+		return count == 0, nil
+	}
+}
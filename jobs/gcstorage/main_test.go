@@ -0,0 +1,154 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/pkg/filetransfer"
+	"github.com/nitrous-io/rise-server/shared/s3client"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+	"github.com/nitrous-io/rise-server/testhelper/fake"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "gcstorage")
+}
+
+var _ = Describe("gcstorage", func() {
+	var (
+		fakeS3 *fake.MemoryS3
+		origS3 filetransfer.FileTransfer
+		err    error
+
+		db *gorm.DB
+
+		u      *user.User
+		proj   *project.Project
+		cutoff time.Time
+	)
+
+	put := func(key string, content string, age time.Duration) {
+		fakeS3.Put(s3client.BucketName, key, []byte(content))
+		fakeS3.Get(s3client.BucketName, key).LastModified = time.Now().Add(-age)
+	}
+
+	BeforeEach(func() {
+		origS3 = S3
+		fakeS3 = fake.NewMemoryS3()
+		S3 = fakeS3
+
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+
+		u = factories.User(db)
+		proj = factories.Project(db, u)
+		cutoff = time.Now().Add(-24 * time.Hour)
+	})
+
+	AfterEach(func() {
+		S3 = origS3
+		DryRun = false
+	})
+
+	Describe("collect() under deployments/", func() {
+		var live, failed, deployed, purged *deployment.Deployment
+
+		BeforeEach(func() {
+			live = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{Prefix: "a1", State: deployment.StateDeployed})
+
+			// Deleted deployments that were never deployed are never purged.
+			failed = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{Prefix: "b2", State: deployment.StateBuildFailed})
+			Expect(db.Delete(failed).Error).To(BeNil())
+
+			// Deleted deployments that were deployed are purged by purgedeploys.
+			deployed = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{Prefix: "c3", State: deployment.StateDeployed})
+			Expect(db.Delete(deployed).Error).To(BeNil())
+
+			purged = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{Prefix: "d4", State: deployment.StateDeployed})
+			Expect(db.Delete(purged).Error).To(BeNil())
+			Expect(db.Model(purged).Unscoped().UpdateColumn("purged_at", time.Now()).Error).To(BeNil())
+
+			put("deployments/"+live.PrefixID()+"/webroot/index.html", "live", 48*time.Hour)
+			put("deployments/"+failed.PrefixID()+"/raw-bundle.tar.gz", "failed", 48*time.Hour)
+			put("deployments/"+deployed.PrefixID()+"/webroot/index.html", "deployed", 48*time.Hour)
+			put("deployments/"+purged.PrefixID()+"/webroot/index.html", "purged!", 48*time.Hour)
+			put("deployments/ffff-999999/webroot/index.html", "no row", 48*time.Hour)
+			put("deployments/ffff-999999/webroot/new.html", "new", time.Hour)
+		})
+
+		It("deletes old objects of deployments that are gone and reports the reclaimed bytes", func() {
+			reclaimed, err := collect("deployments/", deploymentOrphaned(db), map[string]bool{}, cutoff)
+			Expect(err).To(BeNil())
+			Expect(reclaimed).To(Equal(&usage{Objects: 3, Bytes: int64(len("failed") + len("purged!") + len("no row"))}))
+
+			Expect(fakeS3.Keys(s3client.BucketName)).To(ConsistOf(
+				"deployments/"+live.PrefixID()+"/webroot/index.html",
+				"deployments/"+deployed.PrefixID()+"/webroot/index.html",
+				"deployments/ffff-999999/webroot/new.html",
+			))
+		})
+
+		It("keeps objects that are referenced", func() {
+			keep := map[string]bool{"deployments/" + failed.PrefixID() + "/raw-bundle.tar.gz": true}
+
+			reclaimed, err := collect("deployments/", deploymentOrphaned(db), keep, cutoff)
+			Expect(err).To(BeNil())
+			Expect(reclaimed.Objects).To(Equal(2))
+
+			Expect(fakeS3.Content(s3client.BucketName, "deployments/"+failed.PrefixID()+"/raw-bundle.tar.gz")).NotTo(BeNil())
+		})
+
+		It("only reports orphaned objects in a dry run", func() {
+			DryRun = true
+
+			reclaimed, err := collect("deployments/", deploymentOrphaned(db), map[string]bool{}, cutoff)
+			Expect(err).To(BeNil())
+			Expect(reclaimed.Objects).To(Equal(3))
+
+			Expect(fakeS3.Keys(s3client.BucketName)).To(HaveLen(6))
+			Expect(fakeS3.DeleteCalls.Count()).To(Equal(0))
+		})
+	})
+
+	Describe("collect() under certs/", func() {
+		BeforeEach(func() {
+			factories.Domain(db, proj, "www.foo-bar-express.com")
+
+			put("certs/www.foo-bar-express.com/ssl.crt", "crt", 48*time.Hour)
+			put("certs/www.foo-bar-express.com/ssl.key", "key", 48*time.Hour)
+			put("certs/www.gone.com/ssl.crt", "crt", 48*time.Hour)
+			put("certs/www.gone.com/ssl.key", "key", 48*time.Hour)
+		})
+
+		It("deletes the certs of domains that are gone", func() {
+			reclaimed, err := collect("certs/", certOrphaned(db), map[string]bool{}, cutoff)
+			Expect(err).To(BeNil())
+			Expect(reclaimed).To(Equal(&usage{Objects: 2, Bytes: 6}))
+
+			Expect(fakeS3.Keys(s3client.BucketName)).To(Equal([]string{
+				"certs/www.foo-bar-express.com/ssl.crt",
+				"certs/www.foo-bar-express.com/ssl.key",
+			}))
+		})
+	})
+
+	Describe("referencedKeys()", func() {
+		It("returns the paths of raw bundles and certs", func() {
+			bun := factories.RawBundle(db, proj)
+
+			keep, err := referencedKeys(db)
+			Expect(err).To(BeNil())
+			Expect(keep).To(HaveKey(bun.UploadedPath))
+		})
+	})
+})
@@ -87,7 +87,7 @@ func findSoftDeletedDeployments(db *gorm.DB) ([]*deployment.Deployment, error) {
 	err := db.Unscoped().
 		Where("deleted_at IS NOT NULL").
 		Where("purged_at IS NULL").
-		Where("state = ?", deployment.StateDeployed).
+		Where("state IN (?)", []string{deployment.StateDeployed, deployment.StateBuildFailed, deployment.StateDeployFailed}).
 		Find(&depls).Error
 	if err != nil {
 		return nil, err
@@ -109,7 +109,12 @@ func purger(db *gorm.DB, wg *sync.WaitGroup, jobs chan *deployment.Deployment) {
 }
 
 func purge(db *gorm.DB, depl *deployment.Deployment) error {
-	prefix := "deployments/" + depl.PrefixID()
+	// The trailing slash keeps deployments whose prefix ID starts with this
+	// one's (e.g. "abc-12" for "abc-1") from being deleted along with it.
+	prefix := "deployments/" + depl.PrefixID() + "/"
+
+	// If only some files could be deleted, purged_at is not set so that the
+	// rest are deleted the next time this runs.
 	if err := S3.DeleteAll(s3client.BucketRegion, s3client.BucketName, prefix); err != nil {
 		return err
 	}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"testing"
 	"time"
 
@@ -100,6 +101,23 @@ var _ = Describe("purgedeploys", func() {
 			}
 			Expect(ids).To(ConsistOf(depl2.ID, depl4.ID))
 		})
+
+		It("returns soft-deleted deployments that failed", func() {
+			failed := factories.DeploymentWithAttrs(db, proj1, u, deployment.Deployment{
+				Prefix: "p1-d",
+				State:  deployment.StateBuildFailed,
+			})
+			Expect(db.Delete(failed).Error).To(BeNil())
+
+			depls, err := findSoftDeletedDeployments(db)
+			Expect(err).To(BeNil())
+
+			ids := []uint{}
+			for _, depl := range depls {
+				ids = append(ids, depl.ID)
+			}
+			Expect(ids).To(ConsistOf(depl2.ID, depl4.ID, failed.ID))
+		})
 	})
 
 	Describe("purge()", func() {
@@ -112,7 +130,7 @@ var _ = Describe("purgedeploys", func() {
 			Expect(deleteCall).NotTo(BeNil())
 			Expect(deleteCall.Arguments[0]).To(Equal(s3client.BucketRegion))
 			Expect(deleteCall.Arguments[1]).To(Equal(s3client.BucketName))
-			Expect(deleteCall.Arguments[2]).To(Equal("deployments/" + depl2.PrefixID()))
+			Expect(deleteCall.Arguments[2]).To(Equal("deployments/" + depl2.PrefixID() + "/"))
 			Expect(deleteCall.ReturnValues[0]).To(BeNil())
 		})
 
@@ -128,6 +146,24 @@ var _ = Describe("purgedeploys", func() {
 			Expect(depl2.PurgedAt).NotTo(BeNil())
 		})
 
+		Context("when some of the deployment's files could not be deleted", func() {
+			BeforeEach(func() {
+				fakeS3.DeleteAllError = &filetransfer.DeleteError{
+					Keys: []string{"deployments/" + depl2.PrefixID() + "/webroot/index.html"},
+					Err:  errors.New("InternalError: We encountered an internal error. Please try again."),
+				}
+			})
+
+			It("returns the error and does not set purged_at", func() {
+				err := purge(db, depl2)
+				Expect(err).To(Equal(fakeS3.DeleteAllError))
+
+				err = db.Unscoped().First(depl2, depl2.ID).Error
+				Expect(err).To(BeNil())
+				Expect(depl2.PurgedAt).To(BeNil())
+			})
+		})
+
 		It("deletes the deployment's raw bundle", func() {
 			bun := factories.RawBundle(db, proj1)
 
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/pkg/aesencrypter"
+)
+
+func init() {
+	riseEnv := os.Getenv("RISE_ENV")
+	if riseEnv == "" {
+		riseEnv = "development"
+		os.Setenv("RISE_ENV", riseEnv)
+	}
+}
+
+const jobName = "rotate-aes-key"
+
+var (
+	fields = log.Fields{"job": jobName}
+
+	// BatchSize is the number of rows that are re-encrypted at a time.
+	BatchSize = 500
+)
+
+// encryptedColumns are the columns, by table, that hold data encrypted with
+// common.AesKeyring().
+var encryptedColumns = []struct {
+	table   string
+	columns []string
+}{
+	{"acme_certs", []string{"letsencrypt_key", "private_key", "cert", "tls_alpn_challenge_cert"}},
+	{"deployments", []string{"encrypted_js_env_vars"}},
+	{"log_destinations", []string{"encrypted_secret_access_key"}},
+	{"api_keys", []string{"encrypted_secret"}},
+	{"sso_connections", []string{"encrypted_client_secret"}},
+}
+
+func main() {
+	if u, err := user.Current(); err == nil {
+		fields["user"] = u.Username
+	}
+
+	keyring := common.AesKeyring()
+
+	log.WithFields(fields).WithField("event", "start").
+		Infof("Re-encrypting data with AES key ID %d...", keyring.CurrentID())
+
+	db, err := dbconn.DB()
+	if err != nil {
+		log.WithFields(fields).Fatalf("failed to initialize db, err: %v", err)
+	}
+
+	total := 0
+	for _, t := range encryptedColumns {
+		for _, column := range t.columns {
+			n, err := reencryptColumn(db, keyring, t.table, column)
+			if err != nil {
+				log.WithFields(fields).Fatalf("failed to re-encrypt %s.%s, err: %v", t.table, column, err)
+			}
+
+			log.WithFields(fields).Infof("Re-encrypted %s.%s of %d rows", t.table, column, n)
+			total += n
+		}
+	}
+
+	log.WithFields(fields).WithField("event", "completed").
+		Infof("Re-encrypted %d values with AES key ID %d", total, keyring.CurrentID())
+}
+
+type encryptedValue struct {
+	ID    uint
+	Value string
+}
+
+// reencryptColumn re-encrypts the values of a column that are not encrypted
+// with the current key of the keyring, including those of soft-deleted rows.
+// It returns the number of values re-encrypted.
+func reencryptColumn(db *gorm.DB, keyring *aesencrypter.Keyring, table, column string) (int, error) {
+	// Values encrypted with the legacy key are not prefixed with a key ID.
+	pending := fmt.Sprintf("%s NOT LIKE '%%:%%'", column)
+	if id := keyring.CurrentID(); id != aesencrypter.LegacyKeyID {
+		pending = fmt.Sprintf("%s NOT LIKE '%d:%%'", column, id)
+	}
+
+	q := fmt.Sprintf(`SELECT id, %s FROM %s
+		WHERE %s IS NOT NULL AND %s <> '' AND %s
+		ORDER BY id ASC LIMIT ?`, column, table, column, column, pending)
+	update := fmt.Sprintf("UPDATE %s SET %s = ? WHERE id = ?", table, column)
+
+	n := 0
+	for {
+		var rows []encryptedValue
+
+		r, err := db.Raw(q, BatchSize).Rows()
+		if err != nil {
+			return n, err
+		}
+		for r.Next() {
+			var row encryptedValue
+			if err := r.Scan(&row.ID, &row.Value); err != nil {
+				r.Close()
+				return n, err
+			}
+			rows = append(rows, row)
+		}
+		if err := r.Err(); err != nil {
+			r.Close()
+			return n, err
+		}
+		r.Close()
+
+		if len(rows) == 0 {
+			return n, nil
+		}
+
+		for _, row := range rows {
+			reencrypted, _, err := keyring.Reencrypt(row.Value)
+			if err != nil {
+				return n, fmt.Errorf("failed to re-encrypt row ID %d, err: %v", row.ID, err)
+			}
+
+			if err := db.Exec(update, reencrypted, row.ID).Error; err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/acmecert"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/pkg/aesencrypter"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "rotateaeskey")
+}
+
+var _ = Describe("rotateaeskey", func() {
+	const legacyKey = "something-something-something-32"
+
+	var (
+		err error
+
+		db *gorm.DB
+
+		origBatchSize int
+
+		oldKeyring *aesencrypter.Keyring
+		newKeyring *aesencrypter.Keyring
+
+		crt  *acmecert.AcmeCert
+		depl *deployment.Deployment
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+
+		origBatchSize = BatchSize
+		BatchSize = 1
+
+		oldKeyring = aesencrypter.NewKeyring(legacyKey, nil)
+		newKeyring = aesencrypter.NewKeyring(legacyKey, map[int]string{
+			1: "something-else-something-else-32",
+		})
+
+		u := factories.User(db)
+		proj := factories.Project(db, u)
+		dm := factories.Domain(db, proj)
+
+		crt, err = acmecert.New(dm.ID, oldKeyring)
+		Expect(err).To(BeNil())
+		Expect(db.Create(crt).Error).To(BeNil())
+
+		d := &deployment.Deployment{}
+		Expect(d.SetJsEnvVars(map[string]string{"foo": "bar"}, oldKeyring)).To(Succeed())
+		depl = factories.DeploymentWithAttrs(db, proj, u, deployment.Deployment{
+			EncryptedJsEnvVars: d.EncryptedJsEnvVars,
+		})
+	})
+
+	AfterEach(func() {
+		BatchSize = origBatchSize
+	})
+
+	Describe("reencryptColumn()", func() {
+		It("re-encrypts values with the current key", func() {
+			n, err := reencryptColumn(db, newKeyring, "acme_certs", "letsencrypt_key")
+			Expect(err).To(BeNil())
+			Expect(n).To(Equal(1))
+
+			n, err = reencryptColumn(db, newKeyring, "deployments", "encrypted_js_env_vars")
+			Expect(err).To(BeNil())
+			Expect(n).To(Equal(1))
+
+			c := &acmecert.AcmeCert{}
+			Expect(db.First(c, crt.ID).Error).To(BeNil())
+			Expect(strings.HasPrefix(c.LetsencryptKey, "1:")).To(BeTrue())
+
+			key, err := c.DecryptedLetsencryptKey(newKeyring)
+			Expect(err).To(BeNil())
+
+			origKey, err := crt.DecryptedLetsencryptKey(oldKeyring)
+			Expect(err).To(BeNil())
+			Expect(key).To(Equal(origKey))
+
+			// Columns that were not re-encrypted are still readable.
+			_, err = c.DecryptedPrivateKey(newKeyring)
+			Expect(err).To(BeNil())
+
+			d := &deployment.Deployment{}
+			Expect(db.First(d, depl.ID).Error).To(BeNil())
+			Expect(strings.HasPrefix(*d.EncryptedJsEnvVars, "1:")).To(BeTrue())
+
+			vars, err := d.DecryptedJsEnvVars(newKeyring)
+			Expect(err).To(BeNil())
+			Expect(vars).To(Equal(map[string]string{"foo": "bar"}))
+		})
+
+		It("does nothing when all values are encrypted with the current key", func() {
+			_, err := reencryptColumn(db, newKeyring, "acme_certs", "private_key")
+			Expect(err).To(BeNil())
+
+			n, err := reencryptColumn(db, newKeyring, "acme_certs", "private_key")
+			Expect(err).To(BeNil())
+			Expect(n).To(Equal(0))
+		})
+
+		It("skips empty values", func() {
+			n, err := reencryptColumn(db, newKeyring, "acme_certs", "cert")
+			Expect(err).To(BeNil())
+			Expect(n).To(Equal(0))
+		})
+	})
+})
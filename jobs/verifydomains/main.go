@@ -0,0 +1,188 @@
+package main
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/domain"
+	"github.com/nitrous-io/rise-server/apiserver/models/jobrecord"
+	"github.com/nitrous-io/rise-server/apiserver/models/outboxjob"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	ruser "github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/pkg/job"
+	"github.com/nitrous-io/rise-server/shared/emails"
+	"github.com/nitrous-io/rise-server/shared/messages"
+	"github.com/nitrous-io/rise-server/shared/queues"
+)
+
+func init() {
+	riseEnv := os.Getenv("RISE_ENV")
+	if riseEnv == "" {
+		riseEnv = "development"
+		os.Setenv("RISE_ENV", riseEnv)
+	}
+}
+
+const jobName = "verify-domains"
+
+var fields = log.Fields{"job": jobName}
+
+func main() {
+	if u, err := user.Current(); err == nil {
+		fields["user"] = u.Username
+	}
+	log.WithFields(fields).WithField("event", "start").
+		Infof("Verifying DNS records of pending and active domains...")
+
+	db, err := dbconn.DB()
+	if err != nil {
+		log.WithFields(fields).Fatalf("failed to initialize db, err: %v", err)
+	}
+	job.DefaultRecorder = &jobrecord.Recorder{DB: db}
+
+	checked, activated, err := verifyPendingDomains(db)
+	if err != nil {
+		log.WithFields(fields).Fatalf("failed to verify pending domains, err: %v", err)
+	}
+
+	log.WithFields(fields).Infof("Checked %d pending domains, activated: %d", checked, activated)
+
+	checked, disabled, enabled, err := reverifyDomains(db)
+	if err != nil {
+		log.WithFields(fields).Fatalf("failed to re-verify domains, err: %v", err)
+	}
+
+	log.WithFields(fields).WithField("event", "completed").
+		Infof("Re-verified %d domains, disabled: %d, enabled: %d", checked, disabled, enabled)
+}
+
+// verifyPendingDomains checks the DNS records of all domains that are pending
+// verification, and activates those that point to their project. It returns
+// the number of domains checked and activated.
+func verifyPendingDomains(db *gorm.DB) (checked, activated int, err error) {
+	var doms []*domain.Domain
+	if err := db.Where("state = ?", domain.StatePendingVerification).Order("id ASC").Find(&doms).Error; err != nil {
+		return 0, 0, err
+	}
+
+	for _, dom := range doms {
+		proj := &project.Project{}
+		if err := db.First(proj, dom.ProjectID).Error; err != nil {
+			if err == gorm.RecordNotFound {
+				continue
+			}
+			return checked, activated, err
+		}
+
+		checked++
+		if !dom.PointsTo(proj.DefaultDomainName()) {
+			if err := db.Model(domain.Domain{}).Where("id = ?", dom.ID).Update("dns_checked_at", time.Now()).Error; err != nil {
+				return checked, activated, err
+			}
+			continue
+		}
+
+		ok, err := activate(db, dom, proj)
+		if err != nil {
+			return checked, activated, err
+		}
+		if ok {
+			activated++
+			log.WithFields(fields).Infof("Activated domain %q of project %q", dom.Name, proj.Name)
+		}
+	}
+
+	return checked, activated, nil
+}
+
+// activate marks a pending or disabled domain as active and enqueues a deploy
+// job to upload its meta.json and invalidate it on the edges, then notifies
+// the project owner. It returns false if the domain was no longer pending or
+// disabled.
+func activate(db *gorm.DB, dom *domain.Domain, proj *project.Project) (bool, error) {
+	tx := db.Begin()
+	if err := tx.Error; err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if dom.State == domain.StateDisabled {
+		ok, err := dom.Enable(tx)
+		if err != nil || !ok {
+			return false, err
+		}
+	} else {
+		q := tx.Model(domain.Domain{}).Where("id = ? AND state = ?", dom.ID, domain.StatePendingVerification).Updates(map[string]interface{}{
+			"state":          domain.StateActive,
+			"dns_checked_at": time.Now(),
+		})
+		if err := q.Error; err != nil {
+			return false, err
+		}
+		if q.RowsAffected == 0 {
+			return false, nil
+		}
+	}
+
+	var ob *outboxjob.OutboxJob
+	if proj.ActiveDeploymentID != nil {
+		j, err := job.NewWithJSON(queues.Deploy, &messages.DeployJobData{
+			DeploymentID:      *proj.ActiveDeploymentID,
+			SkipWebrootUpload: true,
+		})
+		if err != nil {
+			return false, err
+		}
+
+		ob, err = outboxjob.Add(tx, j)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return false, err
+	}
+
+	if ob != nil {
+		outboxjob.DeliverAll(db, ob)
+	}
+
+	dom.State = domain.StateActive
+
+	u := &ruser.User{}
+	if err := db.First(u, proj.UserID).Error; err != nil {
+		log.WithFields(fields).Errorf("failed to find owner of project %d, err: %v", proj.ID, err)
+		return true, nil
+	}
+
+	if err := common.SendTemplatedMail([]string{u.Email}, emails.DomainActive, &emails.DomainActiveData{
+		ProjectName: proj.Name,
+		DomainName:  dom.Name,
+	}); err != nil {
+		log.WithFields(fields).Errorf("failed to send domain active email for domain %q, err: %v", dom.Name, err)
+	}
+
+	{
+		var (
+			event = "Verified Custom Domain"
+			props = map[string]interface{}{
+				"projectName": proj.Name,
+				"domain":      dom.Name,
+			}
+			context map[string]interface{}
+		)
+		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
+			log.WithFields(fields).Errorf("failed to track %q event for user ID %d, err: %v",
+				event, u.ID, err)
+		}
+	}
+
+	return true, nil
+}
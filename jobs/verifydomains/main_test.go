@@ -0,0 +1,289 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/domain"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	ruser "github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/pkg/filetransfer"
+	"github.com/nitrous-io/rise-server/pkg/mailer"
+	"github.com/nitrous-io/rise-server/pkg/mqconn"
+	"github.com/nitrous-io/rise-server/pkg/tracker"
+	"github.com/nitrous-io/rise-server/shared"
+	"github.com/nitrous-io/rise-server/shared/queues"
+	"github.com/nitrous-io/rise-server/shared/s3client"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+	"github.com/nitrous-io/rise-server/testhelper/fake"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "verifydomains")
+}
+
+var _ = Describe("verifydomains", func() {
+	var (
+		err error
+
+		db *gorm.DB
+		mq mqconn.Conn
+
+		fakeMailer  *fake.Mailer
+		origMailer  mailer.Mailer
+		fakeTracker *fake.Tracker
+		origTracker tracker.Trackable
+
+		origLookupCNAME func(string) (string, error)
+		origLookupHost  func(string) ([]string, error)
+
+		u          *ruser.User
+		proj       *project.Project
+		dom1, dom2 *domain.Domain
+	)
+
+	BeforeEach(func() {
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+
+		mq, err = mqconn.MQ()
+		Expect(err).To(BeNil())
+
+		testhelper.TruncateTables(db.DB())
+		testhelper.DeleteQueue(mq, queues.All...)
+
+		origMailer = common.Mailer
+		fakeMailer = &fake.Mailer{}
+		common.Mailer = fakeMailer
+
+		origTracker = common.Tracker
+		fakeTracker = &fake.Tracker{}
+		common.Tracker = fakeTracker
+
+		u = factories.User(db)
+		proj = factories.Project(db, u)
+
+		depl := factories.Deployment(db, proj, u, deployment.StateDeployed)
+		Expect(db.Model(proj).Update("active_deployment_id", depl.ID).Error).To(BeNil())
+
+		dom1 = &domain.Domain{ProjectID: proj.ID, Name: "www.foo-bar.com", State: domain.StatePendingVerification}
+		Expect(db.Create(dom1).Error).To(BeNil())
+		dom2 = &domain.Domain{ProjectID: proj.ID, Name: "www.baz-qux.com", State: domain.StatePendingVerification}
+		Expect(db.Create(dom2).Error).To(BeNil())
+
+		// Only www.foo-bar.com points to the project.
+		origLookupCNAME = domain.LookupCNAME
+		domain.LookupCNAME = func(name string) (string, error) {
+			if name == "www.foo-bar.com" {
+				return proj.DefaultDomainName() + ".", nil
+			}
+			return name + ".", nil
+		}
+		origLookupHost = domain.LookupHost
+		domain.LookupHost = func(name string) ([]string, error) {
+			if name == proj.DefaultDomainName() {
+				return []string{"203.0.113.1"}, nil
+			}
+			return []string{"198.51.100.1"}, nil
+		}
+	})
+
+	AfterEach(func() {
+		common.Mailer = origMailer
+		common.Tracker = origTracker
+		domain.LookupCNAME = origLookupCNAME
+		domain.LookupHost = origLookupHost
+	})
+
+	Describe("verifyPendingDomains()", func() {
+		It("activates domains that point to their project", func() {
+			checked, activated, err := verifyPendingDomains(db)
+			Expect(err).To(BeNil())
+			Expect(checked).To(Equal(2))
+			Expect(activated).To(Equal(1))
+
+			Expect(db.First(dom1, dom1.ID).Error).To(BeNil())
+			Expect(dom1.State).To(Equal(domain.StateActive))
+			Expect(dom1.DNSCheckedAt).NotTo(BeNil())
+
+			Expect(db.First(dom2, dom2.ID).Error).To(BeNil())
+			Expect(dom2.State).To(Equal(domain.StatePendingVerification))
+			Expect(dom2.DNSCheckedAt).NotTo(BeNil())
+		})
+
+		It("enqueues a deploy job to upload meta.json and invalidate the domain", func() {
+			_, _, err := verifyPendingDomains(db)
+			Expect(err).To(BeNil())
+
+			d := testhelper.ConsumeQueue(mq, queues.Deploy)
+			Expect(d).NotTo(BeNil())
+			Expect(d.Body).To(MatchJSON(fmt.Sprintf(`{
+				"deployment_id": %d,
+				"skip_webroot_upload": true,
+				"skip_invalidation": false,
+				"use_raw_bundle": false
+			}`, *proj.ActiveDeploymentID)))
+
+			Expect(testhelper.ConsumeQueue(mq, queues.Deploy)).To(BeNil())
+		})
+
+		It("notifies the project owner", func() {
+			_, _, err := verifyPendingDomains(db)
+			Expect(err).To(BeNil())
+
+			Expect(fakeMailer.SendMailCalled).To(BeTrue())
+			Expect(fakeMailer.Tos).To(Equal([]string{u.Email}))
+			Expect(fakeMailer.Subject).To(Equal("www.foo-bar.com is now live on PubStorm"))
+
+			trackCall := fakeTracker.TrackCalls.NthCall(1)
+			Expect(trackCall).NotTo(BeNil())
+			Expect(trackCall.Arguments[0]).To(Equal(fmt.Sprintf("%d", u.ID)))
+			Expect(trackCall.Arguments[1]).To(Equal("Verified Custom Domain"))
+		})
+
+		Context("when the project has no active deployment", func() {
+			BeforeEach(func() {
+				Expect(db.Model(proj).Update("active_deployment_id", nil).Error).To(BeNil())
+			})
+
+			It("activates the domain without enqueuing a deploy job", func() {
+				_, activated, err := verifyPendingDomains(db)
+				Expect(err).To(BeNil())
+				Expect(activated).To(Equal(1))
+
+				Expect(testhelper.ConsumeQueue(mq, queues.Deploy)).To(BeNil())
+			})
+		})
+	})
+
+	Describe("reverifyDomains()", func() {
+		var (
+			fakeS3 *fake.S3
+			origS3 filetransfer.FileTransfer
+		)
+
+		BeforeEach(func() {
+			origS3 = s3client.S3
+			fakeS3 = &fake.S3{}
+			s3client.S3 = fakeS3
+
+			// www.baz-qux.com has not pointed to the project for longer than
+			// the number of days domains are disabled after.
+			failingSince := time.Now().Add(-time.Duration(shared.DomainDisableDays+1) * 24 * time.Hour)
+			checkedAt := time.Now().Add(-2 * ReverifyInterval)
+			Expect(db.Model(dom1).Updates(map[string]interface{}{
+				"state":          domain.StateActive,
+				"dns_checked_at": checkedAt,
+			}).Error).To(BeNil())
+			Expect(db.Model(dom2).Updates(map[string]interface{}{
+				"state":             domain.StateActive,
+				"dns_checked_at":    checkedAt,
+				"dns_failing_since": failingSince,
+			}).Error).To(BeNil())
+		})
+
+		AfterEach(func() {
+			s3client.S3 = origS3
+		})
+
+		It("disables domains that have not pointed to their project for long enough", func() {
+			checked, disabled, enabled, err := reverifyDomains(db)
+			Expect(err).To(BeNil())
+			Expect(checked).To(Equal(2))
+			Expect(disabled).To(Equal(1))
+			Expect(enabled).To(Equal(0))
+
+			Expect(db.First(dom1, dom1.ID).Error).To(BeNil())
+			Expect(dom1.State).To(Equal(domain.StateActive))
+			Expect(dom1.DNSFailingSince).To(BeNil())
+			Expect(*dom1.DNSCheckedAt).To(BeTemporally("~", time.Now(), time.Minute))
+
+			Expect(db.First(dom2, dom2.ID).Error).To(BeNil())
+			Expect(dom2.State).To(Equal(domain.StateDisabled))
+			Expect(*dom2.DisabledReason).To(Equal(domain.DisabledReasonDNS))
+		})
+
+		It("deletes the meta.json of disabled domains and notifies the project owner", func() {
+			_, _, _, err := reverifyDomains(db)
+			Expect(err).To(BeNil())
+
+			Expect(fakeS3.DeleteCalls.Count()).To(Equal(1))
+			deleteCall := fakeS3.DeleteCalls.NthCall(1)
+			Expect(deleteCall.Arguments[2]).To(Equal("domains/www.baz-qux.com/meta.json"))
+
+			Expect(fakeMailer.SendMailCalled).To(BeTrue())
+			Expect(fakeMailer.Tos).To(Equal([]string{u.Email}))
+			Expect(fakeMailer.Subject).To(Equal("www.baz-qux.com is no longer being served by PubStorm"))
+
+			trackCall := fakeTracker.TrackCalls.NthCall(1)
+			Expect(trackCall).NotTo(BeNil())
+			Expect(trackCall.Arguments[1]).To(Equal("Disabled Custom Domain"))
+		})
+
+		It("records when active domains started failing", func() {
+			Expect(db.Model(dom2).Update("dns_failing_since", nil).Error).To(BeNil())
+
+			_, disabled, _, err := reverifyDomains(db)
+			Expect(err).To(BeNil())
+			Expect(disabled).To(Equal(0))
+
+			Expect(db.First(dom2, dom2.ID).Error).To(BeNil())
+			Expect(dom2.State).To(Equal(domain.StateActive))
+			Expect(dom2.DNSFailingSince).NotTo(BeNil())
+			Expect(*dom2.DNSFailingSince).To(BeTemporally("~", time.Now(), time.Minute))
+			Expect(fakeS3.DeleteCalls.Count()).To(Equal(0))
+		})
+
+		It("skips domains that were checked recently", func() {
+			Expect(db.Model(domain.Domain{}).Where("id IN (?)", []uint{dom1.ID, dom2.ID}).Update("dns_checked_at", time.Now()).Error).To(BeNil())
+
+			checked, _, _, err := reverifyDomains(db)
+			Expect(err).To(BeNil())
+			Expect(checked).To(Equal(0))
+		})
+
+		Context("when a domain disabled because of its DNS records points to its project again", func() {
+			BeforeEach(func() {
+				Expect(db.Model(dom1).Updates(map[string]interface{}{
+					"state":           domain.StateDisabled,
+					"disabled_at":     time.Now().Add(-time.Hour),
+					"disabled_reason": domain.DisabledReasonDNS,
+				}).Error).To(BeNil())
+			})
+
+			It("enables the domain and enqueues a deploy job", func() {
+				_, _, enabled, err := reverifyDomains(db)
+				Expect(err).To(BeNil())
+				Expect(enabled).To(Equal(1))
+
+				Expect(db.First(dom1, dom1.ID).Error).To(BeNil())
+				Expect(dom1.State).To(Equal(domain.StateActive))
+				Expect(dom1.DisabledAt).To(BeNil())
+				Expect(dom1.DisabledReason).To(BeNil())
+
+				d := testhelper.ConsumeQueue(mq, queues.Deploy)
+				Expect(d).NotTo(BeNil())
+			})
+
+			It("does not enable domains that were disabled by an admin", func() {
+				Expect(db.Model(dom1).Update("disabled_reason", domain.DisabledReasonAdmin).Error).To(BeNil())
+
+				_, _, enabled, err := reverifyDomains(db)
+				Expect(err).To(BeNil())
+				Expect(enabled).To(Equal(0))
+
+				Expect(db.First(dom1, dom1.ID).Error).To(BeNil())
+				Expect(dom1.State).To(Equal(domain.StateDisabled))
+			})
+		})
+	})
+})
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/models/domain"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	ruser "github.com/nitrous-io/rise-server/apiserver/models/user"
+	"github.com/nitrous-io/rise-server/shared"
+	"github.com/nitrous-io/rise-server/shared/emails"
+	"github.com/nitrous-io/rise-server/shared/invalidation"
+	"github.com/nitrous-io/rise-server/shared/s3client"
+)
+
+// ReverifyInterval is how often the DNS records of active domains are checked
+// again, to find domains that no longer point to PubStorm, e.g. because they
+// have changed hands.
+const ReverifyInterval = 24 * time.Hour
+
+// reverifyDomains checks the DNS records of the domains that are due for
+// re-verification. Active domains that have not pointed to their project for
+// shared.DomainDisableDays are disabled, and domains that were disabled
+// because of their DNS records are activated again once they point to their
+// project again. It returns the number of domains checked, disabled and
+// enabled.
+func reverifyDomains(db *gorm.DB) (checked, disabled, enabled int, err error) {
+	doms, err := domain.FindDueForReverification(db, time.Now().Add(-ReverifyInterval))
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	disableAfter := time.Duration(shared.DomainDisableDays) * 24 * time.Hour
+
+	for _, dom := range doms {
+		proj := &project.Project{}
+		if err := db.First(proj, dom.ProjectID).Error; err != nil {
+			if err == gorm.RecordNotFound {
+				continue
+			}
+			return checked, disabled, enabled, err
+		}
+
+		checked++
+		pointsTo := dom.PointsTo(proj.DefaultDomainName())
+
+		if dom.State == domain.StateDisabled && pointsTo {
+			ok, err := activate(db, dom, proj)
+			if err != nil {
+				return checked, disabled, enabled, err
+			}
+			if ok {
+				enabled++
+				log.WithFields(fields).Infof("Enabled domain %q of project %q", dom.Name, proj.Name)
+			}
+			continue
+		}
+
+		if err := dom.RecordDNSCheck(db, pointsTo); err != nil {
+			return checked, disabled, enabled, err
+		}
+
+		if dom.State != domain.StateActive || pointsTo || disableAfter <= 0 || dom.FailingFor(time.Now()) < disableAfter {
+			continue
+		}
+
+		ok, err := disable(db, dom, proj)
+		if err != nil {
+			return checked, disabled, enabled, err
+		}
+		if ok {
+			disabled++
+			log.WithFields(fields).Infof("Disabled domain %q of project %q, DNS records have not pointed to it since %s",
+				dom.Name, proj.Name, dom.DNSFailingSince.Format(time.RFC3339))
+		}
+	}
+
+	return checked, disabled, enabled, nil
+}
+
+// disable stops serving a domain whose DNS records no longer point to its
+// project by deleting its meta.json, marks it as disabled, then notifies the
+// project owner. It returns false if the domain was already disabled.
+func disable(db *gorm.DB, dom *domain.Domain, proj *project.Project) (bool, error) {
+	// The meta.json is deleted first, so that a domain is never left being
+	// served after it has been marked as disabled.
+	if err := s3client.Delete("domains/" + dom.Name + "/meta.json"); err != nil {
+		return false, err
+	}
+
+	if err := invalidation.Invalidate([]string{dom.Name}); err != nil {
+		return false, err
+	}
+
+	ok, err := dom.Disable(db, domain.DisabledReasonDNS)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	u := &ruser.User{}
+	if err := db.First(u, proj.UserID).Error; err != nil {
+		log.WithFields(fields).Errorf("failed to find owner of project %d, err: %v", proj.ID, err)
+		return true, nil
+	}
+
+	if err := common.SendTemplatedMail([]string{u.Email}, emails.DomainDisabled, &emails.DomainDisabledData{
+		ProjectName: proj.Name,
+		DomainName:  dom.Name,
+		Days:        shared.DomainDisableDays,
+	}); err != nil {
+		log.WithFields(fields).Errorf("failed to send domain disabled email for domain %q, err: %v", dom.Name, err)
+	}
+
+	{
+		var (
+			event = "Disabled Custom Domain"
+			props = map[string]interface{}{
+				"projectName": proj.Name,
+				"domain":      dom.Name,
+				"reason":      domain.DisabledReasonDNS,
+			}
+			context map[string]interface{}
+		)
+		if err := common.Track(strconv.Itoa(int(u.ID)), event, "", props, context); err != nil {
+			log.WithFields(fields).Errorf("failed to track %q event for user ID %d, err: %v",
+				event, u.ID, err)
+		}
+	}
+
+	return true, nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/nitrous-io/rise-server/logd/logd"
+	"github.com/nitrous-io/rise-server/pkg/job"
+	"github.com/nitrous-io/rise-server/pkg/mqconn"
+	"github.com/nitrous-io/rise-server/shared/queues"
+	"github.com/streadway/amqp"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// delivery is a message consumed from one of the queues, and the function that
+// processes messages of that queue.
+type delivery struct {
+	amqp.Delivery
+	queueName string
+	work      func([]byte) error
+}
+
+func main() {
+	run()
+	os.Exit(1)
+}
+
+func run() {
+	mq, err := mqconn.MQ()
+	if err != nil {
+		log.Errorln("Failed to connect to mq:", err)
+		return
+	}
+	connErrCh := mq.NotifyClose(make(chan *amqp.Error))
+
+	ch, err := mq.Channel()
+	if err != nil {
+		log.Errorln("Failed to obtain channel:", err)
+		return
+	}
+
+	defer func() {
+		err = ch.Close()
+		if err != nil {
+			log.Errorln("Failed to close channel:", err)
+		}
+	}()
+
+	err = ch.Qos(
+		1,     // prefetch count
+		0,     // prefetch size
+		false, // global
+	)
+
+	if err != nil {
+		log.Errorln("Failed to set qos to channel:", err)
+		return
+	}
+
+	workers := map[string]func([]byte) error{
+		queues.AccessLog:     logd.Work,
+		queues.EdgeConfigAck: logd.AcknowledgeConfig,
+	}
+
+	deliveries := make(chan delivery)
+	for queueName, work := range workers {
+		q, err := ch.QueueDeclare(
+			queueName,
+			true,  // durable
+			false, // delete when unused
+			false, // exclusive
+			false, // noWait
+			nil,
+		)
+		if err != nil {
+			log.Errorf("Failed to declare queue(%s): %v", queueName, err)
+			return
+		}
+
+		msgCh, err := ch.Consume(
+			q.Name, // queue
+			"",     // consumer
+			false,  // auto-ack
+			false,  // exclusive
+			false,  // no-local
+			false,  // no-wait
+			nil,    // args
+		)
+
+		if err != nil {
+			log.Errorf("Failed to start consuming message from queue(%s): %v", q.Name, err)
+			return
+		}
+
+		go func(queueName string, work func([]byte) error, msgCh <-chan amqp.Delivery) {
+			for d := range msgCh {
+				deliveries <- delivery{d, queueName, work}
+			}
+		}(q.Name, work, msgCh)
+
+		log.Infof("Worker started listening to queue(%s)...", q.Name)
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+
+	for {
+		select {
+		case del := <-deliveries:
+			d, queueName := del.Delivery, del.queueName
+			if err := job.Verify(d); err != nil {
+				// Drop messages that were not enqueued by us.
+				log.WithFields(log.Fields{"queue": queueName}).Errorf("Rejected message, err: %v, message: %s", err, d.Body)
+				if err := d.Ack(false); err != nil {
+					log.WithFields(log.Fields{"queue": queueName}).Warnln("Failed to Ack message:", err)
+				}
+				continue
+			}
+
+			err = del.work(d.Body)
+
+			if err != nil {
+				// failure
+				log.Warnln("Work failed", err, string(d.Body))
+
+				// It does not retry malformed payloads because they would never
+				// succeed.
+				if err == logd.ErrInvalidPayload {
+					if err := d.Ack(false); err != nil {
+						log.WithFields(log.Fields{"queue": queueName}).Warnln("Failed to Ack message:", err)
+					}
+				} else {
+					go func() {
+						// nack after a delay to prevent thrashing
+						time.Sleep(1 * time.Second)
+						if err := d.Nack(false, true); err != nil {
+							log.WithFields(log.Fields{"queue": queueName}).Warnln("Failed to Nack message:", err)
+						}
+					}()
+				}
+			} else {
+				// success
+				if err := d.Ack(false); err != nil {
+					log.WithFields(log.Fields{"queue": queueName}).Warnln("Failed to Ack message:", err)
+				}
+			}
+
+		case err := <-connErrCh:
+			log.Errorln(err)
+			return
+
+		case sig := <-sigCh:
+			log.Errorln("Caught signal:", sig)
+			return
+		}
+	}
+}
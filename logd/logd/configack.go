@@ -0,0 +1,25 @@
+package logd
+
+import (
+	"encoding/json"
+
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/edge"
+	"github.com/nitrous-io/rise-server/shared/messages"
+)
+
+// AcknowledgeConfig records the version of the edge settings that an edge
+// server has applied.
+func AcknowledgeConfig(data []byte) error {
+	d := &messages.EdgeConfigAckJobData{}
+	if err := json.Unmarshal(data, d); err != nil || d.Edge == "" {
+		return ErrInvalidPayload
+	}
+
+	db, err := dbconn.DB()
+	if err != nil {
+		return err
+	}
+
+	return edge.Acknowledge(db, d.Edge, d.Version)
+}
@@ -0,0 +1,249 @@
+package logd
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/accesslogfile"
+	"github.com/nitrous-io/rise-server/apiserver/models/dailystat"
+	"github.com/nitrous-io/rise-server/apiserver/models/domain"
+	"github.com/nitrous-io/rise-server/apiserver/models/logdestination"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/quotaevent"
+	"github.com/nitrous-io/rise-server/pkg/filetransfer"
+	"github.com/nitrous-io/rise-server/shared"
+	"github.com/nitrous-io/rise-server/shared/messages"
+	"github.com/nitrous-io/rise-server/shared/s3client"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+var (
+	S3 filetransfer.FileTransfer = filetransfer.NewS3(s3client.PartSize, s3client.MaxUploadParts)
+
+	ErrInvalidPayload = errors.New("payload is invalid")
+
+	// JobTimeout is how long processing a batch may take before its queries
+	// and uploads are abandoned, so that the batch can be retried.
+	JobTimeout = 2 * time.Minute
+)
+
+type statKey struct {
+	projectID  uint
+	domainName string
+	date       string
+}
+
+// Work aggregates a batch of access log entries by project, domain and day,
+// and adds the numbers to the daily stats of each project.
+func Work(data []byte) error {
+	d := &messages.AccessLogJobData{}
+	if err := json.Unmarshal(data, d); err != nil {
+		return ErrInvalidPayload
+	}
+
+	if len(d.Entries) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), JobTimeout)
+	defer cancel()
+
+	// Increment all stats in a transaction so that a failed batch can be
+	// retried without counting any entries twice.
+	tx, err := dbconn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	projectIDs := map[string]*uint{}
+	stats := map[statKey]*dailystat.DailyStat{}
+	entriesByProject := map[uint][]messages.AccessLogEntry{}
+
+	for _, entry := range d.Entries {
+		domainName := strings.ToLower(entry.Domain)
+
+		projectID, seen := projectIDs[domainName]
+		if !seen {
+			projectID, err = findProjectID(tx, domainName)
+			if err != nil {
+				return err
+			}
+			projectIDs[domainName] = projectID
+		}
+
+		if projectID == nil {
+			log.Debugf("ignoring access log entry for unknown domain %q", domainName)
+			continue
+		}
+
+		date := entry.Timestamp.UTC().Truncate(24 * time.Hour)
+		key := statKey{*projectID, domainName, date.Format(dailystat.DateFormat)}
+
+		s, ok := stats[key]
+		if !ok {
+			s = &dailystat.DailyStat{
+				ProjectID:  *projectID,
+				DomainName: domainName,
+				Date:       date,
+			}
+			stats[key] = s
+		}
+
+		s.Requests++
+		s.Bytes += entry.Bytes
+		s.AddStatus(entry.Status)
+
+		entriesByProject[*projectID] = append(entriesByProject[*projectID], entry)
+	}
+
+	// Keep raw entries of projects that have a log destination so that they
+	// can be delivered later.
+	logFiles, err := storeRawEntries(ctx, tx, data, entriesByProject)
+	if err != nil {
+		return err
+	}
+
+	// The numbers of this batch are kept, as Increment replaces them with
+	// the totals, so that crossed quotas can be told apart.
+	added := make(map[statKey]dailystat.DailyStat, len(stats))
+	for key, s := range stats {
+		added[key] = *s
+		if err := dailystat.Increment(tx, s); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range logFiles {
+		if err := tx.Create(f).Error; err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	// The batch must not be retried once it has been counted, so failing to
+	// alert on quotas is only logged.
+	if err := checkQuotas(stats, added); err != nil {
+		log.Errorf("failed to check quotas of domains, err: %v", err)
+	}
+
+	return nil
+}
+
+// checkQuotas emits quota events for the domains that crossed one of their
+// quotas with the numbers that were added to their daily stats.
+func checkQuotas(stats map[statKey]*dailystat.DailyStat, added map[statKey]dailystat.DailyStat) error {
+	db, err := dbconn.DB()
+	if err != nil {
+		return err
+	}
+
+	projects := map[uint]*project.Project{}
+	for key, s := range stats {
+		proj, ok := projects[s.ProjectID]
+		if !ok {
+			proj = &project.Project{}
+			if err := db.First(proj, s.ProjectID).Error; err != nil {
+				return err
+			}
+			projects[s.ProjectID] = proj
+		}
+
+		quotas, err := proj.DomainQuotas(db, s.DomainName)
+		if err != nil {
+			return err
+		}
+
+		a := added[key]
+		if err := quotaevent.Check(db, quotas, s, a.Requests, a.Bytes); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// findProjectID returns the ID of the project the given domain belongs to, or
+// nil if the domain is unknown.
+func findProjectID(db *gorm.DB, domainName string) (*uint, error) {
+	if strings.HasSuffix(domainName, "."+shared.DefaultDomain) {
+		projName := strings.TrimSuffix(domainName, "."+shared.DefaultDomain)
+
+		proj, err := project.FindByName(db, projName)
+		if err != nil {
+			return nil, err
+		}
+		if proj == nil || !proj.DefaultDomainEnabled {
+			return nil, nil
+		}
+		return &proj.ID, nil
+	}
+
+	dom := &domain.Domain{}
+	if err := db.Where("name = ?", domainName).First(dom).Error; err != nil {
+		if err == gorm.RecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &dom.ProjectID, nil
+}
+
+// storeRawEntries uploads the entries of each project that has a log
+// destination to S3 as a JSON lines file. The file path is derived from the
+// batch payload so that retries of the same batch overwrite the same file.
+func storeRawEntries(ctx context.Context, db *gorm.DB, data []byte, entriesByProject map[uint][]messages.AccessLogEntry) ([]*accesslogfile.AccessLogFile, error) {
+	if len(entriesByProject) == 0 {
+		return nil, nil
+	}
+
+	projectIDs := make([]uint, 0, len(entriesByProject))
+	for projectID := range entriesByProject {
+		projectIDs = append(projectIDs, projectID)
+	}
+
+	var dests []*logdestination.LogDestination
+	if err := db.Where("project_id IN (?)", projectIDs).Find(&dests).Error; err != nil {
+		return nil, err
+	}
+
+	batchID := fmt.Sprintf("%x", sha1.Sum(data))
+
+	var files []*accesslogfile.AccessLogFile
+	for _, dest := range dests {
+		buf := &bytes.Buffer{}
+		enc := json.NewEncoder(buf)
+		for _, entry := range entriesByProject[dest.ProjectID] {
+			if err := enc.Encode(entry); err != nil {
+				return nil, err
+			}
+		}
+
+		path := fmt.Sprintf("access-logs/%d/%s.log", dest.ProjectID, batchID)
+		if err := filetransfer.WithContext(ctx, func() error {
+			return S3.Upload(s3client.BucketRegion, s3client.BucketName, path, buf, "application/x-ndjson", "private")
+		}); err != nil {
+			return nil, err
+		}
+
+		files = append(files, &accesslogfile.AccessLogFile{
+			ProjectID: dest.ProjectID,
+			Path:      path,
+		})
+	}
+
+	return files, nil
+}
@@ -0,0 +1,208 @@
+package logd_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/common"
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/accesslogfile"
+	"github.com/nitrous-io/rise-server/apiserver/models/dailystat"
+	"github.com/nitrous-io/rise-server/apiserver/models/edge"
+	"github.com/nitrous-io/rise-server/apiserver/models/logdestination"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/quotaevent"
+	"github.com/nitrous-io/rise-server/logd/logd"
+	"github.com/nitrous-io/rise-server/pkg/filetransfer"
+	"github.com/nitrous-io/rise-server/pkg/tracker"
+	"github.com/nitrous-io/rise-server/shared"
+	"github.com/nitrous-io/rise-server/shared/s3client"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/factories"
+	"github.com/nitrous-io/rise-server/testhelper/fake"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "logd")
+}
+
+var _ = Describe("Logd", func() {
+	var (
+		fakeS3 *fake.S3
+		origS3 filetransfer.FileTransfer
+
+		db   *gorm.DB
+		err  error
+		proj *project.Project
+	)
+
+	BeforeEach(func() {
+		origS3 = logd.S3
+		fakeS3 = &fake.S3{}
+		logd.S3 = fakeS3
+
+		db, err = dbconn.DB()
+		Expect(err).To(BeNil())
+		testhelper.TruncateTables(db.DB())
+
+		proj = factories.Project(db, nil, "foo-bar-express")
+		factories.Domain(db, proj, "www.foo-bar-express.com")
+	})
+
+	AfterEach(func() {
+		logd.S3 = origS3
+	})
+
+	Describe("Work()", func() {
+		It("aggregates entries by project, domain and day", func() {
+			err := logd.Work([]byte(`{
+				"entries": [
+					{"domain": "www.foo-bar-express.com", "timestamp": "2016-06-01T10:00:00Z", "status": 200, "bytes": 100},
+					{"domain": "WWW.foo-bar-express.com", "timestamp": "2016-06-01T23:59:59Z", "status": 404, "bytes": 50},
+					{"domain": "www.foo-bar-express.com", "timestamp": "2016-06-02T00:00:00Z", "status": 304, "bytes": 0},
+					{"domain": "foo-bar-express.` + shared.DefaultDomain + `", "timestamp": "2016-06-01T12:00:00Z", "status": 500, "bytes": 10},
+					{"domain": "www.unknown.com", "timestamp": "2016-06-01T12:00:00Z", "status": 200, "bytes": 10}
+				]
+			}`))
+			Expect(err).To(BeNil())
+
+			var stats []*dailystat.DailyStat
+			Expect(db.Order("domain_name ASC, date ASC").Find(&stats).Error).To(BeNil())
+			Expect(stats).To(HaveLen(3))
+
+			Expect(stats[0].ProjectID).To(Equal(proj.ID))
+			Expect(stats[0].DomainName).To(Equal("foo-bar-express." + shared.DefaultDomain))
+			Expect(stats[0].Requests).To(Equal(int64(1)))
+			Expect(stats[0].Status5xx).To(Equal(int64(1)))
+
+			Expect(stats[1].DomainName).To(Equal("www.foo-bar-express.com"))
+			Expect(stats[1].Date.Format(dailystat.DateFormat)).To(Equal("2016-06-01"))
+			Expect(stats[1].Requests).To(Equal(int64(2)))
+			Expect(stats[1].Bytes).To(Equal(int64(150)))
+			Expect(stats[1].Status2xx).To(Equal(int64(1)))
+			Expect(stats[1].Status4xx).To(Equal(int64(1)))
+
+			Expect(stats[2].Date.Format(dailystat.DateFormat)).To(Equal("2016-06-02"))
+			Expect(stats[2].Requests).To(Equal(int64(1)))
+			Expect(stats[2].Status3xx).To(Equal(int64(1)))
+
+			Expect(fakeS3.UploadCalls.Count()).To(Equal(0))
+		})
+
+		Context("when the project has a log destination", func() {
+			BeforeEach(func() {
+				dest := &logdestination.LogDestination{
+					ProjectID:    proj.ID,
+					BucketName:   "my-access-logs",
+					BucketRegion: "us-west-2",
+				}
+				Expect(db.Create(dest).Error).To(BeNil())
+			})
+
+			It("stores raw entries of the project in S3 for delivery", func() {
+				err := logd.Work([]byte(`{
+					"entries": [
+						{"domain": "www.foo-bar-express.com", "timestamp": "2016-06-01T10:00:00Z", "status": 200, "bytes": 100},
+						{"domain": "www.unknown.com", "timestamp": "2016-06-01T12:00:00Z", "status": 200, "bytes": 10}
+					]
+				}`))
+				Expect(err).To(BeNil())
+
+				Expect(fakeS3.UploadCalls.Count()).To(Equal(1))
+				call := fakeS3.UploadCalls.NthCall(1)
+				Expect(call.Arguments[0]).To(Equal(s3client.BucketRegion))
+				Expect(call.Arguments[1]).To(Equal(s3client.BucketName))
+				Expect(call.Arguments[2]).To(HavePrefix(fmt.Sprintf("access-logs/%d/", proj.ID)))
+				Expect(string(call.SideEffects["uploaded_content"].([]byte))).To(MatchJSON(
+					`{"domain": "www.foo-bar-express.com", "timestamp": "2016-06-01T10:00:00Z", "status": 200, "bytes": 100}`,
+				))
+
+				files, err := accesslogfile.Undelivered(db, proj.ID)
+				Expect(err).To(BeNil())
+				Expect(files).To(HaveLen(1))
+				Expect(files[0].Path).To(Equal(call.Arguments[2]))
+			})
+		})
+
+		Context("when a domain crosses one of its quotas", func() {
+			var (
+				fakeTracker *fake.Tracker
+				origTracker tracker.Trackable
+			)
+
+			BeforeEach(func() {
+				origTracker = common.Tracker
+				fakeTracker = &fake.Tracker{}
+				common.Tracker = fakeTracker
+
+				Expect(db.Model(proj).Update("max_domain_requests", 3).Error).To(BeNil())
+				Expect(dailystat.Increment(db, &dailystat.DailyStat{
+					ProjectID:  proj.ID,
+					DomainName: "www.foo-bar-express.com",
+					Date:       time.Date(2016, 6, 1, 0, 0, 0, 0, time.UTC),
+					Requests:   1,
+				})).To(BeNil())
+			})
+
+			AfterEach(func() {
+				common.Tracker = origTracker
+			})
+
+			It("emits a quota event", func() {
+				err := logd.Work([]byte(`{
+					"entries": [
+						{"domain": "www.foo-bar-express.com", "timestamp": "2016-06-01T10:00:00Z", "status": 200, "bytes": 100},
+						{"domain": "www.foo-bar-express.com", "timestamp": "2016-06-01T10:00:01Z", "status": 200, "bytes": 100}
+					]
+				}`))
+				Expect(err).To(BeNil())
+
+				var events []*quotaevent.QuotaEvent
+				Expect(db.Order("id ASC").Find(&events).Error).To(BeNil())
+				Expect(events).To(HaveLen(2))
+
+				Expect(events[0].DomainName).To(Equal("www.foo-bar-express.com"))
+				Expect(events[0].Metric).To(Equal(quotaevent.MetricRequests))
+				Expect(events[0].Level).To(Equal(quotaevent.LevelSoft))
+				Expect(events[0].Quota).To(Equal(int64(2)))
+				Expect(events[0].Usage).To(Equal(int64(3)))
+
+				Expect(events[1].Level).To(Equal(quotaevent.LevelHard))
+				Expect(events[1].Quota).To(Equal(int64(3)))
+
+				Expect(fakeTracker.TrackCalls.Count()).To(Equal(2))
+			})
+		})
+
+		Context("when the payload is not valid JSON", func() {
+			It("returns ErrInvalidPayload", func() {
+				Expect(logd.Work([]byte(`{`))).To(Equal(logd.ErrInvalidPayload))
+			})
+		})
+	})
+
+	Describe("AcknowledgeConfig()", func() {
+		It("records the config version the edge runs", func() {
+			err := logd.AcknowledgeConfig([]byte(`{"edge": "edge-sg-1", "version": 3}`))
+			Expect(err).To(BeNil())
+
+			edges, err := edge.All(db)
+			Expect(err).To(BeNil())
+			Expect(edges).To(HaveLen(1))
+			Expect(edges[0].Name).To(Equal("edge-sg-1"))
+			Expect(edges[0].ConfigVersion).To(Equal(uint(3)))
+		})
+
+		Context("when the edge is missing", func() {
+			It("returns ErrInvalidPayload", func() {
+				Expect(logd.AcknowledgeConfig([]byte(`{"version": 3}`))).To(Equal(logd.ErrInvalidPayload))
+			})
+		})
+	})
+})
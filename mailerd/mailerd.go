@@ -0,0 +1,161 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/jobrecord"
+	"github.com/nitrous-io/rise-server/mailerd/mailerd"
+	"github.com/nitrous-io/rise-server/pkg/job"
+	"github.com/nitrous-io/rise-server/pkg/mqconn"
+	"github.com/nitrous-io/rise-server/shared/emails"
+	"github.com/nitrous-io/rise-server/shared/queues"
+	"github.com/streadway/amqp"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+func main() {
+	run()
+	os.Exit(1)
+}
+
+func run() {
+	m, err := emails.NewProviderFromEnv()
+	if err != nil {
+		log.Errorln("Failed to initialize mailer:", err)
+		return
+	}
+	mailerd.Mailer = m
+
+	// mailerd does not otherwise need the database, so jobs are worked on
+	// without being recorded if it is unavailable.
+	if db, err := dbconn.DB(); err != nil {
+		log.Warnln("Failed to connect to db, jobs will not be recorded:", err)
+	} else {
+		job.DefaultRecorder = &jobrecord.Recorder{DB: db}
+	}
+
+	mq, err := mqconn.MQ()
+	if err != nil {
+		log.Errorln("Failed to connect to mq:", err)
+		return
+	}
+	connErrCh := mq.NotifyClose(make(chan *amqp.Error))
+
+	ch, err := mq.Channel()
+	if err != nil {
+		log.Errorln("Failed to obtain channel:", err)
+		return
+	}
+
+	defer func() {
+		err = ch.Close()
+		if err != nil {
+			log.Errorln("Failed to close channel:", err)
+		}
+	}()
+
+	err = ch.Qos(
+		1,     // prefetch count
+		0,     // prefetch size
+		false, // global
+	)
+
+	if err != nil {
+		log.Errorln("Failed to set qos to channel:", err)
+		return
+	}
+
+	queueName := queues.Mail
+
+	q, err := ch.QueueDeclare(
+		queueName,
+		true,  // durable
+		false, // delete when unused
+		false, // exclusive
+		false, // noWait
+		nil,
+	)
+	if err != nil {
+		log.Errorf("Failed to declare queue(%s): %v", queueName, err)
+		return
+	}
+
+	msgCh, err := ch.Consume(
+		q.Name, // queue
+		"",     // consumer
+		false,  // auto-ack
+		false,  // exclusive
+		false,  // no-local
+		false,  // no-wait
+		nil,    // args
+	)
+
+	if err != nil {
+		log.Errorf("Failed to start consuming message from queue(%s): %v", q.Name, err)
+		return
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+
+	log.Infof("Worker started listening to queue(%s)...", q.Name)
+
+	for {
+		select {
+		case d := <-msgCh:
+			if err := job.Verify(d); err != nil {
+				// Drop messages that were not enqueued by us.
+				log.WithFields(log.Fields{"queue": queueName}).Errorf("Rejected message, err: %v, message: %s", err, d.Body)
+				if err := d.Ack(false); err != nil {
+					log.WithFields(log.Fields{"queue": queueName}).Warnln("Failed to Ack message:", err)
+				}
+				continue
+			}
+
+			job.Started(d)
+			err = mailerd.Work(d.Body)
+
+			if err != nil {
+				// failure
+				log.Warnln("Work failed", err, string(d.Body))
+
+				// Failed attempts to send mail are retried by mailerd.Work, so
+				// only failures to reschedule a mail are retried here.
+				if err == mailerd.ErrInvalidPayload || err == mailerd.ErrTooManyAttempts {
+					job.Finished(d, err, false)
+					if err := d.Ack(false); err != nil {
+						log.WithFields(log.Fields{"queue": queueName}).Warnln("Failed to Ack message:", err)
+					}
+				} else {
+					job.Finished(d, err, true)
+					go func() {
+						// nack after a delay to prevent thrashing
+						time.Sleep(1 * time.Second)
+						if err := d.Nack(false, true); err != nil {
+							log.WithFields(log.Fields{"queue": queueName}).Warnln("Failed to Nack message:", err)
+						}
+					}()
+				}
+			} else {
+				// success
+				job.Finished(d, nil, false)
+				if err := d.Ack(false); err != nil {
+					log.WithFields(log.Fields{"queue": queueName}).Warnln("Failed to Ack message:", err)
+				}
+			}
+
+		case err := <-connErrCh:
+			log.Errorln(err)
+			return
+
+		case sig := <-sigCh:
+			log.Errorln("Caught signal:", sig)
+			return
+		}
+	}
+}
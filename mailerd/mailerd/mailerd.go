@@ -0,0 +1,57 @@
+package mailerd
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/nitrous-io/rise-server/pkg/job"
+	"github.com/nitrous-io/rise-server/pkg/mailer"
+	"github.com/nitrous-io/rise-server/shared/messages"
+	"github.com/nitrous-io/rise-server/shared/queues"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+var (
+	// Mailer is the mail provider used to send mail.
+	Mailer mailer.Mailer
+
+	MaxAttempts = 5
+	RetryDelay  = 5 * time.Second // doubled after each failed attempt
+
+	ErrInvalidPayload  = errors.New("mail payload is invalid")
+	ErrTooManyAttempts = errors.New("failed to send mail too many times")
+)
+
+// Work sends a mail. If sending fails, the mail is enqueued again after a
+// delay, until it has been attempted MaxAttempts times.
+func Work(data []byte) error {
+	d := &messages.SendMailJobData{}
+	if err := json.Unmarshal(data, d); err != nil {
+		return ErrInvalidPayload
+	}
+
+	err := Mailer.SendMail(d.From, d.Tos, d.Ccs, d.Bccs, d.ReplyTo, d.Subject, d.Body, d.HTML)
+	if err == nil {
+		return nil
+	}
+
+	d.Attempts++
+	if d.Attempts >= MaxAttempts {
+		log.Errorf("giving up sending mail %q to %v after %d attempts, err: %v", d.Subject, d.Tos, d.Attempts, err)
+		return ErrTooManyAttempts
+	}
+
+	log.Warnf("failed to send mail %q to %v (attempt %d), err: %v", d.Subject, d.Tos, d.Attempts, err)
+
+	// wait before retrying to avoid hammering a provider that is down
+	time.Sleep(RetryDelay * time.Duration(1<<uint(d.Attempts-1)))
+
+	j, err := job.NewWithJSON(queues.Mail, d)
+	if err != nil {
+		return err
+	}
+
+	return j.Enqueue()
+}
@@ -0,0 +1,117 @@
+package mailerd_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nitrous-io/rise-server/mailerd/mailerd"
+	"github.com/nitrous-io/rise-server/pkg/mailer"
+	"github.com/nitrous-io/rise-server/pkg/mqconn"
+	"github.com/nitrous-io/rise-server/shared/queues"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/nitrous-io/rise-server/testhelper/fake"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "mailerd")
+}
+
+var _ = Describe("Mailerd", func() {
+	var (
+		mq  mqconn.Conn
+		err error
+
+		fakeMailer     *fake.Mailer
+		origMailer     mailer.Mailer
+		origRetryDelay time.Duration
+
+		payload []byte
+	)
+
+	BeforeEach(func() {
+		mq, err = mqconn.MQ()
+		Expect(err).To(BeNil())
+		testhelper.DeleteQueue(mq, queues.All...)
+
+		origMailer = mailerd.Mailer
+		fakeMailer = &fake.Mailer{}
+		mailerd.Mailer = fakeMailer
+
+		origRetryDelay = mailerd.RetryDelay
+		mailerd.RetryDelay = 0
+
+		payload = []byte(`{
+			"from": "PubStorm <support@pubstorm.com>",
+			"tos": ["foo@example.com"],
+			"reply_to": "support@pubstorm.com",
+			"subject": "Hello",
+			"body": "Hi there",
+			"html": "<p>Hi there</p>"
+		}`)
+	})
+
+	AfterEach(func() {
+		mailerd.Mailer = origMailer
+		mailerd.RetryDelay = origRetryDelay
+	})
+
+	Describe("Work()", func() {
+		It("sends the mail", func() {
+			Expect(mailerd.Work(payload)).To(BeNil())
+
+			Expect(fakeMailer.SendMailCalled).To(BeTrue())
+			Expect(fakeMailer.From).To(Equal("PubStorm <support@pubstorm.com>"))
+			Expect(fakeMailer.Tos).To(Equal([]string{"foo@example.com"}))
+			Expect(fakeMailer.ReplyTo).To(Equal("support@pubstorm.com"))
+			Expect(fakeMailer.Subject).To(Equal("Hello"))
+			Expect(fakeMailer.Body).To(Equal("Hi there"))
+			Expect(fakeMailer.HTML).To(Equal("<p>Hi there</p>"))
+
+			Expect(testhelper.ConsumeQueue(mq, queues.Mail)).To(BeNil())
+		})
+
+		Context("when sending fails", func() {
+			BeforeEach(func() {
+				fakeMailer.Error = errors.New("connection refused")
+			})
+
+			It("enqueues the mail again with the number of attempts", func() {
+				Expect(mailerd.Work(payload)).To(BeNil())
+
+				d := testhelper.ConsumeQueue(mq, queues.Mail)
+				Expect(d).NotTo(BeNil())
+				Expect(d.Body).To(MatchJSON(`{
+					"from": "PubStorm <support@pubstorm.com>",
+					"tos": ["foo@example.com"],
+					"reply_to": "support@pubstorm.com",
+					"subject": "Hello",
+					"body": "Hi there",
+					"html": "<p>Hi there</p>",
+					"attempts": 1
+				}`))
+			})
+
+			Context("when the mail has been attempted too many times", func() {
+				BeforeEach(func() {
+					payload = []byte(`{"tos": ["foo@example.com"], "subject": "Hello", "attempts": 4}`)
+				})
+
+				It("gives up", func() {
+					Expect(mailerd.Work(payload)).To(Equal(mailerd.ErrTooManyAttempts))
+					Expect(testhelper.ConsumeQueue(mq, queues.Mail)).To(BeNil())
+				})
+			})
+		})
+
+		Context("when the payload is not valid JSON", func() {
+			It("returns ErrInvalidPayload", func() {
+				Expect(mailerd.Work([]byte(`{`))).To(Equal(mailerd.ErrInvalidPayload))
+			})
+		})
+	})
+})
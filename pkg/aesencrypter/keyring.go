@@ -0,0 +1,148 @@
+package aesencrypter
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// LegacyKeyID is the ID of the key that data was encrypted with before keys
+// were versioned. Data encrypted with it is not prefixed with a key ID.
+const LegacyKeyID = 0
+
+var (
+	ErrUnknownKeyID = errors.New("data is encrypted with an unknown key")
+	ErrInvalidKeys  = errors.New(`keys should be in the format "1:key1,2:key2"`)
+)
+
+// Keyring holds versioned AES keys so that keys can be rotated. Data is
+// encrypted with the latest key, i.e. the key with the highest ID, and is
+// prefixed with the ID of the key (e.g. "2:<base64-encoded cipher text>") so
+// that it can be decrypted after newer keys are added.
+type Keyring struct {
+	keys      map[int][]byte
+	currentID int
+}
+
+// NewKeyring returns a Keyring with the legacy key and versioned keys by ID.
+// IDs of versioned keys must be greater than LegacyKeyID.
+func NewKeyring(legacyKey string, keys map[int]string) *Keyring {
+	k := &Keyring{keys: map[int][]byte{LegacyKeyID: []byte(legacyKey)}}
+	for id, key := range keys {
+		k.keys[id] = []byte(key)
+		if id > k.currentID {
+			k.currentID = id
+		}
+	}
+	return k
+}
+
+// ParseKeys parses versioned keys in the format "1:key1,2:key2".
+func ParseKeys(s string) (map[int]string, error) {
+	keys := map[int]string{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 {
+			return nil, ErrInvalidKeys
+		}
+
+		id, err := strconv.Atoi(parts[0])
+		if err != nil || id <= LegacyKeyID {
+			return nil, ErrInvalidKeys
+		}
+		if len(parts[1]) < KeyLength {
+			return nil, ErrKeyTooShort
+		}
+		keys[id] = parts[1]
+	}
+	return keys, nil
+}
+
+// CurrentID returns the ID of the key that data is encrypted with.
+func (k *Keyring) CurrentID() int {
+	return k.currentID
+}
+
+// EncryptToString encrypts data with the current key and returns it
+// base64-encoded and prefixed with the ID of the key.
+func (k *Keyring) EncryptToString(plainText []byte) (string, error) {
+	cipherText, err := Encrypt(plainText, k.keys[k.currentID])
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(cipherText)
+	if k.currentID == LegacyKeyID {
+		return encoded, nil
+	}
+	return fmt.Sprintf("%d:%s", k.currentID, encoded), nil
+}
+
+// DecryptString decrypts data returned by EncryptToString with the key it was
+// encrypted with.
+func (k *Keyring) DecryptString(s string) ([]byte, error) {
+	id, encoded, err := splitKeyID(s)
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, ErrUnknownKeyID
+	}
+
+	cipherText, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	return Decrypt(cipherText, key)
+}
+
+// Reencrypt re-encrypts data returned by EncryptToString with the current key
+// if it was encrypted with another key. It returns whether the data was
+// re-encrypted.
+func (k *Keyring) Reencrypt(s string) (string, bool, error) {
+	id, _, err := splitKeyID(s)
+	if err != nil {
+		return "", false, err
+	}
+	if id == k.currentID {
+		return s, false, nil
+	}
+
+	plainText, err := k.DecryptString(s)
+	if err != nil {
+		return "", false, err
+	}
+
+	reencrypted, err := k.EncryptToString(plainText)
+	if err != nil {
+		return "", false, err
+	}
+	return reencrypted, true, nil
+}
+
+// splitKeyID splits data returned by EncryptToString into the ID of the key
+// and the base64-encoded cipher text.
+func splitKeyID(s string) (int, string, error) {
+	// ":" is not in the base64 alphabet, so data without it was encrypted
+	// with the legacy key.
+	i := strings.Index(s, ":")
+	if i == -1 {
+		return LegacyKeyID, s, nil
+	}
+
+	id, err := strconv.Atoi(s[:i])
+	if err != nil {
+		return 0, "", ErrUnknownKeyID
+	}
+	return id, s[i+1:], nil
+}
@@ -0,0 +1,124 @@
+package aesencrypter_test
+
+import (
+	"strings"
+
+	"github.com/nitrous-io/rise-server/pkg/aesencrypter"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Keyring", func() {
+	const (
+		legacyKey = "supercalifragilisticexpi"
+		key1      = "something-something-something-32"
+		key2      = "something-else-something-else-32"
+	)
+
+	var data = []byte("super secret information")
+
+	Describe("ParseKeys()", func() {
+		It("parses versioned keys", func() {
+			keys, err := aesencrypter.ParseKeys(" 1:" + key1 + ", 2:" + key2)
+			Expect(err).To(BeNil())
+			Expect(keys).To(Equal(map[int]string{1: key1, 2: key2}))
+		})
+
+		It("returns an error if the keys are malformed", func() {
+			_, err := aesencrypter.ParseKeys(key1)
+			Expect(err).To(Equal(aesencrypter.ErrInvalidKeys))
+
+			_, err = aesencrypter.ParseKeys("0:" + key1)
+			Expect(err).To(Equal(aesencrypter.ErrInvalidKeys))
+
+			_, err = aesencrypter.ParseKeys("1:short")
+			Expect(err).To(Equal(aesencrypter.ErrKeyTooShort))
+		})
+	})
+
+	Context("when there are no versioned keys", func() {
+		It("encrypts with the legacy key without a key ID", func() {
+			k := aesencrypter.NewKeyring(legacyKey, nil)
+			Expect(k.CurrentID()).To(Equal(aesencrypter.LegacyKeyID))
+
+			encrypted, err := k.EncryptToString(data)
+			Expect(err).To(BeNil())
+			Expect(encrypted).NotTo(ContainSubstring(":"))
+
+			decrypted, err := k.DecryptString(encrypted)
+			Expect(err).To(BeNil())
+			Expect(decrypted).To(Equal(data))
+		})
+	})
+
+	Context("when there are versioned keys", func() {
+		var (
+			legacy, k1, k2 *aesencrypter.Keyring
+		)
+
+		BeforeEach(func() {
+			legacy = aesencrypter.NewKeyring(legacyKey, nil)
+			k1 = aesencrypter.NewKeyring(legacyKey, map[int]string{1: key1})
+			k2 = aesencrypter.NewKeyring(legacyKey, map[int]string{1: key1, 2: key2})
+		})
+
+		It("encrypts with the latest key prefixed with its ID", func() {
+			Expect(k2.CurrentID()).To(Equal(2))
+
+			encrypted, err := k2.EncryptToString(data)
+			Expect(err).To(BeNil())
+			Expect(strings.HasPrefix(encrypted, "2:")).To(BeTrue())
+
+			decrypted, err := k2.DecryptString(encrypted)
+			Expect(err).To(BeNil())
+			Expect(decrypted).To(Equal(data))
+		})
+
+		It("decrypts data encrypted with older keys", func() {
+			fromLegacy, err := legacy.EncryptToString(data)
+			Expect(err).To(BeNil())
+			from1, err := k1.EncryptToString(data)
+			Expect(err).To(BeNil())
+
+			for _, encrypted := range []string{fromLegacy, from1} {
+				decrypted, err := k2.DecryptString(encrypted)
+				Expect(err).To(BeNil())
+				Expect(decrypted).To(Equal(data))
+			}
+		})
+
+		It("returns an error if the key is unknown", func() {
+			encrypted, err := k2.EncryptToString(data)
+			Expect(err).To(BeNil())
+
+			_, err = k1.DecryptString(encrypted)
+			Expect(err).To(Equal(aesencrypter.ErrUnknownKeyID))
+		})
+
+		Describe("Reencrypt()", func() {
+			It("re-encrypts data encrypted with older keys with the latest key", func() {
+				from1, err := k1.EncryptToString(data)
+				Expect(err).To(BeNil())
+
+				reencrypted, changed, err := k2.Reencrypt(from1)
+				Expect(err).To(BeNil())
+				Expect(changed).To(BeTrue())
+				Expect(strings.HasPrefix(reencrypted, "2:")).To(BeTrue())
+
+				decrypted, err := k2.DecryptString(reencrypted)
+				Expect(err).To(BeNil())
+				Expect(decrypted).To(Equal(data))
+			})
+
+			It("does not re-encrypt data encrypted with the latest key", func() {
+				from2, err := k2.EncryptToString(data)
+				Expect(err).To(BeNil())
+
+				reencrypted, changed, err := k2.Reencrypt(from2)
+				Expect(err).To(BeNil())
+				Expect(changed).To(BeFalse())
+				Expect(reencrypted).To(Equal(from2))
+			})
+		})
+	})
+})
@@ -0,0 +1,34 @@
+package bitbucketapi
+
+type PushPayload struct {
+	Push struct {
+		Changes []struct {
+			New *struct {
+				Type   string `json:"type"`
+				Name   string `json:"name"`
+				Target struct {
+					Hash string `json:"hash"`
+				} `json:"target"`
+			} `json:"new"`
+		} `json:"changes"`
+	} `json:"push"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+	Actor struct {
+		Username string `json:"username"`
+	} `json:"actor"`
+}
+
+// Head returns the commit that the given branch was pushed to, or an empty
+// string if the push did not update the branch. A push can update several
+// branches and tags at once, and changes that delete a branch have no new
+// commit.
+func (p *PushPayload) Head(branch string) string {
+	for _, ch := range p.Push.Changes {
+		if ch.New != nil && ch.New.Type == "branch" && ch.New.Name == branch {
+			return ch.New.Target.Hash
+		}
+	}
+	return ""
+}
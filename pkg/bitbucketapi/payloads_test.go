@@ -0,0 +1,120 @@
+package bitbucketapi_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/nitrous-io/rise-server/pkg/bitbucketapi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "bitbucketapi")
+}
+
+var _ = Describe("bitbucketapi", func() {
+	Describe("PushPayload", func() {
+		var pl bitbucketapi.PushPayload
+
+		BeforeEach(func() {
+			pl = bitbucketapi.PushPayload{}
+			err := json.Unmarshal(sampleBitbucketPushPayload, &pl)
+			Expect(err).To(BeNil())
+		})
+
+		It("can be used to unmarshal a Bitbucket webhook payload", func() {
+			Expect(pl.Repository.FullName).To(Equal("chuyeow/pubstorm-www"))
+			Expect(pl.Actor.Username).To(Equal("chuyeow"))
+			Expect(pl.Push.Changes).To(HaveLen(3))
+		})
+
+		Describe("Head()", func() {
+			It("returns the commit that the branch was pushed to", func() {
+				Expect(pl.Head("master")).To(Equal("5e908dc1f01e9e5ae2ff1314666e366cbc7260dc"))
+			})
+
+			It("returns an empty string for a branch that was not pushed to", func() {
+				Expect(pl.Head("release")).To(Equal(""))
+			})
+
+			It("returns an empty string for a branch that was deleted", func() {
+				Expect(pl.Head("old-feature")).To(Equal(""))
+			})
+
+			It("ignores tags", func() {
+				Expect(pl.Head("v1.0.0")).To(Equal(""))
+			})
+		})
+	})
+})
+
+var sampleBitbucketPushPayload = []byte(`{
+  "push": {
+    "changes": [
+      {
+        "new": {
+          "type": "tag",
+          "name": "v1.0.0",
+          "target": {
+            "type": "commit",
+            "hash": "5e908dc1f01e9e5ae2ff1314666e366cbc7260dc"
+          }
+        },
+        "old": null,
+        "created": true,
+        "closed": false,
+        "forced": false
+      },
+      {
+        "new": {
+          "type": "branch",
+          "name": "master",
+          "target": {
+            "type": "commit",
+            "hash": "5e908dc1f01e9e5ae2ff1314666e366cbc7260dc",
+            "message": "Push test.\n"
+          }
+        },
+        "old": {
+          "type": "branch",
+          "name": "master",
+          "target": {
+            "type": "commit",
+            "hash": "a0fbcc76e4b2453c35261419208e2f72c98b010f"
+          }
+        },
+        "created": false,
+        "closed": false,
+        "forced": false
+      },
+      {
+        "new": null,
+        "old": {
+          "type": "branch",
+          "name": "old-feature",
+          "target": {
+            "type": "commit",
+            "hash": "a0fbcc76e4b2453c35261419208e2f72c98b010f"
+          }
+        },
+        "created": false,
+        "closed": true,
+        "forced": false
+      }
+    ]
+  },
+  "repository": {
+    "type": "repository",
+    "name": "pubstorm-www",
+    "full_name": "chuyeow/pubstorm-www",
+    "uuid": "{0f2c0c6b-4b8c-4a5b-8c6d-2b0d4b0d8c3a}",
+    "is_private": true
+  },
+  "actor": {
+    "type": "user",
+    "username": "chuyeow",
+    "display_name": "Cheah Chu Yeow"
+  }
+}`)
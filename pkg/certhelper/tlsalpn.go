@@ -0,0 +1,182 @@
+package certhelper
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ericchiang/letsencrypt"
+	"github.com/square/go-jose"
+)
+
+// ChallengeTLSALPN is the type of the TLS-ALPN-01 challenge defined in RFC
+// 8737. The letsencrypt package predates it and its Client.ChallengeReady
+// refuses challenges of this type, so use TLSALPNChallengeReady instead.
+const ChallengeTLSALPN = "tls-alpn-01"
+
+const jwsContentType = "application/jose+jws"
+
+var errNoNonce = errors.New("acme: no Replay-Nonce header in HTTP response")
+
+// TLSALPNChallengeReady informs the ACME server that a TLS-ALPN-01 challenge
+// is ready for verification, and then polls cli until the challenge is valid
+// or invalid the same way letsencrypt.Client.ChallengeReady does.
+//
+// The request is signed with accountKey and a nonce fetched from the
+// directory at directoryURL, using the transport t (or the default transport
+// if t is nil).
+func TLSALPNChallengeReady(cli *letsencrypt.Client, directoryURL string, t http.RoundTripper, accountKey *rsa.PrivateKey, keyAuth string, chal letsencrypt.Challenge) error {
+	if chal.Type != ChallengeTLSALPN {
+		return fmt.Errorf("unsupported challenge type '%s'", chal.Type)
+	}
+
+	hc := &http.Client{Transport: t}
+
+	nonce, err := fetchNonce(hc, directoryURL)
+	if err != nil {
+		return err
+	}
+
+	data := struct {
+		Resource string `json:"resource"`
+		KeyAuth  string `json:"keyAuthorization"`
+		Type     string `json:"type"`
+		Token    string `json:"token"`
+	}{"challenge", keyAuth, chal.Type, chal.Token}
+	sig, err := signJWS(accountKey, nonce, &data)
+	if err != nil {
+		return err
+	}
+
+	resp, err := hc.Post(chal.URI, jwsContentType, strings.NewReader(sig))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkHTTPError(resp, http.StatusAccepted); err != nil {
+		return err
+	}
+
+	return pollChallenge(cli, chal)
+}
+
+// fetchNonce returns the Replay-Nonce of a request to the ACME directory.
+func fetchNonce(hc *http.Client, directoryURL string) (string, error) {
+	resp, err := hc.Get(directoryURL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	nonce := resp.Header.Get("Replay-Nonce")
+	if nonce == "" {
+		return "", errNoNonce
+	}
+	return nonce, nil
+}
+
+type staticNonce string
+
+func (n staticNonce) Nonce() (string, error) {
+	return string(n), nil
+}
+
+// signJWS returns v signed with the account key and nonce as a JWS in the
+// full serialization, like the letsencrypt package does for its requests.
+func signJWS(accountKey *rsa.PrivateKey, nonce string, v interface{}) (string, error) {
+	// Let's Encrypt only supports RS256 for RSA keys.
+	if accountKey.N.BitLen() != 2048 {
+		return "", errors.New("acme: unsupported RSA key length")
+	}
+
+	signer, err := jose.NewSigner(jose.RS256, accountKey)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	signer.SetNonceSource(staticNonce(nonce))
+	sig, err := signer.Sign(data)
+	if err != nil {
+		return "", err
+	}
+	return sig.FullSerialize(), nil
+}
+
+// pollChallenge polls the ACME server until the challenge is no longer
+// pending, within cli's poll timeout.
+func pollChallenge(cli *letsencrypt.Client, chal letsencrypt.Challenge) error {
+	pollInterval := cli.PollInterval
+	if pollInterval == 0 {
+		pollInterval = 500 * time.Millisecond
+	}
+	pollTimeout := cli.PollTimeout
+	if pollTimeout == 0 {
+		pollTimeout = 30 * time.Second
+	}
+
+	start := time.Now()
+	for {
+		if time.Now().Sub(start) > pollTimeout {
+			if chal.Error != nil {
+				return chal.Error
+			}
+			return errors.New("polling pending challenge timed out")
+		}
+
+		var err error
+		chal, err = cli.Challenge(chal.URI)
+		if err != nil {
+			return err
+		}
+
+		switch chal.Status {
+		case letsencrypt.StatusPending, "":
+			time.Sleep(pollInterval)
+		case letsencrypt.StatusInvalid:
+			if chal.Error == nil {
+				return errors.New("challenge returned status 'invalid' without explicit error")
+			}
+			return chal.Error
+		case letsencrypt.StatusValid:
+			return nil
+		default:
+			return fmt.Errorf("unexpected challenge status '%s'", chal.Status)
+		}
+	}
+}
+
+// checkHTTPError returns an error unless resp has the expected status code,
+// decoding the ACME error in the body when there is one.
+func checkHTTPError(resp *http.Response, expCode int) error {
+	if resp.StatusCode == expCode {
+		return nil
+	}
+	if resp.StatusCode < 400 || resp.StatusCode >= 600 {
+		return fmt.Errorf("acme: expected Status %d %s, got %s", expCode, http.StatusText(expCode), resp.Status)
+	}
+
+	var errData struct {
+		Typ    string `json:"type"`
+		Detail string `json:"detail"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&errData); err != nil {
+		return fmt.Errorf("parsing error: %v", err)
+	}
+	return &letsencrypt.Error{
+		Typ:    strings.TrimPrefix(errData.Typ, "urn:acme:error:"),
+		Detail: errData.Detail,
+		Status: resp.StatusCode,
+	}
+}
@@ -0,0 +1,148 @@
+package certhelper_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/ericchiang/letsencrypt"
+	"github.com/nitrous-io/rise-server/pkg/certhelper"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("TLSALPNChallengeReady", func() {
+	var (
+		acmeServer *ghttp.Server
+		cli        *letsencrypt.Client
+		accountKey *rsa.PrivateKey
+		chal       letsencrypt.Challenge
+
+		challengeStatusCode int
+		challengeBody       string
+		jwsHeader           map[string]interface{}
+		jwsPayload          map[string]interface{}
+	)
+
+	BeforeEach(func() {
+		var err error
+		accountKey, err = rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).To(BeNil())
+
+		acmeServer = ghttp.NewServer()
+		challengeStatusCode = http.StatusAccepted
+		challengeBody = `{ "type": "tls-alpn-01", "status": "valid" }`
+		jwsHeader = nil
+		jwsPayload = nil
+
+		acmeServer.AppendHandlers(
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", "/"),
+				ghttp.RespondWith(http.StatusOK, `{
+					"new-authz": "`+acmeServer.URL()+`/new-authz",
+					"new-cert": "`+acmeServer.URL()+`/new-cert",
+					"new-reg": "`+acmeServer.URL()+`/new-reg",
+					"revoke-cert": "`+acmeServer.URL()+`/revoke-cert"
+				}`, http.Header{"Replay-Nonce": {"nonce-1"}}),
+			),
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", "/terms"),
+				ghttp.RespondWith(http.StatusOK, "ToS PDF file"),
+			),
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", "/"),
+				ghttp.RespondWith(http.StatusOK, `{}`, http.Header{"Replay-Nonce": {"nonce-2"}}),
+			),
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("POST", "/acme/challenge/abcde/124"),
+				ghttp.VerifyContentType("application/jose+jws"),
+				func(w http.ResponseWriter, r *http.Request) {
+					var jws struct {
+						Protected string `json:"protected"`
+						Payload   string `json:"payload"`
+					}
+					Expect(json.NewDecoder(r.Body).Decode(&jws)).To(Succeed())
+
+					header, err := base64.RawURLEncoding.DecodeString(jws.Protected)
+					Expect(err).To(BeNil())
+					Expect(json.Unmarshal(header, &jwsHeader)).To(Succeed())
+
+					payload, err := base64.RawURLEncoding.DecodeString(jws.Payload)
+					Expect(err).To(BeNil())
+					Expect(json.Unmarshal(payload, &jwsPayload)).To(Succeed())
+				},
+				ghttp.RespondWith(http.StatusAccepted, `{}`, http.Header{"Replay-Nonce": {"nonce-3"}}),
+			),
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", "/acme/challenge/abcde/124"),
+				ghttp.RespondWithPtr(&challengeStatusCode, &challengeBody),
+			),
+		)
+
+		cli, err = letsencrypt.NewClient(acmeServer.URL())
+		Expect(err).To(BeNil())
+		cli.PollInterval = 10 * time.Millisecond
+		cli.PollTimeout = time.Second
+
+		chal = letsencrypt.Challenge{
+			Type:  certhelper.ChallengeTLSALPN,
+			URI:   acmeServer.URL() + "/acme/challenge/abcde/124",
+			Token: "secret-token",
+		}
+	})
+
+	AfterEach(func() {
+		acmeServer.Close()
+	})
+
+	It("marks the challenge as ready with a freshly fetched nonce", func() {
+		err := certhelper.TLSALPNChallengeReady(cli, acmeServer.URL(), nil, accountKey, "secret-token.thumbprint", chal)
+		Expect(err).To(BeNil())
+
+		Expect(jwsHeader["alg"]).To(Equal("RS256"))
+		Expect(jwsHeader["nonce"]).To(Equal("nonce-2"))
+		Expect(jwsPayload).To(Equal(map[string]interface{}{
+			"resource":         "challenge",
+			"keyAuthorization": "secret-token.thumbprint",
+			"type":             "tls-alpn-01",
+			"token":            "secret-token",
+		}))
+	})
+
+	Context("when the challenge is invalid", func() {
+		BeforeEach(func() {
+			challengeBody = `{
+				"type": "tls-alpn-01",
+				"status": "invalid",
+				"error": {
+					"type": "urn:acme:error:connection",
+					"detail": "Connection refused",
+					"status": 400
+				}
+			}`
+		})
+
+		It("returns the error of the challenge", func() {
+			err := certhelper.TLSALPNChallengeReady(cli, acmeServer.URL(), nil, accountKey, "secret-token.thumbprint", chal)
+			Expect(err).NotTo(BeNil())
+			Expect(err.Error()).To(ContainSubstring("Connection refused"))
+		})
+	})
+
+	Context("when the challenge is not a TLS-ALPN-01 challenge", func() {
+		BeforeEach(func() {
+			chal.Type = letsencrypt.ChallengeHTTP
+		})
+
+		It("returns an error without contacting the ACME server", func() {
+			err := certhelper.TLSALPNChallengeReady(cli, acmeServer.URL(), nil, accountKey, "secret-token.thumbprint", chal)
+			Expect(err).NotTo(BeNil())
+			// Only the requests made by letsencrypt.NewClient().
+			Expect(acmeServer.ReceivedRequests()).To(HaveLen(2))
+		})
+	})
+})
@@ -3,14 +3,68 @@ package filetransfer
 import (
 	"io"
 	"time"
+
+	"golang.org/x/net/context"
 )
 
 type FileTransfer interface {
 	Upload(region, bucket, key string, body io.Reader, contentType, acl string) error
+	// UploadWithHeaders is Upload for objects that are served with headers
+	// besides Content-Type.
+	UploadWithHeaders(region, bucket, key string, body io.Reader, h *Headers, acl string) error
 	Download(region, bucket, key string, out io.WriterAt) error
+	Open(region, bucket, key string) (io.ReadCloser, error)
 	Delete(region, bucket string, keys ...string) error
 	DeleteAll(region, bucket, prefix string) error
-	Copy(region, bucket, srcKey, destKey string) error
+	List(region, bucket, prefix string, fn func(obj *ObjectInfo) error) error
+	Copy(region, bucket, srcKey, destKey, acl string) error
 	Exists(region, bucket, key string) (bool, error)
 	PresignedURL(region, bucket, key string, expireTime time.Duration) (string, error)
+	PresignedPutURL(region, bucket, key string, expireTime time.Duration) (string, error)
+
+	CreateMultipartUpload(region, bucket, key, acl string) (string, error)
+	UploadPart(region, bucket, key, uploadID string, partNumber int64, body io.ReadSeeker) error
+	ListParts(region, bucket, key, uploadID string) ([]*PartInfo, error)
+	CompleteMultipartUpload(region, bucket, key, uploadID string, parts []*PartInfo) error
+}
+
+// Headers are the headers that an uploaded object is served with.
+type Headers struct {
+	ContentType string
+	// ContentEncoding is set for objects whose content is compressed, e.g.
+	// "gzip".
+	ContentEncoding string
+	CacheControl    string
+}
+
+// ObjectInfo describes an object listed by List.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// PartInfo describes a part of a multipart upload listed by ListParts.
+type PartInfo struct {
+	PartNumber int64
+	ETag       string
+	Size       int64
+}
+
+// WithContext runs fn, which is typically a FileTransfer call, and returns
+// ctx.Err() if ctx is done before fn returns. The S3 client cannot cancel
+// requests that are in flight, so fn keeps running in the background in that
+// case.
+func WithContext(ctx context.Context, fn func() error) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- fn()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
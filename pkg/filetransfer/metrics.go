@@ -0,0 +1,227 @@
+package filetransfer
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// Classes of errors that S3 operations fail with.
+const (
+	ErrorClassNotFound     = "not_found"
+	ErrorClassAccessDenied = "access_denied"
+	ErrorClassThrottled    = "throttled"
+	ErrorClassServer       = "server_error"
+	ErrorClassClient       = "client_error"
+	ErrorClassTimeout      = "timeout"
+	ErrorClassNetwork      = "network_error"
+	ErrorClassPartial      = "partial_failure"
+	ErrorClassOther        = "other"
+)
+
+var (
+	// SlowThreshold is how long an S3 operation may take before it is logged
+	// as slow, unless SlowThresholds has a threshold for the operation.
+	SlowThreshold = 5 * time.Second
+
+	// SlowThresholds are the thresholds of operations that are expected to
+	// take longer, by operation name.
+	SlowThresholds = map[string]time.Duration{
+		"Upload":    30 * time.Second,
+		"Download":  30 * time.Second,
+		"DeleteAll": time.Minute,
+	}
+)
+
+// OpMetrics are the metrics of an S3 operation, e.g. "Upload", since the
+// process started.
+type OpMetrics struct {
+	Count int64 `json:"count"`
+	// Errors are the number of failed calls by error class, e.g. "throttled".
+	Errors map[string]int64 `json:"errors"`
+	// Bytes are the number of bytes uploaded or downloaded.
+	Bytes int64 `json:"bytes"`
+	// Retries are the number of requests that the AWS SDK retried.
+	Retries int64 `json:"retries"`
+	// Slow is the number of calls that took longer than the slow threshold
+	// of the operation.
+	Slow       int64 `json:"slow"`
+	TotalMilli int64 `json:"total_ms"`
+	MaxMilli   int64 `json:"max_ms"`
+}
+
+var metrics = struct {
+	sync.Mutex
+	ops map[string]*OpMetrics
+}{ops: map[string]*OpMetrics{}}
+
+// Metrics returns a copy of the metrics of the S3 operations that have been
+// performed, by operation name.
+func Metrics() map[string]OpMetrics {
+	metrics.Lock()
+	defer metrics.Unlock()
+
+	m := make(map[string]OpMetrics, len(metrics.ops))
+	for name, op := range metrics.ops {
+		c := *op
+		c.Errors = make(map[string]int64, len(op.Errors))
+		for class, n := range op.Errors {
+			c.Errors[class] = n
+		}
+		m[name] = c
+	}
+	return m
+}
+
+// ResetMetrics clears the metrics of all operations.
+func ResetMetrics() {
+	metrics.Lock()
+	metrics.ops = map[string]*OpMetrics{}
+	metrics.Unlock()
+}
+
+// ClassifyError returns the class of an error returned by S3, e.g.
+// ErrorClassThrottled, or an empty string if err is nil.
+func ClassifyError(err error) string {
+	switch e := err.(type) {
+	case nil:
+		return ""
+	case *DeleteError:
+		return ErrorClassPartial
+	case awserr.RequestFailure:
+		switch {
+		case e.StatusCode() == http.StatusNotFound:
+			return ErrorClassNotFound
+		case e.StatusCode() == http.StatusForbidden:
+			return ErrorClassAccessDenied
+		case e.StatusCode() == http.StatusServiceUnavailable, e.Code() == "SlowDown":
+			return ErrorClassThrottled
+		case e.StatusCode() >= 500:
+			return ErrorClassServer
+		default:
+			return ErrorClassClient
+		}
+	case awserr.Error:
+		if e.OrigErr() != nil {
+			if class := ClassifyError(e.OrigErr()); class != ErrorClassOther {
+				return class
+			}
+		}
+		if e.Code() == "RequestError" {
+			return ErrorClassNetwork
+		}
+	case net.Error:
+		if e.Timeout() {
+			return ErrorClassTimeout
+		}
+		return ErrorClassNetwork
+	}
+	return ErrorClassOther
+}
+
+// operation measures a single call to an S3 method.
+type operation struct {
+	name     string
+	bucket   string
+	key      string
+	start    time.Time
+	bytes    int64
+	requests int64
+	retries  int64
+}
+
+func startOperation(name, bucket, key string) *operation {
+	return &operation{
+		name:   name,
+		bucket: bucket,
+		key:    key,
+		start:  time.Now(),
+	}
+}
+
+// addBytes records bytes that were transferred outside of the requests that
+// are sent by the handler, e.g. bytes of a download.
+func (op *operation) addBytes(n int64) {
+	atomic.AddInt64(&op.bytes, n)
+}
+
+// uploadOperations are the S3 API operations whose request bodies are the
+// content of objects.
+var uploadOperations = map[string]bool{
+	"PutObject":  true,
+	"UploadPart": true,
+}
+
+// handler is added to the Send handlers of the sessions of the operation to
+// count the bytes that are uploaded and the requests that are retried.
+func (op *operation) handler(r *request.Request) {
+	atomic.AddInt64(&op.requests, 1)
+	if r.RetryCount > 0 {
+		atomic.AddInt64(&op.retries, 1)
+		return
+	}
+	if uploadOperations[r.Operation.Name] && r.HTTPRequest.ContentLength > 0 {
+		atomic.AddInt64(&op.bytes, r.HTTPRequest.ContentLength)
+	}
+}
+
+// finish records the metrics of the operation and logs it if it was slow.
+func (op *operation) finish(err error) {
+	elapsed := time.Since(op.start)
+	class := ClassifyError(err)
+
+	threshold, ok := SlowThresholds[op.name]
+	if !ok {
+		threshold = SlowThreshold
+	}
+	slow := elapsed > threshold
+
+	var (
+		bytes   = atomic.LoadInt64(&op.bytes)
+		retries = atomic.LoadInt64(&op.retries)
+		ms      = int64(elapsed / time.Millisecond)
+	)
+
+	metrics.Lock()
+	m, ok := metrics.ops[op.name]
+	if !ok {
+		m = &OpMetrics{Errors: map[string]int64{}}
+		metrics.ops[op.name] = m
+	}
+	m.Count++
+	if class != "" {
+		m.Errors[class]++
+	}
+	m.Bytes += bytes
+	m.Retries += retries
+	if slow {
+		m.Slow++
+	}
+	m.TotalMilli += ms
+	if ms > m.MaxMilli {
+		m.MaxMilli = ms
+	}
+	metrics.Unlock()
+
+	if slow {
+		fields := log.Fields{
+			"operation": op.name,
+			"bucket":    op.bucket,
+			"key":       op.key,
+			"elapsed":   elapsed.String(),
+			"bytes":     bytes,
+			"requests":  atomic.LoadInt64(&op.requests),
+			"retries":   retries,
+		}
+		if class != "" {
+			fields["error_class"] = class
+		}
+		log.WithFields(fields).Warnf("slow S3 operation, took longer than %s", threshold)
+	}
+}
@@ -0,0 +1,87 @@
+package filetransfer
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "filetransfer")
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+var _ net.Error = timeoutError{}
+
+var _ = Describe("Metrics", func() {
+	BeforeEach(func() {
+		ResetMetrics()
+	})
+
+	Describe("ClassifyError()", func() {
+		It("returns the class of the error", func() {
+			for err, class := range map[error]string{
+				awserr.NewRequestFailure(awserr.New("NoSuchKey", "not found", nil), http.StatusNotFound, "1"):           ErrorClassNotFound,
+				awserr.NewRequestFailure(awserr.New("AccessDenied", "denied", nil), http.StatusForbidden, "2"):          ErrorClassAccessDenied,
+				awserr.NewRequestFailure(awserr.New("SlowDown", "slow down", nil), http.StatusServiceUnavailable, "3"):  ErrorClassThrottled,
+				awserr.NewRequestFailure(awserr.New("InternalError", "oops", nil), http.StatusInternalServerError, "4"): ErrorClassServer,
+				awserr.NewRequestFailure(awserr.New("InvalidRequest", "bad", nil), http.StatusBadRequest, "5"):          ErrorClassClient,
+				awserr.New("RequestError", "send request failed", timeoutError{}):                                       ErrorClassTimeout,
+				awserr.New("RequestError", "send request failed", errors.New("connection reset")):                       ErrorClassNetwork,
+				&DeleteError{Keys: []string{"a"}, Err: errors.New("oops")}:                                              ErrorClassPartial,
+				errors.New("oops"): ErrorClassOther,
+			} {
+				Expect(ClassifyError(err)).To(Equal(class), err.Error())
+			}
+
+			Expect(ClassifyError(nil)).To(Equal(""))
+		})
+	})
+
+	Describe("operation", func() {
+		It("records the metrics of the operation", func() {
+			op := startOperation("Download", "bucket", "a.txt")
+			op.addBytes(100)
+			op.retries = 2
+			op.finish(nil)
+
+			op = startOperation("Download", "bucket", "b.txt")
+			op.start = time.Now().Add(-time.Minute)
+			op.finish(awserr.NewRequestFailure(awserr.New("SlowDown", "slow down", nil), http.StatusServiceUnavailable, "1"))
+
+			m := Metrics()
+			Expect(m).To(HaveLen(1))
+
+			dm := m["Download"]
+			Expect(dm.Count).To(Equal(int64(2)))
+			Expect(dm.Bytes).To(Equal(int64(100)))
+			Expect(dm.Retries).To(Equal(int64(2)))
+			Expect(dm.Slow).To(Equal(int64(1)))
+			Expect(dm.Errors).To(Equal(map[string]int64{ErrorClassThrottled: 1}))
+			Expect(dm.MaxMilli).To(BeNumerically(">=", 60000))
+			Expect(dm.TotalMilli).To(BeNumerically(">=", dm.MaxMilli))
+		})
+
+		It("returns a copy of the metrics", func() {
+			startOperation("Exists", "bucket", "a.txt").finish(errors.New("oops"))
+
+			m := Metrics()
+			m["Exists"].Errors[ErrorClassOther] = 100
+
+			Expect(Metrics()["Exists"].Errors[ErrorClassOther]).To(Equal(int64(1)))
+		})
+	})
+})
@@ -1,12 +1,14 @@
 package filetransfer
 
 import (
+	"fmt"
 	"io"
 	"net/http"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
@@ -15,6 +17,9 @@ import (
 type S3 struct {
 	partSize       int64
 	maxUploadParts int
+
+	// If nil, credentials are taken from the environment.
+	creds *credentials.Credentials
 }
 
 func NewS3(partSize int64, maxUploadParts int) *S3 {
@@ -24,8 +29,41 @@ func NewS3(partSize int64, maxUploadParts int) *S3 {
 	}
 }
 
+// NewS3WithCredentials returns an S3 that authenticates with the given AWS
+// access key instead of the one in the environment, e.g. to write to buckets
+// owned by users.
+func NewS3WithCredentials(partSize int64, maxUploadParts int, accessKeyID, secretAccessKey string) *S3 {
+	return &S3{
+		partSize:       partSize,
+		maxUploadParts: maxUploadParts,
+		creds:          credentials.NewStaticCredentials(accessKeyID, secretAccessKey, ""),
+	}
+}
+
+func (s *S3) config(region string) *aws.Config {
+	cfg := &aws.Config{Region: aws.String(region)}
+	if s.creds != nil {
+		cfg.Credentials = s.creds
+	}
+	return cfg
+}
+
+// newSession returns a session whose requests are measured by op.
+func (s *S3) newSession(region string, op *operation) *session.Session {
+	sess := session.New(s.config(region))
+	sess.Handlers.Send.PushFront(op.handler)
+	return sess
+}
+
 func (s *S3) Upload(region, bucket, key string, body io.Reader, contentType, acl string) error {
-	sess := session.New(&aws.Config{Region: aws.String(region)})
+	return s.UploadWithHeaders(region, bucket, key, body, &Headers{ContentType: contentType}, acl)
+}
+
+func (s *S3) UploadWithHeaders(region, bucket, key string, body io.Reader, h *Headers, acl string) (err error) {
+	op := startOperation("Upload", bucket, key)
+	defer func() { op.finish(err) }()
+
+	sess := s.newSession(region, op)
 	uploader := s3manager.NewUploader(sess, func(u *s3manager.Uploader) {
 		if s.partSize != 0 {
 			u.PartSize = s.partSize
@@ -35,6 +73,7 @@ func (s *S3) Upload(region, bucket, key string, body io.Reader, contentType, acl
 		}
 	})
 
+	contentType := h.ContentType
 	if contentType == "" {
 		contentType = "application/octet-stream"
 	}
@@ -43,55 +82,120 @@ func (s *S3) Upload(region, bucket, key string, body io.Reader, contentType, acl
 		acl = "private"
 	}
 
-	_, err := uploader.Upload(&s3manager.UploadInput{
+	input := &s3manager.UploadInput{
 		Bucket:      aws.String(bucket),
 		Key:         aws.String(key),
 		Body:        body,
 		ACL:         aws.String(acl),
 		ContentType: aws.String(contentType),
-	})
+	}
+	if h.ContentEncoding != "" {
+		input.ContentEncoding = aws.String(h.ContentEncoding)
+	}
+	if h.CacheControl != "" {
+		input.CacheControl = aws.String(h.CacheControl)
+	}
+
+	_, err = uploader.Upload(input)
 	return err
 }
 
-func (s *S3) Download(region, bucket, key string, out io.WriterAt) error {
-	sess := session.New(&aws.Config{Region: aws.String(region)})
+func (s *S3) Download(region, bucket, key string, out io.WriterAt) (err error) {
+	op := startOperation("Download", bucket, key)
+	defer func() { op.finish(err) }()
+
+	sess := s.newSession(region, op)
 	downloader := s3manager.NewDownloader(sess, func(d *s3manager.Downloader) {
 		if s.partSize != 0 {
 			d.PartSize = s.partSize
 		}
 	})
 
-	_, err := downloader.Download(out, &s3.GetObjectInput{
+	n, err := downloader.Download(out, &s3.GetObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(key),
 	})
+	op.addBytes(n)
 	return err
 }
 
-func (s *S3) Delete(region, bucket string, keys ...string) error {
-	svc := s3.New(session.New(&aws.Config{Region: aws.String(region)}))
+// Open returns the content of an object as a stream, so that it can be read
+// without downloading it first. The caller is responsible for closing it.
+// Only the time to the response is measured, as the content is read by the
+// caller.
+func (s *S3) Open(region, bucket, key string) (rc io.ReadCloser, err error) {
+	op := startOperation("Open", bucket, key)
+	defer func() { op.finish(err) }()
 
-	var objects []*s3.ObjectIdentifier
-	for _, key := range keys {
-		oi := &s3.ObjectIdentifier{
-			Key:       aws.String(key),
-			VersionId: nil,
-		}
+	svc := s3.New(s.newSession(region, op))
 
-		objects = append(objects, oi)
+	out, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
 	}
+	op.addBytes(aws.Int64Value(out.ContentLength))
+	return out.Body, nil
+}
 
-	params := &s3.DeleteObjectsInput{
-		Bucket: aws.String(bucket),
-		Delete: &s3.Delete{Objects: objects},
+// MaxKeysPerDelete is the maximum number of objects S3 deletes in a single
+// request.
+const MaxKeysPerDelete = 1000
+
+// DeleteError is returned when some objects could not be deleted. The other
+// objects are deleted regardless, so that deleting again only has to retry
+// the objects in Keys.
+type DeleteError struct {
+	Keys []string
+	Err  error // the first error that occurred
+}
+
+func (e *DeleteError) Error() string {
+	return fmt.Sprintf("failed to delete %d object(s), first error: %v", len(e.Keys), e.Err)
+}
+
+// Delete deletes objects by key, in batches of MaxKeysPerDelete. It returns a
+// *DeleteError if any of the objects could not be deleted.
+func (s *S3) Delete(region, bucket string, keys ...string) (err error) {
+	op := startOperation("Delete", bucket, "")
+	defer func() { op.finish(err) }()
+
+	svc := s3.New(s.newSession(region, op))
+
+	var delErr *DeleteError
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > MaxKeysPerDelete {
+			n = MaxKeysPerDelete
+		}
+
+		objects := make([]*s3.ObjectIdentifier, 0, n)
+		for _, key := range keys[:n] {
+			objects = append(objects, &s3.ObjectIdentifier{
+				Key:       aws.String(key),
+				VersionId: nil,
+			})
+		}
+
+		delErr = deleteObjects(svc, bucket, objects, delErr)
+		keys = keys[n:]
 	}
 
-	_, err := svc.DeleteObjects(params)
-	return err
+	if delErr != nil {
+		return delErr
+	}
+	return nil
 }
 
-func (s *S3) DeleteAll(region, bucket, prefix string) error {
-	svc := s3.New(session.New(&aws.Config{Region: aws.String(region)}))
+// DeleteAll deletes all objects whose keys start with prefix. It returns a
+// *DeleteError if any of the objects could not be deleted.
+func (s *S3) DeleteAll(region, bucket, prefix string) (err error) {
+	op := startOperation("DeleteAll", bucket, prefix)
+	defer func() { op.finish(err) }()
+
+	svc := s3.New(s.newSession(region, op))
 
 	listInput := &s3.ListObjectsInput{
 		Bucket: aws.String(bucket),
@@ -100,9 +204,10 @@ func (s *S3) DeleteAll(region, bucket, prefix string) error {
 
 	// This is slightly complex because ListObjectsPages() accepts a function
 	// that gets called for every page returned. The function should return
-	// false to stop iterating.
-	var fnErr error
-	err := svc.ListObjectsPages(listInput, func(res *s3.ListObjectsOutput, lastPage bool) (shouldContinue bool) {
+	// false to stop iterating. A page has at most 1000 objects, so each page
+	// is deleted in a single request.
+	var delErr *DeleteError
+	err = svc.ListObjectsPages(listInput, func(res *s3.ListObjectsOutput, lastPage bool) (shouldContinue bool) {
 
 		if len(res.Contents) == 0 {
 			return false // Stop iterating.
@@ -116,43 +221,104 @@ func (s *S3) DeleteAll(region, bucket, prefix string) error {
 			})
 		}
 
-		_, fnErr = svc.DeleteObjects(&s3.DeleteObjectsInput{
-			Bucket: aws.String(bucket),
-			Delete: &s3.Delete{Objects: objIdentifiers},
-		})
-		if fnErr != nil {
-			return false // Stop iterating.
-		}
+		// Keep going on failures to delete as many objects as possible.
+		delErr = deleteObjects(svc, bucket, objIdentifiers, delErr)
 
 		return !lastPage
 	})
 	if err != nil {
 		return err
 	}
-	if fnErr != nil {
-		return fnErr
+	if delErr != nil {
+		return delErr
 	}
 
 	return nil
 }
 
-func (s *S3) Copy(region, bucket, srcKey, destKey string) error {
-	svc := s3.New(session.New(&aws.Config{Region: aws.String(region)}))
+// List calls fn for every object whose key starts with prefix, in the
+// lexicographical order of their keys. Listing stops at the first error
+// returned by fn, which is then returned.
+func (s *S3) List(region, bucket, prefix string, fn func(obj *ObjectInfo) error) (err error) {
+	op := startOperation("List", bucket, prefix)
+	defer func() { op.finish(err) }()
+
+	svc := s3.New(s.newSession(region, op))
+
+	listInput := &s3.ListObjectsInput{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}
 
-	_, err := svc.CopyObject(&s3.CopyObjectInput{
+	var fnErr error
+	err = svc.ListObjectsPages(listInput, func(res *s3.ListObjectsOutput, lastPage bool) (shouldContinue bool) {
+		for _, obj := range res.Contents {
+			if fnErr = fn(&ObjectInfo{
+				Key:          aws.StringValue(obj.Key),
+				Size:         aws.Int64Value(obj.Size),
+				LastModified: aws.TimeValue(obj.LastModified),
+			}); fnErr != nil {
+				return false
+			}
+		}
+		return !lastPage
+	})
+	if err != nil {
+		return err
+	}
+	return fnErr
+}
+
+// deleteObjects deletes a batch of objects and adds the keys of the objects
+// that could not be deleted to delErr, which is allocated if nil.
+func deleteObjects(svc *s3.S3, bucket string, objects []*s3.ObjectIdentifier, delErr *DeleteError) *DeleteError {
+	fail := func(key string, err error) {
+		if delErr == nil {
+			delErr = &DeleteError{Err: err}
+		}
+		delErr.Keys = append(delErr.Keys, key)
+	}
+
+	out, err := svc.DeleteObjects(&s3.DeleteObjectsInput{
+		Bucket: aws.String(bucket),
+		Delete: &s3.Delete{Objects: objects},
+	})
+	if err != nil {
+		for _, obj := range objects {
+			fail(aws.StringValue(obj.Key), err)
+		}
+		return delErr
+	}
+
+	for _, e := range out.Errors {
+		fail(aws.StringValue(e.Key), fmt.Errorf("%s: %s", aws.StringValue(e.Code), aws.StringValue(e.Message)))
+	}
+	return delErr
+}
+
+func (s *S3) Copy(region, bucket, srcKey, destKey, acl string) (err error) {
+	op := startOperation("Copy", bucket, destKey)
+	defer func() { op.finish(err) }()
+
+	svc := s3.New(s.newSession(region, op))
+
+	_, err = svc.CopyObject(&s3.CopyObjectInput{
 		Bucket:     aws.String(bucket),
 		Key:        aws.String(destKey),
 		CopySource: aws.String(bucket + "/" + srcKey),
-		ACL:        aws.String("private"),
+		ACL:        aws.String(acl),
 	})
 
 	return err
 }
 
-func (s *S3) Exists(region, bucket, key string) (bool, error) {
-	svc := s3.New(session.New(&aws.Config{Region: aws.String(region)}))
+func (s *S3) Exists(region, bucket, key string) (exists bool, err error) {
+	op := startOperation("Exists", bucket, key)
+	defer func() { op.finish(err) }()
 
-	_, err := svc.HeadObject(&s3.HeadObjectInput{
+	svc := s3.New(s.newSession(region, op))
+
+	_, err = svc.HeadObject(&s3.HeadObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(key),
 	})
@@ -169,7 +335,7 @@ func (s *S3) Exists(region, bucket, key string) (bool, error) {
 }
 
 func (s *S3) PresignedURL(region, bucket, key string, expireTime time.Duration) (string, error) {
-	svc := s3.New(session.New(&aws.Config{Region: aws.String(region)}))
+	svc := s3.New(session.New(s.config(region)))
 
 	req, _ := svc.GetObjectRequest(&s3.GetObjectInput{
 		Bucket: aws.String(bucket),
@@ -183,3 +349,124 @@ func (s *S3) PresignedURL(region, bucket, key string, expireTime time.Duration)
 
 	return url, nil
 }
+
+// PresignedPutURL returns a URL that the object can be uploaded to with a PUT
+// request until it expires, without the uploader having AWS credentials.
+func (s *S3) PresignedPutURL(region, bucket, key string, expireTime time.Duration) (string, error) {
+	svc := s3.New(session.New(s.config(region)))
+
+	req, _ := svc.PutObjectRequest(&s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		ACL:    aws.String("private"),
+	})
+
+	url, err := req.Presign(expireTime)
+	if err != nil {
+		return "", err
+	}
+
+	return url, nil
+}
+
+// CreateMultipartUpload starts a multipart upload of an object whose parts are
+// uploaded with UploadPart, and returns the ID of the upload.
+func (s *S3) CreateMultipartUpload(region, bucket, key, acl string) (uploadID string, err error) {
+	op := startOperation("CreateMultipartUpload", bucket, key)
+	defer func() { op.finish(err) }()
+
+	svc := s3.New(s.newSession(region, op))
+
+	if acl == "" {
+		acl = "private"
+	}
+
+	out, err := svc.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		ACL:    aws.String(acl),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return aws.StringValue(out.UploadId), nil
+}
+
+// UploadPart uploads a part of a multipart upload. Uploading a part with the
+// number of a part that was uploaded before replaces it.
+func (s *S3) UploadPart(region, bucket, key, uploadID string, partNumber int64, body io.ReadSeeker) (err error) {
+	op := startOperation("UploadPart", bucket, key)
+	defer func() { op.finish(err) }()
+
+	svc := s3.New(s.newSession(region, op))
+
+	_, err = svc.UploadPart(&s3.UploadPartInput{
+		Bucket:     aws.String(bucket),
+		Key:        aws.String(key),
+		UploadId:   aws.String(uploadID),
+		PartNumber: aws.Int64(partNumber),
+		Body:       body,
+	})
+	return err
+}
+
+// ListParts returns the parts of a multipart upload that have been uploaded,
+// ordered by part number.
+func (s *S3) ListParts(region, bucket, key, uploadID string) (parts []*PartInfo, err error) {
+	op := startOperation("ListParts", bucket, key)
+	defer func() { op.finish(err) }()
+
+	svc := s3.New(s.newSession(region, op))
+
+	var marker *int64
+	for {
+		out, err := svc.ListParts(&s3.ListPartsInput{
+			Bucket:           aws.String(bucket),
+			Key:              aws.String(key),
+			UploadId:         aws.String(uploadID),
+			PartNumberMarker: marker,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range out.Parts {
+			parts = append(parts, &PartInfo{
+				PartNumber: aws.Int64Value(p.PartNumber),
+				ETag:       aws.StringValue(p.ETag),
+				Size:       aws.Int64Value(p.Size),
+			})
+		}
+
+		if !aws.BoolValue(out.IsTruncated) {
+			return parts, nil
+		}
+		marker = out.NextPartNumberMarker
+	}
+}
+
+// CompleteMultipartUpload assembles the given parts of a multipart upload
+// into the object.
+func (s *S3) CompleteMultipartUpload(region, bucket, key, uploadID string, parts []*PartInfo) (err error) {
+	op := startOperation("CompleteMultipartUpload", bucket, key)
+	defer func() { op.finish(err) }()
+
+	svc := s3.New(s.newSession(region, op))
+
+	completed := make([]*s3.CompletedPart, len(parts))
+	for i, p := range parts {
+		completed[i] = &s3.CompletedPart{
+			ETag:       aws.String(p.ETag),
+			PartNumber: aws.Int64(p.PartNumber),
+		}
+	}
+
+	_, err = svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(bucket),
+		Key:             aws.String(key),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: completed},
+	})
+	return err
+}
@@ -0,0 +1,356 @@
+// Package fingerprint renames the static assets of a site to names that
+// contain a hash of their content, e.g. "css/app.3f2a9c1b7d.css", and rewrites
+// the references to them in HTML and CSS files. As the name of a fingerprinted
+// asset changes whenever its content does, it can be cached forever.
+package fingerprint
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// CacheControl is the Cache-Control header that fingerprinted assets are
+// served with.
+const CacheControl = "public, max-age=31536000, immutable"
+
+// hashLength is the number of hex characters of the hash in the names of
+// fingerprinted assets.
+const hashLength = 10
+
+// assetExts are the extensions of files that are fingerprinted.
+var assetExts = map[string]bool{
+	".css":   true,
+	".js":    true,
+	".png":   true,
+	".jpg":   true,
+	".jpeg":  true,
+	".gif":   true,
+	".svg":   true,
+	".webp":  true,
+	".woff":  true,
+	".woff2": true,
+	".ttf":   true,
+	".otf":   true,
+	".eot":   true,
+}
+
+var fingerprintedName = regexp.MustCompile(`\.[0-9a-f]{10}\.[0-9A-Za-z]+$`)
+
+var (
+	// htmlRefs match URLs in src, href and poster attributes.
+	htmlRefs = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)\b(?:src|href|poster)\s*=\s*"([^"]*)"`),
+		regexp.MustCompile(`(?i)\b(?:src|href|poster)\s*=\s*'([^']*)'`),
+		regexp.MustCompile(`(?i)\b(?:src|href|poster)\s*=\s*([^\s"'>]+)`),
+	}
+	// srcsetRefs match srcset attributes, which are lists of URLs.
+	srcsetRefs = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)\bsrcset\s*=\s*"([^"]*)"`),
+		regexp.MustCompile(`(?i)\bsrcset\s*=\s*'([^']*)'`),
+	}
+	// cssRefs match URLs in CSS, which can also be in style elements and
+	// attributes of HTML files.
+	cssRefs = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)url\(\s*"([^"]*)"\s*\)`),
+		regexp.MustCompile(`(?i)url\(\s*'([^']*)'\s*\)`),
+		regexp.MustCompile(`(?i)url\(\s*([^"'()\s]+)\s*\)`),
+		regexp.MustCompile(`(?i)@import\s+"([^"]*)"`),
+		regexp.MustCompile(`(?i)@import\s+'([^']*)'`),
+	}
+)
+
+// IsFingerprinted returns whether the file at name is an asset that was
+// fingerprinted by Dir.
+func IsFingerprinted(name string) bool {
+	return assetExts[strings.ToLower(path.Ext(name))] && fingerprintedName.MatchString(name)
+}
+
+// Dir fingerprints the assets in dir that are referenced by the HTML and CSS
+// files in it, and rewrites the references. Assets that are not referenced by
+// HTML or CSS, e.g. ones that are only loaded by JavaScript, keep their names,
+// as the references to them cannot be rewritten. It returns the new paths of
+// the assets that were renamed, keyed by their old paths, which are relative
+// to dir and slash-separated.
+func Dir(dir string) (map[string]string, error) {
+	f := &fingerprinter{
+		dir:     dir,
+		files:   map[string]bool{},
+		docs:    map[string]string{},
+		refs:    map[string][]string{},
+		renamed: map[string]string{},
+		state:   map[string]int{},
+	}
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+
+	// Docs are processed in a fixed order, so that the same bundle is always
+	// fingerprinted the same way.
+	docNames := make([]string, 0, len(f.docs))
+	for name := range f.docs {
+		docNames = append(docNames, name)
+	}
+	sort.Strings(docNames)
+
+	referenced := map[string]bool{}
+	var names []string
+	for _, name := range docNames {
+		f.refs[name] = f.references(name, f.docs[name])
+		for _, ref := range f.refs[name] {
+			if !referenced[ref] {
+				referenced[ref] = true
+				names = append(names, ref)
+			}
+		}
+	}
+
+	for _, name := range names {
+		if err := f.fingerprint(name, referenced); err != nil {
+			return nil, err
+		}
+	}
+
+	// The references in CSS files that are not referenced themselves, and in
+	// HTML files, are rewritten now that all assets have been renamed.
+	for _, name := range docNames {
+		if f.state[name] != done {
+			if err := f.rewrite(name); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return f.renamed, nil
+}
+
+// States of the assets that are being fingerprinted, to process CSS files
+// after the CSS files they import.
+const (
+	unvisited = iota
+	visiting
+	done
+)
+
+type fingerprinter struct {
+	dir string
+	// files are all files in dir.
+	files map[string]bool
+	// docs are the contents of the HTML and CSS files, whose references are
+	// rewritten.
+	docs map[string]string
+	// refs are the assets referenced by each doc.
+	refs    map[string][]string
+	renamed map[string]string
+	state   map[string]int
+	// keep are the CSS files that import each other, which are not renamed,
+	// as the references between them cannot all be rewritten.
+	keep map[string]bool
+}
+
+func (f *fingerprinter) load() error {
+	return filepath.Walk(f.dir, func(absPath string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(f.dir, absPath)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(relPath)
+		f.files[name] = true
+
+		switch strings.ToLower(path.Ext(name)) {
+		case ".html", ".htm", ".css":
+			b, err := ioutil.ReadFile(absPath)
+			if err != nil {
+				return err
+			}
+			f.docs[name] = string(b)
+		}
+		return nil
+	})
+}
+
+// fingerprint renames the asset with the given name, after rewriting the
+// references in it if it is a CSS file.
+func (f *fingerprinter) fingerprint(name string, referenced map[string]bool) error {
+	switch f.state[name] {
+	case visiting:
+		if f.keep == nil {
+			f.keep = map[string]bool{}
+		}
+		f.keep[name] = true
+		return nil
+	case done:
+		return nil
+	}
+	f.state[name] = visiting
+
+	if _, isDoc := f.docs[name]; isDoc {
+		for _, ref := range f.refs[name] {
+			if err := f.fingerprint(ref, referenced); err != nil {
+				return err
+			}
+		}
+		if err := f.rewrite(name); err != nil {
+			return err
+		}
+	}
+	f.state[name] = done
+
+	// Assets whose names already contain a hash, e.g. ones fingerprinted by
+	// the site's own build, are left as they are.
+	if !referenced[name] || f.keep[name] || IsFingerprinted(name) {
+		return nil
+	}
+
+	absPath := filepath.Join(f.dir, filepath.FromSlash(name))
+	b, err := ioutil.ReadFile(absPath)
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(b)
+	ext := path.Ext(name)
+	newName := strings.TrimSuffix(name, ext) + "." + hex.EncodeToString(sum[:])[:hashLength] + ext
+	if err := os.Rename(absPath, filepath.Join(f.dir, filepath.FromSlash(newName))); err != nil {
+		return err
+	}
+	f.renamed[name] = newName
+	return nil
+}
+
+// rewrite rewrites the references to assets that have been renamed in the doc
+// with the given name.
+func (f *fingerprinter) rewrite(name string) error {
+	content := f.docs[name]
+	rewritten := f.replaceRefs(name, content, func(target, ref string) string {
+		newName, ok := f.renamed[target]
+		p, suffix := splitURL(ref)
+		if !ok || !strings.HasSuffix(p, path.Base(target)) {
+			return ref
+		}
+		return p[:len(p)-len(path.Base(target))] + path.Base(newName) + suffix
+	})
+
+	if rewritten == content {
+		return nil
+	}
+	f.docs[name] = rewritten
+	return ioutil.WriteFile(filepath.Join(f.dir, filepath.FromSlash(name)), []byte(rewritten), 0644)
+}
+
+// references returns the assets in the bundle that are referenced by the doc
+// with the given name.
+func (f *fingerprinter) references(name, content string) []string {
+	var refs []string
+	f.replaceRefs(name, content, func(target, ref string) string {
+		refs = append(refs, target)
+		return ref
+	})
+	return refs
+}
+
+// replaceRefs replaces the references to assets in the bundle in the content
+// of the doc with the given name with the result of fn, which is called with
+// the path of the asset and the reference.
+func (f *fingerprinter) replaceRefs(name, content string, fn func(target, ref string) string) string {
+	replace := func(ref string) string {
+		target, ok := f.resolve(name, ref)
+		if !ok {
+			return ref
+		}
+		return fn(target, ref)
+	}
+
+	patterns := cssRefs
+	isHTML := strings.ToLower(path.Ext(name)) != ".css"
+	if isHTML {
+		patterns = append(append([]*regexp.Regexp{}, htmlRefs...), cssRefs...)
+	}
+	for _, re := range patterns {
+		content = replaceSubmatches(re, content, replace)
+	}
+
+	if isHTML {
+		for _, re := range srcsetRefs {
+			content = replaceSubmatches(re, content, func(srcset string) string {
+				candidates := strings.Split(srcset, ",")
+				for i, c := range candidates {
+					fields := strings.Fields(c)
+					if len(fields) == 0 {
+						continue
+					}
+					candidates[i] = strings.Replace(c, fields[0], replace(fields[0]), 1)
+				}
+				return strings.Join(candidates, ",")
+			})
+		}
+	}
+
+	return content
+}
+
+// resolve returns the path of the asset in the bundle that the reference in
+// the doc with the given name is to. The second return value is false if it is
+// not a reference to an asset in the bundle.
+func (f *fingerprinter) resolve(name, ref string) (string, bool) {
+	p, _ := splitURL(ref)
+	if p == "" || strings.HasPrefix(p, "//") || strings.Contains(strings.SplitN(p, "/", 2)[0], ":") {
+		return "", false
+	}
+
+	// As with browsers, ".." elements cannot go above the root of the site.
+	var target string
+	if strings.HasPrefix(p, "/") {
+		target = strings.TrimPrefix(path.Clean(p), "/")
+	} else {
+		target = strings.TrimPrefix(path.Join("/", path.Dir(name), p), "/")
+	}
+
+	if !f.files[target] || !assetExts[strings.ToLower(path.Ext(target))] {
+		return "", false
+	}
+	return target, true
+}
+
+// splitURL splits a URL into its path and its query and fragment.
+func splitURL(ref string) (p, suffix string) {
+	if i := strings.IndexAny(ref, "?#"); i >= 0 {
+		return ref[:i], ref[i:]
+	}
+	return ref, ""
+}
+
+// replaceSubmatches replaces the first submatch of each match of re in s with
+// the result of fn.
+func replaceSubmatches(re *regexp.Regexp, s string, fn func(string) string) string {
+	matches := re.FindAllStringSubmatchIndex(s, -1)
+	if len(matches) == 0 {
+		return s
+	}
+
+	var (
+		b    []byte
+		last int
+	)
+	for _, m := range matches {
+		start, end := m[2], m[3]
+		b = append(b, s[last:start]...)
+		b = append(b, fn(s[start:end])...)
+		last = end
+	}
+	b = append(b, s[last:]...)
+	return string(b)
+}
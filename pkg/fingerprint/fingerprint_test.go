@@ -0,0 +1,197 @@
+package fingerprint_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nitrous-io/rise-server/pkg/fingerprint"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "fingerprint")
+}
+
+var _ = Describe("Fingerprint", func() {
+	Describe("IsFingerprinted()", func() {
+		It("returns whether the name of an asset contains a hash", func() {
+			for name, expected := range map[string]bool{
+				"css/app.3f2a9c1b7d.css": true,
+				"app.0123456789.js":      true,
+				"logo.abcdef0123.PNG":    true,
+				"app.css":                false,
+				"app.3f2a9c1b7.css":      false,
+				"app.3F2A9C1B7D.css":     false,
+				"index.3f2a9c1b7d.html":  false,
+				"data.3f2a9c1b7d.json":   false,
+			} {
+				Expect(fingerprint.IsFingerprinted(name)).To(Equal(expected), name)
+			}
+		})
+	})
+
+	Describe("Dir()", func() {
+		var dir string
+
+		write := func(name, content string) {
+			p := filepath.Join(dir, filepath.FromSlash(name))
+			Expect(os.MkdirAll(filepath.Dir(p), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(p, []byte(content), 0644)).To(Succeed())
+		}
+
+		read := func(name string) string {
+			b, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+			Expect(err).To(BeNil())
+			return string(b)
+		}
+
+		exists := func(name string) bool {
+			_, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name)))
+			return err == nil
+		}
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "fingerprint")
+			Expect(err).To(BeNil())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("renames referenced assets and rewrites the references to them", func() {
+			write("index.html", `<html><head>
+<link rel="stylesheet" href="/css/app.css?v=1">
+<script src='js/app.js'></script>
+</head><body>
+<img src=images/logo.png srcset="images/logo.png 1x, images/logo@2x.png 2x">
+<a href="about.html">About</a>
+<div style="background: url(images/bg.gif)"></div>
+<img src="https://example.com/remote.png">
+</body></html>`)
+			write("about.html", `<script src="../js/app.js"></script><script src="/js/app.js#main"></script>`)
+			write("css/app.css", `@import "base.css"; body { background: url('../images/bg.gif'); } .x { background: url(data:image/png;base64,AAAA); }`)
+			write("css/base.css", `h1 { font-family: x; src: url("../fonts/x.woff2"); }`)
+			write("js/app.js", `console.log("images/logo.png");`)
+			write("images/logo.png", "logo")
+			write("images/logo@2x.png", "logo2x")
+			write("images/bg.gif", "bg")
+			write("images/unused.png", "unused")
+			write("fonts/x.woff2", "font")
+
+			renamed, err := fingerprint.Dir(dir)
+			Expect(err).To(BeNil())
+
+			Expect(renamed).To(HaveLen(7))
+			for _, name := range []string{"css/app.css", "css/base.css", "js/app.js", "images/logo.png", "images/logo@2x.png", "images/bg.gif", "fonts/x.woff2"} {
+				newName, ok := renamed[name]
+				Expect(ok).To(BeTrue(), name)
+				Expect(fingerprint.IsFingerprinted(newName)).To(BeTrue(), newName)
+				Expect(exists(newName)).To(BeTrue(), newName)
+				Expect(exists(name)).To(BeFalse(), name)
+			}
+
+			base := func(name string) string {
+				return filepath.Base(renamed[name])
+			}
+
+			Expect(read("index.html")).To(Equal(`<html><head>
+<link rel="stylesheet" href="/css/` + base("css/app.css") + `?v=1">
+<script src='js/` + base("js/app.js") + `'></script>
+</head><body>
+<img src=images/` + base("images/logo.png") + ` srcset="images/` + base("images/logo.png") + ` 1x, images/` + base("images/logo@2x.png") + ` 2x">
+<a href="about.html">About</a>
+<div style="background: url(images/` + base("images/bg.gif") + `)"></div>
+<img src="https://example.com/remote.png">
+</body></html>`))
+			Expect(read("about.html")).To(Equal(`<script src="../js/` + base("js/app.js") + `"></script><script src="/js/` + base("js/app.js") + `#main"></script>`))
+			Expect(read(renamed["css/app.css"])).To(Equal(`@import "` + base("css/base.css") + `"; body { background: url('../images/` + base("images/bg.gif") + `'); } .x { background: url(data:image/png;base64,AAAA); }`))
+			Expect(read(renamed["css/base.css"])).To(Equal(`h1 { font-family: x; src: url("../fonts/` + base("fonts/x.woff2") + `"); }`))
+
+			// JavaScript is not rewritten, and unreferenced assets keep their
+			// names.
+			Expect(read(renamed["js/app.js"])).To(Equal(`console.log("images/logo.png");`))
+			Expect(exists("images/unused.png")).To(BeTrue())
+		})
+
+		It("names assets after a hash of their content", func() {
+			write("index.html", `<img src="a.png"><img src="b.png"><img src="c.png">`)
+			write("a.png", "same")
+			write("b.png", "same")
+			write("c.png", "different")
+
+			renamed, err := fingerprint.Dir(dir)
+			Expect(err).To(BeNil())
+
+			Expect(renamed["a.png"]).To(Equal("a.0967115f28.png"))
+			Expect(renamed["b.png"]).To(Equal("b.0967115f28.png"))
+			Expect(renamed["c.png"]).NotTo(Equal("c.0967115f28.png"))
+		})
+
+		It("hashes CSS files after rewriting their references", func() {
+			write("index.html", `<link href="app.css">`)
+			write("app.css", `body { background: url(bg.png); }`)
+			write("bg.png", "one")
+
+			renamed, err := fingerprint.Dir(dir)
+			Expect(err).To(BeNil())
+			first := renamed["app.css"]
+
+			Expect(os.RemoveAll(dir)).To(Succeed())
+			Expect(os.MkdirAll(dir, 0755)).To(Succeed())
+			write("index.html", `<link href="app.css">`)
+			write("app.css", `body { background: url(bg.png); }`)
+			write("bg.png", "two")
+
+			renamed, err = fingerprint.Dir(dir)
+			Expect(err).To(BeNil())
+			Expect(renamed["app.css"]).NotTo(Equal(first))
+		})
+
+		It("does not rename CSS files that import each other", func() {
+			write("index.html", `<link href="a.css"><link href="b.css">`)
+			write("a.css", `@import "b.css";`)
+			write("b.css", `@import "a.css";`)
+
+			renamed, err := fingerprint.Dir(dir)
+			Expect(err).To(BeNil())
+
+			Expect(renamed).To(HaveLen(1))
+			kept, other := "a.css", "b.css"
+			if _, ok := renamed["a.css"]; ok {
+				kept, other = other, kept
+			}
+			Expect(exists(kept)).To(BeTrue())
+			Expect(read(renamed[other])).To(Equal(`@import "` + kept + `";`))
+			Expect(read(kept)).To(Equal(`@import "` + renamed[other] + `";`))
+		})
+
+		It("leaves assets that are already fingerprinted as they are", func() {
+			write("index.html", `<script src="app.0123456789.js"></script>`)
+			write("app.0123456789.js", "app")
+
+			renamed, err := fingerprint.Dir(dir)
+			Expect(err).To(BeNil())
+
+			Expect(renamed).To(BeEmpty())
+			Expect(read("index.html")).To(Equal(`<script src="app.0123456789.js"></script>`))
+		})
+
+		It("does not follow references above the root of the directory", func() {
+			write("index.html", `<img src="../../logo.png">`)
+			write("logo.png", "logo")
+
+			renamed, err := fingerprint.Dir(dir)
+			Expect(err).To(BeNil())
+
+			Expect(renamed).To(HaveKey("logo.png"))
+			Expect(read("index.html")).To(Equal(`<img src="../../` + renamed["logo.png"] + `">`))
+		})
+	})
+})
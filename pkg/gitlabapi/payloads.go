@@ -0,0 +1,19 @@
+package gitlabapi
+
+import "strings"
+
+type PushPayload struct {
+	ObjectKind   string `json:"object_kind"`
+	Ref          string `json:"ref"`
+	After        string `json:"after"`
+	CheckoutSHA  string `json:"checkout_sha"`
+	UserUsername string `json:"user_username"`
+	Project      struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+		WebURL            string `json:"web_url"`
+	} `json:"project"`
+}
+
+func (p *PushPayload) Branch() string {
+	return strings.TrimPrefix(p.Ref, "refs/heads/")
+}
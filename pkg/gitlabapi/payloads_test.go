@@ -0,0 +1,84 @@
+package gitlabapi_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/nitrous-io/rise-server/pkg/gitlabapi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "gitlabapi")
+}
+
+var _ = Describe("gitlabapi", func() {
+	Describe("PushPayload", func() {
+		It("can be used to unmarshal a GitLab webhook payload", func() {
+			var pl gitlabapi.PushPayload
+			err := json.Unmarshal(sampleGitLabPushPayload, &pl)
+			Expect(err).To(BeNil())
+
+			Expect(pl.ObjectKind).To(Equal("push"))
+			Expect(pl.Ref).To(Equal("refs/heads/master"))
+			Expect(pl.After).To(Equal("da1560886d4f094c3e6c9ef40349f7d38b5d27d7"))
+			Expect(pl.CheckoutSHA).To(Equal("da1560886d4f094c3e6c9ef40349f7d38b5d27d7"))
+			Expect(pl.UserUsername).To(Equal("jsmith"))
+			Expect(pl.Project.PathWithNamespace).To(Equal("mike/diaspora"))
+			Expect(pl.Project.WebURL).To(Equal("http://example.com/mike/diaspora"))
+		})
+
+		Describe("Branch()", func() {
+			It("returns the branch", func() {
+				var pl gitlabapi.PushPayload
+				err := json.Unmarshal(sampleGitLabPushPayload, &pl)
+				Expect(err).To(BeNil())
+
+				Expect(pl.Branch()).To(Equal("master"))
+			})
+		})
+	})
+})
+
+var sampleGitLabPushPayload = []byte(`{
+  "object_kind": "push",
+  "before": "95790bf891e76fee5e1747ab589903a6a1f80f22",
+  "after": "da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+  "ref": "refs/heads/master",
+  "checkout_sha": "da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+  "user_id": 4,
+  "user_name": "John Smith",
+  "user_username": "jsmith",
+  "user_email": "john@example.com",
+  "project_id": 15,
+  "project": {
+    "id": 15,
+    "name": "Diaspora",
+    "description": "",
+    "web_url": "http://example.com/mike/diaspora",
+    "git_ssh_url": "git@example.com:mike/diaspora.git",
+    "git_http_url": "http://example.com/mike/diaspora.git",
+    "namespace": "Mike",
+    "visibility_level": 0,
+    "path_with_namespace": "mike/diaspora",
+    "default_branch": "master"
+  },
+  "commits": [
+    {
+      "id": "da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+      "message": "fixed readme",
+      "timestamp": "2012-01-03T23:36:29+02:00",
+      "url": "http://example.com/mike/diaspora/commit/da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+      "author": {
+        "name": "GitLab dev user",
+        "email": "gitlabdev@dv6700.(none)"
+      },
+      "added": [],
+      "modified": ["README.md"],
+      "removed": []
+    }
+  ],
+  "total_commits_count": 1
+}`)
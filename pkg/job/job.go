@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"time"
 
+	log "github.com/Sirupsen/logrus"
 	"github.com/nitrous-io/rise-server/pkg/mqconn"
 	"github.com/streadway/amqp"
 )
@@ -49,16 +50,32 @@ func (j *Job) Enqueue() error {
 		return err
 	}
 
+	// A job that could not be recorded is enqueued regardless, since losing
+	// the job would be worse than not being able to look up its status.
+	var messageID string
+	if DefaultRecorder != nil {
+		id, err := DefaultRecorder.RecordEnqueued(j)
+		if err != nil {
+			log.Warnf("failed to record job enqueued to %q, err: %v", j.QueueName, err)
+		} else {
+			messageID = id
+		}
+	}
+
+	now := time.Now()
+
 	return ch.Publish(
 		"",     // exchange
 		q.Name, // routing key
 		false,  // mandatory
 		false,  // immediate
 		amqp.Publishing{
+			Headers:      SignatureHeaders(now, messageID, j.Data),
+			MessageId:    messageID,
 			DeliveryMode: amqp.Persistent,
 			ContentType:  "text/plain",
 			Body:         []byte(j.Data),
-			Timestamp:    time.Now(),
+			Timestamp:    now,
 		},
 	)
 }
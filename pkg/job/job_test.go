@@ -1,7 +1,9 @@
 package job_test
 
 import (
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/nitrous-io/rise-server/pkg/job"
 	"github.com/nitrous-io/rise-server/pkg/mqconn"
@@ -38,7 +40,7 @@ var _ = Describe("Job", func() {
 
 	Describe("Enqueue()", func() {
 		var (
-			mq  *amqp.Connection
+			mq  mqconn.Conn
 			j   *job.Job
 			err error
 		)
@@ -58,6 +60,197 @@ var _ = Describe("Job", func() {
 			d := testhelper.ConsumeQueue(mq, "fooq")
 			Expect(d).NotTo(BeNil())
 			Expect(string(d.Body)).To(Equal("bar"))
+			Expect(d.Headers).NotTo(HaveKey(job.SignatureHeader))
+		})
+
+		Context("when signing key is set", func() {
+			var origSigningKey string
+
+			BeforeEach(func() {
+				origSigningKey = job.SigningKey
+				job.SigningKey = "shared-secret"
+			})
+
+			AfterEach(func() {
+				job.SigningKey = origSigningKey
+			})
+
+			It("signs the job", func() {
+				err := j.Enqueue()
+				Expect(err).To(BeNil())
+
+				d := testhelper.ConsumeQueue(mq, "fooq")
+				Expect(d).NotTo(BeNil())
+				Expect(d.Headers[job.SignatureHeader]).To(Equal(job.SignMessage("shared-secret", d.Timestamp, d.MessageId, []byte("bar"))))
+				Expect(job.Verify(*d)).To(Succeed())
+			})
+		})
+
+		Context("when a recorder is set", func() {
+			var rec *fakeRecorder
+
+			BeforeEach(func() {
+				rec = &fakeRecorder{id: "42"}
+				job.DefaultRecorder = rec
+			})
+
+			AfterEach(func() {
+				job.DefaultRecorder = nil
+			})
+
+			It("records the job and sends its id as the message id", func() {
+				err := j.Enqueue()
+				Expect(err).To(BeNil())
+
+				Expect(rec.enqueued).To(Equal([]*job.Job{j}))
+
+				d := testhelper.ConsumeQueue(mq, "fooq")
+				Expect(d).NotTo(BeNil())
+				Expect(d.MessageId).To(Equal("42"))
+			})
+
+			It("enqueues the job even if it could not be recorded", func() {
+				rec.err = errors.New("db is down")
+
+				err := j.Enqueue()
+				Expect(err).To(BeNil())
+
+				d := testhelper.ConsumeQueue(mq, "fooq")
+				Expect(d).NotTo(BeNil())
+				Expect(string(d.Body)).To(Equal("bar"))
+				Expect(d.MessageId).To(BeEmpty())
+			})
+		})
+	})
+
+	Describe("Started() and Finished()", func() {
+		var rec *fakeRecorder
+
+		BeforeEach(func() {
+			rec = &fakeRecorder{}
+			job.DefaultRecorder = rec
+		})
+
+		AfterEach(func() {
+			job.DefaultRecorder = nil
+		})
+
+		It("records the job with the message id", func() {
+			d := amqp.Delivery{MessageId: "42"}
+			workErr := errors.New("s3 is down")
+
+			job.Started(d)
+			job.Finished(d, workErr, true)
+
+			Expect(rec.started).To(Equal([]string{"42"}))
+			Expect(rec.finished).To(Equal([]string{"42"}))
+			Expect(rec.workErr).To(Equal(workErr))
+			Expect(rec.willRetry).To(BeTrue())
+		})
+
+		It("does not record messages without a message id", func() {
+			job.Started(amqp.Delivery{})
+			job.Finished(amqp.Delivery{}, nil, false)
+
+			Expect(rec.started).To(BeEmpty())
+			Expect(rec.finished).To(BeEmpty())
+		})
+	})
+
+	Describe("Verify()", func() {
+		var (
+			origSigningKey string
+			d              amqp.Delivery
+		)
+
+		BeforeEach(func() {
+			origSigningKey = job.SigningKey
+			job.SigningKey = "shared-secret"
+
+			now := time.Now()
+			d = amqp.Delivery{
+				Headers:   amqp.Table{job.SignatureHeader: job.SignMessage("shared-secret", now, "42", []byte("bar"))},
+				MessageId: "42",
+				Timestamp: now,
+				Body:      []byte("bar"),
+			}
+		})
+
+		AfterEach(func() {
+			job.SigningKey = origSigningKey
+		})
+
+		It("returns nil if the message is signed with the signing key", func() {
+			Expect(job.Verify(d)).To(Succeed())
+		})
+
+		It("returns an error if the message is not signed", func() {
+			d.Headers = nil
+			Expect(job.Verify(d)).To(Equal(job.ErrSignatureMissing))
+		})
+
+		It("returns an error if the message is signed with a different key", func() {
+			d.Headers[job.SignatureHeader] = job.SignMessage("another-secret", d.Timestamp, "42", []byte("bar"))
+			Expect(job.Verify(d)).To(Equal(job.ErrSignatureInvalid))
+		})
+
+		It("returns an error if the body has been tampered with", func() {
+			d.Body = []byte("baz")
+			Expect(job.Verify(d)).To(Equal(job.ErrSignatureInvalid))
+		})
+
+		It("returns an error if the timestamp has been tampered with", func() {
+			d.Timestamp = d.Timestamp.Add(time.Hour)
+			Expect(job.Verify(d)).To(Equal(job.ErrSignatureInvalid))
+		})
+
+		It("returns an error if the message id has been tampered with", func() {
+			d.MessageId = "43"
+			Expect(job.Verify(d)).To(Equal(job.ErrSignatureInvalid))
+		})
+
+		It("returns an error if the message is older than MaxMessageAge", func() {
+			signedAt := time.Now().Add(-job.MaxMessageAge - time.Minute)
+			d.Timestamp = signedAt
+			d.Headers[job.SignatureHeader] = job.SignMessage("shared-secret", signedAt, "42", []byte("bar"))
+			Expect(job.Verify(d)).To(Equal(job.ErrMessageStale))
+		})
+
+		It("returns nil if the signing key is not set", func() {
+			job.SigningKey = ""
+			d.Headers = nil
+			Expect(job.Verify(d)).To(Succeed())
 		})
 	})
 })
+
+type fakeRecorder struct {
+	id  string
+	err error
+
+	enqueued  []*job.Job
+	started   []string
+	finished  []string
+	workErr   error
+	willRetry bool
+}
+
+func (r *fakeRecorder) RecordEnqueued(j *job.Job) (string, error) {
+	if r.err != nil {
+		return "", r.err
+	}
+	r.enqueued = append(r.enqueued, j)
+	return r.id, nil
+}
+
+func (r *fakeRecorder) RecordStarted(id string) error {
+	r.started = append(r.started, id)
+	return r.err
+}
+
+func (r *fakeRecorder) RecordFinished(id string, workErr error, willRetry bool) error {
+	r.finished = append(r.finished, id)
+	r.workErr = workErr
+	r.willRetry = willRetry
+	return r.err
+}
@@ -0,0 +1,50 @@
+package job
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+// Recorder records jobs as they are enqueued and worked on, so that the
+// status of a job can be looked up later.
+type Recorder interface {
+	// RecordEnqueued records a job that is about to be enqueued and returns
+	// an ID that identifies it. The ID is sent along with the job as the
+	// message ID.
+	RecordEnqueued(j *Job) (id string, err error)
+	// RecordStarted records that a worker started working on a job.
+	RecordStarted(id string) error
+	// RecordFinished records that a worker finished working on a job, with
+	// workErr being the error the job failed with, if any. willRetry is
+	// whether the job was requeued to be retried.
+	RecordFinished(id string, workErr error, willRetry bool) error
+}
+
+// DefaultRecorder is the Recorder jobs are recorded with. Jobs are not
+// recorded if it is nil.
+var DefaultRecorder Recorder
+
+// Started records that a worker started working on the job in d. Failures
+// to record are only logged, as they should not stop the job from being
+// worked on.
+func Started(d amqp.Delivery) {
+	if DefaultRecorder == nil || d.MessageId == "" {
+		return
+	}
+
+	if err := DefaultRecorder.RecordStarted(d.MessageId); err != nil {
+		log.Warnf("failed to record start of job %s, err: %v", d.MessageId, err)
+	}
+}
+
+// Finished records that a worker finished working on the job in d. Failures
+// to record are only logged.
+func Finished(d amqp.Delivery, workErr error, willRetry bool) {
+	if DefaultRecorder == nil || d.MessageId == "" {
+		return
+	}
+
+	if err := DefaultRecorder.RecordFinished(d.MessageId, workErr, willRetry); err != nil {
+		log.Warnf("failed to record completion of job %s, err: %v", d.MessageId, err)
+	}
+}
@@ -0,0 +1,97 @@
+package job
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+// SignatureHeader is the header of a queue message that holds the signature
+// of its timestamp, message id and body.
+const SignatureHeader = "X-Rise-Signature"
+
+// SigningKey is the secret shared between the apiserver and the workers that
+// is used to sign and verify queue messages. It is required outside of
+// development and test, where messages are neither signed nor verified if it
+// is blank.
+var SigningKey = os.Getenv("JOB_SIGNING_KEY")
+
+// MaxMessageAge is how old a signed message may be before it is rejected, so
+// that captured messages cannot be replayed long after they were published.
+// It is generous so that messages that are retried, or that wait in a backed
+// up queue, are not dropped.
+var MaxMessageAge = 24 * time.Hour
+
+var (
+	ErrSignatureMissing = errors.New("message is not signed")
+	ErrSignatureInvalid = errors.New("message signature is invalid")
+	ErrMessageStale     = errors.New("message is too old")
+)
+
+func init() {
+	if SigningKey != "" {
+		return
+	}
+
+	switch os.Getenv("RISE_ENV") {
+	case "test":
+	case "", "development":
+		log.Warn("JOB_SIGNING_KEY is not set, queue messages will neither be signed nor verified!")
+	default:
+		log.Fatal("JOB_SIGNING_KEY environment variable is required!")
+	}
+}
+
+// Sign returns the hex-encoded HMAC-SHA256 signature of data.
+func Sign(data []byte, key string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignMessage returns the signature of a queue message with the given
+// timestamp, message id and body, joined by newlines.
+func SignMessage(key string, timestamp time.Time, messageID string, body []byte) string {
+	data := strconv.FormatInt(timestamp.Unix(), 10) + "\n" + messageID + "\n"
+	return Sign(append([]byte(data), body...), key)
+}
+
+// SignatureHeaders returns the headers that a message with the given
+// timestamp, message id and body is published with so that it passes Verify,
+// or nil if SigningKey is blank.
+func SignatureHeaders(timestamp time.Time, messageID string, body []byte) amqp.Table {
+	if SigningKey == "" {
+		return nil
+	}
+	return amqp.Table{SignatureHeader: SignMessage(SigningKey, timestamp, messageID, body)}
+}
+
+// Verify returns an error unless a queue message was signed with SigningKey
+// within MaxMessageAge, so that workers do not act on messages that were not
+// published by us or that are being replayed.
+func Verify(d amqp.Delivery) error {
+	if SigningKey == "" {
+		return nil
+	}
+
+	sig, ok := d.Headers[SignatureHeader].(string)
+	if !ok || sig == "" {
+		return ErrSignatureMissing
+	}
+
+	if !hmac.Equal([]byte(sig), []byte(SignMessage(SigningKey, d.Timestamp, d.MessageId, d.Body))) {
+		return ErrSignatureInvalid
+	}
+
+	if age := time.Since(d.Timestamp); age > MaxMessageAge || age < -MaxMessageAge {
+		return ErrMessageStale
+	}
+	return nil
+}
@@ -1,5 +1,23 @@
 package mailer
 
+import (
+	"html"
+	"mime"
+	"strings"
+)
+
 type Mailer interface {
 	SendMail(from string, tos, ccs, bccs []string, replyTo, subject, body, htmltext string) error
 }
+
+// textToHTML converts a plain text body to a simple HTML body.
+func textToHTML(body string) string {
+	htmltext := html.EscapeString(body)
+	return "<html><body>" + strings.Replace(htmltext, "\n", "<br>", -1) + "</body></html>"
+}
+
+// mimeEncodeHeader encodes a header value that may contain non-ASCII
+// characters.
+func mimeEncodeHeader(s string) string {
+	return mime.QEncoding.Encode("utf-8", s)
+}
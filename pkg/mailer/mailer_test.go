@@ -0,0 +1,91 @@
+package mailer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "mailer")
+}
+
+var _ = Describe("Mailer", func() {
+	Describe("buildMessage()", func() {
+		It("builds a multipart message with text and html parts", func() {
+			msg, err := buildMessage("PubStorm <support@pubstorm.com>", []string{"foo@example.com"}, []string{"bar@example.com"},
+				"support@pubstorm.com", "Hello", "Hi there", "<p>Hi there</p>")
+			Expect(err).To(BeNil())
+
+			s := string(msg)
+			Expect(s).To(ContainSubstring("From: PubStorm <support@pubstorm.com>\r\n"))
+			Expect(s).To(ContainSubstring("To: foo@example.com\r\n"))
+			Expect(s).To(ContainSubstring("Cc: bar@example.com\r\n"))
+			Expect(s).To(ContainSubstring("Reply-To: support@pubstorm.com\r\n"))
+			Expect(s).To(ContainSubstring("Subject: Hello\r\n"))
+			Expect(s).To(ContainSubstring("Content-Type: multipart/alternative; boundary="))
+			Expect(s).To(ContainSubstring("Content-Type: text/plain; charset=UTF-8"))
+			Expect(s).To(ContainSubstring("Content-Type: text/html; charset=UTF-8"))
+			Expect(s).To(ContainSubstring("<p>Hi there</p>"))
+		})
+	})
+
+	Describe("MailgunMailer", func() {
+		var (
+			server *httptest.Server
+			req    *http.Request
+			status int
+		)
+
+		BeforeEach(func() {
+			status = http.StatusOK
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				Expect(r.ParseForm()).To(BeNil())
+				req = r
+				w.WriteHeader(status)
+			}))
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		send := func() error {
+			m := NewMailgunMailer("mg.example.com", "key-123")
+			m.apiURL = server.URL
+			return m.SendMail("support@pubstorm.com", []string{"foo@example.com", "baz@example.com"}, nil, []string{"bar@example.com"},
+				"support@pubstorm.com", "Hello", "Hi\nthere", "")
+		}
+
+		It("posts the message to the Mailgun API", func() {
+			Expect(send()).To(BeNil())
+
+			Expect(req.URL.Path).To(Equal("/mg.example.com/messages"))
+			username, password, ok := req.BasicAuth()
+			Expect(ok).To(BeTrue())
+			Expect(username).To(Equal("api"))
+			Expect(password).To(Equal("key-123"))
+
+			Expect(req.PostForm.Get("from")).To(Equal("support@pubstorm.com"))
+			Expect(req.PostForm.Get("to")).To(Equal("foo@example.com,baz@example.com"))
+			Expect(req.PostForm.Get("bcc")).To(Equal("bar@example.com"))
+			Expect(req.PostForm.Get("cc")).To(Equal(""))
+			Expect(req.PostForm.Get("h:Reply-To")).To(Equal("support@pubstorm.com"))
+			Expect(req.PostForm.Get("subject")).To(Equal("Hello"))
+			Expect(req.PostForm.Get("text")).To(Equal("Hi\nthere"))
+			Expect(req.PostForm.Get("html")).To(Equal("<html><body>Hi<br>there</body></html>"))
+		})
+
+		It("returns an error if Mailgun does not respond with 200", func() {
+			status = http.StatusUnauthorized
+			err := send()
+			Expect(err).NotTo(BeNil())
+			Expect(strings.Contains(err.Error(), "401")).To(BeTrue())
+		})
+	})
+})
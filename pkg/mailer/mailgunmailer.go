@@ -0,0 +1,68 @@
+package mailer
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const mailgunAPIURL = "https://api.mailgun.net/v3"
+
+type MailgunMailer struct {
+	domain string
+	apiKey string
+	apiURL string
+	client *http.Client
+}
+
+func NewMailgunMailer(domain, apiKey string) *MailgunMailer {
+	return &MailgunMailer{
+		domain: domain,
+		apiKey: apiKey,
+		apiURL: mailgunAPIURL,
+		client: http.DefaultClient,
+	}
+}
+
+func (m *MailgunMailer) SendMail(from string, tos, ccs, bccs []string, replyTo, subject, body, htmltext string) error {
+	if htmltext == "" {
+		htmltext = textToHTML(body)
+	}
+
+	params := url.Values{
+		"from":    {from},
+		"to":      {strings.Join(tos, ",")},
+		"subject": {subject},
+		"text":    {body},
+		"html":    {htmltext},
+	}
+	if len(ccs) > 0 {
+		params.Set("cc", strings.Join(ccs, ","))
+	}
+	if len(bccs) > 0 {
+		params.Set("bcc", strings.Join(bccs, ","))
+	}
+	if replyTo != "" {
+		params.Set("h:Reply-To", replyTo)
+	}
+
+	req, err := http.NewRequest("POST", m.apiURL+"/"+m.domain+"/messages", strings.NewReader(params.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("api", m.apiKey)
+
+	res, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("mailgun responded with %d", res.StatusCode)
+	}
+
+	return nil
+}
@@ -1,11 +1,6 @@
 package mailer
 
-import (
-	"html"
-	"strings"
-
-	"github.com/sendgrid/sendgrid-go"
-)
+import "github.com/sendgrid/sendgrid-go"
 
 type SendGridMailer struct {
 	client *sendgrid.SGClient
@@ -37,8 +32,7 @@ func (s *SendGridMailer) SendMail(from string, tos, ccs, bccs []string, replyTo,
 	m.SetSubject(subject)
 	m.SetText(body)
 	if htmltext == "" {
-		htmltext = html.EscapeString(body)
-		htmltext = "<html><body>" + strings.Replace(htmltext, "\n", "<br>", -1) + "</body></html>"
+		htmltext = textToHTML(body)
 	}
 	m.SetHTML(htmltext)
 
@@ -0,0 +1,117 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+)
+
+type SMTPMailer struct {
+	addr string
+	auth smtp.Auth
+}
+
+// NewSMTPMailer returns a mailer that sends mail through the SMTP server at
+// host:port. If username is empty, no authentication is performed.
+func NewSMTPMailer(host string, port int, username, password string) *SMTPMailer {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &SMTPMailer{
+		addr: net.JoinHostPort(host, fmt.Sprintf("%d", port)),
+		auth: auth,
+	}
+}
+
+// NewSESMailer returns a mailer that sends mail through the SMTP interface of
+// Amazon SES in the given region, using SES SMTP credentials.
+func NewSESMailer(region, username, password string) *SMTPMailer {
+	return NewSMTPMailer("email-smtp."+region+".amazonaws.com", 587, username, password)
+}
+
+func (s *SMTPMailer) SendMail(from string, tos, ccs, bccs []string, replyTo, subject, body, htmltext string) error {
+	if htmltext == "" {
+		htmltext = textToHTML(body)
+	}
+
+	msg, err := buildMessage(from, tos, ccs, replyTo, subject, body, htmltext)
+	if err != nil {
+		return err
+	}
+
+	sender, err := mail.ParseAddress(from)
+	if err != nil {
+		return err
+	}
+
+	var rcpts []string
+	for _, list := range [][]string{tos, ccs, bccs} {
+		for _, r := range list {
+			addr, err := mail.ParseAddress(r)
+			if err != nil {
+				return err
+			}
+			rcpts = append(rcpts, addr.Address)
+		}
+	}
+
+	return smtp.SendMail(s.addr, s.auth, sender.Address, rcpts, msg)
+}
+
+// buildMessage builds a multipart/alternative MIME message with text and HTML
+// parts. Bccs are intentionally not included in the headers.
+func buildMessage(from string, tos, ccs []string, replyTo, subject, body, htmltext string) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w := multipart.NewWriter(buf)
+
+	headers := []string{
+		"From: " + from,
+		"To: " + strings.Join(tos, ", "),
+	}
+	if len(ccs) > 0 {
+		headers = append(headers, "Cc: "+strings.Join(ccs, ", "))
+	}
+	if replyTo != "" {
+		headers = append(headers, "Reply-To: "+replyTo)
+	}
+	headers = append(headers,
+		"Subject: "+mimeEncodeHeader(subject),
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/alternative; boundary="+w.Boundary(),
+	)
+	buf.WriteString(strings.Join(headers, "\r\n") + "\r\n\r\n")
+
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=UTF-8", body},
+		{"text/html; charset=UTF-8", htmltext},
+	} {
+		pw, err := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		qw := quotedprintable.NewWriter(pw)
+		if _, err := qw.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+		if err := qw.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
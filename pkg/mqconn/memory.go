@@ -0,0 +1,481 @@
+package mqconn
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/streadway/amqp"
+)
+
+// broker is the in-process message queue shared by all connections returned
+// by Memory.
+var broker = newMemoryBroker()
+
+// Memory returns a new connection to the in-process message queue. Messages
+// are routed the same way as RabbitMQ routes them through the default and
+// direct exchanges, but they only live as long as the process, so it is only
+// meant for tests and for local development without RabbitMQ. Durability,
+// exclusivity, auto-deletion and QoS settings are ignored.
+func Memory() Conn {
+	return &memoryConn{}
+}
+
+type memoryBroker struct {
+	mu sync.Mutex
+
+	queues    map[string]*memoryQueue
+	exchanges map[string][]memoryBinding
+	queueSeq  int
+}
+
+type memoryBinding struct {
+	route string
+	queue string
+}
+
+type memoryQueue struct {
+	name     string
+	messages []*memoryMessage
+
+	// wake is closed and replaced whenever a message is added to the queue.
+	wake    chan struct{}
+	deleted chan struct{}
+}
+
+type memoryMessage struct {
+	exchange    string
+	route       string
+	publishing  amqp.Publishing
+	redelivered bool
+}
+
+func newMemoryBroker() *memoryBroker {
+	return &memoryBroker{
+		queues:    map[string]*memoryQueue{},
+		exchanges: map[string][]memoryBinding{},
+	}
+}
+
+func notFound(format string, a ...interface{}) error {
+	return &amqp.Error{Code: amqp.NotFound, Reason: "NOT_FOUND - " + fmt.Sprintf(format, a...)}
+}
+
+// push adds a message to a queue. b.mu must be held.
+func (b *memoryBroker) push(q *memoryQueue, m *memoryMessage, front bool) {
+	if front {
+		q.messages = append([]*memoryMessage{m}, q.messages...)
+	} else {
+		q.messages = append(q.messages, m)
+	}
+	close(q.wake)
+	q.wake = make(chan struct{})
+}
+
+type memoryConn struct {
+	mu         sync.Mutex
+	closed     bool
+	channels   []*memoryChannel
+	closeChans []chan *amqp.Error
+}
+
+func (c *memoryConn) Channel() (Channel, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, amqp.ErrClosed
+	}
+
+	ch := &memoryChannel{
+		unacked: map[uint64]*memoryDelivery{},
+		closed:  make(chan struct{}),
+	}
+	c.channels = append(c.channels, ch)
+	return ch, nil
+}
+
+func (c *memoryConn) NotifyClose(ch chan *amqp.Error) chan *amqp.Error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		close(ch)
+		return ch
+	}
+	c.closeChans = append(c.closeChans, ch)
+	return ch
+}
+
+func (c *memoryConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return amqp.ErrClosed
+	}
+	c.closed = true
+
+	for _, ch := range c.channels {
+		ch.Close()
+	}
+	for _, ch := range c.closeChans {
+		close(ch)
+	}
+	return nil
+}
+
+type memoryDelivery struct {
+	queue   *memoryQueue
+	message *memoryMessage
+}
+
+type memoryChannel struct {
+	mu        sync.Mutex
+	closing   bool
+	closed    chan struct{}
+	consumers sync.WaitGroup
+	tag       uint64
+	consumer  int
+	unacked   map[uint64]*memoryDelivery
+}
+
+func (ch *memoryChannel) Qos(prefetchCount, prefetchSize int, global bool) error {
+	return nil
+}
+
+func (ch *memoryChannel) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	if name == "" {
+		broker.queueSeq++
+		name = fmt.Sprintf("amq.gen-%d", broker.queueSeq)
+	}
+
+	q, ok := broker.queues[name]
+	if !ok {
+		q = &memoryQueue{
+			name:    name,
+			wake:    make(chan struct{}),
+			deleted: make(chan struct{}),
+		}
+		broker.queues[name] = q
+	}
+
+	return amqp.Queue{Name: name, Messages: len(q.messages)}, nil
+}
+
+func (ch *memoryChannel) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	if _, ok := broker.queues[name]; !ok {
+		return notFound("no queue '%s'", name)
+	}
+	bindings, ok := broker.exchanges[exchange]
+	if !ok {
+		return notFound("no exchange '%s'", exchange)
+	}
+
+	for _, b := range bindings {
+		if b.route == key && b.queue == name {
+			return nil
+		}
+	}
+	broker.exchanges[exchange] = append(bindings, memoryBinding{route: key, queue: name})
+	return nil
+}
+
+func (ch *memoryChannel) QueueUnbind(name, key, exchange string, args amqp.Table) error {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	bindings := broker.exchanges[exchange]
+	for i, b := range bindings {
+		if b.route == key && b.queue == name {
+			broker.exchanges[exchange] = append(bindings[:i:i], bindings[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (ch *memoryChannel) QueueDelete(name string, ifUnused, ifEmpty, noWait bool) (int, error) {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	q, ok := broker.queues[name]
+	if !ok {
+		return 0, nil
+	}
+
+	delete(broker.queues, name)
+	for exchange, bindings := range broker.exchanges {
+		kept := []memoryBinding{}
+		for _, b := range bindings {
+			if b.queue != name {
+				kept = append(kept, b)
+			}
+		}
+		broker.exchanges[exchange] = kept
+	}
+	close(q.deleted)
+
+	return len(q.messages), nil
+}
+
+func (ch *memoryChannel) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	if kind != "direct" {
+		return &amqp.Error{Code: amqp.NotImplemented, Reason: fmt.Sprintf("NOT_IMPLEMENTED - exchange type '%s' is not supported", kind)}
+	}
+
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	if _, ok := broker.exchanges[name]; !ok {
+		broker.exchanges[name] = []memoryBinding{}
+	}
+	return nil
+}
+
+func (ch *memoryChannel) ExchangeDelete(name string, ifUnused, noWait bool) error {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	delete(broker.exchanges, name)
+	return nil
+}
+
+// Publish routes a message to the queue named by key when exchange is empty,
+// or otherwise to all queues bound to the exchange with key. As with
+// RabbitMQ, messages that cannot be routed to any queue are dropped.
+func (ch *memoryChannel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	var queueNames []string
+	if exchange == "" {
+		queueNames = []string{key}
+	} else {
+		bindings, ok := broker.exchanges[exchange]
+		if !ok {
+			return notFound("no exchange '%s'", exchange)
+		}
+		for _, b := range bindings {
+			if b.route == key {
+				queueNames = append(queueNames, b.queue)
+			}
+		}
+	}
+
+	for _, name := range queueNames {
+		if q, ok := broker.queues[name]; ok {
+			body := make([]byte, len(msg.Body))
+			copy(body, msg.Body)
+			p := msg
+			p.Body = body
+
+			broker.push(q, &memoryMessage{exchange: exchange, route: key, publishing: p}, false)
+		}
+	}
+	return nil
+}
+
+// Consume delivers messages from the queue until the channel is closed or the
+// queue is deleted. Messages that are not acknowledged when the channel is
+// closed are put back on the queue.
+func (ch *memoryChannel) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	broker.mu.Lock()
+	q, ok := broker.queues[queue]
+	broker.mu.Unlock()
+	if !ok {
+		return nil, notFound("no queue '%s'", queue)
+	}
+
+	ch.mu.Lock()
+	if ch.closing {
+		ch.mu.Unlock()
+		return nil, amqp.ErrClosed
+	}
+	if consumer == "" {
+		ch.consumer++
+		consumer = fmt.Sprintf("ctag-%d", ch.consumer)
+	}
+	ch.consumers.Add(1)
+	ch.mu.Unlock()
+
+	out := make(chan amqp.Delivery)
+	go func() {
+		defer ch.consumers.Done()
+		defer close(out)
+
+		for {
+			broker.mu.Lock()
+			if len(q.messages) == 0 {
+				wake := q.wake
+				broker.mu.Unlock()
+
+				select {
+				case <-wake:
+					continue
+				case <-ch.closed:
+					return
+				case <-q.deleted:
+					return
+				}
+			}
+
+			m := q.messages[0]
+			q.messages = q.messages[1:]
+			broker.mu.Unlock()
+
+			d := ch.delivery(q, m, consumer, autoAck)
+			select {
+			case out <- d:
+			case <-ch.closed:
+				// The message was never delivered, so put it back.
+				ch.mu.Lock()
+				delete(ch.unacked, d.DeliveryTag)
+				ch.mu.Unlock()
+				requeue(q, m)
+				return
+			case <-q.deleted:
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+func (ch *memoryChannel) delivery(q *memoryQueue, m *memoryMessage, consumer string, autoAck bool) amqp.Delivery {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	ch.tag++
+	if !autoAck {
+		ch.unacked[ch.tag] = &memoryDelivery{queue: q, message: m}
+	}
+
+	p := m.publishing
+	return amqp.Delivery{
+		Acknowledger:    ch,
+		Headers:         p.Headers,
+		ContentType:     p.ContentType,
+		ContentEncoding: p.ContentEncoding,
+		DeliveryMode:    p.DeliveryMode,
+		Priority:        p.Priority,
+		CorrelationId:   p.CorrelationId,
+		ReplyTo:         p.ReplyTo,
+		Expiration:      p.Expiration,
+		MessageId:       p.MessageId,
+		Timestamp:       p.Timestamp,
+		Type:            p.Type,
+		UserId:          p.UserId,
+		AppId:           p.AppId,
+		ConsumerTag:     consumer,
+		DeliveryTag:     ch.tag,
+		Redelivered:     m.redelivered,
+		Exchange:        m.exchange,
+		RoutingKey:      m.route,
+		Body:            p.Body,
+	}
+}
+
+// requeue puts a message back at the front of its queue, unless the queue has
+// been deleted in the meantime.
+func requeue(q *memoryQueue, m *memoryMessage) {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	if broker.queues[q.name] != q {
+		return
+	}
+	redelivered := *m
+	redelivered.redelivered = true
+	broker.push(q, &redelivered, true)
+}
+
+// requeueAll puts the given deliveries back on their queues in the order in
+// which they were delivered.
+func requeueAll(deliveries map[uint64]*memoryDelivery) {
+	tags := make([]uint64, 0, len(deliveries))
+	for t := range deliveries {
+		tags = append(tags, t)
+	}
+	sort.Sort(sort.Reverse(tagSlice(tags)))
+
+	for _, t := range tags {
+		requeue(deliveries[t].queue, deliveries[t].message)
+	}
+}
+
+type tagSlice []uint64
+
+func (s tagSlice) Len() int           { return len(s) }
+func (s tagSlice) Less(i, j int) bool { return s[i] < s[j] }
+func (s tagSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// settle acknowledges or rejects the delivery with the given tag, or all
+// unacknowledged deliveries up to it if multiple is true.
+func (ch *memoryChannel) settle(tag uint64, multiple, requeue bool) error {
+	ch.mu.Lock()
+	var settled []uint64
+	if multiple {
+		for t := range ch.unacked {
+			if t <= tag {
+				settled = append(settled, t)
+			}
+		}
+	} else if _, ok := ch.unacked[tag]; ok {
+		settled = []uint64{tag}
+	}
+	deliveries := map[uint64]*memoryDelivery{}
+	for _, t := range settled {
+		deliveries[t] = ch.unacked[t]
+		delete(ch.unacked, t)
+	}
+	ch.mu.Unlock()
+
+	if len(settled) == 0 {
+		return &amqp.Error{Code: amqp.PreconditionFailed, Reason: fmt.Sprintf("PRECONDITION_FAILED - unknown delivery tag %d", tag)}
+	}
+
+	if requeue {
+		requeueAll(deliveries)
+	}
+	return nil
+}
+
+func (ch *memoryChannel) Ack(tag uint64, multiple bool) error {
+	return ch.settle(tag, multiple, false)
+}
+
+func (ch *memoryChannel) Nack(tag uint64, multiple bool, requeue bool) error {
+	return ch.settle(tag, multiple, requeue)
+}
+
+func (ch *memoryChannel) Reject(tag uint64, requeue bool) error {
+	return ch.settle(tag, false, requeue)
+}
+
+func (ch *memoryChannel) Close() error {
+	ch.mu.Lock()
+	if ch.closing {
+		ch.mu.Unlock()
+		return amqp.ErrClosed
+	}
+	ch.closing = true
+	close(ch.closed)
+	ch.mu.Unlock()
+
+	// Wait for consumers to put back messages that they have not delivered
+	// before putting back the ones that were delivered earlier.
+	ch.consumers.Wait()
+
+	ch.mu.Lock()
+	unacked := ch.unacked
+	ch.unacked = map[uint64]*memoryDelivery{}
+	ch.mu.Unlock()
+
+	requeueAll(unacked)
+	return nil
+}
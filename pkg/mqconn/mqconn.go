@@ -2,34 +2,84 @@ package mqconn
 
 import (
 	"os"
+	"strings"
 	"sync"
 
 	"github.com/streadway/amqp"
 )
 
+// MemoryURL is the AMQP_URL that makes MQ return a connection to an
+// in-process message queue instead of RabbitMQ.
+const MemoryURL = "memory://"
+
+// Conn is a connection to a message queue. It is implemented by RabbitMQ
+// connections and by the in-process message queue.
+type Conn interface {
+	Channel() (Channel, error)
+	NotifyClose(c chan *amqp.Error) chan *amqp.Error
+	Close() error
+}
+
+// Channel is the subset of the methods of *amqp.Channel that is used to
+// publish and consume messages.
+type Channel interface {
+	Qos(prefetchCount, prefetchSize int, global bool) error
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error
+	QueueUnbind(name, key, exchange string, args amqp.Table) error
+	QueueDelete(name string, ifUnused, ifEmpty, noWait bool) (int, error)
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
+	ExchangeDelete(name string, ifUnused, noWait bool) error
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error)
+	Close() error
+}
+
 var (
-	mq     *amqp.Connection
+	mq     Conn
 	mqLock sync.Mutex
 
 	closeChan chan *amqp.Error
 )
 
-// MQ returns RabbitMQ conection
-func MQ() (*amqp.Connection, error) {
+// MQ returns RabbitMQ conection, or a connection to the in-process message
+// queue if AMQP_URL is set to MemoryURL.
+func MQ() (Conn, error) {
 	mqLock.Lock()
 	defer mqLock.Unlock()
 	if mq == nil {
-		conn, err := amqp.Dial(os.Getenv("AMQP_URL"))
+		url := os.Getenv("AMQP_URL")
+		if strings.HasPrefix(url, MemoryURL) {
+			mq = Memory()
+			return mq, nil
+		}
+
+		conn, err := amqp.Dial(url)
 		if err != nil {
 			return nil, err
 		}
-		mq = conn
+		mq = &amqpConn{conn}
 
 		closeChan = conn.NotifyClose(make(chan *amqp.Error))
 		go func() {
 			<-closeChan
+			mqLock.Lock()
 			mq = nil
+			mqLock.Unlock()
 		}()
 	}
 	return mq, nil
 }
+
+// amqpConn wraps *amqp.Connection so that it implements Conn.
+type amqpConn struct {
+	*amqp.Connection
+}
+
+func (c *amqpConn) Channel() (Channel, error) {
+	ch, err := c.Connection.Channel()
+	if err != nil {
+		return nil, err
+	}
+	return ch, nil
+}
@@ -0,0 +1,229 @@
+package mqconn_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nitrous-io/rise-server/pkg/mqconn"
+	"github.com/nitrous-io/rise-server/testhelper"
+	"github.com/streadway/amqp"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "mqconn")
+}
+
+var _ = Describe("Memory", func() {
+	var (
+		mq  mqconn.Conn
+		ch  mqconn.Channel
+		err error
+	)
+
+	publish := func(exchange, key, body string) {
+		Expect(ch.Publish(exchange, key, false, false, amqp.Publishing{
+			ContentType: "text/plain",
+			Body:        []byte(body),
+		})).To(BeNil())
+	}
+
+	receive := func(msgCh <-chan amqp.Delivery) *amqp.Delivery {
+		select {
+		case d := <-msgCh:
+			return &d
+		case <-time.After(100 * time.Millisecond):
+			return nil
+		}
+	}
+
+	BeforeEach(func() {
+		mq = mqconn.Memory()
+		ch, err = mq.Channel()
+		Expect(err).To(BeNil())
+
+		testhelper.DeleteQueue(mq, "fooq", "barq")
+		testhelper.DeleteExchange(mq, "foo-exchange")
+
+		_, err = ch.QueueDeclare("fooq", true, false, false, false, nil)
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		mq.Close()
+	})
+
+	It("delivers messages published to the default exchange to the named queue", func() {
+		publish("", "fooq", "one")
+		publish("", "fooq", "two")
+
+		d := testhelper.ConsumeQueue(mq, "fooq")
+		Expect(d).NotTo(BeNil())
+		Expect(string(d.Body)).To(Equal("one"))
+		Expect(d.ContentType).To(Equal("text/plain"))
+
+		d = testhelper.ConsumeQueue(mq, "fooq")
+		Expect(d).NotTo(BeNil())
+		Expect(string(d.Body)).To(Equal("two"))
+
+		Expect(testhelper.ConsumeQueue(mq, "fooq")).To(BeNil())
+	})
+
+	It("shares messages between connections", func() {
+		publish("", "fooq", "one")
+
+		other := mqconn.Memory()
+		defer other.Close()
+
+		d := testhelper.ConsumeQueue(other, "fooq")
+		Expect(d).NotTo(BeNil())
+		Expect(string(d.Body)).To(Equal("one"))
+	})
+
+	It("drops messages that cannot be routed to a queue", func() {
+		publish("", "barq", "one")
+
+		_, err := ch.QueueDeclare("barq", true, false, false, false, nil)
+		Expect(err).To(BeNil())
+		Expect(testhelper.ConsumeQueue(mq, "barq")).To(BeNil())
+	})
+
+	It("delivers messages published to an exchange to all queues bound with the route", func() {
+		q1 := testhelper.StartQueueWithExchange(mq, "foo-exchange", "bar-route")
+		q2 := testhelper.StartQueueWithExchange(mq, "foo-exchange", "bar-route")
+		q3 := testhelper.StartQueueWithExchange(mq, "foo-exchange", "baz-route")
+		defer testhelper.DeleteQueue(mq, q1, q2, q3)
+
+		publish("foo-exchange", "bar-route", "chocolates")
+
+		for _, q := range []string{q1, q2} {
+			d := testhelper.ConsumeQueue(mq, q)
+			Expect(d).NotTo(BeNil())
+			Expect(string(d.Body)).To(Equal("chocolates"))
+			Expect(d.Exchange).To(Equal("foo-exchange"))
+			Expect(d.RoutingKey).To(Equal("bar-route"))
+		}
+		Expect(testhelper.ConsumeQueue(mq, q3)).To(BeNil())
+	})
+
+	It("returns an error when publishing to an exchange that does not exist", func() {
+		err := ch.Publish("foo-exchange", "bar-route", false, false, amqp.Publishing{})
+		Expect(err).NotTo(BeNil())
+		Expect(err.(*amqp.Error).Code).To(Equal(amqp.NotFound))
+	})
+
+	It("returns an error when consuming from a queue that does not exist", func() {
+		_, err := ch.Consume("barq", "", true, false, false, false, nil)
+		Expect(err).NotTo(BeNil())
+		Expect(err.(*amqp.Error).Code).To(Equal(amqp.NotFound))
+	})
+
+	It("delivers messages published after consuming has started", func() {
+		msgCh, err := ch.Consume("fooq", "", true, false, false, false, nil)
+		Expect(err).To(BeNil())
+
+		Expect(receive(msgCh)).To(BeNil())
+
+		publish("", "fooq", "one")
+
+		d := receive(msgCh)
+		Expect(d).NotTo(BeNil())
+		Expect(string(d.Body)).To(Equal("one"))
+	})
+
+	Describe("acknowledgements", func() {
+		var msgCh <-chan amqp.Delivery
+
+		BeforeEach(func() {
+			publish("", "fooq", "one")
+			publish("", "fooq", "two")
+
+			msgCh, err = ch.Consume("fooq", "", false, false, false, false, nil)
+			Expect(err).To(BeNil())
+		})
+
+		It("removes acknowledged messages from the queue", func() {
+			d := receive(msgCh)
+			Expect(d).NotTo(BeNil())
+			Expect(d.Ack(false)).To(BeNil())
+			Expect(ch.Close()).To(BeNil())
+
+			d = testhelper.ConsumeQueue(mq, "fooq")
+			Expect(d).NotTo(BeNil())
+			Expect(string(d.Body)).To(Equal("two"))
+			Expect(testhelper.ConsumeQueue(mq, "fooq")).To(BeNil())
+		})
+
+		It("redelivers messages that are rejected with requeue", func() {
+			d := receive(msgCh)
+			Expect(d).NotTo(BeNil())
+			Expect(string(d.Body)).To(Equal("one"))
+			Expect(d.Redelivered).To(BeFalse())
+			Expect(d.Nack(false, true)).To(BeNil())
+
+			// "two" may already be on its way to the consumer, so the
+			// redelivered message is not necessarily the next one.
+			redelivered := map[string]bool{}
+			for i := 0; i < 2; i++ {
+				d = receive(msgCh)
+				Expect(d).NotTo(BeNil())
+				redelivered[string(d.Body)] = d.Redelivered
+			}
+			Expect(redelivered).To(Equal(map[string]bool{"one": true, "two": false}))
+		})
+
+		It("discards messages that are rejected without requeue", func() {
+			d := receive(msgCh)
+			Expect(d).NotTo(BeNil())
+			Expect(d.Nack(false, false)).To(BeNil())
+			Expect(ch.Close()).To(BeNil())
+
+			d = testhelper.ConsumeQueue(mq, "fooq")
+			Expect(d).NotTo(BeNil())
+			Expect(string(d.Body)).To(Equal("two"))
+		})
+
+		It("returns an error when acknowledging a message twice", func() {
+			d := receive(msgCh)
+			Expect(d).NotTo(BeNil())
+			Expect(d.Ack(false)).To(BeNil())
+			Expect(d.Ack(false)).NotTo(BeNil())
+		})
+
+		It("puts unacknowledged messages back in order when the channel is closed", func() {
+			d1 := receive(msgCh)
+			Expect(d1).NotTo(BeNil())
+			d2 := receive(msgCh)
+			Expect(d2).NotTo(BeNil())
+
+			Expect(ch.Close()).To(BeNil())
+
+			d := testhelper.ConsumeQueue(mq, "fooq")
+			Expect(d).NotTo(BeNil())
+			Expect(string(d.Body)).To(Equal("one"))
+			Expect(d.Redelivered).To(BeTrue())
+
+			d = testhelper.ConsumeQueue(mq, "fooq")
+			Expect(d).NotTo(BeNil())
+			Expect(string(d.Body)).To(Equal("two"))
+		})
+	})
+
+	It("stops delivering messages when the queue is deleted", func() {
+		msgCh, err := ch.Consume("fooq", "", true, false, false, false, nil)
+		Expect(err).To(BeNil())
+
+		testhelper.DeleteQueue(mq, "fooq")
+
+		Eventually(msgCh).Should(BeClosed())
+	})
+
+	It("closes the channels passed to NotifyClose when the connection is closed", func() {
+		closeCh := mq.NotifyClose(make(chan *amqp.Error, 1))
+		Expect(mq.Close()).To(BeNil())
+		Eventually(closeCh).Should(BeClosed())
+	})
+})
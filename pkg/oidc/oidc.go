@@ -0,0 +1,313 @@
+// Package oidc implements the parts of OpenID Connect needed to log users in
+// with an identity provider using the authorization code flow.
+package oidc
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/square/go-jose"
+)
+
+// Errors returned from this package.
+var (
+	ErrInvalidIDToken   = errors.New("invalid id token")
+	ErrUnsupportedAlg   = errors.New("id token is signed with an unsupported algorithm")
+	ErrKeyNotFound      = errors.New("id token signing key not found")
+	ErrIssuerMismatch   = errors.New("id token issuer does not match")
+	ErrAudienceMismatch = errors.New("id token audience does not match")
+	ErrTokenExpired     = errors.New("id token has expired")
+	ErrNonceMismatch    = errors.New("id token nonce does not match")
+)
+
+// SupportedAlgs are the algorithms ID tokens may be signed with.
+var SupportedAlgs = []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}
+
+// ClockSkew is how much the clocks of the identity provider and this server
+// are allowed to differ by when checking the expiry of ID tokens.
+var ClockSkew = time.Minute
+
+// DefaultHTTPClient is the client used to make requests to identity
+// providers, unless a provider has its own.
+var DefaultHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// maxResponseSize is the maximum size of a response from an identity
+// provider.
+const maxResponseSize = 1 << 20
+
+// Metadata is the subset of an identity provider's discovery document that is
+// needed to log users in.
+type Metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider is an identity provider with which this server is registered as a
+// client.
+type Provider struct {
+	Metadata
+
+	ClientID     string
+	ClientSecret string
+
+	// HTTPClient is the client used to make requests to the provider. If it
+	// is nil, DefaultHTTPClient is used.
+	HTTPClient *http.Client
+}
+
+// Claims are the claims of an ID token that are used to identify a user.
+type Claims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      Audience `json:"aud"`
+	Expiry        int64    `json:"exp"`
+	Nonce         string   `json:"nonce"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"email_verified"`
+	Name          string   `json:"name"`
+}
+
+// Audience is the audience of an ID token, which may either be a string or an
+// array of strings.
+type Audience []string
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = Audience{s}
+		return nil
+	}
+
+	var ss []string
+	if err := json.Unmarshal(b, &ss); err != nil {
+		return err
+	}
+	*a = Audience(ss)
+	return nil
+}
+
+// Contains returns whether the audience contains clientID.
+func (a Audience) Contains(clientID string) bool {
+	for _, aud := range a {
+		if aud == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// Discover fetches the discovery document of the identity provider with the
+// given issuer URL. If client is nil, DefaultHTTPClient is used.
+func Discover(client *http.Client, issuer string) (*Metadata, error) {
+	wellKnown := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+
+	m := &Metadata{}
+	if err := getJSON(client, wellKnown, m); err != nil {
+		return nil, err
+	}
+
+	if m.Issuer != issuer {
+		return nil, fmt.Errorf("discovery document issuer %q does not match %q", m.Issuer, issuer)
+	}
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" {
+		return nil, errors.New("discovery document is missing endpoints")
+	}
+
+	return m, nil
+}
+
+// AuthCodeURL returns the URL of the identity provider's authorization
+// endpoint that users are redirected to in order to log in.
+func (p *Provider) AuthCodeURL(redirectURI, state, nonce string) string {
+	params := url.Values{
+		"response_type": {"code"},
+		"client_id":     {p.ClientID},
+		"redirect_uri":  {redirectURI},
+		"scope":         {"openid email profile"},
+		"state":         {state},
+		"nonce":         {nonce},
+	}
+
+	sep := "?"
+	if strings.Contains(p.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return p.AuthorizationEndpoint + sep + params.Encode()
+}
+
+// Exchange exchanges an authorization code for an ID token, and returns the
+// verified claims of the token.
+func (p *Provider) Exchange(code, redirectURI, nonce string) (*Claims, error) {
+	params := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {redirectURI},
+	}
+
+	req, err := http.NewRequest("POST", p.TokenEndpoint, strings.NewReader(params.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+
+	res, err := p.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint responded with %d", res.StatusCode)
+	}
+
+	var tokenRes struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxResponseSize)).Decode(&tokenRes); err != nil {
+		return nil, err
+	}
+	if tokenRes.IDToken == "" {
+		return nil, errors.New("token endpoint did not return an id token")
+	}
+
+	return p.VerifyIDToken(tokenRes.IDToken, nonce)
+}
+
+// VerifyIDToken verifies the signature of an ID token with the identity
+// provider's keys, checks that it was issued to this client for the given
+// nonce and has not expired, and returns its claims.
+func (p *Provider) VerifyIDToken(rawIDToken, nonce string) (*Claims, error) {
+	jws, err := jose.ParseSigned(rawIDToken)
+	if err != nil || len(jws.Signatures) != 1 {
+		return nil, ErrInvalidIDToken
+	}
+
+	header := jws.Signatures[0].Header
+	if !isSupportedAlg(header.Algorithm) {
+		return nil, ErrUnsupportedAlg
+	}
+
+	keys, err := p.keys(header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	var payload []byte
+	for _, key := range keys {
+		if payload, err = jws.Verify(key); err == nil {
+			break
+		}
+	}
+	if payload == nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	claims := &Claims{}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	if claims.Issuer != p.Issuer {
+		return nil, ErrIssuerMismatch
+	}
+	if !claims.Audience.Contains(p.ClientID) {
+		return nil, ErrAudienceMismatch
+	}
+	if time.Unix(claims.Expiry, 0).Add(ClockSkew).Before(time.Now()) {
+		return nil, ErrTokenExpired
+	}
+	if claims.Nonce != nonce {
+		return nil, ErrNonceMismatch
+	}
+
+	return claims, nil
+}
+
+// keys returns the public keys of the identity provider that may have been
+// used to sign a token with the given key ID. Only asymmetric keys are
+// returned, so that tokens cannot be signed with a key that was published.
+func (p *Provider) keys(kid string) ([]interface{}, error) {
+	var set jose.JsonWebKeySet
+	if err := getJSON(p.httpClient(), p.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+
+	candidates := set.Keys
+	if kid != "" {
+		candidates = set.Key(kid)
+	}
+
+	var keys []interface{}
+	for _, k := range candidates {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Key.(type) {
+		case *rsa.PublicKey, *ecdsa.PublicKey:
+			keys = append(keys, k.Key)
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, ErrKeyNotFound
+	}
+	return keys, nil
+}
+
+func (p *Provider) httpClient() *http.Client {
+	if p.HTTPClient != nil {
+		return p.HTTPClient
+	}
+	return DefaultHTTPClient
+}
+
+func isSupportedAlg(alg string) bool {
+	for _, a := range SupportedAlgs {
+		if a == alg {
+			return true
+		}
+	}
+	return false
+}
+
+func getJSON(client *http.Client, u string, v interface{}) error {
+	if client == nil {
+		client = DefaultHTTPClient
+	}
+
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s responded with %d", u, res.StatusCode)
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(res.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,186 @@
+package oidc_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/nitrous-io/rise-server/pkg/oidc"
+	"github.com/nitrous-io/rise-server/testhelper/fake"
+	"github.com/square/go-jose"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "oidc")
+}
+
+var _ = Describe("OIDC", func() {
+	var (
+		idp    *fake.OIDCProvider
+		p      *oidc.Provider
+		claims map[string]interface{}
+
+		origHTTPClient *http.Client
+	)
+
+	BeforeEach(func() {
+		idp = fake.NewOIDCProvider()
+		origHTTPClient = oidc.DefaultHTTPClient
+		oidc.DefaultHTTPClient = idp.Client()
+
+		m, err := oidc.Discover(nil, idp.URL)
+		Expect(err).To(BeNil())
+
+		p = &oidc.Provider{
+			Metadata:     *m,
+			ClientID:     "rise",
+			ClientSecret: "s3cr3t",
+		}
+
+		claims = map[string]interface{}{
+			"iss":            idp.URL,
+			"sub":            "248289761001",
+			"aud":            "rise",
+			"exp":            time.Now().Add(time.Hour).Unix(),
+			"nonce":          "n-0S6_WzA2Mj",
+			"email":          "harry@hogwarts.edu",
+			"email_verified": true,
+			"name":           "Harry Potter",
+		}
+	})
+
+	AfterEach(func() {
+		oidc.DefaultHTTPClient = origHTTPClient
+		idp.Close()
+	})
+
+	Describe("Discover()", func() {
+		It("returns the endpoints of the provider", func() {
+			m, err := oidc.Discover(nil, idp.URL)
+			Expect(err).To(BeNil())
+			Expect(m).To(Equal(&oidc.Metadata{
+				Issuer:                idp.URL,
+				AuthorizationEndpoint: idp.URL + "/authorize",
+				TokenEndpoint:         idp.URL + "/token",
+				JWKSURI:               idp.URL + "/jwks",
+			}))
+		})
+
+		It("returns an error if the issuer does not match", func() {
+			_, err := oidc.Discover(nil, idp.URL+"/")
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Describe("AuthCodeURL()", func() {
+		It("returns the authorization endpoint with the request params", func() {
+			u, err := url.Parse(p.AuthCodeURL("https://api.example.com/sso/callback", "the-state", "the-nonce"))
+			Expect(err).To(BeNil())
+			Expect(u.Path).To(Equal("/authorize"))
+			Expect(u.Query()).To(Equal(url.Values{
+				"response_type": {"code"},
+				"client_id":     {"rise"},
+				"redirect_uri":  {"https://api.example.com/sso/callback"},
+				"scope":         {"openid email profile"},
+				"state":         {"the-state"},
+				"nonce":         {"the-nonce"},
+			}))
+		})
+	})
+
+	Describe("Exchange()", func() {
+		BeforeEach(func() {
+			idp.Claims = claims
+		})
+
+		It("exchanges the code for an id token and returns its claims", func() {
+			c, err := p.Exchange("the-code", "https://api.example.com/sso/callback", "n-0S6_WzA2Mj")
+			Expect(err).To(BeNil())
+			Expect(c.Subject).To(Equal("248289761001"))
+			Expect(c.Email).To(Equal("harry@hogwarts.edu"))
+			Expect(c.EmailVerified).To(BeTrue())
+			Expect(c.Name).To(Equal("Harry Potter"))
+
+			Expect(idp.TokenParams).To(Equal([]map[string]string{{
+				"client_id":     "rise",
+				"client_secret": "s3cr3t",
+				"grant_type":    "authorization_code",
+				"code":          "the-code",
+				"redirect_uri":  "https://api.example.com/sso/callback",
+			}}))
+		})
+
+		It("returns an error if the token endpoint fails", func() {
+			idp.TokenStatus = 400
+			_, err := p.Exchange("the-code", "https://api.example.com/sso/callback", "n-0S6_WzA2Mj")
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Describe("VerifyIDToken()", func() {
+		It("returns the claims of a valid token", func() {
+			c, err := p.VerifyIDToken(idp.IDToken(claims), "n-0S6_WzA2Mj")
+			Expect(err).To(BeNil())
+			Expect(c.Audience).To(Equal(oidc.Audience{"rise"}))
+		})
+
+		It("accepts an audience that is an array", func() {
+			claims["aud"] = []string{"someone-else", "rise"}
+			_, err := p.VerifyIDToken(idp.IDToken(claims), "n-0S6_WzA2Mj")
+			Expect(err).To(BeNil())
+		})
+
+		It("rejects a token for another client", func() {
+			claims["aud"] = "someone-else"
+			_, err := p.VerifyIDToken(idp.IDToken(claims), "n-0S6_WzA2Mj")
+			Expect(err).To(Equal(oidc.ErrAudienceMismatch))
+		})
+
+		It("rejects a token from another issuer", func() {
+			claims["iss"] = "https://evil.example.com"
+			_, err := p.VerifyIDToken(idp.IDToken(claims), "n-0S6_WzA2Mj")
+			Expect(err).To(Equal(oidc.ErrIssuerMismatch))
+		})
+
+		It("rejects an expired token", func() {
+			claims["exp"] = time.Now().Add(-time.Hour).Unix()
+			_, err := p.VerifyIDToken(idp.IDToken(claims), "n-0S6_WzA2Mj")
+			Expect(err).To(Equal(oidc.ErrTokenExpired))
+		})
+
+		It("rejects a token with another nonce", func() {
+			_, err := p.VerifyIDToken(idp.IDToken(claims), "another-nonce")
+			Expect(err).To(Equal(oidc.ErrNonceMismatch))
+		})
+
+		It("rejects a token signed with another key", func() {
+			key, err := rsa.GenerateKey(rand.Reader, 2048)
+			Expect(err).To(BeNil())
+			idp.Key, key = key, idp.Key
+			token := idp.IDToken(claims)
+			idp.Key = key
+
+			_, err = p.VerifyIDToken(token, "n-0S6_WzA2Mj")
+			Expect(err).To(Equal(oidc.ErrInvalidIDToken))
+		})
+
+		It("rejects a token signed with a symmetric key", func() {
+			signer, err := jose.NewSigner(jose.HS256, []byte("published-secret"))
+			Expect(err).To(BeNil())
+			jws, err := signer.Sign([]byte(`{}`))
+			Expect(err).To(BeNil())
+			token, err := jws.CompactSerialize()
+			Expect(err).To(BeNil())
+
+			_, err = p.VerifyIDToken(token, "n-0S6_WzA2Mj")
+			Expect(err).To(Equal(oidc.ErrUnsupportedAlg))
+		})
+	})
+})
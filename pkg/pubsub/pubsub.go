@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"time"
 
+	"github.com/nitrous-io/rise-server/pkg/job"
 	"github.com/nitrous-io/rise-server/pkg/mqconn"
 	"github.com/streadway/amqp"
 )
@@ -52,16 +53,21 @@ func (j *Message) Publish() error {
 		return err
 	}
 
+	now := time.Now()
+
+	// Messages are signed like jobs so that subscribers can tell that they
+	// were published by us.
 	return ch.Publish(
 		j.ExchangeName, // exchange
 		j.Route,        // routing key
 		false,          // mandatory
 		false,          // immediate
 		amqp.Publishing{
+			Headers:      job.SignatureHeaders(now, "", j.Data),
 			DeliveryMode: amqp.Persistent,
 			ContentType:  "text/plain",
 			Body:         []byte(j.Data),
-			Timestamp:    time.Now(),
+			Timestamp:    now,
 		},
 	)
 }
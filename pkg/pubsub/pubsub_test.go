@@ -3,12 +3,12 @@ package pubsub_test
 import (
 	"testing"
 
+	"github.com/nitrous-io/rise-server/pkg/job"
 	"github.com/nitrous-io/rise-server/pkg/mqconn"
 	"github.com/nitrous-io/rise-server/pkg/pubsub"
 	"github.com/nitrous-io/rise-server/testhelper"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
-	"github.com/streadway/amqp"
 )
 
 func Test(t *testing.T) {
@@ -39,7 +39,7 @@ var _ = Describe("PubSub", func() {
 
 	Describe("Message.Publish()", func() {
 		var (
-			mq       *amqp.Connection
+			mq       mqconn.Conn
 			q1, q2   string
 			pm       *pubsub.Message
 			exchange string
@@ -76,5 +76,27 @@ var _ = Describe("PubSub", func() {
 			Expect(d2).NotTo(BeNil())
 			Expect(string(d2.Body)).To(Equal("chocolates"))
 		})
+
+		Context("when signing key is set", func() {
+			var origSigningKey string
+
+			BeforeEach(func() {
+				origSigningKey = job.SigningKey
+				job.SigningKey = "shared-secret"
+			})
+
+			AfterEach(func() {
+				job.SigningKey = origSigningKey
+			})
+
+			It("signs the message", func() {
+				err := pm.Publish()
+				Expect(err).To(BeNil())
+
+				d := testhelper.ConsumeQueue(mq, q1)
+				Expect(d).NotTo(BeNil())
+				Expect(job.Verify(*d)).To(Succeed())
+			})
+		})
 	})
 })
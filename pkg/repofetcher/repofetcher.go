@@ -0,0 +1,214 @@
+// Package repofetcher downloads files and archives of git repositories from
+// the APIs of the providers that they are hosted on, so that a project can be
+// deployed when a repository it is linked to is pushed to.
+package repofetcher
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// Errors returned from this package.
+var (
+	ErrFileNotFound       = errors.New("file could not be found in repository")
+	ErrArchiveUnavailable = errors.New("archive of repository could not be downloaded")
+)
+
+// Providers that repositories can be fetched from.
+const (
+	ProviderGitHub    = "github"
+	ProviderGitLab    = "gitlab"
+	ProviderBitbucket = "bitbucket"
+)
+
+// Default hosts of the providers, used when a Fetcher is created without one.
+const (
+	DefaultGitHubAPIHost    = "https://api.github.com"
+	DefaultGitLabAPIHost    = "https://gitlab.com"
+	DefaultBitbucketAPIHost = "https://api.bitbucket.org"
+	DefaultBitbucketHost    = "https://bitbucket.org"
+)
+
+// FileTimeout and ArchiveTimeout are how long fetching a file or an archive
+// may take.
+var (
+	FileTimeout    = 2 * time.Second
+	ArchiveTimeout = 10 * time.Second
+)
+
+// maxFileSize is the maximum size of a file fetched with FetchFile.
+const maxFileSize = 1 << 20
+
+// Fetcher fetches the contents of repositories hosted on a provider. Repos
+// are identified by their full name, e.g. "PubStorm/pubstorm-www", and refs
+// are branch names or commit SHAs.
+type Fetcher interface {
+	// FetchFile returns the contents of the file at filePath in the repo at
+	// the given ref. ErrFileNotFound is returned if the file does not exist.
+	FetchFile(fullName, ref, filePath string) ([]byte, error)
+
+	// FetchArchive returns a gzipped tarball of the repo at the given ref.
+	// The contents of the repo are in a single top-level directory of the
+	// tarball. The caller must close the returned reader.
+	FetchArchive(fullName, ref string) (io.ReadCloser, error)
+}
+
+// GitHub fetches repositories with the GitHub API.
+type GitHub struct {
+	APIHost string
+	// Token is a personal access token that is sent with requests if set,
+	// so that private repositories can be fetched and rate limits are higher.
+	Token string
+}
+
+// FetchFile implements Fetcher using the contents API.
+func (g *GitHub) FetchFile(fullName, ref, filePath string) ([]byte, error) {
+	u := fmt.Sprintf("%s/repos/%s/contents/%s?%s", hostOr(g.APIHost, DefaultGitHubAPIHost),
+		fullName, escapePath(filePath), url.Values{"ref": {ref}}.Encode())
+
+	return fetchFile(u, g.authorize, http.Header{"Accept": {"application/vnd.github.v3.raw"}})
+}
+
+// FetchArchive implements Fetcher using the tarball endpoint, which redirects
+// to the archive.
+func (g *GitHub) FetchArchive(fullName, ref string) (io.ReadCloser, error) {
+	u := fmt.Sprintf("%s/repos/%s/tarball/%s", hostOr(g.APIHost, DefaultGitHubAPIHost),
+		fullName, ref)
+
+	return fetchArchive(u, g.authorize)
+}
+
+func (g *GitHub) authorize(req *http.Request) {
+	if g.Token != "" {
+		req.Header.Set("Authorization", "token "+g.Token)
+	}
+}
+
+// GitLab fetches repositories with the GitLab API (v4). APIHost can be set to
+// fetch from a self-hosted GitLab instance.
+type GitLab struct {
+	APIHost string
+	// Token is a personal access token that is sent with requests if set.
+	Token string
+}
+
+// FetchFile implements Fetcher using the repository files API.
+func (g *GitLab) FetchFile(fullName, ref, filePath string) ([]byte, error) {
+	u := fmt.Sprintf("%s/api/v4/projects/%s/repository/files/%s/raw?%s",
+		hostOr(g.APIHost, DefaultGitLabAPIHost), url.QueryEscape(fullName),
+		url.QueryEscape(path.Clean(filePath)), url.Values{"ref": {ref}}.Encode())
+
+	return fetchFile(u, g.authorize, nil)
+}
+
+// FetchArchive implements Fetcher using the repository archive API.
+func (g *GitLab) FetchArchive(fullName, ref string) (io.ReadCloser, error) {
+	u := fmt.Sprintf("%s/api/v4/projects/%s/repository/archive.tar.gz?%s",
+		hostOr(g.APIHost, DefaultGitLabAPIHost), url.QueryEscape(fullName),
+		url.Values{"sha": {ref}}.Encode())
+
+	return fetchArchive(u, g.authorize)
+}
+
+func (g *GitLab) authorize(req *http.Request) {
+	if g.Token != "" {
+		req.Header.Set("PRIVATE-TOKEN", g.Token)
+	}
+}
+
+// Bitbucket fetches repositories from Bitbucket Cloud. Files are fetched with
+// the API (v2.0), but archives are only available from the website.
+type Bitbucket struct {
+	APIHost string
+	Host    string
+	// Username and AppPassword are sent with requests if set.
+	Username    string
+	AppPassword string
+}
+
+// FetchFile implements Fetcher using the source API.
+func (b *Bitbucket) FetchFile(fullName, ref, filePath string) ([]byte, error) {
+	u := fmt.Sprintf("%s/2.0/repositories/%s/src/%s/%s",
+		hostOr(b.APIHost, DefaultBitbucketAPIHost), fullName, url.QueryEscape(ref),
+		escapePath(filePath))
+
+	return fetchFile(u, b.authorize, nil)
+}
+
+// FetchArchive implements Fetcher using the download link of the website.
+func (b *Bitbucket) FetchArchive(fullName, ref string) (io.ReadCloser, error) {
+	u := fmt.Sprintf("%s/%s/get/%s.tar.gz", hostOr(b.Host, DefaultBitbucketHost),
+		fullName, url.QueryEscape(ref))
+
+	return fetchArchive(u, b.authorize)
+}
+
+func (b *Bitbucket) authorize(req *http.Request) {
+	if b.Username != "" {
+		req.SetBasicAuth(b.Username, b.AppPassword)
+	}
+}
+
+func fetchFile(u string, authorize func(*http.Request), header http.Header) ([]byte, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	authorize(req)
+
+	cl := &http.Client{Timeout: FileTimeout}
+	res, err := cl.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, ErrFileNotFound
+	}
+
+	return ioutil.ReadAll(io.LimitReader(res.Body, maxFileSize))
+}
+
+func fetchArchive(u string, authorize func(*http.Request)) (io.ReadCloser, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	authorize(req)
+
+	cl := &http.Client{Timeout: ArchiveTimeout}
+	res, err := cl.Do(req)
+	if err != nil {
+		return nil, ErrArchiveUnavailable
+	}
+
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, ErrArchiveUnavailable
+	}
+
+	return res.Body, nil
+}
+
+func hostOr(host, defaultHost string) string {
+	if host == "" {
+		return defaultHost
+	}
+	return strings.TrimSuffix(host, "/")
+}
+
+// escapePath escapes each element of p for use in the path of a URL.
+func escapePath(p string) string {
+	return (&url.URL{Path: path.Clean(p)}).EscapedPath()
+}
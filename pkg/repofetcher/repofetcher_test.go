@@ -0,0 +1,192 @@
+package repofetcher_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/nitrous-io/rise-server/pkg/repofetcher"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "repofetcher")
+}
+
+var _ = Describe("repofetcher", func() {
+	var server *ghttp.Server
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	readArchive := func(f repofetcher.Fetcher, fullName, ref string) string {
+		rc, err := f.FetchArchive(fullName, ref)
+		Expect(err).To(BeNil())
+		defer rc.Close()
+
+		b, err := ioutil.ReadAll(rc)
+		Expect(err).To(BeNil())
+		return string(b)
+	}
+
+	Describe("GitHub", func() {
+		var f *repofetcher.GitHub
+
+		BeforeEach(func() {
+			f = &repofetcher.GitHub{APIHost: server.URL(), Token: "s3cr3t"}
+		})
+
+		Describe("FetchFile()", func() {
+			It("fetches the raw file with the contents API", func() {
+				server.AppendHandlers(ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/repos/PubStorm/pubstorm-www/contents/site/pubstorm.json", "ref=abc123"),
+					ghttp.VerifyHeader(http.Header{
+						"Accept":        {"application/vnd.github.v3.raw"},
+						"Authorization": {"token s3cr3t"},
+					}),
+					ghttp.RespondWith(http.StatusOK, `{"path": "build"}`),
+				))
+
+				b, err := f.FetchFile("PubStorm/pubstorm-www", "abc123", "site/pubstorm.json")
+				Expect(err).To(BeNil())
+				Expect(string(b)).To(Equal(`{"path": "build"}`))
+			})
+
+			It("returns ErrFileNotFound if the file does not exist", func() {
+				server.AppendHandlers(ghttp.RespondWith(http.StatusNotFound, ""))
+
+				_, err := f.FetchFile("PubStorm/pubstorm-www", "abc123", "pubstorm.json")
+				Expect(err).To(Equal(repofetcher.ErrFileNotFound))
+			})
+		})
+
+		Describe("FetchArchive()", func() {
+			It("follows the redirect to the tarball", func() {
+				server.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/repos/PubStorm/pubstorm-www/tarball/abc123"),
+						ghttp.RespondWith(http.StatusFound, "", http.Header{"Location": {server.URL() + "/PubStorm/pubstorm-www/legacy.tar.gz/abc123"}}),
+					),
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/PubStorm/pubstorm-www/legacy.tar.gz/abc123"),
+						ghttp.RespondWith(http.StatusOK, "tarball"),
+					),
+				)
+
+				Expect(readArchive(f, "PubStorm/pubstorm-www", "abc123")).To(Equal("tarball"))
+			})
+
+			It("returns ErrArchiveUnavailable if the archive cannot be downloaded", func() {
+				server.AppendHandlers(ghttp.RespondWith(http.StatusNotFound, ""))
+
+				_, err := f.FetchArchive("PubStorm/pubstorm-www", "abc123")
+				Expect(err).To(Equal(repofetcher.ErrArchiveUnavailable))
+			})
+		})
+	})
+
+	Describe("GitLab", func() {
+		var f *repofetcher.GitLab
+
+		BeforeEach(func() {
+			f = &repofetcher.GitLab{APIHost: server.URL(), Token: "s3cr3t"}
+		})
+
+		Describe("FetchFile()", func() {
+			It("fetches the raw file with the repository files API", func() {
+				server.AppendHandlers(ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/api/v4/projects/mike/diaspora/repository/files/site/pubstorm.json/raw", "ref=abc123"),
+					verifyEscapedPath("/api/v4/projects/mike%2Fdiaspora/repository/files/site%2Fpubstorm.json/raw"),
+					ghttp.VerifyHeader(http.Header{"Private-Token": {"s3cr3t"}}),
+					ghttp.RespondWith(http.StatusOK, `{"path": "build"}`),
+				))
+
+				b, err := f.FetchFile("mike/diaspora", "abc123", "site/pubstorm.json")
+				Expect(err).To(BeNil())
+				Expect(string(b)).To(Equal(`{"path": "build"}`))
+			})
+
+			It("returns ErrFileNotFound if the file does not exist", func() {
+				server.AppendHandlers(ghttp.RespondWith(http.StatusNotFound, ""))
+
+				_, err := f.FetchFile("mike/diaspora", "abc123", "pubstorm.json")
+				Expect(err).To(Equal(repofetcher.ErrFileNotFound))
+			})
+		})
+
+		Describe("FetchArchive()", func() {
+			It("downloads the tarball with the repository archive API", func() {
+				server.AppendHandlers(ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/api/v4/projects/mike/diaspora/repository/archive.tar.gz", "sha=abc123"),
+					verifyEscapedPath("/api/v4/projects/mike%2Fdiaspora/repository/archive.tar.gz"),
+					ghttp.VerifyHeader(http.Header{"Private-Token": {"s3cr3t"}}),
+					ghttp.RespondWith(http.StatusOK, "tarball"),
+				))
+
+				Expect(readArchive(f, "mike/diaspora", "abc123")).To(Equal("tarball"))
+			})
+		})
+	})
+
+	Describe("Bitbucket", func() {
+		var f *repofetcher.Bitbucket
+
+		BeforeEach(func() {
+			f = &repofetcher.Bitbucket{
+				APIHost:     server.URL(),
+				Host:        server.URL(),
+				Username:    "pubstorm",
+				AppPassword: "s3cr3t",
+			}
+		})
+
+		Describe("FetchFile()", func() {
+			It("fetches the raw file with the source API", func() {
+				server.AppendHandlers(ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/2.0/repositories/chuyeow/pubstorm-www/src/abc123/site/pubstorm.json"),
+					ghttp.VerifyBasicAuth("pubstorm", "s3cr3t"),
+					ghttp.RespondWith(http.StatusOK, `{"path": "build"}`),
+				))
+
+				b, err := f.FetchFile("chuyeow/pubstorm-www", "abc123", "site/pubstorm.json")
+				Expect(err).To(BeNil())
+				Expect(string(b)).To(Equal(`{"path": "build"}`))
+			})
+
+			It("returns ErrFileNotFound if the file does not exist", func() {
+				server.AppendHandlers(ghttp.RespondWith(http.StatusNotFound, ""))
+
+				_, err := f.FetchFile("chuyeow/pubstorm-www", "abc123", "pubstorm.json")
+				Expect(err).To(Equal(repofetcher.ErrFileNotFound))
+			})
+		})
+
+		Describe("FetchArchive()", func() {
+			It("downloads the tarball from the website", func() {
+				server.AppendHandlers(ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/chuyeow/pubstorm-www/get/abc123.tar.gz"),
+					ghttp.VerifyBasicAuth("pubstorm", "s3cr3t"),
+					ghttp.RespondWith(http.StatusOK, "tarball"),
+				))
+
+				Expect(readArchive(f, "chuyeow/pubstorm-www", "abc123")).To(Equal("tarball"))
+			})
+		})
+	})
+})
+
+// verifyEscapedPath verifies the path of a request as it was sent, as
+// ghttp.VerifyRequest only verifies the unescaped path.
+func verifyEscapedPath(p string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		Expect(req.URL.EscapedPath()).To(Equal(p))
+	}
+}
@@ -0,0 +1,67 @@
+// Package rootdir handles the root directory of a bundle, which is the
+// subdirectory of a bundle or repository that is built and deployed. It allows
+// deploying a static site that lives alongside other code, e.g. in a monorepo.
+package rootdir
+
+import (
+	"errors"
+	"path"
+	"strings"
+)
+
+// MaxLength is the maximum length of a root directory.
+const MaxLength = 255
+
+// ErrInvalid is returned by Clean for a root directory that is not a plain
+// relative path within the bundle.
+var ErrInvalid = errors.New("root directory is invalid")
+
+// Clean validates dir and returns it in a normalized form, without leading or
+// trailing slashes. An empty string is returned for the root of the bundle.
+// Directories that could refer to a path outside of the bundle (i.e. ones that
+// contain a ".." element) are rejected, as are backslashes and control
+// characters.
+func Clean(dir string) (string, error) {
+	if len(dir) > MaxLength {
+		return "", ErrInvalid
+	}
+
+	for _, r := range dir {
+		if r < 0x20 || r == 0x7f || r == '\\' {
+			return "", ErrInvalid
+		}
+	}
+
+	for _, el := range strings.Split(dir, "/") {
+		if el == ".." {
+			return "", ErrInvalid
+		}
+	}
+
+	cleaned := strings.Trim(path.Clean("/"+dir), "/")
+	return cleaned, nil
+}
+
+// Rel returns the path of the file with the given name relative to dir, which
+// must have been cleaned with Clean. The second return value is false if the
+// file is not in dir. If dir is empty, name is returned as is.
+func Rel(dir, name string) (string, bool) {
+	if dir == "" {
+		return name, true
+	}
+
+	name = strings.TrimLeft(path.Clean(name), "/")
+	if !strings.HasPrefix(name, dir+"/") {
+		return "", false
+	}
+	return name[len(dir)+1:], true
+}
+
+// ZipName returns the name of a file in a zip archive with forward slashes as
+// separators, as required by the zip spec. Zip archives created by some
+// Windows tools (e.g. older versions of PowerShell's Compress-Archive) use
+// backslashes instead, which would otherwise be treated as part of the names
+// of files.
+func ZipName(name string) string {
+	return strings.Replace(name, `\`, "/", -1)
+}
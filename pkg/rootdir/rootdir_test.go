@@ -0,0 +1,119 @@
+package rootdir_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nitrous-io/rise-server/pkg/rootdir"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "rootdir")
+}
+
+var _ = Describe("RootDir", func() {
+	Describe("Clean()", func() {
+		It("normalizes valid directories", func() {
+			for dir, expected := range map[string]string{
+				"":               "",
+				".":              "",
+				"/":              "",
+				"site":           "site",
+				"site/":          "site",
+				"/site":          "site",
+				"./site":         "site",
+				"apps/www/build": "apps/www/build",
+				"apps//www/./":   "apps/www",
+				"my site":        "my site",
+				"..site":         "..site",
+			} {
+				cleaned, err := rootdir.Clean(dir)
+				Expect(err).To(BeNil(), dir)
+				Expect(cleaned).To(Equal(expected), dir)
+			}
+		})
+
+		It("rejects directories that could be outside of the bundle", func() {
+			for _, dir := range []string{
+				"..",
+				"../site",
+				"site/..",
+				"site/../..",
+				"/../etc",
+				"site/../../etc",
+			} {
+				_, err := rootdir.Clean(dir)
+				Expect(err).To(Equal(rootdir.ErrInvalid), dir)
+			}
+		})
+
+		It("rejects directories with backslashes or control characters", func() {
+			for _, dir := range []string{
+				`..\site`,
+				`apps\www`,
+				"site\x00",
+				"site\n",
+			} {
+				_, err := rootdir.Clean(dir)
+				Expect(err).To(Equal(rootdir.ErrInvalid), dir)
+			}
+		})
+
+		It("rejects directories that are too long", func() {
+			_, err := rootdir.Clean(strings.Repeat("a", rootdir.MaxLength+1))
+			Expect(err).To(Equal(rootdir.ErrInvalid))
+		})
+	})
+
+	Describe("Rel()", func() {
+		It("returns the path relative to the directory for files in it", func() {
+			for name, expected := range map[string]string{
+				"site/index.html":       "index.html",
+				"./site/index.html":     "index.html",
+				"/site/css/app.css":     "css/app.css",
+				"site//images/logo.png": "images/logo.png",
+			} {
+				rel, ok := rootdir.Rel("site", name)
+				Expect(ok).To(BeTrue(), name)
+				Expect(rel).To(Equal(expected), name)
+			}
+		})
+
+		It("returns false for files that are not in the directory", func() {
+			for _, name := range []string{
+				"index.html",
+				"site",
+				"site-other/index.html",
+				"other/site/index.html",
+				"site/../index.html",
+			} {
+				_, ok := rootdir.Rel("site", name)
+				Expect(ok).To(BeFalse(), name)
+			}
+		})
+
+		It("returns the name as is if the directory is empty", func() {
+			rel, ok := rootdir.Rel("", "./site/index.html")
+			Expect(ok).To(BeTrue())
+			Expect(rel).To(Equal("./site/index.html"))
+		})
+	})
+
+	Describe("ZipName()", func() {
+		It("replaces backslashes with forward slashes", func() {
+			for name, expected := range map[string]string{
+				"index.html":           "index.html",
+				"css/app.css":          "css/app.css",
+				`css\app.css`:          "css/app.css",
+				`site\images\logo.png`: "site/images/logo.png",
+				`site\images\`:         "site/images/",
+			} {
+				Expect(rootdir.ZipName(name)).To(Equal(expected), name)
+			}
+		})
+	})
+})
@@ -0,0 +1,86 @@
+// Package safehttp provides HTTP clients for calling URLs that users control,
+// which must not be able to make PubStorm call its internal services.
+package safehttp
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ErrPrivateAddress is returned when a client refuses to connect to an address
+// that is not publicly routable.
+var ErrPrivateAddress = errors.New("address is not publicly routable")
+
+var privateNets []*net.IPNet
+
+func init() {
+	for _, cidr := range []string{
+		"0.0.0.0/8",
+		"10.0.0.0/8",
+		"100.64.0.0/10",
+		"127.0.0.0/8",
+		"169.254.0.0/16",
+		"172.16.0.0/12",
+		"192.0.0.0/24",
+		"192.168.0.0/16",
+		"198.18.0.0/15",
+		"::/128",
+		"::1/128",
+		// NAT64 prefixes, through which IPv4 addresses, including private
+		// ones, can be reached over IPv6.
+		"64:ff9b::/96",
+		"64:ff9b:1::/48",
+		"fc00::/7",
+		"fe80::/10",
+	} {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		privateNets = append(privateNets, n)
+	}
+}
+
+// NewClient returns an HTTP client that refuses to connect to addresses that
+// are not publicly routable, unless allowPrivateIPs is true, which is only
+// meant for tests. The address is checked after it is resolved, so that DNS
+// records pointing at private addresses, including those of redirects, are
+// refused too.
+func NewClient(timeout time.Duration, allowPrivateIPs bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Dial: func(network, addr string) (net.Conn, error) {
+				conn, err := dialer.Dial(network, addr)
+				if err != nil {
+					return nil, err
+				}
+
+				if !allowPrivateIPs {
+					if tcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr); !ok || IsPrivate(tcpAddr.IP) {
+						conn.Close()
+						return nil, ErrPrivateAddress
+					}
+				}
+
+				return conn, nil
+			},
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+	}
+}
+
+// IsPrivate returns whether ip is an unspecified, loopback, link-local,
+// private or otherwise reserved address that is not publicly routable.
+func IsPrivate(ip net.IP) bool {
+	for _, n := range privateNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package safehttp_test
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nitrous-io/rise-server/pkg/safehttp"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "safehttp")
+}
+
+var _ = Describe("safehttp", func() {
+	Describe("NewClient()", func() {
+		var s *httptest.Server
+
+		BeforeEach(func() {
+			s = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("internal"))
+			}))
+		})
+
+		AfterEach(func() {
+			s.Close()
+		})
+
+		It("refuses to connect to private addresses", func() {
+			_, err := safehttp.NewClient(time.Second, false).Get(s.URL)
+			Expect(err).NotTo(BeNil())
+			Expect(err.Error()).To(ContainSubstring(safehttp.ErrPrivateAddress.Error()))
+		})
+
+		It("connects to private addresses if they are allowed", func() {
+			res, err := safehttp.NewClient(time.Second, true).Get(s.URL)
+			Expect(err).To(BeNil())
+			defer res.Body.Close()
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+		})
+	})
+
+	Describe("IsPrivate()", func() {
+		It("returns whether the address is not publicly routable", func() {
+			for ip, expected := range map[string]bool{
+				"127.0.0.1":       true,
+				"10.1.2.3":        true,
+				"172.16.0.1":      true,
+				"192.168.1.1":     true,
+				"169.254.169.254": true,
+				"::1":             true,
+				"fd00::1":         true,
+				"100.64.0.1":      true,
+				"192.0.0.8":       true,
+				"198.18.0.1":      true,
+				"198.19.255.255":  true,
+				"::":              true,
+				"64:ff9b::a00:1":  true,
+				"64:ff9b:1::1":    true,
+				"0.0.0.0":         true,
+				"8.8.8.8":         false,
+				"52.1.2.3":        false,
+				"2001:4860::8888": false,
+			} {
+				Expect(safehttp.IsPrivate(net.ParseIP(ip))).To(Equal(expected), ip)
+			}
+		})
+	})
+})
@@ -6,6 +6,9 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/apiserver/models/jobrecord"
+	"github.com/nitrous-io/rise-server/pkg/job"
 	"github.com/nitrous-io/rise-server/pkg/mqconn"
 	"github.com/nitrous-io/rise-server/pushd/pushd"
 	"github.com/nitrous-io/rise-server/shared/queues"
@@ -20,6 +23,13 @@ func main() {
 }
 
 func run() {
+	db, err := dbconn.DB()
+	if err != nil {
+		log.Errorln("Failed to connect to db:", err)
+		return
+	}
+	job.DefaultRecorder = &jobrecord.Recorder{DB: db}
+
 	mq, err := mqconn.MQ()
 	if err != nil {
 		log.Errorln("Failed to connect to mq:", err)
@@ -78,6 +88,16 @@ func run() {
 	for {
 		select {
 		case d := <-msgCh:
+			if err := job.Verify(d); err != nil {
+				// Drop messages that were not enqueued by us.
+				log.WithFields(log.Fields{"queue": queueName}).Errorf("Rejected message, err: %v, message: %s", err, d.Body)
+				if err := d.Ack(false); err != nil {
+					log.WithFields(log.Fields{"queue": queueName}).Warnln("Failed to Ack message:", err)
+				}
+				continue
+			}
+
+			job.Started(d)
 			err := pushd.Work(d.Body)
 			if err != nil {
 				log.Warnf("pushd.Work failed, err: %v, message: %s", err, d.Body)
@@ -88,10 +108,12 @@ func run() {
 					pushd.ErrProjectConfigInvalidFormat,
 					pushd.ErrRecordNotFound:
 					// Acknowledge message so that we don't retry.
+					job.Finished(d, err, false)
 					if err := d.Ack(false); err != nil {
 						log.WithFields(log.Fields{"queue": queueName}).Warnln("Failed to Ack message:", err)
 					}
 				default:
+					job.Finished(d, err, true)
 					go func() {
 						// nack after a delay to prevent thrashing
 						time.Sleep(1 * time.Second)
@@ -101,6 +123,7 @@ func run() {
 					}()
 				}
 			} else {
+				job.Finished(d, nil, false)
 				if err := d.Ack(false); err != nil {
 					log.WithFields(log.Fields{"queue": queueName}).Warnln("Failed to Ack message:", err)
 				}
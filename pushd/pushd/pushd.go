@@ -8,13 +8,10 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"net/http"
-	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
-	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/jinzhu/gorm"
@@ -24,9 +21,13 @@ import (
 	"github.com/nitrous-io/rise-server/apiserver/models/project"
 	"github.com/nitrous-io/rise-server/apiserver/models/push"
 	"github.com/nitrous-io/rise-server/apiserver/models/repo"
+	"github.com/nitrous-io/rise-server/pkg/bitbucketapi"
 	"github.com/nitrous-io/rise-server/pkg/filetransfer"
 	"github.com/nitrous-io/rise-server/pkg/githubapi"
+	"github.com/nitrous-io/rise-server/pkg/gitlabapi"
 	"github.com/nitrous-io/rise-server/pkg/job"
+	"github.com/nitrous-io/rise-server/pkg/repofetcher"
+	"github.com/nitrous-io/rise-server/pkg/rootdir"
 	"github.com/nitrous-io/rise-server/shared/messages"
 	"github.com/nitrous-io/rise-server/shared/queues"
 	"github.com/nitrous-io/rise-server/shared/s3client"
@@ -36,9 +37,9 @@ var (
 	S3 filetransfer.FileTransfer = filetransfer.NewS3(s3client.PartSize, s3client.MaxUploadParts)
 
 	ErrUnexpectedDeploymentState  = errors.New("deployment is in an unexpected state")
-	ErrProjectConfigNotFound      = errors.New("GitHub Contents API response not HTTP 200")
+	ErrProjectConfigNotFound      = errors.New("pubstorm.json file not found in repository")
 	ErrProjectConfigInvalidFormat = errors.New("pubstorm.json file invalid")
-	ErrGitHubArchiveProblem       = errors.New("could not download archive of repository from GitHub")
+	ErrArchiveProblem             = errors.New("could not download archive of repository")
 	ErrRecordNotFound             = errors.New("project or deployment is deleted")
 )
 
@@ -83,16 +84,19 @@ func Work(data []byte) error {
 		return err
 	}
 
-	pl := &githubapi.PushPayload{}
-	if err := json.Unmarshal([]byte(pu.Payload), pl); err != nil {
+	fetcher, fullName, err := pushSource(rp, pu)
+	if err != nil {
 		return err
 	}
 
-	projPath, err := fetchProjectPath(pl)
+	projPath, err := fetchProjectPath(fetcher, fullName, pu.Ref, rp.RootDir)
 	if err != nil {
 		switch err {
 		case ErrProjectConfigNotFound:
-			m := "Your GitHub repository does not contain a pubstorm.json file, aborting. Please check in the pubstorm.json file in the root of your repository."
+			m := fmt.Sprintf("Your %s repository does not contain a pubstorm.json file, aborting. Please check in the pubstorm.json file in the root of your repository.", rp.ProviderName())
+			if rp.RootDir != "" {
+				m = fmt.Sprintf("Your %s repository does not contain a pubstorm.json file in %q, aborting. Please check in the pubstorm.json file in the root directory of your project.", rp.ProviderName(), rp.RootDir)
+			}
 			depl.ErrorMessage = &m
 			if err := depl.UpdateState(db, deployment.StateDeployFailed); err != nil {
 				fmt.Printf("Failed to update deployment state for deployment ID %d due to %v", depl.ID, err)
@@ -108,21 +112,17 @@ func Work(data []byte) error {
 		return err
 	}
 
-	// E.g. https://api.github.com/repos/PubStorm/pubstorm-www/{archive_format}{/ref}
-	archiveURL := strings.Replace(pl.Repository.ArchiveURL, "{archive_format}", "tarball", 1)
-	archiveURL = strings.Replace(archiveURL, "{/ref}", "/"+pl.After, 1)
-
-	tmpDir, err := ioutil.TempDir("", "github-archive-"+depl.PrefixID())
+	tmpDir, err := ioutil.TempDir("", "repo-archive-"+depl.PrefixID())
 	if err != nil {
 		return err
 	}
 	defer os.RemoveAll(tmpDir)
 
-	if err := fetchAndUnpackArchive(archiveURL, tmpDir, projPath); err != nil {
+	if err := fetchAndUnpackArchive(fetcher, fullName, pu.Ref, tmpDir, path.Join(rp.RootDir, projPath)); err != nil {
 		return err
 	}
 
-	tarball, err := ioutil.TempFile("", "github-archive-raw-bundle")
+	tarball, err := ioutil.TempFile("", "repo-archive-raw-bundle")
 	if err != nil {
 		return err
 	}
@@ -138,7 +138,7 @@ func Work(data []byte) error {
 	}
 
 	var j *job.Job
-	if proj.SkipBuild {
+	if !proj.NeedsBuild() {
 		j, err = job.NewWithJSON(queues.Deploy, &messages.DeployJobData{
 			DeploymentID: depl.ID,
 			UseRawBundle: true,
@@ -157,47 +157,80 @@ func Work(data []byte) error {
 	}
 
 	newState := deployment.StatePendingBuild
-	if proj.SkipBuild {
+	if !proj.NeedsBuild() {
 		newState = deployment.StatePendingDeploy
 	}
 
 	return depl.UpdateState(db, newState)
 }
 
-// fetchProjectPath downloads the pubstorm.json file from root dir of repository
-// to determine the project path.
-func fetchProjectPath(pl *githubapi.PushPayload) (string, error) {
-	qs := url.Values{}
-	qs.Add("ref", pl.After)
-	cfgURL := fmt.Sprintf("%s/repos/%s/contents/pubstorm.json?%s",
-		common.GitHubAPIHost, pl.Repository.FullName, qs.Encode())
-	req, err := http.NewRequest("GET", cfgURL, nil)
-	req.Header.Set("Accept", "application/vnd.github.v3.raw")
-	if common.GitHubAPIToken != "" {
-		req.Header.Set("Authorization", "token "+common.GitHubAPIToken)
+// pushSource returns the fetcher of the provider that a repo is hosted on and
+// the full name of the repo, e.g. "PubStorm/pubstorm-www", which is taken from
+// the payload of the push.
+func pushSource(rp *repo.Repo, pu *push.Push) (repofetcher.Fetcher, string, error) {
+	switch rp.Provider {
+	case repo.ProviderGitLab:
+		pl := &gitlabapi.PushPayload{}
+		if err := json.Unmarshal([]byte(pu.Payload), pl); err != nil {
+			return nil, "", err
+		}
+		return &repofetcher.GitLab{
+			APIHost: common.GitLabAPIHost,
+			Token:   common.GitLabAPIToken,
+		}, pl.Project.PathWithNamespace, nil
+	case repo.ProviderBitbucket:
+		pl := &bitbucketapi.PushPayload{}
+		if err := json.Unmarshal([]byte(pu.Payload), pl); err != nil {
+			return nil, "", err
+		}
+		return &repofetcher.Bitbucket{
+			APIHost:     common.BitbucketAPIHost,
+			Host:        common.BitbucketHost,
+			Username:    common.BitbucketUsername,
+			AppPassword: common.BitbucketAppPassword,
+		}, pl.Repository.FullName, nil
+	default:
+		pl := &githubapi.PushPayload{}
+		if err := json.Unmarshal([]byte(pu.Payload), pl); err != nil {
+			return nil, "", err
+		}
+		return &repofetcher.GitHub{
+			APIHost: common.GitHubAPIHost,
+			Token:   common.GitHubAPIToken,
+		}, pl.Repository.FullName, nil
 	}
-	cl := &http.Client{Timeout: 2 * time.Second}
-	res, err := cl.Do(req)
+}
+
+// fetchProjectPath downloads the pubstorm.json file from the given root dir of
+// the repository to determine the project path, which is relative to rootDir.
+func fetchProjectPath(fetcher repofetcher.Fetcher, fullName, ref, rootDir string) (string, error) {
+	b, err := fetcher.FetchFile(fullName, ref, path.Join(rootDir, "pubstorm.json"))
 	if err != nil {
+		if err == repofetcher.ErrFileNotFound {
+			return "", ErrProjectConfigNotFound
+		}
 		return "", err
 	}
-	defer res.Body.Close()
 
-	if res.StatusCode != http.StatusOK {
-		return "", ErrProjectConfigNotFound
-	}
 	var j struct {
 		Path string `json:"path"`
 	}
-	if err := json.NewDecoder(res.Body).Decode(&j); err != nil {
+	if err := json.Unmarshal(b, &j); err != nil {
+		return "", ErrProjectConfigInvalidFormat
+	}
+
+	// The project path must not point outside of the root dir.
+	projPath, err := rootdir.Clean(j.Path)
+	if err != nil {
 		return "", ErrProjectConfigInvalidFormat
 	}
 
-	return j.Path, nil
+	return projPath, nil
 }
 
-// fetchAndUnpackArchive downloads the gzipped tarball from the given URL and
-// unpacks only the given subdirectory to the root of the dst directory.
+// fetchAndUnpackArchive downloads a gzipped tarball of the repository at the
+// given ref and unpacks only the given subdirectory to the root of the dst
+// directory.
 //
 // We could optimize the download by performing a sparse checkout, so that we
 // only fetch the contents of the directory instead of the entire repo:
@@ -206,24 +239,15 @@ func fetchProjectPath(pl *githubapi.PushPayload) (string, error) {
 //   3. git config --local core.sparseCheckout true
 //   4. echo build/ >> .git/info/sparse-checkout
 //   5. git pull origin master
-func fetchAndUnpackArchive(url, dst, subdir string) error {
-	cl := &http.Client{Timeout: 10 * time.Second}
-	req, err := http.NewRequest("GET", url, nil)
-	if common.GitHubAPIToken != "" {
-		req.Header.Set("Authorization", "token "+common.GitHubAPIToken)
-	}
-	res, err := cl.Do(req)
+func fetchAndUnpackArchive(fetcher repofetcher.Fetcher, fullName, ref, dst, subdir string) error {
+	archive, err := fetcher.FetchArchive(fullName, ref)
 	if err != nil {
-		log.Errorf("error downloading archive of repo from GitHub, err: %v", err)
-		return ErrGitHubArchiveProblem
-	}
-	defer res.Body.Close()
-
-	if res.StatusCode != http.StatusOK {
-		return ErrGitHubArchiveProblem
+		log.Errorf("error downloading archive of repo, err: %v", err)
+		return ErrArchiveProblem
 	}
+	defer archive.Close()
 
-	gr, err := gzip.NewReader(res.Body)
+	gr, err := gzip.NewReader(archive)
 	if err != nil {
 		return err
 	}
@@ -246,7 +270,7 @@ func fetchAndUnpackArchive(url, dst, subdir string) error {
 		fileName := path.Clean(hdr.Name)
 
 		// Strip away top-level directory.
-		// GitHub archives the actual repo contents in a top-level directory, e.g.
+		// Providers archive the actual repo contents in a top-level directory, e.g.
 		//   - chuyeow-chuyeow.github.io-56cead1/index.html
 		//   - chuyeow-chuyeow.github.io-56cead1/pubstorm.json
 		splits := strings.SplitN(fileName, string(os.PathSeparator), 2)
@@ -29,7 +29,6 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/onsi/gomega/ghttp"
-	"github.com/streadway/amqp"
 )
 
 func Test(t *testing.T) {
@@ -41,7 +40,7 @@ var _ = Describe("Pushd", func() {
 	var (
 		err error
 		db  *gorm.DB
-		mq  *amqp.Connection
+		mq  mqconn.Conn
 
 		proj *project.Project
 		depl *deployment.Deployment
@@ -230,6 +229,163 @@ var _ = Describe("Pushd", func() {
 		})
 	})
 
+	Context("when the repository is hosted on GitLab", func() {
+		var (
+			gitlabAPIServer   *ghttp.Server
+			origGitLabAPIHost string
+		)
+
+		BeforeEach(func() {
+			gitlabAPIServer = ghttp.NewServer()
+			gitlabAPIServer.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/api/v4/projects/mike/diaspora/repository/files/pubstorm.json/raw", "ref=deafcafe1e9e5ae2ff1314666e366cbc7260dc"),
+					ghttp.RespondWithPtr(&contentsStatusCode, &contentsBody),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/api/v4/projects/mike/diaspora/repository/archive.tar.gz", "sha=deafcafe1e9e5ae2ff1314666e366cbc7260dc"),
+					ghttp.RespondWithPtr(&archiveStatusCode, &archiveBody, http.Header{"Content-Type": {"application/x-gzip"}}),
+				),
+			)
+
+			origGitLabAPIHost = common.GitLabAPIHost
+			common.GitLabAPIHost = gitlabAPIServer.URL()
+
+			rp.Provider = repo.ProviderGitLab
+			Expect(db.Save(rp).Error).To(BeNil())
+			pu.Payload = `{
+				"object_kind": "push",
+				"ref": "refs/heads/release",
+				"checkout_sha": "deafcafe1e9e5ae2ff1314666e366cbc7260dc",
+				"project": {
+					"path_with_namespace": "mike/diaspora"
+				}
+			}`
+			Expect(db.Save(pu).Error).To(BeNil())
+		})
+
+		AfterEach(func() {
+			common.GitLabAPIHost = origGitLabAPIHost
+			gitlabAPIServer.Close()
+		})
+
+		It("downloads the repository archive from GitLab and uploads the files in the project path to S3", func() {
+			err := pushd.Work([]byte(fmt.Sprintf(`{
+				"push_id": %d
+			}`, pu.ID)))
+			Expect(err).To(BeNil())
+
+			Expect(gitlabAPIServer.ReceivedRequests()).To(HaveLen(2))
+			Expect(githubAPIServer.ReceivedRequests()).To(HaveLen(0))
+
+			Expect(fakeS3.UploadCalls.Count()).To(Equal(1))
+			uploadCall := fakeS3.UploadCalls.NthCall(1)
+			Expect(uploadCall.Arguments[2]).To(Equal(fmt.Sprintf("deployments/%s/raw-bundle.tar.gz", depl.PrefixID())))
+
+			d := testhelper.ConsumeQueue(mq, queues.Build)
+			Expect(d).NotTo(BeNil())
+		})
+
+		Context("when the repository does not contain a pubstorm.json", func() {
+			BeforeEach(func() {
+				contentsStatusCode = http.StatusNotFound
+			})
+
+			It("marks the deployment as failed with a message that mentions GitLab", func() {
+				err := pushd.Work([]byte(fmt.Sprintf(`{
+					"push_id": %d
+				}`, pu.ID)))
+				Expect(err).To(Equal(pushd.ErrProjectConfigNotFound))
+
+				Expect(db.First(depl, depl.ID).Error).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StateDeployFailed))
+				Expect(*depl.ErrorMessage).To(Equal("Your GitLab repository does not contain a pubstorm.json file, aborting. Please check in the pubstorm.json file in the root of your repository."))
+			})
+		})
+	})
+
+	Context("when the repository has a root directory", func() {
+		BeforeEach(func() {
+			rp.RootDir = "build"
+			Expect(db.Save(rp).Error).To(BeNil())
+
+			contentsBody = `{ "name": "", "path": "css" }`
+			githubAPIServer.SetHandler(0, ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", "/repos/chuyeow/chuyeow.github.io/contents/build/pubstorm.json", "ref=deafcafe1e9e5ae2ff1314666e366cbc7260dc"),
+				ghttp.RespondWithPtr(&contentsStatusCode, &contentsBody),
+			))
+		})
+
+		It("uses the pubstorm.json file in the root directory and uploads only the files in the project path relative to it", func() {
+			err := pushd.Work([]byte(fmt.Sprintf(`{
+				"push_id": %d
+			}`, pu.ID)))
+			Expect(err).To(BeNil())
+
+			Expect(githubAPIServer.ReceivedRequests()).To(HaveLen(3))
+
+			uploadCall := fakeS3.UploadCalls.NthCall(1)
+			Expect(uploadCall).NotTo(BeNil())
+
+			filenames := []string{}
+			uploadedContent, ok := uploadCall.SideEffects["uploaded_content"].([]byte)
+			Expect(ok).To(BeTrue())
+			gr, err := gzip.NewReader(bytes.NewBuffer(uploadedContent))
+			Expect(err).To(BeNil())
+			defer gr.Close()
+			tr := tar.NewReader(gr)
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				Expect(err).To(BeNil())
+
+				filenames = append(filenames, hdr.Name)
+			}
+
+			Expect(filenames).To(ConsistOf("app.css"))
+		})
+
+		Context("when the project path in pubstorm.json is outside of the root directory", func() {
+			BeforeEach(func() {
+				contentsBody = `{ "name": "", "path": "../" }`
+			})
+
+			It("returns an error without uploading anything", func() {
+				err := pushd.Work([]byte(fmt.Sprintf(`{
+					"push_id": %d
+				}`, pu.ID)))
+				Expect(err).To(Equal(pushd.ErrProjectConfigInvalidFormat))
+
+				Expect(fakeS3.UploadCalls.Count()).To(Equal(0))
+
+				err = db.First(depl, pu.DeploymentID).Error
+				Expect(err).To(BeNil())
+				Expect(depl.State).To(Equal(deployment.StateDeployFailed))
+			})
+		})
+
+		Context("when the root directory does not contain a pubstorm.json", func() {
+			BeforeEach(func() {
+				contentsStatusCode = http.StatusNotFound
+			})
+
+			It("returns an error", func() {
+				err := pushd.Work([]byte(fmt.Sprintf(`{
+					"push_id": %d
+				}`, pu.ID)))
+				Expect(err).To(Equal(pushd.ErrProjectConfigNotFound))
+
+				err = db.First(depl, pu.DeploymentID).Error
+				Expect(err).To(BeNil())
+
+				Expect(*depl.ErrorMessage).To(Equal(`Your GitHub repository does not contain a pubstorm.json file in "build", aborting. Please check in the pubstorm.json file in the root directory of your project.`))
+				Expect(depl.State).To(Equal(deployment.StateDeployFailed))
+			})
+		})
+	})
+
 	Context("when the repository does not contain a pubstorm.json", func() {
 		BeforeEach(func() {
 			contentsStatusCode = http.StatusNotFound
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/nitrous-io/rise-server/apiserver/dbconn"
+	"github.com/nitrous-io/rise-server/scheduler/scheduler"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// checkInterval is how often a replica tries to become the leader, and how
+// often the leader checks for tasks that are due.
+const checkInterval = 10 * time.Second
+
+func main() {
+	run()
+	os.Exit(1)
+}
+
+func run() {
+	db, err := dbconn.DB()
+	if err != nil {
+		log.Errorln("Failed to connect to db:", err)
+		return
+	}
+
+	s := scheduler.New(&scheduler.AdvisoryLockElector{
+		DB:  db.DB(),
+		Key: scheduler.LockKey,
+	}, scheduler.Tasks(db)...)
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+
+	stop := make(chan struct{})
+	go func() {
+		sig := <-sigCh
+		log.Errorln("Caught signal:", sig)
+		close(stop)
+	}()
+
+	log.Infof("Scheduler started with %d tasks...", len(s.Tasks))
+
+	s.Run(checkInterval, stop)
+}
@@ -0,0 +1,216 @@
+package scheduler
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+// LockKey is the key of the Postgres advisory lock that is held by the leader
+// among scheduler replicas.
+const LockKey int64 = 0x72697365 // "rise"
+
+// Task is a periodic maintenance task.
+type Task struct {
+	Name string
+
+	// Interval is how often the task is run. Tasks are run at multiples of
+	// the interval since the Unix epoch (e.g. hourly tasks are run on the
+	// hour) rather than relative to when the scheduler started, so that
+	// restarting the scheduler or a change of leader does not cause tasks to
+	// be run more often than they should.
+	Interval time.Duration
+
+	// Run runs the task. ctx is cancelled if the scheduler stops being the
+	// leader while the task is running.
+	Run func(ctx context.Context) error
+}
+
+// Elector elects a leader among scheduler replicas.
+type Elector interface {
+	// Elect tries to become the leader, or checks that leadership has not
+	// been lost if it is already the leader, and returns whether it is the
+	// leader.
+	Elect(ctx context.Context) (bool, error)
+	// Resign gives up leadership.
+	Resign() error
+}
+
+// AdvisoryLockElector is an Elector that elects the replica that holds a
+// Postgres advisory lock. The lock is held for as long as the connection that
+// acquired it is open, so leadership is lost if the connection is lost.
+type AdvisoryLockElector struct {
+	DB  *sql.DB
+	Key int64
+
+	conn *sql.Conn
+}
+
+// Elect implements Elector.
+func (e *AdvisoryLockElector) Elect(ctx context.Context) (bool, error) {
+	if e.conn != nil {
+		if _, err := e.conn.ExecContext(ctx, "SELECT 1"); err != nil {
+			e.conn.Close()
+			e.conn = nil
+			return false, err
+		}
+		return true, nil
+	}
+
+	conn, err := e.DB.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", e.Key).Scan(&acquired); err != nil {
+		conn.Close()
+		return false, err
+	}
+	if !acquired {
+		conn.Close()
+		return false, nil
+	}
+
+	e.conn = conn
+	return true, nil
+}
+
+// Resign implements Elector.
+func (e *AdvisoryLockElector) Resign() error {
+	if e.conn == nil {
+		return nil
+	}
+
+	_, err := e.conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", e.Key)
+	e.conn.Close()
+	e.conn = nil
+	return err
+}
+
+// Scheduler runs tasks while it is the leader among scheduler replicas, so
+// that it is safe to run multiple replicas.
+type Scheduler struct {
+	Tasks   []*Task
+	Elector Elector
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	lastRun map[string]time.Time
+
+	mu      sync.Mutex
+	running map[string]bool
+	wg      sync.WaitGroup
+}
+
+// New returns a Scheduler that runs tasks while elector elects it as the
+// leader.
+func New(elector Elector, tasks ...*Task) *Scheduler {
+	return &Scheduler{
+		Tasks:   tasks,
+		Elector: elector,
+		lastRun: map[string]time.Time{},
+		running: map[string]bool{},
+	}
+}
+
+// Run calls Tick every checkInterval until stop is closed, and then waits for
+// running tasks to finish.
+func (s *Scheduler) Run(checkInterval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		s.Tick(time.Now())
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			s.stepDown()
+			if err := s.Elector.Resign(); err != nil {
+				log.Warnf("failed to resign leadership, err: %v", err)
+			}
+			s.wg.Wait()
+			return
+		}
+	}
+}
+
+// Tick holds an election and, if the scheduler is the leader, starts the
+// tasks that are due at now. A task is skipped if its previous run has not
+// finished yet.
+func (s *Scheduler) Tick(now time.Time) {
+	leader, err := s.Elector.Elect(context.Background())
+	if err != nil {
+		log.Warnf("failed to hold leader election, err: %v", err)
+	}
+
+	if !leader {
+		if s.cancel != nil {
+			log.Warnln("Lost leadership, cancelling running tasks")
+			s.stepDown()
+		}
+		return
+	}
+
+	if s.cancel == nil {
+		log.Infoln("Became the leader")
+		s.ctx, s.cancel = context.WithCancel(context.Background())
+
+		// Wait for the next run of each task rather than running every task
+		// as soon as a replica becomes the leader.
+		for _, t := range s.Tasks {
+			s.lastRun[t.Name] = now.Truncate(t.Interval)
+		}
+	}
+
+	for _, t := range s.Tasks {
+		due := now.Truncate(t.Interval)
+		if !due.After(s.lastRun[t.Name]) {
+			continue
+		}
+		s.lastRun[t.Name] = due
+
+		s.mu.Lock()
+		if s.running[t.Name] {
+			s.mu.Unlock()
+			log.WithField("task", t.Name).Warnln("Skipping task as its previous run has not finished")
+			continue
+		}
+		s.running[t.Name] = true
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.run(s.ctx, t)
+	}
+}
+
+func (s *Scheduler) run(ctx context.Context, t *Task) {
+	defer func() {
+		s.mu.Lock()
+		s.running[t.Name] = false
+		s.mu.Unlock()
+		s.wg.Done()
+	}()
+
+	fields := log.Fields{"task": t.Name}
+	log.WithFields(fields).WithField("event", "start").Infoln("Running task")
+
+	started := time.Now()
+	if err := t.Run(ctx); err != nil {
+		log.WithFields(fields).Errorf("task failed, err: %v", err)
+		return
+	}
+
+	log.WithFields(fields).WithField("event", "completed").Infof("Completed task in %v", time.Since(started))
+}
+
+func (s *Scheduler) stepDown() {
+	if s.cancel != nil {
+		s.cancel()
+		s.cancel = nil
+	}
+}
@@ -0,0 +1,168 @@
+package scheduler_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nitrous-io/rise-server/scheduler/scheduler"
+	"golang.org/x/net/context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "scheduler")
+}
+
+type fakeElector struct {
+	mu       sync.Mutex
+	leader   bool
+	err      error
+	resigned bool
+}
+
+func (e *fakeElector) Elect(ctx context.Context) (bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leader, e.err
+}
+
+func (e *fakeElector) Resign() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.leader = false
+	e.resigned = true
+	return nil
+}
+
+func (e *fakeElector) setLeader(leader bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.leader = leader
+}
+
+var _ = Describe("Scheduler", func() {
+	var (
+		elector *fakeElector
+		s       *scheduler.Scheduler
+		runs    chan string
+		block   chan struct{}
+		start   time.Time
+	)
+
+	BeforeEach(func() {
+		elector = &fakeElector{leader: true}
+		runs = make(chan string, 10)
+		block = nil
+		start = time.Date(2016, 6, 1, 10, 0, 30, 0, time.UTC)
+
+		s = scheduler.New(elector, &scheduler.Task{
+			Name:     "hourly",
+			Interval: time.Hour,
+			Run: func(ctx context.Context) error {
+				runs <- "hourly"
+				if block != nil {
+					select {
+					case <-block:
+					case <-ctx.Done():
+						runs <- "hourly cancelled"
+					}
+				}
+				return nil
+			},
+		}, &scheduler.Task{
+			Name:     "minutely",
+			Interval: time.Minute,
+			Run: func(ctx context.Context) error {
+				runs <- "minutely"
+				return errors.New("failed")
+			},
+		})
+	})
+
+	It("waits for the next run of each task after becoming the leader", func() {
+		s.Tick(start)
+		Consistently(runs).ShouldNot(Receive())
+
+		s.Tick(start.Add(10 * time.Second))
+		Consistently(runs).ShouldNot(Receive())
+	})
+
+	It("runs tasks when they are due", func() {
+		s.Tick(start)
+
+		s.Tick(start.Add(30 * time.Second))
+		Eventually(runs).Should(Receive(Equal("minutely")))
+		Consistently(runs).ShouldNot(Receive())
+
+		s.Tick(start.Add(40 * time.Second))
+		Consistently(runs).ShouldNot(Receive())
+
+		s.Tick(start.Add(time.Hour))
+		Eventually(runs).Should(Receive())
+		Eventually(runs).Should(Receive())
+		Consistently(runs).ShouldNot(Receive())
+	})
+
+	It("does not run tasks if it is not the leader", func() {
+		elector.setLeader(false)
+
+		s.Tick(start)
+		s.Tick(start.Add(time.Hour))
+		Consistently(runs).ShouldNot(Receive())
+	})
+
+	It("does not run tasks if the election fails", func() {
+		elector.setLeader(false)
+		elector.err = errors.New("connection refused")
+
+		s.Tick(start)
+		s.Tick(start.Add(time.Hour))
+		Consistently(runs).ShouldNot(Receive())
+	})
+
+	It("skips a task if its previous run has not finished", func() {
+		block = make(chan struct{})
+		defer close(block)
+
+		s.Tick(start)
+		s.Tick(start.Add(time.Hour))
+		Eventually(runs).Should(Receive(Equal("hourly")))
+
+		s.Tick(start.Add(2 * time.Hour))
+		Eventually(runs).Should(Receive(Equal("minutely")))
+		Consistently(runs).ShouldNot(Receive(Equal("hourly")))
+	})
+
+	It("cancels running tasks when it loses leadership", func() {
+		block = make(chan struct{})
+		defer close(block)
+
+		s.Tick(start)
+		s.Tick(start.Add(time.Hour))
+		Eventually(runs).Should(Receive(Equal("hourly")))
+
+		elector.setLeader(false)
+		s.Tick(start.Add(time.Hour + 10*time.Second))
+		Eventually(runs).Should(Receive(Equal("hourly cancelled")))
+	})
+
+	Describe("Run()", func() {
+		It("resigns leadership when it is stopped", func() {
+			stop := make(chan struct{})
+			done := make(chan struct{})
+			go func() {
+				s.Run(time.Millisecond, stop)
+				close(done)
+			}()
+
+			close(stop)
+			Eventually(done).Should(BeClosed())
+			Expect(elector.resigned).To(BeTrue())
+		})
+	})
+})
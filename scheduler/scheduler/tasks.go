@@ -0,0 +1,212 @@
+package scheduler
+
+import (
+	"os"
+	"os/exec"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/models/acmecert"
+	"github.com/nitrous-io/rise-server/apiserver/models/apirequest"
+	"github.com/nitrous-io/rise-server/apiserver/models/deployment"
+	"github.com/nitrous-io/rise-server/apiserver/models/devicecode"
+	"github.com/nitrous-io/rise-server/apiserver/models/oauthtoken"
+	"github.com/nitrous-io/rise-server/apiserver/models/project"
+	"github.com/nitrous-io/rise-server/apiserver/models/releasedname"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+	"golang.org/x/net/context"
+)
+
+// AbandonedUploadAge is how long a deployment can be pending upload before
+// it is deleted.
+const AbandonedUploadAge = 24 * time.Hour
+
+// APIRequestRetention is how long the API requests that are recorded when
+// common.RequestAuditSink is "db" are kept.
+const APIRequestRetention = 90 * 24 * time.Hour
+
+// Tasks returns the maintenance tasks run by the scheduler.
+func Tasks(db *gorm.DB) []*Task {
+	return []*Task{
+		{
+			Name:     "renew-acme-certs",
+			Interval: 24 * time.Hour,
+			Run:      Command("acmerenewal"),
+		},
+		{
+			// Deletes the Let's Encrypt certs of deleted domains, so that
+			// they are not renewed.
+			Name:     "delete-orphaned-acme-certs",
+			Interval: time.Hour,
+			Run: func(ctx context.Context) error {
+				n, err := acmecert.DeleteOrphaned(db)
+				if err != nil {
+					return err
+				}
+				log.WithField("task", "delete-orphaned-acme-certs").Infof("Deleted %d Let's Encrypt certs of deleted domains", n)
+				return nil
+			},
+		},
+		{
+			// Deletes deployments beyond the MaxDeploysKept of their
+			// projects, including failed ones, which deploys do not prune.
+			Name:     "prune-old-deploys",
+			Interval: 24 * time.Hour,
+			Run: func(ctx context.Context) error {
+				n, err := deployment.Prune(db)
+				if err != nil {
+					return err
+				}
+				log.WithField("task", "prune-old-deploys").Infof("Deleted %d deployments beyond the number of deployments kept by their projects", n)
+				return nil
+			},
+		},
+		{
+			// Deletes the names of deleted projects whose cooldown has
+			// ended, so that anyone can use them again.
+			Name:     "delete-expired-released-names",
+			Interval: 24 * time.Hour,
+			Run: func(ctx context.Context) error {
+				n, err := releasedname.DeleteExpired(db)
+				if err != nil {
+					return err
+				}
+				log.WithField("task", "delete-expired-released-names").Infof("Deleted %d released project names whose cooldown has ended", n)
+				return nil
+			},
+		},
+		{
+			// Activates pending domains whose DNS records point to PubStorm,
+			// and disables active domains whose DNS records have not for
+			// shared.DomainDisableDays.
+			Name:     "verify-domains",
+			Interval: time.Hour,
+			Run:      Command("verifydomains"),
+		},
+		{
+			// Degrades domains of projects with the degrade bandwidth
+			// exceeded mode once they go over their hard bandwidth quota,
+			// and restores them once the quota resets at midnight UTC.
+			Name:     "enforce-quotas",
+			Interval: 5 * time.Minute,
+			Run:      Command("enforcequotas"),
+		},
+		{
+			// Purges the files of deployments that were deleted, either by
+			// users or because the project keeps only the last
+			// MaxDeploysKept deployments.
+			Name:     "purge-deleted-deploys",
+			Interval: time.Hour,
+			Run:      Command("purgedeploys"),
+		},
+		{
+			// Deletes files in S3 that no deployment or domain refers to,
+			// e.g. ones left behind by jobs that crashed.
+			Name:     "gc-storage",
+			Interval: 24 * time.Hour,
+			Run:      Command("gcstorage"),
+		},
+		{
+			Name:     "clear-expired-password-reset-tokens",
+			Interval: time.Hour,
+			Run: func(ctx context.Context) error {
+				n, err := user.ClearExpiredPasswordResetTokens(db)
+				if err != nil {
+					return err
+				}
+				log.WithField("task", "clear-expired-password-reset-tokens").Infof("Cleared %d expired password reset tokens", n)
+				return nil
+			},
+		},
+		{
+			Name:     "delete-expired-device-codes",
+			Interval: time.Hour,
+			Run: func(ctx context.Context) error {
+				n, err := devicecode.DeleteExpired(db)
+				if err != nil {
+					return err
+				}
+				log.WithField("task", "delete-expired-device-codes").Infof("Deleted %d expired device codes", n)
+				return nil
+			},
+		},
+		{
+			Name:     "delete-expired-deploy-tokens",
+			Interval: time.Hour,
+			Run: func(ctx context.Context) error {
+				n, err := oauthtoken.DeleteExpired(db)
+				if err != nil {
+					return err
+				}
+				log.WithField("task", "delete-expired-deploy-tokens").Infof("Deleted %d expired deploy tokens", n)
+				return nil
+			},
+		},
+		{
+			Name:     "delete-abandoned-uploads",
+			Interval: time.Hour,
+			Run: func(ctx context.Context) error {
+				n, err := deployment.DeleteAbandonedUploads(db, time.Now().Add(-AbandonedUploadAge))
+				if err != nil {
+					return err
+				}
+				log.WithField("task", "delete-abandoned-uploads").Infof("Deleted %d deployments that were never uploaded", n)
+				return nil
+			},
+		},
+		{
+			Name:     "delete-old-api-requests",
+			Interval: 24 * time.Hour,
+			Run: func(ctx context.Context) error {
+				n, err := apirequest.DeleteOlderThan(db, time.Now().Add(-APIRequestRetention))
+				if err != nil {
+					return err
+				}
+				log.WithField("task", "delete-old-api-requests").Infof("Deleted %d API requests older than %s", n, APIRequestRetention)
+				return nil
+			},
+		},
+		{
+			Name:     "release-expired-project-locks",
+			Interval: time.Minute,
+			Run: func(ctx context.Context) error {
+				n, err := project.ReleaseExpiredLocks(db)
+				if err != nil {
+					return err
+				}
+				if n > 0 {
+					log.WithField("task", "release-expired-project-locks").Infof("Released %d expired project locks", n)
+				}
+				return nil
+			},
+		},
+		{
+			// Starts deployments that were queued behind another deployment
+			// of their project once it has finished.
+			Name:     "release-queued-deployments",
+			Interval: 10 * time.Second,
+			Run: func(ctx context.Context) error {
+				n, err := deployment.ReleaseQueued(db)
+				if err != nil {
+					return err
+				}
+				if n > 0 {
+					log.WithField("task", "release-queued-deployments").Infof("Started %d queued deployments", n)
+				}
+				return nil
+			},
+		},
+	}
+}
+
+// Command returns a function that runs the named job binary, which is looked
+// up in PATH. The job is killed if ctx is cancelled.
+func Command(name string, args ...string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		cmd := exec.CommandContext(ctx, name, args...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		return cmd.Run()
+	}
+}
@@ -0,0 +1,115 @@
+// Package emails provides templated transactional emails and the mailer used
+// to send them.
+package emails
+
+import (
+	"bytes"
+	"errors"
+	htmltemplate "html/template"
+	texttemplate "text/template"
+	"time"
+)
+
+// template names
+const (
+	Confirmation   = "confirmation"
+	PasswordReset  = "password_reset"
+	CertExpiry     = "cert_expiry"
+	DeployFailure  = "deploy_failure"
+	DomainActive   = "domain_active"
+	DomainDisabled = "domain_disabled"
+	UnexpectedCert = "unexpected_cert"
+)
+
+var ErrUnknownTemplate = errors.New("unknown email template")
+
+// Email is a rendered email.
+type Email struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+type ConfirmationData struct {
+	ConfirmationCode string
+}
+
+type PasswordResetData struct {
+	PasswordResetToken string
+}
+
+type CertExpiryData struct {
+	Prefs
+	DomainName string
+	ExpiresAt  time.Time
+}
+
+type DeployFailureData struct {
+	ProjectName  string
+	Version      int64
+	ErrorMessage string
+}
+
+type DomainActiveData struct {
+	ProjectName string
+	DomainName  string
+}
+
+type DomainDisabledData struct {
+	ProjectName string
+	DomainName  string
+	// Days is the number of days the DNS records of the domain did not point
+	// to PubStorm for, unless it was disabled by an admin.
+	Days    int
+	ByAdmin bool
+}
+
+type UnexpectedCertData struct {
+	Prefs
+	ProjectName string
+	DomainName  string
+	IssuerName  string
+	NotBefore   time.Time
+	LogEntryURL string
+}
+
+type template struct {
+	subject *texttemplate.Template
+	text    *texttemplate.Template
+	html    *htmltemplate.Template
+}
+
+var templates = map[string]*template{}
+
+func register(name, subject, text, html string) {
+	templates[name] = &template{
+		subject: texttemplate.Must(texttemplate.New(name + "_subject").Parse(subject)),
+		text:    texttemplate.Must(texttemplate.New(name + "_text").Parse(text)),
+		html:    htmltemplate.Must(htmltemplate.New(name + "_html").Parse(html)),
+	}
+}
+
+// Render renders the email template with the given name using data.
+func Render(name string, data interface{}) (*Email, error) {
+	t, ok := templates[name]
+	if !ok {
+		return nil, ErrUnknownTemplate
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := t.subject.Execute(&subject, data); err != nil {
+		return nil, err
+	}
+	if err := t.text.Execute(&text, data); err != nil {
+		return nil, err
+	}
+	if err := t.html.Execute(&html, data); err != nil {
+		return nil, err
+	}
+
+	return &Email{
+		Subject: subject.String(),
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
@@ -0,0 +1,136 @@
+package emails_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nitrous-io/rise-server/shared/emails"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "emails")
+}
+
+var _ = Describe("Emails", func() {
+	Describe("Render()", func() {
+		It("renders the confirmation email", func() {
+			e, err := emails.Render(emails.Confirmation, &emails.ConfirmationData{ConfirmationCode: "123456"})
+			Expect(err).To(BeNil())
+			Expect(e.Subject).To(Equal("Please confirm your PubStorm account email address"))
+			Expect(e.Text).To(ContainSubstring("\n\n123456\n\n"))
+			Expect(e.HTML).To(ContainSubstring("<strong>123456</strong>"))
+		})
+
+		It("renders the password reset email", func() {
+			e, err := emails.Render(emails.PasswordReset, &emails.PasswordResetData{PasswordResetToken: "abcdef"})
+			Expect(err).To(BeNil())
+			Expect(e.Subject).To(Equal("PubStorm password reset instructions"))
+			Expect(e.Text).To(ContainSubstring("abcdef"))
+			Expect(e.Text).To(ContainSubstring("`storm password.reset --continue`"))
+			Expect(e.HTML).To(ContainSubstring("<strong>abcdef</strong>"))
+		})
+
+		It("renders the cert expiry email", func() {
+			e, err := emails.Render(emails.CertExpiry, &emails.CertExpiryData{
+				DomainName: "www.example.com",
+				ExpiresAt:  time.Date(2016, 7, 1, 0, 0, 0, 0, time.UTC),
+			})
+			Expect(err).To(BeNil())
+			Expect(e.Subject).To(Equal("Your SSL certificate for www.example.com is about to expire"))
+			Expect(e.Text).To(ContainSubstring("will expire on 1 July 2016"))
+		})
+
+		It("renders the dates of the cert expiry email with the preferences of the recipient", func() {
+			e, err := emails.Render(emails.CertExpiry, &emails.CertExpiryData{
+				Prefs:      emails.Prefs{Timezone: "America/New_York", Locale: "en-US"},
+				DomainName: "www.example.com",
+				ExpiresAt:  time.Date(2016, 7, 1, 0, 0, 0, 0, time.UTC),
+			})
+			Expect(err).To(BeNil())
+			Expect(e.Text).To(ContainSubstring("will expire on June 30, 2016 at 8:00 PM EDT."))
+			Expect(e.HTML).To(ContainSubstring("will expire on June 30, 2016 at 8:00 PM EDT."))
+		})
+
+		It("renders the deploy failure email and escapes HTML", func() {
+			e, err := emails.Render(emails.DeployFailure, &emails.DeployFailureData{
+				ProjectName:  "foo-bar-express",
+				Version:      3,
+				ErrorMessage: "<b>Timed out</b>",
+			})
+			Expect(err).To(BeNil())
+			Expect(e.Subject).To(Equal("Deployment of foo-bar-express failed"))
+			Expect(e.Text).To(ContainSubstring("Version v3 of your project foo-bar-express"))
+			Expect(e.Text).To(ContainSubstring("Error: <b>Timed out</b>"))
+			Expect(e.HTML).To(ContainSubstring("Error: &lt;b&gt;Timed out&lt;/b&gt;"))
+		})
+
+		It("renders the domain active email", func() {
+			e, err := emails.Render(emails.DomainActive, &emails.DomainActiveData{
+				ProjectName: "foo-bar-express",
+				DomainName:  "www.example.com",
+			})
+			Expect(err).To(BeNil())
+			Expect(e.Subject).To(Equal("www.example.com is now live on PubStorm"))
+			Expect(e.Text).To(ContainSubstring("your project foo-bar-express is now being served on it"))
+		})
+
+		It("renders the domain disabled email", func() {
+			e, err := emails.Render(emails.DomainDisabled, &emails.DomainDisabledData{
+				ProjectName: "foo-bar-express",
+				DomainName:  "www.example.com",
+				Days:        7,
+			})
+			Expect(err).To(BeNil())
+			Expect(e.Subject).To(Equal("www.example.com is no longer being served by PubStorm"))
+			Expect(e.Text).To(ContainSubstring("have not pointed to PubStorm for 7 days"))
+			Expect(e.HTML).To(ContainSubstring("<strong>www.example.com</strong>"))
+		})
+
+		It("renders the domain disabled email of domains disabled by an admin", func() {
+			e, err := emails.Render(emails.DomainDisabled, &emails.DomainDisabledData{
+				ProjectName: "foo-bar-express",
+				DomainName:  "www.example.com",
+				ByAdmin:     true,
+			})
+			Expect(err).To(BeNil())
+			Expect(e.Text).To(ContainSubstring("We have disabled www.example.com, a domain of your project foo-bar-express"))
+			Expect(e.Text).NotTo(ContainSubstring("DNS records"))
+		})
+
+		It("renders the unexpected cert email", func() {
+			e, err := emails.Render(emails.UnexpectedCert, &emails.UnexpectedCertData{
+				ProjectName: "foo-bar-express",
+				DomainName:  "www.example.com",
+				IssuerName:  "C=US, O=Sketchy CA, CN=Sketchy CA",
+				NotBefore:   time.Date(2016, time.July, 1, 0, 0, 0, 0, time.UTC),
+				LogEntryURL: "https://crt.sh/?id=12345",
+			})
+			Expect(err).To(BeNil())
+			Expect(e.Subject).To(Equal("An SSL certificate for www.example.com was issued by an unexpected CA"))
+			Expect(e.Text).To(ContainSubstring("was issued on 1 July 2016 by:\n\nC=US, O=Sketchy CA, CN=Sketchy CA"))
+			Expect(e.HTML).To(ContainSubstring(`<a href="https://crt.sh/?id=12345">`))
+		})
+
+		It("renders the dates of the unexpected cert email in the time zone of the recipient", func() {
+			e, err := emails.Render(emails.UnexpectedCert, &emails.UnexpectedCertData{
+				Prefs:       emails.Prefs{Timezone: "Asia/Singapore", Locale: "en-GB"},
+				ProjectName: "foo-bar-express",
+				DomainName:  "www.example.com",
+				IssuerName:  "C=US, O=Sketchy CA, CN=Sketchy CA",
+				NotBefore:   time.Date(2016, time.June, 30, 20, 0, 0, 0, time.UTC),
+				LogEntryURL: "https://crt.sh/?id=12345",
+			})
+			Expect(err).To(BeNil())
+			Expect(e.Text).To(ContainSubstring("was issued on 1 July 2016 by:"))
+		})
+
+		It("returns an error for unknown templates", func() {
+			e, err := emails.Render("nope", nil)
+			Expect(e).To(BeNil())
+			Expect(err).To(Equal(emails.ErrUnknownTemplate))
+		})
+	})
+})
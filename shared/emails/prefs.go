@@ -0,0 +1,76 @@
+package emails
+
+import (
+	"sort"
+	"time"
+)
+
+// DefaultLocale and DefaultTimezone are used to render emails to users who
+// have not set their preferences.
+const (
+	DefaultLocale   = "en"
+	DefaultTimezone = "UTC"
+)
+
+// formats are the date and time formats of the supported locales.
+var formats = map[string]struct{ date, time string }{
+	"en":    {"2 January 2006", "15:04"},
+	"en-GB": {"2 January 2006", "15:04"},
+	"en-US": {"January 2, 2006", "3:04 PM"},
+}
+
+// Locales returns the supported locales in alphabetical order.
+func Locales() []string {
+	locales := make([]string, 0, len(formats))
+	for locale := range formats {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// IsSupportedLocale returns whether emails can be rendered in the locale.
+func IsSupportedLocale(locale string) bool {
+	_, ok := formats[locale]
+	return ok
+}
+
+// Prefs are the preferences of the recipient of an email, which the dates
+// and times in the email are rendered with. It is embedded in the data of
+// templates that render times, e.g. as {{ .DateTime .ExpiresAt }}.
+type Prefs struct {
+	Timezone string
+	Locale   string
+}
+
+func (p Prefs) location() *time.Location {
+	if p.Timezone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(p.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+func (p Prefs) formats() (string, string) {
+	f, ok := formats[p.Locale]
+	if !ok {
+		f = formats[DefaultLocale]
+	}
+	return f.date, f.time
+}
+
+// Date renders the date of t in the time zone of the recipient.
+func (p Prefs) Date(t time.Time) string {
+	dateFormat, _ := p.formats()
+	return t.In(p.location()).Format(dateFormat)
+}
+
+// DateTime renders t in the time zone of the recipient, followed by the name
+// of the time zone, so that it is unambiguous.
+func (p Prefs) DateTime(t time.Time) string {
+	dateFormat, timeFormat := p.formats()
+	return t.In(p.location()).Format(dateFormat + " at " + timeFormat + " MST")
+}
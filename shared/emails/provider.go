@@ -0,0 +1,34 @@
+package emails
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/nitrous-io/rise-server/pkg/mailer"
+)
+
+// NewProviderFromEnv returns a mailer that delivers mail through the provider
+// set in the MAILER_PROVIDER environment variable ("sendgrid" by default).
+func NewProviderFromEnv() (mailer.Mailer, error) {
+	switch p := os.Getenv("MAILER_PROVIDER"); p {
+	case "", "sendgrid":
+		return mailer.NewSendGridMailer(os.Getenv("SENDGRID_USERNAME"), os.Getenv("SENDGRID_PASSWORD")), nil
+	case "smtp":
+		port := 587
+		if v := os.Getenv("SMTP_PORT"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("SMTP_PORT is invalid: %q", v)
+			}
+			port = n
+		}
+		return mailer.NewSMTPMailer(os.Getenv("SMTP_HOST"), port, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD")), nil
+	case "ses":
+		return mailer.NewSESMailer(os.Getenv("SES_REGION"), os.Getenv("SES_SMTP_USERNAME"), os.Getenv("SES_SMTP_PASSWORD")), nil
+	case "mailgun":
+		return mailer.NewMailgunMailer(os.Getenv("MAILGUN_DOMAIN"), os.Getenv("MAILGUN_API_KEY")), nil
+	default:
+		return nil, fmt.Errorf("unknown MAILER_PROVIDER: %q", p)
+	}
+}
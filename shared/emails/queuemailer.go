@@ -0,0 +1,29 @@
+package emails
+
+import (
+	"github.com/nitrous-io/rise-server/pkg/job"
+	"github.com/nitrous-io/rise-server/shared/messages"
+	"github.com/nitrous-io/rise-server/shared/queues"
+)
+
+// QueueMailer is a mailer that enqueues mail to be sent asynchronously by
+// mailerd, which retries sending on failure.
+type QueueMailer struct{}
+
+func (m *QueueMailer) SendMail(from string, tos, ccs, bccs []string, replyTo, subject, body, htmltext string) error {
+	j, err := job.NewWithJSON(queues.Mail, &messages.SendMailJobData{
+		From:    from,
+		Tos:     tos,
+		Ccs:     ccs,
+		Bccs:    bccs,
+		ReplyTo: replyTo,
+		Subject: subject,
+		Body:    body,
+		HTML:    htmltext,
+	})
+	if err != nil {
+		return err
+	}
+
+	return j.Enqueue()
+}
@@ -0,0 +1,124 @@
+package emails
+
+func init() {
+	register(Confirmation,
+		`Please confirm your PubStorm account email address`,
+
+		`Welcome to PubStorm!
+
+To complete sign up, please confirm your email address by entering the following confirmation code when logging in for the first time:
+
+{{ .ConfirmationCode }}
+
+Thanks,
+PubStorm`,
+
+		`<p>Welcome to PubStorm!</p>`+
+			`<p>To complete sign up, please confirm your email address by entering the following confirmation code when logging in for the first time:</p>`+
+			`<p><strong>{{ .ConfirmationCode }}</strong></p>`+
+			`<p>Thanks,<br />`+
+			`PubStorm</p>`,
+	)
+
+	register(PasswordReset,
+		`PubStorm password reset instructions`,
+
+		`Someone (hopefully you!) requested a password reset for your PubStorm account.
+
+To reset your password, please use the following code with the PubStorm CLI:
+
+{{ .PasswordResetToken }}
+
+You can use `+"`storm password.reset --continue`"+` to enter this code.
+
+Thanks,
+PubStorm`,
+
+		`<p>Someone (hopefully you!) requested a password reset for your PubStorm account.</p>`+
+			`<p>To reset your password, please use the following code with the PubStorm CLI:</p>`+
+			`<p><strong>{{ .PasswordResetToken }}</strong></p>`+
+			`<p>You can use <code>storm password.reset --continue</code> to enter this code.</p>`+
+			`<p>Thanks,<br />`+
+			`PubStorm</p>`,
+	)
+
+	register(CertExpiry,
+		`Your SSL certificate for {{ .DomainName }} is about to expire`,
+
+		`The SSL certificate for {{ .DomainName }} will expire on {{ .DateTime .ExpiresAt }}.
+
+Please upload a renewed certificate before then to avoid interruptions to HTTPS traffic.
+
+Thanks,
+PubStorm`,
+
+		`<p>The SSL certificate for <strong>{{ .DomainName }}</strong> will expire on {{ .DateTime .ExpiresAt }}.</p>`+
+			`<p>Please upload a renewed certificate before then to avoid interruptions to HTTPS traffic.</p>`+
+			`<p>Thanks,<br />`+
+			`PubStorm</p>`,
+	)
+
+	register(DeployFailure,
+		`Deployment of {{ .ProjectName }} failed`,
+
+		`Version v{{ .Version }} of your project {{ .ProjectName }} could not be deployed.
+{{ if .ErrorMessage }}
+Error: {{ .ErrorMessage }}
+{{ end }}
+Your previously deployed version, if any, is still being served.
+
+Thanks,
+PubStorm`,
+
+		`<p>Version v{{ .Version }} of your project <strong>{{ .ProjectName }}</strong> could not be deployed.</p>`+
+			`{{ if .ErrorMessage }}<p>Error: {{ .ErrorMessage }}</p>{{ end }}`+
+			`<p>Your previously deployed version, if any, is still being served.</p>`+
+			`<p>Thanks,<br />`+
+			`PubStorm</p>`,
+	)
+
+	register(DomainActive,
+		`{{ .DomainName }} is now live on PubStorm`,
+
+		`We have verified the DNS records of {{ .DomainName }}, and your project {{ .ProjectName }} is now being served on it.
+
+Thanks,
+PubStorm`,
+
+		`<p>We have verified the DNS records of <strong>{{ .DomainName }}</strong>, and your project <strong>{{ .ProjectName }}</strong> is now being served on it.</p>`+
+			`<p>Thanks,<br />`+
+			`PubStorm</p>`,
+	)
+
+	register(DomainDisabled,
+		`{{ .DomainName }} is no longer being served by PubStorm`,
+
+		`{{ if .ByAdmin }}We have disabled {{ .DomainName }}, a domain of your project {{ .ProjectName }}, and stopped serving your project on it. Please reply to this email if you believe this was done in error.{{ else }}The DNS records of {{ .DomainName }}, a domain of your project {{ .ProjectName }}, have not pointed to PubStorm for {{ .Days }} days, so we have stopped serving your project on it. It will be served again within a day of its DNS records pointing to PubStorm again.{{ end }}
+
+Thanks,
+PubStorm`,
+
+		`{{ if .ByAdmin }}<p>We have disabled <strong>{{ .DomainName }}</strong>, a domain of your project <strong>{{ .ProjectName }}</strong>, and stopped serving your project on it. Please reply to this email if you believe this was done in error.</p>{{ else }}<p>The DNS records of <strong>{{ .DomainName }}</strong>, a domain of your project <strong>{{ .ProjectName }}</strong>, have not pointed to PubStorm for {{ .Days }} days, so we have stopped serving your project on it. It will be served again within a day of its DNS records pointing to PubStorm again.</p>{{ end }}`+
+			`<p>Thanks,<br />`+
+			`PubStorm</p>`,
+	)
+
+	register(UnexpectedCert,
+		`An SSL certificate for {{ .DomainName }} was issued by an unexpected CA`,
+
+		`A certificate for {{ .DomainName }}, a domain of your project {{ .ProjectName }}, was issued on {{ .Date .NotBefore }} by:
+
+{{ .IssuerName }}
+
+We did not request this certificate. If you did not either, someone else may have gained control of your domain or its DNS records. You can find the certificate at {{ .LogEntryURL }}
+
+Thanks,
+PubStorm`,
+
+		`<p>A certificate for <strong>{{ .DomainName }}</strong>, a domain of your project <strong>{{ .ProjectName }}</strong>, was issued on {{ .Date .NotBefore }} by:</p>`+
+			`<p>{{ .IssuerName }}</p>`+
+			`<p>We did not request this certificate. If you did not either, someone else may have gained control of your domain or its DNS records. You can find the certificate at <a href="{{ .LogEntryURL }}">{{ .LogEntryURL }}</a></p>`+
+			`<p>Thanks,<br />`+
+			`PubStorm</p>`,
+	)
+}
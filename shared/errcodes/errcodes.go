@@ -0,0 +1,76 @@
+// Package errcodes is the registry of the machine-readable codes of API
+// errors. Codes are returned in the "code" field of error responses, next to
+// the more general "error" field (e.g. "invalid_params"), so that clients can
+// tell specific failures apart without parsing "error_description". Codes are
+// part of the API, so they must never be renamed once they are in use.
+package errcodes
+
+import "sort"
+
+// error codes
+const (
+	QuotaExceeded        = "quota_exceeded"
+	NameBlacklisted      = "name_blacklisted"
+	NameTaken            = "name_taken"
+	EmailTaken           = "email_taken"
+	EmailBlacklisted     = "email_blacklisted"
+	CertPending          = "cert_pending"
+	CertExists           = "cert_exists"
+	DeploymentInProgress = "deployment_in_progress"
+	DeploysFrozen        = "deploys_frozen"
+	NoActiveDeployment   = "no_active_deployment"
+	ChecksumMismatch     = "checksum_mismatch"
+	PayloadTooLarge      = "payload_too_large"
+	ProjectLocked        = "project_locked"
+	StaleLockVersion     = "stale_lock_version"
+	AlreadyCollaborator  = "already_collaborator"
+	TokenExpired         = "token_expired"
+	TokenInvalid         = "token_invalid"
+	TokenScopeForbidden  = "token_scope_forbidden"
+	UserInactive         = "user_inactive"
+)
+
+// registry describes when each code is returned, to document them.
+var registry = map[string]string{
+	QuotaExceeded:        "the user or project has reached a limit of its plan, e.g. the maximum number of projects or domains",
+	NameBlacklisted:      "the project name is reserved and cannot be used",
+	NameTaken:            "the project or domain name is used by another project",
+	EmailTaken:           "the email address is used by another user",
+	EmailBlacklisted:     "the email address, or its domain, is not allowed to sign up",
+	CertPending:          "the certificate cannot be issued yet because the domain could not be verified, e.g. its DNS records do not point to PubStorm yet",
+	CertExists:           "the domain already has a certificate from Let's Encrypt",
+	DeploymentInProgress: "another deployment of the project is being built or deployed",
+	DeploysFrozen:        "deployments of the project are frozen, and only its owner can deploy by overriding the freeze",
+	NoActiveDeployment:   "the project has no active deployment, e.g. because it has never been deployed",
+	ChecksumMismatch:     "the uploaded bundle does not match its checksum",
+	PayloadTooLarge:      "the request body is larger than allowed",
+	ProjectLocked:        "the project is locked by a deployment or another change",
+	StaleLockVersion:     "the project was modified by someone else after the client loaded it",
+	AlreadyCollaborator:  "the user is already a collaborator of the project",
+	TokenExpired:         "the access token has expired",
+	TokenInvalid:         "the access token is missing or invalid",
+	TokenScopeForbidden:  "the deploy token or project API key is not allowed to make the request, e.g. because it is for another project",
+	UserInactive:         "the user account is locked, suspended or unconfirmed, and pending_actions says what the user has to do",
+}
+
+// IsRegistered returns whether code is a registered error code.
+func IsRegistered(code string) bool {
+	_, ok := registry[code]
+	return ok
+}
+
+// Description returns when the error with the given code is returned, or an
+// empty string if the code is not registered.
+func Description(code string) string {
+	return registry[code]
+}
+
+// All returns all registered error codes in alphabetical order.
+func All() []string {
+	codes := make([]string, 0, len(registry))
+	for code := range registry {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
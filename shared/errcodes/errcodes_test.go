@@ -0,0 +1,38 @@
+package errcodes_test
+
+import (
+	"testing"
+
+	"github.com/nitrous-io/rise-server/shared/errcodes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "errcodes")
+}
+
+var _ = Describe("errcodes", func() {
+	It("describes every registered code", func() {
+		Expect(errcodes.All()).NotTo(BeEmpty())
+		for _, code := range errcodes.All() {
+			Expect(code).To(MatchRegexp(`^[a-z]+(_[a-z]+)*$`))
+			Expect(errcodes.IsRegistered(code)).To(BeTrue())
+			Expect(errcodes.Description(code)).NotTo(BeEmpty())
+		}
+	})
+
+	It("returns the codes in alphabetical order", func() {
+		codes := errcodes.All()
+		for i := 1; i < len(codes); i++ {
+			Expect(codes[i-1] < codes[i]).To(BeTrue())
+		}
+	})
+
+	It("does not register unknown codes", func() {
+		Expect(errcodes.IsRegistered("no_such_code")).To(BeFalse())
+		Expect(errcodes.Description("no_such_code")).To(Equal(""))
+	})
+})
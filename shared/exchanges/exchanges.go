@@ -3,14 +3,22 @@ package exchanges
 // exchange names
 const (
 	Edges = "edges"
+	// DeploymentStatus is where workers publish the progress of the
+	// deployments they build or deploy, for API servers to stream to
+	// clients.
+	DeploymentStatus = "deployment_status"
 )
 
 // make sure to add the exchange here too so testhelper can clean it
 var All = []string{
 	Edges,
+	DeploymentStatus,
 }
 
 // routes
 const (
 	RouteV1Invalidation = "v1.invalidation"
+	RouteV1EdgeConfig   = "v1.edge_config"
+
+	RouteV1DeploymentProgress = "v1.deployment_progress"
 )
@@ -0,0 +1,121 @@
+package invalidation
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/client/metadata"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/private/protocol/restxml"
+	"github.com/aws/aws-sdk-go/private/signer/v4"
+)
+
+const cloudFrontAPIVersion = "2016-01-28"
+
+var ErrCloudFrontNotConfigured = errors.New("CLOUDFRONT_DISTRIBUTION_ID is required")
+
+// CloudFront invalidates domains by creating an invalidation of a CloudFront
+// distribution that fronts the edge servers. CloudFront invalidates by path
+// regardless of the host, so every path of the distribution is invalidated.
+type CloudFront struct {
+	DistributionID string
+
+	// Config is the AWS config used to make requests, e.g. to use a different
+	// endpoint. Credentials are taken from the environment if it is nil.
+	Config *aws.Config
+}
+
+// CloudFrontFromEnv returns a CloudFront adapter configured with
+// CLOUDFRONT_DISTRIBUTION_ID.
+func CloudFrontFromEnv() (*CloudFront, error) {
+	cf := &CloudFront{DistributionID: os.Getenv("CLOUDFRONT_DISTRIBUTION_ID")}
+	if cf.DistributionID == "" {
+		return nil, ErrCloudFrontNotConfigured
+	}
+	return cf, nil
+}
+
+func (cf *CloudFront) Invalidate(domains []string) error {
+	input := &createInvalidationInput{
+		DistributionId: aws.String(cf.DistributionID),
+		InvalidationBatch: &invalidationBatch{
+			// The caller reference has to be unique for every invalidation,
+			// otherwise CloudFront treats it as a retry of an earlier one.
+			CallerReference: aws.String(fmt.Sprintf("rise-%d", time.Now().UnixNano())),
+			Paths: &invalidationPaths{
+				Items:    []*string{aws.String("/*")},
+				Quantity: aws.Int64(1),
+			},
+		},
+	}
+
+	req := cf.client().NewRequest(&request.Operation{
+		Name:       "CreateInvalidation",
+		HTTPMethod: "POST",
+		HTTPPath:   "/" + cloudFrontAPIVersion + "/distribution/{DistributionId}/invalidation",
+	}, input, &createInvalidationOutput{})
+
+	return req.Send()
+}
+
+// client returns a client of the parts of the CloudFront API that are used,
+// set up the same way as the service clients of the AWS SDK.
+func (cf *CloudFront) client() *client.Client {
+	// CloudFront is a global service, which is signed for us-east-1.
+	cfgs := []*aws.Config{aws.NewConfig().WithRegion("us-east-1")}
+	if cf.Config != nil {
+		cfgs = append(cfgs, cf.Config)
+	}
+	c := session.New().ClientConfig("cloudfront", cfgs...)
+
+	svc := client.New(
+		*c.Config,
+		metadata.ClientInfo{
+			ServiceName:   "cloudfront",
+			SigningRegion: c.SigningRegion,
+			Endpoint:      c.Endpoint,
+			APIVersion:    cloudFrontAPIVersion,
+		},
+		c.Handlers,
+	)
+
+	svc.Handlers.Sign.PushBack(v4.Sign)
+	svc.Handlers.Build.PushBackNamed(restxml.BuildHandler)
+	svc.Handlers.Unmarshal.PushBackNamed(restxml.UnmarshalHandler)
+	svc.Handlers.UnmarshalMeta.PushBackNamed(restxml.UnmarshalMetaHandler)
+	svc.Handlers.UnmarshalError.PushBackNamed(restxml.UnmarshalErrorHandler)
+
+	return svc
+}
+
+type createInvalidationInput struct {
+	_ struct{} `type:"structure" payload:"InvalidationBatch"`
+
+	DistributionId    *string            `location:"uri" locationName:"DistributionId" type:"string" required:"true"`
+	InvalidationBatch *invalidationBatch `locationName:"InvalidationBatch" type:"structure" required:"true" xmlURI:"http://cloudfront.amazonaws.com/doc/2016-01-28/"`
+}
+
+type invalidationBatch struct {
+	_ struct{} `type:"structure"`
+
+	CallerReference *string            `type:"string" required:"true"`
+	Paths           *invalidationPaths `type:"structure" required:"true"`
+}
+
+type invalidationPaths struct {
+	_ struct{} `type:"structure"`
+
+	Items    []*string `locationNameList:"Path" type:"list"`
+	Quantity *int64    `type:"integer" required:"true"`
+}
+
+type createInvalidationOutput struct {
+	_ struct{} `type:"structure"`
+
+	Location *string `location:"header" locationName:"Location" type:"string"`
+}
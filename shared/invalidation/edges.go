@@ -0,0 +1,44 @@
+package invalidation
+
+import (
+	"sort"
+
+	"github.com/nitrous-io/rise-server/pkg/pubsub"
+	"github.com/nitrous-io/rise-server/shared/exchanges"
+	"github.com/nitrous-io/rise-server/shared/messages"
+)
+
+// Edges invalidates domains by publishing an invalidation message that all
+// edge servers receive.
+type Edges struct{}
+
+func (e Edges) Invalidate(domains []string) error {
+	return e.publish(&messages.V1InvalidationMessageData{
+		Domains: domains,
+	})
+}
+
+// InvalidatePaths publishes an invalidation message with the paths of each
+// domain, so that edge servers only purge those paths. Edge servers that do
+// not know about paths purge the whole domains.
+func (e Edges) InvalidatePaths(paths map[string][]string) error {
+	domains := make([]string, 0, len(paths))
+	for domain := range paths {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+
+	return e.publish(&messages.V1InvalidationMessageData{
+		Domains: domains,
+		Paths:   paths,
+	})
+}
+
+func (Edges) publish(data *messages.V1InvalidationMessageData) error {
+	m, err := pubsub.NewMessageWithJSON(exchanges.Edges, exchanges.RouteV1Invalidation, data)
+	if err != nil {
+		return err
+	}
+
+	return m.Publish()
+}
@@ -0,0 +1,62 @@
+package invalidation
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// FastlyAPIHost is the host of the Fastly API.
+var FastlyAPIHost = "https://api.fastly.com"
+
+var ErrFastlyNotConfigured = errors.New("FASTLY_SERVICE_ID and FASTLY_API_KEY are required")
+
+// Fastly invalidates domains by purging them from a Fastly service by
+// surrogate key. Edge servers tag responses with the domain as the surrogate
+// key, so that only the content of the given domains is purged.
+type Fastly struct {
+	ServiceID string
+	APIKey    string
+}
+
+// FastlyFromEnv returns a Fastly adapter configured with FASTLY_SERVICE_ID
+// and FASTLY_API_KEY.
+func FastlyFromEnv() (*Fastly, error) {
+	f := &Fastly{
+		ServiceID: os.Getenv("FASTLY_SERVICE_ID"),
+		APIKey:    os.Getenv("FASTLY_API_KEY"),
+	}
+	if f.ServiceID == "" || f.APIKey == "" {
+		return nil, ErrFastlyNotConfigured
+	}
+	return f, nil
+}
+
+func (f *Fastly) Invalidate(domains []string) error {
+	for _, domain := range domains {
+		purgeURL := fmt.Sprintf("%s/service/%s/purge/%s", FastlyAPIHost, url.QueryEscape(f.ServiceID), url.QueryEscape(domain))
+		req, err := http.NewRequest("POST", purgeURL, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Fastly-Key", f.APIKey)
+		req.Header.Set("Accept", "application/json")
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+
+		if res.StatusCode != http.StatusOK {
+			b, _ := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			return fmt.Errorf("fastly purge of %q failed: (%d) %s", domain, res.StatusCode, b)
+		}
+		res.Body.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package invalidation
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Invalidator purges the cached content of domains.
+type Invalidator interface {
+	Invalidate(domains []string) error
+}
+
+// PathInvalidator is an Invalidator that can also purge only some paths of
+// domains.
+type PathInvalidator interface {
+	Invalidator
+	InvalidatePaths(paths map[string][]string) error
+}
+
+var ErrUnknownAdapter = errors.New("unknown invalidation adapter")
+
+// Adapters are the invalidators that Invalidate purges domains from. They are
+// configured as a comma-separated list of adapter names in
+// INVALIDATION_ADAPTERS, and default to the edge servers only.
+var Adapters = []Invalidator{Edges{}}
+
+func init() {
+	if names := os.Getenv("INVALIDATION_ADAPTERS"); names != "" {
+		adapters, err := FromNames(strings.Split(names, ","))
+		if err != nil {
+			log.Fatalf("Could not configure INVALIDATION_ADAPTERS: %v", err)
+		}
+		Adapters = adapters
+	}
+}
+
+// FromNames returns the adapters with the given names, configured from the
+// environment. Valid names are "edges", "cloudfront" and "fastly".
+func FromNames(names []string) ([]Invalidator, error) {
+	var adapters []Invalidator
+	for _, name := range names {
+		switch strings.TrimSpace(name) {
+		case "edges":
+			adapters = append(adapters, Edges{})
+		case "cloudfront":
+			cf, err := CloudFrontFromEnv()
+			if err != nil {
+				return nil, err
+			}
+			adapters = append(adapters, cf)
+		case "fastly":
+			f, err := FastlyFromEnv()
+			if err != nil {
+				return nil, err
+			}
+			adapters = append(adapters, f)
+		case "":
+		default:
+			return nil, fmt.Errorf("%v: %q", ErrUnknownAdapter, name)
+		}
+	}
+	return adapters, nil
+}
+
+// Invalidate purges the cached content of the given domains from all
+// adapters. It stops at the first adapter that fails, so that the
+// invalidation can be retried.
+func Invalidate(domains []string) error {
+	if len(domains) == 0 {
+		return nil
+	}
+
+	for _, a := range Adapters {
+		if err := a.Invalidate(domains); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InvalidatePaths purges the given URL paths of each domain, by domain name,
+// from all adapters. Adapters that cannot purge paths purge the whole domains
+// instead. Like Invalidate, it stops at the first adapter that fails.
+func InvalidatePaths(paths map[string][]string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+
+	domains := make([]string, 0, len(paths))
+	for domain := range paths {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+
+	for _, a := range Adapters {
+		var err error
+		if pa, ok := a.(PathInvalidator); ok {
+			err = pa.InvalidatePaths(paths)
+		} else {
+			err = a.Invalidate(domains)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package invalidation_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/nitrous-io/rise-server/shared/invalidation"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "invalidation")
+}
+
+type fakeInvalidator struct {
+	calls [][]string
+	err   error
+}
+
+func (f *fakeInvalidator) Invalidate(domains []string) error {
+	f.calls = append(f.calls, domains)
+	return f.err
+}
+
+type fakePathInvalidator struct {
+	fakeInvalidator
+	pathCalls []map[string][]string
+}
+
+func (f *fakePathInvalidator) InvalidatePaths(paths map[string][]string) error {
+	f.pathCalls = append(f.pathCalls, paths)
+	return f.err
+}
+
+var _ = Describe("Invalidation", func() {
+	var server *ghttp.Server
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Describe("Invalidate()", func() {
+		var (
+			origAdapters []invalidation.Invalidator
+			a1, a2       *fakeInvalidator
+		)
+
+		BeforeEach(func() {
+			origAdapters = invalidation.Adapters
+			a1, a2 = &fakeInvalidator{}, &fakeInvalidator{}
+			invalidation.Adapters = []invalidation.Invalidator{a1, a2}
+		})
+
+		AfterEach(func() {
+			invalidation.Adapters = origAdapters
+		})
+
+		It("invalidates the domains with every adapter", func() {
+			Expect(invalidation.Invalidate([]string{"foo-bar-express.com"})).To(Succeed())
+
+			Expect(a1.calls).To(Equal([][]string{{"foo-bar-express.com"}}))
+			Expect(a2.calls).To(Equal([][]string{{"foo-bar-express.com"}}))
+		})
+
+		It("stops at the first adapter that fails", func() {
+			a1.err = errors.New("purge failed")
+
+			Expect(invalidation.Invalidate([]string{"foo-bar-express.com"})).To(Equal(a1.err))
+			Expect(a2.calls).To(BeEmpty())
+		})
+
+		It("does nothing if there are no domains", func() {
+			Expect(invalidation.Invalidate(nil)).To(Succeed())
+			Expect(a1.calls).To(BeEmpty())
+		})
+	})
+
+	Describe("InvalidatePaths()", func() {
+		var (
+			origAdapters []invalidation.Invalidator
+			a1           *fakePathInvalidator
+			a2           *fakeInvalidator
+		)
+
+		BeforeEach(func() {
+			origAdapters = invalidation.Adapters
+			a1, a2 = &fakePathInvalidator{}, &fakeInvalidator{}
+			invalidation.Adapters = []invalidation.Invalidator{a1, a2}
+		})
+
+		AfterEach(func() {
+			invalidation.Adapters = origAdapters
+		})
+
+		It("purges the paths with adapters that can, and whole domains with the others", func() {
+			paths := map[string][]string{
+				"www.foo-bar-express.com": {"/", "/css/app.css"},
+				"foo-bar-express.com":     {"/", "/css/app.css"},
+			}
+			Expect(invalidation.InvalidatePaths(paths)).To(Succeed())
+
+			Expect(a1.pathCalls).To(Equal([]map[string][]string{paths}))
+			Expect(a1.calls).To(BeEmpty())
+			Expect(a2.calls).To(Equal([][]string{{"foo-bar-express.com", "www.foo-bar-express.com"}}))
+		})
+
+		It("stops at the first adapter that fails", func() {
+			a1.err = errors.New("purge failed")
+
+			Expect(invalidation.InvalidatePaths(map[string][]string{"foo-bar-express.com": {"/"}})).To(Equal(a1.err))
+			Expect(a2.calls).To(BeEmpty())
+		})
+
+		It("does nothing if there are no domains", func() {
+			Expect(invalidation.InvalidatePaths(nil)).To(Succeed())
+			Expect(a1.pathCalls).To(BeEmpty())
+			Expect(a2.calls).To(BeEmpty())
+		})
+	})
+
+	Describe("FromNames()", func() {
+		It("returns the named adapters", func() {
+			os.Setenv("FASTLY_SERVICE_ID", "svc")
+			os.Setenv("FASTLY_API_KEY", "key")
+			defer os.Unsetenv("FASTLY_SERVICE_ID")
+			defer os.Unsetenv("FASTLY_API_KEY")
+
+			adapters, err := invalidation.FromNames([]string{"edges", " fastly"})
+			Expect(err).To(BeNil())
+			Expect(adapters).To(HaveLen(2))
+			Expect(adapters[0]).To(Equal(invalidation.Edges{}))
+			Expect(adapters[1]).To(Equal(&invalidation.Fastly{ServiceID: "svc", APIKey: "key"}))
+		})
+
+		It("returns an error if an adapter is not configured", func() {
+			_, err := invalidation.FromNames([]string{"cloudfront"})
+			Expect(err).To(Equal(invalidation.ErrCloudFrontNotConfigured))
+		})
+
+		It("returns an error for unknown adapters", func() {
+			_, err := invalidation.FromNames([]string{"akamai"})
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Describe("Fastly", func() {
+		var origAPIHost string
+
+		BeforeEach(func() {
+			origAPIHost = invalidation.FastlyAPIHost
+			invalidation.FastlyAPIHost = server.URL()
+		})
+
+		AfterEach(func() {
+			invalidation.FastlyAPIHost = origAPIHost
+		})
+
+		It("purges each domain by surrogate key", func() {
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", "/service/svc/purge/foo-bar-express.com"),
+					ghttp.VerifyHeaderKV("Fastly-Key", "key"),
+					ghttp.RespondWith(http.StatusOK, `{"status": "ok"}`),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", "/service/svc/purge/www.foo-bar-express.com"),
+					ghttp.RespondWith(http.StatusOK, `{"status": "ok"}`),
+				),
+			)
+
+			f := &invalidation.Fastly{ServiceID: "svc", APIKey: "key"}
+			Expect(f.Invalidate([]string{"foo-bar-express.com", "www.foo-bar-express.com"})).To(Succeed())
+			Expect(server.ReceivedRequests()).To(HaveLen(2))
+		})
+
+		It("returns an error if a purge fails", func() {
+			server.AppendHandlers(
+				ghttp.RespondWith(http.StatusUnauthorized, `{"msg": "Provided credentials are missing or invalid"}`),
+			)
+
+			f := &invalidation.Fastly{ServiceID: "svc", APIKey: "wrong"}
+			Expect(f.Invalidate([]string{"foo-bar-express.com"})).NotTo(Succeed())
+		})
+	})
+
+	Describe("CloudFront", func() {
+		var cf *invalidation.CloudFront
+
+		BeforeEach(func() {
+			cf = &invalidation.CloudFront{
+				DistributionID: "EDFDVBD6EXAMPLE",
+				Config: &aws.Config{
+					Endpoint:    aws.String(server.URL()),
+					Credentials: credentials.NewStaticCredentials("AKID", "SECRET", ""),
+					MaxRetries:  aws.Int(0),
+				},
+			}
+		})
+
+		It("creates an invalidation of every path of the distribution", func() {
+			var body string
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", "/2016-01-28/distribution/EDFDVBD6EXAMPLE/invalidation"),
+					func(w http.ResponseWriter, r *http.Request) {
+						b, err := ioutil.ReadAll(r.Body)
+						Expect(err).To(BeNil())
+						body = string(b)
+						Expect(r.Header.Get("Authorization")).To(HavePrefix("AWS4-HMAC-SHA256"))
+					},
+					ghttp.RespondWith(http.StatusCreated, `<?xml version="1.0" encoding="UTF-8"?>
+<Invalidation xmlns="http://cloudfront.amazonaws.com/doc/2016-01-28/"><Id>IDFDVBD632BHDS5</Id><Status>InProgress</Status></Invalidation>`),
+				),
+			)
+
+			Expect(cf.Invalidate([]string{"foo-bar-express.com"})).To(Succeed())
+			Expect(body).To(ContainSubstring(`<Items><Path>/*</Path></Items>`))
+			Expect(body).To(ContainSubstring(`<Quantity>1</Quantity>`))
+			Expect(body).To(ContainSubstring(`<CallerReference>rise-`))
+		})
+
+		It("returns an error if the invalidation could not be created", func() {
+			server.AppendHandlers(
+				ghttp.RespondWith(http.StatusNotFound, `<?xml version="1.0"?>
+<ErrorResponse xmlns="http://cloudfront.amazonaws.com/doc/2016-01-28/"><Error><Type>Sender</Type><Code>NoSuchDistribution</Code><Message>The specified distribution does not exist.</Message></Error></ErrorResponse>`),
+			)
+
+			Expect(cf.Invalidate([]string{"foo-bar-express.com"})).NotTo(Succeed())
+		})
+	})
+})
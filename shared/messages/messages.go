@@ -1,11 +1,24 @@
 package messages
 
+import (
+	"encoding/json"
+	"time"
+)
+
 type DeployJobData struct {
-	DeploymentID      uint   `json:"deployment_id"`
-	SkipWebrootUpload bool   `json:"skip_webroot_upload"`      // if true, uploading of webroot will be skipped and only meta.json for domains will be deployed
-	SkipInvalidation  bool   `json:"skip_invalidation"`        // if true, prefix cache invalidation message will not be published
-	UseRawBundle      bool   `json:"use_raw_bundle"`           // if true, it uses raw bundle to deploy instead of optimized bundle
-	ArchiveFormat     string `json:"archive_format,omitempty"` // "zip" or "tar.gz"
+	DeploymentID      uint            `json:"deployment_id"`
+	SkipWebrootUpload bool            `json:"skip_webroot_upload"`       // if true, uploading of webroot will be skipped and only meta.json for domains will be deployed
+	SkipInvalidation  bool            `json:"skip_invalidation"`         // if true, prefix cache invalidation message will not be published
+	UseRawBundle      bool            `json:"use_raw_bundle"`            // if true, it uses raw bundle to deploy instead of optimized bundle
+	ArchiveFormat     string          `json:"archive_format,omitempty"`  // "zip" or "tar.gz"
+	PrefixRotation    *PrefixRotation `json:"prefix_rotation,omitempty"` // if set, the files of the deployment are moved to a new prefix instead of being deployed
+}
+
+// PrefixRotation moves the files of a deployment from the From prefix to the
+// To prefix, so that they can no longer be accessed at the old path.
+type PrefixRotation struct {
+	From string `json:"from"`
+	To   string `json:"to"`
 }
 
 type BuildJobData struct {
@@ -17,6 +30,78 @@ type PushJobData struct {
 	PushID uint `json:"push_id"`
 }
 
+// Providers that sites can be imported from.
+const (
+	// ImportProviderURL crawls a live site.
+	ImportProviderURL = "url"
+	// ImportProviderNetlify reads a Netlify deploy that has been downloaded
+	// as a zip archive.
+	ImportProviderNetlify = "netlify"
+	// ImportProviderSurge reads a zip or gzipped tar archive of a Surge
+	// project directory.
+	ImportProviderSurge = "surge"
+)
+
+type ImportJobData struct {
+	DeploymentID uint   `json:"deployment_id"`
+	Provider     string `json:"provider"`
+	URL          string `json:"url"`
+}
+
+type SendMailJobData struct {
+	From     string   `json:"from"`
+	Tos      []string `json:"tos"`
+	Ccs      []string `json:"ccs,omitempty"`
+	Bccs     []string `json:"bccs,omitempty"`
+	ReplyTo  string   `json:"reply_to,omitempty"`
+	Subject  string   `json:"subject"`
+	Body     string   `json:"body"`
+	HTML     string   `json:"html,omitempty"`
+	Attempts int      `json:"attempts,omitempty"` // number of failed attempts to send this mail so far
+}
+
+// V1InvalidationMessageData tells edge servers to purge the cached content of
+// domains. Domains that have an entry in Paths only have the given URL paths
+// purged, e.g. "/css/app.css", and their meta.json reloaded, so that content
+// that did not change stays cached. Other domains are purged entirely.
 type V1InvalidationMessageData struct {
-	Domains []string `json:"domains"`
+	Domains []string            `json:"domains"`
+	Paths   map[string][]string `json:"paths,omitempty"`
+}
+
+// V1EdgeConfigMessageData is a version of the settings of edge servers. It
+// applies to the domains of the given project, or to all domains if there is
+// no project.
+type V1EdgeConfigMessageData struct {
+	Version  uint            `json:"version"`
+	Project  string          `json:"project,omitempty"`
+	Domains  []string        `json:"domains,omitempty"`
+	Settings json.RawMessage `json:"settings"`
+}
+
+// EdgeConfigAckJobData is sent by an edge server once it has applied a
+// version of the edge settings.
+type EdgeConfigAckJobData struct {
+	Edge    string `json:"edge"`
+	Version uint   `json:"version"`
+}
+
+// V1DeploymentProgressMessageData is a progress message of a deployment that a
+// worker publishes while building or deploying it, e.g. "Optimizing assets".
+type V1DeploymentProgressMessageData struct {
+	DeploymentID uint      `json:"deployment_id"`
+	Message      string    `json:"message"`
+	Timestamp    time.Time `json:"timestamp"`
+}
+
+type AccessLogJobData struct {
+	Entries []AccessLogEntry `json:"entries"`
+}
+
+// AccessLogEntry is a single request served by an edge server.
+type AccessLogEntry struct {
+	Domain    string    `json:"domain"`
+	Timestamp time.Time `json:"timestamp"`
+	Status    int       `json:"status"`
+	Bytes     int64     `json:"bytes"`
 }
@@ -1,6 +1,10 @@
 package mimetypes
 
-import "mime"
+import (
+	"mime"
+	"path/filepath"
+	"strings"
+)
 
 func Register() {
 	mime.AddExtensionType(".htm", "text/html")
@@ -10,6 +14,7 @@ func Register() {
 	mime.AddExtensionType(".xhtml", "text/xhtml+xml")
 	mime.AddExtensionType(".css", "text/css")
 	mime.AddExtensionType(".js", "application/javascript")
+	mime.AddExtensionType(".mjs", "application/javascript")
 	mime.AddExtensionType(".json", "application/json")
 	mime.AddExtensionType(".txt", "text/plain")
 	mime.AddExtensionType(".text", "text/plain")
@@ -57,4 +62,27 @@ func Register() {
 	// misc
 	mime.AddExtensionType(".swf", "application/x-shockwave-flash")
 	mime.AddExtensionType(".jar", "application/java-archive")
+	mime.AddExtensionType(".wasm", "application/wasm")
+}
+
+// TypeByPath returns the content type of a file in a webroot (e.g.
+// "js/app.mjs") without any parameters. overrides maps paths (e.g.
+// "/js/app.mjs") and lowercase extensions (e.g. ".mjs") to content types that
+// are used instead of the type registered for the extension. Paths take
+// precedence over extensions.
+func TypeByPath(fileName string, overrides map[string]string) string {
+	if t, ok := overrides["/"+strings.TrimPrefix(fileName, "/")]; ok {
+		return t
+	}
+
+	ext := filepath.Ext(fileName)
+	if t, ok := overrides[strings.ToLower(ext)]; ok {
+		return t
+	}
+
+	contentType := mime.TypeByExtension(ext)
+	if i := strings.Index(contentType, ";"); i != -1 {
+		contentType = contentType[:i]
+	}
+	return contentType
 }
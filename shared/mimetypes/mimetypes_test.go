@@ -0,0 +1,43 @@
+package mimetypes_test
+
+import (
+	"testing"
+
+	"github.com/nitrous-io/rise-server/shared/mimetypes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+func Test(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "mimetypes")
+}
+
+var _ = Describe("TypeByPath()", func() {
+	BeforeEach(func() {
+		mimetypes.Register()
+	})
+
+	overrides := map[string]string{
+		".unityweb":       "application/octet-stream",
+		".js":             "text/javascript",
+		"/Build/app.data": "application/x-game-data",
+	}
+
+	DescribeTable("returns the content type of a file",
+		func(fileName, contentType string) {
+			Expect(mimetypes.TypeByPath(fileName, overrides)).To(Equal(contentType))
+		},
+
+		Entry("registered extension", "index.html", "text/html"),
+		Entry("wasm", "app.wasm", "application/wasm"),
+		Entry("strips parameters", "readme.txt", "text/plain"),
+		Entry("overridden extension", "Build/game.unityweb", "application/octet-stream"),
+		Entry("overridden extension in uppercase", "Build/GAME.UNITYWEB", "application/octet-stream"),
+		Entry("extension overrides registered type", "js/app.js", "text/javascript"),
+		Entry("overridden path", "Build/app.data", "application/x-game-data"),
+		Entry("unknown extension", "Build/other.data", ""),
+	)
+})
@@ -0,0 +1,30 @@
+// Package progress publishes progress messages of deployments, which API
+// servers stream to clients that watch the deployments.
+package progress
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/nitrous-io/rise-server/pkg/pubsub"
+	"github.com/nitrous-io/rise-server/shared/exchanges"
+	"github.com/nitrous-io/rise-server/shared/messages"
+)
+
+// Publish publishes a progress message of the deployment with the given ID.
+// Failing to publish it is only logged, as progress messages are
+// informational, and nothing is lost if nobody is watching the deployment.
+func Publish(deploymentID uint, format string, args ...interface{}) {
+	m, err := pubsub.NewMessageWithJSON(exchanges.DeploymentStatus, exchanges.RouteV1DeploymentProgress, &messages.V1DeploymentProgressMessageData{
+		DeploymentID: deploymentID,
+		Message:      fmt.Sprintf(format, args...),
+		Timestamp:    time.Now().UTC(),
+	})
+	if err == nil {
+		err = m.Publish()
+	}
+	if err != nil {
+		log.Errorf("failed to publish progress of deployment %d, err: %v", deploymentID, err)
+	}
+}
@@ -2,9 +2,14 @@ package queues
 
 // queue names
 const (
-	Deploy = "deploy"
-	Build  = "build"
-	Push   = "push"
+	Deploy    = "deploy"
+	Build     = "build"
+	Push      = "push"
+	Import    = "import"
+	AccessLog = "access_log"
+	Mail      = "mail"
+
+	EdgeConfigAck = "edge_config_ack"
 )
 
 // make sure to add the queue here too so testhelper can clean it
@@ -12,4 +17,8 @@ var All = []string{
 	Deploy,
 	Build,
 	Push,
+	Import,
+	AccessLog,
+	Mail,
+	EdgeConfigAck,
 }
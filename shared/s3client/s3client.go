@@ -39,12 +39,16 @@ func Download(path string, out io.WriterAt) error {
 	return S3.Download(BucketRegion, BucketName, path, out)
 }
 
+func Open(path string) (io.ReadCloser, error) {
+	return S3.Open(BucketRegion, BucketName, path)
+}
+
 func Delete(path ...string) error {
 	return S3.Delete(BucketRegion, BucketName, path...)
 }
 
 func Copy(src, dest string) error {
-	return S3.Copy(BucketRegion, BucketName, src, dest)
+	return S3.Copy(BucketRegion, BucketName, src, dest, "private")
 }
 
 func Exists(path string) (bool, error) {
@@ -54,3 +58,23 @@ func Exists(path string) (bool, error) {
 func PresignedURL(key string, expireTime time.Duration) (string, error) {
 	return S3.PresignedURL(BucketRegion, BucketName, key, expireTime)
 }
+
+func PresignedPutURL(key string, expireTime time.Duration) (string, error) {
+	return S3.PresignedPutURL(BucketRegion, BucketName, key, expireTime)
+}
+
+func CreateMultipartUpload(path string) (string, error) {
+	return S3.CreateMultipartUpload(BucketRegion, BucketName, path, "private")
+}
+
+func UploadPart(path, uploadID string, partNumber int64, body io.ReadSeeker) error {
+	return S3.UploadPart(BucketRegion, BucketName, path, uploadID, partNumber, body)
+}
+
+func ListParts(path, uploadID string) ([]*filetransfer.PartInfo, error) {
+	return S3.ListParts(BucketRegion, BucketName, path, uploadID)
+}
+
+func CompleteMultipartUpload(path, uploadID string, parts []*filetransfer.PartInfo) error {
+	return S3.CompleteMultipartUpload(BucketRegion, BucketName, path, uploadID, parts)
+}
@@ -3,6 +3,7 @@ package shared
 import (
 	"os"
 	"strconv"
+	"strings"
 
 	log "github.com/Sirupsen/logrus"
 )
@@ -10,6 +11,14 @@ import (
 var (
 	DefaultDomain        = os.Getenv("DEFAULT_DOMAIN") // default domain (e.g. rise.cloud)
 	MaxDomainsPerProject = 5                           // MAX_DOMAINS - max # of custom domains per project
+	MaxFilesPerBundle    = 20000                       // MAX_BUNDLE_FILES - max # of files deployed from a bundle
+	MaxFileSize          = int64(100 * 1000 * 1000)    // MAX_FILE_SIZE - max size in bytes of a file deployed from a bundle
+	EdgeIPs              = []string{}                  // EDGE_IPS - comma-separated IP addresses of edges that apex domains point to
+	NameCooldownDays     = 30                          // NAME_COOLDOWN_DAYS - # of days the name of a deleted project is held before other users can use it, 0 disables
+	DomainDisableDays    = 7                           // DOMAIN_DISABLE_DAYS - # of days a custom domain can fail DNS re-verification before it is disabled, 0 disables
+
+	MaxDomainRequestsPerDay  = int64(0) // MAX_DOMAIN_REQUESTS - max # of requests per day served for a domain, 0 is unlimited
+	MaxDomainBandwidthPerDay = int64(0) // MAX_DOMAIN_BANDWIDTH - max # of bytes per day served for a domain, 0 is unlimited
 )
 
 func init() {
@@ -25,4 +34,66 @@ func init() {
 			MaxDomainsPerProject = n
 		}
 	}
+
+	if maxFilesEnv := os.Getenv("MAX_BUNDLE_FILES"); maxFilesEnv != "" {
+		n, err := strconv.Atoi(maxFilesEnv)
+		if err != nil {
+			log.Warn("Ignoring MAX_BUNDLE_FILES, not a valid numeric value!")
+		} else {
+			MaxFilesPerBundle = n
+		}
+	}
+
+	if maxFileSizeEnv := os.Getenv("MAX_FILE_SIZE"); maxFileSizeEnv != "" {
+		n, err := strconv.ParseInt(maxFileSizeEnv, 10, 64)
+		if err != nil {
+			log.Warn("Ignoring MAX_FILE_SIZE, not a valid numeric value!")
+		} else {
+			MaxFileSize = n
+		}
+	}
+
+	if maxDomainRequestsEnv := os.Getenv("MAX_DOMAIN_REQUESTS"); maxDomainRequestsEnv != "" {
+		n, err := strconv.ParseInt(maxDomainRequestsEnv, 10, 64)
+		if err != nil {
+			log.Warn("Ignoring MAX_DOMAIN_REQUESTS, not a valid numeric value!")
+		} else {
+			MaxDomainRequestsPerDay = n
+		}
+	}
+
+	if maxDomainBandwidthEnv := os.Getenv("MAX_DOMAIN_BANDWIDTH"); maxDomainBandwidthEnv != "" {
+		n, err := strconv.ParseInt(maxDomainBandwidthEnv, 10, 64)
+		if err != nil {
+			log.Warn("Ignoring MAX_DOMAIN_BANDWIDTH, not a valid numeric value!")
+		} else {
+			MaxDomainBandwidthPerDay = n
+		}
+	}
+
+	if nameCooldownEnv := os.Getenv("NAME_COOLDOWN_DAYS"); nameCooldownEnv != "" {
+		n, err := strconv.Atoi(nameCooldownEnv)
+		if err != nil || n < 0 {
+			log.Warn("Ignoring NAME_COOLDOWN_DAYS, not a valid numeric value!")
+		} else {
+			NameCooldownDays = n
+		}
+	}
+
+	if domainDisableEnv := os.Getenv("DOMAIN_DISABLE_DAYS"); domainDisableEnv != "" {
+		n, err := strconv.Atoi(domainDisableEnv)
+		if err != nil || n < 0 {
+			log.Warn("Ignoring DOMAIN_DISABLE_DAYS, not a valid numeric value!")
+		} else {
+			DomainDisableDays = n
+		}
+	}
+
+	if edgeIPsEnv := os.Getenv("EDGE_IPS"); edgeIPsEnv != "" {
+		for _, ip := range strings.Split(edgeIPsEnv, ",") {
+			if ip = strings.TrimSpace(ip); ip != "" {
+				EdgeIPs = append(EdgeIPs, ip)
+			}
+		}
+	}
 }
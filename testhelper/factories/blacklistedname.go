@@ -12,16 +12,23 @@ import (
 var blacklistedNameN = 0
 
 func BlacklistedName(db *gorm.DB, name string) (dpn *blacklistedname.BlacklistedName) {
+	return BlacklistedNameWithKind(db, blacklistedname.KindExact, name)
+}
+
+func BlacklistedNameWithKind(db *gorm.DB, kind, name string) (dpn *blacklistedname.BlacklistedName) {
 	if name == "" {
 		name = fmt.Sprintf("blacklisted-name-%04d", blacklistedNameN)
 	}
 
 	dpn = &blacklistedname.BlacklistedName{
 		Name: name,
+		Kind: kind,
 	}
 
 	err := db.Create(dpn).Error
 	Expect(err).To(BeNil())
 
+	blacklistedname.ClearCache()
+
 	return dpn
 }
@@ -26,9 +26,10 @@ func Project(db *gorm.DB, u *user.User, name ...string) (proj *project.Project)
 	}
 
 	proj = &project.Project{
-		UserID:               u.ID,
-		Name:                 pName,
-		DefaultDomainEnabled: true,
+		UserID:                 u.ID,
+		Name:                   pName,
+		DefaultDomainEnabled:   true,
+		SecurityHeadersEnabled: true,
 	}
 
 	err := db.Create(proj).Error
@@ -28,7 +28,7 @@ func UserWithPassword(db *gorm.DB, password string) (u *user.User) {
 	err := u.Insert(db)
 	Expect(err).To(BeNil())
 
-	err = db.Model(u).Update("confirmed_at", gorm.Expr("now()")).Error
+	err = u.Transition(db, user.EventConfirm)
 	Expect(err).To(BeNil())
 
 	return u
@@ -0,0 +1,471 @@
+package fake
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/nitrous-io/rise-server/pkg/filetransfer"
+)
+
+// Object is a file stored in MemoryS3.
+type Object struct {
+	Content         []byte
+	ContentType     string
+	ContentEncoding string
+	CacheControl    string
+	ACL             string
+	LastModified    time.Time
+}
+
+// MemoryS3 is a FileTransfer that keeps uploaded files in memory and serves
+// them back, so that tests can assert on stored content and download files
+// that were uploaded earlier. Calls are recorded and errors are injected the
+// same way as with S3. Regions are ignored.
+type MemoryS3 struct {
+	S3
+
+	mu      sync.Mutex
+	objects map[string]map[string]*Object
+	uploads map[string]*multipartUpload
+}
+
+// multipartUpload is a multipart upload that has not been completed.
+type multipartUpload struct {
+	bucket, key string
+	acl         string
+	parts       map[int64][]byte
+}
+
+func NewMemoryS3() *MemoryS3 {
+	return &MemoryS3{objects: map[string]map[string]*Object{}}
+}
+
+// Put stores content at the given path without recording a call, to set up
+// files that are expected to exist.
+func (s *MemoryS3) Put(bucket, key string, content []byte) {
+	s.put(bucket, key, &Object{
+		Content:     content,
+		ContentType: "application/octet-stream",
+		ACL:         "private",
+	})
+}
+
+// Get returns the file stored at the given path, or nil if there is none.
+func (s *MemoryS3) Get(bucket, key string) *Object {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.objects[bucket][key]
+}
+
+// Content returns the content of the file stored at the given path, or nil if
+// there is none.
+func (s *MemoryS3) Content(bucket, key string) []byte {
+	if obj := s.Get(bucket, key); obj != nil {
+		return obj.Content
+	}
+	return nil
+}
+
+// Keys returns the sorted paths of all files stored in the bucket.
+func (s *MemoryS3) Keys(bucket string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := []string{}
+	for key := range s.objects[bucket] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *MemoryS3) Upload(region, bucket, key string, body io.Reader, contentType, acl string) (err error) {
+	var content []byte
+
+	if s.UploadError == nil {
+		if seeker, ok := body.(io.Seeker); ok {
+			if _, err := seeker.Seek(0, 0); err != nil {
+				return err
+			}
+		}
+
+		content, err = ioutil.ReadAll(body)
+	} else {
+		err = s.UploadError
+	}
+
+	s.UploadCalls.Add(List{region, bucket, key, body, contentType, acl}, List{err}, Map{
+		"uploaded_content": content,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	s.putUploaded(bucket, key, content, &filetransfer.Headers{ContentType: contentType}, acl)
+	return nil
+}
+
+func (s *MemoryS3) UploadWithHeaders(region, bucket, key string, body io.Reader, h *filetransfer.Headers, acl string) (err error) {
+	var content []byte
+
+	if s.UploadWithHeadersError == nil {
+		if seeker, ok := body.(io.Seeker); ok {
+			if _, err := seeker.Seek(0, 0); err != nil {
+				return err
+			}
+		}
+
+		content, err = ioutil.ReadAll(body)
+	} else {
+		err = s.UploadWithHeadersError
+	}
+
+	s.UploadWithHeadersCalls.Add(List{region, bucket, key, body, h, acl}, List{err}, Map{
+		"uploaded_content": content,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	s.putUploaded(bucket, key, content, h, acl)
+	return nil
+}
+
+// putUploaded stores an uploaded file, defaulting its content type and ACL
+// the way S3 does.
+func (s *MemoryS3) putUploaded(bucket, key string, content []byte, h *filetransfer.Headers, acl string) {
+	contentType := h.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	if acl == "" {
+		acl = "private"
+	}
+
+	s.put(bucket, key, &Object{
+		Content:         content,
+		ContentType:     contentType,
+		ContentEncoding: h.ContentEncoding,
+		CacheControl:    h.CacheControl,
+		ACL:             acl,
+	})
+
+	// This is to simulate slow uploading.
+	time.Sleep(s.UploadTimeout)
+}
+
+func (s *MemoryS3) Download(region, bucket, key string, out io.WriterAt) (err error) {
+	if s.DownloadError == nil {
+		if obj := s.Get(bucket, key); obj != nil {
+			_, err = out.WriteAt(obj.Content, 0)
+		} else {
+			err = notFoundError(bucket, key)
+		}
+	} else {
+		err = s.DownloadError
+	}
+
+	s.DownloadCalls.Add(List{region, bucket, key, out}, List{err}, nil)
+
+	return err
+}
+
+func (s *MemoryS3) Open(region, bucket, key string) (rc io.ReadCloser, err error) {
+	if s.OpenError == nil {
+		if obj := s.Get(bucket, key); obj != nil {
+			rc = ioutil.NopCloser(bytes.NewReader(obj.Content))
+		} else {
+			err = notFoundError(bucket, key)
+		}
+	} else {
+		err = s.OpenError
+	}
+
+	s.OpenCalls.Add(List{region, bucket, key}, List{rc, err}, nil)
+
+	return rc, err
+}
+
+func (s *MemoryS3) Delete(region, bucket string, keys ...string) (err error) {
+	err = s.DeleteError
+	arglist := List{region, bucket}
+	for _, key := range keys {
+		arglist = append(arglist, key)
+	}
+
+	if err == nil {
+		s.mu.Lock()
+		for _, key := range keys {
+			delete(s.objects[bucket], key)
+		}
+		s.mu.Unlock()
+	}
+
+	s.DeleteCalls.Add(arglist, List{err}, nil)
+	return err
+}
+
+func (s *MemoryS3) DeleteAll(region, bucket, prefix string) error {
+	err := s.DeleteAllError
+	argList := List{region, bucket, prefix}
+
+	if err == nil {
+		s.mu.Lock()
+		for key := range s.objects[bucket] {
+			if strings.HasPrefix(key, prefix) {
+				delete(s.objects[bucket], key)
+			}
+		}
+		s.mu.Unlock()
+	}
+
+	s.DeleteAllCalls.Add(argList, List{err}, nil)
+	return err
+}
+
+// List calls fn for the files stored in the bucket whose keys start with
+// prefix, in the order of their keys.
+func (s *MemoryS3) List(region, bucket, prefix string, fn func(obj *filetransfer.ObjectInfo) error) error {
+	err := s.ListError
+	argList := List{region, bucket, prefix}
+
+	if err == nil {
+		for _, key := range s.Keys(bucket) {
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+
+			obj := s.Get(bucket, key)
+			if obj == nil {
+				continue
+			}
+			if err = fn(&filetransfer.ObjectInfo{
+				Key:          key,
+				Size:         int64(len(obj.Content)),
+				LastModified: obj.LastModified,
+			}); err != nil {
+				break
+			}
+		}
+	}
+
+	s.ListCalls.Add(argList, List{err}, nil)
+	return err
+}
+
+func (s *MemoryS3) Copy(region, bucket, srcKey, destKey, acl string) error {
+	err := s.CopyError
+	argList := List{region, bucket, srcKey, destKey, acl}
+
+	if err == nil {
+		if obj := s.Get(bucket, srcKey); obj != nil {
+			content := make([]byte, len(obj.Content))
+			copy(content, obj.Content)
+			s.put(bucket, destKey, &Object{
+				Content:         content,
+				ContentType:     obj.ContentType,
+				ContentEncoding: obj.ContentEncoding,
+				CacheControl:    obj.CacheControl,
+				ACL:             acl,
+			})
+		} else {
+			err = notFoundError(bucket, srcKey)
+		}
+	}
+
+	s.CopyCalls.Add(argList, List{err}, nil)
+	return err
+}
+
+func (s *MemoryS3) Exists(region, bucket, key string) (bool, error) {
+	err := s.ExistsError
+	argList := List{region, bucket, key}
+
+	exists := false
+	if err == nil {
+		exists = s.Get(bucket, key) != nil
+	}
+
+	s.ExistsCalls.Add(argList, List{exists, err}, nil)
+	return exists, err
+}
+
+// PresignedURL returns PresignedURLReturn if it is set, or otherwise a URL
+// derived from the bucket, key and expiry time.
+func (s *MemoryS3) PresignedURL(region, bucket, key string, expireTime time.Duration) (string, error) {
+	err := s.PresignedURLError
+	argList := List{region, bucket, key, expireTime}
+
+	u := s.PresignedURLReturn
+	if u == "" && err == nil {
+		u = fmt.Sprintf("https://%s.s3.amazonaws.com/%s?Expires=%d", bucket, (&url.URL{Path: strings.TrimPrefix(key, "/")}).EscapedPath(), int64(expireTime/time.Second))
+	}
+
+	s.PresignedURLCalls.Add(argList, List{u, err}, nil)
+	return u, err
+}
+
+// PresignedPutURL returns PresignedPutURLReturn if it is set, or otherwise a
+// URL derived from the bucket, key and expiry time.
+func (s *MemoryS3) PresignedPutURL(region, bucket, key string, expireTime time.Duration) (string, error) {
+	err := s.PresignedPutURLError
+	argList := List{region, bucket, key, expireTime}
+
+	u := s.PresignedPutURLReturn
+	if u == "" && err == nil {
+		u = fmt.Sprintf("https://%s.s3.amazonaws.com/%s?Expires=%d&Method=PUT", bucket, (&url.URL{Path: strings.TrimPrefix(key, "/")}).EscapedPath(), int64(expireTime/time.Second))
+	}
+
+	s.PresignedPutURLCalls.Add(argList, List{u, err}, nil)
+	return u, err
+}
+
+// CreateMultipartUpload starts a multipart upload whose parts are kept in
+// memory until it is completed.
+func (s *MemoryS3) CreateMultipartUpload(region, bucket, key, acl string) (string, error) {
+	err := s.CreateMultipartUploadError
+	argList := List{region, bucket, key, acl}
+
+	var uploadID string
+	if err == nil {
+		s.mu.Lock()
+		if s.uploads == nil {
+			s.uploads = map[string]*multipartUpload{}
+		}
+		uploadID = fmt.Sprintf("upload-%d", len(s.uploads)+1)
+		s.uploads[uploadID] = &multipartUpload{bucket: bucket, key: key, acl: acl, parts: map[int64][]byte{}}
+		s.mu.Unlock()
+	}
+
+	s.CreateMultipartUploadCalls.Add(argList, List{uploadID, err}, nil)
+	return uploadID, err
+}
+
+func (s *MemoryS3) UploadPart(region, bucket, key, uploadID string, partNumber int64, body io.ReadSeeker) (err error) {
+	var content []byte
+
+	if s.UploadPartError == nil {
+		content, err = ioutil.ReadAll(body)
+	} else {
+		err = s.UploadPartError
+	}
+
+	if err == nil {
+		s.mu.Lock()
+		if u := s.uploads[uploadID]; u != nil {
+			u.parts[partNumber] = content
+		} else {
+			err = notFoundError(bucket, key)
+		}
+		s.mu.Unlock()
+	}
+
+	s.UploadPartCalls.Add(List{region, bucket, key, uploadID, partNumber, body}, List{err}, Map{
+		"uploaded_content": content,
+	})
+	return err
+}
+
+func (s *MemoryS3) ListParts(region, bucket, key, uploadID string) ([]*filetransfer.PartInfo, error) {
+	err := s.ListPartsError
+	argList := List{region, bucket, key, uploadID}
+
+	var parts []*filetransfer.PartInfo
+	if err == nil {
+		s.mu.Lock()
+		if u := s.uploads[uploadID]; u != nil {
+			for n, content := range u.parts {
+				parts = append(parts, &filetransfer.PartInfo{
+					PartNumber: n,
+					ETag:       fmt.Sprintf(`"%x"`, md5.Sum(content)),
+					Size:       int64(len(content)),
+				})
+			}
+			sort.Sort(byPartNumber(parts))
+		} else {
+			err = notFoundError(bucket, key)
+		}
+		s.mu.Unlock()
+	}
+
+	s.ListPartsCalls.Add(argList, List{parts, err}, nil)
+	return parts, err
+}
+
+// CompleteMultipartUpload stores the concatenated content of the given parts
+// as the object, and discards the upload.
+func (s *MemoryS3) CompleteMultipartUpload(region, bucket, key, uploadID string, parts []*filetransfer.PartInfo) error {
+	err := s.CompleteMultipartUploadError
+	argList := List{region, bucket, key, uploadID, parts}
+
+	var (
+		content []byte
+		acl     string
+	)
+	if err == nil {
+		s.mu.Lock()
+		if u := s.uploads[uploadID]; u != nil {
+			for _, p := range parts {
+				content = append(content, u.parts[p.PartNumber]...)
+			}
+			acl = u.acl
+			delete(s.uploads, uploadID)
+		} else {
+			err = notFoundError(bucket, key)
+		}
+		s.mu.Unlock()
+	}
+
+	s.CompleteMultipartUploadCalls.Add(argList, List{err}, nil)
+	if err != nil {
+		return err
+	}
+
+	s.put(bucket, key, &Object{
+		Content:     content,
+		ContentType: "application/octet-stream",
+		ACL:         acl,
+	})
+	return nil
+}
+
+type byPartNumber []*filetransfer.PartInfo
+
+func (p byPartNumber) Len() int           { return len(p) }
+func (p byPartNumber) Less(i, j int) bool { return p[i].PartNumber < p[j].PartNumber }
+func (p byPartNumber) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+func (s *MemoryS3) put(bucket, key string, obj *Object) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.objects == nil {
+		s.objects = map[string]map[string]*Object{}
+	}
+	if s.objects[bucket] == nil {
+		s.objects[bucket] = map[string]*Object{}
+	}
+	if obj.LastModified.IsZero() {
+		obj.LastModified = time.Now()
+	}
+	s.objects[bucket][key] = obj
+}
+
+// notFoundError returns an error similar to the one returned by S3 for a key
+// that does not exist.
+func notFoundError(bucket, key string) error {
+	return awserr.NewRequestFailure(awserr.New("NoSuchKey", fmt.Sprintf("the key %q does not exist in bucket %q", key, bucket), nil), http.StatusNotFound, "")
+}
@@ -0,0 +1,104 @@
+package fake
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/square/go-jose"
+)
+
+// OIDCProvider is a fake OpenID Connect identity provider served over HTTPS.
+// It issues an ID token with Claims for any authorization code. Requests to it
+// must be made with its Client().
+type OIDCProvider struct {
+	*httptest.Server
+
+	Key    *rsa.PrivateKey
+	Claims map[string]interface{}
+
+	// TokenStatus is the status the token endpoint responds with, if set.
+	TokenStatus int
+
+	mu          sync.Mutex
+	TokenParams []map[string]string
+}
+
+// NewOIDCProvider starts a fake identity provider.
+func NewOIDCProvider() *OIDCProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+
+	p := &OIDCProvider{Key: key, Claims: map[string]interface{}{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.URL,
+			"authorization_endpoint": p.URL + "/authorize",
+			"token_endpoint":         p.URL + "/token",
+			"jwks_uri":               p.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JsonWebKeySet{Keys: []jose.JsonWebKey{
+			{Key: &p.Key.PublicKey, KeyID: "key-1", Algorithm: "RS256", Use: "sig"},
+		}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		clientID, clientSecret, _ := r.BasicAuth()
+		p.mu.Lock()
+		p.TokenParams = append(p.TokenParams, map[string]string{
+			"client_id":     clientID,
+			"client_secret": clientSecret,
+			"grant_type":    r.PostFormValue("grant_type"),
+			"code":          r.PostFormValue("code"),
+			"redirect_uri":  r.PostFormValue("redirect_uri"),
+		})
+		p.mu.Unlock()
+
+		if p.TokenStatus != 0 {
+			w.WriteHeader(p.TokenStatus)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "idp-access-token",
+			"token_type":   "Bearer",
+			"id_token":     p.IDToken(p.Claims),
+		})
+	})
+
+	p.Server = httptest.NewTLSServer(mux)
+	return p
+}
+
+// IDToken returns an ID token with the given claims, signed with the
+// provider's key.
+func (p *OIDCProvider) IDToken(claims map[string]interface{}) string {
+	signer, err := jose.NewSigner(jose.RS256, &jose.JsonWebKey{Key: p.Key, KeyID: "key-1"})
+	if err != nil {
+		panic(err)
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		panic(err)
+	}
+
+	jws, err := signer.Sign(payload)
+	if err != nil {
+		panic(err)
+	}
+
+	token, err := jws.CompactSerialize()
+	if err != nil {
+		panic(err)
+	}
+	return token
+}
@@ -1,30 +1,57 @@
 package fake
 
 import (
+	"bytes"
 	"io"
 	"io/ioutil"
+	"strings"
 	"time"
+
+	"github.com/nitrous-io/rise-server/pkg/filetransfer"
 )
 
 type S3 struct {
-	UploadCalls       Calls
-	DownloadCalls     Calls
-	DeleteCalls       Calls
-	DeleteAllCalls    Calls
-	CopyCalls         Calls
-	ExistsCalls       Calls
-	PresignedURLCalls Calls
-
-	UploadError       error
-	DownloadError     error
-	DeleteError       error
-	DeleteAllError    error
-	CopyError         error
-	ExistsError       error
-	PresignedURLError error
-
-	ExistsReturn       bool
-	PresignedURLReturn string
+	UploadCalls            Calls
+	UploadWithHeadersCalls Calls
+	DownloadCalls          Calls
+	OpenCalls              Calls
+	DeleteCalls            Calls
+	DeleteAllCalls         Calls
+	ListCalls              Calls
+	CopyCalls              Calls
+	ExistsCalls            Calls
+	PresignedURLCalls      Calls
+	PresignedPutURLCalls   Calls
+
+	CreateMultipartUploadCalls   Calls
+	UploadPartCalls              Calls
+	ListPartsCalls               Calls
+	CompleteMultipartUploadCalls Calls
+
+	UploadError            error
+	UploadWithHeadersError error
+	DownloadError          error
+	OpenError              error
+	DeleteError            error
+	DeleteAllError         error
+	ListError              error
+	CopyError              error
+	ExistsError            error
+	PresignedURLError      error
+	PresignedPutURLError   error
+
+	CreateMultipartUploadError   error
+	UploadPartError              error
+	ListPartsError               error
+	CompleteMultipartUploadError error
+
+	ExistsReturn          bool
+	ListReturn            []*filetransfer.ObjectInfo
+	PresignedURLReturn    string
+	PresignedPutURLReturn string
+
+	CreateMultipartUploadReturn string
+	ListPartsReturn             []*filetransfer.PartInfo
 
 	UploadTimeout time.Duration
 
@@ -59,6 +86,31 @@ func (s *S3) Upload(region, bucket, key string, body io.Reader, contentType, acl
 	return err
 }
 
+func (s *S3) UploadWithHeaders(region, bucket, key string, body io.Reader, h *filetransfer.Headers, acl string) (err error) {
+	var content []byte
+
+	if s.UploadWithHeadersError == nil {
+		if seeker, ok := body.(io.Seeker); ok {
+			if _, err := seeker.Seek(0, 0); err != nil {
+				return err
+			}
+		}
+
+		content, err = ioutil.ReadAll(body)
+	} else {
+		err = s.UploadWithHeadersError
+	}
+
+	s.UploadWithHeadersCalls.Add(List{region, bucket, key, body, h, acl}, List{err}, Map{
+		"uploaded_content": content,
+	})
+
+	// This is to simulate slow uploading.
+	time.Sleep(s.UploadTimeout)
+
+	return err
+}
+
 func (s *S3) Download(region, bucket, key string, out io.WriterAt) (err error) {
 	if s.DownloadError == nil {
 		_, err = out.WriteAt(s.DownloadContent, 0)
@@ -71,6 +123,18 @@ func (s *S3) Download(region, bucket, key string, out io.WriterAt) (err error) {
 	return err
 }
 
+func (s *S3) Open(region, bucket, key string) (rc io.ReadCloser, err error) {
+	if s.OpenError == nil {
+		rc = ioutil.NopCloser(bytes.NewReader(s.DownloadContent))
+	} else {
+		err = s.OpenError
+	}
+
+	s.OpenCalls.Add(List{region, bucket, key}, List{rc, err}, nil)
+
+	return rc, err
+}
+
 func (s *S3) Delete(region, bucket string, keys ...string) (err error) {
 	err = s.DeleteError
 	arglist := List{region, bucket}
@@ -90,9 +154,28 @@ func (s *S3) DeleteAll(region, bucket, prefix string) error {
 	return err
 }
 
-func (s *S3) Copy(region, bucket, srcKey, destKey string) error {
+func (s *S3) List(region, bucket, prefix string, fn func(obj *filetransfer.ObjectInfo) error) error {
+	err := s.ListError
+	argList := List{region, bucket, prefix}
+
+	if err == nil {
+		for _, obj := range s.ListReturn {
+			if !strings.HasPrefix(obj.Key, prefix) {
+				continue
+			}
+			if err = fn(obj); err != nil {
+				break
+			}
+		}
+	}
+
+	s.ListCalls.Add(argList, List{err}, nil)
+	return err
+}
+
+func (s *S3) Copy(region, bucket, srcKey, destKey, acl string) error {
 	err := s.CopyError
-	argList := List{region, bucket, srcKey, destKey}
+	argList := List{region, bucket, srcKey, destKey, acl}
 
 	s.CopyCalls.Add(argList, List{err}, nil)
 	return err
@@ -106,6 +189,14 @@ func (s *S3) PresignedURL(region, bucket, key string, expireTime time.Duration)
 	return s.PresignedURLReturn, err
 }
 
+func (s *S3) PresignedPutURL(region, bucket, key string, expireTime time.Duration) (string, error) {
+	err := s.PresignedPutURLError
+	argList := List{region, bucket, key, expireTime}
+
+	s.PresignedPutURLCalls.Add(argList, List{s.PresignedPutURLReturn, err}, nil)
+	return s.PresignedPutURLReturn, err
+}
+
 func (s *S3) Exists(region, bucket, key string) (bool, error) {
 	err := s.ExistsError
 	argList := List{region, bucket, key}
@@ -113,3 +204,42 @@ func (s *S3) Exists(region, bucket, key string) (bool, error) {
 	s.ExistsCalls.Add(argList, List{s.ExistsReturn, err}, nil)
 	return s.ExistsReturn, err
 }
+
+func (s *S3) CreateMultipartUpload(region, bucket, key, acl string) (string, error) {
+	err := s.CreateMultipartUploadError
+	argList := List{region, bucket, key, acl}
+
+	s.CreateMultipartUploadCalls.Add(argList, List{s.CreateMultipartUploadReturn, err}, nil)
+	return s.CreateMultipartUploadReturn, err
+}
+
+func (s *S3) UploadPart(region, bucket, key, uploadID string, partNumber int64, body io.ReadSeeker) (err error) {
+	var content []byte
+
+	if s.UploadPartError == nil {
+		content, err = ioutil.ReadAll(body)
+	} else {
+		err = s.UploadPartError
+	}
+
+	s.UploadPartCalls.Add(List{region, bucket, key, uploadID, partNumber, body}, List{err}, Map{
+		"uploaded_content": content,
+	})
+	return err
+}
+
+func (s *S3) ListParts(region, bucket, key, uploadID string) ([]*filetransfer.PartInfo, error) {
+	err := s.ListPartsError
+	argList := List{region, bucket, key, uploadID}
+
+	s.ListPartsCalls.Add(argList, List{s.ListPartsReturn, err}, nil)
+	return s.ListPartsReturn, err
+}
+
+func (s *S3) CompleteMultipartUpload(region, bucket, key, uploadID string, parts []*filetransfer.PartInfo) error {
+	err := s.CompleteMultipartUploadError
+	argList := List{region, bucket, key, uploadID, parts}
+
+	s.CompleteMultipartUploadCalls.Add(argList, List{err}, nil)
+	return err
+}
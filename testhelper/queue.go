@@ -3,11 +3,12 @@ package testhelper
 import (
 	"time"
 
+	"github.com/nitrous-io/rise-server/pkg/mqconn"
 	. "github.com/onsi/gomega"
 	"github.com/streadway/amqp"
 )
 
-func DeleteQueue(mq *amqp.Connection, queueNames ...string) {
+func DeleteQueue(mq mqconn.Conn, queueNames ...string) {
 	ch, err := mq.Channel()
 	Expect(err).To(BeNil())
 	defer ch.Close()
@@ -17,7 +18,7 @@ func DeleteQueue(mq *amqp.Connection, queueNames ...string) {
 	}
 }
 
-func ConsumeQueue(mq *amqp.Connection, queueName string) *amqp.Delivery {
+func ConsumeQueue(mq mqconn.Conn, queueName string) *amqp.Delivery {
 	ch, err := mq.Channel()
 	Expect(err).To(BeNil())
 	defer ch.Close()
@@ -40,7 +41,7 @@ func ConsumeQueue(mq *amqp.Connection, queueName string) *amqp.Delivery {
 	}
 }
 
-func DeleteExchange(mq *amqp.Connection, exchangeNames ...string) {
+func DeleteExchange(mq mqconn.Conn, exchangeNames ...string) {
 	ch, err := mq.Channel()
 	Expect(err).To(BeNil())
 	defer ch.Close()
@@ -51,7 +52,7 @@ func DeleteExchange(mq *amqp.Connection, exchangeNames ...string) {
 	}
 }
 
-func StartQueueWithExchange(mq *amqp.Connection, exchangeName, route string) string {
+func StartQueueWithExchange(mq mqconn.Conn, exchangeName, route string) string {
 	ch, err := mq.Channel()
 	Expect(err).To(BeNil())
 	defer ch.Close()
@@ -51,6 +51,7 @@ func ItLocksProject(
 			Expect(res.StatusCode).To(Equal(423))
 			Expect(b.String()).To(MatchJSON(`{
 						"error": "locked",
+						"code": "project_locked",
 						"error_description": "project is locked"
 					}`))
 
@@ -0,0 +1,69 @@
+package sharedexamples
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nitrous-io/rise-server/apiserver/models/user"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func ItRequiresActiveUser(
+	varFn func() (*gorm.DB, *user.User),
+	reqFn func() *http.Response,
+	assertFn func(),
+) {
+	var (
+		db *gorm.DB
+		u  *user.User
+
+		res *http.Response
+	)
+
+	BeforeEach(func() {
+		db, u = varFn()
+	})
+
+	assertForbidden := func(desc, action string) {
+		b := &bytes.Buffer{}
+		_, err := b.ReadFrom(res.Body)
+		Expect(err).To(BeNil())
+
+		Expect(res.StatusCode).To(Equal(http.StatusForbidden))
+		Expect(b.String()).To(MatchJSON(fmt.Sprintf(`{
+			"error": "forbidden",
+			"error_description": %q,
+			"pending_actions": [%q]
+		}`, desc, action)))
+
+		if assertFn != nil {
+			assertFn()
+		}
+	}
+
+	Context("when the user is locked", func() {
+		BeforeEach(func() {
+			Expect(u.Transition(db, user.EventLock)).To(Succeed())
+			res = reqFn()
+		})
+
+		It("returns 403 forbidden", func() {
+			assertForbidden("user is locked, reset password to unlock", user.ActionResetPassword)
+		})
+	})
+
+	Context("when the user is suspended", func() {
+		BeforeEach(func() {
+			Expect(u.Transition(db, user.EventSuspend)).To(Succeed())
+			res = reqFn()
+		})
+
+		It("returns 403 forbidden", func() {
+			assertForbidden("user is suspended", user.ActionContactSupport)
+		})
+	})
+}
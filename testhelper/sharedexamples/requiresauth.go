@@ -42,6 +42,7 @@ func ItRequiresAuthentication(
 			Expect(res.StatusCode).To(Equal(http.StatusUnauthorized))
 			Expect(b.String()).To(MatchJSON(`{
 				"error": "invalid_token",
+				"code": "token_invalid",
 				"error_description": "access token is required"
 			}`))
 
@@ -65,6 +66,7 @@ func ItRequiresAuthentication(
 			Expect(res.StatusCode).To(Equal(http.StatusUnauthorized))
 			Expect(b.String()).To(MatchJSON(`{
 				"error": "invalid_token",
+				"code": "token_invalid",
 				"error_description": "access token is invalid"
 			}`))
 
@@ -89,6 +91,7 @@ func ItRequiresAuthentication(
 			Expect(res.StatusCode).To(Equal(http.StatusUnauthorized))
 			Expect(b.String()).To(MatchJSON(`{
 				"error": "invalid_token",
+				"code": "token_invalid",
 				"error_description": "access token is invalid"
 			}`))
 